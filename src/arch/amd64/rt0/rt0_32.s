@@ -45,10 +45,22 @@ err_sse_not_supported db '[rt0_32] the processor does not support SSE instructio
 section .bss
 align 4096
 
-; Reserve 3 pages for the initial page tables
+; Reserve 5 pages for the initial page tables. The l3/l2 tables map the
+; physical 0-8M region to itself while the l3_hi/l2_hi tables map it to the
+; randomized kernel base.
 page_table_l4:		resb 4096
 page_table_l3:		resb 4096
 page_table_l2:		resb 4096
+page_table_l3_hi:	resb 4096
+page_table_l2_hi:	resb 4096
+
+; The offset between the randomized virtual base of the kernel and the base
+; address that the kernel was linked against, as well as the indices of the
+; P3 and P2 entries that map the randomized base.
+global kernel_slide ; Make this available to the 64-bit entrypoint
+kernel_slide:		resq 1
+kaslr_l3_index:		resd 1
+kaslr_l2_index:		resd 1
 
 ; Reserve 16K for storing multiboot data and for the kernel stack
 global multiboot_data ; Make this available to the 64-bit entrypoint
@@ -92,6 +104,11 @@ _rt0_32_entry:
 	call _rt0_check_longmode_support
 	call _rt0_check_sse_support
 
+	; Pick a random virtual base for the kernel and relocate the kernel
+	; image to it
+	call _rt0_choose_kernel_slide
+	call _rt0_apply_kernel_relocations
+
 	; Setup initial page tables, enable paging and enter longmode 
 	call _rt0_populate_initial_page_tables
 	call _rt0_enter_long_mode
@@ -229,13 +246,108 @@ _rt0_check_sse_support:
 	call write_string
 	jmp _rt0_32_entry.halt
 
+;------------------------------------------------------------------------------
+; Select the offset (kernel slide) between the randomized virtual base of the
+; kernel and the address that it was linked against. The slide is a multiple
+; of 2M so that the kernel can be mapped using 2M pages and places the kernel
+; within the first KASLR_MAX_GB gigabytes above PAGE_OFFSET. The entropy is
+; obtained via RDRAND if the processor supports it or by mixing the bits of
+; the timestamp counter otherwise.
+;------------------------------------------------------------------------------
+KASLR_MAX_GB         equ 64
+KASLR_SLOTS_PER_GB   equ 508 ; leaves room for the 8M kernel mapping in the 1G window
+KASLR_RDRAND_RETRIES equ 10
+
+_rt0_choose_kernel_slide:
+	mov eax, 0x1
+	cpuid
+	test ecx, 1 << 30
+	jz _rt0_choose_kernel_slide.use_tsc
+
+	; RDRAND may transiently fail to return a value so retry a few times
+	mov ecx, KASLR_RDRAND_RETRIES
+.retry_rdrand:
+	rdrand eax
+	jc _rt0_choose_kernel_slide.have_entropy
+	dec ecx
+	jnz _rt0_choose_kernel_slide.retry_rdrand
+
+.use_tsc:
+	; Spread the entropy from the low bits of the timestamp counter
+	; using a xorshift step
+	rdtsc
+	mov ecx, eax
+	shl ecx, 13
+	xor eax, ecx
+	mov ecx, eax
+	shr ecx, 17
+	xor eax, ecx
+	mov ecx, eax
+	shl ecx, 5
+	xor eax, ecx
+
+.have_entropy:
+	; The low bits select a 2M slot within a 1G window and the high bits
+	; select the window
+	mov esi, eax
+	xor edx, edx
+	mov ecx, KASLR_SLOTS_PER_GB
+	div ecx
+	shr esi, 24
+	and esi, KASLR_MAX_GB - 1
+
+	mov [kaslr_l3_index - PAGE_OFFSET], esi
+	mov [kaslr_l2_index - PAGE_OFFSET], edx
+
+	; kernel_slide = window * 1G + slot * 2M
+	mov eax, esi
+	and eax, 3
+	shl eax, 30
+	shl edx, 21
+	add eax, edx
+	shr esi, 2
+	mov [kernel_slide - PAGE_OFFSET], eax
+	mov [kernel_slide - PAGE_OFFSET + 4], esi
+	ret
+
+;------------------------------------------------------------------------------
+; Relocate the kernel image to its randomized virtual base by adding the
+; kernel slide to each location listed in the relocation table generated by
+; tools/relocs. Each table entry contains the offset of a 64-bit location that
+; holds an absolute kernel address from _kernel_start. As paging is not yet
+; enabled, the locations are accessed via their physical addresses.
+;------------------------------------------------------------------------------
+extern _kernel_start
+extern _kaslr_relocs_start
+extern _kaslr_relocs_end
+
+_rt0_apply_kernel_relocations:
+	mov esi, _kaslr_relocs_start - PAGE_OFFSET
+	mov ebx, [kernel_slide - PAGE_OFFSET]
+	mov edx, [kernel_slide - PAGE_OFFSET + 4]
+
+.next_reloc:
+	cmp esi, _kaslr_relocs_end - PAGE_OFFSET
+	jae _rt0_apply_kernel_relocations.done
+
+	mov edi, [esi]
+	add edi, _kernel_start - PAGE_OFFSET
+	add [edi], ebx
+	adc [edi + 4], edx
+
+	add esi, 4
+	jmp _rt0_apply_kernel_relocations.next_reloc
+
+.done:
+	ret
+
 ;------------------------------------------------------------------------------
 ; Setup minimal page tables to allow access to the following regions:
 ; - 0 to 8M
-; - PAGE_OFFSET to PAGE_OFFSET + 8M
+; - PAGE_OFFSET + kernel_slide to PAGE_OFFSET + kernel_slide + 8M
 ;
-; The second region mapping allows us to access the kernel at its VMA when 
-; paging is enabled.
+; The second region mapping allows us to access the kernel at its randomized
+; VMA when paging is enabled.
 ;------------------------------------------------------------------------------
 PAGE_PRESENT  equ (1 << 0)
 PAGE_WRITABLE equ (1 << 1)
@@ -254,9 +366,11 @@ _rt0_populate_initial_page_tables:
 	or ecx, PAGE_PRESENT | PAGE_WRITABLE 
 	mov [ebx + 511*8], ecx
 
-	; Also map the addresses starting at PAGE_OFFSET to the same P3 table. 
-	; To find the P4 index for PAGE_OFFSET we need to extract bits 39-47
-	; of its address.
+	; Map the addresses starting at PAGE_OFFSET to the P3 table for the
+	; kernel. To find the P4 index for PAGE_OFFSET we need to extract bits
+	; 39-47 of its address.
+	mov eax, page_table_l3_hi - PAGE_OFFSET
+	or eax, PAGE_PRESENT | PAGE_WRITABLE
 	mov ecx, (PAGE_OFFSET >> 39) & 511
 	mov [ebx + ecx*8], eax 
 
@@ -267,6 +381,14 @@ _rt0_populate_initial_page_tables:
 	mov ebx, page_table_l3 - PAGE_OFFSET
 	mov [ebx], eax 
 
+	; For the kernel P3 table, we need to map the entry for the 1G window
+	; that contains the randomized kernel base.
+	mov eax, page_table_l2_hi - PAGE_OFFSET
+	or eax, PAGE_PRESENT | PAGE_WRITABLE
+	mov ebx, page_table_l3_hi - PAGE_OFFSET
+	mov ecx, [kaslr_l3_index - PAGE_OFFSET]
+	mov [ebx + ecx*8], eax
+
 	; For the L2 table we enable the huge page bit which allows us to specify 
 	; 2M pages without needing to use the L1 table. To cover the required 
 	; 0-8M region we need to provide 4 2M page entries at indices 0 to 4.
@@ -282,6 +404,22 @@ _rt0_populate_initial_page_tables:
 	cmp ecx, 4
 	jne _rt0_populate_initial_page_tables.next_page
 
+	; The same 0-8M region is mapped by the kernel L2 table starting at
+	; the entry for the 2M slot that contains the randomized kernel base.
+	mov ecx, 0
+	mov ebx, page_table_l2_hi - PAGE_OFFSET
+	mov eax, [kaslr_l2_index - PAGE_OFFSET]
+	lea ebx, [ebx + eax*8]
+.next_kernel_page:
+	mov eax, 1 << 21  ; 2M
+	mul ecx           ; eax *= ecx
+	or eax, PAGE_PRESENT | PAGE_WRITABLE | PAGE_2MB
+	mov [ebx + ecx*8], eax
+
+	inc ecx
+	cmp ecx, 4
+	jne _rt0_populate_initial_page_tables.next_kernel_page
+
 	ret
 
 ;------------------------------------------------------------------------------
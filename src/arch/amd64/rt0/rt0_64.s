@@ -22,7 +22,8 @@ section .text
 ; - it has entered long mode and enabled paging
 ; - it has loaded a 64bit GDT
 ; - it has set up identity paging for the physical 0-8M region and the
-;   PAGE_OFFSET to PAGE_OFFSET+8M region.
+;   PAGE_OFFSET+kernel_slide to PAGE_OFFSET+kernel_slide+8M region.
+; - it has relocated the kernel image to PAGE_OFFSET+kernel_slide.
 ;------------------------------------------------------------------------------
 global _rt0_64_entry
 _rt0_64_entry:
@@ -32,10 +33,13 @@ _rt0_64_entry:
 	; Call the kernel entry point passing a pointer to the multiboot data
 	; copied by the 32-bit entry code
 	extern multiboot_data
+	extern kernel_slide
 	extern _kernel_start
 	extern _kernel_end
 	extern kernel.Kmain
 
+	mov rax, kernel_slide
+	push qword [rax]
 	mov rax, PAGE_OFFSET
 	push rax
 	mov rax, _kernel_end - PAGE_OFFSET
@@ -147,14 +151,22 @@ write_string:
 ; Note: this code modification is only possible because we are currently
 ; operating in supervisor mode with no memory protection enabled. Under normal
 ; conditions the .text section should be flagged as read-only.
+;
+; As the table is populated after linking, it contains the link-time addresses
+; of the symbols; the kernel slide is added to each address so that it points
+; to the randomized location of the symbol.
 ;------------------------------------------------------------------------------
 _rt0_install_redirect_trampolines:
 	mov rax, _rt0_redirect_table
 	mov rdx, NUM_REDIRECTS
+	mov r8, kernel_slide
+	mov r8, [r8]
 
 _rt0_install_redirect_rampolines.next:
 	mov rdi, [rax]	 ; the symbol address to hook
+	add rdi, r8
 	mov rbx, [rax+8] ; the symbol to redirect to
+	add rbx, r8
 
 	; setup trampoline target and copy it to the hooked symbol
 	mov rsi, _rt0_redirect_trampoline
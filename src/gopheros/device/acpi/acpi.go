@@ -2,6 +2,7 @@ package acpi
 
 import (
 	"gopheros/device"
+	"gopheros/device/acpi/aml"
 	"gopheros/device/acpi/table"
 	"gopheros/kernel"
 	"gopheros/kernel/kfmt"
@@ -17,8 +18,7 @@ const (
 )
 
 var (
-	errMissingRSDP           = &kernel.Error{Module: "acpi", Message: "could not locate ACPI RSDP"}
-	errTableChecksumMismatch = &kernel.Error{Module: "acpi", Message: "detected checksum mismatch while parsing ACPI table header"}
+	errMissingRSDP = &kernel.Error{Module: "acpi", Message: "could not locate ACPI RSDP", Code: kernel.ErrCodeNotFound}
 
 	mapFn         = vmm.Map
 	identityMapFn = vmm.IdentityMapRegion
@@ -29,8 +29,18 @@ var (
 	rsdpLocationHi  uintptr = 0xfffff
 	rsdpAlignment   uintptr = 16
 
+	// The maximum table length that the driver is willing to map. Tables
+	// reporting a larger length are treated as corrupted.
+	maxTableLen uint32 = 16 << 20
+
 	rsdpSignature = [8]byte{'R', 'S', 'D', ' ', 'P', 'T', 'R', ' '}
 	fadtSignature = "FACP"
+	dsdtSignature = "DSDT"
+	ssdtSignature = "SSDT"
+
+	// activeDriver points to the initialized ACPI driver instance and is
+	// used by LookupTable.
+	activeDriver *acpiDriver
 )
 
 type acpiDriver struct {
@@ -44,6 +54,21 @@ type acpiDriver struct {
 	// by the table name. All tables included in this map are mapped into
 	// memory.
 	tableMap map[string]*table.SDTHeader
+
+	// The headers of all mapped tables in the order they were discovered.
+	// Tables that appear multiple times (e.g. SSDTs) are only tracked
+	// once by tableMap but all their instances are included here.
+	tables []*table.SDTHeader
+
+	// The definition blocks (the DSDT followed by all SSDTs in the order
+	// they are listed by the RSDT/XSDT) that get parsed into the AML
+	// namespace. As a system may provide multiple SSDTs, this list is
+	// required in addition to tableMap.
+	definitionBlocks []*table.SDTHeader
+
+	// The tables loaded from boot modules that replace the matching
+	// firmware tables (see loadTableOverrides).
+	overrides []*table.SDTHeader
 }
 
 // DriverInit initializes this driver.
@@ -53,10 +78,30 @@ func (drv *acpiDriver) DriverInit(w io.Writer) *kernel.Error {
 	}
 
 	drv.printTableInfo(w)
+	activeDriver = drv
+	table.SetTables(drv.tables)
+	drv.loadNamespace(w)
 
 	return nil
 }
 
+// LookupTable implements table.Resolver. It returns the header of the mapped
+// table with the specified signature or nil if no such table exists.
+func (drv *acpiDriver) LookupTable(signature string) *table.SDTHeader {
+	return drv.tableMap[signature]
+}
+
+// LookupTable returns the header of the ACPI table with the specified
+// signature or nil if the table is not available. Drivers that rely on ACPI
+// tables (e.g. the HPET) must be probed after the ACPI driver initializes.
+func LookupTable(signature string) *table.SDTHeader {
+	if activeDriver == nil {
+		return nil
+	}
+
+	return activeDriver.LookupTable(signature)
+}
+
 // DriverName returns the name of this driver.
 func (*acpiDriver) DriverName() string {
 	return "ACPI"
@@ -81,14 +126,21 @@ func (drv *acpiDriver) printTableInfo(w io.Writer) {
 
 // enumerateTables detects and maps all ACPI tables that are present. Besides
 // the table list defined by the RSDP, this method will also peek into the
-// FADT (if found) looking for the address of DSDT.
+// FADT (if found) looking for the address of DSDT. Tables for which a boot
+// module provides a replacement are substituted by their replacement.
 func (drv *acpiDriver) enumerateTables(w io.Writer) *kernel.Error {
 	header, sizeofHeader, err := mapACPITable(drv.rsdtAddr)
 	if err != nil {
 		return err
 	}
 
+	if err = drv.loadTableOverrides(w); err != nil {
+		return err
+	}
+
 	drv.tableMap = make(map[string]*table.SDTHeader)
+	drv.tables = nil
+	drv.definitionBlocks = nil
 
 	var (
 		acpiRev      = header.Revision
@@ -111,79 +163,150 @@ func (drv *acpiDriver) enumerateTables(w io.Writer) *kernel.Error {
 	}
 
 	for _, addr := range sdtAddresses {
-		if header, _, err = mapACPITable(addr); err != nil {
-			switch err {
-			case errTableChecksumMismatch:
-				kfmt.Fprintf(w, "%s at 0x%16x %6x [checksum mismatch; skipping]\n",
-					string(header.Signature[:]),
-					uintptr(unsafe.Pointer(header)),
-					header.Length,
-				)
-				continue
-			default:
+		header, _, err = mapACPITable(addr)
+		if header, err = drv.overrideTable(w, header, err); err != nil {
+			if !skipInvalidTable(w, header, err) {
 				return err
 			}
+			continue
 		}
 
 		signature := string(header.Signature[:])
-		drv.tableMap[signature] = header
+		drv.addTable(header)
 
 		// The FADT allows us to lookup the DSDT table address
 		if signature == fadtSignature {
 			fadt := (*table.FADT)(unsafe.Pointer(header))
+			activeFADT = fadt
+			if info, err := table.DecodeFADT(header); err == nil {
+				activeFADTInfo = info
+				drv.loadFACS(w, info.FirmwareControl)
+			}
 
 			dsdtAddr := uintptr(fadt.Dsdt)
 			if acpiRev >= acpiRev2Plus {
 				dsdtAddr = uintptr(fadt.Ext.Dsdt)
 			}
 
-			if header, _, err = mapACPITable(dsdtAddr); err != nil {
-				switch err {
-				case errTableChecksumMismatch:
-					kfmt.Fprintf(w, "%s at 0x%16x %6x [checksum mismatch; skipping]\n",
-						string(header.Signature[:]),
-						uintptr(unsafe.Pointer(header)),
-						header.Length,
-					)
-					continue
-				default:
+			header, _, err = mapACPITable(dsdtAddr)
+			if header, err = drv.overrideTable(w, header, err); err != nil {
+				if !skipInvalidTable(w, header, err) {
 					return err
 				}
+				continue
 			}
 
-			drv.tableMap[string(header.Signature[:])] = header
+			drv.addTable(header)
 		}
 
 	}
 
+	// The DSDT must be loaded before any SSDT
+	if dsdt := drv.tableMap[dsdtSignature]; dsdt != nil {
+		drv.definitionBlocks = append(drv.definitionBlocks, dsdt)
+	}
+	for _, header := range drv.tables {
+		if string(header.Signature[:]) == ssdtSignature {
+			drv.definitionBlocks = append(drv.definitionBlocks, header)
+		}
+	}
+
 	return nil
 }
 
+// addTable records a mapped table so that it can be located by LookupTable
+// and visited via table.ForEach.
+func (drv *acpiDriver) addTable(header *table.SDTHeader) {
+	drv.tableMap[string(header.Signature[:])] = header
+	drv.tables = append(drv.tables, header)
+}
+
+// loadNamespace parses the DSDT and all SSDTs into a single AML namespace and
+// attaches an AML interpreter to it. A table that cannot be parsed does not
+// prevent the remaining tables from being loaded. Once the namespace is
+// loaded, ACPI events are enabled and the drivers for the devices defined in
+// the namespace are initialized.
+func (drv *acpiDriver) loadNamespace(w io.Writer) {
+	if len(drv.definitionBlocks) == 0 {
+		return
+	}
+
+	tree := aml.NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	loadErrors := aml.LoadDefinitionBlocks(w, tree, drv.definitionBlocks)
+	kfmt.Fprintf(w, "loaded %d/%d definition blocks\n", len(drv.definitionBlocks)-len(loadErrors), len(drv.definitionBlocks))
+
+	vm := aml.NewVM(w, tree)
+	vm.SetTableResolver(drv)
+	vm.RegisterDefaultRegionHandlers()
+	if lock, err := FirmwareGlobalLock(); err == nil {
+		vm.SetGlobalLock(lock)
+	}
+	AttachInterpreter(vm, tree.Namespace())
+
+	if err := initEvents(w, vm, tree.Namespace()); err != nil {
+		kfmt.Fprintf(w, "ACPI events not available: %s\n", err.Error())
+	}
+
+	initDevices(w, vm, tree.Namespace())
+}
+
+// loadFACS maps the FACS located at the specified physical address. Platforms
+// without a FACS (e.g. hardware-reduced ACPI systems) set the address to 0.
+func (drv *acpiDriver) loadFACS(w io.Writer, addr uint64) {
+	var err *kernel.Error
+	if activeFACS, err = mapFACS(addr); err != nil && err != errNoFACS {
+		kfmt.Fprintf(w, "FACS at 0x%16x [%s; skipping]\n", addr, err.Error())
+	}
+}
+
 // mapACPITable attempts to map and parse the header for the ACPI table starting
 // at the given address. It then uses the length field for the header to expand
-// the mapping to cover the table contents and verifies the checksum before
-// returning a pointer to the table header.
+// the mapping to cover the table contents and validates the table before
+// returning a pointer to the table header. If the table fails validation, the
+// returned error wraps a *table.ValidationError and the returned header can
+// still be used for reporting the failure.
 func mapACPITable(tableAddr uintptr) (header *table.SDTHeader, sizeofHeader uintptr, err *kernel.Error) {
 	var headerPage mm.Page
 
 	// Identity-map the table header so we can access its length field
 	sizeofHeader = unsafe.Sizeof(table.SDTHeader{})
-	if headerPage, err = identityMapFn(mm.FrameFromAddress(tableAddr), sizeofHeader, vmm.FlagPresent); err != nil {
+	pageOffset := vmm.PageOffset(tableAddr)
+	if headerPage, err = identityMapFn(mm.FrameFromAddress(tableAddr), pageOffset+sizeofHeader, vmm.FlagPresent); err != nil {
 		return nil, sizeofHeader, err
 	}
 
-	// Expand mapping to cover the table contents
-	headerPageAddr := headerPage.Address() + vmm.PageOffset(tableAddr)
+	// Expand mapping to cover the table contents unless the header
+	// reports a bogus length
+	headerPageAddr := headerPage.Address() + pageOffset
 	header = (*table.SDTHeader)(unsafe.Pointer(headerPageAddr))
-	if _, err = identityMapFn(mm.FrameFromAddress(tableAddr), uintptr(header.Length), vmm.FlagPresent); err != nil {
-		return nil, sizeofHeader, err
+	mappedSize := sizeofHeader
+	if header.Length > uint32(sizeofHeader) && header.Length <= maxTableLen {
+		if _, err = identityMapFn(mm.FrameFromAddress(tableAddr), pageOffset+uintptr(header.Length), vmm.FlagPresent); err != nil {
+			return nil, sizeofHeader, err
+		}
+		mappedSize = uintptr(header.Length)
 	}
 
-	if !validTable(headerPageAddr, header.Length) {
-		err = errTableChecksumMismatch
+	return header, sizeofHeader, table.Validate(header, mappedSize)
+}
+
+// skipInvalidTable returns true if err indicates that the table described by
+// header failed validation. In that case, the failure details are also
+// reported to w.
+func skipInvalidTable(w io.Writer, header *table.SDTHeader, err *kernel.Error) bool {
+	verr, ok := err.Cause.(*table.ValidationError)
+	if !ok {
+		return false
 	}
 
-	return header, sizeofHeader, err
+	kfmt.Fprintf(w, "%s at 0x%16x %6x [%s; skipping]\n",
+		string(header.Signature[:]),
+		uintptr(unsafe.Pointer(header)),
+		header.Length,
+		verr.Error(),
+	)
+	return true
 }
 
 // locateRSDT scans the memory region [rsdpLocationLow, rsdpLocationHi] looking
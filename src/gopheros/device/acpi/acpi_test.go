@@ -1,22 +1,23 @@
 package acpi
 
 import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/event"
 	"gopheros/device/acpi/table"
 	"gopheros/kernel"
+	"gopheros/kernel/cpu"
 	"gopheros/kernel/mm"
 	"gopheros/kernel/mm/vmm"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
 	"testing"
 	"unsafe"
 )
 
-var (
-	dsdtSignature = "DSDT"
-)
-
 func TestProbe(t *testing.T) {
 	defer func(rsdpLow, rsdpHi, rsdpAlign uintptr) {
 		mapFn = vmm.Map
@@ -172,6 +173,12 @@ func TestProbe(t *testing.T) {
 func TestDriverInit(t *testing.T) {
 	defer func() {
 		identityMapFn = vmm.IdentityMapRegion
+		portReadWordFn = cpu.PortReadWord
+		newDispatcherFn = event.NewDispatcher
+		activeDispatcher = nil
+		activeDriver = nil
+		activeVM, activeNS = nil, nil
+		table.SetTables(nil)
 	}()
 
 	t.Run("success", func(t *testing.T) {
@@ -180,14 +187,53 @@ func TestDriverInit(t *testing.T) {
 			return mm.Page(frame), nil
 		}
 
+		// Report that the system is already in ACPI mode and skip the
+		// event dispatcher setup which accesses the PM1 event blocks.
+		portReadWordFn = func(_ uint16) uint16 { return uint16(pm1SCIEnable) }
+		newDispatcherFn = func(_ io.Writer, _ *aml.VM, _ *aml.Namespace, _ *table.FADT) (*event.Dispatcher, *kernel.Error) {
+			return nil, &kernel.Error{Module: "test", Message: "no event blocks"}
+		}
+
 		drv := &acpiDriver{
 			rsdtAddr: rsdtAddr,
 			useXSDT:  true,
 		}
 
+		if LookupTable("APIC") != nil {
+			t.Fatal("expected LookupTable to fail before the driver is initialized")
+		}
+
 		if err := drv.DriverInit(os.Stderr); err != nil {
 			t.Fatal(err)
 		}
+
+		if header := LookupTable("APIC"); header == nil || string(header.Signature[:]) != "APIC" {
+			t.Fatalf("expected LookupTable to return the APIC table; got %v", header)
+		}
+
+		if header := LookupTable("HPET"); header != nil {
+			t.Fatalf("expected LookupTable to return nil for a missing table; got %v", header)
+		}
+
+		var visited []string
+		for _, sig := range []string{"APIC", dsdtSignature, ssdtSignature} {
+			table.ForEach(sig, func(hdr *table.SDTHeader, _ []byte) {
+				visited = append(visited, string(hdr.Signature[:]))
+			})
+		}
+
+		if exp := []string{"APIC", dsdtSignature, ssdtSignature}; !reflect.DeepEqual(visited, exp) {
+			t.Fatalf("expected table.ForEach to visit %v; got %v", exp, visited)
+		}
+
+		vm, ns := Interpreter()
+		if vm == nil || ns == nil {
+			t.Fatal("expected DriverInit to attach an AML interpreter")
+		}
+
+		if ns.Lookup(nil, `\_PR_.CPU0`) == nil {
+			t.Fatal("expected the AML namespace to include the objects defined by the SSDT")
+		}
 	})
 
 	t.Run("map errors in enumerateTables", func(t *testing.T) {
@@ -219,7 +265,7 @@ func TestDriverInit(t *testing.T) {
 			func(frame mm.Frame, size uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
 				// fail while trying to map DSDT
 				for _, header := range tableList {
-					if header.Length == uint32(size) && string(header.Signature[:]) == dsdtSignature {
+					if uintptr(header.Length)+vmm.PageOffset(uintptr(unsafe.Pointer(header))) == size && string(header.Signature[:]) == dsdtSignature {
 						return 0, expErr
 					}
 				}
@@ -241,6 +287,7 @@ func TestDriverInit(t *testing.T) {
 func TestEnumerateTables(t *testing.T) {
 	defer func() {
 		identityMapFn = vmm.IdentityMapRegion
+		activeFADT, activeFADTInfo = nil, nil
 	}()
 
 	var expTables = []string{"SSDT", "APIC", "FACP", "DSDT"}
@@ -279,6 +326,10 @@ func TestEnumerateTables(t *testing.T) {
 			}
 		}
 
+		if activeFADTInfo == nil || activeFADTInfo.SCIInterrupt != 9 {
+			t.Fatalf("expected enumerateTables to decode the FADT; got %+v", activeFADTInfo)
+		}
+
 		drv.printTableInfo(os.Stderr)
 	})
 
@@ -306,6 +357,15 @@ func TestEnumerateTables(t *testing.T) {
 				t.Fatalf("expected enumerateTables to discover table %q", tableName)
 			}
 		}
+
+		var blocks []string
+		for _, header := range drv.definitionBlocks {
+			blocks = append(blocks, string(header.Signature[:]))
+		}
+
+		if exp := []string{"DSDT", "SSDT"}; !reflect.DeepEqual(blocks, exp) {
+			t.Fatalf("expected definition blocks to be %v; got %v", exp, blocks)
+		}
 	})
 
 	t.Run("checksum mismatch", func(t *testing.T) {
@@ -353,7 +413,8 @@ func TestMapACPITableErrors(t *testing.T) {
 	var (
 		callCount int
 		expErr    = &kernel.Error{Module: "test", Message: "identityMapRegion failed"}
-		header    table.SDTHeader
+		header    = table.SDTHeader{Length: 2 * uint32(unsafe.Sizeof(table.SDTHeader{}))}
+		tableAddr = vmm.PageOffset(uintptr(unsafe.Pointer(&header)))
 	)
 
 	identityMapFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
@@ -367,7 +428,7 @@ func TestMapACPITableErrors(t *testing.T) {
 
 	// Test errors while mapping the table contents and the table header
 	for i := 0; i < 2; i++ {
-		if _, _, err := mapACPITable(0xf00); err != expErr {
+		if _, _, err := mapACPITable(tableAddr); err != expErr {
 			t.Errorf("[spec %d]; expected to get an error\n", i)
 		}
 	}
@@ -406,6 +467,11 @@ func genTestRDST(t *testing.T, acpiVersion uint8) (rsdtAddr uintptr, tableList [
 	// Setup the pointer to the DSDT
 	if fadt != nil && dsdt != nil {
 		fadtHeader := (*table.FADT)(unsafe.Pointer(fadt))
+
+		// The FACS pointers (FIRMWARE_CTRL and X_FIRMWARE_CTRL) in the
+		// dump refer to memory that is not accessible by the tests
+		fadtHeader.FirmwareCtrl = 0
+		*(*uint64)(unsafe.Pointer(uintptr(unsafe.Pointer(fadt)) + 132)) = 0
 		if acpiVersion == acpiRev1 {
 			// Since the tests run in 64-bit mode these 32-bit addresses
 			// will be invalid and cause a page fault. So we cheat and
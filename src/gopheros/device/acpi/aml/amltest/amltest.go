@@ -0,0 +1,124 @@
+// Package amltest provides helpers for assembling the AML payloads used by
+// the tests of the ACPI packages. It does not depend on the aml package so
+// that it can also be used by the aml package tests; package vmtest provides
+// helpers for loading the assembled payloads into an AML VM.
+package amltest
+
+import (
+	"gopheros/device/acpi/table"
+	"unsafe"
+)
+
+// pkgLengthLimits contains the largest value that can be encoded using a
+// PkgLength of 1, 2, 3 and 4 bytes.
+var pkgLengthLimits = [...]uint32{0x3f, 0xfff, 0xfffff, 0xfffffff}
+
+// PkgLength returns the shortest PkgLength encoding for length. It panics if
+// length cannot be encoded using a 4-byte PkgLength.
+func PkgLength(length uint32) []byte {
+	if length <= pkgLengthLimits[0] {
+		return []byte{byte(length)}
+	}
+
+	for extraBytes := 1; extraBytes < len(pkgLengthLimits); extraBytes++ {
+		if length > pkgLengthLimits[extraBytes] {
+			continue
+		}
+
+		// The first byte contains the number of bytes that follow and
+		// the 4 least significant bits of the length.
+		enc := []byte{byte(extraBytes<<6) | byte(length&0xf)}
+		for i := 0; i < extraBytes; i++ {
+			enc = append(enc, byte(length>>uint(4+8*i)))
+		}
+		return enc
+	}
+
+	panic("amltest: length exceeds the maximum PkgLength value")
+}
+
+// Pkg returns a byte slice containing op followed by a PkgLength encoding for
+// the supplied contents and the contents themselves. As the encoded length
+// includes the size of the PkgLength itself, the shortest PkgLength that can
+// encode the total length is used. Pkg panics if the contents are too large
+// to be described by a PkgLength.
+func Pkg(op []byte, contents []byte) []byte {
+	for pkgLenSize := 1; pkgLenSize <= len(pkgLengthLimits); pkgLenSize++ {
+		if pkgLen := PkgLength(uint32(len(contents) + pkgLenSize)); len(pkgLen) == pkgLenSize {
+			return Concat(op, pkgLen, contents)
+		}
+	}
+
+	panic("amltest: package contents exceed the maximum PkgLength value")
+}
+
+// Concat returns a byte slice containing the concatenation of chunks.
+func Concat(chunks ...[]byte) []byte {
+	var out []byte
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return out
+}
+
+// Int returns the shortest AML encoding for an integer constant.
+func Int(val uint64) []byte {
+	switch {
+	case val <= 1:
+		return []byte{byte(val)}
+	case val <= 0xff:
+		return []byte{0x0a, byte(val)}
+	case val <= 0xffff:
+		return Concat([]byte{0x0b}, LE(val, 2))
+	case val <= 0xffffffff:
+		return Concat([]byte{0x0c}, LE(val, 4))
+	default:
+		return Concat([]byte{0x0e}, LE(val, 8))
+	}
+}
+
+// String returns the AML encoding for a string constant.
+func String(val string) []byte {
+	return Concat([]byte{0x0d}, []byte(val), []byte{0x00})
+}
+
+// Buffer returns the AML encoding for a buffer initialized with data.
+func Buffer(data ...byte) []byte {
+	return Pkg([]byte{0x11}, Concat(Int(uint64(len(data))), data))
+}
+
+// Package returns the AML encoding for a package containing the supplied
+// (already encoded) elements.
+func Package(elements ...[]byte) []byte {
+	return Pkg([]byte{0x12}, Concat([]byte{byte(len(elements))}, Concat(elements...)))
+}
+
+// LE returns the size least significant bytes of val in little-endian order.
+func LE(val uint64, size int) []byte {
+	out := make([]byte, size)
+	for i := range out {
+		out[i] = byte(val >> (8 * uint(i)))
+	}
+	return out
+}
+
+// SDTHeaderFor returns the header of a DSDT containing the supplied AML
+// payload.
+func SDTHeaderFor(payload []byte) *table.SDTHeader {
+	return TableFor("DSDT", payload)
+}
+
+// TableFor returns the header of a table with the specified signature that
+// contains the supplied AML payload.
+func TableFor(signature string, payload []byte) *table.SDTHeader {
+	hdrLen := int(unsafe.Sizeof(table.SDTHeader{}))
+	stream := make([]byte, hdrLen+len(payload))
+	copy(stream[hdrLen:], payload)
+
+	header := (*table.SDTHeader)(unsafe.Pointer(&stream[0]))
+	copy(header.Signature[:], signature)
+	header.Length = uint32(len(stream))
+	header.Revision = 2
+
+	return header
+}
@@ -0,0 +1,106 @@
+package amltest
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPkgLength(t *testing.T) {
+	specs := []struct {
+		length uint32
+		exp    []byte
+	}{
+		{0x00, []byte{0x00}},
+		{0x3f, []byte{0x3f}},
+		{0x40, []byte{0x40, 0x04}},
+		{0xfff, []byte{0x4f, 0xff}},
+		{0x1000, []byte{0x80, 0x00, 0x01}},
+		{0xfffff, []byte{0x8f, 0xff, 0xff}},
+		{0x100000, []byte{0xc0, 0x00, 0x00, 0x01}},
+		{0xfffffff, []byte{0xcf, 0xff, 0xff, 0xff}},
+	}
+
+	for specIndex, spec := range specs {
+		if got := PkgLength(spec.length); !bytes.Equal(got, spec.exp) {
+			t.Errorf("[spec %d] expected PkgLength(0x%x) to return % x; got % x", specIndex, spec.length, spec.exp, got)
+		}
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected PkgLength to panic for lengths that cannot be encoded")
+		}
+	}()
+	PkgLength(0x10000000)
+}
+
+func TestPkg(t *testing.T) {
+	op := []byte{0x14}
+
+	specs := []struct {
+		contentLen int
+		expPkgLen  []byte
+	}{
+		{0, []byte{0x01}},
+		{0x3e, []byte{0x3f}},
+		// 0x3f + 1 does not fit in a single byte
+		{0x3f, []byte{0x41, 0x04}},
+		{0xffd, []byte{0x4f, 0xff}},
+		// 0xffe + 2 does not fit in two bytes
+		{0xffe, []byte{0x81, 0x00, 0x01}},
+		{0xffffc, []byte{0x8f, 0xff, 0xff}},
+		// 0xffffd + 3 does not fit in three bytes
+		{0xffffd, []byte{0xc1, 0x00, 0x00, 0x01}},
+	}
+
+	for specIndex, spec := range specs {
+		contents := make([]byte, spec.contentLen)
+		got := Pkg(op, contents)
+
+		if exp := Concat(op, spec.expPkgLen, contents); !bytes.Equal(got, exp) {
+			t.Errorf("[spec %d] expected Pkg to encode %d content bytes using PkgLength % x; got % x", specIndex, spec.contentLen, spec.expPkgLen, got[1:len(got)-spec.contentLen])
+		}
+	}
+}
+
+func TestSDTHeaderFor(t *testing.T) {
+	payload := []byte{0x08, 'F', 'O', 'O', '_', 0x00}
+	header := SDTHeaderFor(payload)
+
+	if string(header.Signature[:]) != "DSDT" || header.Revision != 2 {
+		t.Fatalf("expected a revision 2 DSDT header; got %q rev %d", header.Signature[:], header.Revision)
+	}
+
+	if exp := uint32(36 + len(payload)); header.Length != exp {
+		t.Fatalf("expected table length to be %d; got %d", exp, header.Length)
+	}
+}
+
+func TestTableFor(t *testing.T) {
+	if header := TableFor("SSDT", nil); string(header.Signature[:]) != "SSDT" || header.Length != 36 {
+		t.Fatalf("expected an empty SSDT header; got %q with length %d", header.Signature[:], header.Length)
+	}
+}
+
+func TestDataObjects(t *testing.T) {
+	specs := []struct {
+		got, exp []byte
+	}{
+		{Int(0), []byte{0x00}},
+		{Int(1), []byte{0x01}},
+		{Int(0xff), []byte{0x0a, 0xff}},
+		{Int(0x1234), []byte{0x0b, 0x34, 0x12}},
+		{Int(0x12345678), []byte{0x0c, 0x78, 0x56, 0x34, 0x12}},
+		{Int(0x100000000), []byte{0x0e, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00}},
+		{String("ab"), []byte{0x0d, 'a', 'b', 0x00}},
+		{Buffer(0xaa, 0xbb), []byte{0x11, 0x05, 0x0a, 0x02, 0xaa, 0xbb}},
+		{Package(Int(2), String("")), []byte{0x12, 0x06, 0x02, 0x0a, 0x02, 0x0d, 0x00}},
+		{LE(0x11223344, 3), []byte{0x44, 0x33, 0x22}},
+	}
+
+	for specIndex, spec := range specs {
+		if !bytes.Equal(spec.got, spec.exp) {
+			t.Errorf("[spec %d] expected % x; got % x", specIndex, spec.exp, spec.got)
+		}
+	}
+}
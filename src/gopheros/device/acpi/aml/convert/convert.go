@@ -0,0 +1,253 @@
+// Package convert implements the implicit data type conversion rules that the
+// ACPI specification defines for AML objects. The rules are applied in two
+// situations:
+//   - when an opcode expects an operand of a particular type (source operand
+//     conversion; e.g. the arithmetic and comparison opcodes)
+//   - when a value is stored to a named object whose current value has a
+//     different type (target conversion; e.g. the Store opcode)
+//
+// Values are represented using the same Go types as the AML VM: uint64 for
+// Integers, string for Strings and []byte for Buffers.
+package convert
+
+import "gopheros/kernel"
+
+// ErrConversionFailed is returned when a value cannot be implicitly converted
+// to the requested type.
+var ErrConversionFailed = &kernel.Error{Module: "acpi_aml_convert", Message: "value cannot be converted to the requested type", Code: kernel.ErrCodeInvalidArgument}
+
+// Width describes the size of AML Integers in bytes. Definition blocks whose
+// revision is lower than 2 use 32-bit Integers while all other blocks use
+// 64-bit Integers.
+type Width uint8
+
+// The supported Integer widths.
+const (
+	Width32 Width = 4
+	Width64 Width = 8
+)
+
+// WidthForRevision returns the Integer width used by a definition block with
+// the specified revision.
+func WidthForRevision(revision uint8) Width {
+	if revision < 2 {
+		return Width32
+	}
+
+	return Width64
+}
+
+// Ones returns the Integer value with all bits set. It is also used by the
+// AML logical opcodes to represent True.
+func (w Width) Ones() uint64 {
+	return ^uint64(0) >> (64 - 8*uint(w))
+}
+
+// Truncate discards the bits of val that do not fit in an Integer.
+func (w Width) Truncate(val uint64) uint64 {
+	return val & w.Ones()
+}
+
+// ToInteger implicitly converts val into an Integer:
+//   - Strings are interpreted as hex numbers. Leading whitespace and zeroes
+//     are skipped and the conversion stops at the first character that is
+//     not a hex digit. Digits that do not fit in an Integer are ignored.
+//   - Buffers are interpreted as little-endian byte sequences. Buffers that
+//     are smaller than an Integer are zero-extended while larger buffers are
+//     truncated.
+func ToInteger(val interface{}, w Width) (uint64, *kernel.Error) {
+	switch typ := val.(type) {
+	case uint64:
+		return w.Truncate(typ), nil
+	case string:
+		index := 0
+		for ; index < len(typ) && (typ[index] == ' ' || typ[index] == '\t'); index++ {
+		}
+		for ; index < len(typ) && typ[index] == '0'; index++ {
+		}
+
+		var res uint64
+		for digits := 0; index < len(typ) && digits < 2*int(w); index, digits = index+1, digits+1 {
+			digit, ok := hexDigit(typ[index])
+			if !ok {
+				break
+			}
+			res = res<<4 | uint64(digit)
+		}
+		return res, nil
+	case []byte:
+		var res uint64
+		for index := 0; index < len(typ) && index < int(w); index++ {
+			res |= uint64(typ[index]) << (8 * uint(index))
+		}
+		return res, nil
+	default:
+		return 0, ErrConversionFailed
+	}
+}
+
+// ToBuffer implicitly converts val into a Buffer:
+//   - Integers are converted to a little-endian Buffer whose length equals
+//     the Integer width.
+//   - Strings are converted to a Buffer containing the string characters
+//     followed by a null terminator. Empty strings are converted to an empty
+//     Buffer.
+//
+// Buffers are returned as-is; callers must copy them before modifying their
+// contents.
+func ToBuffer(val interface{}, w Width) ([]byte, *kernel.Error) {
+	switch typ := val.(type) {
+	case uint64:
+		out := make([]byte, w)
+		for index := range out {
+			out[index] = byte(typ >> (8 * uint(index)))
+		}
+		return out, nil
+	case string:
+		if len(typ) == 0 {
+			return []byte{}, nil
+		}
+		out := make([]byte, len(typ)+1)
+		copy(out, typ)
+		return out, nil
+	case []byte:
+		return typ, nil
+	default:
+		return nil, ErrConversionFailed
+	}
+}
+
+// ToString implicitly converts val into a String:
+//   - Integers are converted to their full-width, upper-case hex
+//     representation (8 or 16 characters depending on the Integer width).
+//   - Buffers are converted to a list of space-separated, two-character hex
+//     byte values. Empty buffers are converted to an empty String.
+func ToString(val interface{}, w Width) (string, *kernel.Error) {
+	switch typ := val.(type) {
+	case uint64:
+		out := make([]byte, 2*w)
+		for index := len(out) - 1; index >= 0; index, typ = index-1, typ>>4 {
+			out[index] = hexChar(uint8(typ))
+		}
+		return string(out), nil
+	case string:
+		return typ, nil
+	case []byte:
+		out := make([]byte, 0, 3*len(typ))
+		for index, b := range typ {
+			if index != 0 {
+				out = append(out, ' ')
+			}
+			out = append(out, hexChar(b>>4), hexChar(b))
+		}
+		return string(out), nil
+	default:
+		return "", ErrConversionFailed
+	}
+}
+
+// ToTypeOf implicitly converts val to the type of other. It implements the
+// source operand conversion for opcodes (e.g. the comparison opcodes) that
+// require their second operand to have the same type as their first operand.
+// If other is not an Integer, String or Buffer, ErrConversionFailed is
+// returned.
+func ToTypeOf(val, other interface{}, w Width) (interface{}, *kernel.Error) {
+	switch other.(type) {
+	case uint64:
+		return ToInteger(val, w)
+	case string:
+		return ToString(val, w)
+	case []byte:
+		return ToBuffer(val, w)
+	default:
+		return nil, ErrConversionFailed
+	}
+}
+
+// ForStore applies the target conversion rules for storing val to an object
+// whose current value is target and returns the value that must be stored:
+//   - Integer targets receive the source converted to an Integer.
+//   - String targets receive the source converted to a String; the length
+//     of the target changes to match the converted value.
+//   - Buffer targets retain their length. The source is converted to a
+//     Buffer which is truncated or zero-extended to the target length. Empty
+//     targets take the length of the converted source.
+//
+// If target is not an Integer, String or Buffer (e.g. an uninitialized object
+// or a Package) val is returned unchanged.
+func ForStore(val, target interface{}, w Width) (interface{}, *kernel.Error) {
+	switch typ := target.(type) {
+	case uint64:
+		return ToInteger(val, w)
+	case string:
+		return ToString(val, w)
+	case []byte:
+		data, err := ToBuffer(val, w)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(typ) == 0 {
+			out := make([]byte, len(data))
+			copy(out, data)
+			return out, nil
+		}
+
+		out := make([]byte, len(typ))
+		copy(out, data)
+		return out, nil
+	default:
+		return val, nil
+	}
+}
+
+// FieldValue returns the value of a field (e.g. a FieldUnit or BufferField)
+// whose contents are stored in data in little-endian order. Fields that fit
+// in an Integer evaluate to an Integer while larger fields evaluate to a
+// Buffer.
+func FieldValue(data []byte, bitWidth uint32, w Width) interface{} {
+	if bitWidth > 8*uint32(w) {
+		return data
+	}
+
+	val, _ := ToInteger(data, w)
+	return val
+}
+
+// FieldData converts val into a little-endian byte slice that is large enough
+// to hold a field with the specified width in bits. Values that are shorter
+// than the field are zero-extended while longer values are truncated; any
+// bits in the last byte beyond bitWidth are left for the caller to mask.
+func FieldData(val interface{}, bitWidth uint32, w Width) ([]byte, *kernel.Error) {
+	data, err := ToBuffer(val, w)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]byte, (bitWidth+7)>>3)
+	copy(out, data)
+	return out, nil
+}
+
+// hexDigit returns the value of the hex digit ch.
+func hexDigit(ch byte) (uint8, bool) {
+	switch {
+	case ch >= '0' && ch <= '9':
+		return ch - '0', true
+	case ch >= 'a' && ch <= 'f':
+		return ch - 'a' + 10, true
+	case ch >= 'A' && ch <= 'F':
+		return ch - 'A' + 10, true
+	default:
+		return 0, false
+	}
+}
+
+// hexChar returns the upper-case hex digit for the low nibble of val.
+func hexChar(val uint8) byte {
+	if val &= 0xf; val <= 9 {
+		return '0' + val
+	}
+
+	return 'A' + val - 10
+}
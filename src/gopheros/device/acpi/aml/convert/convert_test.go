@@ -0,0 +1,223 @@
+package convert
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWidth(t *testing.T) {
+	specs := []struct {
+		revision uint8
+		exp      Width
+		expOnes  uint64
+	}{
+		{0, Width32, 0xffffffff},
+		{1, Width32, 0xffffffff},
+		{2, Width64, 0xffffffffffffffff},
+		{6, Width64, 0xffffffffffffffff},
+	}
+
+	for specIndex, spec := range specs {
+		w := WidthForRevision(spec.revision)
+		if w != spec.exp || w.Ones() != spec.expOnes {
+			t.Errorf("[spec %d] expected revision %d to use width %d (ones: 0x%x); got %d (ones: 0x%x)", specIndex, spec.revision, spec.exp, spec.expOnes, w, w.Ones())
+		}
+	}
+
+	if got := Width32.Truncate(0x123456789); got != 0x23456789 {
+		t.Errorf("expected Truncate to return 0x23456789; got 0x%x", got)
+	}
+}
+
+func TestToInteger(t *testing.T) {
+	specs := []struct {
+		in  interface{}
+		w   Width
+		exp uint64
+	}{
+		// Integer -> Integer
+		{uint64(42), Width64, 42},
+		{uint64(0x1122334455667788), Width32, 0x55667788},
+		// String -> Integer
+		{"1aF", Width64, 0x1af},
+		{"12zz", Width64, 0x12},
+		{"", Width64, 0},
+		{"xyz", Width64, 0},
+		{"  0000ff", Width64, 0xff},
+		{"0x10", Width64, 0},
+		{"123456789ABCDEF0FF", Width64, 0x123456789abcdef0},
+		{"123456789", Width32, 0x12345678},
+		{"00000000000000000001", Width64, 1},
+		// Buffer -> Integer
+		{[]byte{}, Width64, 0},
+		{[]byte{0x01, 0x02}, Width64, 0x0201},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, Width64, 0x0807060504030201},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, Width32, 0x04030201},
+	}
+
+	for specIndex, spec := range specs {
+		if got, err := ToInteger(spec.in, spec.w); err != nil || got != spec.exp {
+			t.Errorf("[spec %d] expected ToInteger(%v, %d) to return 0x%x; got 0x%x, %v", specIndex, spec.in, spec.w, spec.exp, got, err)
+		}
+	}
+}
+
+func TestToBuffer(t *testing.T) {
+	specs := []struct {
+		in  interface{}
+		w   Width
+		exp []byte
+	}{
+		// Integer -> Buffer
+		{uint64(0x0102030405060708), Width64, []byte{8, 7, 6, 5, 4, 3, 2, 1}},
+		{uint64(0x0102030405060708), Width32, []byte{8, 7, 6, 5}},
+		// String -> Buffer
+		{"hi", Width64, []byte{'h', 'i', 0}},
+		{"", Width64, []byte{}},
+		// Buffer -> Buffer
+		{[]byte{1, 2, 3}, Width64, []byte{1, 2, 3}},
+	}
+
+	for specIndex, spec := range specs {
+		if got, err := ToBuffer(spec.in, spec.w); err != nil || !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected ToBuffer(%v, %d) to return %v; got %v, %v", specIndex, spec.in, spec.w, spec.exp, got, err)
+		}
+	}
+}
+
+func TestToString(t *testing.T) {
+	specs := []struct {
+		in  interface{}
+		w   Width
+		exp string
+	}{
+		// Integer -> String
+		{uint64(0xbadf00d), Width64, "000000000BADF00D"},
+		{uint64(0xbadf00d), Width32, "0BADF00D"},
+		{uint64(0x1122334455667788), Width32, "55667788"},
+		// Buffer -> String
+		{[]byte{0x0a, 0xff}, Width64, "0A FF"},
+		{[]byte{}, Width64, ""},
+		// String -> String
+		{"foo", Width64, "foo"},
+	}
+
+	for specIndex, spec := range specs {
+		if got, err := ToString(spec.in, spec.w); err != nil || got != spec.exp {
+			t.Errorf("[spec %d] expected ToString(%v, %d) to return %q; got %q, %v", specIndex, spec.in, spec.w, spec.exp, got, err)
+		}
+	}
+}
+
+func TestToTypeOf(t *testing.T) {
+	specs := []struct {
+		in, other interface{}
+		exp       interface{}
+	}{
+		{"10", uint64(0), uint64(0x10)},
+		{uint64(0x10), "", "0000000000000010"},
+		{"ab", []byte{}, []byte{'a', 'b', 0}},
+		{[]byte{1}, uint64(0), uint64(1)},
+	}
+
+	for specIndex, spec := range specs {
+		if got, err := ToTypeOf(spec.in, spec.other, Width64); err != nil || !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected ToTypeOf(%v, %v) to return %v; got %v, %v", specIndex, spec.in, spec.other, spec.exp, got, err)
+		}
+	}
+
+	if _, err := ToTypeOf(uint64(1), []interface{}{}, Width64); err != ErrConversionFailed {
+		t.Errorf("expected to get ErrConversionFailed; got %v", err)
+	}
+}
+
+func TestForStore(t *testing.T) {
+	specs := []struct {
+		in, target interface{}
+		w          Width
+		exp        interface{}
+	}{
+		// Integer targets
+		{"ff", uint64(0), Width64, uint64(0xff)},
+		{uint64(0x100000001), uint64(0), Width32, uint64(1)},
+		// String targets take the length of the converted value
+		{uint64(0x2a), "a much longer string", Width32, "0000002A"},
+		{[]byte{1, 2}, "x", Width64, "01 02"},
+		// Buffer targets keep their length
+		{uint64(0x0102), []byte{0xff, 0xff, 0xff}, Width64, []byte{0x02, 0x01, 0x00}},
+		{uint64(0x0102), make([]byte, 10), Width64, []byte{0x02, 0x01, 0, 0, 0, 0, 0, 0, 0, 0}},
+		{"abcdef", []byte{0, 0, 0}, Width64, []byte{'a', 'b', 'c'}},
+		{[]byte{1, 2, 3}, []byte{}, Width64, []byte{1, 2, 3}},
+		// Other targets receive the source unchanged
+		{[]interface{}{uint64(1)}, nil, Width64, []interface{}{uint64(1)}},
+		{uint64(1), []interface{}{}, Width64, uint64(1)},
+	}
+
+	for specIndex, spec := range specs {
+		if got, err := ForStore(spec.in, spec.target, spec.w); err != nil || !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected ForStore(%v, %v, %d) to return %v; got %v, %v", specIndex, spec.in, spec.target, spec.w, spec.exp, got, err)
+		}
+	}
+
+	if _, err := ForStore([]interface{}{}, []byte{1}, Width64); err != ErrConversionFailed {
+		t.Errorf("expected to get ErrConversionFailed; got %v", err)
+	}
+}
+
+func TestFieldValue(t *testing.T) {
+	specs := []struct {
+		data     []byte
+		bitWidth uint32
+		w        Width
+		exp      interface{}
+	}{
+		{[]byte{0x01}, 1, Width64, uint64(1)},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8}, 64, Width64, uint64(0x0807060504030201)},
+		{[]byte{1, 2, 3, 4, 5}, 33, Width64, uint64(0x0504030201)},
+		{[]byte{1, 2, 3, 4, 5}, 33, Width32, []byte{1, 2, 3, 4, 5}},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, 72, Width64, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+	}
+
+	for specIndex, spec := range specs {
+		if got := FieldValue(spec.data, spec.bitWidth, spec.w); !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected FieldValue to return %v; got %v", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestFieldData(t *testing.T) {
+	specs := []struct {
+		in       interface{}
+		bitWidth uint32
+		exp      []byte
+	}{
+		{uint64(0x0201), 4, []byte{0x01}},
+		{uint64(0x0201), 72, []byte{0x01, 0x02, 0, 0, 0, 0, 0, 0, 0}},
+		{[]byte{1, 2, 3}, 16, []byte{1, 2}},
+		{"a", 24, []byte{'a', 0, 0}},
+	}
+
+	for specIndex, spec := range specs {
+		if got, err := FieldData(spec.in, spec.bitWidth, Width64); err != nil || !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected FieldData to return %v; got %v, %v", specIndex, spec.exp, got, err)
+		}
+	}
+
+	if _, err := FieldData(nil, 8, Width64); err != ErrConversionFailed {
+		t.Errorf("expected to get ErrConversionFailed; got %v", err)
+	}
+}
+
+func TestConversionErrors(t *testing.T) {
+	for _, in := range []interface{}{nil, []interface{}{}, 42} {
+		if _, err := ToInteger(in, Width64); err != ErrConversionFailed {
+			t.Errorf("expected ToInteger(%v) to return ErrConversionFailed; got %v", in, err)
+		}
+		if _, err := ToBuffer(in, Width64); err != ErrConversionFailed {
+			t.Errorf("expected ToBuffer(%v) to return ErrConversionFailed; got %v", in, err)
+		}
+		if _, err := ToString(in, Width64); err != ErrConversionFailed {
+			t.Errorf("expected ToString(%v) to return ErrConversionFailed; got %v", in, err)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+// Package device enumerates the Device objects defined in the ACPI namespace
+// and evaluates the standard identification objects (_HID, _CID, _UID, _ADR
+// and _STA) so that platform drivers can match against them.
+package device
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/kernel"
+	"strconv"
+)
+
+var errInvalidIDType = &kernel.Error{Module: "acpi_aml_device", Message: "device identification object has an unsupported type", Code: kernel.ErrCodeCorrupted}
+
+// The bits returned by the _STA method.
+const (
+	StatusPresent     uint64 = 1 << 0
+	StatusEnabled     uint64 = 1 << 1
+	StatusVisible     uint64 = 1 << 2
+	StatusFunctioning uint64 = 1 << 3
+	StatusBattery     uint64 = 1 << 4
+
+	// The status reported for devices without a _STA method.
+	defaultStatus = StatusPresent | StatusEnabled | StatusVisible | StatusFunctioning
+)
+
+// Info describes a Device object discovered in the ACPI namespace.
+type Info struct {
+	// The namespace node for the device and its absolute path.
+	Node *aml.NamespaceNode
+	Path string
+
+	// The hardware ID (_HID) and compatible IDs (_CID). Compressed EISA
+	// IDs are decoded into their string form (e.g. "PNP0A03"). HID is
+	// empty if the device does not define a _HID object.
+	HID  string
+	CIDs []string
+
+	// The unique ID (_UID). Integer values are converted into their
+	// decimal string form. UID is empty if the device does not define a
+	// _UID object.
+	UID string
+
+	// The address of the device on its parent bus (_ADR). HasAddress is
+	// false if the device does not define an _ADR object.
+	Address    uint64
+	HasAddress bool
+
+	// The device status as reported by _STA (see the Status* constants).
+	Status uint64
+}
+
+// Present returns true if the device status indicates that the device is
+// present.
+func (info *Info) Present() bool {
+	return info.Status&StatusPresent != 0
+}
+
+// Matches returns true if the device HID or any of its CIDs matches one of
+// the supplied IDs.
+func (info *Info) Matches(ids ...string) bool {
+	for _, id := range ids {
+		if info.HID == id {
+			return true
+		}
+
+		for _, cid := range info.CIDs {
+			if cid == id {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Enumerate walks the namespace and returns a flat list with the Device
+// objects that it contains in depth-first order. As required by the ACPI
+// spec, the children of devices that are neither present nor functioning
+// are not enumerated.
+func Enumerate(vm *aml.VM, ns *aml.Namespace) ([]*Info, *kernel.Error) {
+	var (
+		devices []*Info
+		err     *kernel.Error
+	)
+
+	ns.Walk(aml.WalkFilter{Types: aml.ObjectTypeDevice}, func(node *aml.NamespaceNode) bool {
+		if err != nil {
+			return false
+		}
+
+		var info *Info
+		if info, err = Identify(vm, node); err != nil {
+			return false
+		}
+
+		devices = append(devices, info)
+		return info.Status&(StatusPresent|StatusFunctioning) != 0
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	return devices, nil
+}
+
+// Identify evaluates the identification objects of the device at node.
+func Identify(vm *aml.VM, node *aml.NamespaceNode) (*Info, *kernel.Error) {
+	info := &Info{
+		Node:   node,
+		Path:   node.Path(),
+		Status: defaultStatus,
+	}
+
+	if val, err := evalChild(vm, node, "_HID"); err != nil {
+		return nil, err
+	} else if val != nil {
+		if info.HID, err = hardwareID(val); err != nil {
+			return nil, err
+		}
+	}
+
+	if val, err := evalChild(vm, node, "_CID"); err != nil {
+		return nil, err
+	} else if val != nil {
+		cids, isList := val.([]interface{})
+		if !isList {
+			cids = []interface{}{val}
+		}
+
+		info.CIDs = make([]string, 0, len(cids))
+		for _, cid := range cids {
+			id, err := hardwareID(cid)
+			if err != nil {
+				return nil, err
+			}
+			info.CIDs = append(info.CIDs, id)
+		}
+	}
+
+	if val, err := evalChild(vm, node, "_UID"); err != nil {
+		return nil, err
+	} else if val != nil {
+		switch typ := val.(type) {
+		case string:
+			info.UID = typ
+		case uint64:
+			info.UID = strconv.FormatUint(typ, 10)
+		default:
+			return nil, errInvalidIDType
+		}
+	}
+
+	if val, err := evalChild(vm, node, "_ADR"); err != nil {
+		return nil, err
+	} else if val != nil {
+		if info.Address, info.HasAddress = val.(uint64); !info.HasAddress {
+			return nil, errInvalidIDType
+		}
+	}
+
+	if val, err := evalChild(vm, node, "_STA"); err != nil {
+		return nil, err
+	} else if val != nil {
+		var ok bool
+		if info.Status, ok = val.(uint64); !ok {
+			return nil, errInvalidIDType
+		}
+	}
+
+	return info, nil
+}
+
+// evalChild evaluates the object with the specified name that is defined
+// inside the scope of node. It returns nil if no such object exists.
+func evalChild(vm *aml.VM, node *aml.NamespaceNode, name string) (interface{}, *kernel.Error) {
+	child := node.Child(name)
+	if child == nil {
+		return nil, nil
+	}
+
+	return vm.Evaluate(child.Path())
+}
+
+// hardwareID converts a _HID or _CID value into its string form.
+func hardwareID(val interface{}) (string, *kernel.Error) {
+	switch typ := val.(type) {
+	case string:
+		return typ, nil
+	case uint64:
+		return EISAID(uint32(typ)), nil
+	default:
+		return "", errInvalidIDType
+	}
+}
+
+// EISAID decodes a compressed EISA ID (e.g. 0x030ad041) into its 7-character
+// string form (e.g. "PNP0A03"). The ID is stored in big-endian byte order with
+// the first 16 bits encoding three 5-bit characters and the remaining 16
+// bits encoding the product ID.
+func EISAID(val uint32) string {
+	const hexDigits = "0123456789ABCDEF"
+
+	id := val>>24 | (val>>8)&0xff00 | (val<<8)&0xff0000 | val<<24
+	return string([]byte{
+		byte('@' + (id>>26)&0x1f),
+		byte('@' + (id>>21)&0x1f),
+		byte('@' + (id>>16)&0x1f),
+		hexDigits[(id>>12)&0xf],
+		hexDigits[(id>>8)&0xf],
+		hexDigits[(id>>4)&0xf],
+		hexDigits[id&0xf],
+	})
+}
@@ -0,0 +1,169 @@
+package device
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"reflect"
+	"testing"
+)
+
+func TestEnumerate(t *testing.T) {
+	vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x10}, amltest.Concat(
+		// Scope(_SB) {
+		[]byte{'_', 'S', 'B', '_'},
+		//   Device(PCI0) {
+		//     Name(_HID, EISAID("PNP0A08"))
+		//     Name(_CID, EISAID("PNP0A03"))
+		//     Name(_UID, One)
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '0'},
+			[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0a, 0x08},
+			[]byte{0x08, '_', 'C', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0a, 0x03},
+			[]byte{0x08, '_', 'U', 'I', 'D', 0x01},
+			//     Device(ISA0) {
+			//       Name(_ADR, 0x001f0000)
+			//       Name(_CID, Package(2) { "PNP0A05", EISAID("PNP0A06") })
+			//     }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'I', 'S', 'A', '0'},
+				[]byte{0x08, '_', 'A', 'D', 'R', 0x0c, 0x00, 0x00, 0x1f, 0x00},
+				[]byte{0x08, '_', 'C', 'I', 'D'}, amltest.Pkg([]byte{0x12}, amltest.Concat(
+					[]byte{0x02},
+					[]byte{0x0d, 'P', 'N', 'P', '0', 'A', '0', '5', 0x00},
+					[]byte{0x0c, 0x41, 0xd0, 0x0a, 0x06},
+				)),
+			)),
+		)),
+		//   }
+		//   Device(HPET) {
+		//     Name(_HID, "PNP0103")
+		//     Name(_UID, "timer")
+		//     Method(_STA) { Return(Zero) }
+		//     Device(CHLD) {}
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'H', 'P', 'E', 'T'},
+			[]byte{0x08, '_', 'H', 'I', 'D', 0x0d, 'P', 'N', 'P', '0', '1', '0', '3', 0x00},
+			[]byte{0x08, '_', 'U', 'I', 'D', 0x0d, 't', 'i', 'm', 'e', 'r', 0x00},
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'S', 'T', 'A', 0x00, 0xa4, 0x00}),
+			amltest.Pkg([]byte{0x5b, 0x82}, []byte{'C', 'H', 'L', 'D'}),
+		)),
+		// }
+	)))
+
+	devices, err := Enumerate(vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []Info{
+		{
+			Path:   `\_SB_.PCI0`,
+			HID:    "PNP0A08",
+			CIDs:   []string{"PNP0A03"},
+			UID:    "1",
+			Status: defaultStatus,
+		},
+		{
+			Path:       `\_SB_.PCI0.ISA0`,
+			CIDs:       []string{"PNP0A05", "PNP0A06"},
+			Address:    0x001f0000,
+			HasAddress: true,
+			Status:     defaultStatus,
+		},
+		{
+			Path: `\_SB_.HPET`,
+			HID:  "PNP0103",
+			UID:  "timer",
+		},
+	}
+
+	if len(devices) != len(exp) {
+		t.Fatalf("expected to enumerate %d devices; got %d", len(exp), len(devices))
+	}
+
+	for devIndex, dev := range devices {
+		if dev.Node == nil || dev.Node.Path() != dev.Path {
+			t.Errorf("[dev %d] expected Node to point to %q", devIndex, dev.Path)
+		}
+
+		got := *dev
+		got.Node = nil
+		if !reflect.DeepEqual(got, exp[devIndex]) {
+			t.Errorf("[dev %d] expected device info to be:\n%+v\ngot:\n%+v", devIndex, exp[devIndex], got)
+		}
+	}
+
+	t.Run("matching", func(t *testing.T) {
+		specs := []struct {
+			dev       *Info
+			ids       []string
+			expResult bool
+		}{
+			{devices[0], []string{"PNP0A08"}, true},
+			{devices[0], []string{"FOO0000", "PNP0A03"}, true},
+			{devices[1], []string{"PNP0A06"}, true},
+			{devices[1], []string{"PNP0A08"}, false},
+			{devices[2], nil, false},
+		}
+
+		for specIndex, spec := range specs {
+			if got := spec.dev.Matches(spec.ids...); got != spec.expResult {
+				t.Errorf("[spec %d] expected Matches(%v) to return %t; got %t", specIndex, spec.ids, spec.expResult, got)
+			}
+		}
+	})
+
+	t.Run("presence", func(t *testing.T) {
+		if !devices[0].Present() {
+			t.Error("expected device without a _STA method to be present")
+		}
+
+		if devices[2].Present() {
+			t.Error("expected device whose _STA method returns zero to be absent")
+		}
+	})
+}
+
+func TestIdentifyErrors(t *testing.T) {
+	specs := [][]byte{
+		// Name(_HID, Package(0) {})
+		amltest.Concat([]byte{0x08, '_', 'H', 'I', 'D'}, amltest.Pkg([]byte{0x12}, []byte{0x00})),
+		// Name(_CID, Package(1) { Package(0) {} })
+		amltest.Concat([]byte{0x08, '_', 'C', 'I', 'D'}, amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x01}, amltest.Pkg([]byte{0x12}, []byte{0x00})))),
+		// Name(_UID, Package(0) {})
+		amltest.Concat([]byte{0x08, '_', 'U', 'I', 'D'}, amltest.Pkg([]byte{0x12}, []byte{0x00})),
+		// Name(_ADR, "foo")
+		[]byte{0x08, '_', 'A', 'D', 'R', 0x0d, 'f', 'o', 'o', 0x00},
+		// Name(_STA, "foo")
+		[]byte{0x08, '_', 'S', 'T', 'A', 0x0d, 'f', 'o', 'o', 0x00},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'D', 'E', 'V', '0'},
+			spec,
+		)))
+
+		if _, err := Enumerate(vm, ns); err != errInvalidIDType {
+			t.Errorf("[spec %d] expected to get errInvalidIDType; got %v", specIndex, err)
+		}
+	}
+}
+
+func TestEISAID(t *testing.T) {
+	specs := []struct {
+		in  uint32
+		exp string
+	}{
+		{0x030ad041, "PNP0A03"},
+		{0x080ad041, "PNP0A08"},
+		{0x0f0cd041, "PNP0C0F"},
+	}
+
+	for specIndex, spec := range specs {
+		if got := EISAID(spec.in); got != spec.exp {
+			t.Errorf("[spec %d] expected EISAID(0x%x) to return %q; got %q", specIndex, spec.in, spec.exp, got)
+		}
+	}
+}
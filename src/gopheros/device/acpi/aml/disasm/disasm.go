@@ -0,0 +1,435 @@
+// Package disasm generates ASL-like listings for the AML entities stored in
+// an aml.ObjectTree. The generated listings are meant to help with debugging
+// parser issues against real vendor tables; they are not guaranteed to be
+// accepted by an ASL compiler.
+package disasm
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+const (
+	// indentWidth is the number of spaces used for each indentation level.
+	indentWidth = 4
+
+	// externalTypeMethod is the ObjectType value used by External
+	// declarations that refer to control methods.
+	externalTypeMethod = 8
+)
+
+var (
+	// aslNames maps object kinds whose ASL keyword differs from the name
+	// reported by aml.Object.Kind.
+	aslNames = map[string]string{
+		"Land":       "LAnd",
+		"Lor":        "LOr",
+		"Lnot":       "LNot",
+		"Nand":       "NAnd",
+		"Nor":        "NOr",
+		"OpRegion":   "OperationRegion",
+		"PowerRes":   "PowerResource",
+		"VarPackage": "Package",
+	}
+
+	regionSpaceNames = []string{
+		"SystemMemory", "SystemIO", "PCI_Config", "EmbeddedControl",
+		"SMBus", "SystemCMOS", "PciBarTarget", "IPMI", "GeneralPurposeIo",
+		"GenericSerialBus", "PCC",
+	}
+
+	externalTypeNames = []string{
+		"UnknownObj", "IntObj", "StrObj", "BuffObj", "PkgObj",
+		"FieldUnitObj", "DeviceObj", "EventObj", "MethodObj", "MutexObj",
+		"OpRegionObj", "PowerResObj", "ProcessorObj", "ThermalZoneObj",
+		"BuffFieldObj", "DDBHandleObj",
+	}
+
+	accessTypeNames = []string{"AnyAcc", "ByteAcc", "WordAcc", "DWordAcc", "QWordAcc", "BufferAcc"}
+	updateRuleNames = []string{"Preserve", "WriteAsOnes", "WriteAsZeros"}
+)
+
+// disassembler holds the state for generating a listing.
+type disassembler struct {
+	w    io.Writer
+	tree *aml.ObjectTree
+	ns   *aml.Namespace
+}
+
+// Disassemble writes an ASL-like listing of the entities stored in tree to w.
+// The contents of the root scope are emitted at the top level while the
+// contents of each named scope are indented inside Scope blocks.
+func Disassemble(w io.Writer, tree *aml.ObjectTree) {
+	root := tree.ObjectAt(0)
+	if root == nil {
+		return
+	}
+
+	d := &disassembler{w: w, tree: tree, ns: tree.Namespace()}
+	d.writeTermList(root, 0)
+}
+
+// writeTermList emits each object in the arg list of scope as a separate
+// statement.
+func (d *disassembler) writeTermList(scope *aml.Object, depth int) {
+	siblings := d.tree.Args(scope)
+	for _, obj := range siblings {
+		// Named fields are emitted as part of the field list of the
+		// Field object that defines them while builtin methods are
+		// not backed by any AML code.
+		if obj.Kind() == "NamedField" || obj.IsBuiltin() {
+			continue
+		}
+
+		d.writeStatement(obj, siblings, depth)
+	}
+}
+
+// writeStatement emits a single statement for obj. Named scopes, control flow
+// blocks and field definitions are emitted as multi-line blocks.
+func (d *disassembler) writeStatement(obj *aml.Object, siblings []*aml.Object, depth int) {
+	args := d.tree.Args(obj)
+	d.writeIndent(depth)
+
+	switch obj.Kind() {
+	case "ScopeBlock":
+		if len(obj.Name()) == 0 {
+			_, _ = io.WriteString(d.w, "{\n")
+			d.writeTermList(obj, depth+1)
+			d.writeIndent(depth)
+			_, _ = io.WriteString(d.w, "}\n")
+			return
+		}
+
+		kfmt.Fprintf(d.w, "Scope (%s)", obj.Name())
+		d.writeBlock(obj, depth)
+	case "Device", "ThermalZone":
+		kfmt.Fprintf(d.w, "%s (%s)", obj.Kind(), obj.Name())
+		d.writeBlock(lastArg(args), depth)
+	case "Method":
+		flags := d.intArg(args, 1)
+		serialized := "NotSerialized"
+		if flags&0x8 != 0 {
+			serialized = "Serialized"
+		}
+
+		kfmt.Fprintf(d.w, "Method (%s, %d, %s", obj.Name(), flags&0x7, serialized)
+		if syncLevel := (flags >> 4) & 0xf; syncLevel != 0 {
+			kfmt.Fprintf(d.w, ", %d", syncLevel)
+		}
+		_, _ = io.WriteString(d.w, ")")
+		d.writeBlock(lastArg(args), depth)
+	case "Processor":
+		kfmt.Fprintf(d.w, "Processor (%s, 0x%2x, 0x%8x, 0x%2x)", obj.Name(), d.intArg(args, 1), d.intArg(args, 2), d.intArg(args, 3))
+		d.writeBlock(lastArg(args), depth)
+	case "PowerRes":
+		kfmt.Fprintf(d.w, "PowerResource (%s, 0x%2x, 0x%4x)", obj.Name(), d.intArg(args, 1), d.intArg(args, 2))
+		d.writeBlock(lastArg(args), depth)
+	case "If", "While":
+		kfmt.Fprintf(d.w, "%s (", obj.Kind())
+		d.writeExpr(argAt(args, 0))
+		_, _ = io.WriteString(d.w, ")")
+		d.writeBlock(argAt(args, 1), depth)
+	case "Else":
+		_, _ = io.WriteString(d.w, "Else")
+		d.writeBlock(argAt(args, 0), depth)
+	case "Field", "IndexField", "BankField":
+		d.writeField(obj, args, siblings, depth)
+	default:
+		d.writeExpr(obj)
+		_, _ = io.WriteString(d.w, "\n")
+	}
+}
+
+// writeBlock emits the contents of body as a brace-delimited block. The
+// opening brace is emitted on a new line.
+func (d *disassembler) writeBlock(body *aml.Object, depth int) {
+	_, _ = io.WriteString(d.w, "\n")
+	d.writeIndent(depth)
+	_, _ = io.WriteString(d.w, "{\n")
+	if body != nil {
+		d.writeTermList(body, depth+1)
+	}
+	d.writeIndent(depth)
+	_, _ = io.WriteString(d.w, "}\n")
+}
+
+// writeField emits a Field, IndexField or BankField definition followed by
+// the list of named fields that it defines.
+func (d *disassembler) writeField(obj *aml.Object, args, siblings []*aml.Object, depth int) {
+	var flagsArgIndex int
+	switch obj.Kind() {
+	case "Field":
+		flagsArgIndex = 1
+	case "IndexField":
+		flagsArgIndex = 2
+	case "BankField":
+		flagsArgIndex = 3
+	}
+
+	kfmt.Fprintf(d.w, "%s (", obj.Kind())
+	for argIndex := 0; argIndex < flagsArgIndex; argIndex++ {
+		d.writeExpr(argAt(args, argIndex))
+		_, _ = io.WriteString(d.w, ", ")
+	}
+
+	flags := d.intArg(args, flagsArgIndex)
+	accessType := uint8(flags & 0xf)
+	lockRule := "NoLock"
+	if flags&0x10 != 0 {
+		lockRule = "Lock"
+	}
+	kfmt.Fprintf(d.w, "%s, %s, %s)\n", lookupName(accessTypeNames, uint64(accessType)), lockRule, lookupName(updateRuleNames, (flags>>5)&0x3))
+
+	d.writeIndent(depth)
+	_, _ = io.WriteString(d.w, "{\n")
+
+	var (
+		nextOffset      uint32
+		accessAttrib    uint8
+		connectionIndex = aml.InvalidIndex
+	)
+
+	for _, sibling := range siblings {
+		info, isField := sibling.FieldUnit()
+		if !isField || info.FieldIndex != obj.Index() {
+			continue
+		}
+
+		if info.ConnectionIndex != connectionIndex && info.ConnectionIndex != aml.InvalidIndex {
+			connectionIndex = info.ConnectionIndex
+			d.writeIndent(depth + 1)
+			_, _ = io.WriteString(d.w, "Connection (")
+			d.writeExpr(d.tree.ArgAt(d.tree.ObjectAt(connectionIndex), 0))
+			_, _ = io.WriteString(d.w, "),\n")
+		}
+
+		if info.AccessType != accessType || info.AccessAttrib != accessAttrib {
+			accessType, accessAttrib = info.AccessType, info.AccessAttrib
+			d.writeIndent(depth + 1)
+			kfmt.Fprintf(d.w, "AccessAs (%s, 0x%2x),\n", lookupName(accessTypeNames, uint64(accessType)), accessAttrib)
+		}
+
+		if info.BitOffset > nextOffset {
+			d.writeIndent(depth + 1)
+			if info.BitOffset%8 == 0 {
+				kfmt.Fprintf(d.w, "Offset (0x%2x),\n", info.BitOffset/8)
+			} else {
+				kfmt.Fprintf(d.w, ", %d,\n", info.BitOffset-nextOffset)
+			}
+		}
+
+		d.writeIndent(depth + 1)
+		kfmt.Fprintf(d.w, "%s, %d,\n", sibling.Name(), info.BitWidth)
+		nextOffset = info.BitOffset + info.BitWidth
+	}
+
+	d.writeIndent(depth)
+	_, _ = io.WriteString(d.w, "}\n")
+}
+
+// writeExpr emits obj as a single-line ASL expression.
+func (d *disassembler) writeExpr(obj *aml.Object) {
+	if obj == nil {
+		return
+	}
+
+	args := d.tree.Args(obj)
+	switch kind := obj.Kind(); kind {
+	case "BytePrefix":
+		kfmt.Fprintf(d.w, "0x%2x", obj.Value())
+	case "WordPrefix":
+		kfmt.Fprintf(d.w, "0x%4x", obj.Value())
+	case "DwordPrefix":
+		kfmt.Fprintf(d.w, "0x%8x", obj.Value())
+	case "QwordPrefix":
+		kfmt.Fprintf(d.w, "0x%16x", obj.Value())
+	case "StringPrefix":
+		kfmt.Fprintf(d.w, "\"%s\"", obj.Value())
+	case "ByteList":
+		data, _ := obj.Value().([]byte)
+		_, _ = io.WriteString(d.w, "{")
+		for i, b := range data {
+			if i != 0 {
+				_, _ = io.WriteString(d.w, ", ")
+			}
+			kfmt.Fprintf(d.w, "0x%2x", b)
+		}
+		_, _ = io.WriteString(d.w, "}")
+	case "NamePath", "NamePath or MethodCall":
+		path, _ := obj.Value().([]byte)
+		_, _ = io.WriteString(d.w, decodePath(path))
+	case "ResolvedNamePath":
+		d.writeObjectPath(obj)
+	case "MethodCall":
+		d.writeObjectPath(obj)
+		_, _ = io.WriteString(d.w, " (")
+		d.writeExprList(args)
+		_, _ = io.WriteString(d.w, ")")
+	case "Buffer":
+		_, _ = io.WriteString(d.w, "Buffer (")
+		d.writeExpr(argAt(args, 0))
+		_, _ = io.WriteString(d.w, ") ")
+		if data := argAt(args, 1); data != nil {
+			d.writeExpr(data)
+		} else {
+			_, _ = io.WriteString(d.w, "{}")
+		}
+	case "Package", "VarPackage":
+		_, _ = io.WriteString(d.w, "Package (")
+		d.writeExpr(argAt(args, 0))
+		_, _ = io.WriteString(d.w, ") {")
+		d.writeExprList(d.tree.Args(argAt(args, 1)))
+		_, _ = io.WriteString(d.w, "}")
+	case "OpRegion":
+		kfmt.Fprintf(d.w, "OperationRegion (%s, %s, ", obj.Name(), lookupName(regionSpaceNames, d.intArg(args, 1)))
+		d.writeExprList(args[2:])
+		_, _ = io.WriteString(d.w, ")")
+	case "External":
+		externalType := d.intArg(args, 1)
+		_, _ = io.WriteString(d.w, "External (")
+		d.writeExpr(argAt(args, 0))
+		kfmt.Fprintf(d.w, ", %s)", lookupName(externalTypeNames, externalType))
+		if externalType == externalTypeMethod {
+			kfmt.Fprintf(d.w, "    // %d Arguments", d.intArg(args, 2))
+		}
+	default:
+		if name, exists := aslNames[kind]; exists {
+			kind = name
+		}
+
+		_, _ = io.WriteString(d.w, kind)
+		if len(args) != 0 {
+			_, _ = io.WriteString(d.w, " (")
+			d.writeExprList(args)
+			_, _ = io.WriteString(d.w, ")")
+		}
+	}
+}
+
+// writeExprList emits a comma-separated list of expressions.
+func (d *disassembler) writeExprList(list []*aml.Object) {
+	for i, obj := range list {
+		if i != 0 {
+			_, _ = io.WriteString(d.w, ", ")
+		}
+		d.writeExpr(obj)
+	}
+}
+
+// writeObjectPath emits the absolute path of the object referenced by a
+// resolved name path or method call. If the referenced object is not part of
+// the namespace (e.g. an External declaration), its name is emitted instead.
+func (d *disassembler) writeObjectPath(obj *aml.Object) {
+	targetIndex, _ := obj.Value().(uint32)
+	target := d.tree.ObjectAt(targetIndex)
+	if target == nil {
+		_, _ = io.WriteString(d.w, "<invalid reference>")
+		return
+	}
+
+	if node := d.ns.NodeFor(target); node != nil && node.Object() == target {
+		_, _ = io.WriteString(d.w, node.Path())
+		return
+	}
+
+	_, _ = d.w.Write(target.Name())
+}
+
+// intArg returns the integer value of the arg at argIndex or 0 if the arg is
+// missing or does not contain an integer constant.
+func (d *disassembler) intArg(args []*aml.Object, argIndex int) uint64 {
+	if argIndex >= len(args) {
+		return 0
+	}
+
+	switch args[argIndex].Kind() {
+	case "One":
+		return 1
+	case "Ones":
+		return ^uint64(0)
+	}
+
+	val, _ := args[argIndex].Value().(uint64)
+	return val
+}
+
+// writeIndent emits the indentation for the specified nesting depth.
+func (d *disassembler) writeIndent(depth int) {
+	for i := 0; i < depth*indentWidth; i++ {
+		_, _ = io.WriteString(d.w, " ")
+	}
+}
+
+// decodePath converts a raw AML name path into its ASL representation where
+// name segments are separated by dots and trailing '_' padding characters are
+// removed from each segment (e.g. `\_SB_PCI0` becomes `\_SB.PCI0`).
+func decodePath(path []byte) string {
+	var (
+		out     []byte
+		segment int
+	)
+
+	for i := 0; i < len(path); {
+		switch ch := path[i]; {
+		case ch == '\\' || ch == '^':
+			out = append(out, ch)
+			i++
+		case ch == 0x2e: // DualNamePrefix
+			i++
+		case ch == 0x2f: // MultiNamePrefix followed by the segment count
+			i += 2
+		case ch == 0x00: // NullName
+			i++
+		default:
+			end := i + 4
+			if end > len(path) {
+				end = len(path)
+			}
+
+			seg := path[i:end]
+			for len(seg) > 1 && seg[len(seg)-1] == '_' {
+				seg = seg[:len(seg)-1]
+			}
+
+			if segment != 0 {
+				out = append(out, '.')
+			}
+			out = append(out, seg...)
+			segment++
+			i = end
+		}
+	}
+
+	return string(out)
+}
+
+// lookupName returns the name at index or the index formatted as a hex value
+// if the index is out of range.
+func lookupName(names []string, index uint64) string {
+	if index < uint64(len(names)) {
+		return names[index]
+	}
+
+	const hexDigits = "0123456789abcdef"
+	return "0x" + string([]byte{hexDigits[(index>>4)&0xf], hexDigits[index&0xf]})
+}
+
+// argAt returns the arg at argIndex or nil if the arg is missing.
+func argAt(args []*aml.Object, argIndex int) *aml.Object {
+	if argIndex >= len(args) {
+		return nil
+	}
+
+	return args[argIndex]
+}
+
+// lastArg returns the last arg in args or nil if args is empty.
+func lastArg(args []*aml.Object) *aml.Object {
+	if len(args) == 0 {
+		return nil
+	}
+
+	return args[len(args)-1]
+}
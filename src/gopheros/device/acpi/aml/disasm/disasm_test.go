@@ -0,0 +1,162 @@
+package disasm
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/table"
+	"io/ioutil"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestDisassemble(t *testing.T) {
+	payload := amltest.Pkg([]byte{0x10}, amltest.Concat(
+		// Scope(_SB) {
+		[]byte{'_', 'S', 'B', '_'},
+		//   Device(DEV0) {
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'D', 'E', 'V', '0'},
+			//     Name(_HID, "ACPI0001")
+			[]byte{0x08, '_', 'H', 'I', 'D', 0x0d, 'A', 'C', 'P', 'I', '0', '0', '0', '1', 0x00},
+			//     Name(BUF0, Buffer(2) {0x01, 0x02})
+			[]byte{0x08, 'B', 'U', 'F', '0'}, amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x02, 0x01, 0x02}),
+			//     Name(PKG0, Package(2) {0x1234, "A"})
+			[]byte{0x08, 'P', 'K', 'G', '0'}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x0b, 0x34, 0x12, 0x0d, 'A', 0x00}),
+			//     OperationRegion(REG0, SystemIO, 0x80, 0x04)
+			[]byte{0x5b, 0x80, 'R', 'E', 'G', '0', 0x01, 0x0a, 0x80, 0x0a, 0x04},
+			//     Field(REG0, ByteAcc, Lock, WriteAsOnes) { FLD0, 8, Offset(2), FLD1, 4 }
+			amltest.Pkg([]byte{0x5b, 0x81}, []byte{'R', 'E', 'G', '0', 0x31, 'F', 'L', 'D', '0', 0x08, 0x00, 0x08, 'F', 'L', 'D', '1', 0x04}),
+			//     Method(_STA, 1, Serialized) {
+			amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'_', 'S', 'T', 'A', 0x09},
+				//       If(LEqual(Arg0, One)) { Return(0x0f) }
+				amltest.Pkg([]byte{0xa0}, []byte{0x93, 0x68, 0x01, 0xa4, 0x0a, 0x0f}),
+				//       Else { Return(Zero) }
+				amltest.Pkg([]byte{0xa1}, []byte{0xa4, 0x00}),
+			)),
+			//     }
+		)),
+		//   }
+		// }
+	))
+
+	tree := aml.NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	if err := aml.NewParser(ioutil.Discard, tree).ParseAML(0, "DSDT", amltest.SDTHeaderFor(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `Scope (_GPE)
+{
+}
+Scope (_PR_)
+{
+}
+Scope (_SB_)
+{
+    Device (DEV0)
+    {
+        Name (_HID, "ACPI0001")
+        Name (BUF0, Buffer (0x02) {0x01, 0x02})
+        Name (PKG0, Package (0x02) {0x1234, "A"})
+        OperationRegion (REG0, SystemIO, 0x80, 0x04)
+        Field (REG0, ByteAcc, Lock, WriteAsOnes)
+        {
+            FLD0, 8,
+            Offset (0x02),
+            FLD1, 4,
+        }
+        Method (_STA, 1, Serialized)
+        {
+            If (LEqual (Arg0, One))
+            {
+                Return (0x0f)
+            }
+            Else
+            {
+                Return (Zero)
+            }
+        }
+    }
+}
+Scope (_SI_)
+{
+}
+Scope (_TZ_)
+{
+}
+`
+
+	var buf bytes.Buffer
+	Disassemble(&buf, tree)
+
+	if got := buf.String(); got != exp {
+		t.Fatalf("expected disassembler output to be:\n%s\ngot:\n%s", exp, got)
+	}
+}
+
+func TestDisassembleTables(t *testing.T) {
+	pathToDumps := filepath.Join(pkgDir(), "..", "..", "table", "tabletest")
+
+	for _, tableFile := range []string{"DSDT.aml", "parser-testsuite-DSDT.aml"} {
+		data, err := ioutil.ReadFile(filepath.Join(pathToDumps, tableFile))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		tree := aml.NewObjectTree()
+		tree.CreateDefaultScopes(0)
+		if err := aml.NewParser(ioutil.Discard, tree).ParseAML(0, "DSDT", (*table.SDTHeader)(unsafe.Pointer(&data[0]))); err != nil {
+			t.Fatalf("[%s] %v", tableFile, err)
+		}
+
+		var buf bytes.Buffer
+		Disassemble(&buf, tree)
+
+		// Each opening brace should have a matching closing brace
+		got := buf.String()
+		if open, closed := strings.Count(got, "{\n"), strings.Count(got, "}\n"); open == 0 || open != closed {
+			t.Errorf("[%s] expected balanced block delimiters; got %d opening and %d closing", tableFile, open, closed)
+		}
+	}
+}
+
+func TestDecodePath(t *testing.T) {
+	specs := []struct {
+		path []byte
+		exp  string
+	}{
+		{[]byte{'\\'}, `\`},
+		{[]byte("FOO_"), "FOO"},
+		{[]byte("\\_SB_"), `\_SB`},
+		{[]byte{'^', '^', 'F', 'O', 'O', '_'}, "^^FOO"},
+		{[]byte{'\\', 0x2e, '_', 'S', 'B', '_', 'P', 'C', 'I', '0'}, `\_SB.PCI0`},
+		{[]byte{0x2f, 0x03, '_', 'S', 'B', '_', 'P', 'C', 'I', '0', 'L', 'P', 'C', '_'}, `_SB.PCI0.LPC`},
+		{[]byte{0x00}, ""},
+	}
+
+	for specIndex, spec := range specs {
+		if got := decodePath(spec.path); got != spec.exp {
+			t.Errorf("[spec %d] expected to get %q; got %q", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestLookupName(t *testing.T) {
+	if got := lookupName(regionSpaceNames, 1); got != "SystemIO" {
+		t.Errorf("expected to get SystemIO; got %q", got)
+	}
+
+	if got := lookupName(regionSpaceNames, 0x80); got != "0x80" {
+		t.Errorf("expected to get 0x80; got %q", got)
+	}
+}
+
+func pkgDir() string {
+	_, f, _, _ := runtime.Caller(1)
+	return filepath.Dir(f)
+}
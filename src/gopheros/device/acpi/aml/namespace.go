@@ -0,0 +1,311 @@
+package aml
+
+import "bytes"
+
+// Namespace stores the named entities defined by the parsed AML tables in a
+// hierarchical tree and resolves namespace paths using the name search rules
+// specified in section 5.3 of the ACPI 6.2 spec. Each ObjectTree maintains a
+// Namespace which is populated by the parser after each table is parsed.
+type Namespace struct {
+	tree *ObjectTree
+	root *NamespaceNode
+
+	// nodes maps ObjectTree indices to the namespace nodes that describe
+	// them.
+	nodes map[uint32]*NamespaceNode
+}
+
+// NamespaceNode describes a named entity in the ACPI namespace.
+type NamespaceNode struct {
+	obj      *Object
+	parent   *NamespaceNode
+	children []*NamespaceNode
+}
+
+// Namespace returns the Namespace that describes the named entities stored in
+// the tree. The namespace is automatically updated by the parser each time a
+// new table is parsed.
+func (tree *ObjectTree) Namespace() *Namespace {
+	if tree.namespace == nil {
+		tree.namespace = &Namespace{tree: tree}
+		tree.namespace.populate()
+	}
+
+	return tree.namespace
+}
+
+// populate rebuilds the namespace contents by scanning the object tree. As the
+// parser may relocate previously parsed named objects when it processes Scope
+// directives in subsequent tables, the namespace is always rebuilt from
+// scratch.
+func (ns *Namespace) populate() {
+	ns.root = nil
+	ns.nodes = make(map[uint32]*NamespaceNode)
+
+	if root := ns.tree.ObjectAt(0); root != nil {
+		ns.root = &NamespaceNode{obj: root}
+		ns.nodes[0] = ns.root
+		ns.populateScope(root, ns.root)
+	}
+}
+
+// populateScope scans the args of obj and attaches any named objects it
+// encounters to scope. Unnamed objects (e.g. If blocks or the scope blocks of
+// Devices) are recursively scanned using the same scope.
+func (ns *Namespace) populateScope(obj *Object, scope *NamespaceNode) {
+	for argIndex := obj.firstArgIndex; argIndex != InvalidIndex; argIndex = ns.tree.ObjectAt(argIndex).nextSiblingIndex {
+		argObj := ns.tree.ObjectAt(argIndex)
+
+		if isBufferFieldOpcode(argObj.opcode) {
+			ns.tree.nameBufferField(argObj)
+		}
+
+		argScope := scope
+		if isNamespaceObject(argObj) && argObj.opcode != pOpExternal && len(nameOf(argObj)) != 0 {
+			// When the same name is defined more than once (e.g. by
+			// both branches of an If block) the first definition wins.
+			if argScope = scope.Child(string(argObj.name[:])); argScope == nil {
+				argScope = &NamespaceNode{obj: argObj, parent: scope}
+				scope.children = append(scope.children, argScope)
+				ns.nodes[argIndex] = argScope
+			}
+		}
+
+		ns.populateScope(argObj, argScope)
+	}
+}
+
+// Root returns the node for the root scope ('\') or nil if the namespace is
+// empty.
+func (ns *Namespace) Root() *NamespaceNode {
+	return ns.root
+}
+
+// NodeFor returns the namespace node for the scope that contains obj. If obj
+// defines a name, then its own namespace node is returned.
+func (ns *Namespace) NodeFor(obj *Object) *NamespaceNode {
+	if obj == nil {
+		return nil
+	}
+
+	return ns.nodeAt(obj.index)
+}
+
+// Lookup resolves path into a namespace node. The path may be specified either
+// in its raw AML form (e.g. `\_SB_PCI0_STA`) or using dots to separate name
+// segments (e.g. `\_SB.PCI0._STA`). Relative paths are resolved starting from
+// scope or from the root scope if scope is nil.
+//
+// Relative paths consisting of a single name segment are resolved by
+// searching scope and each one of its ancestors up to the root scope. For any
+// other relative path, the name search rules do not apply and the path is
+// resolved relative to scope. Lookup returns nil if path cannot be resolved.
+func (ns *Namespace) Lookup(scope *NamespaceNode, path string) *NamespaceNode {
+	if scope == nil {
+		scope = ns.root
+	}
+
+	return ns.lookup(scope, normalizePath(path))
+}
+
+// lookup implements Lookup for raw AML name paths.
+func (ns *Namespace) lookup(scope *NamespaceNode, path []byte) *NamespaceNode {
+	if scope == nil || len(path) == 0 {
+		return nil
+	}
+
+	switch path[0] {
+	case '\\':
+		scope, path = ns.root, path[1:]
+	case '^':
+		for ; len(path) != 0 && path[0] == '^'; path = path[1:] {
+			if scope = scope.parent; scope == nil {
+				return nil
+			}
+		}
+	default:
+		if segs := nameSegments(path); len(segs) == 1 {
+			for ; scope != nil; scope = scope.parent {
+				if node := scope.Child(string(segs[0])); node != nil {
+					return node
+				}
+			}
+
+			return nil
+		}
+	}
+
+	for _, seg := range nameSegments(path) {
+		if scope = scope.Child(string(seg)); scope == nil {
+			return nil
+		}
+	}
+
+	return scope
+}
+
+// nodeAt returns the namespace node for the object at index or for its
+// closest ancestor that defines a name.
+func (ns *Namespace) nodeAt(index uint32) *NamespaceNode {
+	for obj := ns.tree.ObjectAt(index); obj != nil; obj = ns.tree.ObjectAt(obj.parentIndex) {
+		if node, exists := ns.nodes[obj.index]; exists {
+			return node
+		}
+	}
+
+	return nil
+}
+
+// Object returns the Object that defines this namespace node.
+func (n *NamespaceNode) Object() *Object {
+	return n.obj
+}
+
+// Parent returns the node for the scope that contains this node or nil if
+// this is the root node.
+func (n *NamespaceNode) Parent() *NamespaceNode {
+	return n.parent
+}
+
+// Children returns the nodes defined directly inside the scope of this node.
+func (n *NamespaceNode) Children() []*NamespaceNode {
+	return n.children
+}
+
+// Child returns the node with the specified name that is defined directly
+// inside the scope of this node. Names shorter than 4 characters are padded
+// with '_'. If no such node exists, Child returns nil.
+func (n *NamespaceNode) Child(name string) *NamespaceNode {
+	seg := normalizePath(name)
+	for _, child := range n.children {
+		if bytes.Equal(child.obj.name[:], seg) {
+			return child
+		}
+	}
+
+	return nil
+}
+
+// Name returns the name of this node.
+func (n *NamespaceNode) Name() string {
+	return string(nameOf(n.obj))
+}
+
+// Path returns the absolute path to this node using dots to separate name
+// segments (e.g. `\_SB_.PCI0._STA`).
+func (n *NamespaceNode) Path() string {
+	if n.parent == nil {
+		return `\`
+	}
+
+	var buf bytes.Buffer
+	n.writePath(&buf)
+	return buf.String()
+}
+
+// writePath appends the absolute path to this node to buf.
+func (n *NamespaceNode) writePath(buf *bytes.Buffer) {
+	if n.parent == nil {
+		buf.WriteByte('\\')
+		return
+	}
+
+	n.parent.writePath(buf)
+	if n.parent.parent != nil {
+		buf.WriteByte('.')
+	}
+	buf.Write(n.obj.name[:])
+}
+
+// ProcessorInfo describes the attributes of a Processor object.
+type ProcessorInfo struct {
+	// The ID of the processor. It matches the processor ID of the
+	// corresponding Local APIC entry in the MADT.
+	ID uint8
+
+	// The I/O address and length of the processor control block or 0 if
+	// the processor does not have a control block.
+	PblkAddr uint32
+	PblkLen  uint8
+}
+
+// Processor returns the ID and control block location specified by the
+// Processor object at node. If node does not describe a Processor object,
+// Processor returns false.
+func (ns *Namespace) Processor(node *NamespaceNode) (ProcessorInfo, bool) {
+	if node == nil || node.obj.opcode != pOpProcessor {
+		return ProcessorInfo{}, false
+	}
+
+	var info ProcessorInfo
+	if idObj := ns.tree.ArgAt(node.obj, 1); idObj != nil {
+		id, _ := idObj.value.(uint64)
+		info.ID = uint8(id)
+	}
+	if addrObj := ns.tree.ArgAt(node.obj, 2); addrObj != nil {
+		addr, _ := addrObj.value.(uint64)
+		info.PblkAddr = uint32(addr)
+	}
+	if lenObj := ns.tree.ArgAt(node.obj, 3); lenObj != nil {
+		pblkLen, _ := lenObj.value.(uint64)
+		info.PblkLen = uint8(pblkLen)
+	}
+
+	return info, true
+}
+
+// nameSegments splits a relative raw AML name path into its name segments,
+// skipping over any DualNamePrefix and MultiNamePrefix bytes.
+func nameSegments(path []byte) [][]byte {
+	var segs [][]byte
+	for i := 0; i < len(path); {
+		switch path[i] {
+		case 0x2e: // DualNamePrefix
+			i++
+		case 0x2f: // MultiNamePrefix followed by the segment count
+			i += 2
+		default:
+			if len(path)-i < amlNameLen {
+				return nil
+			}
+			segs = append(segs, path[i:i+amlNameLen])
+			i += amlNameLen
+		}
+	}
+
+	return segs
+}
+
+// isNamespaceObject returns true if obj defines a name in the ACPI namespace.
+// IndexField and BankField objects are flagged as named by the parser as they
+// reference a named register but the names they define are the ones of their
+// field units. BufferFields are not flagged as named by the parser but they
+// define the name specified by the last arg of their CreateXField opcode.
+func isNamespaceObject(obj *Object) bool {
+	switch obj.opcode {
+	case pOpIndexField, pOpBankField:
+		return false
+	case pOpIntNamedField:
+		return true
+	}
+
+	return isBufferFieldOpcode(obj.opcode) || pOpcodeTable[obj.infoIndex].flags&pOpFlagNamed != 0
+}
+
+// nameBufferField assigns a name to a BufferField object. Unlike other named
+// objects, the name of a BufferField is specified by the last arg of the
+// CreateXField opcode so the parser does not populate it.
+func (tree *ObjectTree) nameBufferField(obj *Object) {
+	if len(nameOf(obj)) != 0 {
+		return
+	}
+
+	nameObj := tree.ObjectAt(obj.lastArgIndex)
+	if nameObj == nil || nameObj.opcode != pOpIntNamePath {
+		return
+	}
+
+	if namepath, ok := nameObj.value.([]byte); ok && len(namepath) >= amlNameLen {
+		copy(obj.name[:], namepath[len(namepath)-amlNameLen:])
+	}
+}
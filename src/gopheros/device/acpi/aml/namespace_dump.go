@@ -0,0 +1,79 @@
+package aml
+
+import (
+	"bytes"
+	"io"
+)
+
+// dumpKindWidth is the column width used for the object kind by Dump.
+const dumpKindWidth = 12
+
+// Dump writes a human-readable representation of the entire namespace to w.
+// See DumpScope for a description of the output format.
+func (ns *Namespace) Dump(w io.Writer) {
+	if ns.root == nil {
+		return
+	}
+
+	ns.DumpScope(w, ns.root)
+}
+
+// DumpScope writes a human-readable representation of scope and all nodes
+// defined inside it to w. Each node is printed on a separate line, indented
+// according to its depth relative to scope, using the format:
+//
+//   <name> <kind>[ <attributes>]
+//
+// Methods are annotated with their arg count, serialization flag and sync
+// level, Name objects with their static value and fields with their location
+// inside the region that contains them. Methods implemented by the
+// interpreter are flagged as builtin.
+func (ns *Namespace) DumpScope(w io.Writer, scope *NamespaceNode) {
+	var lineBuf, attrBuf bytes.Buffer
+	ns.dumpNode(w, &lineBuf, &attrBuf, scope, 0)
+}
+
+// dumpNode writes the line for node and recursively dumps its children.
+func (ns *Namespace) dumpNode(w io.Writer, lineBuf, attrBuf *bytes.Buffer, node *NamespaceNode, depth int) {
+	lineBuf.Reset()
+	for i := 0; i < depth; i++ {
+		lineBuf.WriteString("  ")
+	}
+
+	name := node.Name()
+	if node.parent == nil {
+		name = `\`
+	}
+	lineBuf.WriteString(name)
+	writePadding(lineBuf, amlNameLen+1-len(name))
+
+	kind := pOpcodeName(node.obj.opcode)
+	lineBuf.WriteString(kind)
+
+	attrBuf.Reset()
+	if node.obj.IsBuiltin() {
+		attrBuf.WriteString(" builtin")
+	} else {
+		ns.tree.writeSnapshotAttrs(attrBuf, node.obj)
+	}
+
+	// Align the attributes of all objects to the same column.
+	if attrBuf.Len() != 0 {
+		writePadding(lineBuf, dumpKindWidth-len(kind))
+		lineBuf.Write(attrBuf.Bytes())
+	}
+
+	lineBuf.WriteByte('\n')
+	_, _ = w.Write(lineBuf.Bytes())
+
+	for _, child := range node.children {
+		ns.dumpNode(w, lineBuf, attrBuf, child, depth+1)
+	}
+}
+
+// writePadding appends count spaces to buf.
+func writePadding(buf *bytes.Buffer, count int) {
+	for ; count > 0; count-- {
+		buf.WriteByte(' ')
+	}
+}
@@ -0,0 +1,78 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"testing"
+)
+
+func TestNamespaceDump(t *testing.T) {
+	vm := vmForPayload(t, amltest.Concat(
+		// Name(INT0, 0x2a)
+		[]byte{0x08, 'I', 'N', 'T', '0', 0x0a, 0x2a},
+		// Method(M000, 2, Serialized, 3) {}
+		amltest.Pkg([]byte{0x14}, []byte{'M', '0', '0', '0', 0x3a}),
+		// Device(DEV0) { Name(_HID, "FOO") }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'D', 'E', 'V', '0'},
+			[]byte{0x08, '_', 'H', 'I', 'D', 0x0d, 'F', 'O', 'O', 0x00},
+		)),
+	))
+	ns := vm.tree.Namespace()
+
+	specs := []struct {
+		scope string
+		exp   string
+	}{
+		{
+			`\`,
+			`\    ScopeBlock
+  _GPE ScopeBlock
+  _PR_ ScopeBlock
+  _SB_ ScopeBlock
+  _SI_ ScopeBlock
+  _TZ_ ScopeBlock
+  _OSI Method       builtin
+  INT0 Name         = 0x2a
+  M000 Method       args=2 serialized=true sync=3
+  DEV0 Device
+    _HID Name         = "FOO"
+`,
+		},
+		{
+			`\DEV0`,
+			`DEV0 Device
+  _HID Name         = "FOO"
+`,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		scope := ns.Lookup(nil, spec.scope)
+		if scope == nil {
+			t.Fatalf("[spec %d] unable to lookup scope %q", specIndex, spec.scope)
+		}
+
+		var buf bytes.Buffer
+		ns.DumpScope(&buf, scope)
+		if got := buf.String(); got != spec.exp {
+			t.Errorf("[spec %d] expected dump output:\n%s\ngot:\n%s", specIndex, spec.exp, got)
+		}
+	}
+
+	t.Run("full namespace", func(t *testing.T) {
+		var buf bytes.Buffer
+		ns.Dump(&buf)
+		if exp := specs[0].exp; buf.String() != exp {
+			t.Errorf("expected dump output:\n%s\ngot:\n%s", exp, buf.String())
+		}
+	})
+
+	t.Run("empty namespace", func(t *testing.T) {
+		var buf bytes.Buffer
+		NewObjectTree().Namespace().Dump(&buf)
+		if buf.Len() != 0 {
+			t.Errorf("expected empty output; got %q", buf.String())
+		}
+	})
+}
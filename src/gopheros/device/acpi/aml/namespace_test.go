@@ -0,0 +1,191 @@
+package aml
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestNamespaceLookup(t *testing.T) {
+	ns := namespaceForTables(t, "DSDT.aml", "SSDT.aml")
+
+	pci0 := ns.Lookup(nil, `\_SB.PCI0`)
+	if pci0 == nil {
+		t.Fatal(`expected \_SB.PCI0 to be resolved`)
+	}
+	sbrg := pci0.Child("SBRG")
+	if sbrg == nil {
+		t.Fatal(`expected \_SB.PCI0 to contain SBRG`)
+	}
+
+	specs := []struct {
+		scope   *NamespaceNode
+		path    string
+		expPath string
+	}{
+		{nil, `\`, `\`},
+		{nil, `\_SB_`, `\_SB_`},
+		{nil, `\_SB.PCI0.SBRG.HPET`, `\_SB_.PCI0.SBRG.HPET`},
+		{nil, `\_SB_PCI0SBRG`, `\_SB_.PCI0.SBRG`},
+		// Single segment paths are searched in the scope and all its
+		// ancestors
+		{sbrg, `HPET`, `\_SB_.PCI0.SBRG.HPET`},
+		{sbrg, `GIGE`, `\_SB_.PCI0.GIGE`},
+		{sbrg, `_SB`, `\_SB_`},
+		{nil, `MSWV`, `\MSWV`},
+		// Multi-segment relative paths are not searched
+		{pci0, `SBRG.HPET`, `\_SB_.PCI0.SBRG.HPET`},
+		{sbrg, `HPET.CRS_`, `\_SB_.PCI0.SBRG.HPET.CRS_`},
+		{pci0, `HPET.CRS_`, ``},
+		// Parent prefixes
+		{sbrg, `^GIGE`, `\_SB_.PCI0.GIGE`},
+		{sbrg, `^^PCI0`, `\_SB_.PCI0`},
+		{sbrg, `^`, `\_SB_.PCI0`},
+		{ns.Root(), `^FOO`, ``},
+		// Missing objects
+		{nil, `\_SB.PCI0.FOO`, ``},
+		{nil, `FOOO`, ``},
+		{nil, ``, ``},
+	}
+
+	for specIndex, spec := range specs {
+		node := ns.Lookup(spec.scope, spec.path)
+		switch {
+		case node == nil && spec.expPath != "":
+			t.Errorf("[spec %d] expected lookup of %q to return %q; got nil", specIndex, spec.path, spec.expPath)
+		case node != nil && spec.expPath == "":
+			t.Errorf("[spec %d] expected lookup of %q to fail; got %q", specIndex, spec.path, node.Path())
+		case node != nil && node.Path() != spec.expPath:
+			t.Errorf("[spec %d] expected lookup of %q to return %q; got %q", specIndex, spec.path, spec.expPath, node.Path())
+		}
+	}
+}
+
+func TestNamespaceNodes(t *testing.T) {
+	ns := namespaceForTables(t, "DSDT.aml", "SSDT.aml")
+
+	root := ns.Root()
+	if root.Parent() != nil || root.Name() != `\` || root.Path() != `\` {
+		t.Fatalf("unexpected root node attributes: name %q, path %q", root.Name(), root.Path())
+	}
+
+	lnka := ns.Lookup(nil, `\_SB.LNKA`)
+	if lnka == nil {
+		t.Fatal(`expected \_SB.LNKA to be resolved`)
+	}
+
+	if exp, got := "Device", lnka.Object().Kind(); got != exp {
+		t.Errorf("expected LNKA kind to be %q; got %q", exp, got)
+	}
+
+	if exp, got := "_SB_", lnka.Parent().Name(); got != exp {
+		t.Errorf("expected LNKA parent to be %q; got %q", exp, got)
+	}
+
+	var childNames []string
+	for _, child := range lnka.Children() {
+		if child.Parent() != lnka {
+			t.Errorf("expected parent of %q to be LNKA", child.Path())
+		}
+		childNames = append(childNames, child.Name())
+	}
+
+	if got := strings.Join(childNames, ","); !strings.Contains(got, "_HID") || !strings.Contains(got, "_SRS") {
+		t.Errorf("expected LNKA children to include _HID and _SRS; got %s", got)
+	}
+
+	// Nodes for objects inside a scope resolve to the enclosing scope
+	srs := lnka.Child("_SRS")
+	body := ns.tree.ArgAt(srs.Object(), 2)
+	if got := ns.NodeFor(body); got != srs {
+		t.Errorf("expected NodeFor(method body) to return the method node; got %v", got)
+	}
+
+	if ns.NodeFor(nil) != nil {
+		t.Error("expected NodeFor(nil) to return nil")
+	}
+
+	// IndexField objects and External declarations do not define names
+	for _, node := range ns.nodes {
+		switch node.Object().opcode {
+		case pOpIndexField, pOpBankField, pOpExternal:
+			t.Errorf("unexpected namespace node %q for %s object", node.Path(), node.Object().Kind())
+		}
+	}
+}
+
+func TestNamespaceProcessor(t *testing.T) {
+	ns := namespaceForTables(t, "parser-testsuite-DSDT.aml")
+
+	cpu0 := ns.Lookup(nil, `\CPU0`)
+	if cpu0 == nil {
+		t.Fatal(`expected \CPU0 to be resolved`)
+	}
+
+	expInfo := ProcessorInfo{ID: 1, PblkAddr: 0x120, PblkLen: 6}
+	if info, ok := ns.Processor(cpu0); !ok || info != expInfo {
+		t.Errorf("expected Processor() to return %+v; got %+v, %t", expInfo, info, ok)
+	}
+
+	for _, node := range []*NamespaceNode{nil, ns.Lookup(nil, `\PWR0`)} {
+		if _, ok := ns.Processor(node); ok {
+			t.Errorf("expected Processor() to return false for a non-processor node")
+		}
+	}
+}
+
+func TestNamespaceEmptyTree(t *testing.T) {
+	ns := NewObjectTree().Namespace()
+	if ns.Root() != nil {
+		t.Fatal("expected namespace for empty tree to have no root node")
+	}
+
+	if node := ns.Lookup(nil, `\_SB_`); node != nil {
+		t.Fatalf("expected lookup to fail; got %q", node.Path())
+	}
+}
+
+func TestNameSegments(t *testing.T) {
+	specs := []struct {
+		path []byte
+		exp  []string
+	}{
+		{[]byte("FOO_"), []string{"FOO_"}},
+		{[]byte{0x2e, 'F', 'O', 'O', '_', 'B', 'A', 'R', '_'}, []string{"FOO_", "BAR_"}},
+		{[]byte{0x2f, 0x03, 'F', 'O', 'O', '_', 'B', 'A', 'R', '_', 'B', 'A', 'Z', '_'}, []string{"FOO_", "BAR_", "BAZ_"}},
+		{[]byte("FO"), nil},
+	}
+
+	for specIndex, spec := range specs {
+		var got []string
+		for _, seg := range nameSegments(spec.path) {
+			got = append(got, string(seg))
+		}
+
+		if strings.Join(got, ",") != strings.Join(spec.exp, ",") {
+			t.Errorf("[spec %d] expected segments %v; got %v", specIndex, spec.exp, got)
+		}
+	}
+}
+
+// namespaceForTables parses the specified tables from the tabletest folder
+// and returns the populated namespace.
+func namespaceForTables(t *testing.T, tableFiles ...string) *Namespace {
+	resolver := mockResolver{
+		pathToDumps: pkgDir() + "/../table/tabletest/",
+		tableFiles:  tableFiles,
+	}
+
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+
+	p := NewParser(ioutil.Discard, tree)
+	for tableIndex, tableFile := range tableFiles {
+		tableName := strings.Replace(tableFile, ".aml", "", -1)
+		if err := p.ParseAML(uint8(tableIndex), tableName, resolver.LookupTable(tableName)); err != nil {
+			t.Fatalf("[%s]: %v", tableName, err)
+		}
+	}
+
+	return tree.Namespace()
+}
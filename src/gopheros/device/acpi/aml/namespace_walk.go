@@ -0,0 +1,99 @@
+package aml
+
+// ObjectType is a bitmask that selects the kinds of namespace objects visited
+// by Namespace.Walk.
+type ObjectType uint8
+
+// The object types that can be used to filter a namespace walk.
+const (
+	ObjectTypeDevice ObjectType = 1 << iota
+	ObjectTypeProcessor
+	ObjectTypeThermalZone
+	ObjectTypeMethod
+	ObjectTypePowerResource
+
+	// ObjectTypeAny matches all namespace objects.
+	ObjectTypeAny ObjectType = 0
+)
+
+// WalkFilter controls which namespace nodes are visited by Namespace.Walk.
+type WalkFilter struct {
+	// Scope specifies the node where the walk begins. The scope node
+	// itself is not visited. If Scope is nil, the walk begins at the
+	// root scope.
+	Scope *NamespaceNode
+
+	// Types restricts the visitor invocations to the nodes whose objects
+	// match one of the specified types. Nodes that do not match are not
+	// visited but their children are still scanned. A zero value
+	// (ObjectTypeAny) matches all nodes.
+	Types ObjectType
+
+	// MaxDepth limits the number of levels below Scope that are scanned.
+	// The direct children of Scope are at depth 1. A zero value disables
+	// the depth limit.
+	MaxDepth int
+}
+
+// Visitor is a function invoked by Namespace.Walk for each node that matches
+// the walk filter. The return value controls whether the children of the
+// node should also be scanned.
+type Visitor func(node *NamespaceNode) bool
+
+// Walk performs a depth-first scan of the namespace and invokes visitor for
+// each node that matches filter. Nodes are visited in the order in which
+// they were defined by the AML tables.
+func (ns *Namespace) Walk(filter WalkFilter, visitor Visitor) {
+	scope := filter.Scope
+	if scope == nil {
+		if scope = ns.root; scope == nil {
+			return
+		}
+	}
+
+	walk(scope, &filter, 1, visitor)
+}
+
+// walk invokes visitor for the children of scope that match filter and
+// recursively scans their contents.
+func walk(scope *NamespaceNode, filter *WalkFilter, depth int, visitor Visitor) {
+	if filter.MaxDepth != 0 && depth > filter.MaxDepth {
+		return
+	}
+
+	for _, child := range scope.children {
+		if filter.Types != ObjectTypeAny && filter.Types&child.Type() == 0 {
+			walk(child, filter, depth+1, visitor)
+			continue
+		}
+
+		if visitor(child) {
+			walk(child, filter, depth+1, visitor)
+		}
+	}
+}
+
+// Type returns the ObjectType for this node or ObjectTypeAny if the node
+// defines an object that cannot be used to filter a namespace walk.
+func (n *NamespaceNode) Type() ObjectType {
+	return objectTypeOf(n.obj.opcode)
+}
+
+// objectTypeOf returns the ObjectType for objects generated by opcode or
+// ObjectTypeAny if these objects cannot be used to filter a namespace walk.
+func objectTypeOf(opcode uint16) ObjectType {
+	switch opcode {
+	case pOpDevice:
+		return ObjectTypeDevice
+	case pOpProcessor:
+		return ObjectTypeProcessor
+	case pOpThermalZone:
+		return ObjectTypeThermalZone
+	case pOpMethod:
+		return ObjectTypeMethod
+	case pOpPowerRes:
+		return ObjectTypePowerResource
+	default:
+		return ObjectTypeAny
+	}
+}
@@ -0,0 +1,118 @@
+package aml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestNamespaceWalk(t *testing.T) {
+	ns := namespaceForTables(t, "DSDT.aml", "SSDT.aml")
+
+	// collect returns the paths of the nodes below scope whose kind is
+	// one of kinds using a manual recursive scan.
+	var collect func(scope *NamespaceNode, depth, maxDepth int, kinds ...string) []string
+	collect = func(scope *NamespaceNode, depth, maxDepth int, kinds ...string) []string {
+		var paths []string
+		if maxDepth != 0 && depth > maxDepth {
+			return nil
+		}
+
+		for _, child := range scope.Children() {
+			for _, kind := range kinds {
+				if kind == "" || child.Object().Kind() == kind {
+					paths = append(paths, child.Path())
+					break
+				}
+			}
+			paths = append(paths, collect(child, depth+1, maxDepth, kinds...)...)
+		}
+		return paths
+	}
+
+	specs := []struct {
+		filter WalkFilter
+		exp    []string
+	}{
+		{
+			WalkFilter{},
+			collect(ns.Root(), 1, 0, ""),
+		},
+		{
+			WalkFilter{Types: ObjectTypeDevice},
+			collect(ns.Root(), 1, 0, "Device"),
+		},
+		{
+			WalkFilter{Types: ObjectTypeDevice | ObjectTypeMethod},
+			collect(ns.Root(), 1, 0, "Device", "Method"),
+		},
+		{
+			WalkFilter{Types: ObjectTypeProcessor | ObjectTypeThermalZone | ObjectTypePowerResource},
+			collect(ns.Root(), 1, 0, "Processor", "ThermalZone", "PowerRes"),
+		},
+		{
+			WalkFilter{MaxDepth: 1},
+			collect(ns.Root(), 1, 1, ""),
+		},
+		{
+			WalkFilter{Scope: ns.Lookup(nil, `\_SB.PCI0`), Types: ObjectTypeDevice, MaxDepth: 2},
+			collect(ns.Lookup(nil, `\_SB.PCI0`), 1, 2, "Device"),
+		},
+	}
+
+	for specIndex, spec := range specs {
+		var got []string
+		ns.Walk(spec.filter, func(node *NamespaceNode) bool {
+			got = append(got, node.Path())
+			return true
+		})
+
+		if !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected walk to visit:\n%v\ngot:\n%v", specIndex, spec.exp, got)
+		}
+	}
+
+	t.Run("sanity", func(t *testing.T) {
+		if len(specs[1].exp) == 0 {
+			t.Fatal("expected test tables to define at least one Device")
+		}
+
+		if len(specs[4].exp) >= len(specs[0].exp) {
+			t.Fatal("expected depth-limited walk to visit fewer nodes")
+		}
+	})
+
+	t.Run("skip children", func(t *testing.T) {
+		var got []string
+		ns.Walk(WalkFilter{Types: ObjectTypeDevice}, func(node *NamespaceNode) bool {
+			got = append(got, node.Path())
+			return node.Name() != "PCI0"
+		})
+
+		for _, path := range got {
+			if len(path) > len(`\_SB_.PCI0.`) && path[:len(`\_SB_.PCI0.`)] == `\_SB_.PCI0.` {
+				t.Fatalf("expected the children of PCI0 to be skipped; got %q", path)
+			}
+		}
+	})
+
+	t.Run("node types", func(t *testing.T) {
+		if got := ns.Lookup(nil, `\_SB.PCI0`).Type(); got != ObjectTypeDevice {
+			t.Errorf("expected PCI0 type to be ObjectTypeDevice; got %d", got)
+		}
+
+		if got := ns.Lookup(nil, `\_SB.LNKA._SRS`).Type(); got != ObjectTypeMethod {
+			t.Errorf("expected _SRS type to be ObjectTypeMethod; got %d", got)
+		}
+
+		if got := ns.Root().Type(); got != ObjectTypeAny {
+			t.Errorf("expected root type to be ObjectTypeAny; got %d", got)
+		}
+	})
+
+	t.Run("empty namespace", func(t *testing.T) {
+		NewObjectTree().Namespace().Walk(WalkFilter{}, func(node *NamespaceNode) bool {
+			t.Fatalf("unexpected visit to %q", node.Path())
+			return true
+		})
+	})
+}
@@ -79,12 +79,104 @@ type Object struct {
 	value interface{}
 }
 
+// Index returns the index of this object in the ObjectTree that allocated it.
+func (obj *Object) Index() uint32 {
+	return obj.index
+}
+
+// Name returns the name of this object or nil if the object is not named.
+func (obj *Object) Name() []byte {
+	return nameOf(obj)
+}
+
+// Kind returns a human-readable name for the AML opcode that this object
+// describes (e.g. "Method" or "Device").
+func (obj *Object) Kind() string {
+	return pOpcodeName(obj.opcode)
+}
+
+// Value returns the value associated with this object. Depending on the
+// object kind, the value is either a numeric constant (uint64), the contents
+// of a string constant or byte list ([]byte), the raw AML path of a name
+// reference ([]byte) or the index of the object that a resolved name path or
+// method call refers to (uint32). Objects without a value return nil.
+func (obj *Object) Value() interface{} {
+	switch obj.value.(type) {
+	case uint64, []byte, uint32:
+		return obj.value
+	default:
+		return nil
+	}
+}
+
+// IsBuiltin returns true if this object is a method whose calls are serviced
+// by the interpreter rather than by AML code (e.g. _OSI).
+func (obj *Object) IsBuiltin() bool {
+	_, builtin := obj.value.(builtinMethodMarker)
+	return builtin
+}
+
+// FieldUnitInfo describes the location of a named field inside the region
+// accessed by the Field, IndexField or BankField object that defines it.
+type FieldUnitInfo struct {
+	// The index of the Field, IndexField or BankField object that defines
+	// the named field.
+	FieldIndex uint32
+
+	// The offset and width of the named field in bits.
+	BitOffset uint32
+	BitWidth  uint32
+
+	// The access type and attributes in effect for the named field. They
+	// may differ from the ones specified by the field flags if the field
+	// list contains AccessAs entries.
+	AccessType   uint8
+	AccessAttrib uint8
+	AccessLength uint8
+
+	// The index of the Connection object used by the named field or
+	// InvalidIndex if the field does not use a connection.
+	ConnectionIndex uint32
+}
+
+// FieldUnit returns information about the location of a named field. If the
+// object is not a named field, FieldUnit returns false.
+func (obj *Object) FieldUnit() (FieldUnitInfo, bool) {
+	field, ok := obj.value.(*fieldElement)
+	if !ok || obj.opcode != pOpIntNamedField {
+		return FieldUnitInfo{}, false
+	}
+
+	return FieldUnitInfo{
+		FieldIndex:      field.fieldIndex,
+		BitOffset:       field.offset,
+		BitWidth:        field.width,
+		AccessType:      field.accessType,
+		AccessAttrib:    field.accessAttrib,
+		AccessLength:    field.accessLength,
+		ConnectionIndex: field.connectionIndex,
+	}, true
+}
+
 // ObjectTree is a structure that contains a tree of AML entities where each
 // entity is allocated from a contiguous Object pool. Index #0 of the pool
 // contains the root scope ('\') of the AML tree.
 type ObjectTree struct {
 	objPool           []*Object
 	freeListHeadIndex uint32
+
+	// namespace provides a hierarchical view of the named objects in
+	// the tree. It is lazily allocated by Namespace.
+	namespace *Namespace
+
+	// forwardRefs tracks the name references that the parser could not
+	// resolve to an object definition.
+	forwardRefs []forwardRef
+
+	// The revision of the parsed DSDT. It determines the width of the
+	// Integers processed by the VM.
+	dsdtRevision uint8
+	dsdtParsed   bool
 }
 
 // NewObjectTree returns a new ObjectTree instance.
@@ -94,6 +186,12 @@ func NewObjectTree() *ObjectTree {
 	}
 }
 
+// DSDTRevision returns the revision of the DSDT table that was parsed into the
+// tree. The second return value is false if no DSDT has been parsed.
+func (tree *ObjectTree) DSDTRevision() (uint8, bool) {
+	return tree.dsdtRevision, tree.dsdtParsed
+}
+
 // CreateDefaultScopes populates the Object pool with the default scopes
 // specified by the ACPI standard:
 //
@@ -103,6 +201,7 @@ func NewObjectTree() *ObjectTree {
 //      +- [_SB_] (System bus with all device objects)
 //      +- [_SI_] (System indicators)
 //      +- [_TZ_] (ACPI 1.0 thermal zone namespace)
+//      +- [_OSI] (Operating system interfaces; implemented by the interpreter)
 func (tree *ObjectTree) CreateDefaultScopes(tableHandle uint8) {
 	root := tree.newNamedObject(pOpIntScopeBlock, tableHandle, [amlNameLen]byte{'\\'})
 	tree.append(root, tree.newNamedObject(pOpIntScopeBlock, tableHandle, [amlNameLen]byte{'_', 'G', 'P', 'E'})) // General events in GPE register block
@@ -110,8 +209,33 @@ func (tree *ObjectTree) CreateDefaultScopes(tableHandle uint8) {
 	tree.append(root, tree.newNamedObject(pOpIntScopeBlock, tableHandle, [amlNameLen]byte{'_', 'S', 'B', '_'})) // System bus with all device objects
 	tree.append(root, tree.newNamedObject(pOpIntScopeBlock, tableHandle, [amlNameLen]byte{'_', 'S', 'I', '_'})) // System indicators
 	tree.append(root, tree.newNamedObject(pOpIntScopeBlock, tableHandle, [amlNameLen]byte{'_', 'T', 'Z', '_'})) // ACPI 1.0 thermal zone namespace
+	tree.append(root, tree.newBuiltinMethod(tableHandle, [amlNameLen]byte{'_', 'O', 'S', 'I'}, 1))              // Operating system interfaces
+}
+
+// newBuiltinMethod allocates a Method object with an empty body that accepts
+// argCount arguments. Calls to builtin methods are serviced by the
+// interpreter. Defining builtin methods in the tree allows the parser to
+// resolve calls to them and consume the correct number of arguments.
+func (tree *ObjectTree) newBuiltinMethod(tableHandle uint8, name [amlNameLen]byte, argCount uint8) *Object {
+	method := tree.newNamedObject(pOpMethod, tableHandle, name)
+	method.value = builtinMethodMarker{}
+
+	namePath := tree.newObject(pOpIntNamePath, tableHandle)
+	namePath.value = name[:]
+	tree.append(method, namePath)
+
+	flags := tree.newObject(pOpBytePrefix, tableHandle)
+	flags.value = uint64(argCount)
+	tree.append(method, flags)
+
+	tree.append(method, tree.newObject(pOpIntScopeBlock, tableHandle))
+	return method
 }
 
+// builtinMethodMarker is stored as the value of Method objects created by
+// newBuiltinMethod.
+type builtinMethodMarker struct{}
+
 // newObject allocates a new Object from the Object pool, populates its
 // contents and returns back a pointer to it.
 func (tree *ObjectTree) newObject(opcode uint16, tableHandle uint8) *Object {
@@ -201,6 +325,15 @@ func (tree *ObjectTree) free(obj *Object) {
 	tree.freeListHeadIndex = obj.index
 }
 
+// freeTree frees obj and, recursively, all of its args.
+func (tree *ObjectTree) freeTree(obj *Object) {
+	for obj.firstArgIndex != InvalidIndex {
+		tree.freeTree(tree.ObjectAt(obj.firstArgIndex))
+	}
+
+	tree.free(obj)
+}
+
 // detach detaches arg from obj's argument list.
 func (tree *ObjectTree) detach(obj, arg *Object) {
 	if obj.firstArgIndex == arg.index {
@@ -269,7 +402,7 @@ func (tree *ObjectTree) Find(scopeIndex uint32, expr []byte) uint32 {
 			case '^':
 				// Mpve up one parent. If we were at the root scope
 				// then the lookup failed.
-				if scopeIndex = tree.ObjectAt(scopeIndex).parentIndex; scopeIndex == InvalidIndex {
+				if scopeIndex = tree.parentScope(scopeIndex); scopeIndex == InvalidIndex {
 					return InvalidIndex
 				}
 			default:
@@ -289,7 +422,7 @@ func (tree *ObjectTree) Find(scopeIndex uint32, expr []byte) uint32 {
 		// search for it in this scope and all its parent scopes till
 		// we reach the root.
 		for nextScopeIndex := scopeIndex; nextScopeIndex != InvalidIndex; nextScopeIndex = tree.ObjectAt(nextScopeIndex).parentIndex {
-			scopeObj := tree.ObjectAt(nextScopeIndex)
+			scopeObj := tree.scopeContents(tree.ObjectAt(nextScopeIndex))
 		checkNextSibling:
 			for nextIndex := scopeObj.firstArgIndex; nextIndex != InvalidIndex; nextIndex = tree.ObjectAt(nextIndex).nextSiblingIndex {
 				obj := tree.ObjectAt(nextIndex)
@@ -326,7 +459,7 @@ nextSegment:
 		}
 
 		// Search current scope for an entity matching the next name segment
-		scopeObj := tree.ObjectAt(scopeIndex)
+		scopeObj := tree.scopeContents(tree.ObjectAt(scopeIndex))
 
 	checkNextSibling:
 		for nextIndex := scopeObj.firstArgIndex; nextIndex != InvalidIndex; nextIndex = tree.ObjectAt(nextIndex).nextSiblingIndex {
@@ -352,6 +485,38 @@ nextSegment:
 	return scopeIndex
 }
 
+// scopeContents returns the object whose args hold the contents of the scope
+// defined by obj. Scoped named objects (e.g. Devices and Methods) store their
+// contents in a nested pOpIntScopeBlock; for all other objects scopeContents
+// returns obj itself.
+func (tree *ObjectTree) scopeContents(obj *Object) *Object {
+	if obj.opcode == pOpIntScopeBlock || pOpcodeTable[obj.infoIndex].flags&pOpFlagScoped == 0 {
+		return obj
+	}
+
+	for argIndex := obj.firstArgIndex; argIndex != InvalidIndex; argIndex = tree.ObjectAt(argIndex).nextSiblingIndex {
+		if argObj := tree.ObjectAt(argIndex); argObj.opcode == pOpIntScopeBlock {
+			return argObj
+		}
+	}
+
+	return obj
+}
+
+// parentScope returns the index of the closest ancestor of the object at
+// scopeIndex that defines a namespace scope, skipping over any unnamed
+// pOpIntScopeBlock objects. If no such ancestor exists, parentScope returns
+// InvalidIndex.
+func (tree *ObjectTree) parentScope(scopeIndex uint32) uint32 {
+	for scopeIndex = tree.ObjectAt(scopeIndex).parentIndex; scopeIndex != InvalidIndex; scopeIndex = tree.ObjectAt(scopeIndex).parentIndex {
+		if obj := tree.ObjectAt(scopeIndex); obj.opcode != pOpIntScopeBlock || nameOf(obj) != nil {
+			return scopeIndex
+		}
+	}
+
+	return InvalidIndex
+}
+
 // ClosestNamedAncestor returns the index of the first named object that is an
 // ancestor of obj. If any of obj's parents are unresolved scope directives
 // then the call will return InvalidIndex.
@@ -390,6 +555,49 @@ func (tree *ObjectTree) NumArgs(obj *Object) uint32 {
 	return argCount
 }
 
+// methodArgCount returns the number of args expected by a Method object or
+// by an External declaration for a method defined in another table. If obj
+// does not describe a method, methodArgCount returns false.
+func (tree *ObjectTree) methodArgCount(obj *Object) (uint8, bool) {
+	var argCountIndex uint32
+	switch {
+	case obj == nil:
+		return 0, false
+	case obj.opcode == pOpMethod:
+		argCountIndex = 1
+	case obj.opcode == pOpExternal:
+		if typeObj := tree.ArgAt(obj, 1); typeObj == nil || typeObj.value != externalTypeMethod {
+			return 0, false
+		}
+		argCountIndex = 2
+	default:
+		return 0, false
+	}
+
+	argCountObj := tree.ArgAt(obj, argCountIndex)
+	if argCountObj == nil {
+		return 0, false
+	}
+
+	argCount, ok := argCountObj.value.(uint64)
+	return uint8(argCount & 0x7), ok
+}
+
+// Args returns the args of obj in the order that they appear in the AML
+// stream.
+func (tree *ObjectTree) Args(obj *Object) []*Object {
+	if obj == nil {
+		return nil
+	}
+
+	var args []*Object
+	for argIndex := obj.firstArgIndex; argIndex != InvalidIndex; argIndex = tree.ObjectAt(argIndex).nextSiblingIndex {
+		args = append(args, tree.ObjectAt(argIndex))
+	}
+
+	return args
+}
+
 // ArgAt returns a pointer to obj's arg located at index.
 func (tree *ObjectTree) ArgAt(obj *Object, index uint32) *Object {
 	if obj == nil {
@@ -432,7 +640,7 @@ func (tree *ObjectTree) toString(w io.Writer, padBuf *bytes.Buffer, index uint32
 
 	if curObj.opcode == pOpIntMethodCall {
 		methodObj := tree.ObjectAt(curObj.value.(uint32))
-		argCount := uint8(tree.ArgAt(methodObj, 1).value.(uint64) & 0x7)
+		argCount, _ := tree.methodArgCount(methodObj)
 		kfmt.Fprintf(w, " -> [call to \"%s\", argCount: %d, table: %d, index: %d, offset: 0x%x]", methodObj.name[:], argCount, methodObj.tableHandle, methodObj.index, methodObj.amlOffset)
 	} else if curObj.opcode == pOpIntResolvedNamePath {
 		resolvedObj := tree.ObjectAt(curObj.value.(uint32))
@@ -27,6 +27,61 @@ func TestTreeObjectAt(t *testing.T) {
 	}
 }
 
+func TestObjectAccessors(t *testing.T) {
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+
+	obj := tree.ObjectAt(tree.Find(0, []byte(`\_SB_`)))
+	if obj == nil {
+		t.Fatal("expected to find the _SB_ scope")
+	}
+
+	if got := obj.Index(); got != obj.index {
+		t.Errorf("expected Index() to return %d; got %d", obj.index, got)
+	}
+
+	if got := string(obj.Name()); got != "_SB_" {
+		t.Errorf("expected Name() to return %q; got %q", "_SB_", got)
+	}
+
+	if got, exp := obj.Kind(), pOpcodeName(pOpIntScopeBlock); got != exp {
+		t.Errorf("expected Kind() to return %q; got %q", exp, got)
+	}
+
+	if got := obj.Value(); got != nil {
+		t.Errorf("expected Value() to return nil; got %#v", got)
+	}
+
+	constObj := tree.newObject(pOpBytePrefix, 0)
+	constObj.value = uint64(42)
+	if got := constObj.Value(); got != uint64(42) {
+		t.Errorf("expected Value() to return 42; got %#v", got)
+	}
+
+	if _, ok := constObj.FieldUnit(); ok {
+		t.Error("expected FieldUnit() to return false for a non-field object")
+	}
+
+	fieldObj := tree.newObject(pOpIntNamedField, 0)
+	fieldObj.value = &fieldElement{fieldIndex: 7, offset: 12, width: 4, accessType: 1, connectionIndex: InvalidIndex}
+	expInfo := FieldUnitInfo{FieldIndex: 7, BitOffset: 12, BitWidth: 4, AccessType: 1, ConnectionIndex: InvalidIndex}
+	if info, ok := fieldObj.FieldUnit(); !ok || info != expInfo {
+		t.Errorf("expected FieldUnit() to return %+v; got %+v, %t", expInfo, info, ok)
+	}
+
+	if got := fieldObj.Value(); got != nil {
+		t.Errorf("expected Value() for a named field to return nil; got %#v", got)
+	}
+
+	if constObj.IsBuiltin() {
+		t.Error("expected IsBuiltin() to return false for a non-method object")
+	}
+
+	if osi := tree.newBuiltinMethod(0, [amlNameLen]byte{'_', 'O', 'S', 'I'}, 1); !osi.IsBuiltin() {
+		t.Error("expected IsBuiltin() to return true for a builtin method")
+	}
+}
+
 func TestTreeFreelist(t *testing.T) {
 	tree := NewObjectTree()
 
@@ -328,11 +383,71 @@ func TestFind(t *testing.T) {
 	}
 }
 
+func TestFindInScopedObjects(t *testing.T) {
+	// Setup a tree where named objects store their contents in a nested
+	// (unnamed) scope block just like the parser does:
+	// \
+	//  SB
+	//    \
+	//     PCI0 (Device)
+	//         | _PRT (Method)
+	//         |   | LOC0
+	//         \
+	//          SBRG (Device)
+	//              | APDE
+	tree := NewObjectTree()
+	root := tree.newNamedObject(pOpIntScopeBlock, 0, [4]byte{'\\'})
+	sb := tree.newNamedObject(pOpIntScopeBlock, 0, [4]byte{'_', 'S', 'B', '_'})
+	tree.append(root, sb)
+
+	pci := tree.newNamedObject(pOpDevice, 0, [4]byte{'P', 'C', 'I', '0'})
+	pciBody := tree.newObject(pOpIntScopeBlock, 0)
+	tree.append(sb, pci)
+	tree.append(pci, tree.newObject(pOpIntNamePath, 0))
+	tree.append(pci, pciBody)
+
+	prt := tree.newNamedObject(pOpMethod, 0, [4]byte{'_', 'P', 'R', 'T'})
+	prtBody := tree.newObject(pOpIntScopeBlock, 0)
+	loc := tree.newNamedObject(pOpName, 0, [4]byte{'L', 'O', 'C', '0'})
+	tree.append(pciBody, prt)
+	tree.append(prt, tree.newObject(pOpIntNamePath, 0))
+	tree.append(prt, prtBody)
+	tree.append(prtBody, loc)
+
+	sbrg := tree.newNamedObject(pOpDevice, 0, [4]byte{'S', 'B', 'R', 'G'})
+	sbrgBody := tree.newObject(pOpIntScopeBlock, 0)
+	apde := tree.newNamedObject(pOpName, 0, [4]byte{'A', 'P', 'D', 'E'})
+	tree.append(pciBody, sbrg)
+	tree.append(sbrg, sbrgBody)
+	tree.append(sbrgBody, apde)
+
+	specs := []struct {
+		curScope uint32
+		expr     string
+		want     uint32
+	}{
+		{root.index, `\_SB_PCI0SBRGAPDE`, apde.index},
+		{root.index, `\_SB_.PCI0._PRT`, prt.index},
+		{prt.index, `^SBRGAPDE`, apde.index},
+		{prt.index, `^`, pci.index},
+		{prt.index, `^^PCI0`, pci.index},
+		{prt.index, "LOC0", loc.index},
+		{prt.index, "SBRG", sbrg.index},
+		{sbrg.index, "LOC0", InvalidIndex},
+	}
+
+	for specIndex, spec := range specs {
+		if got := tree.Find(spec.curScope, []byte(spec.expr)); got != spec.want {
+			t.Errorf("[spec %d] expected lookup to return index %d; got %d", specIndex, spec.want, got)
+		}
+	}
+}
+
 func TestNumArgs(t *testing.T) {
 	tree := NewObjectTree()
 	tree.CreateDefaultScopes(42)
 
-	if exp, got := uint32(5), tree.NumArgs(tree.ObjectAt(0)); got != exp {
+	if exp, got := uint32(6), tree.NumArgs(tree.ObjectAt(0)); got != exp {
 		t.Fatalf("expected NumArgs(root) to return %d; got %d", exp, got)
 	}
 
@@ -341,6 +456,27 @@ func TestNumArgs(t *testing.T) {
 	}
 }
 
+func TestArgs(t *testing.T) {
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(42)
+
+	root := tree.ObjectAt(0)
+	args := tree.Args(root)
+	if exp, got := int(tree.NumArgs(root)), len(args); got != exp {
+		t.Fatalf("expected Args(root) to return %d args; got %d", exp, got)
+	}
+
+	for argIndex, arg := range args {
+		if exp := tree.ArgAt(root, uint32(argIndex)); arg != exp {
+			t.Errorf("expected arg %d to be %s; got %s", argIndex, exp.Name(), arg.Name())
+		}
+	}
+
+	if got := tree.Args(nil); got != nil {
+		t.Errorf("expected Args(nil) to return nil; got %v", got)
+	}
+}
+
 func TestArgAt(t *testing.T) {
 	tree := NewObjectTree()
 	tree.CreateDefaultScopes(42)
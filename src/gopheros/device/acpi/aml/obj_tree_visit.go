@@ -0,0 +1,101 @@
+package aml
+
+// VisitAction controls how ObjectTree.Visit proceeds after invoking a visitor
+// function.
+type VisitAction uint8
+
+// The list of actions that can be returned by a VisitFunc.
+const (
+	// VisitContinue continues the walk. When returned by a pre-order
+	// visitor, the args of the visited object are also visited.
+	VisitContinue VisitAction = iota
+
+	// VisitSkipArgs prevents the args of the visited object from being
+	// visited. It is equivalent to VisitContinue when returned by a
+	// post-order visitor.
+	VisitSkipArgs
+
+	// VisitStop aborts the walk.
+	VisitStop
+)
+
+// VisitFunc is a function invoked by ObjectTree.Visit for each visited object.
+type VisitFunc func(obj *Object) VisitAction
+
+// TransformFunc is a function invoked by ObjectTree.Transform for each visited
+// object. It returns the object that should take the place of obj in the tree:
+//   - returning obj leaves the tree unchanged.
+//   - returning nil removes obj and its args from the tree.
+//   - returning a different, detached object replaces obj and its args with
+//     the returned object.
+type TransformFunc func(obj *Object) *Object
+
+// Visit performs a depth-first walk of the subtree rooted at root. The pre
+// visitor is invoked before visiting the args of an object and the post
+// visitor after all args have been visited. Either visitor may be nil.
+//
+// If pre returns VisitSkipArgs, the args of the object are not visited but
+// post is still invoked for it. If any visitor returns VisitStop, the walk is
+// aborted.
+func (tree *ObjectTree) Visit(root *Object, pre, post VisitFunc) {
+	if root == nil {
+		return
+	}
+
+	tree.visit(root, pre, post)
+}
+
+// visit implements Visit and returns false if the walk must be aborted.
+func (tree *ObjectTree) visit(obj *Object, pre, post VisitFunc) bool {
+	action := VisitContinue
+	if pre != nil {
+		if action = pre(obj); action == VisitStop {
+			return false
+		}
+	}
+
+	if action != VisitSkipArgs {
+		// The next sibling index is looked up before visiting each arg
+		// so that visitors may safely detach the visited object.
+		for argIndex := obj.firstArgIndex; argIndex != InvalidIndex; {
+			argObj := tree.ObjectAt(argIndex)
+			argIndex = argObj.nextSiblingIndex
+			if !tree.visit(argObj, pre, post) {
+				return false
+			}
+		}
+	}
+
+	return post == nil || post(obj) != VisitStop
+}
+
+// Transform performs a post-order walk of the subtree rooted at root, invoking
+// fn for each object after its args have been transformed, and applies the
+// changes requested by fn to the tree. Replaced and removed objects are
+// returned to the tree's free list.
+//
+// Transform returns the object that takes the place of root after the
+// transformation or nil if root was removed.
+func (tree *ObjectTree) Transform(root *Object, fn TransformFunc) *Object {
+	if root == nil {
+		return nil
+	}
+
+	for argIndex := root.firstArgIndex; argIndex != InvalidIndex; {
+		argObj := tree.ObjectAt(argIndex)
+		argIndex = argObj.nextSiblingIndex
+		tree.Transform(argObj, fn)
+	}
+
+	repl := fn(root)
+	if repl == root {
+		return root
+	}
+
+	if repl != nil && root.parentIndex != InvalidIndex {
+		tree.appendAfter(tree.ObjectAt(root.parentIndex), repl, root)
+	}
+
+	tree.freeTree(root)
+	return repl
+}
@@ -0,0 +1,145 @@
+package aml
+
+import (
+	"reflect"
+	"testing"
+)
+
+// visitTestTree builds the following tree and returns its root. The table
+// handle of each object is used as its identifier.
+//
+//   0
+//   +- 1
+//   |  +- 2
+//   |  +- 3
+//   +- 4
+//      +- 5
+func visitTestTree(tree *ObjectTree) *Object {
+	objs := make([]*Object, 6)
+	for i := range objs {
+		objs[i] = tree.newObject(pOpIntScopeBlock, uint8(i))
+	}
+
+	tree.append(objs[0], objs[1])
+	tree.append(objs[1], objs[2])
+	tree.append(objs[1], objs[3])
+	tree.append(objs[0], objs[4])
+	tree.append(objs[4], objs[5])
+	return objs[0]
+}
+
+func TestTreeVisit(t *testing.T) {
+	specs := []struct {
+		pre, post VisitAction
+		skipID    uint8
+		expPre    []uint8
+		expPost   []uint8
+	}{
+		{VisitContinue, VisitContinue, 0xff, []uint8{0, 1, 2, 3, 4, 5}, []uint8{2, 3, 1, 5, 4, 0}},
+		{VisitSkipArgs, VisitContinue, 1, []uint8{0, 1, 4, 5}, []uint8{1, 5, 4, 0}},
+		{VisitStop, VisitContinue, 3, []uint8{0, 1, 2, 3}, []uint8{2}},
+		{VisitContinue, VisitStop, 1, []uint8{0, 1, 2, 3}, []uint8{2, 3, 1}},
+	}
+
+	for specIndex, spec := range specs {
+		tree := NewObjectTree()
+		root := visitTestTree(tree)
+
+		var gotPre, gotPost []uint8
+		tree.Visit(
+			root,
+			func(obj *Object) VisitAction {
+				gotPre = append(gotPre, obj.tableHandle)
+				if obj.tableHandle == spec.skipID {
+					return spec.pre
+				}
+				return VisitContinue
+			},
+			func(obj *Object) VisitAction {
+				gotPost = append(gotPost, obj.tableHandle)
+				if obj.tableHandle == spec.skipID {
+					return spec.post
+				}
+				return VisitContinue
+			},
+		)
+
+		if !reflect.DeepEqual(gotPre, spec.expPre) {
+			t.Errorf("[spec %d] expected pre-order visits %v; got %v", specIndex, spec.expPre, gotPre)
+		}
+
+		if !reflect.DeepEqual(gotPost, spec.expPost) {
+			t.Errorf("[spec %d] expected post-order visits %v; got %v", specIndex, spec.expPost, gotPost)
+		}
+	}
+
+	t.Run("nil visitors", func(t *testing.T) {
+		tree := NewObjectTree()
+		tree.Visit(visitTestTree(tree), nil, nil)
+		tree.Visit(nil, nil, nil)
+	})
+}
+
+func TestTreeTransform(t *testing.T) {
+	tree := NewObjectTree()
+	root := visitTestTree(tree)
+
+	var visited []uint8
+	got := tree.Transform(root, func(obj *Object) *Object {
+		visited = append(visited, obj.tableHandle)
+		switch obj.tableHandle {
+		case 1:
+			// Replace the subtree rooted at 1 with a new object
+			return tree.newObject(pOpIntScopeBlock, 6)
+		case 5:
+			// Remove 5
+			return nil
+		default:
+			return obj
+		}
+	})
+
+	if got != root {
+		t.Fatal("expected Transform to return the original root")
+	}
+
+	if exp := []uint8{2, 3, 1, 5, 4, 0}; !reflect.DeepEqual(visited, exp) {
+		t.Fatalf("expected objects to be visited in order %v; got %v", exp, visited)
+	}
+
+	var ids []uint8
+	tree.Visit(root, func(obj *Object) VisitAction {
+		ids = append(ids, obj.tableHandle)
+		return VisitContinue
+	}, nil)
+
+	if exp := []uint8{0, 6, 4}; !reflect.DeepEqual(ids, exp) {
+		t.Fatalf("expected transformed tree to contain %v; got %v", exp, ids)
+	}
+
+	// The freed objects should be reused by subsequent allocations
+	if obj := tree.newObject(pOpIntScopeBlock, 0); obj.index > 6 {
+		t.Fatalf("expected freed objects to be reused; got new object with index %d", obj.index)
+	}
+
+	t.Run("replace root", func(t *testing.T) {
+		tree := NewObjectTree()
+		root := visitTestTree(tree)
+
+		repl := tree.newObject(pOpIntScopeBlock, 7)
+		got := tree.Transform(root, func(obj *Object) *Object {
+			if obj == root {
+				return repl
+			}
+			return obj
+		})
+
+		if got != repl {
+			t.Fatalf("expected Transform to return the replacement root")
+		}
+
+		if tree.Transform(nil, nil) != nil {
+			t.Fatal("expected Transform to return nil for a nil root")
+		}
+	})
+}
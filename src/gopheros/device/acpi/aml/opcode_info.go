@@ -0,0 +1,130 @@
+package aml
+
+// OpFlag is a set of OR-able flags that describe the attributes of the
+// objects generated by an opcode.
+type OpFlag uint8
+
+// The list of flags that can be reported by OpInfo.
+const (
+	// OpFlagNamed is set for opcodes that define a named object.
+	OpFlagNamed = OpFlag(pOpFlagNamed)
+
+	// OpFlagConstant is set for opcodes that encode a constant value.
+	OpFlagConstant = OpFlag(pOpFlagConstant)
+
+	// OpFlagReference is set for opcodes that produce a reference.
+	OpFlagReference = OpFlag(pOpFlagReference)
+
+	// OpFlagCreate is set for opcodes that create a data object.
+	OpFlagCreate = OpFlag(pOpFlagCreate)
+
+	// OpFlagExecutable is set for opcodes that are executed by the VM.
+	OpFlagExecutable = OpFlag(pOpFlagExecutable)
+
+	// OpFlagScoped is set for opcodes that open a new namespace scope.
+	OpFlagScoped = OpFlag(pOpFlagScoped)
+
+	// OpFlagDeferParsing is set for opcodes whose contents are parsed in
+	// a second pass once all named objects have been defined.
+	OpFlagDeferParsing = OpFlag(pOpFlagDeferParsing)
+)
+
+// ArgType describes the encoding of an argument expected by an opcode.
+type ArgType uint8
+
+// The list of argument types that can be reported by OpInfo.
+const (
+	ArgTypeTermList   = ArgType(pArgTypeTermList)
+	ArgTypeTermArg    = ArgType(pArgTypeTermArg)
+	ArgTypeByteList   = ArgType(pArgTypeByteList)
+	ArgTypeString     = ArgType(pArgTypeString)
+	ArgTypeByteData   = ArgType(pArgTypeByteData)
+	ArgTypeWordData   = ArgType(pArgTypeWordData)
+	ArgTypeDwordData  = ArgType(pArgTypeDwordData)
+	ArgTypeQwordData  = ArgType(pArgTypeQwordData)
+	ArgTypeNameString = ArgType(pArgTypeNameString)
+	ArgTypeSuperName  = ArgType(pArgTypeSuperName)
+	ArgTypeSimpleName = ArgType(pArgTypeSimpleName)
+	ArgTypeDataRefObj = ArgType(pArgTypeDataRefObj)
+	ArgTypeTarget     = ArgType(pArgTypeTarget)
+	ArgTypeFieldList  = ArgType(pArgTypeFieldList)
+	ArgTypePkgLen     = ArgType(pArgTypePkgLen)
+)
+
+var argTypeNames = [...]string{
+	"Unknown", "TermList", "TermArg", "ByteList", "String", "ByteData",
+	"WordData", "DwordData", "QwordData", "NameString", "SuperName",
+	"SimpleName", "DataRefObj", "Target", "FieldList", "PkgLen",
+}
+
+// String implements fmt.Stringer for ArgType.
+func (t ArgType) String() string {
+	if int(t) >= len(argTypeNames) {
+		return argTypeNames[0]
+	}
+
+	return argTypeNames[t]
+}
+
+// OpInfo describes an opcode known to the parser.
+type OpInfo struct {
+	// The opcode value. Extended opcodes (prefixed by 0x5b in the AML
+	// stream) are encoded as 0xff + the second opcode byte.
+	Opcode uint16
+
+	// The opcode name as reported by Object.Kind.
+	Name string
+
+	// The attributes of the objects generated by the opcode.
+	Flags OpFlag
+
+	// The types of the arguments expected by the opcode in the order
+	// that they appear in the AML stream.
+	Args []ArgType
+
+	// Internal is set for opcodes that do not appear in the AML stream
+	// but are used by the parser to represent entities such as named
+	// fields and method calls.
+	Internal bool
+
+	// The namespace object type for objects generated by the opcode or
+	// ObjectTypeAny if these objects cannot be used to filter a
+	// namespace walk.
+	ObjectType ObjectType
+}
+
+// OpcodeInfo returns the information that the parser's opcode table contains
+// about opcode. The second return value is false if the opcode is not known
+// to the parser.
+func OpcodeInfo(opcode uint16) (OpInfo, bool) {
+	if opcode >= pOpIntFreedObject {
+		return OpInfo{}, false
+	}
+
+	index := pOpcodeTableIndex(opcode, true)
+	if index == badOpcode || int(index) >= len(pOpcodeTable) || pOpcodeTable[index].op != opcode {
+		return OpInfo{}, false
+	}
+
+	entry := &pOpcodeTable[index]
+	info := OpInfo{
+		Opcode:     entry.op,
+		Name:       entry.opName,
+		Flags:      OpFlag(entry.flags),
+		Args:       make([]ArgType, entry.argFlags.argCount()),
+		Internal:   opcode >= pOpIntScopeBlock,
+		ObjectType: objectTypeOf(opcode),
+	}
+
+	for argIndex := range info.Args {
+		info.Args[argIndex] = ArgType(entry.argFlags.arg(uint8(argIndex)))
+	}
+
+	return info, true
+}
+
+// Opcode returns the opcode of the AML entity described by this object. The
+// returned value can be passed to OpcodeInfo to query the opcode attributes.
+func (obj *Object) Opcode() uint16 {
+	return obj.opcode
+}
@@ -0,0 +1,79 @@
+package aml
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestOpcodeInfo(t *testing.T) {
+	specs := []struct {
+		opcode uint16
+		exp    OpInfo
+	}{
+		{
+			pOpStore,
+			OpInfo{Opcode: pOpStore, Name: "Store", Flags: OpFlagExecutable, Args: []ArgType{ArgTypeTermArg, ArgTypeSuperName}},
+		},
+		{
+			pOpZero,
+			OpInfo{Opcode: pOpZero, Name: "Zero", Flags: OpFlagConstant, Args: []ArgType{}},
+		},
+		{
+			pOpDevice,
+			OpInfo{
+				Opcode:     pOpDevice,
+				Name:       "Device",
+				Flags:      OpFlagNamed | OpFlagScoped,
+				Args:       []ArgType{ArgTypePkgLen, ArgTypeNameString, ArgTypeTermList},
+				ObjectType: ObjectTypeDevice,
+			},
+		},
+		{
+			pOpIntMethodCall,
+			OpInfo{
+				Opcode:   pOpIntMethodCall,
+				Name:     "MethodCall",
+				Flags:    OpFlagCreate,
+				Args:     []ArgType{},
+				Internal: true,
+			},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		got, ok := OpcodeInfo(spec.opcode)
+		if !ok {
+			t.Errorf("[spec %d] expected lookup to succeed", specIndex)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected to get %+v; got %+v", specIndex, spec.exp, got)
+		}
+	}
+
+	for _, opcode := range []uint16{0x02ff, pOpIntFreedObject, extOpPrefix} {
+		if _, ok := OpcodeInfo(opcode); ok {
+			t.Errorf("expected lookup for unknown opcode 0x%x to fail", opcode)
+		}
+	}
+
+	t.Run("all table entries", func(t *testing.T) {
+		for _, entry := range pOpcodeTable {
+			info, ok := OpcodeInfo(entry.op)
+			if !ok || info.Name != entry.opName || int(entry.argFlags.argCount()) != len(info.Args) {
+				t.Errorf("unexpected info for opcode %s: %+v", entry.opName, info)
+			}
+		}
+	})
+}
+
+func TestArgTypeString(t *testing.T) {
+	if got := ArgTypeSuperName.String(); got != "SuperName" {
+		t.Errorf("expected SuperName; got %s", got)
+	}
+
+	if got := ArgType(0xff).String(); got != "Unknown" {
+		t.Errorf("expected Unknown; got %s", got)
+	}
+}
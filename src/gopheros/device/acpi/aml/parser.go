@@ -5,12 +5,11 @@ import (
 	"gopheros/kernel"
 	"gopheros/kernel/kfmt"
 	"io"
-	"reflect"
 	"unsafe"
 )
 
 var (
-	errParsingAML = &kernel.Error{Module: "acpi_aml_parser", Message: "could not parse AML bytecode"}
+	errParsingAML = &kernel.Error{Module: "acpi_aml_parser", Message: "could not parse AML bytecode", Code: kernel.ErrCodeCorrupted}
 )
 
 type parseResult uint8
@@ -28,6 +27,10 @@ const (
 	// The maximum number of mergeScopeDirectives - relocateNamedObjects passes
 	// attempted by the parser to fully resolve static objects.
 	maxResolvePasses = 5
+
+	// The table name used in error messages for streams parsed by
+	// ParseFragment.
+	fragmentTableName = "fragment"
 )
 
 // Parser implements a parser for ACPI Machine Language (AML) bytecode.
@@ -35,8 +38,9 @@ type Parser struct {
 	r         amlStreamReader
 	errWriter io.Writer
 
-	tableName   string
-	tableHandle uint8
+	tableName      string
+	tableSignature string
+	tableHandle    uint8
 
 	objTree     *ObjectTree
 	scopeStack  []uint32
@@ -48,6 +52,14 @@ type Parser struct {
 	relocatedObjects uint32
 
 	mode parseMode
+
+	recoveryMode    bool
+	recoveredErrors []RecoveredError
+
+	// The indices of the objects whose args are currently being parsed
+	// and the details of the last parse failure.
+	parseStack []uint32
+	lastErr    *ParseError
 }
 
 // NewParser creates a new AML parser instance that attaches parsed AML entities to
@@ -63,16 +75,74 @@ func NewParser(errWriter io.Writer, objTree *ObjectTree) *Parser {
 // table tagging each scoped entity with the supplied table handle.
 func (p *Parser) ParseAML(tableHandle uint8, tableName string, header *table.SDTHeader) *kernel.Error {
 	p.init(tableHandle, tableName, header)
+	return p.parse()
+}
+
+// ParseAMLStream works like ParseAML but reads the table contents (including
+// the table header) from src instead of requiring the entire table to be
+// mapped as a contiguous block of memory. The parser only buffers a small
+// window of the table contents while parsing; the strings, byte lists and
+// name paths referenced by the parsed entities are copied out of the stream.
+func (p *Parser) ParseAMLStream(tableHandle uint8, tableName string, src io.ReaderAt) *kernel.Error {
+	var header table.SDTHeader
+	headerBytes := (*[unsafe.Sizeof(table.SDTHeader{})]byte)(unsafe.Pointer(&header))
+	if n, _ := src.ReadAt(headerBytes[:], 0); n != len(headerBytes) || header.Length < uint32(len(headerBytes)) {
+		p.resetState(tableHandle, tableName)
+		p.lastErr = &ParseError{TableName: tableName, Message: "could not read table header"}
+		return errParsingAML.Wrap(p.lastErr)
+	}
 
+	p.resetState(tableHandle, tableName)
+	p.tableSignature = string(header.Signature[:])
+	p.recordRevision(&header)
+	p.r.InitStream(src, header.Length, uint32(len(headerBytes)))
+
+	// Keep track of the stream end for parsing deferred objects
+	p.streamEnd = header.Length
+	_ = p.pushPkgEnd(header.Length)
+
+	return p.parse()
+}
+
+// ParseFragment parses a raw AML byte stream that is not prefixed by an ACPI
+// table header (e.g. the body of an SSDT or an input generated by a fuzzer)
+// and attaches the parsed entities to the object tree tagging them with the
+// supplied table handle. All reads are bounds-checked against the end of
+// data; malformed or truncated input results in an error.
+func (p *Parser) ParseFragment(tableHandle uint8, data []byte) *kernel.Error {
+	p.resetState(tableHandle, fragmentTableName)
+	p.tableSignature = ""
+
+	if uint64(len(data)) > uint64(^uint32(0)) {
+		p.lastErr = &ParseError{TableName: fragmentTableName, Message: "fragment exceeds the maximum AML stream length"}
+		return errParsingAML.Wrap(p.lastErr)
+	}
+
+	var dataAddr uintptr
+	if len(data) != 0 {
+		dataAddr = uintptr(unsafe.Pointer(&data[0]))
+	}
+	p.r.Init(dataAddr, uint32(len(data)), 0)
+
+	// Keep track of the stream end for parsing deferred objects
+	p.streamEnd = uint32(len(data))
+	_ = p.pushPkgEnd(p.streamEnd)
+
+	return p.parse()
+}
+
+// parse processes the AML stream that the parser's reader has been
+// initialized with.
+func (p *Parser) parse() *kernel.Error {
 	// Parse raw object list starting at the root scope
 	p.scopeEnter(0)
 	if p.parseObjectList() == parseResultFailed {
-		return errParsingAML
+		return p.parseFailed("parsing object list")
 	}
 
 	// Connect missing args to named objects
 	if p.connectNamedObjArgs(0) != parseResultOk {
-		return errParsingAML
+		return p.parseFailed("connecting named object args")
 	}
 
 	// Resolve scope directives for non-executable blocks and relocate named
@@ -116,12 +186,12 @@ func (p *Parser) ParseAML(tableHandle uint8, tableName string, header *table.SDT
 	for ; ; p.resolvePasses++ {
 		mergeRes := p.mergeScopeDirectives(0)
 		if mergeRes == parseResultFailed {
-			return errParsingAML
+			return p.parseFailed("merging scope directives")
 		}
 
 		relocateRes := p.relocateNamedObjects(0)
 		if relocateRes == parseResultFailed {
-			return errParsingAML
+			return p.parseFailed("relocating named objects")
 		}
 
 		// Stop if both calls returned OK
@@ -132,25 +202,47 @@ func (p *Parser) ParseAML(tableHandle uint8, tableName string, header *table.SDT
 
 	// Parse deferred blocks
 	if p.parseDeferredBlocks(0) != parseResultOk {
-		return errParsingAML
+		return p.parseFailed("parsing deferred blocks")
 	}
 
 	// Resolve method calls
 	if p.resolveMethodCalls(0) != parseResultOk {
-		return errParsingAML
+		return p.parseFailed("resolving method calls")
 	}
 
 	// Connect missing args that include method invocations to remaining
 	// non-named objects
 	if p.connectNonNamedObjArgs(0) != parseResultOk {
-		return errParsingAML
+		return p.parseFailed("connecting non-named object args")
 	}
 
+	// Update the namespace with the named objects defined by this table
+	p.objTree.Namespace().populate()
+
+	// Revisit references to objects that could not be resolved while
+	// parsing this or any previously parsed table (e.g. calls to methods
+	// defined in a subsequent SSDT).
+	p.resolveForwardRefs()
+
 	return nil
 }
 
+// parseFailed returns an error that wraps the ParseError describing the last
+// parse failure. If no failure details have been recorded, a ParseError for
+// the specified parser stage is generated. The error details are also written
+// to the parser's error writer.
+func (p *Parser) parseFailed(stage string) *kernel.Error {
+	p.recordParseError(nil, p.r.Offset(), "parser failed while %s", stage)
+
+	kfmt.Fprintf(p.errWriter, "%s\n", p.lastErr.Error())
+	p.lastErr.Hexdump(p.errWriter)
+	return errParsingAML.Wrap(p.lastErr)
+}
+
 func (p *Parser) init(tableHandle uint8, tableName string, header *table.SDTHeader) {
 	p.resetState(tableHandle, tableName)
+	p.tableSignature = string(header.Signature[:])
+	p.recordRevision(header)
 
 	p.r.Init(
 		uintptr(unsafe.Pointer(header)),
@@ -163,6 +255,15 @@ func (p *Parser) init(tableHandle uint8, tableName string, header *table.SDTHead
 	_ = p.pushPkgEnd(header.Length)
 }
 
+// recordRevision stores the revision of a DSDT table in the object tree so
+// that the VM can select the appropriate Integer width.
+func (p *Parser) recordRevision(header *table.SDTHeader) {
+	if p.tableSignature == "DSDT" {
+		p.objTree.dsdtRevision = header.Revision
+		p.objTree.dsdtParsed = true
+	}
+}
+
 func (p *Parser) resetState(tableHandle uint8, tableName string) {
 	p.tableHandle = tableHandle
 	p.tableName = tableName
@@ -173,7 +274,8 @@ func (p *Parser) resetState(tableHandle uint8, tableName string) {
 
 	p.scopeStack = nil
 	p.pkgEndStack = nil
-
+	p.parseStack = nil
+	p.lastErr = nil
 }
 
 // parseObjectList tries to parse an AML object list. Object lists are usually
@@ -183,8 +285,17 @@ func (p *Parser) parseObjectList() parseResult {
 	for len(p.scopeStack) != 0 {
 		// Consume up to the current package end
 		for !p.r.EOF() {
+			var rp recoveryPoint
+			if p.recoveryMode {
+				rp = p.recoveryPoint()
+			}
+
 			if p.parseNextObject() != parseResultOk {
-				return parseResultFailed
+				if !p.recoveryMode {
+					return parseResultFailed
+				}
+
+				p.recover(rp)
 			}
 		}
 
@@ -209,7 +320,10 @@ func (p *Parser) parseNextObject() parseResult {
 	}
 
 	if res == parseResultFailed {
-		return p.parseNamePathOrMethodCall()
+		if res = p.parseNamePathOrMethodCall(); res == parseResultFailed {
+			p.recordParseError(nil, curOffset, "encountered invalid opcode or name string")
+		}
+		return res
 	}
 
 	curObj := p.objTree.newObject(nextOp, p.tableHandle)
@@ -235,7 +349,20 @@ func (p *Parser) parseObjectArgs(curObj *Object) parseResult {
 	case pOpStringPrefix:
 		curObj.value, res = p.parseString()
 	default:
+		// Match mixes TermArgs with ByteData args. As the ByteData args
+		// cannot be parsed as standalone sibling objects, the args of
+		// Match are always parsed strictly.
+		if curObj.opcode == pOpMatch && p.mode == parseModeSkipAmbiguousBlocks {
+			p.mode = parseModeAllBlocks
+			defer func() { p.mode = parseModeSkipAmbiguousBlocks }()
+		}
+
+		p.parseStack = append(p.parseStack, curObj.index)
 		res = p.parseArgs(&pOpcodeTable[curObj.infoIndex], curObj, 0)
+		if res == parseResultFailed {
+			p.recordParseError(curObj, p.r.Offset(), "could not parse args for opcode %s", pOpcodeName(curObj.opcode))
+		}
+		p.parseStack = p.parseStack[:len(p.parseStack)-1]
 	}
 
 	if res == parseResultShortCircuit {
@@ -265,6 +392,13 @@ func (p *Parser) parseArgs(info *pOpcodeInfo, curObj *Object, argOffset uint8) p
 		}
 	}
 
+	// When parsing all blocks, objects with a pkgLen are fully consumed by
+	// the loop above. Restore the pkgEnd of the enclosing block so that the
+	// parser can keep processing any objects following curObj.
+	if p.mode == parseModeAllBlocks && argOffset == 0 && info.argFlags.arg(0) == pArgTypePkgLen {
+		p.popPkgEnd()
+	}
+
 	return res
 }
 
@@ -341,8 +475,9 @@ func (p *Parser) parseArg(info *pOpcodeInfo, curObj *Object, argType pArgType) (
 // parseNamePathOrMethodCall is invoked by the parser when it cannot parse an
 // opcode from the AML stream. This indicates that the stream may contain
 // either a method call or a named path reference. If the current parse mode is
-// parseModeAllBlocks the method will return an error if the next term in the
-// stream does not match an existing namepath or method name.
+// parseModeAllBlocks and the next term in the stream does not match an
+// existing namepath or method name, the term is emitted as an unresolved
+// namepath which the interpreter will attempt to resolve at run-time.
 func (p *Parser) parseNamePathOrMethodCall() parseResult {
 	curOffset := p.r.Offset()
 
@@ -367,9 +502,16 @@ func (p *Parser) parseNamePathOrMethodCall() parseResult {
 		pathExpr,
 	)
 
+	// The path may refer to an object that gets created at run-time (e.g.
+	// via a CreateXXXField opcode). Just like resolveMethodCalls does, treat
+	// it as a namepath to be resolved by the interpreter.
 	if targetIndex == InvalidIndex {
-		kfmt.Fprintf(p.errWriter, "[table: %s, offset: 0x%x] unable to resolve path expression %s\n", p.tableName, p.r.Offset(), pathExpr)
-		return parseResultFailed
+		curObj := p.objTree.newObject(pOpIntNamePath, p.tableHandle)
+		curObj.amlOffset = curOffset
+		curObj.value = pathExpr
+		p.objTree.append(p.scopeCurrent(), curObj)
+		p.deferForwardRef(curObj, pathExpr)
+		return parseResultOk
 	}
 
 	target := p.objTree.ObjectAt(targetIndex)
@@ -377,17 +519,25 @@ func (p *Parser) parseNamePathOrMethodCall() parseResult {
 	curObj.amlOffset = curOffset
 	curObj.value = targetIndex
 	p.objTree.append(p.scopeCurrent(), curObj)
-	if target.opcode != pOpMethod {
+
+	// References to External declarations need to be revisited once the
+	// table that defines the actual object gets parsed.
+	if target.opcode == pOpExternal {
+		p.deferForwardRef(curObj, pathExpr)
+	}
+
+	argCount, isMethod := p.objTree.methodArgCount(target)
+	if !isMethod {
 		return parseResultOk
 	}
 
-	// If target is a method definition we need to make curObj the active scope
-	// and parse the number of args specified by the definition.
+	// If target is a method definition (or an External declaration for a
+	// method) we need to make curObj the active scope and parse the number
+	// of args specified by the definition.
 	curObj.opcode = pOpIntMethodCall
 	curObj.infoIndex = pOpcodeTableIndex(curObj.opcode, true)
 	p.scopeEnter(curObj.index)
 
-	argCount := uint8(p.objTree.ArgAt(target, 1).value.(uint64) & 0x7)
 	for argIndex := uint8(0); argIndex < argCount; argIndex++ {
 		if p.parseNextObject() != parseResultOk {
 			p.scopeExit()
@@ -424,26 +574,28 @@ func (p *Parser) parseStrictTermArg(curObj *Object) (*Object, parseResult) {
 		if res == parseResultOk {
 			termObj = p.objTree.ObjectAt(curObj.lastArgIndex)
 			p.objTree.detach(curObj, termObj)
+		} else {
+			p.recordParseError(nil, curOffset, "encountered invalid opcode or name string")
 		}
 
-		if p.r.EOF() {
-			p.popPkgEnd()
-		}
 		return termObj, res
 	}
 
 	if !pOpIsType2(nextOp) && !pOpIsDataObject(nextOp) && !pOpIsArg(nextOp) {
 		kfmt.Fprintf(p.errWriter, "[table: %s, offset: 0x%x] encountered unexpected opcode %s while parsing termArg\n", p.tableName, p.r.Offset(), pOpcodeName(nextOp))
+		p.recordParseError(nil, curOffset, "encountered unexpected opcode %s while parsing termArg", pOpcodeName(nextOp))
 		return nil, parseResultFailed
 	}
 
 	_, _ = p.nextOpcode()
 	termObj = p.objTree.newObject(nextOp, p.tableHandle)
 	termObj.amlOffset = curOffset
+
+	// Temporarily attach termObj to curObj so that any nested namepath
+	// lookups can walk up the tree to find the enclosing named scope.
+	p.objTree.append(curObj, termObj)
 	res = p.parseObjectArgs(termObj)
-	if p.r.EOF() {
-		p.popPkgEnd()
-	}
+	p.objTree.detach(curObj, termObj)
 
 	return termObj, res
 }
@@ -723,11 +875,7 @@ func (p *Parser) parseFieldElements(curObj *Object) parseResult {
 func (p *Parser) parseByteList(obj *Object, dataLen uint32) {
 	obj.opcode = pOpIntByteList
 	obj.infoIndex = pOpcodeTableIndex(obj.opcode, true)
-	obj.value = *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
-		Len:  int(dataLen),
-		Cap:  int(dataLen),
-		Data: p.r.DataPtr(),
-	}))
+	obj.value = p.r.Bytes(p.r.Offset(), dataLen)
 
 	p.r.SetOffset(p.r.Offset() + dataLen)
 }
@@ -820,10 +968,11 @@ func (p *Parser) parseNumConstant(numBytes uint8) (uint64, parseResult) {
 func (p *Parser) parseString() ([]byte, parseResult) {
 	// Read ASCII chars till we reach a null byte
 	var (
-		next byte
-		err  error
-		res  = parseResultOk
-		str  = reflect.SliceHeader{Data: p.r.DataPtr()}
+		next      byte
+		err       error
+		res       = parseResultOk
+		strOffset = p.r.Offset()
+		strLen    uint32
 	)
 
 	for {
@@ -836,15 +985,14 @@ func (p *Parser) parseString() ([]byte, parseResult) {
 		if next == 0x00 {
 			break
 		} else if next >= 0x01 && next <= 0x7f { // AsciiChar
-			str.Len++
+			strLen++
 		} else {
 			res = parseResultFailed
 			break
 		}
 	}
 
-	str.Cap = str.Len
-	return *(*[]byte)(unsafe.Pointer(&str)), res
+	return p.r.Bytes(strOffset, strLen), res
 }
 
 // parseNameString parses a NameString from the AML bytestream and returns back
@@ -857,7 +1005,7 @@ func (p *Parser) parseString() ([]byte, parseResult) {
 func (p *Parser) parseNameString() ([]byte, parseResult) {
 	var (
 		res         = parseResultOk
-		str         = reflect.SliceHeader{Data: p.r.DataPtr()}
+		strOffset   = p.r.Offset()
 		next        byte
 		err         error
 		startOffset = p.r.Offset()
@@ -919,9 +1067,7 @@ func (p *Parser) parseNameString() ([]byte, parseResult) {
 		p.r.SetOffset(endOffset)
 	}
 
-	str.Len = int(p.r.Offset() - startOffset)
-	str.Cap = str.Len
-	return *(*[]byte)(unsafe.Pointer(&str)), res
+	return p.r.Bytes(strOffset, p.r.Offset()-startOffset), res
 }
 
 // peekNextOpcode returns the next opcode in the stream without advancing the
@@ -995,7 +1141,7 @@ func (p *Parser) popPkgEnd() {
 	}
 
 	// If the stack is not empty restore the last pushed pkgEnd
-	if len(p.pkgEndStack) != 0 && len(p.pkgEndStack) != 0 {
+	if len(p.pkgEndStack) != 0 {
 		_ = p.r.SetPkgEnd(p.pkgEndStack[len(p.pkgEndStack)-1])
 	}
 }
@@ -1246,7 +1392,11 @@ func (p *Parser) relocateNamedObjects(objIndex uint32) parseResult {
 // are flagged as deferred (e.g. Buffers and BankFields).
 func (p *Parser) parseDeferredBlocks(objIndex uint32) parseResult {
 	obj := p.objTree.ObjectAt(objIndex)
-	if pOpcodeTable[obj.infoIndex].flags&pOpFlagDeferParsing != 0 && obj.tableHandle == p.tableHandle {
+
+	// Deferred objects have their pkgEnd set by the first parser pass.
+	// Objects that were parsed strictly (e.g. a Buffer passed as an arg to
+	// Match) have already been fully processed.
+	if pOpcodeTable[obj.infoIndex].flags&pOpFlagDeferParsing != 0 && obj.tableHandle == p.tableHandle && obj.pkgEnd != 0 {
 		p.mode = parseModeAllBlocks
 
 		// Set stream offset to the first arg
@@ -1256,8 +1406,13 @@ func (p *Parser) parseDeferredBlocks(objIndex uint32) parseResult {
 			_, _ = p.r.ReadByte()
 		}
 
+		scopeDepth := len(p.scopeStack)
 		if p.parseObjectArgs(obj) != parseResultOk {
-			return parseResultFailed
+			if !p.recoveryMode {
+				return parseResultFailed
+			}
+
+			p.recoverDeferredBlock(obj, scopeDepth)
 		}
 
 		// As we are using a different parse flow than the one used for
@@ -1272,11 +1427,15 @@ func (p *Parser) parseDeferredBlocks(objIndex uint32) parseResult {
 		return parseResultOk
 	}
 
-	// Recursively process children
-	for argIndex := obj.firstArgIndex; argIndex != InvalidIndex; argIndex = p.objTree.ObjectAt(argIndex).nextSiblingIndex {
+	// Recursively process children. As the parser may discard malformed
+	// deferred blocks when operating in recovery mode, the index of the next
+	// sibling must be looked up before processing each child.
+	for argIndex := obj.firstArgIndex; argIndex != InvalidIndex; {
+		nextArgIndex := p.objTree.ObjectAt(argIndex).nextSiblingIndex
 		if p.parseDeferredBlocks(argIndex) != parseResultOk {
 			return parseResultFailed
 		}
+		argIndex = nextArgIndex
 	}
 
 	return parseResultOk
@@ -1354,8 +1513,9 @@ func (p *Parser) resolveMethodCalls(objIndex uint32) parseResult {
 		obj                 = p.objTree.ObjectAt(objIndex)
 		ok                  bool
 		argObj, resolvedObj *Object
-		argCount            uint64
+		argCount            uint8
 		targetIndex         uint32
+		pathExpr            []byte
 	)
 
 	// The arg list must be visited in reverse order to handle nesting:
@@ -1376,37 +1536,44 @@ func (p *Parser) resolveMethodCalls(objIndex uint32) parseResult {
 		}
 
 		// Resolve namepath
-		targetIndex = p.objTree.Find(argObj.parentIndex, argObj.value.([]byte))
+		pathExpr = argObj.value.([]byte)
+		targetIndex = p.objTree.Find(argObj.parentIndex, pathExpr)
 		switch targetIndex {
 		case InvalidIndex:
 			// Treat this as a namepath to be resolved at run-time
 			argObj.opcode = pOpIntNamePath
 			argObj.infoIndex = pOpcodeTableIndex(argObj.opcode, true)
+			p.deferForwardRef(argObj, pathExpr)
 		default:
 			resolvedObj = p.objTree.ObjectAt(targetIndex)
+			if resolvedObj.opcode == pOpExternal {
+				p.deferForwardRef(argObj, pathExpr)
+			}
 
 			switch resolvedObj.opcode {
-			case pOpMethod:
-				// Mutate into a method call with value pointing at the resolved method index
-				argObj.opcode = pOpIntMethodCall
-				argObj.infoIndex = pOpcodeTableIndex(argObj.opcode, true)
-				argObj.value = resolvedObj.index
-
+			case pOpMethod, pOpExternal:
 				// Consume the required number of args which are encoded as
-				// bits [0:2] of the method obj 2nd arg
-				methodFlagsObj := p.objTree.ArgAt(resolvedObj, 1)
-				if methodFlagsObj == nil {
-					kfmt.Fprintf(p.errWriter, "[table: %s, offset: 0x%x] target method \"%s\" is missing a flag object\n", p.tableName, argObj.amlOffset, resolvedObj.name[:])
-					return parseResultFailed
-				}
+				// bits [0:2] of the method obj 2nd arg (or the External
+				// obj 3rd arg)
+				if argCount, ok = p.objTree.methodArgCount(resolvedObj); !ok {
+					if resolvedObj.opcode == pOpExternal {
+						// External declaration for a non-method object
+						argObj.opcode = pOpIntResolvedNamePath
+						argObj.infoIndex = pOpcodeTableIndex(argObj.opcode, true)
+						argObj.value = resolvedObj.index
+						continue
+					}
 
-				argCount, ok = methodFlagsObj.value.(uint64)
-				if !ok {
 					kfmt.Fprintf(p.errWriter, "[table: %s, offset: 0x%x] target method \"%s\" contains a malformed flag object\n", p.tableName, argObj.amlOffset, resolvedObj.name[:])
 					return parseResultFailed
 				}
 
-				if p.attachSiblingsAsArgs(obj, argObj, uint8(argCount&0x7), true) != parseResultOk {
+				// Mutate into a method call with value pointing at the resolved method index
+				argObj.opcode = pOpIntMethodCall
+				argObj.infoIndex = pOpcodeTableIndex(argObj.opcode, true)
+				argObj.value = resolvedObj.index
+
+				if p.attachSiblingsAsArgs(obj, argObj, argCount, true) != parseResultOk {
 					return parseResultFailed
 				}
 			default:
@@ -1435,6 +1602,7 @@ func (p *Parser) attachSiblingsAsArgs(parentObj, targetObj *Object, numArgs uint
 
 		if siblingIndex == InvalidIndex {
 			kfmt.Fprintf(p.errWriter, "[table: %s, offset: 0x%x] unexpected arg count for opcode: %s (0x%x)\n", p.tableName, targetObj.amlOffset, pOpcodeName(targetObj.opcode), targetObj.opcode)
+			p.recordParseError(targetObj, targetObj.amlOffset, "unexpected arg count for opcode %s", pOpcodeName(targetObj.opcode))
 			return parseResultFailed
 		}
 
@@ -0,0 +1,194 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+// parseErrorContextLen is the number of bytes before and after the failure
+// offset that are captured by a ParseError.
+const parseErrorContextLen = 16
+
+// ParseError describes a failure to parse the contents of an AML table. The
+// error returned by ParseAML and ParseAMLStream wraps a *ParseError which can
+// be retrieved via errors.As or by calling the parser's LastError method.
+type ParseError struct {
+	// The table signature and the name passed to ParseAML.
+	Signature string
+	TableName string
+
+	// The offset (relative to the table start) where the failure occurred.
+	Offset uint32
+
+	// A description of the failure.
+	Message string
+
+	// The opcodes of the objects that were being parsed when the failure
+	// occurred starting with the outermost enclosing scope. Named objects
+	// are listed together with their name, e.g. "Device(PCI0)".
+	OpcodeChain []string
+
+	// A copy of the table contents surrounding Offset. ContextOffset is
+	// the table offset of the first byte in Context.
+	Context       []byte
+	ContextOffset uint32
+}
+
+// Error implements the error interface.
+func (e *ParseError) Error() string {
+	var buf bytes.Buffer
+	kfmt.Fprintf(&buf, "[table: %s, offset: 0x%x] %s", e.TableName, e.Offset, e.Message)
+
+	if len(e.OpcodeChain) != 0 {
+		buf.WriteString(" (in ")
+		for i, op := range e.OpcodeChain {
+			if i != 0 {
+				buf.WriteString(" > ")
+			}
+			buf.WriteString(op)
+		}
+		buf.WriteByte(')')
+	}
+
+	return buf.String()
+}
+
+// Hexdump writes the table contents surrounding the failure offset to w
+// using 16 bytes per line. The byte at the failure offset is enclosed in
+// square brackets.
+func (e *ParseError) Hexdump(w io.Writer) {
+	for lineStart := 0; lineStart < len(e.Context); lineStart += 16 {
+		kfmt.Fprintf(w, "%8x:", e.ContextOffset+uint32(lineStart))
+		for i := lineStart; i < lineStart+16 && i < len(e.Context); i++ {
+			if e.ContextOffset+uint32(i) == e.Offset {
+				kfmt.Fprintf(w, " [%2x]", e.Context[i])
+			} else {
+				kfmt.Fprintf(w, " %2x", e.Context[i])
+			}
+		}
+		_, _ = io.WriteString(w, "\n")
+	}
+}
+
+// LastError returns the error that caused the last ParseAML or ParseAMLStream
+// call to fail or nil if the call succeeded.
+func (p *Parser) LastError() *ParseError {
+	return p.lastErr
+}
+
+// recordParseError populates a ParseError for a failure at the specified
+// stream offset while parsing obj. If an error has already been recorded for
+// the current failure, recordParseError is a no-op so that the innermost
+// failure site is reported.
+func (p *Parser) recordParseError(obj *Object, offset uint32, format string, args ...interface{}) {
+	if p.lastErr != nil {
+		return
+	}
+
+	var msg bytes.Buffer
+	kfmt.Fprintf(&msg, format, args...)
+
+	p.lastErr = &ParseError{
+		Signature:   p.tableSignature,
+		TableName:   p.tableName,
+		Offset:      offset,
+		Message:     msg.String(),
+		OpcodeChain: p.opcodeChain(obj),
+	}
+
+	p.lastErr.ContextOffset = 0
+	if offset > parseErrorContextLen {
+		p.lastErr.ContextOffset = offset - parseErrorContextLen
+	}
+
+	contextEnd := offset + parseErrorContextLen
+	if contextEnd > p.streamEnd {
+		contextEnd = p.streamEnd
+	}
+
+	if contextEnd > p.lastErr.ContextOffset {
+		// Copy the contents so the error does not reference the table
+		// memory.
+		p.lastErr.Context = append([]byte(nil), p.r.Bytes(p.lastErr.ContextOffset, contextEnd-p.lastErr.ContextOffset)...)
+	}
+}
+
+// opcodeChain returns a description of the objects that enclose obj. If obj is
+// nil, the chain for the objects currently being parsed is returned instead.
+func (p *Parser) opcodeChain(obj *Object) []string {
+	var (
+		chain []string
+		base  *Object
+		inner []uint32
+	)
+
+	switch {
+	case len(p.parseStack) != 0:
+		base = p.objTree.ObjectAt(p.parseStack[0])
+		inner = p.parseStack[1:]
+	case obj != nil:
+		base = obj
+	case len(p.scopeStack) != 0:
+		base = p.scopeCurrent()
+	default:
+		return nil
+	}
+
+	// Collect the enclosing objects in reverse order
+	for cur := base; cur != nil; cur = p.objTree.ObjectAt(cur.parentIndex) {
+		if desc := p.describeObject(cur); desc != "" {
+			chain = append(chain, desc)
+		}
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	for _, index := range inner {
+		chain = append(chain, p.describeObject(p.objTree.ObjectAt(index)))
+	}
+
+	if obj != nil && base != obj && (len(inner) == 0 || inner[len(inner)-1] != obj.index) {
+		chain = append(chain, p.describeObject(obj))
+	}
+
+	return chain
+}
+
+// describeObject returns the opcode name for obj followed by its name if obj
+// is a named object. The root scope and unnamed scope blocks are omitted from
+// opcode chains so an empty string is returned for them.
+func (p *Parser) describeObject(obj *Object) string {
+	name := nameOf(obj)
+
+	// Named objects are only assigned a name after the first parser pass
+	// so fall back to the name path stored in their first arg.
+	if len(name) == 0 && (pOpcodeTable[obj.infoIndex].flags&pOpFlagNamed != 0 || obj.opcode == pOpScope) {
+		if path, ok := p.objTree.ArgAt(obj, 0).valueBytes(); ok && len(path) >= amlNameLen {
+			name = path[len(path)-amlNameLen:]
+		}
+	}
+
+	switch {
+	case obj.opcode == pOpIntScopeBlock && (len(name) == 0 || name[0] == '\\'):
+		return ""
+	case obj.opcode == pOpIntScopeBlock:
+		return "Scope(" + string(name) + ")"
+	case len(name) != 0:
+		return pOpcodeName(obj.opcode) + "(" + string(name) + ")"
+	default:
+		return pOpcodeName(obj.opcode)
+	}
+}
+
+// valueBytes returns the value of obj if it is a byte slice.
+func (obj *Object) valueBytes() ([]byte, bool) {
+	if obj == nil {
+		return nil, false
+	}
+
+	val, ok := obj.value.([]byte)
+	return val, ok
+}
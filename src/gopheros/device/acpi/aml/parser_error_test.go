@@ -0,0 +1,148 @@
+package aml
+
+import (
+	"bytes"
+	"errors"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/table"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestParseError(t *testing.T) {
+	hdrLen := uint32(unsafe.Sizeof(table.SDTHeader{}))
+
+	specs := []struct {
+		payload  []byte
+		expOff   uint32
+		expChain []string
+		expMsg   string
+	}{
+		// Invalid opcode inside a Device
+		{
+			amltest.Concat(
+				amltest.Pkg([]byte{0x10}, amltest.Concat(
+					[]byte{'_', 'S', 'B', '_'},
+					amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+						[]byte{'D', 'E', 'V', '0'},
+						[]byte{0x08, 'V', 'A', 'L', '0', 0x01},
+						[]byte{0x02},
+					)),
+				)),
+			),
+			hdrLen + 2 + 4 + 3 + 4 + 6,
+			[]string{"Scope(_SB_)", "Device(DEV0)"},
+			"encountered invalid opcode or name string",
+		},
+		// Invalid opcode inside a deferred If block
+		{
+			amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'M', 'T', 'H', '0', 0x00},
+				amltest.Pkg([]byte{0xa0}, []byte{0x93, 0x68, 0x02}),
+			)),
+			hdrLen + 2 + 5 + 2 + 2,
+			[]string{"Method(MTH0)", "If", "LEqual"},
+			"encountered invalid opcode or name string",
+		},
+	}
+
+	for specIndex, spec := range specs {
+		tree := NewObjectTree()
+		tree.CreateDefaultScopes(0)
+
+		var errOut bytes.Buffer
+		p := NewParser(&errOut, tree)
+		err := p.ParseAML(0, "MYTABLE", mockByteDataResolver(spec.payload).LookupTable("DSDT"))
+		if !errParsingAML.Is(err) {
+			t.Errorf("[spec %d] expected to get errParsingAML; got %v", specIndex, err)
+			continue
+		}
+
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) || parseErr != p.LastError() {
+			t.Errorf("[spec %d] expected returned error to wrap the parser's last error", specIndex)
+			continue
+		}
+
+		if parseErr.Signature != "DSDT" || parseErr.TableName != "MYTABLE" {
+			t.Errorf("[spec %d] expected error to refer to table DSDT (MYTABLE); got %s (%s)", specIndex, parseErr.Signature, parseErr.TableName)
+		}
+
+		if parseErr.Offset != spec.expOff {
+			t.Errorf("[spec %d] expected error offset to be 0x%x; got 0x%x", specIndex, spec.expOff, parseErr.Offset)
+		}
+
+		if parseErr.Message != spec.expMsg {
+			t.Errorf("[spec %d] expected error message to be %q; got %q", specIndex, spec.expMsg, parseErr.Message)
+		}
+
+		if !reflect.DeepEqual(parseErr.OpcodeChain, spec.expChain) {
+			t.Errorf("[spec %d] expected opcode chain to be %v; got %v", specIndex, spec.expChain, parseErr.OpcodeChain)
+		}
+
+		// The context window should include the byte at the failure offset
+		ctxIndex := parseErr.Offset - parseErr.ContextOffset
+		if ctxIndex >= uint32(len(parseErr.Context)) || parseErr.Context[ctxIndex] != 0x02 {
+			t.Errorf("[spec %d] expected context window to contain the malformed byte; got %v (offset 0x%x)", specIndex, parseErr.Context, parseErr.ContextOffset)
+		}
+
+		// The error details and the hexdump should be written to the error writer
+		if got := errOut.String(); !strings.Contains(got, parseErr.Error()) || !strings.Contains(got, "[02]") {
+			t.Errorf("[spec %d] expected error writer output to contain the error details; got:\n%s", specIndex, got)
+		}
+	}
+}
+
+func TestParseErrorFormatting(t *testing.T) {
+	parseErr := &ParseError{
+		TableName:     "DSDT",
+		Offset:        0x12,
+		Message:       "bad opcode",
+		OpcodeChain:   []string{"Scope(_SB_)", "Method(FOO)"},
+		Context:       []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19},
+		ContextOffset: 0x10,
+	}
+
+	if exp, got := "[table: DSDT, offset: 0x12] bad opcode (in Scope(_SB_) > Method(FOO))", parseErr.Error(); got != exp {
+		t.Errorf("expected Error() to return:\n%q\ngot:\n%q", exp, got)
+	}
+
+	var buf bytes.Buffer
+	parseErr.Hexdump(&buf)
+
+	exp := "00000010: 00 01 [02] 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f\n00000020: 10 11 12 13\n"
+	if got := buf.String(); got != exp {
+		t.Errorf("expected Hexdump output to be:\n%q\ngot:\n%q", exp, got)
+	}
+}
+
+func TestParseErrorRecoveryMode(t *testing.T) {
+	payload := amltest.Concat(
+		amltest.Pkg([]byte{0x5b, 0x82}, []byte{'D', 'E', 'V', '0', 0x02}),
+		[]byte{0x08, 'V', 'A', 'L', '0', 0x01},
+	)
+
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	p := NewParser(ioutil.Discard, tree)
+	p.SetRecoveryMode(true)
+	if err := p.ParseAML(0, "DSDT", mockByteDataResolver(payload).LookupTable("DSDT")); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.LastError() != nil {
+		t.Fatalf("expected LastError to return nil after a successful parse; got %v", p.LastError())
+	}
+
+	recovered := p.RecoveredErrors()
+	if len(recovered) != 1 || recovered[0].Err == nil {
+		t.Fatalf("expected one recovered error with failure details; got %+v", recovered)
+	}
+
+	if exp := []string{"Device(DEV0)"}; !reflect.DeepEqual(recovered[0].Err.OpcodeChain, exp) {
+		t.Fatalf("expected opcode chain to be %v; got %v", exp, recovered[0].Err.OpcodeChain)
+	}
+}
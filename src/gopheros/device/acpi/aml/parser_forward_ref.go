@@ -0,0 +1,120 @@
+package aml
+
+import "gopheros/kernel/kfmt"
+
+// externalTypeMethod is the ObjectType value used by External declarations
+// that refer to control methods.
+const externalTypeMethod = uint64(8)
+
+// forwardRef describes a name reference that the parser could not resolve to
+// an object definition. This typically happens when the referenced object is
+// defined in a table that has not been parsed yet or when the only matching
+// object is an External declaration.
+type forwardRef struct {
+	// The index of the pOpIntNamePath, pOpIntResolvedNamePath or
+	// pOpIntMethodCall object that contains the reference.
+	index uint32
+
+	// The raw AML path of the referenced object.
+	path []byte
+}
+
+// deferForwardRef records a reference to path from obj so that it can be
+// revisited by resolveForwardRefs once more tables have been parsed.
+func (p *Parser) deferForwardRef(obj *Object, path []byte) {
+	p.objTree.forwardRefs = append(p.objTree.forwardRefs, forwardRef{index: obj.index, path: path})
+}
+
+// resolveForwardRefs revisits the name references that could not be resolved
+// while parsing this and any previously parsed table and attempts to resolve
+// them using the current namespace contents. References that are still
+// unresolved are retained and get revisited after the next table is parsed.
+// As any remaining pOpIntNamePath objects are resolved by the VM at run-time,
+// a failure to resolve a reference is not treated as a parse error.
+func (p *Parser) resolveForwardRefs() {
+	var (
+		tree    = p.objTree
+		ns      = tree.Namespace()
+		pending = tree.forwardRefs[:0]
+	)
+
+	for _, ref := range tree.forwardRefs {
+		obj := tree.ObjectAt(ref.index)
+		if !p.isForwardRef(obj) {
+			continue
+		}
+
+		node := ns.lookup(ns.nodeAt(tree.ClosestNamedAncestor(obj)), ref.path)
+		if node == nil || !p.resolveForwardRef(obj, node.Object(), ref.path) {
+			pending = append(pending, ref)
+		}
+	}
+
+	tree.forwardRefs = pending
+}
+
+// isForwardRef returns true if obj is an unresolved name reference or a
+// reference to an External declaration.
+func (p *Parser) isForwardRef(obj *Object) bool {
+	switch {
+	case obj == nil:
+		return false
+	case obj.opcode == pOpIntNamePath:
+		return true
+	case obj.opcode == pOpIntResolvedNamePath, obj.opcode == pOpIntMethodCall:
+		target := p.objTree.ObjectAt(obj.value.(uint32))
+		return target != nil && target.opcode == pOpExternal
+	default:
+		return false
+	}
+}
+
+// resolveForwardRef updates obj so it refers to target. Unresolved name paths
+// that refer to a method are converted into method calls and the required
+// number of args is extracted from obj's sibling list. As this is only
+// possible when the call appears as a statement inside a scope block,
+// resolveForwardRef returns false if the call args cannot be located.
+func (p *Parser) resolveForwardRef(obj, target *Object, path []byte) bool {
+	argCount, isMethod := p.objTree.methodArgCount(target)
+
+	switch {
+	case !isMethod:
+		if obj.opcode == pOpIntMethodCall && p.objTree.NumArgs(obj) != 0 {
+			kfmt.Fprintf(p.errWriter, "[table: %s] call to \"%s\" resolved to non-method object of type %s\n", p.tableName, path, pOpcodeName(target.opcode))
+			return false
+		}
+
+		obj.opcode = pOpIntResolvedNamePath
+	case obj.opcode == pOpIntMethodCall:
+		if numArgs := p.objTree.NumArgs(obj); numArgs != uint32(argCount) {
+			kfmt.Fprintf(p.errWriter, "[table: %s] call to \"%s\" passes %d args; method expects %d\n", p.tableName, path, numArgs, argCount)
+		}
+	case argCount != 0:
+		parent := p.objTree.ObjectAt(obj.parentIndex)
+		if parent == nil || parent.opcode != pOpIntScopeBlock || p.numSiblingsAfter(obj) < uint32(argCount) {
+			kfmt.Fprintf(p.errWriter, "[table: %s] unable to locate args for forward call to \"%s\"\n", p.tableName, path)
+			return false
+		}
+
+		if p.attachSiblingsAsArgs(parent, obj, argCount, false) != parseResultOk {
+			return false
+		}
+		obj.opcode = pOpIntMethodCall
+	default:
+		obj.opcode = pOpIntMethodCall
+	}
+
+	obj.infoIndex = pOpcodeTableIndex(obj.opcode, true)
+	obj.value = target.index
+	return true
+}
+
+// numSiblingsAfter returns the number of siblings that follow obj.
+func (p *Parser) numSiblingsAfter(obj *Object) uint32 {
+	var count uint32
+	for siblingIndex := obj.nextSiblingIndex; siblingIndex != InvalidIndex; siblingIndex = p.objTree.ObjectAt(siblingIndex).nextSiblingIndex {
+		count++
+	}
+
+	return count
+}
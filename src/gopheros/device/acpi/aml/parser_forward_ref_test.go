@@ -0,0 +1,123 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/table"
+	"io/ioutil"
+	"testing"
+	"unsafe"
+)
+
+func TestParserForwardRefs(t *testing.T) {
+	var (
+		sbPath = func(name string) []byte { return amltest.Concat([]byte{0x5c, 0x2e, '_', 'S', 'B', '_'}, []byte(name)) }
+
+		dsdtPayload = amltest.Concat(
+			// External(\_SB.GETV, MethodObj, 1)
+			[]byte{0x15}, sbPath("GETV"), []byte{0x08, 0x01},
+			// Method(TEST, 0) {
+			//   \_SB.SETV(3, 4)
+			//   Return(Add(\_SB.GETV(5), \_SB.VAL0))
+			// }
+			amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'T', 'E', 'S', 'T', 0x00},
+				sbPath("SETV"), []byte{0x0a, 0x03, 0x0a, 0x04},
+				[]byte{0xa4, 0x72}, sbPath("GETV"), []byte{0x0a, 0x05}, sbPath("VAL0"), []byte{0x00},
+			)),
+			// Method(TST2, 0) { Store(\_SB.SETV, Local0) }
+			amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'T', 'S', 'T', '2', 0x00},
+				[]byte{0x70}, sbPath("SETV"), []byte{0x60},
+			)),
+		)
+
+		ssdtPayload = amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'_', 'S', 'B', '_'},
+			// Name(VAL0, 0)
+			[]byte{0x08, 'V', 'A', 'L', '0', 0x00},
+			// Method(SETV, 2) { Store(Add(Arg0, Arg1), VAL0) }
+			amltest.Pkg([]byte{0x14}, []byte{'S', 'E', 'T', 'V', 0x02, 0x70, 0x72, 0x68, 0x69, 0x00, 'V', 'A', 'L', '0'}),
+			// Method(GETV, 1) { Return(Multiply(Arg0, 2)) }
+			amltest.Pkg([]byte{0x14}, []byte{'G', 'E', 'T', 'V', 0x01, 0xa4, 0x77, 0x68, 0x0a, 0x02, 0x00}),
+		))
+	)
+
+	vm := vmForPayload(t, dsdtPayload)
+	tree := vm.tree
+
+	// The calls to SETV/GETV and the reference to VAL0 cannot be resolved
+	// until the SSDT is parsed
+	if exp, got := 4, len(tree.forwardRefs); got != exp {
+		t.Fatalf("expected %d unresolved references after parsing the DSDT; got %d", exp, got)
+	}
+
+	if _, err := vm.Evaluate(`TEST`); err == nil {
+		t.Fatal("expected TEST evaluation to fail before the SSDT is parsed")
+	}
+
+	ssdt := ssdtImage("SSDT", "MYOEM", ssdtPayload)
+	if err := NewParser(ioutil.Discard, tree).ParseAML(1, "SSDT", (*table.SDTHeader)(unsafe.Pointer(&ssdt[0]))); err != nil {
+		t.Fatal(err)
+	}
+
+	// The reference to SETV inside Store cannot be converted into a
+	// method call as its args are not available.
+	if exp, got := 1, len(tree.forwardRefs); got != exp {
+		t.Fatalf("expected %d unresolved references after parsing the SSDT; got %d", exp, got)
+	}
+
+	got, err := vm.Evaluate(`TEST`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := uint64(17); got != exp {
+		t.Fatalf("expected TEST to return %d; got %#v", exp, got)
+	}
+
+	// Calls resolved via External declarations should now point to the
+	// actual method definitions
+	for _, obj := range tree.objPool {
+		if obj.opcode != pOpIntMethodCall {
+			continue
+		}
+
+		if target := tree.ObjectAt(obj.value.(uint32)); target.opcode != pOpMethod {
+			t.Errorf("expected method call at offset 0x%x to refer to a Method; got %s", obj.amlOffset, target.Kind())
+		}
+	}
+}
+
+func TestMethodArgCount(t *testing.T) {
+	tree := NewObjectTree()
+
+	newObj := func(opcode uint16, args ...uint64) *Object {
+		obj := tree.newObject(opcode, 0)
+		for _, arg := range args {
+			argObj := tree.newObject(pOpBytePrefix, 0)
+			argObj.value = arg
+			tree.append(obj, argObj)
+		}
+		return obj
+	}
+
+	specs := []struct {
+		obj         *Object
+		expArgCount uint8
+		expOk       bool
+	}{
+		{nil, 0, false},
+		{newObj(pOpMethod, 0, 0x0b), 3, true},
+		{newObj(pOpMethod, 0), 0, false},
+		{newObj(pOpExternal, 0, externalTypeMethod, 2), 2, true},
+		{newObj(pOpExternal, 0, 1, 0), 0, false},
+		{newObj(pOpName, 0, 2), 0, false},
+	}
+
+	for specIndex, spec := range specs {
+		argCount, ok := tree.methodArgCount(spec.obj)
+		if argCount != spec.expArgCount || ok != spec.expOk {
+			t.Errorf("[spec %d] expected to get (%d, %t); got (%d, %t)", specIndex, spec.expArgCount, spec.expOk, argCount, ok)
+		}
+	}
+}
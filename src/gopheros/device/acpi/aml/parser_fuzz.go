@@ -14,6 +14,10 @@ import (
 	"unsafe"
 )
 
+// The entry points in this file can be selected using the -func flag of
+// go-fuzz-build. Fuzz exercises the full table parsing flow while the
+// remaining entry points target individual parser stages.
+
 // Fuzz is the driver for go-fuzz. The function must return 1 if the fuzzer
 // should increase priority of the given input during subsequent fuzzing (for
 // example, the input is lexically correct and was parsed successfully); -1 if
@@ -38,3 +42,88 @@ func Fuzz(data []byte) int {
 
 	return 1
 }
+
+// FuzzFragment parses data as a raw AML stream that is not prefixed by a table
+// header.
+func FuzzFragment(data []byte) int {
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	if err := NewParser(ioutil.Discard, tree).ParseFragment(uint8(1), data); err != nil {
+		return 0
+	}
+
+	return 1
+}
+
+// FuzzPkgLength decodes a PkgLength from data and checks that the decoder
+// never reads past the end of the stream and restores the stream offset when
+// decoding fails.
+func FuzzPkgLength(data []byte) int {
+	p := newFuzzParser(data)
+
+	_, res := p.parsePkgLength()
+	if res != parseResultOk && p.r.Offset() != 0 {
+		panic("parsePkgLength did not restore the stream offset")
+	}
+
+	return checkFuzzedRead(p, data, res, 4)
+}
+
+// FuzzNameString decodes a NameString from data and checks that the decoder
+// never reads past the end of the stream.
+func FuzzNameString(data []byte) int {
+	p := newFuzzParser(data)
+
+	name, res := p.parseNameString()
+	if res == parseResultOk && uint32(len(name)) > p.r.Offset() {
+		panic("parseNameString returned a name that is longer than the consumed input")
+	}
+
+	return checkFuzzedRead(p, data, res, uint32(len(data)))
+}
+
+// FuzzOpcode decodes and dispatches a single object from data.
+func FuzzOpcode(data []byte) int {
+	p := newFuzzParser(data)
+	p.scopeEnter(0)
+
+	res := p.parseNextObject()
+	return checkFuzzedRead(p, data, res, uint32(len(data)))
+}
+
+// newFuzzParser returns a Parser whose reader is initialized with data.
+func newFuzzParser(data []byte) *Parser {
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+
+	p := NewParser(ioutil.Discard, tree)
+	p.resetState(1, fragmentTableName)
+
+	var dataAddr uintptr
+	if len(data) != 0 {
+		dataAddr = uintptr(unsafe.Pointer(&data[0]))
+	}
+	p.r.Init(dataAddr, uint32(len(data)), 0)
+	p.streamEnd = uint32(len(data))
+	_ = p.pushPkgEnd(p.streamEnd)
+	return p
+}
+
+// checkFuzzedRead panics if the parser's read offset moved past the end of
+// data or, for successful reads, if more than maxLen bytes were consumed. It
+// returns the go-fuzz priority for the input.
+func checkFuzzedRead(p *Parser, data []byte, res parseResult, maxLen uint32) int {
+	if p.r.Offset() > uint32(len(data)) {
+		panic("parser read past the end of the stream")
+	}
+
+	if res != parseResultOk {
+		return 0
+	}
+
+	if p.r.Offset() > maxLen {
+		panic("parser consumed more bytes than expected")
+	}
+
+	return 1
+}
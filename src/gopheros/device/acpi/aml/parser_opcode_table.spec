@@ -0,0 +1,149 @@
+# AML opcode table specification.
+#
+# Each line describes an opcode table entry using the format:
+#
+#   <const name> <encoding> <name> <flags> <args>
+#
+# where:
+#   - <encoding> is the opcode value. Extended opcodes (prefixed by 0x5b in the
+#     AML stream) use the "ext:" prefix while opcodes that are internal to the
+#     parser use the "int:" prefix.
+#   - <name> is the opcode name returned by Object.Kind. Names containing spaces
+#     must be quoted.
+#   - <flags> is a "|"-separated list of pOpFlag values (without the pOpFlag
+#     prefix) or "-" if the opcode has no flags.
+#   - <args> is a ","-separated list of pArgType values (without the pArgType
+#     prefix) or "-" if the opcode has no args.
+#
+# Entries are emitted to the opcode table in the order they appear in this
+# file. Internal opcodes must be listed last in ascending encoding order.
+#
+# After editing this file, regenerate parser_opcode_table_gen.go by running
+# "go generate gopheros/device/acpi/aml".
+
+# Regular opcodes
+pOpZero                     0x00      Zero                     Constant                               -
+pOpOne                      0x01      One                      Constant                               -
+pOpAlias                    0x06      Alias                    Named                                  NameString,NameString
+pOpName                     0x08      Name                     Named                                  NameString,DataRefObj
+pOpBytePrefix               0x0a      BytePrefix               Constant                               ByteData
+pOpWordPrefix               0x0b      WordPrefix               Constant                               WordData
+pOpDwordPrefix              0x0c      DwordPrefix              Constant                               DwordData
+pOpStringPrefix             0x0d      StringPrefix             Constant                               String
+pOpQwordPrefix              0x0e      QwordPrefix              Constant                               QwordData
+pOpScope                    0x10      Scope                    -                                      PkgLen,NameString,TermList
+pOpBuffer                   0x11      Buffer                   DeferParsing|Create                    PkgLen,TermArg,ByteList
+pOpPackage                  0x12      Package                  Create                                 PkgLen,ByteData,TermList
+pOpVarPackage               0x13      VarPackage               Create                                 PkgLen,ByteData,TermList
+pOpMethod                   0x14      Method                   Named|Scoped                           PkgLen,NameString,ByteData,TermList
+pOpExternal                 0x15      External                 Named                                  NameString,ByteData,ByteData
+pOpLocal0                   0x60      Local0                   Executable                             -
+pOpLocal1                   0x61      Local1                   Executable                             -
+pOpLocal2                   0x62      Local2                   Executable                             -
+pOpLocal3                   0x63      Local3                   Executable                             -
+pOpLocal4                   0x64      Local4                   Executable                             -
+pOpLocal5                   0x65      Local5                   Executable                             -
+pOpLocal6                   0x66      Local6                   Executable                             -
+pOpLocal7                   0x67      Local7                   Executable                             -
+pOpArg0                     0x68      Arg0                     Executable                             -
+pOpArg1                     0x69      Arg1                     Executable                             -
+pOpArg2                     0x6a      Arg2                     Executable                             -
+pOpArg3                     0x6b      Arg3                     Executable                             -
+pOpArg4                     0x6c      Arg4                     Executable                             -
+pOpArg5                     0x6d      Arg5                     Executable                             -
+pOpArg6                     0x6e      Arg6                     Executable                             -
+pOpStore                    0x70      Store                    Executable                             TermArg,SuperName
+pOpRefOf                    0x71      RefOf                    Reference|Executable                   SuperName
+pOpAdd                      0x72      Add                      Executable                             TermArg,TermArg,Target
+pOpConcat                   0x73      Concat                   Executable                             TermArg,TermArg,Target
+pOpSubtract                 0x74      Subtract                 Executable                             TermArg,TermArg,Target
+pOpIncrement                0x75      Increment                Executable                             SuperName
+pOpDecrement                0x76      Decrement                Executable                             SuperName
+pOpMultiply                 0x77      Multiply                 Executable                             TermArg,TermArg,Target
+pOpDivide                   0x78      Divide                   Executable                             TermArg,TermArg,Target,Target
+pOpShiftLeft                0x79      ShiftLeft                Executable                             TermArg,TermArg,Target
+pOpShiftRight               0x7a      ShiftRight               Executable                             TermArg,TermArg,Target
+pOpAnd                      0x7b      And                      Executable                             TermArg,TermArg,Target
+pOpNand                     0x7c      Nand                     Executable                             TermArg,TermArg,Target
+pOpOr                       0x7d      Or                       Executable                             TermArg,TermArg,Target
+pOpNor                      0x7e      Nor                      Executable                             TermArg,TermArg,Target
+pOpXor                      0x7f      Xor                      Executable                             TermArg,TermArg,Target
+pOpNot                      0x80      Not                      Executable                             TermArg,Target
+pOpFindSetLeftBit           0x81      FindSetLeftBit           Executable                             TermArg,Target
+pOpFindSetRightBit          0x82      FindSetRightBit          Executable                             TermArg,Target
+pOpDerefOf                  0x83      DerefOf                  Executable                             TermArg
+pOpConcatRes                0x84      ConcatRes                Executable                             TermArg,TermArg,Target
+pOpMod                      0x85      Mod                      Executable                             TermArg,TermArg,Target
+pOpNotify                   0x86      Notify                   Executable                             SuperName,TermArg
+pOpSizeOf                   0x87      SizeOf                   Executable                             SuperName
+pOpIndex                    0x88      Index                    Executable                             TermArg,TermArg,Target
+pOpMatch                    0x89      Match                    Executable                             TermArg,ByteData,TermArg,ByteData,TermArg,TermArg
+pOpCreateDWordField         0x8a      CreateDWordField         Create|Executable                      TermArg,TermArg,NameString
+pOpCreateWordField          0x8b      CreateWordField          Create|Executable                      TermArg,TermArg,NameString
+pOpCreateByteField          0x8c      CreateByteField          Create|Executable                      TermArg,TermArg,NameString
+pOpCreateBitField           0x8d      CreateBitField           Create|Executable                      TermArg,TermArg,NameString
+pOpObjectType               0x8e      ObjectType               Executable                             SuperName
+pOpCreateQWordField         0x8f      CreateQWordField         Create                                 TermArg,TermArg,NameString
+pOpLand                     0x90      Land                     Executable                             TermArg,TermArg
+pOpLor                      0x91      Lor                      Executable                             TermArg,TermArg
+pOpLnot                     0x92      Lnot                     Executable                             TermArg
+pOpLEqual                   0x93      LEqual                   Executable                             TermArg,TermArg
+pOpLGreater                 0x94      LGreater                 Executable                             TermArg,TermArg
+pOpLLess                    0x95      LLess                    Executable                             TermArg,TermArg
+pOpToBuffer                 0x96      ToBuffer                 Executable                             TermArg,Target
+pOpToDecimalString          0x97      ToDecimalString          Executable                             TermArg,Target
+pOpToHexString              0x98      ToHexString              Executable                             TermArg,Target
+pOpToInteger                0x99      ToInteger                Executable                             TermArg,Target
+pOpToString                 0x9c      ToString                 Executable                             TermArg,Target
+pOpCopyObject               0x9d      CopyObject               Executable                             TermArg,SimpleName
+pOpMid                      0x9e      Mid                      Executable                             TermArg,TermArg,TermArg,Target
+pOpContinue                 0x9f      Continue                 Executable                             -
+pOpIf                       0xa0      If                       DeferParsing|Executable|Scoped         PkgLen,TermArg,TermList
+pOpElse                     0xa1      Else                     Executable|Scoped                      PkgLen,TermList
+pOpWhile                    0xa2      While                    DeferParsing|Executable|Scoped         PkgLen,TermArg,TermList
+pOpNoop                     0xa3      Noop                     Executable                             -
+pOpReturn                   0xa4      Return                   Executable                             TermArg
+pOpBreak                    0xa5      Break                    Executable                             -
+pOpBreakPoint               0xcc      BreakPoint               Executable                             -
+pOpOnes                     0xff      Ones                     Constant                               -
+
+# Extended opcodes
+pOpMutex                    ext:0x01  Mutex                    Named                                  NameString,ByteData
+pOpEvent                    ext:0x02  Event                    Named                                  NameString
+pOpCondRefOf                ext:0x12  CondRefOf                Executable                             SuperName,SuperName
+pOpCreateField              ext:0x13  CreateField              Executable                             TermArg,TermArg,TermArg,NameString
+pOpLoadTable                ext:0x1f  LoadTable                Executable                             TermArg,TermArg,TermArg,TermArg,TermArg,TermArg
+pOpLoad                     ext:0x20  Load                     Executable                             NameString,SuperName
+pOpStall                    ext:0x21  Stall                    Executable                             TermArg
+pOpSleep                    ext:0x22  Sleep                    Executable                             TermArg
+pOpAcquire                  ext:0x23  Acquire                  Executable                             SuperName,WordData
+pOpSignal                   ext:0x24  Signal                   Executable                             TermArg
+pOpWait                     ext:0x25  Wait                     Executable                             SuperName,TermArg
+pOpReset                    ext:0x26  Reset                    Executable                             SuperName
+pOpRelease                  ext:0x27  Release                  Executable                             SuperName
+pOpFromBCD                  ext:0x28  FromBCD                  Executable                             TermArg,Target
+pOpToBCD                    ext:0x29  ToBCD                    Executable                             TermArg,Target
+pOpUnload                   ext:0x2a  Unload                   Executable                             SuperName
+pOpRevision                 ext:0x30  Revision                 Constant|Executable                    -
+pOpDebug                    ext:0x31  Debug                    Executable                             -
+pOpFatal                    ext:0x32  Fatal                    Executable                             ByteData,DwordData,TermArg
+pOpTimer                    ext:0x33  Timer                    Executable                             -
+pOpOpRegion                 ext:0x80  OpRegion                 Named                                  NameString,ByteData,TermArg,TermArg
+pOpField                    ext:0x81  Field                    Create                                 PkgLen,NameString,ByteData,FieldList
+pOpDevice                   ext:0x82  Device                   Named|Scoped                           PkgLen,NameString,TermList
+pOpProcessor                ext:0x83  Processor                Named|Scoped                           PkgLen,NameString,ByteData,DwordData,ByteData,TermList
+pOpPowerRes                 ext:0x84  PowerRes                 Named|Scoped                           PkgLen,NameString,ByteData,WordData,TermList
+pOpThermalZone              ext:0x85  ThermalZone              Named|Scoped                           PkgLen,NameString,TermList
+pOpIndexField               ext:0x86  IndexField               Create|Named                           PkgLen,NameString,NameString,ByteData,FieldList
+pOpBankField                ext:0x87  BankField                DeferParsing|Create|Named              PkgLen,NameString,NameString,TermArg,ByteData,FieldList
+pOpDataRegion               ext:0x88  DataRegion               Create|Named                           NameString,TermArg,TermArg,TermArg
+
+# Internal opcodes
+pOpIntScopeBlock            int:0xf7  ScopeBlock               Create|Named                           TermList
+pOpIntByteList              int:0xf8  ByteList                 Create                                 -
+pOpIntConnection            int:0xf9  Connection               Create                                 -
+pOpIntNamedField            int:0xfa  NamedField               Create                                 -
+pOpIntResolvedNamePath      int:0xfb  ResolvedNamePath         Create                                 -
+pOpIntNamePath              int:0xfc  NamePath                 Create                                 -
+pOpIntNamePathOrMethodCall  int:0xfd  "NamePath or MethodCall" Create                                 -
+pOpIntMethodCall            int:0xfe  MethodCall               Create                                 -
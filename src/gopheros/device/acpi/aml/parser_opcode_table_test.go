@@ -141,3 +141,37 @@ func TestOpcodeMap(t *testing.T) {
 		}
 	}
 }
+
+func TestOpcodeTableConsistency(t *testing.T) {
+	// Each opcode map slot must point to the table entry for that opcode.
+	for code, tableIndex := range opcodeMap {
+		if tableIndex != badOpcode && pOpcodeTable[tableIndex].op != uint16(code) {
+			t.Errorf("[opcodeMap 0x%x] points to the table entry for %s", code, pOpcodeTable[tableIndex].opName)
+		}
+	}
+
+	for code, tableIndex := range extendedOpcodeMap {
+		if tableIndex != badOpcode && pOpcodeTable[tableIndex].op != uint16(0xff+code) {
+			t.Errorf("[extendedOpcodeMap 0x%x] points to the table entry for %s", code, pOpcodeTable[tableIndex].opName)
+		}
+	}
+
+	// Each table entry must be reachable and describe a valid opcode.
+	names := make(map[string]bool)
+	for tableIndex, entry := range pOpcodeTable {
+		if got := pOpcodeTableIndex(entry.op, true); got != uint8(tableIndex) {
+			t.Errorf("[entry %s] expected opcode 0x%x to map to table index 0x%x; got 0x%x", entry.opName, entry.op, tableIndex, got)
+		}
+
+		if names[entry.opName] {
+			t.Errorf("[entry %s] duplicate opcode name", entry.opName)
+		}
+		names[entry.opName] = true
+
+		for argIndex := uint8(0); argIndex < entry.argFlags.argCount(); argIndex++ {
+			if argType := entry.argFlags.arg(argIndex); argType == 0 || argType > pArgTypePkgLen {
+				t.Errorf("[entry %s] invalid type %d for arg %d", entry.opName, argType, argIndex)
+			}
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package aml
+
+import "gopheros/kernel/kfmt"
+
+// RecoveredError describes a malformed AML construct that was skipped by a
+// parser operating in recovery mode.
+type RecoveredError struct {
+	// The name of the table that contains the malformed construct.
+	TableName string
+
+	// The offset (relative to the table start) of the first skipped byte
+	// and the number of skipped bytes.
+	Offset       uint32
+	SkippedBytes uint32
+
+	// Details about the failure that caused the parser to skip the
+	// malformed construct.
+	Err *ParseError
+}
+
+// recoveryPoint captures the parser state before an object is parsed so
+// that it can be restored if the object turns out to be malformed.
+type recoveryPoint struct {
+	offset       uint32
+	scopeIndex   uint32
+	lastArgIndex uint32
+	scopeDepth   int
+	pkgEndDepth  int
+}
+
+// SetRecoveryMode enables or disables the parser's recovery mode. By default,
+// a single malformed opcode causes ParseAML to abort and return an error.
+// When recovery mode is enabled, the parser instead discards any objects
+// defined by the malformed construct, skips to the end of the enclosing
+// PkgLength-delimited block and continues parsing. Each skipped block is
+// reported to the error writer and can be retrieved via RecoveredErrors.
+func (p *Parser) SetRecoveryMode(enabled bool) {
+	p.recoveryMode = enabled
+}
+
+// RecoveredErrors returns the list of malformed constructs that have been
+// skipped by the parser while operating in recovery mode.
+func (p *Parser) RecoveredErrors() []RecoveredError {
+	return p.recoveredErrors
+}
+
+// recoveryPoint returns a snapshot of the parser state that can be passed to
+// recover.
+func (p *Parser) recoveryPoint() recoveryPoint {
+	scope := p.scopeCurrent()
+	return recoveryPoint{
+		offset:       p.r.Offset(),
+		scopeIndex:   scope.index,
+		lastArgIndex: scope.lastArgIndex,
+		scopeDepth:   len(p.scopeStack),
+		pkgEndDepth:  len(p.pkgEndStack),
+	}
+}
+
+// recover restores the parser state captured by rp after a failure to parse
+// an object, frees any objects allocated while parsing it and skips to the
+// end of the enclosing block.
+func (p *Parser) recover(rp recoveryPoint) {
+	scope := p.objTree.ObjectAt(rp.scopeIndex)
+
+	// Free the objects appended to the scope while parsing the malformed
+	// construct together with any preceding objects that expected it to
+	// provide their args.
+	nextArgIndex := scope.firstArgIndex
+	if rp.lastArgIndex != InvalidIndex {
+		nextArgIndex = p.objTree.ObjectAt(rp.lastArgIndex).nextSiblingIndex
+	}
+	p.freeSiblingsFrom(nextArgIndex)
+	p.trimIncompleteStatements(scope)
+
+	p.scopeStack = p.scopeStack[:rp.scopeDepth]
+	p.pkgEndStack = p.pkgEndStack[:rp.pkgEndDepth]
+	_ = p.r.SetPkgEnd(p.pkgEndStack[len(p.pkgEndStack)-1])
+
+	p.recordRecoveredError(rp.offset, p.r.pkgEnd)
+	p.r.SetOffset(p.r.pkgEnd)
+}
+
+// recoverDeferredBlock discards a deferred object whose contents could not be
+// parsed.
+func (p *Parser) recoverDeferredBlock(obj *Object, scopeDepth int) {
+	p.scopeStack = p.scopeStack[:scopeDepth]
+	p.recordRecoveredError(obj.amlOffset, obj.pkgEnd)
+	p.objTree.freeTree(obj)
+	p.objTree.pruneForwardRefs()
+}
+
+// recordRecoveredError reports that the parser skipped the stream contents in
+// the range [start, end).
+func (p *Parser) recordRecoveredError(start, end uint32) {
+	kfmt.Fprintf(p.errWriter, "[table: %s, offset: 0x%x] skipping %d bytes of malformed AML\n", p.tableName, start, end-start)
+	p.recordParseError(nil, start, "malformed AML")
+	p.recoveredErrors = append(p.recoveredErrors, RecoveredError{
+		TableName:    p.tableName,
+		Offset:       start,
+		SkippedBytes: end - start,
+		Err:          p.lastErr,
+	})
+
+	// Clear the error so that the next failure gets recorded
+	p.lastErr = nil
+}
+
+// freeSiblingsFrom frees the object at index and all objects that follow it
+// in its parent's arg list.
+func (p *Parser) freeSiblingsFrom(index uint32) {
+	for index != InvalidIndex {
+		obj := p.objTree.ObjectAt(index)
+		index = obj.nextSiblingIndex
+		p.objTree.freeTree(obj)
+	}
+
+	p.objTree.pruneForwardRefs()
+}
+
+// trimIncompleteStatements frees any objects at the end of scope's arg list
+// that describe a statement whose trailing args were not parsed. As the
+// parser emits the TermArgs of objects parsed during the first pass as
+// siblings of the object, this is detected by checking whether enough
+// siblings follow each object.
+func (p *Parser) trimIncompleteStatements(scope *Object) {
+	for index := scope.firstArgIndex; index != InvalidIndex; {
+		nextIndex, complete := p.statementEnd(index)
+		if !complete {
+			p.freeSiblingsFrom(index)
+			return
+		}
+
+		index = nextIndex
+	}
+}
+
+// statementEnd returns the index of the object following the statement that
+// begins with the object at index and a flag indicating whether the
+// statement is complete.
+func (p *Parser) statementEnd(index uint32) (uint32, bool) {
+	obj := p.objTree.ObjectAt(index)
+	nextIndex := obj.nextSiblingIndex
+
+	for pending := p.numSiblingArgs(obj); pending > 0; pending-- {
+		if nextIndex == InvalidIndex {
+			return InvalidIndex, false
+		}
+
+		var complete bool
+		if nextIndex, complete = p.statementEnd(nextIndex); !complete {
+			return InvalidIndex, false
+		}
+	}
+
+	return nextIndex, true
+}
+
+// numSiblingArgs returns the number of args that the first parser pass emits
+// as siblings of obj.
+func (p *Parser) numSiblingArgs(obj *Object) uint8 {
+	info := &pOpcodeTable[obj.infoIndex]
+	argCount := info.argFlags.argCount()
+
+	for argIndex := uint8(0); argIndex < argCount; argIndex++ {
+		switch info.argFlags.arg(argIndex) {
+		case pArgTypePkgLen:
+			if info.flags&pOpFlagDeferParsing != 0 {
+				return 0
+			}
+		case pArgTypeTermList:
+			return 0
+		case pArgTypeTermArg, pArgTypeDataRefObj:
+			return argCount - argIndex
+		}
+	}
+
+	return 0
+}
+
+// pruneForwardRefs drops any pending forward references to objects that have
+// been freed.
+func (tree *ObjectTree) pruneForwardRefs() {
+	pending := tree.forwardRefs[:0]
+	for _, ref := range tree.forwardRefs {
+		if tree.ObjectAt(ref.index).opcode != pOpIntFreedObject {
+			pending = append(pending, ref)
+		}
+	}
+
+	tree.forwardRefs = pending
+}
@@ -0,0 +1,162 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"io/ioutil"
+	"testing"
+)
+
+func TestParserRecoveryMode(t *testing.T) {
+	// badOp is neither a valid opcode nor a valid lead name char
+	const badOp = 0x02
+
+	specs := []struct {
+		payload       []byte
+		expPresent    []string
+		expMissing    []string
+		expRecoveries int
+	}{
+		// Malformed construct inside a Device; the rest of the Device
+		// body is skipped while the following objects are parsed.
+		{
+			amltest.Concat(
+				amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+					[]byte{'D', 'E', 'V', '0'},
+					// Name(VAL0, 1)
+					[]byte{0x08, 'V', 'A', 'L', '0', 0x01},
+					[]byte{badOp, 0xff, 0xff},
+					// Name(VAL1, 1)
+					[]byte{0x08, 'V', 'A', 'L', '1', 0x01},
+				)),
+				// Name(VAL2, 1)
+				[]byte{0x08, 'V', 'A', 'L', '2', 0x01},
+			),
+			[]string{`\DEV0`, `\DEV0.VAL0`, `\VAL2`},
+			[]string{`\DEV0.VAL1`},
+			1,
+		},
+		// Named object whose value is malformed; the incomplete Name
+		// should be discarded.
+		{
+			amltest.Concat(
+				amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+					[]byte{'D', 'E', 'V', '0'},
+					// Name(VAL0, 1)
+					[]byte{0x08, 'V', 'A', 'L', '0', 0x01},
+					// Name(VAL1, <malformed>)
+					[]byte{0x08, 'V', 'A', 'L', '1', badOp},
+				)),
+				// Method(MTH0, 0) { Return(1) }
+				amltest.Pkg([]byte{0x14}, []byte{'M', 'T', 'H', '0', 0x00, 0xa4, 0x01}),
+			),
+			[]string{`\DEV0`, `\DEV0.VAL0`, `\MTH0`},
+			[]string{`\DEV0.VAL1`},
+			1,
+		},
+		// Malformed predicate inside a deferred If block; the If block
+		// is discarded.
+		{
+			amltest.Concat(
+				// Method(MTH0, 0) { If(<malformed>) { Return(2) } Return(1) }
+				amltest.Pkg([]byte{0x14}, amltest.Concat(
+					[]byte{'M', 'T', 'H', '0', 0x00},
+					amltest.Pkg([]byte{0xa0}, []byte{badOp, 0xa4, 0x0a, 0x02}),
+					[]byte{0xa4, 0x01},
+				)),
+				// Name(VAL0, 1)
+				[]byte{0x08, 'V', 'A', 'L', '0', 0x01},
+			),
+			[]string{`\MTH0`, `\VAL0`},
+			nil,
+			1,
+		},
+		// Well-formed payload
+		{
+			[]byte{0x08, 'V', 'A', 'L', '0', 0x01},
+			[]string{`\VAL0`},
+			nil,
+			0,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		resolver := mockByteDataResolver(spec.payload)
+
+		// Without recovery mode, parsing a malformed payload should fail
+		tree := NewObjectTree()
+		tree.CreateDefaultScopes(0)
+		err := NewParser(ioutil.Discard, tree).ParseAML(0, "DSDT", resolver.LookupTable("DSDT"))
+		if expFail := spec.expRecoveries != 0; (err != nil) != expFail {
+			t.Errorf("[spec %d] expected parse failure without recovery mode to be %t; got %v", specIndex, expFail, err)
+		}
+
+		tree = NewObjectTree()
+		tree.CreateDefaultScopes(0)
+		p := NewParser(ioutil.Discard, tree)
+		p.SetRecoveryMode(true)
+		if err = p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != nil {
+			t.Errorf("[spec %d] unexpected error in recovery mode: %v", specIndex, err)
+			continue
+		}
+
+		if got := len(p.RecoveredErrors()); got != spec.expRecoveries {
+			t.Errorf("[spec %d] expected %d recovered errors; got %d", specIndex, spec.expRecoveries, got)
+		}
+
+		for _, recovered := range p.RecoveredErrors() {
+			if recovered.TableName != "DSDT" || recovered.SkippedBytes == 0 {
+				t.Errorf("[spec %d] unexpected recovered error contents: %+v", specIndex, recovered)
+			}
+		}
+
+		ns := tree.Namespace()
+		for _, path := range spec.expPresent {
+			if ns.Lookup(nil, path) == nil {
+				t.Errorf("[spec %d] expected %s to be defined", specIndex, path)
+			}
+		}
+
+		for _, path := range spec.expMissing {
+			if ns.Lookup(nil, path) != nil {
+				t.Errorf("[spec %d] expected %s not to be defined", specIndex, path)
+			}
+		}
+
+		// Any methods that survived should still be executable
+		if ns.Lookup(nil, `\MTH0`) != nil {
+			if got, err := NewVM(ioutil.Discard, tree).Evaluate(`\MTH0`); err != nil || got != uint64(1) {
+				t.Errorf("[spec %d] expected MTH0 to return 1; got %#v, %v", specIndex, got, err)
+			}
+		}
+	}
+}
+
+func TestTrimIncompleteStatements(t *testing.T) {
+	tree := NewObjectTree()
+	p := NewParser(ioutil.Discard, tree)
+
+	newObj := func(parent *Object, opcode uint16) *Object {
+		obj := tree.newObject(opcode, 0)
+		tree.append(parent, obj)
+		return obj
+	}
+
+	scope := tree.newObject(pOpIntScopeBlock, 0)
+	// Name(FOO) with its DataRefObj arg emitted as a sibling
+	name := newObj(scope, pOpName)
+	newObj(name, pOpIntNamePath)
+	newObj(scope, pOpOne)
+	// Add(One, <missing>, <missing>)
+	newObj(scope, pOpAdd)
+	newObj(scope, pOpOne)
+
+	p.trimIncompleteStatements(scope)
+
+	if exp, got := uint32(2), tree.NumArgs(scope); got != exp {
+		t.Fatalf("expected scope to contain %d args after trimming; got %d", exp, got)
+	}
+
+	if obj := tree.ObjectAt(scope.lastArgIndex); obj.opcode != pOpOne {
+		t.Fatalf("expected last remaining arg to be One; got %s", obj.Kind())
+	}
+}
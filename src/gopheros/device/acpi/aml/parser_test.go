@@ -4,8 +4,10 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"gopheros/device/acpi/aml/amltest"
 	"gopheros/device/acpi/table"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -122,10 +124,157 @@ func TestParser(t *testing.T) {
 	}
 }
 
+func TestParserStream(t *testing.T) {
+	pathToDumps := pkgDir() + "/../table/tabletest/"
+
+	specs := []struct {
+		expTreeContentFile string
+		tableFiles         []string
+	}{
+		{
+			"DSDT-SSDT.exp",
+			[]string{"DSDT.aml", "SSDT.aml"},
+		},
+		{
+			"parser-testsuite-DSDT.exp",
+			[]string{"parser-testsuite-DSDT.aml"},
+		},
+	}
+
+	for _, spec := range specs {
+		t.Run(fmt.Sprintf("parse [%s]", strings.Join(spec.tableFiles, ", ")), func(t *testing.T) {
+			tree := NewObjectTree()
+			tree.CreateDefaultScopes(42)
+
+			p := NewParser(&testWriter{t: t}, tree)
+			for tableIndex, tableFile := range spec.tableFiles {
+				data, err := ioutil.ReadFile(filepath.Join(pathToDumps, tableFile))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				tableName := strings.Replace(tableFile, ".aml", "", -1)
+				if err := p.ParseAMLStream(uint8(tableIndex), tableName, bytes.NewReader(data)); err != nil {
+					t.Errorf("[%s]: %v", tableName, err)
+					return
+				}
+
+				// Clobber the table contents; the parsed tree must
+				// not reference the stream contents.
+				for i := range data {
+					data[i] = 0xff
+				}
+			}
+
+			var treeDump bytes.Buffer
+			tree.PrettyPrint(&treeDump)
+
+			expDump, err := ioutil.ReadFile(filepath.Join(pathToDumps, spec.expTreeContentFile))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !reflect.DeepEqual(expDump, treeDump.Bytes()) {
+				t.Fatal("parsed tree content does not match the tree generated by ParseAML")
+			}
+		})
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		_, resolver := parserForMockPayload(t, []byte{0x08, 'F', 'O', 'O', '_', 0x0d, 'B', 'A', 'R', 0x00})
+		header := resolver.LookupTable("DSDT")
+		data := make([]byte, header.Length)
+		copy(data, (*[1 << 16]byte)(unsafe.Pointer(header))[:header.Length])
+
+		specs := [][]byte{
+			// Truncated header
+			data[:8],
+			// Truncated payload
+			data[:len(data)-2],
+		}
+
+		for specIndex, spec := range specs {
+			tree := NewObjectTree()
+			tree.CreateDefaultScopes(0)
+			if err := NewParser(ioutil.Discard, tree).ParseAMLStream(0, "DSDT", bytes.NewReader(spec)); !errParsingAML.Is(err) {
+				t.Errorf("[spec %d] expected to get errParsingAML; got %v", specIndex, err)
+			}
+		}
+	})
+}
+
+func TestParseFragment(t *testing.T) {
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+
+	// Name(FOO_, "BAR")
+	p := NewParser(&testWriter{t: t}, tree)
+	if err := p.ParseFragment(1, []byte{0x08, 'F', 'O', 'O', '_', 0x0d, 'B', 'A', 'R', 0x00}); err != nil {
+		t.Fatal(err)
+	}
+
+	if tree.Find(0, []byte("FOO_")) == InvalidIndex {
+		t.Fatal("expected fragment contents to be attached to the object tree")
+	}
+
+	if _, parsed := tree.DSDTRevision(); parsed {
+		t.Fatal("expected parsing a fragment not to record a DSDT revision")
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		specs := [][]byte{
+			// Truncated Name
+			{0x08, 'F', 'O'},
+			// Truncated PkgLength
+			{0x10, 0xc0},
+			// Invalid extended opcode
+			{0x5b, 0xff},
+		}
+
+		for specIndex, spec := range specs {
+			tree := NewObjectTree()
+			tree.CreateDefaultScopes(0)
+			if err := NewParser(ioutil.Discard, tree).ParseFragment(0, spec); !errParsingAML.Is(err) {
+				t.Errorf("[spec %d] expected to get errParsingAML; got %v", specIndex, err)
+			}
+		}
+	})
+
+	t.Run("truncated and mutated tables", func(t *testing.T) {
+		data, err := ioutil.ReadFile(filepath.Join(pkgDir(), "../table/tabletest/parser-testsuite-DSDT.aml"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body := data[unsafe.Sizeof(table.SDTHeader{}):]
+
+		parse := func(input []byte) {
+			tree := NewObjectTree()
+			tree.CreateDefaultScopes(0)
+			_ = NewParser(ioutil.Discard, tree).ParseFragment(0, input)
+		}
+
+		// Parsing malformed input may fail but must never read past the
+		// end of the fragment.
+		for end := 0; end <= len(body); end++ {
+			parse(body[:end])
+		}
+
+		rng := rand.New(rand.NewSource(42))
+		input := make([]byte, len(body))
+		for i := 0; i < 500; i++ {
+			copy(input, body)
+			for mutations := 1 + rng.Intn(8); mutations > 0; mutations-- {
+				input[rng.Intn(len(input))] = byte(rng.Intn(256))
+			}
+			parse(input)
+		}
+	})
+}
+
 func TestParseAMLErrors(t *testing.T) {
 	t.Run("parseObjectList failed", func(t *testing.T) {
 		p, resolver := parserForMockPayload(t, []byte{uint8(pOpBuffer)})
-		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != errParsingAML {
+		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); !errParsingAML.Is(err) {
 			t.Fatalf("expected to get errParsingAML; got: %v", err)
 		}
 	})
@@ -137,7 +286,7 @@ func TestParseAMLErrors(t *testing.T) {
 		p.objTree.append(namedObj, p.objTree.newObject(pOpDwordPrefix, 0))
 		p.objTree.append(p.objTree.ObjectAt(1), namedObj) // Attach to first child of root scope
 
-		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != errParsingAML {
+		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); !errParsingAML.Is(err) {
 			t.Fatalf("expected to get errParsingAML; got: %v", err)
 		}
 	})
@@ -148,7 +297,7 @@ func TestParseAMLErrors(t *testing.T) {
 		scopeDirective := p.objTree.newObject(pOpScope, 0)
 		p.objTree.append(p.objTree.ObjectAt(1), scopeDirective) // Attach to first child of root scope
 
-		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != errParsingAML {
+		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); !errParsingAML.Is(err) {
 			t.Fatalf("expected to get errParsingAML; got: %v", err)
 		}
 	})
@@ -164,7 +313,7 @@ func TestParseAMLErrors(t *testing.T) {
 		p.objTree.append(namedObj, target)
 		p.objTree.append(p.objTree.ObjectAt(0), namedObj)
 
-		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != errParsingAML {
+		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); !errParsingAML.Is(err) {
 			t.Fatalf("expected to get errParsingAML; got: %v", err)
 		}
 	})
@@ -177,7 +326,7 @@ func TestParseAMLErrors(t *testing.T) {
 		def.pkgEnd = 1
 		p.objTree.append(p.objTree.ObjectAt(1), def)
 
-		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != errParsingAML {
+		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); !errParsingAML.Is(err) {
 			t.Fatalf("expected to get errParsingAML; got: %v", err)
 		}
 	})
@@ -195,7 +344,7 @@ func TestParseAMLErrors(t *testing.T) {
 		inv.value = []byte{'M', 'T', 'H', 'D'}
 		p.objTree.append(p.objTree.ObjectAt(0), inv)
 
-		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != errParsingAML {
+		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); !errParsingAML.Is(err) {
 			t.Fatalf("expected to get errParsingAML; got: %v", err)
 		}
 	})
@@ -207,7 +356,7 @@ func TestParseAMLErrors(t *testing.T) {
 		obj := p.objTree.newObject(pOpMatch, 0)
 		p.objTree.append(p.objTree.ObjectAt(0), obj)
 
-		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); err != errParsingAML {
+		if err := p.ParseAML(0, "DSDT", resolver.LookupTable("DSDT")); !errParsingAML.Is(err) {
 			t.Fatalf("expected to get errParsingAML; got: %v", err)
 		}
 	})
@@ -255,16 +404,6 @@ func TestParseNameOrMethodCallErrors(t *testing.T) {
 		'F', 'O', 'O', 'F',
 	}
 
-	t.Run("path not resolving to entity", func(t *testing.T) {
-		p, _ := parserForMockPayload(t, payload)
-		p.scopeEnter(0)
-
-		p.mode = parseModeAllBlocks
-		if res := p.parseNamePathOrMethodCall(); res != parseResultFailed {
-			t.Fatalf("expected to get parseResultFailed(%d); got %d", parseResultFailed, res)
-		}
-	})
-
 	t.Run("incomplete method call", func(t *testing.T) {
 		p, _ := parserForMockPayload(t, payload)
 		p.mode = parseModeAllBlocks
@@ -990,6 +1129,132 @@ func TestResolveMethodCallsErrors(t *testing.T) {
 	})
 }
 
+func TestParseUnresolvedNamePath(t *testing.T) {
+	t.Run("parseNamePathOrMethodCall", func(t *testing.T) {
+		p, _ := parserForMockPayload(t, []byte{'F', 'O', 'O', 'F'})
+		p.scopeEnter(0)
+
+		p.mode = parseModeAllBlocks
+		if res := p.parseNamePathOrMethodCall(); res != parseResultOk {
+			t.Fatalf("expected to get parseResultOk(%d); got %d", parseResultOk, res)
+		}
+
+		// The path should be emitted as a namepath to be resolved at run-time
+		obj := p.objTree.ObjectAt(p.objTree.ObjectAt(0).lastArgIndex)
+		if obj.opcode != pOpIntNamePath {
+			t.Fatalf("expected unresolved path to be emitted as a NamePath; got %s", pOpcodeName(obj.opcode))
+		}
+
+		if exp, got := []byte("FOOF"), obj.value.([]byte); !bytes.Equal(got, exp) {
+			t.Fatalf("expected NamePath to contain %q; got %q", exp, got)
+		}
+	})
+
+	t.Run("method body", func(t *testing.T) {
+		// Method(MTH0, 0) { Return(FOO_) } where FOO_ is never declared
+		tree := NewObjectTree()
+		tree.CreateDefaultScopes(0)
+		err := NewParser(&testWriter{t: t}, tree).ParseFragment(1, amltest.Pkg(
+			[]byte{uint8(pOpMethod)},
+			amltest.Concat([]byte("MTH0"), []byte{0x00, uint8(pOpReturn)}, []byte("FOO_")),
+		))
+		if err != nil {
+			t.Fatalf("expected unresolved path not to cause a parse error; got %v", err)
+		}
+
+		method := tree.ObjectAt(tree.Find(0, []byte("MTH0")))
+		body := tree.scopeContents(method)
+		ret := tree.ArgAt(body, 0)
+		if ret == nil || ret.opcode != pOpReturn {
+			t.Fatal("expected method body to contain a Return statement")
+		}
+
+		if arg := tree.ArgAt(ret, 0); arg == nil || arg.opcode != pOpIntNamePath || !bytes.Equal(arg.value.([]byte), []byte("FOO_")) {
+			t.Fatalf("expected Return arg to be an unresolved NamePath for FOO_; got %v", arg)
+		}
+	})
+}
+
+func TestParsePkgEndRestore(t *testing.T) {
+	// Method(MTH0, 0) {
+	//   Return(Match(Package(){0x10, 0x20}, MEQ, 0x20, MTR, Zero, Zero))
+	// }
+	//
+	// The Package argument sets its own package end while it is being
+	// parsed. The package end of the method body must be restored once the
+	// Package is consumed so that the remaining Match args can be parsed.
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	err := NewParser(&testWriter{t: t}, tree).ParseFragment(1, amltest.Pkg(
+		[]byte{uint8(pOpMethod)},
+		amltest.Concat(
+			[]byte("MTH0"), []byte{0x00, uint8(pOpReturn), uint8(pOpMatch)},
+			amltest.Package(amltest.Int(0x10), amltest.Int(0x20)),
+			[]byte{0x01}, amltest.Int(0x20), []byte{0x00, uint8(pOpZero), uint8(pOpZero)},
+		),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	method := tree.ObjectAt(tree.Find(0, []byte("MTH0")))
+	ret := tree.ArgAt(tree.scopeContents(method), 0)
+	if ret == nil || ret.opcode != pOpReturn {
+		t.Fatal("expected method body to contain a Return statement")
+	}
+
+	match := tree.ArgAt(ret, 0)
+	if match == nil || match.opcode != pOpMatch {
+		t.Fatal("expected Return arg to be a Match expression")
+	}
+
+	if exp, got := uint32(6), tree.NumArgs(match); got != exp {
+		t.Fatalf("expected Match to have %d args; got %d", exp, got)
+	}
+
+	if pkg := tree.ArgAt(match, 0); pkg.opcode != pOpPackage {
+		t.Fatalf("expected first Match arg to be a Package; got %s", pOpcodeName(pkg.opcode))
+	}
+}
+
+func TestParseStrictTermArgScope(t *testing.T) {
+	// Add(CNT0, One, Zero)
+	p, _ := parserForMockPayload(t, amltest.Concat(
+		[]byte{uint8(pOpAdd)}, []byte("CNT0"), []byte{uint8(pOpOne), uint8(pOpZero)},
+	))
+	p.mode = parseModeAllBlocks
+
+	// Device(DEV0) { Name(CNT0, One); Return(<term arg>) }
+	tree := p.objTree
+	dev := tree.newNamedObject(pOpDevice, 0, [amlNameLen]byte{'D', 'E', 'V', '0'})
+	tree.append(tree.ObjectAt(0), dev)
+	body := tree.newObject(pOpIntScopeBlock, 0)
+	tree.append(dev, body)
+	tree.append(body, tree.newNamedObject(pOpName, 0, [amlNameLen]byte{'C', 'N', 'T', '0'}))
+	ret := tree.newObject(pOpReturn, 0)
+	tree.append(body, ret)
+
+	// The nested CNT0 reference can only be resolved if the Add object is
+	// reachable from the enclosing Device while its args are parsed.
+	termObj, res := p.parseStrictTermArg(ret)
+	if res != parseResultOk {
+		t.Fatalf("expected to get parseResultOk(%d); got %d", parseResultOk, res)
+	}
+
+	if termObj.opcode != pOpAdd {
+		t.Fatalf("expected term arg to be an Add expression; got %s", pOpcodeName(termObj.opcode))
+	}
+
+	if arg := tree.ArgAt(termObj, 0); arg == nil || arg.opcode != pOpIntResolvedNamePath {
+		t.Fatalf("expected nested CNT0 reference to be resolved while parsing; got %v", arg)
+	}
+
+	// The term arg must be detached again so the caller can attach it.
+	if termObj.parentIndex != InvalidIndex || tree.NumArgs(ret) != 0 {
+		t.Fatal("expected parsed term arg to be detached from curObj")
+	}
+}
+
 func parserForMockPayload(t *testing.T, payload []byte) (*Parser, table.Resolver) {
 	tree := NewObjectTree()
 	tree.CreateDefaultScopes(42)
@@ -0,0 +1,264 @@
+// Package pci builds PCI interrupt routing tables by evaluating the _PRT
+// objects defined under the PCI root bridges in the ACPI namespace and
+// describes the bus numbers and address windows decoded by each root bridge.
+package pci
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/device"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/kernel"
+)
+
+var (
+	errNoRoutingTable    = &kernel.Error{Module: "acpi_aml_pci", Message: "PCI root bridge does not define a _PRT object", Code: kernel.ErrCodeNotFound}
+	errMalformedPRT      = &kernel.Error{Module: "acpi_aml_pci", Message: "malformed _PRT entry", Code: kernel.ErrCodeCorrupted}
+	errLinkNotFound      = &kernel.Error{Module: "acpi_aml_pci", Message: "unable to resolve PCI link device referenced by _PRT", Code: kernel.ErrCodeNotFound}
+	errLinkNotConfigured = &kernel.Error{Module: "acpi_aml_pci", Message: "PCI link device has no interrupt assigned", Code: kernel.ErrCodeNotSupported}
+)
+
+// The hardware IDs used by PCI and PCI express root bridges.
+var rootBridgeIDs = []string{"PNP0A03", "PNP0A08"}
+
+// Pin identifies a PCI interrupt pin.
+type Pin uint8
+
+// The supported PCI interrupt pins.
+const (
+	PinA Pin = iota
+	PinB
+	PinC
+	PinD
+)
+
+// String implements fmt.Stringer for Pin.
+func (p Pin) String() string {
+	switch p {
+	case PinA:
+		return "INTA"
+	case PinB:
+		return "INTB"
+	case PinC:
+		return "INTC"
+	case PinD:
+		return "INTD"
+	default:
+		return "INT?"
+	}
+}
+
+// Route describes the interrupt that is raised when a PCI device asserts one
+// of its interrupt pins.
+type Route struct {
+	// The PCI device number and the interrupt pin.
+	Device uint8
+	Pin    Pin
+
+	// The global system interrupt that the pin is routed to.
+	GSI uint32
+
+	// The namespace path of the PCI link device that the pin is routed
+	// through or an empty string if the pin is hardwired to GSI.
+	Link string
+
+	// The interrupt trigger mode and polarity. Hardwired pins are
+	// level-triggered and active-low.
+	EdgeTriggered bool
+	ActiveLow     bool
+}
+
+// RoutingTable contains the interrupt routes for the devices attached to a
+// PCI root bridge.
+type RoutingTable struct {
+	// The namespace path of the root bridge device.
+	Bridge string
+
+	Routes []Route
+}
+
+// Lookup returns the route for the specified device and pin.
+func (t *RoutingTable) Lookup(device uint8, pin Pin) (Route, bool) {
+	for _, route := range t.Routes {
+		if route.Device == device && route.Pin == pin {
+			return route, true
+		}
+	}
+
+	return Route{}, false
+}
+
+// RoutingTables locates all PCI root bridges in ns and returns the routing
+// tables built from their _PRT objects. Root bridges that do not define a
+// _PRT object or are reported as absent by their _STA method are skipped.
+func RoutingTables(vm *aml.VM, ns *aml.Namespace) ([]*RoutingTable, *kernel.Error) {
+	devices, err := device.Enumerate(vm, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var tables []*RoutingTable
+	for _, dev := range devices {
+		if !dev.Matches(rootBridgeIDs...) || !dev.Present() || dev.Node.Child("_PRT") == nil {
+			continue
+		}
+
+		table, err := routingTableFor(vm, ns, dev.Node)
+		if err != nil {
+			return nil, err
+		}
+
+		tables = append(tables, table)
+	}
+
+	return tables, nil
+}
+
+// RoutingTableFor builds the routing table for the PCI root bridge at the
+// specified namespace path.
+func RoutingTableFor(vm *aml.VM, ns *aml.Namespace, bridgePath string) (*RoutingTable, *kernel.Error) {
+	bridge := ns.Lookup(nil, bridgePath)
+	if bridge == nil || bridge.Child("_PRT") == nil {
+		return nil, errNoRoutingTable
+	}
+
+	return routingTableFor(vm, ns, bridge)
+}
+
+// routingTableFor evaluates the _PRT object of bridge and resolves any link
+// devices that it references.
+func routingTableFor(vm *aml.VM, ns *aml.Namespace, bridge *aml.NamespaceNode) (*RoutingTable, *kernel.Error) {
+	prt, err := vm.Evaluate(bridge.Child("_PRT").Path())
+	if err != nil {
+		return nil, err
+	}
+
+	entries, ok := prt.([]interface{})
+	if !ok {
+		return nil, errMalformedPRT
+	}
+
+	table := &RoutingTable{
+		Bridge: bridge.Path(),
+		Routes: make([]Route, 0, len(entries)),
+	}
+
+	for _, entry := range entries {
+		route, err := parseRoute(vm, ns, bridge, entry)
+		if err != nil {
+			return nil, err
+		}
+
+		table.Routes = append(table.Routes, route)
+	}
+
+	return table, nil
+}
+
+// parseRoute converts a _PRT entry into a Route. Each entry is a package
+// containing the device address (device number in the high word and 0xffff
+// in the low word), the pin, the interrupt source and the source index. If
+// the source is zero, the source index specifies the GSI that the pin is
+// hardwired to; otherwise the source refers to the link device that the pin
+// is routed through.
+func parseRoute(vm *aml.VM, ns *aml.Namespace, bridge *aml.NamespaceNode, entry interface{}) (Route, *kernel.Error) {
+	fields, ok := entry.([]interface{})
+	if !ok || len(fields) != 4 {
+		return Route{}, errMalformedPRT
+	}
+
+	addr, addrOk := fields[0].(uint64)
+	pin, pinOk := fields[1].(uint64)
+	sourceIndex, indexOk := fields[3].(uint64)
+	if !addrOk || !pinOk || !indexOk || pin > uint64(PinD) {
+		return Route{}, errMalformedPRT
+	}
+
+	route := Route{
+		Device: uint8(addr >> 16),
+		Pin:    Pin(pin),
+	}
+
+	var link *aml.NamespaceNode
+	switch source := fields[2].(type) {
+	case uint64:
+		if source != 0 {
+			return Route{}, errMalformedPRT
+		}
+
+		route.GSI = uint32(sourceIndex)
+		route.ActiveLow = true
+		return route, nil
+	case *aml.Object:
+		link = ns.NodeFor(source)
+	case *aml.Reference:
+		link = ns.NodeFor(source.Target)
+	case string:
+		link = ns.Lookup(bridge, source)
+	default:
+		return Route{}, errMalformedPRT
+	}
+
+	if link == nil {
+		return Route{}, errLinkNotFound
+	}
+
+	route.Link = link.Path()
+	if err := resolveLink(vm, link, &route); err != nil {
+		return Route{}, err
+	}
+
+	return route, nil
+}
+
+// resolveLink evaluates the _CRS method of a PCI link device and populates
+// route with the currently assigned interrupt.
+func resolveLink(vm *aml.VM, link *aml.NamespaceNode, route *Route) *kernel.Error {
+	crs := link.Child("_CRS")
+	if crs == nil {
+		return errLinkNotConfigured
+	}
+
+	val, err := vm.Evaluate(crs.Path())
+	if err != nil {
+		return err
+	}
+
+	template, ok := val.([]byte)
+	if !ok {
+		return errLinkNotConfigured
+	}
+
+	descriptors, err := resource.Decode(template)
+	if err != nil {
+		return err
+	}
+
+	for _, desc := range descriptors {
+		switch typ := desc.(type) {
+		case *resource.IRQ:
+			if typ.Mask == 0 {
+				continue
+			}
+
+			for irq := uint32(0); irq < 16; irq++ {
+				if typ.Mask&(1<<irq) != 0 {
+					route.GSI = irq
+					break
+				}
+			}
+
+			route.EdgeTriggered, route.ActiveLow = typ.EdgeTriggered, typ.ActiveLow
+			return nil
+		case *resource.ExtendedInterrupt:
+			if len(typ.Interrupts) == 0 {
+				continue
+			}
+
+			route.GSI = typ.Interrupts[0]
+			route.EdgeTriggered, route.ActiveLow = typ.EdgeTriggered, typ.ActiveLow
+			return nil
+		}
+	}
+
+	return errLinkNotConfigured
+}
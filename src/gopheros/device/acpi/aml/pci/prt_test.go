@@ -0,0 +1,197 @@
+package pci
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"reflect"
+	"testing"
+)
+
+var (
+	// EISAID("PNP0A08"), EISAID("PNP0A03") and EISAID("PNP0C0F")
+	pnp0a08 = []byte{0x0c, 0x41, 0xd0, 0x0a, 0x08}
+	pnp0a03 = []byte{0x0c, 0x41, 0xd0, 0x0a, 0x03}
+	pnp0c0f = []byte{0x0c, 0x41, 0xd0, 0x0c, 0x0f}
+)
+
+func TestRoutingTables(t *testing.T) {
+	vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x10}, amltest.Concat(
+		// Scope(_SB) {
+		[]byte{'_', 'S', 'B', '_'},
+		//   Device(LNKA) {
+		//     Name(_HID, EISAID("PNP0C0F"))
+		//     Name(_CRS, ResourceTemplate() { IRQ(Level, ActiveLow, Shared) {11} })
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'L', 'N', 'K', 'A'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0c0f,
+			[]byte{0x08, '_', 'C', 'R', 'S'}, amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x06, 0x23, 0x00, 0x08, 0x18, 0x79, 0x00}),
+		)),
+		//   Device(LNKB) {
+		//     Name(_HID, EISAID("PNP0C0F"))
+		//     Method(_CRS) { Return(ResourceTemplate() { Interrupt(ResourceConsumer, Edge, ActiveHigh) {0x20} }) }
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'L', 'N', 'K', 'B'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0c0f,
+			amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'_', 'C', 'R', 'S', 0x00, 0xa4},
+				amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x0b, 0x89, 0x06, 0x00, 0x03, 0x01, 0x20, 0x00, 0x00, 0x00, 0x79, 0x00}),
+			)),
+		)),
+		//   Device(PCI0) {
+		//     Name(_HID, EISAID("PNP0A08"))
+		//     Name(_PRT, Package(3) {
+		//       Package(4) {0x0001ffff, 0, LNKA, 0},
+		//       Package(4) {0x0001ffff, 2, LNKB, 0},
+		//       Package(4) {0x0002ffff, 1, Zero, 17},
+		//     })
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '0'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0a08,
+			[]byte{0x08, '_', 'P', 'R', 'T'}, amltest.Pkg([]byte{0x12}, amltest.Concat(
+				[]byte{0x03},
+				amltest.Pkg([]byte{0x12}, []byte{0x04, 0x0c, 0xff, 0xff, 0x01, 0x00, 0x00, 'L', 'N', 'K', 'A', 0x00}),
+				amltest.Pkg([]byte{0x12}, []byte{0x04, 0x0c, 0xff, 0xff, 0x01, 0x00, 0x0a, 0x02, 'L', 'N', 'K', 'B', 0x00}),
+				amltest.Pkg([]byte{0x12}, []byte{0x04, 0x0c, 0xff, 0xff, 0x02, 0x00, 0x01, 0x00, 0x0a, 0x11}),
+			)),
+		)),
+		//   Device(PCI1) {
+		//     Name(_HID, "PNP0A05")
+		//     Name(_CID, EISAID("PNP0A03"))
+		//     Method(_PRT) { Return(Package(1) { Package(4) {0x0003ffff, 3, Zero, 20} }) }
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '1'},
+			[]byte{0x08, '_', 'H', 'I', 'D', 0x0d, 'P', 'N', 'P', '0', 'A', '0', '5', 0x00},
+			[]byte{0x08, '_', 'C', 'I', 'D'}, pnp0a03,
+			amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'_', 'P', 'R', 'T', 0x00, 0xa4},
+				amltest.Pkg([]byte{0x12}, amltest.Concat(
+					[]byte{0x01},
+					amltest.Pkg([]byte{0x12}, []byte{0x04, 0x0c, 0xff, 0xff, 0x03, 0x00, 0x0a, 0x03, 0x00, 0x0a, 0x14}),
+				)),
+			)),
+		)),
+		//   Device(PCI2) {
+		//     Name(_HID, EISAID("PNP0A03"))
+		//     Method(_STA) { Return(Zero) }
+		//     Name(_PRT, Package(0) {})
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '2'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0a03,
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'S', 'T', 'A', 0x00, 0xa4, 0x00}),
+			[]byte{0x08, '_', 'P', 'R', 'T'}, amltest.Pkg([]byte{0x12}, []byte{0x00}),
+		)),
+		// }
+	)))
+
+	tables, err := RoutingTables(vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []*RoutingTable{
+		{
+			Bridge: `\_SB_.PCI0`,
+			Routes: []Route{
+				{Device: 1, Pin: PinA, GSI: 11, Link: `\_SB_.LNKA`, ActiveLow: true},
+				{Device: 1, Pin: PinC, GSI: 0x20, Link: `\_SB_.LNKB`, EdgeTriggered: true},
+				{Device: 2, Pin: PinB, GSI: 17, ActiveLow: true},
+			},
+		},
+		{
+			Bridge: `\_SB_.PCI1`,
+			Routes: []Route{
+				{Device: 3, Pin: PinD, GSI: 20, ActiveLow: true},
+			},
+		},
+	}
+
+	if !reflect.DeepEqual(tables, exp) {
+		t.Fatalf("expected routing tables to be:\n%+v\n%+v\ngot:\n%+v", *exp[0], *exp[1], tables)
+	}
+
+	t.Run("lookup", func(t *testing.T) {
+		if route, found := tables[0].Lookup(1, PinC); !found || route.GSI != 0x20 {
+			t.Errorf("expected lookup for device 1, INTC to return GSI 0x20; got %+v (found: %t)", route, found)
+		}
+
+		if _, found := tables[0].Lookup(1, PinB); found {
+			t.Error("expected lookup for device 1, INTB to fail")
+		}
+	})
+
+	t.Run("by path", func(t *testing.T) {
+		table, err := RoutingTableFor(vm, ns, `\_SB.PCI1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(table, exp[1]) {
+			t.Fatalf("expected routing table to be %+v; got %+v", exp[1], table)
+		}
+
+		if _, err = RoutingTableFor(vm, ns, `\_SB.LNKA`); err != errNoRoutingTable {
+			t.Fatalf("expected to get errNoRoutingTable; got %v", err)
+		}
+	})
+}
+
+func TestRoutingTableErrors(t *testing.T) {
+	specs := []struct {
+		prt    []byte
+		expErr error
+	}{
+		// Entry is not a package
+		{
+			amltest.Pkg([]byte{0x12}, []byte{0x01, 0x01}),
+			errMalformedPRT,
+		},
+		// Entry has too few fields
+		{
+			amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x01}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x00, 0x00}))),
+			errMalformedPRT,
+		},
+		// Invalid pin
+		{
+			amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x01}, amltest.Pkg([]byte{0x12}, []byte{0x04, 0x00, 0x0a, 0x04, 0x00, 0x00}))),
+			errMalformedPRT,
+		},
+		// Link device without an interrupt assigned
+		{
+			amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x01}, amltest.Pkg([]byte{0x12}, []byte{0x04, 0x00, 0x00, 'L', 'N', 'K', 'Z', 0x00}))),
+			errLinkNotConfigured,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'_', 'S', 'B', '_'},
+			// Device(LNKZ) { Name(_CRS, ResourceTemplate() { IRQ(Level, ActiveLow, Shared) {} }) }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'L', 'N', 'K', 'Z'},
+				[]byte{0x08, '_', 'C', 'R', 'S'}, amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x06, 0x23, 0x00, 0x00, 0x18, 0x79, 0x00}),
+			)),
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'P', 'C', 'I', '0'},
+				[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0a03,
+				[]byte{0x08, '_', 'P', 'R', 'T'}, spec.prt,
+			)),
+		)))
+
+		if _, err := RoutingTables(vm, ns); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestPinString(t *testing.T) {
+	for pin, exp := range []string{"INTA", "INTB", "INTC", "INTD", "INT?"} {
+		if got := Pin(pin).String(); got != exp {
+			t.Errorf("expected Pin(%d).String() to return %q; got %q", pin, exp, got)
+		}
+	}
+}
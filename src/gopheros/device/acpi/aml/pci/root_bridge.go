@@ -0,0 +1,207 @@
+package pci
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/device"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/kernel"
+)
+
+var (
+	errBridgeNotFound   = &kernel.Error{Module: "acpi_aml_pci", Message: "PCI root bridge not found", Code: kernel.ErrCodeNotFound}
+	errMalformedBridge  = &kernel.Error{Module: "acpi_aml_pci", Message: "PCI root bridge _SEG or _BBN object does not evaluate to an Integer", Code: kernel.ErrCodeCorrupted}
+	errMalformedBusCRS  = &kernel.Error{Module: "acpi_aml_pci", Message: "PCI root bridge _CRS object does not evaluate to a resource template", Code: kernel.ErrCodeCorrupted}
+	errInvalidBusWindow = &kernel.Error{Module: "acpi_aml_pci", Message: "PCI root bridge _CRS specifies an invalid bus number range", Code: kernel.ErrCodeCorrupted}
+)
+
+// The flags of Address resource descriptors that are used when decoding the
+// _CRS of a root bridge.
+const (
+	// Set if the resource is consumed by the bridge itself instead of
+	// being forwarded to its secondary bus.
+	addrFlagConsumer = uint8(1 << 0)
+
+	// The memory attribute bits of memory address ranges and the value
+	// used by prefetchable ranges.
+	memAttrShift        = 1
+	memAttrMask         = uint8(3 << memAttrShift)
+	memAttrPrefetchable = uint8(3 << memAttrShift)
+)
+
+// WindowType identifies the address space of a root bridge window.
+type WindowType uint8
+
+// The supported window types.
+const (
+	WindowMemory WindowType = iota
+	WindowIO
+)
+
+// Window describes an address range that a root bridge forwards to the
+// devices on its secondary bus.
+type Window struct {
+	Type WindowType
+
+	// The first and last address of the window on the PCI bus.
+	Min uint64
+	Max uint64
+
+	// The offset that must be added to a PCI bus address in this window
+	// to obtain the corresponding address on the host side.
+	TranslationOffset uint64
+
+	// Prefetchable is set for memory windows that can be prefetched.
+	Prefetchable bool
+}
+
+// RootBridge describes a PCI root bridge and the resources that it decodes.
+// PCI bus drivers use this information as the starting points for enumerating
+// the devices attached to the system.
+type RootBridge struct {
+	// The namespace path of the root bridge device.
+	Path string
+
+	// The PCI segment group (_SEG) that the bridge belongs to.
+	Segment uint16
+
+	// The range of bus numbers that are decoded by the bridge. The first
+	// bus is the bridge's secondary bus.
+	BusStart uint8
+	BusEnd   uint8
+
+	// The memory and I/O windows forwarded by the bridge.
+	Windows []Window
+}
+
+// RootBridges locates all PCI root bridges in ns and evaluates their _SEG,
+// _BBN and _CRS objects. Root bridges that are reported as absent by their
+// _STA method are skipped.
+func RootBridges(vm *aml.VM, ns *aml.Namespace) ([]*RootBridge, *kernel.Error) {
+	devices, err := device.Enumerate(vm, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var bridges []*RootBridge
+	for _, dev := range devices {
+		if !dev.Matches(rootBridgeIDs...) || !dev.Present() {
+			continue
+		}
+
+		bridge, err := rootBridgeFor(vm, dev.Node)
+		if err != nil {
+			return nil, err
+		}
+
+		bridges = append(bridges, bridge)
+	}
+
+	return bridges, nil
+}
+
+// RootBridgeFor evaluates the _SEG, _BBN and _CRS objects of the PCI root
+// bridge at the specified namespace path.
+func RootBridgeFor(vm *aml.VM, ns *aml.Namespace, bridgePath string) (*RootBridge, *kernel.Error) {
+	node := ns.Lookup(nil, bridgePath)
+	if node == nil {
+		return nil, errBridgeNotFound
+	}
+
+	return rootBridgeFor(vm, node)
+}
+
+// rootBridgeFor builds the RootBridge for the bridge device at node. Bridges
+// without a _SEG or _BBN object belong to segment 0 and use 0 as their base
+// bus number. Unless _CRS specifies a bus number range, bridges are assumed
+// to decode all buses starting from their base bus number.
+func rootBridgeFor(vm *aml.VM, node *aml.NamespaceNode) (*RootBridge, *kernel.Error) {
+	segment, err := evalIntChild(vm, node, "_SEG")
+	if err != nil {
+		return nil, err
+	}
+
+	busStart, err := evalIntChild(vm, node, "_BBN")
+	if err != nil {
+		return nil, err
+	}
+
+	bridge := &RootBridge{
+		Path:     node.Path(),
+		Segment:  uint16(segment),
+		BusStart: uint8(busStart),
+		BusEnd:   0xff,
+	}
+
+	if node.Child("_CRS") == nil {
+		return bridge, nil
+	}
+
+	val, err := vm.Evaluate(node.Child("_CRS").Path())
+	if err != nil {
+		return nil, err
+	}
+
+	template, ok := val.([]byte)
+	if !ok {
+		return nil, errMalformedBusCRS
+	}
+
+	descriptors, err := resource.Decode(template)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, desc := range descriptors {
+		addr, ok := desc.(*resource.Address)
+		if !ok || addr.GeneralFlags&addrFlagConsumer != 0 || addr.Length == 0 {
+			continue
+		}
+
+		switch addr.ResourceType {
+		case resource.AddressTypeBus:
+			if addr.Min > addr.Max || addr.Max > 0xff {
+				return nil, errInvalidBusWindow
+			}
+			bridge.BusStart, bridge.BusEnd = uint8(addr.Min), uint8(addr.Max)
+		case resource.AddressTypeMemory:
+			bridge.Windows = append(bridge.Windows, Window{
+				Type:              WindowMemory,
+				Min:               addr.Min,
+				Max:               addr.Max,
+				TranslationOffset: addr.TranslationOffset,
+				Prefetchable:      addr.TypeSpecificFlags&memAttrMask == memAttrPrefetchable,
+			})
+		case resource.AddressTypeIO:
+			bridge.Windows = append(bridge.Windows, Window{
+				Type:              WindowIO,
+				Min:               addr.Min,
+				Max:               addr.Max,
+				TranslationOffset: addr.TranslationOffset,
+			})
+		}
+	}
+
+	return bridge, nil
+}
+
+// evalIntChild evaluates the object with the specified name defined inside
+// the scope of node and returns its value. If node does not contain such an
+// object, evalIntChild returns 0.
+func evalIntChild(vm *aml.VM, node *aml.NamespaceNode, name string) (uint64, *kernel.Error) {
+	child := node.Child(name)
+	if child == nil {
+		return 0, nil
+	}
+
+	val, err := vm.Evaluate(child.Path())
+	if err != nil {
+		return 0, err
+	}
+
+	intVal, ok := val.(uint64)
+	if !ok {
+		return 0, errMalformedBridge
+	}
+
+	return intVal, nil
+}
@@ -0,0 +1,163 @@
+package pci
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/kernel"
+	"reflect"
+	"testing"
+)
+
+func TestRootBridges(t *testing.T) {
+	vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x10}, amltest.Concat(
+		// Scope(_SB) {
+		[]byte{'_', 'S', 'B', '_'},
+		//   Device(PCI0) {
+		//     Name(_HID, EISAID("PNP0A08"))
+		//     Name(_SEG, 1)
+		//     Name(_CRS, ResourceTemplate() {
+		//       WordBusNumber(ResourceProducer, ..., 0x00, 0x00, 0x3f, 0x00, 0x40)
+		//       WordIO(ResourceProducer, ..., 0x00, 0x0000, 0x0cf7, 0x00, 0x0cf8)
+		//       IO(Decode16, 0x0cf8, 0x0cf8, 0x01, 0x08)
+		//       DWordMemory(ResourceProducer, ..., Prefetchable, ..., 0x00, 0xe0000000, 0xefffffff, 0x00, 0x10000000)
+		//       DWordMemory(ResourceConsumer, ..., 0x00, 0xfed00000, 0xfed003ff, 0x00, 0x400)
+		//     })
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '0'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0a08,
+			[]byte{0x08, '_', 'S', 'E', 'G', 0x01},
+			[]byte{0x08, '_', 'C', 'R', 'S'}, resourceTemplate(
+				wordAddress(2, 0, 0, 0x00, 0x3f, 0x40),
+				wordAddress(1, 0, 0, 0x0000, 0x0cf7, 0x0cf8),
+				[]byte{0x47, 0x01, 0xf8, 0x0c, 0xf8, 0x0c, 0x01, 0x08},
+				dwordAddress(0, 0, 0x06, 0xe0000000, 0xefffffff, 0x10000000),
+				dwordAddress(0, 1, 0, 0xfed00000, 0xfed003ff, 0x400),
+			),
+		)),
+		//   Device(PCI1) {
+		//     Name(_HID, EISAID("PNP0A03"))
+		//     Name(_BBN, 0x80)
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '1'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0a03,
+			[]byte{0x08, '_', 'B', 'B', 'N', 0x0a, 0x80},
+		)),
+		//   Device(PCI2) {
+		//     Name(_HID, EISAID("PNP0A03"))
+		//     Name(_STA, Zero)
+		//   }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '2'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0a03,
+			[]byte{0x08, '_', 'S', 'T', 'A', 0x00},
+		)),
+		//   Device(LNKA) { Name(_HID, EISAID("PNP0C0F")) }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'L', 'N', 'K', 'A'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0c0f,
+		)),
+		// }
+	)))
+
+	exp := []*RootBridge{
+		{
+			Path:     `\_SB_.PCI0`,
+			Segment:  1,
+			BusStart: 0x00,
+			BusEnd:   0x3f,
+			Windows: []Window{
+				{Type: WindowIO, Min: 0x0000, Max: 0x0cf7},
+				{Type: WindowMemory, Min: 0xe0000000, Max: 0xefffffff, Prefetchable: true},
+			},
+		},
+		{
+			Path:     `\_SB_.PCI1`,
+			BusStart: 0x80,
+			BusEnd:   0xff,
+		},
+	}
+
+	bridges, err := RootBridges(vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(bridges, exp) {
+		t.Fatalf("expected to get root bridges:\n%#v\ngot:\n%#v", exp, bridges)
+	}
+
+	t.Run("lookup by path", func(t *testing.T) {
+		bridge, err := RootBridgeFor(vm, ns, `\_SB.PCI1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(bridge, exp[1]) {
+			t.Fatalf("expected to get root bridge:\n%#v\ngot:\n%#v", exp[1], bridge)
+		}
+
+		if _, err = RootBridgeFor(vm, ns, `\_SB.PCI9`); err != errBridgeNotFound {
+			t.Fatalf("expected to get errBridgeNotFound; got %v", err)
+		}
+	})
+}
+
+func TestRootBridgeErrors(t *testing.T) {
+	specs := []struct {
+		contents []byte
+		expErr   *kernel.Error
+	}{
+		// Name(_SEG, "foo")
+		{
+			[]byte{0x08, '_', 'S', 'E', 'G', 0x0d, 'f', 'o', 'o', 0x00},
+			errMalformedBridge,
+		},
+		// Name(_CRS, One)
+		{
+			[]byte{0x08, '_', 'C', 'R', 'S', 0x01},
+			errMalformedBusCRS,
+		},
+		// Bus number range exceeds the maximum bus number
+		{
+			amltest.Concat([]byte{0x08, '_', 'C', 'R', 'S'}, resourceTemplate(wordAddress(2, 0, 0, 0x00, 0x100, 0x101))),
+			errInvalidBusWindow,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '0'},
+			[]byte{0x08, '_', 'H', 'I', 'D'}, pnp0a03,
+			spec.contents,
+		)))
+
+		if _, err := RootBridges(vm, ns); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+// resourceTemplate returns the AML encoding of a Buffer containing the
+// supplied resource descriptors followed by an end tag.
+func resourceTemplate(descriptors ...[]byte) []byte {
+	contents := append(amltest.Concat(descriptors...), 0x79, 0x00)
+	return amltest.Pkg([]byte{0x11}, amltest.Concat([]byte{0x0a, byte(len(contents))}, contents))
+}
+
+// wordAddress returns a Word Address Space descriptor.
+func wordAddress(resType, generalFlags, typeFlags uint8, min, max, length uint64) []byte {
+	return amltest.Concat(
+		[]byte{0x88, 0x0d, 0x00, resType, generalFlags, typeFlags},
+		amltest.LE(0, 2), amltest.LE(min, 2), amltest.LE(max, 2), amltest.LE(0, 2), amltest.LE(length, 2),
+	)
+}
+
+// dwordAddress returns a DWord Address Space descriptor.
+func dwordAddress(resType, generalFlags, typeFlags uint8, min, max, length uint64) []byte {
+	return amltest.Concat(
+		[]byte{0x87, 0x17, 0x00, resType, generalFlags, typeFlags},
+		amltest.LE(0, 4), amltest.LE(min, 4), amltest.LE(max, 4), amltest.LE(0, 4), amltest.LE(length, 4),
+	)
+}
@@ -0,0 +1,97 @@
+package resource
+
+import (
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+// WriteDescriptor writes a single-line text representation of desc to w. The
+// output is named after the ASL macro that generates the descriptor and lists
+// all decoded fields in a fixed order so that it can be compared with a
+// line-based diff.
+func WriteDescriptor(w io.Writer, desc Descriptor) {
+	switch d := desc.(type) {
+	case *IRQ:
+		kfmt.Fprintf(w, "IRQ(mask=0x%x edge=%t low=%t shared=%t wake=%t)", d.Mask, d.EdgeTriggered, d.ActiveLow, d.Shared, d.WakeCapable)
+	case *DMA:
+		kfmt.Fprintf(w, "DMA(channels=0x%x type=%d busmaster=%t speed=%d)", d.Channels, d.TransferType, d.BusMaster, d.Speed)
+	case *StartDependent:
+		kfmt.Fprintf(w, "StartDependentFn(priority=%d robustness=%d)", d.Priority, d.Robustness)
+	case *EndDependent:
+		kfmt.Fprintf(w, "EndDependentFn()")
+	case *IO:
+		kfmt.Fprintf(w, "IO(decode16=%t min=0x%x max=0x%x align=0x%x len=0x%x)", d.Decode16, d.Min, d.Max, d.Alignment, d.Length)
+	case *FixedIO:
+		kfmt.Fprintf(w, "FixedIO(base=0x%x len=0x%x)", d.Base, d.Length)
+	case *FixedDMA:
+		kfmt.Fprintf(w, "FixedDMA(line=%d channel=%d width=%d)", d.RequestLine, d.Channel, d.TransferWidth)
+	case *Vendor:
+		kfmt.Fprintf(w, "Vendor(large=%t data=", d.Large)
+		writeBytes(w, d.Data)
+		kfmt.Fprintf(w, ")")
+	case *Memory24:
+		kfmt.Fprintf(w, "Memory24(rw=%t min=0x%x max=0x%x align=0x%x len=0x%x)", d.Writable, d.Min, d.Max, d.Alignment, d.Length)
+	case *Memory32:
+		kfmt.Fprintf(w, "Memory32(rw=%t min=0x%x max=0x%x align=0x%x len=0x%x)", d.Writable, d.Min, d.Max, d.Alignment, d.Length)
+	case *Memory32Fixed:
+		kfmt.Fprintf(w, "Memory32Fixed(rw=%t base=0x%x len=0x%x)", d.Writable, d.Base, d.Length)
+	case *GenericRegister:
+		kfmt.Fprintf(w, "Register(space=%d width=%d offset=%d access=%d addr=0x%x)", d.AddressSpace, d.BitWidth, d.BitOffset, d.AccessSize, d.Address)
+	case *Address:
+		kfmt.Fprintf(w, "%sSpace(type=%d flags=0x%x tflags=0x%x gran=0x%x min=0x%x max=0x%x xlat=0x%x len=0x%x",
+			addressWidthName(d.Width), d.ResourceType, d.GeneralFlags, d.TypeSpecificFlags,
+			d.Granularity, d.Min, d.Max, d.TranslationOffset, d.Length,
+		)
+		if d.Width == AddressExtended {
+			kfmt.Fprintf(w, " attrs=0x%x", d.TypeSpecificAttributes)
+		}
+		writeResourceSource(w, d.ResourceSourceIndex, d.ResourceSource)
+		kfmt.Fprintf(w, ")")
+	case *ExtendedInterrupt:
+		kfmt.Fprintf(w, "Interrupt(consumer=%t edge=%t low=%t shared=%t wake=%t irqs=", d.Consumer, d.EdgeTriggered, d.ActiveLow, d.Shared, d.WakeCapable)
+		for i, irq := range d.Interrupts {
+			if i != 0 {
+				kfmt.Fprintf(w, ",")
+			}
+			kfmt.Fprintf(w, "%d", irq)
+		}
+		writeResourceSource(w, d.ResourceSourceIndex, d.ResourceSource)
+		kfmt.Fprintf(w, ")")
+	case *Unknown:
+		kfmt.Fprintf(w, "Unknown(large=%t name=0x%x data=", d.Large, d.Name)
+		writeBytes(w, d.Data)
+		kfmt.Fprintf(w, ")")
+	}
+}
+
+// addressWidthName returns the ASL macro prefix for an address space
+// descriptor width.
+func addressWidthName(width AddressWidth) string {
+	switch width {
+	case AddressWord:
+		return "Word"
+	case AddressDWord:
+		return "DWord"
+	case AddressQWord:
+		return "QWord"
+	default:
+		return "Extended"
+	}
+}
+
+// writeResourceSource appends the optional resource source of a descriptor.
+func writeResourceSource(w io.Writer, index uint8, source string) {
+	if source != "" {
+		kfmt.Fprintf(w, " source=%s:%d", source, index)
+	}
+}
+
+// writeBytes writes data as a comma-separated list of hex bytes.
+func writeBytes(w io.Writer, data []byte) {
+	for i, b := range data {
+		if i != 0 {
+			kfmt.Fprintf(w, ",")
+		}
+		kfmt.Fprintf(w, "%2x", b)
+	}
+}
@@ -0,0 +1,803 @@
+// Package resource decodes and encodes the resource templates that are
+// returned by the _CRS and _PRS control methods and passed to _SRS. A resource
+// template is a byte buffer containing a list of small and large resource
+// descriptors terminated by an end tag.
+package resource
+
+import "gopheros/kernel"
+
+var (
+	errTruncatedDescriptor = &kernel.Error{Module: "acpi_aml_resource", Message: "resource descriptor extends past the end of the buffer", Code: kernel.ErrCodeCorrupted}
+	errInvalidLength       = &kernel.Error{Module: "acpi_aml_resource", Message: "resource descriptor has an invalid length", Code: kernel.ErrCodeCorrupted}
+	errMissingEndTag       = &kernel.Error{Module: "acpi_aml_resource", Message: "resource template is not terminated by an end tag", Code: kernel.ErrCodeCorrupted}
+	errDescriptorTooLarge  = &kernel.Error{Module: "acpi_aml_resource", Message: "resource descriptor contents exceed the maximum descriptor length", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// Small resource descriptor item names.
+const (
+	smallIRQ            = 0x04
+	smallDMA            = 0x05
+	smallStartDependent = 0x06
+	smallEndDependent   = 0x07
+	smallIO             = 0x08
+	smallFixedIO        = 0x09
+	smallFixedDMA       = 0x0a
+	smallVendor         = 0x0e
+	smallEndTag         = 0x0f
+
+	// The maximum payload length for a small descriptor.
+	smallMaxLen = 7
+)
+
+// Large resource descriptor item names.
+const (
+	largeMemory24          = 0x01
+	largeGenericRegister   = 0x02
+	largeVendor            = 0x04
+	largeMemory32          = 0x05
+	largeMemory32Fixed     = 0x06
+	largeDWordAddress      = 0x07
+	largeWordAddress       = 0x08
+	largeExtendedInterrupt = 0x09
+	largeQWordAddress      = 0x0a
+	largeExtendedAddress   = 0x0b
+)
+
+// Descriptor is implemented by all resource descriptor types.
+type Descriptor interface {
+	// encode appends the encoded descriptor to buf.
+	encode(buf []byte) ([]byte, *kernel.Error)
+}
+
+// IRQ describes the ISA interrupts that a device uses (IRQ descriptor).
+type IRQ struct {
+	// A bitmask where bit n indicates that the device uses IRQ n.
+	Mask uint16
+
+	EdgeTriggered bool
+	ActiveLow     bool
+	Shared        bool
+	WakeCapable   bool
+}
+
+// DMA describes the ISA DMA channels that a device uses (DMA descriptor).
+type DMA struct {
+	// A bitmask where bit n indicates that the device uses channel n.
+	Channels uint8
+
+	// The DMA transfer size (0: 8-bit, 1: 8 and 16-bit, 2: 16-bit).
+	TransferType uint8
+
+	BusMaster bool
+
+	// The DMA channel speed (0: compatibility, 1: type A, 2: type B,
+	// 3: type F).
+	Speed uint8
+}
+
+// StartDependent marks the beginning of a set of alternative resource
+// configurations (Start Dependent Functions descriptor).
+type StartDependent struct {
+	// The configuration priority (0: good, 1: acceptable, 2: sub-optimal).
+	Priority uint8
+
+	// The performance/robustness priority.
+	Robustness uint8
+}
+
+// EndDependent marks the end of a set of alternative resource configurations
+// (End Dependent Functions descriptor).
+type EndDependent struct{}
+
+// IO describes a range of relocatable I/O ports (I/O Port descriptor).
+type IO struct {
+	// Decode16 is set when the device decodes the full 16-bit ISA address.
+	Decode16 bool
+
+	Min       uint16
+	Max       uint16
+	Alignment uint8
+	Length    uint8
+}
+
+// FixedIO describes a fixed range of I/O ports with a 10-bit base address
+// (Fixed Location I/O Port descriptor).
+type FixedIO struct {
+	Base   uint16
+	Length uint8
+}
+
+// FixedDMA describes a fixed DMA request line and channel (Fixed DMA
+// descriptor).
+type FixedDMA struct {
+	RequestLine uint16
+	Channel     uint16
+
+	// The transfer width (0: 8-bit, 1: 16-bit, 2: 32-bit, ...).
+	TransferWidth uint8
+}
+
+// Vendor contains vendor-defined data stored in a small or large vendor
+// descriptor.
+type Vendor struct {
+	Large bool
+	Data  []byte
+}
+
+// Memory24 describes a range of 24-bit ISA memory (24-bit Memory Range
+// descriptor). All values are specified in bytes.
+type Memory24 struct {
+	Writable  bool
+	Min       uint32
+	Max       uint32
+	Alignment uint32
+	Length    uint32
+}
+
+// Memory32 describes a range of relocatable 32-bit memory (32-bit Memory
+// Range descriptor).
+type Memory32 struct {
+	Writable  bool
+	Min       uint32
+	Max       uint32
+	Alignment uint32
+	Length    uint32
+}
+
+// Memory32Fixed describes a fixed range of 32-bit memory (32-bit Fixed Memory
+// Range descriptor).
+type Memory32Fixed struct {
+	Writable bool
+	Base     uint32
+	Length   uint32
+}
+
+// GenericRegister describes the location of a fixed-function register
+// (Generic Register descriptor).
+type GenericRegister struct {
+	AddressSpace uint8
+	BitWidth     uint8
+	BitOffset    uint8
+	AccessSize   uint8
+	Address      uint64
+}
+
+// AddressWidth identifies the descriptor that was used to encode an address
+// space resource.
+type AddressWidth uint8
+
+// The supported address space descriptor types.
+const (
+	AddressWord AddressWidth = iota
+	AddressDWord
+	AddressQWord
+	AddressExtended
+)
+
+// Address space resource types.
+const (
+	AddressTypeMemory uint8 = 0
+	AddressTypeIO     uint8 = 1
+	AddressTypeBus    uint8 = 2
+)
+
+// Address describes a memory, I/O or bus number range (Word, DWord, QWord and
+// Extended Address Space descriptors).
+type Address struct {
+	Width AddressWidth
+
+	// The resource type (see AddressType* constants), the general flags
+	// (producer/consumer, decode type, min/max fixed) and the resource
+	// type-specific flags (e.g. memory cacheability).
+	ResourceType      uint8
+	GeneralFlags      uint8
+	TypeSpecificFlags uint8
+
+	Granularity       uint64
+	Min               uint64
+	Max               uint64
+	TranslationOffset uint64
+	Length            uint64
+
+	// The type-specific attributes; only valid for extended address
+	// descriptors.
+	TypeSpecificAttributes uint64
+
+	// The optional device that produces this resource; only valid for
+	// non-extended descriptors.
+	ResourceSourceIndex uint8
+	ResourceSource      string
+}
+
+// ExtendedInterrupt describes a set of interrupts using global system
+// interrupt numbers (Extended Interrupt descriptor).
+type ExtendedInterrupt struct {
+	Consumer      bool
+	EdgeTriggered bool
+	ActiveLow     bool
+	Shared        bool
+	WakeCapable   bool
+
+	Interrupts []uint32
+
+	// The optional device that produces this resource.
+	ResourceSourceIndex uint8
+	ResourceSource      string
+}
+
+// Unknown contains the raw contents of a descriptor that is not recognized
+// by the decoder.
+type Unknown struct {
+	Large bool
+	Name  uint8
+	Data  []byte
+}
+
+// Decode parses the resource template in buf and returns the list of
+// descriptors that it contains. Decoding stops at the end tag descriptor
+// which is not included in the returned list.
+func Decode(buf []byte) ([]Descriptor, *kernel.Error) {
+	var descriptors []Descriptor
+
+	for offset := 0; offset < len(buf); {
+		large, name, data, next, err := readDescriptor(buf, offset)
+		if err != nil {
+			return nil, err
+		}
+		offset = next
+
+		if !large && name == smallEndTag {
+			return descriptors, nil
+		}
+
+		desc, err := decodeDescriptor(large, name, data)
+		if err != nil {
+			return nil, err
+		}
+
+		descriptors = append(descriptors, desc)
+	}
+
+	return nil, errMissingEndTag
+}
+
+// EndTagOffset returns the offset of the end tag descriptor that terminates
+// the resource template in buf.
+func EndTagOffset(buf []byte) (int, *kernel.Error) {
+	for offset := 0; offset < len(buf); {
+		large, name, _, next, err := readDescriptor(buf, offset)
+		if err != nil {
+			return 0, err
+		}
+
+		if !large && name == smallEndTag {
+			return offset, nil
+		}
+		offset = next
+	}
+
+	return 0, errMissingEndTag
+}
+
+// readDescriptor reads the header of the descriptor at the specified offset
+// and returns its type, its contents and the offset of the next descriptor.
+func readDescriptor(buf []byte, offset int) (large bool, name uint8, data []byte, next int, err *kernel.Error) {
+	var (
+		tag     = buf[offset]
+		dataLen int
+	)
+
+	if large = tag&0x80 != 0; large {
+		if offset+3 > len(buf) {
+			return false, 0, nil, 0, errTruncatedDescriptor
+		}
+
+		name = tag & 0x7f
+		dataLen = int(readUint16(buf[offset+1:]))
+		offset += 3
+	} else {
+		name = (tag >> 3) & 0xf
+		dataLen = int(tag & 0x7)
+		offset++
+	}
+
+	if offset+dataLen > len(buf) {
+		return false, 0, nil, 0, errTruncatedDescriptor
+	}
+
+	return large, name, buf[offset : offset+dataLen], offset + dataLen, nil
+}
+
+// decodeDescriptor decodes the contents of a single descriptor.
+func decodeDescriptor(large bool, name uint8, data []byte) (Descriptor, *kernel.Error) {
+	if !large {
+		return decodeSmall(name, data)
+	}
+
+	return decodeLarge(name, data)
+}
+
+func decodeSmall(name uint8, data []byte) (Descriptor, *kernel.Error) {
+	switch name {
+	case smallIRQ:
+		if len(data) != 2 && len(data) != 3 {
+			return nil, errInvalidLength
+		}
+
+		// Descriptors without a flags byte describe edge-triggered,
+		// active-high interrupts.
+		irq := &IRQ{Mask: readUint16(data), EdgeTriggered: true}
+		if len(data) == 3 {
+			irq.EdgeTriggered = data[2]&0x01 != 0
+			irq.ActiveLow = data[2]&0x08 != 0
+			irq.Shared = data[2]&0x10 != 0
+			irq.WakeCapable = data[2]&0x20 != 0
+		}
+		return irq, nil
+	case smallDMA:
+		if len(data) != 2 {
+			return nil, errInvalidLength
+		}
+
+		return &DMA{
+			Channels:     data[0],
+			TransferType: data[1] & 0x3,
+			BusMaster:    data[1]&0x4 != 0,
+			Speed:        (data[1] >> 5) & 0x3,
+		}, nil
+	case smallStartDependent:
+		switch len(data) {
+		case 0:
+			// Without a priority byte the configuration is
+			// considered acceptable.
+			return &StartDependent{Priority: 1, Robustness: 1}, nil
+		case 1:
+			return &StartDependent{Priority: data[0] & 0x3, Robustness: (data[0] >> 2) & 0x3}, nil
+		default:
+			return nil, errInvalidLength
+		}
+	case smallEndDependent:
+		if len(data) != 0 {
+			return nil, errInvalidLength
+		}
+		return &EndDependent{}, nil
+	case smallIO:
+		if len(data) != 7 {
+			return nil, errInvalidLength
+		}
+
+		return &IO{
+			Decode16:  data[0]&0x1 != 0,
+			Min:       readUint16(data[1:]),
+			Max:       readUint16(data[3:]),
+			Alignment: data[5],
+			Length:    data[6],
+		}, nil
+	case smallFixedIO:
+		if len(data) != 3 {
+			return nil, errInvalidLength
+		}
+
+		return &FixedIO{Base: readUint16(data) & 0x3ff, Length: data[2]}, nil
+	case smallFixedDMA:
+		if len(data) != 5 {
+			return nil, errInvalidLength
+		}
+
+		return &FixedDMA{
+			RequestLine:   readUint16(data),
+			Channel:       readUint16(data[2:]),
+			TransferWidth: data[4],
+		}, nil
+	case smallVendor:
+		return &Vendor{Data: copyBytes(data)}, nil
+	default:
+		return &Unknown{Name: name, Data: copyBytes(data)}, nil
+	}
+}
+
+func decodeLarge(name uint8, data []byte) (Descriptor, *kernel.Error) {
+	switch name {
+	case largeMemory24:
+		if len(data) != 9 {
+			return nil, errInvalidLength
+		}
+
+		return &Memory24{
+			Writable:  data[0]&0x1 != 0,
+			Min:       uint32(readUint16(data[1:])) << 8,
+			Max:       uint32(readUint16(data[3:])) << 8,
+			Alignment: uint32(readUint16(data[5:])),
+			Length:    uint32(readUint16(data[7:])) << 8,
+		}, nil
+	case largeGenericRegister:
+		if len(data) != 12 {
+			return nil, errInvalidLength
+		}
+
+		return &GenericRegister{
+			AddressSpace: data[0],
+			BitWidth:     data[1],
+			BitOffset:    data[2],
+			AccessSize:   data[3],
+			Address:      readUint64(data[4:]),
+		}, nil
+	case largeVendor:
+		return &Vendor{Large: true, Data: copyBytes(data)}, nil
+	case largeMemory32:
+		if len(data) != 17 {
+			return nil, errInvalidLength
+		}
+
+		return &Memory32{
+			Writable:  data[0]&0x1 != 0,
+			Min:       readUint32(data[1:]),
+			Max:       readUint32(data[5:]),
+			Alignment: readUint32(data[9:]),
+			Length:    readUint32(data[13:]),
+		}, nil
+	case largeMemory32Fixed:
+		if len(data) != 9 {
+			return nil, errInvalidLength
+		}
+
+		return &Memory32Fixed{
+			Writable: data[0]&0x1 != 0,
+			Base:     readUint32(data[1:]),
+			Length:   readUint32(data[5:]),
+		}, nil
+	case largeWordAddress:
+		return decodeAddress(AddressWord, data, 2)
+	case largeDWordAddress:
+		return decodeAddress(AddressDWord, data, 4)
+	case largeQWordAddress:
+		return decodeAddress(AddressQWord, data, 8)
+	case largeExtendedAddress:
+		return decodeAddress(AddressExtended, data, 8)
+	case largeExtendedInterrupt:
+		if len(data) < 2 {
+			return nil, errInvalidLength
+		}
+
+		count := int(data[1])
+		if len(data) < 2+4*count {
+			return nil, errInvalidLength
+		}
+
+		irq := &ExtendedInterrupt{
+			Consumer:      data[0]&0x01 != 0,
+			EdgeTriggered: data[0]&0x02 != 0,
+			ActiveLow:     data[0]&0x04 != 0,
+			Shared:        data[0]&0x08 != 0,
+			WakeCapable:   data[0]&0x10 != 0,
+			Interrupts:    make([]uint32, count),
+		}
+
+		for i := 0; i < count; i++ {
+			irq.Interrupts[i] = readUint32(data[2+4*i:])
+		}
+
+		irq.ResourceSourceIndex, irq.ResourceSource = decodeResourceSource(data[2+4*count:])
+		return irq, nil
+	default:
+		return &Unknown{Large: true, Name: name, Data: copyBytes(data)}, nil
+	}
+}
+
+// decodeAddress decodes an address space descriptor whose numeric fields are
+// fieldLen bytes wide.
+func decodeAddress(width AddressWidth, data []byte, fieldLen int) (Descriptor, *kernel.Error) {
+	// Extended descriptors include a revision and a reserved byte
+	// between the flags and the numeric fields.
+	headerLen := 3
+	if width == AddressExtended {
+		headerLen = 5
+	}
+
+	minLen := headerLen + 5*fieldLen
+	if width == AddressExtended {
+		minLen += 8
+	}
+
+	if len(data) < minLen {
+		return nil, errInvalidLength
+	}
+
+	addr := &Address{
+		Width:             width,
+		ResourceType:      data[0],
+		GeneralFlags:      data[1],
+		TypeSpecificFlags: data[2],
+	}
+
+	fields := []*uint64{&addr.Granularity, &addr.Min, &addr.Max, &addr.TranslationOffset, &addr.Length}
+	for i, field := range fields {
+		*field = readUint(data[headerLen+i*fieldLen:], fieldLen)
+	}
+
+	if width == AddressExtended {
+		addr.TypeSpecificAttributes = readUint64(data[headerLen+5*fieldLen:])
+	} else {
+		addr.ResourceSourceIndex, addr.ResourceSource = decodeResourceSource(data[minLen:])
+	}
+
+	return addr, nil
+}
+
+// decodeResourceSource decodes the optional resource source index and
+// null-terminated resource source path that follow some large descriptors.
+func decodeResourceSource(data []byte) (uint8, string) {
+	if len(data) == 0 {
+		return 0, ""
+	}
+
+	end := 1
+	for end < len(data) && data[end] != 0 {
+		end++
+	}
+
+	return data[0], string(data[1:end])
+}
+
+// Encode generates a resource template (e.g. for passing to _SRS) containing
+// the supplied descriptors followed by an end tag.
+func Encode(descriptors []Descriptor) ([]byte, *kernel.Error) {
+	var (
+		buf []byte
+		err *kernel.Error
+	)
+
+	for _, desc := range descriptors {
+		if buf, err = desc.encode(buf); err != nil {
+			return nil, err
+		}
+	}
+
+	// A zero checksum indicates that the template checksum should be
+	// ignored.
+	return append(buf, smallEndTag<<3|1, 0), nil
+}
+
+func (d *IRQ) encode(buf []byte) ([]byte, *kernel.Error) {
+	var flags uint8
+	if d.EdgeTriggered {
+		flags |= 0x01
+	}
+	if d.ActiveLow {
+		flags |= 0x08
+	}
+	if d.Shared {
+		flags |= 0x10
+	}
+	if d.WakeCapable {
+		flags |= 0x20
+	}
+
+	return appendSmall(buf, smallIRQ, appendUint16(nil, d.Mask), flags)
+}
+
+func (d *DMA) encode(buf []byte) ([]byte, *kernel.Error) {
+	flags := d.TransferType&0x3 | (d.Speed&0x3)<<5
+	if d.BusMaster {
+		flags |= 0x4
+	}
+
+	return appendSmall(buf, smallDMA, []byte{d.Channels, flags})
+}
+
+func (d *StartDependent) encode(buf []byte) ([]byte, *kernel.Error) {
+	return appendSmall(buf, smallStartDependent, []byte{d.Priority&0x3 | (d.Robustness&0x3)<<2})
+}
+
+func (d *EndDependent) encode(buf []byte) ([]byte, *kernel.Error) {
+	return appendSmall(buf, smallEndDependent, nil)
+}
+
+func (d *IO) encode(buf []byte) ([]byte, *kernel.Error) {
+	var info uint8
+	if d.Decode16 {
+		info = 0x1
+	}
+
+	data := appendUint16([]byte{info}, d.Min)
+	data = appendUint16(data, d.Max)
+	return appendSmall(buf, smallIO, data, d.Alignment, d.Length)
+}
+
+func (d *FixedIO) encode(buf []byte) ([]byte, *kernel.Error) {
+	return appendSmall(buf, smallFixedIO, appendUint16(nil, d.Base&0x3ff), d.Length)
+}
+
+func (d *FixedDMA) encode(buf []byte) ([]byte, *kernel.Error) {
+	data := appendUint16(nil, d.RequestLine)
+	data = appendUint16(data, d.Channel)
+	return appendSmall(buf, smallFixedDMA, data, d.TransferWidth)
+}
+
+func (d *Vendor) encode(buf []byte) ([]byte, *kernel.Error) {
+	if d.Large {
+		return appendLarge(buf, largeVendor, d.Data)
+	}
+
+	return appendSmall(buf, smallVendor, d.Data)
+}
+
+func (d *Memory24) encode(buf []byte) ([]byte, *kernel.Error) {
+	data := []byte{boolToUint8(d.Writable)}
+	data = appendUint16(data, uint16(d.Min>>8))
+	data = appendUint16(data, uint16(d.Max>>8))
+	data = appendUint16(data, uint16(d.Alignment))
+	data = appendUint16(data, uint16(d.Length>>8))
+	return appendLarge(buf, largeMemory24, data)
+}
+
+func (d *Memory32) encode(buf []byte) ([]byte, *kernel.Error) {
+	data := []byte{boolToUint8(d.Writable)}
+	data = appendUint32(data, d.Min)
+	data = appendUint32(data, d.Max)
+	data = appendUint32(data, d.Alignment)
+	data = appendUint32(data, d.Length)
+	return appendLarge(buf, largeMemory32, data)
+}
+
+func (d *Memory32Fixed) encode(buf []byte) ([]byte, *kernel.Error) {
+	data := []byte{boolToUint8(d.Writable)}
+	data = appendUint32(data, d.Base)
+	data = appendUint32(data, d.Length)
+	return appendLarge(buf, largeMemory32Fixed, data)
+}
+
+func (d *GenericRegister) encode(buf []byte) ([]byte, *kernel.Error) {
+	data := []byte{d.AddressSpace, d.BitWidth, d.BitOffset, d.AccessSize}
+	return appendLarge(buf, largeGenericRegister, appendUint64(data, d.Address))
+}
+
+func (d *Address) encode(buf []byte) ([]byte, *kernel.Error) {
+	var (
+		name     uint8
+		fieldLen int
+		data     = []byte{d.ResourceType, d.GeneralFlags, d.TypeSpecificFlags}
+	)
+
+	switch d.Width {
+	case AddressWord:
+		name, fieldLen = largeWordAddress, 2
+	case AddressDWord:
+		name, fieldLen = largeDWordAddress, 4
+	case AddressQWord:
+		name, fieldLen = largeQWordAddress, 8
+	default:
+		// Extended descriptors use revision 1 and a reserved byte.
+		name, fieldLen = largeExtendedAddress, 8
+		data = append(data, 1, 0)
+	}
+
+	for _, field := range []uint64{d.Granularity, d.Min, d.Max, d.TranslationOffset, d.Length} {
+		data = appendUint(data, field, fieldLen)
+	}
+
+	if d.Width == AddressExtended {
+		data = appendUint64(data, d.TypeSpecificAttributes)
+	} else {
+		data = appendResourceSource(data, d.ResourceSourceIndex, d.ResourceSource)
+	}
+
+	return appendLarge(buf, name, data)
+}
+
+func (d *ExtendedInterrupt) encode(buf []byte) ([]byte, *kernel.Error) {
+	if len(d.Interrupts) > 0xff {
+		return nil, errDescriptorTooLarge
+	}
+
+	var flags uint8
+	for bit, set := range []bool{d.Consumer, d.EdgeTriggered, d.ActiveLow, d.Shared, d.WakeCapable} {
+		if set {
+			flags |= 1 << uint8(bit)
+		}
+	}
+
+	data := []byte{flags, uint8(len(d.Interrupts))}
+	for _, irq := range d.Interrupts {
+		data = appendUint32(data, irq)
+	}
+
+	return appendLarge(buf, largeExtendedInterrupt, appendResourceSource(data, d.ResourceSourceIndex, d.ResourceSource))
+}
+
+func (d *Unknown) encode(buf []byte) ([]byte, *kernel.Error) {
+	if d.Large {
+		return appendLarge(buf, d.Name&0x7f, d.Data)
+	}
+
+	return appendSmall(buf, d.Name&0xf, d.Data)
+}
+
+// appendSmall appends a small descriptor with the specified name whose
+// contents are data followed by extra to buf.
+func appendSmall(buf []byte, name uint8, data []byte, extra ...byte) ([]byte, *kernel.Error) {
+	dataLen := len(data) + len(extra)
+	if dataLen > smallMaxLen {
+		return nil, errDescriptorTooLarge
+	}
+
+	buf = append(buf, name<<3|uint8(dataLen))
+	buf = append(buf, data...)
+	return append(buf, extra...), nil
+}
+
+// appendLarge appends a large descriptor with the specified name and contents
+// to buf.
+func appendLarge(buf []byte, name uint8, data []byte) ([]byte, *kernel.Error) {
+	if len(data) > 0xffff {
+		return nil, errDescriptorTooLarge
+	}
+
+	buf = appendUint16(append(buf, 0x80|name), uint16(len(data)))
+	return append(buf, data...), nil
+}
+
+// appendResourceSource appends the optional resource source index and path to
+// buf. Nothing is appended if no resource source is specified.
+func appendResourceSource(buf []byte, index uint8, source string) []byte {
+	if source == "" {
+		return buf
+	}
+
+	buf = append(buf, index)
+	buf = append(buf, source...)
+	return append(buf, 0)
+}
+
+func copyBytes(data []byte) []byte {
+	return append([]byte(nil), data...)
+}
+
+func boolToUint8(v bool) uint8 {
+	if v {
+		return 1
+	}
+	return 0
+}
+
+func readUint16(data []byte) uint16 {
+	return uint16(readUint(data, 2))
+}
+
+func readUint32(data []byte) uint32 {
+	return uint32(readUint(data, 4))
+}
+
+func readUint64(data []byte) uint64 {
+	return readUint(data, 8)
+}
+
+// readUint decodes a little-endian value that is size bytes long.
+func readUint(data []byte, size int) uint64 {
+	var val uint64
+	for i := size - 1; i >= 0; i-- {
+		val = val<<8 | uint64(data[i])
+	}
+	return val
+}
+
+func appendUint16(buf []byte, val uint16) []byte {
+	return appendUint(buf, uint64(val), 2)
+}
+
+func appendUint32(buf []byte, val uint32) []byte {
+	return appendUint(buf, uint64(val), 4)
+}
+
+func appendUint64(buf []byte, val uint64) []byte {
+	return appendUint(buf, val, 8)
+}
+
+// appendUint appends the size least significant bytes of val to buf using
+// little-endian encoding.
+func appendUint(buf []byte, val uint64, size int) []byte {
+	for i := 0; i < size; i++ {
+		buf = append(buf, uint8(val>>(8*uint(i))))
+	}
+	return buf
+}
@@ -0,0 +1,334 @@
+package resource
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"reflect"
+	"testing"
+)
+
+func TestDecode(t *testing.T) {
+	specs := []struct {
+		template []byte
+		exp      []Descriptor
+	}{
+		// IRQ without flags defaults to edge-triggered, active-high
+		{
+			[]byte{0x22, 0x02, 0x00, 0x79, 0x00},
+			[]Descriptor{&IRQ{Mask: 1 << 1, EdgeTriggered: true}},
+		},
+		// IRQ with flags: level-triggered, active-low, shared
+		{
+			[]byte{0x23, 0x00, 0x02, 0x18, 0x79, 0x00},
+			[]Descriptor{&IRQ{Mask: 1 << 9, ActiveLow: true, Shared: true}},
+		},
+		// DMA, IO, FixedIO
+		{
+			[]byte{
+				0x2a, 0x04, 0x25,
+				0x47, 0x01, 0xf8, 0x03, 0xf8, 0x03, 0x01, 0x08,
+				0x4b, 0x60, 0x00, 0x01,
+				0x79, 0x00,
+			},
+			[]Descriptor{
+				&DMA{Channels: 0x04, TransferType: 1, BusMaster: true, Speed: 1},
+				&IO{Decode16: true, Min: 0x3f8, Max: 0x3f8, Alignment: 1, Length: 8},
+				&FixedIO{Base: 0x60, Length: 1},
+			},
+		},
+		// Start/End dependent functions
+		{
+			[]byte{0x30, 0x31, 0x06, 0x38, 0x79, 0x00},
+			[]Descriptor{
+				&StartDependent{Priority: 1, Robustness: 1},
+				&StartDependent{Priority: 2, Robustness: 1},
+				&EndDependent{},
+			},
+		},
+		// FixedDMA and small vendor
+		{
+			[]byte{
+				0x55, 0x02, 0x00, 0x03, 0x00, 0x02,
+				0x72, 0xaa, 0xbb,
+				0x79, 0x00,
+			},
+			[]Descriptor{
+				&FixedDMA{RequestLine: 2, Channel: 3, TransferWidth: 2},
+				&Vendor{Data: []byte{0xaa, 0xbb}},
+			},
+		},
+		// Memory32Fixed and Memory24
+		{
+			[]byte{
+				0x86, 0x09, 0x00, 0x01, 0x00, 0x00, 0xd0, 0xfe, 0x00, 0x04, 0x00, 0x00,
+				0x81, 0x09, 0x00, 0x00, 0x00, 0x0a, 0x00, 0x0a, 0x00, 0x00, 0x00, 0x02,
+				0x79, 0x00,
+			},
+			[]Descriptor{
+				&Memory32Fixed{Writable: true, Base: 0xfed00000, Length: 0x400},
+				&Memory24{Min: 0xa0000, Max: 0xa0000, Alignment: 0, Length: 0x20000},
+			},
+		},
+		// Memory32 and GenericRegister
+		{
+			[]byte{
+				0x85, 0x11, 0x00, 0x01,
+				0x00, 0x00, 0x00, 0xe0, 0xff, 0xff, 0xff, 0xef, 0x00, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x10,
+				0x82, 0x0c, 0x00, 0x7f, 0x01, 0x02, 0x03, 0x00, 0x10, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+				0x79, 0x00,
+			},
+			[]Descriptor{
+				&Memory32{Writable: true, Min: 0xe0000000, Max: 0xefffffff, Alignment: 0x100000, Length: 0x10000000},
+				&GenericRegister{AddressSpace: 0x7f, BitWidth: 1, BitOffset: 2, AccessSize: 3, Address: 0x1000},
+			},
+		},
+		// WordAddress (bus number) with a resource source
+		{
+			[]byte{
+				0x88, 0x13, 0x00, 0x02, 0x0c, 0x00,
+				0x00, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0x00, 0x01,
+				0x00, 'P', 'C', 'I', '0', 0x00,
+				0x79, 0x00,
+			},
+			[]Descriptor{
+				&Address{
+					Width:               AddressWord,
+					ResourceType:        AddressTypeBus,
+					GeneralFlags:        0x0c,
+					Max:                 0xff,
+					Length:              0x100,
+					ResourceSourceIndex: 0,
+					ResourceSource:      "PCI0",
+				},
+			},
+		},
+		// ExtendedInterrupt
+		{
+			[]byte{
+				0x89, 0x0a, 0x00, 0x0b, 0x02, 0x10, 0x00, 0x00, 0x00, 0x11, 0x00, 0x00, 0x00,
+				0x79, 0x00,
+			},
+			[]Descriptor{
+				&ExtendedInterrupt{Consumer: true, EdgeTriggered: true, Shared: true, Interrupts: []uint32{0x10, 0x11}},
+			},
+		},
+		// Unknown descriptors are preserved
+		{
+			[]byte{0x61, 0x42, 0xfe, 0x01, 0x00, 0x99, 0x79, 0x00},
+			[]Descriptor{
+				&Unknown{Name: 0x0c, Data: []byte{0x42}},
+				&Unknown{Large: true, Name: 0x7e, Data: []byte{0x99}},
+			},
+		},
+		// Contents following the end tag are ignored
+		{
+			[]byte{0x79, 0x00, 0xff},
+			nil,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		got, err := Decode(spec.template)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected decoded descriptors to be:\n%#v\ngot:\n%#v", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	specs := []struct {
+		template []byte
+		expErr   error
+	}{
+		{[]byte{}, errMissingEndTag},
+		{[]byte{0x22, 0x02, 0x00}, errMissingEndTag},
+		{[]byte{0x22, 0x02}, errTruncatedDescriptor},
+		{[]byte{0x86, 0x09}, errTruncatedDescriptor},
+		{[]byte{0x86, 0x09, 0x00, 0x00}, errTruncatedDescriptor},
+		{[]byte{0x21, 0x00, 0x79, 0x00}, errInvalidLength},
+		{[]byte{0x46, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x79, 0x00}, errInvalidLength},
+		{[]byte{0x86, 0x01, 0x00, 0x00, 0x79, 0x00}, errInvalidLength},
+		{[]byte{0x87, 0x03, 0x00, 0x00, 0x00, 0x00, 0x79, 0x00}, errInvalidLength},
+		// Interrupt count exceeds the descriptor length
+		{[]byte{0x89, 0x06, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00, 0x00, 0x79, 0x00}, errInvalidLength},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := Decode(spec.template); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestEndTagOffset(t *testing.T) {
+	specs := []struct {
+		template  []byte
+		expOffset int
+		expErr    *kernel.Error
+	}{
+		{[]byte{0x79, 0x00}, 0, nil},
+		// IRQNoFlags() {1}, EndTag
+		{[]byte{0x22, 0x02, 0x00, 0x79, 0x00}, 3, nil},
+		// Memory32Fixed(ReadWrite, 0xfed00000, 0x400), EndTag
+		{[]byte{0x86, 0x09, 0x00, 0x01, 0x00, 0x00, 0xd0, 0xfe, 0x00, 0x04, 0x00, 0x00, 0x79, 0x00}, 12, nil},
+		// Descriptor payloads that look like end tags are skipped
+		{[]byte{0x22, 0x79, 0x00, 0x79, 0x00}, 3, nil},
+		{[]byte{}, 0, errMissingEndTag},
+		{[]byte{0x22, 0x02, 0x00}, 0, errMissingEndTag},
+		{[]byte{0x86, 0x09}, 0, errTruncatedDescriptor},
+	}
+
+	for specIndex, spec := range specs {
+		offset, err := EndTagOffset(spec.template)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if offset != spec.expOffset {
+			t.Errorf("[spec %d] expected end tag offset %d; got %d", specIndex, spec.expOffset, offset)
+		}
+	}
+}
+
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	descriptors := []Descriptor{
+		&IRQ{Mask: 1 << 4, EdgeTriggered: true, ActiveLow: true, WakeCapable: true},
+		&DMA{Channels: 0x02, TransferType: 2, Speed: 3},
+		&StartDependent{Priority: 0, Robustness: 2},
+		&IO{Min: 0x2f8, Max: 0x2f8, Alignment: 1, Length: 8},
+		&EndDependent{},
+		&FixedIO{Base: 0x3c0, Length: 0x20},
+		&FixedDMA{RequestLine: 1, Channel: 2, TransferWidth: 1},
+		&Vendor{Data: []byte{1, 2, 3}},
+		&Vendor{Large: true, Data: bytes.Repeat([]byte{0x55}, 20)},
+		&Memory24{Writable: true, Min: 0xc0000, Max: 0xc8000, Alignment: 0x100, Length: 0x8000},
+		&Memory32{Min: 0x1000, Max: 0x2000, Alignment: 0x1000, Length: 0x1000},
+		&Memory32Fixed{Base: 0xfee00000, Length: 0x1000},
+		&GenericRegister{AddressSpace: 1, BitWidth: 8, Address: 0xb2},
+		&Address{Width: AddressWord, ResourceType: AddressTypeIO, GeneralFlags: 0x0c, TypeSpecificFlags: 0x03, Max: 0xcf7, Length: 0xcf8},
+		&Address{Width: AddressDWord, ResourceType: AddressTypeMemory, Granularity: 0xffffffff, Min: 0xa0000, Max: 0xbffff, Length: 0x20000, ResourceSourceIndex: 1, ResourceSource: `\_SB.PCI0`},
+		&Address{Width: AddressQWord, ResourceType: AddressTypeMemory, Min: 0x100000000, Max: 0x1ffffffff, TranslationOffset: 0x10, Length: 0x100000000},
+		&Address{Width: AddressExtended, ResourceType: AddressTypeMemory, Min: 0x1000, Max: 0x1fff, Length: 0x1000, TypeSpecificAttributes: 0x8},
+		&ExtendedInterrupt{Consumer: true, Interrupts: []uint32{5, 6, 7}, ResourceSourceIndex: 2, ResourceSource: "LNKA"},
+		&Unknown{Name: 0x0d, Data: []byte{0x01}},
+		&Unknown{Large: true, Name: 0x20, Data: []byte{0x01, 0x02}},
+	}
+
+	template, err := Encode(descriptors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []byte{0x79, 0x00}; !bytes.Equal(template[len(template)-2:], exp) {
+		t.Fatalf("expected template to end with %v; got %v", exp, template[len(template)-2:])
+	}
+
+	got, err := Decode(template)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != len(descriptors) {
+		t.Fatalf("expected to decode %d descriptors; got %d", len(descriptors), len(got))
+	}
+
+	for index, exp := range descriptors {
+		if !reflect.DeepEqual(got[index], exp) {
+			t.Errorf("[descriptor %d] expected decoded descriptor to be:\n%#v\ngot:\n%#v", index, exp, got[index])
+		}
+	}
+}
+
+func TestEncode(t *testing.T) {
+	specs := []struct {
+		descriptors []Descriptor
+		exp         []byte
+	}{
+		{
+			nil,
+			[]byte{0x79, 0x00},
+		},
+		{
+			[]Descriptor{&IRQ{Mask: 1 << 1, EdgeTriggered: true}},
+			[]byte{0x23, 0x02, 0x00, 0x01, 0x79, 0x00},
+		},
+		{
+			[]Descriptor{&Memory32Fixed{Writable: true, Base: 0xfed00000, Length: 0x400}},
+			[]byte{0x86, 0x09, 0x00, 0x01, 0x00, 0x00, 0xd0, 0xfe, 0x00, 0x04, 0x00, 0x00, 0x79, 0x00},
+		},
+		{
+			[]Descriptor{&FixedIO{Base: 0xfc60, Length: 1}},
+			[]byte{0x4b, 0x60, 0x00, 0x01, 0x79, 0x00},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		got, err := Encode(spec.descriptors)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if !bytes.Equal(got, spec.exp) {
+			t.Errorf("[spec %d] expected encoded template to be:\n%v\ngot:\n%v", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestEncodeErrors(t *testing.T) {
+	specs := [][]Descriptor{
+		{&Vendor{Data: make([]byte, 8)}},
+		{&Vendor{Large: true, Data: make([]byte, 0x10000)}},
+		{&ExtendedInterrupt{Interrupts: make([]uint32, 256)}},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := Encode(spec); err != errDescriptorTooLarge {
+			t.Errorf("[spec %d] expected to get errDescriptorTooLarge; got %v", specIndex, err)
+		}
+	}
+}
+
+func TestWriteDescriptor(t *testing.T) {
+	specs := []struct {
+		desc Descriptor
+		exp  string
+	}{
+		{&IRQ{Mask: 1 << 9, ActiveLow: true, Shared: true}, "IRQ(mask=0x200 edge=false low=true shared=true wake=false)"},
+		{&DMA{Channels: 0x04, TransferType: 1, BusMaster: true, Speed: 1}, "DMA(channels=0x4 type=1 busmaster=true speed=1)"},
+		{&StartDependent{Priority: 1, Robustness: 2}, "StartDependentFn(priority=1 robustness=2)"},
+		{&EndDependent{}, "EndDependentFn()"},
+		{&IO{Decode16: true, Min: 0x3f8, Max: 0x3f8, Alignment: 1, Length: 8}, "IO(decode16=true min=0x3f8 max=0x3f8 align=0x1 len=0x8)"},
+		{&FixedIO{Base: 0x60, Length: 1}, "FixedIO(base=0x60 len=0x1)"},
+		{&FixedDMA{RequestLine: 3, Channel: 4, TransferWidth: 2}, "FixedDMA(line=3 channel=4 width=2)"},
+		{&Vendor{Large: true, Data: []byte{0xa, 0xbc}}, "Vendor(large=true data=0a,bc)"},
+		{&Memory24{Writable: true, Min: 0x100, Max: 0x200, Alignment: 0x10, Length: 0x100}, "Memory24(rw=true min=0x100 max=0x200 align=0x10 len=0x100)"},
+		{&Memory32{Min: 0x1000, Max: 0x2000, Alignment: 0x1000, Length: 0x1000}, "Memory32(rw=false min=0x1000 max=0x2000 align=0x1000 len=0x1000)"},
+		{&Memory32Fixed{Writable: true, Base: 0xfed00000, Length: 0x400}, "Memory32Fixed(rw=true base=0xfed00000 len=0x400)"},
+		{&GenericRegister{AddressSpace: 1, BitWidth: 8, AccessSize: 1, Address: 0xb2}, "Register(space=1 width=8 offset=0 access=1 addr=0xb2)"},
+		{
+			&Address{Width: AddressWord, ResourceType: AddressTypeBus, GeneralFlags: 0xc, Max: 0xff, Length: 0x100, ResourceSourceIndex: 1, ResourceSource: `\_SB.PCI0`},
+			`WordSpace(type=2 flags=0xc tflags=0x0 gran=0x0 min=0x0 max=0xff xlat=0x0 len=0x100 source=\_SB.PCI0:1)`,
+		},
+		{
+			&Address{Width: AddressExtended, TypeSpecificAttributes: 0x8000},
+			"ExtendedSpace(type=0 flags=0x0 tflags=0x0 gran=0x0 min=0x0 max=0x0 xlat=0x0 len=0x0 attrs=0x8000)",
+		},
+		{&ExtendedInterrupt{Consumer: true, Interrupts: []uint32{9, 10}}, "Interrupt(consumer=true edge=false low=false shared=false wake=false irqs=9,10)"},
+		{&Unknown{Large: true, Name: 0x7f, Data: []byte{1}}, "Unknown(large=true name=0x7f data=01)"},
+	}
+
+	for specIndex, spec := range specs {
+		var buf bytes.Buffer
+		WriteDescriptor(&buf, spec.desc)
+		if got := buf.String(); got != spec.exp {
+			t.Errorf("[spec %d] expected to get:\n%s\ngot:\n%s", specIndex, spec.exp, got)
+		}
+	}
+}
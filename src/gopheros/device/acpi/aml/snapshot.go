@@ -0,0 +1,213 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/kernel/kfmt"
+	"io"
+	"sort"
+)
+
+// snapshotMalformed is appended to the snapshot line of objects whose
+// arguments do not have the expected type.
+const snapshotMalformed = " <malformed>"
+
+// WriteSnapshot serializes the named objects in the tree to a canonical text
+// form and writes it to w. Each line describes a single named object using
+// the format:
+//
+//   <absolute path> <kind>[ <attributes>]
+//
+// Lines are sorted by path and do not include any information that depends
+// on the parse order or the table layout (e.g. object indices and AML
+// offsets). This allows snapshots generated from different firmware versions
+// or by different parser versions to be compared with a line-based diff.
+//
+// Resource templates assigned to _CRS and _PRS objects are decoded and listed
+// descriptor by descriptor. Objects whose arguments are malformed (e.g. due
+// to unresolved references) are tagged with a "<malformed>" attribute instead
+// of aborting the snapshot.
+func (tree *ObjectTree) WriteSnapshot(w io.Writer) {
+	if len(tree.objPool) == 0 {
+		return
+	}
+
+	var (
+		lines   []string
+		lineBuf bytes.Buffer
+		pathBuf bytes.Buffer
+	)
+
+	// The pre-order visitor appends the name of each namespace object to
+	// pathBuf while the post-order visitor restores the path of the
+	// enclosing scope.
+	var pathLens []int
+	tree.Visit(
+		tree.ObjectAt(0),
+		func(obj *Object) VisitAction {
+			pathLens = append(pathLens, pathBuf.Len())
+			tree.appendSnapshotLine(obj, &pathBuf, &lineBuf, &lines)
+			return VisitContinue
+		},
+		func(obj *Object) VisitAction {
+			pathBuf.Truncate(pathLens[len(pathLens)-1])
+			pathLens = pathLens[:len(pathLens)-1]
+			return VisitContinue
+		},
+	)
+	sort.Strings(lines)
+
+	for _, line := range lines {
+		_, _ = io.WriteString(w, line)
+		_, _ = w.Write([]byte{'\n'})
+	}
+}
+
+// appendSnapshotLine appends a snapshot line for obj to lines if obj is a
+// named namespace object. The object name is also appended to pathBuf.
+func (tree *ObjectTree) appendSnapshotLine(obj *Object, pathBuf, lineBuf *bytes.Buffer, lines *[]string) {
+	name := nameOf(obj)
+	if len(name) == 0 || !isNamespaceObject(obj) {
+		return
+	}
+
+	switch {
+	case obj.index == 0:
+		pathBuf.WriteByte('\\')
+	case pathBuf.Len() == 1:
+		pathBuf.Write(name)
+	default:
+		pathBuf.WriteByte('.')
+		pathBuf.Write(name)
+	}
+
+	lineBuf.Reset()
+	lineBuf.Write(pathBuf.Bytes())
+	lineBuf.WriteByte(' ')
+	lineBuf.WriteString(pOpcodeName(obj.opcode))
+	tree.writeSnapshotAttrs(lineBuf, obj)
+	*lines = append(*lines, lineBuf.String())
+}
+
+// writeSnapshotAttrs appends the type-specific attributes for obj to w.
+func (tree *ObjectTree) writeSnapshotAttrs(w *bytes.Buffer, obj *Object) {
+	switch obj.opcode {
+	case pOpMethod:
+		flagsObj := tree.ArgAt(obj, 1)
+		if flagsObj == nil {
+			w.WriteString(snapshotMalformed)
+			return
+		}
+
+		flags, ok := flagsObj.value.(uint64)
+		if !ok {
+			w.WriteString(snapshotMalformed)
+			return
+		}
+		kfmt.Fprintf(w, " args=%d serialized=%t sync=%d", flags&0x7, flags&0x8 != 0, (flags>>4)&0xf)
+	case pOpName:
+		switch string(nameOf(obj)) {
+		case "_CRS", "_PRS":
+			if tree.writeSnapshotResources(w, tree.ArgAt(obj, 1)) {
+				return
+			}
+		}
+		tree.writeSnapshotValue(w, tree.ArgAt(obj, 1))
+	case pOpIntNamedField:
+		field, ok := obj.value.(*fieldElement)
+		if !ok {
+			w.WriteString(snapshotMalformed)
+			return
+		}
+		kfmt.Fprintf(w, " offset=0x%x width=%d access=%d", field.offset, field.width, field.accessType)
+	}
+}
+
+// writeSnapshotResources decodes the resource template stored in the buffer
+// obj and appends its descriptors to w. It returns false if obj is not a
+// buffer containing a valid resource template.
+func (tree *ObjectTree) writeSnapshotResources(w *bytes.Buffer, obj *Object) bool {
+	byteList := tree.bufferBytes(obj)
+	if byteList == nil {
+		return false
+	}
+
+	descriptors, err := resource.Decode(byteList)
+	if err != nil {
+		return false
+	}
+
+	w.WriteString(" = ResourceTemplate {")
+	for i, desc := range descriptors {
+		if i != 0 {
+			w.WriteString("; ")
+		}
+		resource.WriteDescriptor(w, desc)
+	}
+	w.WriteByte('}')
+	return true
+}
+
+// bufferBytes returns the initializer of a Buffer object or nil if obj is
+// not a Buffer with a byte list initializer.
+func (tree *ObjectTree) bufferBytes(obj *Object) []byte {
+	if obj == nil || obj.opcode != pOpBuffer {
+		return nil
+	}
+
+	byteList := tree.ArgAt(obj, 1)
+	if byteList == nil || byteList.opcode != pOpIntByteList {
+		return nil
+	}
+
+	data, _ := byteList.value.([]byte)
+	return data
+}
+
+// writeSnapshotValue appends a description of a data object to w.
+func (tree *ObjectTree) writeSnapshotValue(w *bytes.Buffer, obj *Object) {
+	if obj == nil {
+		return
+	}
+
+	switch obj.opcode {
+	case pOpZero:
+		kfmt.Fprintf(w, " = 0x0")
+	case pOpOne:
+		kfmt.Fprintf(w, " = 0x1")
+	case pOpOnes:
+		kfmt.Fprintf(w, " = Ones")
+	case pOpBytePrefix, pOpWordPrefix, pOpDwordPrefix, pOpQwordPrefix:
+		val, ok := obj.value.(uint64)
+		if !ok {
+			w.WriteString(snapshotMalformed)
+			return
+		}
+		kfmt.Fprintf(w, " = 0x%x", val)
+	case pOpStringPrefix:
+		str, ok := obj.value.([]byte)
+		if !ok {
+			w.WriteString(snapshotMalformed)
+			return
+		}
+		kfmt.Fprintf(w, " = \"%s\"", str)
+	case pOpBuffer:
+		w.WriteString(" = Buffer {")
+		for i, b := range tree.bufferBytes(obj) {
+			if i != 0 {
+				w.WriteByte(',')
+			}
+			kfmt.Fprintf(w, "%2x", b)
+		}
+		w.WriteByte('}')
+	case pOpPackage, pOpVarPackage:
+		kfmt.Fprintf(w, " = %s", pOpcodeName(obj.opcode))
+		if numElements := tree.ArgAt(obj, 0); numElements != nil {
+			if val, ok := numElements.value.(uint64); ok {
+				kfmt.Fprintf(w, "(%d)", val)
+			}
+		}
+	default:
+		kfmt.Fprintf(w, " = %s", pOpcodeName(obj.opcode))
+	}
+}
@@ -0,0 +1,141 @@
+package aml
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestWriteSnapshot(t *testing.T) {
+	snapshot := func(tableFiles ...string) string {
+		resolver := mockResolver{
+			pathToDumps: pkgDir() + "/../table/tabletest/",
+			tableFiles:  tableFiles,
+		}
+
+		tree := NewObjectTree()
+		tree.CreateDefaultScopes(0)
+
+		p := NewParser(ioutil.Discard, tree)
+		for tableIndex, tableFile := range tableFiles {
+			tableName := strings.Replace(tableFile, ".aml", "", -1)
+			if err := p.ParseAML(uint8(tableIndex), tableName, resolver.LookupTable(tableName)); err != nil {
+				t.Fatalf("[%s]: %v", tableName, err)
+			}
+		}
+
+		var buf bytes.Buffer
+		tree.WriteSnapshot(&buf)
+		return buf.String()
+	}
+
+	got := snapshot("DSDT.aml", "SSDT.aml")
+	lines := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+	if !sort.StringsAreSorted(lines) {
+		t.Error("expected snapshot lines to be sorted")
+	}
+
+	specs := []string{
+		`\ ScopeBlock`,
+		`\_SB_ ScopeBlock`,
+		`\_SB_.BUFA Name = Buffer {23,00,80,18,79,00}`,
+		`\_SB_.LNKA Device`,
+		`\_SB_.LNKA._HID Name = 0xf0cd041`,
+		`\_SB_.LNKA._SRS Method args=1 serialized=false sync=0`,
+		`\_S0_ Name = Package(2)`,
+		`\MSWV Name = Ones`,
+		`\DCHR NamedField offset=0x8 width=8 access=1`,
+		`\_SB_.PCI0.SBRG.PIC_._CRS Name = ResourceTemplate {IO(decode16=true min=0x20 max=0x20 align=0x0 len=0x2); IO(decode16=true min=0xa0 max=0xa0 align=0x0 len=0x2); IRQ(mask=0x4 edge=true low=false shared=false wake=false)}`,
+	}
+
+	for specIndex, spec := range specs {
+		if !strings.Contains(got, spec+"\n") {
+			t.Errorf("[spec %d] expected snapshot to contain line %q", specIndex, spec)
+		}
+	}
+
+	if strings.Contains(got, "IndexField") {
+		t.Error("expected snapshot not to include IndexField entries")
+	}
+
+	// Snapshots must be deterministic
+	if got2 := snapshot("DSDT.aml", "SSDT.aml"); got2 != got {
+		t.Error("expected snapshots of the same tables to be identical")
+	}
+
+	var buf bytes.Buffer
+	NewObjectTree().WriteSnapshot(&buf)
+	if buf.Len() != 0 {
+		t.Errorf("expected empty snapshot for an empty tree; got %q", buf.String())
+	}
+}
+
+func TestWriteSnapshotMalformedObjects(t *testing.T) {
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	root := tree.ObjectAt(0)
+
+	newArg := func(opcode uint16, value interface{}) *Object {
+		obj := tree.newObject(opcode, 0)
+		obj.value = value
+		return obj
+	}
+
+	// Method whose flags are not an integer
+	method := tree.newNamedObject(pOpMethod, 0, [amlNameLen]byte{'M', 'T', 'H', '1'})
+	tree.append(method, newArg(pOpIntNamePath, nil))
+	tree.append(method, newArg(pOpStringPrefix, []byte("x")))
+	tree.append(root, method)
+
+	// Method without flags
+	method = tree.newNamedObject(pOpMethod, 0, [amlNameLen]byte{'M', 'T', 'H', '2'})
+	tree.append(root, method)
+
+	// Names whose values do not have the expected type
+	for _, spec := range []struct {
+		name   [amlNameLen]byte
+		opcode uint16
+		value  interface{}
+	}{
+		{[amlNameLen]byte{'I', 'N', 'T', '0'}, pOpDwordPrefix, []byte("x")},
+		{[amlNameLen]byte{'S', 'T', 'R', '0'}, pOpStringPrefix, uint64(1)},
+	} {
+		name := tree.newNamedObject(pOpName, 0, spec.name)
+		tree.append(name, newArg(pOpIntNamePath, nil))
+		tree.append(name, newArg(spec.opcode, spec.value))
+		tree.append(root, name)
+	}
+
+	// Field element without field info
+	tree.append(root, tree.newNamedObject(pOpIntNamedField, 0, [amlNameLen]byte{'F', 'L', 'D', '0'}))
+
+	// _CRS buffer that does not contain a valid resource template
+	crs := tree.newNamedObject(pOpName, 0, [amlNameLen]byte{'_', 'C', 'R', 'S'})
+	buf := newArg(pOpBuffer, nil)
+	tree.append(buf, newArg(pOpBytePrefix, uint64(2)))
+	tree.append(buf, newArg(pOpIntByteList, []byte{0x22, 0x02}))
+	tree.append(crs, newArg(pOpIntNamePath, nil))
+	tree.append(crs, buf)
+	tree.append(root, crs)
+
+	var out bytes.Buffer
+	tree.WriteSnapshot(&out)
+	got := out.String()
+
+	specs := []string{
+		`\MTH1 Method <malformed>`,
+		`\MTH2 Method <malformed>`,
+		`\INT0 Name <malformed>`,
+		`\STR0 Name <malformed>`,
+		`\FLD0 NamedField <malformed>`,
+		`\_CRS Name = Buffer {22,02}`,
+	}
+
+	for specIndex, spec := range specs {
+		if !strings.Contains(got, spec+"\n") {
+			t.Errorf("[spec %d] expected snapshot to contain line %q; got:\n%s", specIndex, spec, got)
+		}
+	}
+}
@@ -2,20 +2,37 @@ package aml
 
 import (
 	"gopheros/kernel"
+	"io"
 	"reflect"
 	"unsafe"
 )
 
+// streamWindowSize is the number of bytes that a streaming amlStreamReader
+// buffers from its data source.
+const streamWindowSize = 4096
+
 var (
-	errInvalidUnreadByte = &kernel.Error{Module: "acpi_aml_parser", Message: "bad call to UnreadByte; stream offset is 0"}
-	errInvalidPkgEnd     = &kernel.Error{Module: "acpi_aml_parser", Message: "attempted to set pkgEnd past the end of the stream"}
-	errReadPastPkgEnd    = &kernel.Error{Module: "acpi_aml_parser", Message: "attempted to read past pkgEnd"}
+	errInvalidUnreadByte = &kernel.Error{Module: "acpi_aml_parser", Message: "bad call to UnreadByte; stream offset is 0", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidPkgEnd     = &kernel.Error{Module: "acpi_aml_parser", Message: "attempted to set pkgEnd past the end of the stream", Code: kernel.ErrCodeInvalidArgument}
+	errReadPastPkgEnd    = &kernel.Error{Module: "acpi_aml_parser", Message: "attempted to read past pkgEnd", Code: kernel.ErrCodeInvalidArgument}
+	errStreamRead        = &kernel.Error{Module: "acpi_aml_parser", Message: "failed to read AML stream contents from source", Code: kernel.ErrCodeCorrupted}
 )
 
+// amlStreamReader provides byte-level access to an AML stream. The stream
+// contents are either accessed directly via a byte slice that overlays the
+// table contents or, when operating in streaming mode, via a fixed-size window
+// that is refilled from an io.ReaderAt as the read offset moves around.
 type amlStreamReader struct {
-	offset uint32
-	data   []byte
-	pkgEnd uint32
+	offset  uint32
+	data    []byte
+	dataLen uint32
+	pkgEnd  uint32
+
+	// Streaming mode state
+	src         io.ReaderAt
+	window      []byte
+	windowStart uint32
+	windowLen   uint32
 }
 
 // Init sets up the reader so it can read up to dataLen bytes from the virtual
@@ -29,17 +46,87 @@ func (r *amlStreamReader) Init(dataAddr uintptr, dataLen, initialOffset uint32)
 		Data: dataAddr,
 	}))
 
+	r.dataLen = dataLen
+	r.src = nil
+	r.window = nil
+
 	r.SetPkgEnd(dataLen)
 	r.SetOffset(initialOffset)
 }
 
+// InitStream sets up the reader so it can read up to dataLen bytes from src
+// while only keeping a small window of the stream contents in memory. If a
+// non-zero initialOffset is specified, it will be used as the current offset
+// in the stream.
+func (r *amlStreamReader) InitStream(src io.ReaderAt, dataLen, initialOffset uint32) {
+	r.data = nil
+	r.dataLen = dataLen
+	r.src = src
+	if r.window == nil {
+		r.window = make([]byte, streamWindowSize)
+	}
+	r.windowStart, r.windowLen = 0, 0
+
+	r.SetPkgEnd(dataLen)
+	r.SetOffset(initialOffset)
+}
+
+// byteAt returns the byte at the specified stream offset. In streaming mode,
+// the window is refilled if off lies outside of it.
+func (r *amlStreamReader) byteAt(off uint32) (byte, error) {
+	if r.src == nil {
+		return r.data[off], nil
+	}
+
+	if off < r.windowStart || off >= r.windowStart+r.windowLen {
+		windowLen := r.dataLen - off
+		if windowLen > uint32(len(r.window)) {
+			windowLen = uint32(len(r.window))
+		}
+
+		// ReadAt may return io.EOF together with a full read when the
+		// window extends to the end of the source so only the number of
+		// bytes read is checked here.
+		if n, _ := r.src.ReadAt(r.window[:windowLen], int64(off)); uint32(n) != windowLen {
+			r.windowLen = 0
+			return 0, errStreamRead
+		}
+
+		r.windowStart, r.windowLen = off, windowLen
+	}
+
+	return r.window[off-r.windowStart], nil
+}
+
+// Bytes returns a byte slice with the length bytes starting at stream offset
+// start. When the reader operates on an in-memory stream, the returned slice
+// overlays the stream contents; in streaming mode the contents are copied
+// into a new slice. Bytes returns nil if the requested range exceeds the
+// stream length or cannot be read.
+func (r *amlStreamReader) Bytes(start, length uint32) []byte {
+	if start+length > r.dataLen || start+length < start {
+		return nil
+	}
+
+	if r.src == nil {
+		return r.data[start : start+length : start+length]
+	}
+
+	buf := make([]byte, length)
+	if n, _ := r.src.ReadAt(buf, int64(start)); uint32(n) != length {
+		return nil
+	}
+
+	return buf
+}
+
 // EOF returns true if the end of the  pkg has been reached.
 func (r *amlStreamReader) EOF() bool {
 	return r.offset >= r.pkgEnd
 }
 
 func (r *amlStreamReader) SetPkgEnd(pkgEnd uint32) error {
-	if pkgEnd > uint32(len(r.data)) {
+	if pkgEnd > r.dataLen {
 		return errInvalidPkgEnd
 	}
 
@@ -53,8 +140,13 @@ func (r *amlStreamReader) ReadByte() (byte, error) {
 		return 0, errReadPastPkgEnd
 	}
 
+	next, err := r.byteAt(r.offset)
+	if err != nil {
+		return 0, err
+	}
+
 	r.offset++
-	return r.data[r.offset-1], nil
+	return next, nil
 }
 
 // PeekByte returns the next byte from the stream without advancing the read pointer.
@@ -63,7 +155,7 @@ func (r *amlStreamReader) PeekByte() (byte, error) {
 		return 0, errReadPastPkgEnd
 	}
 
-	return r.data[r.offset], nil
+	return r.byteAt(r.offset)
 }
 
 // LastByte returns the last byte read off the stream
@@ -72,7 +164,7 @@ func (r *amlStreamReader) LastByte() (byte, error) {
 		return 0, errReadPastPkgEnd
 	}
 
-	return r.data[r.offset-1], nil
+	return r.byteAt(r.offset - 1)
 }
 
 // UnreadByte moves back the read pointer by one byte.
@@ -90,9 +182,11 @@ func (r *amlStreamReader) Offset() uint32 {
 	return r.offset
 }
 
-// DataPtr returns a pointer to the stream contents at the current stream offset.
+// DataPtr returns a pointer to the stream contents at the current stream
+// offset. As the stream contents are not addressable in streaming mode,
+// DataPtr always returns 0 in that case.
 func (r *amlStreamReader) DataPtr() uintptr {
-	if r.EOF() {
+	if r.EOF() || r.src != nil {
 		return 0
 	}
 	return uintptr(unsafe.Pointer(&r.data[r.offset]))
@@ -100,7 +194,7 @@ func (r *amlStreamReader) DataPtr() uintptr {
 
 // SetOffset sets the reader offset to the supplied value.
 func (r *amlStreamReader) SetOffset(off uint32) {
-	if max := uint32(len(r.data)); off > max {
+	if max := r.dataLen; off > max {
 		off = max
 	}
 	r.offset = off
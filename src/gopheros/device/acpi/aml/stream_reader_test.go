@@ -1,6 +1,8 @@
 package aml
 
 import (
+	"bytes"
+	"io"
 	"math"
 	"testing"
 	"unsafe"
@@ -128,4 +130,84 @@ func TestAMLStreamReader(t *testing.T) {
 			t.Fatal("expected DataPtr to return a pointer to buf[2]")
 		}
 	})
+
+	t.Run("streaming", func(t *testing.T) {
+		src := &countingReaderAt{data: make([]byte, 2*streamWindowSize+16)}
+		for i := 0; i < len(src.data); i++ {
+			src.data[i] = byte(i)
+		}
+
+		var r amlStreamReader
+		r.InitStream(src, uint32(len(src.data)), 4)
+
+		if ptr := r.DataPtr(); ptr != 0 {
+			t.Fatal("expected DataPtr to return 0 in streaming mode")
+		}
+
+		for i := 4; i < len(src.data); i++ {
+			next, err := r.ReadByte()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exp := byte(i); next != exp {
+				t.Fatalf("expected ReadByte to return %d; got %d", exp, next)
+			}
+		}
+
+		// Reading sequentially should only refill the window when the
+		// read offset moves past its end.
+		if exp := 3; src.reads != exp {
+			t.Fatalf("expected %d reads from the source; got %d", exp, src.reads)
+		}
+
+		// Moving back into a previous window should trigger a refill
+		r.SetOffset(2)
+		if last, err := r.LastByte(); err != nil || last != 1 {
+			t.Fatalf("expected LastByte to return 1; got %d, %v", last, err)
+		}
+
+		got := r.Bytes(2, 3)
+		if exp := []byte{2, 3, 4}; !bytes.Equal(got, exp) {
+			t.Fatalf("expected Bytes to return %v; got %v", exp, got)
+		}
+
+		// The returned slice should be a copy of the stream contents
+		src.data[2] = 0xff
+		if got[0] != 2 {
+			t.Fatal("expected Bytes to return a copy of the stream contents in streaming mode")
+		}
+
+		if got := r.Bytes(uint32(len(src.data)-1), 2); got != nil {
+			t.Fatalf("expected Bytes to return nil for a range past the end of the stream; got %v", got)
+		}
+
+		// Source read errors should be reported
+		r.SetOffset(uint32(len(src.data) - 1))
+		src.data = src.data[:len(src.data)-1]
+		r.windowLen = 0
+		if _, err := r.PeekByte(); err != errStreamRead {
+			t.Fatalf("expected to get errStreamRead; got %v", err)
+		}
+	})
+}
+
+// countingReaderAt is an io.ReaderAt over a byte slice that keeps track of the
+// number of ReadAt calls.
+type countingReaderAt struct {
+	data  []byte
+	reads int
+}
+
+func (r *countingReaderAt) ReadAt(buf []byte, off int64) (int, error) {
+	r.reads++
+	if off >= int64(len(r.data)) {
+		return 0, io.EOF
+	}
+
+	n := copy(buf, r.data[off:])
+	if n < len(buf) {
+		return n, io.EOF
+	}
+
+	return n, nil
 }
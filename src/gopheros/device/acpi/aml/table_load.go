@@ -0,0 +1,80 @@
+package aml
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+// TableLoadError describes a definition block that could not be loaded by
+// LoadDefinitionBlocks.
+type TableLoadError struct {
+	// The position of the table in the list passed to
+	// LoadDefinitionBlocks and the table header.
+	Index  int
+	Header *table.SDTHeader
+
+	// The error reported by the parser.
+	Err *kernel.Error
+}
+
+// LoadDefinitionBlocks parses the supplied definition blocks (typically the
+// DSDT followed by all SSDTs in the order they appear in the XSDT/RSDT) into
+// tree so that they get merged into a single namespace. The tree must
+// already contain the default scopes. Each table is tagged with a unique
+// handle starting from 1.
+//
+// Loading errors are isolated to the table that caused them: if a table
+// cannot be parsed, any objects it defined are discarded from the tree and
+// loading resumes with the next table. The function returns a list of the
+// tables that could not be loaded; each failure is also reported to
+// errWriter.
+func LoadDefinitionBlocks(errWriter io.Writer, tree *ObjectTree, headers []*table.SDTHeader) []TableLoadError {
+	var (
+		loadErrors []TableLoadError
+		parser     = NewParser(errWriter, tree)
+	)
+
+	for index, header := range headers {
+		tableHandle := uint8(index + 1)
+		tableName := string(header.Signature[:])
+		if err := parser.ParseAML(tableHandle, tableName, header); err != nil {
+			kfmt.Fprintf(errWriter, "[table: %s, oem table ID: %s] failed to load: %s; skipping\n", tableName, string(header.OEMTableID[:]), err.Error())
+			tree.discardTable(tableHandle)
+			loadErrors = append(loadErrors, TableLoadError{Index: index, Header: header, Err: err})
+		}
+	}
+
+	return loadErrors
+}
+
+// discardTable frees all objects that are tagged with tableHandle and
+// rebuilds the tree namespace. It is used for undoing the effects of a table
+// that could not be parsed.
+func (tree *ObjectTree) discardTable(tableHandle uint8) {
+	if root := tree.ObjectAt(0); root != nil {
+		tree.discardTableObjects(root, tableHandle)
+	}
+	tree.pruneForwardRefs()
+
+	if tree.namespace != nil {
+		tree.namespace.populate()
+	}
+}
+
+// discardTableObjects frees the args of obj that are tagged with tableHandle
+// and recursively scans the remaining args for such objects.
+func (tree *ObjectTree) discardTableObjects(obj *Object, tableHandle uint8) {
+	for argIndex := obj.firstArgIndex; argIndex != InvalidIndex; {
+		argObj := tree.ObjectAt(argIndex)
+		argIndex = argObj.nextSiblingIndex
+
+		if argObj.tableHandle == tableHandle {
+			tree.freeTree(argObj)
+			continue
+		}
+
+		tree.discardTableObjects(argObj, tableHandle)
+	}
+}
@@ -0,0 +1,82 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/table"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestLoadDefinitionBlocks(t *testing.T) {
+	pathToDumps := pkgDir() + "/../table/tabletest/"
+	readTable := func(name string) *table.SDTHeader {
+		data, err := ioutil.ReadFile(filepath.Join(pathToDumps, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	}
+
+	// A table that defines \_SB.BRKN and then refers to a missing scope
+	brokenSSDT := amltest.TableFor("SSDT", []byte{
+		0x10, 0x0c, '\\', '_', 'S', 'B', '_', // Scope(\_SB)
+		0x08, 'B', 'R', 'K', 'N', 0x01, //         Name(BRKN, One)
+		0x10, 0x06, '\\', 'N', 'O', 'P', 'E', // Scope(\NOPE)
+	})
+
+	dsdt, ssdt := readTable("DSDT.aml"), readTable("SSDT.aml")
+
+	// Generate the expected namespace contents by parsing the valid tables
+	expTree := NewObjectTree()
+	expTree.CreateDefaultScopes(0)
+	p := NewParser(&testWriter{t: t}, expTree)
+	for tableIndex, header := range []*table.SDTHeader{dsdt, ssdt} {
+		if err := p.ParseAML(uint8(tableIndex+1), string(header.Signature[:]), header); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var expSnapshot bytes.Buffer
+	expTree.WriteSnapshot(&expSnapshot)
+
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	loadErrors := LoadDefinitionBlocks(&testWriter{t: t}, tree, []*table.SDTHeader{dsdt, brokenSSDT, ssdt})
+
+	if len(loadErrors) != 1 {
+		t.Fatalf("expected to get 1 load error; got %d", len(loadErrors))
+	}
+
+	if loadErrors[0].Index != 1 || loadErrors[0].Header != brokenSSDT || loadErrors[0].Err == nil {
+		t.Fatalf("expected load error to refer to the broken SSDT; got %+v", loadErrors[0])
+	}
+
+	var snapshot bytes.Buffer
+	tree.WriteSnapshot(&snapshot)
+
+	if strings.Contains(snapshot.String(), "BRKN") {
+		t.Fatal("expected the objects defined by the broken SSDT to be discarded")
+	}
+
+	for _, obj := range tree.objPool {
+		if obj.tableHandle == 2 && obj.opcode != pOpIntFreedObject {
+			t.Fatalf("expected object %d defined by the broken SSDT to be freed", obj.index)
+		}
+	}
+
+	if exp, got := expSnapshot.String(), snapshot.String(); got != exp {
+		t.Fatalf("expected namespace snapshot to be:\n%s\ngot:\n%s", exp, got)
+	}
+
+	if revision, ok := tree.DSDTRevision(); !ok || revision != dsdt.Revision {
+		t.Fatalf("expected DSDT revision to be %d; got %d", dsdt.Revision, revision)
+	}
+
+	if tree.Namespace().Lookup(nil, `\_PR_.CPU0`) == nil {
+		t.Fatal("expected namespace to include the objects defined by the SSDT")
+	}
+}
@@ -0,0 +1,612 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/convert"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/sync"
+	"io"
+)
+
+var (
+	errNilObjectTree       = &kernel.Error{Module: "acpi_aml_vm", Message: "object tree is nil", Code: kernel.ErrCodeInvalidArgument}
+	errPathNotFound        = &kernel.Error{Module: "acpi_aml_vm", Message: "could not resolve namespace path", Code: kernel.ErrCodeNotFound}
+	errArgCountMismatch    = &kernel.Error{Module: "acpi_aml_vm", Message: "argument count does not match method definition", Code: kernel.ErrCodeInvalidArgument}
+	errUnsupportedArgType  = &kernel.Error{Module: "acpi_aml_vm", Message: "unsupported method argument type", Code: kernel.ErrCodeInvalidArgument}
+	errUnsupportedOpcode   = &kernel.Error{Module: "acpi_aml_vm", Message: "unsupported AML opcode", Code: kernel.ErrCodeNotSupported}
+	errUninitializedValue  = &kernel.Error{Module: "acpi_aml_vm", Message: "access to uninitialized local or method arg", Code: kernel.ErrCodeInvalidArgument}
+	errConversionFailed    = convert.ErrConversionFailed
+	errDivideByZero        = &kernel.Error{Module: "acpi_aml_vm", Message: "divide by zero", Code: kernel.ErrCodeInvalidArgument}
+	errIndexOutOfBounds    = &kernel.Error{Module: "acpi_aml_vm", Message: "index out of bounds", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidStoreTarget  = &kernel.Error{Module: "acpi_aml_vm", Message: "invalid store target", Code: kernel.ErrCodeInvalidArgument}
+	errMaxCallDepthReached = &kernel.Error{Module: "acpi_aml_vm", Message: "maximum method call depth reached", Code: kernel.ErrCodeFault}
+	errMalformedObject     = &kernel.Error{Module: "acpi_aml_vm", Message: "malformed AML object", Code: kernel.ErrCodeCorrupted}
+	errInvalidMatchOp      = &kernel.Error{Module: "acpi_aml_vm", Message: "invalid Match comparison operator", Code: kernel.ErrCodeInvalidArgument}
+)
+
+const (
+	// The maximum number of local and method args supported by AML.
+	maxLocalArgs  = 8
+	maxMethodArgs = 7
+
+	// vmRevision is the value returned by the AML Revision opcode.
+	vmRevision = uint64(1)
+)
+
+// ctrlFlowType describes the different ways that the control flow can be
+// altered while executing a set of AML opcodes.
+type ctrlFlowType uint8
+
+// The list of supported control flows.
+const (
+	ctrlFlowTypeNextOpcode ctrlFlowType = iota
+	ctrlFlowTypeBreak
+	ctrlFlowTypeContinue
+	ctrlFlowTypeFnReturn
+)
+
+// execContext holds the AML interpreter state for an executing method.
+type execContext struct {
+	localArg  [maxLocalArgs]interface{}
+	methodArg [maxMethodArgs]interface{}
+
+	// The index of the namespace object (typically the method being
+	// executed) that serves as the starting point for resolving relative
+	// name paths.
+	scopeIndex uint32
+
+	// retVal holds the value produced by the last executed expression
+	// opcode or the value passed to a Return opcode.
+	retVal interface{}
+
+	// storeResult, if set by the target of a Store opcode, overrides the
+	// value returned by the Store. It is used by SMBus and
+	// GenericSerialBus field writes which return the transaction status.
+	storeResult interface{}
+
+	ctrlFlow ctrlFlowType
+
+	// The number of nested method invocations that led to this context.
+	depth uint32
+
+	// The thread that this context belongs to. All contexts created while
+	// servicing a VM.Evaluate call share the same thread.
+	thread *execThread
+
+	// The Buffers and Packages that this context holds a reference to.
+	// The references are dropped when the method executed by the context
+	// returns.
+	owned []interface{}
+}
+
+// opHandler is a function that implements an AML opcode. Handlers for
+// opcodes that produce a value store it in ctx.retVal.
+type opHandler func(vm *VM, ctx *execContext, obj *Object) *kernel.Error
+
+// VM implements an interpreter for AML control methods. It operates on the
+// objects of an ObjectTree populated by the AML parser.
+//
+// Values produced and consumed by the VM are represented using the following
+// Go types:
+//  - Integer: uint64
+//  - String: string
+//  - Buffer: []byte
+//  - Package: []interface{}
+//  - References to named objects (e.g. Devices or Mutexes): *Object
+//  - References created via RefOf/Index: *Reference
+type VM struct {
+	tree      *ObjectTree
+	errWriter io.Writer
+
+	// namedValues holds the run-time values of Name objects keyed by the
+	// object index. Values are populated lazily the first time that a
+	// Name object is accessed.
+	namedValues map[uint32]interface{}
+
+	// regions caches the evaluated OperationRegion objects keyed by the
+	// object index while regionHandlers holds the handlers that provide
+	// access to each region address space.
+	regions        map[uint32]*Region
+	regionHandlers map[RegionSpace]RegionHandler
+
+	// serialBusHandlers holds the handlers that service accesses to fields
+	// in SMBus and GenericSerialBus regions.
+	serialBusHandlers map[RegionSpace]SerialBusHandler
+
+	// globalLock is held while accessing fields declared with the Lock
+	// flag.
+	globalLock sync.Locker
+
+	// mutexes holds the run-time state of Mutex objects and the implicit
+	// mutexes of serialized methods keyed by the object index.
+	mutexes map[uint32]*amlMutex
+
+	// bufferFields holds the run-time state of the BufferFields created
+	// by the CreateXField opcodes keyed by the object index.
+	bufferFields map[uint32]*bufferField
+
+	// events holds the pending signal counts for Event objects keyed by
+	// the object index.
+	events map[uint32]*sync.Semaphore
+
+	// sleepFn, if set, is used for suspending method execution.
+	sleepFn func(milliseconds uint64)
+
+	// The interface strings reported as supported by the builtin _OSI
+	// method and an optional handler that overrides them.
+	osiInterfaces map[string]struct{}
+	osiHandler    OSIHandler
+
+	// The resolver used by LoadTable for locating tables and the handler
+	// that gets notified when a table is dynamically loaded.
+	tableResolver    table.Resolver
+	tableLoadHandler TableLoadHandler
+
+	// loadedTables holds the headers of dynamically loaded tables keyed
+	// by their table handle.
+	loadedTables map[uint8]*table.SDTHeader
+
+	// notifyHandlers holds the handlers installed for Device, Processor
+	// and ThermalZone objects keyed by the object index while
+	// pendingNotifications holds the notifications raised by the Notify
+	// opcode that have not yet been delivered.
+	notifyLock           sync.Spinlock
+	notifyHandlers       map[uint32]NotifyHandler
+	pendingNotifications []notification
+
+	// implicitReturn enables the implicit return quirk. See
+	// SetImplicitReturn for more details.
+	implicitReturn bool
+
+	// limits bounds the work performed by each Evaluate call. See
+	// SetExecLimits for more details.
+	limits ExecLimits
+
+	// intWidth is the width of the Integers processed by the VM. See
+	// SetIntegerWidth for more details.
+	intWidth convert.Width
+
+	// debugOutput controls whether stores to the Debug object generate
+	// any output while debugWriter specifies where the output is sent.
+	debugOutput bool
+	debugWriter io.Writer
+
+	// tracer, if set, is notified about each executed opcode.
+	tracer Tracer
+
+	// heap manages the lifetime of the Buffers and Packages created while
+	// executing methods.
+	heap *valueHeap
+
+	jumpTable []opHandler
+}
+
+// NewVM creates a new AML VM for executing the methods contained in tree. Any
+// execution errors will be logged to errWriter.
+func NewVM(errWriter io.Writer, tree *ObjectTree) *VM {
+	vm := &VM{
+		tree:              tree,
+		errWriter:         errWriter,
+		namedValues:       make(map[uint32]interface{}),
+		regions:           make(map[uint32]*Region),
+		regionHandlers:    make(map[RegionSpace]RegionHandler),
+		serialBusHandlers: make(map[RegionSpace]SerialBusHandler),
+		globalLock:        &sync.Mutex{},
+		mutexes:           make(map[uint32]*amlMutex),
+		bufferFields:      make(map[uint32]*bufferField),
+		events:            make(map[uint32]*sync.Semaphore),
+		loadedTables:      make(map[uint8]*table.SDTHeader),
+		notifyHandlers:    make(map[uint32]NotifyHandler),
+		implicitReturn:    true,
+		limits:            DefaultExecLimits,
+		intWidth:          convert.Width64,
+		heap:              newValueHeap(),
+		jumpTable:         make([]opHandler, len(pOpcodeTable)),
+	}
+	vm.populateJumpTable()
+	vm.SetOSIInterfaces(DefaultOSIInterfaces...)
+
+	if tree != nil {
+		if revision, ok := tree.DSDTRevision(); ok {
+			vm.intWidth = convert.WidthForRevision(revision)
+		}
+	}
+	return vm
+}
+
+// SetIntegerWidth overrides the width of the Integers processed by the VM. By
+// default, the VM uses 32-bit Integers if the revision of the DSDT parsed
+// into its object tree is lower than 2 and 64-bit Integers otherwise. All
+// Integer constants, arithmetic results and conversions are truncated to the
+// selected width and the Ones/True values become 0xFFFFFFFF in 32-bit mode.
+func (vm *VM) SetIntegerWidth(width convert.Width) {
+	vm.intWidth = width
+}
+
+// IntegerWidth returns the width of the Integers processed by the VM.
+func (vm *VM) IntegerWidth() convert.Width {
+	return vm.intWidth
+}
+
+// SetImplicitReturn controls whether the VM implements the implicit return
+// quirk. Firmware written against the Windows AML interpreter often relies on
+// methods without an explicit Return returning the value produced by the last
+// executed expression. When the quirk is enabled (the default), methods that
+// finish without executing a Return opcode return that value instead of nil.
+func (vm *VM) SetImplicitReturn(enabled bool) {
+	vm.implicitReturn = enabled
+}
+
+// Evaluate resolves the namespace object at the given path and evaluates it.
+// If the object is a Method, it will be invoked with the supplied arguments
+// and its return value (nil if the method does not return a value) will be
+// returned to the caller. If the object is a Name, its current value will be
+// returned instead. For any other named object, Evaluate returns a pointer to
+// the Object itself.
+//
+// The path may be specified either in its raw AML form (e.g. `\_SB_PCI0_STA`)
+// or using dots to separate name segments (e.g. `\_SB.PCI0._STA`). Relative
+// paths are resolved from the root scope.
+//
+// Method arguments may be specified using any Go integer type, bool, string,
+// []byte or []interface{}.
+func (vm *VM) Evaluate(path string, args ...interface{}) (interface{}, *kernel.Error) {
+	if vm.tree == nil {
+		return nil, errNilObjectTree
+	}
+
+	node := vm.tree.Namespace().Lookup(nil, path)
+	if node == nil {
+		return nil, errPathNotFound
+	}
+	obj := node.Object()
+
+	thread := &execThread{}
+	defer releaseAllMutexes(thread)
+
+	if obj.opcode != pOpMethod {
+		if len(args) != 0 {
+			return nil, errArgCountMismatch
+		}
+		return vm.readNamedObject(&execContext{scopeIndex: obj.index, thread: thread}, obj)
+	}
+
+	// The returned value escapes the VM; its storage must not be recycled
+	// once the context that receives it gets released.
+	ctx := &execContext{scopeIndex: obj.index, thread: thread}
+	defer vm.releaseOwned(ctx, nil, nil)
+
+	var (
+		methodArgs [maxMethodArgs]interface{}
+		err        *kernel.Error
+	)
+
+	if len(args) > maxMethodArgs {
+		return nil, errArgCountMismatch
+	}
+
+	for argIndex, arg := range args {
+		if methodArgs[argIndex], err = vm.valueFromGo(arg); err != nil {
+			return nil, err
+		}
+	}
+
+	retVal, err := vm.invokeMethod(ctx, obj, methodArgs[:len(args)])
+	vm.escape(retVal)
+	return retVal, err
+}
+
+// normalizePath converts a dot-separated namespace path into the raw AML path
+// format expected by ObjectTree.Find by padding each name segment to
+// amlNameLen characters using '_'.
+func normalizePath(path string) []byte {
+	var (
+		out    = make([]byte, 0, len(path)+amlNameLen)
+		segLen int
+	)
+
+	for i := 0; i < len(path); i++ {
+		switch ch := path[i]; ch {
+		case '\\', '^':
+			out = append(out, ch)
+		case '.':
+			for ; segLen > 0 && segLen < amlNameLen; segLen++ {
+				out = append(out, '_')
+			}
+			segLen = 0
+		default:
+			out = append(out, ch)
+			segLen++
+		}
+	}
+
+	for ; segLen > 0 && segLen < amlNameLen; segLen++ {
+		out = append(out, '_')
+	}
+
+	return out
+}
+
+// valueFromGo converts a Go value into a value that can be used by the VM.
+// Integers are truncated to the Integer width of the VM.
+func (vm *VM) valueFromGo(v interface{}) (interface{}, *kernel.Error) {
+	switch typ := v.(type) {
+	case bool:
+		return vm.boolToInt(typ), nil
+	case uint8:
+		return uint64(typ), nil
+	case uint16:
+		return uint64(typ), nil
+	case uint32:
+		return uint64(typ), nil
+	case uint64:
+		return vm.intWidth.Truncate(typ), nil
+	case uint:
+		return vm.intWidth.Truncate(uint64(typ)), nil
+	case int8:
+		return vm.intWidth.Truncate(uint64(typ)), nil
+	case int16:
+		return vm.intWidth.Truncate(uint64(typ)), nil
+	case int32:
+		return vm.intWidth.Truncate(uint64(typ)), nil
+	case int64:
+		return vm.intWidth.Truncate(uint64(typ)), nil
+	case int:
+		return vm.intWidth.Truncate(uint64(typ)), nil
+	case string:
+		return typ, nil
+	case []byte:
+		return copyValue(typ), nil
+	case []interface{}:
+		pkg := make([]interface{}, len(typ))
+		for i, elem := range typ {
+			var err *kernel.Error
+			if pkg[i], err = vm.valueFromGo(elem); err != nil {
+				return nil, err
+			}
+		}
+		return pkg, nil
+	case *Object:
+		return typ, nil
+	default:
+		return nil, errUnsupportedArgType
+	}
+}
+
+// invokeMethod executes the supplied method object using args as the method
+// arguments and returns the method's return value.
+func (vm *VM) invokeMethod(caller *execContext, method *Object, args []interface{}) (interface{}, *kernel.Error) {
+	if limit := vm.limits.MaxCallDepth; limit != 0 && caller.depth >= limit {
+		return nil, vm.fail(method, errMaxCallDepthReached)
+	}
+
+	flagsObj := vm.tree.ArgAt(method, 1)
+	bodyObj := vm.tree.ArgAt(method, 2)
+	if flagsObj == nil || bodyObj == nil {
+		return nil, vm.fail(method, errMalformedObject)
+	}
+
+	flags, _ := flagsObj.value.(uint64)
+	if uint64(len(args)) != flags&0x7 {
+		return nil, vm.fail(method, errArgCountMismatch)
+	}
+
+	if _, builtin := method.value.(builtinMethodMarker); builtin {
+		return vm.invokeBuiltinMethod(method, args)
+	}
+
+	ctx := &execContext{
+		scopeIndex: method.index,
+		depth:      caller.depth + 1,
+		thread:     caller.thread,
+	}
+	copy(ctx.methodArg[:], args)
+
+	// Serialized methods may create named objects so only one thread at
+	// a time is allowed to execute them.
+	if flags&0x8 != 0 && ctx.thread != nil {
+		mutex, err := vm.enterSerializedMethod(ctx.thread, method, uint8(flags>>4)&0xf)
+		if err != nil {
+			return nil, err
+		}
+		defer exitSerializedMethod(ctx.thread, mutex)
+	}
+
+	err := vm.execBlock(ctx, bodyObj)
+
+	// Unless the implicit return quirk is enabled, methods that do not
+	// execute a Return opcode do not return a value.
+	var retVal interface{}
+	if err == nil && (ctx.ctrlFlow == ctrlFlowTypeFnReturn || vm.implicitReturn) {
+		retVal = ctx.retVal
+	}
+
+	// Release any objects created by the method; the reference to the
+	// return value is transferred to the caller.
+	vm.releaseOwned(ctx, caller, retVal)
+
+	if err != nil {
+		return nil, err
+	}
+
+	return retVal, nil
+}
+
+// execBlock sequentially executes the opcodes contained in block until either
+// an error occurs or the control flow is altered by a Break, Continue or
+// Return opcode.
+func (vm *VM) execBlock(ctx *execContext, block *Object) *kernel.Error {
+	for index := block.firstArgIndex; index != InvalidIndex; {
+		obj := vm.tree.ObjectAt(index)
+		if err := vm.execOpcode(ctx, obj); err != nil {
+			return err
+		}
+
+		if ctx.ctrlFlow != ctrlFlowTypeNextOpcode {
+			return nil
+		}
+
+		index = obj.nextSiblingIndex
+	}
+
+	return nil
+}
+
+// execOpcode executes the handler for the opcode represented by obj.
+func (vm *VM) execOpcode(ctx *execContext, obj *Object) *kernel.Error {
+	handler := vm.jumpTable[obj.infoIndex]
+	if handler == nil {
+		return vm.fail(obj, errUnsupportedOpcode)
+	}
+
+	if err := vm.countOp(ctx, obj); err != nil {
+		return err
+	}
+
+	if vm.tracer != nil {
+		return vm.traceOpcode(ctx, obj, handler)
+	}
+
+	return handler(vm, ctx, obj)
+}
+
+// evalArg evaluates the arg of obj located at argIndex and returns its value.
+func (vm *VM) evalArg(ctx *execContext, obj *Object, argIndex uint32) (interface{}, *kernel.Error) {
+	argObj := vm.tree.ArgAt(obj, argIndex)
+	if argObj == nil {
+		return nil, vm.fail(obj, errMalformedObject)
+	}
+
+	val, err := vm.eval(ctx, argObj)
+	if err == nil && vm.tracer != nil {
+		vm.traceArg(ctx, val)
+	}
+
+	return val, err
+}
+
+// eval evaluates the expression represented by obj and returns its value.
+func (vm *VM) eval(ctx *execContext, obj *Object) (interface{}, *kernel.Error) {
+	switch {
+	case obj.opcode == pOpZero:
+		return uint64(0), nil
+	case obj.opcode == pOpOne:
+		return uint64(1), nil
+	case obj.opcode == pOpOnes:
+		return vm.intWidth.Ones(), nil
+	case obj.opcode == pOpRevision:
+		return vmRevision, nil
+	case obj.opcode == pOpStringPrefix:
+		str, _ := obj.value.([]byte)
+		return string(str), nil
+	case obj.opcode == pOpBytePrefix, obj.opcode == pOpWordPrefix,
+		obj.opcode == pOpDwordPrefix, obj.opcode == pOpQwordPrefix:
+		return vm.intWidth.Truncate(obj.value.(uint64)), nil
+	case pOpIsLocalArg(obj.opcode):
+		val := ctx.localArg[obj.opcode-pOpLocal0]
+		if val == nil {
+			return nil, vm.fail(obj, errUninitializedValue)
+		}
+		return val, nil
+	case pOpIsMethodArg(obj.opcode):
+		val := ctx.methodArg[obj.opcode-pOpArg0]
+		if val == nil {
+			return nil, vm.fail(obj, errUninitializedValue)
+		}
+		return val, nil
+	case obj.opcode == pOpIntResolvedNamePath, obj.opcode == pOpIntNamePath:
+		target, err := vm.resolveNamePath(ctx, obj)
+		if err != nil {
+			return nil, err
+		}
+		return vm.readNamedObject(ctx, target)
+	}
+
+	ctx.retVal = nil
+	if err := vm.execOpcode(ctx, obj); err != nil {
+		return nil, err
+	}
+
+	val := ctx.retVal
+	ctx.retVal = nil
+	return val, nil
+}
+
+// resolveNamePath returns the Object referenced by a pOpIntResolvedNamePath
+// or a pOpIntNamePath object.
+func (vm *VM) resolveNamePath(ctx *execContext, obj *Object) (*Object, *kernel.Error) {
+	var target *Object
+
+	switch obj.opcode {
+	case pOpIntResolvedNamePath:
+		target = vm.tree.ObjectAt(obj.value.(uint32))
+	case pOpIntNamePath:
+		// Paths that could not be resolved by the parser are looked up
+		// relative to the location where they appear first and relative
+		// to the scope of the executing method as a fallback.
+		var (
+			ns   = vm.tree.Namespace()
+			path = obj.value.([]byte)
+			node = ns.lookup(ns.nodeAt(vm.tree.ClosestNamedAncestor(obj)), path)
+		)
+
+		if node == nil {
+			node = ns.lookup(ns.nodeAt(ctx.scopeIndex), path)
+		}
+
+		if node != nil {
+			target = node.Object()
+		}
+	}
+
+	if target == nil {
+		return nil, vm.fail(obj, errPathNotFound)
+	}
+
+	return target, nil
+}
+
+// readNamedObject returns the value of a named object. Reading a Name object
+// returns its current value, reading a field returns the field contents while
+// reading a Method with no arguments invokes it. For all other named objects,
+// a reference to the object is returned.
+func (vm *VM) readNamedObject(ctx *execContext, obj *Object) (interface{}, *kernel.Error) {
+	switch obj.opcode {
+	case pOpName:
+		return vm.nameValue(obj)
+	case pOpMethod:
+		return vm.invokeMethod(ctx, obj, nil)
+	case pOpIntNamedField:
+		return vm.readField(ctx, obj)
+	case pOpCreateField, pOpCreateBitField, pOpCreateByteField,
+		pOpCreateWordField, pOpCreateDWordField, pOpCreateQWordField:
+		return vm.readBufferField(ctx, obj)
+	default:
+		return obj, nil
+	}
+}
+
+// nameValue returns the current value of a Name object. The value is lazily
+// initialized the first time that the object is accessed.
+func (vm *VM) nameValue(obj *Object) (interface{}, *kernel.Error) {
+	if val, exists := vm.namedValues[obj.index]; exists {
+		return val, nil
+	}
+
+	val, err := vm.evalArg(&execContext{scopeIndex: obj.index}, obj, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.escape(val)
+	vm.namedValues[obj.index] = val
+	return val, nil
+}
+
+// fail logs the error that occurred while executing obj and returns err back
+// to the caller.
+func (vm *VM) fail(obj *Object, err *kernel.Error) *kernel.Error {
+	kfmt.Fprintf(vm.errWriter, "[table: %d, offset: 0x%x] error executing %s: %s\n", obj.tableHandle, obj.amlOffset, pOpcodeName(obj.opcode), err.Message)
+	return err
+}
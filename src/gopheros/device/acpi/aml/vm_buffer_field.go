@@ -0,0 +1,158 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/convert"
+	"gopheros/kernel"
+)
+
+var (
+	errNotABuffer             = &kernel.Error{Module: "acpi_aml_vm", Message: "buffer field source operand is not a Buffer", Code: kernel.ErrCodeInvalidArgument}
+	errBufferFieldOutOfBounds = &kernel.Error{Module: "acpi_aml_vm", Message: "buffer field exceeds the bounds of its source buffer", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// bufferField holds the run-time state of a BufferField object created by
+// one of the CreateXField opcodes.
+type bufferField struct {
+	// The buffer that contains the field. Reads and writes to the field
+	// access the buffer contents directly.
+	buf []byte
+
+	// The offset and width of the field in bits.
+	offset, width uint32
+}
+
+// isBufferFieldOpcode returns true if opcode creates a BufferField.
+func isBufferFieldOpcode(opcode uint16) bool {
+	switch opcode {
+	case pOpCreateField, pOpCreateBitField, pOpCreateByteField,
+		pOpCreateWordField, pOpCreateDWordField, pOpCreateQWordField:
+		return true
+	}
+
+	return false
+}
+
+// vmOpCreateField creates a BufferField over the buffer specified by the
+// first arg of obj. As the source buffer and the field offset are evaluated
+// each time the opcode executes, methods can create fields over buffers
+// stored in Locals or Args and use offsets computed at run-time.
+func vmOpCreateField(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	field, err := vm.createBufferField(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	vm.bufferFields[obj.index] = field
+	return nil
+}
+
+// bufferField returns the run-time state of the BufferField created by obj.
+// Fields defined outside of method bodies are never executed by the VM; they
+// are lazily created the first time that they are accessed.
+func (vm *VM) bufferField(ctx *execContext, obj *Object) (*bufferField, *kernel.Error) {
+	if field, exists := vm.bufferFields[obj.index]; exists {
+		return field, nil
+	}
+
+	field, err := vm.createBufferField(&execContext{scopeIndex: obj.index, depth: ctx.depth, thread: ctx.thread}, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	vm.bufferFields[obj.index] = field
+	return field, nil
+}
+
+// createBufferField evaluates the args of a CreateXField opcode and returns
+// the BufferField it describes. CreateBitField and CreateField specify the
+// field offset in bits while all other opcodes specify it in bytes.
+func (vm *VM) createBufferField(ctx *execContext, obj *Object) (*bufferField, *kernel.Error) {
+	src, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref, isRef := src.(*Reference); isRef {
+		if src, err = vm.deref(ctx, ref); err != nil {
+			return nil, vm.fail(obj, err)
+		}
+	}
+
+	buf, isBuf := src.([]byte)
+	if !isBuf {
+		return nil, vm.fail(obj, errNotABuffer)
+	}
+
+	offset, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	var width uint64
+	switch obj.opcode {
+	case pOpCreateField:
+		if width, err = vm.evalIntArg(ctx, obj, 2); err != nil {
+			return nil, err
+		}
+	case pOpCreateBitField:
+		width = 1
+	case pOpCreateByteField:
+		offset, width = offset<<3, 8
+	case pOpCreateWordField:
+		offset, width = offset<<3, 16
+	case pOpCreateDWordField:
+		offset, width = offset<<3, 32
+	case pOpCreateQWordField:
+		offset, width = offset<<3, 64
+	}
+
+	bufBits := uint64(len(buf)) << 3
+	if width == 0 || offset >= bufBits || width > bufBits-offset {
+		return nil, vm.fail(obj, errBufferFieldOutOfBounds)
+	}
+
+	// The field aliases the source buffer so its storage must outlive the
+	// method that created the field.
+	vm.escape(buf)
+
+	return &bufferField{buf: buf, offset: uint32(offset), width: uint32(width)}, nil
+}
+
+// readBufferField reads the contents of a BufferField. Fields that fit in an
+// Integer are returned as an Integer; wider fields are returned as a Buffer.
+func (vm *VM) readBufferField(ctx *execContext, obj *Object) (interface{}, *kernel.Error) {
+	field, err := vm.bufferField(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, (field.width+7)>>3)
+	for pos := uint32(0); pos < field.width; pos += 64 {
+		count := minUint32(64, field.width-pos)
+		setBits(data, pos, count, getBits(field.buf, field.offset+pos, count))
+	}
+
+	return convert.FieldValue(data, field.width, vm.intWidth), nil
+}
+
+// writeBufferField updates the contents of a BufferField with val. Values
+// that are shorter than the field are zero-extended while longer values are
+// truncated. The bits of the source buffer outside the field are preserved.
+func (vm *VM) writeBufferField(ctx *execContext, obj *Object, val interface{}) *kernel.Error {
+	field, err := vm.bufferField(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	data, err := convert.FieldData(val, field.width, vm.intWidth)
+	if err != nil {
+		return vm.fail(obj, err)
+	}
+
+	for pos := uint32(0); pos < field.width; pos += 64 {
+		count := minUint32(64, field.width-pos)
+		setBits(field.buf, field.offset+pos, count, getBits(data, pos, count))
+	}
+
+	return nil
+}
@@ -0,0 +1,188 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/kernel"
+	"reflect"
+	"testing"
+)
+
+func TestVMBufferFields(t *testing.T) {
+	var (
+		fld0       = []byte{'F', 'L', 'D', '0'}
+		buf0       = []byte{'B', 'U', 'F', '0'}
+		returnFLD0 = amltest.Concat([]byte{0xa4}, fld0)
+		returnBUF0 = amltest.Concat([]byte{0xa4}, buf0)
+	)
+
+	specs := []struct {
+		body   []byte
+		arg    interface{}
+		expVal interface{}
+		expErr *kernel.Error
+	}{
+		// CreateBitField(BUF0, Arg0, FLD0)
+		{
+			amltest.Concat([]byte{0x8d}, buf0, []byte{0x68}, fld0, returnFLD0),
+			4,
+			uint64(1),
+			nil,
+		},
+		// CreateByteField(BUF0, Arg0, FLD0)
+		{
+			amltest.Concat([]byte{0x8c}, buf0, []byte{0x68}, fld0, returnFLD0),
+			3,
+			uint64(0x44),
+			nil,
+		},
+		// CreateWordField(BUF0, Arg0, FLD0)
+		{
+			amltest.Concat([]byte{0x8b}, buf0, []byte{0x68}, fld0, returnFLD0),
+			8,
+			uint64(0xaa99),
+			nil,
+		},
+		// CreateDWordField(BUF0, Arg0, FLD0)
+		{
+			amltest.Concat([]byte{0x8a}, buf0, []byte{0x68}, fld0, returnFLD0),
+			1,
+			uint64(0x55443322),
+			nil,
+		},
+		// CreateQWordField(BUF0, Arg0, FLD0)
+		{
+			amltest.Concat([]byte{0x8f}, buf0, []byte{0x68}, fld0, returnFLD0),
+			0,
+			uint64(0x8877665544332211),
+			nil,
+		},
+		// CreateField(BUF0, Arg0, 12, FLD0)
+		{
+			amltest.Concat([]byte{0x5b, 0x13}, buf0, []byte{0x68, 0x0a, 0x0c}, fld0, returnFLD0),
+			4,
+			uint64(0x221),
+			nil,
+		},
+		// CreateField(BUF0, Arg0, 72, FLD0); fields wider than an Integer
+		// evaluate to a Buffer
+		{
+			amltest.Concat([]byte{0x5b, 0x13}, buf0, []byte{0x68, 0x0a, 0x48}, fld0, returnFLD0),
+			8,
+			[]byte{0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa},
+			nil,
+		},
+		// CreateField(BUF0, 4, 8, FLD0); Store(0xff, FLD0); Return(BUF0)
+		{
+			amltest.Concat(
+				[]byte{0x5b, 0x13}, buf0, []byte{0x0a, 0x04, 0x0a, 0x08}, fld0,
+				[]byte{0x70, 0x0a, 0xff}, fld0,
+				returnBUF0,
+			),
+			0,
+			[]byte{0xf1, 0x2f, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa},
+			nil,
+		},
+		// Store(Buffer() {1, 2}, Local0)
+		// CreateWordField(Local0, 0, FLD0)
+		// Store(0xbeef, FLD0)
+		// Return(Local0)
+		{
+			amltest.Concat(
+				[]byte{0x70}, amltest.Buffer(1, 2), []byte{0x60},
+				[]byte{0x8b, 0x60, 0x00}, fld0,
+				[]byte{0x70, 0x0b, 0xef, 0xbe}, fld0,
+				[]byte{0xa4, 0x60},
+			),
+			0,
+			[]byte{0xef, 0xbe},
+			nil,
+		},
+		// CreateDWordField(Arg0, 0, FLD0)
+		{
+			amltest.Concat([]byte{0x8a, 0x68, 0x00}, fld0, returnFLD0),
+			[]byte{0xef, 0xbe, 0xad, 0xde},
+			uint64(0xdeadbeef),
+			nil,
+		},
+		// Field exceeds the source buffer bounds
+		{
+			amltest.Concat([]byte{0x8a}, buf0, []byte{0x68}, fld0, returnFLD0),
+			7,
+			nil,
+			errBufferFieldOutOfBounds,
+		},
+		// Field offset exceeds the source buffer bounds
+		{
+			amltest.Concat([]byte{0x8d}, buf0, []byte{0x68}, fld0, returnFLD0),
+			80,
+			nil,
+			errBufferFieldOutOfBounds,
+		},
+		// CreateField(BUF0, 0, 0, FLD0); zero-width fields are not allowed
+		{
+			amltest.Concat([]byte{0x5b, 0x13}, buf0, []byte{0x00, 0x00}, fld0, returnFLD0),
+			0,
+			nil,
+			errBufferFieldOutOfBounds,
+		},
+		// CreateByteField(INT0, 0, FLD0)
+		{
+			amltest.Concat([]byte{0x8c, 'I', 'N', 'T', '0', 0x00}, fld0, returnFLD0),
+			0,
+			nil,
+			errNotABuffer,
+		},
+		// Fields defined outside of methods are created when first
+		// accessed: Store(0xbeef, SWF0); Return(BUF0)
+		{
+			amltest.Concat([]byte{0x70, 0x0b, 0xef, 0xbe, 'S', 'W', 'F', '0'}, returnBUF0),
+			0,
+			[]byte{0x11, 0x22, 0xef, 0xbe, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa},
+			nil,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, bufferFieldTestPayload(spec.body))
+
+		got, err := vm.Evaluate(`TEST`, spec.arg)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, spec.expVal) {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.expVal, got)
+		}
+	}
+
+	t.Run("lookup static field", func(t *testing.T) {
+		vm := vmForPayload(t, bufferFieldTestPayload(nil))
+
+		got, err := vm.Evaluate(`\SWF0`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := uint64(0x4433); got != exp {
+			t.Fatalf("expected to get %#v; got %#v", exp, got)
+		}
+	})
+}
+
+// bufferFieldTestPayload returns an AML payload that defines a 10-byte Buffer
+// called BUF0, a word field over BUF0 called SWF0, an integer Name called INT0
+// and a method called TEST with one argument and the supplied body.
+func bufferFieldTestPayload(body []byte) []byte {
+	return amltest.Concat(
+		// Name(BUF0, Buffer() { 0x11, 0x22, ..., 0xaa })
+		[]byte{0x08, 'B', 'U', 'F', '0'},
+		amltest.Buffer(0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88, 0x99, 0xaa),
+		// CreateWordField(BUF0, 2, SWF0)
+		[]byte{0x8b, 'B', 'U', 'F', '0', 0x0a, 0x02, 'S', 'W', 'F', '0'},
+		// Name(INT0, 1)
+		[]byte{0x08, 'I', 'N', 'T', '0', 0x01},
+		// Method(TEST, 1) { body }
+		amltest.Pkg([]byte{0x14}, amltest.Concat([]byte{'T', 'E', 'S', 'T', 0x01}, body)),
+	)
+}
@@ -0,0 +1,72 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/convert"
+	"gopheros/kernel"
+)
+
+// The values of the AML True and False constants for 64-bit Integers. In
+// 32-bit mode, True evaluates to 0xFFFFFFFF instead; see VM.boolToInt.
+const (
+	vmTrue  = ^uint64(0)
+	vmFalse = uint64(0)
+)
+
+// Reference is a value that points to a named object or to an element of a
+// Package or Buffer. References are created via the RefOf and Index opcodes.
+type Reference struct {
+	// Target points to a named object. It is only used for references
+	// created by RefOf.
+	Target *Object
+
+	// Container holds the Package ([]interface{}) or Buffer ([]byte)
+	// referenced by an Index opcode and Index the element offset.
+	Container interface{}
+	Index     uint64
+}
+
+// copyValue returns a copy of val. Buffers and packages are deep-copied so
+// that modifying the copy does not affect the original value.
+func copyValue(val interface{}) interface{} {
+	switch typ := val.(type) {
+	case []byte:
+		out := make([]byte, len(typ))
+		copy(out, typ)
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(typ))
+		for i, elem := range typ {
+			out[i] = copyValue(elem)
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// toInteger implicitly converts val into an Integer using the Integer width
+// of the VM. See convert.ToInteger for the conversion rules.
+func (vm *VM) toInteger(val interface{}) (uint64, *kernel.Error) {
+	return convert.ToInteger(val, vm.intWidth)
+}
+
+// toBuffer implicitly converts val into a Buffer using the Integer width of
+// the VM. See convert.ToBuffer for the conversion rules.
+func (vm *VM) toBuffer(val interface{}) ([]byte, *kernel.Error) {
+	return convert.ToBuffer(val, vm.intWidth)
+}
+
+// toString implicitly converts val into a String using the Integer width of
+// the VM. See convert.ToString for the conversion rules.
+func (vm *VM) toString(val interface{}) (string, *kernel.Error) {
+	return convert.ToString(val, vm.intWidth)
+}
+
+// boolToInt converts a boolean value to the AML True (Ones) or False (Zero)
+// Integer values.
+func (vm *VM) boolToInt(val bool) uint64 {
+	if val {
+		return vm.intWidth.Ones()
+	}
+	return vmFalse
+}
@@ -0,0 +1,82 @@
+package aml
+
+import (
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+var debugPrefix = []byte("ACPI Debug: ")
+
+// SetDebugOutput enables or disables the output generated by AML code that
+// stores values to the Debug object. Debug output is disabled by default.
+func (vm *VM) SetDebugOutput(enabled bool) {
+	vm.debugOutput = enabled
+}
+
+// SetDebugWriter sets the writer for the output generated by stores to the
+// Debug object. If w is nil, the output is sent to the kfmt output sink.
+func (vm *VM) SetDebugWriter(w io.Writer) {
+	vm.debugWriter = w
+}
+
+// writeDebug formats val and writes it to the debug writer. Each output line
+// is prefixed with "ACPI Debug: ". Integers are printed in hex, Buffers as a
+// hexdump and Packages are printed recursively.
+func (vm *VM) writeDebug(val interface{}) {
+	if !vm.debugOutput {
+		return
+	}
+
+	sink := vm.debugWriter
+	if sink == nil {
+		sink = kfmt.GetOutputSink()
+	}
+
+	w := &kfmt.PrefixWriter{Sink: sink, Prefix: debugPrefix}
+	vm.formatDebugValue(w, val, 0)
+}
+
+// formatDebugValue writes a human-readable representation of val to w
+// indented by the specified number of levels.
+func (vm *VM) formatDebugValue(w io.Writer, val interface{}, depth int) {
+	indent := func(extra int) {
+		for i := 0; i < 2*(depth+extra); i++ {
+			kfmt.Fprintf(w, " ")
+		}
+	}
+
+	indent(0)
+	switch typ := val.(type) {
+	case nil:
+		kfmt.Fprintf(w, "[Uninitialized]\n")
+	case uint64:
+		kfmt.Fprintf(w, "[Integer] 0x%x\n", typ)
+	case string:
+		kfmt.Fprintf(w, "[String] \"%s\"\n", typ)
+	case []byte:
+		kfmt.Fprintf(w, "[Buffer] length: %d\n", len(typ))
+		for offset := 0; offset < len(typ); offset += 16 {
+			indent(1)
+			kfmt.Fprintf(w, "%4x:", offset)
+			for index := offset; index < offset+16 && index < len(typ); index++ {
+				kfmt.Fprintf(w, " %2x", typ[index])
+			}
+			kfmt.Fprintf(w, "\n")
+		}
+	case []interface{}:
+		kfmt.Fprintf(w, "[Package] elements: %d\n", len(typ))
+		for _, elem := range typ {
+			vm.formatDebugValue(w, elem, depth+1)
+		}
+	case *Object:
+		kfmt.Fprintf(w, "[%s] %s\n", pOpcodeName(typ.opcode), typ.Name())
+	case *Reference:
+		if typ.Target != nil {
+			kfmt.Fprintf(w, "[Reference] %s\n", typ.Target.Name())
+			break
+		}
+		kfmt.Fprintf(w, "[Reference] index: %d\n", typ.Index)
+	default:
+		kfmt.Fprintf(w, "[Unknown]\n")
+	}
+}
@@ -0,0 +1,61 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"testing"
+)
+
+func TestVMDebugOutput(t *testing.T) {
+	// Store(Package() {
+	//   0x2a,
+	//   "foo",
+	//   Buffer() {0x00, 0x01, ..., 0x11},
+	//   Package() { One },
+	//   INT0
+	// }, Debug)
+	body := amltest.Concat(
+		[]byte{0x70},
+		amltest.Pkg([]byte{0x12}, amltest.Concat(
+			[]byte{0x05, 0x0a, 0x2a, 0x0d, 'f', 'o', 'o', 0x00},
+			amltest.Buffer(0x00, 0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08, 0x09, 0x0a, 0x0b, 0x0c, 0x0d, 0x0e, 0x0f, 0x10, 0x11),
+			amltest.Pkg([]byte{0x12}, []byte{0x01, 0x01}),
+		)),
+		[]byte{0x5b, 0x31},
+		// Store(INT0, Debug)
+		[]byte{0x70, 'I', 'N', 'T', '0', 0x5b, 0x31},
+	)
+
+	expOutput := `ACPI Debug: [Package] elements: 5
+ACPI Debug:   [Integer] 0x2a
+ACPI Debug:   [String] "foo"
+ACPI Debug:   [Buffer] length: 18
+ACPI Debug:     0000: 00 01 02 03 04 05 06 07 08 09 0a 0b 0c 0d 0e 0f
+ACPI Debug:     0010: 10 11
+ACPI Debug:   [Package] elements: 1
+ACPI Debug:     [Integer] 0x1
+ACPI Debug:   [Uninitialized]
+ACPI Debug: [Integer] 0x2a
+`
+
+	var buf bytes.Buffer
+	vm := vmForTestMethod(t, 0, body)
+	vm.SetDebugWriter(&buf)
+
+	if _, err := vm.Evaluate(`\TEST`); err != nil {
+		t.Fatal(err)
+	}
+
+	if buf.Len() != 0 {
+		t.Fatalf("expected debug output to be disabled by default; got %q", buf.String())
+	}
+
+	vm.SetDebugOutput(true)
+	if _, err := vm.Evaluate(`\TEST`); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := buf.String(); got != expOutput {
+		t.Fatalf("expected debug output:\n%s\ngot:\n%s", expOutput, got)
+	}
+}
@@ -0,0 +1,387 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/convert"
+	"gopheros/kernel"
+	"gopheros/kernel/sync"
+)
+
+// The list of supported field access types.
+const (
+	fieldAccessTypeAny uint8 = iota
+	fieldAccessTypeByte
+	fieldAccessTypeWord
+	fieldAccessTypeDword
+	fieldAccessTypeQword
+	fieldAccessTypeBuffer
+)
+
+// The list of supported field update rules.
+const (
+	fieldUpdateRulePreserve uint8 = iota
+	fieldUpdateRuleWriteAsOnes
+	fieldUpdateRuleWriteAsZeros
+)
+
+// fieldLockTypeLock indicates that the ACPI global lock must be held while
+// accessing a field.
+const fieldLockTypeLock = uint8(1)
+
+// SetGlobalLock overrides the lock that the VM acquires while accessing fields
+// declared with the Lock flag. By default, the VM uses a Mutex that is private
+// to the VM instance; the kernel can replace it with a lock that also
+// synchronizes with the firmware via the FACS global lock.
+func (vm *VM) SetGlobalLock(lock sync.Locker) {
+	vm.globalLock = lock
+}
+
+// fieldTarget describes where the contents of a named field are stored.
+type fieldTarget struct {
+	// The region that contains the field. Used by fields that belong to a
+	// Field or a BankField.
+	region *Region
+
+	// For fields that belong to a BankField, the bank selection field
+	// and the value that must be written to it before accessing region.
+	bankObj   *Object
+	bankValue uint64
+
+	// For fields that belong to an IndexField, the index and data fields
+	// used to access the field contents.
+	indexObj, dataObj *Object
+}
+
+// fieldTarget returns the field element information for a named field object
+// together with a fieldTarget describing where the field contents are stored.
+func (vm *VM) fieldTarget(ctx *execContext, obj *Object) (*fieldElement, *fieldTarget, *kernel.Error) {
+	field, ok := obj.value.(*fieldElement)
+	if !ok {
+		return nil, nil, vm.fail(obj, errMalformedObject)
+	}
+
+	fieldObj := vm.tree.ObjectAt(field.fieldIndex)
+	if fieldObj == nil {
+		return nil, nil, vm.fail(obj, errMalformedObject)
+	}
+
+	var (
+		target fieldTarget
+		err    *kernel.Error
+	)
+
+	switch fieldObj.opcode {
+	case pOpField:
+		target.region, err = vm.fieldArgRegion(ctx, fieldObj, 0)
+	case pOpBankField:
+		if target.region, err = vm.fieldArgRegion(ctx, fieldObj, 0); err != nil {
+			break
+		}
+
+		if target.bankObj, err = vm.fieldArgFieldUnit(ctx, fieldObj, 1); err != nil {
+			break
+		}
+
+		target.bankValue, err = vm.evalIntArg(&execContext{scopeIndex: fieldObj.index, depth: ctx.depth, thread: ctx.thread}, fieldObj, 2)
+	case pOpIndexField:
+		if target.indexObj, err = vm.fieldArgFieldUnit(ctx, fieldObj, 0); err != nil {
+			break
+		}
+
+		target.dataObj, err = vm.fieldArgFieldUnit(ctx, fieldObj, 1)
+	default:
+		err = vm.fail(obj, errUnsupportedFieldReg)
+	}
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return field, &target, nil
+}
+
+// fieldArgRegion resolves the name path stored in fieldObj's arg at argIndex
+// and returns the Region it refers to.
+func (vm *VM) fieldArgRegion(ctx *execContext, fieldObj *Object, argIndex uint32) (*Region, *kernel.Error) {
+	regionPath := vm.tree.ArgAt(fieldObj, argIndex)
+	if regionPath == nil {
+		return nil, vm.fail(fieldObj, errMalformedObject)
+	}
+
+	regionObj, err := vm.resolveNamePath(ctx, regionPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.region(ctx, regionObj)
+}
+
+// fieldArgFieldUnit resolves the name path stored in fieldObj's arg at
+// argIndex and ensures that it refers to a named field.
+func (vm *VM) fieldArgFieldUnit(ctx *execContext, fieldObj *Object, argIndex uint32) (*Object, *kernel.Error) {
+	unitPath := vm.tree.ArgAt(fieldObj, argIndex)
+	if unitPath == nil {
+		return nil, vm.fail(fieldObj, errMalformedObject)
+	}
+
+	unitObj, err := vm.resolveNamePath(ctx, unitPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if unitObj.opcode != pOpIntNamedField {
+		return nil, vm.fail(fieldObj, errUnsupportedFieldReg)
+	}
+
+	return unitObj, nil
+}
+
+// readUnit reads an access unit of the specified width (in bits) located at
+// byteOffset from a field target. For BankFields, the bank selection value is
+// written to the bank field before accessing the region. For IndexFields,
+// byteOffset is written to the index field and the unit contents are then
+// read from the data field.
+func (vm *VM) readUnit(ctx *execContext, target *fieldTarget, byteOffset uint64, width uint32, lockHeld bool) (uint64, *kernel.Error) {
+	if target.indexObj != nil {
+		if err := vm.writeFieldUnit(ctx, target.indexObj, byteOffset, lockHeld); err != nil {
+			return 0, err
+		}
+
+		val, err := vm.readFieldUnit(ctx, target.dataObj, lockHeld)
+		if err != nil {
+			return 0, err
+		}
+
+		intVal, err := vm.toInteger(val)
+		return intVal & bitMask(width), err
+	}
+
+	if target.bankObj != nil {
+		if err := vm.writeFieldUnit(ctx, target.bankObj, target.bankValue, lockHeld); err != nil {
+			return 0, err
+		}
+	}
+
+	return vm.readRegion(target.region, byteOffset, uint8(width))
+}
+
+// writeUnit writes an access unit of the specified width (in bits) located at
+// byteOffset to a field target using the same approach as readUnit.
+func (vm *VM) writeUnit(ctx *execContext, target *fieldTarget, byteOffset uint64, width uint32, val uint64, lockHeld bool) *kernel.Error {
+	if target.indexObj != nil {
+		if err := vm.writeFieldUnit(ctx, target.indexObj, byteOffset, lockHeld); err != nil {
+			return err
+		}
+
+		return vm.writeFieldUnit(ctx, target.dataObj, val, lockHeld)
+	}
+
+	if target.bankObj != nil {
+		if err := vm.writeFieldUnit(ctx, target.bankObj, target.bankValue, lockHeld); err != nil {
+			return err
+		}
+	}
+
+	return vm.writeRegion(target.region, byteOffset, uint8(width), val)
+}
+
+// fieldAccessWidth returns the width in bits of each access to the region
+// that contains field. For AnyAcc fields, the smallest access width that
+// allows the field to be accessed using a single naturally aligned access
+// that does not exceed the region bounds is selected. If no such width
+// exists or the field does not belong to a region (e.g. it is part of an
+// IndexField), byte accesses are used.
+func fieldAccessWidth(field *fieldElement, region *Region) uint32 {
+	switch field.accessType {
+	case fieldAccessTypeWord:
+		return 16
+	case fieldAccessTypeDword:
+		return 32
+	case fieldAccessTypeQword:
+		return 64
+	case fieldAccessTypeAny:
+		if region == nil {
+			break
+		}
+
+		for width := uint32(8); width <= 64; width <<= 1 {
+			unitStart := field.offset &^ (width - 1)
+			if field.offset+field.width <= unitStart+width && uint64(unitStart+width)>>3 <= region.Length {
+				return width
+			}
+		}
+	}
+
+	return 8
+}
+
+// readField reads the contents of a named field. Fields that are up to 64
+// bits wide are returned as an Integer; wider fields are returned as a Buffer.
+//
+// The field contents are read using naturally aligned accesses whose width
+// depends on the field's access type. Fields declared with the Lock flag are
+// read while holding the ACPI global lock.
+func (vm *VM) readField(ctx *execContext, obj *Object) (interface{}, *kernel.Error) {
+	return vm.readFieldUnit(ctx, obj, false)
+}
+
+// readFieldUnit implements readField. As the global lock is not reentrant,
+// lockHeld is set to true when reading the index, data or bank fields that
+// back another field while the global lock is already held.
+func (vm *VM) readFieldUnit(ctx *execContext, obj *Object, lockHeld bool) (interface{}, *kernel.Error) {
+	field, target, err := vm.fieldTarget(ctx, obj)
+	if err != nil {
+		return nil, err
+	}
+
+	if field.lockType == fieldLockTypeLock && !lockHeld {
+		lockHeld = true
+		vm.globalLock.Acquire()
+		defer vm.globalLock.Release()
+	}
+
+	if target.region != nil && isSerialBusSpace(target.region.Space) {
+		return vm.readSerialBusField(ctx, field, target.region)
+	}
+
+	var (
+		accessWidth = fieldAccessWidth(field, target.region)
+		fieldEnd    = field.offset + field.width
+		buf         = make([]byte, (field.width+7)>>3)
+	)
+
+	for unitStart := field.offset &^ (accessWidth - 1); unitStart < fieldEnd; unitStart += accessWidth {
+		unitVal, err := vm.readUnit(ctx, target, uint64(unitStart>>3), accessWidth, lockHeld)
+		if err != nil {
+			return nil, vm.fail(obj, err)
+		}
+
+		// Copy the unit bits that overlap with the field into buf
+		first, last := maxUint32(unitStart, field.offset), minUint32(unitStart+accessWidth, fieldEnd)
+		setBits(buf, first-field.offset, last-first, unitVal>>(first-unitStart))
+	}
+
+	return convert.FieldValue(buf, field.width, vm.intWidth), nil
+}
+
+// writeField updates the contents of a named field with val.
+//
+// The field contents are written using naturally aligned accesses whose
+// width depends on the field's access type. Bits in each access unit that
+// are not part of the field are handled according to the field's update
+// rule: they are either preserved (requiring the unit to be read before it
+// gets written) or they are set to all ones or all zeroes. Fields declared
+// with the Lock flag are written while holding the ACPI global lock.
+func (vm *VM) writeField(ctx *execContext, obj *Object, val interface{}) *kernel.Error {
+	return vm.writeFieldUnit(ctx, obj, val, false)
+}
+
+// writeFieldUnit implements writeField. The lockHeld argument has the same
+// meaning as in readFieldUnit.
+func (vm *VM) writeFieldUnit(ctx *execContext, obj *Object, val interface{}, lockHeld bool) *kernel.Error {
+	field, target, err := vm.fieldTarget(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	if target.region != nil && isSerialBusSpace(target.region.Space) {
+		if field.lockType == fieldLockTypeLock && !lockHeld {
+			vm.globalLock.Acquire()
+			defer vm.globalLock.Release()
+		}
+
+		if err = vm.writeSerialBusField(ctx, field, target.region, val); err != nil {
+			return vm.fail(obj, err)
+		}
+		return nil
+	}
+
+	buf, err := convert.FieldData(val, field.width, vm.intWidth)
+	if err != nil {
+		return vm.fail(obj, err)
+	}
+
+	if field.lockType == fieldLockTypeLock && !lockHeld {
+		lockHeld = true
+		vm.globalLock.Acquire()
+		defer vm.globalLock.Release()
+	}
+
+	var (
+		accessWidth = fieldAccessWidth(field, target.region)
+		fieldEnd    = field.offset + field.width
+		unitVal     uint64
+	)
+
+	for unitStart := field.offset &^ (accessWidth - 1); unitStart < fieldEnd; unitStart += accessWidth {
+		first, last := maxUint32(unitStart, field.offset), minUint32(unitStart+accessWidth, fieldEnd)
+		shift := first - unitStart
+		mask := bitMask(last-first) << shift
+
+		switch {
+		case mask == bitMask(accessWidth):
+			// The field covers the entire unit
+			unitVal = 0
+		case field.updateType == fieldUpdateRuleWriteAsOnes:
+			unitVal = bitMask(accessWidth)
+		case field.updateType == fieldUpdateRuleWriteAsZeros:
+			unitVal = 0
+		default:
+			if unitVal, err = vm.readUnit(ctx, target, uint64(unitStart>>3), accessWidth, lockHeld); err != nil {
+				return vm.fail(obj, err)
+			}
+		}
+
+		unitVal = (unitVal &^ mask) | ((getBits(buf, first-field.offset, last-first) << shift) & mask)
+		if err = vm.writeUnit(ctx, target, uint64(unitStart>>3), accessWidth, unitVal, lockHeld); err != nil {
+			return vm.fail(obj, err)
+		}
+	}
+
+	return nil
+}
+
+// setBits copies the lower count bits of val into buf starting at the bit
+// offset pos.
+func setBits(buf []byte, pos, count uint32, val uint64) {
+	for i := uint32(0); i < count; i++ {
+		bit := pos + i
+		if (val>>i)&1 != 0 {
+			buf[bit>>3] |= 1 << (bit & 7)
+		} else {
+			buf[bit>>3] &^= 1 << (bit & 7)
+		}
+	}
+}
+
+// getBits returns count (up to 64) bits from buf starting at the bit offset
+// pos.
+func getBits(buf []byte, pos, count uint32) uint64 {
+	var val uint64
+	for i := uint32(0); i < count; i++ {
+		bit := pos + i
+		val |= uint64((buf[bit>>3]>>(bit&7))&1) << i
+	}
+	return val
+}
+
+// bitMask returns a mask with the lower count bits set.
+func bitMask(count uint32) uint64 {
+	if count >= 64 {
+		return ^uint64(0)
+	}
+	return (1 << count) - 1
+}
+
+func minUint32(a, b uint32) uint32 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxUint32(a, b uint32) uint32 {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,327 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"reflect"
+	"testing"
+)
+
+func TestVMFieldAccessRules(t *testing.T) {
+	specs := []struct {
+		flags       uint8
+		offset      uint32
+		width       uint32
+		expRead     uint64
+		expReadLog  []string
+		writeVal    uint64
+		expWriteLog []string
+		expData     []byte
+	}{
+		// ByteAcc, Preserve; field straddles two bytes
+		{
+			0x01, 4, 12,
+			0x221, []string{"R0:8", "R1:8"},
+			0xabc, []string{"R0:8", "W0:8=0xc1", "W1:8=0xab"},
+			[]byte{0xc1, 0xab, 0x33, 0x44},
+		},
+		// WordAcc, Preserve; field straddles two words
+		{
+			0x02, 12, 8,
+			0x32, []string{"R0:16", "R2:16"},
+			0xab, []string{"R0:16", "W0:16=0xb211", "R2:16", "W2:16=0x443a"},
+			[]byte{0x11, 0xb2, 0x3a, 0x44},
+		},
+		// DWordAcc, Preserve; field covers the entire unit so no reads
+		// are required for writing it
+		{
+			0x03, 0, 32,
+			0x44332211, []string{"R0:32"},
+			0xdeadbeef, []string{"W0:32=0xdeadbeef"},
+			[]byte{0xef, 0xbe, 0xad, 0xde},
+		},
+		// QWordAcc, Preserve
+		{
+			0x04, 0, 8,
+			0x11, []string{"R0:64"},
+			0xff, []string{"R0:64", "W0:64=0x88776655443322ff"},
+			[]byte{0xff, 0x22, 0x33, 0x44},
+		},
+		// AnyAcc; the field fits in a single dword
+		{
+			0x00, 8, 16,
+			0x3322, []string{"R0:32"},
+			0xbeef, []string{"R0:32", "W0:32=0x44beef11"},
+			[]byte{0x11, 0xef, 0xbe, 0x44},
+		},
+		// AnyAcc; the field fits in a single byte
+		{
+			0x00, 8, 8,
+			0x22, []string{"R1:8"},
+			0xaa, []string{"W1:8=0xaa"},
+			[]byte{0x11, 0xaa, 0x33, 0x44},
+		},
+		// BufferAcc is treated as ByteAcc
+		{
+			0x05, 8, 8,
+			0x22, []string{"R1:8"},
+			0xaa, []string{"W1:8=0xaa"},
+			[]byte{0x11, 0xaa, 0x33, 0x44},
+		},
+		// ByteAcc, WriteAsOnes
+		{
+			0x21, 2, 4,
+			0x4, []string{"R0:8"},
+			0x0, []string{"W0:8=0xc3"},
+			[]byte{0xc3, 0x22, 0x33, 0x44},
+		},
+		// ByteAcc, WriteAsZeros
+		{
+			0x41, 2, 4,
+			0x4, []string{"R0:8"},
+			0xf, []string{"W0:8=0x3c"},
+			[]byte{0x3c, 0x22, 0x33, 0x44},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, fieldTestPayload(spec.flags, spec.offset, spec.width))
+		handler := &mockRegionHandler{
+			data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88},
+		}
+		vm.RegisterRegionHandler(RegionSpaceSystemIO, handler)
+
+		got, err := vm.Evaluate(`FLDX`)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got != spec.expRead {
+			t.Errorf("[spec %d] expected to read 0x%x; got %#v", specIndex, spec.expRead, got)
+		}
+
+		if !reflect.DeepEqual(handler.log, spec.expReadLog) {
+			t.Errorf("[spec %d] expected read accesses %v; got %v", specIndex, spec.expReadLog, handler.log)
+		}
+
+		handler.log = nil
+		if _, err = vm.Evaluate(`WFLD`, spec.writeVal); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(handler.log, spec.expWriteLog) {
+			t.Errorf("[spec %d] expected write accesses %v; got %v", specIndex, spec.expWriteLog, handler.log)
+		}
+
+		if !bytes.Equal(handler.data[:4], spec.expData) {
+			t.Errorf("[spec %d] expected region contents to be %v; got %v", specIndex, spec.expData, handler.data[:4])
+		}
+	}
+}
+
+func TestVMFieldGlobalLock(t *testing.T) {
+	// ByteAcc, Lock, Preserve
+	vm := vmForPayload(t, fieldTestPayload(0x11, 0, 8))
+	vm.RegisterRegionHandler(RegionSpaceSystemIO, &mockRegionHandler{data: make([]byte, 8)})
+
+	lock := &mockLocker{}
+	vm.SetGlobalLock(lock)
+
+	if _, err := vm.Evaluate(`FLDX`); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := vm.Evaluate(`WFLD`, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if lock.acquireCount != 2 || lock.releaseCount != 2 {
+		t.Fatalf("expected lock to be acquired and released twice; got %d acquires and %d releases", lock.acquireCount, lock.releaseCount)
+	}
+}
+
+func TestVMIndexAndBankFields(t *testing.T) {
+	specs := []struct {
+		field       string
+		expRead     uint64
+		expReadLog  []string
+		writeVal    uint64
+		expWriteLog []string
+	}{
+		// Each unit access writes the unit offset to IDX_ and then
+		// accesses DAT_
+		{
+			"IFLD",
+			0x222, []string{"W0:8=0x2", "R1:8", "W0:8=0x3", "R1:8"},
+			0xabc, []string{"W0:8=0x2", "W1:8=0xbc", "W0:8=0x3", "R1:8", "W0:8=0x3", "W1:8=0xba"},
+		},
+		// Each unit access writes the bank value to BNK_ and then
+		// accesses the region
+		{
+			"BFLD",
+			0x55, []string{"W2:8=0x1", "R4:8"},
+			0xaa, []string{"W2:8=0x1", "W4:8=0xaa"},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, indexBankFieldTestPayload(0x01))
+		handler := &mockRegionHandler{
+			data: []byte{0x11, 0x22, 0x33, 0x44, 0x55, 0x66, 0x77, 0x88},
+		}
+		vm.RegisterRegionHandler(RegionSpaceSystemIO, handler)
+
+		got, err := vm.Evaluate(spec.field)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got != spec.expRead {
+			t.Errorf("[spec %d] expected to read 0x%x; got %#v", specIndex, spec.expRead, got)
+		}
+
+		if !reflect.DeepEqual(handler.log, spec.expReadLog) {
+			t.Errorf("[spec %d] expected read accesses %v; got %v", specIndex, spec.expReadLog, handler.log)
+		}
+
+		handler.log = nil
+		if _, err = vm.Evaluate(`W`+spec.field[:3], spec.writeVal); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(handler.log, spec.expWriteLog) {
+			t.Errorf("[spec %d] expected write accesses %v; got %v", specIndex, spec.expWriteLog, handler.log)
+		}
+	}
+}
+
+func TestVMIndexFieldGlobalLock(t *testing.T) {
+	// Both the IndexField and the fields it is backed by specify the
+	// Lock flag; the global lock must only be acquired once per access.
+	vm := vmForPayload(t, indexBankFieldTestPayload(0x11))
+	vm.RegisterRegionHandler(RegionSpaceSystemIO, &mockRegionHandler{data: make([]byte, 8)})
+
+	lock := &mockLocker{}
+	vm.SetGlobalLock(lock)
+
+	if _, err := vm.Evaluate(`IFLD`); err != nil {
+		t.Fatal(err)
+	}
+
+	if lock.acquireCount != 1 || lock.releaseCount != 1 {
+		t.Fatalf("expected lock to be acquired and released once; got %d acquires and %d releases", lock.acquireCount, lock.releaseCount)
+	}
+}
+
+func TestVMIndexFieldErrors(t *testing.T) {
+	payload := amltest.Concat(
+		// OperationRegion(REG0, SystemIO, 0, 8)
+		[]byte{0x5b, 0x80, 'R', 'E', 'G', '0', 0x01, 0x00, 0x0a, 0x08},
+		// Name(IDX_, 0)
+		[]byte{0x08, 'I', 'D', 'X', '_', 0x00},
+		// IndexField(IDX_, IDX_, ByteAcc) { IFLD, 8 }
+		amltest.Pkg([]byte{0x5b, 0x86}, []byte{'I', 'D', 'X', '_', 'I', 'D', 'X', '_', 0x01, 'I', 'F', 'L', 'D', 0x08}),
+	)
+
+	vm := vmForPayload(t, payload)
+	vm.RegisterRegionHandler(RegionSpaceSystemIO, &mockRegionHandler{data: make([]byte, 8)})
+
+	if _, err := vm.Evaluate(`IFLD`); err != errUnsupportedFieldReg {
+		t.Fatalf("expected to get errUnsupportedFieldReg; got %v", err)
+	}
+}
+
+func TestFieldAccessWidth(t *testing.T) {
+	region := &Region{Length: 2}
+
+	specs := []struct {
+		field *fieldElement
+		exp   uint32
+	}{
+		{&fieldElement{accessType: fieldAccessTypeAny, offset: 0, width: 1}, 8},
+		{&fieldElement{accessType: fieldAccessTypeAny, offset: 4, width: 8}, 16},
+		// A dword access would exceed the region length
+		{&fieldElement{accessType: fieldAccessTypeAny, offset: 12, width: 8}, 8},
+		{&fieldElement{accessType: fieldAccessTypeByte}, 8},
+		{&fieldElement{accessType: fieldAccessTypeWord}, 16},
+		{&fieldElement{accessType: fieldAccessTypeDword}, 32},
+		{&fieldElement{accessType: fieldAccessTypeQword}, 64},
+		{&fieldElement{accessType: fieldAccessTypeBuffer}, 8},
+	}
+
+	for specIndex, spec := range specs {
+		if got := fieldAccessWidth(spec.field, region); got != spec.exp {
+			t.Errorf("[spec %d] expected access width to be %d; got %d", specIndex, spec.exp, got)
+		}
+	}
+}
+
+// fieldTestPayload returns an AML payload that defines a SystemIO region, a
+// field called FLDX with the specified flags, bit offset and width and a
+// method called WFLD that stores its argument to FLDX.
+func fieldTestPayload(flags uint8, offset, width uint32) []byte {
+	var fieldList []byte
+	if offset != 0 {
+		fieldList = amltest.Concat([]byte{0x00}, amltest.PkgLength(offset))
+	}
+
+	return amltest.Concat(
+		// OperationRegion(REG0, SystemIO, 0, 8)
+		[]byte{0x5b, 0x80, 'R', 'E', 'G', '0', 0x01, 0x00, 0x0a, 0x08},
+		// Field(REG0, flags) { Offset(offset), FLDX, width }
+		amltest.Pkg([]byte{0x5b, 0x81}, amltest.Concat(
+			[]byte{'R', 'E', 'G', '0', flags},
+			fieldList,
+			[]byte{'F', 'L', 'D', 'X'},
+			amltest.PkgLength(width),
+		)),
+		// Method(WFLD, 1) { Store(Arg0, FLDX) }
+		amltest.Pkg([]byte{0x14}, []byte{'W', 'F', 'L', 'D', 0x01, 0x70, 0x68, 'F', 'L', 'D', 'X'}),
+	)
+}
+
+// indexBankFieldTestPayload returns an AML payload that defines a SystemIO
+// region with three byte-wide fields (IDX_, DAT_ and BNK_), an IndexField
+// called IFLD and a BankField called BFLD. All fields use the specified flags.
+// The payload also defines the WIFL and WBFL methods that store their argument
+// to IFLD and BFLD respectively.
+func indexBankFieldTestPayload(flags uint8) []byte {
+	return amltest.Concat(
+		// OperationRegion(REG0, SystemIO, 0, 8)
+		[]byte{0x5b, 0x80, 'R', 'E', 'G', '0', 0x01, 0x00, 0x0a, 0x08},
+		// Field(REG0, flags) { IDX_, 8, DAT_, 8, BNK_, 8 }
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{
+			'R', 'E', 'G', '0', flags,
+			'I', 'D', 'X', '_', 0x08,
+			'D', 'A', 'T', '_', 0x08,
+			'B', 'N', 'K', '_', 0x08,
+		}),
+		// IndexField(IDX_, DAT_, flags) { Offset(2), IFLD, 12 }
+		amltest.Pkg([]byte{0x5b, 0x86}, []byte{
+			'I', 'D', 'X', '_', 'D', 'A', 'T', '_', flags,
+			0x00, 0x10,
+			'I', 'F', 'L', 'D', 0x0c,
+		}),
+		// BankField(REG0, BNK_, 1, flags) { Offset(4), BFLD, 8 }
+		amltest.Pkg([]byte{0x5b, 0x87}, []byte{
+			'R', 'E', 'G', '0', 'B', 'N', 'K', '_', 0x01, flags,
+			0x00, 0x20,
+			'B', 'F', 'L', 'D', 0x08,
+		}),
+		// Method(WIFL, 1) { Store(Arg0, IFLD) }
+		amltest.Pkg([]byte{0x14}, []byte{'W', 'I', 'F', 'L', 0x01, 0x70, 0x68, 'I', 'F', 'L', 'D'}),
+		// Method(WBFL, 1) { Store(Arg0, BFLD) }
+		amltest.Pkg([]byte{0x14}, []byte{'W', 'B', 'F', 'L', 0x01, 0x70, 0x68, 'B', 'F', 'L', 'D'}),
+	)
+}
+
+type mockLocker struct {
+	acquireCount, releaseCount int
+}
+
+func (l *mockLocker) Acquire() { l.acquireCount++ }
+func (l *mockLocker) Release() { l.releaseCount++ }
@@ -0,0 +1,311 @@
+package aml
+
+import (
+	"gopheros/kernel/sync"
+	"unsafe"
+)
+
+const (
+	// The capacity of the smallest and largest Buffers and Packages whose
+	// storage is recycled by the valueHeap. Capacities are rounded up to
+	// the next power of two.
+	minPooledCap     = 16
+	numSizeClasses   = 9
+	maxPooledCap     = minPooledCap << (numSizeClasses - 1)
+	maxFreeListItems = 32
+)
+
+// HeapStats contains statistics about the Buffers and Packages allocated by
+// the VM while executing methods.
+type HeapStats struct {
+	// The number of allocated objects and how many of them reused the
+	// storage of a previously released object.
+	Allocated uint64
+	Reused    uint64
+
+	// The number of objects that were released when the method that
+	// owned them returned.
+	Released uint64
+
+	// The number of objects that are currently owned by executing
+	// methods.
+	Live uint64
+}
+
+// valueHeap manages the storage for the Buffers and Packages that are created
+// while executing AML methods. As the kernel heap is never garbage-collected,
+// storage that is not explicitly recycled is permanently lost. To avoid
+// leaking memory when long method chains execute at boot time, objects
+// created during method execution are reference-counted:
+//   - the method that allocates an object owns a reference to it until it
+//     returns; the reference to its return value is transferred to the
+//     caller.
+//   - Packages own a reference to each of their elements while References
+//     share the references of their container.
+//
+// When the reference count of an object drops to zero its storage is placed
+// in a per size-class free list and reused by subsequent allocations. Objects
+// that escape the VM (e.g. stored to a named object or returned by Evaluate)
+// stop being tracked and are never recycled.
+type valueHeap struct {
+	lock sync.Spinlock
+
+	refs         map[uintptr]uint32
+	freeBuffers  [numSizeClasses][][]byte
+	freePackages [numSizeClasses][][]interface{}
+
+	stats HeapStats
+}
+
+func newValueHeap() *valueHeap {
+	return &valueHeap{refs: make(map[uintptr]uint32)}
+}
+
+// HeapStats returns statistics about the objects allocated by the VM.
+func (vm *VM) HeapStats() HeapStats {
+	vm.heap.lock.Acquire()
+	defer vm.heap.lock.Release()
+	return vm.heap.stats
+}
+
+// allocBuffer returns a zeroed Buffer of the requested size that is owned by
+// ctx.
+func (vm *VM) allocBuffer(ctx *execContext, size int) []byte {
+	if size == 0 {
+		return []byte{}
+	}
+
+	h := vm.heap
+	h.lock.Acquire()
+	var buf []byte
+	if class, ok := sizeClass(size); ok {
+		if free := h.freeBuffers[class]; len(free) != 0 {
+			buf = free[len(free)-1][:size]
+			h.freeBuffers[class] = free[:len(free)-1]
+			for i := range buf {
+				buf[i] = 0
+			}
+			h.stats.Reused++
+		} else {
+			buf = make([]byte, size, minPooledCap<<class)
+		}
+	} else {
+		buf = make([]byte, size)
+	}
+	h.track(uintptr(unsafe.Pointer(&buf[0])))
+	h.lock.Release()
+
+	ctx.owned = append(ctx.owned, buf)
+	return buf
+}
+
+// allocPackage returns a Package with count uninitialized elements that is
+// owned by ctx.
+func (vm *VM) allocPackage(ctx *execContext, count int) []interface{} {
+	if count == 0 {
+		return []interface{}{}
+	}
+
+	h := vm.heap
+	h.lock.Acquire()
+	var pkg []interface{}
+	if class, ok := sizeClass(count); ok {
+		if free := h.freePackages[class]; len(free) != 0 {
+			pkg = free[len(free)-1][:count]
+			h.freePackages[class] = free[:len(free)-1]
+			h.stats.Reused++
+		} else {
+			pkg = make([]interface{}, count, minPooledCap<<class)
+		}
+	} else {
+		pkg = make([]interface{}, count)
+	}
+	h.track(uintptr(unsafe.Pointer(&pkg[0])))
+	h.lock.Release()
+
+	ctx.owned = append(ctx.owned, pkg)
+	return pkg
+}
+
+// copyLocal returns a deep copy of val whose storage is owned by ctx. It is
+// used for values that get stored to method-local locations (locals and
+// method args).
+func (vm *VM) copyLocal(ctx *execContext, val interface{}) interface{} {
+	switch typ := val.(type) {
+	case []byte:
+		out := vm.allocBuffer(ctx, len(typ))
+		copy(out, typ)
+		return out
+	case []interface{}:
+		out := vm.allocPackage(ctx, len(typ))
+		for i, elem := range typ {
+			vm.setPackageElement(out, i, vm.copyLocal(ctx, elem))
+		}
+		return out
+	default:
+		return val
+	}
+}
+
+// setPackageElement stores val as the element of pkg at index. The package
+// acquires a reference to val.
+func (vm *VM) setPackageElement(pkg []interface{}, index int, val interface{}) {
+	vm.heap.lock.Acquire()
+	vm.heap.retain(val)
+	vm.heap.lock.Release()
+	pkg[index] = val
+}
+
+// releaseOwned drops the references held by ctx. If the method executed by
+// ctx produced a return value, the reference to it is transferred to the
+// caller context.
+func (vm *VM) releaseOwned(ctx, caller *execContext, retVal interface{}) {
+	h := vm.heap
+	h.lock.Acquire()
+	if caller != nil && retVal != nil && h.retain(retVal) {
+		caller.owned = append(caller.owned, retVal)
+	}
+
+	for _, val := range ctx.owned {
+		h.release(val)
+	}
+	h.lock.Release()
+
+	ctx.owned = nil
+}
+
+// escape stops tracking val (and any values reachable through it) so that
+// its storage never gets recycled. It must be invoked for values that outlive
+// the method that created them.
+func (vm *VM) escape(val interface{}) {
+	vm.heap.lock.Acquire()
+	vm.heap.escape(val)
+	vm.heap.lock.Release()
+}
+
+// track registers a newly allocated object with a single reference.
+func (h *valueHeap) track(key uintptr) {
+	h.refs[key] = 1
+	h.stats.Allocated++
+	h.stats.Live++
+}
+
+// retain increments the reference count of val and returns true if val is
+// tracked by the heap.
+func (h *valueHeap) retain(val interface{}) bool {
+	if ref, isRef := val.(*Reference); isRef {
+		return h.retain(ref.Container)
+	}
+
+	key, ok := valueKey(val)
+	if !ok {
+		return false
+	}
+
+	if _, tracked := h.refs[key]; !tracked {
+		return false
+	}
+
+	h.refs[key]++
+	return true
+}
+
+// release decrements the reference count of val. Once the count reaches zero
+// the references held by Package elements are released and the storage of
+// val is placed in a free list.
+func (h *valueHeap) release(val interface{}) {
+	if ref, isRef := val.(*Reference); isRef {
+		h.release(ref.Container)
+		return
+	}
+
+	key, ok := valueKey(val)
+	if !ok {
+		return
+	}
+
+	refs, tracked := h.refs[key]
+	if !tracked {
+		return
+	}
+
+	if refs > 1 {
+		h.refs[key] = refs - 1
+		return
+	}
+
+	delete(h.refs, key)
+	h.stats.Released++
+	h.stats.Live--
+
+	switch typ := val.(type) {
+	case []byte:
+		if class, ok := sizeClass(cap(typ)); ok && cap(typ) == minPooledCap<<class && len(h.freeBuffers[class]) < maxFreeListItems {
+			h.freeBuffers[class] = append(h.freeBuffers[class], typ[:0])
+		}
+	case []interface{}:
+		for i, elem := range typ {
+			h.release(elem)
+			typ[i] = nil
+		}
+
+		if class, ok := sizeClass(cap(typ)); ok && cap(typ) == minPooledCap<<class && len(h.freePackages[class]) < maxFreeListItems {
+			h.freePackages[class] = append(h.freePackages[class], typ[:0])
+		}
+	}
+}
+
+// escape removes val and any values reachable through it from the heap.
+func (h *valueHeap) escape(val interface{}) {
+	if ref, isRef := val.(*Reference); isRef {
+		h.escape(ref.Container)
+		return
+	}
+
+	key, ok := valueKey(val)
+	if !ok {
+		return
+	}
+
+	if _, tracked := h.refs[key]; tracked {
+		delete(h.refs, key)
+		h.stats.Live--
+	}
+
+	if pkg, isPkg := val.([]interface{}); isPkg {
+		for _, elem := range pkg {
+			h.escape(elem)
+		}
+	}
+}
+
+// valueKey returns the key used for tracking the storage of a Buffer or
+// Package value.
+func valueKey(val interface{}) (uintptr, bool) {
+	switch typ := val.(type) {
+	case []byte:
+		if cap(typ) != 0 {
+			return uintptr(unsafe.Pointer(&typ[:1][0])), true
+		}
+	case []interface{}:
+		if cap(typ) != 0 {
+			return uintptr(unsafe.Pointer(&typ[:1][0])), true
+		}
+	}
+
+	return 0, false
+}
+
+// sizeClass returns the free list index for objects with the specified
+// capacity. It returns false if objects of that size are not pooled.
+func sizeClass(size int) (int, bool) {
+	if size > maxPooledCap {
+		return 0, false
+	}
+
+	class := 0
+	for minPooledCap<<uint(class) < size {
+		class++
+	}
+	return class, true
+}
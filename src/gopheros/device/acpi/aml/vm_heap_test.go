@@ -0,0 +1,104 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"reflect"
+	"testing"
+)
+
+func TestVMHeap(t *testing.T) {
+	t.Run("method-local objects are recycled", func(t *testing.T) {
+		// Store(Buffer() {0x01, 0x02}, Local0)
+		// Store(Package(2) { Local0, "foo" }, Local1)
+		// Return(SizeOf(Local1))
+		vm := vmForTestMethod(t, 0, amltest.Concat(
+			[]byte{0x70}, amltest.Buffer(0x01, 0x02), []byte{0x60},
+			[]byte{0x70}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x60, 0x0d, 'f', 'o', 'o', 0x00}), []byte{0x61},
+			[]byte{0xa4, 0x87, 0x61},
+		))
+
+		for i := 0; i < 3; i++ {
+			if got, err := vm.Evaluate(`\TEST`); err != nil || got != uint64(2) {
+				t.Fatalf("[iteration %d] expected to get 2; got %v (err: %v)", i, got, err)
+			}
+
+			if stats := vm.HeapStats(); stats.Live != 0 {
+				t.Fatalf("[iteration %d] expected all objects to be released; got %+v", i, stats)
+			}
+		}
+
+		stats := vm.HeapStats()
+		if stats.Reused == 0 || stats.Released != stats.Allocated {
+			t.Fatalf("expected storage to be reused across invocations; got %+v", stats)
+		}
+	})
+
+	t.Run("returned objects escape", func(t *testing.T) {
+		// Store(Buffer() {0x01, 0x02}, Local0)
+		// Return(Package(2) { Local0, Local0 })
+		vm := vmForTestMethod(t, 0, amltest.Concat(
+			[]byte{0x70}, amltest.Buffer(0x01, 0x02), []byte{0x60},
+			[]byte{0xa4}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x60, 0x60}),
+		))
+
+		exp := []interface{}{[]byte{0x01, 0x02}, []byte{0x01, 0x02}}
+		got, err := vm.Evaluate(`\TEST`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Subsequent invocations must not reuse the storage of the
+		// returned package.
+		for i := 0; i < 3; i++ {
+			if _, err = vm.Evaluate(`\TEST`); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("expected to get %v; got %v", exp, got)
+		}
+
+		if stats := vm.HeapStats(); stats.Live != 0 {
+			t.Fatalf("expected no live objects; got %+v", stats)
+		}
+	})
+
+	t.Run("return values are transferred to the caller", func(t *testing.T) {
+		// Method(PKG_) { Return(Package(1) { Buffer() {0x2a} }) }
+		// Method(TEST) {
+		//   Store(PKG_(), Local0)
+		//   Store(Buffer(8){}, Local1)
+		//   Return(DerefOf(Index(Local0, 0)))
+		// }
+		pkgMethod := amltest.Concat(
+			[]byte{'P', 'K', 'G', '_', 0x00},
+			[]byte{0xa4}, amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x01}, amltest.Buffer(0x2a))),
+		)
+		payload := amltest.Concat(
+			amltest.Pkg([]byte{0x14}, pkgMethod),
+			amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'T', 'E', 'S', 'T', 0x00},
+				[]byte{0x70, 'P', 'K', 'G', '_', 0x60},
+				[]byte{0x70}, amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x08}), []byte{0x61},
+				[]byte{0xa4, 0x83, 0x88, 0x60, 0x00, 0x00},
+			)),
+		)
+
+		vm := vmForPayload(t, payload)
+		for i := 0; i < 2; i++ {
+			got, err := vm.Evaluate(`\TEST`)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if exp := []byte{0x2a}; !reflect.DeepEqual(got, exp) {
+				t.Fatalf("[iteration %d] expected to get %v; got %v", i, exp, got)
+			}
+		}
+
+		if stats := vm.HeapStats(); stats.Live != 0 {
+			t.Fatalf("expected no live objects; got %+v", stats)
+		}
+	})
+}
@@ -0,0 +1,98 @@
+package aml
+
+// populateJumpTable assigns the functions that implement the various AML
+// opcodes to the VM's jump table. Opcodes without a handler cause the VM to
+// abort method execution with errUnsupportedOpcode.
+func (vm *VM) populateJumpTable() {
+	// Named object declarations are processed by the parser; the VM just
+	// skips over them when they appear inside a method body.
+	for _, op := range []uint16{
+		pOpAlias, pOpMethod, pOpExternal, pOpMutex, pOpEvent, pOpField, pOpIndexField, pOpBankField, pOpDataRegion, pOpDevice,
+		pOpProcessor, pOpPowerRes, pOpThermalZone, pOpIntNamedField,
+		pOpIntConnection,
+	} {
+		vm.setHandler(op, vmOpNoop)
+	}
+
+	// Control flow
+	vm.setHandler(pOpIf, vmOpIf)
+	vm.setHandler(pOpElse, vmOpNoop)
+	vm.setHandler(pOpWhile, vmOpWhile)
+	vm.setHandler(pOpBreak, vmOpBreak)
+	vm.setHandler(pOpContinue, vmOpContinue)
+	vm.setHandler(pOpReturn, vmOpReturn)
+	vm.setHandler(pOpNoop, vmOpNoop)
+	vm.setHandler(pOpBreakPoint, vmOpNoop)
+	vm.setHandler(pOpIntMethodCall, vmOpMethodCall)
+	vm.setHandler(pOpName, vmOpName)
+	vm.setHandler(pOpOpRegion, vmOpOpRegion)
+
+	// Synchronization
+	vm.setHandler(pOpAcquire, vmOpAcquire)
+	vm.setHandler(pOpRelease, vmOpRelease)
+	vm.setHandler(pOpWait, vmOpWait)
+	vm.setHandler(pOpSignal, vmOpSignal)
+	vm.setHandler(pOpReset, vmOpReset)
+
+	// Notifications
+	vm.setHandler(pOpNotify, vmOpNotify)
+
+	// Dynamic table loading
+	vm.setHandler(pOpLoad, vmOpLoad)
+	vm.setHandler(pOpLoadTable, vmOpLoadTable)
+
+	// Data objects, stores and references
+	vm.setHandler(pOpBuffer, vmOpBuffer)
+	vm.setHandler(pOpPackage, vmOpPackage)
+	vm.setHandler(pOpVarPackage, vmOpPackage)
+	vm.setHandler(pOpStore, vmOpStore)
+	vm.setHandler(pOpCopyObject, vmOpCopyObject)
+	vm.setHandler(pOpRefOf, vmOpRefOf)
+	vm.setHandler(pOpDerefOf, vmOpDerefOf)
+	vm.setHandler(pOpIndex, vmOpIndex)
+	vm.setHandler(pOpSizeOf, vmOpSizeOf)
+	vm.setHandler(pOpMid, vmOpMid)
+	vm.setHandler(pOpMatch, vmOpMatch)
+
+	// Buffer fields
+	for _, op := range []uint16{
+		pOpCreateField, pOpCreateBitField, pOpCreateByteField,
+		pOpCreateWordField, pOpCreateDWordField, pOpCreateQWordField,
+	} {
+		vm.setHandler(op, vmOpCreateField)
+	}
+
+	// Arithmetic
+	vm.setHandler(pOpAdd, vmOpAdd)
+	vm.setHandler(pOpSubtract, vmOpSubtract)
+	vm.setHandler(pOpMultiply, vmOpMultiply)
+	vm.setHandler(pOpDivide, vmOpDivide)
+	vm.setHandler(pOpMod, vmOpMod)
+	vm.setHandler(pOpShiftLeft, vmOpShiftLeft)
+	vm.setHandler(pOpShiftRight, vmOpShiftRight)
+	vm.setHandler(pOpAnd, vmOpAnd)
+	vm.setHandler(pOpNand, vmOpNand)
+	vm.setHandler(pOpOr, vmOpOr)
+	vm.setHandler(pOpNor, vmOpNor)
+	vm.setHandler(pOpXor, vmOpXor)
+	vm.setHandler(pOpNot, vmOpNot)
+	vm.setHandler(pOpFindSetLeftBit, vmOpFindSetLeftBit)
+	vm.setHandler(pOpFindSetRightBit, vmOpFindSetRightBit)
+	vm.setHandler(pOpIncrement, vmOpIncrement)
+	vm.setHandler(pOpDecrement, vmOpDecrement)
+	vm.setHandler(pOpConcat, vmOpConcat)
+	vm.setHandler(pOpConcatRes, vmOpConcatRes)
+
+	// Logic
+	vm.setHandler(pOpLand, vmOpLand)
+	vm.setHandler(pOpLor, vmOpLor)
+	vm.setHandler(pOpLnot, vmOpLnot)
+	vm.setHandler(pOpLEqual, vmOpLEqual)
+	vm.setHandler(pOpLGreater, vmOpLGreater)
+	vm.setHandler(pOpLLess, vmOpLLess)
+}
+
+// setHandler registers handler as the implementation for opcode op.
+func (vm *VM) setHandler(op uint16, handler opHandler) {
+	vm.jumpTable[pOpcodeTableIndex(op, true)] = handler
+}
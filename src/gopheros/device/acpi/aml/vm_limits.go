@@ -0,0 +1,59 @@
+package aml
+
+import "gopheros/kernel"
+
+var (
+	errMaxLoopIterationsReached = &kernel.Error{Module: "acpi_aml_vm", Message: "maximum While loop iteration count reached", Code: kernel.ErrCodeTimeout}
+	errMaxOpsReached            = &kernel.Error{Module: "acpi_aml_vm", Message: "maximum number of executed opcodes reached", Code: kernel.ErrCodeTimeout}
+)
+
+// ExecLimits bounds the amount of work that the VM performs while servicing
+// a single Evaluate call. Buggy firmware may contain While loops whose
+// predicate never becomes false or methods that recurse indefinitely; when a
+// limit is exceeded the VM aborts the evaluation and reports the offending
+// opcode to its error writer instead of hanging the kernel. A zero value for
+// any of the limits disables it.
+type ExecLimits struct {
+	// MaxLoopIterations limits the number of iterations that a single
+	// While block may execute.
+	MaxLoopIterations uint64
+
+	// MaxCallDepth limits the number of nested method invocations.
+	MaxCallDepth uint32
+
+	// MaxOps limits the total number of opcodes that may be executed by
+	// a single Evaluate call.
+	MaxOps uint64
+}
+
+// DefaultExecLimits contains the limits used by VMs created via NewVM.
+var DefaultExecLimits = ExecLimits{
+	MaxLoopIterations: 0xffff,
+	MaxCallDepth:      64,
+	MaxOps:            1 << 24,
+}
+
+// SetExecLimits replaces the execution limits enforced by the VM.
+func (vm *VM) SetExecLimits(limits ExecLimits) {
+	vm.limits = limits
+}
+
+// ExecLimits returns the execution limits enforced by the VM.
+func (vm *VM) ExecLimits() ExecLimits {
+	return vm.limits
+}
+
+// countOp increments the number of opcodes executed by the thread that ctx
+// belongs to and returns an error if the MaxOps execution limit is exceeded.
+func (vm *VM) countOp(ctx *execContext, obj *Object) *kernel.Error {
+	if ctx.thread == nil {
+		return nil
+	}
+
+	ctx.thread.opCount++
+	if limit := vm.limits.MaxOps; limit != 0 && ctx.thread.opCount > limit {
+		return vm.fail(obj, errMaxOpsReached)
+	}
+
+	return nil
+}
@@ -0,0 +1,89 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/kernel"
+	"strings"
+	"testing"
+)
+
+func TestVMExecLimits(t *testing.T) {
+	var (
+		// Store(0, Local0)
+		// While(LLess(Local0, 10)) { Increment(Local0) }
+		// Return(Local0)
+		countTo10 = amltest.Concat(
+			[]byte{0x70, 0x00, 0x60},
+			amltest.Pkg([]byte{0xa2}, []byte{0x95, 0x60, 0x0a, 0x0a, 0x75, 0x60}),
+			[]byte{0xa4, 0x60},
+		)
+
+		// While(One) { Noop }
+		infiniteLoop = amltest.Pkg([]byte{0xa2}, []byte{0x01, 0xa3})
+
+		// If(LGreater(Arg0, 0)) { Return(TEST(Subtract(Arg0, 1))) }
+		// Return(0)
+		recurse = amltest.Concat(
+			amltest.Pkg([]byte{0xa0}, []byte{
+				0x94, 0x68, 0x00,
+				0xa4, 'T', 'E', 'S', 'T', 0x74, 0x68, 0x01, 0x00,
+			}),
+			[]byte{0xa4, 0x00},
+		)
+	)
+
+	specs := []struct {
+		argCount uint8
+		body     []byte
+		args     []interface{}
+		limits   ExecLimits
+		expErr   *kernel.Error
+	}{
+		{0, countTo10, nil, DefaultExecLimits, nil},
+		{0, countTo10, nil, ExecLimits{MaxLoopIterations: 10}, nil},
+		{0, countTo10, nil, ExecLimits{MaxLoopIterations: 9}, errMaxLoopIterationsReached},
+		{0, infiniteLoop, nil, DefaultExecLimits, errMaxLoopIterationsReached},
+		{0, infiniteLoop, nil, ExecLimits{MaxOps: 100}, errMaxOpsReached},
+		{1, recurse, []interface{}{4}, ExecLimits{MaxCallDepth: 5}, nil},
+		{1, recurse, []interface{}{5}, ExecLimits{MaxCallDepth: 5}, errMaxCallDepthReached},
+		{1, recurse, []interface{}{100}, DefaultExecLimits, errMaxCallDepthReached},
+		{1, recurse, []interface{}{100}, ExecLimits{MaxCallDepth: 200, MaxOps: 50}, errMaxOpsReached},
+	}
+
+	for specIndex, spec := range specs {
+		var errBuf bytes.Buffer
+
+		vm := vmForTestMethod(t, spec.argCount, spec.body)
+		vm.errWriter = &errBuf
+		vm.SetExecLimits(spec.limits)
+
+		if got := vm.ExecLimits(); got != spec.limits {
+			t.Errorf("[spec %d] expected ExecLimits to return %+v; got %+v", specIndex, spec.limits, got)
+		}
+
+		_, err := vm.Evaluate(`\TEST`, spec.args...)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		// A single diagnostic must be reported for aborted evaluations
+		if err != nil {
+			if got := strings.Count(errBuf.String(), err.Message); got != 1 {
+				t.Errorf("[spec %d] expected the error to be reported once; got log %q", specIndex, errBuf.String())
+			}
+		}
+	}
+
+	t.Run("op count is tracked per Evaluate call", func(t *testing.T) {
+		vm := vmForTestMethod(t, 0, countTo10)
+		vm.SetExecLimits(ExecLimits{MaxOps: 64})
+
+		for i := 0; i < 3; i++ {
+			if got, err := vm.Evaluate(`\TEST`); err != nil || got != uint64(10) {
+				t.Fatalf("[call %d] expected to get 10; got %v (err: %v)", i, got, err)
+			}
+		}
+	})
+}
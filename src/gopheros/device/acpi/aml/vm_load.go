@@ -0,0 +1,282 @@
+package aml
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	errInvalidTable          = &kernel.Error{Module: "acpi_aml_vm", Message: "table has an invalid signature, length or checksum", Code: kernel.ErrCodeCorrupted}
+	errTooManyTables         = &kernel.Error{Module: "acpi_aml_vm", Message: "no free table handles", Code: kernel.ErrCodeBusy}
+	errUnsupportedLoadSource = &kernel.Error{Module: "acpi_aml_vm", Message: "Load source must be an OperationRegion, a Field or a Buffer", Code: kernel.ErrCodeInvalidArgument}
+	errUnsupportedLoadScope  = &kernel.Error{Module: "acpi_aml_vm", Message: "tables can only be loaded relative to the root scope", Code: kernel.ErrCodeNotSupported}
+)
+
+// sdtHeaderLen is the size in bytes of an ACPI table header.
+const sdtHeaderLen = uint32(unsafe.Sizeof(table.SDTHeader{}))
+
+// TableLoadHandler is invoked by the VM after a Load or LoadTable opcode
+// dynamically loads a table. The handler receives the handle that has been
+// assigned to the table and its header.
+type TableLoadHandler func(tableHandle uint8, header *table.SDTHeader)
+
+// SetTableResolver registers the resolver that is used by the LoadTable
+// opcode for locating tables by their signature (typically via the XSDT).
+// If no resolver is registered, LoadTable always reports that the requested
+// table could not be found.
+func (vm *VM) SetTableResolver(resolver table.Resolver) {
+	vm.tableResolver = resolver
+}
+
+// SetTableLoadHandler registers a handler that gets notified each time that a
+// table is dynamically loaded by the AML code. Passing a nil handler removes
+// any previously registered handler.
+func (vm *VM) SetTableLoadHandler(handler TableLoadHandler) {
+	vm.tableLoadHandler = handler
+}
+
+// vmOpLoad loads the SSDT whose contents are provided by the first arg of obj
+// (an OperationRegion, a Field or a Buffer), merges its definitions into the
+// namespace and stores the handle assigned to the table to the second arg.
+func vmOpLoad(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	src, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch typ := src.(type) {
+	case []byte:
+		data = make([]byte, len(typ))
+		copy(data, typ)
+	case *Object:
+		if typ.opcode != pOpOpRegion {
+			return vm.fail(obj, errUnsupportedLoadSource)
+		}
+
+		region, err := vm.region(ctx, typ)
+		if err != nil {
+			return err
+		}
+
+		if data, err = vm.readRegionTable(region); err != nil {
+			return vm.fail(obj, err)
+		}
+	default:
+		return vm.fail(obj, errUnsupportedLoadSource)
+	}
+
+	if uint32(len(data)) < sdtHeaderLen {
+		return vm.fail(obj, errInvalidTable)
+	}
+
+	header := (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	if header.Length > uint32(len(data)) {
+		return vm.fail(obj, errInvalidTable)
+	}
+
+	tableHandle, err := vm.loadTable(header)
+	if err != nil {
+		return vm.fail(obj, err)
+	}
+
+	return vm.store(ctx, uint64(tableHandle), vm.targetArg(obj, 1))
+}
+
+// vmOpLoadTable locates the table that matches the signature, OEM ID and OEM
+// table ID specified by the first three args of obj using the registered
+// table resolver and merges its definitions into the namespace. Empty OEM ID
+// and OEM table ID args match any value. Once the table is loaded, the
+// optional ParameterData arg is stored to the object specified by the
+// ParameterPath arg.
+//
+// The opcode returns the handle assigned to the loaded table or Zero if no
+// matching table could be found.
+func vmOpLoadTable(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	var strArgs [5]string
+	for argIndex := range strArgs {
+		val, err := vm.evalArg(ctx, obj, uint32(argIndex))
+		if err != nil {
+			return err
+		}
+
+		if strArgs[argIndex], err = vm.toString(val); err != nil {
+			return vm.fail(obj, err)
+		}
+	}
+
+	signature, oemID, oemTableID, rootPath, paramPath := strArgs[0], strArgs[1], strArgs[2], strArgs[3], strArgs[4]
+	if rootPath != "" && rootPath != `\` {
+		return vm.fail(obj, errUnsupportedLoadScope)
+	}
+
+	ctx.retVal = uint64(0)
+	if vm.tableResolver == nil {
+		return nil
+	}
+
+	header := vm.tableResolver.LookupTable(signature)
+	if header == nil || !matchTableID(header.OEMID[:], oemID) || !matchTableID(header.OEMTableID[:], oemTableID) {
+		return nil
+	}
+
+	tableHandle, err := vm.loadTable(header)
+	if err != nil {
+		return vm.fail(obj, err)
+	}
+
+	if paramPath != "" {
+		paramData, err := vm.evalArg(ctx, obj, 5)
+		if err != nil {
+			return err
+		}
+
+		node := vm.tree.Namespace().Lookup(nil, paramPath)
+		if node == nil {
+			return vm.fail(obj, errPathNotFound)
+		}
+
+		if err = vm.storeToNamedObject(ctx, node.Object(), paramData, storeModeConvert); err != nil {
+			return err
+		}
+	}
+
+	ctx.retVal = uint64(tableHandle)
+	return nil
+}
+
+// readRegionTable reads the table header stored at the beginning of region
+// followed by the remaining table contents.
+func (vm *VM) readRegionTable(region *Region) ([]byte, *kernel.Error) {
+	if region.Length < uint64(sdtHeaderLen) {
+		return nil, errInvalidTable
+	}
+
+	data, err := vm.readRegionBytes(region, make([]byte, 0, sdtHeaderLen), sdtHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+
+	tableLen := (*table.SDTHeader)(unsafe.Pointer(&data[0])).Length
+	if tableLen < sdtHeaderLen || uint64(tableLen) > region.Length {
+		return nil, errInvalidTable
+	}
+
+	return vm.readRegionBytes(region, data, tableLen)
+}
+
+// readRegionBytes appends the region contents from offset len(data) up to
+// offset end to data and returns the updated slice.
+func (vm *VM) readRegionBytes(region *Region, data []byte, end uint32) ([]byte, *kernel.Error) {
+	for offset := uint32(len(data)); offset < end; offset++ {
+		val, err := vm.readRegion(region, uint64(offset), 8)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, byte(val))
+	}
+
+	return data, nil
+}
+
+// loadTable validates the table described by header, parses its contents
+// into the VM's object tree and notifies the registered TableLoadHandler.
+// Only SSDT, PSDT and OEM-specific tables can be dynamically loaded.
+func (vm *VM) loadTable(header *table.SDTHeader) (uint8, *kernel.Error) {
+	switch sig := string(header.Signature[:]); {
+	case sig == "SSDT", sig == "PSDT", sig[:3] == "OEM":
+	default:
+		return 0, errInvalidTable
+	}
+
+	if header.Length < sdtHeaderLen {
+		return 0, errInvalidTable
+	}
+
+	var sum uint8
+	for _, b := range tableBytes(header) {
+		sum += b
+	}
+	if sum != 0 {
+		return 0, errInvalidTable
+	}
+
+	tableHandle, err := vm.nextTableHandle()
+	if err != nil {
+		return 0, err
+	}
+
+	if err = NewParser(vm.errWriter, vm.tree).ParseAML(tableHandle, string(header.Signature[:]), header); err != nil {
+		return 0, err
+	}
+
+	// Keep a reference to the table contents so that they remain
+	// reachable for as long as the objects that point to them.
+	vm.loadedTables[tableHandle] = header
+
+	if vm.tableLoadHandler != nil {
+		vm.tableLoadHandler(tableHandle, header)
+	}
+
+	return tableHandle, nil
+}
+
+// nextTableHandle returns a table handle that is not used by any of the
+// objects in the VM's object tree.
+func (vm *VM) nextTableHandle() (uint8, *kernel.Error) {
+	var maxHandle uint8
+	for _, obj := range vm.tree.objPool {
+		if obj.tableHandle > maxHandle {
+			maxHandle = obj.tableHandle
+		}
+	}
+
+	for tableHandle := range vm.loadedTables {
+		if tableHandle > maxHandle {
+			maxHandle = tableHandle
+		}
+	}
+
+	if maxHandle == 0xff {
+		return 0, errTooManyTables
+	}
+
+	return maxHandle + 1, nil
+}
+
+// tableBytes returns a byte slice that overlays the contents of the table
+// described by header.
+func tableBytes(header *table.SDTHeader) []byte {
+	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Len:  int(header.Length),
+		Cap:  int(header.Length),
+		Data: uintptr(unsafe.Pointer(header)),
+	}))
+}
+
+// matchTableID returns true if id is empty or if it matches the contents of
+// a space or NULL-padded table ID field.
+func matchTableID(field []byte, id string) bool {
+	if id == "" {
+		return true
+	}
+
+	if len(id) > len(field) {
+		return false
+	}
+
+	for i := 0; i < len(field); i++ {
+		switch {
+		case i < len(id):
+			if field[i] != id[i] {
+				return false
+			}
+		case field[i] != ' ' && field[i] != 0:
+			return false
+		}
+	}
+
+	return true
+}
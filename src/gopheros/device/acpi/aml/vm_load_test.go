@@ -0,0 +1,287 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"testing"
+	"unsafe"
+)
+
+func TestVMLoad(t *testing.T) {
+	ssdt := ssdtImage("SSDT", "MYOEM", loadTestSSDTPayload)
+	badSum := ssdtImage("SSDT", "MYOEM", loadTestSSDTPayload)
+	badSum[9]++
+	badSig := ssdtImage("FACP", "MYOEM", loadTestSSDTPayload)
+
+	specs := []struct {
+		regionData []byte
+		bufData    []byte
+		body       []byte
+		expErr     *kernel.Error
+	}{
+		// Load(BUF0, Local0); Return(Local0)
+		{
+			nil,
+			ssdt,
+			[]byte{0x5b, 0x20, 'B', 'U', 'F', '0', 0x60, 0xa4, 0x60},
+			nil,
+		},
+		// Load(SSDR, Local0); Return(Local0)
+		{
+			ssdt,
+			ssdt,
+			[]byte{0x5b, 0x20, 'S', 'S', 'D', 'R', 0x60, 0xa4, 0x60},
+			nil,
+		},
+		// Load a table with an invalid checksum
+		{
+			badSum,
+			badSum,
+			[]byte{0x5b, 0x20, 'S', 'S', 'D', 'R', 0x60},
+			errInvalidTable,
+		},
+		// Load a table with an unsupported signature
+		{
+			nil,
+			badSig,
+			[]byte{0x5b, 0x20, 'B', 'U', 'F', '0', 0x60},
+			errInvalidTable,
+		},
+		// Load a buffer that is shorter than the table header
+		{
+			nil,
+			ssdt[:8],
+			[]byte{0x5b, 0x20, 'B', 'U', 'F', '0', 0x60},
+			errInvalidTable,
+		},
+		// Load a table from an Integer
+		{
+			nil,
+			ssdt,
+			[]byte{0x5b, 0x20, 'I', 'N', 'T', '0', 0x60},
+			errUnsupportedLoadSource,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, loadTestPayload(spec.bufData, uint8(len(spec.regionData)), spec.body))
+		vm.RegisterRegionHandler(RegionSpaceSystemMemory, &mockRegionHandler{data: spec.regionData})
+
+		var loaded []uint8
+		vm.SetTableLoadHandler(func(tableHandle uint8, header *table.SDTHeader) {
+			if exp, got := "SSDT", string(header.Signature[:]); got != exp {
+				t.Errorf("[spec %d] expected load handler to receive table %q; got %q", specIndex, exp, got)
+			}
+			loaded = append(loaded, tableHandle)
+		})
+
+		got, err := vm.Evaluate(`TEST`)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if spec.expErr != nil {
+			if len(loaded) != 0 {
+				t.Errorf("[spec %d] expected load handler not to be invoked", specIndex)
+			}
+			continue
+		}
+
+		if len(loaded) != 1 || got != uint64(loaded[0]) {
+			t.Errorf("[spec %d] expected Load to return the handle passed to the load handler; got %#v, handler calls: %v", specIndex, got, loaded)
+			continue
+		}
+
+		// The definitions in the loaded table should be accessible
+		if got, err = vm.Evaluate(`\_SB.NEWM`); err != nil || got != uint64(0x2a) {
+			t.Errorf("[spec %d] expected loaded method to return 0x2a; got %#v, %v", specIndex, got, err)
+		}
+
+		// Loading the same table again should allocate a new handle
+		if got, err = vm.Evaluate(`TEST`); err != nil || got != uint64(loaded[0]+1) {
+			t.Errorf("[spec %d] expected second Load to return handle %d; got %#v, %v", specIndex, loaded[0]+1, got, err)
+		}
+	}
+}
+
+func TestVMLoadTable(t *testing.T) {
+	loadTable := func(sig, oemID, rootPath, paramPath string) []byte {
+		str := func(s string) []byte { return amltest.Concat([]byte{0x0d}, []byte(s), []byte{0x00}) }
+		// Return(LoadTable(sig, oemID, "", rootPath, paramPath, 5))
+		return amltest.Concat(
+			[]byte{0xa4, 0x5b, 0x1f},
+			str(sig), str(oemID), str(""), str(rootPath), str(paramPath),
+			[]byte{0x0a, 0x05},
+		)
+	}
+
+	specs := []struct {
+		resolver table.Resolver
+		body     []byte
+		expVal   interface{}
+		expPRM0  interface{}
+		expErr   *kernel.Error
+	}{
+		{
+			ssdtResolver(ssdtImage("SSDT", "MYOEM", loadTestSSDTPayload)),
+			loadTable("SSDT", "MYOEM", `\`, `\_SB.PRM0`),
+			uint64(1),
+			uint64(5),
+			nil,
+		},
+		// Missing OEM ID matches any table
+		{
+			ssdtResolver(ssdtImage("OEM1", "MYOEM", loadTestSSDTPayload)),
+			loadTable("OEM1", "", "", ""),
+			uint64(1),
+			uint64(0),
+			nil,
+		},
+		// OEM ID mismatch
+		{
+			ssdtResolver(ssdtImage("SSDT", "MYOEM", loadTestSSDTPayload)),
+			loadTable("SSDT", "OTHER", "", ""),
+			uint64(0),
+			nil,
+			nil,
+		},
+		// No resolver
+		{
+			nil,
+			loadTable("SSDT", "", "", ""),
+			uint64(0),
+			nil,
+			nil,
+		},
+		// Loading relative to a non-root scope
+		{
+			ssdtResolver(ssdtImage("SSDT", "MYOEM", loadTestSSDTPayload)),
+			loadTable("SSDT", "", `\_SB`, ""),
+			nil,
+			nil,
+			errUnsupportedLoadScope,
+		},
+		// Unknown parameter path
+		{
+			ssdtResolver(ssdtImage("SSDT", "MYOEM", loadTestSSDTPayload)),
+			loadTable("SSDT", "", "", "FOOO"),
+			nil,
+			nil,
+			errPathNotFound,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, loadTestPayload(nil, 0, spec.body))
+		if spec.resolver != nil {
+			vm.SetTableResolver(spec.resolver)
+		}
+
+		got, err := vm.Evaluate(`TEST`)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if spec.expErr != nil {
+			continue
+		}
+
+		if got != spec.expVal {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.expVal, got)
+		}
+
+		if spec.expPRM0 == nil {
+			if vm.tree.Namespace().Lookup(nil, `\_SB.PRM0`) != nil {
+				t.Errorf("[spec %d] expected table not to be loaded", specIndex)
+			}
+			continue
+		}
+
+		if got, err = vm.Evaluate(`\_SB.PRM0`); err != nil || got != spec.expPRM0 {
+			t.Errorf("[spec %d] expected PRM0 to be %#v; got %#v, %v", specIndex, spec.expPRM0, got, err)
+		}
+	}
+}
+
+func TestMatchTableID(t *testing.T) {
+	specs := []struct {
+		field []byte
+		id    string
+		exp   bool
+	}{
+		{[]byte("MYOEM "), "", true},
+		{[]byte("MYOEM "), "MYOEM", true},
+		{[]byte{'M', 'Y', 'O', 'E', 'M', 0}, "MYOEM", true},
+		{[]byte("MYOEM1"), "MYOEM", false},
+		{[]byte("MYOEM "), "MYOEM12", false},
+		{[]byte("MYOEM "), "OTHER", false},
+	}
+
+	for specIndex, spec := range specs {
+		if got := matchTableID(spec.field, spec.id); got != spec.exp {
+			t.Errorf("[spec %d] expected matchTableID(%q, %q) to return %t", specIndex, spec.field, spec.id, spec.exp)
+		}
+	}
+}
+
+// loadTestSSDTPayload defines a method that returns 0x2a and an integer Name
+// inside the \_SB scope.
+var loadTestSSDTPayload = amltest.Pkg([]byte{0x10}, amltest.Concat(
+	[]byte{'_', 'S', 'B', '_'},
+	// Method(NEWM, 0) { Return(0x2a) }
+	amltest.Pkg([]byte{0x14}, []byte{'N', 'E', 'W', 'M', 0x00, 0xa4, 0x0a, 0x2a}),
+	// Name(PRM0, 0)
+	[]byte{0x08, 'P', 'R', 'M', '0', 0x00},
+))
+
+// loadTestPayload returns an AML payload that defines a Buffer called BUF0
+// with the supplied contents, a SystemMemory OperationRegion called SSDR with
+// the supplied length, an integer Name called INT0 and a method called TEST
+// with the supplied body.
+func loadTestPayload(bufData []byte, regionLen uint8, body []byte) []byte {
+	return amltest.Concat(
+		// Name(BUF0, Buffer() { bufData })
+		[]byte{0x08, 'B', 'U', 'F', '0'},
+		amltest.Pkg([]byte{0x11}, amltest.Concat([]byte{0x0a, byte(len(bufData))}, bufData)),
+		// OperationRegion(SSDR, SystemMemory, 0, regionLen)
+		[]byte{0x5b, 0x80, 'S', 'S', 'D', 'R', 0x00, 0x00, 0x0a, regionLen},
+		// Name(INT0, 1)
+		[]byte{0x08, 'I', 'N', 'T', '0', 0x01},
+		// Method(TEST, 0) { body }
+		amltest.Pkg([]byte{0x14}, amltest.Concat([]byte{'T', 'E', 'S', 'T', 0x00}, body)),
+	)
+}
+
+// ssdtImage returns the contents of a table with the supplied signature, OEM
+// ID and AML payload and a valid checksum.
+func ssdtImage(signature, oemID string, payload []byte) []byte {
+	headerLen := unsafe.Sizeof(table.SDTHeader{})
+	data := make([]byte, int(headerLen)+len(payload))
+	copy(data[headerLen:], payload)
+
+	header := (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	copy(header.Signature[:], signature)
+	copy(header.OEMID[:], "      ")
+	copy(header.OEMID[:], oemID)
+	header.Length = uint32(len(data))
+	header.Revision = 2
+
+	var sum uint8
+	for _, b := range data {
+		sum += b
+	}
+	header.Checksum = -sum
+
+	return data
+}
+
+// ssdtResolver is a table.Resolver that returns the same table image for any
+// requested signature.
+type ssdtResolver []byte
+
+func (r ssdtResolver) LookupTable(string) *table.SDTHeader {
+	return (*table.SDTHeader)(unsafe.Pointer(&r[0]))
+}
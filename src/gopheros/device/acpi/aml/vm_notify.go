@@ -0,0 +1,158 @@
+package aml
+
+import "gopheros/kernel"
+
+var (
+	errNotNotifiable         = &kernel.Error{Module: "acpi_aml_vm", Message: "Notify target must be a Device, Processor or ThermalZone object", Code: kernel.ErrCodeInvalidArgument}
+	errNotifyHandlerExists   = &kernel.Error{Module: "acpi_aml_vm", Message: "a notify handler is already installed for this object", Code: kernel.ErrCodeAlreadyExists}
+	errNotifyHandlerNotFound = &kernel.Error{Module: "acpi_aml_vm", Message: "no notify handler is installed for this object", Code: kernel.ErrCodeNotFound}
+)
+
+// The notification values defined by the ACPI spec. Values 0x80 to 0xbf are
+// device-specific while values 0xc0 to 0xff are reserved for OEM use.
+const (
+	NotifyBusCheck          = uint64(0x00)
+	NotifyDeviceCheck       = uint64(0x01)
+	NotifyDeviceWake        = uint64(0x02)
+	NotifyEjectRequest      = uint64(0x03)
+	NotifyDeviceCheckLight  = uint64(0x04)
+	NotifyFrequencyMismatch = uint64(0x05)
+	NotifyBusModeMismatch   = uint64(0x06)
+	NotifyPowerFault        = uint64(0x07)
+)
+
+// NotifyHandler is a function that receives the notification values sent to
+// a Device, Processor or ThermalZone object via the AML Notify opcode.
+type NotifyHandler func(node *NamespaceNode, value uint64)
+
+// notification describes a Notify event that has not yet been delivered.
+type notification struct {
+	objIndex uint32
+	value    uint64
+}
+
+// InstallNotifyHandler registers handler as the recipient of the notifications
+// sent to the Device, Processor or ThermalZone object at path. Only a single
+// handler may be installed for each object.
+func (vm *VM) InstallNotifyHandler(path string, handler NotifyHandler) *kernel.Error {
+	obj, err := vm.notifyTarget(path)
+	if err != nil {
+		return err
+	}
+
+	vm.notifyLock.Acquire()
+	defer vm.notifyLock.Release()
+
+	if _, exists := vm.notifyHandlers[obj.index]; exists {
+		return errNotifyHandlerExists
+	}
+
+	vm.notifyHandlers[obj.index] = handler
+	return nil
+}
+
+// RemoveNotifyHandler removes the notify handler installed for the object at
+// path. Any notifications for the object that are still pending are dropped.
+func (vm *VM) RemoveNotifyHandler(path string) *kernel.Error {
+	obj, err := vm.notifyTarget(path)
+	if err != nil {
+		return err
+	}
+
+	vm.notifyLock.Acquire()
+	defer vm.notifyLock.Release()
+
+	if _, exists := vm.notifyHandlers[obj.index]; !exists {
+		return errNotifyHandlerNotFound
+	}
+
+	delete(vm.notifyHandlers, obj.index)
+	return nil
+}
+
+// DispatchNotifications delivers the notifications queued by the Notify
+// opcode to their handlers in the order they were raised and returns the
+// number of delivered notifications. Notifications for objects without a
+// handler are dropped. As handlers typically evaluate AML objects, they are
+// never invoked while a method is executing; instead, the code that drives
+// the VM (e.g. the GPE dispatcher) is expected to call DispatchNotifications
+// once method execution completes.
+func (vm *VM) DispatchNotifications() int {
+	var delivered int
+
+	for {
+		vm.notifyLock.Acquire()
+		if len(vm.pendingNotifications) == 0 {
+			vm.notifyLock.Release()
+			return delivered
+		}
+
+		next := vm.pendingNotifications[0]
+		vm.pendingNotifications = vm.pendingNotifications[1:]
+		handler := vm.notifyHandlers[next.objIndex]
+		vm.notifyLock.Release()
+
+		if handler == nil {
+			continue
+		}
+
+		handler(vm.tree.Namespace().NodeFor(vm.tree.ObjectAt(next.objIndex)), next.value)
+		delivered++
+	}
+}
+
+// notifyTarget resolves path into a Device, Processor or ThermalZone object.
+func (vm *VM) notifyTarget(path string) (*Object, *kernel.Error) {
+	if vm.tree == nil {
+		return nil, errNilObjectTree
+	}
+
+	node := vm.tree.Namespace().Lookup(nil, path)
+	if node == nil {
+		return nil, errPathNotFound
+	}
+
+	if !isNotifiable(node.Object()) {
+		return nil, errNotNotifiable
+	}
+
+	return node.Object(), nil
+}
+
+// vmOpNotify queues the notification value specified by the second arg of obj
+// for delivery to the handler of the object referenced by the first arg.
+func vmOpNotify(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	val, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	if ref, isRef := val.(*Reference); isRef && ref.Target != nil {
+		val = ref.Target
+	}
+
+	target, ok := val.(*Object)
+	if !ok || !isNotifiable(target) {
+		return vm.fail(obj, errNotNotifiable)
+	}
+
+	value, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	vm.notifyLock.Acquire()
+	vm.pendingNotifications = append(vm.pendingNotifications, notification{objIndex: target.index, value: value})
+	vm.notifyLock.Release()
+	return nil
+}
+
+// isNotifiable returns true if obj can be the target of a Notify opcode.
+func isNotifiable(obj *Object) bool {
+	switch obj.opcode {
+	case pOpDevice, pOpProcessor, pOpThermalZone:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,111 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"reflect"
+	"testing"
+)
+
+func TestVMNotify(t *testing.T) {
+	vm := vmForPayload(t, amltest.Concat(
+		// Device(DEV0) {}
+		amltest.Pkg([]byte{0x5b, 0x82}, []byte{'D', 'E', 'V', '0'}),
+		// ThermalZone(TZ00) {}
+		amltest.Pkg([]byte{0x5b, 0x85}, []byte{'T', 'Z', '0', '0'}),
+		// Device(DEV1) {}
+		amltest.Pkg([]byte{0x5b, 0x82}, []byte{'D', 'E', 'V', '1'}),
+		// Name(INT0, One)
+		[]byte{0x08, 'I', 'N', 'T', '0', 0x01},
+		// Method(NTFY) {
+		//   Notify(DEV0, 0x80)
+		//   Notify(TZ00, 0x81)
+		//   Store(RefOf(DEV0), Local0)
+		//   Notify(Local0, 0x01)
+		//   Notify(DEV1, 0x02)
+		// }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'N', 'T', 'F', 'Y', 0x00,
+			0x86, 'D', 'E', 'V', '0', 0x0a, 0x80,
+			0x86, 'T', 'Z', '0', '0', 0x0a, 0x81,
+			0x70, 0x71, 'D', 'E', 'V', '0', 0x60,
+			0x86, 0x60, 0x01,
+			0x86, 'D', 'E', 'V', '1', 0x0a, 0x02,
+		}),
+		// Method(BAD_) { Notify(INT0, 0x80) }
+		amltest.Pkg([]byte{0x14}, []byte{'B', 'A', 'D', '_', 0x00, 0x86, 'I', 'N', 'T', '0', 0x0a, 0x80}),
+	))
+
+	type delivery struct {
+		path  string
+		value uint64
+	}
+	var got []delivery
+
+	handler := func(node *NamespaceNode, value uint64) {
+		got = append(got, delivery{node.Path(), value})
+	}
+
+	for _, path := range []string{`DEV0`, `TZ00`} {
+		if err := vm.InstallNotifyHandler(path, handler); err != nil {
+			t.Fatalf("unexpected error installing handler for %s: %v", path, err)
+		}
+	}
+
+	if _, err := vm.Evaluate(`NTFY`); err != nil {
+		t.Fatal(err)
+	}
+
+	// Notifications must only be delivered when explicitly requested
+	if len(got) != 0 {
+		t.Fatalf("expected no notifications to be delivered while executing; got %v", got)
+	}
+
+	// The notification for DEV1 is dropped as it has no handler
+	if delivered := vm.DispatchNotifications(); delivered != 3 {
+		t.Fatalf("expected 3 notifications to be delivered; got %d", delivered)
+	}
+
+	exp := []delivery{
+		{`\DEV0`, 0x80},
+		{`\TZ00`, 0x81},
+		{`\DEV0`, NotifyDeviceCheck},
+	}
+	if !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected notifications:\n%v\ngot:\n%v", exp, got)
+	}
+
+	t.Run("remove handler", func(t *testing.T) {
+		got = nil
+		if err := vm.RemoveNotifyHandler(`DEV0`); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := vm.Evaluate(`NTFY`); err != nil {
+			t.Fatal(err)
+		}
+
+		if delivered := vm.DispatchNotifications(); delivered != 1 || got[0].path != `\TZ00` {
+			t.Fatalf("expected only the TZ00 notification to be delivered; got %v", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		specs := []struct {
+			fn     func() error
+			expErr error
+		}{
+			{func() error { return vm.InstallNotifyHandler(`TZ00`, handler) }, errNotifyHandlerExists},
+			{func() error { return vm.InstallNotifyHandler(`INT0`, handler) }, errNotNotifiable},
+			{func() error { return vm.InstallNotifyHandler(`FOO0`, handler) }, errPathNotFound},
+			{func() error { return vm.RemoveNotifyHandler(`DEV1`) }, errNotifyHandlerNotFound},
+			{func() error { return vm.RemoveNotifyHandler(`INT0`) }, errNotNotifiable},
+			{func() error { _, err := vm.Evaluate(`BAD_`); return err }, errNotNotifiable},
+		}
+
+		for specIndex, spec := range specs {
+			if err := spec.fn(); !reflect.DeepEqual(err, spec.expErr) {
+				t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			}
+		}
+	})
+}
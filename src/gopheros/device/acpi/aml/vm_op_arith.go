@@ -0,0 +1,419 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/convert"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/kernel"
+)
+
+// vmOpAdd implements: Add(Addend1, Addend2, Target) => Integer
+func vmOpAdd(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return a + b, nil })
+}
+
+// vmOpSubtract implements: Subtract(Minuend, Subtrahend, Target) => Integer
+func vmOpSubtract(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return a - b, nil })
+}
+
+// vmOpMultiply implements: Multiply(Multiplicand, Multiplier, Target) => Integer
+func vmOpMultiply(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return a * b, nil })
+}
+
+// vmOpMod implements: Mod(Dividend, Divisor, Target) => Integer
+func vmOpMod(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) {
+		if b == 0 {
+			return 0, errDivideByZero
+		}
+		return a % b, nil
+	})
+}
+
+// vmOpShiftLeft implements: ShiftLeft(Source, ShiftCount, Target) => Integer
+func vmOpShiftLeft(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) {
+		if b >= 64 {
+			return 0, nil
+		}
+		return a << b, nil
+	})
+}
+
+// vmOpShiftRight implements: ShiftRight(Source, ShiftCount, Target) => Integer
+func vmOpShiftRight(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) {
+		if b >= 64 {
+			return 0, nil
+		}
+		return a >> b, nil
+	})
+}
+
+// vmOpAnd implements: And(Source1, Source2, Target) => Integer
+func vmOpAnd(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return a & b, nil })
+}
+
+// vmOpNand implements: NAnd(Source1, Source2, Target) => Integer
+func vmOpNand(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return ^(a & b), nil })
+}
+
+// vmOpOr implements: Or(Source1, Source2, Target) => Integer
+func vmOpOr(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return a | b, nil })
+}
+
+// vmOpNor implements: NOr(Source1, Source2, Target) => Integer
+func vmOpNor(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return ^(a | b), nil })
+}
+
+// vmOpXor implements: XOr(Source1, Source2, Target) => Integer
+func vmOpXor(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.binaryIntOp(ctx, obj, func(a, b uint64) (uint64, *kernel.Error) { return a ^ b, nil })
+}
+
+// vmOpNot implements: Not(Source, Target) => Integer
+func vmOpNot(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.unaryIntOp(ctx, obj, func(a uint64) uint64 { return ^a })
+}
+
+// vmOpFindSetLeftBit implements: FindSetLeftBit(Source, Target) => Integer
+//
+// The result is the one-based index of the most significant set bit in
+// Source or zero if Source is zero.
+func vmOpFindSetLeftBit(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.unaryIntOp(ctx, obj, func(a uint64) uint64 {
+		var bit uint64
+		for ; a != 0; a >>= 1 {
+			bit++
+		}
+		return bit
+	})
+}
+
+// vmOpFindSetRightBit implements: FindSetRightBit(Source, Target) => Integer
+//
+// The result is the one-based index of the least significant set bit in
+// Source or zero if Source is zero.
+func vmOpFindSetRightBit(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.unaryIntOp(ctx, obj, func(a uint64) uint64 {
+		if a == 0 {
+			return 0
+		}
+
+		var bit uint64 = 1
+		for ; a&1 == 0; a >>= 1 {
+			bit++
+		}
+		return bit
+	})
+}
+
+// vmOpDivide implements: Divide(Dividend, Divisor, Remainder, Result) => Integer
+func vmOpDivide(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	dividend, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	divisor, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	if divisor == 0 {
+		return vm.fail(obj, errDivideByZero)
+	}
+
+	quotient, remainder := dividend/divisor, dividend%divisor
+	if err = vm.store(ctx, remainder, vm.targetArg(obj, 2)); err != nil {
+		return err
+	}
+
+	if err = vm.store(ctx, quotient, vm.targetArg(obj, 3)); err != nil {
+		return err
+	}
+
+	ctx.retVal = quotient
+	return nil
+}
+
+// vmOpIncrement implements: Increment(Addend) => Integer
+func vmOpIncrement(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.updateIntArg(ctx, obj, func(a uint64) uint64 { return a + 1 })
+}
+
+// vmOpDecrement implements: Decrement(Addend) => Integer
+func vmOpDecrement(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.updateIntArg(ctx, obj, func(a uint64) uint64 { return a - 1 })
+}
+
+// vmOpConcat implements: Concatenate(Source1, Source2, Target) => Buffer or String
+//
+// The type of the result depends on the type of Source1. Integer sources
+// produce a Buffer containing the little-endian representation of both
+// integers, String sources produce a String and Buffer sources produce a
+// Buffer. Source2 is implicitly converted to the type of Source1.
+func vmOpConcat(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	src1, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	src2, err := vm.evalArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	var res interface{}
+	switch typ := src1.(type) {
+	case string:
+		var str2 string
+		if str2, err = vm.toString(src2); err == nil {
+			res = typ + str2
+		}
+	case uint64, []byte:
+		var buf1, buf2 []byte
+		if buf1, err = vm.toBuffer(typ); err == nil {
+			if _, isInt := typ.(uint64); isInt {
+				var int2 uint64
+				int2, err = vm.toInteger(src2)
+				src2 = int2
+			}
+			if err == nil {
+				buf2, err = vm.toBuffer(src2)
+			}
+		}
+
+		if err == nil {
+			out := vm.allocBuffer(ctx, len(buf1)+len(buf2))
+			copy(out[copy(out, buf1):], buf2)
+			res = out
+		}
+	default:
+		err = errConversionFailed
+	}
+
+	if err != nil {
+		return vm.fail(obj, err)
+	}
+
+	if err = vm.store(ctx, res, vm.targetArg(obj, 2)); err != nil {
+		return err
+	}
+
+	ctx.retVal = res
+	return nil
+}
+
+// vmOpConcatRes implements: ConcatenateResTemplate(Source1, Source2, Result) => Buffer
+//
+// Both sources must be resource templates. The result contains the
+// descriptors of Source1 followed by the descriptors of Source2 and a single
+// end tag. Zero-length buffers are treated as empty templates.
+func vmOpConcatRes(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	var srcs [2][]byte
+
+	for argIndex := range srcs {
+		src, err := vm.evalArg(ctx, obj, uint32(argIndex))
+		if err != nil {
+			return err
+		}
+
+		buf, isBuf := src.([]byte)
+		if !isBuf {
+			return vm.fail(obj, errConversionFailed)
+		}
+
+		if len(buf) != 0 {
+			endOffset, err := resource.EndTagOffset(buf)
+			if err != nil {
+				return vm.fail(obj, err)
+			}
+			buf = buf[:endOffset]
+		}
+		srcs[argIndex] = buf
+	}
+
+	// The end tag checksum is set to zero which indicates that the
+	// template contents should not be checksummed.
+	res := vm.allocBuffer(ctx, len(srcs[0])+len(srcs[1])+2)
+	offset := copy(res, srcs[0])
+	offset += copy(res[offset:], srcs[1])
+	res[offset] = 0x79
+
+	if err := vm.store(ctx, res, vm.targetArg(obj, 2)); err != nil {
+		return err
+	}
+
+	ctx.retVal = res
+	return nil
+}
+
+// vmOpLand implements: LAnd(Source1, Source2) => Boolean
+func vmOpLand(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.logicIntOp(ctx, obj, func(a, b uint64) bool { return a != 0 && b != 0 })
+}
+
+// vmOpLor implements: LOr(Source1, Source2) => Boolean
+func vmOpLor(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.logicIntOp(ctx, obj, func(a, b uint64) bool { return a != 0 || b != 0 })
+}
+
+// vmOpLnot implements: LNot(Source) => Boolean
+func vmOpLnot(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	val, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	ctx.retVal = vm.boolToInt(val == 0)
+	return nil
+}
+
+// vmOpLEqual implements: LEqual(Source1, Source2) => Boolean
+func vmOpLEqual(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.compareOp(ctx, obj, func(res int) bool { return res == 0 })
+}
+
+// vmOpLGreater implements: LGreater(Source1, Source2) => Boolean
+func vmOpLGreater(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.compareOp(ctx, obj, func(res int) bool { return res > 0 })
+}
+
+// vmOpLLess implements: LLess(Source1, Source2) => Boolean
+func vmOpLLess(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	return vm.compareOp(ctx, obj, func(res int) bool { return res < 0 })
+}
+
+// binaryIntOp evaluates the first two args of obj as Integers, applies fn to
+// them and stores the result to the target specified by the third arg.
+func (vm *VM) binaryIntOp(ctx *execContext, obj *Object, fn func(a, b uint64) (uint64, *kernel.Error)) *kernel.Error {
+	a, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	b, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	res, err := fn(a, b)
+	if err != nil {
+		return vm.fail(obj, err)
+	}
+	res = vm.intWidth.Truncate(res)
+
+	if err = vm.store(ctx, res, vm.targetArg(obj, 2)); err != nil {
+		return err
+	}
+
+	ctx.retVal = res
+	return nil
+}
+
+// unaryIntOp evaluates the first arg of obj as an Integer, applies fn to it
+// and stores the result to the target specified by the second arg.
+func (vm *VM) unaryIntOp(ctx *execContext, obj *Object, fn func(a uint64) uint64) *kernel.Error {
+	a, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	res := vm.intWidth.Truncate(fn(a))
+	if err = vm.store(ctx, res, vm.targetArg(obj, 1)); err != nil {
+		return err
+	}
+
+	ctx.retVal = res
+	return nil
+}
+
+// updateIntArg evaluates the first arg of obj as an Integer, applies fn to it
+// and stores the result back to the first arg.
+func (vm *VM) updateIntArg(ctx *execContext, obj *Object, fn func(a uint64) uint64) *kernel.Error {
+	a, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	res := vm.intWidth.Truncate(fn(a))
+	if err = vm.store(ctx, res, vm.targetArg(obj, 0)); err != nil {
+		return err
+	}
+
+	ctx.retVal = res
+	return nil
+}
+
+// logicIntOp evaluates the first two args of obj as Integers and sets the
+// result to the boolean value returned by fn.
+func (vm *VM) logicIntOp(ctx *execContext, obj *Object, fn func(a, b uint64) bool) *kernel.Error {
+	a, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	b, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	ctx.retVal = vm.boolToInt(fn(a, b))
+	return nil
+}
+
+// compareOp compares the first two args of obj and sets the result to the
+// boolean value returned by fn when invoked with the comparison result (-1, 0
+// or 1). The second arg is implicitly converted to the type of the first arg
+// before comparing them. Strings and buffers are compared lexicographically.
+func (vm *VM) compareOp(ctx *execContext, obj *Object, fn func(res int) bool) *kernel.Error {
+	a, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	b, err := vm.evalArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	if b, err = convert.ToTypeOf(b, a, vm.intWidth); err != nil {
+		return vm.fail(obj, err)
+	}
+
+	ctx.retVal = vm.boolToInt(fn(compareValues(a, b)))
+	return nil
+}
+
+// compareValues compares two Integers, Strings or Buffers of the same type
+// and returns -1, 0 or 1 if a is less than, equal to or greater than b.
+func compareValues(a, b interface{}) int {
+	var res int
+	switch typ := a.(type) {
+	case uint64:
+		switch intB := b.(uint64); {
+		case typ < intB:
+			res = -1
+		case typ > intB:
+			res = 1
+		}
+	case string:
+		switch strB := b.(string); {
+		case typ < strB:
+			res = -1
+		case typ > strB:
+			res = 1
+		}
+	case []byte:
+		res = bytes.Compare(typ, b.([]byte))
+	}
+
+	return res
+}
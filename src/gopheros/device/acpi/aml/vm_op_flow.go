@@ -0,0 +1,148 @@
+package aml
+
+import "gopheros/kernel"
+
+// vmOpNoop is used for opcodes that do not require any run-time processing.
+func vmOpNoop(_ *VM, _ *execContext, _ *Object) *kernel.Error {
+	return nil
+}
+
+// vmOpIf executes the body of an If block if its predicate evaluates to a
+// non-zero value. Otherwise, if the If block is followed by an Else block,
+// the contents of the Else block are executed instead.
+func vmOpIf(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	predicate, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	if predicate != 0 {
+		return vm.execScopeArg(ctx, obj, 1)
+	}
+
+	if elseObj := vm.tree.ObjectAt(obj.nextSiblingIndex); elseObj != nil && elseObj.opcode == pOpElse {
+		return vm.execScopeArg(ctx, elseObj, 0)
+	}
+
+	return nil
+}
+
+// vmOpWhile repeatedly executes the body of a While block while its predicate
+// evaluates to a non-zero value. The loop is aborted if it exceeds the
+// MaxLoopIterations execution limit. Each iteration counts towards the MaxOps
+// limit so that loops with an empty body cannot bypass it.
+func vmOpWhile(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	for iterations := uint64(0); ; iterations++ {
+		predicate, err := vm.evalIntArg(ctx, obj, 0)
+		if err != nil || predicate == 0 {
+			return err
+		}
+
+		if limit := vm.limits.MaxLoopIterations; limit != 0 && iterations >= limit {
+			return vm.fail(obj, errMaxLoopIterationsReached)
+		}
+
+		if err = vm.countOp(ctx, obj); err != nil {
+			return err
+		}
+
+		if err = vm.execScopeArg(ctx, obj, 1); err != nil {
+			return err
+		}
+
+		switch ctx.ctrlFlow {
+		case ctrlFlowTypeBreak:
+			ctx.ctrlFlow = ctrlFlowTypeNextOpcode
+			return nil
+		case ctrlFlowTypeContinue:
+			ctx.ctrlFlow = ctrlFlowTypeNextOpcode
+		case ctrlFlowTypeFnReturn:
+			return nil
+		}
+	}
+}
+
+// vmOpBreak exits the innermost While block.
+func vmOpBreak(_ *VM, ctx *execContext, _ *Object) *kernel.Error {
+	ctx.ctrlFlow = ctrlFlowTypeBreak
+	return nil
+}
+
+// vmOpContinue skips to the next iteration of the innermost While block.
+func vmOpContinue(_ *VM, ctx *execContext, _ *Object) *kernel.Error {
+	ctx.ctrlFlow = ctrlFlowTypeContinue
+	return nil
+}
+
+// vmOpReturn stops the execution of the current method and sets up its
+// return value.
+func vmOpReturn(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	var (
+		retVal interface{}
+		err    *kernel.Error
+	)
+
+	if obj.firstArgIndex != InvalidIndex {
+		if retVal, err = vm.evalArg(ctx, obj, 0); err != nil {
+			return err
+		}
+	}
+
+	ctx.retVal = retVal
+	ctx.ctrlFlow = ctrlFlowTypeFnReturn
+	return nil
+}
+
+// vmOpMethodCall evaluates the arguments for a method invocation and then
+// invokes the method.
+func vmOpMethodCall(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	method := vm.tree.ObjectAt(obj.value.(uint32))
+	if method == nil {
+		return vm.fail(obj, errPathNotFound)
+	}
+
+	var (
+		args    [maxMethodArgs]interface{}
+		argc    uint32
+		argVal  interface{}
+		retVal  interface{}
+		err     *kernel.Error
+		numArgs = vm.tree.NumArgs(obj)
+	)
+
+	if numArgs > maxMethodArgs {
+		return vm.fail(obj, errArgCountMismatch)
+	}
+
+	for ; argc < numArgs; argc++ {
+		if argVal, err = vm.evalArg(ctx, obj, argc); err != nil {
+			return err
+		}
+		args[argc] = vm.copyLocal(ctx, argVal)
+	}
+
+	if retVal, err = vm.invokeMethod(ctx, method, args[:argc]); err != nil {
+		return err
+	}
+
+	ctx.retVal = retVal
+	return nil
+}
+
+// vmOpName resets the value of a Name object declared inside a method body so
+// that it gets re-initialized each time the method is invoked.
+func vmOpName(vm *VM, _ *execContext, obj *Object) *kernel.Error {
+	delete(vm.namedValues, obj.index)
+	return nil
+}
+
+// execScopeArg executes the contents of the scope block stored in obj's arg at
+// the specified index.
+func (vm *VM) execScopeArg(ctx *execContext, obj *Object, argIndex uint32) *kernel.Error {
+	scope := vm.tree.ArgAt(obj, argIndex)
+	if scope == nil || scope.opcode != pOpIntScopeBlock {
+		return vm.fail(obj, errMalformedObject)
+	}
+
+	return vm.execBlock(ctx, scope)
+}
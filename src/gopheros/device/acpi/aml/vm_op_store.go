@@ -0,0 +1,612 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/convert"
+	"gopheros/kernel"
+)
+
+// vmOpStore evaluates its first arg and stores the result to the target
+// specified by its second arg, applying any implicit conversions required by
+// the target type. Stores to SMBus and GenericSerialBus fields return the
+// buffer with the transaction status instead of the stored value.
+func vmOpStore(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	val, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	ctx.storeResult = nil
+	if err = vm.store(ctx, val, vm.targetArg(obj, 1)); err != nil {
+		return err
+	}
+
+	if ctx.storeResult != nil {
+		val, ctx.storeResult = ctx.storeResult, nil
+	}
+
+	ctx.retVal = val
+	return nil
+}
+
+// vmOpCopyObject evaluates its first arg and stores a copy of the result to
+// the target specified by its second arg. Unlike Store, no implicit
+// conversions are applied; the target takes the type of the copied value.
+func vmOpCopyObject(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	val, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	if err = vm.storeWithMode(ctx, val, vm.targetArg(obj, 1), storeModeReplace); err != nil {
+		return err
+	}
+
+	ctx.retVal = val
+	return nil
+}
+
+// vmOpBuffer creates a new Buffer. The buffer size is specified by the first
+// arg while the second arg contains the initial buffer contents. If the
+// buffer size exceeds the length of the initializer, the remaining buffer
+// bytes are set to zero.
+func vmOpBuffer(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	size, err := vm.evalIntArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	var initData []byte
+	if dataObj := vm.tree.ArgAt(obj, 1); dataObj != nil {
+		initData, _ = dataObj.value.([]byte)
+	}
+
+	if uint64(len(initData)) > size {
+		size = uint64(len(initData))
+	}
+
+	buf := vm.allocBuffer(ctx, int(size))
+	copy(buf, initData)
+	ctx.retVal = buf
+	return nil
+}
+
+// vmOpPackage creates a new Package (or VarPackage). The number of package
+// elements is specified by the first arg while the second arg contains the
+// package element initializers. Any elements without an initializer are left
+// uninitialized (nil). Named references inside the package are stored as
+// references to the named object.
+func vmOpPackage(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	var (
+		count uint64
+		err   *kernel.Error
+	)
+
+	if obj.opcode == pOpVarPackage {
+		count, err = vm.evalIntArg(ctx, obj, 0)
+	} else if countObj := vm.tree.ArgAt(obj, 0); countObj != nil {
+		count, _ = countObj.value.(uint64)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	elemList := vm.tree.ArgAt(obj, 1)
+	if elemList != nil {
+		if numInit := uint64(vm.tree.NumArgs(elemList)); numInit > count {
+			count = numInit
+		}
+	}
+
+	var (
+		pkg       = vm.allocPackage(ctx, int(count))
+		elem      interface{}
+		elemIndex int
+	)
+
+	if elemList != nil {
+		for index := elemList.firstArgIndex; index != InvalidIndex; index = vm.tree.ObjectAt(index).nextSiblingIndex {
+			elemObj := vm.tree.ObjectAt(index)
+			switch elemObj.opcode {
+			case pOpIntResolvedNamePath, pOpIntNamePath:
+				if elem, err = vm.resolveNamePath(ctx, elemObj); err != nil {
+					return err
+				}
+			default:
+				if elem, err = vm.eval(ctx, elemObj); err != nil {
+					return err
+				}
+			}
+
+			vm.setPackageElement(pkg, elemIndex, elem)
+			elemIndex++
+		}
+	}
+
+	ctx.retVal = pkg
+	return nil
+}
+
+// vmOpRefOf returns a Reference to the named object specified by its arg.
+func vmOpRefOf(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	argObj := vm.tree.ArgAt(obj, 0)
+	if argObj == nil || (argObj.opcode != pOpIntResolvedNamePath && argObj.opcode != pOpIntNamePath) {
+		return vm.fail(obj, errUnsupportedOpcode)
+	}
+
+	target, err := vm.resolveNamePath(ctx, argObj)
+	if err != nil {
+		return err
+	}
+
+	ctx.retVal = &Reference{Target: target}
+	return nil
+}
+
+// vmOpDerefOf returns the value pointed to by a Reference.
+func vmOpDerefOf(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	val, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	switch typ := val.(type) {
+	case *Reference:
+		val, err = vm.deref(ctx, typ)
+	case *Object:
+		val, err = vm.readNamedObject(ctx, typ)
+	default:
+		err = errConversionFailed
+	}
+
+	if err != nil {
+		return vm.fail(obj, err)
+	}
+
+	ctx.retVal = val
+	return nil
+}
+
+// vmOpIndex returns a Reference to an element of a Package, Buffer or String
+// and optionally stores it to the target specified by the third arg.
+func vmOpIndex(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	container, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	index, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	var length int
+	switch typ := container.(type) {
+	case []interface{}:
+		length = len(typ)
+	case []byte:
+		length = len(typ)
+	case string:
+		length = len(typ)
+	default:
+		return vm.fail(obj, errConversionFailed)
+	}
+
+	if index >= uint64(length) {
+		return vm.fail(obj, errIndexOutOfBounds)
+	}
+
+	ref := &Reference{Container: container, Index: index}
+	if err = vm.store(ctx, ref, vm.targetArg(obj, 2)); err != nil {
+		return err
+	}
+
+	ctx.retVal = ref
+	return nil
+}
+
+// The comparison operators supported by the Match opcode.
+const (
+	matchOpTrue = iota
+	matchOpEqual
+	matchOpLessEqual
+	matchOpLess
+	matchOpGreaterEqual
+	matchOpGreater
+)
+
+// vmOpMatch implements: Match(SearchPackage, Op1, MatchObject1, Op2, MatchObject2, StartIndex) => Ones | Integer
+//
+// Match returns the index of the first package element, starting at
+// StartIndex, that satisfies both comparisons. Each match object is
+// converted to the type of the element before comparing it; elements that
+// are not Integers, Strings or Buffers or that cannot be compared to a
+// match object never match. If no element matches, Ones is returned.
+func vmOpMatch(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	val, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	if ref, isRef := val.(*Reference); isRef {
+		if val, err = vm.deref(ctx, ref); err != nil {
+			return vm.fail(obj, err)
+		}
+	}
+
+	pkg, isPkg := val.([]interface{})
+	if !isPkg {
+		return vm.fail(obj, errConversionFailed)
+	}
+
+	var (
+		ops        [2]uint64
+		matchObjs  [2]interface{}
+		startIndex uint64
+	)
+
+	for i := range ops {
+		if ops[i], err = vm.evalIntArg(ctx, obj, uint32(2*i+1)); err != nil {
+			return err
+		}
+		if ops[i] > matchOpGreater {
+			return vm.fail(obj, errInvalidMatchOp)
+		}
+
+		if matchObjs[i], err = vm.evalArg(ctx, obj, uint32(2*i+2)); err != nil {
+			return err
+		}
+		switch matchObjs[i].(type) {
+		case uint64, string, []byte:
+		default:
+			return vm.fail(obj, errConversionFailed)
+		}
+	}
+
+	if startIndex, err = vm.evalIntArg(ctx, obj, 5); err != nil {
+		return err
+	}
+	if startIndex >= uint64(len(pkg)) {
+		return vm.fail(obj, errIndexOutOfBounds)
+	}
+
+	ctx.retVal = vm.intWidth.Ones()
+	for index := startIndex; index < uint64(len(pkg)); index++ {
+		if vm.matchElement(pkg[index], ops[0], matchObjs[0]) && vm.matchElement(pkg[index], ops[1], matchObjs[1]) {
+			ctx.retVal = index
+			break
+		}
+	}
+
+	return nil
+}
+
+// matchElement returns true if the package element elem satisfies the Match
+// comparison op when compared against matchObj.
+func (vm *VM) matchElement(elem interface{}, op uint64, matchObj interface{}) bool {
+	if op == matchOpTrue {
+		return true
+	}
+
+	switch elem.(type) {
+	case uint64, string, []byte:
+	default:
+		return false
+	}
+
+	other, err := convert.ToTypeOf(matchObj, elem, vm.intWidth)
+	if err != nil {
+		return false
+	}
+
+	switch res := compareValues(elem, other); op {
+	case matchOpEqual:
+		return res == 0
+	case matchOpLessEqual:
+		return res <= 0
+	case matchOpLess:
+		return res < 0
+	case matchOpGreaterEqual:
+		return res >= 0
+	default:
+		return res > 0
+	}
+}
+
+// vmOpMid implements: Mid(Source, Index, Length, Result) => Buffer or String
+//
+// Mid returns a substring of a String or a slice of a Buffer. Integer sources
+// are converted to a Buffer. If Index is past the end of the source, an empty
+// String or Buffer is returned; Length is clamped to the available data.
+func vmOpMid(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	src, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	index, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	length, err := vm.evalIntArg(ctx, obj, 2)
+	if err != nil {
+		return err
+	}
+
+	// midBounds clamps [index, index+length) to the size of the source.
+	midBounds := func(size uint64) (uint64, uint64) {
+		if index >= size {
+			return size, size
+		}
+		if length > size-index {
+			return index, size
+		}
+		return index, index + length
+	}
+
+	var res interface{}
+	switch typ := src.(type) {
+	case string:
+		start, end := midBounds(uint64(len(typ)))
+		res = typ[start:end]
+	case uint64, []byte:
+		var buf []byte
+		if buf, err = vm.toBuffer(typ); err != nil {
+			return vm.fail(obj, err)
+		}
+
+		start, end := midBounds(uint64(len(buf)))
+		out := vm.allocBuffer(ctx, int(end-start))
+		copy(out, buf[start:end])
+		res = out
+	default:
+		return vm.fail(obj, errConversionFailed)
+	}
+
+	if err = vm.store(ctx, res, vm.targetArg(obj, 3)); err != nil {
+		return err
+	}
+
+	ctx.retVal = res
+	return nil
+}
+
+// vmOpSizeOf returns the size of a String, Buffer or Package.
+func vmOpSizeOf(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	val, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return err
+	}
+
+	if ref, isRef := val.(*Reference); isRef {
+		if val, err = vm.deref(ctx, ref); err != nil {
+			return vm.fail(obj, err)
+		}
+	}
+
+	switch typ := val.(type) {
+	case string:
+		ctx.retVal = uint64(len(typ))
+	case []byte:
+		ctx.retVal = uint64(len(typ))
+	case []interface{}:
+		ctx.retVal = uint64(len(typ))
+	default:
+		return vm.fail(obj, errConversionFailed)
+	}
+
+	return nil
+}
+
+// deref returns the value pointed to by ref.
+func (vm *VM) deref(ctx *execContext, ref *Reference) (interface{}, *kernel.Error) {
+	if ref.Target != nil {
+		return vm.readNamedObject(ctx, ref.Target)
+	}
+
+	switch typ := ref.Container.(type) {
+	case []interface{}:
+		if ref.Index < uint64(len(typ)) {
+			if obj, isObj := typ[ref.Index].(*Object); isObj {
+				return vm.readNamedObject(ctx, obj)
+			}
+			return typ[ref.Index], nil
+		}
+	case []byte:
+		if ref.Index < uint64(len(typ)) {
+			return uint64(typ[ref.Index]), nil
+		}
+	case string:
+		if ref.Index < uint64(len(typ)) {
+			return uint64(typ[ref.Index]), nil
+		}
+	}
+
+	return nil, errIndexOutOfBounds
+}
+
+// storeMode controls how a value is written to a store target.
+type storeMode uint8
+
+const (
+	// storeModeConvert applies the implicit target conversion rules used
+	// by Store and by the Target operands of the remaining opcodes.
+	storeModeConvert storeMode = iota
+
+	// storeModeReplace overwrites the target with a copy of the value
+	// replacing the target's type. It is used by CopyObject.
+	storeModeReplace
+)
+
+// store writes val to the location specified by target applying the implicit
+// conversion rules for Store. A nil target or a NullName target (encoded as a
+// Zero opcode) causes the value to be discarded.
+func (vm *VM) store(ctx *execContext, val interface{}, target *Object) *kernel.Error {
+	return vm.storeWithMode(ctx, val, target, storeModeConvert)
+}
+
+// storeWithMode writes val to the location specified by target:
+//   - LocalX targets are always overwritten with a copy of val.
+//   - ArgX targets that contain a Reference (e.g. an arg passed via RefOf)
+//     store val to the referenced object; all other ArgX targets are
+//     overwritten with a copy of val.
+//   - Index, RefOf and DerefOf targets store val to the object that the
+//     Reference they evaluate to points to.
+//   - Named objects are written via storeToNamedObject.
+func (vm *VM) storeWithMode(ctx *execContext, val interface{}, target *Object, mode storeMode) *kernel.Error {
+	if target == nil || target.opcode == pOpZero {
+		return nil
+	}
+
+	switch {
+	case pOpIsLocalArg(target.opcode):
+		ctx.localArg[target.opcode-pOpLocal0] = vm.copyLocal(ctx, val)
+	case pOpIsMethodArg(target.opcode):
+		if ref, isRef := ctx.methodArg[target.opcode-pOpArg0].(*Reference); isRef {
+			return vm.storeToReference(ctx, target, ref, val, mode)
+		}
+		ctx.methodArg[target.opcode-pOpArg0] = vm.copyLocal(ctx, val)
+	case target.opcode == pOpDebug:
+		vm.writeDebug(val)
+	case target.opcode == pOpIntResolvedNamePath, target.opcode == pOpIntNamePath:
+		namedObj, err := vm.resolveNamePath(ctx, target)
+		if err != nil {
+			return err
+		}
+		return vm.storeToNamedObject(ctx, namedObj, val, mode)
+	case target.opcode == pOpIndex, target.opcode == pOpRefOf:
+		refVal, err := vm.eval(ctx, target)
+		if err != nil {
+			return err
+		}
+		ref, _ := refVal.(*Reference)
+		return vm.storeToReference(ctx, target, ref, val, mode)
+	case target.opcode == pOpDerefOf:
+		refVal, err := vm.evalArg(ctx, target, 0)
+		if err != nil {
+			return err
+		}
+		ref, _ := refVal.(*Reference)
+		return vm.storeToReference(ctx, target, ref, val, mode)
+	default:
+		return vm.fail(target, errInvalidStoreTarget)
+	}
+
+	return nil
+}
+
+// storeToReference writes val to the object pointed to by ref. Stores to
+// Package elements replace the element with a copy of val while stores to
+// Buffer elements update the referenced byte with the low-order byte of the
+// value. As Strings are immutable, Index references to String elements are
+// not valid store targets.
+func (vm *VM) storeToReference(ctx *execContext, target *Object, ref *Reference, val interface{}, mode storeMode) *kernel.Error {
+	if ref == nil {
+		return vm.fail(target, errInvalidStoreTarget)
+	}
+
+	if ref.Target != nil {
+		return vm.storeToNamedObject(ctx, ref.Target, val, mode)
+	}
+
+	switch container := ref.Container.(type) {
+	case []interface{}:
+		if ref.Index >= uint64(len(container)) {
+			return vm.fail(target, errIndexOutOfBounds)
+		}
+
+		// The element may outlive the method that created val.
+		vm.escape(val)
+		container[ref.Index] = copyValue(val)
+	case []byte:
+		if ref.Index >= uint64(len(container)) {
+			return vm.fail(target, errIndexOutOfBounds)
+		}
+
+		var data byte
+		switch typ := val.(type) {
+		case string:
+			if len(typ) != 0 {
+				data = typ[0]
+			}
+		case []byte:
+			if len(typ) != 0 {
+				data = typ[0]
+			}
+		default:
+			intVal, err := vm.toInteger(val)
+			if err != nil {
+				return vm.fail(target, err)
+			}
+			data = byte(intVal)
+		}
+		container[ref.Index] = data
+	default:
+		return vm.fail(target, errInvalidStoreTarget)
+	}
+
+	return nil
+}
+
+// storeToNamedObject writes val to a named object. Values written to fields
+// update the contents of the field's region. When mode is storeModeConvert,
+// values written to Name objects are converted to the type of the object's
+// current value using the target conversion rules implemented by
+// convert.ForStore; Buffer values are updated in place so that any Index
+// references to them observe the new contents. When mode is storeModeReplace,
+// the Name object's value is replaced by a copy of val.
+func (vm *VM) storeToNamedObject(ctx *execContext, obj *Object, val interface{}, mode storeMode) *kernel.Error {
+	switch obj.opcode {
+	case pOpName:
+	case pOpIntNamedField:
+		return vm.writeField(ctx, obj, val)
+	case pOpCreateField, pOpCreateBitField, pOpCreateByteField,
+		pOpCreateWordField, pOpCreateDWordField, pOpCreateQWordField:
+		return vm.writeBufferField(ctx, obj, val)
+	default:
+		return vm.fail(obj, errInvalidStoreTarget)
+	}
+
+	curVal, err := vm.nameValue(obj)
+	if err != nil {
+		return err
+	}
+
+	if mode == storeModeConvert {
+		if val, err = convert.ForStore(val, curVal, vm.intWidth); err != nil {
+			return vm.fail(obj, err)
+		}
+
+		if curBuf, isBuf := curVal.([]byte); isBuf && len(curBuf) != 0 {
+			copy(curBuf, val.([]byte))
+			return nil
+		}
+	}
+
+	// References stored to named objects keep their container alive.
+	vm.escape(val)
+	vm.namedValues[obj.index] = copyValue(val)
+	return nil
+}
+
+// targetArg returns obj's arg at argIndex if it specifies a store target or
+// nil if the arg is missing.
+func (vm *VM) targetArg(obj *Object, argIndex uint32) *Object {
+	return vm.tree.ArgAt(obj, argIndex)
+}
+
+// evalIntArg evaluates obj's arg at argIndex and converts it to an Integer.
+func (vm *VM) evalIntArg(ctx *execContext, obj *Object, argIndex uint32) (uint64, *kernel.Error) {
+	val, err := vm.evalArg(ctx, obj, argIndex)
+	if err != nil {
+		return 0, err
+	}
+
+	intVal, err := vm.toInteger(val)
+	if err != nil {
+		return 0, vm.fail(obj, err)
+	}
+
+	return intVal, nil
+}
@@ -0,0 +1,86 @@
+package aml
+
+import "gopheros/kernel"
+
+// OSIHandler is a function that decides whether an operating system interface
+// string passed to the _OSI method is supported.
+type OSIHandler func(iface string) bool
+
+// DefaultOSIInterfaces lists the interface strings that the VM reports as
+// supported by default. Most firmware only enables features (e.g. native PCIe
+// hotplug or thermal management) when recent Windows versions are detected so
+// the list includes all Windows versions up to Windows 10 (2019 update) as
+// well as the feature strings defined by the ACPI spec.
+var DefaultOSIInterfaces = []string{
+	"Windows 2000",
+	"Windows 2001",
+	"Windows 2001 SP1",
+	"Windows 2001.1",
+	"Windows 2001 SP2",
+	"Windows 2001.1 SP1",
+	"Windows 2006",
+	"Windows 2006.1",
+	"Windows 2006 SP1",
+	"Windows 2006 SP2",
+	"Windows 2009",
+	"Windows 2012",
+	"Windows 2013",
+	"Windows 2015",
+	"Windows 2016",
+	"Windows 2017",
+	"Windows 2017.2",
+	"Windows 2018",
+	"Windows 2018.2",
+	"Windows 2019",
+	"Module Device",
+	"Processor Device",
+	"3.0 Thermal Model",
+	"3.0 _SCP Extensions",
+	"Processor Aggregator Device",
+}
+
+// SetOSIInterfaces replaces the list of interface strings that the builtin
+// _OSI method reports as supported.
+func (vm *VM) SetOSIInterfaces(ifaces ...string) {
+	vm.osiInterfaces = make(map[string]struct{}, len(ifaces))
+	for _, iface := range ifaces {
+		vm.osiInterfaces[iface] = struct{}{}
+	}
+}
+
+// SetOSIHandler overrides the way that the builtin _OSI method decides whether
+// an interface string is supported. Passing a nil handler restores the default
+// behavior of checking the list of interfaces set via SetOSIInterfaces.
+func (vm *VM) SetOSIHandler(handler OSIHandler) {
+	vm.osiHandler = handler
+}
+
+// invokeBuiltinMethod services calls to the methods created by the
+// ObjectTree's newBuiltinMethod.
+func (vm *VM) invokeBuiltinMethod(method *Object, args []interface{}) (interface{}, *kernel.Error) {
+	switch string(method.name[:]) {
+	case "_OSI":
+		iface, err := vm.toString(args[0])
+		if err != nil {
+			return nil, vm.fail(method, err)
+		}
+
+		if vm.osiSupported(iface) {
+			return vm.boolToInt(true), nil
+		}
+		return vmFalse, nil
+	default:
+		return nil, vm.fail(method, errUnsupportedOpcode)
+	}
+}
+
+// osiSupported returns true if iface is a supported operating system
+// interface string.
+func (vm *VM) osiSupported(iface string) bool {
+	if vm.osiHandler != nil {
+		return vm.osiHandler(iface)
+	}
+
+	_, supported := vm.osiInterfaces[iface]
+	return supported
+}
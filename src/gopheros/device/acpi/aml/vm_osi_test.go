@@ -0,0 +1,55 @@
+package aml
+
+import "testing"
+
+func TestVMOSI(t *testing.T) {
+	// Method(TEST, 1) { Return(_OSI(Arg0)) }
+	vm := vmForTestMethod(t, 1, []byte{0xa4, '_', 'O', 'S', 'I', 0x68})
+
+	osi := func(iface string) interface{} {
+		got, err := vm.Evaluate(`TEST`, iface)
+		if err != nil {
+			t.Fatalf("unexpected error evaluating _OSI(%q): %v", iface, err)
+		}
+		return got
+	}
+
+	specs := []struct {
+		setup  func()
+		iface  string
+		expRes interface{}
+	}{
+		{nil, "Windows 2015", vmTrue},
+		{nil, "3.0 Thermal Model", vmTrue},
+		{nil, "Linux", vmFalse},
+		{func() { vm.SetOSIInterfaces("Linux") }, "Linux", vmTrue},
+		{nil, "Windows 2015", vmFalse},
+		{
+			func() {
+				vm.SetOSIHandler(func(iface string) bool { return iface == "Darwin" })
+			},
+			"Darwin",
+			vmTrue,
+		},
+		{nil, "Linux", vmFalse},
+		{func() { vm.SetOSIHandler(nil) }, "Linux", vmTrue},
+	}
+
+	for specIndex, spec := range specs {
+		if spec.setup != nil {
+			spec.setup()
+		}
+
+		if got := osi(spec.iface); got != spec.expRes {
+			t.Errorf("[spec %d] expected _OSI(%q) to return %#v; got %#v", specIndex, spec.iface, spec.expRes, got)
+		}
+	}
+
+	if _, err := vm.Evaluate(`TEST`, []interface{}{uint64(1)}); err != errConversionFailed {
+		t.Fatalf("expected to get errConversionFailed; got %v", err)
+	}
+
+	if _, err := vm.Evaluate(`_OSI`, "Windows 2015"); err != nil {
+		t.Fatalf("unexpected error invoking _OSI directly: %v", err)
+	}
+}
@@ -0,0 +1,282 @@
+package aml
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+)
+
+var (
+	errNoRegionHandler     = &kernel.Error{Module: "acpi_aml_vm", Message: "no handler installed for operation region address space", Code: kernel.ErrCodeNotSupported}
+	errRegionOutOfBounds   = &kernel.Error{Module: "acpi_aml_vm", Message: "access exceeds operation region bounds", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidAccessWidth  = &kernel.Error{Module: "acpi_aml_vm", Message: "unsupported operation region access width", Code: kernel.ErrCodeInvalidArgument}
+	errUnsupportedFieldReg = &kernel.Error{Module: "acpi_aml_vm", Message: "field does not reference an operation region", Code: kernel.ErrCodeNotSupported}
+)
+
+// RegionSpace identifies the address space that an OperationRegion maps to.
+type RegionSpace uint8
+
+// The list of address spaces defined by the ACPI spec.
+const (
+	RegionSpaceSystemMemory RegionSpace = iota
+	RegionSpaceSystemIO
+	RegionSpacePCIConfig
+	RegionSpaceEmbeddedControl
+	RegionSpaceSMBus
+	RegionSpaceSystemCMOS
+	RegionSpacePCIBarTarget
+	RegionSpaceIPMI
+	RegionSpaceGeneralPurposeIO
+	RegionSpaceGenericSerialBus
+	RegionSpacePCC
+)
+
+// Region describes the address range covered by an OperationRegion.
+type Region struct {
+	Space RegionSpace
+
+	// The start address and length (in bytes) of the region inside its
+	// address space.
+	Offset uint64
+	Length uint64
+
+	// The PCI address of the device that defines a PCI_Config region. The
+	// device and function numbers are obtained by evaluating the _ADR
+	// object of the device while the bus and segment numbers are obtained
+	// by evaluating the _BBN and _SEG objects of its closest ancestors
+	// that define them (typically the PCI root bridge).
+	PCISegment  uint16
+	PCIBus      uint8
+	PCIDevice   uint8
+	PCIFunction uint8
+}
+
+// RegionHandler is implemented by types that provide access to the contents
+// of an OperationRegion address space.
+//
+// Accesses are always performed using a width of 8, 16, 32 or 64 bits and
+// offset is relative to the region start. The VM ensures that offset and
+// width fall within the region bounds before invoking the handler.
+type RegionHandler interface {
+	ReadRegion(region *Region, offset uint64, width uint8) (uint64, *kernel.Error)
+	WriteRegion(region *Region, offset uint64, width uint8, val uint64) *kernel.Error
+}
+
+// RegisterRegionHandler installs a handler for accessing OperationRegions that
+// map to the specified address space, replacing any previously registered
+// handler for the same space. Passing a nil handler uninstalls the handler
+// for the space.
+func (vm *VM) RegisterRegionHandler(space RegionSpace, handler RegionHandler) {
+	if handler == nil {
+		delete(vm.regionHandlers, space)
+		return
+	}
+
+	vm.regionHandlers[space] = handler
+}
+
+// RegisterDefaultRegionHandlers installs the built-in handlers for the
+// SystemMemory, SystemIO and PCI_Config address spaces. As the built-in
+// handlers access the hardware directly, this method should only be invoked
+// for VMs that run inside the kernel.
+//
+// If a table resolver has been registered and it can locate a valid MCFG
+// table, PCI_Config regions are accessed via the memory-mapped enhanced
+// configuration access mechanism; otherwise, the legacy I/O port based
+// mechanism is used.
+func (vm *VM) RegisterDefaultRegionHandlers() {
+	vm.RegisterRegionHandler(RegionSpaceSystemMemory, newSystemMemoryHandler())
+	vm.RegisterRegionHandler(RegionSpaceSystemIO, systemIOHandler{})
+
+	if entries := vm.mcfgEntries(); len(entries) != 0 {
+		vm.RegisterRegionHandler(RegionSpacePCIConfig, newPCIECAMHandler(entries))
+	} else {
+		vm.RegisterRegionHandler(RegionSpacePCIConfig, pciConfigHandler{})
+	}
+}
+
+// mcfgEntries returns the allocation records of the MCFG table or nil if the
+// table is not available or cannot be decoded.
+func (vm *VM) mcfgEntries() []table.MCFGEntry {
+	if vm.tableResolver == nil {
+		return nil
+	}
+
+	header := vm.tableResolver.LookupTable("MCFG")
+	if header == nil {
+		return nil
+	}
+
+	entries, err := table.DecodeMCFG(header)
+	if err != nil {
+		return nil
+	}
+
+	return entries
+}
+
+// vmOpOpRegion discards any cached information about an OperationRegion
+// declared inside a method body so that its offset and length arguments get
+// re-evaluated the next time that the region is accessed.
+func vmOpOpRegion(vm *VM, _ *execContext, obj *Object) *kernel.Error {
+	delete(vm.regions, obj.index)
+	return nil
+}
+
+// region returns the Region described by an OperationRegion object. The
+// region offset and length args are lazily evaluated the first time that the
+// region is accessed.
+func (vm *VM) region(ctx *execContext, obj *Object) (*Region, *kernel.Error) {
+	if region, exists := vm.regions[obj.index]; exists {
+		return region, nil
+	}
+
+	if obj.opcode != pOpOpRegion {
+		return nil, vm.fail(obj, errUnsupportedFieldReg)
+	}
+
+	spaceObj := vm.tree.ArgAt(obj, 1)
+	if spaceObj == nil {
+		return nil, vm.fail(obj, errMalformedObject)
+	}
+
+	space, _ := spaceObj.value.(uint64)
+	regionCtx := &execContext{scopeIndex: obj.index, depth: ctx.depth, thread: ctx.thread}
+
+	offset, err := vm.evalIntArg(regionCtx, obj, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	length, err := vm.evalIntArg(regionCtx, obj, 3)
+	if err != nil {
+		return nil, err
+	}
+
+	region := &Region{
+		Space:  RegionSpace(space),
+		Offset: offset,
+		Length: length,
+	}
+
+	if region.Space == RegionSpacePCIConfig {
+		if err = vm.resolvePCIAddress(regionCtx, obj, region); err != nil {
+			return nil, err
+		}
+	}
+
+	vm.regions[obj.index] = region
+	return region, nil
+}
+
+// resolvePCIAddress populates the PCI address of a PCI_Config region by
+// evaluating the _ADR, _BBN and _SEG objects of the Device that defines it
+// and its ancestors. Missing objects are treated as having a zero value.
+func (vm *VM) resolvePCIAddress(ctx *execContext, obj *Object, region *Region) *kernel.Error {
+	var (
+		adr, bbn, seg                uint64
+		foundADR, foundBBN, foundSEG bool
+		err                          *kernel.Error
+	)
+
+	for ancestorIndex := obj.parentIndex; ancestorIndex != InvalidIndex; ancestorIndex = vm.tree.ObjectAt(ancestorIndex).parentIndex {
+		if vm.tree.ObjectAt(ancestorIndex).opcode != pOpDevice {
+			continue
+		}
+
+		if !foundADR {
+			if adr, foundADR, err = vm.evalDeviceObject(ctx, ancestorIndex, "_ADR"); err != nil {
+				return err
+			}
+		}
+
+		if !foundBBN {
+			if bbn, foundBBN, err = vm.evalDeviceObject(ctx, ancestorIndex, "_BBN"); err != nil {
+				return err
+			}
+		}
+
+		if !foundSEG {
+			if seg, foundSEG, err = vm.evalDeviceObject(ctx, ancestorIndex, "_SEG"); err != nil {
+				return err
+			}
+		}
+	}
+
+	region.PCISegment = uint16(seg)
+	region.PCIBus = uint8(bbn)
+	region.PCIDevice = uint8(adr >> 16)
+	region.PCIFunction = uint8(adr)
+	return nil
+}
+
+// evalDeviceObject looks up an object with the given name that is defined
+// directly inside the scope of the device at deviceIndex and evaluates it to
+// an integer. The returned boolean is false if the device does not define the
+// requested object.
+func (vm *VM) evalDeviceObject(ctx *execContext, deviceIndex uint32, name string) (uint64, bool, *kernel.Error) {
+	deviceNode := vm.tree.Namespace().nodeAt(deviceIndex)
+	if deviceNode == nil {
+		return 0, false, nil
+	}
+
+	node := deviceNode.Child(name)
+	if node == nil {
+		return 0, false, nil
+	}
+	obj := node.Object()
+
+	val, err := vm.readNamedObject(ctx, obj)
+	if err != nil {
+		return 0, false, err
+	}
+
+	intVal, err := vm.toInteger(val)
+	if err != nil {
+		return 0, false, vm.fail(obj, err)
+	}
+
+	return intVal, true, nil
+}
+
+// readRegion reads width bits from the specified region offset using the
+// handler registered for the region's address space.
+func (vm *VM) readRegion(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	handler, err := vm.regionHandler(region, offset, width)
+	if err != nil {
+		return 0, err
+	}
+
+	return handler.ReadRegion(region, offset, width)
+}
+
+// writeRegion writes the lower width bits of val to the specified region
+// offset using the handler registered for the region's address space.
+func (vm *VM) writeRegion(region *Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	handler, err := vm.regionHandler(region, offset, width)
+	if err != nil {
+		return err
+	}
+
+	return handler.WriteRegion(region, offset, width, val)
+}
+
+// regionHandler validates a region access request and returns the handler
+// that is registered for the region's address space.
+func (vm *VM) regionHandler(region *Region, offset uint64, width uint8) (RegionHandler, *kernel.Error) {
+	switch width {
+	case 8, 16, 32, 64:
+	default:
+		return nil, errInvalidAccessWidth
+	}
+
+	if offset+uint64(width>>3) > region.Length {
+		return nil, errRegionOutOfBounds
+	}
+
+	handler, exists := vm.regionHandlers[region.Space]
+	if !exists {
+		return nil, errNoRegionHandler
+	}
+
+	return handler, nil
+}
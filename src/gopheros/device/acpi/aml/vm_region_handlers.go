@@ -0,0 +1,380 @@
+package aml
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"unsafe"
+)
+
+const (
+	// The I/O ports used for accessing the PCI configuration space using
+	// configuration access mechanism #1.
+	pciConfigAddressPort = uint16(0xcf8)
+	pciConfigDataPort    = uint16(0xcfc)
+
+	// The size of the configuration space of a PCI function that is
+	// accessible using configuration access mechanism #1.
+	pciConfigSpaceSize = uint64(256)
+
+	// The size of the extended configuration space of a PCI function that
+	// is accessible using the enhanced configuration access mechanism.
+	pciExtConfigSpaceSize = uint64(4096)
+)
+
+var (
+	errUnsupportedPCISegment = &kernel.Error{Module: "acpi_aml_vm", Message: "PCI segments other than 0 are not supported", Code: kernel.ErrCodeNotSupported}
+
+	mapRegionFn      = vmm.MapRegion
+	portReadByteFn   = cpu.PortReadByte
+	portReadWordFn   = cpu.PortReadWord
+	portReadDwordFn  = cpu.PortReadDword
+	portWriteByteFn  = cpu.PortWriteByte
+	portWriteWordFn  = cpu.PortWriteWord
+	portWriteDwordFn = cpu.PortWriteDword
+)
+
+// systemMemoryHandler implements a RegionHandler for the SystemMemory address
+// space. Physical memory pages are mapped into the kernel address space the
+// first time that they get accessed and the mappings are kept around for
+// subsequent accesses.
+type systemMemoryHandler struct {
+	mappings map[mm.Frame]mm.Page
+}
+
+func newSystemMemoryHandler() *systemMemoryHandler {
+	return &systemMemoryHandler{
+		mappings: make(map[mm.Frame]mm.Page),
+	}
+}
+
+// ReadRegion implements RegionHandler.
+func (h *systemMemoryHandler) ReadRegion(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	physAddr := uintptr(region.Offset + offset)
+
+	// Accesses that cross a page boundary are split into byte accesses
+	if straddlesPage(physAddr, width) {
+		var val uint64
+		for byteIndex := uint8(0); byteIndex < width>>3; byteIndex++ {
+			byteVal, err := h.ReadRegion(region, offset+uint64(byteIndex), 8)
+			if err != nil {
+				return 0, err
+			}
+			val |= byteVal << (byteIndex << 3)
+		}
+		return val, nil
+	}
+
+	addr, err := h.virtAddr(physAddr)
+	if err != nil {
+		return 0, err
+	}
+
+	switch width {
+	case 8:
+		return uint64(*(*uint8)(unsafe.Pointer(addr))), nil
+	case 16:
+		return uint64(*(*uint16)(unsafe.Pointer(addr))), nil
+	case 32:
+		return uint64(*(*uint32)(unsafe.Pointer(addr))), nil
+	default:
+		return *(*uint64)(unsafe.Pointer(addr)), nil
+	}
+}
+
+// WriteRegion implements RegionHandler.
+func (h *systemMemoryHandler) WriteRegion(region *Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	physAddr := uintptr(region.Offset + offset)
+
+	// Accesses that cross a page boundary are split into byte accesses
+	if straddlesPage(physAddr, width) {
+		for byteIndex := uint8(0); byteIndex < width>>3; byteIndex++ {
+			if err := h.WriteRegion(region, offset+uint64(byteIndex), 8, val>>(byteIndex<<3)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	addr, err := h.virtAddr(physAddr)
+	if err != nil {
+		return err
+	}
+
+	switch width {
+	case 8:
+		*(*uint8)(unsafe.Pointer(addr)) = uint8(val)
+	case 16:
+		*(*uint16)(unsafe.Pointer(addr)) = uint16(val)
+	case 32:
+		*(*uint32)(unsafe.Pointer(addr)) = uint32(val)
+	default:
+		*(*uint64)(unsafe.Pointer(addr)) = val
+	}
+
+	return nil
+}
+
+// virtAddr returns the virtual address that corresponds to physAddr, mapping
+// the physical page that contains it if required.
+func (h *systemMemoryHandler) virtAddr(physAddr uintptr) (uintptr, *kernel.Error) {
+	frame := mm.FrameFromAddress(physAddr)
+	page, exists := h.mappings[frame]
+	if !exists {
+		var err *kernel.Error
+		if page, err = mapRegionFn(frame, mm.PageSize, vmm.FlagPresent|vmm.FlagRW|vmm.FlagDoNotCache|vmm.FlagNoExecute); err != nil {
+			return 0, err
+		}
+		h.mappings[frame] = page
+	}
+
+	return page.Address() + (physAddr & (mm.PageSize - 1)), nil
+}
+
+// straddlesPage returns true if accessing width bits starting at addr would
+// cross a page boundary.
+func straddlesPage(addr uintptr, width uint8) bool {
+	return (addr&(mm.PageSize-1))+uintptr(width>>3) > mm.PageSize
+}
+
+// systemIOHandler implements a RegionHandler for the SystemIO address space.
+// 64-bit accesses are split into two 32-bit port accesses.
+type systemIOHandler struct{}
+
+// ReadRegion implements RegionHandler.
+func (systemIOHandler) ReadRegion(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	return readPort(uint16(region.Offset+offset), width), nil
+}
+
+// WriteRegion implements RegionHandler.
+func (systemIOHandler) WriteRegion(region *Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	writePort(uint16(region.Offset+offset), width, val)
+	return nil
+}
+
+// pciConfigHandler implements a RegionHandler for the PCI_Config address space
+// using PCI configuration access mechanism #1. Accesses that are not contained
+// within a single configuration space dword are split into smaller accesses.
+type pciConfigHandler struct{}
+
+// ReadRegion implements RegionHandler.
+func (h pciConfigHandler) ReadRegion(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	cfgOffset, err := pciConfigOffset(region, offset, width)
+	if err != nil {
+		return 0, err
+	}
+
+	if (cfgOffset&3)+uint64(width>>3) > 4 {
+		lo, _ := h.ReadRegion(region, offset, width>>1)
+		hi, _ := h.ReadRegion(region, offset+uint64(width>>4), width>>1)
+		return lo | hi<<(width>>1), nil
+	}
+
+	portWriteDwordFn(pciConfigAddressPort, pciConfigAddress(region, cfgOffset))
+	return readPort(pciConfigDataPort+uint16(cfgOffset&3), width), nil
+}
+
+// WriteRegion implements RegionHandler.
+func (h pciConfigHandler) WriteRegion(region *Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	cfgOffset, err := pciConfigOffset(region, offset, width)
+	if err != nil {
+		return err
+	}
+
+	if (cfgOffset&3)+uint64(width>>3) > 4 {
+		_ = h.WriteRegion(region, offset, width>>1, val)
+		return h.WriteRegion(region, offset+uint64(width>>4), width>>1, val>>(width>>1))
+	}
+
+	portWriteDwordFn(pciConfigAddressPort, pciConfigAddress(region, cfgOffset))
+	writePort(pciConfigDataPort+uint16(cfgOffset&3), width, val)
+	return nil
+}
+
+// pciECAMHandler implements a RegionHandler for the PCI_Config address space
+// using the memory-mapped enhanced configuration access mechanism (ECAM)
+// described by the MCFG table. Regions whose segment and bus are not covered
+// by any MCFG entry are accessed via the legacy pciConfigHandler.
+type pciECAMHandler struct {
+	entries []table.MCFGEntry
+
+	// The configuration space pages of each function are mapped the
+	// first time that they get accessed.
+	mem *systemMemoryHandler
+}
+
+func newPCIECAMHandler(entries []table.MCFGEntry) *pciECAMHandler {
+	return &pciECAMHandler{
+		entries: entries,
+		mem:     newSystemMemoryHandler(),
+	}
+}
+
+// ReadRegion implements RegionHandler.
+func (h *pciECAMHandler) ReadRegion(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	entry := h.entryFor(region)
+	if entry == nil {
+		return pciConfigHandler{}.ReadRegion(region, offset, width)
+	}
+
+	cfgOffset, err := pciExtConfigOffset(region, offset, width)
+	if err != nil {
+		return 0, err
+	}
+
+	if splitECAMAccess(cfgOffset, width) {
+		lo, err := h.ReadRegion(region, offset, width>>1)
+		if err != nil {
+			return 0, err
+		}
+		hi, err := h.ReadRegion(region, offset+uint64(width>>4), width>>1)
+		return lo | hi<<(width>>1), err
+	}
+
+	addr, err := h.mem.virtAddr(ecamAddress(entry, region, cfgOffset))
+	if err != nil {
+		return 0, err
+	}
+
+	switch width {
+	case 8:
+		return uint64(*(*uint8)(unsafe.Pointer(addr))), nil
+	case 16:
+		return uint64(*(*uint16)(unsafe.Pointer(addr))), nil
+	default:
+		return uint64(*(*uint32)(unsafe.Pointer(addr))), nil
+	}
+}
+
+// WriteRegion implements RegionHandler.
+func (h *pciECAMHandler) WriteRegion(region *Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	entry := h.entryFor(region)
+	if entry == nil {
+		return pciConfigHandler{}.WriteRegion(region, offset, width, val)
+	}
+
+	cfgOffset, err := pciExtConfigOffset(region, offset, width)
+	if err != nil {
+		return err
+	}
+
+	if splitECAMAccess(cfgOffset, width) {
+		if err = h.WriteRegion(region, offset, width>>1, val); err != nil {
+			return err
+		}
+		return h.WriteRegion(region, offset+uint64(width>>4), width>>1, val>>(width>>1))
+	}
+
+	addr, err := h.mem.virtAddr(ecamAddress(entry, region, cfgOffset))
+	if err != nil {
+		return err
+	}
+
+	switch width {
+	case 8:
+		*(*uint8)(unsafe.Pointer(addr)) = uint8(val)
+	case 16:
+		*(*uint16)(unsafe.Pointer(addr)) = uint16(val)
+	default:
+		*(*uint32)(unsafe.Pointer(addr)) = uint32(val)
+	}
+
+	return nil
+}
+
+// entryFor returns the MCFG entry that covers the segment and bus of region
+// or nil if the region is not accessible via ECAM.
+func (h *pciECAMHandler) entryFor(region *Region) *table.MCFGEntry {
+	for i := range h.entries {
+		if h.entries[i].Covers(region.PCISegment, region.PCIBus) {
+			return &h.entries[i]
+		}
+	}
+
+	return nil
+}
+
+// pciExtConfigOffset calculates the extended configuration space offset for
+// accessing width bits at the specified region offset.
+func pciExtConfigOffset(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	cfgOffset := region.Offset + offset
+	if cfgOffset+uint64(width>>3) > pciExtConfigSpaceSize {
+		return 0, errRegionOutOfBounds
+	}
+
+	return cfgOffset, nil
+}
+
+// splitECAMAccess returns true if accessing width bits at cfgOffset must be
+// split into smaller accesses. ECAM only supports naturally aligned accesses
+// of up to 32 bits.
+func splitECAMAccess(cfgOffset uint64, width uint8) bool {
+	return width > 32 || cfgOffset&uint64((width>>3)-1) != 0
+}
+
+// ecamAddress returns the physical address of the configuration space byte
+// at cfgOffset for the function that defines region.
+func ecamAddress(entry *table.MCFGEntry, region *Region, cfgOffset uint64) uintptr {
+	return uintptr(entry.BaseAddress +
+		uint64(region.PCIBus)<<20 +
+		uint64(region.PCIDevice&0x1f)<<15 +
+		uint64(region.PCIFunction&0x7)<<12 +
+		cfgOffset)
+}
+
+// pciConfigOffset calculates the configuration space offset for accessing
+// width bits at the specified region offset.
+func pciConfigOffset(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	if region.PCISegment != 0 {
+		return 0, errUnsupportedPCISegment
+	}
+
+	cfgOffset := region.Offset + offset
+	if cfgOffset+uint64(width>>3) > pciConfigSpaceSize {
+		return 0, errRegionOutOfBounds
+	}
+
+	return cfgOffset, nil
+}
+
+// pciConfigAddress returns the value that needs to be written to the
+// configuration address port for accessing the configuration space dword
+// that contains cfgOffset.
+func pciConfigAddress(region *Region, cfgOffset uint64) uint32 {
+	return 1<<31 |
+		uint32(region.PCIBus)<<16 |
+		uint32(region.PCIDevice&0x1f)<<11 |
+		uint32(region.PCIFunction&0x7)<<8 |
+		uint32(cfgOffset&0xfc)
+}
+
+// readPort reads width bits from the specified I/O port.
+func readPort(port uint16, width uint8) uint64 {
+	switch width {
+	case 8:
+		return uint64(portReadByteFn(port))
+	case 16:
+		return uint64(portReadWordFn(port))
+	case 32:
+		return uint64(portReadDwordFn(port))
+	default:
+		return uint64(portReadDwordFn(port)) | uint64(portReadDwordFn(port+4))<<32
+	}
+}
+
+// writePort writes the lower width bits of val to the specified I/O port.
+func writePort(port uint16, width uint8, val uint64) {
+	switch width {
+	case 8:
+		portWriteByteFn(port, uint8(val))
+	case 16:
+		portWriteWordFn(port, uint16(val))
+	case 32:
+		portWriteDwordFn(port, uint32(val))
+	default:
+		portWriteDwordFn(port, uint32(val))
+		portWriteDwordFn(port+4, uint32(val>>32))
+	}
+}
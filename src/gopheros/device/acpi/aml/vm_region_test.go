@@ -0,0 +1,642 @@
+package aml
+
+import (
+	"bytes"
+	"fmt"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestVMFieldAccess(t *testing.T) {
+	payload := amltest.Concat(
+		// OperationRegion(REG0, SystemIO, 0x80, 0x10)
+		[]byte{0x5b, 0x80, 'R', 'E', 'G', '0', 0x01, 0x0a, 0x80, 0x0a, 0x10},
+		// Field(REG0, ByteAcc, NoLock, Preserve) {
+		//   FLD0, 4, FLD1, 12, FLD2, 8, FLD3, 8, WIDE, 72
+		// }
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{
+			'R', 'E', 'G', '0', 0x01,
+			'F', 'L', 'D', '0', 0x04,
+			'F', 'L', 'D', '1', 0x0c,
+			'F', 'L', 'D', '2', 0x08,
+			'F', 'L', 'D', '3', 0x08,
+			'W', 'I', 'D', 'E', 0x48, 0x04,
+		}),
+		// Field(REG0, ByteAcc, NoLock, Preserve) { Offset(16), OOBF, 8 }
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{
+			'R', 'E', 'G', '0', 0x01,
+			0x00, 0x40, 0x08,
+			'O', 'O', 'B', 'F', 0x08,
+		}),
+		// OperationRegion(ECRG, EmbeddedControl, 0, 0x10)
+		[]byte{0x5b, 0x80, 'E', 'C', 'R', 'G', 0x03, 0x00, 0x0a, 0x10},
+		// Field(ECRG, ByteAcc, NoLock, Preserve) { ECF0, 8 }
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{'E', 'C', 'R', 'G', 0x01, 'E', 'C', 'F', '0', 0x08}),
+		// Method(WFLD, 1) { Store(Arg0, FLD1) }
+		amltest.Pkg([]byte{0x14}, []byte{'W', 'F', 'L', 'D', 0x01, 0x70, 0x68, 'F', 'L', 'D', '1'}),
+		// Method(WWID, 1) { Store(Arg0, WIDE) }
+		amltest.Pkg([]byte{0x14}, []byte{'W', 'W', 'I', 'D', 0x01, 0x70, 0x68, 'W', 'I', 'D', 'E'}),
+	)
+
+	vm := vmForPayload(t, payload)
+	handler := &mockRegionHandler{
+		data: []byte{0xa5, 0x3c, 0x7e, 0x81, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12},
+	}
+	vm.RegisterRegionHandler(RegionSpaceSystemIO, handler)
+
+	t.Run("read", func(t *testing.T) {
+		specs := []struct {
+			path string
+			exp  interface{}
+		}{
+			{`FLD0`, uint64(0x5)},
+			{`FLD1`, uint64(0x3ca)},
+			{`FLD2`, uint64(0x7e)},
+			{`FLD3`, uint64(0x81)},
+			{`WIDE`, []byte{1, 2, 3, 4, 5, 6, 7, 8, 9}},
+		}
+
+		for specIndex, spec := range specs {
+			got, err := vm.Evaluate(spec.path)
+			if err != nil {
+				t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+				continue
+			}
+
+			if !reflect.DeepEqual(got, spec.exp) {
+				t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.exp, got)
+			}
+		}
+
+		if exp := (Region{Space: RegionSpaceSystemIO, Offset: 0x80, Length: 0x10}); *handler.lastRegion != exp {
+			t.Errorf("expected handler to be invoked with region %+v; got %+v", exp, *handler.lastRegion)
+		}
+	})
+
+	t.Run("write", func(t *testing.T) {
+		if _, err := vm.Evaluate(`WFLD`, 0x123); err != nil {
+			t.Fatal(err)
+		}
+
+		// FLD0 and FLD2 should be preserved
+		if exp := []byte{0x35, 0x12, 0x7e}; !bytes.Equal(handler.data[:3], exp) {
+			t.Errorf("expected region contents to be %v; got %v", exp, handler.data[:3])
+		}
+
+		// Buffers shorter than the field are zero-extended
+		if _, err := vm.Evaluate(`WWID`, []byte{0xff, 0xee}); err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := []byte{0x81, 0xff, 0xee, 0, 0, 0, 0, 0, 0, 0, 10, 11, 12}; !bytes.Equal(handler.data[3:], exp) {
+			t.Errorf("expected region contents to be %v; got %v", exp, handler.data[3:])
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		specs := []struct {
+			path   string
+			expErr *kernel.Error
+		}{
+			{`OOBF`, errRegionOutOfBounds},
+			{`ECF0`, errNoRegionHandler},
+		}
+
+		for specIndex, spec := range specs {
+			if _, err := vm.Evaluate(spec.path); err != spec.expErr {
+				t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			}
+		}
+
+		vm.RegisterRegionHandler(RegionSpaceSystemIO, nil)
+		if _, err := vm.Evaluate(`FLD0`); err != errNoRegionHandler {
+			t.Errorf("expected to get errNoRegionHandler after uninstalling the handler; got %v", err)
+		}
+	})
+}
+
+func TestVMPCIConfigRegionAddress(t *testing.T) {
+	payload := amltest.Concat(
+		// Device(PCI0) {
+		//   Name(_BBN, 2)
+		//   Name(_ADR, 0)
+		//   Device(DEV1) {
+		//     Name(_ADR, 0x001f0003)
+		//     OperationRegion(PCIC, PCI_Config, 0x40, 0x10)
+		//     Field(PCIC, ByteAcc, NoLock, Preserve) { PFLD, 8 }
+		//   }
+		// }
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'P', 'C', 'I', '0'},
+			[]byte{0x08, '_', 'B', 'B', 'N', 0x0a, 0x02},
+			[]byte{0x08, '_', 'A', 'D', 'R', 0x00},
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'D', 'E', 'V', '1'},
+				[]byte{0x08, '_', 'A', 'D', 'R', 0x0c, 0x03, 0x00, 0x1f, 0x00},
+				[]byte{0x5b, 0x80, 'P', 'C', 'I', 'C', 0x02, 0x0a, 0x40, 0x0a, 0x10},
+				amltest.Pkg([]byte{0x5b, 0x81}, []byte{'P', 'C', 'I', 'C', 0x01, 'P', 'F', 'L', 'D', 0x08}),
+			)),
+		)),
+	)
+
+	vm := vmForPayload(t, payload)
+	handler := &mockRegionHandler{data: make([]byte, 16)}
+	vm.RegisterRegionHandler(RegionSpacePCIConfig, handler)
+
+	if _, err := vm.Evaluate(`\PCI0.DEV1.PFLD`); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := Region{
+		Space:       RegionSpacePCIConfig,
+		Offset:      0x40,
+		Length:      0x10,
+		PCIBus:      2,
+		PCIDevice:   0x1f,
+		PCIFunction: 3,
+	}
+
+	if *handler.lastRegion != exp {
+		t.Fatalf("expected handler to be invoked with region %+v; got %+v", exp, *handler.lastRegion)
+	}
+}
+
+func TestVMRegionAccessWidth(t *testing.T) {
+	vm := NewVM(nil, nil)
+	vm.RegisterRegionHandler(RegionSpaceSystemIO, &mockRegionHandler{data: make([]byte, 8)})
+	region := &Region{Space: RegionSpaceSystemIO, Length: 8}
+
+	for _, width := range []uint8{0, 7, 24, 128} {
+		if _, err := vm.readRegion(region, 0, width); err != errInvalidAccessWidth {
+			t.Errorf("[width %d] expected to get errInvalidAccessWidth; got %v", width, err)
+		}
+
+		if err := vm.writeRegion(region, 0, width, 0); err != errInvalidAccessWidth {
+			t.Errorf("[width %d] expected to get errInvalidAccessWidth; got %v", width, err)
+		}
+	}
+
+	if _, err := vm.readRegion(region, 4, 64); err != errRegionOutOfBounds {
+		t.Errorf("expected to get errRegionOutOfBounds; got %v", err)
+	}
+}
+
+func TestSystemMemoryRegionHandler(t *testing.T) {
+	defer func() {
+		mapRegionFn = vmm.MapRegion
+	}()
+
+	// Allocate a buffer large enough to contain two aligned pages and use it
+	// as the target for any region mappings
+	buf := make([]byte, 3*mm.PageSize)
+	bufPage := mm.PageFromAddress(uintptr(unsafe.Pointer(&buf[0])) + mm.PageSize - 1)
+	bufBase := bufPage.Address() - uintptr(unsafe.Pointer(&buf[0]))
+
+	var mapCount int
+	mapRegionFn = func(frame mm.Frame, size uintptr, flags vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		mapCount++
+		if flags&vmm.FlagDoNotCache == 0 {
+			t.Error("expected region to be mapped with caching disabled")
+		}
+
+		return bufPage + mm.Page(frame-0x100), nil
+	}
+
+	h := newSystemMemoryHandler()
+	region := &Region{Space: RegionSpaceSystemMemory, Offset: 0x100ff8, Length: 0x10}
+
+	specs := []struct {
+		offset uint64
+		width  uint8
+		val    uint64
+	}{
+		{0, 8, 0xaa},
+		{1, 16, 0xbbcc},
+		{4, 32, 0xddeeff00},
+		{8, 64, 0x1122334455667788},
+		// Straddles the page boundary
+		{4, 64, 0x0102030405060708},
+	}
+
+	for specIndex, spec := range specs {
+		if err := h.WriteRegion(region, spec.offset, spec.width, spec.val); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		got, err := h.ReadRegion(region, spec.offset, spec.width)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got != spec.val {
+			t.Errorf("[spec %d] expected to read back 0x%x; got 0x%x", specIndex, spec.val, got)
+		}
+	}
+
+	if exp := []byte{0x08, 0x07, 0x06, 0x05}; !bytes.Equal(buf[bufBase+0xffc:bufBase+0x1000], exp) {
+		t.Errorf("expected page contents to be %v; got %v", exp, buf[bufBase+0xffc:bufBase+0x1000])
+	}
+
+	if exp := []byte{0x04, 0x03, 0x02, 0x01, 0x44, 0x33, 0x22, 0x11}; !bytes.Equal(buf[bufBase+0x1000:bufBase+0x1008], exp) {
+		t.Errorf("expected page contents to be %v; got %v", exp, buf[bufBase+0x1000:bufBase+0x1008])
+	}
+
+	if mapCount != 2 {
+		t.Errorf("expected each page to be mapped once; got %d mappings", mapCount)
+	}
+
+	t.Run("map error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapRegionFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+
+		h := newSystemMemoryHandler()
+		if _, err := h.ReadRegion(region, 0, 8); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if err := h.WriteRegion(region, 0, 8, 0); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if _, err := h.ReadRegion(region, 4, 64); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if err := h.WriteRegion(region, 4, 64, 0); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestSystemIORegionHandler(t *testing.T) {
+	defer restorePortFns()
+	ports := mockPortFns()
+
+	var (
+		h      systemIOHandler
+		region = &Region{Space: RegionSpaceSystemIO, Offset: 0x400, Length: 0x10}
+	)
+
+	specs := []struct {
+		offset  uint64
+		width   uint8
+		val     uint64
+		expPort uint16
+	}{
+		{0, 8, 0xaa, 0x400},
+		{2, 16, 0xbbcc, 0x402},
+		{4, 32, 0xddeeff00, 0x404},
+		{8, 64, 0x1122334455667788, 0x408},
+	}
+
+	for specIndex, spec := range specs {
+		if err := h.WriteRegion(region, spec.offset, spec.width, spec.val); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got, _ := h.ReadRegion(region, spec.offset, spec.width); got != spec.val {
+			t.Errorf("[spec %d] expected to read back 0x%x; got 0x%x", specIndex, spec.val, got)
+		}
+
+		if got := ports.readUint(spec.expPort, spec.width); got != spec.val {
+			t.Errorf("[spec %d] expected port 0x%x to contain 0x%x; got 0x%x", specIndex, spec.expPort, spec.val, got)
+		}
+	}
+}
+
+func TestPCIConfigRegionHandler(t *testing.T) {
+	defer restorePortFns()
+	ports := mockPortFns()
+
+	var (
+		h      pciConfigHandler
+		region = &Region{
+			Space:       RegionSpacePCIConfig,
+			Offset:      0x40,
+			Length:      0x20,
+			PCIBus:      1,
+			PCIDevice:   2,
+			PCIFunction: 3,
+		}
+		expAddr = uint32(1<<31 | 1<<16 | 2<<11 | 3<<8)
+	)
+
+	t.Run("aligned access", func(t *testing.T) {
+		specs := []struct {
+			offset  uint64
+			width   uint8
+			expAddr uint32
+			expPort uint16
+		}{
+			{0, 8, expAddr | 0x40, 0xcfc},
+			{3, 8, expAddr | 0x40, 0xcff},
+			{6, 16, expAddr | 0x44, 0xcfe},
+			{8, 32, expAddr | 0x48, 0xcfc},
+		}
+
+		for specIndex, spec := range specs {
+			if err := h.WriteRegion(region, spec.offset, spec.width, 0xff); err != nil {
+				t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+				continue
+			}
+
+			if got := ports.readUint(pciConfigAddressPort, 32); got != uint64(spec.expAddr) {
+				t.Errorf("[spec %d] expected config address to be 0x%x; got 0x%x", specIndex, spec.expAddr, got)
+			}
+
+			if got := ports.readUint(spec.expPort, spec.width); got != 0xff {
+				t.Errorf("[spec %d] expected data to be written to port 0x%x", specIndex, spec.expPort)
+			}
+
+			if got, _ := h.ReadRegion(region, spec.offset, spec.width); got != 0xff {
+				t.Errorf("[spec %d] expected to read back 0xff; got 0x%x", specIndex, got)
+			}
+		}
+	})
+
+	t.Run("split access", func(t *testing.T) {
+		if err := h.WriteRegion(region, 0x10, 64, 0x1122334455667788); err != nil {
+			t.Fatal(err)
+		}
+
+		// The last access should target the upper dword
+		if got := ports.readUint(pciConfigAddressPort, 32); got != uint64(expAddr|0x54) {
+			t.Errorf("expected config address to be 0x%x; got 0x%x", expAddr|0x54, got)
+		}
+
+		if got := ports.readUint(pciConfigDataPort, 32); got != 0x11223344 {
+			t.Errorf("expected data port to contain 0x11223344; got 0x%x", got)
+		}
+
+		if got, _ := h.ReadRegion(region, 0x10, 64); got != 0x1122334411223344 {
+			t.Errorf("expected to read back 0x1122334411223344; got 0x%x", got)
+		}
+
+		// A word access that straddles a dword boundary is split into
+		// two byte accesses.
+		if err := h.WriteRegion(region, 0x13, 16, 0xaabb); err != nil {
+			t.Fatal(err)
+		}
+
+		if got := ports.readUint(pciConfigAddressPort, 32); got != uint64(expAddr|0x54) {
+			t.Errorf("expected config address to be 0x%x; got 0x%x", expAddr|0x54, got)
+		}
+
+		if got := ports.readUint(pciConfigDataPort, 8); got != 0xaa {
+			t.Errorf("expected data port to contain 0xaa; got 0x%x", got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := h.ReadRegion(&Region{Offset: 0xfc, Length: 8}, 0, 64); err != errRegionOutOfBounds {
+			t.Errorf("expected to get errRegionOutOfBounds; got %v", err)
+		}
+
+		if err := h.WriteRegion(&Region{PCISegment: 1, Length: 8}, 0, 8, 0); err != errUnsupportedPCISegment {
+			t.Errorf("expected to get errUnsupportedPCISegment; got %v", err)
+		}
+	})
+}
+
+func TestPCIECAMRegionHandler(t *testing.T) {
+	defer func() {
+		mapRegionFn = vmm.MapRegion
+		restorePortFns()
+	}()
+	ports := mockPortFns()
+
+	// Allocate a buffer large enough to contain an aligned page and use it
+	// as the target for any configuration space mappings
+	buf := make([]byte, 2*mm.PageSize)
+	bufPage := mm.PageFromAddress(uintptr(unsafe.Pointer(&buf[0])) + mm.PageSize - 1)
+	bufBase := bufPage.Address() - uintptr(unsafe.Pointer(&buf[0]))
+
+	var mappedFrames []mm.Frame
+	mapRegionFn = func(frame mm.Frame, size uintptr, flags vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		mappedFrames = append(mappedFrames, frame)
+		if flags&vmm.FlagDoNotCache == 0 {
+			t.Error("expected region to be mapped with caching disabled")
+		}
+		return bufPage, nil
+	}
+
+	h := newPCIECAMHandler([]table.MCFGEntry{
+		{BaseAddress: 0xe0000000, Segment: 0, StartBus: 0, EndBus: 0x0f},
+		{BaseAddress: 0x100000000, Segment: 1, StartBus: 0x10, EndBus: 0x1f},
+	})
+
+	t.Run("ECAM access", func(t *testing.T) {
+		region := &Region{
+			Space:       RegionSpacePCIConfig,
+			Offset:      0x100,
+			Length:      0x20,
+			PCISegment:  1,
+			PCIBus:      0x11,
+			PCIDevice:   2,
+			PCIFunction: 3,
+		}
+
+		specs := []struct {
+			offset uint64
+			width  uint8
+			val    uint64
+		}{
+			{0, 8, 0xaa},
+			{2, 16, 0xbbcc},
+			{4, 32, 0xddeeff00},
+			{8, 64, 0x1122334455667788},
+			// Unaligned accesses are split
+			{0x11, 32, 0x01020304},
+		}
+
+		for specIndex, spec := range specs {
+			if err := h.WriteRegion(region, spec.offset, spec.width, spec.val); err != nil {
+				t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+				continue
+			}
+
+			got, err := h.ReadRegion(region, spec.offset, spec.width)
+			if err != nil {
+				t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+				continue
+			}
+
+			if got != spec.val {
+				t.Errorf("[spec %d] expected to read back 0x%x; got 0x%x", specIndex, spec.val, got)
+			}
+		}
+
+		if exp := []byte{0xaa, 0x00, 0xcc, 0xbb, 0x00, 0xff, 0xee, 0xdd}; !bytes.Equal(buf[bufBase+0x100:bufBase+0x108], exp) {
+			t.Errorf("expected config space contents to be %v; got %v", exp, buf[bufBase+0x100:bufBase+0x108])
+		}
+
+		// bus 0x11, device 2, function 3
+		expFrame := mm.FrameFromAddress(0x100000000 + 0x11<<20 + 2<<15 + 3<<12)
+		if len(mappedFrames) != 1 || mappedFrames[0] != expFrame {
+			t.Errorf("expected frame %v to be mapped once; got %v", expFrame, mappedFrames)
+		}
+
+		if len(ports) != 0 {
+			t.Error("expected no port I/O to take place")
+		}
+	})
+
+	t.Run("legacy fallback", func(t *testing.T) {
+		region := &Region{Space: RegionSpacePCIConfig, Offset: 0x40, Length: 4, PCIBus: 0x20, PCIDevice: 1}
+		if err := h.WriteRegion(region, 0, 32, 0xcafebabe); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, _ := h.ReadRegion(region, 0, 32); got != 0xcafebabe {
+			t.Errorf("expected to read back 0xcafebabe; got 0x%x", got)
+		}
+
+		if exp, got := uint64(1<<31|0x20<<16|1<<11|0x40), ports.readUint(pciConfigAddressPort, 32); got != exp {
+			t.Errorf("expected config address to be 0x%x; got 0x%x", exp, got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		if _, err := h.ReadRegion(&Region{Offset: 0xffc, Length: 8}, 0, 64); err != errRegionOutOfBounds {
+			t.Errorf("expected to get errRegionOutOfBounds; got %v", err)
+		}
+
+		// Not covered by the MCFG entries and not accessible via the
+		// legacy mechanism
+		if err := h.WriteRegion(&Region{PCISegment: 2, Length: 8}, 0, 8, 0); err != errUnsupportedPCISegment {
+			t.Errorf("expected to get errUnsupportedPCISegment; got %v", err)
+		}
+
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapRegionFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+
+		h := newPCIECAMHandler(h.entries)
+		region := &Region{Length: 8}
+		if _, err := h.ReadRegion(region, 0, 64); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if err := h.WriteRegion(region, 0, 64, 0); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestRegisterDefaultRegionHandlers(t *testing.T) {
+	vm := NewVM(nil, nil)
+	vm.RegisterDefaultRegionHandlers()
+
+	for _, space := range []RegionSpace{RegionSpaceSystemMemory, RegionSpaceSystemIO, RegionSpacePCIConfig} {
+		if vm.regionHandlers[space] == nil {
+			t.Errorf("expected a default handler to be registered for space %d", space)
+		}
+	}
+
+	t.Run("PCI_Config handler selection", func(t *testing.T) {
+		mcfg := make([]byte, 60)
+		copy(mcfg, "MCFG")
+		mcfg[4] = byte(len(mcfg))
+		mcfg[47], mcfg[55] = 0xe0, 0xff
+
+		specs := []struct {
+			resolver table.Resolver
+			expECAM  bool
+		}{
+			{nil, false},
+			{ssdtResolver(ssdtImage("SSDT", "MYOEM", nil)), false},
+			{ssdtResolver(mcfg), true},
+		}
+
+		for specIndex, spec := range specs {
+			vm := NewVM(nil, nil)
+			if spec.resolver != nil {
+				vm.SetTableResolver(spec.resolver)
+			}
+			vm.RegisterDefaultRegionHandlers()
+
+			if _, isECAM := vm.regionHandlers[RegionSpacePCIConfig].(*pciECAMHandler); isECAM != spec.expECAM {
+				t.Errorf("[spec %d] expected ECAM handler selection to be %t", specIndex, spec.expECAM)
+			}
+		}
+	})
+}
+
+// mockRegionHandler implements a RegionHandler backed by a byte slice. Each
+// region access is also recorded to the handler's access log.
+type mockRegionHandler struct {
+	data       []byte
+	lastRegion *Region
+	log        []string
+}
+
+func (h *mockRegionHandler) ReadRegion(region *Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	h.lastRegion = region
+	h.log = append(h.log, fmt.Sprintf("R%d:%d", offset, width))
+
+	var val uint64
+	for i := uint64(0); i < uint64(width>>3); i++ {
+		val |= uint64(h.data[offset+i]) << (i << 3)
+	}
+	return val, nil
+}
+
+func (h *mockRegionHandler) WriteRegion(region *Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	h.lastRegion = region
+	h.log = append(h.log, fmt.Sprintf("W%d:%d=0x%x", offset, width, val))
+
+	for i := uint64(0); i < uint64(width>>3); i++ {
+		h.data[offset+i] = byte(val >> (i << 3))
+	}
+	return nil
+}
+
+// mockPorts emulates an I/O port address space where each port holds a byte.
+type mockPorts map[uint16]uint8
+
+func (p mockPorts) readUint(port uint16, width uint8) uint64 {
+	var val uint64
+	for i := uint16(0); i < uint16(width>>3); i++ {
+		val |= uint64(p[port+i]) << (i << 3)
+	}
+	return val
+}
+
+func (p mockPorts) writeUint(port uint16, width uint8, val uint64) {
+	for i := uint16(0); i < uint16(width>>3); i++ {
+		p[port+i] = uint8(val >> (i << 3))
+	}
+}
+
+func mockPortFns() mockPorts {
+	ports := make(mockPorts)
+	portReadByteFn = func(port uint16) uint8 { return uint8(ports.readUint(port, 8)) }
+	portReadWordFn = func(port uint16) uint16 { return uint16(ports.readUint(port, 16)) }
+	portReadDwordFn = func(port uint16) uint32 { return uint32(ports.readUint(port, 32)) }
+	portWriteByteFn = func(port uint16, val uint8) { ports.writeUint(port, 8, uint64(val)) }
+	portWriteWordFn = func(port uint16, val uint16) { ports.writeUint(port, 16, uint64(val)) }
+	portWriteDwordFn = func(port uint16, val uint32) { ports.writeUint(port, 32, uint64(val)) }
+	return ports
+}
+
+func restorePortFns() {
+	portReadByteFn = cpu.PortReadByte
+	portReadWordFn = cpu.PortReadWord
+	portReadDwordFn = cpu.PortReadDword
+	portWriteByteFn = cpu.PortWriteByte
+	portWriteWordFn = cpu.PortWriteWord
+	portWriteDwordFn = cpu.PortWriteDword
+}
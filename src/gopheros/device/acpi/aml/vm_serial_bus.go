@@ -0,0 +1,248 @@
+package aml
+
+import "gopheros/kernel"
+
+// The status codes reported in the first byte of the data buffer exchanged
+// with SMBus and GenericSerialBus fields.
+const (
+	SerialBusStatusOK                  uint8 = 0x00
+	SerialBusStatusUnknownFailure      uint8 = 0x07
+	SerialBusStatusAddressNotAcked     uint8 = 0x10
+	SerialBusStatusDeviceError         uint8 = 0x11
+	SerialBusStatusCommandDenied       uint8 = 0x12
+	SerialBusStatusUnknownError        uint8 = 0x13
+	SerialBusStatusAccessDenied        uint8 = 0x17
+	SerialBusStatusTimeout             uint8 = 0x18
+	SerialBusStatusUnsupportedProtocol uint8 = 0x19
+	SerialBusStatusBusy                uint8 = 0x1a
+)
+
+// The access attributes that select the bus protocol used for accessing
+// SMBus and GenericSerialBus fields. The last three attributes are only
+// available via ExtendedAccessField and use the access length of the field
+// to specify the number of transferred bytes.
+const (
+	SerialBusAttribQuick            uint8 = 0x02
+	SerialBusAttribSendReceive      uint8 = 0x04
+	SerialBusAttribByte             uint8 = 0x06
+	SerialBusAttribWord             uint8 = 0x08
+	SerialBusAttribBlock            uint8 = 0x0a
+	SerialBusAttribProcessCall      uint8 = 0x0c
+	SerialBusAttribBlockProcessCall uint8 = 0x0d
+	SerialBusAttribBytes            uint8 = 0x0b
+	SerialBusAttribRawBytes         uint8 = 0x0e
+	SerialBusAttribRawProcessBytes  uint8 = 0x0f
+)
+
+const (
+	// The size of the status and length header that precedes the data
+	// bytes in a serial bus data buffer.
+	serialBusHeaderLen = 2
+
+	// The maximum number of data bytes that can be transferred by an
+	// SMBus and a GenericSerialBus transaction.
+	smbusMaxDataLen = 32
+	gsbusMaxDataLen = 255
+)
+
+// SerialBusRequest describes a transaction on an SMBus or GenericSerialBus
+// address space.
+type SerialBusRequest struct {
+	// The address space and region that the accessed field belongs to.
+	Space  RegionSpace
+	Region *Region
+
+	// Write is true if the field is being written.
+	Write bool
+
+	// The slave address (SMBus only; encoded in bits [8:15] of the region
+	// offset) and the command value which is derived from the field
+	// offset.
+	Address uint16
+	Command uint8
+
+	// The protocol (one of the SerialBusAttrib values) and, for protocols
+	// that transfer a variable number of bytes, the access length
+	// specified by the field declaration.
+	Protocol     uint8
+	AccessLength uint8
+
+	// Connection contains the resource template (e.g. an I2cSerialBus
+	// descriptor) specified via the Connection macro for
+	// GenericSerialBus fields.
+	Connection []byte
+
+	// For writes, Data contains the bytes to be sent. For reads, the
+	// handler replaces Data with the bytes that were received.
+	Data []byte
+}
+
+// SerialBusHandler is implemented by bus controller drivers that service the
+// accesses to fields located in SMBus or GenericSerialBus operation regions.
+// Transfer performs the transaction described by req and returns one of the
+// SerialBusStatus values which is reported back to the AML code.
+type SerialBusHandler interface {
+	Transfer(req *SerialBusRequest) uint8
+}
+
+// RegisterSerialBusHandler installs a handler for accessing fields located in
+// SMBus or GenericSerialBus operation regions, replacing any previously
+// registered handler for the same space. Passing a nil handler uninstalls the
+// handler for the space. Accesses to serial bus fields without a registered
+// handler do not abort method execution; instead they complete with a
+// SerialBusStatusUnknownFailure status.
+func (vm *VM) RegisterSerialBusHandler(space RegionSpace, handler SerialBusHandler) {
+	if handler == nil {
+		delete(vm.serialBusHandlers, space)
+		return
+	}
+
+	vm.serialBusHandlers[space] = handler
+}
+
+// isSerialBusSpace returns true if accesses to the fields of regions in space
+// exchange data buffers instead of integer values.
+func isSerialBusSpace(space RegionSpace) bool {
+	return space == RegionSpaceSMBus || space == RegionSpaceGenericSerialBus
+}
+
+// readSerialBusField performs a read transaction for a field located in an
+// SMBus or GenericSerialBus region and returns a buffer containing the
+// transaction status, the number of bytes read and the data bytes.
+func (vm *VM) readSerialBusField(ctx *execContext, field *fieldElement, region *Region) ([]byte, *kernel.Error) {
+	req, err := vm.serialBusRequest(ctx, field, region)
+	if err != nil {
+		return nil, err
+	}
+
+	return vm.serialBusTransfer(req), nil
+}
+
+// writeSerialBusField performs a write transaction for a field located in an
+// SMBus or GenericSerialBus region. The data to write is extracted from val
+// which must use the same layout as the buffer returned by
+// readSerialBusField. As required by the spec, the buffer with the
+// transaction result becomes the result of the Store operation that
+// triggered the write.
+func (vm *VM) writeSerialBusField(ctx *execContext, field *fieldElement, region *Region, val interface{}) *kernel.Error {
+	req, err := vm.serialBusRequest(ctx, field, region)
+	if err != nil {
+		return err
+	}
+
+	buf, err := vm.toBuffer(val)
+	if err != nil {
+		return err
+	}
+
+	var dataLen int
+	if len(buf) > serialBusHeaderLen {
+		dataLen = serialBusDataLen(req, buf[1])
+		if maxLen := len(buf) - serialBusHeaderLen; dataLen > maxLen {
+			dataLen = maxLen
+		}
+		req.Data = buf[serialBusHeaderLen : serialBusHeaderLen+dataLen]
+	}
+	req.Write = true
+
+	ctx.storeResult = vm.serialBusTransfer(req)
+	return nil
+}
+
+// serialBusRequest populates a SerialBusRequest for accessing field.
+func (vm *VM) serialBusRequest(ctx *execContext, field *fieldElement, region *Region) (*SerialBusRequest, *kernel.Error) {
+	req := &SerialBusRequest{
+		Space:        region.Space,
+		Region:       region,
+		Command:      uint8(region.Offset + uint64(field.offset>>3)),
+		Protocol:     field.accessAttrib,
+		AccessLength: field.accessLength,
+	}
+
+	if region.Space == RegionSpaceSMBus {
+		req.Address = uint16(region.Offset>>8) & 0xff
+	}
+
+	if field.connectionIndex != InvalidIndex {
+		conn, err := vm.connectionBytes(ctx, vm.tree.ObjectAt(field.connectionIndex))
+		if err != nil {
+			return nil, err
+		}
+		req.Connection = conn
+	}
+
+	return req, nil
+}
+
+// connectionBytes returns the resource template referenced by a Connection
+// object. The template is either specified inline or via a named Buffer.
+func (vm *VM) connectionBytes(ctx *execContext, connObj *Object) ([]byte, *kernel.Error) {
+	if connObj == nil {
+		return nil, errMalformedObject
+	}
+
+	arg := vm.tree.ArgAt(connObj, 0)
+	if arg == nil {
+		return nil, vm.fail(connObj, errMalformedObject)
+	}
+
+	if arg.opcode == pOpIntByteList {
+		data, _ := arg.value.([]byte)
+		return data, nil
+	}
+
+	val, err := vm.eval(&execContext{scopeIndex: connObj.index, depth: ctx.depth, thread: ctx.thread}, arg)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := vm.toBuffer(val)
+	if err != nil {
+		return nil, vm.fail(connObj, err)
+	}
+
+	return data, nil
+}
+
+// serialBusTransfer passes req to the handler registered for its address
+// space and returns the data buffer describing the transaction result.
+func (vm *VM) serialBusTransfer(req *SerialBusRequest) []byte {
+	maxLen := smbusMaxDataLen
+	if req.Space == RegionSpaceGenericSerialBus {
+		maxLen = gsbusMaxDataLen
+	}
+
+	buf := make([]byte, serialBusHeaderLen+maxLen)
+	buf[0] = SerialBusStatusUnknownFailure
+
+	handler, exists := vm.serialBusHandlers[req.Space]
+	if !exists {
+		return buf
+	}
+
+	buf[0] = handler.Transfer(req)
+	if !req.Write {
+		buf[1] = uint8(copy(buf[serialBusHeaderLen:], req.Data))
+	}
+
+	return buf
+}
+
+// serialBusDataLen returns the number of data bytes that a write transaction
+// using the request protocol transfers. For protocols that transfer a
+// variable number of bytes, bufLen contains the length from the buffer
+// header.
+func serialBusDataLen(req *SerialBusRequest, bufLen uint8) int {
+	switch req.Protocol {
+	case SerialBusAttribQuick:
+		return 0
+	case SerialBusAttribSendReceive, SerialBusAttribByte:
+		return 1
+	case SerialBusAttribWord, SerialBusAttribProcessCall:
+		return 2
+	case SerialBusAttribBytes, SerialBusAttribRawBytes, SerialBusAttribRawProcessBytes:
+		return int(req.AccessLength)
+	default:
+		return int(bufLen)
+	}
+}
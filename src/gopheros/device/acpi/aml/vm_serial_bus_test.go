@@ -0,0 +1,168 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"reflect"
+	"testing"
+)
+
+type mockSerialBusHandler struct {
+	status uint8
+	data   []byte
+	reqs   []SerialBusRequest
+}
+
+func (h *mockSerialBusHandler) Transfer(req *SerialBusRequest) uint8 {
+	h.reqs = append(h.reqs, *req)
+	if !req.Write {
+		req.Data = h.data
+	}
+	return h.status
+}
+
+func TestVMSerialBusFieldAccess(t *testing.T) {
+	payload := amltest.Concat(
+		// OperationRegion(SMB0, SMBus, 0x4200, 0x100)
+		[]byte{0x5b, 0x80, 'S', 'M', 'B', '0', 0x04, 0x0b, 0x00, 0x42, 0x0b, 0x00, 0x01},
+		// Field(SMB0, BufferAcc, NoLock, Preserve) {
+		//   Offset(0x10), AccessAs(BufferAcc, SMBWord), WRD0, 8,
+		//   AccessAs(BufferAcc, SMBBlock), BLK0, 8
+		// }
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{
+			'S', 'M', 'B', '0', 0x05,
+			0x00, 0x40, 0x08,
+			0x01, 0x05, 0x08,
+			'W', 'R', 'D', '0', 0x08,
+			0x01, 0x05, 0x0a,
+			'B', 'L', 'K', '0', 0x08,
+		}),
+		// OperationRegion(GSB0, GenericSerialBus, 0, 0x100)
+		[]byte{0x5b, 0x80, 'G', 'S', 'B', '0', 0x09, 0x00, 0x0b, 0x00, 0x01},
+		// Field(GSB0, BufferAcc, NoLock, Preserve) {
+		//   Connection(Buffer(){0xaa, 0xbb, 0xcc}),
+		//   AccessAs(BufferAcc, AttribBytes(3)), GSF0, 8
+		// }
+		amltest.Pkg([]byte{0x5b, 0x81}, amltest.Concat(
+			[]byte{'G', 'S', 'B', '0', 0x05, 0x02},
+			amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x03, 0xaa, 0xbb, 0xcc}),
+			[]byte{0x03, 0x05, 0x0b, 0x03, 'G', 'S', 'F', '0', 0x08},
+		)),
+		// Method(WWRD) { Return(Store(Buffer(){0x00, 0x02, 0x34, 0x12}, WRD0)) }
+		amltest.Pkg([]byte{0x14}, amltest.Concat(
+			[]byte{'W', 'W', 'R', 'D', 0x00, 0xa4, 0x70},
+			amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x04, 0x00, 0x02, 0x34, 0x12}),
+			[]byte{'W', 'R', 'D', '0'},
+		)),
+		// Method(WBLK) { Return(Store(Buffer(){0x00, 0x02, 0x01, 0x02, 0x03}, BLK0)) }
+		amltest.Pkg([]byte{0x14}, amltest.Concat(
+			[]byte{'W', 'B', 'L', 'K', 0x00, 0xa4, 0x70},
+			amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x05, 0x00, 0x02, 0x01, 0x02, 0x03}),
+			[]byte{'B', 'L', 'K', '0'},
+		)),
+	)
+
+	t.Run("without handler", func(t *testing.T) {
+		vm := vmForPayload(t, payload)
+
+		got, err := vm.Evaluate(`WRD0`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf, ok := got.([]byte)
+		if !ok || len(buf) != serialBusHeaderLen+smbusMaxDataLen || buf[0] != SerialBusStatusUnknownFailure {
+			t.Fatalf("expected a %d-byte buffer with an unknown failure status; got %v", serialBusHeaderLen+smbusMaxDataLen, got)
+		}
+
+		if got, err = vm.Evaluate(`WWRD`); err != nil {
+			t.Fatal(err)
+		}
+
+		if buf, ok = got.([]byte); !ok || buf[0] != SerialBusStatusUnknownFailure {
+			t.Fatalf("expected Store to return a buffer with an unknown failure status; got %v", got)
+		}
+	})
+
+	vm := vmForPayload(t, payload)
+	smbus := &mockSerialBusHandler{data: []byte{0xef, 0xbe}}
+	gsbus := &mockSerialBusHandler{status: SerialBusStatusTimeout, data: []byte{1, 2, 3}}
+	vm.RegisterSerialBusHandler(RegionSpaceSMBus, smbus)
+	vm.RegisterSerialBusHandler(RegionSpaceGenericSerialBus, gsbus)
+
+	t.Run("smbus read", func(t *testing.T) {
+		got, err := vm.Evaluate(`WRD0`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		exp := make([]byte, serialBusHeaderLen+smbusMaxDataLen)
+		exp[0], exp[1], exp[2], exp[3] = SerialBusStatusOK, 2, 0xef, 0xbe
+		if !reflect.DeepEqual(got, exp) {
+			t.Fatalf("expected to read %v; got %v", exp, got)
+		}
+
+		req := smbus.reqs[len(smbus.reqs)-1]
+		if req.Write || req.Address != 0x42 || req.Command != 0x10 || req.Protocol != SerialBusAttribWord {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+	})
+
+	t.Run("smbus write", func(t *testing.T) {
+		specs := []struct {
+			method   string
+			command  uint8
+			protocol uint8
+			expData  []byte
+		}{
+			{`WWRD`, 0x10, SerialBusAttribWord, []byte{0x34, 0x12}},
+			{`WBLK`, 0x11, SerialBusAttribBlock, []byte{0x01, 0x02}},
+		}
+
+		for specIndex, spec := range specs {
+			got, err := vm.Evaluate(spec.method)
+			if err != nil {
+				t.Errorf("[spec %d] %v", specIndex, err)
+				continue
+			}
+
+			if buf, ok := got.([]byte); !ok || buf[0] != SerialBusStatusOK {
+				t.Errorf("[spec %d] expected Store to return a buffer with an OK status; got %v", specIndex, got)
+				continue
+			}
+
+			req := smbus.reqs[len(smbus.reqs)-1]
+			if !req.Write || req.Command != spec.command || req.Protocol != spec.protocol || !reflect.DeepEqual(req.Data, spec.expData) {
+				t.Errorf("[spec %d] unexpected request: %+v", specIndex, req)
+			}
+		}
+	})
+
+	t.Run("generic serial bus read", func(t *testing.T) {
+		got, err := vm.Evaluate(`GSF0`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		buf := got.([]byte)
+		if len(buf) != serialBusHeaderLen+gsbusMaxDataLen || buf[0] != SerialBusStatusTimeout || buf[1] != 3 {
+			t.Fatalf("unexpected result buffer header: %v", buf[:serialBusHeaderLen])
+		}
+
+		req := gsbus.reqs[len(gsbus.reqs)-1]
+		if req.Protocol != SerialBusAttribBytes || req.AccessLength != 3 || !reflect.DeepEqual(req.Connection, []byte{0xaa, 0xbb, 0xcc}) {
+			t.Fatalf("unexpected request: %+v", req)
+		}
+	})
+
+	t.Run("unregister handler", func(t *testing.T) {
+		vm.RegisterSerialBusHandler(RegionSpaceSMBus, nil)
+		got, err := vm.Evaluate(`WRD0`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if buf := got.([]byte); buf[0] != SerialBusStatusUnknownFailure {
+			t.Fatalf("expected an unknown failure status; got 0x%x", buf[0])
+		}
+	})
+}
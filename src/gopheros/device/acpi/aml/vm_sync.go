@@ -0,0 +1,342 @@
+package aml
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/sync"
+)
+
+var (
+	errNotAMutex         = &kernel.Error{Module: "acpi_aml_vm", Message: "operand is not a Mutex object", Code: kernel.ErrCodeInvalidArgument}
+	errMutexSyncLevel    = &kernel.Error{Module: "acpi_aml_vm", Message: "cannot acquire mutex with a sync level lower than the current sync level", Code: kernel.ErrCodeInvalidArgument}
+	errMutexReleaseOrder = &kernel.Error{Module: "acpi_aml_vm", Message: "mutexes must be released in the reverse order of their sync levels", Code: kernel.ErrCodeInvalidArgument}
+	errMutexNotOwned     = &kernel.Error{Module: "acpi_aml_vm", Message: "cannot release mutex not owned by the current thread", Code: kernel.ErrCodeInvalidArgument}
+	errNotAnEvent        = &kernel.Error{Module: "acpi_aml_vm", Message: "operand is not an Event object", Code: kernel.ErrCodeInvalidArgument}
+	errMethodSyncLevel   = &kernel.Error{Module: "acpi_aml_vm", Message: "cannot invoke serialized method with a sync level lower than the current sync level", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// waitForever is the timeout value that causes Acquire and Wait to block
+// until the mutex or event becomes available.
+const waitForever = uint64(0xffff)
+
+// execThread holds the synchronization state that is shared by all contexts
+// created while servicing a single VM.Evaluate call.
+type execThread struct {
+	// The sync level of the most recently acquired mutex. A thread may
+	// only acquire mutexes whose sync level is greater or equal to it.
+	syncLevel uint8
+
+	// The mutexes currently owned by the thread in acquisition order.
+	mutexes []*amlMutex
+
+	// The number of opcodes executed by the thread. It is checked against
+	// the MaxOps execution limit.
+	opCount uint64
+
+	// The events for the opcodes that are currently being traced.
+	traceStack []*TraceEvent
+}
+
+// amlMutex holds the run-time state of an AML Mutex object.
+type amlMutex struct {
+	lock      sync.Mutex
+	syncLevel uint8
+
+	// The thread that currently owns the mutex and the number of times
+	// it has acquired the mutex.
+	owner        *execThread
+	acquireCount uint32
+
+	// The sync level of the owner thread before it acquired the mutex.
+	prevSyncLevel uint8
+}
+
+// SetSleepFunc registers the function that the VM invokes for suspending
+// execution for the specified number of milliseconds (e.g. while waiting for
+// an Acquire or Wait opcode to time out). By default, the VM does not sleep
+// and timeouts are measured as the number of attempts to acquire a mutex or
+// to consume an event signal.
+func (vm *VM) SetSleepFunc(fn func(milliseconds uint64)) {
+	vm.sleepFn = fn
+}
+
+// sleep suspends execution for the specified number of milliseconds using
+// the registered sleep function.
+func (vm *VM) sleep(milliseconds uint64) {
+	if vm.sleepFn != nil {
+		vm.sleepFn(milliseconds)
+	}
+}
+
+// vmOpAcquire attempts to acquire the mutex specified by the first arg of obj
+// waiting for up to the number of milliseconds specified by the second arg.
+// The opcode returns True if the wait timed out and False if the mutex was
+// successfully acquired. Threads that already own the mutex acquire it again
+// without blocking.
+func vmOpAcquire(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	mutex, err := vm.mutexArg(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	timeout, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	thread := ctx.thread
+	if mutex.owner == thread {
+		mutex.acquireCount++
+		ctx.retVal = vmFalse
+		return nil
+	}
+
+	if mutex.syncLevel < thread.syncLevel {
+		return vm.fail(obj, errMutexSyncLevel)
+	}
+
+	for !mutex.lock.TryToAcquire() {
+		if timeout == 0 {
+			ctx.retVal = vm.boolToInt(true)
+			return nil
+		}
+
+		if timeout != waitForever {
+			timeout--
+		}
+		vm.sleep(1)
+	}
+
+	acquireMutex(thread, mutex)
+	ctx.retVal = vmFalse
+	return nil
+}
+
+// vmOpRelease releases the mutex specified by the first arg of obj. Mutexes
+// that have been acquired multiple times by the same thread are only released
+// once the number of Release calls matches the number of Acquire calls.
+func vmOpRelease(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	mutex, err := vm.mutexArg(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	if mutex.owner != ctx.thread {
+		return vm.fail(obj, errMutexNotOwned)
+	}
+
+	if mutex.acquireCount > 1 {
+		mutex.acquireCount--
+		return nil
+	}
+
+	if mutex.syncLevel != ctx.thread.syncLevel {
+		return vm.fail(obj, errMutexReleaseOrder)
+	}
+
+	releaseMutex(ctx.thread, mutex)
+	return nil
+}
+
+// mutexArg evaluates the first arg of obj and returns the run-time state for
+// the Mutex object it refers to. The mutex state is lazily allocated the first
+// time that the Mutex is accessed.
+func (vm *VM) mutexArg(ctx *execContext, obj *Object) (*amlMutex, *kernel.Error) {
+	mutexObj, err := vm.syncObjectArg(ctx, obj, pOpMutex, errNotAMutex)
+	if err != nil {
+		return nil, err
+	}
+
+	if mutex, exists := vm.mutexes[mutexObj.index]; exists {
+		return mutex, nil
+	}
+
+	flagsObj := vm.tree.ArgAt(mutexObj, 1)
+	if flagsObj == nil {
+		return nil, vm.fail(mutexObj, errMalformedObject)
+	}
+
+	flags, _ := flagsObj.value.(uint64)
+	mutex := &amlMutex{syncLevel: uint8(flags & 0xf)}
+	vm.mutexes[mutexObj.index] = mutex
+	return mutex, nil
+}
+
+// acquireMutex records thread as the owner of a mutex whose lock has just been
+// acquired and raises the thread's sync level to the sync level of the mutex.
+func acquireMutex(thread *execThread, mutex *amlMutex) {
+	mutex.owner = thread
+	mutex.acquireCount = 1
+	mutex.prevSyncLevel = thread.syncLevel
+	thread.syncLevel = mutex.syncLevel
+	thread.mutexes = append(thread.mutexes, mutex)
+}
+
+// releaseMutex releases a mutex owned by thread and restores the thread's
+// sync level to the value it had before the mutex was acquired.
+func releaseMutex(thread *execThread, mutex *amlMutex) {
+	for i := len(thread.mutexes) - 1; i >= 0; i-- {
+		if thread.mutexes[i] == mutex {
+			thread.mutexes = append(thread.mutexes[:i], thread.mutexes[i+1:]...)
+			break
+		}
+	}
+
+	thread.syncLevel = mutex.prevSyncLevel
+	mutex.owner = nil
+	mutex.acquireCount = 0
+	mutex.lock.Release()
+}
+
+// releaseAllMutexes releases any mutexes that are still owned by thread in
+// the reverse order of their acquisition. It is invoked when a thread
+// finishes executing so that misbehaving methods that return while holding
+// a mutex do not cause subsequent Acquire calls to block.
+func releaseAllMutexes(thread *execThread) {
+	for len(thread.mutexes) != 0 {
+		releaseMutex(thread, thread.mutexes[len(thread.mutexes)-1])
+	}
+}
+
+// enterSerializedMethod acquires the implicit mutex of a method declared with
+// the Serialized flag, blocking until any other thread executing the method
+// returns. The mutex uses the sync level specified in the method flags and is
+// lazily allocated the first time that the method is invoked. Threads that
+// already own the mutex (e.g. due to a recursive method call) acquire it again
+// without blocking.
+func (vm *VM) enterSerializedMethod(thread *execThread, method *Object, syncLevel uint8) (*amlMutex, *kernel.Error) {
+	mutex, exists := vm.mutexes[method.index]
+	if !exists {
+		mutex = &amlMutex{syncLevel: syncLevel}
+		vm.mutexes[method.index] = mutex
+	}
+
+	if mutex.owner == thread {
+		mutex.acquireCount++
+		return mutex, nil
+	}
+
+	if mutex.syncLevel < thread.syncLevel {
+		return nil, vm.fail(method, errMethodSyncLevel)
+	}
+
+	for !mutex.lock.TryToAcquire() {
+		vm.sleep(1)
+	}
+
+	acquireMutex(thread, mutex)
+	return mutex, nil
+}
+
+// exitSerializedMethod releases the implicit mutex acquired by
+// enterSerializedMethod. Any mutexes that the method acquired but did not
+// release before returning are released first so that the thread's sync level
+// is restored to the value it had before the method was invoked.
+func exitSerializedMethod(thread *execThread, mutex *amlMutex) {
+	if mutex.acquireCount > 1 {
+		mutex.acquireCount--
+		return
+	}
+
+	for len(thread.mutexes) != 0 {
+		last := thread.mutexes[len(thread.mutexes)-1]
+		releaseMutex(thread, last)
+		if last == mutex {
+			return
+		}
+	}
+}
+
+// vmOpWait waits for the event specified by the first arg of obj to be
+// signaled for up to the number of milliseconds specified by the second arg.
+// The opcode returns True if the wait timed out and False if the event was
+// signaled.
+func vmOpWait(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	event, err := vm.eventArg(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	timeout, err := vm.evalIntArg(ctx, obj, 1)
+	if err != nil {
+		return err
+	}
+
+	for !event.TryToAcquire() {
+		if timeout == 0 {
+			ctx.retVal = vm.boolToInt(true)
+			return nil
+		}
+
+		if timeout != waitForever {
+			timeout--
+		}
+		vm.sleep(1)
+	}
+
+	ctx.retVal = vmFalse
+	return nil
+}
+
+// vmOpSignal signals the event specified by the first arg of obj, allowing a
+// single pending or future Wait on the event to complete.
+func vmOpSignal(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	event, err := vm.eventArg(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	event.Release()
+	return nil
+}
+
+// vmOpReset discards any pending signals for the event specified by the first
+// arg of obj.
+func vmOpReset(vm *VM, ctx *execContext, obj *Object) *kernel.Error {
+	event, err := vm.eventArg(ctx, obj)
+	if err != nil {
+		return err
+	}
+
+	for event.TryToAcquire() {
+	}
+	return nil
+}
+
+// eventArg evaluates the first arg of obj and returns the Semaphore that
+// tracks the pending signals for the Event object it refers to. The semaphore
+// is lazily allocated the first time that the Event is accessed.
+func (vm *VM) eventArg(ctx *execContext, obj *Object) (*sync.Semaphore, *kernel.Error) {
+	eventObj, err := vm.syncObjectArg(ctx, obj, pOpEvent, errNotAnEvent)
+	if err != nil {
+		return nil, err
+	}
+
+	event, exists := vm.events[eventObj.index]
+	if !exists {
+		event = sync.NewSemaphore(0)
+		vm.events[eventObj.index] = event
+	}
+
+	return event, nil
+}
+
+// syncObjectArg evaluates the first arg of obj and returns the named object
+// it refers to, either directly or via a reference. If the object opcode does
+// not match expOpcode, syncObjectArg returns typeErr.
+func (vm *VM) syncObjectArg(ctx *execContext, obj *Object, expOpcode uint16, typeErr *kernel.Error) (*Object, *kernel.Error) {
+	val, err := vm.evalArg(ctx, obj, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if ref, isRef := val.(*Reference); isRef && ref.Target != nil {
+		val = ref.Target
+	}
+
+	target, ok := val.(*Object)
+	if !ok || target.opcode != expOpcode {
+		return nil, vm.fail(obj, typeErr)
+	}
+
+	return target, nil
+}
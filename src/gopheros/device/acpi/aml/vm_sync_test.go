@@ -0,0 +1,339 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestVMMutexes(t *testing.T) {
+	var (
+		acquireMTX1 = []byte{0x5b, 0x23, 'M', 'T', 'X', '1', 0xff, 0xff}
+		acquireMTX5 = []byte{0x5b, 0x23, 'M', 'T', 'X', '5', 0xff, 0xff}
+		releaseMTX1 = []byte{0x5b, 0x27, 'M', 'T', 'X', '1'}
+		releaseMTX5 = []byte{0x5b, 0x27, 'M', 'T', 'X', '5'}
+	)
+
+	specs := []struct {
+		body   []byte
+		expVal interface{}
+		expErr *kernel.Error
+	}{
+		// Recursive acquisition
+		{
+			amltest.Concat(acquireMTX1, acquireMTX1, releaseMTX1, releaseMTX1, releaseMTX1),
+			nil,
+			errMutexNotOwned,
+		},
+		// Acquire mutexes in increasing sync level order and release them
+		// in reverse order
+		{
+			amltest.Concat(acquireMTX1, acquireMTX5, releaseMTX5, releaseMTX1, []byte{0xa4}, acquireMTX5),
+			vmFalse,
+			nil,
+		},
+		// Acquire via a reference stored in a local
+		{
+			amltest.Concat(
+				// Store(RefOf(MTX1), Local0)
+				[]byte{0x70, 0x71, 'M', 'T', 'X', '1', 0x60},
+				// Return(Acquire(Local0, 0))
+				[]byte{0xa4, 0x5b, 0x23, 0x60, 0x00, 0x00},
+			),
+			vmFalse,
+			nil,
+		},
+		// Acquire mutex with a lower sync level than the current one
+		{
+			amltest.Concat(acquireMTX5, acquireMTX1),
+			nil,
+			errMutexSyncLevel,
+		},
+		// Release mutexes out of order
+		{
+			amltest.Concat(acquireMTX1, acquireMTX5, releaseMTX1),
+			nil,
+			errMutexReleaseOrder,
+		},
+		// Release mutex that is not held
+		{
+			releaseMTX1,
+			nil,
+			errMutexNotOwned,
+		},
+		// Acquire a non-mutex object
+		{
+			[]byte{0x5b, 0x23, 'I', 'N', 'T', '0', 0xff, 0xff},
+			nil,
+			errNotAMutex,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, mutexTestPayload(spec.body))
+
+		got, err := vm.Evaluate(`TEST`)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if got != spec.expVal {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.expVal, got)
+		}
+
+		// Any mutexes still held when Evaluate returns must be released
+		for index, mutex := range vm.mutexes {
+			if mutex.owner != nil || !mutex.lock.TryToAcquire() {
+				t.Errorf("[spec %d] expected mutex at index %d to be released", specIndex, index)
+			}
+		}
+	}
+}
+
+func TestVMMutexTimeout(t *testing.T) {
+	// Return(Acquire(MTX1, 5))
+	vm := vmForPayload(t, mutexTestPayload([]byte{0xa4, 0x5b, 0x23, 'M', 'T', 'X', '1', 0x05, 0x00}))
+
+	var sleepCount int
+	vm.SetSleepFunc(func(milliseconds uint64) {
+		if milliseconds != 1 {
+			t.Errorf("expected sleep duration to be 1ms; got %d", milliseconds)
+		}
+		sleepCount++
+	})
+
+	// Acquire the mutex from another thread
+	mutexObj := vm.tree.ObjectAt(vm.tree.Find(0, []byte("MTX1")))
+	mutex := &amlMutex{syncLevel: 1, owner: &execThread{}}
+	mutex.lock.Acquire()
+	vm.mutexes[mutexObj.index] = mutex
+
+	got, err := vm.Evaluate(`TEST`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got != vmTrue {
+		t.Fatalf("expected Acquire to time out; got %#v", got)
+	}
+
+	if sleepCount != 5 {
+		t.Fatalf("expected the VM to sleep 5 times; got %d", sleepCount)
+	}
+}
+
+func TestVMSerializedMethods(t *testing.T) {
+	specs := []struct {
+		body   []byte
+		expVal interface{}
+		expErr *kernel.Error
+	}{
+		// The mutex acquired by SER1 is released when it returns and the
+		// sync level is restored
+		{
+			[]byte{'S', 'E', 'R', '1', 0xa4, 0x5b, 0x23, 'M', 'T', 'X', '1', 0x00, 0x00},
+			vmFalse,
+			nil,
+		},
+		// Invoke a serialized method with a lower sync level from a
+		// serialized method
+		{
+			[]byte{'S', 'E', 'R', '5'},
+			nil,
+			errMethodSyncLevel,
+		},
+		// Invoke a serialized method while holding a mutex with a higher
+		// sync level
+		{
+			[]byte{0x5b, 0x23, 'M', 'T', 'X', '5', 0xff, 0xff, 'S', 'E', 'R', '1'},
+			nil,
+			errMethodSyncLevel,
+		},
+		// Recursive invocation of a serialized method
+		{
+			[]byte{0xa4, 'S', 'E', 'R', 'R', 0x01},
+			uint64(0x2a),
+			nil,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, serializedTestPayload(spec.body))
+
+		got, err := vm.Evaluate(`TEST`)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if got != spec.expVal {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.expVal, got)
+		}
+
+		for index, mutex := range vm.mutexes {
+			if mutex.owner != nil || !mutex.lock.TryToAcquire() {
+				t.Errorf("[spec %d] expected mutex at index %d to be released", specIndex, index)
+			}
+		}
+	}
+
+	t.Run("wait for other thread", func(t *testing.T) {
+		vm := vmForPayload(t, serializedTestPayload(nil))
+
+		// Execute SER1 from another thread
+		methodObj := vm.tree.ObjectAt(vm.tree.Find(0, []byte("SER1")))
+		mutex := &amlMutex{syncLevel: 1, owner: &execThread{}}
+		mutex.lock.Acquire()
+		vm.mutexes[methodObj.index] = mutex
+
+		var sleepCount int
+		vm.SetSleepFunc(func(_ uint64) {
+			if sleepCount++; sleepCount == 3 {
+				mutex.owner = nil
+				mutex.lock.Release()
+			}
+		})
+
+		got, err := vm.Evaluate(`SER1`)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != vmFalse {
+			t.Fatalf("expected to get %#v; got %#v", vmFalse, got)
+		}
+
+		if sleepCount != 3 {
+			t.Fatalf("expected the VM to sleep 3 times; got %d", sleepCount)
+		}
+	})
+}
+
+func TestVMEvents(t *testing.T) {
+	var (
+		signalEVT0 = []byte{0x5b, 0x24, 'E', 'V', 'T', '0'}
+		resetEVT0  = []byte{0x5b, 0x26, 'E', 'V', 'T', '0'}
+		// Wait(EVT0, 3)
+		waitEVT0 = []byte{0x5b, 0x25, 'E', 'V', 'T', '0', 0x0a, 0x03}
+	)
+
+	specs := []struct {
+		body          []byte
+		expVal        interface{}
+		expErr        *kernel.Error
+		expSleepCount int
+	}{
+		// Wait on an event that is never signaled
+		{
+			amltest.Concat([]byte{0xa4}, waitEVT0),
+			vmTrue,
+			nil,
+			3,
+		},
+		// Wait on a signaled event
+		{
+			amltest.Concat(signalEVT0, []byte{0xa4}, waitEVT0),
+			vmFalse,
+			nil,
+			0,
+		},
+		// Each signal satisfies a single Wait
+		{
+			amltest.Concat(signalEVT0, waitEVT0, []byte{0xa4}, waitEVT0),
+			vmTrue,
+			nil,
+			3,
+		},
+		// Reset discards all pending signals
+		{
+			amltest.Concat(signalEVT0, signalEVT0, resetEVT0, []byte{0xa4}, waitEVT0),
+			vmTrue,
+			nil,
+			3,
+		},
+		// Wait via a reference stored in a local
+		{
+			amltest.Concat(
+				signalEVT0,
+				// Store(RefOf(EVT0), Local0)
+				[]byte{0x70, 0x71, 'E', 'V', 'T', '0', 0x60},
+				// Return(Wait(Local0, 0))
+				[]byte{0xa4, 0x5b, 0x25, 0x60, 0x00},
+			),
+			vmFalse,
+			nil,
+			0,
+		},
+		// Signal a non-event object
+		{
+			[]byte{0x5b, 0x24, 'M', 'T', 'X', '1'},
+			nil,
+			errNotAnEvent,
+			0,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, mutexTestPayload(spec.body))
+
+		var sleepCount int
+		vm.SetSleepFunc(func(_ uint64) { sleepCount++ })
+
+		got, err := vm.Evaluate(`TEST`)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if got != spec.expVal {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.expVal, got)
+		}
+
+		if sleepCount != spec.expSleepCount {
+			t.Errorf("[spec %d] expected the VM to sleep %d times; got %d", specIndex, spec.expSleepCount, sleepCount)
+		}
+	}
+}
+
+// mutexTestPayload returns an AML payload that defines two mutexes with sync
+// levels 1 and 5 (MTX1 and MTX5), an Event called EVT0, an integer Name called
+// INT0 and a method called TEST with the supplied body.
+func mutexTestPayload(body []byte) []byte {
+	return amltest.Concat(
+		// Mutex(MTX1, 1)
+		[]byte{0x5b, 0x01, 'M', 'T', 'X', '1', 0x01},
+		// Mutex(MTX5, 5)
+		[]byte{0x5b, 0x01, 'M', 'T', 'X', '5', 0x05},
+		// Event(EVT0)
+		[]byte{0x5b, 0x02, 'E', 'V', 'T', '0'},
+		// Name(INT0, 1)
+		[]byte{0x08, 'I', 'N', 'T', '0', 0x01},
+		// Method(TEST, 0) { body }
+		amltest.Pkg([]byte{0x14}, amltest.Concat([]byte{'T', 'E', 'S', 'T', 0x00}, body)),
+	)
+}
+
+// serializedTestPayload extends the payload returned by mutexTestPayload with
+// the following serialized methods:
+//   - SER1 (sync level 1) acquires MTX5 without releasing it.
+//   - SER5 (sync level 5) invokes SER1.
+//   - SERR (sync level 0) invokes itself once if Arg0 is non-zero.
+func serializedTestPayload(body []byte) []byte {
+	return amltest.Concat(
+		mutexTestPayload(body),
+		// Method(SER1, 0, Serialized, 1) { Return(Acquire(MTX5, 0)) }
+		amltest.Pkg([]byte{0x14}, []byte{'S', 'E', 'R', '1', 0x18, 0xa4, 0x5b, 0x23, 'M', 'T', 'X', '5', 0x00, 0x00}),
+		// Method(SER5, 0, Serialized, 5) { SER1() }
+		amltest.Pkg([]byte{0x14}, []byte{'S', 'E', 'R', '5', 0x58, 'S', 'E', 'R', '1'}),
+		// Method(SERR, 1, Serialized) {
+		//   If (Arg0) { Return(SERR(0)) }
+		//   Return(0x2a)
+		// }
+		amltest.Pkg([]byte{0x14}, amltest.Concat(
+			[]byte{'S', 'E', 'R', 'R', 0x09},
+			amltest.Pkg([]byte{0xa0}, []byte{0x68, 0xa4, 'S', 'E', 'R', 'R', 0x00}),
+			[]byte{0xa4, 0x0a, 0x2a},
+		)),
+	)
+}
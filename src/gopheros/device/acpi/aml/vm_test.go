@@ -0,0 +1,806 @@
+package aml
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/convert"
+	"gopheros/kernel"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestVMEvaluate(t *testing.T) {
+	specs := []struct {
+		argCount uint8
+		body     []byte
+		args     []interface{}
+		exp      interface{}
+	}{
+		// Return(Add(Arg0, Arg1))
+		{
+			2,
+			[]byte{0xa4, 0x72, 0x68, 0x69, 0x00},
+			[]interface{}{2, 3},
+			uint64(5),
+		},
+		// Store(Subtract(Arg0, Arg1), Local0); Return(Local0)
+		{
+			2,
+			[]byte{0x70, 0x74, 0x68, 0x69, 0x00, 0x60, 0xa4, 0x60},
+			[]interface{}{10, 3},
+			uint64(7),
+		},
+		// Return(Or(ShiftLeft(Arg0, 4), Arg1))
+		{
+			2,
+			[]byte{0xa4, 0x7d, 0x79, 0x68, 0x0a, 0x04, 0x00, 0x69, 0x00},
+			[]interface{}{1, 2},
+			uint64(0x12),
+		},
+		// Divide(Arg0, Arg1, Local1, Local0); Return(Local0 * 100 + Local1)
+		{
+			2,
+			[]byte{
+				0x78, 0x68, 0x69, 0x61, 0x60,
+				0xa4, 0x72, 0x77, 0x60, 0x0a, 0x64, 0x00, 0x61, 0x00,
+			},
+			[]interface{}{17, 5},
+			uint64(302),
+		},
+		// Return(And(Not(Arg0), 0xff))
+		{
+			1,
+			[]byte{0xa4, 0x7b, 0x80, 0x68, 0x00, 0x0a, 0xff, 0x00},
+			[]interface{}{0x0f},
+			uint64(0xf0),
+		},
+		// Return(FindSetLeftBit(Arg0) + FindSetRightBit(Arg1))
+		{
+			2,
+			[]byte{0xa4, 0x72, 0x81, 0x68, 0x00, 0x82, 0x69, 0x00, 0x00},
+			[]interface{}{0x80, 0x6},
+			uint64(10),
+		},
+		// If (LGreater(Arg0, Arg1)) { Return(One) } Else { Return(Zero) }
+		{
+			2,
+			amltest.Concat(
+				amltest.Pkg([]byte{0xa0}, []byte{0x94, 0x68, 0x69, 0xa4, 0x01}),
+				amltest.Pkg([]byte{0xa1}, []byte{0xa4, 0x00}),
+			),
+			[]interface{}{3, 2},
+			uint64(1),
+		},
+		{
+			2,
+			amltest.Concat(
+				amltest.Pkg([]byte{0xa0}, []byte{0x94, 0x68, 0x69, 0xa4, 0x01}),
+				amltest.Pkg([]byte{0xa1}, []byte{0xa4, 0x00}),
+			),
+			[]interface{}{2, 3},
+			uint64(0),
+		},
+		// Local0 = 0; Local1 = 0
+		// While (Local0 < Arg0) {
+		//   Local0++
+		//   If (Local0 == 3) { Continue }
+		//   If (Local0 > 5) { Break }
+		//   Local1 += Local0
+		// }
+		// Return(Local1)
+		{
+			1,
+			amltest.Concat(
+				[]byte{0x70, 0x00, 0x60, 0x70, 0x00, 0x61},
+				amltest.Pkg([]byte{0xa2}, amltest.Concat(
+					[]byte{0x95, 0x60, 0x68, 0x75, 0x60},
+					amltest.Pkg([]byte{0xa0}, []byte{0x93, 0x60, 0x0a, 0x03, 0x9f}),
+					amltest.Pkg([]byte{0xa0}, []byte{0x94, 0x60, 0x0a, 0x05, 0xa5}),
+					[]byte{0x72, 0x61, 0x60, 0x61},
+				)),
+				[]byte{0xa4, 0x61},
+			),
+			[]interface{}{10},
+			uint64(12),
+		},
+		// Return from within a While block
+		{
+			0,
+			amltest.Concat(
+				amltest.Pkg([]byte{0xa2}, []byte{0x01, 0xa4, 0x0a, 0x2a}),
+			),
+			nil,
+			uint64(42),
+		},
+		// Return(Concatenate("ab", Arg0))
+		{
+			1,
+			[]byte{0xa4, 0x73, 0x0d, 'a', 'b', 0x00, 0x68, 0x00},
+			[]interface{}{"cd"},
+			"abcd",
+		},
+		// Return(Concatenate(1, Arg0))
+		{
+			1,
+			[]byte{0xa4, 0x73, 0x01, 0x68, 0x00},
+			[]interface{}{2},
+			[]byte{1, 0, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0},
+		},
+		// Return(LEqual(Arg0, "foo"))
+		{
+			1,
+			[]byte{0xa4, 0x93, 0x68, 0x0d, 'f', 'o', 'o', 0x00},
+			[]interface{}{"foo"},
+			vmTrue,
+		},
+		// Return(LAnd(Arg0, LNot(Arg1)))
+		{
+			2,
+			[]byte{0xa4, 0x90, 0x68, 0x92, 0x69},
+			[]interface{}{true, false},
+			vmTrue,
+		},
+		// Return(Add(INT0, Arg0, INT0))
+		{
+			1,
+			[]byte{0xa4, 0x72, 'I', 'N', 'T', '0', 0x68, 'I', 'N', 'T', '0'},
+			[]interface{}{1},
+			uint64(0x2b),
+		},
+		// Store(Arg0, CNV0); Return(CNV0) (implicit String to Integer conversion)
+		{
+			1,
+			[]byte{0x70, 0x68, 'C', 'N', 'V', '0', 0xa4, 'C', 'N', 'V', '0'},
+			[]interface{}{"1F"},
+			uint64(0x1f),
+		},
+		// Return(DBL_(Arg0))
+		{
+			1,
+			[]byte{0xa4, 'D', 'B', 'L', '_', 0x68},
+			[]interface{}{21},
+			uint64(42),
+		},
+		// Store(0x2a, STR0); Return(STR0)
+		{
+			0,
+			[]byte{0x70, 0x0a, 0x2a, 'S', 'T', 'R', '0', 0xa4, 'S', 'T', 'R', '0'},
+			nil,
+			"000000000000002A",
+		},
+		// Return(LEqual(INT0, "2a"))
+		{
+			0,
+			[]byte{0xa4, 0x93, 'I', 'N', 'T', '0', 0x0d, '2', 'a', 0x00},
+			nil,
+			vmTrue,
+		},
+		// Store(Buffer(3){1, 2, 3}, Local0)
+		// Return(SizeOf(Local0) + DerefOf(Index(Local0, 2)))
+		{
+			0,
+			amltest.Concat(
+				[]byte{0x70},
+				amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x03, 0x01, 0x02, 0x03}),
+				[]byte{0x60},
+				[]byte{0xa4, 0x72, 0x87, 0x60, 0x83, 0x88, 0x60, 0x0a, 0x02, 0x00, 0x00},
+			),
+			nil,
+			uint64(6),
+		},
+		// Return(Buffer(4){1, 2})
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4},
+				amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x04, 0x01, 0x02}),
+			),
+			nil,
+			[]byte{1, 2, 0, 0},
+		},
+		// Return(DerefOf(Index(Package(){1, "two", 3}, 1)))
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4, 0x83, 0x88},
+				amltest.Pkg([]byte{0x12}, []byte{0x03, 0x01, 0x0d, 't', 'w', 'o', 0x00, 0x0a, 0x03}),
+				[]byte{0x0a, 0x01, 0x00},
+			),
+			nil,
+			"two",
+		},
+		// Return(Package(3){One, INT0})
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4},
+				amltest.Pkg([]byte{0x12}, []byte{0x03, 0x01, 'I', 'N', 'T', '0'}),
+			),
+			nil,
+			[]interface{}{uint64(1), "INT0", nil},
+		},
+		// Return(ConcatenateResTemplate(
+		//   ResourceTemplate() { IRQNoFlags() {1} },
+		//   ResourceTemplate() { IO(Decode16, 0x60, 0x60, 0x01, 0x01) },
+		//   Local0))
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4, 0x84},
+				amltest.Buffer(0x22, 0x02, 0x00, 0x79, 0x00),
+				amltest.Buffer(0x47, 0x01, 0x60, 0x00, 0x60, 0x00, 0x01, 0x01, 0x79, 0x00),
+				[]byte{0x60},
+			),
+			nil,
+			[]byte{0x22, 0x02, 0x00, 0x47, 0x01, 0x60, 0x00, 0x60, 0x00, 0x01, 0x01, 0x79, 0x00},
+		},
+		// Return(ConcatenateResTemplate(Buffer(0) {}, ResourceTemplate() { IRQNoFlags() {1} }, Zero))
+		{
+			0,
+			amltest.Concat([]byte{0xa4, 0x84}, amltest.Pkg([]byte{0x11}, []byte{0x00}), amltest.Buffer(0x22, 0x02, 0x00, 0x79, 0xde), []byte{0x00}),
+			nil,
+			[]byte{0x22, 0x02, 0x00, 0x79, 0x00},
+		},
+		// Return(Match(Package() {0x10, 0x20, 0x30}, MGE, Arg0, MTR, 0, 0))
+		{
+			1,
+			amltest.Concat(
+				[]byte{0xa4, 0x89},
+				amltest.Pkg([]byte{0x12}, []byte{0x03, 0x0a, 0x10, 0x0a, 0x20, 0x0a, 0x30}),
+				[]byte{0x04, 0x68, 0x00, 0x00, 0x00},
+			),
+			[]interface{}{0x18},
+			uint64(1),
+		},
+		// Return(Match(Package() {0x10, 0x20, 0x30}, MGE, Arg0, MTR, 0, 0))
+		{
+			1,
+			amltest.Concat(
+				[]byte{0xa4, 0x89},
+				amltest.Pkg([]byte{0x12}, []byte{0x03, 0x0a, 0x10, 0x0a, 0x20, 0x0a, 0x30}),
+				[]byte{0x04, 0x68, 0x00, 0x00, 0x00},
+			),
+			[]interface{}{0x40},
+			vmTrue,
+		},
+		// Return(Match(Package() {"PNP0A03", "PNP0A08"}, MEQ, "PNP0A08", MTR, 0, 0))
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4, 0x89},
+				amltest.Pkg([]byte{0x12}, []byte{
+					0x02,
+					0x0d, 'P', 'N', 'P', '0', 'A', '0', '3', 0x00,
+					0x0d, 'P', 'N', 'P', '0', 'A', '0', '8', 0x00,
+				}),
+				[]byte{0x01, 0x0d, 'P', 'N', 'P', '0', 'A', '0', '8', 0x00, 0x00, 0x00, 0x00},
+			),
+			nil,
+			uint64(1),
+		},
+		// Return(Match(Package() {1, "2", 3, 4}, MLT, 4, MGT, 1, 1))
+		// The String element is compared after converting the match
+		// objects to Strings.
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4, 0x89},
+				amltest.Pkg([]byte{0x12}, []byte{0x04, 0x01, 0x0d, '2', 0x00, 0x0a, 0x03, 0x0a, 0x04}),
+				[]byte{0x03, 0x0a, 0x04, 0x05, 0x01, 0x01},
+			),
+			nil,
+			uint64(2),
+		},
+		// Return(Match(Package() {1, Buffer() {1, 2}}, MEQ, Buffer() {1, 2}, MTR, 0, 0))
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4, 0x89},
+				amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x02, 0x01}, amltest.Buffer(0x01, 0x02))),
+				[]byte{0x01},
+				amltest.Buffer(0x01, 0x02),
+				[]byte{0x00, 0x00, 0x00},
+			),
+			nil,
+			uint64(1),
+		},
+		// Return(Mid("PNP0A03", 3, 4, Local0))
+		{
+			0,
+			[]byte{0xa4, 0x9e, 0x0d, 'P', 'N', 'P', '0', 'A', '0', '3', 0x00, 0x0a, 0x03, 0x0a, 0x04, 0x60},
+			nil,
+			"0A03",
+		},
+		// Return(Mid(Buffer() {1, 2, 3, 4}, 2, 10, Zero))
+		{
+			0,
+			amltest.Concat([]byte{0xa4, 0x9e}, amltest.Buffer(0x01, 0x02, 0x03, 0x04), []byte{0x0a, 0x02, 0x0a, 0x0a, 0x00}),
+			nil,
+			[]byte{0x03, 0x04},
+		},
+		// Return(Mid("abc", 5, 1, Zero))
+		{
+			0,
+			[]byte{0xa4, 0x9e, 0x0d, 'a', 'b', 'c', 0x00, 0x0a, 0x05, 0x01, 0x00},
+			nil,
+			"",
+		},
+		// Return(Mid(0x04030201, 1, 2, Zero))
+		{
+			0,
+			[]byte{0xa4, 0x9e, 0x0c, 0x01, 0x02, 0x03, 0x04, 0x01, 0x0a, 0x02, 0x00},
+			nil,
+			[]byte{0x02, 0x03},
+		},
+		// Store("123", INT0); Return(INT0)
+		{
+			0,
+			[]byte{0x70, 0x0d, '1', '2', '3', 0x00, 'I', 'N', 'T', '0', 0xa4, 'I', 'N', 'T', '0'},
+			nil,
+			uint64(0x123),
+		},
+		// CopyObject("123", INT0); Return(INT0)
+		{
+			0,
+			[]byte{0x9d, 0x0d, '1', '2', '3', 0x00, 'I', 'N', 'T', '0', 0xa4, 'I', 'N', 'T', '0'},
+			nil,
+			"123",
+		},
+		// Store(0x10, RefOf(INT0)); Return(INT0)
+		{
+			0,
+			[]byte{0x70, 0x0a, 0x10, 0x71, 'I', 'N', 'T', '0', 0xa4, 'I', 'N', 'T', '0'},
+			nil,
+			uint64(0x10),
+		},
+		// Store(0x41, DerefOf(RefOf(STR0))); Return(STR0)
+		{
+			0,
+			[]byte{0x70, 0x0a, 0x41, 0x83, 0x71, 'S', 'T', 'R', '0', 0xa4, 'S', 'T', 'R', '0'},
+			nil,
+			"0000000000000041",
+		},
+		// Store(RefOf(INT0), Local0); Store(One, Local0); Return(Add(Local0, INT0))
+		{
+			0,
+			[]byte{0x70, 0x71, 'I', 'N', 'T', '0', 0x60, 0x70, 0x01, 0x60, 0xa4, 0x72, 0x60, 'I', 'N', 'T', '0', 0x00},
+			nil,
+			uint64(0x2b),
+		},
+		// Store(Package(2) { One, One }, Local0)
+		// Store(5, Index(Local0, One))
+		// Return(Local0)
+		{
+			0,
+			amltest.Concat(
+				[]byte{0x70}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x01, 0x01}), []byte{0x60},
+				[]byte{0x70, 0x0a, 0x05, 0x88, 0x60, 0x01, 0x00},
+				[]byte{0xa4, 0x60},
+			),
+			nil,
+			[]interface{}{uint64(1), uint64(5)},
+		},
+		// Store(Buffer() { 1, 2, 3 }, Local0)
+		// Store("AB", Index(Local0, 2))
+		// Store(0x1ff, Index(Local0, 0))
+		// Return(Local0)
+		{
+			0,
+			amltest.Concat(
+				[]byte{0x70}, amltest.Buffer(0x01, 0x02, 0x03), []byte{0x60},
+				[]byte{0x70, 0x0d, 'A', 'B', 0x00, 0x88, 0x60, 0x0a, 0x02, 0x00},
+				[]byte{0x70, 0x0b, 0xff, 0x01, 0x88, 0x60, 0x00, 0x00},
+				[]byte{0xa4, 0x60},
+			),
+			nil,
+			[]byte{0xff, 0x02, 'A'},
+		},
+		// Method without a Return; the value of the last expression is
+		// implicitly returned
+		{
+			0,
+			[]byte{0x70, 0x01, 0x60},
+			nil,
+			uint64(1),
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForTestMethod(t, spec.argCount, spec.body)
+
+		got, err := vm.Evaluate(`\TEST`, spec.args...)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		// Named references are returned as *Object values; compare them
+		// using their names.
+		if pkg, ok := got.([]interface{}); ok {
+			for i, elem := range pkg {
+				if obj, isObj := elem.(*Object); isObj {
+					pkg[i] = string(obj.Name())
+				}
+			}
+		}
+
+		if !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestVMStoreToArgReference(t *testing.T) {
+	payload := amltest.Concat(
+		// Name(INT0, 0x2a)
+		[]byte{0x08, 'I', 'N', 'T', '0', 0x0a, 0x2a},
+		// Method(SETV, 1) { Store("ff", Arg0) }
+		amltest.Pkg([]byte{0x14}, []byte{'S', 'E', 'T', 'V', 0x01, 0x70, 0x0d, 'f', 'f', 0x00, 0x68}),
+		// Method(CPYV, 1) { CopyObject("ff", Arg0) }
+		amltest.Pkg([]byte{0x14}, []byte{'C', 'P', 'Y', 'V', 0x01, 0x9d, 0x0d, 'f', 'f', 0x00, 0x68}),
+		// Method(TST0, 0) { SETV(RefOf(INT0)); Return(INT0) }
+		amltest.Pkg([]byte{0x14}, []byte{'T', 'S', 'T', '0', 0x00, 'S', 'E', 'T', 'V', 0x71, 'I', 'N', 'T', '0', 0xa4, 'I', 'N', 'T', '0'}),
+		// Method(TST1, 0) { CPYV(RefOf(INT0)); Return(INT0) }
+		amltest.Pkg([]byte{0x14}, []byte{'T', 'S', 'T', '1', 0x00, 'C', 'P', 'Y', 'V', 0x71, 'I', 'N', 'T', '0', 0xa4, 'I', 'N', 'T', '0'}),
+		// Method(TST2, 0) { SETV(INT0); Return(INT0) }
+		amltest.Pkg([]byte{0x14}, []byte{'T', 'S', 'T', '2', 0x00, 'S', 'E', 'T', 'V', 'I', 'N', 'T', '0', 0xa4, 'I', 'N', 'T', '0'}),
+	)
+
+	specs := []struct {
+		method string
+		exp    interface{}
+	}{
+		// Stores through a reference arg are converted to the type of
+		// the referenced object
+		{`\TST0`, uint64(0xff)},
+		// CopyObject through a reference arg replaces the referenced
+		// object's type
+		{`\TST1`, "ff"},
+		// Stores to args passed by value do not affect the caller
+		{`\TST2`, uint64(0x2a)},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForPayload(t, payload)
+
+		got, err := vm.Evaluate(spec.method)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestVMImplicitReturn(t *testing.T) {
+	specs := []struct {
+		body        []byte
+		implicitRet bool
+		exp         interface{}
+	}{
+		// Store(0x2a, Local0)
+		{[]byte{0x70, 0x0a, 0x2a, 0x60}, true, uint64(0x2a)},
+		{[]byte{0x70, 0x0a, 0x2a, 0x60}, false, nil},
+		// Store(1, Local0); If (One) { Add(Local0, 2, Local1) }
+		{
+			amltest.Concat(
+				[]byte{0x70, 0x01, 0x60},
+				amltest.Pkg([]byte{0xa0}, []byte{0x01, 0x72, 0x60, 0x0a, 0x02, 0x61}),
+			),
+			true,
+			uint64(3),
+		},
+		// Explicit returns are not affected by the quirk
+		{[]byte{0x70, 0x0a, 0x2a, 0x60, 0xa4, 0x01}, true, uint64(1)},
+		{[]byte{0x70, 0x0a, 0x2a, 0x60, 0xa4, 0x01}, false, uint64(1)},
+		// Method with an empty body
+		{nil, true, nil},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForTestMethod(t, 0, spec.body)
+		vm.SetImplicitReturn(spec.implicitRet)
+
+		got, err := vm.Evaluate(`\TEST`)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got != spec.exp {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestVMEvaluateNamedObjects(t *testing.T) {
+	// Return(Add(INT0, Arg0, INT0))
+	vm := vmForTestMethod(t, 1, []byte{0xa4, 0x72, 'I', 'N', 'T', '0', 0x68, 'I', 'N', 'T', '0'})
+
+	specs := []struct {
+		path string
+		exp  interface{}
+	}{
+		{`\INT0`, uint64(0x2a)},
+		{`INT0`, uint64(0x2a)},
+		{`\STR0`, "foo"},
+		{`\_SB`, vm.tree.ObjectAt(vm.tree.Find(0, []byte(`\_SB_`)))},
+	}
+
+	for specIndex, spec := range specs {
+		got, err := vm.Evaluate(spec.path)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected to get %#v; got %#v", specIndex, spec.exp, got)
+		}
+	}
+
+	// Running the method should update the value of INT0
+	if _, err := vm.Evaluate(`\TEST`, 8); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := vm.Evaluate(`\INT0`); got != uint64(0x32) {
+		t.Fatalf("expected INT0 to be updated to 0x32; got %#v", got)
+	}
+}
+
+func TestVMEvaluateErrors(t *testing.T) {
+	t.Run("nil tree", func(t *testing.T) {
+		if _, err := NewVM(ioutil.Discard, nil).Evaluate(`\TEST`); err != errNilObjectTree {
+			t.Fatalf("expected to get errNilObjectTree; got %v", err)
+		}
+	})
+
+	specs := []struct {
+		argCount uint8
+		body     []byte
+		path     string
+		args     []interface{}
+		expErr   error
+	}{
+		{0, []byte{0xa4, 0x00}, `\FOO_`, nil, errPathNotFound},
+		{0, []byte{0xa4, 0x00}, `\INT0`, []interface{}{1}, errArgCountMismatch},
+		{1, []byte{0xa4, 0x00}, `\TEST`, nil, errArgCountMismatch},
+		{1, []byte{0xa4, 0x00}, `\TEST`, []interface{}{1, 2, 3, 4, 5, 6, 7, 8}, errArgCountMismatch},
+		{1, []byte{0xa4, 0x00}, `\TEST`, []interface{}{1.0}, errUnsupportedArgType},
+		// Return(Local0)
+		{0, []byte{0xa4, 0x60}, `\TEST`, nil, errUninitializedValue},
+		// Return(Arg0 / 0)
+		{1, []byte{0xa4, 0x78, 0x68, 0x00, 0x00, 0x00}, `\TEST`, []interface{}{1}, errDivideByZero},
+		// Return(Mod(Arg0, 0))
+		{1, []byte{0xa4, 0x85, 0x68, 0x00, 0x00}, `\TEST`, []interface{}{1}, errDivideByZero},
+		// Return(Add(Arg0, 1)) with a Package arg
+		{1, []byte{0xa4, 0x72, 0x68, 0x01, 0x00}, `\TEST`, []interface{}{[]interface{}{1}}, errConversionFailed},
+		// Return(DerefOf(Index(Buffer(1){}, 4)))
+		{
+			0,
+			amltest.Concat(
+				[]byte{0xa4, 0x83, 0x88},
+				amltest.Pkg([]byte{0x11}, []byte{0x01}),
+				[]byte{0x0a, 0x04, 0x00},
+			),
+			`\TEST`, nil, errIndexOutOfBounds,
+		},
+		// Store(One, Index(STR0, Zero))
+		{0, []byte{0x70, 0x01, 0x88, 'S', 'T', 'R', '0', 0x00, 0x00}, `\TEST`, nil, errInvalidStoreTarget},
+		// Store(One, DerefOf(INT0))
+		{0, []byte{0x70, 0x01, 0x83, 'I', 'N', 'T', '0'}, `\TEST`, nil, errInvalidStoreTarget},
+		// Return(ConcatenateResTemplate("foo", Buffer(0) {}, Zero))
+		{
+			0,
+			amltest.Concat([]byte{0xa4, 0x84, 0x0d, 'f', 'o', 'o', 0x00}, amltest.Pkg([]byte{0x11}, []byte{0x00}), []byte{0x00}),
+			`\TEST`, nil, errConversionFailed,
+		},
+		// Return(Match(Package() {1}, 6, 0, MTR, 0, 0))
+		{
+			0,
+			amltest.Concat([]byte{0xa4, 0x89}, amltest.Pkg([]byte{0x12}, []byte{0x01, 0x01}), []byte{0x06, 0x00, 0x00, 0x00, 0x00}),
+			`\TEST`, nil, errInvalidMatchOp,
+		},
+		// Return(Match(Package() {1}, MTR, 0, MTR, 0, 1))
+		{
+			0,
+			amltest.Concat([]byte{0xa4, 0x89}, amltest.Pkg([]byte{0x12}, []byte{0x01, 0x01}), []byte{0x00, 0x00, 0x00, 0x00, 0x01}),
+			`\TEST`, nil, errIndexOutOfBounds,
+		},
+		// Return(RECR())
+		{0, []byte{0xa4, 'R', 'E', 'C', 'R'}, `\RECR`, nil, errMaxCallDepthReached},
+		// Store(1, \_SB)
+		{0, []byte{0x70, 0x01, '\\', '_', 'S', 'B', '_'}, `\TEST`, nil, errInvalidStoreTarget},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForTestMethod(t, spec.argCount, spec.body)
+		if _, err := vm.Evaluate(spec.path, spec.args...); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	t.Run("resource template without an end tag", func(t *testing.T) {
+		// Return(ConcatenateResTemplate(Buffer() {0x22, 0x02, 0x00}, Buffer(0) {}, Zero))
+		vm := vmForTestMethod(t, 0, amltest.Concat([]byte{0xa4, 0x84}, amltest.Buffer(0x22, 0x02, 0x00), amltest.Pkg([]byte{0x11}, []byte{0x00}), []byte{0x00}))
+
+		if _, err := vm.Evaluate(`\TEST`); err == nil || err.Module != "acpi_aml_resource" {
+			t.Fatalf("expected to get a resource decoding error; got %v", err)
+		}
+	})
+
+	t.Run("unsupported opcode", func(t *testing.T) {
+		// Return(Add(Arg0, 1))
+		vm := vmForTestMethod(t, 1, []byte{0xa4, 0x72, 0x68, 0x01, 0x00})
+		vm.jumpTable[pOpcodeTableIndex(pOpAdd, true)] = nil
+
+		if _, err := vm.Evaluate(`\TEST`, 1); err != errUnsupportedOpcode {
+			t.Fatalf("expected to get errUnsupportedOpcode; got %v", err)
+		}
+	})
+}
+
+func TestNormalizePath(t *testing.T) {
+	specs := []struct {
+		in  string
+		exp string
+	}{
+		{`\_SB.PCI0._STA`, `\_SB_PCI0_STA`},
+		{`\_SB_PCI0_STA`, `\_SB_PCI0_STA`},
+		{`^^FOO.BAR`, `^^FOO_BAR_`},
+		{`\`, `\`},
+		{`_S5`, `_S5_`},
+	}
+
+	for specIndex, spec := range specs {
+		if got := string(normalizePath(spec.in)); got != spec.exp {
+			t.Errorf("[spec %d] expected to get %q; got %q", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestVMConversions(t *testing.T) {
+	vm := NewVM(ioutil.Discard, NewObjectTree())
+
+	intSpecs := []struct {
+		in  interface{}
+		exp uint64
+	}{
+		{uint64(42), 42},
+		{"1aF", 0x1af},
+		{"12zz", 0x12},
+		{[]byte{0x01, 0x02}, 0x0201},
+		{[]byte{1, 2, 3, 4, 5, 6, 7, 8, 9}, 0x0807060504030201},
+	}
+
+	for specIndex, spec := range intSpecs {
+		if got, err := vm.toInteger(spec.in); err != nil || got != spec.exp {
+			t.Errorf("[spec %d] expected toInteger to return 0x%x; got 0x%x, %v", specIndex, spec.exp, got, err)
+		}
+	}
+
+	if got, err := vm.toString(uint64(0xbadf00d)); err != nil || got != "000000000BADF00D" {
+		t.Errorf("expected toString to return 000000000BADF00D; got %q, %v", got, err)
+	}
+
+	if got, err := vm.toString([]byte{0x0a, 0xff}); err != nil || got != "0A FF" {
+		t.Errorf(`expected toString to return "0A FF"; got %q, %v`, got, err)
+	}
+
+	if got, err := vm.toBuffer("hi"); err != nil || !reflect.DeepEqual(got, []byte{'h', 'i', 0}) {
+		t.Errorf("expected toBuffer to return a null-terminated buffer; got %v, %v", got, err)
+	}
+
+	for _, fn := range []func(interface{}) *kernel.Error{
+		func(v interface{}) *kernel.Error { _, err := vm.toInteger(v); return err },
+		func(v interface{}) *kernel.Error { _, err := vm.toString(v); return err },
+		func(v interface{}) *kernel.Error { _, err := vm.toBuffer(v); return err },
+	} {
+		if err := fn([]interface{}{}); err != errConversionFailed {
+			t.Errorf("expected to get errConversionFailed; got %v", err)
+		}
+	}
+}
+
+func TestVMIntegerWidth(t *testing.T) {
+	specs := []struct {
+		body []byte
+		args []interface{}
+		exp  interface{}
+	}{
+		// Return(Ones)
+		{[]byte{0xa4, 0xff}, nil, uint64(0xffffffff)},
+		// Return(Add(0xffffffff, 1))
+		{[]byte{0xa4, 0x72, 0x0c, 0xff, 0xff, 0xff, 0xff, 0x01, 0x00}, nil, uint64(0)},
+		// Return(Not(Zero))
+		{[]byte{0xa4, 0x80, 0x00, 0x00}, nil, uint64(0xffffffff)},
+		// Return(LEqual(1, 1))
+		{[]byte{0xa4, 0x93, 0x01, 0x01}, nil, uint64(0xffffffff)},
+		// Return(0x1122334455667788)
+		{[]byte{0xa4, 0x0e, 0x88, 0x77, 0x66, 0x55, 0x44, 0x33, 0x22, 0x11}, nil, uint64(0x55667788)},
+		// Return(Concatenate("", 0x2a))
+		{[]byte{0xa4, 0x73, 0x0d, 0x00, 0x0a, 0x2a, 0x00}, nil, "0000002A"},
+		// Return(Arg0)
+		{[]byte{0xa4, 0x68}, []interface{}{uint64(0x100000001)}, uint64(1)},
+	}
+
+	for specIndex, spec := range specs {
+		vm := vmForTestMethodWithRevision(t, 1, uint8(len(spec.args)), spec.body)
+		if got := vm.IntegerWidth(); got != convert.Width32 {
+			t.Fatalf("[spec %d] expected VM to use 32-bit integers for a revision 1 DSDT; got width %d", specIndex, got)
+		}
+
+		if got, err := vm.Evaluate(`\TEST`, spec.args...); err != nil || !reflect.DeepEqual(got, spec.exp) {
+			t.Errorf("[spec %d] expected to get %v; got %v (err: %v)", specIndex, spec.exp, got, err)
+		}
+	}
+
+	t.Run("width override", func(t *testing.T) {
+		vm := vmForTestMethodWithRevision(t, 1, 0, []byte{0xa4, 0xff})
+		vm.SetIntegerWidth(convert.Width64)
+
+		if got, err := vm.Evaluate(`\TEST`); err != nil || got != vmTrue {
+			t.Fatalf("expected to get 0x%x; got %v (err: %v)", vmTrue, got, err)
+		}
+	})
+
+	t.Run("revision 2 DSDT", func(t *testing.T) {
+		if got := vmForTestMethod(t, 0, nil).IntegerWidth(); got != convert.Width64 {
+			t.Fatalf("expected VM to use 64-bit integers for a revision 2 DSDT; got width %d", got)
+		}
+	})
+}
+
+// vmForTestMethod generates a DSDT that contains a few global objects and a
+// method called TEST with the specified argument count and body, parses it
+// and returns a VM instance for executing it.
+func vmForTestMethod(t *testing.T, argCount uint8, body []byte) *VM {
+	return vmForTestMethodWithRevision(t, 2, argCount, body)
+}
+
+// vmForTestMethodWithRevision behaves like vmForTestMethod but sets the
+// revision of the generated DSDT to the specified value.
+func vmForTestMethodWithRevision(t *testing.T, revision, argCount uint8, body []byte) *VM {
+	payload := amltest.Concat(
+		// Name(INT0, 0x2a)
+		[]byte{0x08, 'I', 'N', 'T', '0', 0x0a, 0x2a},
+		// Name(CNV0, Zero)
+		[]byte{0x08, 'C', 'N', 'V', '0', 0x00},
+		// Name(STR0, "foo")
+		[]byte{0x08, 'S', 'T', 'R', '0', 0x0d, 'f', 'o', 'o', 0x00},
+		// Method(DBL_, 1) { Return(Multiply(Arg0, 2)) }
+		amltest.Pkg([]byte{0x14}, []byte{'D', 'B', 'L', '_', 0x01, 0xa4, 0x77, 0x68, 0x0a, 0x02, 0x00}),
+		// Method(RECR, 0) { Return(RECR()) }
+		amltest.Pkg([]byte{0x14}, []byte{'R', 'E', 'C', 'R', 0x00, 0xa4, 'R', 'E', 'C', 'R'}),
+		// Method(TEST, argCount) { body }
+		amltest.Pkg([]byte{0x14}, amltest.Concat([]byte{'T', 'E', 'S', 'T', argCount}, body)),
+	)
+
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+
+	header := mockByteDataResolver(payload).LookupTable("DSDT")
+	header.Revision = revision
+	if err := NewParser(&testWriter{t: t}, tree).ParseAML(0, "DSDT", header); err != nil {
+		t.Fatalf("unable to parse test payload: %v", err)
+	}
+
+	return NewVM(ioutil.Discard, tree)
+}
+
+// vmForPayload parses a DSDT containing the supplied AML payload and returns a
+// VM instance for executing it.
+func vmForPayload(t *testing.T, payload []byte) *VM {
+	tree := NewObjectTree()
+	tree.CreateDefaultScopes(0)
+
+	if err := NewParser(&testWriter{t: t}, tree).ParseAML(0, "DSDT", mockByteDataResolver(payload).LookupTable("DSDT")); err != nil {
+		t.Fatalf("unable to parse test payload: %v", err)
+	}
+
+	return NewVM(ioutil.Discard, tree)
+}
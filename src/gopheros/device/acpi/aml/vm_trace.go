@@ -0,0 +1,164 @@
+package aml
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+// TraceEvent describes the execution of a single AML opcode.
+type TraceEvent struct {
+	// The name of the executed opcode.
+	Opcode string
+
+	// The namespace path of the method (or scope) that contains the
+	// opcode.
+	Path string
+
+	// The table handle and offset of the opcode in the AML stream.
+	TableHandle uint8
+	Offset      uint32
+
+	// The number of nested method invocations that led to the opcode
+	// execution.
+	Depth uint32
+
+	// The values of the args evaluated by the opcode, its result and
+	// any execution error. These fields are only populated when the
+	// event is passed to OnOpEnd. Buffer and Package values may be
+	// recycled once the method that created them returns so tracers
+	// must copy them if they need to access them after OnOpEnd returns.
+	Args   []interface{}
+	Result interface{}
+	Err    *kernel.Error
+}
+
+// Tracer receives notifications about the opcodes executed by the VM. Calls
+// to OnOpStart and OnOpEnd are nested; opcodes that are evaluated as args of
+// another opcode start and end before the enclosing opcode ends.
+type Tracer interface {
+	OnOpStart(ev *TraceEvent)
+	OnOpEnd(ev *TraceEvent)
+}
+
+// SetTracer installs a tracer that gets notified about each opcode executed
+// by the VM. Passing a nil tracer disables tracing.
+func (vm *VM) SetTracer(tracer Tracer) {
+	vm.tracer = tracer
+}
+
+// traceOpcode executes obj using handler and notifies the installed tracer
+// before and after the execution.
+func (vm *VM) traceOpcode(ctx *execContext, obj *Object, handler opHandler) *kernel.Error {
+	ev := &TraceEvent{
+		Opcode:      pOpcodeName(obj.opcode),
+		TableHandle: obj.tableHandle,
+		Offset:      obj.amlOffset,
+		Depth:       ctx.depth,
+	}
+
+	if node := vm.tree.Namespace().nodeAt(ctx.scopeIndex); node != nil {
+		ev.Path = node.Path()
+	}
+
+	vm.tracer.OnOpStart(ev)
+	if ctx.thread != nil {
+		ctx.thread.traceStack = append(ctx.thread.traceStack, ev)
+	}
+
+	ev.Err = handler(vm, ctx, obj)
+
+	if ctx.thread != nil {
+		ctx.thread.traceStack = ctx.thread.traceStack[:len(ctx.thread.traceStack)-1]
+	}
+	ev.Result = ctx.retVal
+	vm.tracer.OnOpEnd(ev)
+
+	return ev.Err
+}
+
+// traceArg records an arg value evaluated by the opcode that is currently
+// being traced.
+func (vm *VM) traceArg(ctx *execContext, val interface{}) {
+	if ctx.thread == nil || len(ctx.thread.traceStack) == 0 {
+		return
+	}
+
+	ev := ctx.thread.traceStack[len(ctx.thread.traceStack)-1]
+	ev.Args = append(ev.Args, val)
+}
+
+// LogTracer is a Tracer that logs each executed opcode together with its
+// args and result to an io.Writer (typically a serial port). Opcodes are
+// indented according to their nesting level and logged once they finish
+// executing so the output follows the evaluation order of the AML code.
+type LogTracer struct {
+	w     io.Writer
+	level int
+}
+
+// NewLogTracer returns a LogTracer that writes its output to w.
+func NewLogTracer(w io.Writer) *LogTracer {
+	return &LogTracer{w: w}
+}
+
+// OnOpStart implements Tracer.
+func (t *LogTracer) OnOpStart(ev *TraceEvent) {
+	t.level++
+}
+
+// OnOpEnd implements Tracer.
+func (t *LogTracer) OnOpEnd(ev *TraceEvent) {
+	t.level--
+
+	kfmt.Fprintf(t.w, "[%s] ", ev.Path)
+	for i := 0; i < t.level; i++ {
+		kfmt.Fprintf(t.w, "  ")
+	}
+
+	kfmt.Fprintf(t.w, "%s(", ev.Opcode)
+	for argIndex, arg := range ev.Args {
+		if argIndex != 0 {
+			kfmt.Fprintf(t.w, ", ")
+		}
+		traceValue(t.w, arg)
+	}
+	kfmt.Fprintf(t.w, ")")
+
+	if ev.Err != nil {
+		kfmt.Fprintf(t.w, " error: %s\n", ev.Err.Message)
+		return
+	}
+
+	if ev.Result != nil {
+		kfmt.Fprintf(t.w, " = ")
+		traceValue(t.w, ev.Result)
+	}
+	kfmt.Fprintf(t.w, "\n")
+}
+
+// traceValue writes a compact, single-line representation of val to w.
+func traceValue(w io.Writer, val interface{}) {
+	switch typ := val.(type) {
+	case nil:
+		kfmt.Fprintf(w, "<uninitialized>")
+	case uint64:
+		kfmt.Fprintf(w, "0x%x", typ)
+	case string:
+		kfmt.Fprintf(w, "\"%s\"", typ)
+	case []byte:
+		kfmt.Fprintf(w, "Buffer(%d)", len(typ))
+	case []interface{}:
+		kfmt.Fprintf(w, "Package(%d)", len(typ))
+	case *Object:
+		kfmt.Fprintf(w, "%s(%s)", pOpcodeName(typ.opcode), typ.Name())
+	case *Reference:
+		if typ.Target != nil {
+			kfmt.Fprintf(w, "RefOf(%s)", typ.Target.Name())
+			break
+		}
+		kfmt.Fprintf(w, "Index(%d)", typ.Index)
+	default:
+		kfmt.Fprintf(w, "<unknown>")
+	}
+}
@@ -0,0 +1,98 @@
+package aml
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"reflect"
+	"testing"
+)
+
+func TestVMTracer(t *testing.T) {
+	// Return(Add(Arg0, DBL_(3)))
+	vm := vmForTestMethod(t, 1, []byte{0xa4, 0x72, 0x68, 'D', 'B', 'L', '_', 0x0a, 0x03, 0x00})
+
+	var tracer recordingTracer
+	vm.SetTracer(&tracer)
+
+	if got, err := vm.Evaluate(`\TEST`, 1); err != nil || got != uint64(7) {
+		t.Fatalf("expected to get 7; got %v (err: %v)", got, err)
+	}
+
+	expEvents := []TraceEvent{
+		{Opcode: "Multiply", Path: `\DBL_`, Depth: 2, Args: []interface{}{uint64(3), uint64(2)}, Result: uint64(6)},
+		{Opcode: "Return", Path: `\DBL_`, Depth: 2, Args: []interface{}{uint64(6)}, Result: uint64(6)},
+		{Opcode: "MethodCall", Path: `\TEST`, Depth: 1, Args: []interface{}{uint64(3)}, Result: uint64(6)},
+		{Opcode: "Add", Path: `\TEST`, Depth: 1, Args: []interface{}{uint64(1), uint64(6)}, Result: uint64(7)},
+		{Opcode: "Return", Path: `\TEST`, Depth: 1, Args: []interface{}{uint64(7)}, Result: uint64(7)},
+	}
+
+	if tracer.started != len(expEvents) {
+		t.Errorf("expected OnOpStart to be called %d times; got %d", len(expEvents), tracer.started)
+	}
+
+	if len(tracer.events) != len(expEvents) {
+		t.Fatalf("expected %d trace events; got %d", len(expEvents), len(tracer.events))
+	}
+
+	for index, exp := range expEvents {
+		got := tracer.events[index]
+		got.TableHandle, got.Offset = 0, 0
+		if !reflect.DeepEqual(got, exp) {
+			t.Errorf("[event %d] expected %+v; got %+v", index, exp, got)
+		}
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		// Return(Divide(Arg0, 0))
+		vm := vmForTestMethod(t, 1, []byte{0xa4, 0x78, 0x68, 0x00, 0x00, 0x00})
+
+		var tracer recordingTracer
+		vm.SetTracer(&tracer)
+
+		if _, err := vm.Evaluate(`\TEST`, 1); err != errDivideByZero {
+			t.Fatalf("expected to get errDivideByZero; got %v", err)
+		}
+
+		if got := tracer.events[0]; got.Opcode != "Divide" || got.Err != errDivideByZero {
+			t.Fatalf("expected the Divide event to report errDivideByZero; got %+v", got)
+		}
+	})
+}
+
+func TestLogTracer(t *testing.T) {
+	// Store(Package(1) { "foo" }, Local0)
+	// Return(Add(Arg0, DBL_(3)))
+	vm := vmForTestMethod(t, 1, amltest.Concat(
+		[]byte{0x70},
+		amltest.Pkg([]byte{0x12}, []byte{0x01, 0x0d, 'f', 'o', 'o', 0x00}),
+		[]byte{0x60},
+		[]byte{0xa4, 0x72, 0x68, 'D', 'B', 'L', '_', 0x0a, 0x03, 0x00},
+	))
+
+	var buf bytes.Buffer
+	vm.SetTracer(NewLogTracer(&buf))
+
+	if _, err := vm.Evaluate(`\TEST`, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := `[\TEST]   Package() = Package(1)
+[\TEST] Store(Package(1)) = Package(1)
+[\DBL_]         Multiply(0x3, 0x2) = 0x6
+[\DBL_]       Return(0x6) = 0x6
+[\TEST]     MethodCall(0x3) = 0x6
+[\TEST]   Add(0x1, 0x6) = 0x7
+[\TEST] Return(0x7) = 0x7
+`
+	if got := buf.String(); got != exp {
+		t.Fatalf("expected trace output:\n%s\ngot:\n%s", exp, got)
+	}
+}
+
+type recordingTracer struct {
+	started int
+	events  []TraceEvent
+}
+
+func (t *recordingTracer) OnOpStart(ev *TraceEvent) { t.started++ }
+func (t *recordingTracer) OnOpEnd(ev *TraceEvent)   { t.events = append(t.events, *ev) }
@@ -0,0 +1,23 @@
+// Package vmtest provides helpers for tests that evaluate AML payloads
+// assembled using the amltest package.
+package vmtest
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/amltest"
+	"io/ioutil"
+	"testing"
+)
+
+// ForPayload parses a DSDT containing the supplied AML payload and returns a
+// VM for executing it together with the populated namespace. The test fails
+// if the payload cannot be parsed.
+func ForPayload(t testing.TB, payload []byte) (*aml.VM, *aml.Namespace) {
+	tree := aml.NewObjectTree()
+	tree.CreateDefaultScopes(0)
+	if err := aml.NewParser(ioutil.Discard, tree).ParseAML(0, "DSDT", amltest.SDTHeaderFor(payload)); err != nil {
+		t.Fatalf("unable to parse test payload: %v", err)
+	}
+
+	return aml.NewVM(ioutil.Discard, tree), tree.Namespace()
+}
@@ -0,0 +1,278 @@
+// Package apei implements support for the ACPI Platform Error Interfaces. The
+// driver reports the hardware errors that the firmware logged in the boot
+// error region (BERT) during the previous boot and registers the generic
+// hardware error sources listed in the HEST table with the machine check
+// subsystem. The error record serialization table (ERST) is decoded so that
+// its presence can be reported.
+package apei
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mce"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"io"
+	"reflect"
+	"strconv"
+	"unsafe"
+)
+
+var (
+	errNoErrorStatusBlock = &kernel.Error{Module: "apei", Message: "error source does not define a valid error status block", Code: kernel.ErrCodeNotFound}
+
+	lookupTableFn         = acpi.LookupTable
+	readGenericAddressFn  = acpi.ReadGenericAddress
+	writeGenericAddressFn = acpi.WriteGenericAddress
+	mapRegionFn           = vmm.MapRegion
+	registerSourceFn      = mce.RegisterSource
+)
+
+// minErrorStatusBlockLen is the length of the header of a generic error status
+// block.
+const minErrorStatusBlockLen = 20
+
+// The names of the error sections that are relevant to x86 systems.
+var sectionNames = map[[16]byte]string{
+	table.ErrorSectionProcessorGeneric: "generic processor",
+	table.ErrorSectionProcessorX86:     "x86 processor",
+	table.ErrorSectionMemory:           "memory",
+	table.ErrorSectionPCIe:             "PCIe",
+}
+
+// Driver implements a device.Driver for the APEI tables.
+type Driver struct {
+	hest []table.HESTErrorSource
+	bert *table.BERTInfo
+	erst *table.ERSTInfo
+}
+
+// DriverName returns the name of this driver.
+func (*Driver) DriverName() string {
+	return "APEI"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Driver) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit reports the errors logged in the boot error region and
+// registers the polled and NMI-signalled generic hardware error sources with
+// the machine check subsystem. Errors encountered while processing a
+// particular table are reported but do not prevent the remaining tables from
+// being processed.
+func (d *Driver) DriverInit(w io.Writer) *kernel.Error {
+	if d.bert != nil {
+		if err := d.reportBootErrors(w); err != nil {
+			kfmt.Fprintf(w, "unable to process boot error region: %s\n", err.Error())
+		}
+	}
+
+	if len(d.hest) != 0 {
+		d.registerErrorSources(w)
+	}
+
+	if d.erst != nil {
+		kfmt.Fprintf(w, "ERST: %d serialization instructions\n", len(d.erst.Entries))
+	}
+
+	return nil
+}
+
+// reportBootErrors maps the boot error region and reports its contents.
+func (d *Driver) reportBootErrors(w io.Writer) *kernel.Error {
+	region, err := mapBuffer(d.bert.RegionAddress, d.bert.RegionLength, vmm.FlagPresent)
+	if err != nil {
+		return err
+	}
+
+	block, err := table.DecodeErrorStatusBlock(region)
+	if err != nil {
+		return err
+	}
+
+	if block.BlockStatus == 0 {
+		return nil
+	}
+
+	reportErrors(w, "boot error region", block)
+	return nil
+}
+
+// registerErrorSources registers the enabled generic hardware error sources
+// that use polling or NMIs for notifications. Other error sources are
+// skipped.
+func (d *Driver) registerErrorSources(w io.Writer) {
+	var registered, skipped int
+
+	for index := range d.hest {
+		src := &d.hest[index]
+		if !src.Enabled || (src.Type != table.HESTSourceGHES && src.Type != table.HESTSourceGHESv2) {
+			skipped++
+			continue
+		}
+
+		var notify mce.Notification
+		switch src.Notification.Type {
+		case table.HESTNotifyPolled:
+			notify = mce.NotifyPolled
+		case table.HESTNotifyNMI:
+			notify = mce.NotifyNMI
+		default:
+			skipped++
+			continue
+		}
+
+		ghes, err := newGHES(src)
+		if err == nil {
+			err = registerSourceFn(ghes, notify, src.Notification.PollInterval)
+		}
+
+		if err != nil {
+			kfmt.Fprintf(w, "unable to register error source %d: %s\n", src.SourceID, err.Error())
+			skipped++
+			continue
+		}
+
+		registered++
+	}
+
+	kfmt.Fprintf(w, "registered %d hardware error sources (skipped: %d)\n", registered, skipped)
+}
+
+// ghesSource implements mce.Source for a generic hardware error source.
+type ghesSource struct {
+	info *table.HESTErrorSource
+	name string
+
+	// The mapped error status block.
+	block []byte
+}
+
+// newGHES looks up and maps the error status block of a generic hardware
+// error source.
+func newGHES(info *table.HESTErrorSource) (*ghesSource, *kernel.Error) {
+	if info.ErrorStatusBlockLength < minErrorStatusBlockLen {
+		return nil, errNoErrorStatusBlock
+	}
+
+	blockAddr, err := readGenericAddressFn(info.ErrorStatusAddress)
+	if err != nil {
+		return nil, err
+	}
+
+	if blockAddr == 0 {
+		return nil, errNoErrorStatusBlock
+	}
+
+	block, err := mapBuffer(blockAddr, info.ErrorStatusBlockLength, vmm.FlagPresent|vmm.FlagRW)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ghesSource{
+		info:  info,
+		name:  "GHES " + strconv.Itoa(int(info.SourceID)),
+		block: block,
+	}, nil
+}
+
+// ErrorSourceName returns the name of the error source.
+func (s *ghesSource) ErrorSourceName() string {
+	return s.name
+}
+
+// CheckErrors reports the contents of the error status block if it contains
+// any errors. Once the errors are reported, the block status is cleared and,
+// for version 2 sources, the firmware is notified that the block can be
+// reused.
+func (s *ghesSource) CheckErrors(w io.Writer) bool {
+	pw := &kfmt.PrefixWriter{Sink: w, Prefix: []byte("[apei] ")}
+
+	block, err := table.DecodeErrorStatusBlock(s.block)
+	if err != nil {
+		kfmt.Fprintf(pw, "%s: %s\n", s.name, err.Error())
+	} else if block.BlockStatus == 0 {
+		return false
+	} else {
+		reportErrors(pw, s.name, block)
+	}
+
+	// Clear the block status so the firmware can log new errors
+	for i := 0; i < 4; i++ {
+		s.block[i] = 0
+	}
+
+	if s.info.Type == table.HESTSourceGHESv2 {
+		val, err := readGenericAddressFn(s.info.ReadAckRegister)
+		if err == nil {
+			_ = writeGenericAddressFn(s.info.ReadAckRegister, val&s.info.ReadAckPreserve|s.info.ReadAckWrite)
+		}
+	}
+
+	return true
+}
+
+// reportErrors writes a summary of the errors in an error status block to w.
+func reportErrors(w io.Writer, source string, block *table.ErrorStatusBlock) {
+	kfmt.Fprintf(w, "%s: %s hardware error\n", source, block.Severity.String())
+	for index, section := range block.Sections {
+		name, ok := sectionNames[section.SectionType]
+		if !ok {
+			name = "unknown"
+		}
+
+		kfmt.Fprintf(w, "  section %d: %s error (severity: %s, length: %d)\n", index, name, section.Severity.String(), len(section.Data))
+	}
+}
+
+// mapBuffer maps the physical memory region at the specified address and
+// returns a byte slice that overlays it.
+func mapBuffer(physAddr uint64, length uint32, flags vmm.PageTableEntryFlag) ([]byte, *kernel.Error) {
+	pageOffset := vmm.PageOffset(uintptr(physAddr))
+	page, err := mapRegionFn(mm.FrameFromAddress(uintptr(physAddr)), pageOffset+uintptr(length), flags)
+	if err != nil {
+		return nil, err
+	}
+
+	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Len:  int(length),
+		Cap:  int(length),
+		Data: page.Address() + pageOffset,
+	})), nil
+}
+
+// probeForAPEI checks for the presence of any of the HEST, BERT or ERST
+// tables. Tables that cannot be decoded are ignored.
+func probeForAPEI() device.Driver {
+	var drv Driver
+
+	if header := lookupTableFn("HEST"); header != nil {
+		drv.hest, _ = table.DecodeHEST(header)
+	}
+
+	if header := lookupTableFn("BERT"); header != nil {
+		drv.bert, _ = table.DecodeBERT(header)
+	}
+
+	if header := lookupTableFn("ERST"); header != nil {
+		drv.erst, _ = table.DecodeERST(header)
+	}
+
+	if len(drv.hest) == 0 && drv.bert == nil && drv.erst == nil {
+		return nil
+	}
+
+	return &drv
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForAPEI,
+	})
+}
@@ -0,0 +1,237 @@
+package apei
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mce"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+	mockMapRegion()
+
+	var (
+		bootRegion  = genErrorStatusBlock(table.ErrorSeverityFatal, table.ErrorSectionMemory)
+		polledBlock = genErrorStatusBlock(table.ErrorSeverityNone)
+		nmiBlock    = genErrorStatusBlock(table.ErrorSeverityNone)
+		blockAddrs  = map[uint64]uint64{
+			0x1000: bufferAddr(polledBlock),
+			0x1008: bufferAddr(nmiBlock),
+			0x1010: 0,
+		}
+		registered = make(map[string]mce.Notification)
+	)
+
+	readGenericAddressFn = func(addr table.GenericAddress) (uint64, *kernel.Error) {
+		return blockAddrs[addr.Address], nil
+	}
+	registerSourceFn = func(src mce.Source, notify mce.Notification, _ uint32) *kernel.Error {
+		registered[src.ErrorSourceName()] = notify
+		return nil
+	}
+
+	ghes := func(srcID uint16, notify table.HESTNotifyType, statusAddr uint64) table.HESTErrorSource {
+		return table.HESTErrorSource{
+			Type:                   table.HESTSourceGHES,
+			SourceID:               srcID,
+			Enabled:                true,
+			Notification:           table.HESTNotification{Type: notify, PollInterval: 1000},
+			ErrorStatusAddress:     table.GenericAddress{Space: table.AddressSpaceSysMemory, BitWidth: 64, Address: statusAddr},
+			ErrorStatusBlockLength: uint32(len(polledBlock)),
+		}
+	}
+
+	disabled := ghes(3, table.HESTNotifyPolled, 0x1000)
+	disabled.Enabled = false
+
+	drv := &Driver{
+		bert: &table.BERTInfo{RegionAddress: bufferAddr(bootRegion), RegionLength: uint32(len(bootRegion))},
+		erst: &table.ERSTInfo{Entries: make([]table.ERSTEntry, 3)},
+		hest: []table.HESTErrorSource{
+			{Type: table.HESTSourceIA32MCE, Enabled: true},
+			ghes(1, table.HESTNotifyPolled, 0x1000),
+			ghes(2, table.HESTNotifyNMI, 0x1008),
+			disabled,
+			ghes(4, table.HESTNotifySCI, 0x1000),
+			// Missing error status block
+			ghes(5, table.HESTNotifyPolled, 0x1010),
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := drv.DriverInit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := map[string]mce.Notification{"GHES 1": mce.NotifyPolled, "GHES 2": mce.NotifyNMI}; len(registered) != len(exp) || registered["GHES 1"] != exp["GHES 1"] || registered["GHES 2"] != exp["GHES 2"] {
+		t.Fatalf("expected registered sources %v; got %v", exp, registered)
+	}
+
+	for _, exp := range []string{
+		"boot error region: fatal hardware error\n",
+		"section 0: memory error (severity: fatal, length: 4)\n",
+		"unable to register error source 5",
+		"registered 2 hardware error sources (skipped: 4)\n",
+		"ERST: 3 serialization instructions\n",
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("expected output to contain %q; got:\n%s", exp, buf.String())
+		}
+	}
+
+	// Failures to map the boot error region should be reported
+	expErr := &kernel.Error{Module: "test", Message: "map failed"}
+	mapRegionFn = func(mm.Frame, uintptr, vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) { return 0, expErr }
+
+	buf.Reset()
+	drv = &Driver{bert: &table.BERTInfo{RegionAddress: 0x1000, RegionLength: 64}}
+	if err := drv.DriverInit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "unable to process boot error region: map failed\n"; buf.String() != exp {
+		t.Fatalf("expected output %q; got %q", exp, buf.String())
+	}
+}
+
+func TestCheckErrors(t *testing.T) {
+	defer restoreFns()
+	mockMapRegion()
+
+	var (
+		block    = genErrorStatusBlock(table.ErrorSeverityCorrected, table.ErrorSectionPCIe, [16]byte{0x42})
+		ackReg   = uint64(0xf0)
+		ackAddr  = table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 8, Address: 0x80}
+		statAddr = table.GenericAddress{Space: table.AddressSpaceSysMemory, BitWidth: 64, Address: 0x1000}
+	)
+
+	readGenericAddressFn = func(addr table.GenericAddress) (uint64, *kernel.Error) {
+		if addr == ackAddr {
+			return ackReg, nil
+		}
+		return bufferAddr(block), nil
+	}
+	writeGenericAddressFn = func(addr table.GenericAddress, val uint64) *kernel.Error {
+		if addr == ackAddr {
+			ackReg = val
+		}
+		return nil
+	}
+
+	src, err := newGHES(&table.HESTErrorSource{
+		Type:                   table.HESTSourceGHESv2,
+		SourceID:               7,
+		ErrorStatusAddress:     statAddr,
+		ErrorStatusBlockLength: uint32(len(block)),
+		ReadAckRegister:        ackAddr,
+		ReadAckPreserve:        0x0f,
+		ReadAckWrite:           0x01,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if !src.CheckErrors(&buf) {
+		t.Fatal("expected CheckErrors to report the logged errors")
+	}
+
+	exp := "[apei] GHES 7: corrected hardware error\n" +
+		"[apei]   section 0: PCIe error (severity: corrected, length: 4)\n" +
+		"[apei]   section 1: unknown error (severity: corrected, length: 4)\n"
+	if buf.String() != exp {
+		t.Fatalf("expected output:\n%s\ngot:\n%s", exp, buf.String())
+	}
+
+	if block[0] != 0 || ackReg != 0x01 {
+		t.Fatalf("expected block status to be cleared and the read ack register to be 0x01; got status 0x%x, ack 0x%x", block[0], ackReg)
+	}
+
+	// The block has been consumed
+	buf.Reset()
+	if src.CheckErrors(&buf) || buf.Len() != 0 {
+		t.Fatalf("expected CheckErrors to find no errors; got output %q", buf.String())
+	}
+
+	// Malformed blocks should be reported and cleared
+	block[0], block[12] = 0x11, 0xff
+	if !src.CheckErrors(&buf) || !strings.Contains(buf.String(), "malformed") || block[0] != 0 {
+		t.Fatalf("expected the malformed block to be reported and cleared; got output %q", buf.String())
+	}
+
+	if _, err := newGHES(&table.HESTErrorSource{ErrorStatusBlockLength: 4}); err != errNoErrorStatusBlock {
+		t.Fatalf("expected to get error %v; got %v", errNoErrorStatusBlock, err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	bert := make([]byte, 48)
+	copy(bert, "BERT")
+	bert[4] = byte(len(bert))
+
+	specs := []struct {
+		tables    map[string]*table.SDTHeader
+		expDriver bool
+	}{
+		{nil, false},
+		{map[string]*table.SDTHeader{"BERT": (*table.SDTHeader)(unsafe.Pointer(&bert[0]))}, true},
+		// Truncated table
+		{map[string]*table.SDTHeader{"BERT": {Signature: [4]byte{'B', 'E', 'R', 'T'}, Length: 36}}, false},
+	}
+
+	for specIndex, spec := range specs {
+		lookupTableFn = func(name string) *table.SDTHeader { return spec.tables[name] }
+
+		if drv := probeForAPEI(); (drv != nil) != spec.expDriver {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDriver, drv)
+		}
+	}
+}
+
+// genErrorStatusBlock returns an error status block with a 4-byte section for
+// each of the specified section types. If no section types are specified,
+// the block status is left cleared.
+func genErrorStatusBlock(severity table.ErrorSeverity, sectionTypes ...[16]byte) []byte {
+	block := make([]byte, 20, 256)
+	for _, sectionType := range sectionTypes {
+		entry := make([]byte, 72+4)
+		copy(entry, sectionType[:])
+		entry[16] = byte(severity)
+		entry[24] = 4
+		block = append(block, entry...)
+	}
+
+	if len(sectionTypes) != 0 {
+		block[0] = byte(table.ErrorStatusCorrectable) | byte(len(sectionTypes)<<4)
+	}
+	block[12] = byte(len(block) - 20)
+	block[16] = byte(severity)
+	return block
+}
+
+func bufferAddr(buf []byte) uint64 {
+	return uint64(uintptr(unsafe.Pointer(&buf[0])))
+}
+
+func mockMapRegion() {
+	mapRegionFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.PageFromAddress(frame.Address()), nil
+	}
+}
+
+func restoreFns() {
+	lookupTableFn = acpi.LookupTable
+	readGenericAddressFn = acpi.ReadGenericAddress
+	writeGenericAddressFn = acpi.WriteGenericAddress
+	mapRegionFn = vmm.MapRegion
+	registerSourceFn = mce.RegisterSource
+}
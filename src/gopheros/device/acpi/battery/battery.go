@@ -0,0 +1,292 @@
+// Package battery implements drivers for the ACPI control method battery
+// (PNP0C0A) and AC adapter (ACPI0003) devices and exposes them through the
+// power supply API.
+package battery
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/device"
+	"gopheros/device/power"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+var (
+	errMalformedBIF = &kernel.Error{Module: "acpi_battery", Message: "battery information object (_BIX/_BIF) has an unexpected format", Code: kernel.ErrCodeCorrupted}
+	errMalformedBST = &kernel.Error{Module: "acpi_battery", Message: "_BST must evaluate to a 4-element package of integers", Code: kernel.ErrCodeCorrupted}
+	errMalformedPSR = &kernel.Error{Module: "acpi_battery", Message: "_PSR must evaluate to an integer", Code: kernel.ErrCodeCorrupted}
+	errMissingInfo  = &kernel.Error{Module: "acpi_battery", Message: "battery does not define a _BIX or _BIF object", Code: kernel.ErrCodeNotSupported}
+)
+
+// The hardware IDs of the supported devices.
+const (
+	batteryHID = "PNP0C0A"
+	adapterHID = "ACPI0003"
+)
+
+// The notifications that the firmware sends to battery and AC adapter
+// devices.
+const (
+	notifyStatusChanged = uint64(0x80)
+	notifyInfoChanged   = uint64(0x81)
+)
+
+// The bits of the battery state value reported by _BST.
+const (
+	bstDischarging = uint64(1 << 0)
+	bstCharging    = uint64(1 << 1)
+	bstCritical    = uint64(1 << 2)
+)
+
+// The value that _BIF, _BIX and _BST use to indicate that a field value is
+// not known.
+const acpiUnknown = uint64(0xffffffff)
+
+// The indices of the fields used by the driver in the _BIF and _BIX
+// packages. _BIX extends _BIF with a leading revision field and a set of
+// fields following the low capacity warning level.
+const (
+	bifPowerUnit      = 0
+	bifDesignCapacity = 1
+	bifFullCapacity   = 2
+
+	bixFieldOffset = 1
+
+	// The number of leading integer fields in each package. The
+	// remaining fields contain strings such as the model number.
+	bifIntFields = 9
+	bixIntFields = 16
+	bstIntFields = 4
+)
+
+// Battery drives an ACPI control method battery.
+type Battery struct {
+	vm   *aml.VM
+	node *aml.NamespaceNode
+
+	// The static battery information obtained via _BIX or _BIF. It is
+	// refreshed when the firmware reports that it has changed or when a
+	// battery is inserted.
+	infoValid      bool
+	unit           power.CapacityUnit
+	designCapacity uint64
+	fullCapacity   uint64
+}
+
+// Name implements power.Supply.
+func (b *Battery) Name() string {
+	return b.node.Path()
+}
+
+// Type implements power.Supply.
+func (b *Battery) Type() power.SupplyType {
+	return power.SupplyTypeBattery
+}
+
+// State implements power.Supply. The returned state is marked as offline if
+// _STA reports that the battery is not installed. Batteries without a _STA
+// method are assumed to be always installed.
+func (b *Battery) State() (power.State, *kernel.Error) {
+	info, err := device.Identify(b.vm, b.node)
+	if err != nil {
+		return power.State{}, err
+	}
+
+	if b.node.Child("_STA") != nil && info.Status&device.StatusBattery == 0 {
+		b.infoValid = false
+		return power.State{Status: power.ChargeStatusUnknown}, nil
+	}
+
+	if !b.infoValid {
+		if err = b.refreshInfo(); err != nil {
+			return power.State{}, err
+		}
+	}
+
+	bst, err := evalIntPackage(b.vm, b.node, "_BST", bstIntFields, errMalformedBST)
+	if err != nil {
+		return power.State{}, err
+	}
+
+	state := power.State{
+		Online:            true,
+		Unit:              b.unit,
+		DesignCapacity:    b.designCapacity,
+		FullCapacity:      b.fullCapacity,
+		Rate:              knownOrUnknown(bst[1]),
+		RemainingCapacity: knownOrUnknown(bst[2]),
+		Voltage:           knownOrUnknown(bst[3]),
+		Critical:          bst[0]&bstCritical != 0,
+	}
+
+	switch {
+	case bst[0]&bstCharging != 0:
+		state.Status = power.ChargeStatusCharging
+	case bst[0]&bstDischarging != 0:
+		state.Status = power.ChargeStatusDischarging
+	case state.Percentage() == 100:
+		state.Status = power.ChargeStatusFull
+	default:
+		state.Status = power.ChargeStatusNotCharging
+	}
+
+	return state, nil
+}
+
+// refreshInfo evaluates the _BIX object of the battery or, if it is not
+// defined, the legacy _BIF object.
+func (b *Battery) refreshInfo() *kernel.Error {
+	var (
+		fields []uint64
+		offset int
+		err    *kernel.Error
+	)
+
+	switch {
+	case b.node.Child("_BIX") != nil:
+		fields, err = evalIntPackage(b.vm, b.node, "_BIX", bixIntFields, errMalformedBIF)
+		offset = bixFieldOffset
+	case b.node.Child("_BIF") != nil:
+		fields, err = evalIntPackage(b.vm, b.node, "_BIF", bifIntFields, errMalformedBIF)
+	default:
+		err = errMissingInfo
+	}
+
+	if err != nil {
+		return err
+	}
+
+	b.unit = power.CapacityUnit(fields[offset+bifPowerUnit])
+	b.designCapacity = knownOrUnknown(fields[offset+bifDesignCapacity])
+	b.fullCapacity = knownOrUnknown(fields[offset+bifFullCapacity])
+	b.infoValid = true
+	return nil
+}
+
+// Adapter drives an ACPI AC adapter.
+type Adapter struct {
+	vm   *aml.VM
+	node *aml.NamespaceNode
+}
+
+// Name implements power.Supply.
+func (a *Adapter) Name() string {
+	return a.node.Path()
+}
+
+// Type implements power.Supply.
+func (a *Adapter) Type() power.SupplyType {
+	return power.SupplyTypeMains
+}
+
+// State implements power.Supply. The adapter is online if it is connected to
+// mains power.
+func (a *Adapter) State() (power.State, *kernel.Error) {
+	psr := a.node.Child("_PSR")
+	if psr == nil {
+		return power.State{}, errMalformedPSR
+	}
+
+	val, err := a.vm.Evaluate(psr.Path())
+	if err != nil {
+		return power.State{}, err
+	}
+
+	online, ok := val.(uint64)
+	if !ok {
+		return power.State{}, errMalformedPSR
+	}
+
+	return power.State{Online: online != 0}, nil
+}
+
+// Probe locates the battery and AC adapter devices in ns, registers them
+// with the power package and installs notify handlers that report their
+// state changes to the power package listeners. Devices whose notify
+// handlers cannot be installed are skipped and the errors are reported to
+// errWriter.
+func Probe(errWriter io.Writer, vm *aml.VM, ns *aml.Namespace) ([]power.Supply, *kernel.Error) {
+	devices, err := device.Enumerate(vm, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var supplies []power.Supply
+	for _, dev := range devices {
+		var (
+			supply  power.Supply
+			battery *Battery
+		)
+
+		switch {
+		case dev.Matches(batteryHID):
+			battery = &Battery{vm: vm, node: dev.Node}
+			supply = battery
+		case dev.Matches(adapterHID):
+			supply = &Adapter{vm: vm, node: dev.Node}
+		default:
+			continue
+		}
+
+		handler := func(_ *aml.NamespaceNode, value uint64) {
+			if battery != nil && value == notifyInfoChanged {
+				battery.infoValid = false
+			}
+
+			if value == notifyStatusChanged || value == notifyInfoChanged {
+				power.NotifyChanged(supply)
+			}
+		}
+
+		if err = vm.InstallNotifyHandler(dev.Path, handler); err != nil {
+			kfmt.Fprintf(errWriter, "[acpi_battery] %s: %s\n", dev.Path, err.Error())
+			continue
+		}
+
+		power.Register(supply)
+		supplies = append(supplies, supply)
+	}
+
+	return supplies, nil
+}
+
+// evalIntPackage evaluates the child object of node with the specified name
+// and returns the values of the first intFields elements of the package it
+// evaluates to. If the object does not evaluate to a package whose first
+// intFields elements are integers, errMalformed is returned.
+func evalIntPackage(vm *aml.VM, node *aml.NamespaceNode, name string, intFields int, errMalformed *kernel.Error) ([]uint64, *kernel.Error) {
+	child := node.Child(name)
+	if child == nil {
+		return nil, errMalformed
+	}
+
+	val, err := vm.Evaluate(child.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	pkg, ok := val.([]interface{})
+	if !ok || len(pkg) < intFields {
+		return nil, errMalformed
+	}
+
+	values := make([]uint64, intFields)
+	for index := range values {
+		if values[index], ok = pkg[index].(uint64); !ok {
+			return nil, errMalformed
+		}
+	}
+
+	return values, nil
+}
+
+// knownOrUnknown maps the ACPI representation of an unknown value to
+// power.Unknown.
+func knownOrUnknown(val uint64) uint64 {
+	if val == acpiUnknown {
+		return power.Unknown
+	}
+
+	return val
+}
@@ -0,0 +1,205 @@
+package battery
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/device/power"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	defer func() {
+		for _, supply := range power.Supplies() {
+			power.Unregister(supply)
+		}
+	}()
+
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'S', 'B', '_'},
+			// Device(BAT0) {
+			//   Name(_HID, EISAID("PNP0C0A"))
+			//   Name(STA_, 0x1f)
+			//   Method(_STA) { Return(STA_) }
+			//   Name(_BIF, Package() { 1, 5000, 4800, 1, 11100, 500, 200, 1, 1, "M", "S", "LION", "OEM" })
+			//   Name(_BST, Package() { 1, 1000, 2400, 11000 })
+			// }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'B', 'A', 'T', '0'},
+				[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x0a},
+				[]byte{0x08, 'S', 'T', 'A', '_', 0x0a, 0x1f},
+				amltest.Pkg([]byte{0x14}, []byte{'_', 'S', 'T', 'A', 0x00, 0xa4, 'S', 'T', 'A', '_'}),
+				[]byte{0x08, '_', 'B', 'I', 'F'}, amltest.Package(
+					amltest.Int(1), amltest.Int(5000), amltest.Int(4800), amltest.Int(1), amltest.Int(11100), amltest.Int(500), amltest.Int(200), amltest.Int(1), amltest.Int(1),
+					amltest.String("M"), amltest.String("S"), amltest.String("LION"), amltest.String("OEM"),
+				),
+				[]byte{0x08, '_', 'B', 'S', 'T'}, amltest.Package(amltest.Int(1), amltest.Int(1000), amltest.Int(2400), amltest.Int(11000)),
+			)),
+			// Device(BAT1) {
+			//   Name(_HID, EISAID("PNP0C0A"))
+			//   Name(_BIX, Package() { 0, 0, 6000, 5500, 1, 12000, 600, 300, 100, 95000, 0, 0, 0, 0, 1, 1, "M", "S", "LION", "OEM" })
+			//   Name(_BST, Package() { 0, 0xffffffff, 5500, 12000 })
+			// }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'B', 'A', 'T', '1'},
+				[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x0a},
+				[]byte{0x08, '_', 'B', 'I', 'X'}, amltest.Package(
+					amltest.Int(0), amltest.Int(0), amltest.Int(6000), amltest.Int(5500), amltest.Int(1), amltest.Int(12000), amltest.Int(600), amltest.Int(300),
+					amltest.Int(100), amltest.Int(95000), amltest.Int(0), amltest.Int(0), amltest.Int(0), amltest.Int(0), amltest.Int(1), amltest.Int(1),
+					amltest.String("M"), amltest.String("S"), amltest.String("LION"), amltest.String("OEM"),
+				),
+				[]byte{0x08, '_', 'B', 'S', 'T'}, amltest.Package(amltest.Int(0), amltest.Int(0xffffffff), amltest.Int(5500), amltest.Int(12000)),
+			)),
+			// Device(ADP0) {
+			//   Name(_HID, "ACPI0003")
+			//   Name(PSR_, One)
+			//   Method(_PSR) { Return(PSR_) }
+			// }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'A', 'D', 'P', '0'},
+				[]byte{0x08, '_', 'H', 'I', 'D'}, amltest.String("ACPI0003"),
+				[]byte{0x08, 'P', 'S', 'R', '_', 0x01},
+				amltest.Pkg([]byte{0x14}, []byte{'_', 'P', 'S', 'R', 0x00, 0xa4, 'P', 'S', 'R', '_'}),
+			)),
+		)),
+		// Method(UNPL) { Store(Zero, \_SB.ADP0.PSR_) Notify(\_SB.ADP0, 0x80) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'U', 'N', 'P', 'L', 0x00,
+			0x70, 0x00, '\\', 0x2f, 0x03, '_', 'S', 'B', '_', 'A', 'D', 'P', '0', 'P', 'S', 'R', '_',
+			0x86, '\\', 0x2e, '_', 'S', 'B', '_', 'A', 'D', 'P', '0', 0x0a, 0x80,
+		}),
+		// Method(EJCT) { Store(0x0f, \_SB.BAT0.STA_) Notify(\_SB.BAT0, 0x81) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'E', 'J', 'C', 'T', 0x00,
+			0x70, 0x0a, 0x0f, '\\', 0x2f, 0x03, '_', 'S', 'B', '_', 'B', 'A', 'T', '0', 'S', 'T', 'A', '_',
+			0x86, '\\', 0x2e, '_', 'S', 'B', '_', 'B', 'A', 'T', '0', 0x0a, 0x81,
+		}),
+	))
+
+	supplies, err := Probe(ioutil.Discard, vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(supplies) != 3 || !reflect.DeepEqual(power.Supplies(), supplies) {
+		t.Fatalf("expected 3 registered power supplies; got %d", len(supplies))
+	}
+
+	specs := []struct {
+		name     string
+		typ      power.SupplyType
+		expState power.State
+	}{
+		{
+			`\_SB_.BAT0`,
+			power.SupplyTypeBattery,
+			power.State{
+				Online:            true,
+				Status:            power.ChargeStatusDischarging,
+				Unit:              power.CapacityUnitMilliAmpHours,
+				DesignCapacity:    5000,
+				FullCapacity:      4800,
+				RemainingCapacity: 2400,
+				Rate:              1000,
+				Voltage:           11000,
+			},
+		},
+		{
+			`\_SB_.BAT1`,
+			power.SupplyTypeBattery,
+			power.State{
+				Online:            true,
+				Status:            power.ChargeStatusFull,
+				Unit:              power.CapacityUnitMilliWattHours,
+				DesignCapacity:    6000,
+				FullCapacity:      5500,
+				RemainingCapacity: 5500,
+				Rate:              power.Unknown,
+				Voltage:           12000,
+			},
+		},
+		{`\_SB_.ADP0`, power.SupplyTypeMains, power.State{Online: true}},
+	}
+
+	for specIndex, spec := range specs {
+		supply := supplies[specIndex]
+		if supply.Name() != spec.name || supply.Type() != spec.typ {
+			t.Errorf("[spec %d] expected supply %q of type %d; got %q of type %d", specIndex, spec.name, spec.typ, supply.Name(), supply.Type())
+			continue
+		}
+
+		state, err := supply.State()
+		if err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(state, spec.expState) {
+			t.Errorf("[spec %d] expected state:\n%+v\ngot:\n%+v", specIndex, spec.expState, state)
+		}
+	}
+
+	t.Run("notifications", func(t *testing.T) {
+		var changed []string
+		power.AddListener(func(supply power.Supply) { changed = append(changed, supply.Name()) })
+
+		for _, method := range []string{`UNPL`, `EJCT`} {
+			if _, err := vm.Evaluate(method); err != nil {
+				t.Fatal(err)
+			}
+		}
+		vm.DispatchNotifications()
+
+		if exp := []string{`\_SB_.ADP0`, `\_SB_.BAT0`}; !reflect.DeepEqual(changed, exp) {
+			t.Fatalf("expected change notifications for %v; got %v", exp, changed)
+		}
+
+		if state, err := supplies[2].State(); err != nil || state.Online {
+			t.Fatalf("expected AC adapter to be offline; got %+v (err: %v)", state, err)
+		}
+
+		if state, err := supplies[0].State(); err != nil || state.Online {
+			t.Fatalf("expected ejected battery to be offline; got %+v (err: %v)", state, err)
+		}
+	})
+}
+
+func TestBatteryErrors(t *testing.T) {
+	specs := []struct {
+		contents []byte
+		expErr   error
+	}{
+		{nil, errMissingInfo},
+		// Name(_BIF, Package() { 1, 5000 })
+		{amltest.Concat([]byte{0x08, '_', 'B', 'I', 'F'}, amltest.Package(amltest.Int(1), amltest.Int(5000))), errMalformedBIF},
+		// Name(_BIF, Package() { 1, 5000, 4800, 1, 11100, 500, 200, 1, 1 }), Name(_BST, Package() { 1, "x", 0, 0 })
+		{
+			amltest.Concat(
+				[]byte{0x08, '_', 'B', 'I', 'F'}, amltest.Package(amltest.Int(1), amltest.Int(5000), amltest.Int(4800), amltest.Int(1), amltest.Int(11100), amltest.Int(500), amltest.Int(200), amltest.Int(1), amltest.Int(1)),
+				[]byte{0x08, '_', 'B', 'S', 'T'}, amltest.Package(amltest.Int(1), amltest.String("x"), amltest.Int(0), amltest.Int(0)),
+			),
+			errMalformedBST,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'B', 'A', 'T', '0', 0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x0a},
+			spec.contents,
+		)))
+
+		bat := &Battery{vm: vm, node: ns.Lookup(nil, `\BAT0`)}
+		if _, err := bat.State(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	// An adapter without a _PSR object
+	vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x82}, []byte{'A', 'D', 'P', '0'}))
+	adp := &Adapter{vm: vm, node: ns.Lookup(nil, `\ADP0`)}
+	if _, err := adp.State(); err != errMalformedPSR {
+		t.Fatalf("expected to get errMalformedPSR; got %v", err)
+	}
+}
@@ -0,0 +1,204 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"image"
+	"image/color"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	errNoBootImage          = &kernel.Error{Module: "acpi", Message: "firmware did not report a displayed boot image", Code: kernel.ErrCodeNotFound}
+	errUnsupportedBootImage = &kernel.Error{Module: "acpi", Message: "unsupported boot image format", Code: kernel.ErrCodeNotSupported}
+	errMalformedBootImage   = &kernel.Error{Module: "acpi", Message: "boot image is malformed", Code: kernel.ErrCodeCorrupted}
+)
+
+// The layout of the BMP file and info headers that precede the boot image
+// pixel data.
+const (
+	bmpSignature       = "BM"
+	bmpFileHeaderLen   = 14
+	bmpInfoHeaderLen   = 40
+	bmpHeaderLen       = bmpFileHeaderLen + bmpInfoHeaderLen
+	bmpCompressionNone = 0
+)
+
+// BootImage describes the logo that was displayed by the firmware while
+// booting the system.
+type BootImage struct {
+	image.Image
+
+	// The position of the upper-left corner of the image on the screen in
+	// pixels.
+	OffsetX uint32
+	OffsetY uint32
+}
+
+// FirmwareBootImage locates the boot image described by the BGRT table and
+// maps it into memory so it can be re-rendered on the screen. An error is
+// returned if the firmware did not provide a BGRT table, if the image is no
+// longer displayed on the screen or if the image is not stored as an
+// unrotated 24 or 32 bpp bitmap.
+func FirmwareBootImage() (*BootImage, *kernel.Error) {
+	header := LookupTable("BGRT")
+	if header == nil {
+		return nil, errNoBootImage
+	}
+
+	info, err := table.DecodeBGRT(header)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.Displayed {
+		return nil, errNoBootImage
+	}
+
+	if info.Version != 1 || info.ImageType != table.BGRTImageBitmap || info.Orientation != table.BGRTOrientation0 {
+		return nil, errUnsupportedBootImage
+	}
+
+	// Map the bitmap headers to figure out the size of the image file
+	data, err := mapBootImage(info.ImageAddress, bmpHeaderLen)
+	if err != nil {
+		return nil, err
+	}
+
+	if string(data[:2]) != bmpSignature {
+		return nil, errUnsupportedBootImage
+	}
+
+	fileSize := leDword(data[2:])
+	if fileSize < bmpHeaderLen {
+		return nil, errMalformedBootImage
+	}
+
+	if data, err = mapBootImage(info.ImageAddress, uintptr(fileSize)); err != nil {
+		return nil, err
+	}
+
+	img, err := decodeBitmap(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return &BootImage{
+		Image:   img,
+		OffsetX: info.OffsetX,
+		OffsetY: info.OffsetY,
+	}, nil
+}
+
+// mapBootImage maps size bytes of the boot image located at the specified
+// physical address and returns a byte slice that overlays them.
+func mapBootImage(addr uint64, size uintptr) ([]byte, *kernel.Error) {
+	pageOffset := vmm.PageOffset(uintptr(addr))
+	page, err := mapRegionFn(mm.FrameFromAddress(uintptr(addr)), pageOffset+size, vmm.FlagPresent|vmm.FlagNoExecute)
+	if err != nil {
+		return nil, err
+	}
+
+	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Len:  int(size),
+		Cap:  int(size),
+		Data: page.Address() + pageOffset,
+	})), nil
+}
+
+// bitmapImage implements image.Image for uncompressed 24 and 32 bpp bitmaps.
+// Pixels are decoded on demand from the bitmap data.
+type bitmapImage struct {
+	pixels        []byte
+	width, height int
+	bytesPerPixel int
+	stride        int
+
+	// Set if the bitmap rows are stored from top to bottom instead of
+	// the default bottom to top order.
+	topDown bool
+}
+
+// decodeBitmap parses the headers of the BMP file stored in data and returns
+// an image that provides access to its pixels.
+func decodeBitmap(data []byte) (*bitmapImage, *kernel.Error) {
+	if len(data) < bmpHeaderLen || leDword(data[bmpFileHeaderLen:]) < bmpInfoHeaderLen {
+		return nil, errMalformedBootImage
+	}
+
+	var (
+		pixelOffset = leDword(data[10:])
+		width       = int32(leDword(data[18:]))
+		height      = int32(leDword(data[22:]))
+		bpp         = int(leWord(data[28:]))
+		compression = leDword(data[30:])
+		img         = &bitmapImage{bytesPerPixel: bpp >> 3}
+	)
+
+	if (bpp != 24 && bpp != 32) || compression != bmpCompressionNone {
+		return nil, errUnsupportedBootImage
+	}
+
+	if height < 0 {
+		img.topDown = true
+		height = -height
+	}
+
+	if width <= 0 || height == 0 {
+		return nil, errMalformedBootImage
+	}
+
+	// Rows are padded to a multiple of 4 bytes
+	img.width, img.height = int(width), int(height)
+	img.stride = ((img.width*bpp + 31) >> 5) << 2
+
+	if uint64(pixelOffset)+uint64(img.stride)*uint64(img.height) > uint64(len(data)) {
+		return nil, errMalformedBootImage
+	}
+
+	img.pixels = data[pixelOffset:]
+	return img, nil
+}
+
+// ColorModel implements image.Image.
+func (img *bitmapImage) ColorModel() color.Model {
+	return color.RGBAModel
+}
+
+// Bounds implements image.Image.
+func (img *bitmapImage) Bounds() image.Rectangle {
+	return image.Rect(0, 0, img.width, img.height)
+}
+
+// At implements image.Image. The alpha channel of 32 bpp bitmaps is ignored
+// and all pixels are treated as opaque.
+func (img *bitmapImage) At(x, y int) color.Color {
+	if x < 0 || y < 0 || x >= img.width || y >= img.height {
+		return color.RGBA{}
+	}
+
+	if !img.topDown {
+		y = img.height - 1 - y
+	}
+
+	offset := y*img.stride + x*img.bytesPerPixel
+	return color.RGBA{
+		R: img.pixels[offset+2],
+		G: img.pixels[offset+1],
+		B: img.pixels[offset],
+		A: 255,
+	}
+}
+
+// leWord returns the little-endian word stored in the first 2 bytes of buf.
+func leWord(buf []byte) uint16 {
+	return uint16(buf[0]) | uint16(buf[1])<<8
+}
+
+// leDword returns the little-endian dword stored in the first 4 bytes of buf.
+func leDword(buf []byte) uint32 {
+	return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
+}
@@ -0,0 +1,181 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"image"
+	"image/color"
+	"testing"
+	"unsafe"
+)
+
+func TestFirmwareBootImage(t *testing.T) {
+	defer func() {
+		mapRegionFn = vmm.MapRegion
+		activeDriver = nil
+	}()
+
+	// Allocate a buffer large enough to contain an aligned page and use it
+	// as the target for the boot image mappings
+	buf := make([]byte, 2*mm.PageSize)
+	bufPage := mm.PageFromAddress(uintptr(unsafe.Pointer(&buf[0])) + mm.PageSize - 1)
+	bufBase := bufPage.Address() - uintptr(unsafe.Pointer(&buf[0]))
+
+	mapRegionFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return bufPage, nil
+	}
+
+	// A 2x2 24bpp bottom-up bitmap; each row is padded to 8 bytes
+	imageAddr := uint64(0x10)
+	copy(buf[bufBase+uintptr(imageAddr):], genBitmap(2, 2, 24, []byte{
+		// bottom row: blue, white
+		0xff, 0x00, 0x00, 0xff, 0xff, 0xff, 0x00, 0x00,
+		// top row: red, green
+		0x00, 0x00, 0xff, 0x00, 0xff, 0x00, 0x00, 0x00,
+	}))
+
+	t.Run("success", func(t *testing.T) {
+		activeDriver = &acpiDriver{tableMap: map[string]*table.SDTHeader{
+			"BGRT": genBGRT(1, 0x1, 0, imageAddr, 100, 200),
+		}}
+
+		img, err := FirmwareBootImage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if img.OffsetX != 100 || img.OffsetY != 200 {
+			t.Errorf("expected image offset to be (100, 200); got (%d, %d)", img.OffsetX, img.OffsetY)
+		}
+
+		if exp, got := image.Rect(0, 0, 2, 2), img.Bounds(); got != exp {
+			t.Fatalf("expected image bounds to be %v; got %v", exp, got)
+		}
+
+		specs := []struct {
+			x, y int
+			exp  color.RGBA
+		}{
+			{0, 0, color.RGBA{R: 255, A: 255}},
+			{1, 0, color.RGBA{G: 255, A: 255}},
+			{0, 1, color.RGBA{B: 255, A: 255}},
+			{1, 1, color.RGBA{R: 255, G: 255, B: 255, A: 255}},
+			{2, 0, color.RGBA{}},
+		}
+
+		for specIndex, spec := range specs {
+			if got := img.At(spec.x, spec.y); got != spec.exp {
+				t.Errorf("[spec %d] expected pixel at (%d, %d) to be %v; got %v", specIndex, spec.x, spec.y, spec.exp, got)
+			}
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		specs := []struct {
+			bgrt   *table.SDTHeader
+			expErr *kernel.Error
+		}{
+			{nil, errNoBootImage},
+			{genBGRT(1, 0x0, 0, imageAddr, 0, 0), errNoBootImage},
+			{genBGRT(2, 0x1, 0, imageAddr, 0, 0), errUnsupportedBootImage},
+			{genBGRT(1, 0x3, 0, imageAddr, 0, 0), errUnsupportedBootImage},
+			{genBGRT(1, 0x1, 1, imageAddr, 0, 0), errUnsupportedBootImage},
+			{genBGRT(1, 0x1, 0, imageAddr+1, 0, 0), errUnsupportedBootImage},
+		}
+
+		for specIndex, spec := range specs {
+			activeDriver = &acpiDriver{tableMap: map[string]*table.SDTHeader{}}
+			if spec.bgrt != nil {
+				activeDriver.tableMap["BGRT"] = spec.bgrt
+			}
+
+			if _, err := FirmwareBootImage(); err != spec.expErr {
+				t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			}
+		}
+
+		activeDriver.tableMap["BGRT"] = genBGRT(1, 0x1, 0, imageAddr, 0, 0)
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapRegionFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+
+		if _, err := FirmwareBootImage(); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestDecodeBitmap(t *testing.T) {
+	pixels := []byte{
+		0x01, 0x02, 0x03, 0x04,
+		0x05, 0x06, 0x07, 0x08,
+	}
+
+	t.Run("top-down 32bpp", func(t *testing.T) {
+		img, err := decodeBitmap(genBitmap(1, -2, 32, pixels))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if exp, got := (color.RGBA{R: 3, G: 2, B: 1, A: 255}), img.At(0, 0); got != exp {
+			t.Errorf("expected pixel at (0, 0) to be %v; got %v", exp, got)
+		}
+
+		if exp, got := (color.RGBA{R: 7, G: 6, B: 5, A: 255}), img.At(0, 1); got != exp {
+			t.Errorf("expected pixel at (0, 1) to be %v; got %v", exp, got)
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		specs := []struct {
+			data   []byte
+			expErr *kernel.Error
+		}{
+			{genBitmap(1, 2, 32, pixels)[:bmpHeaderLen-1], errMalformedBootImage},
+			{genBitmap(1, 2, 16, pixels), errUnsupportedBootImage},
+			{genBitmap(0, 2, 32, pixels), errMalformedBootImage},
+			{genBitmap(1, 0, 32, pixels), errMalformedBootImage},
+			{genBitmap(2, 2, 32, pixels), errMalformedBootImage},
+		}
+
+		for specIndex, spec := range specs {
+			if _, err := decodeBitmap(spec.data); err != spec.expErr {
+				t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			}
+		}
+	})
+}
+
+// genBGRT returns a BGRT table with the specified contents.
+func genBGRT(version uint16, status, imageType uint8, imageAddr uint64, offsetX, offsetY uint32) *table.SDTHeader {
+	data := make([]byte, 56)
+	copy(data, "BGRT")
+	copy(data[36:], amltest.LE(uint64(version), 2))
+	data[38], data[39] = status, imageType
+	copy(data[40:], amltest.LE(imageAddr, 8))
+	copy(data[48:], amltest.LE(uint64(offsetX), 4))
+	copy(data[52:], amltest.LE(uint64(offsetY), 4))
+
+	header := (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	header.Length = uint32(len(data))
+	return header
+}
+
+// genBitmap returns a BMP file containing the specified pixel data.
+func genBitmap(width, height int32, bpp uint16, pixels []byte) []byte {
+	data := make([]byte, bmpHeaderLen+len(pixels))
+	copy(data, bmpSignature)
+	copy(data[2:], amltest.LE(uint64(len(data)), 4))
+	copy(data[10:], amltest.LE(bmpHeaderLen, 4))
+	copy(data[14:], amltest.LE(bmpInfoHeaderLen, 4))
+	copy(data[18:], amltest.LE(uint64(uint32(width)), 4))
+	copy(data[22:], amltest.LE(uint64(uint32(height)), 4))
+	copy(data[26:], amltest.LE(1, 2))
+	copy(data[28:], amltest.LE(uint64(bpp), 2))
+	copy(data[bmpHeaderLen:], pixels)
+	return data
+}
@@ -0,0 +1,47 @@
+package acpi
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kshell"
+	"io"
+)
+
+var (
+	errInvalidArgs       = &kernel.Error{Module: "acpi", Message: "invalid arguments", Code: kernel.ErrCodeInvalidArgument}
+	errNamespaceNotFound = &kernel.Error{Module: "acpi", Message: "no such namespace path", Code: kernel.ErrCodeNotFound}
+)
+
+// cmdNamespace implements the "acpi-ns" kshell command. When invoked without
+// arguments it dumps the entire ACPI namespace; otherwise it only dumps the
+// scope at the specified path.
+func cmdNamespace(w io.Writer, args []string) *kernel.Error {
+	if len(args) > 1 {
+		return errInvalidArgs
+	}
+
+	if activeNS == nil {
+		return errNoInterpreter
+	}
+
+	if len(args) == 0 {
+		activeNS.Dump(w)
+		return nil
+	}
+
+	scope := activeNS.Lookup(nil, args[0])
+	if scope == nil {
+		return errNamespaceNotFound
+	}
+
+	activeNS.DumpScope(w, scope)
+	return nil
+}
+
+func init() {
+	kshell.RegisterCommand(&kshell.Command{
+		Name:  "acpi-ns",
+		Usage: "[path]",
+		Help:  "dump the ACPI namespace or the scope at the specified path",
+		Fn:    cmdNamespace,
+	})
+}
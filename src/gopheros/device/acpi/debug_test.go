@@ -0,0 +1,55 @@
+package acpi
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestCmdNamespace(t *testing.T) {
+	defer restorePowerHW()
+
+	var buf bytes.Buffer
+	if err := cmdNamespace(&buf, nil); err != errNoInterpreter {
+		t.Fatalf("expected to get errNoInterpreter; got %v", err)
+	}
+
+	// Device(DEV0) { Name(_HID, 0x2a) }
+	AttachInterpreter(vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x82}, []byte{
+		'D', 'E', 'V', '0',
+		0x08, '_', 'H', 'I', 'D', 0x0a, 0x2a,
+	})))
+
+	specs := []struct {
+		args   []string
+		expErr *kernel.Error
+		expOut string
+	}{
+		{[]string{`\DEV0`}, nil, "DEV0 Device\n  _HID Name         = 0x2a\n"},
+		{[]string{`\FOO_`}, errNamespaceNotFound, ""},
+		{[]string{"a", "b"}, errInvalidArgs, ""},
+	}
+
+	for specIndex, spec := range specs {
+		buf.Reset()
+		if err := cmdNamespace(&buf, spec.args); err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if got := buf.String(); got != spec.expOut {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, spec.expOut, got)
+		}
+	}
+
+	buf.Reset()
+	if err := cmdNamespace(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Contains(buf.Bytes(), []byte("\n  DEV0 Device\n")) {
+		t.Errorf("expected full dump to include DEV0; got:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,255 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/battery"
+	"gopheros/device/acpi/ec"
+	"gopheros/device/acpi/memhotplug"
+	"gopheros/device/acpi/processor"
+	"gopheros/device/acpi/thermal"
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+const (
+	// thermalPollUnit is the number of nanoseconds in the unit (tenths of
+	// a second) used by the thermal zone polling interval.
+	thermalPollUnit = uint64(100000000)
+
+	// maxCStateLatency is the maximum exit latency (in microseconds) of the
+	// C-states used by the idle loop. The ACPI specification treats C3
+	// states with a higher latency as unsupported.
+	maxCStateLatency = uint64(1000)
+)
+
+var (
+	errNoThermalZones  = &kernel.Error{Module: "acpi", Message: "no thermal zones defined", Code: kernel.ErrCodeNotFound}
+	errNoPowerSupplies = &kernel.Error{Module: "acpi", Message: "no batteries or AC adapters defined", Code: kernel.ErrCodeNotFound}
+	errNoMemoryDevices = &kernel.Error{Module: "acpi", Message: "no memory devices defined", Code: kernel.ErrCodeNotFound}
+
+	nanosecondsFn   = clock.Nanoseconds
+	newFreqDriverFn = processor.NewFreqDriver
+
+	// deviceInitFns lists the functions that initialize the drivers for
+	// the devices defined in the ACPI namespace in the order that they are
+	// invoked. The embedded controller must be installed first as the
+	// methods evaluated by the other drivers frequently access fields in
+	// EC-backed operation regions.
+	deviceInitFns = []struct {
+		name string
+		fn   func(io.Writer, *aml.VM, *aml.Namespace) *kernel.Error
+	}{
+		{"embedded controller", initEmbeddedController},
+		{"thermal zones", initThermalZones},
+		{"processor performance control", initCPUFreq},
+		{"processor power control", initCPUIdle},
+		{"power supplies", initPowerSupplies},
+		{"memory hotplug", initMemoryHotplug},
+	}
+
+	// activeEC is the embedded controller that handles accesses to the
+	// EmbeddedControl address space.
+	activeEC *ec.Controller
+
+	// activeThermal tracks the temperature of the thermal zones and the
+	// time (in nanoseconds) when the zones were last polled.
+	activeThermal   *thermal.Monitor
+	lastThermalPoll uint64
+
+	// activeFreqDriver manages the P-states of the processors.
+	activeFreqDriver *processor.FreqDriver
+
+	// activeIdleDriver places the processor in a low-power C-state when
+	// the kernel is idle.
+	activeIdleDriver *processor.IdleDriver
+
+	// activeMemDevices contains the memory devices that support hotplug.
+	activeMemDevices []*memhotplug.Device
+)
+
+// initDevices invokes the functions in deviceInitFns and reports any errors
+// to w.
+func initDevices(w io.Writer, vm *aml.VM, ns *aml.Namespace) {
+	for _, entry := range deviceInitFns {
+		if err := entry.fn(w, vm, ns); err != nil {
+			kfmt.Fprintf(w, "%s not available: %s\n", entry.name, err.Error())
+		}
+	}
+}
+
+// initEmbeddedController locates the embedded controller, installs it as the
+// handler for the EmbeddedControl address space (invoking its _REG method)
+// and routes its GPE to the event dispatcher. It must run before the _STA and
+// _INI methods of any other device are evaluated as these frequently access
+// fields in EC-backed operation regions.
+func initEmbeddedController(w io.Writer, vm *aml.VM, ns *aml.Namespace) *kernel.Error {
+	ctrl, err := ec.Find(w, vm, ns)
+	if err != nil {
+		return err
+	}
+
+	// Query events are not serviced if ACPI events are not available
+	var dispatcher ec.GPEDispatcher
+	if activeDispatcher != nil {
+		dispatcher = activeDispatcher
+	}
+
+	if err = ctrl.Install(dispatcher); err != nil {
+		return err
+	}
+
+	activeEC = ctrl
+	return nil
+}
+
+// EmbeddedController returns the embedded controller or nil if the platform
+// does not implement one.
+func EmbeddedController() *ec.Controller {
+	return activeEC
+}
+
+// initThermalZones starts monitoring the thermal zones defined in the
+// namespace. Temperature change notifications are delivered by PollEvents
+// while zones that must be polled are updated by pollThermalZones which is
+// registered as an idle loop poller.
+func initThermalZones(w io.Writer, vm *aml.VM, ns *aml.Namespace) *kernel.Error {
+	monitor := thermal.NewMonitor(w, vm, ns, thermalTripHandler{})
+	if len(monitor.Zones()) == 0 {
+		return errNoThermalZones
+	}
+
+	activeThermal, lastThermalPoll = monitor, nanosecondsFn()
+	registerPollerFn(pollThermalZones)
+	return nil
+}
+
+// pollThermalZones updates the thermal zones once the polling interval
+// requested by the firmware has elapsed. If no clock source is available or
+// none of the zones needs to be polled, the zones are only updated when the
+// firmware sends a temperature change notification.
+func pollThermalZones() {
+	interval := activeThermal.PollInterval() * thermalPollUnit
+	now := nanosecondsFn()
+	if interval == 0 || now == 0 || now-lastThermalPoll < interval {
+		return
+	}
+
+	lastThermalPoll = now
+	activeThermal.Poll()
+}
+
+// ThermalMonitor returns the monitor for the thermal zones defined by the
+// platform or nil if the platform does not define any thermal zones.
+func ThermalMonitor() *thermal.Monitor {
+	return activeThermal
+}
+
+// thermalTripHandler implements thermal.TripHandler. Reaching a critical or
+// hot trip point triggers an orderly shutdown as the kernel does not support
+// hibernation. The remaining trip points are reported to the console.
+type thermalTripHandler struct{}
+
+// Trip implements thermal.TripHandler.
+func (thermalTripHandler) Trip(zone *thermal.Zone, trip thermal.TripPoint, tripped bool) {
+	if !tripped {
+		kfmt.Printf("[acpi] %s: temperature dropped below the %s trip point\n", zone.Path(), trip.Type.String())
+		return
+	}
+
+	switch trip.Type {
+	case thermal.TripCritical, thermal.TripHot:
+		orderlyShutdown(zone.Path() + ": " + trip.Type.String() + " temperature reached")
+	default:
+		kfmt.Printf("[acpi] %s: %s trip point reached (%d dC)\n", zone.Path(), trip.Type.String(), zone.Temperature.DeciCelsius())
+	}
+}
+
+// initCPUFreq sets up P-state control for the processors that support it.
+// As the kernel does not track the processor load, the performance governor
+// is used and each processor is switched to the highest P-state permitted by
+// the platform. Changes to the platform limit are tracked via notifications.
+func initCPUFreq(w io.Writer, vm *aml.VM, ns *aml.Namespace) *kernel.Error {
+	drv, err := newFreqDriverFn(w, vm, ns, processor.PerformanceGovernor{})
+	if err != nil {
+		return err
+	}
+
+	for cpuIndex, perf := range drv.CPUs() {
+		if err = drv.Update(cpuIndex, 100); err != nil {
+			kfmt.Fprintf(w, "[acpi] %s: unable to set P-state: %s\n", perf.Node.Path(), err.Error())
+		}
+	}
+
+	activeFreqDriver = drv
+	return nil
+}
+
+// CPUFreqDriver returns the driver that manages the processor P-states or nil
+// if the processors do not support performance control.
+func CPUFreqDriver() *processor.FreqDriver {
+	return activeFreqDriver
+}
+
+// initCPUIdle installs a kernel idle handler that places the processor in the
+// deepest C-state whose exit latency does not exceed maxCStateLatency.
+func initCPUIdle(w io.Writer, vm *aml.VM, ns *aml.Namespace) *kernel.Error {
+	drv, err := processor.NewIdleDriver(w, vm, ns, maxCStateLatency)
+	if err != nil {
+		return err
+	}
+
+	drv.Install()
+	activeIdleDriver = drv
+	return nil
+}
+
+// CPUIdleDriver returns the driver that manages the processor C-states or nil
+// if the processors do not support power control.
+func CPUIdleDriver() *processor.IdleDriver {
+	return activeIdleDriver
+}
+
+// initPowerSupplies registers the batteries and AC adapters defined in the
+// namespace with the power package. Their status change notifications are
+// delivered by PollEvents.
+func initPowerSupplies(w io.Writer, vm *aml.VM, ns *aml.Namespace) *kernel.Error {
+	supplies, err := battery.Probe(w, vm, ns)
+	if err != nil {
+		return err
+	}
+
+	if len(supplies) == 0 {
+		return errNoPowerSupplies
+	}
+
+	for _, supply := range supplies {
+		kfmt.Fprintf(w, "power supply: %s\n", supply.Name())
+	}
+
+	return nil
+}
+
+// initMemoryHotplug adds the memory of the memory devices that are present to
+// the physical memory manager and installs notify handlers for adding or
+// removing device memory at runtime. The device check and eject request
+// notifications sent by the firmware are delivered by PollEvents.
+func initMemoryHotplug(w io.Writer, vm *aml.VM, ns *aml.Namespace) *kernel.Error {
+	devices, err := memhotplug.Probe(w, vm, ns)
+	if err != nil {
+		return err
+	}
+
+	if len(devices) == 0 {
+		return errNoMemoryDevices
+	}
+
+	activeMemDevices = devices
+	return nil
+}
+
+// MemoryDevices returns the memory devices that support hotplug.
+func MemoryDevices() []*memhotplug.Device {
+	return activeMemDevices
+}
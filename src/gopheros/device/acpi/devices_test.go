@@ -0,0 +1,471 @@
+package acpi
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/device/acpi/event"
+	"gopheros/device/acpi/processor"
+	"gopheros/device/acpi/table"
+	"gopheros/device/acpi/thermal"
+	"gopheros/device/power"
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/idle"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestInitDevices(t *testing.T) {
+	defer func(orig []struct {
+		name string
+		fn   func(io.Writer, *aml.VM, *aml.Namespace) *kernel.Error
+	}) {
+		deviceInitFns = orig
+	}(deviceInitFns)
+
+	var calls []string
+	deviceInitFns = []struct {
+		name string
+		fn   func(io.Writer, *aml.VM, *aml.Namespace) *kernel.Error
+	}{
+		{"foo", func(_ io.Writer, _ *aml.VM, _ *aml.Namespace) *kernel.Error {
+			calls = append(calls, "foo")
+			return &kernel.Error{Module: "test", Message: "probe failed"}
+		}},
+		{"bar", func(_ io.Writer, _ *aml.VM, _ *aml.Namespace) *kernel.Error {
+			calls = append(calls, "bar")
+			return nil
+		}},
+	}
+
+	var buf bytes.Buffer
+	initDevices(&buf, nil, nil)
+
+	if len(calls) != 2 || calls[0] != "foo" || calls[1] != "bar" {
+		t.Fatalf("expected all device init functions to be invoked in order; got %v", calls)
+	}
+
+	if exp := "foo not available: probe failed\n"; buf.String() != exp {
+		t.Fatalf("expected output to be %q; got %q", exp, buf.String())
+	}
+}
+
+func TestInitEmbeddedController(t *testing.T) {
+	defer func() {
+		activeDispatcher, activeEC = nil, nil
+	}()
+
+	ecPayload := amltest.Pkg([]byte{0x10}, amltest.Concat(
+		[]byte{'\\', '_', 'S', 'B', '_'},
+		amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'E', 'C', '0', '_'},
+			// Name(_HID, EISAID("PNP0C09"))
+			[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x09},
+			// Name(_CRS, ResourceTemplate() {
+			//   IO(Decode16, 0x62, 0x62, 0, 1)
+			//   IO(Decode16, 0x66, 0x66, 0, 1)
+			// })
+			[]byte{0x08, '_', 'C', 'R', 'S'}, amltest.Pkg([]byte{0x11}, []byte{
+				0x0a, 0x12,
+				0x47, 0x01, 0x62, 0x00, 0x62, 0x00, 0x00, 0x01,
+				0x47, 0x01, 0x66, 0x00, 0x66, 0x00, 0x00, 0x01,
+				0x79, 0x00,
+			}),
+			// Name(_GPE, 0x17)
+			[]byte{0x08, '_', 'G', 'P', 'E', 0x0a, 0x17},
+			// Name(REGC, Zero)
+			[]byte{0x08, 'R', 'E', 'G', 'C', 0x00},
+			// Method(_REG, 2) { Store(Arg1, REGC) }
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'R', 'E', 'G', 0x02, 0x70, 0x69, 'R', 'E', 'G', 'C'}),
+		)),
+	))
+
+	t.Run("no EC", func(t *testing.T) {
+		vm, ns := vmtest.ForPayload(t, []byte{0x08, 'F', 'O', 'O', '_', 0x00})
+		if err := initEmbeddedController(ioutil.Discard, vm, ns); err == nil {
+			t.Fatal("expected to get an error")
+		}
+
+		if EmbeddedController() != nil {
+			t.Fatal("expected EmbeddedController to return nil")
+		}
+	})
+
+	t.Run("without event dispatcher", func(t *testing.T) {
+		vm, ns := vmtest.ForPayload(t, ecPayload)
+		if err := initEmbeddedController(ioutil.Discard, vm, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := `\_SB_.EC0_`; EmbeddedController() == nil || EmbeddedController().Path() != exp {
+			t.Fatalf("expected EmbeddedController to return the controller at %q", exp)
+		}
+
+		if got, _ := vm.Evaluate(`\_SB.EC0.REGC`); got != uint64(1) {
+			t.Fatalf("expected _REG to be invoked with the connect flag; REGC is %v", got)
+		}
+	})
+
+	t.Run("GPE routing error", func(t *testing.T) {
+		activeEC = nil
+		vm, ns := vmtest.ForPayload(t, ecPayload)
+
+		// The dispatcher does not implement any GPE blocks so the EC
+		// GPE cannot be routed to it.
+		dispatcher, err := event.NewDispatcher(ioutil.Discard, vm, ns, &table.FADT{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		activeDispatcher = dispatcher
+
+		if err := initEmbeddedController(ioutil.Discard, vm, ns); err == nil {
+			t.Fatal("expected to get an error")
+		}
+
+		if EmbeddedController() != nil {
+			t.Fatal("expected EmbeddedController to return nil")
+		}
+	})
+}
+
+func TestInitThermalZones(t *testing.T) {
+	defer func() {
+		registerPollerFn = idle.RegisterPoller
+		nanosecondsFn = clock.Nanoseconds
+		shutdownFn = Shutdown
+		activeThermal, lastThermalPoll = nil, 0
+	}()
+
+	t.Run("no thermal zones", func(t *testing.T) {
+		registerPollerFn = func(_ func()) {
+			t.Fatal("unexpected call to RegisterPoller")
+		}
+
+		vm, ns := vmtest.ForPayload(t, []byte{0x08, 'F', 'O', 'O', '_', 0x00})
+		if err := initThermalZones(ioutil.Discard, vm, ns); err != errNoThermalZones {
+			t.Fatalf("expected to get errNoThermalZones; got %v", err)
+		}
+
+		if ThermalMonitor() != nil {
+			t.Fatal("expected ThermalMonitor to return nil")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		vm, ns := vmtest.ForPayload(t, amltest.Concat(
+			// ThermalZone(TZ0) {
+			amltest.Pkg([]byte{0x5b, 0x85}, amltest.Concat(
+				[]byte{'T', 'Z', '0', '_'},
+				// Name(TCNT, Zero)
+				// Name(TMPV, 2732)
+				[]byte{0x08, 'T', 'C', 'N', 'T', 0x00},
+				[]byte{0x08, 'T', 'M', 'P', 'V', 0x0b, 0xac, 0x0a},
+				// Method(_TMP) { Increment(TCNT) Return(TMPV) }
+				amltest.Pkg([]byte{0x14}, []byte{'_', 'T', 'M', 'P', 0x00, 0x75, 'T', 'C', 'N', 'T', 0xa4, 'T', 'M', 'P', 'V'}),
+				// Method(SETT, 1) { Store(Arg0, TMPV) }
+				amltest.Pkg([]byte{0x14}, []byte{'S', 'E', 'T', 'T', 0x01, 0x70, 0x68, 'T', 'M', 'P', 'V'}),
+				// Name(_CRT, 3732)
+				[]byte{0x08, '_', 'C', 'R', 'T', 0x0b, 0x94, 0x0e},
+				// Name(_TZP, 50)
+				[]byte{0x08, '_', 'T', 'Z', 'P', 0x0a, 0x32},
+			)),
+		))
+
+		var (
+			now       uint64 = 1
+			poller    func()
+			shutdowns int
+		)
+		nanosecondsFn = func() uint64 { return now }
+		registerPollerFn = func(fn func()) { poller = fn }
+		shutdownFn = func() *kernel.Error {
+			shutdowns++
+			return nil
+		}
+
+		if err := initThermalZones(ioutil.Discard, vm, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		if ThermalMonitor() == nil || len(ThermalMonitor().Zones()) != 1 {
+			t.Fatal("expected ThermalMonitor to track the defined thermal zone")
+		}
+
+		if poller == nil {
+			t.Fatal("expected the thermal zone poller to be registered")
+		}
+
+		specs := []struct {
+			now          uint64
+			expEvalCount uint64
+		}{
+			// the zone is evaluated once while creating the monitor
+			{1, 1},
+			// polling interval has not elapsed
+			{1 + 49*thermalPollUnit, 1},
+			{1 + 50*thermalPollUnit, 2},
+			// no clock source
+			{0, 2},
+		}
+
+		for specIndex, spec := range specs {
+			now = spec.now
+			poller()
+
+			if got, _ := vm.Evaluate(`\TZ0.TCNT`); got != spec.expEvalCount {
+				t.Errorf("[spec %d] expected _TMP to be evaluated %d times; got %v", specIndex, spec.expEvalCount, got)
+			}
+		}
+
+		// Reaching the critical trip point should shut the system down
+		if _, err := vm.Evaluate(`\TZ0.SETT`, 3800); err != nil {
+			t.Fatal(err)
+		}
+
+		now = 1 + 100*thermalPollUnit
+		poller()
+
+		if shutdowns != 1 {
+			t.Fatalf("expected reaching the critical trip point to shut down the system; got %d shutdown calls", shutdowns)
+		}
+	})
+}
+
+func TestThermalTripHandler(t *testing.T) {
+	defer func() {
+		shutdownFn = Shutdown
+	}()
+
+	var shutdowns int
+	shutdownFn = func() *kernel.Error {
+		shutdowns++
+		return nil
+	}
+
+	_, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x85}, []byte{'T', 'Z', '0', '_'}))
+	zone := &thermal.Zone{Node: ns.Lookup(nil, `\TZ0_`)}
+	if zone.Node == nil {
+		t.Fatal("unable to locate test thermal zone")
+	}
+
+	specs := []struct {
+		typ          thermal.TripType
+		tripped      bool
+		expShutdowns int
+	}{
+		{thermal.TripCritical, true, 1},
+		{thermal.TripHot, true, 1},
+		{thermal.TripPassive, true, 0},
+		{thermal.TripActive, true, 0},
+		{thermal.TripCritical, false, 0},
+	}
+
+	for specIndex, spec := range specs {
+		shutdowns = 0
+		thermalTripHandler{}.Trip(zone, thermal.TripPoint{Type: spec.typ}, spec.tripped)
+
+		if shutdowns != spec.expShutdowns {
+			t.Errorf("[spec %d] expected %d shutdown calls for tripped %s trip point (%t); got %d", specIndex, spec.expShutdowns, spec.typ, spec.tripped, shutdowns)
+		}
+	}
+}
+
+func TestInitCPUFreq(t *testing.T) {
+	defer func() {
+		newFreqDriverFn = processor.NewFreqDriver
+		activeFreqDriver = nil
+	}()
+
+	t.Run("no performance controls", func(t *testing.T) {
+		// Processor(CPU0, 0, 0, 0) {}
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x83}, []byte{'C', 'P', 'U', '0', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}))
+		if err := initCPUFreq(ioutil.Discard, vm, ns); err == nil {
+			t.Fatal("expected to get an error")
+		}
+
+		if CPUFreqDriver() != nil {
+			t.Fatal("expected CPUFreqDriver to return nil")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		drv := &processor.FreqDriver{}
+		newFreqDriverFn = func(_ io.Writer, _ *aml.VM, _ *aml.Namespace, governor processor.Governor) (*processor.FreqDriver, *kernel.Error) {
+			if _, ok := governor.(processor.PerformanceGovernor); !ok {
+				t.Errorf("expected the performance governor to be used; got %T", governor)
+			}
+			return drv, nil
+		}
+
+		if err := initCPUFreq(ioutil.Discard, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if CPUFreqDriver() != drv {
+			t.Fatal("expected CPUFreqDriver to return the active driver")
+		}
+	})
+}
+
+func TestInitCPUIdle(t *testing.T) {
+	defer func() {
+		idle.SetHandler(nil)
+		activeIdleDriver = nil
+	}()
+
+	t.Run("no C-states", func(t *testing.T) {
+		// Processor(CPU0, 0, 0, 0) {}
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x83}, []byte{'C', 'P', 'U', '0', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}))
+		if err := initCPUIdle(ioutil.Discard, vm, ns); err == nil {
+			t.Fatal("expected to get an error")
+		}
+
+		if CPUIdleDriver() != nil {
+			t.Fatal("expected CPUIdleDriver to return nil")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		// Processor(CPU0, 0, 0, 0) {
+		//   Name(_CST, Package() {
+		//     1,
+		//     Package() { ResourceTemplate() { Register(FFixedHW, 0, 0, 0) }, 1, 1, 1000 }
+		//   })
+		// }
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x83}, amltest.Concat(
+			[]byte{'C', 'P', 'U', '0', 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			[]byte{0x08, '_', 'C', 'S', 'T'},
+			amltest.Pkg([]byte{0x12}, amltest.Concat(
+				[]byte{0x02, 0x01},
+				amltest.Pkg([]byte{0x12}, amltest.Concat(
+					[]byte{0x04},
+					amltest.Pkg([]byte{0x11}, []byte{
+						0x0a, 0x11,
+						0x82, 0x0c, 0x00, 0x7f, 0x00, 0x00, 0x00,
+						0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+						0x79, 0x00,
+					}),
+					[]byte{0x01, 0x01, 0x0b, 0xe8, 0x03},
+				)),
+			)),
+		)))
+
+		if err := initCPUIdle(ioutil.Discard, vm, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		drv := CPUIdleDriver()
+		if drv == nil {
+			t.Fatal("expected CPUIdleDriver to return the active driver")
+		}
+
+		if got := len(drv.Power().CStates); got != 1 {
+			t.Fatalf("expected the driver to discover 1 C-state; got %d", got)
+		}
+	})
+}
+
+func TestInitPowerSupplies(t *testing.T) {
+	t.Run("no power supplies", func(t *testing.T) {
+		vm, ns := vmtest.ForPayload(t, []byte{0x08, 'F', 'O', 'O', '_', 0x00})
+		if err := initPowerSupplies(ioutil.Discard, vm, ns); err != errNoPowerSupplies {
+			t.Fatalf("expected to get errNoPowerSupplies; got %v", err)
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		// Device(AC0) {
+		//   Name(_HID, "ACPI0003")
+		//   Method(_PSR) { Return(One) }
+		// }
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'A', 'C', '0', '_'},
+			[]byte{0x08, '_', 'H', 'I', 'D', 0x0d, 'A', 'C', 'P', 'I', '0', '0', '0', '3', 0x00},
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'P', 'S', 'R', 0x00, 0xa4, 0x01}),
+		)))
+
+		var buf bytes.Buffer
+		if err := initPowerSupplies(&buf, vm, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		supplies := append([]power.Supply(nil), power.Supplies()...)
+		defer func() {
+			for _, supply := range supplies {
+				power.Unregister(supply)
+			}
+		}()
+
+		if len(supplies) != 1 || supplies[0].Type() != power.SupplyTypeMains {
+			t.Fatalf("expected the AC adapter to be registered with the power package; got %v", supplies)
+		}
+
+		if exp := "power supply: " + supplies[0].Name() + "\n"; buf.String() != exp {
+			t.Fatalf("expected output to be %q; got %q", exp, buf.String())
+		}
+	})
+}
+
+func TestInitMemoryHotplug(t *testing.T) {
+	defer func() {
+		activeDispatcher, activeMemDevices = nil, nil
+	}()
+
+	t.Run("no memory devices", func(t *testing.T) {
+		vm, ns := vmtest.ForPayload(t, []byte{0x08, 'F', 'O', 'O', '_', 0x00})
+		if err := initMemoryHotplug(ioutil.Discard, vm, ns); err != errNoMemoryDevices {
+			t.Fatalf("expected to get errNoMemoryDevices; got %v", err)
+		}
+
+		if MemoryDevices() != nil {
+			t.Fatal("expected MemoryDevices to return nil")
+		}
+	})
+
+	t.Run("notifications are delivered via the event dispatcher", func(t *testing.T) {
+		vm, ns := vmtest.ForPayload(t, amltest.Concat(
+			// Device(MEM0) {
+			//   Name(_HID, EISAID("PNP0C80"))
+			//   Name(_STA, Zero)
+			//   Name(OSTE, 0xff)
+			//   Method(_OST, 3) { Store(Arg0, OSTE) }
+			// }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'M', 'E', 'M', '0'},
+				[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x80},
+				[]byte{0x08, '_', 'S', 'T', 'A', 0x00},
+				[]byte{0x08, 'O', 'S', 'T', 'E', 0x0a, 0xff},
+				amltest.Pkg([]byte{0x14}, []byte{'_', 'O', 'S', 'T', 0x03, 0x70, 0x68, 'O', 'S', 'T', 'E'}),
+			)),
+			// Method(TST0) { Notify(MEM0, 1) }
+			amltest.Pkg([]byte{0x14}, []byte{'T', 'S', 'T', '0', 0x00, 0x86, 'M', 'E', 'M', '0', 0x01}),
+		))
+
+		dispatcher, err := event.NewDispatcher(ioutil.Discard, vm, ns, &table.FADT{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		activeDispatcher = dispatcher
+
+		if err = initMemoryHotplug(ioutil.Discard, vm, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		if devices := MemoryDevices(); len(devices) != 1 || devices[0].Name() != `\MEM0` {
+			t.Fatalf("expected MemoryDevices to return the memory device at \\MEM0; got %v", devices)
+		}
+
+		if _, err = vm.Evaluate(`\TST0`); err != nil {
+			t.Fatal(err)
+		}
+
+		PollEvents()
+
+		if got, _ := vm.Evaluate(`\MEM0.OSTE`); got != uint64(aml.NotifyDeviceCheck) {
+			t.Fatalf("expected the device check notification to be processed by the memory device driver; _OST received event %v", got)
+		}
+	})
+}
@@ -0,0 +1,410 @@
+// Package ec implements a driver for the ACPI Embedded Controller (EC) and
+// provides the handler for the EmbeddedControl operation region address
+// space.
+package ec
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/device"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/device/acpi/event"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/sync"
+	"io"
+)
+
+var (
+	errNoEC          = &kernel.Error{Module: "acpi_ec", Message: "no embedded controller found", Code: kernel.ErrCodeNotFound}
+	errMissingPorts  = &kernel.Error{Module: "acpi_ec", Message: "embedded controller _CRS does not define the data and command ports", Code: kernel.ErrCodeCorrupted}
+	errTimeout       = &kernel.Error{Module: "acpi_ec", Message: "timeout waiting for embedded controller", Code: kernel.ErrCodeTimeout}
+	errBurstRejected = &kernel.Error{Module: "acpi_ec", Message: "embedded controller rejected burst mode request", Code: kernel.ErrCodeIO}
+	errNoGPE         = &kernel.Error{Module: "acpi_ec", Message: "embedded controller does not define a _GPE object", Code: kernel.ErrCodeNotSupported}
+
+	portReadByteFn  = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+
+	// pollLimit specifies the number of times that the status register is
+	// polled before giving up on an operation.
+	pollLimit = 100000
+)
+
+// The hardware ID used by embedded controllers.
+const hardwareID = "PNP0C09"
+
+// The bits of the EC status register.
+const (
+	statusOBF    uint8 = 1 << 0
+	statusIBF    uint8 = 1 << 1
+	statusCMD    uint8 = 1 << 3
+	statusBurst  uint8 = 1 << 4
+	statusSCIEvt uint8 = 1 << 5
+	statusSMIEvt uint8 = 1 << 6
+)
+
+// The commands supported by the EC.
+const (
+	cmdRead         uint8 = 0x80
+	cmdWrite        uint8 = 0x81
+	cmdBurstEnable  uint8 = 0x82
+	cmdBurstDisable uint8 = 0x83
+	cmdQuery        uint8 = 0x84
+
+	// The value returned by the EC to acknowledge a burst enable command.
+	burstAck uint8 = 0x90
+
+	// The maximum number of query events processed per GPE. It guards
+	// against misbehaving controllers that keep the SCI_EVT bit set.
+	maxQueriesPerGPE = 32
+
+	// The value that _REG receives to identify the EmbeddedControl space
+	// and to signal that the handler is connected.
+	regSpaceEC   = uint64(aml.RegionSpaceEmbeddedControl)
+	regConnected = uint64(1)
+)
+
+// GPEDispatcher is implemented by types (e.g. event.Dispatcher) that can route
+// a GPE to a handler.
+type GPEDispatcher interface {
+	InstallHandler(gpe uint32, trigger event.Trigger, handler event.Handler) *kernel.Error
+}
+
+// Controller drives an embedded controller using the EC_SC (command/status)
+// and EC_DATA ports specified by its _CRS object.
+type Controller struct {
+	errWriter io.Writer
+	vm        *aml.VM
+	node      *aml.NamespaceNode
+
+	dataPort uint16
+	cmdPort  uint16
+
+	// lock serializes the transactions with the controller.
+	lock sync.Mutex
+}
+
+// Find locates the first present embedded controller device (PNP0C09) in the
+// namespace and returns a Controller for it. Errors that occur while
+// evaluating query methods are reported to errWriter.
+func Find(errWriter io.Writer, vm *aml.VM, ns *aml.Namespace) (*Controller, *kernel.Error) {
+	devices, err := device.Enumerate(vm, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, dev := range devices {
+		if !dev.Matches(hardwareID) || !dev.Present() {
+			continue
+		}
+
+		return newController(errWriter, vm, dev.Node)
+	}
+
+	return nil, errNoEC
+}
+
+// newController creates a Controller for the EC device at node using the
+// I/O ports listed in its _CRS object. The first port is the data port and
+// the second port is the command/status port.
+func newController(errWriter io.Writer, vm *aml.VM, node *aml.NamespaceNode) (*Controller, *kernel.Error) {
+	crs := node.Child("_CRS")
+	if crs == nil {
+		return nil, errMissingPorts
+	}
+
+	val, err := vm.Evaluate(crs.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	template, ok := val.([]byte)
+	if !ok {
+		return nil, errMissingPorts
+	}
+
+	descriptors, err := resource.Decode(template)
+	if err != nil {
+		return nil, err
+	}
+
+	var ports []uint16
+	for _, desc := range descriptors {
+		switch typ := desc.(type) {
+		case *resource.IO:
+			ports = append(ports, typ.Min)
+		case *resource.FixedIO:
+			ports = append(ports, typ.Base)
+		}
+	}
+
+	if len(ports) < 2 {
+		return nil, errMissingPorts
+	}
+
+	return &Controller{
+		errWriter: errWriter,
+		vm:        vm,
+		node:      node,
+		dataPort:  ports[0],
+		cmdPort:   ports[1],
+	}, nil
+}
+
+// Path returns the namespace path of the EC device.
+func (c *Controller) Path() string {
+	return c.node.Path()
+}
+
+// GPE returns the GPE that the EC uses to signal query events.
+func (c *Controller) GPE() (uint32, *kernel.Error) {
+	gpeNode := c.node.Child("_GPE")
+	if gpeNode == nil {
+		return 0, errNoGPE
+	}
+
+	val, err := c.vm.Evaluate(gpeNode.Path())
+	if err != nil {
+		return 0, err
+	}
+
+	gpe, ok := val.(uint64)
+	if !ok {
+		return 0, errNoGPE
+	}
+
+	return uint32(gpe), nil
+}
+
+// Install registers the controller as the handler for the EmbeddedControl
+// address space, informs the firmware that the address space is available by
+// invoking the optional _REG method of the EC device and routes the EC GPE to
+// the controller's query event handler. If dispatcher is nil, query events
+// are not serviced.
+func (c *Controller) Install(dispatcher GPEDispatcher) *kernel.Error {
+	c.vm.RegisterRegionHandler(aml.RegionSpaceEmbeddedControl, c)
+
+	if reg := c.node.Child("_REG"); reg != nil {
+		if _, err := c.vm.Evaluate(reg.Path(), regSpaceEC, regConnected); err != nil {
+			return err
+		}
+	}
+
+	if dispatcher == nil {
+		return nil
+	}
+
+	gpe, err := c.GPE()
+	if err != nil {
+		return err
+	}
+
+	return dispatcher.InstallHandler(gpe, event.TriggerEdge, c.handleGPE)
+}
+
+// Read returns the contents of the EC register at addr.
+func (c *Controller) Read(addr uint8) (uint8, *kernel.Error) {
+	c.lock.Acquire()
+	defer c.lock.Release()
+
+	return c.read(addr)
+}
+
+// Write sets the contents of the EC register at addr to val.
+func (c *Controller) Write(addr, val uint8) *kernel.Error {
+	c.lock.Acquire()
+	defer c.lock.Release()
+
+	return c.write(addr, val)
+}
+
+// ReadRegion implements aml.RegionHandler. Accesses wider than 8 bits are
+// performed in burst mode as a sequence of byte reads.
+func (c *Controller) ReadRegion(region *aml.Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	c.lock.Acquire()
+	defer c.lock.Release()
+
+	addr := uint8(region.Offset + offset)
+	if width == 8 {
+		val, err := c.read(addr)
+		return uint64(val), err
+	}
+
+	if err := c.enableBurst(); err != nil {
+		return 0, err
+	}
+
+	var val uint64
+	for byteIndex := uint8(0); byteIndex < width>>3; byteIndex++ {
+		byteVal, err := c.read(addr + byteIndex)
+		if err != nil {
+			return 0, err
+		}
+		val |= uint64(byteVal) << (byteIndex << 3)
+	}
+
+	return val, c.disableBurst()
+}
+
+// WriteRegion implements aml.RegionHandler. Accesses wider than 8 bits are
+// performed in burst mode as a sequence of byte writes.
+func (c *Controller) WriteRegion(region *aml.Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	c.lock.Acquire()
+	defer c.lock.Release()
+
+	addr := uint8(region.Offset + offset)
+	if width == 8 {
+		return c.write(addr, uint8(val))
+	}
+
+	if err := c.enableBurst(); err != nil {
+		return err
+	}
+
+	for byteIndex := uint8(0); byteIndex < width>>3; byteIndex++ {
+		if err := c.write(addr+byteIndex, uint8(val>>(byteIndex<<3))); err != nil {
+			return err
+		}
+	}
+
+	return c.disableBurst()
+}
+
+// handleGPE services the query events pending in the EC by invoking the
+// matching _Qxx methods of the EC device.
+func (c *Controller) handleGPE(_ uint32) {
+	for i := 0; i < maxQueriesPerGPE && portReadByteFn(c.cmdPort)&statusSCIEvt != 0; i++ {
+		c.lock.Acquire()
+		query, err := c.query()
+		c.lock.Release()
+
+		switch {
+		case err != nil:
+			kfmt.Fprintf(c.errWriter, "[acpi_ec] query failed: %s\n", err.Error())
+			return
+		case query == 0:
+			return
+		}
+
+		method := c.node.Child(queryMethodName(query))
+		if method == nil {
+			continue
+		}
+
+		if _, err = c.vm.Evaluate(method.Path()); err != nil {
+			kfmt.Fprintf(c.errWriter, "[acpi_ec] error evaluating %s: %s\n", method.Path(), err.Error())
+		}
+	}
+}
+
+// queryMethodName returns the name of the _Qxx method for a query value.
+func queryMethodName(query uint8) string {
+	const hexDigits = "0123456789ABCDEF"
+	return string([]byte{'_', 'Q', hexDigits[query>>4], hexDigits[query&0xf]})
+}
+
+// read implements the RD_EC transaction.
+func (c *Controller) read(addr uint8) (uint8, *kernel.Error) {
+	if err := c.sendCommand(cmdRead); err != nil {
+		return 0, err
+	}
+
+	if err := c.sendData(addr); err != nil {
+		return 0, err
+	}
+
+	return c.receiveData()
+}
+
+// write implements the WR_EC transaction.
+func (c *Controller) write(addr, val uint8) *kernel.Error {
+	if err := c.sendCommand(cmdWrite); err != nil {
+		return err
+	}
+
+	if err := c.sendData(addr); err != nil {
+		return err
+	}
+
+	return c.sendData(val)
+}
+
+// query implements the QR_EC transaction and returns the pending query value
+// or 0 if no query events are pending.
+func (c *Controller) query() (uint8, *kernel.Error) {
+	if err := c.sendCommand(cmdQuery); err != nil {
+		return 0, err
+	}
+
+	return c.receiveData()
+}
+
+// enableBurst implements the BE_EC transaction which requests the EC to
+// dedicate itself to servicing the host until burst mode is disabled.
+func (c *Controller) enableBurst() *kernel.Error {
+	if err := c.sendCommand(cmdBurstEnable); err != nil {
+		return err
+	}
+
+	ack, err := c.receiveData()
+	if err != nil {
+		return err
+	}
+
+	if ack != burstAck {
+		return errBurstRejected
+	}
+
+	return nil
+}
+
+// disableBurst implements the BD_EC transaction.
+func (c *Controller) disableBurst() *kernel.Error {
+	if err := c.sendCommand(cmdBurstDisable); err != nil {
+		return err
+	}
+
+	return c.waitForStatus(statusIBF, 0)
+}
+
+// sendCommand writes cmd to the command port once the EC input buffer is
+// empty.
+func (c *Controller) sendCommand(cmd uint8) *kernel.Error {
+	if err := c.waitForStatus(statusIBF, 0); err != nil {
+		return err
+	}
+
+	portWriteByteFn(c.cmdPort, cmd)
+	return nil
+}
+
+// sendData writes val to the data port once the EC input buffer is empty.
+func (c *Controller) sendData(val uint8) *kernel.Error {
+	if err := c.waitForStatus(statusIBF, 0); err != nil {
+		return err
+	}
+
+	portWriteByteFn(c.dataPort, val)
+	return nil
+}
+
+// receiveData reads the data port once the EC output buffer is full.
+func (c *Controller) receiveData() (uint8, *kernel.Error) {
+	if err := c.waitForStatus(statusOBF, statusOBF); err != nil {
+		return 0, err
+	}
+
+	return portReadByteFn(c.dataPort), nil
+}
+
+// waitForStatus polls the status register until the bits specified by mask
+// are equal to exp.
+func (c *Controller) waitForStatus(mask, exp uint8) *kernel.Error {
+	for attempt := 0; attempt < pollLimit; attempt++ {
+		if portReadByteFn(c.cmdPort)&mask == exp {
+			return nil
+		}
+	}
+
+	return errTimeout
+}
@@ -0,0 +1,358 @@
+package ec
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/device/acpi/event"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+const (
+	testDataPort = uint16(0x62)
+	testCmdPort  = uint16(0x66)
+)
+
+func TestController(t *testing.T) {
+	defer restorePorts()
+	fake := newFakeEC()
+
+	vm, ns := vmtest.ForPayload(t, ecDevice(
+		// Name(REGC, Zero)
+		[]byte{0x08, 'R', 'E', 'G', 'C', 0x00},
+		// Method(_REG, 2) { Store(Arg1, REGC) }
+		amltest.Pkg([]byte{0x14}, []byte{'_', 'R', 'E', 'G', 0x02, 0x70, 0x69, 'R', 'E', 'G', 'C'}),
+		// OperationRegion(ECOR, EmbeddedControl, 0, 0xff)
+		[]byte{0x5b, 0x80, 'E', 'C', 'O', 'R', 0x03, 0x00, 0x0a, 0xff},
+		// Field(ECOR, ByteAcc, NoLock, Preserve) { Offset(0x10), TMP0, 8 }
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{'E', 'C', 'O', 'R', 0x01, 0x00, 0x40, 0x08, 'T', 'M', 'P', '0', 0x08}),
+		// Field(ECOR, WordAcc, NoLock, Preserve) { Offset(0x20), CNT0, 16 }
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{'E', 'C', 'O', 'R', 0x02, 0x00, 0x40, 0x10, 'C', 'N', 'T', '0', 0x10}),
+		// Name(QCNT, Zero)
+		[]byte{0x08, 'Q', 'C', 'N', 'T', 0x00},
+		// Method(_Q42) { Increment(QCNT) }
+		amltest.Pkg([]byte{0x14}, []byte{'_', 'Q', '4', '2', 0x00, 0x75, 'Q', 'C', 'N', 'T'}),
+	))
+
+	var errBuf bytes.Buffer
+	ec, err := Find(&errBuf, vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := `\_SB_.EC0_`; ec.Path() != exp {
+		t.Fatalf("expected EC path to be %q; got %q", exp, ec.Path())
+	}
+
+	if ec.dataPort != testDataPort || ec.cmdPort != testCmdPort {
+		t.Fatalf("expected EC ports to be 0x62/0x66; got 0x%x/0x%x", ec.dataPort, ec.cmdPort)
+	}
+
+	dispatcher := &fakeDispatcher{}
+	if err = ec.Install(dispatcher); err != nil {
+		t.Fatal(err)
+	}
+
+	if dispatcher.gpe != 0x17 || dispatcher.trigger != event.TriggerEdge || dispatcher.handler == nil {
+		t.Fatalf("expected EC to install an edge-triggered handler for GPE 0x17; got GPE 0x%x", dispatcher.gpe)
+	}
+
+	if got, _ := vm.Evaluate(`\_SB.EC0.REGC`); got != uint64(1) {
+		t.Fatalf("expected _REG to be invoked with the connect flag; REGC is %v", got)
+	}
+
+	t.Run("register access", func(t *testing.T) {
+		if err := ec.Write(0x05, 0xaa); err != nil {
+			t.Fatal(err)
+		}
+
+		if got, err := ec.Read(0x05); err != nil || got != 0xaa {
+			t.Fatalf("expected to read back 0xaa; got 0x%x (err: %v)", got, err)
+		}
+	})
+
+	t.Run("byte field", func(t *testing.T) {
+		fake.ram[0x10] = 0x2a
+		if got, err := vm.Evaluate(`\_SB.EC0.TMP0`); err != nil || got != uint64(0x2a) {
+			t.Fatalf("expected TMP0 to be 0x2a; got %v (err: %v)", got, err)
+		}
+
+		if fake.burstTransactions != 0 {
+			t.Fatalf("expected byte accesses not to use burst mode")
+		}
+	})
+
+	t.Run("word field", func(t *testing.T) {
+		fake.ram[0x20], fake.ram[0x21] = 0x34, 0x12
+		if got, err := vm.Evaluate(`\_SB.EC0.CNT0`); err != nil || got != uint64(0x1234) {
+			t.Fatalf("expected CNT0 to be 0x1234; got %v (err: %v)", got, err)
+		}
+
+		if err := ec.WriteRegion(&aml.Region{Space: aml.RegionSpaceEmbeddedControl, Length: 0xff}, 0x30, 16, 0xbeef); err != nil {
+			t.Fatal(err)
+		}
+
+		if fake.ram[0x30] != 0xef || fake.ram[0x31] != 0xbe {
+			t.Fatalf("expected word write to update two registers; got 0x%x 0x%x", fake.ram[0x30], fake.ram[0x31])
+		}
+
+		if fake.burstTransactions != 2 || fake.burst {
+			t.Fatalf("expected word accesses to use burst mode; got %d burst transactions (burst active: %t)", fake.burstTransactions, fake.burst)
+		}
+	})
+
+	t.Run("query events", func(t *testing.T) {
+		fake.queries = []uint8{0x42, 0x10, 0x42}
+		dispatcher.handler(0x17)
+
+		if got, _ := vm.Evaluate(`\_SB.EC0.QCNT`); got != uint64(2) {
+			t.Fatalf("expected _Q42 to be invoked twice; QCNT is %v", got)
+		}
+
+		if len(fake.queries) != 0 {
+			t.Fatalf("expected all queries to be consumed; %d remaining", len(fake.queries))
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		defer func(limit int) { pollLimit = limit }(pollLimit)
+		pollLimit = 10
+
+		fake.stuck = true
+		defer func() { fake.stuck = false }()
+
+		if _, err := ec.Read(0); err != errTimeout {
+			t.Fatalf("expected to get errTimeout; got %v", err)
+		}
+
+		fake.queries = []uint8{0x42}
+		dispatcher.handler(0x17)
+		if !strings.Contains(errBuf.String(), "query failed") {
+			t.Fatalf("expected query failure to be logged; got %q", errBuf.String())
+		}
+	})
+
+	t.Run("burst rejected", func(t *testing.T) {
+		fake.rejectBurst = true
+		defer func() { fake.rejectBurst = false }()
+
+		if _, err := vm.Evaluate(`\_SB.EC0.CNT0`); err != errBurstRejected {
+			t.Fatalf("expected to get errBurstRejected; got %v", err)
+		}
+	})
+}
+
+func TestFindErrors(t *testing.T) {
+	defer restorePorts()
+	newFakeEC()
+
+	specs := []struct {
+		payload []byte
+		expErr  *kernel.Error
+	}{
+		{nil, errNoEC},
+		// _CRS with a single port
+		{
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'E', 'C', '0', '_'},
+				[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x09},
+				[]byte{0x08, '_', 'C', 'R', 'S'}, amltest.Pkg([]byte{0x11}, []byte{0x0a, 0x0a, 0x47, 0x01, 0x62, 0x00, 0x62, 0x00, 0x00, 0x01, 0x79, 0x00}),
+			)),
+			errMissingPorts,
+		},
+		// no _CRS
+		{
+			amltest.Pkg([]byte{0x5b, 0x82}, []byte{'E', 'C', '0', '_', 0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x09}),
+			errMissingPorts,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, spec.payload)
+		if _, err := Find(ioutil.Discard, vm, ns); err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	// An EC without a _GPE object cannot service query events
+	vm, ns := vmtest.ForPayload(t, ecDevice())
+	ec, err := Find(ioutil.Discard, vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = ec.Install(&fakeDispatcher{}); err != errNoGPE {
+		t.Fatalf("expected to get errNoGPE; got %v", err)
+	}
+
+	if err = ec.Install(nil); err != nil {
+		t.Fatalf("unexpected error installing EC without a dispatcher: %v", err)
+	}
+}
+
+func TestQueryMethodName(t *testing.T) {
+	for query, exp := range map[uint8]string{0x01: "_Q01", 0x42: "_Q42", 0xaf: "_QAF"} {
+		if got := queryMethodName(query); got != exp {
+			t.Errorf("expected queryMethodName(0x%x) to return %q; got %q", query, exp, got)
+		}
+	}
+}
+
+// ecDevice returns the AML for Scope(\_SB) { Device(EC0) { ... } } with
+// _HID and _CRS objects and the supplied contents. If any contents are
+// specified, the device also defines a _GPE object.
+func ecDevice(contents ...[]byte) []byte {
+	body := amltest.Concat(
+		[]byte{'E', 'C', '0', '_'},
+		// Name(_HID, EISAID("PNP0C09"))
+		[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x09},
+		// Name(_CRS, ResourceTemplate() {
+		//   IO(Decode16, 0x62, 0x62, 0, 1)
+		//   IO(Decode16, 0x66, 0x66, 0, 1)
+		// })
+		[]byte{0x08, '_', 'C', 'R', 'S'}, amltest.Pkg([]byte{0x11}, []byte{
+			0x0a, 0x12,
+			0x47, 0x01, 0x62, 0x00, 0x62, 0x00, 0x00, 0x01,
+			0x47, 0x01, 0x66, 0x00, 0x66, 0x00, 0x00, 0x01,
+			0x79, 0x00,
+		}),
+	)
+
+	if len(contents) != 0 {
+		// Name(_GPE, 0x17)
+		body = amltest.Concat(body, []byte{0x08, '_', 'G', 'P', 'E', 0x0a, 0x17}, amltest.Concat(contents...))
+	}
+
+	return amltest.Pkg([]byte{0x10}, amltest.Concat(
+		[]byte{'\\', '_', 'S', 'B', '_'},
+		amltest.Pkg([]byte{0x5b, 0x82}, body),
+	))
+}
+
+type fakeDispatcher struct {
+	gpe     uint32
+	trigger event.Trigger
+	handler event.Handler
+}
+
+func (d *fakeDispatcher) InstallHandler(gpe uint32, trigger event.Trigger, handler event.Handler) *kernel.Error {
+	d.gpe, d.trigger, d.handler = gpe, trigger, handler
+	return nil
+}
+
+// fakeEC emulates the command/status and data ports of an embedded
+// controller.
+type fakeEC struct {
+	ram [256]uint8
+
+	// The command being processed and the operand bytes received so far.
+	cmd      uint8
+	operands []uint8
+
+	// output holds the byte that the host can read from the data port
+	// while OBF is set.
+	output    uint8
+	outputSet bool
+
+	burst             bool
+	burstTransactions int
+	rejectBurst       bool
+
+	// The pending query values. SCI_EVT is set while queries is not
+	// empty.
+	queries []uint8
+
+	// stuck causes the controller to never clear IBF.
+	stuck bool
+}
+
+func newFakeEC() *fakeEC {
+	fake := &fakeEC{}
+
+	portReadByteFn = func(port uint16) uint8 {
+		switch port {
+		case testCmdPort:
+			return fake.status()
+		case testDataPort:
+			fake.outputSet = false
+			return fake.output
+		}
+		return 0xff
+	}
+
+	portWriteByteFn = func(port uint16, val uint8) {
+		switch port {
+		case testCmdPort:
+			fake.command(val)
+		case testDataPort:
+			fake.data(val)
+		}
+	}
+
+	return fake
+}
+
+func restorePorts() {
+	portReadByteFn = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+}
+
+func (f *fakeEC) status() uint8 {
+	var status uint8
+	if f.outputSet {
+		status |= statusOBF
+	}
+	if f.stuck {
+		status |= statusIBF
+	}
+	if f.burst {
+		status |= statusBurst
+	}
+	if len(f.queries) != 0 {
+		status |= statusSCIEvt
+	}
+	return status
+}
+
+func (f *fakeEC) setOutput(val uint8) {
+	f.output, f.outputSet = val, true
+}
+
+func (f *fakeEC) command(cmd uint8) {
+	f.cmd, f.operands = cmd, nil
+
+	switch cmd {
+	case cmdBurstEnable:
+		if f.rejectBurst {
+			f.setOutput(0)
+			return
+		}
+		f.burst = true
+		f.burstTransactions++
+		f.setOutput(burstAck)
+	case cmdBurstDisable:
+		f.burst = false
+	case cmdQuery:
+		var query uint8
+		if len(f.queries) != 0 {
+			query, f.queries = f.queries[0], f.queries[1:]
+		}
+		f.setOutput(query)
+	}
+}
+
+func (f *fakeEC) data(val uint8) {
+	f.operands = append(f.operands, val)
+
+	switch {
+	case f.cmd == cmdRead && len(f.operands) == 1:
+		f.setOutput(f.ram[f.operands[0]])
+	case f.cmd == cmdWrite && len(f.operands) == 2:
+		f.ram[f.operands[0]] = f.operands[1]
+	}
+}
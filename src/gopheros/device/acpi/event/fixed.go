@@ -0,0 +1,269 @@
+package event
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	errUnsupportedPM1Block  = &kernel.Error{Module: "acpi_event", Message: "PM1 event blocks outside the SystemIO address space are not supported", Code: kernel.ErrCodeNotSupported}
+	errMalformedPM1Block    = &kernel.Error{Module: "acpi_event", Message: "PM1 event block length must be a multiple of 4", Code: kernel.ErrCodeCorrupted}
+	errNoSuchFixedEvent     = &kernel.Error{Module: "acpi_event", Message: "unknown fixed event", Code: kernel.ErrCodeNotFound}
+	errFixedEventNotPresent = &kernel.Error{Module: "acpi_event", Message: "fixed event is not implemented by the hardware", Code: kernel.ErrCodeNotSupported}
+)
+
+// FixedEvent identifies one of the events that are signaled through the
+// fixed PM1 status registers.
+type FixedEvent uint8
+
+// The supported fixed events.
+const (
+	FixedEventPMTimer FixedEvent = iota
+	FixedEventGlobalLock
+	FixedEventPowerButton
+	FixedEventSleepButton
+	FixedEventRTC
+
+	fixedEventCount
+)
+
+// String implements fmt.Stringer for FixedEvent.
+func (ev FixedEvent) String() string {
+	switch ev {
+	case FixedEventPMTimer:
+		return "PM timer"
+	case FixedEventGlobalLock:
+		return "global lock"
+	case FixedEventPowerButton:
+		return "power button"
+	case FixedEventSleepButton:
+		return "sleep button"
+	case FixedEventRTC:
+		return "RTC alarm"
+	default:
+		return "unknown"
+	}
+}
+
+// fixedEventBits maps each FixedEvent to the bit that corresponds to it in
+// the PM1 status and enable registers.
+var fixedEventBits = [fixedEventCount]uint16{
+	FixedEventPMTimer:     1 << 0,
+	FixedEventGlobalLock:  1 << 5,
+	FixedEventPowerButton: 1 << 8,
+	FixedEventSleepButton: 1 << 9,
+	FixedEventRTC:         1 << 10,
+}
+
+// The FADT flags that indicate that the power and sleep buttons are
+// implemented as control method devices instead of fixed events.
+const (
+	fadtFlagPowerButton = uint32(1 << 4)
+	fadtFlagSleepButton = uint32(1 << 5)
+)
+
+// FixedHandler is a function that services a fixed event. Handlers are
+// invoked by Dispatcher.RunPending and never from interrupt context.
+type FixedHandler func(event FixedEvent)
+
+// pm1Blocks describes the PM1a and (optional) PM1b event register blocks.
+// Each block consists of a 16-bit status register followed by a 16-bit enable
+// register. The OS must treat the contents of the two blocks as a single
+// logical register by OR-ing the values it reads from them and writing the
+// same value to both.
+type pm1Blocks struct {
+	// The I/O ports for the status registers of each block. An unused
+	// block has its port set to 0.
+	ports [2]uint16
+
+	// The offset of the enable register from the status register.
+	enableOffset uint16
+
+	// The bits of the fixed events that are not implemented by the
+	// hardware.
+	absentMask uint16
+
+	// handlers tracks the handlers installed for each fixed event.
+	handlers [fixedEventCount]FixedHandler
+
+	// pending tracks the fixed events that have been signaled but not yet
+	// serviced. It is updated atomically as it is modified from interrupt
+	// context.
+	pending uint32
+}
+
+// readStatus returns the combined contents of the PM1 status registers.
+func (pm1 *pm1Blocks) readStatus() uint16 {
+	var val uint16
+	for _, port := range pm1.ports {
+		if port != 0 {
+			val |= portReadWordFn(port)
+		}
+	}
+	return val
+}
+
+// readEnable returns the combined contents of the PM1 enable registers.
+func (pm1 *pm1Blocks) readEnable() uint16 {
+	var val uint16
+	for _, port := range pm1.ports {
+		if port != 0 {
+			val |= portReadWordFn(port + pm1.enableOffset)
+		}
+	}
+	return val
+}
+
+// writeStatus writes val to the PM1 status registers. Status bits are cleared
+// by writing a 1 to them.
+func (pm1 *pm1Blocks) writeStatus(val uint16) {
+	for _, port := range pm1.ports {
+		if port != 0 {
+			portWriteWordFn(port, val)
+		}
+	}
+}
+
+// writeEnable writes val to the PM1 enable registers.
+func (pm1 *pm1Blocks) writeEnable(val uint16) {
+	for _, port := range pm1.ports {
+		if port != 0 {
+			portWriteWordFn(port+pm1.enableOffset, val)
+		}
+	}
+}
+
+// initFixedEvents locates the PM1 event blocks defined by the FADT, disables
+// all fixed events and clears their status bits. Systems that do not define
+// a PM1a event block (e.g. hardware-reduced ACPI platforms) do not support
+// fixed events.
+func (d *Dispatcher) initFixedEvents(fadt *table.FADT) *kernel.Error {
+	pm1a, pm1b := pm1BlockAddresses(fadt)
+	if pm1a.Address == 0 || fadt.PM1EventLength == 0 {
+		return nil
+	}
+
+	if fadt.PM1EventLength&3 != 0 {
+		return errMalformedPM1Block
+	}
+
+	for blockIndex, addr := range []table.GenericAddress{pm1a, pm1b} {
+		if addr.Address == 0 {
+			continue
+		}
+
+		if addr.Space != table.AddressSpaceSysIO {
+			return errUnsupportedPM1Block
+		}
+
+		d.pm1.ports[blockIndex] = uint16(addr.Address)
+	}
+	d.pm1.enableOffset = uint16(fadt.PM1EventLength >> 1)
+
+	if fadt.Flags&fadtFlagPowerButton != 0 {
+		d.pm1.absentMask |= fixedEventBits[FixedEventPowerButton]
+	}
+	if fadt.Flags&fadtFlagSleepButton != 0 {
+		d.pm1.absentMask |= fixedEventBits[FixedEventSleepButton]
+	}
+
+	d.pm1.writeEnable(0)
+	d.pm1.writeStatus(0xffff)
+	return nil
+}
+
+// pm1BlockAddresses returns the addresses of the PM1a and PM1b event blocks.
+// The 64-bit FADT extensions are preferred if the table is large enough to
+// contain them.
+func pm1BlockAddresses(fadt *table.FADT) (table.GenericAddress, table.GenericAddress) {
+	pm1a := table.GenericAddress{Space: table.AddressSpaceSysIO, Address: uint64(fadt.PM1aEventBlock)}
+	pm1b := table.GenericAddress{Space: table.AddressSpaceSysIO, Address: uint64(fadt.PM1bEventBlock)}
+
+	if uintptr(fadt.Length) >= unsafe.Sizeof(*fadt) {
+		if fadt.Ext.PM1aEventBlock.Address != 0 {
+			pm1a = fadt.Ext.PM1aEventBlock
+		}
+		if fadt.Ext.PM1bEventBlock.Address != 0 {
+			pm1b = fadt.Ext.PM1bEventBlock
+		}
+	}
+
+	return pm1a, pm1b
+}
+
+// InstallFixedHandler registers a handler for the specified fixed event and
+// enables it. Passing a nil handler disables the event. An error is returned
+// if the hardware does not implement the event; for instance, platforms that
+// expose the power and sleep buttons as control method devices signal button
+// presses via Notify instead.
+func (d *Dispatcher) InstallFixedHandler(event FixedEvent, handler FixedHandler) *kernel.Error {
+	if event >= fixedEventCount {
+		return errNoSuchFixedEvent
+	}
+
+	bit := fixedEventBits[event]
+	if d.pm1.ports[0] == 0 || d.pm1.absentMask&bit != 0 {
+		return errFixedEventNotPresent
+	}
+
+	d.pm1.handlers[event] = handler
+	if handler == nil {
+		d.pm1.writeEnable(d.pm1.readEnable() &^ bit)
+		return nil
+	}
+
+	// Discard any stale event before unmasking it.
+	d.pm1.writeStatus(bit)
+	d.pm1.writeEnable(d.pm1.readEnable() | bit)
+	return nil
+}
+
+// handleFixedSCI acknowledges the enabled fixed events that have been raised
+// and queues them for processing. It returns true if at least one event was
+// queued.
+func (d *Dispatcher) handleFixedSCI() bool {
+	if d.pm1.ports[0] == 0 {
+		return false
+	}
+
+	active := d.pm1.readStatus() & d.pm1.readEnable()
+	if active == 0 {
+		return false
+	}
+
+	d.pm1.writeStatus(active)
+	for {
+		old := atomic.LoadUint32(&d.pm1.pending)
+		if atomic.CompareAndSwapUint32(&d.pm1.pending, old, old|uint32(active)) {
+			break
+		}
+	}
+
+	return true
+}
+
+// runPendingFixed invokes the handlers for the fixed events queued by
+// handleFixedSCI and returns the number of events that were serviced.
+func (d *Dispatcher) runPendingFixed() int {
+	var serviced int
+
+	pending := uint16(atomic.SwapUint32(&d.pm1.pending, 0))
+	for event, bit := range fixedEventBits {
+		if pending&bit == 0 {
+			continue
+		}
+
+		serviced++
+		if handler := d.pm1.handlers[event]; handler != nil {
+			handler(FixedEvent(event))
+			continue
+		}
+
+		kfmt.Fprintf(d.errWriter, "[acpi_event] no handler for fixed event: %s\n", FixedEvent(event).String())
+	}
+
+	return serviced
+}
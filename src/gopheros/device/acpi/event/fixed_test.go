@@ -0,0 +1,207 @@
+package event
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel/cpu"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestFixedEvents(t *testing.T) {
+	defer func() {
+		portReadByteFn = cpu.PortReadByte
+		portWriteByteFn = cpu.PortWriteByte
+		portReadWordFn = cpu.PortReadWord
+		portWriteWordFn = cpu.PortWriteWord
+	}()
+
+	// PM1a event block at 0x600; PM1b event block at 0x700
+	ports := newFakeGPEPorts(map[uint16]bool{0x600: true, 0x601: true, 0x700: true, 0x701: true})
+	for port, val := range map[uint16]uint8{0x600: 0xff, 0x601: 0xff, 0x602: 0x21, 0x603: 0x01, 0x703: 0x02} {
+		ports.regs[port] = val
+	}
+
+	vm, ns := vmtest.ForPayload(t, nil)
+	fadt := &table.FADT{
+		PM1aEventBlock: 0x600,
+		PM1bEventBlock: 0x700,
+		PM1EventLength: 4,
+	}
+
+	var errBuf bytes.Buffer
+	d, err := NewDispatcher(&errBuf, vm, ns, fadt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Initialization must disable all fixed events and clear their status
+	// bits.
+	for port := uint16(0x600); port < 0x604; port++ {
+		if ports.regs[port] != 0 || ports.regs[port+0x100] != 0 {
+			t.Fatalf("expected PM1 registers to be cleared after init; got 0x%x (PM1a), 0x%x (PM1b) for offset %d",
+				ports.regs[port], ports.regs[port+0x100], port-0x600)
+		}
+	}
+
+	var got []FixedEvent
+	for _, event := range []FixedEvent{FixedEventPowerButton, FixedEventSleepButton, FixedEventRTC} {
+		if err = d.InstallFixedHandler(event, func(event FixedEvent) { got = append(got, event) }); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, port := range []uint16{0x602, 0x702} {
+		if val := uint16(ports.regs[port]) | uint16(ports.regs[port+1])<<8; val != 0x0700 {
+			t.Fatalf("expected enable register at 0x%x to be 0x0700; got 0x%x", port, val)
+		}
+	}
+
+	t.Run("dispatch", func(t *testing.T) {
+		// Raise the power button event via PM1a, the RTC event via PM1b
+		// and the PM timer event which is not enabled.
+		ports.regs[0x600] = 0x01
+		ports.regs[0x601] = 0x01
+		ports.regs[0x701] = 0x04
+
+		if !d.HandleSCI() {
+			t.Fatal("expected HandleSCI to return true")
+		}
+
+		// Raised events must be acknowledged but remain enabled
+		for port, exp := range map[uint16]uint8{0x600: 0x01, 0x601: 0, 0x701: 0, 0x603: 0x07, 0x703: 0x07} {
+			if got := ports.regs[port]; got != exp {
+				t.Errorf("expected register 0x%x to be 0x%x after HandleSCI; got 0x%x", port, exp, got)
+			}
+		}
+
+		if serviced := d.RunPending(); serviced != 2 {
+			t.Fatalf("expected 2 events to be serviced; got %d", serviced)
+		}
+
+		if len(got) != 2 || got[0] != FixedEventPowerButton || got[1] != FixedEventRTC {
+			t.Fatalf("expected power button and RTC handlers to be invoked; got %v", got)
+		}
+
+		if d.HandleSCI() {
+			t.Fatal("expected HandleSCI to return false when no enabled events are raised")
+		}
+		ports.regs[0x600] = 0
+	})
+
+	t.Run("remove handler", func(t *testing.T) {
+		if err := d.InstallFixedHandler(FixedEventRTC, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := uint8(0x03); ports.regs[0x603] != exp || ports.regs[0x703] != exp {
+			t.Fatalf("expected RTC event to be disabled; got enable registers 0x%x, 0x%x", ports.regs[0x603], ports.regs[0x703])
+		}
+	})
+
+	t.Run("event without a handler", func(t *testing.T) {
+		// Enable the global lock event behind the dispatcher's back
+		ports.regs[0x602] = 0x20
+		ports.regs[0x600] = 0x20
+		d.HandleSCI()
+		d.RunPending()
+
+		if !strings.Contains(errBuf.String(), "no handler for fixed event: global lock") {
+			t.Fatalf("expected an error to be logged; got %q", errBuf.String())
+		}
+	})
+
+	if err := d.InstallFixedHandler(fixedEventCount, nil); err != errNoSuchFixedEvent {
+		t.Fatalf("expected to get errNoSuchFixedEvent; got %v", err)
+	}
+}
+
+func TestFixedEventsNotPresent(t *testing.T) {
+	defer func() {
+		portReadByteFn = cpu.PortReadByte
+		portWriteByteFn = cpu.PortWriteByte
+		portReadWordFn = cpu.PortReadWord
+		portWriteWordFn = cpu.PortWriteWord
+	}()
+	newFakeGPEPorts(nil)
+
+	vm, ns := vmtest.ForPayload(t, nil)
+	specs := []struct {
+		fadt  table.FADT
+		event FixedEvent
+	}{
+		// No PM1 event block (hardware-reduced ACPI)
+		{table.FADT{}, FixedEventPowerButton},
+		// Control method power button
+		{table.FADT{PM1aEventBlock: 0x600, PM1EventLength: 4, Flags: fadtFlagPowerButton}, FixedEventPowerButton},
+		// Control method sleep button
+		{table.FADT{PM1aEventBlock: 0x600, PM1EventLength: 4, Flags: fadtFlagSleepButton}, FixedEventSleepButton},
+	}
+
+	for specIndex, spec := range specs {
+		d, err := NewDispatcher(ioutil.Discard, vm, ns, &spec.fadt)
+		if err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if err = d.InstallFixedHandler(spec.event, func(FixedEvent) {}); err != errFixedEventNotPresent {
+			t.Errorf("[spec %d] expected to get errFixedEventNotPresent; got %v", specIndex, err)
+		}
+	}
+}
+
+func TestFixedEventErrors(t *testing.T) {
+	defer func() {
+		portReadByteFn = cpu.PortReadByte
+		portWriteByteFn = cpu.PortWriteByte
+		portReadWordFn = cpu.PortReadWord
+		portWriteWordFn = cpu.PortWriteWord
+	}()
+	newFakeGPEPorts(nil)
+
+	vm, ns := vmtest.ForPayload(t, nil)
+	specs := []struct {
+		fadt   table.FADT
+		expErr error
+	}{
+		{
+			table.FADT{PM1aEventBlock: 0x600, PM1EventLength: 6},
+			errMalformedPM1Block,
+		},
+		{
+			table.FADT{
+				SDTHeader:      table.SDTHeader{Length: uint32(unsafe.Sizeof(table.FADT{}))},
+				PM1EventLength: 4,
+				Ext: table.FADT64{
+					PM1aEventBlock: table.GenericAddress{Space: table.AddressSpaceSysMemory, Address: 0x1000},
+				},
+			},
+			errUnsupportedPM1Block,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := NewDispatcher(ioutil.Discard, vm, ns, &spec.fadt); err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestFixedEventString(t *testing.T) {
+	for event, exp := range map[FixedEvent]string{
+		FixedEventPMTimer:     "PM timer",
+		FixedEventGlobalLock:  "global lock",
+		FixedEventPowerButton: "power button",
+		FixedEventSleepButton: "sleep button",
+		FixedEventRTC:         "RTC alarm",
+		fixedEventCount:       "unknown",
+	} {
+		if got := event.String(); got != exp {
+			t.Errorf("expected %d.String() to return %q; got %q", event, exp, got)
+		}
+	}
+}
@@ -0,0 +1,400 @@
+// Package event implements the dispatching of ACPI events that are signaled
+// to the OS via the System Control Interrupt (SCI).
+package event
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/kfmt"
+	"io"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	errUnsupportedGPEBlock = &kernel.Error{Module: "acpi_event", Message: "GPE blocks outside the SystemIO address space are not supported", Code: kernel.ErrCodeNotSupported}
+	errMalformedGPEBlock   = &kernel.Error{Module: "acpi_event", Message: "GPE block length must be a multiple of 2", Code: kernel.ErrCodeCorrupted}
+	errNoSuchGPE           = &kernel.Error{Module: "acpi_event", Message: "GPE number is not backed by a GPE block", Code: kernel.ErrCodeNotFound}
+
+	portReadByteFn  = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+	portReadWordFn  = cpu.PortReadWord
+	portWriteWordFn = cpu.PortWriteWord
+)
+
+// Trigger describes how a GPE is signaled by the hardware.
+type Trigger uint8
+
+// The supported GPE trigger modes.
+const (
+	// TriggerLevel GPEs remain asserted until the source of the event is
+	// serviced. Their status bit is cleared after the handler runs.
+	TriggerLevel Trigger = iota
+
+	// TriggerEdge GPEs are asserted once per event. Their status bit is
+	// cleared before the handler runs so that no events get lost.
+	TriggerEdge
+)
+
+// Handler is a function that services a GPE. Handlers are invoked by
+// Dispatcher.RunPending and never from interrupt context.
+type Handler func(gpe uint32)
+
+// gpeBlock describes a GPE register block. Each block consists of a set of
+// 8-bit status registers followed by an equal number of 8-bit enable
+// registers. Each register bit corresponds to a single GPE.
+type gpeBlock struct {
+	// The number of the GPE that corresponds to bit 0 of the first
+	// status register.
+	base uint32
+
+	// The I/O port for the first status register and the number of
+	// status registers in the block.
+	port     uint16
+	regCount uint16
+
+	// The GPEs that are edge-triggered and the GPEs that have been
+	// enabled by the OS. Each entry corresponds to a register.
+	edgeMask   []uint8
+	enableMask []uint8
+
+	// pending tracks the GPEs that have been signaled but not yet
+	// serviced. Each entry corresponds to a register and is updated
+	// atomically as it is modified from interrupt context.
+	pending []uint32
+}
+
+// statusPort returns the I/O port for the status register at index reg.
+func (blk *gpeBlock) statusPort(reg uint16) uint16 {
+	return blk.port + reg
+}
+
+// enablePort returns the I/O port for the enable register at index reg.
+func (blk *gpeBlock) enablePort(reg uint16) uint16 {
+	return blk.port + blk.regCount + reg
+}
+
+// gpeInfo describes how a GPE is serviced.
+type gpeInfo struct {
+	// The path to the _Lxx or _Exx method that services the GPE. It is
+	// ignored if handler is not nil.
+	method  string
+	handler Handler
+}
+
+// Dispatcher services the fixed events and the General Purpose Events (GPE)
+// signaled through the SCI. When a GPE is raised, the dispatcher masks it and
+// queues it for processing. Queued GPEs are serviced outside of interrupt
+// context by invoking the matching _Lxx or _Exx method defined in the \_GPE
+// scope (or an installed Handler) and are then unmasked. Fixed events (e.g.
+// power button presses) are acknowledged when raised and serviced by the
+// FixedHandler installed for them.
+type Dispatcher struct {
+	errWriter io.Writer
+	vm        *aml.VM
+	sci       uint16
+
+	pm1    pm1Blocks
+	blocks []gpeBlock
+	gpes   map[uint32]*gpeInfo
+}
+
+// NewDispatcher creates a Dispatcher for the PM1 event and GPE blocks defined
+// by the FADT. All fixed events and GPEs are initially disabled and their
+// status bits cleared. GPEs with a
+// matching _Lxx or _Exx method in the \_GPE scope of ns are then enabled.
+// Method evaluation errors are reported to errWriter.
+func NewDispatcher(errWriter io.Writer, vm *aml.VM, ns *aml.Namespace, fadt *table.FADT) (*Dispatcher, *kernel.Error) {
+	d := &Dispatcher{
+		errWriter: errWriter,
+		vm:        vm,
+		sci:       fadt.SCIInterrupt,
+		gpes:      make(map[uint32]*gpeInfo),
+	}
+
+	if err := d.initFixedEvents(fadt); err != nil {
+		return nil, err
+	}
+
+	gpe0, gpe1 := gpeBlockAddresses(fadt)
+	for _, spec := range []struct {
+		addr table.GenericAddress
+		len  uint8
+		base uint32
+	}{
+		{gpe0, fadt.GPE0Length, 0},
+		{gpe1, fadt.GPE1Length, uint32(fadt.GPE1Base)},
+	} {
+		if spec.addr.Address == 0 || spec.len == 0 {
+			continue
+		}
+
+		switch {
+		case spec.addr.Space != table.AddressSpaceSysIO:
+			return nil, errUnsupportedGPEBlock
+		case spec.len&1 != 0:
+			return nil, errMalformedGPEBlock
+		}
+
+		regCount := uint16(spec.len >> 1)
+		d.blocks = append(d.blocks, gpeBlock{
+			base:       spec.base,
+			port:       uint16(spec.addr.Address),
+			regCount:   regCount,
+			edgeMask:   make([]uint8, regCount),
+			enableMask: make([]uint8, regCount),
+			pending:    make([]uint32, regCount),
+		})
+
+		blk := &d.blocks[len(d.blocks)-1]
+		for reg := uint16(0); reg < regCount; reg++ {
+			portWriteByteFn(blk.enablePort(reg), 0)
+			portWriteByteFn(blk.statusPort(reg), 0xff)
+		}
+	}
+
+	if scope := ns.Lookup(nil, `\_GPE`); scope != nil {
+		ns.Walk(aml.WalkFilter{Scope: scope, Types: aml.ObjectTypeMethod, MaxDepth: 1}, func(node *aml.NamespaceNode) bool {
+			gpe, trigger, ok := parseGPEMethodName(node.Name())
+			if !ok {
+				return false
+			}
+
+			if blk, reg, bit := d.locate(gpe); blk != nil {
+				d.gpes[gpe] = &gpeInfo{method: node.Path()}
+				d.setTrigger(blk, reg, bit, trigger)
+				d.setEnabled(blk, reg, bit, true)
+			}
+			return false
+		})
+	}
+
+	return d, nil
+}
+
+// gpeBlockAddresses returns the addresses of the GPE0 and GPE1 blocks. The
+// 64-bit FADT extensions are preferred if the table is large enough to
+// contain them.
+func gpeBlockAddresses(fadt *table.FADT) (table.GenericAddress, table.GenericAddress) {
+	gpe0 := table.GenericAddress{Space: table.AddressSpaceSysIO, Address: uint64(fadt.GPE0Block)}
+	gpe1 := table.GenericAddress{Space: table.AddressSpaceSysIO, Address: uint64(fadt.GPE1Block)}
+
+	if uintptr(fadt.Length) >= unsafe.Sizeof(*fadt) {
+		if fadt.Ext.GPE0Block.Address != 0 {
+			gpe0 = fadt.Ext.GPE0Block
+		}
+		if fadt.Ext.GPE1Block.Address != 0 {
+			gpe1 = fadt.Ext.GPE1Block
+		}
+	}
+
+	return gpe0, gpe1
+}
+
+// parseGPEMethodName parses a _Lxx or _Exx method name and returns the GPE
+// number and trigger mode that it encodes.
+func parseGPEMethodName(name string) (uint32, Trigger, bool) {
+	if len(name) != 4 || name[0] != '_' {
+		return 0, 0, false
+	}
+
+	var trigger Trigger
+	switch name[1] {
+	case 'L':
+		trigger = TriggerLevel
+	case 'E':
+		trigger = TriggerEdge
+	default:
+		return 0, 0, false
+	}
+
+	var gpe uint32
+	for _, ch := range []byte(name[2:]) {
+		switch {
+		case ch >= '0' && ch <= '9':
+			gpe = gpe<<4 | uint32(ch-'0')
+		case ch >= 'A' && ch <= 'F':
+			gpe = gpe<<4 | uint32(ch-'A'+10)
+		default:
+			return 0, 0, false
+		}
+	}
+
+	return gpe, trigger, true
+}
+
+// SCI returns the interrupt number used by the hardware to signal the SCI.
+// The interrupt handler for the SCI must invoke HandleSCI.
+func (d *Dispatcher) SCI() uint16 {
+	return d.sci
+}
+
+// InstallHandler registers a handler for the specified GPE and enables it.
+// The handler takes precedence over any _Lxx or _Exx method defined for the
+// GPE.
+func (d *Dispatcher) InstallHandler(gpe uint32, trigger Trigger, handler Handler) *kernel.Error {
+	blk, reg, bit := d.locate(gpe)
+	if blk == nil {
+		return errNoSuchGPE
+	}
+
+	info, exists := d.gpes[gpe]
+	if !exists {
+		info = &gpeInfo{}
+		d.gpes[gpe] = info
+	}
+	info.handler = handler
+
+	d.setTrigger(blk, reg, bit, trigger)
+	d.setEnabled(blk, reg, bit, true)
+	return nil
+}
+
+// Enable unmasks the specified GPE.
+func (d *Dispatcher) Enable(gpe uint32) *kernel.Error {
+	blk, reg, bit := d.locate(gpe)
+	if blk == nil {
+		return errNoSuchGPE
+	}
+
+	d.setEnabled(blk, reg, bit, true)
+	return nil
+}
+
+// Disable masks the specified GPE.
+func (d *Dispatcher) Disable(gpe uint32) *kernel.Error {
+	blk, reg, bit := d.locate(gpe)
+	if blk == nil {
+		return errNoSuchGPE
+	}
+
+	d.setEnabled(blk, reg, bit, false)
+	return nil
+}
+
+// HandleSCI checks the PM1 and GPE status registers for enabled events that
+// have been raised and queues them for processing by RunPending. Raised GPEs
+// are masked and edge-triggered GPEs also get their status bit cleared. Fixed
+// events are acknowledged but remain enabled. HandleSCI is safe to call from
+// interrupt context and returns true if at least one event was queued.
+func (d *Dispatcher) HandleSCI() bool {
+	queued := d.handleFixedSCI()
+
+	for blockIndex := range d.blocks {
+		blk := &d.blocks[blockIndex]
+		for reg := uint16(0); reg < blk.regCount; reg++ {
+			enabled := portReadByteFn(blk.enablePort(reg))
+			active := portReadByteFn(blk.statusPort(reg)) & enabled
+			if active == 0 {
+				continue
+			}
+
+			portWriteByteFn(blk.enablePort(reg), enabled&^active)
+			if edge := active & blk.edgeMask[reg]; edge != 0 {
+				portWriteByteFn(blk.statusPort(reg), edge)
+			}
+
+			for {
+				old := atomic.LoadUint32(&blk.pending[reg])
+				if atomic.CompareAndSwapUint32(&blk.pending[reg], old, old|uint32(active)) {
+					break
+				}
+			}
+			queued = true
+		}
+	}
+
+	return queued
+}
+
+// RunPending services the fixed events and GPEs queued by HandleSCI and
+// returns the number of events that were serviced. Fixed events are serviced
+// first. Once a GPE is serviced, its status bit is cleared (for
+// level-triggered GPEs) and the GPE is unmasked unless it was disabled in the
+// meantime. Any notifications raised by the GPE methods are delivered
+// to their handlers before RunPending returns. RunPending must not be called
+// from interrupt context.
+func (d *Dispatcher) RunPending() int {
+	serviced := d.runPendingFixed()
+
+	for blockIndex := range d.blocks {
+		blk := &d.blocks[blockIndex]
+		for reg := uint16(0); reg < blk.regCount; reg++ {
+			pending := uint8(atomic.SwapUint32(&blk.pending[reg], 0))
+			for bit := uint8(0); bit < 8; bit++ {
+				mask := uint8(1 << bit)
+				if pending&mask == 0 {
+					continue
+				}
+
+				d.dispatch(blk.base + uint32(reg)<<3 + uint32(bit))
+				serviced++
+
+				if blk.edgeMask[reg]&mask == 0 {
+					portWriteByteFn(blk.statusPort(reg), mask)
+				}
+
+				if blk.enableMask[reg]&mask != 0 {
+					portWriteByteFn(blk.enablePort(reg), portReadByteFn(blk.enablePort(reg))|mask)
+				}
+			}
+		}
+	}
+
+	d.vm.DispatchNotifications()
+	return serviced
+}
+
+// dispatch invokes the handler or the method associated with gpe.
+func (d *Dispatcher) dispatch(gpe uint32) {
+	info, exists := d.gpes[gpe]
+	switch {
+	case !exists:
+		kfmt.Fprintf(d.errWriter, "[acpi_event] no handler for GPE 0x%x\n", gpe)
+	case info.handler != nil:
+		info.handler(gpe)
+	default:
+		if _, err := d.vm.Evaluate(info.method); err != nil {
+			kfmt.Fprintf(d.errWriter, "[acpi_event] error evaluating %s: %s\n", info.method, err.Error())
+		}
+	}
+}
+
+// locate returns the block, register index and bit that correspond to gpe or
+// a nil block if gpe is not backed by any block.
+func (d *Dispatcher) locate(gpe uint32) (*gpeBlock, uint16, uint8) {
+	for blockIndex := range d.blocks {
+		blk := &d.blocks[blockIndex]
+		if gpe >= blk.base && gpe < blk.base+uint32(blk.regCount)<<3 {
+			offset := gpe - blk.base
+			return blk, uint16(offset >> 3), uint8(offset & 7)
+		}
+	}
+
+	return nil, 0, 0
+}
+
+// setTrigger updates the trigger mode for the GPE at the specified bit of
+// register reg.
+func (d *Dispatcher) setTrigger(blk *gpeBlock, reg uint16, bit uint8, trigger Trigger) {
+	if trigger == TriggerEdge {
+		blk.edgeMask[reg] |= 1 << bit
+	} else {
+		blk.edgeMask[reg] &^= 1 << bit
+	}
+}
+
+// setEnabled masks or unmasks the GPE at the specified bit of register reg.
+func (d *Dispatcher) setEnabled(blk *gpeBlock, reg uint16, bit uint8, enabled bool) {
+	val := portReadByteFn(blk.enablePort(reg))
+	if enabled {
+		blk.enableMask[reg] |= 1 << bit
+		val |= 1 << bit
+	} else {
+		blk.enableMask[reg] &^= 1 << bit
+		val &^= 1 << bit
+	}
+	portWriteByteFn(blk.enablePort(reg), val)
+}
@@ -0,0 +1,293 @@
+package event
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel/cpu"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestGPEDispatcher(t *testing.T) {
+	defer func() {
+		portReadByteFn = cpu.PortReadByte
+		portWriteByteFn = cpu.PortWriteByte
+	}()
+
+	// GPE0 block at 0x400 (GPEs 0-15); GPE1 block at 0x500 (GPEs 32-39)
+	ports := newFakeGPEPorts(map[uint16]bool{0x400: true, 0x401: true, 0x500: true})
+	ports.regs[0x402] = 0xff
+	ports.regs[0x400] = 0xff
+
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		// Scope(\_SB) { Device(LID0) {} }
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'S', 'B', '_'},
+			amltest.Pkg([]byte{0x5b, 0x82}, []byte{'L', 'I', 'D', '0'}),
+		)),
+		// Scope(\_GPE) {
+		//   Name(LCNT, Zero)
+		//   Name(ECNT, Zero)
+		//   Method(_L01) { Increment(LCNT) }
+		//   Method(_E0A) { Increment(ECNT) Notify(\_SB.LID0, 0x80) }
+		//   Method(_L21) { Increment(LCNT) }
+		//   Method(_LXY) {}
+		//   Method(_L40) {}
+		// }
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'G', 'P', 'E'},
+			[]byte{0x08, 'L', 'C', 'N', 'T', 0x00},
+			[]byte{0x08, 'E', 'C', 'N', 'T', 0x00},
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'L', '0', '1', 0x00, 0x75, 'L', 'C', 'N', 'T'}),
+			amltest.Pkg([]byte{0x14}, []byte{
+				'_', 'E', '0', 'A', 0x00, 0x75, 'E', 'C', 'N', 'T',
+				0x86, '\\', 0x2e, '_', 'S', 'B', '_', 'L', 'I', 'D', '0', 0x0a, 0x80,
+			}),
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'L', '2', '1', 0x00, 0x75, 'L', 'C', 'N', 'T'}),
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'L', 'X', 'Y', 0x00}),
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'L', '4', '0', 0x00}),
+		)),
+	))
+
+	fadt := &table.FADT{
+		SCIInterrupt: 9,
+		GPE0Block:    0x400,
+		GPE0Length:   4,
+		GPE1Block:    0x500,
+		GPE1Length:   2,
+		GPE1Base:     32,
+	}
+	fadt.Length = uint32(unsafe.Sizeof(*fadt))
+
+	var errBuf bytes.Buffer
+	d, err := NewDispatcher(&errBuf, vm, ns, fadt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := d.SCI(); got != 9 {
+		t.Errorf("expected SCI to be 9; got %d", got)
+	}
+
+	// Initialization must clear all status bits and only enable the GPEs
+	// with a matching method.
+	for port, exp := range map[uint16]uint8{0x400: 0, 0x401: 0, 0x402: 0x02, 0x403: 0x04, 0x500: 0, 0x501: 0x02} {
+		if got := ports.regs[port]; got != exp {
+			t.Errorf("expected register 0x%x to be 0x%x after init; got 0x%x", port, exp, got)
+		}
+	}
+
+	t.Run("no events", func(t *testing.T) {
+		if d.HandleSCI() {
+			t.Fatal("expected HandleSCI to return false when no GPEs are raised")
+		}
+
+		if got := d.RunPending(); got != 0 {
+			t.Fatalf("expected no GPEs to be serviced; got %d", got)
+		}
+	})
+
+	t.Run("dispatch", func(t *testing.T) {
+		// Raise GPE 1 (level), GPE 10 (edge), GPE 33 (level) and GPE 2
+		// which is not enabled.
+		ports.regs[0x400] = 0x06
+		ports.regs[0x401] = 0x04
+		ports.regs[0x500] = 0x02
+
+		if !d.HandleSCI() {
+			t.Fatal("expected HandleSCI to return true")
+		}
+
+		// Raised GPEs must be masked; the edge-triggered one must also be
+		// acknowledged while the level-triggered ones remain asserted.
+		for port, exp := range map[uint16]uint8{0x400: 0x06, 0x401: 0, 0x402: 0, 0x403: 0, 0x500: 0x02, 0x501: 0} {
+			if got := ports.regs[port]; got != exp {
+				t.Errorf("expected register 0x%x to be 0x%x after HandleSCI; got 0x%x", port, exp, got)
+			}
+		}
+
+		var notified []uint64
+		vm.InstallNotifyHandler(`\_SB.LID0`, func(_ *aml.NamespaceNode, value uint64) {
+			notified = append(notified, value)
+		})
+
+		if got := d.RunPending(); got != 3 {
+			t.Fatalf("expected 3 GPEs to be serviced; got %d", got)
+		}
+
+		for path, exp := range map[string]uint64{`\_GPE.LCNT`: 2, `\_GPE.ECNT`: 1} {
+			if got, _ := vm.Evaluate(path); got != exp {
+				t.Errorf("expected %s to be %d; got %v", path, exp, got)
+			}
+		}
+
+		if len(notified) != 1 || notified[0] != 0x80 {
+			t.Errorf("expected GPE methods to notify LID0 with value 0x80; got %v", notified)
+		}
+
+		// Serviced GPEs must be acknowledged and unmasked
+		for port, exp := range map[uint16]uint8{0x400: 0x04, 0x401: 0, 0x402: 0x02, 0x403: 0x04, 0x500: 0, 0x501: 0x02} {
+			if got := ports.regs[port]; got != exp {
+				t.Errorf("expected register 0x%x to be 0x%x after RunPending; got 0x%x", port, exp, got)
+			}
+		}
+		ports.regs[0x400] = 0
+	})
+
+	t.Run("handlers", func(t *testing.T) {
+		var got []uint32
+		if err := d.InstallHandler(3, TriggerEdge, func(gpe uint32) { got = append(got, gpe) }); err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := uint8(0x0a); ports.regs[0x402] != exp {
+			t.Fatalf("expected InstallHandler to enable GPE 3; got enable register 0x%x", ports.regs[0x402])
+		}
+
+		ports.regs[0x400] = 0x08
+		d.HandleSCI()
+
+		// Disabling a GPE while it is pending prevents it from being
+		// unmasked after it is serviced.
+		if err := d.Disable(3); err != nil {
+			t.Fatal(err)
+		}
+		d.RunPending()
+
+		if len(got) != 1 || got[0] != 3 {
+			t.Fatalf("expected handler to be invoked for GPE 3; got %v", got)
+		}
+
+		if ports.regs[0x402] != 0x02 {
+			t.Fatalf("expected GPE 3 to remain masked; got enable register 0x%x", ports.regs[0x402])
+		}
+
+		if err := d.Enable(3); err != nil || ports.regs[0x402] != 0x0a {
+			t.Fatalf("expected GPE 3 to be unmasked; got enable register 0x%x (err: %v)", ports.regs[0x402], err)
+		}
+	})
+
+	t.Run("unknown GPE", func(t *testing.T) {
+		for _, gpe := range []uint32{16, 31, 40} {
+			if err := d.Enable(gpe); err != errNoSuchGPE {
+				t.Errorf("[gpe %d] expected Enable to return errNoSuchGPE; got %v", gpe, err)
+			}
+			if err := d.Disable(gpe); err != errNoSuchGPE {
+				t.Errorf("[gpe %d] expected Disable to return errNoSuchGPE; got %v", gpe, err)
+			}
+			if err := d.InstallHandler(gpe, TriggerLevel, nil); err != errNoSuchGPE {
+				t.Errorf("[gpe %d] expected InstallHandler to return errNoSuchGPE; got %v", gpe, err)
+			}
+		}
+	})
+
+	t.Run("GPE without a handler", func(t *testing.T) {
+		d.Enable(4)
+		ports.regs[0x400] = 0x10
+		d.HandleSCI()
+		d.RunPending()
+
+		if !strings.Contains(errBuf.String(), "no handler for GPE 0x4") {
+			t.Fatalf("expected an error to be logged; got %q", errBuf.String())
+		}
+	})
+}
+
+func TestGPEDispatcherErrors(t *testing.T) {
+	defer func() {
+		portReadByteFn = cpu.PortReadByte
+		portWriteByteFn = cpu.PortWriteByte
+	}()
+	newFakeGPEPorts(nil)
+
+	vm, ns := vmtest.ForPayload(t, nil)
+
+	specs := []struct {
+		fadt   table.FADT
+		expErr error
+	}{
+		{
+			table.FADT{GPE0Block: 0x400, GPE0Length: 3},
+			errMalformedGPEBlock,
+		},
+		{
+			table.FADT{
+				SDTHeader:  table.SDTHeader{Length: uint32(unsafe.Sizeof(table.FADT{}))},
+				GPE0Length: 4,
+				Ext: table.FADT64{
+					GPE0Block: table.GenericAddress{Space: table.AddressSpaceSysMemory, Address: 0x1000},
+				},
+			},
+			errUnsupportedGPEBlock,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := NewDispatcher(ioutil.Discard, vm, ns, &spec.fadt); err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestParseGPEMethodName(t *testing.T) {
+	specs := []struct {
+		name       string
+		expGPE     uint32
+		expTrigger Trigger
+		expOK      bool
+	}{
+		{"_L00", 0, TriggerLevel, true},
+		{"_E1F", 0x1f, TriggerEdge, true},
+		{"_LFF", 0xff, TriggerLevel, true},
+		{"_X01", 0, 0, false},
+		{"_L0g", 0, 0, false},
+		{"L001", 0, 0, false},
+		{"_L1", 0, 0, false},
+	}
+
+	for specIndex, spec := range specs {
+		gpe, trigger, ok := parseGPEMethodName(spec.name)
+		if gpe != spec.expGPE || trigger != spec.expTrigger || ok != spec.expOK {
+			t.Errorf("[spec %d] expected parseGPEMethodName(%q) to return (%d, %d, %t); got (%d, %d, %t)",
+				specIndex, spec.name, spec.expGPE, spec.expTrigger, spec.expOK, gpe, trigger, ok)
+		}
+	}
+}
+
+// fakeGPEPorts emulates the I/O ports of a set of GPE and PM1 register
+// blocks. Writes to status registers clear the bits that are set in the
+// written value. Word accesses are split into two byte accesses.
+type fakeGPEPorts struct {
+	regs        map[uint16]uint8
+	statusPorts map[uint16]bool
+}
+
+func newFakeGPEPorts(statusPorts map[uint16]bool) *fakeGPEPorts {
+	ports := &fakeGPEPorts{
+		regs:        make(map[uint16]uint8),
+		statusPorts: statusPorts,
+	}
+
+	portReadByteFn = func(port uint16) uint8 { return ports.regs[port] }
+	portWriteByteFn = func(port uint16, val uint8) {
+		if ports.statusPorts[port] {
+			ports.regs[port] &^= val
+			return
+		}
+		ports.regs[port] = val
+	}
+	portReadWordFn = func(port uint16) uint16 {
+		return uint16(portReadByteFn(port)) | uint16(portReadByteFn(port+1))<<8
+	}
+	portWriteWordFn = func(port uint16, val uint16) {
+		portWriteByteFn(port, uint8(val))
+		portWriteByteFn(port+1, uint8(val>>8))
+	}
+
+	return ports
+}
@@ -0,0 +1,132 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/event"
+	"gopheros/kernel"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/idle"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/replay"
+	"io"
+)
+
+var (
+	errACPIModeTimeout = &kernel.Error{Module: "acpi", Message: "firmware did not hand over control of the ACPI hardware", Code: kernel.ErrCodeIO}
+
+	newDispatcherFn   = event.NewDispatcher
+	handleInterruptFn = replay.HandleInterrupt
+	registerPollerFn  = idle.RegisterPoller
+	shutdownFn        = Shutdown
+
+	// activeDispatcher services the events signaled via the SCI.
+	activeDispatcher *event.Dispatcher
+)
+
+const (
+	// irqVectorBase is the interrupt vector that legacy IRQ 0 is mapped to.
+	// The vector for the SCI is obtained by adding the SCI interrupt
+	// number reported by the FADT.
+	irqVectorBase = 0x20
+
+	// The SCI_EN bit of the PM1 control register is set once the firmware
+	// hands over control of the ACPI hardware to the OS.
+	pm1SCIEnable = uint64(1)
+
+	// The number of times to poll SCI_EN after requesting the switch to
+	// ACPI mode before giving up.
+	acpiModeMaxPolls = 0x10000
+)
+
+// initEvents switches the system to ACPI mode, creates the dispatcher for the
+// events signaled via the SCI and routes the SCI to it. Queued events are
+// serviced by PollEvents which is registered as an idle loop poller. If the
+// hardware implements a fixed power button, pressing it shuts the system down.
+func initEvents(w io.Writer, vm *aml.VM, ns *aml.Namespace) *kernel.Error {
+	if activeFADT == nil {
+		return errNoFADT
+	}
+
+	if err := enableACPIMode(); err != nil {
+		return err
+	}
+
+	dispatcher, err := newDispatcherFn(w, vm, ns, activeFADT)
+	if err != nil {
+		return err
+	}
+
+	// Buttons implemented as control method devices signal presses via
+	// Notify instead.
+	_ = dispatcher.InstallFixedHandler(event.FixedEventPowerButton, handlePowerButton)
+
+	activeDispatcher = dispatcher
+	handleInterruptFn(gate.InterruptNumber(irqVectorBase+dispatcher.SCI()), 0, handleSCI)
+	registerPollerFn(PollEvents)
+	return nil
+}
+
+// enableACPIMode asks the firmware to hand over control of the ACPI hardware
+// to the OS by writing the ACPI_ENABLE value to the SMI command port and
+// waits for the SCI_EN bit to be set. Systems without an SMI command port
+// (e.g. hardware-reduced ACPI platforms) are always in ACPI mode.
+func enableACPIMode() *kernel.Error {
+	info, pm1a := FADT(), PM1aControlBlock()
+	if info == nil || info.SMICommandPort == 0 || info.ACPIEnable == 0 {
+		return nil
+	}
+
+	for poll := 0; poll <= acpiModeMaxPolls; poll++ {
+		val, err := pm1a.Read(0, 16)
+		if err != nil {
+			return err
+		}
+
+		if val&pm1SCIEnable != 0 {
+			return nil
+		}
+
+		if poll == 0 {
+			portWriteByteFn(uint16(info.SMICommandPort), info.ACPIEnable)
+		}
+	}
+
+	return errACPIModeTimeout
+}
+
+// handleSCI is invoked when the SCI fires and queues the raised events for
+// processing by PollEvents.
+func handleSCI(_ *gate.Registers) {
+	if activeDispatcher != nil {
+		activeDispatcher.HandleSCI()
+	}
+}
+
+// handlePowerButton shuts the system down when the power button is pressed.
+func handlePowerButton(_ event.FixedEvent) {
+	orderlyShutdown("power button pressed")
+}
+
+// orderlyShutdown reports the reason for shutting down the system and enters
+// the S5 sleep state. Shutdown errors are reported to the console.
+func orderlyShutdown(reason string) {
+	kfmt.Printf("[acpi] %s; shutting down\n", reason)
+	if err := shutdownFn(); err != nil {
+		kfmt.Printf("[acpi] shutdown failed: %s\n", err.Error())
+	}
+}
+
+// PollEvents services the ACPI events queued by the SCI handler and delivers
+// any resulting notifications to their handlers. It must not be called from
+// interrupt context.
+func PollEvents() {
+	if activeDispatcher != nil {
+		activeDispatcher.RunPending()
+	}
+}
+
+// EventDispatcher returns the dispatcher for the events signaled via the SCI
+// or nil if ACPI events are not available.
+func EventDispatcher() *event.Dispatcher {
+	return activeDispatcher
+}
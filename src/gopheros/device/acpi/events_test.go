@@ -0,0 +1,193 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/device/acpi/event"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/idle"
+	"gopheros/kernel/replay"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestEnableACPIMode(t *testing.T) {
+	defer func() {
+		restorePowerHW()
+		activeFADTInfo = nil
+	}()
+
+	pm1a := table.RegisterBlock{
+		Address: table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 16, Address: 0x804},
+		Length:  2,
+	}
+
+	specs := []struct {
+		info       *table.FADTInfo
+		pm1Control uint16
+		enableSCI  bool
+		expWrites  []portWrite
+		expErr     *kernel.Error
+	}{
+		// no FADT
+		{nil, 0, false, nil, nil},
+		// no SMI command port; always in ACPI mode
+		{&table.FADTInfo{PM1aControlBlock: pm1a}, 0, false, nil, nil},
+		// already in ACPI mode
+		{&table.FADTInfo{SMICommandPort: 0xb2, ACPIEnable: 0xa0, PM1aControlBlock: pm1a}, 1, false, nil, nil},
+		// firmware enables ACPI mode after receiving the command
+		{&table.FADTInfo{SMICommandPort: 0xb2, ACPIEnable: 0xa0, PM1aControlBlock: pm1a}, 0, true, []portWrite{{0xb2, 0xa0}}, nil},
+		// firmware never sets SCI_EN
+		{&table.FADTInfo{SMICommandPort: 0xb2, ACPIEnable: 0xa0, PM1aControlBlock: pm1a}, 0, false, []portWrite{{0xb2, 0xa0}}, errACPIModeTimeout},
+		// missing PM1a control block
+		{&table.FADTInfo{SMICommandPort: 0xb2, ACPIEnable: 0xa0}, 0, false, nil, errBlockNotPresent},
+	}
+
+	for specIndex, spec := range specs {
+		ports := newFakePowerPorts()
+		ports.words[0x804] = spec.pm1Control
+		if spec.enableSCI {
+			portWriteByteFn = func(port uint16, val uint8) {
+				ports.writes = append(ports.writes, portWrite{port, uint16(val)})
+				ports.words[0x804] |= uint16(pm1SCIEnable)
+			}
+		}
+		activeFADTInfo = spec.info
+
+		if err := enableACPIMode(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+
+		if len(ports.writes) != len(spec.expWrites) {
+			t.Errorf("[spec %d] expected port writes %v; got %v", specIndex, spec.expWrites, ports.writes)
+			continue
+		}
+
+		for i, exp := range spec.expWrites {
+			if ports.writes[i] != exp {
+				t.Errorf("[spec %d] expected port writes %v; got %v", specIndex, spec.expWrites, ports.writes)
+				break
+			}
+		}
+	}
+}
+
+func TestInitEvents(t *testing.T) {
+	defer func() {
+		restorePowerHW()
+		newDispatcherFn = event.NewDispatcher
+		handleInterruptFn = replay.HandleInterrupt
+		registerPollerFn = idle.RegisterPoller
+		activeDispatcher = nil
+	}()
+
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		// Device(DEV0) {}
+		[]byte{0x5b, 0x82, 0x05, 'D', 'E', 'V', '0'},
+		// Method(TST0) { Notify(DEV0, 0x80) }
+		amltest.Pkg([]byte{0x14}, []byte{'T', 'S', 'T', '0', 0x00, 0x86, 'D', 'E', 'V', '0', 0x0a, 0x80}),
+	))
+
+	t.Run("no FADT", func(t *testing.T) {
+		activeFADT = nil
+		if err := initEvents(ioutil.Discard, vm, ns); err != errNoFADT {
+			t.Fatalf("expected to get errNoFADT; got %v", err)
+		}
+	})
+
+	t.Run("dispatcher error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "NewDispatcher failed"}
+		newDispatcherFn = func(_ io.Writer, _ *aml.VM, _ *aml.Namespace, _ *table.FADT) (*event.Dispatcher, *kernel.Error) {
+			return nil, expErr
+		}
+		handleInterruptFn = func(_ gate.InterruptNumber, _ uint8, _ func(*gate.Registers)) {
+			t.Fatal("unexpected call to HandleInterrupt")
+		}
+
+		activeFADT = &table.FADT{SCIInterrupt: 9}
+		if err := initEvents(ioutil.Discard, vm, ns); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if EventDispatcher() != nil {
+			t.Fatal("expected EventDispatcher to return nil")
+		}
+	})
+
+	t.Run("success", func(t *testing.T) {
+		var (
+			gotVector  gate.InterruptNumber
+			gotHandler func(*gate.Registers)
+			gotPoller  func()
+			notified   uint64
+		)
+		newDispatcherFn = event.NewDispatcher
+		handleInterruptFn = func(vector gate.InterruptNumber, _ uint8, handler func(*gate.Registers)) {
+			gotVector, gotHandler = vector, handler
+		}
+		registerPollerFn = func(fn func()) { gotPoller = fn }
+
+		activeFADT = &table.FADT{SCIInterrupt: 9}
+		if err := initEvents(ioutil.Discard, vm, ns); err != nil {
+			t.Fatal(err)
+		}
+
+		if EventDispatcher() == nil {
+			t.Fatal("expected EventDispatcher to return the active dispatcher")
+		}
+
+		if exp := gate.InterruptNumber(irqVectorBase + 9); gotVector != exp {
+			t.Fatalf("expected the SCI handler to be installed for vector %d; got %d", exp, gotVector)
+		}
+
+		if gotHandler == nil || gotPoller == nil {
+			t.Fatal("expected the SCI handler and the event poller to be registered")
+		}
+
+		if err := vm.InstallNotifyHandler(`\DEV0`, func(_ *aml.NamespaceNode, value uint64) {
+			notified = value
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := vm.Evaluate(`\TST0`); err != nil {
+			t.Fatal(err)
+		}
+
+		gotHandler(nil)
+		gotPoller()
+
+		if notified != 0x80 {
+			t.Fatalf("expected the event poller to deliver notification 0x80; got 0x%x", notified)
+		}
+	})
+}
+
+func TestHandlePowerButton(t *testing.T) {
+	defer func() {
+		shutdownFn = Shutdown
+	}()
+
+	specs := []*kernel.Error{
+		nil,
+		{Module: "test", Message: "shutdown failed"},
+	}
+
+	for specIndex, expErr := range specs {
+		var called bool
+		shutdownFn = func() *kernel.Error {
+			called = true
+			return expErr
+		}
+
+		handlePowerButton(event.FixedEventPowerButton)
+
+		if !called {
+			t.Errorf("[spec %d] expected the power button handler to shut down the system", specIndex)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+var (
+	errNoFACS        = &kernel.Error{Module: "acpi", Message: "firmware did not provide a FACS", Code: kernel.ErrCodeNotFound}
+	errMalformedFACS = &kernel.Error{Module: "acpi", Message: "FACS has an invalid signature or length", Code: kernel.ErrCodeCorrupted}
+
+	facsSignature = [4]byte{'F', 'A', 'C', 'S'}
+
+	// The FACS located via the FADT.
+	activeFACS *table.FACS
+
+	// globalLock is the lock returned by FirmwareGlobalLock.
+	globalLock GlobalLock
+)
+
+// The bits of the global lock field in the FACS.
+const (
+	globalLockPending = uint32(1 << 0)
+	globalLockOwned   = uint32(1 << 1)
+)
+
+// pm1GlobalLockRelease is the GBL_RLS bit of the PM1 control register. The OS
+// sets it to notify the firmware that it has released a global lock that the
+// firmware is waiting for.
+const pm1GlobalLockRelease = uint64(1 << 2)
+
+// FACS returns the firmware ACPI control structure or nil if the ACPI driver
+// has not located a valid FACS.
+func FACS() *table.FACS {
+	return activeFACS
+}
+
+// WakingVector returns the physical address of the real mode code that the
+// firmware jumps to when the system resumes from the S3 sleep state.
+func WakingVector() (uint32, *kernel.Error) {
+	if activeFACS == nil {
+		return 0, errNoFACS
+	}
+
+	return activeFACS.FirmwareWakingVector, nil
+}
+
+// SetWakingVector sets the physical address of the real mode code that the
+// firmware jumps to when the system resumes from the S3 sleep state. The
+// 64-bit waking vector (if defined by the FACS) is cleared so that the
+// firmware uses the supplied address.
+func SetWakingVector(addr uint32) *kernel.Error {
+	if activeFACS == nil {
+		return errNoFACS
+	}
+
+	activeFACS.FirmwareWakingVector = addr
+	if activeFACS.Version >= 1 {
+		activeFACS.XFirmwareWakingVector = 0
+	}
+
+	return nil
+}
+
+// GlobalLock implements sync.Locker for the ACPI global lock which is used
+// for synchronizing access to hardware resources that are shared between the
+// OS and the firmware. The lock combines a mutex that serializes the OS-side
+// lock holders with the acquire/release protocol defined by the ACPI
+// specification for the global lock field of the FACS.
+type GlobalLock struct {
+	mutex sync.Mutex
+	facs  *table.FACS
+}
+
+// FirmwareGlobalLock returns the ACPI global lock. An error is returned if
+// the firmware did not provide a FACS.
+func FirmwareGlobalLock() (*GlobalLock, *kernel.Error) {
+	if activeFACS == nil {
+		return nil, errNoFACS
+	}
+
+	globalLock.facs = activeFACS
+	return &globalLock, nil
+}
+
+// Acquire blocks until the global lock is owned by the caller. If the
+// firmware currently owns the lock, the lock is flagged as pending so that
+// the firmware notifies the OS when it releases it; Acquire keeps retrying
+// until the lock can be claimed.
+func (l *GlobalLock) Acquire() {
+	l.mutex.Acquire()
+	for !acquireFirmwareLock(&l.facs.GlobalLock) {
+		// The firmware owns the lock; keep retrying until it
+		// releases it
+	}
+}
+
+// Release releases the global lock. If the firmware requested the lock while
+// the OS was holding it, the firmware is notified via the GBL_RLS bit.
+func (l *GlobalLock) Release() {
+	if releaseFirmwareLock(&l.facs.GlobalLock) {
+		signalGlobalLockRelease()
+	}
+	l.mutex.Release()
+}
+
+// acquireFirmwareLock attempts to claim the global lock field at lockPtr and
+// returns true if the lock was acquired. If the lock is owned by the
+// firmware, the pending bit is set and the function returns false.
+func acquireFirmwareLock(lockPtr *uint32) bool {
+	for {
+		old := atomic.LoadUint32(lockPtr)
+		val := (old &^ globalLockPending) | globalLockOwned
+		if old&globalLockOwned != 0 {
+			val |= globalLockPending
+		}
+
+		if atomic.CompareAndSwapUint32(lockPtr, old, val) {
+			return val&globalLockPending == 0
+		}
+	}
+}
+
+// releaseFirmwareLock releases the global lock field at lockPtr and returns
+// true if the firmware is waiting for the lock.
+func releaseFirmwareLock(lockPtr *uint32) bool {
+	for {
+		old := atomic.LoadUint32(lockPtr)
+		if atomic.CompareAndSwapUint32(lockPtr, old, old&^(globalLockPending|globalLockOwned)) {
+			return old&globalLockPending != 0
+		}
+	}
+}
+
+// signalGlobalLockRelease sets the GBL_RLS bit in the PM1 control registers.
+func signalGlobalLockRelease() {
+	for _, blk := range []RegisterBlock{PM1aControlBlock(), PM1bControlBlock()} {
+		if !blk.Present() {
+			continue
+		}
+
+		if val, err := blk.Read(0, 16); err == nil {
+			blk.Write(0, 16, val|pm1GlobalLockRelease)
+		}
+	}
+}
+
+// mapFACS maps the FACS located at the specified physical address with write
+// access and checks its signature and length.
+func mapFACS(addr uint64) (*table.FACS, *kernel.Error) {
+	if addr == 0 {
+		return nil, errNoFACS
+	}
+
+	sizeofFACS := unsafe.Sizeof(table.FACS{})
+	pageOffset := vmm.PageOffset(uintptr(addr))
+	page, err := identityMapFn(mm.FrameFromAddress(uintptr(addr)), pageOffset+sizeofFACS, vmm.FlagPresent|vmm.FlagRW)
+	if err != nil {
+		return nil, err
+	}
+
+	facs := (*table.FACS)(unsafe.Pointer(page.Address() + pageOffset))
+	if facs.Signature != facsSignature || uintptr(facs.Length) < sizeofFACS {
+		return nil, errMalformedFACS
+	}
+
+	return facs, nil
+}
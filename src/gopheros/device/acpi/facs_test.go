@@ -0,0 +1,201 @@
+package acpi
+
+import (
+	"bytes"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestMapFACS(t *testing.T) {
+	defer func() {
+		identityMapFn = vmm.IdentityMapRegion
+		activeFACS = nil
+	}()
+
+	identityMapFn = func(frame mm.Frame, _ uintptr, flags vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		if flags&vmm.FlagRW == 0 {
+			t.Error("expected the FACS to be mapped with write access")
+		}
+		return mm.Page(frame), nil
+	}
+
+	var (
+		facs       = genFACS(1)
+		badSig     = genFACS(1)
+		tooShort   = genFACS(1)
+		expMapErr  = &kernel.Error{Module: "test", Message: "identityMapRegion failed"}
+		facsAddr   = func(facs *table.FACS) uint64 { return uint64(uintptr(unsafe.Pointer(facs))) }
+		failingMap = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expMapErr
+		}
+	)
+	badSig.Signature[0] = 'X'
+	tooShort.Length = 32
+
+	specs := []struct {
+		addr    uint64
+		expFACS *table.FACS
+		expErr  *kernel.Error
+	}{
+		{facsAddr(facs), facs, nil},
+		{0, nil, errNoFACS},
+		{facsAddr(badSig), nil, errMalformedFACS},
+		{facsAddr(tooShort), nil, errMalformedFACS},
+	}
+
+	for specIndex, spec := range specs {
+		got, err := mapFACS(spec.addr)
+		if got != spec.expFACS || err != spec.expErr {
+			t.Errorf("[spec %d] expected to get (%p, %v); got (%p, %v)", specIndex, spec.expFACS, spec.expErr, got, err)
+		}
+	}
+
+	identityMapFn = failingMap
+	if _, err := mapFACS(facsAddr(facs)); err != expMapErr {
+		t.Errorf("expected to get error %v; got %v", expMapErr, err)
+	}
+
+	// loadFACS should only report malformed tables
+	var buf bytes.Buffer
+	drv := &acpiDriver{}
+	drv.loadFACS(&buf, 0)
+	if buf.Len() != 0 || activeFACS != nil {
+		t.Errorf("expected loadFACS to silently skip a missing FACS; got output %q", buf.String())
+	}
+
+	drv.loadFACS(&buf, facsAddr(facs))
+	if !strings.Contains(buf.String(), "skipping") || activeFACS != nil {
+		t.Errorf("expected loadFACS to report the map failure; got output %q", buf.String())
+	}
+}
+
+func TestWakingVector(t *testing.T) {
+	defer func() { activeFACS = nil }()
+
+	if _, err := WakingVector(); err != errNoFACS {
+		t.Fatalf("expected to get error %v; got %v", errNoFACS, err)
+	}
+
+	if err := SetWakingVector(0x1000); err != errNoFACS {
+		t.Fatalf("expected to get error %v; got %v", errNoFACS, err)
+	}
+
+	for _, version := range []uint8{0, 1} {
+		activeFACS = genFACS(version)
+		activeFACS.XFirmwareWakingVector = 0xdeadbeef
+
+		if FACS() != activeFACS {
+			t.Fatalf("[version %d] expected FACS() to return the active FACS", version)
+		}
+
+		if err := SetWakingVector(0x9000); err != nil {
+			t.Fatalf("[version %d] unexpected error: %v", version, err)
+		}
+
+		if got, err := WakingVector(); err != nil || got != 0x9000 {
+			t.Fatalf("[version %d] expected waking vector to be 0x9000; got 0x%x (err: %v)", version, got, err)
+		}
+
+		expX := uint64(0xdeadbeef)
+		if version >= 1 {
+			expX = 0
+		}
+
+		if got := activeFACS.XFirmwareWakingVector; got != expX {
+			t.Fatalf("[version %d] expected the 64-bit waking vector to be 0x%x; got 0x%x", version, expX, got)
+		}
+	}
+}
+
+func TestGlobalLockProtocol(t *testing.T) {
+	specs := []struct {
+		lock        uint32
+		expAcquired bool
+		expLock     uint32
+	}{
+		{0, true, globalLockOwned},
+		{globalLockPending, true, globalLockOwned},
+		{globalLockOwned, false, globalLockOwned | globalLockPending},
+		{globalLockOwned | globalLockPending, false, globalLockOwned | globalLockPending},
+	}
+
+	for specIndex, spec := range specs {
+		lock := spec.lock
+		if got := acquireFirmwareLock(&lock); got != spec.expAcquired || lock != spec.expLock {
+			t.Errorf("[spec %d] expected acquire to return %t and set lock to 0x%x; got %t, 0x%x", specIndex, spec.expAcquired, spec.expLock, got, lock)
+		}
+	}
+
+	for specIndex, spec := range []struct {
+		lock       uint32
+		expPending bool
+	}{
+		{globalLockOwned, false},
+		{globalLockOwned | globalLockPending, true},
+	} {
+		lock := spec.lock
+		if got := releaseFirmwareLock(&lock); got != spec.expPending || lock != 0 {
+			t.Errorf("[spec %d] expected release to return %t and clear the lock; got %t, 0x%x", specIndex, spec.expPending, got, lock)
+		}
+	}
+}
+
+func TestGlobalLock(t *testing.T) {
+	defer func() {
+		restoreRegisterHW()
+		activeFACS = nil
+	}()
+
+	if _, err := FirmwareGlobalLock(); err != errNoFACS {
+		t.Fatalf("expected to get error %v; got %v", errNoFACS, err)
+	}
+
+	ports := mockRegisterPorts()
+	activeFADTInfo = &table.FADTInfo{
+		PM1aControlBlock: table.RegisterBlock{
+			Address: table.GenericAddress{Space: table.AddressSpaceSysIO, Address: 0x404},
+			Length:  2,
+		},
+	}
+	activeFACS = genFACS(1)
+
+	lock, err := FirmwareGlobalLock()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lock.Acquire()
+	if exp := globalLockOwned; activeFACS.GlobalLock != exp {
+		t.Fatalf("expected global lock field to be 0x%x; got 0x%x", exp, activeFACS.GlobalLock)
+	}
+
+	lock.Release()
+	if activeFACS.GlobalLock != 0 || ports[0x404] != 0 {
+		t.Fatalf("expected the lock to be released without notifying the firmware; got lock: 0x%x, PM1a control: 0x%x", activeFACS.GlobalLock, ports[0x404])
+	}
+
+	// Simulate the firmware requesting the lock while the OS holds it
+	ports[0x404] = 1
+	lock.Acquire()
+	activeFACS.GlobalLock |= globalLockPending
+	lock.Release()
+
+	if exp := uint64(1) | pm1GlobalLockRelease; ports[0x404] != exp {
+		t.Fatalf("expected PM1a control register to be 0x%x; got 0x%x", exp, ports[0x404])
+	}
+}
+
+// genFACS returns a FACS with the specified version.
+func genFACS(version uint8) *table.FACS {
+	facs := &table.FACS{
+		Signature: facsSignature,
+		Version:   version,
+	}
+	facs.Length = uint32(unsafe.Sizeof(*facs))
+	return facs
+}
@@ -0,0 +1,312 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"unsafe"
+)
+
+var (
+	errUnsupportedRegisterSpace = &kernel.Error{Module: "acpi", Message: "register address space is not supported", Code: kernel.ErrCodeNotSupported}
+	errInvalidRegisterWidth     = &kernel.Error{Module: "acpi", Message: "unsupported register access width", Code: kernel.ErrCodeInvalidArgument}
+	errUnalignedRegisterAccess  = &kernel.Error{Module: "acpi", Message: "register access is not naturally aligned", Code: kernel.ErrCodeInvalidArgument}
+	errRegisterOutOfBounds      = &kernel.Error{Module: "acpi", Message: "access exceeds register block bounds", Code: kernel.ErrCodeInvalidArgument}
+	errBlockNotPresent          = &kernel.Error{Module: "acpi", Message: "register block is not implemented by the platform", Code: kernel.ErrCodeNotFound}
+
+	portReadDwordFn  = cpu.PortReadDword
+	portWriteDwordFn = cpu.PortWriteDword
+	mapRegionFn      = vmm.MapRegion
+
+	// The decoded contents of the FADT located by the driver.
+	activeFADTInfo *table.FADTInfo
+
+	// The pages that have been mapped for accessing memory-mapped
+	// registers, indexed by the physical frame they map.
+	registerMappings = make(map[mm.Frame]mm.Page)
+)
+
+// RegisterBlock provides access to a fixed hardware register block defined
+// by the FADT.
+type RegisterBlock struct {
+	table.RegisterBlock
+}
+
+// Read returns the contents of the width-bit register located at the
+// specified byte offset from the start of the block.
+func (b RegisterBlock) Read(offset uint8, width uint8) (uint64, *kernel.Error) {
+	if err := b.checkAccess(offset, width); err != nil {
+		return 0, err
+	}
+
+	return readRegister(b.Address.Space, b.Address.Address+uint64(offset), width)
+}
+
+// Write stores the lower width bits of val to the register located at the
+// specified byte offset from the start of the block.
+func (b RegisterBlock) Write(offset uint8, width uint8, val uint64) *kernel.Error {
+	if err := b.checkAccess(offset, width); err != nil {
+		return err
+	}
+
+	return writeRegister(b.Address.Space, b.Address.Address+uint64(offset), width, val)
+}
+
+// checkAccess ensures that the block is present and that a width-bit access
+// at offset lies within its bounds.
+func (b RegisterBlock) checkAccess(offset uint8, width uint8) *kernel.Error {
+	if !b.Present() {
+		return errBlockNotPresent
+	}
+
+	if uint16(offset)+uint16(width>>3) > uint16(b.Length) {
+		return errRegisterOutOfBounds
+	}
+
+	return nil
+}
+
+// FADT returns the decoded contents of the FADT or nil if the ACPI driver has
+// not located a valid FADT.
+//
+// The register block accessors that follow return a block that is not
+// Present if the FADT is not available or the platform does not implement
+// the block.
+func FADT() *table.FADTInfo {
+	return activeFADTInfo
+}
+
+// PM1aEventBlock returns the PM1a event register block.
+func PM1aEventBlock() RegisterBlock {
+	return RegisterBlock{fadtInfo().PM1aEventBlock}
+}
+
+// PM1bEventBlock returns the PM1b event register block.
+func PM1bEventBlock() RegisterBlock {
+	return RegisterBlock{fadtInfo().PM1bEventBlock}
+}
+
+// PM1aControlBlock returns the PM1a control register block.
+func PM1aControlBlock() RegisterBlock {
+	return RegisterBlock{fadtInfo().PM1aControlBlock}
+}
+
+// PM1bControlBlock returns the PM1b control register block.
+func PM1bControlBlock() RegisterBlock {
+	return RegisterBlock{fadtInfo().PM1bControlBlock}
+}
+
+// PM2ControlBlock returns the PM2 control register block.
+func PM2ControlBlock() RegisterBlock {
+	return RegisterBlock{fadtInfo().PM2ControlBlock}
+}
+
+// PMTimerBlock returns the power management timer register block.
+func PMTimerBlock() RegisterBlock {
+	return RegisterBlock{fadtInfo().PMTimerBlock}
+}
+
+// GPE0Block returns the general-purpose event 0 register block.
+func GPE0Block() RegisterBlock {
+	return RegisterBlock{fadtInfo().GPE0Block}
+}
+
+// GPE1Block returns the general-purpose event 1 register block.
+func GPE1Block() RegisterBlock {
+	return RegisterBlock{fadtInfo().GPE1Block}
+}
+
+// fadtInfo returns the active FADT contents or an empty FADT if the FADT is
+// not available.
+func fadtInfo() *table.FADTInfo {
+	if activeFADTInfo == nil {
+		return &table.FADTInfo{}
+	}
+
+	return activeFADTInfo
+}
+
+// ReadGenericAddress returns the contents of the register described by addr.
+// The register is accessed using the access size specified by addr (or its
+// bit width if no access size is specified) and the returned value is
+// shifted and masked according to the bit offset and width of addr.
+func ReadGenericAddress(addr table.GenericAddress) (uint64, *kernel.Error) {
+	accessWidth, err := genericAddressWidth(addr)
+	if err != nil {
+		return 0, err
+	}
+
+	val, err := readRegister(addr.Space, addr.Address, accessWidth)
+	if err != nil {
+		return 0, err
+	}
+
+	return (val >> addr.BitOffset) & bitMask(addr.BitWidth), nil
+}
+
+// WriteGenericAddress stores val to the register described by addr. If the
+// register does not span the entire access width, the remaining bits are
+// preserved via a read-modify-write cycle.
+func WriteGenericAddress(addr table.GenericAddress, val uint64) *kernel.Error {
+	accessWidth, err := genericAddressWidth(addr)
+	if err != nil {
+		return err
+	}
+
+	if addr.BitOffset == 0 && (addr.BitWidth == 0 || addr.BitWidth >= accessWidth) {
+		return writeRegister(addr.Space, addr.Address, accessWidth, val)
+	}
+
+	cur, err := readRegister(addr.Space, addr.Address, accessWidth)
+	if err != nil {
+		return err
+	}
+
+	mask := bitMask(addr.BitWidth) << addr.BitOffset
+	return writeRegister(addr.Space, addr.Address, accessWidth, cur&^mask|(val<<addr.BitOffset)&mask)
+}
+
+// genericAddressWidth returns the access width in bits for the register
+// described by addr.
+func genericAddressWidth(addr table.GenericAddress) (uint8, *kernel.Error) {
+	switch {
+	case addr.AccessSize >= 1 && addr.AccessSize <= 4:
+		return 8 << (addr.AccessSize - 1), nil
+	case addr.AccessSize == 0 && addr.BitWidth != 0:
+		return addr.BitWidth, nil
+	default:
+		return 0, errInvalidRegisterWidth
+	}
+}
+
+// bitMask returns a mask with the lower width bits set. A zero width
+// selects all bits.
+func bitMask(width uint8) uint64 {
+	if width == 0 || width >= 64 {
+		return ^uint64(0)
+	}
+
+	return 1<<width - 1
+}
+
+// readRegister reads width bits from the register at the specified address.
+// Registers in the SystemIO and SystemMemory address spaces are supported.
+func readRegister(space table.AddressSpace, addr uint64, width uint8) (uint64, *kernel.Error) {
+	if err := checkRegisterWidth(addr, width); err != nil {
+		return 0, err
+	}
+
+	switch space {
+	case table.AddressSpaceSysIO:
+		port := uint16(addr)
+		switch width {
+		case 8:
+			return uint64(portReadByteFn(port)), nil
+		case 16:
+			return uint64(portReadWordFn(port)), nil
+		case 32:
+			return uint64(portReadDwordFn(port)), nil
+		default:
+			return uint64(portReadDwordFn(port)) | uint64(portReadDwordFn(port+4))<<32, nil
+		}
+	case table.AddressSpaceSysMemory:
+		ptr, err := registerPtr(addr)
+		if err != nil {
+			return 0, err
+		}
+
+		switch width {
+		case 8:
+			return uint64(*(*uint8)(ptr)), nil
+		case 16:
+			return uint64(*(*uint16)(ptr)), nil
+		case 32:
+			return uint64(*(*uint32)(ptr)), nil
+		default:
+			return *(*uint64)(ptr), nil
+		}
+	default:
+		return 0, errUnsupportedRegisterSpace
+	}
+}
+
+// writeRegister writes the lower width bits of val to the register at the
+// specified address. Registers in the SystemIO and SystemMemory address
+// spaces are supported.
+func writeRegister(space table.AddressSpace, addr uint64, width uint8, val uint64) *kernel.Error {
+	if err := checkRegisterWidth(addr, width); err != nil {
+		return err
+	}
+
+	switch space {
+	case table.AddressSpaceSysIO:
+		port := uint16(addr)
+		switch width {
+		case 8:
+			portWriteByteFn(port, uint8(val))
+		case 16:
+			portWriteWordFn(port, uint16(val))
+		case 32:
+			portWriteDwordFn(port, uint32(val))
+		default:
+			portWriteDwordFn(port, uint32(val))
+			portWriteDwordFn(port+4, uint32(val>>32))
+		}
+	case table.AddressSpaceSysMemory:
+		ptr, err := registerPtr(addr)
+		if err != nil {
+			return err
+		}
+
+		switch width {
+		case 8:
+			*(*uint8)(ptr) = uint8(val)
+		case 16:
+			*(*uint16)(ptr) = uint16(val)
+		case 32:
+			*(*uint32)(ptr) = uint32(val)
+		default:
+			*(*uint64)(ptr) = val
+		}
+	default:
+		return errUnsupportedRegisterSpace
+	}
+
+	return nil
+}
+
+// checkRegisterWidth ensures that width is a supported access width and that
+// addr is naturally aligned for it.
+func checkRegisterWidth(addr uint64, width uint8) *kernel.Error {
+	switch width {
+	case 8, 16, 32, 64:
+	default:
+		return errInvalidRegisterWidth
+	}
+
+	if addr&uint64((width>>3)-1) != 0 {
+		return errUnalignedRegisterAccess
+	}
+
+	return nil
+}
+
+// registerPtr returns a pointer to the memory-mapped register at the
+// specified physical address, mapping the page that contains it if required.
+// As register accesses are naturally aligned, they never cross a page
+// boundary.
+func registerPtr(addr uint64) (unsafe.Pointer, *kernel.Error) {
+	frame := mm.FrameFromAddress(uintptr(addr))
+	page, exists := registerMappings[frame]
+	if !exists {
+		var err *kernel.Error
+		if page, err = mapRegionFn(frame, mm.PageSize, vmm.FlagPresent|vmm.FlagRW|vmm.FlagDoNotCache|vmm.FlagNoExecute); err != nil {
+			return nil, err
+		}
+		registerMappings[frame] = page
+	}
+
+	return unsafe.Pointer(page.Address() + vmm.PageOffset(uintptr(addr))), nil
+}
@@ -0,0 +1,205 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"testing"
+	"unsafe"
+)
+
+func TestFADTRegisterBlocks(t *testing.T) {
+	defer restoreRegisterHW()
+	ports := mockRegisterPorts()
+
+	if FADT() != nil || PM1aEventBlock().Present() {
+		t.Fatal("expected no register blocks to be present without a FADT")
+	}
+
+	if _, err := PM1aEventBlock().Read(0, 16); err != errBlockNotPresent {
+		t.Fatalf("expected to get errBlockNotPresent; got %v", err)
+	}
+
+	ioBlock := func(port uint64, length uint8) table.RegisterBlock {
+		return table.RegisterBlock{
+			Address: table.GenericAddress{Space: table.AddressSpaceSysIO, Address: port},
+			Length:  length,
+		}
+	}
+
+	activeFADTInfo = &table.FADTInfo{
+		PM1aEventBlock:   ioBlock(0x400, 4),
+		PM1bEventBlock:   ioBlock(0x500, 4),
+		PM1aControlBlock: ioBlock(0x404, 2),
+		PM1bControlBlock: ioBlock(0x504, 2),
+		PM2ControlBlock:  ioBlock(0x450, 1),
+		PMTimerBlock:     ioBlock(0x408, 4),
+		GPE0Block:        ioBlock(0x420, 8),
+		GPE1Block:        ioBlock(0x440, 4),
+	}
+
+	specs := []struct {
+		block   RegisterBlock
+		offset  uint8
+		width   uint8
+		expPort uint16
+	}{
+		{PM1aEventBlock(), 2, 16, 0x402},
+		{PM1bEventBlock(), 0, 16, 0x500},
+		{PM1aControlBlock(), 0, 16, 0x404},
+		{PM1bControlBlock(), 0, 16, 0x504},
+		{PM2ControlBlock(), 0, 8, 0x450},
+		{PMTimerBlock(), 0, 32, 0x408},
+		{GPE0Block(), 4, 8, 0x424},
+		{GPE1Block(), 0, 32, 0x440},
+	}
+
+	for specIndex, spec := range specs {
+		val := uint64(0x80 + specIndex)
+		if err := spec.block.Write(spec.offset, spec.width, val); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got := ports[spec.expPort]; got != val {
+			t.Errorf("[spec %d] expected port 0x%x to contain 0x%x; got 0x%x", specIndex, spec.expPort, val, got)
+		}
+
+		if got, err := spec.block.Read(spec.offset, spec.width); err != nil || got != val {
+			t.Errorf("[spec %d] expected to read back 0x%x; got 0x%x, %v", specIndex, val, got, err)
+		}
+	}
+
+	if _, err := PM1aControlBlock().Read(1, 16); err != errRegisterOutOfBounds {
+		t.Errorf("expected to get errRegisterOutOfBounds; got %v", err)
+	}
+
+	if err := GPE0Block().Write(1, 16, 0); err != errUnalignedRegisterAccess {
+		t.Errorf("expected to get errUnalignedRegisterAccess; got %v", err)
+	}
+}
+
+func TestGenericAddressAccess(t *testing.T) {
+	defer restoreRegisterHW()
+	ports := mockRegisterPorts()
+
+	// Allocate a buffer large enough to contain an aligned page and use it
+	// as the target for any register mappings
+	buf := make([]byte, 2*mm.PageSize)
+	bufPage := mm.PageFromAddress(uintptr(unsafe.Pointer(&buf[0])) + mm.PageSize - 1)
+	bufBase := bufPage.Address() - uintptr(unsafe.Pointer(&buf[0]))
+
+	var mapCount int
+	mapRegionFn = func(frame mm.Frame, _ uintptr, flags vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		mapCount++
+		if flags&vmm.FlagDoNotCache == 0 {
+			t.Error("expected register page to be mapped with caching disabled")
+		}
+		return bufPage, nil
+	}
+
+	specs := []struct {
+		addr     table.GenericAddress
+		val      uint64
+		expRead  uint64
+		expWrite uint64
+	}{
+		// Byte access to an I/O port
+		{table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 8, Address: 0xcf9}, 0x06, 0x06, 0x06},
+		// Dword access to an I/O port; the access size takes precedence
+		{table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 24, AccessSize: 3, Address: 0x800}, 0xffaabbcc, 0xaabbcc, 0xaabbcc},
+		// Qword access to memory
+		{table.GenericAddress{Space: table.AddressSpaceSysMemory, BitWidth: 64, Address: 0xfed00008}, 0x1122334455667788, 0x1122334455667788, 0x1122334455667788},
+		// Bit field within a memory dword; other bits are preserved
+		{table.GenericAddress{Space: table.AddressSpaceSysMemory, BitWidth: 4, BitOffset: 8, AccessSize: 3, Address: 0xfed00010}, 0x5, 0x5, 0xffff05ff},
+	}
+
+	// Pre-populate the dword targeted by the bit field spec
+	*(*uint32)(unsafe.Pointer(&buf[bufBase+0x10])) = 0xffff0fff
+
+	for specIndex, spec := range specs {
+		if err := WriteGenericAddress(spec.addr, spec.val); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		var written uint64
+		switch spec.addr.Space {
+		case table.AddressSpaceSysIO:
+			written = ports[uint16(spec.addr.Address)]
+		default:
+			written = *(*uint64)(unsafe.Pointer(&buf[bufBase+uintptr(spec.addr.Address&0xfff)]))
+			if spec.addr.AccessSize == 3 {
+				written &= 0xffffffff
+			}
+		}
+
+		if written != spec.expWrite {
+			t.Errorf("[spec %d] expected register to contain 0x%x; got 0x%x", specIndex, spec.expWrite, written)
+		}
+
+		if got, err := ReadGenericAddress(spec.addr); err != nil || got != spec.expRead {
+			t.Errorf("[spec %d] expected to read 0x%x; got 0x%x, %v", specIndex, spec.expRead, got, err)
+		}
+	}
+
+	if mapCount != 1 {
+		t.Errorf("expected the register page to be mapped once; got %d mappings", mapCount)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapRegionFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+
+		specs := []struct {
+			addr   table.GenericAddress
+			expErr *kernel.Error
+		}{
+			{table.GenericAddress{Space: table.AddressSpaceSysMemory, BitWidth: 32, Address: 0x1000}, expErr},
+			{table.GenericAddress{Space: table.AddressSpaceSysMemory, BitWidth: 8, BitOffset: 1, AccessSize: 1, Address: 0x1000}, expErr},
+			{table.GenericAddress{Space: table.AddressSpacePCI, BitWidth: 32}, errUnsupportedRegisterSpace},
+			{table.GenericAddress{Space: table.AddressSpaceSysIO}, errInvalidRegisterWidth},
+			{table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 12}, errInvalidRegisterWidth},
+			{table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 32, Address: 0x802}, errUnalignedRegisterAccess},
+		}
+
+		for specIndex, spec := range specs {
+			if _, err := ReadGenericAddress(spec.addr); err != spec.expErr {
+				t.Errorf("[spec %d] expected read to fail with %v; got %v", specIndex, spec.expErr, err)
+			}
+
+			if err := WriteGenericAddress(spec.addr, 0); err != spec.expErr {
+				t.Errorf("[spec %d] expected write to fail with %v; got %v", specIndex, spec.expErr, err)
+			}
+		}
+	})
+}
+
+// mockRegisterPorts redirects all port accesses to a map indexed by port.
+// Multi-byte accesses are recorded at the first port that they access.
+func mockRegisterPorts() map[uint16]uint64 {
+	ports := make(map[uint16]uint64)
+	portReadByteFn = func(port uint16) uint8 { return uint8(ports[port]) }
+	portReadWordFn = func(port uint16) uint16 { return uint16(ports[port]) }
+	portReadDwordFn = func(port uint16) uint32 { return uint32(ports[port]) }
+	portWriteByteFn = func(port uint16, val uint8) { ports[port] = uint64(val) }
+	portWriteWordFn = func(port uint16, val uint16) { ports[port] = uint64(val) }
+	portWriteDwordFn = func(port uint16, val uint32) { ports[port] = uint64(val) }
+	return ports
+}
+
+func restoreRegisterHW() {
+	portReadByteFn = cpu.PortReadByte
+	portReadWordFn = cpu.PortReadWord
+	portReadDwordFn = cpu.PortReadDword
+	portWriteByteFn = cpu.PortWriteByte
+	portWriteWordFn = cpu.PortWriteWord
+	portWriteDwordFn = cpu.PortWriteDword
+	mapRegionFn = vmm.MapRegion
+	registerMappings = make(map[mm.Frame]mm.Page)
+	activeFADTInfo = nil
+}
@@ -0,0 +1,354 @@
+// Package hpet implements a driver for the High Precision Event Timer. The
+// timer block is located via the HPET ACPI table; its main counter is
+// registered as a clock source and its first comparator as an event source
+// that can replace the PIT.
+package hpet
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/replay"
+	"io"
+	"unsafe"
+)
+
+var (
+	errUnsupportedAddressSpace = &kernel.Error{Module: "hpet", Message: "HPET registers must be located in system memory", Code: kernel.ErrCodeNotSupported}
+	errInvalidPeriod           = &kernel.Error{Module: "hpet", Message: "HPET reports an invalid counter period", Code: kernel.ErrCodeCorrupted}
+	errNoInterruptRoute        = &kernel.Error{Module: "hpet", Message: "HPET comparator 0 supports neither FSB nor legacy replacement interrupt delivery", Code: kernel.ErrCodeNotSupported}
+	errNoPeriodicMode          = &kernel.Error{Module: "hpet", Message: "HPET comparator 0 does not support periodic mode", Code: kernel.ErrCodeNotSupported}
+	errInvalidTimeout          = &kernel.Error{Module: "hpet", Message: "timeout cannot be represented by the HPET comparator", Code: kernel.ErrCodeInvalidArgument}
+
+	lookupTableFn         = acpi.LookupTable
+	mapRegionFn           = vmm.MapRegion
+	handleInterruptFn     = replay.HandleInterrupt
+	registerSourceFn      = clock.RegisterSource
+	registerEventSourceFn = clock.RegisterEventSource
+	replayEngine          = replay.Global()
+)
+
+// The offsets of the timer block registers. The comparator registers of
+// timer N are located at the listed offsets plus N * timerRegStride.
+const (
+	regCapabilities = 0x000
+	regConfig       = 0x010
+	regIntStatus    = 0x020
+	regCounter      = 0x0f0
+
+	regTimerConfig     = 0x100
+	regTimerComparator = 0x108
+	regTimerFSBRoute   = 0x110
+	timerRegStride     = 0x20
+
+	// The size of the register block.
+	regBlockSize = 0x400
+)
+
+// The fields of the general capabilities register.
+const (
+	capComparatorsShift = 8
+	capComparatorsMask  = uint64(0x1f << capComparatorsShift)
+	capCounter64Bit     = uint64(1 << 13)
+	capLegacyRoute      = uint64(1 << 15)
+	capPeriodShift      = 32
+
+	// The maximum counter period in femtoseconds allowed by the spec.
+	maxCounterPeriod = uint64(0x05f5e100)
+)
+
+// The bits of the general configuration register.
+const (
+	cfgEnable      = uint64(1 << 0)
+	cfgLegacyRoute = uint64(1 << 1)
+)
+
+// The bits of the timer configuration registers.
+const (
+	timerLevelTriggered = uint64(1 << 1)
+	timerIntEnable      = uint64(1 << 2)
+	timerPeriodic       = uint64(1 << 3)
+	timerPeriodicCap    = uint64(1 << 4)
+	timerValueSet       = uint64(1 << 6)
+	timerFSBEnable      = uint64(1 << 14)
+	timerFSBCap         = uint64(1 << 15)
+)
+
+const (
+	// The interrupt vector used by comparator 0. When the legacy
+	// replacement route is used, comparator 0 drives IRQ 0 which is
+	// mapped to this vector by the interrupt controller setup code.
+	timerVector = gate.InterruptNumber(0x20)
+
+	// The address of the message written by comparators that use FSB
+	// interrupt delivery. It targets the local APIC of the boot processor.
+	fsbMessageAddress = uint64(0xfee00000)
+
+	// The rating of the HPET clock and event sources.
+	rating = uint8(100)
+
+	// The number of femtoseconds in a nanosecond.
+	fsPerNs = uint64(1000000)
+)
+
+// Driver implements a device.Driver for the HPET. Once initialized, it also
+// implements clock.Source and clock.EventSource.
+type Driver struct {
+	info *table.HPETInfo
+
+	// The virtual address of the mapped register block.
+	regs uintptr
+
+	// The counter period in femtoseconds and the number of comparators.
+	period      uint64
+	comparators uint8
+
+	// Set if comparator 0 supports periodic mode.
+	periodicCapable bool
+
+	// The handler invoked when comparator 0 fires.
+	handler clock.EventHandler
+}
+
+// DriverName returns the name of this driver.
+func (*Driver) DriverName() string {
+	return "HPET"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Driver) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit maps the timer block registers, resets the main counter and
+// registers the HPET as a clock source. If comparator 0 can deliver
+// interrupts, the HPET is also registered as an event source.
+func (d *Driver) DriverInit(w io.Writer) *kernel.Error {
+	if d.info.BaseAddress.Space != table.AddressSpaceSysMemory {
+		return errUnsupportedAddressSpace
+	}
+
+	regAddr := uintptr(d.info.BaseAddress.Address)
+	page, err := mapRegionFn(mm.FrameFromAddress(regAddr), regBlockSize, vmm.FlagPresent|vmm.FlagRW|vmm.FlagDoNotCache)
+	if err != nil {
+		return err
+	}
+	d.regs = page.Address() + vmm.PageOffset(regAddr)
+
+	caps := d.read(regCapabilities)
+	d.period = caps >> capPeriodShift
+	if d.period == 0 || d.period > maxCounterPeriod {
+		return errInvalidPeriod
+	}
+	d.comparators = uint8((caps&capComparatorsMask)>>capComparatorsShift) + 1
+
+	// Halt and reset the main counter and disable all comparators before
+	// setting up the interrupt route for comparator 0.
+	d.write(regConfig, d.read(regConfig)&^(cfgEnable|cfgLegacyRoute))
+	d.write(regCounter, 0)
+	for timer := uint8(0); timer < d.comparators; timer++ {
+		cfgReg := timerReg(regTimerConfig, timer)
+		d.write(cfgReg, d.read(cfgReg)&^(timerIntEnable|timerPeriodic|timerFSBEnable))
+	}
+	d.write(regIntStatus, ^uint64(0))
+
+	routeErr := d.setupInterruptRoute(caps)
+	d.write(regConfig, d.read(regConfig)|cfgEnable)
+
+	counterWidth := 32
+	if caps&capCounter64Bit != 0 {
+		counterWidth = 64
+	}
+	kfmt.Fprintf(w, "%d comparators, %d-bit counter running at %d Hz\n", d.comparators, counterWidth, d.Frequency())
+	registerSourceFn(d)
+
+	if routeErr != nil {
+		kfmt.Fprintf(w, "not used as an event source: %s\n", routeErr.Message)
+		return nil
+	}
+
+	handleInterruptFn(timerVector, 0, d.handleInterrupt)
+	_ = replayEngine.SetInjector(replay.EventTimerTick, d.injectTick)
+	registerEventSourceFn(d)
+	return nil
+}
+
+// setupInterruptRoute configures comparator 0 to raise timerVector. FSB
+// delivery is preferred as it bypasses the interrupt controllers; otherwise,
+// the legacy replacement route is enabled so comparator 0 takes the place of
+// the PIT.
+func (d *Driver) setupInterruptRoute(caps uint64) *kernel.Error {
+	cfgReg := timerReg(regTimerConfig, 0)
+	cfg := d.read(cfgReg)
+	d.periodicCapable = cfg&timerPeriodicCap != 0
+
+	switch {
+	case cfg&timerFSBCap != 0:
+		d.write(timerReg(regTimerFSBRoute, 0), fsbMessageAddress<<32|uint64(timerVector))
+		d.write(cfgReg, cfg|timerFSBEnable)
+	case caps&capLegacyRoute != 0:
+		d.write(regConfig, d.read(regConfig)|cfgLegacyRoute)
+	default:
+		return errNoInterruptRoute
+	}
+
+	return nil
+}
+
+// SourceName implements clock.Source.
+func (*Driver) SourceName() string {
+	return "hpet"
+}
+
+// SourceRating implements clock.Source.
+func (*Driver) SourceRating() uint8 {
+	return rating
+}
+
+// Frequency implements clock.Source.
+func (d *Driver) Frequency() uint64 {
+	return fsPerNs * 1000000000 / d.period
+}
+
+// ReadCounter implements clock.Source.
+func (d *Driver) ReadCounter() uint64 {
+	return d.read(regCounter)
+}
+
+// EventSourceName implements clock.EventSource.
+func (*Driver) EventSourceName() string {
+	return "hpet"
+}
+
+// EventSourceRating implements clock.EventSource.
+func (*Driver) EventSourceRating() uint8 {
+	return rating
+}
+
+// SetPeriodic implements clock.EventSource. Periods shorter than the minimum
+// tick reported by the HPET table are rejected.
+func (d *Driver) SetPeriodic(period uint64, handler clock.EventHandler) *kernel.Error {
+	if !d.periodicCapable {
+		return errNoPeriodicMode
+	}
+
+	ticks, err := d.ticksFor(period)
+	if err != nil {
+		return err
+	}
+
+	if ticks < uint64(d.info.MinimumTick) {
+		return errInvalidTimeout
+	}
+
+	d.handler = handler
+
+	// With the value-set bit enabled, the first comparator write sets the
+	// time of the first interrupt and the second sets the period.
+	cfgReg := timerReg(regTimerConfig, 0)
+	d.write(cfgReg, (d.read(cfgReg)&^timerLevelTriggered)|timerIntEnable|timerPeriodic|timerValueSet)
+	d.write(timerReg(regTimerComparator, 0), d.ReadCounter()+ticks)
+	d.write(timerReg(regTimerComparator, 0), ticks)
+	return nil
+}
+
+// SetOneShot implements clock.EventSource.
+func (d *Driver) SetOneShot(delay uint64, handler clock.EventHandler) *kernel.Error {
+	ticks, err := d.ticksFor(delay)
+	if err != nil {
+		return err
+	}
+
+	d.handler = handler
+
+	cfgReg := timerReg(regTimerConfig, 0)
+	d.write(cfgReg, (d.read(cfgReg)&^(timerLevelTriggered|timerPeriodic))|timerIntEnable)
+	d.write(timerReg(regTimerComparator, 0), d.ReadCounter()+ticks)
+	return nil
+}
+
+// Stop implements clock.EventSource.
+func (d *Driver) Stop() {
+	cfgReg := timerReg(regTimerConfig, 0)
+	d.write(cfgReg, d.read(cfgReg)&^(timerIntEnable|timerPeriodic))
+	d.handler = nil
+}
+
+// ticksFor converts a timeout in nanoseconds into counter ticks. Timeouts
+// that round down to zero ticks or exceed the counter width are rejected.
+func (d *Driver) ticksFor(ns uint64) (uint64, *kernel.Error) {
+	if ns == 0 || ns > ^uint64(0)/fsPerNs {
+		return 0, errInvalidTimeout
+	}
+
+	ticks := ns * fsPerNs / d.period
+	if ticks == 0 || (!d.info.Counter64Bit && ticks > 0xffffffff) {
+		return 0, errInvalidTimeout
+	}
+
+	return ticks, nil
+}
+
+// handleInterrupt is invoked when comparator 0 fires.
+func (d *Driver) handleInterrupt(_ *gate.Registers) {
+	// Acknowledge the interrupt; this is only required for level-triggered
+	// interrupts but is harmless otherwise.
+	d.write(regIntStatus, 1)
+
+	// While replaying an event log, ticks are delivered by injectTick.
+	if replay.Tick(d.ReadCounter()) && d.handler != nil {
+		d.handler()
+	}
+}
+
+// injectTick is registered as the replay engine injector for timer tick
+// events and delivers each replayed tick to the event handler.
+func (d *Driver) injectTick(_ replay.Event) {
+	if d.handler != nil {
+		d.handler()
+	}
+}
+
+// read returns the contents of the register at the specified offset.
+func (d *Driver) read(offset uintptr) uint64 {
+	return *(*uint64)(unsafe.Pointer(d.regs + offset))
+}
+
+// write stores val to the register at the specified offset.
+func (d *Driver) write(offset uintptr, val uint64) {
+	*(*uint64)(unsafe.Pointer(d.regs + offset)) = val
+}
+
+// timerReg returns the offset of a comparator register for the specified
+// timer.
+func timerReg(reg uintptr, timer uint8) uintptr {
+	return reg + uintptr(timer)*timerRegStride
+}
+
+// probeForHPET checks for the presence of a HPET table.
+func probeForHPET() device.Driver {
+	header := lookupTableFn("HPET")
+	if header == nil {
+		return nil
+	}
+
+	info, err := table.DecodeHPET(header)
+	if err != nil {
+		return nil
+	}
+
+	return &Driver{info: info}
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForHPET,
+	})
+}
@@ -0,0 +1,351 @@
+package hpet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/replay"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+
+	specs := []struct {
+		caps, timer0Cfg uint64
+		expConfig       uint64
+		expTimer0Cfg    uint64
+		expFSBRoute     uint64
+		expEventSource  bool
+		expOutput       string
+	}{
+		// FSB delivery is preferred over the legacy replacement route
+		{
+			testCaps | capLegacyRoute, timerFSBCap | timerPeriodicCap | timerIntEnable,
+			cfgEnable,
+			timerFSBCap | timerPeriodicCap | timerFSBEnable,
+			fsbMessageAddress<<32 | uint64(timerVector),
+			true,
+			"3 comparators, 64-bit counter running at 14318179 Hz",
+		},
+		{
+			testCaps | capLegacyRoute, timerPeriodicCap,
+			cfgEnable | cfgLegacyRoute,
+			timerPeriodicCap,
+			0,
+			true,
+			"3 comparators, 64-bit counter running at 14318179 Hz",
+		},
+		// No interrupt route; only registered as a clock source
+		{
+			testCaps &^ capCounter64Bit, 0,
+			cfgEnable,
+			0,
+			0,
+			false,
+			"3 comparators, 32-bit counter running at 14318179 Hz\nnot used as an event source: " + errNoInterruptRoute.Message,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		regs := newFakeRegs()
+		regs[regCapabilities/8] = spec.caps
+		regs[regConfig/8] = cfgLegacyRoute
+		regs[regCounter/8] = 0xbadf00d
+		regs[timerReg(regTimerConfig, 0)/8] = spec.timer0Cfg
+		regs[timerReg(regTimerConfig, 2)/8] = timerIntEnable | timerPeriodic
+
+		var (
+			installedVector gate.InterruptNumber
+			source          clock.Source
+			eventSource     clock.EventSource
+		)
+		handleInterruptFn = func(vector gate.InterruptNumber, _ uint8, _ func(*gate.Registers)) {
+			installedVector = vector
+		}
+		registerSourceFn = func(src clock.Source) { source = src }
+		registerEventSourceFn = func(src clock.EventSource) { eventSource = src }
+
+		drv := &Driver{info: regs.info()}
+		var buf bytes.Buffer
+		if err := drv.DriverInit(&buf); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got := strings.TrimSpace(buf.String()); got != spec.expOutput {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, spec.expOutput, got)
+		}
+
+		if got := regs[regConfig/8]; got != spec.expConfig {
+			t.Errorf("[spec %d] expected general config to be 0x%x; got 0x%x", specIndex, spec.expConfig, got)
+		}
+
+		if got := regs[regCounter/8]; got != 0 {
+			t.Errorf("[spec %d] expected main counter to be reset; got 0x%x", specIndex, got)
+		}
+
+		if got := regs[timerReg(regTimerConfig, 0)/8]; got != spec.expTimer0Cfg {
+			t.Errorf("[spec %d] expected timer 0 config to be 0x%x; got 0x%x", specIndex, spec.expTimer0Cfg, got)
+		}
+
+		if got := regs[timerReg(regTimerConfig, 2)/8]; got != 0 {
+			t.Errorf("[spec %d] expected timer 2 to be disabled; got config 0x%x", specIndex, got)
+		}
+
+		if got := regs[timerReg(regTimerFSBRoute, 0)/8]; got != spec.expFSBRoute {
+			t.Errorf("[spec %d] expected timer 0 FSB route to be 0x%x; got 0x%x", specIndex, spec.expFSBRoute, got)
+		}
+
+		if source != drv {
+			t.Errorf("[spec %d] expected the driver to be registered as a clock source", specIndex)
+		}
+
+		if gotEventSource := eventSource == drv && installedVector == timerVector; gotEventSource != spec.expEventSource {
+			t.Errorf("[spec %d] expected the driver to be registered as an event source: %t; got %t", specIndex, spec.expEventSource, gotEventSource)
+		}
+	}
+}
+
+func TestDriverInitErrors(t *testing.T) {
+	defer restoreFns()
+
+	t.Run("unsupported address space", func(t *testing.T) {
+		drv := &Driver{info: &table.HPETInfo{BaseAddress: table.GenericAddress{Space: table.AddressSpaceSysIO}}}
+		if err := drv.DriverInit(&bytes.Buffer{}); err != errUnsupportedAddressSpace {
+			t.Fatalf("expected to get error %v; got %v", errUnsupportedAddressSpace, err)
+		}
+	})
+
+	t.Run("map error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapRegionFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+
+		drv := &Driver{info: &table.HPETInfo{}}
+		if err := drv.DriverInit(&bytes.Buffer{}); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+
+	t.Run("invalid period", func(t *testing.T) {
+		for _, period := range []uint64{0, maxCounterPeriod + 1} {
+			regs := newFakeRegs()
+			regs[regCapabilities/8] = period << capPeriodShift
+
+			drv := &Driver{info: regs.info()}
+			if err := drv.DriverInit(&bytes.Buffer{}); err != errInvalidPeriod {
+				t.Errorf("expected to get error %v for period %d; got %v", errInvalidPeriod, period, err)
+			}
+		}
+	})
+}
+
+func TestEventSource(t *testing.T) {
+	defer restoreFns()
+
+	regs := newFakeRegs()
+	regs[regCapabilities/8] = testCaps
+	regs[timerReg(regTimerConfig, 0)/8] = timerFSBCap | timerPeriodicCap | timerLevelTriggered
+
+	var irqHandler func(*gate.Registers)
+	handleInterruptFn = func(_ gate.InterruptNumber, _ uint8, handler func(*gate.Registers)) {
+		irqHandler = handler
+	}
+
+	info := regs.info()
+	info.MinimumTick = 0x80
+	drv := &Driver{info: info}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	var fired int
+	handler := func() { fired++ }
+
+	t.Run("periodic", func(t *testing.T) {
+		regs[regCounter/8] = 1000
+
+		// 1ms at ~14.318MHz
+		if err := drv.SetPeriodic(1000000, handler); err != nil {
+			t.Fatal(err)
+		}
+
+		expCfg := timerFSBCap | timerPeriodicCap | timerFSBEnable | timerIntEnable | timerPeriodic | timerValueSet
+		if got := regs[timerReg(regTimerConfig, 0)/8]; got != expCfg {
+			t.Errorf("expected timer 0 config to be 0x%x; got 0x%x", expCfg, got)
+		}
+
+		// The fake register block retains the last comparator write
+		if exp, got := uint64(14318), regs[timerReg(regTimerComparator, 0)/8]; got != exp {
+			t.Errorf("expected comparator to be set to %d; got %d", exp, got)
+		}
+
+		regs[regIntStatus/8] = 0
+		irqHandler(nil)
+		if fired != 1 || regs[regIntStatus/8] != 1 {
+			t.Errorf("expected the interrupt to be acknowledged and the handler to be invoked")
+		}
+	})
+
+	t.Run("one-shot", func(t *testing.T) {
+		regs[regCounter/8] = 1000
+
+		if err := drv.SetOneShot(1000, handler); err != nil {
+			t.Fatal(err)
+		}
+
+		expCfg := timerFSBCap | timerPeriodicCap | timerFSBEnable | timerIntEnable | timerValueSet
+		if got := regs[timerReg(regTimerConfig, 0)/8]; got != expCfg {
+			t.Errorf("expected timer 0 config to be 0x%x; got 0x%x", expCfg, got)
+		}
+
+		if exp, got := uint64(1014), regs[timerReg(regTimerComparator, 0)/8]; got != exp {
+			t.Errorf("expected comparator to be set to %d; got %d", exp, got)
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		defer replay.Global().Stop()
+
+		// A log with a single timer tick event at timestamp 0
+		log := make([]byte, 30)
+		binary.LittleEndian.PutUint32(log[0:], 0x4c505247)
+		binary.LittleEndian.PutUint16(log[4:], 1)
+		binary.LittleEndian.PutUint32(log[8:], 1)
+		log[12] = uint8(replay.EventTimerTick)
+		if err := replay.Global().StartReplay(log); err != nil {
+			t.Fatal(err)
+		}
+
+		fired = 0
+		irqHandler(nil)
+		if fired != 0 {
+			t.Fatal("expected the handler not to be invoked by the timer interrupt while replaying")
+		}
+
+		if replay.Global().Poll() != 1 || fired != 1 {
+			t.Fatal("expected the replayed tick to be delivered to the handler")
+		}
+	})
+
+	t.Run("stop", func(t *testing.T) {
+		drv.Stop()
+
+		if got := regs[timerReg(regTimerConfig, 0)/8]; got&(timerIntEnable|timerPeriodic) != 0 {
+			t.Errorf("expected timer 0 to be disabled; got config 0x%x", got)
+		}
+
+		fired = 0
+		irqHandler(nil)
+		if fired != 0 {
+			t.Error("expected the handler not to be invoked after Stop")
+		}
+	})
+
+	t.Run("invalid timeouts", func(t *testing.T) {
+		specs := []struct {
+			fn      func() *kernel.Error
+			counter bool
+		}{
+			{func() *kernel.Error { return drv.SetOneShot(0, handler) }, true},
+			// Rounds down to 0 ticks
+			{func() *kernel.Error { return drv.SetOneShot(50, handler) }, true},
+			{func() *kernel.Error { return drv.SetOneShot(^uint64(0), handler) }, true},
+			// Below the minimum tick
+			{func() *kernel.Error { return drv.SetPeriodic(1000, handler) }, true},
+			// Exceeds the width of a 32-bit counter
+			{func() *kernel.Error { return drv.SetOneShot(400000000000, handler) }, false},
+		}
+
+		for specIndex, spec := range specs {
+			info.Counter64Bit = spec.counter
+			if err := spec.fn(); err != errInvalidTimeout {
+				t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, errInvalidTimeout, err)
+			}
+		}
+		info.Counter64Bit = true
+	})
+
+	t.Run("periodic mode not supported", func(t *testing.T) {
+		drv.periodicCapable = false
+		if err := drv.SetPeriodic(1000000, handler); err != errNoPeriodicMode {
+			t.Fatalf("expected to get error %v; got %v", errNoPeriodicMode, err)
+		}
+	})
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	hpetTable := append([]byte("HPET"), make([]byte, 52)...)
+	hpetTable[4] = byte(len(hpetTable))
+
+	specs := []struct {
+		header    *table.SDTHeader
+		expDriver bool
+	}{
+		{nil, false},
+		{(*table.SDTHeader)(unsafe.Pointer(&hpetTable[0])), true},
+		// Truncated table
+		{&table.SDTHeader{Signature: [4]byte{'H', 'P', 'E', 'T'}, Length: 36}, false},
+	}
+
+	for specIndex, spec := range specs {
+		lookupTableFn = func(string) *table.SDTHeader { return spec.header }
+
+		if drv := probeForHPET(); (drv != nil) != spec.expDriver {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDriver, drv)
+		}
+	}
+}
+
+// testCaps describes a timer block with 3 comparators, a 64-bit counter and a
+// counter period of 69841279 fs (~14.318 MHz).
+const testCaps = uint64(69841279)<<capPeriodShift | uint64(2)<<capComparatorsShift | capCounter64Bit
+
+// fakeRegs emulates the HPET register block using plain memory.
+type fakeRegs []uint64
+
+// newFakeRegs allocates a page-aligned fake register block and installs a
+// mapRegionFn that maps it.
+func newFakeRegs() fakeRegs {
+	buf := make([]uint64, (regBlockSize+mm.PageSize)/8)
+	offset := (mm.PageSize - uintptr(unsafe.Pointer(&buf[0]))&(mm.PageSize-1)) & (mm.PageSize - 1)
+	regs := fakeRegs(buf[offset/8 : offset/8+regBlockSize/8])
+
+	mapRegionFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.PageFromAddress(frame.Address()), nil
+	}
+
+	return regs
+}
+
+// info returns a HPETInfo that points to the fake register block.
+func (r fakeRegs) info() *table.HPETInfo {
+	return &table.HPETInfo{
+		Counter64Bit: true,
+		BaseAddress: table.GenericAddress{
+			Space:   table.AddressSpaceSysMemory,
+			Address: uint64(uintptr(unsafe.Pointer(&r[0]))),
+		},
+	}
+}
+
+func restoreFns() {
+	lookupTableFn = acpi.LookupTable
+	mapRegionFn = vmm.MapRegion
+	handleInterruptFn = replay.HandleInterrupt
+	registerSourceFn = clock.RegisterSource
+	registerEventSourceFn = clock.RegisterEventSource
+	_ = replayEngine.SetInjector(replay.EventTimerTick, nil)
+}
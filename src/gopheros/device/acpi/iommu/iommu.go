@@ -0,0 +1,695 @@
+// Package iommu implements a driver for Intel VT-d DMA remapping hardware.
+// The remapping units are located via the DMAR ACPI table. Devices can either
+// be attached to a Domain whose second-level page tables translate the I/O
+// virtual addresses used by the device into physical addresses or be
+// configured for pass-through (identity) DMA.
+//
+// The driver does not enable DMA remapping by itself; this is left to the
+// code that attaches devices to domains once it has set up the translations
+// required by the devices that it manages.
+package iommu
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"io"
+	"unsafe"
+)
+
+var (
+	errNoUsableUnits     = &kernel.Error{Module: "iommu", Message: "no usable DMA remapping units found", Code: kernel.ErrCodeNotFound}
+	errNonCoherentUnit   = &kernel.Error{Module: "iommu", Message: "remapping units that do not snoop their translation tables are not supported", Code: kernel.ErrCodeNotSupported}
+	errUnsupportedAGAW   = &kernel.Error{Module: "iommu", Message: "remapping unit supports neither 3- nor 4-level page tables", Code: kernel.ErrCodeNotSupported}
+	errNoFreeDomains     = &kernel.Error{Module: "iommu", Message: "remapping unit has no free domain IDs", Code: kernel.ErrCodeOutOfMemory}
+	errNoPassThrough     = &kernel.Error{Module: "iommu", Message: "remapping unit does not support pass-through translation", Code: kernel.ErrCodeNotSupported}
+	errForeignDomain     = &kernel.Error{Module: "iommu", Message: "domain belongs to a different remapping unit", Code: kernel.ErrCodeInvalidArgument}
+	errIOVAOutOfRange    = &kernel.Error{Module: "iommu", Message: "I/O virtual address exceeds the domain address width", Code: kernel.ErrCodeInvalidArgument}
+	errUnalignedIOVA     = &kernel.Error{Module: "iommu", Message: "I/O virtual address is not page-aligned", Code: kernel.ErrCodeInvalidArgument}
+	errCommandTimeout    = &kernel.Error{Module: "iommu", Message: "remapping unit did not complete command", Code: kernel.ErrCodeTimeout}
+	errInvalidPermission = &kernel.Error{Module: "iommu", Message: "mappings must grant read and/or write access", Code: kernel.ErrCodeInvalidArgument}
+
+	lookupTableFn = acpi.LookupTable
+	mapRegionFn   = vmm.MapRegion
+	allocFrameFn  = mm.AllocFrame
+	readRegFn     = readReg
+	writeRegFn    = writeReg
+
+	// activeDriver points to the initialized driver instance and is used
+	// by UnitFor.
+	activeDriver *Driver
+
+	// The virtual addresses of the frames that hold translation tables.
+	tableMappings = make(map[mm.Frame]uintptr)
+)
+
+// The offsets of the remapping unit registers.
+const (
+	regVersion        = 0x00
+	regCapability     = 0x08
+	regExtCapability  = 0x10
+	regGlobalCommand  = 0x18
+	regGlobalStatus   = 0x1c
+	regRootTableAddr  = 0x20
+	regContextCommand = 0x28
+
+	// The IOTLB invalidate register is located at a unit-specific offset
+	// that is reported by the extended capabilities register.
+	regIOTLBInvalidate = 0x08
+)
+
+// The fields of the capability and extended capability registers.
+const (
+	capNumDomainsMask = uint64(0x7)
+	capWriteBufFlush  = uint64(1 << 4)
+	capCachingMode    = uint64(1 << 7)
+	capSAGAWShift     = 8
+	capSAGAW39Bit     = uint64(1 << (capSAGAWShift + 1))
+	capSAGAW48Bit     = uint64(1 << (capSAGAWShift + 2))
+
+	ecapCoherent    = uint64(1 << 0)
+	ecapPassThrough = uint64(1 << 6)
+	ecapIROShift    = 8
+	ecapIROMask     = uint64(0x3ff << ecapIROShift)
+)
+
+// The bits of the global command and status registers.
+const (
+	gcmdTranslationEnable = uint32(1 << 31)
+	gcmdSetRootTablePtr   = uint32(1 << 30)
+	gcmdWriteBufferFlush  = uint32(1 << 27)
+
+	// Masks out the status bits of one-shot commands so that the global
+	// status register contents can be used as the base for a command.
+	gstsPersistentMask = uint32(0x96ffffff)
+)
+
+// The bits of the context command and IOTLB invalidate registers.
+const (
+	ccmdInvalidate     = uint64(1 << 63)
+	ccmdGlobal         = uint64(1 << 61)
+	iotlbInvalidate    = uint64(1 << 63)
+	iotlbGlobal        = uint64(1 << 60)
+	iotlbDrainReads    = uint64(1 << 49)
+	iotlbDrainWrites   = uint64(1 << 48)
+	invalidateMaxPolls = 0x100000
+)
+
+// The fields of root, context and second-level page table entries.
+const (
+	entryPresent     = uint64(1 << 0)
+	entryAddrMask    = uint64(0x000ffffffffff000)
+	ctxPassThrough   = uint64(2 << 2)
+	ctxDomainIDShift = 8
+	tableEntries     = 512
+	pageShift        = uint(mm.PageShift)
+	ptLevelShift     = 9
+	ptIndexMask      = uint64(tableEntries - 1)
+)
+
+// Permission describes the type of DMA access granted by a mapping.
+type Permission uint64
+
+// The supported DMA access permissions.
+const (
+	PermRead  Permission = 1 << 0
+	PermWrite Permission = 1 << 1
+)
+
+// Driver implements a device.Driver for the DMA remapping units described by
+// the DMAR table.
+type Driver struct {
+	info  *table.DMARInfo
+	units []*Unit
+}
+
+// DriverName returns the name of this driver.
+func (*Driver) DriverName() string {
+	return "VT-d IOMMU"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Driver) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit maps the registers of each remapping unit and allocates its root
+// table. Units that cannot be used by the driver are skipped.
+func (d *Driver) DriverInit(w io.Writer) *kernel.Error {
+	for index := range d.info.Units {
+		unitInfo := &d.info.Units[index]
+		unit, err := newUnit(unitInfo, d.info.ReservedRegions)
+		if err != nil {
+			kfmt.Fprintf(w, "unit at 0x%x: skipped: %s\n", unitInfo.RegisterBase, err.Message)
+			continue
+		}
+
+		version := readRegFn(unit.regs+regVersion, 32)
+		kfmt.Fprintf(w, "unit at 0x%x: version %d.%d, segment %d, %d domains, %d-level page tables\n",
+			unitInfo.RegisterBase, version>>4&0xf, version&0xf, unitInfo.Segment, unit.domainCount, unit.levels,
+		)
+		d.units = append(d.units, unit)
+	}
+
+	if len(d.units) == 0 {
+		return errNoUsableUnits
+	}
+
+	activeDriver = d
+	return nil
+}
+
+// UnitFor returns the remapping unit that handles DMA requests from the
+// specified PCI function or nil if no unit handles the function.
+func (d *Driver) UnitFor(segment uint16, bus, dev, fn uint8) *Unit {
+	var includeAll *Unit
+	for _, unit := range d.units {
+		if unit.info.Segment != segment {
+			continue
+		}
+
+		if unit.info.IncludeAll {
+			includeAll = unit
+			continue
+		}
+
+		if scopeMatches(unit.info.Scopes, bus, dev, fn) {
+			return unit
+		}
+	}
+
+	return includeAll
+}
+
+// UnitFor returns the remapping unit that handles DMA requests from the
+// specified PCI function or nil if the driver has not been initialized or no
+// unit handles the function.
+func UnitFor(segment uint16, bus, dev, fn uint8) *Unit {
+	if activeDriver == nil {
+		return nil
+	}
+
+	return activeDriver.UnitFor(segment, bus, dev, fn)
+}
+
+// scopeMatches returns true if any of the device scopes refers to the
+// specified PCI function. Only scopes that refer to functions located on
+// their start bus are supported as locating functions behind bridges
+// requires access to the configuration space of the bridges.
+func scopeMatches(scopes []table.DMARDeviceScope, bus, dev, fn uint8) bool {
+	for _, scope := range scopes {
+		if scope.Type == table.DMARScopePCIEndpoint && scope.StartBus == bus &&
+			len(scope.Path) == 1 && scope.Path[0].Device == dev && scope.Path[0].Function == fn {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Unit describes a DMA remapping hardware unit.
+type Unit struct {
+	info *table.DMARHardwareUnit
+
+	// The virtual address of the mapped register block.
+	regs uintptr
+
+	cap, ecap uint64
+
+	// The number of page table levels used by the domains of this unit
+	// and the number of domain IDs supported by the unit.
+	levels      uint8
+	domainCount uint32
+
+	// The next domain ID to allocate and the ID of the domain used for
+	// pass-through translations (0 if not yet allocated).
+	nextDomainID  uint32
+	passThroughID uint16
+
+	// The frame that holds the root table.
+	rootTable mm.Frame
+
+	// The reserved memory regions in the unit's segment.
+	reserved []table.DMARReservedMemory
+
+	enabled bool
+}
+
+// newUnit maps the registers of the remapping unit described by info and
+// allocates its root table.
+func newUnit(info *table.DMARHardwareUnit, reserved []table.DMARReservedMemory) (*Unit, *kernel.Error) {
+	regAddr := uintptr(info.RegisterBase)
+	page, err := mapRegionFn(mm.FrameFromAddress(regAddr), mm.PageSize, vmm.FlagPresent|vmm.FlagRW|vmm.FlagDoNotCache)
+	if err != nil {
+		return nil, err
+	}
+
+	unit := &Unit{
+		info:         info,
+		regs:         page.Address() + vmm.PageOffset(regAddr),
+		nextDomainID: 1,
+	}
+	unit.cap = readRegFn(unit.regs+regCapability, 64)
+	unit.ecap = readRegFn(unit.regs+regExtCapability, 64)
+
+	// The IOTLB registers may be located past the first page.
+	if regBlockSize := unit.iotlbReg() + 8; regBlockSize > mm.PageSize {
+		if page, err = mapRegionFn(mm.FrameFromAddress(regAddr), regBlockSize, vmm.FlagPresent|vmm.FlagRW|vmm.FlagDoNotCache); err != nil {
+			return nil, err
+		}
+		unit.regs = page.Address() + vmm.PageOffset(regAddr)
+	}
+
+	switch {
+	case unit.ecap&ecapCoherent == 0:
+		return nil, errNonCoherentUnit
+	case unit.cap&capSAGAW48Bit != 0:
+		unit.levels = 4
+	case unit.cap&capSAGAW39Bit != 0:
+		unit.levels = 3
+	default:
+		return nil, errUnsupportedAGAW
+	}
+
+	unit.domainCount = 1 << (4 + 2*(unit.cap&capNumDomainsMask))
+
+	if unit.rootTable, err = allocTable(); err != nil {
+		return nil, err
+	}
+
+	for _, region := range reserved {
+		if region.Segment == info.Segment {
+			unit.reserved = append(unit.reserved, region)
+		}
+	}
+
+	return unit, nil
+}
+
+// NewDomain allocates a new translation domain. DMA requests from devices
+// attached to the domain are blocked unless they target an I/O virtual
+// address that has been mapped via Domain.Map.
+func (u *Unit) NewDomain() (*Domain, *kernel.Error) {
+	id, err := u.allocDomainID()
+	if err != nil {
+		return nil, err
+	}
+
+	root, err := allocTable()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Domain{unit: u, id: id, root: root}, nil
+}
+
+// allocDomainID reserves a domain ID. Domain ID 0 is never allocated as it is
+// reserved by units that operate in caching mode.
+func (u *Unit) allocDomainID() (uint16, *kernel.Error) {
+	if u.nextDomainID >= u.domainCount {
+		return 0, errNoFreeDomains
+	}
+
+	u.nextDomainID++
+	return uint16(u.nextDomainID - 1), nil
+}
+
+// Attach routes the DMA requests of the specified PCI function through the
+// page tables of dom. The reserved memory regions that the function may
+// access are identity-mapped into dom before the function is attached.
+func (u *Unit) Attach(bus, dev, fn uint8, dom *Domain) *kernel.Error {
+	if dom.unit != u {
+		return errForeignDomain
+	}
+
+	for _, region := range u.reserved {
+		if !scopeMatches(region.Scopes, bus, dev, fn) {
+			continue
+		}
+
+		if err := dom.MapIdentity(region.Base, region.Limit, PermRead|PermWrite); err != nil {
+			return err
+		}
+	}
+
+	return u.setContext(bus, dev, fn,
+		uint64(dom.root.Address())|entryPresent,
+		uint64(u.levels-2)|uint64(dom.id)<<ctxDomainIDShift,
+	)
+}
+
+// AttachPassThrough configures the unit so that the DMA requests of the
+// specified PCI function bypass address translation.
+func (u *Unit) AttachPassThrough(bus, dev, fn uint8) *kernel.Error {
+	if u.ecap&ecapPassThrough == 0 {
+		return errNoPassThrough
+	}
+
+	if u.passThroughID == 0 {
+		id, err := u.allocDomainID()
+		if err != nil {
+			return err
+		}
+		u.passThroughID = id
+	}
+
+	return u.setContext(bus, dev, fn,
+		ctxPassThrough|entryPresent,
+		uint64(u.levels-2)|uint64(u.passThroughID)<<ctxDomainIDShift,
+	)
+}
+
+// setContext populates the context entry for the specified PCI function,
+// allocating the context table for its bus if required. If translation is
+// enabled, the cached context entries and translations are invalidated.
+func (u *Unit) setContext(bus, dev, fn uint8, lo, hi uint64) *kernel.Error {
+	rootAddr, err := tableAddr(u.rootTable)
+	if err != nil {
+		return err
+	}
+
+	rootEntry := (*[2]uint64)(unsafe.Pointer(rootAddr + uintptr(bus)*16))
+	if rootEntry[0]&entryPresent == 0 {
+		ctxTable, err := allocTable()
+		if err != nil {
+			return err
+		}
+		rootEntry[0] = uint64(ctxTable.Address()) | entryPresent
+	}
+
+	ctxAddr, err := tableAddr(mm.Frame(rootEntry[0] >> mm.PageShift))
+	if err != nil {
+		return err
+	}
+
+	// The present bit must be set after the rest of the entry has been
+	// populated.
+	devfn := uintptr(dev&0x1f)<<3 | uintptr(fn&0x7)
+	ctxEntry := (*[2]uint64)(unsafe.Pointer(ctxAddr + devfn*16))
+	ctxEntry[0] = 0
+	ctxEntry[1] = hi
+	ctxEntry[0] = lo
+
+	if !u.enabled {
+		return nil
+	}
+
+	if err = u.invalidateContextCache(); err != nil {
+		return err
+	}
+	return u.invalidateIOTLB()
+}
+
+// Enable installs the unit's root table and enables DMA remapping. From this
+// point on, DMA requests from functions that have not been attached to a
+// domain are blocked.
+func (u *Unit) Enable() *kernel.Error {
+	writeRegFn(u.regs+regRootTableAddr, 64, uint64(u.rootTable.Address()))
+	if err := u.globalCommand(gcmdSetRootTablePtr, true); err != nil {
+		return err
+	}
+
+	if err := u.invalidateContextCache(); err != nil {
+		return err
+	}
+
+	if err := u.invalidateIOTLB(); err != nil {
+		return err
+	}
+
+	if err := u.globalCommand(gcmdTranslationEnable, true); err != nil {
+		return err
+	}
+
+	u.enabled = true
+	return nil
+}
+
+// Enabled returns true if DMA remapping has been enabled for this unit.
+func (u *Unit) Enabled() bool {
+	return u.enabled
+}
+
+// globalCommand issues a command via the global command register and waits
+// for the corresponding global status bit to become set (or clear if
+// waitSet is false).
+func (u *Unit) globalCommand(cmd uint32, waitSet bool) *kernel.Error {
+	status := uint32(readRegFn(u.regs+regGlobalStatus, 32)) & gstsPersistentMask
+	writeRegFn(u.regs+regGlobalCommand, 32, uint64(status|cmd))
+
+	for polls := 0; polls < invalidateMaxPolls; polls++ {
+		if isSet := uint32(readRegFn(u.regs+regGlobalStatus, 32))&cmd != 0; isSet == waitSet {
+			return nil
+		}
+	}
+
+	return errCommandTimeout
+}
+
+// flushWriteBuffer flushes the internal write buffers of units that require
+// it before their cached translations are invalidated.
+func (u *Unit) flushWriteBuffer() *kernel.Error {
+	if u.cap&capWriteBufFlush == 0 {
+		return nil
+	}
+
+	return u.globalCommand(gcmdWriteBufferFlush, false)
+}
+
+// invalidateContextCache invalidates all cached context entries.
+func (u *Unit) invalidateContextCache() *kernel.Error {
+	if err := u.flushWriteBuffer(); err != nil {
+		return err
+	}
+
+	return u.invalidate(regContextCommand, ccmdInvalidate|ccmdGlobal, ccmdInvalidate)
+}
+
+// invalidateIOTLB invalidates all cached translations.
+func (u *Unit) invalidateIOTLB() *kernel.Error {
+	if err := u.flushWriteBuffer(); err != nil {
+		return err
+	}
+
+	return u.invalidate(u.iotlbReg(), iotlbInvalidate|iotlbGlobal|iotlbDrainReads|iotlbDrainWrites, iotlbInvalidate)
+}
+
+// invalidate writes cmd to the specified invalidation register and waits for
+// the unit to clear the busy bit.
+func (u *Unit) invalidate(reg uintptr, cmd, busy uint64) *kernel.Error {
+	writeRegFn(u.regs+reg, 64, cmd)
+	for polls := 0; polls < invalidateMaxPolls; polls++ {
+		if readRegFn(u.regs+reg, 64)&busy == 0 {
+			return nil
+		}
+	}
+
+	return errCommandTimeout
+}
+
+// iotlbReg returns the offset of the IOTLB invalidate register.
+func (u *Unit) iotlbReg() uintptr {
+	return uintptr((u.ecap&ecapIROMask)>>ecapIROShift)*16 + regIOTLBInvalidate
+}
+
+// Domain describes a set of I/O virtual address translations that is shared
+// by the PCI functions attached to it.
+type Domain struct {
+	unit *Unit
+	id   uint16
+
+	// The frame that holds the top-level page table.
+	root mm.Frame
+}
+
+// ID returns the domain ID.
+func (d *Domain) ID() uint16 {
+	return d.id
+}
+
+// Map establishes a translation from the page-aligned I/O virtual address
+// iova to frame. Any existing translation for iova is replaced.
+func (d *Domain) Map(iova uint64, frame mm.Frame, perm Permission) *kernel.Error {
+	if perm&(PermRead|PermWrite) == 0 || perm&^(PermRead|PermWrite) != 0 {
+		return errInvalidPermission
+	}
+
+	pte, err := d.leafEntry(iova, true)
+	if err != nil {
+		return err
+	}
+
+	replaced := *pte&entryPresent != 0
+	*pte = uint64(frame.Address()) | uint64(perm)
+
+	// Units operating in caching mode may also cache non-present entries.
+	if d.unit.enabled && (replaced || d.unit.cap&capCachingMode != 0) {
+		return d.unit.invalidateIOTLB()
+	}
+
+	return nil
+}
+
+// MapIdentity establishes identity translations for all pages that overlap
+// the physical address range [base, limit].
+func (d *Domain) MapIdentity(base, limit uint64, perm Permission) *kernel.Error {
+	for addr := base &^ uint64(mm.PageSize-1); addr <= limit; addr += uint64(mm.PageSize) {
+		if err := d.Map(addr, mm.FrameFromAddress(uintptr(addr)), perm); err != nil {
+			return err
+		}
+
+		// Guard against wrapping around for regions that end at the top
+		// of the address space.
+		if addr+uint64(mm.PageSize) < addr {
+			break
+		}
+	}
+
+	return nil
+}
+
+// Unmap removes the translation for the page-aligned I/O virtual address
+// iova. Unmapping an address that is not mapped is a no-op.
+func (d *Domain) Unmap(iova uint64) *kernel.Error {
+	pte, err := d.leafEntry(iova, false)
+	if err != nil || pte == nil || *pte&(uint64(PermRead|PermWrite)) == 0 {
+		return err
+	}
+
+	*pte = 0
+	if d.unit.enabled {
+		return d.unit.invalidateIOTLB()
+	}
+
+	return nil
+}
+
+// Translate returns the frame and permissions for the page-aligned I/O
+// virtual address iova. It returns false if iova is not mapped.
+func (d *Domain) Translate(iova uint64) (mm.Frame, Permission, bool) {
+	pte, err := d.leafEntry(iova, false)
+	if err != nil || pte == nil || *pte&(uint64(PermRead|PermWrite)) == 0 {
+		return mm.InvalidFrame, 0, false
+	}
+
+	return mm.FrameFromAddress(uintptr(*pte & entryAddrMask)), Permission(*pte) & (PermRead | PermWrite), true
+}
+
+// leafEntry returns a pointer to the last-level page table entry for iova.
+// Missing intermediate tables are allocated if alloc is true; otherwise, a
+// nil entry is returned if a table is missing.
+func (d *Domain) leafEntry(iova uint64, alloc bool) (*uint64, *kernel.Error) {
+	if iova&uint64(mm.PageSize-1) != 0 {
+		return nil, errUnalignedIOVA
+	}
+
+	levels := uint(d.unit.levels)
+	if iova>>(pageShift+ptLevelShift*levels) != 0 {
+		return nil, errIOVAOutOfRange
+	}
+
+	tableFrame := d.root
+	for level := levels; ; level-- {
+		addr, err := tableAddr(tableFrame)
+		if err != nil {
+			return nil, err
+		}
+
+		index := (iova >> (pageShift + ptLevelShift*(level-1))) & ptIndexMask
+		pte := (*uint64)(unsafe.Pointer(addr + uintptr(index)*8))
+		if level == 1 {
+			return pte, nil
+		}
+
+		if *pte&(uint64(PermRead|PermWrite)) == 0 {
+			if !alloc {
+				return nil, nil
+			}
+
+			next, err := allocTable()
+			if err != nil {
+				return nil, err
+			}
+			*pte = uint64(next.Address()) | uint64(PermRead|PermWrite)
+		}
+
+		tableFrame = mm.FrameFromAddress(uintptr(*pte & entryAddrMask))
+	}
+}
+
+// allocTable allocates and clears a frame for storing a translation table.
+func allocTable() (mm.Frame, *kernel.Error) {
+	frame, err := allocFrameFn()
+	if err != nil {
+		return mm.InvalidFrame, err
+	}
+
+	addr, err := tableAddr(frame)
+	if err != nil {
+		return mm.InvalidFrame, err
+	}
+
+	kernel.Memset(addr, 0, mm.PageSize)
+	return frame, nil
+}
+
+// tableAddr returns the virtual address of a translation table frame, mapping
+// the frame if required.
+func tableAddr(frame mm.Frame) (uintptr, *kernel.Error) {
+	if addr, exists := tableMappings[frame]; exists {
+		return addr, nil
+	}
+
+	page, err := mapRegionFn(frame, mm.PageSize, vmm.FlagPresent|vmm.FlagRW)
+	if err != nil {
+		return 0, err
+	}
+
+	tableMappings[frame] = page.Address()
+	return page.Address(), nil
+}
+
+// readReg returns the contents of the width-bit register at addr.
+func readReg(addr uintptr, width uint8) uint64 {
+	if width == 32 {
+		return uint64(*(*uint32)(unsafe.Pointer(addr)))
+	}
+
+	return *(*uint64)(unsafe.Pointer(addr))
+}
+
+// writeReg stores val to the width-bit register at addr.
+func writeReg(addr uintptr, width uint8, val uint64) {
+	if width == 32 {
+		*(*uint32)(unsafe.Pointer(addr)) = uint32(val)
+		return
+	}
+
+	*(*uint64)(unsafe.Pointer(addr)) = val
+}
+
+// probeForDMAR checks for the presence of a DMAR table.
+func probeForDMAR() device.Driver {
+	header := lookupTableFn("DMAR")
+	if header == nil {
+		return nil
+	}
+
+	info, err := table.DecodeDMAR(header)
+	if err != nil {
+		return nil
+	}
+
+	return &Driver{info: info}
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForDMAR,
+	})
+}
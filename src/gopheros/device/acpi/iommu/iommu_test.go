@@ -0,0 +1,526 @@
+package iommu
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// The first fake physical frame handed out for translation tables.
+const fakeTableFrame = mm.Frame(0x100000)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreHW()
+	hw := newFakeHW(t, 4)
+
+	// Unit 0: 4-level page tables, 256 domains, IOTLB registers at 0x108
+	hw.setCaps(0xfed90000, capSAGAW48Bit|capSAGAW39Bit|2, ecapCoherent|ecapPassThrough|0x10<<ecapIROShift)
+	hw.regs[0xfed90000+regVersion] = 0x10
+	// Unit 1: does not snoop its translation tables
+	hw.setCaps(0xfed91000, capSAGAW39Bit, 0)
+	// Unit 2: only supports 5-level page tables
+	hw.setCaps(0xfed92000, 1<<(capSAGAWShift+3), ecapCoherent)
+
+	drv := &Driver{info: &table.DMARInfo{
+		Units: []table.DMARHardwareUnit{
+			{
+				RegisterBase: 0xfed90000,
+				Scopes: []table.DMARDeviceScope{
+					{Type: table.DMARScopePCIEndpoint, Path: []table.DMARPathEntry{{Device: 2, Function: 0}}},
+				},
+			},
+			{RegisterBase: 0xfed91000, IncludeAll: true},
+			{RegisterBase: 0xfed92000, Segment: 1, IncludeAll: true},
+		},
+	}}
+
+	var buf bytes.Buffer
+	if err := drv.DriverInit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, exp := range []string{
+		"unit at 0xfed90000: version 1.0, segment 0, 256 domains, 4-level page tables",
+		"unit at 0xfed91000: skipped: " + errNonCoherentUnit.Message,
+		"unit at 0xfed92000: skipped: " + errUnsupportedAGAW.Message,
+	} {
+		if !strings.Contains(buf.String(), exp) {
+			t.Errorf("expected driver output to contain %q; got:\n%s", exp, buf.String())
+		}
+	}
+
+	if len(drv.units) != 1 {
+		t.Fatalf("expected 1 usable unit; got %d", len(drv.units))
+	}
+
+	if got := drv.units[0].iotlbReg(); got != 0x108 {
+		t.Errorf("expected IOTLB register offset to be 0x108; got 0x%x", got)
+	}
+
+	specs := []struct {
+		segment      uint16
+		bus, dev, fn uint8
+		expUnit      *Unit
+	}{
+		{0, 0, 2, 0, drv.units[0]},
+		{0, 0, 2, 1, nil},
+		{1, 0, 2, 0, nil},
+	}
+
+	for specIndex, spec := range specs {
+		if got := UnitFor(spec.segment, spec.bus, spec.dev, spec.fn); got != spec.expUnit {
+			t.Errorf("[spec %d] expected UnitFor to return %p; got %p", specIndex, spec.expUnit, got)
+		}
+	}
+
+	t.Run("no usable units", func(t *testing.T) {
+		drv := &Driver{info: &table.DMARInfo{
+			Units: []table.DMARHardwareUnit{{RegisterBase: 0xfed91000}},
+		}}
+
+		if err := drv.DriverInit(&buf); err != errNoUsableUnits {
+			t.Fatalf("expected to get errNoUsableUnits; got %v", err)
+		}
+	})
+
+	t.Run("map error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapRegionFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+
+		if _, err := newUnit(&table.DMARHardwareUnit{RegisterBase: 0xfed90000}, nil); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestDomainMapping(t *testing.T) {
+	defer restoreHW()
+	hw := newFakeHW(t, 8)
+	hw.setCaps(0xfed90000, capSAGAW39Bit, ecapCoherent|0x10<<ecapIROShift)
+
+	unit, err := newUnit(&table.DMARHardwareUnit{RegisterBase: 0xfed90000}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dom, err := unit.NewDomain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dom.ID() != 1 {
+		t.Errorf("expected the first domain to get ID 1; got %d", dom.ID())
+	}
+
+	specs := []struct {
+		iova  uint64
+		frame mm.Frame
+		perm  Permission
+	}{
+		{0, 0x10, PermRead},
+		{0x1000, 0x11, PermRead | PermWrite},
+		// Requires a new level 1 table
+		{0x200000, 0x12, PermWrite},
+		// Requires new level 2 and level 1 tables
+		{0x7fc0000000, 0x13, PermRead},
+	}
+
+	for specIndex, spec := range specs {
+		if err := dom.Map(spec.iova, spec.frame, spec.perm); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if frame, perm, ok := dom.Translate(spec.iova); !ok || frame != spec.frame || perm != spec.perm {
+			t.Errorf("[spec %d] expected iova 0x%x to translate to frame %d (%d); got %d (%d), %t", specIndex, spec.iova, spec.frame, spec.perm, frame, perm, ok)
+		}
+	}
+
+	// root table, domain top-level table, 3 level 2/1 tables for the first
+	// two mappings and 2 for the last one
+	if exp := 1 + 1 + 2 + 1 + 2; hw.allocCount != exp {
+		t.Errorf("expected %d table frames to be allocated; got %d", exp, hw.allocCount)
+	}
+
+	if _, _, ok := dom.Translate(0x400000); ok {
+		t.Error("expected unmapped iova not to be translated")
+	}
+
+	if err := dom.Unmap(0x1000); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, ok := dom.Translate(0x1000); ok {
+		t.Error("expected iova not to be translated after being unmapped")
+	}
+
+	// Unmapping an iova with no page tables is a no-op
+	if err := dom.Unmap(0x40000000); err != nil {
+		t.Fatal(err)
+	}
+
+	if hw.iotlbInvalidations != 0 {
+		t.Errorf("expected no IOTLB invalidations while translation is disabled; got %d", hw.iotlbInvalidations)
+	}
+
+	t.Run("invalidation", func(t *testing.T) {
+		if err := unit.Enable(); err != nil {
+			t.Fatal(err)
+		}
+		invalidations := hw.iotlbInvalidations
+
+		// Populating a non-present entry does not require an invalidation
+		if err := dom.Map(0x2000, 0x14, PermRead); err != nil {
+			t.Fatal(err)
+		}
+
+		// Replacing and removing translations does
+		if err := dom.Map(0x2000, 0x15, PermRead); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := dom.Unmap(0x2000); err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := invalidations + 2; hw.iotlbInvalidations != exp {
+			t.Errorf("expected %d IOTLB invalidations; got %d", exp, hw.iotlbInvalidations)
+		}
+	})
+
+	t.Run("identity mapping", func(t *testing.T) {
+		if err := dom.MapIdentity(0x5800, 0x7000, PermRead); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, frame := range []mm.Frame{5, 6, 7} {
+			if got, _, ok := dom.Translate(uint64(frame.Address())); !ok || got != frame {
+				t.Errorf("expected frame %d to be identity-mapped; got %d, %t", frame, got, ok)
+			}
+		}
+
+		if _, _, ok := dom.Translate(0x8000); ok {
+			t.Error("expected identity mapping to end at the region limit")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		specs := []struct {
+			iova   uint64
+			perm   Permission
+			expErr *kernel.Error
+		}{
+			{0x1001, PermRead, errUnalignedIOVA},
+			{1 << 39, PermRead, errIOVAOutOfRange},
+			{0x1000, 0, errInvalidPermission},
+			{0x1000, 1 << 2, errInvalidPermission},
+		}
+
+		for specIndex, spec := range specs {
+			if err := dom.Map(spec.iova, 0x10, spec.perm); err != spec.expErr {
+				t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			}
+		}
+
+		if err := dom.Unmap(1 << 39); err != errIOVAOutOfRange {
+			t.Errorf("expected to get errIOVAOutOfRange; got %v", err)
+		}
+
+		expErr := &kernel.Error{Module: "test", Message: "out of memory"}
+		allocFrameFn = func() (mm.Frame, *kernel.Error) { return mm.InvalidFrame, expErr }
+		if err := dom.Map(0x10000000, 0x10, PermRead); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if _, err := unit.NewDomain(); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestAttach(t *testing.T) {
+	defer restoreHW()
+	hw := newFakeHW(t, 16)
+	hw.setCaps(0xfed90000, capSAGAW48Bit|capWriteBufFlush, ecapCoherent|ecapPassThrough|0x10<<ecapIROShift)
+
+	unit, err := newUnit(&table.DMARHardwareUnit{RegisterBase: 0xfed90000}, []table.DMARReservedMemory{
+		{
+			Base:  0x3e000000,
+			Limit: 0x3e001fff,
+			Scopes: []table.DMARDeviceScope{
+				{Type: table.DMARScopePCIEndpoint, Path: []table.DMARPathEntry{{Device: 0x14, Function: 0}}},
+			},
+		},
+		// Belongs to a different segment
+		{Segment: 1, Base: 0x3f000000, Limit: 0x3f000fff},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unit.reserved) != 1 {
+		t.Fatalf("expected the unit to track 1 reserved region; got %d", len(unit.reserved))
+	}
+
+	dom, err := unit.NewDomain()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = unit.Attach(0, 0x14, 0, dom); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, iova := range []uint64{0x3e000000, 0x3e001000} {
+		if frame, _, ok := dom.Translate(iova); !ok || frame != mm.FrameFromAddress(uintptr(iova)) {
+			t.Errorf("expected reserved region page 0x%x to be identity-mapped", iova)
+		}
+	}
+
+	if err = unit.AttachPassThrough(0, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		devfn  uintptr
+		expLo  uint64
+		expHi  uint64
+		expLbl string
+	}{
+		{0x14 << 3, uint64(dom.root.Address()) | entryPresent, 2 | 1<<ctxDomainIDShift, "translated"},
+		{2 << 3, ctxPassThrough | entryPresent, 2 | 2<<ctxDomainIDShift, "pass-through"},
+	}
+
+	rootEntry := hw.entry(unit.rootTable, 0)
+	if rootEntry[0]&entryPresent == 0 {
+		t.Fatal("expected root entry for bus 0 to be present")
+	}
+	ctxTable := mm.Frame(rootEntry[0] >> mm.PageShift)
+
+	for specIndex, spec := range specs {
+		ctxEntry := hw.entry(ctxTable, spec.devfn)
+		if ctxEntry[0] != spec.expLo || ctxEntry[1] != spec.expHi {
+			t.Errorf("[spec %d] expected %s context entry to be 0x%x:0x%x; got 0x%x:0x%x", specIndex, spec.expLbl, spec.expHi, spec.expLo, ctxEntry[1], ctxEntry[0])
+		}
+	}
+
+	if hw.ctxInvalidations != 0 {
+		t.Errorf("expected no context cache invalidations while translation is disabled; got %d", hw.ctxInvalidations)
+	}
+
+	if unit.Enabled() {
+		t.Error("expected translation to be disabled")
+	}
+
+	if err = unit.Enable(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !unit.Enabled() {
+		t.Error("expected unit to report translation as enabled")
+	}
+
+	if got := hw.regs[0xfed90000+regRootTableAddr]; got != uint64(unit.rootTable.Address()) {
+		t.Errorf("expected root table address register to be 0x%x; got 0x%x", unit.rootTable.Address(), got)
+	}
+
+	if got := uint32(hw.regs[0xfed90000+regGlobalStatus]); got&gcmdTranslationEnable == 0 {
+		t.Error("expected translation to be enabled")
+	}
+
+	// Attaching devices while translation is enabled invalidates the
+	// cached context entries and translations.
+	if err = unit.AttachPassThrough(0, 3, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if hw.ctxInvalidations != 2 || hw.iotlbInvalidations != 2 || hw.bufferFlushes != 4 {
+		t.Errorf("expected 2 context, 2 IOTLB invalidations and 4 write buffer flushes; got %d, %d, %d", hw.ctxInvalidations, hw.iotlbInvalidations, hw.bufferFlushes)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		other, err := newUnit(&table.DMARHardwareUnit{RegisterBase: 0xfed90000}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = other.Attach(0, 0x14, 0, dom); err != errForeignDomain {
+			t.Errorf("expected to get errForeignDomain; got %v", err)
+		}
+
+		other.ecap &^= ecapPassThrough
+		if err = other.AttachPassThrough(0, 2, 0); err != errNoPassThrough {
+			t.Errorf("expected to get errNoPassThrough; got %v", err)
+		}
+
+		// 16 domain IDs; ID 0 is never allocated
+		other.domainCount = 16
+		other.nextDomainID = 15
+		if _, err = other.NewDomain(); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = other.NewDomain(); err != errNoFreeDomains {
+			t.Errorf("expected to get errNoFreeDomains; got %v", err)
+		}
+
+		// The unit never acknowledges commands
+		writeRegFn = func(addr uintptr, _ uint8, val uint64) { hw.regs[addr] = val }
+		if err = other.Enable(); err != errCommandTimeout {
+			t.Errorf("expected to get errCommandTimeout; got %v", err)
+		}
+	})
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreHW()
+
+	lookupTableFn = func(string) *table.SDTHeader { return nil }
+	if drv := probeForDMAR(); drv != nil {
+		t.Fatal("expected probe to fail when the DMAR table is missing")
+	}
+
+	data := make([]byte, 48+16)
+	copy(data, "DMAR")
+	data[4] = byte(len(data))
+	data[36] = 38
+	// DRHD: include all, registers at 0xfed90000
+	copy(data[48:], []byte{0x00, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0xd9, 0xfe})
+
+	lookupTableFn = func(signature string) *table.SDTHeader {
+		if signature != "DMAR" {
+			t.Errorf("expected probe to look up the DMAR table; got %q", signature)
+		}
+		return (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	}
+
+	drv, ok := probeForDMAR().(*Driver)
+	if !ok {
+		t.Fatal("expected probe to return a Driver")
+	}
+
+	if drv.DriverName() == "" {
+		t.Error("expected DriverName() to return a non-empty string")
+	}
+
+	if major, minor, patch := drv.DriverVersion(); major+minor+patch == 0 {
+		t.Error("expected DriverVersion() to return a non-zero version")
+	}
+
+	if len(drv.info.Units) != 1 || drv.info.Units[0].RegisterBase != 0xfed90000 {
+		t.Errorf("expected probe to decode the DMAR table; got %+v", drv.info)
+	}
+
+	// Malformed table
+	data[48+2] = 0xff
+	if drv := probeForDMAR(); drv != nil {
+		t.Fatal("expected probe to fail when the DMAR table is malformed")
+	}
+}
+
+// fakeHW emulates the registers of remapping units and provides the frames
+// used for translation tables.
+type fakeHW struct {
+	t *testing.T
+
+	// The register contents, indexed by register address.
+	regs map[uintptr]uint64
+
+	// The backing store for the table frames and the address of its
+	// first page-aligned byte.
+	mem     []byte
+	memBase uintptr
+
+	frameCount, allocCount int
+
+	ctxInvalidations, iotlbInvalidations, bufferFlushes int
+}
+
+func newFakeHW(t *testing.T, frameCount int) *fakeHW {
+	hw := &fakeHW{
+		t:          t,
+		regs:       make(map[uintptr]uint64),
+		mem:        make([]byte, (frameCount+1)*int(mm.PageSize)),
+		frameCount: frameCount,
+	}
+	hw.memBase = (uintptr(unsafe.Pointer(&hw.mem[0])) + mm.PageSize - 1) &^ (mm.PageSize - 1)
+
+	allocFrameFn = func() (mm.Frame, *kernel.Error) {
+		if hw.allocCount == hw.frameCount {
+			t.Fatal("fake hardware ran out of frames")
+		}
+
+		// Fill the frame with garbage to ensure that tables are cleared
+		frame := fakeTableFrame + mm.Frame(hw.allocCount)
+		kernel.Memset(hw.memBase+uintptr(hw.allocCount)*mm.PageSize, 0xaa, mm.PageSize)
+		hw.allocCount++
+		return frame, nil
+	}
+
+	mapRegionFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		if frame >= fakeTableFrame {
+			return mm.PageFromAddress(hw.memBase + uintptr(frame-fakeTableFrame)*mm.PageSize), nil
+		}
+
+		// Register blocks are identity-mapped and only accessed via
+		// readRegFn and writeRegFn.
+		return mm.Page(frame), nil
+	}
+
+	readRegFn = func(addr uintptr, width uint8) uint64 {
+		if width == 32 {
+			return uint64(uint32(hw.regs[addr]))
+		}
+		return hw.regs[addr]
+	}
+
+	writeRegFn = func(addr uintptr, width uint8, val uint64) {
+		regBase := addr &^ (mm.PageSize - 1)
+		switch reg := addr - regBase; {
+		case reg == regGlobalCommand:
+			if uint32(val)&gcmdWriteBufferFlush != 0 {
+				hw.bufferFlushes++
+			}
+			hw.regs[regBase+regGlobalStatus] = val & uint64(gcmdTranslationEnable|gcmdSetRootTablePtr)
+		case reg == regContextCommand:
+			hw.ctxInvalidations++
+			hw.regs[addr] = val &^ ccmdInvalidate
+		case val&iotlbInvalidate != 0:
+			hw.iotlbInvalidations++
+			hw.regs[addr] = val &^ iotlbInvalidate
+		default:
+			hw.regs[addr] = val
+		}
+	}
+
+	return hw
+}
+
+// setCaps sets the capability registers of the unit at regBase.
+func (hw *fakeHW) setCaps(regBase uintptr, capVal, ecapVal uint64) {
+	hw.regs[regBase+regCapability] = capVal
+	hw.regs[regBase+regExtCapability] = ecapVal
+}
+
+// entry returns the 128-bit entry at the specified index of a root or
+// context table frame.
+func (hw *fakeHW) entry(frame mm.Frame, index uintptr) *[2]uint64 {
+	return (*[2]uint64)(unsafe.Pointer(hw.memBase + uintptr(frame-fakeTableFrame)*mm.PageSize + index*16))
+}
+
+func restoreHW() {
+	lookupTableFn = acpi.LookupTable
+	mapRegionFn = vmm.MapRegion
+	allocFrameFn = mm.AllocFrame
+	readRegFn = readReg
+	writeRegFn = writeReg
+	activeDriver = nil
+	tableMappings = make(map[mm.Frame]uintptr)
+}
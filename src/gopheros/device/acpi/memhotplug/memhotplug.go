@@ -0,0 +1,318 @@
+// Package memhotplug implements a driver for ACPI memory devices (PNP0C80).
+// The driver hands the memory of hot-plugged devices to the physical memory
+// manager when the firmware reports a device check and removes it again when
+// the firmware requests the device to be ejected.
+package memhotplug
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/device"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm/pmm"
+	"io"
+)
+
+var (
+	errMalformedCRS = &kernel.Error{Module: "acpi_memhotplug", Message: "_CRS must evaluate to a resource template buffer", Code: kernel.ErrCodeCorrupted}
+	errMalformedPXM = &kernel.Error{Module: "acpi_memhotplug", Message: "_PXM must evaluate to an integer", Code: kernel.ErrCodeCorrupted}
+	errNoMemory     = &kernel.Error{Module: "acpi_memhotplug", Message: "memory device does not describe any memory ranges", Code: kernel.ErrCodeNotFound}
+	errBootMemory   = &kernel.Error{Module: "acpi_memhotplug", Message: "memory reported by the boot memory map cannot be removed", Code: kernel.ErrCodeNotSupported}
+
+	// The following functions are used by tests to mock calls to the pmm
+	// package.
+	addMemoryFn    = pmm.AddMemory
+	removeMemoryFn = pmm.RemoveMemory
+	assignNodeFn   = pmm.AssignNode
+)
+
+// memoryDeviceHID is the hardware ID of ACPI memory devices.
+const memoryDeviceHID = "PNP0C80"
+
+// The status codes reported to the firmware via _OST.
+const (
+	ostSuccess             = uint64(0x00)
+	ostFailure             = uint64(0x01)
+	ostEjectNotSupported   = uint64(0x80)
+	ostInsertDriverFailure = uint64(0x81)
+	ostEjectDeviceBusy     = uint64(0x82)
+)
+
+// memRange describes a memory range of a memory device.
+type memRange struct {
+	base, length uint64
+
+	// boot is set for ranges that were already managed by the physical
+	// memory manager when the device was probed. As these ranges were
+	// reported by the boot memory map they cannot be removed.
+	boot bool
+}
+
+// Device drives an ACPI memory device.
+type Device struct {
+	vm   *aml.VM
+	node *aml.NamespaceNode
+
+	// ranges contains the memory ranges of the device that are managed
+	// by the physical memory manager.
+	ranges []memRange
+}
+
+// Name returns the namespace path of the device.
+func (d *Device) Name() string {
+	return d.node.Path()
+}
+
+// Online returns true if the memory of the device is managed by the physical
+// memory manager.
+func (d *Device) Online() bool {
+	return len(d.ranges) != 0
+}
+
+// Size returns the size in bytes of the device memory that is managed by the
+// physical memory manager.
+func (d *Device) Size() uint64 {
+	var size uint64
+	for _, r := range d.ranges {
+		size += r.length
+	}
+	return size
+}
+
+// check evaluates the device status and adds the device memory to the
+// physical memory manager if the device is present and enabled. Memory that
+// the physical memory manager already manages is assumed to have been
+// reported by the boot memory map.
+func (d *Device) check() *kernel.Error {
+	info, err := device.Identify(d.vm, d.node)
+	if err != nil {
+		return err
+	}
+
+	if d.Online() || !info.Present() || info.Status&device.StatusEnabled == 0 {
+		return nil
+	}
+
+	ranges, err := d.memoryRanges()
+	if err != nil {
+		return err
+	}
+
+	node, hasNode, err := d.proximityDomain()
+	if err != nil {
+		return err
+	}
+
+	for index := range ranges {
+		r := &ranges[index]
+		if err = addMemoryFn(r.base, r.length); err != nil && err.Code == kernel.ErrCodeAlreadyExists {
+			r.boot = true
+		} else if err != nil {
+			// Roll back the ranges added so far
+			d.ranges = ranges[:index]
+			_ = d.offline()
+			return err
+		}
+
+		if hasNode && !r.boot {
+			_ = assignNodeFn(node, r.base, r.length)
+		}
+	}
+
+	d.ranges = ranges
+	return nil
+}
+
+// eject removes the device memory from the physical memory manager and asks
+// the firmware to eject the device via _EJ0.
+func (d *Device) eject() *kernel.Error {
+	for _, r := range d.ranges {
+		if r.boot {
+			return errBootMemory
+		}
+	}
+
+	if err := d.offline(); err != nil {
+		return err
+	}
+
+	if ej0 := d.node.Child("_EJ0"); ej0 != nil {
+		if _, err := d.vm.Evaluate(ej0.Path(), uint64(1)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// offline removes the hot-added device memory from the physical memory
+// manager. If any of the ranges cannot be removed, the ranges that were
+// already removed are added back and an error is returned.
+func (d *Device) offline() *kernel.Error {
+	for index, r := range d.ranges {
+		if r.boot {
+			continue
+		}
+
+		if err := removeMemoryFn(r.base, r.length); err != nil {
+			for _, removed := range d.ranges[:index] {
+				if !removed.boot {
+					_ = addMemoryFn(removed.base, removed.length)
+				}
+			}
+			return err
+		}
+	}
+
+	d.ranges = nil
+	return nil
+}
+
+// memoryRanges decodes the _CRS object of the device and returns the memory
+// ranges that it describes.
+func (d *Device) memoryRanges() ([]memRange, *kernel.Error) {
+	crs := d.node.Child("_CRS")
+	if crs == nil {
+		return nil, errMalformedCRS
+	}
+
+	val, err := d.vm.Evaluate(crs.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	template, ok := val.([]byte)
+	if !ok {
+		return nil, errMalformedCRS
+	}
+
+	descriptors, err := resource.Decode(template)
+	if err != nil {
+		return nil, err
+	}
+
+	var ranges []memRange
+	for _, desc := range descriptors {
+		switch res := desc.(type) {
+		case *resource.Address:
+			if res.ResourceType == resource.AddressTypeMemory && res.Length != 0 {
+				ranges = append(ranges, memRange{base: res.Min, length: res.Length})
+			}
+		case *resource.Memory32Fixed:
+			if res.Length != 0 {
+				ranges = append(ranges, memRange{base: uint64(res.Base), length: uint64(res.Length)})
+			}
+		}
+	}
+
+	if len(ranges) == 0 {
+		return nil, errNoMemory
+	}
+
+	return ranges, nil
+}
+
+// proximityDomain evaluates the optional _PXM object of the device and
+// returns the NUMA node that the device memory belongs to.
+func (d *Device) proximityDomain() (uint32, bool, *kernel.Error) {
+	pxm := d.node.Child("_PXM")
+	if pxm == nil {
+		return 0, false, nil
+	}
+
+	val, err := d.vm.Evaluate(pxm.Path())
+	if err != nil {
+		return 0, false, err
+	}
+
+	domain, ok := val.(uint64)
+	if !ok {
+		return 0, false, errMalformedPXM
+	}
+
+	return uint32(domain), true, nil
+}
+
+// reportStatus reports the outcome of processing a notification to the
+// firmware via the optional _OST object of the device.
+func (d *Device) reportStatus(event, status uint64) {
+	if ost := d.node.Child("_OST"); ost != nil {
+		_, _ = d.vm.Evaluate(ost.Path(), event, status, []byte{})
+	}
+}
+
+// Probe locates the memory devices in ns, adds the memory of the devices that
+// are present and installs notify handlers that add or remove the device
+// memory when the firmware reports a device check or an eject request.
+// Devices whose memory cannot be added or whose notify handlers cannot be
+// installed are skipped and the errors are reported to errWriter.
+func Probe(errWriter io.Writer, vm *aml.VM, ns *aml.Namespace) ([]*Device, *kernel.Error) {
+	devices, err := device.Enumerate(vm, ns)
+	if err != nil {
+		return nil, err
+	}
+
+	var memDevices []*Device
+	for _, dev := range devices {
+		if !dev.Matches(memoryDeviceHID) {
+			continue
+		}
+
+		memDev := &Device{vm: vm, node: dev.Node}
+		if err = memDev.check(); err != nil {
+			kfmt.Fprintf(errWriter, "[acpi_memhotplug] %s: %s\n", dev.Path, err.Error())
+			continue
+		}
+
+		handler := func(_ *aml.NamespaceNode, value uint64) {
+			memDev.handleNotify(errWriter, value)
+		}
+
+		if err = vm.InstallNotifyHandler(dev.Path, handler); err != nil {
+			kfmt.Fprintf(errWriter, "[acpi_memhotplug] %s: %s\n", dev.Path, err.Error())
+			continue
+		}
+
+		memDevices = append(memDevices, memDev)
+	}
+
+	return memDevices, nil
+}
+
+// handleNotify processes a notification sent by the firmware to the device.
+func (d *Device) handleNotify(errWriter io.Writer, value uint64) {
+	var (
+		err           *kernel.Error
+		failureStatus = ostFailure
+	)
+
+	switch value {
+	case aml.NotifyBusCheck, aml.NotifyDeviceCheck:
+		wasOnline := d.Online()
+		if err = d.check(); err == nil && !wasOnline && d.Online() {
+			kfmt.Fprintf(errWriter, "[acpi_memhotplug] %s: added %dM\n", d.Name(), d.Size()>>20)
+		}
+		failureStatus = ostInsertDriverFailure
+	case aml.NotifyEjectRequest:
+		size := d.Size()
+		if err = d.eject(); err == nil {
+			kfmt.Fprintf(errWriter, "[acpi_memhotplug] %s: removed %dM\n", d.Name(), size>>20)
+		}
+
+		failureStatus = ostEjectDeviceBusy
+		if err == errBootMemory {
+			failureStatus = ostEjectNotSupported
+		}
+	default:
+		return
+	}
+
+	if err != nil {
+		kfmt.Fprintf(errWriter, "[acpi_memhotplug] %s: %s\n", d.Name(), err.Error())
+		d.reportStatus(value, failureStatus)
+		return
+	}
+
+	d.reportStatus(value, ostSuccess)
+}
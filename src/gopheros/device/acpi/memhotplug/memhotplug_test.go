@@ -0,0 +1,272 @@
+package memhotplug
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/kernel"
+	"gopheros/kernel/mm/pmm"
+	"reflect"
+	"testing"
+)
+
+func TestProbe(t *testing.T) {
+	defer func() {
+		addMemoryFn = pmm.AddMemory
+		removeMemoryFn = pmm.RemoveMemory
+		assignNodeFn = pmm.AssignNode
+	}()
+
+	var (
+		added, removed, assigned [][2]uint64
+		removeErr                *kernel.Error
+		errInUse                 = &kernel.Error{Module: "test", Message: "in use", Code: kernel.ErrCodeBusy}
+		errManaged               = &kernel.Error{Module: "test", Message: "managed", Code: kernel.ErrCodeAlreadyExists}
+	)
+
+	addMemoryFn = func(base, length uint64) *kernel.Error {
+		// Memory below 4G is reported by the boot memory map
+		if base < 1<<32 {
+			return errManaged
+		}
+		added = append(added, [2]uint64{base, length})
+		return nil
+	}
+	removeMemoryFn = func(base, length uint64) *kernel.Error {
+		if removeErr != nil {
+			return removeErr
+		}
+		removed = append(removed, [2]uint64{base, length})
+		return nil
+	}
+	assignNodeFn = func(node uint32, base, length uint64) *kernel.Error {
+		assigned = append(assigned, [2]uint64{uint64(node), base})
+		return nil
+	}
+
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'S', 'B', '_'},
+			// Device(MEM0) {
+			//   Name(_HID, EISAID("PNP0C80"))
+			//   Name(_CRS, ResourceTemplate() { Memory32Fixed(ReadWrite, 0x100000, 0x1000000) })
+			// }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'M', 'E', 'M', '0'},
+				[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x80},
+				[]byte{0x08, '_', 'C', 'R', 'S'}, amlResources(t, &resource.Memory32Fixed{Writable: true, Base: 0x100000, Length: 0x1000000}),
+			)),
+			// Device(MEM1) {
+			//   Name(_HID, EISAID("PNP0C80"))
+			//   Name(STA_, Zero)
+			//   Name(EJ0_, Zero)
+			//   Name(OSTS, 0xff)
+			//   Method(_STA) { Return(STA_) }
+			//   Name(_CRS, ResourceTemplate() {
+			//     QWordMemory(..., 0x100000000, 0x107ffffff, 0, 0x8000000)
+			//     QWordMemory(..., 0x200000000, 0x207ffffff, 0, 0x8000000)
+			//   })
+			//   Name(_PXM, One)
+			//   Method(_EJ0, 1) { Store(Arg0, EJ0_) }
+			//   Method(_OST, 3) { Store(Arg1, OSTS) }
+			// }
+			amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+				[]byte{'M', 'E', 'M', '1'},
+				[]byte{0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x80},
+				[]byte{0x08, 'S', 'T', 'A', '_', 0x00},
+				[]byte{0x08, 'E', 'J', '0', '_', 0x00},
+				[]byte{0x08, 'O', 'S', 'T', 'S', 0x0a, 0xff},
+				amltest.Pkg([]byte{0x14}, []byte{'_', 'S', 'T', 'A', 0x00, 0xa4, 'S', 'T', 'A', '_'}),
+				[]byte{0x08, '_', 'C', 'R', 'S'}, amlResources(t,
+					&resource.Address{Width: resource.AddressQWord, ResourceType: resource.AddressTypeMemory, Min: 0x100000000, Max: 0x107ffffff, Length: 0x8000000},
+					&resource.Address{Width: resource.AddressQWord, ResourceType: resource.AddressTypeMemory, Min: 0x200000000, Max: 0x207ffffff, Length: 0x8000000},
+				),
+				[]byte{0x08, '_', 'P', 'X', 'M', 0x01},
+				amltest.Pkg([]byte{0x14}, []byte{'_', 'E', 'J', '0', 0x01, 0x70, 0x68, 'E', 'J', '0', '_'}),
+				amltest.Pkg([]byte{0x14}, []byte{'_', 'O', 'S', 'T', 0x03, 0x70, 0x69, 'O', 'S', 'T', 'S'}),
+			)),
+		)),
+		// Method(PLUG) { Store(0x0f, \_SB.MEM1.STA_) Notify(\_SB.MEM1, 0x01) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'P', 'L', 'U', 'G', 0x00,
+			0x70, 0x0a, 0x0f, '\\', 0x2f, 0x03, '_', 'S', 'B', '_', 'M', 'E', 'M', '1', 'S', 'T', 'A', '_',
+			0x86, '\\', 0x2e, '_', 'S', 'B', '_', 'M', 'E', 'M', '1', 0x01,
+		}),
+		// Method(EJCT) { Notify(\_SB.MEM1, 0x03) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'E', 'J', 'C', 'T', 0x00,
+			0x86, '\\', 0x2e, '_', 'S', 'B', '_', 'M', 'E', 'M', '1', 0x0a, 0x03,
+		}),
+		// Method(EJC0) { Notify(\_SB.MEM0, 0x03) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'E', 'J', 'C', '0', 0x00,
+			0x86, '\\', 0x2e, '_', 'S', 'B', '_', 'M', 'E', 'M', '0', 0x0a, 0x03,
+		}),
+	))
+
+	var log bytes.Buffer
+	devices, err := Probe(&log, vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(devices) != 2 || devices[0].Name() != `\_SB_.MEM0` || devices[1].Name() != `\_SB_.MEM1` {
+		t.Fatalf("expected 2 memory devices; got %d", len(devices))
+	}
+
+	// MEM0 is present and its memory is already managed by the pmm
+	if !devices[0].Online() || devices[0].Size() != 0x1000000 || devices[1].Online() || len(added) != 0 {
+		t.Fatalf("expected only the boot memory device to be online")
+	}
+
+	evalAndDispatch := func(method string) uint64 {
+		if _, err := vm.Evaluate(method); err != nil {
+			t.Fatal(err)
+		}
+		vm.DispatchNotifications()
+
+		status, _ := vm.Evaluate(`\_SB.MEM1.OSTS`)
+		return status.(uint64)
+	}
+
+	t.Run("hot-add", func(t *testing.T) {
+		if status := evalAndDispatch(`PLUG`); status != ostSuccess {
+			t.Errorf("expected _OST status %d; got %d", ostSuccess, status)
+		}
+
+		if exp := [][2]uint64{{0x100000000, 0x8000000}, {0x200000000, 0x8000000}}; !reflect.DeepEqual(added, exp) {
+			t.Errorf("expected ranges %x to be added; got %x", exp, added)
+		}
+
+		if exp := [][2]uint64{{1, 0x100000000}, {1, 0x200000000}}; !reflect.DeepEqual(assigned, exp) {
+			t.Errorf("expected ranges to be assigned to node 1; got %x", assigned)
+		}
+
+		if !devices[1].Online() || devices[1].Size() != 0x10000000 {
+			t.Errorf("expected hot-added device to be online with 256M; got %dM", devices[1].Size()>>20)
+		}
+
+		// Repeated device checks must not add the memory twice
+		if evalAndDispatch(`PLUG`); len(added) != 2 {
+			t.Errorf("expected the device memory to be added once; got %x", added)
+		}
+	})
+
+	t.Run("eject", func(t *testing.T) {
+		removeErr = errInUse
+		if status := evalAndDispatch(`EJCT`); status != ostEjectDeviceBusy {
+			t.Errorf("expected _OST status %d; got %d", ostEjectDeviceBusy, status)
+		}
+
+		if ej0, _ := vm.Evaluate(`\_SB.MEM1.EJ0_`); ej0.(uint64) != 0 || !devices[1].Online() {
+			t.Errorf("expected busy device to remain online without invoking _EJ0")
+		}
+
+		removeErr = nil
+		if status := evalAndDispatch(`EJCT`); status != ostSuccess {
+			t.Errorf("expected _OST status %d; got %d", ostSuccess, status)
+		}
+
+		if exp := [][2]uint64{{0x100000000, 0x8000000}, {0x200000000, 0x8000000}}; !reflect.DeepEqual(removed, exp) {
+			t.Errorf("expected ranges %x to be removed; got %x", exp, removed)
+		}
+
+		if ej0, _ := vm.Evaluate(`\_SB.MEM1.EJ0_`); ej0.(uint64) != 1 || devices[1].Online() {
+			t.Errorf("expected device to be ejected via _EJ0")
+		}
+
+		// Boot memory cannot be removed
+		evalAndDispatch(`EJC0`)
+		if !devices[0].Online() || len(removed) != 2 {
+			t.Errorf("expected boot memory device to remain online")
+		}
+
+		if !bytes.Contains(log.Bytes(), []byte(errBootMemory.Message)) {
+			t.Errorf("expected the failed eject to be logged; got:\n%s", log.String())
+		}
+	})
+}
+
+func TestCheckErrors(t *testing.T) {
+	defer func() {
+		addMemoryFn = pmm.AddMemory
+		removeMemoryFn = pmm.RemoveMemory
+	}()
+
+	var (
+		errAdd  = &kernel.Error{Module: "test", Message: "add failed"}
+		added   int
+		removed int
+	)
+
+	addMemoryFn = func(base, _ uint64) *kernel.Error {
+		if base >= 0x200000000 {
+			return errAdd
+		}
+		added++
+		return nil
+	}
+	removeMemoryFn = func(_, _ uint64) *kernel.Error {
+		removed++
+		return nil
+	}
+
+	specs := []struct {
+		contents []byte
+		expErr   *kernel.Error
+	}{
+		{nil, errMalformedCRS},
+		// Name(_CRS, One)
+		{[]byte{0x08, '_', 'C', 'R', 'S', 0x01}, errMalformedCRS},
+		// Name(_CRS, ResourceTemplate() { IO(Decode16, 0x60, 0x60, 1, 1) })
+		{amltest.Concat([]byte{0x08, '_', 'C', 'R', 'S'}, amlResources(t, &resource.IO{Decode16: true, Min: 0x60, Max: 0x60, Alignment: 1, Length: 1})), errNoMemory},
+		// Name(_CRS, ...) Name(_PXM, "x")
+		{
+			amltest.Concat(
+				[]byte{0x08, '_', 'C', 'R', 'S'}, amlResources(t, &resource.Memory32Fixed{Base: 0x100000, Length: 0x100000}),
+				[]byte{0x08, '_', 'P', 'X', 'M', 0x0d, 'x', 0x00},
+			),
+			errMalformedPXM,
+		},
+		// The second range cannot be added so the first one is removed
+		{
+			amltest.Concat([]byte{0x08, '_', 'C', 'R', 'S'}, amlResources(t,
+				&resource.Address{Width: resource.AddressQWord, ResourceType: resource.AddressTypeMemory, Min: 0x100000000, Max: 0x1ffffffff, Length: 0x100000000},
+				&resource.Address{Width: resource.AddressQWord, ResourceType: resource.AddressTypeMemory, Min: 0x200000000, Max: 0x2ffffffff, Length: 0x100000000},
+			)),
+			errAdd,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+			[]byte{'M', 'E', 'M', '0', 0x08, '_', 'H', 'I', 'D', 0x0c, 0x41, 0xd0, 0x0c, 0x80},
+			spec.contents,
+		)))
+
+		dev := &Device{vm: vm, node: ns.Lookup(nil, `\MEM0`)}
+		if err := dev.check(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+
+		if dev.Online() {
+			t.Errorf("[spec %d] expected device to remain offline", specIndex)
+		}
+	}
+
+	if added != 1 || removed != 1 {
+		t.Errorf("expected the partially added memory to be removed; got %d adds and %d removals", added, removed)
+	}
+}
+
+// amlResources returns the AML for a Buffer containing a resource template
+// with the supplied descriptors.
+func amlResources(t *testing.T, descriptors ...resource.Descriptor) []byte {
+	template, err := resource.Encode(descriptors)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return amltest.Pkg([]byte{0x11}, amltest.Concat([]byte{0x0a, byte(len(template))}, template))
+}
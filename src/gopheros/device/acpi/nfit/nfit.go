@@ -0,0 +1,90 @@
+// Package nfit implements a driver that locates the persistent memory ranges
+// described by the NFIT ACPI table and assigns them to the persistent memory
+// zone of the physical memory manager. This prevents the frame allocator from
+// handing out NVDIMM-backed frames as ordinary RAM and allows a pmem block
+// device to later locate them via pmm.VisitZone.
+package nfit
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm/pmm"
+	"io"
+)
+
+var (
+	errNoPersistentRanges = &kernel.Error{Module: "nfit", Message: "NFIT does not describe any persistent memory ranges", Code: kernel.ErrCodeNotFound}
+
+	lookupTableFn   = acpi.LookupTable
+	addZoneRegionFn = pmm.AddZoneRegion
+)
+
+// Driver implements a device.Driver that registers the persistent memory
+// ranges described by the NFIT with the physical memory manager.
+type Driver struct {
+	ranges []table.NFITRange
+}
+
+// DriverName returns the name of this driver.
+func (*Driver) DriverName() string {
+	return "NFIT"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Driver) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit assigns each persistent memory range to the persistent memory
+// zone. Ranges that cannot be registered are reported and skipped.
+func (d *Driver) DriverInit(w io.Writer) *kernel.Error {
+	var registered int
+	for _, r := range d.ranges {
+		if !r.Persistent() || r.Flags&table.NFITSPAControlRegion != 0 {
+			continue
+		}
+
+		if err := addZoneRegionFn(pmm.ZonePersistent, r.Base, r.Length); err != nil {
+			kfmt.Fprintf(w, "range %d at 0x%x: skipped: %s\n", r.Index, r.Base, err.Message)
+			continue
+		}
+
+		kfmt.Fprintf(w, "range %d: [0x%x - 0x%x] %dM persistent memory", r.Index, r.Base, r.Base+r.Length-1, r.Length>>20)
+		if r.Flags&table.NFITSPAProximityValid != 0 {
+			kfmt.Fprintf(w, " (domain %d)", r.ProximityDomain)
+		}
+		kfmt.Fprintf(w, "\n")
+		registered++
+	}
+
+	if registered == 0 {
+		return errNoPersistentRanges
+	}
+
+	return nil
+}
+
+// probeForNFIT checks for the presence of a NFIT.
+func probeForNFIT() device.Driver {
+	header := lookupTableFn("NFIT")
+	if header == nil {
+		return nil
+	}
+
+	ranges, err := table.DecodeNFIT(header)
+	if err != nil {
+		return nil
+	}
+
+	return &Driver{ranges: ranges}
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForNFIT,
+	})
+}
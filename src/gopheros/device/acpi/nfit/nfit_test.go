@@ -0,0 +1,92 @@
+package nfit
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm/pmm"
+	"testing"
+	"unsafe"
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+
+	errInUse := &kernel.Error{Module: "test", Message: "in use"}
+
+	var added []uint64
+	addZoneRegionFn = func(zone pmm.Zone, base, length uint64) *kernel.Error {
+		if zone != pmm.ZonePersistent {
+			t.Errorf("expected range to be added to the persistent zone; got %s", zone.String())
+		}
+
+		if base == 0x300000000 {
+			return errInUse
+		}
+
+		added = append(added, base)
+		return nil
+	}
+
+	drv := &Driver{
+		ranges: []table.NFITRange{
+			{Index: 1, Flags: table.NFITSPAProximityValid, ProximityDomain: 1, RangeType: table.NFITRangePersistent, Base: 0x100000000, Length: 0x40000000},
+			{Index: 2, RangeType: table.NFITRangeVolatile, Base: 0x200000000, Length: 0x100000},
+			{Index: 3, RangeType: table.NFITRangePersistent, Base: 0x300000000, Length: 0x100000},
+			{Index: 4, Flags: table.NFITSPAControlRegion, RangeType: table.NFITRangePersistent, Base: 0x400000000, Length: 0x100000},
+			{Index: 5, RangeType: table.NFITRangePersistent, Base: 0x500000000, Length: 0x200000},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := drv.DriverInit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(added) != 2 || added[0] != 0x100000000 || added[1] != 0x500000000 {
+		t.Fatalf("expected ranges 1 and 5 to be added; got %x", added)
+	}
+
+	expOutput := "range 1: [0x100000000 - 0x13fffffff] 1024M persistent memory (domain 1)\n" +
+		"range 3 at 0x300000000: skipped: in use\n" +
+		"range 5: [0x500000000 - 0x5001fffff] 2M persistent memory\n"
+	if got := buf.String(); got != expOutput {
+		t.Fatalf("expected output:\n%q\ngot:\n%q", expOutput, got)
+	}
+
+	drv.ranges = drv.ranges[1:3]
+	if err := drv.DriverInit(&buf); err != errNoPersistentRanges {
+		t.Fatalf("expected to get error %v; got %v", errNoPersistentRanges, err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	nfitTable := append([]byte("NFIT"), make([]byte, 36)...)
+	nfitTable[4] = byte(len(nfitTable))
+
+	specs := []struct {
+		header    *table.SDTHeader
+		expDriver bool
+	}{
+		{nil, false},
+		{(*table.SDTHeader)(unsafe.Pointer(&nfitTable[0])), true},
+		// Truncated table
+		{&table.SDTHeader{Signature: [4]byte{'N', 'F', 'I', 'T'}, Length: 36}, false},
+	}
+
+	for specIndex, spec := range specs {
+		lookupTableFn = func(string) *table.SDTHeader { return spec.header }
+
+		if drv := probeForNFIT(); (drv != nil) != spec.expDriver {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDriver, drv)
+		}
+	}
+}
+
+func restoreFns() {
+	lookupTableFn = acpi.LookupTable
+	addZoneRegionFn = pmm.AddZoneRegion
+}
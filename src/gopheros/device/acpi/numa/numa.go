@@ -0,0 +1,106 @@
+// Package numa implements a driver that uses the NUMA topology described by
+// the SRAT (and the optional SLIT) ACPI tables to assign the physical memory
+// ranges to NUMA nodes. On multi-node systems, the driver also registers a
+// local node policy with the physical memory manager so that frames are
+// allocated from the memory that is closest to the requesting CPU.
+package numa
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm/pmm"
+	"io"
+)
+
+// maxAPICID defines the max initial APIC ID that can be reported by CPUID.
+const maxAPICID = 255
+
+var (
+	lookupTableFn    = acpi.LookupTable
+	assignNodeFn     = pmm.AssignNode
+	setLocalNodeFn   = pmm.SetLocalNode
+	cpuIDFn          = cpu.ID
+	decodeTopologyFn = table.DecodeTopology
+
+	// apicNodes maps the initial APIC ID of each CPU to its NUMA node.
+	apicNodes [maxAPICID + 1]uint32
+)
+
+// Driver implements a device.Driver that registers the NUMA topology of the
+// system with the physical memory manager.
+type Driver struct {
+	topo *table.Topology
+}
+
+// DriverName returns the name of this driver.
+func (*Driver) DriverName() string {
+	return "NUMA"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Driver) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit assigns each memory range described by the SRAT to the NUMA
+// node that matches its proximity domain. Ranges that cannot be assigned are
+// reported and skipped. If the system contains more than one domain, the
+// driver also enables the local node allocation policy.
+func (d *Driver) DriverInit(w io.Writer) *kernel.Error {
+	for _, mem := range d.topo.Memory {
+		if err := assignNodeFn(mem.Domain, mem.Base, mem.Length); err != nil {
+			kfmt.Fprintf(w, "domain %d at 0x%x: skipped: %s\n", mem.Domain, mem.Base, err.Message)
+			continue
+		}
+
+		kfmt.Fprintf(w, "domain %d: [0x%x - 0x%x] %dM\n", mem.Domain, mem.Base, mem.Base+mem.Length-1, mem.Length>>20)
+	}
+
+	domains := d.topo.Domains()
+	if len(domains) < 2 {
+		kfmt.Fprintf(w, "single domain system; local node policy disabled\n")
+		return nil
+	}
+
+	for apicID := range apicNodes {
+		apicNodes[apicID], _ = d.topo.DomainForAPIC(uint32(apicID))
+	}
+	setLocalNodeFn(localNode)
+
+	kfmt.Fprintf(w, "%d domains; local node policy enabled\n", len(domains))
+	return nil
+}
+
+// localNode returns the NUMA node of the CPU that invokes it. CPUs that are
+// not described by the SRAT are treated as belonging to node 0.
+func localNode() uint32 {
+	_, ebx, _, _ := cpuIDFn(1)
+	return apicNodes[ebx>>24]
+}
+
+// probeForNUMA checks for the presence of a SRAT and decodes the NUMA
+// topology it describes together with the optional SLIT.
+func probeForNUMA() device.Driver {
+	srat := lookupTableFn("SRAT")
+	if srat == nil {
+		return nil
+	}
+
+	topo, err := decodeTopologyFn(srat, lookupTableFn("SLIT"))
+	if err != nil {
+		return nil
+	}
+
+	return &Driver{topo: topo}
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForNUMA,
+	})
+}
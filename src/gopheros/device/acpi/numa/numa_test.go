@@ -0,0 +1,146 @@
+package numa
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm/pmm"
+	"testing"
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+
+	var (
+		assigned   []uint64
+		localFn    func() uint32
+		apicID     uint32
+		errInvalid = &kernel.Error{Module: "test", Message: "invalid node"}
+	)
+
+	assignNodeFn = func(node uint32, base, length uint64) *kernel.Error {
+		if node >= pmm.MaxNodes {
+			return errInvalid
+		}
+		assigned = append(assigned, base)
+		return nil
+	}
+	setLocalNodeFn = func(fn func() uint32) { localFn = fn }
+	cpuIDFn = func(leaf uint32) (uint32, uint32, uint32, uint32) {
+		if leaf != 1 {
+			t.Errorf("expected CPUID leaf 1 to be queried; got %d", leaf)
+		}
+		return 0, apicID << 24, 0, 0
+	}
+
+	drv := &Driver{
+		topo: &table.Topology{
+			CPUs: []table.CPUAffinity{{APICID: 0, Domain: 0}, {APICID: 4, Domain: 1}},
+			Memory: []table.MemoryAffinity{
+				{Base: 0, Length: 0x80000000, Domain: 0},
+				{Base: 0x100000000, Length: 0x80000000, Domain: 1},
+				{Base: 0x200000000, Length: 0x100000, Domain: 100},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := drv.DriverInit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(assigned) != 2 || assigned[0] != 0 || assigned[1] != 0x100000000 {
+		t.Fatalf("expected the first two ranges to be assigned; got %x", assigned)
+	}
+
+	expOutput := "domain 0: [0x0 - 0x7fffffff] 2048M\n" +
+		"domain 1: [0x100000000 - 0x17fffffff] 2048M\n" +
+		"domain 100 at 0x200000000: skipped: invalid node\n" +
+		"3 domains; local node policy enabled\n"
+	if got := buf.String(); got != expOutput {
+		t.Fatalf("expected output:\n%q\ngot:\n%q", expOutput, got)
+	}
+
+	if localFn == nil {
+		t.Fatal("expected local node function to be registered")
+	}
+
+	for _, spec := range []struct{ apicID, expNode uint32 }{{0, 0}, {4, 1}, {7, 0}} {
+		apicID = spec.apicID
+		if got := localFn(); got != spec.expNode {
+			t.Errorf("expected CPU with APIC ID %d to belong to node %d; got %d", spec.apicID, spec.expNode, got)
+		}
+	}
+
+	// Single domain systems do not enable the local node policy
+	localFn = nil
+	buf.Reset()
+	drv.topo = &table.Topology{Memory: drv.topo.Memory[:1]}
+	if err := drv.DriverInit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if localFn != nil {
+		t.Fatal("expected local node policy to be disabled for single domain systems")
+	}
+
+	expOutput = "domain 0: [0x0 - 0x7fffffff] 2048M\n" +
+		"single domain system; local node policy disabled\n"
+	if got := buf.String(); got != expOutput {
+		t.Fatalf("expected output:\n%q\ngot:\n%q", expOutput, got)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	var (
+		srat   = &table.SDTHeader{Signature: [4]byte{'S', 'R', 'A', 'T'}}
+		slit   = &table.SDTHeader{Signature: [4]byte{'S', 'L', 'I', 'T'}}
+		expErr = &kernel.Error{Module: "test", Message: "malformed table"}
+	)
+
+	specs := []struct {
+		srat, slit *table.SDTHeader
+		decodeErr  *kernel.Error
+		expDriver  bool
+	}{
+		{nil, nil, nil, false},
+		{srat, nil, expErr, false},
+		{srat, nil, nil, true},
+		{srat, slit, nil, true},
+	}
+
+	for specIndex, spec := range specs {
+		lookupTableFn = func(name string) *table.SDTHeader {
+			if name == "SLIT" {
+				return spec.slit
+			}
+			return spec.srat
+		}
+		decodeTopologyFn = func(gotSRAT, gotSLIT *table.SDTHeader) (*table.Topology, *kernel.Error) {
+			if gotSRAT != spec.srat || gotSLIT != spec.slit {
+				t.Errorf("[spec %d] expected decoder to be called with the SRAT and SLIT", specIndex)
+			}
+			if spec.decodeErr != nil {
+				return nil, spec.decodeErr
+			}
+			return &table.Topology{}, nil
+		}
+
+		if drv := probeForNUMA(); (drv != nil) != spec.expDriver {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDriver, drv)
+		}
+	}
+
+}
+
+func restoreFns() {
+	lookupTableFn = acpi.LookupTable
+	assignNodeFn = pmm.AssignNode
+	setLocalNodeFn = pmm.SetLocalNode
+	cpuIDFn = cpu.ID
+	decodeTopologyFn = table.DecodeTopology
+}
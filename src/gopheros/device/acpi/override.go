@@ -0,0 +1,107 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/multiboot"
+	"io"
+	"strings"
+	"unsafe"
+)
+
+var (
+	visitModulesFn = multiboot.VisitModules
+)
+
+// overrideModuleTag is the token that must be present in the command line of
+// a boot module for its contents to be treated as a list of ACPI table
+// overrides (e.g. "module2 /boot/dsdt.aml acpi_override" when using grub2).
+const overrideModuleTag = "acpi_override"
+
+// loadTableOverrides scans the modules loaded by the bootloader for ACPI
+// tables that should replace the ones provided by the firmware. Each tagged
+// module may contain one or more tables stored back to back. Tables that fail
+// validation are reported to w and ignored along with any tables that follow
+// them in the same module.
+func (drv *acpiDriver) loadTableOverrides(w io.Writer) *kernel.Error {
+	var err *kernel.Error
+
+	drv.overrides = nil
+	visitModulesFn(func(cmdLine string, physStart, physEnd uintptr) bool {
+		if !isOverrideModule(cmdLine) {
+			return true
+		}
+
+		sizeofHeader := unsafe.Sizeof(table.SDTHeader{})
+		for tableAddr := physStart; tableAddr+sizeofHeader <= physEnd; {
+			var header *table.SDTHeader
+
+			// Make sure that the table does not extend past the end of
+			// the module before accepting it
+			header, _, err = mapACPITable(tableAddr)
+			if err == nil {
+				err = table.Validate(header, physEnd-tableAddr)
+			}
+
+			if err != nil {
+				if !skipInvalidTable(w, header, err) {
+					return false
+				}
+				err = nil
+				break
+			}
+
+			drv.overrides = append(drv.overrides, header)
+			tableAddr += uintptr(header.Length)
+		}
+
+		return true
+	})
+
+	return err
+}
+
+// overrideTable checks whether a boot module provides a replacement for the
+// firmware table described by header. Tables are matched by signature and
+// OEM ID; as systems typically provide multiple SSDTs, their OEM table ID
+// must also match. If a replacement is found, it is returned instead of the
+// firmware table together with a nil error; this allows a table that failed
+// validation to be replaced as long as its header could be mapped. Otherwise,
+// overrideTable returns the supplied header and error.
+func (drv *acpiDriver) overrideTable(w io.Writer, header *table.SDTHeader, err *kernel.Error) (*table.SDTHeader, *kernel.Error) {
+	if header == nil {
+		return header, err
+	}
+
+	for _, override := range drv.overrides {
+		if override.Signature != header.Signature || override.OEMID != header.OEMID {
+			continue
+		}
+
+		if string(header.Signature[:]) == ssdtSignature && override.OEMTableID != header.OEMTableID {
+			continue
+		}
+
+		kfmt.Fprintf(w, "%s (%6s %8s) overridden by boot module\n",
+			string(header.Signature[:]),
+			string(header.OEMID[:]),
+			string(header.OEMTableID[:]),
+		)
+		return override, nil
+	}
+
+	return header, err
+}
+
+// isOverrideModule returns true if the supplied module command line contains
+// the overrideModuleTag token.
+func isOverrideModule(cmdLine string) bool {
+	for _, token := range strings.Fields(cmdLine) {
+		if token == overrideModuleTag {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,224 @@
+package acpi
+
+import (
+	"bytes"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/multiboot"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestLoadTableOverrides(t *testing.T) {
+	defer func() {
+		identityMapFn = vmm.IdentityMapRegion
+		visitModulesFn = multiboot.VisitModules
+	}()
+
+	identityMapFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.Page(frame), nil
+	}
+
+	var (
+		dsdt = genOverrideTable(t, "DSDT.aml", "")
+		ssdt = genOverrideTable(t, "SSDT.aml", "")
+
+		// A module containing two tables stored back to back
+		twoTables = append(append([]byte{}, dsdt...), ssdt...)
+
+		// A module whose last table is truncated
+		truncated = append(append([]byte{}, ssdt...), dsdt[:len(dsdt)-1]...)
+	)
+
+	specs := []struct {
+		cmdLine    string
+		module     []byte
+		expTables  []string
+		expWarning bool
+	}{
+		{"/boot/tables.aml acpi_override", twoTables, []string{"DSDT", "SSDT"}, false},
+		{"acpi_override", truncated, []string{"SSDT"}, true},
+		{"/boot/initrd", twoTables, nil, false},
+		{"/boot/initrd acpi_override_x", twoTables, nil, false},
+	}
+
+	for specIndex, spec := range specs {
+		visitModulesFn = func(visitor multiboot.ModuleVisitor) {
+			start := uintptr(unsafe.Pointer(&spec.module[0]))
+			visitor(spec.cmdLine, start, start+uintptr(len(spec.module)))
+		}
+
+		var (
+			buf bytes.Buffer
+			drv acpiDriver
+		)
+
+		if err := drv.loadTableOverrides(&buf); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		var got []string
+		for _, header := range drv.overrides {
+			got = append(got, string(header.Signature[:]))
+		}
+
+		if strings.Join(got, ",") != strings.Join(spec.expTables, ",") {
+			t.Errorf("[spec %d] expected overrides %v; got %v", specIndex, spec.expTables, got)
+		}
+
+		if gotWarning := strings.Contains(buf.String(), "skipping"); gotWarning != spec.expWarning {
+			t.Errorf("[spec %d] expected warning: %t; got output %q", specIndex, spec.expWarning, buf.String())
+		}
+	}
+
+	t.Run("map error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "identityMapRegion failed"}
+		identityMapFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+		visitModulesFn = func(visitor multiboot.ModuleVisitor) {
+			start := uintptr(unsafe.Pointer(&dsdt[0]))
+			visitor(overrideModuleTag, start, start+uintptr(len(dsdt)))
+		}
+
+		var drv acpiDriver
+		if err := drv.loadTableOverrides(os.Stderr); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestOverrideTable(t *testing.T) {
+	var (
+		fwDSDT     = genOverrideTable(t, "DSDT.aml", "")
+		fwSSDT     = genOverrideTable(t, "SSDT.aml", "")
+		fwAPIC     = genOverrideTable(t, "APIC.aml", "")
+		dsdt       = genOverrideTable(t, "DSDT.aml", "OVERRIDE")
+		ssdt       = genOverrideTable(t, "SSDT.aml", "")
+		otherSSDT  = genOverrideTable(t, "SSDT.aml", "OVERRIDE")
+		invalidErr = table.Validate(&table.SDTHeader{}, 0)
+		drv        = &acpiDriver{
+			overrides: []*table.SDTHeader{
+				asHeader(dsdt),
+				asHeader(otherSSDT),
+				asHeader(ssdt),
+			},
+		}
+	)
+
+	specs := []struct {
+		header    *table.SDTHeader
+		err       *kernel.Error
+		expHeader *table.SDTHeader
+		expErr    *kernel.Error
+	}{
+		// DSDT is matched by OEM ID regardless of the OEM table ID
+		{asHeader(fwDSDT), nil, asHeader(dsdt), nil},
+		// overrides also replace tables that failed validation
+		{asHeader(fwDSDT), invalidErr, asHeader(dsdt), nil},
+		// SSDTs must also match the OEM table ID
+		{asHeader(fwSSDT), nil, asHeader(ssdt), nil},
+		// no override available
+		{asHeader(fwAPIC), nil, asHeader(fwAPIC), nil},
+		{asHeader(fwAPIC), invalidErr, asHeader(fwAPIC), invalidErr},
+		{nil, invalidErr, nil, invalidErr},
+	}
+
+	for specIndex, spec := range specs {
+		header, err := drv.overrideTable(os.Stderr, spec.header, spec.err)
+		if header != spec.expHeader || err != spec.expErr {
+			t.Errorf("[spec %d] expected to get (%p, %v); got (%p, %v)", specIndex, spec.expHeader, spec.expErr, header, err)
+		}
+	}
+
+	// SSDTs with a different OEM table ID must not be replaced
+	asHeader(fwSSDT).OEMTableID = [8]byte{'N', 'O', 'M', 'A', 'T', 'C', 'H', ' '}
+	if header, _ := drv.overrideTable(os.Stderr, asHeader(fwSSDT), nil); header != asHeader(fwSSDT) {
+		t.Error("expected SSDT with a different OEM table ID not to be overridden")
+	}
+}
+
+func TestEnumerateTablesWithOverrides(t *testing.T) {
+	defer func() {
+		identityMapFn = vmm.IdentityMapRegion
+		visitModulesFn = multiboot.VisitModules
+		activeFADT, activeFADTInfo = nil, nil
+	}()
+
+	rsdtAddr, tableList := genTestRDST(t, acpiRev2Plus)
+	identityMapFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.Page(frame), nil
+	}
+
+	// Corrupt the firmware DSDT and provide a replacement via a boot module
+	var module []byte
+	for _, header := range tableList {
+		if string(header.Signature[:]) == dsdtSignature {
+			header.Checksum++
+			module = genOverrideTableFrom(header, "OVERRIDE")
+		}
+	}
+
+	visitModulesFn = func(visitor multiboot.ModuleVisitor) {
+		start := uintptr(unsafe.Pointer(&module[0]))
+		visitor("/boot/dsdt.aml "+overrideModuleTag, start, start+uintptr(len(module)))
+	}
+
+	drv := &acpiDriver{
+		rsdtAddr: rsdtAddr,
+		useXSDT:  true,
+	}
+
+	if err := drv.enumerateTables(os.Stderr); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, got := asHeader(module), drv.tableMap[dsdtSignature]; got != exp {
+		t.Fatalf("expected DSDT to be replaced by the boot module table %p; got %p", exp, got)
+	}
+
+	if len(drv.definitionBlocks) == 0 || drv.definitionBlocks[0] != asHeader(module) {
+		t.Fatal("expected the replacement DSDT to be the first definition block")
+	}
+}
+
+// genOverrideTable loads the specified table from the tabletest folder and
+// optionally replaces its OEM table ID before updating its checksum.
+func genOverrideTable(t *testing.T, file, oemTableID string) []byte {
+	data, err := ioutil.ReadFile(pkgDir() + "/table/tabletest/" + file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return genOverrideTableFrom(asHeader(data), oemTableID)
+}
+
+// genOverrideTableFrom returns a copy of the table described by header with
+// its OEM table ID optionally replaced and its checksum updated.
+func genOverrideTableFrom(header *table.SDTHeader, oemTableID string) []byte {
+	var data []byte
+	for ptr := uintptr(unsafe.Pointer(header)); ptr < uintptr(unsafe.Pointer(header))+uintptr(header.Length); ptr++ {
+		data = append(data, *(*byte)(unsafe.Pointer(ptr)))
+	}
+
+	if oemTableID != "" {
+		copy(asHeader(data).OEMTableID[:], oemTableID)
+	}
+
+	header = asHeader(data)
+	header.Checksum = 0
+	updateChecksum(header)
+	return data
+}
+
+// asHeader returns a pointer to the table header stored at the beginning of
+// data.
+func asHeader(data []byte) *table.SDTHeader {
+	return (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+}
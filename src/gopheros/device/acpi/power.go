@@ -0,0 +1,242 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"unsafe"
+)
+
+var (
+	errNoFADT                   = &kernel.Error{Module: "acpi", Message: "FADT not available", Code: kernel.ErrCodeNotFound}
+	errNoInterpreter            = &kernel.Error{Module: "acpi", Message: "no AML interpreter has been attached", Code: kernel.ErrCodeNotFound}
+	errMissingS5                = &kernel.Error{Module: "acpi", Message: "firmware does not define the \\_S5 sleep state", Code: kernel.ErrCodeNotSupported}
+	errMalformedSleepState      = &kernel.Error{Module: "acpi", Message: "sleep state object must evaluate to a package of integers", Code: kernel.ErrCodeCorrupted}
+	errUnsupportedPM1Control    = &kernel.Error{Module: "acpi", Message: "PM1 control blocks outside the SystemIO address space are not supported", Code: kernel.ErrCodeNotSupported}
+	errShutdownFailed           = &kernel.Error{Module: "acpi", Message: "system did not enter the soft-off state", Code: kernel.ErrCodeIO}
+	errRebootFailed             = &kernel.Error{Module: "acpi", Message: "system did not reset", Code: kernel.ErrCodeIO}
+	errNoResetRegister          = &kernel.Error{Module: "acpi", Message: "FADT does not define a reset register", Code: kernel.ErrCodeNotSupported}
+	errUnsupportedResetRegSpace = &kernel.Error{Module: "acpi", Message: "reset register address space is not supported", Code: kernel.ErrCodeNotSupported}
+
+	portReadByteFn      = cpu.PortReadByte
+	portWriteByteFn     = cpu.PortWriteByte
+	portReadWordFn      = cpu.PortReadWord
+	portWriteWordFn     = cpu.PortWriteWord
+	disableInterruptsFn = cpu.DisableInterrupts
+	tripleFaultFn       = cpu.TripleFault
+
+	// The FADT located by the driver and the AML interpreter attached via
+	// AttachInterpreter. They are required for changing the system power
+	// state.
+	activeFADT *table.FADT
+	activeVM   *aml.VM
+	activeNS   *aml.Namespace
+)
+
+// The FADT flags that describe the reset register.
+const fadtFlagResetRegSupported = uint32(1 << 10)
+
+// The fields of the PM1 control register that select the sleep state to
+// enter.
+const (
+	pm1SleepTypeShift = 10
+	pm1SleepTypeMask  = uint16(7 << pm1SleepTypeShift)
+	pm1SleepEnable    = uint16(1 << 13)
+)
+
+// The value passed to _SST to turn off the system indicator.
+const sstIndicatorOff = uint64(0)
+
+// The ports and commands used by the keyboard controller reset fallback.
+const (
+	kbdStatusPort      = uint16(0x64)
+	kbdCommandPort     = uint16(0x64)
+	kbdInputBufferFull = uint8(1 << 1)
+	kbdCmdPulseReset   = uint8(0xfe)
+
+	// The number of times to poll the keyboard controller status before
+	// giving up on its input buffer becoming empty.
+	kbdMaxPolls = 0x10000
+)
+
+// AttachInterpreter registers the AML VM and namespace that Shutdown uses for
+// evaluating the firmware-provided sleep objects.
+func AttachInterpreter(vm *aml.VM, ns *aml.Namespace) {
+	activeVM, activeNS = vm, ns
+}
+
+// Interpreter returns the AML VM and namespace registered via
+// AttachInterpreter or nil if no interpreter has been attached.
+func Interpreter() (*aml.VM, *aml.Namespace) {
+	return activeVM, activeNS
+}
+
+// Shutdown places the system in the S5 (soft-off) sleep state. It evaluates
+// \_S5 to obtain the values that the firmware expects to be written to the
+// SLP_TYP field of the PM1 control registers, invokes the optional \_PTS
+// and \_SI._SST methods and then sets SLP_TYP and SLP_EN to power off the
+// system. Shutdown only returns if the system could not be powered off.
+func Shutdown() *kernel.Error {
+	switch {
+	case activeFADT == nil:
+		return errNoFADT
+	case activeVM == nil:
+		return errNoInterpreter
+	}
+
+	slpTypA, slpTypB, err := sleepTypes(`\_S5`)
+	if err != nil {
+		return err
+	}
+
+	pm1a, pm1b := pm1ControlAddresses(activeFADT)
+	for _, addr := range []table.GenericAddress{pm1a, pm1b} {
+		if addr.Address != 0 && addr.Space != table.AddressSpaceSysIO {
+			return errUnsupportedPM1Control
+		}
+	}
+
+	for _, method := range []struct {
+		path string
+		arg  uint64
+	}{
+		{`\_PTS`, 5},
+		{`\_SI._SST`, sstIndicatorOff},
+	} {
+		if activeNS.Lookup(nil, method.path) == nil {
+			continue
+		}
+
+		if _, err = activeVM.Evaluate(method.path, method.arg); err != nil {
+			return err
+		}
+	}
+
+	disableInterruptsFn()
+
+	// SLP_TYP must be written before SLP_EN is set.
+	for _, setBits := range []uint16{0, pm1SleepEnable} {
+		for _, spec := range []struct {
+			addr   table.GenericAddress
+			slpTyp uint8
+		}{
+			{pm1a, slpTypA},
+			{pm1b, slpTypB},
+		} {
+			if spec.addr.Address == 0 {
+				continue
+			}
+
+			port := uint16(spec.addr.Address)
+			val := portReadWordFn(port) &^ (pm1SleepTypeMask | pm1SleepEnable)
+			val |= uint16(spec.slpTyp)<<pm1SleepTypeShift&pm1SleepTypeMask | setBits
+			portWriteWordFn(port, val)
+		}
+	}
+
+	return errShutdownFailed
+}
+
+// sleepTypes evaluates the sleep state object at path and returns the
+// SLP_TYPa and SLP_TYPb values that it specifies.
+func sleepTypes(path string) (uint8, uint8, *kernel.Error) {
+	if activeNS.Lookup(nil, path) == nil {
+		return 0, 0, errMissingS5
+	}
+
+	val, err := activeVM.Evaluate(path)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	pkg, ok := val.([]interface{})
+	if !ok || len(pkg) == 0 {
+		return 0, 0, errMalformedSleepState
+	}
+
+	var fields [2]uint64
+	for index := 0; index < len(pkg) && index < len(fields); index++ {
+		if fields[index], ok = pkg[index].(uint64); !ok {
+			return 0, 0, errMalformedSleepState
+		}
+	}
+
+	// Some firmware packs both values into a single element with
+	// SLP_TYPa in the low nibble and SLP_TYPb in the high nibble.
+	if len(pkg) == 1 {
+		return uint8(fields[0] & 0xf), uint8(fields[0] >> 4 & 0xf), nil
+	}
+
+	return uint8(fields[0]), uint8(fields[1]), nil
+}
+
+// pm1ControlAddresses returns the addresses of the PM1a and PM1b control
+// blocks. The 64-bit FADT extensions are preferred if the table is large
+// enough to contain them.
+func pm1ControlAddresses(fadt *table.FADT) (table.GenericAddress, table.GenericAddress) {
+	pm1a := table.GenericAddress{Space: table.AddressSpaceSysIO, Address: uint64(fadt.PM1aControlBlock)}
+	pm1b := table.GenericAddress{Space: table.AddressSpaceSysIO, Address: uint64(fadt.PM1bControlBlock)}
+
+	if uintptr(fadt.Length) >= unsafe.Sizeof(*fadt) {
+		if fadt.Ext.PM1aControlBlock.Address != 0 {
+			pm1a = fadt.Ext.PM1aControlBlock
+		}
+		if fadt.Ext.PM1bControlBlock.Address != 0 {
+			pm1b = fadt.Ext.PM1bControlBlock
+		}
+	}
+
+	return pm1a, pm1b
+}
+
+// Reboot resets the system. It first writes the reset value to the reset
+// register specified by the FADT (if supported) and falls back to pulsing
+// the reset line via the keyboard controller. If both methods fail, Reboot
+// resets the CPU by triggering a triple fault. Reboot only returns if all
+// reset methods fail.
+func Reboot() *kernel.Error {
+	if activeFADT != nil {
+		// Errors are ignored as the remaining methods are tried next.
+		_ = writeResetRegister(activeFADT)
+	}
+
+	for polls := 0; polls < kbdMaxPolls; polls++ {
+		if portReadByteFn(kbdStatusPort)&kbdInputBufferFull == 0 {
+			portWriteByteFn(kbdCommandPort, kbdCmdPulseReset)
+			break
+		}
+	}
+
+	tripleFaultFn()
+	return errRebootFailed
+}
+
+// writeResetRegister writes the reset value to the reset register defined by
+// the FADT. Reset registers in the SystemIO and SystemMemory address spaces
+// are supported.
+func writeResetRegister(fadt *table.FADT) *kernel.Error {
+	if uintptr(fadt.Length) < unsafe.Offsetof(fadt.ResetValue)+1 ||
+		fadt.Flags&fadtFlagResetRegSupported == 0 ||
+		fadt.ResetReg.Address == 0 {
+		return errNoResetRegister
+	}
+
+	switch fadt.ResetReg.Space {
+	case table.AddressSpaceSysIO:
+		portWriteByteFn(uint16(fadt.ResetReg.Address), fadt.ResetValue)
+	case table.AddressSpaceSysMemory:
+		addr := uintptr(fadt.ResetReg.Address)
+		page, err := identityMapFn(mm.FrameFromAddress(addr), 1, vmm.FlagPresent|vmm.FlagRW)
+		if err != nil {
+			return err
+		}
+		*(*uint8)(unsafe.Pointer(page.Address() + vmm.PageOffset(addr))) = fadt.ResetValue
+	default:
+		return errUnsupportedResetRegSpace
+	}
+
+	return nil
+}
@@ -0,0 +1,257 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"testing"
+	"unsafe"
+)
+
+func TestShutdown(t *testing.T) {
+	defer restorePowerHW()
+	ports := newFakePowerPorts()
+
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		// Name(_S5, Package() { 5, 6, 0, 0 })
+		[]byte{0x08, '_', 'S', '5', '_'},
+		amltest.Pkg([]byte{0x12}, []byte{0x04, 0x0a, 0x05, 0x0a, 0x06, 0x00, 0x00}),
+		// Name(PTSA, 0xff)
+		// Method(_PTS, 1) { Store(Arg0, PTSA) }
+		[]byte{0x08, 'P', 'T', 'S', 'A', 0x0a, 0xff},
+		amltest.Pkg([]byte{0x14}, []byte{'_', 'P', 'T', 'S', 0x01, 0x70, 0x68, 'P', 'T', 'S', 'A'}),
+		// Scope(\_SI) {
+		//   Name(SSTA, 0xff)
+		//   Method(_SST, 1) { Store(Arg0, SSTA) }
+		// }
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'S', 'I', '_'},
+			[]byte{0x08, 'S', 'S', 'T', 'A', 0x0a, 0xff},
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'S', 'S', 'T', 0x01, 0x70, 0x68, 'S', 'S', 'T', 'A'}),
+		)),
+	))
+
+	activeFADT = &table.FADT{PM1aControlBlock: 0x804, PM1bControlBlock: 0x904}
+	ports.words[0x804] = 0x0001 | pm1SleepTypeMask
+	ports.words[0x904] = 0x0001
+
+	if err := Shutdown(); err != errNoInterpreter {
+		t.Fatalf("expected to get errNoInterpreter; got %v", err)
+	}
+
+	AttachInterpreter(vm, ns)
+	if err := Shutdown(); err != errShutdownFailed {
+		t.Fatalf("expected to get errShutdownFailed; got %v", err)
+	}
+
+	for path, exp := range map[string]uint64{`\PTSA`: 5, `\_SI.SSTA`: sstIndicatorOff} {
+		if got, _ := vm.Evaluate(path); got != exp {
+			t.Errorf("expected %s to be %d; got %v", path, exp, got)
+		}
+	}
+
+	if !ports.interruptsDisabled {
+		t.Error("expected interrupts to be disabled before entering S5")
+	}
+
+	// SLP_TYP must be written to both control registers before SLP_EN
+	// is set; the remaining register bits must be preserved.
+	expWrites := []portWrite{
+		{0x804, 0x0001 | 5<<pm1SleepTypeShift},
+		{0x904, 0x0001 | 6<<pm1SleepTypeShift},
+		{0x804, 0x0001 | 5<<pm1SleepTypeShift | pm1SleepEnable},
+		{0x904, 0x0001 | 6<<pm1SleepTypeShift | pm1SleepEnable},
+	}
+	if len(ports.writes) != len(expWrites) {
+		t.Fatalf("expected %d PM1 control writes; got %v", len(expWrites), ports.writes)
+	}
+	for index, exp := range expWrites {
+		if got := ports.writes[index]; got != exp {
+			t.Errorf("expected write %d to be %+v; got %+v", index, exp, got)
+		}
+	}
+}
+
+func TestShutdownErrors(t *testing.T) {
+	defer restorePowerHW()
+	newFakePowerPorts()
+
+	activeFADT = nil
+	if err := Shutdown(); err != errNoFADT {
+		t.Fatalf("expected to get errNoFADT; got %v", err)
+	}
+
+	specs := []struct {
+		payload []byte
+		fadt    table.FADT
+		expErr  *kernel.Error
+	}{
+		{nil, table.FADT{PM1aControlBlock: 0x804}, errMissingS5},
+		// Name(_S5, "x")
+		{[]byte{0x08, '_', 'S', '5', '_', 0x0d, 'x', 0x00}, table.FADT{PM1aControlBlock: 0x804}, errMalformedSleepState},
+		// Name(_S5, Package() { "x", 0 })
+		{
+			amltest.Concat([]byte{0x08, '_', 'S', '5', '_'}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x0d, 'x', 0x00, 0x00})),
+			table.FADT{PM1aControlBlock: 0x804},
+			errMalformedSleepState,
+		},
+		// Name(_S5, Package() { 5, 5 }) with a memory-mapped PM1 control block
+		{
+			amltest.Concat([]byte{0x08, '_', 'S', '5', '_'}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x0a, 0x05, 0x0a, 0x05})),
+			table.FADT{
+				SDTHeader: table.SDTHeader{Length: uint32(unsafe.Sizeof(table.FADT{}))},
+				Ext: table.FADT64{
+					PM1aControlBlock: table.GenericAddress{Space: table.AddressSpaceSysMemory, Address: 0x1000},
+				},
+			},
+			errUnsupportedPM1Control,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, spec.payload)
+		AttachInterpreter(vm, ns)
+		activeFADT = &spec.fadt
+
+		if err := Shutdown(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestSleepTypesPacked(t *testing.T) {
+	defer restorePowerHW()
+
+	// Name(_S5, Package() { 0x75 })
+	vm, ns := vmtest.ForPayload(t, amltest.Concat([]byte{0x08, '_', 'S', '5', '_'}, amltest.Pkg([]byte{0x12}, []byte{0x01, 0x0a, 0x75})))
+	AttachInterpreter(vm, ns)
+
+	if typA, typB, err := sleepTypes(`\_S5`); err != nil || typA != 5 || typB != 7 {
+		t.Fatalf("expected to get SLP_TYP values (5, 7); got (%d, %d), err: %v", typA, typB, err)
+	}
+}
+
+func TestReboot(t *testing.T) {
+	defer restorePowerHW()
+	defer func() {
+		identityMapFn = vmm.IdentityMapRegion
+	}()
+
+	var resetByte uint8
+	identityMapFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.PageFromAddress(uintptr(unsafe.Pointer(&resetByte))), nil
+	}
+
+	fadtWithResetReg := func(space table.AddressSpace) *table.FADT {
+		fadt := &table.FADT{
+			Flags:      fadtFlagResetRegSupported,
+			ResetReg:   table.GenericAddress{Space: space, Address: 0xcf9},
+			ResetValue: 0x06,
+		}
+		fadt.Length = uint32(unsafe.Sizeof(*fadt))
+		if space == table.AddressSpaceSysMemory {
+			fadt.ResetReg.Address = uint64(uintptr(unsafe.Pointer(&resetByte)))
+		}
+		return fadt
+	}
+
+	specs := []struct {
+		fadt      *table.FADT
+		kbdBusy   bool
+		expWrites []portWrite
+		expMemVal uint8
+	}{
+		// Reset register in the SystemIO space followed by the
+		// keyboard controller
+		{fadtWithResetReg(table.AddressSpaceSysIO), false, []portWrite{{0xcf9, 0x06}, {kbdCommandPort, uint16(kbdCmdPulseReset)}}, 0},
+		// Memory-mapped reset register
+		{fadtWithResetReg(table.AddressSpaceSysMemory), false, []portWrite{{kbdCommandPort, uint16(kbdCmdPulseReset)}}, 0x06},
+		// Unsupported reset register space
+		{fadtWithResetReg(table.AddressSpacePCI), false, []portWrite{{kbdCommandPort, uint16(kbdCmdPulseReset)}}, 0},
+		// No FADT and a keyboard controller that never becomes ready
+		{nil, true, nil, 0},
+	}
+
+	for specIndex, spec := range specs {
+		ports := newFakePowerPorts()
+		if spec.kbdBusy {
+			ports.bytes[kbdStatusPort] = kbdInputBufferFull
+		}
+		activeFADT = spec.fadt
+		resetByte = 0
+
+		if err := Reboot(); err != errRebootFailed {
+			t.Errorf("[spec %d] expected to get errRebootFailed; got %v", specIndex, err)
+			continue
+		}
+
+		if len(ports.writes) != len(spec.expWrites) {
+			t.Errorf("[spec %d] expected port writes %v; got %v", specIndex, spec.expWrites, ports.writes)
+			continue
+		}
+		for index, exp := range spec.expWrites {
+			if got := ports.writes[index]; got != exp {
+				t.Errorf("[spec %d] expected write %d to be %+v; got %+v", specIndex, index, exp, got)
+			}
+		}
+
+		if resetByte != spec.expMemVal {
+			t.Errorf("[spec %d] expected memory-mapped reset register to be 0x%x; got 0x%x", specIndex, spec.expMemVal, resetByte)
+		}
+
+		if !ports.tripleFaulted {
+			t.Errorf("[spec %d] expected Reboot to fall back to a triple fault", specIndex)
+		}
+	}
+}
+
+type portWrite struct {
+	port uint16
+	val  uint16
+}
+
+// fakePowerPorts emulates the I/O ports used for changing the system power
+// state and records all port writes.
+type fakePowerPorts struct {
+	bytes  map[uint16]uint8
+	words  map[uint16]uint16
+	writes []portWrite
+
+	interruptsDisabled bool
+	tripleFaulted      bool
+}
+
+func newFakePowerPorts() *fakePowerPorts {
+	ports := &fakePowerPorts{
+		bytes: make(map[uint16]uint8),
+		words: make(map[uint16]uint16),
+	}
+
+	portReadByteFn = func(port uint16) uint8 { return ports.bytes[port] }
+	portWriteByteFn = func(port uint16, val uint8) {
+		ports.writes = append(ports.writes, portWrite{port, uint16(val)})
+	}
+	portReadWordFn = func(port uint16) uint16 { return ports.words[port] }
+	portWriteWordFn = func(port uint16, val uint16) {
+		ports.writes = append(ports.writes, portWrite{port, val})
+		ports.words[port] = val &^ pm1SleepEnable
+	}
+	disableInterruptsFn = func() { ports.interruptsDisabled = true }
+	tripleFaultFn = func() { ports.tripleFaulted = true }
+
+	return ports
+}
+
+func restorePowerHW() {
+	portReadByteFn = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+	portReadWordFn = cpu.PortReadWord
+	portWriteWordFn = cpu.PortWriteWord
+	disableInterruptsFn = cpu.DisableInterrupts
+	tripleFaultFn = cpu.TripleFault
+	activeFADT, activeVM, activeNS = nil, nil, nil
+}
@@ -0,0 +1,200 @@
+package processor
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/device"
+	"gopheros/kernel"
+	"strconv"
+)
+
+var (
+	errMalformedUID        = &kernel.Error{Module: "acpi_processor", Message: "processor device _UID is not a valid processor ID", Code: kernel.ErrCodeCorrupted}
+	errMalformedOSC        = &kernel.Error{Module: "acpi_processor", Message: "_OSC must return a buffer with the status and capabilities dwords", Code: kernel.ErrCodeCorrupted}
+	errOSCFailed           = &kernel.Error{Module: "acpi_processor", Message: "_OSC reported a capability negotiation failure", Code: kernel.ErrCodeNotSupported}
+	errNoCapabilityMethod  = &kernel.Error{Module: "acpi_processor", Message: "processor does not define an _OSC or _PDC method", Code: kernel.ErrCodeNotSupported}
+	errNoProcessorsPresent = &kernel.Error{Module: "acpi_processor", Message: "no processor objects found in the namespace", Code: kernel.ErrCodeNotFound}
+)
+
+// The processor capabilities that the OS can report to the firmware via _OSC
+// or _PDC. The bit assignments are defined by the Intel Processor
+// Vendor-Specific ACPI interface specification.
+const (
+	// P-states are controlled via the performance control MSRs.
+	CapPStateFFH = uint32(1 << 0)
+
+	// C1 is entered using an I/O port read followed by HLT.
+	CapC1IOHalt = uint32(1 << 1)
+
+	// T-states are controlled via the clock modulation MSR.
+	CapTStateFFH = uint32(1 << 2)
+
+	// The OS supports independent C1 and C2/C3 states on SMP systems.
+	CapSMPC1 = uint32(1 << 3)
+	CapSMPC2 = uint32(1 << 4)
+
+	// The OS coordinates P-states, C-states and T-states between
+	// dependent processors in software (_PSD, _CSD and _TSD).
+	CapSMPPStateCoord = uint32(1 << 5)
+	CapSMPCStateCoord = uint32(1 << 6)
+	CapSMPTStateCoord = uint32(1 << 7)
+
+	// C1 and C2/C3 are entered using the native MWAIT instruction.
+	CapC1FFH   = uint32(1 << 8)
+	CapC2C3FFH = uint32(1 << 9)
+
+	// The hardware coordinates P-states between dependent processors.
+	CapSMPPStateHWCoord = uint32(1 << 11)
+
+	// The OS handles _PPC change notifications.
+	CapPPCNotify = uint32(1 << 12)
+)
+
+// The UUID used when evaluating the _OSC method of processors
+// (4077A616-290C-47BE-9EBD-D87058713953 encoded in its binary form).
+var oscUUID = []byte{
+	0x16, 0xa6, 0x77, 0x40, 0x0c, 0x29, 0xbe, 0x47,
+	0x9e, 0xbd, 0xd8, 0x70, 0x58, 0x71, 0x39, 0x53,
+}
+
+const (
+	// The revision of the processor _OSC interface.
+	oscRevision = uint64(1)
+
+	// The bits of the first _OSC capabilities dword that report an
+	// error. They flag an unspecified failure, an unrecognized UUID and
+	// an unrecognized revision.
+	oscErrorMask = uint32(0xe)
+
+	// The revision of the _PDC capabilities buffer format.
+	pdcRevision = uint32(1)
+)
+
+// CPU describes a processor declared in the ACPI namespace either as a
+// Processor object or as a Device object with the ACPI0007 hardware ID.
+type CPU struct {
+	// The namespace node of the processor. It serves as the handle for
+	// evaluating the performance and power management objects of the
+	// processor.
+	Node *aml.NamespaceNode
+
+	// The processor ID as specified by the Processor object or the _UID of
+	// the processor device. It matches the ID of the Local APIC entry for
+	// the processor in the MADT.
+	ID uint32
+
+	// The I/O address and length of the processor control block. Both
+	// are 0 for processor devices and processors without a control block.
+	PblkAddr uint32
+	PblkLen  uint8
+
+	// The capabilities granted by the firmware during the last call to
+	// Negotiate.
+	Capabilities uint32
+}
+
+// Processors returns the Processor objects and the present processor devices
+// defined in ns in the order in which they appear in the namespace.
+func Processors(vm *aml.VM, ns *aml.Namespace) ([]*CPU, *kernel.Error) {
+	nodes := processorNodes(vm, ns)
+	if len(nodes) == 0 {
+		return nil, errNoProcessorsPresent
+	}
+
+	cpus := make([]*CPU, 0, len(nodes))
+	for _, node := range nodes {
+		cpu := &CPU{Node: node}
+
+		if info, isProcessor := ns.Processor(node); isProcessor {
+			cpu.ID, cpu.PblkAddr, cpu.PblkLen = uint32(info.ID), info.PblkAddr, info.PblkLen
+		} else {
+			devInfo, err := device.Identify(vm, node)
+			if err != nil {
+				return nil, err
+			}
+
+			id, convErr := strconv.ParseUint(devInfo.UID, 0, 32)
+			if convErr != nil {
+				return nil, errMalformedUID
+			}
+			cpu.ID = uint32(id)
+		}
+
+		cpus = append(cpus, cpu)
+	}
+
+	return cpus, nil
+}
+
+// Negotiate reports the processor capabilities supported by the OS to the
+// firmware and records the subset of capabilities granted by the firmware.
+// Firmware may use this information to load additional SSDTs that define the
+// _PSS, _CST and related objects for the capabilities in use so the
+// performance and power management interfaces should be evaluated after a
+// successful negotiation.
+//
+// The _OSC method is preferred if the processor defines it; otherwise, the
+// legacy _PDC method is used. As _PDC does not report back the accepted
+// capabilities, all requested capabilities are assumed to be granted.
+func (c *CPU) Negotiate(vm *aml.VM, caps uint32) *kernel.Error {
+	if osc := c.Node.Child("_OSC"); osc != nil {
+		return c.negotiateOSC(vm, osc, caps)
+	}
+
+	if pdc := c.Node.Child("_PDC"); pdc != nil {
+		return c.negotiatePDC(vm, pdc, caps)
+	}
+
+	return errNoCapabilityMethod
+}
+
+// negotiateOSC evaluates _OSC with the processor UUID and a capabilities
+// buffer whose first dword holds the status flags and the second dword the
+// requested capabilities.
+func (c *CPU) negotiateOSC(vm *aml.VM, osc *aml.NamespaceNode, caps uint32) *kernel.Error {
+	buf := make([]byte, 8)
+	putDword(buf[4:], caps)
+
+	val, err := vm.Evaluate(osc.Path(), oscUUID, oscRevision, uint64(2), buf)
+	if err != nil {
+		return err
+	}
+
+	ret, ok := val.([]byte)
+	if !ok || len(ret) < 8 {
+		return errMalformedOSC
+	}
+
+	if getDword(ret)&oscErrorMask != 0 {
+		return errOSCFailed
+	}
+
+	c.Capabilities = getDword(ret[4:])
+	return nil
+}
+
+// negotiatePDC evaluates _PDC with a capabilities buffer that contains the
+// buffer revision, the number of capability dwords and the requested
+// capabilities.
+func (c *CPU) negotiatePDC(vm *aml.VM, pdc *aml.NamespaceNode, caps uint32) *kernel.Error {
+	buf := make([]byte, 12)
+	putDword(buf, pdcRevision)
+	putDword(buf[4:], 1)
+	putDword(buf[8:], caps)
+
+	if _, err := vm.Evaluate(pdc.Path(), buf); err != nil {
+		return err
+	}
+
+	c.Capabilities = caps
+	return nil
+}
+
+// putDword stores val into the first 4 bytes of buf in little-endian order.
+func putDword(buf []byte, val uint32) {
+	buf[0], buf[1], buf[2], buf[3] = byte(val), byte(val>>8), byte(val>>16), byte(val>>24)
+}
+
+// getDword returns the little-endian dword stored in the first 4 bytes of buf.
+func getDword(buf []byte) uint32 {
+	return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
+}
@@ -0,0 +1,181 @@
+package processor
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestProcessors(t *testing.T) {
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'P', 'R', '_'},
+			// Processor(CPU0, 0, 0x410, 6) {}
+			processorObj('0'),
+			// Processor(CPU1, 1, 0x410, 6) {}
+			processorObj('1'),
+		)),
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'S', 'B', '_'},
+			// Device(CPU2) { Name(_HID, "ACPI0007") Name(_UID, 2) }
+			processorDevice('2', []byte{0x08, '_', 'U', 'I', 'D', 0x0a, 0x02}),
+			// Device(CPU3) { Name(_HID, "ACPI0007") Name(_UID, "0x10") }
+			processorDevice('3', []byte{0x08, '_', 'U', 'I', 'D', 0x0d, '0', 'x', '1', '0', 0x00}),
+			// Device(CPU4) { Name(_HID, "ACPI0007") Name(_STA, 0) }
+			processorDevice('4', []byte{0x08, '_', 'S', 'T', 'A', 0x00}),
+		)),
+	))
+
+	cpus, err := Processors(vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []CPU{
+		{ID: 0, PblkAddr: 0x410, PblkLen: 6},
+		{ID: 1, PblkAddr: 0x410, PblkLen: 6},
+		{ID: 2},
+		{ID: 16},
+	}
+	expPaths := []string{`\_PR_.CPU0`, `\_PR_.CPU1`, `\_SB_.CPU2`, `\_SB_.CPU3`}
+
+	if len(cpus) != len(exp) {
+		t.Fatalf("expected to get %d processors; got %d", len(exp), len(cpus))
+	}
+
+	for index, cpu := range cpus {
+		if got := cpu.Node.Path(); got != expPaths[index] {
+			t.Errorf("[cpu %d] expected node path to be %q; got %q", index, expPaths[index], got)
+		}
+
+		cpu.Node = nil
+		if *cpu != exp[index] {
+			t.Errorf("[cpu %d] expected to get %+v; got %+v", index, exp[index], *cpu)
+		}
+	}
+}
+
+func TestProcessorsErrors(t *testing.T) {
+	specs := []struct {
+		payload []byte
+		expErr  *kernel.Error
+	}{
+		{
+			[]byte{0x08, 'I', 'N', 'T', '0', 0x00},
+			errNoProcessorsPresent,
+		},
+		// Device(CPU0) { Name(_HID, "ACPI0007") Name(_UID, "CPU") }
+		{
+			processorDevice('0', []byte{0x08, '_', 'U', 'I', 'D', 0x0d, 'C', 'P', 'U', 0x00}),
+			errMalformedUID,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, spec.payload)
+		if _, err := Processors(vm, ns); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestNegotiate(t *testing.T) {
+	var (
+		caps     = CapPStateFFH | CapSMPC1 | CapSMPPStateCoord | CapC1FFH | CapPPCNotify
+		capsName = []byte{'C', 'A', 'P', 'S'}
+	)
+
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		// Name(PDCV, 0)
+		[]byte{0x08, 'P', 'D', 'C', 'V', 0x00},
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'P', 'R', '_'},
+			// Method(_OSC, 4) {
+			//   CreateDWordField(Arg3, 4, CAPS)
+			//   And(CAPS, 0xff, CAPS)
+			//   Return(Arg3)
+			// }
+			processorObj('0', amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'_', 'O', 'S', 'C', 0x04},
+				[]byte{0x8a, 0x6b, 0x0a, 0x04}, capsName,
+				[]byte{0x7b}, capsName, []byte{0x0a, 0xff}, capsName,
+				[]byte{0xa4, 0x6b},
+			))),
+			// Method(_PDC, 1) {
+			//   CreateDWordField(Arg0, 8, CAPS)
+			//   Store(CAPS, \PDCV)
+			// }
+			processorObj('1', amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'_', 'P', 'D', 'C', 0x01},
+				[]byte{0x8a, 0x68, 0x0a, 0x08}, capsName,
+				[]byte{0x70}, capsName, []byte{'\\', 'P', 'D', 'C', 'V'},
+			))),
+			// Method(_OSC, 4) {
+			//   CreateDWordField(Arg3, 0, STS0)
+			//   Store(0x04, STS0)
+			//   Return(Arg3)
+			// }
+			processorObj('2', amltest.Pkg([]byte{0x14}, amltest.Concat(
+				[]byte{'_', 'O', 'S', 'C', 0x04},
+				[]byte{0x8a, 0x6b, 0x00, 'S', 'T', 'S', '0'},
+				[]byte{0x70, 0x0a, 0x04, 'S', 'T', 'S', '0'},
+				[]byte{0xa4, 0x6b},
+			))),
+			// Method(_OSC, 4) { Return(Zero) }
+			processorObj('3', amltest.Pkg([]byte{0x14}, []byte{'_', 'O', 'S', 'C', 0x04, 0xa4, 0x00})),
+			// Processor(CPU4, 4, 0x410, 6) {}
+			processorObj('4'),
+		)),
+	))
+
+	cpus, err := Processors(vm, ns)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		expCaps uint32
+		expErr  *kernel.Error
+	}{
+		{caps & 0xff, nil},
+		{caps, nil},
+		{0, errOSCFailed},
+		{0, errMalformedOSC},
+		{0, errNoCapabilityMethod},
+	}
+
+	if len(cpus) != len(specs) {
+		t.Fatalf("expected to get %d processors; got %d", len(specs), len(cpus))
+	}
+
+	for specIndex, spec := range specs {
+		cpu := cpus[specIndex]
+		if err := cpu.Negotiate(vm, caps); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if cpu.Capabilities != spec.expCaps {
+			t.Errorf("[spec %d] expected granted capabilities to be 0x%x; got 0x%x", specIndex, spec.expCaps, cpu.Capabilities)
+		}
+	}
+
+	// _PDC receives the requested capabilities in the third dword of its
+	// capabilities buffer.
+	if got, err := vm.Evaluate(`\PDCV`); err != nil || got != uint64(caps) {
+		t.Errorf("expected _PDC to receive capabilities 0x%x; got %v (err: %v)", caps, got, err)
+	}
+}
+
+// processorDevice returns the AML for Device(CPUx) { Name(_HID, "ACPI0007") }
+// with the supplied contents.
+func processorDevice(id byte, contents ...[]byte) []byte {
+	return amltest.Pkg([]byte{0x5b, 0x82}, amltest.Concat(
+		[]byte{'C', 'P', 'U', id},
+		[]byte{0x08, '_', 'H', 'I', 'D', 0x0d},
+		[]byte(hardwareID),
+		[]byte{0x00},
+		amltest.Concat(contents...),
+	))
+}
@@ -0,0 +1,221 @@
+package processor
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/idle"
+	"gopheros/kernel/kfmt"
+	"io"
+	"unsafe"
+)
+
+var (
+	errMalformedCST = &kernel.Error{Module: "acpi_processor", Message: "_CST must evaluate to a package with a count followed by 4-element packages", Code: kernel.ErrCodeCorrupted}
+	errNoSuchCState = &kernel.Error{Module: "acpi_processor", Message: "C-state index is out of range", Code: kernel.ErrCodeInvalidArgument}
+	errNoCStates    = &kernel.Error{Module: "acpi_processor", Message: "no processor defines a _CST object", Code: kernel.ErrCodeNotSupported}
+
+	waitForInterruptFn = cpu.WaitForInterrupt
+	monitorFn          = cpu.Monitor
+	mwaitFn            = cpu.MWait
+)
+
+// The register classes defined by Intel for functional fixed hardware C-state
+// entry registers. The class is stored in the BitOffset field of the register
+// descriptor.
+const (
+	ffhClassHalt  = uint8(1)
+	ffhClassMWait = uint8(2)
+
+	// When set in the AccessSize field of an MWAIT entry register, the
+	// processor is allowed to exit the C-state on an interrupt even if
+	// interrupts are disabled.
+	ffhMWaitBreakOnInterrupt = uint8(1 << 0)
+)
+
+// CStateType identifies the semantics of a processor power state.
+type CStateType uint8
+
+// The processor power states defined by the ACPI spec.
+const (
+	C1 CStateType = iota + 1
+	C2
+	C3
+)
+
+// CState describes a processor power state as reported by _CST.
+type CState struct {
+	Type CStateType
+
+	// The worst-case latency (in microseconds) for entering and exiting
+	// the state and the average power consumption (in mW) while in it.
+	Latency uint64
+	Power   uint64
+
+	entry register
+}
+
+// Power describes the power management interface of a processor.
+type Power struct {
+	Node *aml.NamespaceNode
+
+	// The available C-states ordered from the shallowest to the deepest
+	// state.
+	CStates []CState
+
+	// monitorWord is the memory location that is armed via MONITOR
+	// before entering a state using MWAIT.
+	monitorWord uint64
+}
+
+// PowerFor evaluates the _CST object of the processor at node.
+func PowerFor(vm *aml.VM, node *aml.NamespaceNode) (*Power, *kernel.Error) {
+	cst := node.Child("_CST")
+	if cst == nil {
+		return nil, errMalformedCST
+	}
+
+	val, err := vm.Evaluate(cst.Path())
+	if err != nil {
+		return nil, err
+	}
+
+	entries, ok := val.([]interface{})
+	if !ok || len(entries) == 0 {
+		return nil, errMalformedCST
+	}
+
+	if count, ok := entries[0].(uint64); !ok || count != uint64(len(entries)-1) {
+		return nil, errMalformedCST
+	}
+
+	power := &Power{Node: node}
+	for _, entry := range entries[1:] {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 4 {
+			return nil, errMalformedCST
+		}
+
+		reg, err := registerFromResource(fields[0])
+		if err != nil {
+			return nil, err
+		}
+
+		var values [3]uint64
+		for fieldIndex, field := range fields[1:] {
+			if values[fieldIndex], ok = field.(uint64); !ok {
+				return nil, errMalformedCST
+			}
+		}
+
+		if values[0] < uint64(C1) || values[0] > uint64(C3) {
+			return nil, errMalformedCST
+		}
+
+		power.CStates = append(power.CStates, CState{
+			Type:    CStateType(values[0]),
+			Latency: values[1],
+			Power:   values[2],
+			entry:   reg,
+		})
+	}
+
+	return power, nil
+}
+
+// Enter places the processor in the C-state at index and returns once the
+// processor resumes execution.
+func (p *Power) Enter(index int) *kernel.Error {
+	if index < 0 || index >= len(p.CStates) {
+		return errNoSuchCState
+	}
+
+	entry := p.CStates[index].entry
+	switch {
+	case entry.space == table.AddressSpaceFuncFixedHW && entry.bitOffset == ffhClassMWait:
+		var extensions uint32
+		if entry.accessSize&ffhMWaitBreakOnInterrupt != 0 {
+			extensions = 1
+		}
+
+		monitorFn(uintptr(unsafe.Pointer(&p.monitorWord)), 0, 0)
+		mwaitFn(uint32(entry.address), extensions)
+	case entry.space == table.AddressSpaceFuncFixedHW && entry.bitOffset == ffhClassHalt:
+		waitForInterruptFn()
+	case entry.space == table.AddressSpaceSysIO:
+		// Reading the P_LVLx register places the processor in the
+		// requested state
+		if _, err := entry.read(); err != nil {
+			return err
+		}
+	default:
+		return errUnsupportedRegister
+	}
+
+	return nil
+}
+
+// IdleDriver places the processor in the deepest C-state whose exit latency
+// does not exceed a configurable limit whenever the kernel has no work to do.
+type IdleDriver struct {
+	errWriter io.Writer
+	power     *Power
+
+	// The index of the C-state used for idling or -1 if the processor
+	// should be halted instead.
+	state int
+}
+
+// NewIdleDriver evaluates the _CST object of the first processor that defines
+// one and selects the deepest C-state whose latency (in microseconds) does not
+// exceed maxLatency. Errors encountered while entering a C-state are reported
+// to errWriter.
+func NewIdleDriver(errWriter io.Writer, vm *aml.VM, ns *aml.Namespace, maxLatency uint64) (*IdleDriver, *kernel.Error) {
+	for _, node := range processorNodes(vm, ns) {
+		if node.Child("_CST") == nil {
+			continue
+		}
+
+		power, err := PowerFor(vm, node)
+		if err != nil {
+			return nil, err
+		}
+
+		d := &IdleDriver{errWriter: errWriter, power: power, state: -1}
+		for index, state := range power.CStates {
+			if state.Latency <= maxLatency && (d.state == -1 || state.Type >= power.CStates[d.state].Type) {
+				d.state = index
+			}
+		}
+
+		return d, nil
+	}
+
+	return nil, errNoCStates
+}
+
+// Power returns the power management interface used by the driver.
+func (d *IdleDriver) Power() *Power {
+	return d.power
+}
+
+// Install registers the driver as the kernel idle handler.
+func (d *IdleDriver) Install() {
+	idle.SetHandler(d.Idle)
+}
+
+// Idle implements idle.Handler. If no suitable C-state is available or the
+// state cannot be entered, the processor is halted.
+func (d *IdleDriver) Idle() {
+	if d.state == -1 {
+		waitForInterruptFn()
+		return
+	}
+
+	if err := d.power.Enter(d.state); err != nil {
+		kfmt.Fprintf(d.errWriter, "[acpi_processor] unable to enter C%d: %s\n", d.power.CStates[d.state].Type, err.Error())
+		d.state = -1
+		waitForInterruptFn()
+	}
+}
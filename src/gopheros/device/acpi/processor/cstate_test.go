@@ -0,0 +1,166 @@
+package processor
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/kernel/idle"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestIdleDriver(t *testing.T) {
+	defer restoreHW()
+	defer idle.SetHandler(nil)
+	hw := newFakeHW()
+
+	vm, ns := vmtest.ForPayload(t, amltest.Pkg([]byte{0x10}, amltest.Concat(
+		[]byte{'\\', '_', 'P', 'R', '_'},
+		// Processor(CPU0, 0, 0x410, 6) { _CST }
+		processorObj('0', testCST),
+	)))
+
+	specs := []struct {
+		maxLatency uint64
+		expState   int
+		check      func() bool
+	}{
+		// No state is shallow enough; halt the processor
+		{0, -1, func() bool { return hw.waitIntrCalls == 1 }},
+		// C1 via HLT
+		{10, 0, func() bool { return hw.waitIntrCalls == 1 }},
+		// C2 via MWAIT
+		{100, 1, func() bool {
+			return hw.mwaitCalls == 1 && hw.mwaitHints == 0x10 && hw.mwaitExt == 1 && hw.monitorAddr != 0
+		}},
+		// C3 via P_LVL3
+		{500, 2, func() bool { return len(hw.portReads) == 1 && hw.portReads[0] == 0x415 }},
+	}
+
+	for specIndex, spec := range specs {
+		*hw = fakeHW{msrs: hw.msrs, ports: hw.ports}
+
+		drv, err := NewIdleDriver(ioutil.Discard, vm, ns, spec.maxLatency)
+		if err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if drv.state != spec.expState {
+			t.Errorf("[spec %d] expected selected state to be %d; got %d", specIndex, spec.expState, drv.state)
+			continue
+		}
+
+		drv.Install()
+		idle.Enter()
+
+		if !spec.check() {
+			t.Errorf("[spec %d] processor did not enter the expected state; hw state: %+v", specIndex, *hw)
+		}
+	}
+
+	t.Run("C-state list", func(t *testing.T) {
+		drv, _ := NewIdleDriver(ioutil.Discard, vm, ns, 0)
+		states := drv.Power().CStates
+		if len(states) != 3 {
+			t.Fatalf("expected 3 C-states; got %d", len(states))
+		}
+
+		for index, exp := range []CState{{Type: C1, Latency: 1, Power: 1000}, {Type: C2, Latency: 50, Power: 500}, {Type: C3, Latency: 200, Power: 100}} {
+			if got := states[index]; got.Type != exp.Type || got.Latency != exp.Latency || got.Power != exp.Power {
+				t.Errorf("expected C-state %d to be %+v; got %+v", index, exp, got)
+			}
+		}
+
+		if err := drv.Power().Enter(3); err != errNoSuchCState {
+			t.Fatalf("expected to get errNoSuchCState; got %v", err)
+		}
+	})
+}
+
+func TestIdleDriverFallback(t *testing.T) {
+	defer restoreHW()
+	hw := newFakeHW()
+
+	// Name(_CST, Package() { 1, Package() { ResourceTemplate() { Register(SystemMemory, 8, 0, 0x1000) }, 2, 10, 100 } })
+	vm, ns := vmtest.ForPayload(t, processorObj('0', amltest.Concat(
+		[]byte{0x08, '_', 'C', 'S', 'T'},
+		amltest.Pkg([]byte{0x12}, amltest.Concat(
+			[]byte{0x02, 0x01},
+			amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x04}, genericRegister(0x00, 8, 0, 0, 0x1000), []byte{0x0a, 0x02, 0x0a, 0x0a, 0x0a, 0x64})),
+		)),
+	)))
+
+	var errBuf bytes.Buffer
+	drv, err := NewIdleDriver(&errBuf, vm, ns, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	drv.Idle()
+	if drv.state != -1 || hw.waitIntrCalls != 1 || !strings.Contains(errBuf.String(), errUnsupportedRegister.Message) {
+		t.Fatalf("expected driver to fall back to halting the processor; state: %d, halts: %d, log: %q", drv.state, hw.waitIntrCalls, errBuf.String())
+	}
+
+	drv.Idle()
+	if hw.waitIntrCalls != 2 {
+		t.Fatalf("expected processor to be halted; got %d halts", hw.waitIntrCalls)
+	}
+}
+
+func TestIdleDriverErrors(t *testing.T) {
+	specs := []struct {
+		payload []byte
+		expErr  error
+	}{
+		{processorObj('0'), errNoCStates},
+		// Name(_CST, Package() { 2, Package() { ... } })
+		{
+			processorObj('0', amltest.Concat(
+				[]byte{0x08, '_', 'C', 'S', 'T'},
+				amltest.Pkg([]byte{0x12}, amltest.Concat(
+					[]byte{0x02, 0x0a, 0x02},
+					amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x04}, genericRegister(0x7f, 1, 1, 0, 0), []byte{0x01, 0x01, 0x01})),
+				)),
+			)),
+			errMalformedCST,
+		},
+		// Name(_CST, Package() { 1, Package() { ..., 4, 1, 1 } })
+		{
+			processorObj('0', amltest.Concat(
+				[]byte{0x08, '_', 'C', 'S', 'T'},
+				amltest.Pkg([]byte{0x12}, amltest.Concat(
+					[]byte{0x02, 0x01},
+					amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x04}, genericRegister(0x7f, 1, 1, 0, 0), []byte{0x0a, 0x04, 0x01, 0x01})),
+				)),
+			)),
+			errMalformedCST,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, spec.payload)
+		if _, err := NewIdleDriver(ioutil.Discard, vm, ns, 100); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+var (
+	// Name(_CST, Package() {
+	//   3,
+	//   Package() { ResourceTemplate() { Register(FFixedHW, 1, 1, 0, 0) }, 1, 1, 1000 },
+	//   Package() { ResourceTemplate() { Register(FFixedHW, 1, 2, 0x10, 1) }, 2, 50, 500 },
+	//   Package() { ResourceTemplate() { Register(SystemIO, 8, 0, 0x415) }, 3, 200, 100 },
+	// })
+	testCST = amltest.Concat(
+		[]byte{0x08, '_', 'C', 'S', 'T'},
+		amltest.Pkg([]byte{0x12}, amltest.Concat(
+			[]byte{0x04, 0x0a, 0x03},
+			amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x04}, genericRegister(0x7f, 1, 1, 0, 0), []byte{0x01, 0x01, 0x0b, 0xe8, 0x03})),
+			amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x04}, genericRegister(0x7f, 1, 2, 1, 0x10), []byte{0x0a, 0x02, 0x0a, 0x32, 0x0b, 0xf4, 0x01})),
+			amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x04}, genericRegister(0x01, 8, 0, 0, 0x415), []byte{0x0a, 0x03, 0x0a, 0xc8, 0x0a, 0x64})),
+		)),
+	)
+)
@@ -0,0 +1,158 @@
+// Package processor enumerates the processors declared in the ACPI namespace,
+// negotiates the OS processor capabilities with the firmware via _OSC/_PDC
+// and implements the ACPI processor performance (P-state) and power (C-state)
+// control interfaces.
+package processor
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/device"
+	"gopheros/device/acpi/aml/resource"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+)
+
+var (
+	errUnsupportedRegister = &kernel.Error{Module: "acpi_processor", Message: "processor control register uses an unsupported address space", Code: kernel.ErrCodeNotSupported}
+	errMalformedRegister   = &kernel.Error{Module: "acpi_processor", Message: "processor control register is not described by a Generic Register descriptor", Code: kernel.ErrCodeCorrupted}
+
+	portReadByteFn   = cpu.PortReadByte
+	portReadWordFn   = cpu.PortReadWord
+	portReadDwordFn  = cpu.PortReadDword
+	portWriteByteFn  = cpu.PortWriteByte
+	portWriteWordFn  = cpu.PortWriteWord
+	portWriteDwordFn = cpu.PortWriteDword
+	readMSRFn        = cpu.ReadMSR
+	writeMSRFn       = cpu.WriteMSR
+)
+
+// The hardware ID used by processor devices. Newer firmware declares
+// processors as Device objects with this ID instead of Processor objects.
+const hardwareID = "ACPI0007"
+
+// processorNodes returns the Processor objects and the present processor
+// devices defined in ns in the order in which they appear in the namespace.
+func processorNodes(vm *aml.VM, ns *aml.Namespace) []*aml.NamespaceNode {
+	var nodes []*aml.NamespaceNode
+
+	ns.Walk(aml.WalkFilter{Types: aml.ObjectTypeProcessor | aml.ObjectTypeDevice}, func(node *aml.NamespaceNode) bool {
+		if node.Type() == aml.ObjectTypeProcessor {
+			nodes = append(nodes, node)
+			return false
+		}
+
+		if info, err := device.Identify(vm, node); err == nil && info.Matches(hardwareID) && info.Present() {
+			nodes = append(nodes, node)
+			return false
+		}
+
+		return true
+	})
+
+	return nodes
+}
+
+// register describes a processor control or status register.
+type register struct {
+	space table.AddressSpace
+
+	// The location of the register value inside the register. A zero
+	// bitWidth indicates that the entire register is used.
+	bitWidth  uint8
+	bitOffset uint8
+
+	// The access size field of the descriptor. For functional fixed
+	// hardware registers, it contains vendor-specific flags.
+	accessSize uint8
+
+	// The I/O port or, for functional fixed hardware registers, the MSR
+	// that holds the register.
+	address uint64
+}
+
+// registerFromResource decodes a resource template that contains a single
+// Generic Register descriptor.
+func registerFromResource(val interface{}) (register, *kernel.Error) {
+	template, ok := val.([]byte)
+	if !ok {
+		return register{}, errMalformedRegister
+	}
+
+	descriptors, err := resource.Decode(template)
+	if err != nil {
+		return register{}, err
+	}
+
+	for _, desc := range descriptors {
+		if reg, ok := desc.(*resource.GenericRegister); ok {
+			return register{
+				space:      table.AddressSpace(reg.AddressSpace),
+				bitWidth:   reg.BitWidth,
+				bitOffset:  reg.BitOffset,
+				accessSize: reg.AccessSize,
+				address:    reg.Address,
+			}, nil
+		}
+	}
+
+	return register{}, errMalformedRegister
+}
+
+// read returns the value stored in the register.
+func (r register) read() (uint64, *kernel.Error) {
+	var val uint64
+
+	switch r.space {
+	case table.AddressSpaceFuncFixedHW:
+		val = readMSRFn(uint32(r.address))
+	case table.AddressSpaceSysIO:
+		switch {
+		case r.bitWidth+r.bitOffset <= 8:
+			val = uint64(portReadByteFn(uint16(r.address)))
+		case r.bitWidth+r.bitOffset <= 16:
+			val = uint64(portReadWordFn(uint16(r.address)))
+		default:
+			val = uint64(portReadDwordFn(uint16(r.address)))
+		}
+	default:
+		return 0, errUnsupportedRegister
+	}
+
+	return (val >> r.bitOffset) & r.mask(), nil
+}
+
+// write stores val into the register. Register bits outside the range
+// described by bitOffset and bitWidth are preserved.
+func (r register) write(val uint64) *kernel.Error {
+	switch r.space {
+	case table.AddressSpaceFuncFixedHW:
+		if r.bitWidth != 0 {
+			val = (readMSRFn(uint32(r.address)) &^ (r.mask() << r.bitOffset)) | ((val & r.mask()) << r.bitOffset)
+		}
+		writeMSRFn(uint32(r.address), val)
+	case table.AddressSpaceSysIO:
+		val = (val & r.mask()) << r.bitOffset
+		switch {
+		case r.bitWidth+r.bitOffset <= 8:
+			portWriteByteFn(uint16(r.address), uint8(val))
+		case r.bitWidth+r.bitOffset <= 16:
+			portWriteWordFn(uint16(r.address), uint16(val))
+		default:
+			portWriteDwordFn(uint16(r.address), uint32(val))
+		}
+	default:
+		return errUnsupportedRegister
+	}
+
+	return nil
+}
+
+// mask returns a mask for the register value bits.
+func (r register) mask() uint64 {
+	if r.bitWidth == 0 || r.bitWidth >= 64 {
+		return ^uint64(0)
+	}
+
+	return (1 << r.bitWidth) - 1
+}
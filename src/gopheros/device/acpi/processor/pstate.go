@@ -0,0 +1,379 @@
+package processor
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"io"
+)
+
+var (
+	errMalformedPSS   = &kernel.Error{Module: "acpi_processor", Message: "_PSS must evaluate to a package of 6-element packages", Code: kernel.ErrCodeCorrupted}
+	errMalformedPCT   = &kernel.Error{Module: "acpi_processor", Message: "_PCT must evaluate to a package with the control and status registers", Code: kernel.ErrCodeCorrupted}
+	errNoSuchPState   = &kernel.Error{Module: "acpi_processor", Message: "P-state index is out of range", Code: kernel.ErrCodeInvalidArgument}
+	errPStateLimited  = &kernel.Error{Module: "acpi_processor", Message: "P-state is not available due to the platform limit set by _PPC", Code: kernel.ErrCodeNotSupported}
+	errUnknownPState  = &kernel.Error{Module: "acpi_processor", Message: "performance status register does not match any P-state", Code: kernel.ErrCodeNotFound}
+	errNoSuchCPU      = &kernel.Error{Module: "acpi_processor", Message: "no performance control interface for the requested CPU", Code: kernel.ErrCodeNotFound}
+	errNoPerfControls = &kernel.Error{Module: "acpi_processor", Message: "no processor supports performance control", Code: kernel.ErrCodeNotSupported}
+)
+
+// The MSRs used for performance control by processors that declare their
+// _PCT registers as functional fixed hardware.
+const (
+	msrPerfStatus = uint64(0x198)
+	msrPerfCtl    = uint64(0x199)
+
+	// The width of the performance state field in the above MSRs.
+	perfFieldWidth = 16
+)
+
+// The notification that the firmware sends to a processor object when its
+// _PPC limit changes.
+const notifyPerformanceChanged = uint64(0x80)
+
+// PState describes a processor performance state as reported by _PSS.
+type PState struct {
+	// The core frequency in MHz and the typical power dissipation in mW.
+	CoreFrequency uint64
+	Power         uint64
+
+	// The worst-case latencies (in microseconds) for transitioning to
+	// this state and for bus masters to access memory while the
+	// transition is in progress.
+	TransitionLatency uint64
+	BusMasterLatency  uint64
+
+	// The value to write to the control register for entering this
+	// state and the value that the status register reports once the
+	// transition completes.
+	Control uint64
+	Status  uint64
+}
+
+// Performance describes the performance control interface of a processor.
+type Performance struct {
+	Node *aml.NamespaceNode
+
+	// The available P-states ordered from the highest to the lowest
+	// performance state.
+	PStates []PState
+
+	// Limit is the index of the highest performance state that the OS may
+	// use as reported by _PPC.
+	Limit int
+
+	control register
+	status  register
+}
+
+// PerformanceFor evaluates the _PSS, _PCT and optional _PPC objects of the
+// processor at node.
+func PerformanceFor(vm *aml.VM, node *aml.NamespaceNode) (*Performance, *kernel.Error) {
+	perf := &Performance{Node: node}
+
+	if err := perf.evalPSS(vm); err != nil {
+		return nil, err
+	}
+
+	if err := perf.evalPCT(vm); err != nil {
+		return nil, err
+	}
+
+	if err := perf.UpdateLimit(vm); err != nil {
+		return nil, err
+	}
+
+	return perf, nil
+}
+
+// UpdateLimit re-evaluates the _PPC object of the processor. Processors
+// without a _PPC object can use all of their P-states.
+func (p *Performance) UpdateLimit(vm *aml.VM) *kernel.Error {
+	ppc := p.Node.Child("_PPC")
+	if ppc == nil {
+		p.Limit = 0
+		return nil
+	}
+
+	val, err := vm.Evaluate(ppc.Path())
+	if err != nil {
+		return err
+	}
+
+	limit, ok := val.(uint64)
+	if !ok || limit >= uint64(len(p.PStates)) {
+		// Ignore invalid limits
+		limit = 0
+	}
+
+	p.Limit = int(limit)
+	return nil
+}
+
+// SetPState requests the processor to enter the P-state at index.
+func (p *Performance) SetPState(index int) *kernel.Error {
+	switch {
+	case index < 0 || index >= len(p.PStates):
+		return errNoSuchPState
+	case index < p.Limit:
+		return errPStateLimited
+	}
+
+	return p.control.write(p.PStates[index].Control)
+}
+
+// CurrentPState returns the index of the P-state that the processor reports
+// via its status register.
+func (p *Performance) CurrentPState() (int, *kernel.Error) {
+	status, err := p.status.read()
+	if err != nil {
+		return 0, err
+	}
+
+	for index, state := range p.PStates {
+		if state.Status&p.status.mask() == status {
+			return index, nil
+		}
+	}
+
+	return 0, errUnknownPState
+}
+
+// evalPSS populates the P-state list from the _PSS object.
+func (p *Performance) evalPSS(vm *aml.VM) *kernel.Error {
+	pss := p.Node.Child("_PSS")
+	if pss == nil {
+		return errMalformedPSS
+	}
+
+	val, err := vm.Evaluate(pss.Path())
+	if err != nil {
+		return err
+	}
+
+	entries, ok := val.([]interface{})
+	if !ok || len(entries) == 0 {
+		return errMalformedPSS
+	}
+
+	p.PStates = make([]PState, len(entries))
+	for index, entry := range entries {
+		fields, ok := entry.([]interface{})
+		if !ok || len(fields) != 6 {
+			return errMalformedPSS
+		}
+
+		var values [6]uint64
+		for fieldIndex, field := range fields {
+			if values[fieldIndex], ok = field.(uint64); !ok {
+				return errMalformedPSS
+			}
+		}
+
+		p.PStates[index] = PState{
+			CoreFrequency:     values[0],
+			Power:             values[1],
+			TransitionLatency: values[2],
+			BusMasterLatency:  values[3],
+			Control:           values[4],
+			Status:            values[5],
+		}
+	}
+
+	return nil
+}
+
+// evalPCT decodes the control and status registers from the _PCT object.
+func (p *Performance) evalPCT(vm *aml.VM) *kernel.Error {
+	pct := p.Node.Child("_PCT")
+	if pct == nil {
+		return errMalformedPCT
+	}
+
+	val, err := vm.Evaluate(pct.Path())
+	if err != nil {
+		return err
+	}
+
+	regs, ok := val.([]interface{})
+	if !ok || len(regs) != 2 {
+		return errMalformedPCT
+	}
+
+	if p.control, err = registerFromResource(regs[0]); err != nil {
+		return err
+	}
+
+	if p.status, err = registerFromResource(regs[1]); err != nil {
+		return err
+	}
+
+	// Functional fixed hardware registers use the architectural
+	// performance control MSRs.
+	for _, reg := range []struct {
+		reg *register
+		msr uint64
+	}{
+		{&p.control, msrPerfCtl},
+		{&p.status, msrPerfStatus},
+	} {
+		if reg.reg.space == table.AddressSpaceFuncFixedHW {
+			reg.reg.address, reg.reg.bitOffset, reg.reg.bitWidth = reg.msr, 0, perfFieldWidth
+		}
+	}
+
+	return nil
+}
+
+// Governor selects the P-state that a processor should use.
+type Governor interface {
+	// Select returns the index of the P-state to use given the
+	// processor load as a percentage of the time spent executing tasks
+	// since the previous invocation.
+	Select(perf *Performance, load uint8) int
+}
+
+// PerformanceGovernor always selects the highest available P-state.
+type PerformanceGovernor struct{}
+
+// Select implements Governor.
+func (PerformanceGovernor) Select(perf *Performance, _ uint8) int {
+	return perf.Limit
+}
+
+// OndemandGovernor scales the processor frequency proportionally to its load
+// and switches to the highest available P-state when the load exceeds
+// UpThreshold.
+type OndemandGovernor struct {
+	UpThreshold uint8
+}
+
+// Select implements Governor. It picks the slowest P-state whose frequency is
+// high enough to service the current load.
+func (g OndemandGovernor) Select(perf *Performance, load uint8) int {
+	if load >= g.UpThreshold {
+		return perf.Limit
+	}
+
+	var (
+		maxFreq    = perf.PStates[perf.Limit].CoreFrequency
+		targetFreq = maxFreq * uint64(load) / uint64(g.UpThreshold)
+		selected   = perf.Limit
+	)
+
+	for index := perf.Limit + 1; index < len(perf.PStates); index++ {
+		if perf.PStates[index].CoreFrequency < targetFreq {
+			break
+		}
+		selected = index
+	}
+
+	return selected
+}
+
+// FreqDriver adjusts the P-state of each processor based on its load using a
+// Governor. Processors are identified by their position in the namespace.
+type FreqDriver struct {
+	errWriter io.Writer
+	vm        *aml.VM
+	governor  Governor
+
+	cpus []*Performance
+
+	// The index of the P-state last selected for each processor.
+	current []int
+}
+
+// NewFreqDriver locates the processors that support performance control and
+// installs notify handlers for tracking changes to their _PPC limits.
+// Processors without a valid performance control interface are skipped and
+// the errors are reported to errWriter.
+func NewFreqDriver(errWriter io.Writer, vm *aml.VM, ns *aml.Namespace, governor Governor) (*FreqDriver, *kernel.Error) {
+	d := &FreqDriver{
+		errWriter: errWriter,
+		vm:        vm,
+		governor:  governor,
+	}
+
+	for _, node := range processorNodes(vm, ns) {
+		if node.Child("_PSS") == nil {
+			continue
+		}
+
+		perf, err := PerformanceFor(vm, node)
+		if err == nil {
+			err = vm.InstallNotifyHandler(node.Path(), d.handleNotify)
+		}
+
+		if err != nil {
+			kfmt.Fprintf(errWriter, "[acpi_processor] %s: %s\n", node.Path(), err.Error())
+			continue
+		}
+
+		d.cpus = append(d.cpus, perf)
+		d.current = append(d.current, -1)
+	}
+
+	if len(d.cpus) == 0 {
+		return nil, errNoPerfControls
+	}
+
+	return d, nil
+}
+
+// CPUs returns the performance control interface for each processor managed
+// by the driver.
+func (d *FreqDriver) CPUs() []*Performance {
+	return d.cpus
+}
+
+// Update consults the governor with the load of the processor at cpuIndex
+// and switches to the selected P-state if it differs from the active one.
+func (d *FreqDriver) Update(cpuIndex int, load uint8) *kernel.Error {
+	if cpuIndex < 0 || cpuIndex >= len(d.cpus) {
+		return errNoSuchCPU
+	}
+
+	perf := d.cpus[cpuIndex]
+	next := d.governor.Select(perf, load)
+	if next == d.current[cpuIndex] {
+		return nil
+	}
+
+	if err := perf.SetPState(next); err != nil {
+		return err
+	}
+
+	d.current[cpuIndex] = next
+	return nil
+}
+
+// handleNotify implements aml.NotifyHandler for processor objects. When the
+// platform limit changes, the processor is moved out of any P-state that is
+// no longer available.
+func (d *FreqDriver) handleNotify(node *aml.NamespaceNode, value uint64) {
+	if value != notifyPerformanceChanged {
+		return
+	}
+
+	for cpuIndex, perf := range d.cpus {
+		if perf.Node != node {
+			continue
+		}
+
+		if err := perf.UpdateLimit(d.vm); err != nil {
+			kfmt.Fprintf(d.errWriter, "[acpi_processor] %s: %s\n", node.Path(), err.Error())
+			return
+		}
+
+		if d.current[cpuIndex] >= 0 && d.current[cpuIndex] < perf.Limit {
+			if err := perf.SetPState(perf.Limit); err != nil {
+				kfmt.Fprintf(d.errWriter, "[acpi_processor] %s: %s\n", node.Path(), err.Error())
+				return
+			}
+			d.current[cpuIndex] = perf.Limit
+		}
+		return
+	}
+}
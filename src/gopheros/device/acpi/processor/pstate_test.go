@@ -0,0 +1,250 @@
+package processor
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/kernel/cpu"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFreqDriver(t *testing.T) {
+	defer restoreHW()
+	hw := newFakeHW()
+	hw.msrs[msrPerfCtl] = 0xabcd0000
+
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		amltest.Pkg([]byte{0x10}, amltest.Concat(
+			[]byte{'\\', '_', 'P', 'R', '_'},
+			// Processor(CPU0, 0, 0x410, 6) { _PSS, _PCT (FFixedHW), _PPC }
+			processorObj('0', testPSS, pctFFH, []byte{0x08, '_', 'P', 'P', 'C', 0x00}),
+			// Processor(CPU1, 1, 0x410, 6) { _PSS, _PCT (SystemIO) }
+			processorObj('1', testPSS, pctSysIO),
+			// Processor(CPU2, 2, 0x410, 6) {}
+			processorObj('2'),
+			// Processor(CPU3, 3, 0x410, 6) { _PSS, Name(_PCT, Zero) }
+			processorObj('3', testPSS, []byte{0x08, '_', 'P', 'C', 'T', 0x00}),
+		)),
+		// Method(LIMT, 1) { Store(Arg0, \_PR.CPU0._PPC) Notify(\_PR.CPU0, 0x80) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'L', 'I', 'M', 'T', 0x01,
+			0x70, 0x68, '\\', 0x2f, 0x03, '_', 'P', 'R', '_', 'C', 'P', 'U', '0', '_', 'P', 'P', 'C',
+			0x86, '\\', 0x2e, '_', 'P', 'R', '_', 'C', 'P', 'U', '0', 0x0a, 0x80,
+		}),
+	))
+
+	var errBuf bytes.Buffer
+	drv, err := NewFreqDriver(&errBuf, vm, ns, OndemandGovernor{UpThreshold: 80})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(errBuf.String(), `\_PR_.CPU3: `+errMalformedPCT.Message) {
+		t.Fatalf("expected an error about the malformed _PCT of CPU3; got %q", errBuf.String())
+	}
+
+	cpus := drv.CPUs()
+	if len(cpus) != 2 {
+		t.Fatalf("expected 2 processors with performance controls; got %d", len(cpus))
+	}
+
+	expStates := []PState{
+		{CoreFrequency: 2000, Power: 25000, TransitionLatency: 10, BusMasterLatency: 10, Control: 0x1400, Status: 0x1400},
+		{CoreFrequency: 1600, Power: 18000, TransitionLatency: 10, BusMasterLatency: 10, Control: 0x1000, Status: 0x1000},
+		{CoreFrequency: 800, Power: 8000, TransitionLatency: 10, BusMasterLatency: 10, Control: 0x0800, Status: 0x0800},
+	}
+	if !reflect.DeepEqual(cpus[0].PStates, expStates) {
+		t.Fatalf("expected P-states:\n%v\ngot:\n%v", expStates, cpus[0].PStates)
+	}
+
+	specs := []struct {
+		cpu    int
+		load   uint8
+		expReg func() uint64
+		exp    uint64
+	}{
+		{0, 100, func() uint64 { return hw.msrs[msrPerfCtl] }, 0xabcd1400},
+		{0, 50, func() uint64 { return hw.msrs[msrPerfCtl] }, 0xabcd1000},
+		{0, 5, func() uint64 { return hw.msrs[msrPerfCtl] }, 0xabcd0800},
+		{1, 90, func() uint64 { return uint64(hw.ports[0xb2]) }, 0x1400},
+		{1, 30, func() uint64 { return uint64(hw.ports[0xb2]) }, 0x0800},
+	}
+
+	for specIndex, spec := range specs {
+		if err := drv.Update(spec.cpu, spec.load); err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+
+		if got := spec.expReg(); got != spec.exp {
+			t.Errorf("[spec %d] expected control register to be 0x%x; got 0x%x", specIndex, spec.exp, got)
+		}
+	}
+
+	t.Run("current P-state", func(t *testing.T) {
+		hw.msrs[msrPerfStatus] = 0xffff1000
+		if got, err := cpus[0].CurrentPState(); err != nil || got != 1 {
+			t.Fatalf("expected current P-state to be 1; got %d (err: %v)", got, err)
+		}
+
+		hw.ports[0xb3] = 0x1234
+		if _, err := cpus[1].CurrentPState(); err != errUnknownPState {
+			t.Fatalf("expected to get errUnknownPState; got %v", err)
+		}
+	})
+
+	t.Run("platform limit", func(t *testing.T) {
+		if _, err := vm.Evaluate(`LIMT`, uint64(1)); err != nil {
+			t.Fatal(err)
+		}
+		vm.DispatchNotifications()
+
+		// CPU0 was running at the slowest P-state which is still allowed
+		if cpus[0].Limit != 1 || hw.msrs[msrPerfCtl] != 0xabcd0800 {
+			t.Fatalf("expected limit to be 1 without a P-state change; got limit %d, ctl 0x%x", cpus[0].Limit, hw.msrs[msrPerfCtl])
+		}
+
+		if err := drv.Update(0, 100); err != nil || hw.msrs[msrPerfCtl] != 0xabcd1000 {
+			t.Fatalf("expected governor to select the limited P-state; got ctl 0x%x (err: %v)", hw.msrs[msrPerfCtl], err)
+		}
+
+		if err := cpus[0].SetPState(0); err != errPStateLimited {
+			t.Fatalf("expected to get errPStateLimited; got %v", err)
+		}
+
+		if err := cpus[0].SetPState(3); err != errNoSuchPState {
+			t.Fatalf("expected to get errNoSuchPState; got %v", err)
+		}
+	})
+
+	if err := drv.Update(2, 0); err != errNoSuchCPU {
+		t.Fatalf("expected to get errNoSuchCPU; got %v", err)
+	}
+}
+
+func TestFreqDriverWithoutPerfControls(t *testing.T) {
+	vm, ns := vmtest.ForPayload(t, processorObj('0'))
+	if _, err := NewFreqDriver(ioutil.Discard, vm, ns, PerformanceGovernor{}); err != errNoPerfControls {
+		t.Fatalf("expected to get errNoPerfControls; got %v", err)
+	}
+}
+
+func TestPerformanceGovernor(t *testing.T) {
+	perf := &Performance{PStates: make([]PState, 3), Limit: 1}
+	if got := (PerformanceGovernor{}).Select(perf, 0); got != 1 {
+		t.Fatalf("expected governor to select P-state 1; got %d", got)
+	}
+}
+
+var (
+	// Name(_PSS, Package() {
+	//   Package() { 2000, 25000, 10, 10, 0x1400, 0x1400 },
+	//   Package() { 1600, 18000, 10, 10, 0x1000, 0x1000 },
+	//   Package() { 800, 8000, 10, 10, 0x0800, 0x0800 },
+	// })
+	testPSS = amltest.Concat(
+		[]byte{0x08, '_', 'P', 'S', 'S'},
+		amltest.Pkg([]byte{0x12}, amltest.Concat(
+			[]byte{0x03},
+			amltest.Pkg([]byte{0x12}, []byte{0x06, 0x0b, 0xd0, 0x07, 0x0b, 0xa8, 0x61, 0x0a, 0x0a, 0x0a, 0x0a, 0x0b, 0x00, 0x14, 0x0b, 0x00, 0x14}),
+			amltest.Pkg([]byte{0x12}, []byte{0x06, 0x0b, 0x40, 0x06, 0x0b, 0x50, 0x46, 0x0a, 0x0a, 0x0a, 0x0a, 0x0b, 0x00, 0x10, 0x0b, 0x00, 0x10}),
+			amltest.Pkg([]byte{0x12}, []byte{0x06, 0x0b, 0x20, 0x03, 0x0b, 0x40, 0x1f, 0x0a, 0x0a, 0x0a, 0x0a, 0x0b, 0x00, 0x08, 0x0b, 0x00, 0x08}),
+		)),
+	)
+
+	// Name(_PCT, Package() {
+	//   ResourceTemplate() { Register(FFixedHW, 0, 0, 0) },
+	//   ResourceTemplate() { Register(FFixedHW, 0, 0, 0) },
+	// })
+	pctFFH = pct(genericRegister(0x7f, 0, 0, 0, 0), genericRegister(0x7f, 0, 0, 0, 0))
+
+	// Name(_PCT, Package() {
+	//   ResourceTemplate() { Register(SystemIO, 16, 0, 0xb2) },
+	//   ResourceTemplate() { Register(SystemIO, 16, 0, 0xb3) },
+	// })
+	pctSysIO = pct(genericRegister(0x01, 16, 0, 0, 0xb2), genericRegister(0x01, 16, 0, 0, 0xb3))
+)
+
+// processorObj returns the AML for Processor(CPUx, x, 0x410, 6) with the
+// supplied contents.
+func processorObj(id byte, contents ...[]byte) []byte {
+	return amltest.Pkg([]byte{0x5b, 0x83}, amltest.Concat(
+		[]byte{'C', 'P', 'U', id, id - '0', 0x10, 0x04, 0x00, 0x00, 0x06},
+		amltest.Concat(contents...),
+	))
+}
+
+func pct(control, status []byte) []byte {
+	return amltest.Concat(
+		[]byte{0x08, '_', 'P', 'C', 'T'},
+		amltest.Pkg([]byte{0x12}, amltest.Concat([]byte{0x02}, control, status)),
+	)
+}
+
+// genericRegister returns the AML for a Buffer containing a resource template
+// with a single Generic Register descriptor.
+func genericRegister(space, bitWidth, bitOffset, accessSize uint8, address uint64) []byte {
+	desc := []byte{0x82, 0x0c, 0x00, space, bitWidth, bitOffset, accessSize}
+	for i := uint(0); i < 8; i++ {
+		desc = append(desc, byte(address>>(i*8)))
+	}
+	desc = append(desc, 0x79, 0x00)
+
+	return amltest.Pkg([]byte{0x11}, amltest.Concat([]byte{0x0a, byte(len(desc))}, desc))
+}
+
+type fakeHW struct {
+	msrs  map[uint64]uint64
+	ports map[uint16]uint32
+
+	// The ports that have been read and the arguments of the last
+	// MONITOR/MWAIT invocations.
+	portReads                 []uint16
+	monitorAddr               uintptr
+	mwaitHints, mwaitExt      uint32
+	mwaitCalls, waitIntrCalls int
+}
+
+func newFakeHW() *fakeHW {
+	hw := &fakeHW{
+		msrs:  make(map[uint64]uint64),
+		ports: make(map[uint16]uint32),
+	}
+
+	readMSRFn = func(msr uint32) uint64 { return hw.msrs[uint64(msr)] }
+	writeMSRFn = func(msr uint32, val uint64) { hw.msrs[uint64(msr)] = val }
+	portReadByteFn = func(port uint16) uint8 {
+		hw.portReads = append(hw.portReads, port)
+		return uint8(hw.ports[port])
+	}
+	portReadWordFn = func(port uint16) uint16 { return uint16(hw.ports[port]) }
+	portReadDwordFn = func(port uint16) uint32 { return hw.ports[port] }
+	portWriteByteFn = func(port uint16, val uint8) { hw.ports[port] = uint32(val) }
+	portWriteWordFn = func(port uint16, val uint16) { hw.ports[port] = uint32(val) }
+	portWriteDwordFn = func(port uint16, val uint32) { hw.ports[port] = val }
+	monitorFn = func(addr uintptr, _, _ uint32) { hw.monitorAddr = addr }
+	mwaitFn = func(hints, ext uint32) {
+		hw.mwaitHints, hw.mwaitExt = hints, ext
+		hw.mwaitCalls++
+	}
+	waitForInterruptFn = func() { hw.waitIntrCalls++ }
+
+	return hw
+}
+
+func restoreHW() {
+	readMSRFn = cpu.ReadMSR
+	writeMSRFn = cpu.WriteMSR
+	portReadByteFn = cpu.PortReadByte
+	portReadWordFn = cpu.PortReadWord
+	portReadDwordFn = cpu.PortReadDword
+	portWriteByteFn = cpu.PortWriteByte
+	portWriteWordFn = cpu.PortWriteWord
+	portWriteDwordFn = cpu.PortWriteDword
+	monitorFn = cpu.Monitor
+	mwaitFn = cpu.MWait
+	waitForInterruptFn = cpu.WaitForInterrupt
+}
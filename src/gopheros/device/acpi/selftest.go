@@ -0,0 +1,56 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/kernel"
+	"gopheros/kernel/selftest"
+)
+
+// selfTestUnknownInterface is an _OSI interface string that the interpreter
+// must never report as supported.
+const selfTestUnknownInterface = "gopheros self-test"
+
+var (
+	errSelfTestNoInterpreter = &kernel.Error{Module: "acpi", Message: "self-test: no AML interpreter attached", Code: kernel.ErrCodeNotFound}
+	errSelfTestOSIResult     = &kernel.Error{Module: "acpi", Message: "self-test: _OSI returned an unexpected result", Code: kernel.ErrCodeCorrupted}
+)
+
+// selfTestAMLSmoke evaluates a few objects through the AML interpreter that
+// was attached while loading the namespace. The builtin _OSI method must
+// report a default interface as supported and an unknown one as unsupported.
+// If the firmware defines the \_S5 sleep object, it must evaluate to a valid
+// sleep state package.
+func selfTestAMLSmoke() *kernel.Error {
+	if activeVM == nil {
+		return errSelfTestNoInterpreter
+	}
+
+	for _, spec := range []struct {
+		iface     string
+		supported bool
+	}{
+		{aml.DefaultOSIInterfaces[0], true},
+		{selfTestUnknownInterface, false},
+	} {
+		res, err := activeVM.Evaluate(`\_OSI`, spec.iface)
+		if err != nil {
+			return err
+		}
+
+		if val, ok := res.(uint64); !ok || (val != 0) != spec.supported {
+			return errSelfTestOSIResult
+		}
+	}
+
+	if activeNS.Lookup(nil, `\_S5`) != nil {
+		if _, _, err := sleepTypes(`\_S5`); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	selftest.Register("acpi/aml-smoke", selfTestAMLSmoke)
+}
@@ -0,0 +1,45 @@
+package acpi
+
+import (
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestSelfTestAMLSmoke(t *testing.T) {
+	defer restorePowerHW()
+
+	if err := selfTestAMLSmoke(); err != errSelfTestNoInterpreter {
+		t.Fatalf("expected to get error %v; got %v", errSelfTestNoInterpreter, err)
+	}
+
+	specs := []struct {
+		payload    []byte
+		osiHandler func(string) bool
+		expErr     *kernel.Error
+	}{
+		// No \_S5 object
+		{nil, nil, nil},
+		// Name(_S5, Package() { 5, 5 })
+		{amltest.Concat([]byte{0x08, '_', 'S', '5', '_'}, amltest.Pkg([]byte{0x12}, []byte{0x02, 0x0a, 0x05, 0x0a, 0x05})), nil, nil},
+		// Name(_S5, "x")
+		{[]byte{0x08, '_', 'S', '5', '_', 0x0d, 'x', 0x00}, nil, errMalformedSleepState},
+		// _OSI reports all interfaces as supported
+		{nil, func(string) bool { return true }, errSelfTestOSIResult},
+		// _OSI reports no interface as supported
+		{nil, func(string) bool { return false }, errSelfTestOSIResult},
+	}
+
+	for specIndex, spec := range specs {
+		vm, ns := vmtest.ForPayload(t, spec.payload)
+		if spec.osiHandler != nil {
+			vm.SetOSIHandler(spec.osiHandler)
+		}
+		AttachInterpreter(vm, ns)
+
+		if err := selfTestAMLSmoke(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
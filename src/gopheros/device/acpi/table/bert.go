@@ -0,0 +1,187 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotBERT               = &kernel.Error{Module: "acpi_table", Message: "table is not a BERT table", Code: kernel.ErrCodeInvalidArgument}
+	errBERTTruncated         = &kernel.Error{Module: "acpi_table", Message: "BERT table is too short", Code: kernel.ErrCodeCorrupted}
+	errMalformedErrorStatus  = &kernel.Error{Module: "acpi_table", Message: "malformed generic error status block", Code: kernel.ErrCodeCorrupted}
+	errMalformedErrorSection = &kernel.Error{Module: "acpi_table", Message: "malformed generic error data entry", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature and length of the BERT table.
+const (
+	bertSignature = "BERT"
+	bertTableLen  = 48
+)
+
+// The lengths of the generic error status block header and of the generic
+// error data entry headers. Data entries with revision 0x300 or later also
+// include a timestamp.
+const (
+	errorStatusHeaderLen     = 20
+	errorDataHeaderLen       = 72
+	errorDataHeaderLenV3     = 80
+	errorDataTimestampMinRev = 0x300
+)
+
+// The bits of the block status field of a generic error status block.
+const (
+	// ErrorStatusUncorrectable indicates that the block contains at
+	// least one uncorrectable error.
+	ErrorStatusUncorrectable = uint32(1 << 0)
+
+	// ErrorStatusCorrectable indicates that the block contains at least
+	// one correctable error.
+	ErrorStatusCorrectable = uint32(1 << 1)
+
+	// ErrorStatusMultipleUncorrectable and ErrorStatusMultipleCorrectable
+	// indicate that more than one error of the respective kind occurred.
+	ErrorStatusMultipleUncorrectable = uint32(1 << 2)
+	ErrorStatusMultipleCorrectable   = uint32(1 << 3)
+
+	// The number of error data entries in the block.
+	errorStatusEntryCountShift = 4
+	errorStatusEntryCountMask  = uint32(0x3ff << errorStatusEntryCountShift)
+)
+
+// ErrorSeverity describes the severity of a hardware error.
+type ErrorSeverity uint32
+
+// The list of error severities defined by the UEFI specification.
+const (
+	ErrorSeverityRecoverable ErrorSeverity = iota
+	ErrorSeverityFatal
+	ErrorSeverityCorrected
+	ErrorSeverityNone
+)
+
+// String implements fmt.Stringer for ErrorSeverity.
+func (s ErrorSeverity) String() string {
+	switch s {
+	case ErrorSeverityRecoverable:
+		return "recoverable"
+	case ErrorSeverityFatal:
+		return "fatal"
+	case ErrorSeverityCorrected:
+		return "corrected"
+	case ErrorSeverityNone:
+		return "informational"
+	default:
+		return "unknown"
+	}
+}
+
+// The section types of the generic error data entries that are relevant to
+// x86 systems. The GUIDs are stored in their in-memory representation.
+var (
+	ErrorSectionProcessorGeneric = [16]byte{0xad, 0xcc, 0x76, 0x98, 0xb4, 0x47, 0xdb, 0x4b, 0xb6, 0x5e, 0x16, 0xf1, 0x93, 0xc4, 0xf3, 0xdb}
+	ErrorSectionProcessorX86     = [16]byte{0xb0, 0xa0, 0x3e, 0xdc, 0x44, 0xa1, 0x97, 0x47, 0xb9, 0x5b, 0x53, 0xfa, 0x24, 0x2b, 0x6e, 0x1d}
+	ErrorSectionMemory           = [16]byte{0x14, 0x11, 0xbc, 0xa5, 0x64, 0x6f, 0xde, 0x4e, 0xb8, 0x63, 0x3e, 0x83, 0xed, 0x7c, 0x83, 0xb1}
+	ErrorSectionPCIe             = [16]byte{0x54, 0xe9, 0x95, 0xd9, 0xc1, 0xbb, 0x0f, 0x43, 0xad, 0x91, 0xb4, 0x4d, 0xcb, 0x3c, 0x6f, 0x35}
+)
+
+// BERTInfo contains the decoded contents of the Boot Error Record Table which
+// describes the region where the firmware logs hardware errors that occurred
+// during the previous boot.
+type BERTInfo struct {
+	// The physical address and length of the boot error region. The
+	// region contains a generic error status block.
+	RegionAddress uint64
+	RegionLength  uint32
+}
+
+// DecodeBERT decodes the BERT table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeBERT(header *SDTHeader) (*BERTInfo, *kernel.Error) {
+	if string(header.Signature[:]) != bertSignature {
+		return nil, errNotBERT
+	}
+
+	data := tableData(header)
+	if len(data) < bertTableLen {
+		return nil, errBERTTruncated
+	}
+
+	return &BERTInfo{
+		RegionLength:  dword(data[36:]),
+		RegionAddress: qword(data[40:]),
+	}, nil
+}
+
+// ErrorSection is a generic error data entry that describes a single error.
+type ErrorSection struct {
+	// The GUID that identifies the format of Data.
+	SectionType [16]byte
+
+	Severity ErrorSeverity
+	Revision uint16
+
+	// The section contents whose format depends on SectionType.
+	Data []byte
+}
+
+// ErrorStatusBlock contains the decoded contents of a generic error status
+// block which is used by the firmware for reporting hardware errors.
+type ErrorStatusBlock struct {
+	// The block status bits. A zero value indicates that the block
+	// contains no errors.
+	BlockStatus uint32
+
+	// The severity of the most severe error in the block.
+	Severity ErrorSeverity
+
+	Sections []ErrorSection
+}
+
+// DecodeErrorStatusBlock decodes the generic error status block stored in
+// data. The returned sections reference the contents of data.
+func DecodeErrorStatusBlock(data []byte) (*ErrorStatusBlock, *kernel.Error) {
+	if len(data) < errorStatusHeaderLen {
+		return nil, errMalformedErrorStatus
+	}
+
+	block := &ErrorStatusBlock{
+		BlockStatus: dword(data),
+		Severity:    ErrorSeverity(dword(data[16:])),
+	}
+
+	if block.BlockStatus == 0 {
+		return block, nil
+	}
+
+	dataLen := int(dword(data[12:]))
+	if dataLen > len(data)-errorStatusHeaderLen {
+		return nil, errMalformedErrorStatus
+	}
+
+	entries := data[errorStatusHeaderLen : errorStatusHeaderLen+dataLen]
+	count := (block.BlockStatus & errorStatusEntryCountMask) >> errorStatusEntryCountShift
+	for ; count > 0 && len(entries) > 0; count-- {
+		if len(entries) < errorDataHeaderLen {
+			return nil, errMalformedErrorSection
+		}
+
+		section := ErrorSection{
+			Severity: ErrorSeverity(dword(entries[16:])),
+			Revision: word(entries[20:]),
+		}
+		copy(section.SectionType[:], entries)
+
+		headerLen := errorDataHeaderLen
+		if section.Revision >= errorDataTimestampMinRev {
+			headerLen = errorDataHeaderLenV3
+		}
+
+		sectionLen := int(dword(entries[24:]))
+		if headerLen+sectionLen > len(entries) {
+			return nil, errMalformedErrorSection
+		}
+
+		section.Data = entries[headerLen : headerLen+sectionLen]
+		block.Sections = append(block.Sections, section)
+		entries = entries[headerLen+sectionLen:]
+	}
+
+	return block, nil
+}
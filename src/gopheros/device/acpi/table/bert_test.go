@@ -0,0 +1,115 @@
+package table
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestDecodeBERT(t *testing.T) {
+	info, err := DecodeBERT(tableFor(bertSignature, 36, []byte{
+		// Boot error region length
+		0x00, 0x02, 0x00, 0x00,
+		// Boot error region address
+		0x00, 0x30, 0xf0, 0x7f, 0x00, 0x00, 0x00, 0x00,
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.RegionLength != 0x200 || info.RegionAddress != 0x7ff03000 {
+		t.Fatalf("expected region 0x7ff03000 with length 0x200; got 0x%x with length 0x%x", info.RegionAddress, info.RegionLength)
+	}
+
+	if _, err := DecodeBERT(tableFor(hpetSignature, bertTableLen, nil)); err != errNotBERT {
+		t.Errorf("expected to get error %v; got %v", errNotBERT, err)
+	}
+
+	if _, err := DecodeBERT(tableFor(bertSignature, bertTableLen-1, nil)); err != errBERTTruncated {
+		t.Errorf("expected to get error %v; got %v", errBERTTruncated, err)
+	}
+}
+
+func TestDecodeErrorStatusBlock(t *testing.T) {
+	section := func(sectionType [16]byte, severity ErrorSeverity, revision uint16, data []byte) []byte {
+		headerLen := errorDataHeaderLen
+		if revision >= errorDataTimestampMinRev {
+			headerLen = errorDataHeaderLenV3
+		}
+
+		entry := make([]byte, headerLen, headerLen+len(data))
+		copy(entry, sectionType[:])
+		entry[16] = byte(severity)
+		entry[20], entry[21] = byte(revision), byte(revision>>8)
+		entry[24] = byte(len(data))
+		return append(entry, data...)
+	}
+
+	statusBlock := func(blockStatus uint32, severity ErrorSeverity, sections ...[]byte) []byte {
+		var entries []byte
+		for _, s := range sections {
+			entries = append(entries, s...)
+		}
+
+		block := make([]byte, errorStatusHeaderLen)
+		for i := uint(0); i < 4; i++ {
+			block[i] = byte(blockStatus >> (8 * i))
+			block[12+i] = byte(uint32(len(entries)) >> (8 * i))
+		}
+		block[16] = byte(severity)
+		return append(block, entries...)
+	}
+
+	memErr := section(ErrorSectionMemory, ErrorSeverityCorrected, 0x201, []byte{1, 2, 3})
+	cpuErr := section(ErrorSectionProcessorX86, ErrorSeverityFatal, 0x300, []byte{4, 5})
+
+	block, err := DecodeErrorStatusBlock(statusBlock(ErrorStatusUncorrectable|ErrorStatusCorrectable|2<<errorStatusEntryCountShift, ErrorSeverityFatal, memErr, cpuErr))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if block.Severity != ErrorSeverityFatal || len(block.Sections) != 2 {
+		t.Fatalf("expected a fatal error block with 2 sections; got severity %s and %d sections", block.Severity.String(), len(block.Sections))
+	}
+
+	specs := []struct {
+		sectionType [16]byte
+		severity    ErrorSeverity
+		revision    uint16
+		data        []byte
+	}{
+		{ErrorSectionMemory, ErrorSeverityCorrected, 0x201, []byte{1, 2, 3}},
+		{ErrorSectionProcessorX86, ErrorSeverityFatal, 0x300, []byte{4, 5}},
+	}
+
+	for specIndex, spec := range specs {
+		got := block.Sections[specIndex]
+		if got.SectionType != spec.sectionType || got.Severity != spec.severity || got.Revision != spec.revision || !bytes.Equal(got.Data, spec.data) {
+			t.Errorf("[spec %d] expected section %+v; got %+v", specIndex, spec, got)
+		}
+	}
+
+	// Blocks without errors should not be parsed any further
+	if block, err := DecodeErrorStatusBlock(statusBlock(0, ErrorSeverityNone, []byte{0xff})); err != nil || len(block.Sections) != 0 {
+		t.Errorf("expected an empty block; got %+v (err: %v)", block, err)
+	}
+
+	truncated := statusBlock(ErrorStatusCorrectable|1<<errorStatusEntryCountShift, ErrorSeverityCorrected, memErr)
+	truncated[12] = 0xff
+
+	errSpecs := []struct {
+		data   []byte
+		expErr *kernel.Error
+	}{
+		{make([]byte, errorStatusHeaderLen-1), errMalformedErrorStatus},
+		{truncated, errMalformedErrorStatus},
+		{statusBlock(ErrorStatusCorrectable|1<<errorStatusEntryCountShift, ErrorSeverityCorrected, memErr[:errorDataHeaderLen-1]), errMalformedErrorSection},
+		{statusBlock(ErrorStatusCorrectable|1<<errorStatusEntryCountShift, ErrorSeverityCorrected, memErr[:len(memErr)-1]), errMalformedErrorSection},
+	}
+
+	for specIndex, spec := range errSpecs {
+		if _, err := DecodeErrorStatusBlock(spec.data); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotBGRT       = &kernel.Error{Module: "acpi_table", Message: "table is not a BGRT table", Code: kernel.ErrCodeInvalidArgument}
+	errBGRTTruncated = &kernel.Error{Module: "acpi_table", Message: "BGRT table is too short", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature and length of the BGRT table.
+const (
+	bgrtSignature = "BGRT"
+	bgrtTableLen  = 56
+)
+
+// The fields of the BGRT status byte.
+const (
+	bgrtStatusDisplayed        = uint8(1 << 0)
+	bgrtStatusOrientationShift = 1
+	bgrtStatusOrientationMask  = uint8(3 << bgrtStatusOrientationShift)
+)
+
+// BGRTImageType describes the format of the boot image referenced by the
+// BGRT table.
+type BGRTImageType uint8
+
+const (
+	// BGRTImageBitmap indicates that the boot image is stored as a BMP file.
+	BGRTImageBitmap BGRTImageType = 0
+)
+
+// BGRTOrientation describes the clockwise rotation that was applied to the
+// boot image when it was displayed by the firmware.
+type BGRTOrientation uint8
+
+// The supported boot image orientations.
+const (
+	BGRTOrientation0 BGRTOrientation = iota
+	BGRTOrientation90
+	BGRTOrientation180
+	BGRTOrientation270
+)
+
+// BGRTInfo contains the decoded contents of the Boot Graphics Resource Table
+// which describes the logo image that was displayed by the firmware during
+// boot.
+type BGRTInfo struct {
+	// The version of the table. It must be set to 1.
+	Version uint16
+
+	// Set if the boot image is currently displayed on the screen.
+	Displayed bool
+
+	// The rotation that was applied to the boot image when it was
+	// displayed.
+	Orientation BGRTOrientation
+
+	// The format of the boot image.
+	ImageType BGRTImageType
+
+	// The physical address of the boot image.
+	ImageAddress uint64
+
+	// The position of the upper-left corner of the boot image on the
+	// screen in pixels.
+	OffsetX uint32
+	OffsetY uint32
+}
+
+// DecodeBGRT decodes the BGRT table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeBGRT(header *SDTHeader) (*BGRTInfo, *kernel.Error) {
+	if string(header.Signature[:]) != bgrtSignature {
+		return nil, errNotBGRT
+	}
+
+	return decodeBGRT(tableData(header))
+}
+
+// decodeBGRT decodes the BGRT table stored in data.
+func decodeBGRT(data []byte) (*BGRTInfo, *kernel.Error) {
+	if len(data) < bgrtTableLen {
+		return nil, errBGRTTruncated
+	}
+
+	status := data[38]
+	return &BGRTInfo{
+		Version:      word(data[36:]),
+		Displayed:    status&bgrtStatusDisplayed != 0,
+		Orientation:  BGRTOrientation((status & bgrtStatusOrientationMask) >> bgrtStatusOrientationShift),
+		ImageType:    BGRTImageType(data[39]),
+		ImageAddress: qword(data[40:]),
+		OffsetX:      dword(data[48:]),
+		OffsetY:      dword(data[52:]),
+	}, nil
+}
@@ -0,0 +1,48 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeBGRT(t *testing.T) {
+	bgrt := tableFor(bgrtSignature, 36, []byte{
+		// Version 1
+		0x01, 0x00,
+		// Status: displayed, rotated by 180 degrees
+		0x05,
+		// Image type: bitmap
+		0x00,
+		// Image address
+		0x00, 0x10, 0x3c, 0x7e, 0x00, 0x00, 0x00, 0x00,
+		// Image offset X and Y
+		0x80, 0x02, 0x00, 0x00, 0x40, 0x01, 0x00, 0x00,
+	})
+
+	info, err := DecodeBGRT(bgrt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &BGRTInfo{
+		Version:      1,
+		Displayed:    true,
+		Orientation:  BGRTOrientation180,
+		ImageType:    BGRTImageBitmap,
+		ImageAddress: 0x7e3c1000,
+		OffsetX:      640,
+		OffsetY:      320,
+	}
+
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, info)
+	}
+
+	if _, err = DecodeBGRT(tableFor(hpetSignature, bgrtTableLen, nil)); err != errNotBGRT {
+		t.Errorf("expected to get error %v; got %v", errNotBGRT, err)
+	}
+
+	if _, err = DecodeBGRT(tableFor(bgrtSignature, bgrtTableLen-1, nil)); err != errBGRTTruncated {
+		t.Errorf("expected to get error %v; got %v", errBGRTTruncated, err)
+	}
+}
@@ -0,0 +1,43 @@
+package table
+
+import (
+	"reflect"
+	"unsafe"
+)
+
+// tableData returns a byte slice that overlays the contents of the table
+// described by header.
+func tableData(header *SDTHeader) []byte {
+	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Len:  int(header.Length),
+		Cap:  int(header.Length),
+		Data: uintptr(unsafe.Pointer(header)),
+	}))
+}
+
+// word returns the little-endian word stored in the first 2 bytes of buf.
+func word(buf []byte) uint16 {
+	return uint16(buf[0]) | uint16(buf[1])<<8
+}
+
+// dword returns the little-endian dword stored in the first 4 bytes of buf.
+func dword(buf []byte) uint32 {
+	return uint32(buf[0]) | uint32(buf[1])<<8 | uint32(buf[2])<<16 | uint32(buf[3])<<24
+}
+
+// qword returns the little-endian qword stored in the first 8 bytes of buf.
+func qword(buf []byte) uint64 {
+	return uint64(dword(buf)) | uint64(dword(buf[4:]))<<32
+}
+
+// genericAddress decodes the generic address structure stored in the first
+// 12 bytes of buf.
+func genericAddress(buf []byte) GenericAddress {
+	return GenericAddress{
+		Space:      AddressSpace(buf[0]),
+		BitWidth:   buf[1],
+		BitOffset:  buf[2],
+		AccessSize: buf[3],
+		Address:    qword(buf[4:]),
+	}
+}
@@ -0,0 +1,237 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotDMAR             = &kernel.Error{Module: "acpi_table", Message: "table is not a DMAR table", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedDMARRecord = &kernel.Error{Module: "acpi_table", Message: "DMAR table contains a malformed remapping structure", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the DMAR table, the length of its header and the types
+// and minimum lengths of the remapping structures that follow it.
+const (
+	dmarSignature = "DMAR"
+	dmarHeaderLen = 48
+
+	dmarTypeDRHD = 0
+	dmarTypeRMRR = 1
+	dmarTypeATSR = 2
+
+	dmarDRHDLen        = 16
+	dmarRMRRLen        = 24
+	dmarATSRLen        = 8
+	dmarDeviceScopeLen = 6
+
+	// Set by DRHD and ATSR structures that apply to all devices (or root
+	// ports) of their segment that are not listed by other structures.
+	dmarFlagIncludeAll = uint8(1 << 0)
+)
+
+// The DMAR table flags.
+const (
+	// Set if the platform supports interrupt remapping.
+	DMARFlagInterruptRemap = uint8(1 << 0)
+
+	// Set if the firmware requests that x2APIC mode is not enabled.
+	DMARFlagX2APICOptOut = uint8(1 << 1)
+
+	// Set if the firmware has configured the platform to protect its
+	// memory from DMA until the OS takes over the remapping hardware.
+	DMARFlagDMACtrlPlatformOptIn = uint8(1 << 2)
+)
+
+// DMARScopeType describes the kind of device listed by a device scope entry.
+type DMARScopeType uint8
+
+// The list of device scope types.
+const (
+	DMARScopePCIEndpoint DMARScopeType = iota + 1
+	DMARScopePCISubHierarchy
+	DMARScopeIOAPIC
+	DMARScopeHPET
+	DMARScopeACPINamespaceDevice
+)
+
+// DMARPathEntry describes a hop in the PCI path to a device.
+type DMARPathEntry struct {
+	Device   uint8
+	Function uint8
+}
+
+// DMARDeviceScope identifies a device (or a hierarchy of devices) that a
+// remapping structure applies to. The device is located by starting at
+// StartBus and following each Path entry; all entries but the last one
+// refer to PCI-PCI bridges.
+type DMARDeviceScope struct {
+	Type DMARScopeType
+
+	// The IOAPIC ID, HPET number or ACPI device number of the device for
+	// the respective scope types.
+	EnumerationID uint8
+
+	StartBus uint8
+	Path     []DMARPathEntry
+}
+
+// DMARHardwareUnit describes a DMA remapping hardware unit (DRHD).
+type DMARHardwareUnit struct {
+	// Set if the unit handles all devices in its segment that are not
+	// handled by other units. Such units do not list any PCI device scopes.
+	IncludeAll bool
+
+	// The PCI segment handled by the unit.
+	Segment uint16
+
+	// The physical address of the unit's register block.
+	RegisterBase uint64
+
+	// The devices that are handled by the unit.
+	Scopes []DMARDeviceScope
+}
+
+// DMARReservedMemory describes a reserved memory region (RMRR) that the
+// listed devices may access via DMA at any time. The region must remain
+// identity-mapped for these devices.
+type DMARReservedMemory struct {
+	Segment uint16
+
+	// The first and last byte of the region.
+	Base  uint64
+	Limit uint64
+
+	Scopes []DMARDeviceScope
+}
+
+// DMARRootPortATS describes the PCI-Express root ports that support address
+// translation services (ATSR).
+type DMARRootPortATS struct {
+	// Set if all root ports of the segment support ATS.
+	AllPorts bool
+
+	Segment uint16
+	Scopes  []DMARDeviceScope
+}
+
+// DMARInfo contains the decoded contents of the DMAR table. Remapping
+// structures of unsupported types are skipped.
+type DMARInfo struct {
+	// The maximum DMA physical addressability of the platform in bits.
+	HostAddressWidth uint8
+
+	Flags uint8
+
+	Units           []DMARHardwareUnit
+	ReservedRegions []DMARReservedMemory
+	RootPorts       []DMARRootPortATS
+}
+
+// DecodeDMAR decodes the DMAR table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeDMAR(header *SDTHeader) (*DMARInfo, *kernel.Error) {
+	if string(header.Signature[:]) != dmarSignature {
+		return nil, errNotDMAR
+	}
+
+	return decodeDMAR(tableData(header))
+}
+
+// decodeDMAR decodes the DMAR table stored in data.
+func decodeDMAR(data []byte) (*DMARInfo, *kernel.Error) {
+	if len(data) < dmarHeaderLen {
+		return nil, errMalformedDMARRecord
+	}
+
+	// The table stores the host address width minus one.
+	info := &DMARInfo{
+		HostAddressWidth: data[36] + 1,
+		Flags:            data[37],
+	}
+
+	for offset := dmarHeaderLen; offset < len(data); {
+		if offset+4 > len(data) {
+			return nil, errMalformedDMARRecord
+		}
+
+		recType, recLen := word(data[offset:]), int(word(data[offset+2:]))
+		if recLen < 4 || offset+recLen > len(data) {
+			return nil, errMalformedDMARRecord
+		}
+		rec := data[offset : offset+recLen]
+
+		var err *kernel.Error
+		switch recType {
+		case dmarTypeDRHD:
+			if recLen < dmarDRHDLen {
+				return nil, errMalformedDMARRecord
+			}
+
+			unit := DMARHardwareUnit{
+				IncludeAll:   rec[4]&dmarFlagIncludeAll != 0,
+				Segment:      word(rec[6:]),
+				RegisterBase: qword(rec[8:]),
+			}
+			unit.Scopes, err = decodeDMARScopes(rec[dmarDRHDLen:])
+			info.Units = append(info.Units, unit)
+		case dmarTypeRMRR:
+			if recLen < dmarRMRRLen {
+				return nil, errMalformedDMARRecord
+			}
+
+			region := DMARReservedMemory{
+				Segment: word(rec[6:]),
+				Base:    qword(rec[8:]),
+				Limit:   qword(rec[16:]),
+			}
+			region.Scopes, err = decodeDMARScopes(rec[dmarRMRRLen:])
+			info.ReservedRegions = append(info.ReservedRegions, region)
+		case dmarTypeATSR:
+			if recLen < dmarATSRLen {
+				return nil, errMalformedDMARRecord
+			}
+
+			ports := DMARRootPortATS{
+				AllPorts: rec[4]&dmarFlagIncludeAll != 0,
+				Segment:  word(rec[6:]),
+			}
+			ports.Scopes, err = decodeDMARScopes(rec[dmarATSRLen:])
+			info.RootPorts = append(info.RootPorts, ports)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		offset += recLen
+	}
+
+	return info, nil
+}
+
+// decodeDMARScopes decodes the list of device scope entries stored in data.
+func decodeDMARScopes(data []byte) ([]DMARDeviceScope, *kernel.Error) {
+	var scopes []DMARDeviceScope
+	for offset := 0; offset < len(data); {
+		if offset+2 > len(data) {
+			return nil, errMalformedDMARRecord
+		}
+
+		scopeLen := int(data[offset+1])
+		if scopeLen < dmarDeviceScopeLen || offset+scopeLen > len(data) || (scopeLen-dmarDeviceScopeLen)&1 != 0 {
+			return nil, errMalformedDMARRecord
+		}
+
+		scope := DMARDeviceScope{
+			Type:          DMARScopeType(data[offset]),
+			EnumerationID: data[offset+4],
+			StartBus:      data[offset+5],
+		}
+		for pathOffset := offset + dmarDeviceScopeLen; pathOffset < offset+scopeLen; pathOffset += 2 {
+			scope.Path = append(scope.Path, DMARPathEntry{Device: data[pathOffset], Function: data[pathOffset+1]})
+		}
+
+		scopes = append(scopes, scope)
+		offset += scopeLen
+	}
+
+	return scopes, nil
+}
@@ -0,0 +1,115 @@
+package table
+
+import (
+	"gopheros/kernel"
+	"reflect"
+	"testing"
+)
+
+func TestDecodeDMAR(t *testing.T) {
+	header := tableFor(dmarSignature, dmarHeaderLen, concat(
+		// DRHD: segment 0, registers at 0xfed90000, handles 00:02.0
+		[]byte{
+			0x00, 0x00, 0x18, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0xd9, 0xfe, 0x00, 0x00, 0x00, 0x00,
+			0x01, 0x08, 0x00, 0x00, 0x00, 0x00, 0x02, 0x00,
+		},
+		// DRHD: include all, segment 0, registers at 0xfed91000, IOAPIC 2
+		[]byte{
+			0x00, 0x00, 0x18, 0x00, 0x01, 0x00, 0x00, 0x00,
+			0x00, 0x10, 0xd9, 0xfe, 0x00, 0x00, 0x00, 0x00,
+			0x03, 0x08, 0x00, 0x00, 0x02, 0xf0, 0x1f, 0x00,
+		},
+		// RMRR: [0x3e000000, 0x3e7fffff] for 00:14.0 behind bridge 00:1c.0
+		[]byte{
+			0x01, 0x00, 0x22, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x3e, 0x00, 0x00, 0x00, 0x00,
+			0xff, 0xff, 0x7f, 0x3e, 0x00, 0x00, 0x00, 0x00,
+			0x01, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x1c, 0x00, 0x00, 0x00,
+		},
+		// ATSR: all ports of segment 1
+		[]byte{0x02, 0x00, 0x08, 0x00, 0x01, 0x00, 0x01, 0x00},
+		// RHSA (unsupported; skipped)
+		[]byte{
+			0x03, 0x00, 0x14, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0xd9, 0xfe, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00,
+		},
+	))
+	data := tableData(header)
+	data[36], data[37] = 38, DMARFlagInterruptRemap|DMARFlagX2APICOptOut
+
+	info, err := DecodeDMAR(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &DMARInfo{
+		HostAddressWidth: 39,
+		Flags:            DMARFlagInterruptRemap | DMARFlagX2APICOptOut,
+		Units: []DMARHardwareUnit{
+			{
+				RegisterBase: 0xfed90000,
+				Scopes: []DMARDeviceScope{
+					{Type: DMARScopePCIEndpoint, Path: []DMARPathEntry{{Device: 2, Function: 0}}},
+				},
+			},
+			{
+				IncludeAll:   true,
+				RegisterBase: 0xfed91000,
+				Scopes: []DMARDeviceScope{
+					{Type: DMARScopeIOAPIC, EnumerationID: 2, StartBus: 0xf0, Path: []DMARPathEntry{{Device: 0x1f, Function: 0}}},
+				},
+			},
+		},
+		ReservedRegions: []DMARReservedMemory{
+			{
+				Base:  0x3e000000,
+				Limit: 0x3e7fffff,
+				Scopes: []DMARDeviceScope{
+					{Type: DMARScopePCIEndpoint, Path: []DMARPathEntry{{Device: 0x1c, Function: 0}, {Device: 0, Function: 0}}},
+				},
+			},
+		},
+		RootPorts: []DMARRootPortATS{
+			{AllPorts: true, Segment: 1},
+		},
+	}
+
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, info)
+	}
+}
+
+func TestDecodeDMARErrors(t *testing.T) {
+	specs := []struct {
+		header *SDTHeader
+		expErr *kernel.Error
+	}{
+		{tableFor(madtSignature, dmarHeaderLen, nil), errNotDMAR},
+		// Truncated header
+		{tableFor(dmarSignature, dmarHeaderLen-4, nil), errMalformedDMARRecord},
+		// Truncated record header
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x00, 0x00}), errMalformedDMARRecord},
+		// Record length exceeds table length
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x00, 0x00, 0x10, 0x00}), errMalformedDMARRecord},
+		// Zero-length record
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x05, 0x00, 0x00, 0x00}), errMalformedDMARRecord},
+		// Record length too short for its type
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x00, 0x00, 0x04, 0x00}), errMalformedDMARRecord},
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x01, 0x00, 0x04, 0x00}), errMalformedDMARRecord},
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x02, 0x00, 0x04, 0x00}), errMalformedDMARRecord},
+		// Device scope length exceeds record length
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x02, 0x00, 0x0e, 0x00, 0, 0, 0, 0, 0x01, 0x08, 0, 0, 0, 0}), errMalformedDMARRecord},
+		// Device scope with an odd path length
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x02, 0x00, 0x0f, 0x00, 0, 0, 0, 0, 0x01, 0x07, 0, 0, 0, 0, 0}), errMalformedDMARRecord},
+		// Truncated device scope header
+		{tableFor(dmarSignature, dmarHeaderLen, []byte{0x02, 0x00, 0x09, 0x00, 0, 0, 0, 0, 0x01}), errMalformedDMARRecord},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := DecodeDMAR(spec.header); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotERST       = &kernel.Error{Module: "acpi_table", Message: "table is not an ERST table", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedERST = &kernel.Error{Module: "acpi_table", Message: "ERST table contains a malformed instruction entry", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the ERST table, the length of its header and the length of
+// each serialization instruction entry that follows it.
+const (
+	erstSignature = "ERST"
+	erstHeaderLen = 48
+	erstEntryLen  = 32
+
+	// The highest instruction value defined by the ACPI specification.
+	erstMaxInstruction = 0x12
+)
+
+// ERSTAction identifies the error record serialization operation that is
+// implemented by a sequence of ERST instruction entries.
+type ERSTAction uint8
+
+// The list of serialization actions defined by the ACPI specification.
+const (
+	ERSTActionBeginWrite                  ERSTAction = 0x00
+	ERSTActionBeginRead                   ERSTAction = 0x01
+	ERSTActionBeginClear                  ERSTAction = 0x02
+	ERSTActionEnd                         ERSTAction = 0x03
+	ERSTActionSetRecordOffset             ERSTAction = 0x04
+	ERSTActionExecuteOperation            ERSTAction = 0x05
+	ERSTActionCheckBusyStatus             ERSTAction = 0x06
+	ERSTActionGetCommandStatus            ERSTAction = 0x07
+	ERSTActionGetRecordIdentifier         ERSTAction = 0x08
+	ERSTActionSetRecordIdentifier         ERSTAction = 0x09
+	ERSTActionGetRecordCount              ERSTAction = 0x0a
+	ERSTActionBeginDummyWrite             ERSTAction = 0x0b
+	ERSTActionGetErrorLogAddressRange     ERSTAction = 0x0d
+	ERSTActionGetErrorLogAddressRangeLen  ERSTAction = 0x0e
+	ERSTActionGetErrorLogAddressRangeAttr ERSTAction = 0x0f
+	ERSTActionGetExecuteOperationTimings  ERSTAction = 0x10
+)
+
+// ERSTEntry is a single step of an error record serialization action.
+type ERSTEntry struct {
+	Action ERSTAction
+
+	// The instruction (e.g. read/write register, stall, move data) and
+	// its flags as defined by the ACPI specification.
+	Instruction uint8
+	Flags       uint8
+
+	// The register accessed by the instruction.
+	Register GenericAddress
+
+	// The value used by the instruction and the mask that selects the
+	// register bits affected by it.
+	Value uint64
+	Mask  uint64
+}
+
+// ERSTInfo contains the decoded contents of the Error Record Serialization
+// Table which describes how error records can be stored to and retrieved
+// from persistent storage.
+type ERSTInfo struct {
+	// The length of the serialization header that precedes each stored
+	// error record.
+	SerializationHeaderSize uint32
+
+	// The instruction entries in table order.
+	Entries []ERSTEntry
+}
+
+// DecodeERST decodes the ERST table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeERST(header *SDTHeader) (*ERSTInfo, *kernel.Error) {
+	if string(header.Signature[:]) != erstSignature {
+		return nil, errNotERST
+	}
+
+	return decodeERST(tableData(header))
+}
+
+// decodeERST decodes the ERST table stored in data.
+func decodeERST(data []byte) (*ERSTInfo, *kernel.Error) {
+	if len(data) < erstHeaderLen {
+		return nil, errMalformedERST
+	}
+
+	info := &ERSTInfo{
+		SerializationHeaderSize: dword(data[36:]),
+	}
+
+	numEntries := int(dword(data[44:]))
+	if numEntries > (len(data)-erstHeaderLen)/erstEntryLen {
+		return nil, errMalformedERST
+	}
+
+	for index, offset := 0, erstHeaderLen; index < numEntries; index, offset = index+1, offset+erstEntryLen {
+		entry := ERSTEntry{
+			Action:      ERSTAction(data[offset]),
+			Instruction: data[offset+1],
+			Flags:       data[offset+2],
+			Register:    genericAddress(data[offset+4:]),
+			Value:       qword(data[offset+16:]),
+			Mask:        qword(data[offset+24:]),
+		}
+
+		if entry.Instruction > erstMaxInstruction {
+			return nil, errMalformedERST
+		}
+
+		info.Entries = append(info.Entries, entry)
+	}
+
+	return info, nil
+}
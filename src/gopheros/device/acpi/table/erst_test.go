@@ -0,0 +1,78 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeERST(t *testing.T) {
+	erstFor := func(numEntries uint32, entries ...[]byte) *SDTHeader {
+		var records []byte
+		for _, entry := range entries {
+			records = append(records, entry...)
+		}
+
+		header := tableFor(erstSignature, erstHeaderLen, records)
+		data := tableData(header)
+		data[36] = 48
+		for i := uint(0); i < 4; i++ {
+			data[44+i] = byte(numEntries >> (8 * i))
+		}
+		return header
+	}
+
+	erstEntry := func(action ERSTAction, instr uint8, value, mask uint64) []byte {
+		entry := []byte{
+			byte(action), instr, 0x01, 0x00,
+			// Register: SystemMemory, 64-bit, 0x7f002000
+			0x00, 0x40, 0x00, 0x04, 0x00, 0x20, 0x00, 0x7f, 0x00, 0x00, 0x00, 0x00,
+		}
+		for _, v := range []uint64{value, mask} {
+			for i := uint(0); i < 8; i++ {
+				entry = append(entry, byte(v>>(8*i)))
+			}
+		}
+		return entry
+	}
+
+	reg := GenericAddress{Space: AddressSpaceSysMemory, BitWidth: 64, AccessSize: 4, Address: 0x7f002000}
+
+	info, err := DecodeERST(erstFor(2,
+		erstEntry(ERSTActionBeginWrite, 0x03, 0x01, 0xff),
+		erstEntry(ERSTActionGetRecordCount, 0x00, 0, 0xffffffff),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &ERSTInfo{
+		SerializationHeaderSize: 48,
+		Entries: []ERSTEntry{
+			{Action: ERSTActionBeginWrite, Instruction: 0x03, Flags: 0x01, Register: reg, Value: 0x01, Mask: 0xff},
+			{Action: ERSTActionGetRecordCount, Instruction: 0x00, Flags: 0x01, Register: reg, Mask: 0xffffffff},
+		},
+	}
+
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, info)
+	}
+
+	specs := []*SDTHeader{
+		// Entry count exceeds the table length
+		erstFor(2, erstEntry(ERSTActionEnd, 0x03, 0, 0)),
+		// Unknown instruction
+		erstFor(1, erstEntry(ERSTActionEnd, erstMaxInstruction+1, 0, 0)),
+		// Truncated header
+		tableFor(erstSignature, erstHeaderLen-1, nil),
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := DecodeERST(spec); err != errMalformedERST {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, errMalformedERST, err)
+		}
+	}
+
+	if _, err := DecodeERST(tableFor(hpetSignature, erstHeaderLen, nil)); err != errNotERST {
+		t.Errorf("expected to get error %v; got %v", errNotERST, err)
+	}
+}
@@ -0,0 +1,208 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotFADT       = &kernel.Error{Module: "acpi_table", Message: "table is not a FADT", Code: kernel.ErrCodeInvalidArgument}
+	errFADTTruncated = &kernel.Error{Module: "acpi_table", Message: "FADT is too short", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the FADT and the offsets of the fields that are decoded
+// by DecodeFADT. The Go FADT struct cannot be overlaid on top of the table
+// contents as the table contains unaligned fields.
+const (
+	fadtSignature = "FACP"
+
+	fadtFirmwareCtrlOffset   = 36
+	fadtDSDTOffset           = 40
+	fadtPMProfileOffset      = 45
+	fadtSCIInterruptOffset   = 46
+	fadtSMICommandOffset     = 48
+	fadtACPIEnableOffset     = 52
+	fadtACPIDisableOffset    = 53
+	fadtPM1aEventOffset      = 56
+	fadtPM1EventLenOffset    = 88
+	fadtPM1ControlLenOffset  = 89
+	fadtPM2ControlLenOffset  = 90
+	fadtPMTimerLenOffset     = 91
+	fadtGPE0LenOffset        = 92
+	fadtGPE1LenOffset        = 93
+	fadtGPE1BaseOffset       = 94
+	fadtBootArchOffset       = 109
+	fadtFlagsOffset          = 112
+	fadtResetRegOffset       = 116
+	fadtResetValueOffset     = 128
+	fadtXFirmwareCtrlOffset  = 132
+	fadtXDSDTOffset          = 140
+	fadtXPM1aEventOffset     = 148
+	fadtGenericAddressLength = 12
+
+	// The length of the ACPI 1.0 FADT which ends right after the flags
+	// and the length of the ACPI 2.0 FADT which includes all extended
+	// fields.
+	fadtMinLen        = fadtResetRegOffset
+	fadtTableLenACPI2 = fadtXPM1aEventOffset + 8*fadtGenericAddressLength
+)
+
+// RegisterBlock describes the location and length of a fixed hardware
+// register block.
+type RegisterBlock struct {
+	Address GenericAddress
+
+	// The length of the block in bytes.
+	Length uint8
+}
+
+// Present returns true if the platform implements the register block.
+func (b RegisterBlock) Present() bool {
+	return b.Address.Address != 0 && b.Length != 0
+}
+
+// FADTInfo contains the decoded contents of the FADT. For fields that are
+// defined both as a legacy 32-bit value and as a 64-bit ACPI 2.0+ extension
+// (X_ field), the extension is used if it is present and non-zero.
+//
+// Register blocks that are only defined by a legacy field reside in the
+// SystemIO address space. Blocks that are not implemented by the platform
+// have a zero address and length.
+type FADTInfo struct {
+	// The revision of the table.
+	Revision uint8
+
+	// The physical addresses of the FACS and the DSDT.
+	FirmwareControl uint64
+	DSDT            uint64
+
+	// The preferred power management profile of the platform.
+	PreferredPowerProfile PowerProfileType
+
+	// The interrupt that is wired to the SCI.
+	SCIInterrupt uint16
+
+	// The SMI command port and the values written to it for transferring
+	// ownership of the ACPI hardware registers to and from the OS.
+	SMICommandPort uint32
+	ACPIEnable     uint8
+	ACPIDisable    uint8
+
+	// The fixed hardware register blocks.
+	PM1aEventBlock   RegisterBlock
+	PM1bEventBlock   RegisterBlock
+	PM1aControlBlock RegisterBlock
+	PM1bControlBlock RegisterBlock
+	PM2ControlBlock  RegisterBlock
+	PMTimerBlock     RegisterBlock
+	GPE0Block        RegisterBlock
+	GPE1Block        RegisterBlock
+
+	// The GPE number that corresponds to the first GPE1 block bit.
+	GPE1Base uint8
+
+	// The IA-PC boot architecture flags and the fixed feature flags.
+	BootArchitectureFlags uint16
+	Flags                 uint32
+
+	// The register and value used for resetting the system. The reset
+	// register is only defined by ACPI 2.0+ tables.
+	ResetRegister GenericAddress
+	ResetValue    uint8
+}
+
+// DecodeFADT decodes the FADT described by header. The caller must ensure
+// that the entire table contents are mapped.
+func DecodeFADT(header *SDTHeader) (*FADTInfo, *kernel.Error) {
+	if string(header.Signature[:]) != fadtSignature {
+		return nil, errNotFADT
+	}
+
+	return decodeFADT(tableData(header))
+}
+
+// decodeFADT decodes the FADT stored in data.
+func decodeFADT(data []byte) (*FADTInfo, *kernel.Error) {
+	if len(data) < fadtMinLen {
+		return nil, errFADTTruncated
+	}
+
+	info := &FADTInfo{
+		Revision:              data[8],
+		FirmwareControl:       uint64(dword(data[fadtFirmwareCtrlOffset:])),
+		DSDT:                  uint64(dword(data[fadtDSDTOffset:])),
+		PreferredPowerProfile: PowerProfileType(data[fadtPMProfileOffset]),
+		SCIInterrupt:          word(data[fadtSCIInterruptOffset:]),
+		SMICommandPort:        dword(data[fadtSMICommandOffset:]),
+		ACPIEnable:            data[fadtACPIEnableOffset],
+		ACPIDisable:           data[fadtACPIDisableOffset],
+		GPE1Base:              data[fadtGPE1BaseOffset],
+		BootArchitectureFlags: word(data[fadtBootArchOffset:]),
+		Flags:                 dword(data[fadtFlagsOffset:]),
+	}
+
+	if len(data) > fadtResetValueOffset {
+		info.ResetRegister = genericAddress(data[fadtResetRegOffset:])
+		info.ResetValue = data[fadtResetValueOffset]
+	}
+
+	info.FirmwareControl = xField(data, fadtXFirmwareCtrlOffset, info.FirmwareControl)
+	info.DSDT = xField(data, fadtXDSDTOffset, info.DSDT)
+
+	// The legacy block fields are laid out in the same order as their
+	// extended counterparts. PM1a/PM1b event blocks and control blocks
+	// share a single length field.
+	for blockIndex, spec := range []struct {
+		block  *RegisterBlock
+		lenOff int
+	}{
+		{&info.PM1aEventBlock, fadtPM1EventLenOffset},
+		{&info.PM1bEventBlock, fadtPM1EventLenOffset},
+		{&info.PM1aControlBlock, fadtPM1ControlLenOffset},
+		{&info.PM1bControlBlock, fadtPM1ControlLenOffset},
+		{&info.PM2ControlBlock, fadtPM2ControlLenOffset},
+		{&info.PMTimerBlock, fadtPMTimerLenOffset},
+		{&info.GPE0Block, fadtGPE0LenOffset},
+		{&info.GPE1Block, fadtGPE1LenOffset},
+	} {
+		spec.block.Address = GenericAddress{
+			Space:   AddressSpaceSysIO,
+			Address: uint64(dword(data[fadtPM1aEventOffset+4*blockIndex:])),
+		}
+		spec.block.Length = data[spec.lenOff]
+
+		xOffset := fadtXPM1aEventOffset + fadtGenericAddressLength*blockIndex
+		if len(data) < xOffset+fadtGenericAddressLength {
+			continue
+		}
+
+		if xBlock := genericAddress(data[xOffset:]); xBlock.Address != 0 {
+			spec.block.Address = xBlock
+
+			// The legacy length fields are also valid for the
+			// extended blocks; only fall back to the bit width of
+			// the extended block if they are not populated.
+			if spec.block.Length == 0 {
+				spec.block.Length = xBlock.BitWidth >> 3
+			}
+		}
+
+		if spec.block.Address.Address == 0 {
+			*spec.block = RegisterBlock{}
+		}
+	}
+
+	return info, nil
+}
+
+// xField returns the 64-bit value stored at the specified offset of data if
+// the table is long enough to contain it and the value is non-zero.
+// Otherwise, it returns the value of the legacy field.
+func xField(data []byte, offset int, legacy uint64) uint64 {
+	if len(data) < offset+8 {
+		return legacy
+	}
+
+	if val := qword(data[offset:]); val != 0 {
+		return val
+	}
+
+	return legacy
+}
@@ -0,0 +1,142 @@
+package table
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestDecodeFADT(t *testing.T) {
+	_, f, _, _ := runtime.Caller(0)
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(f), "tabletest", "FACP.aml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, decErr := DecodeFADT((*SDTHeader)(unsafe.Pointer(&data[0])))
+	if decErr != nil {
+		t.Fatal(decErr)
+	}
+
+	exp := &FADTInfo{
+		Revision:              4,
+		FirmwareControl:       0x3fff0200,
+		DSDT:                  0x3fff0470,
+		SCIInterrupt:          9,
+		SMICommandPort:        0x442e,
+		ACPIEnable:            0xa1,
+		ACPIDisable:           0xa0,
+		PM1aEventBlock:        RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, BitWidth: 32, AccessSize: 2, Address: 0x4000}, Length: 4},
+		PM1aControlBlock:      RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, BitWidth: 16, AccessSize: 2, Address: 0x4004}, Length: 2},
+		PMTimerBlock:          RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, BitWidth: 32, AccessSize: 3, Address: 0x4008}, Length: 4},
+		GPE0Block:             RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, BitWidth: 16, AccessSize: 1, Address: 0x4020}, Length: 2},
+		BootArchitectureFlags: 3,
+		Flags:                 0x541,
+		ResetRegister:         GenericAddress{Space: AddressSpaceSysIO, BitWidth: 8, AccessSize: 1, Address: 0x4050},
+		ResetValue:            0x10,
+	}
+
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, info)
+	}
+
+	for _, block := range []RegisterBlock{info.PM1bEventBlock, info.PM1bControlBlock, info.PM2ControlBlock, info.GPE1Block} {
+		if block.Present() {
+			t.Errorf("expected block %+v not to be present", block)
+		}
+	}
+
+	data[0] = 'X'
+	if _, decErr = DecodeFADT((*SDTHeader)(unsafe.Pointer(&data[0]))); decErr != errNotFADT {
+		t.Fatalf("expected to get error %v; got %v", errNotFADT, decErr)
+	}
+}
+
+func TestDecodeFADTFields(t *testing.T) {
+	// legacyFADT returns an ACPI 1.0 FADT with a PM1a event block at port
+	// 0x400 and a GPE0 block at port 0x420.
+	legacyFADT := func(extraLen int) []byte {
+		data := make([]byte, fadtMinLen+extraLen)
+		copy(data, fadtSignature)
+		data[fadtDSDTOffset] = 0x70
+		data[fadtPM1aEventOffset], data[fadtPM1aEventOffset+1] = 0x00, 0x04
+		data[fadtPM1aEventOffset+24], data[fadtPM1aEventOffset+25] = 0x20, 0x04
+		data[fadtPM1EventLenOffset] = 4
+		data[fadtGPE0LenOffset] = 0x20
+		return data
+	}
+
+	specs := []struct {
+		data     []byte
+		expPM1a  RegisterBlock
+		expGPE0  RegisterBlock
+		expDSDT  uint64
+		expReset GenericAddress
+	}{
+		// ACPI 1.0 table; no reset register or extended fields
+		{
+			legacyFADT(0),
+			RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, Address: 0x400}, Length: 4},
+			RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, Address: 0x420}, Length: 0x20},
+			0x70,
+			GenericAddress{},
+		},
+		// Zeroed extended fields fall back to the legacy fields
+		{
+			legacyFADT(fadtTableLenACPI2 - fadtMinLen),
+			RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, Address: 0x400}, Length: 4},
+			RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, Address: 0x420}, Length: 0x20},
+			0x70,
+			GenericAddress{},
+		},
+		// Extended fields take precedence; the extended PM1a block has
+		// no bit width so the legacy length is used. The legacy GPE0
+		// block has no length so the extended bit width is used.
+		{
+			func() []byte {
+				data := legacyFADT(fadtTableLenACPI2 - fadtMinLen)
+				data[fadtGPE0LenOffset] = 0
+				data[fadtXDSDTOffset+4] = 0x01
+				copy(data[fadtResetRegOffset:], []byte{0x00, 0x08, 0x00, 0x01, 0x00, 0x10})
+				copy(data[fadtXPM1aEventOffset:], []byte{0x00, 0x00, 0x00, 0x03, 0x00, 0x00, 0x00, 0xfe})
+				copy(data[fadtXPM1aEventOffset+6*fadtGenericAddressLength:], []byte{0x01, 0x10, 0x00, 0x01, 0x40, 0x04})
+				return data
+			}(),
+			RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysMemory, AccessSize: 3, Address: 0xfe000000}, Length: 4},
+			RegisterBlock{Address: GenericAddress{Space: AddressSpaceSysIO, BitWidth: 16, AccessSize: 1, Address: 0x440}, Length: 2},
+			0x100000000,
+			GenericAddress{Space: AddressSpaceSysMemory, BitWidth: 8, AccessSize: 1, Address: 0x1000},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		info, err := decodeFADT(spec.data)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if info.PM1aEventBlock != spec.expPM1a {
+			t.Errorf("[spec %d] expected PM1a event block to be %+v; got %+v", specIndex, spec.expPM1a, info.PM1aEventBlock)
+		}
+
+		if info.GPE0Block != spec.expGPE0 {
+			t.Errorf("[spec %d] expected GPE0 block to be %+v; got %+v", specIndex, spec.expGPE0, info.GPE0Block)
+		}
+
+		if info.DSDT != spec.expDSDT {
+			t.Errorf("[spec %d] expected DSDT address to be 0x%x; got 0x%x", specIndex, spec.expDSDT, info.DSDT)
+		}
+
+		if info.ResetRegister != spec.expReset {
+			t.Errorf("[spec %d] expected reset register to be %+v; got %+v", specIndex, spec.expReset, info.ResetRegister)
+		}
+	}
+
+	if _, err := decodeFADT(make([]byte, fadtMinLen-1)); err != errFADTTruncated {
+		t.Errorf("expected to get errFADTTruncated; got %v", err)
+	}
+}
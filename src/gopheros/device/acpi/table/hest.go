@@ -0,0 +1,199 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotHEST       = &kernel.Error{Module: "acpi_table", Message: "table is not a HEST table", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedHEST = &kernel.Error{Module: "acpi_table", Message: "HEST table contains a malformed error source structure", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the HEST table, the length of its header and the length of
+// each machine check bank structure that follows an IA-32 machine check error
+// source.
+const (
+	hestSignature = "HEST"
+	hestHeaderLen = 40
+	hestBankLen   = 28
+)
+
+// HESTSourceType describes the type of a hardware error source.
+type HESTSourceType uint16
+
+// The list of error source types defined by the ACPI specification that are
+// relevant to x86 systems.
+const (
+	HESTSourceIA32MCE        HESTSourceType = 0
+	HESTSourceIA32CMC        HESTSourceType = 1
+	HESTSourceIA32NMI        HESTSourceType = 2
+	HESTSourcePCIeRootAER    HESTSourceType = 6
+	HESTSourcePCIeDeviceAER  HESTSourceType = 7
+	HESTSourcePCIeBridgeAER  HESTSourceType = 8
+	HESTSourceGHES           HESTSourceType = 9
+	HESTSourceGHESv2         HESTSourceType = 10
+	HESTSourceIA32DeferredMC HESTSourceType = 11
+)
+
+// HESTNotifyType describes how the OS is notified about errors reported by an
+// error source.
+type HESTNotifyType uint8
+
+// The list of supported notification types.
+const (
+	HESTNotifyPolled            HESTNotifyType = 0
+	HESTNotifyExternalInterrupt HESTNotifyType = 1
+	HESTNotifyLocalInterrupt    HESTNotifyType = 2
+	HESTNotifySCI               HESTNotifyType = 3
+	HESTNotifyNMI               HESTNotifyType = 4
+	HESTNotifyCMCI              HESTNotifyType = 5
+	HESTNotifyMCE               HESTNotifyType = 6
+	HESTNotifyGPIO              HESTNotifyType = 7
+	HESTNotifySEA               HESTNotifyType = 8
+	HESTNotifySEI               HESTNotifyType = 9
+	HESTNotifyGSIV              HESTNotifyType = 10
+	HESTNotifySoftwareDelegated HESTNotifyType = 11
+)
+
+// HESTNotification describes how an error source notifies the OS.
+type HESTNotification struct {
+	Type HESTNotifyType
+
+	// The interval in milliseconds at which polled error sources should
+	// be checked.
+	PollInterval uint32
+
+	// The interrupt vector used by interrupt-based notifications.
+	Vector uint32
+}
+
+// HESTErrorSource describes a hardware error source. Fields that are not
+// defined for a particular source type are left zeroed.
+type HESTErrorSource struct {
+	Type     HESTSourceType
+	SourceID uint16
+	Enabled  bool
+
+	// The number of machine check banks of IA-32 machine check sources.
+	NumBanks uint8
+
+	// The notification method of IA-32 corrected/deferred machine check
+	// sources and generic hardware error sources.
+	Notification HESTNotification
+
+	// The following fields are only defined for generic hardware error
+	// sources. The error status address points to a register that holds
+	// the physical address of the error status block.
+	RelatedSourceID        uint16
+	ErrorStatusAddress     GenericAddress
+	ErrorStatusBlockLength uint32
+
+	// The following fields are only defined for version 2 generic
+	// hardware error sources. Once the OS has consumed an error status
+	// block, it acknowledges it by writing to the read ack register.
+	ReadAckRegister GenericAddress
+	ReadAckPreserve uint64
+	ReadAckWrite    uint64
+}
+
+// DecodeHEST decodes the error source structures of the HEST table described
+// by header. The caller must ensure that the entire table contents are
+// mapped.
+func DecodeHEST(header *SDTHeader) ([]HESTErrorSource, *kernel.Error) {
+	if string(header.Signature[:]) != hestSignature {
+		return nil, errNotHEST
+	}
+
+	return decodeHEST(tableData(header))
+}
+
+// decodeHEST decodes the HEST table stored in data.
+func decodeHEST(data []byte) ([]HESTErrorSource, *kernel.Error) {
+	if len(data) < hestHeaderLen {
+		return nil, errMalformedHEST
+	}
+
+	var (
+		sources []HESTErrorSource
+		count   = dword(data[36:])
+		offset  = hestHeaderLen
+	)
+
+	for ; count > 0; count-- {
+		if len(data)-offset < 4 {
+			return nil, errMalformedHEST
+		}
+
+		src := HESTErrorSource{
+			Type:     HESTSourceType(word(data[offset:])),
+			SourceID: word(data[offset+2:]),
+		}
+
+		var srcLen int
+		switch src.Type {
+		case HESTSourceIA32MCE:
+			srcLen = 40
+			if offset+srcLen <= len(data) {
+				src.NumBanks = data[offset+32]
+			}
+			srcLen += int(src.NumBanks) * hestBankLen
+		case HESTSourceIA32CMC, HESTSourceIA32DeferredMC:
+			srcLen = 48
+			if offset+srcLen <= len(data) {
+				src.NumBanks = data[offset+44]
+			}
+			srcLen += int(src.NumBanks) * hestBankLen
+		case HESTSourceIA32NMI:
+			srcLen = 20
+		case HESTSourcePCIeRootAER:
+			srcLen = 48
+		case HESTSourcePCIeDeviceAER:
+			srcLen = 44
+		case HESTSourcePCIeBridgeAER:
+			srcLen = 56
+		case HESTSourceGHES:
+			srcLen = 64
+		case HESTSourceGHESv2:
+			srcLen = 92
+		default:
+			return nil, errMalformedHEST
+		}
+
+		if offset+srcLen > len(data) {
+			return nil, errMalformedHEST
+		}
+		rec := data[offset : offset+srcLen]
+
+		// IA-32 NMI sources do not define an enabled flag
+		src.Enabled = src.Type == HESTSourceIA32NMI || rec[7] != 0
+
+		switch src.Type {
+		case HESTSourceIA32CMC, HESTSourceIA32DeferredMC:
+			src.Notification = hestNotification(rec[16:])
+		case HESTSourceGHES, HESTSourceGHESv2:
+			src.RelatedSourceID = word(rec[4:])
+			src.ErrorStatusAddress = genericAddress(rec[20:])
+			src.Notification = hestNotification(rec[32:])
+			src.ErrorStatusBlockLength = dword(rec[60:])
+
+			if src.Type == HESTSourceGHESv2 {
+				src.ReadAckRegister = genericAddress(rec[64:])
+				src.ReadAckPreserve = qword(rec[76:])
+				src.ReadAckWrite = qword(rec[84:])
+			}
+		}
+
+		sources = append(sources, src)
+		offset += srcLen
+	}
+
+	return sources, nil
+}
+
+// hestNotification decodes the hardware error notification structure stored
+// in the first 28 bytes of buf.
+func hestNotification(buf []byte) HESTNotification {
+	return HESTNotification{
+		Type:         HESTNotifyType(buf[0]),
+		PollInterval: dword(buf[4:]),
+		Vector:       dword(buf[8:]),
+	}
+}
@@ -0,0 +1,109 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeHEST(t *testing.T) {
+	hestFor := func(count uint32, records ...[]byte) *SDTHeader {
+		var data []byte
+		for _, rec := range records {
+			data = append(data, rec...)
+		}
+
+		header := tableFor(hestSignature, hestHeaderLen, data)
+		for i := uint(0); i < 4; i++ {
+			tableData(header)[36+i] = byte(count >> (8 * i))
+		}
+		return header
+	}
+
+	// errorSource returns an error source structure of the specified type
+	// and length with the enabled flag set.
+	errorSource := func(srcType HESTSourceType, srcID uint16, length int) []byte {
+		rec := make([]byte, length)
+		rec[0], rec[1] = byte(srcType), byte(srcType>>8)
+		rec[2], rec[3] = byte(srcID), byte(srcID>>8)
+		rec[7] = 1
+		return rec
+	}
+
+	mce := errorSource(HESTSourceIA32MCE, 0, 40+2*hestBankLen)
+	mce[32] = 2
+
+	cmc := errorSource(HESTSourceIA32CMC, 1, 48)
+	cmc[7] = 0
+	cmc[16], cmc[20] = byte(HESTNotifyCMCI), 0x0a
+
+	nmi := errorSource(HESTSourceIA32NMI, 2, 20)
+	nmi[7] = 0
+
+	ghes := errorSource(HESTSourceGHES, 3, 64)
+	ghes[4] = 0xff
+	ghes[5] = 0xff
+	copy(ghes[20:], []byte{0x00, 0x40, 0x00, 0x04, 0x00, 0x10, 0x00, 0x7f, 0x00, 0x00, 0x00, 0x00})
+	ghes[32], ghes[36], ghes[37] = byte(HESTNotifyPolled), 0xe8, 0x03
+	ghes[60], ghes[61] = 0x00, 0x10
+
+	ghesV2 := errorSource(HESTSourceGHESv2, 4, 92)
+	ghesV2[32] = byte(HESTNotifyNMI)
+	copy(ghesV2[64:], []byte{0x00, 0x40, 0x00, 0x04, 0x08, 0x10, 0x00, 0x7f, 0x00, 0x00, 0x00, 0x00})
+	ghesV2[76], ghesV2[84] = 0xfe, 0x01
+
+	aer := errorSource(HESTSourcePCIeRootAER, 5, 48)
+
+	sources, err := DecodeHEST(hestFor(6, mce, cmc, nmi, ghes, ghesV2, aer))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	statusAddr := GenericAddress{Space: AddressSpaceSysMemory, BitWidth: 64, AccessSize: 4, Address: 0x7f001000}
+	exp := []HESTErrorSource{
+		{Type: HESTSourceIA32MCE, SourceID: 0, Enabled: true, NumBanks: 2},
+		{Type: HESTSourceIA32CMC, SourceID: 1, Notification: HESTNotification{Type: HESTNotifyCMCI, PollInterval: 10}},
+		{Type: HESTSourceIA32NMI, SourceID: 2, Enabled: true},
+		{
+			Type: HESTSourceGHES, SourceID: 3, Enabled: true, RelatedSourceID: 0xffff,
+			ErrorStatusAddress:     statusAddr,
+			Notification:           HESTNotification{Type: HESTNotifyPolled, PollInterval: 1000},
+			ErrorStatusBlockLength: 0x1000,
+		},
+		{
+			Type: HESTSourceGHESv2, SourceID: 4, Enabled: true,
+			Notification:    HESTNotification{Type: HESTNotifyNMI},
+			ReadAckRegister: GenericAddress{Space: AddressSpaceSysMemory, BitWidth: 64, AccessSize: 4, Address: 0x7f001008},
+			ReadAckPreserve: 0xfe,
+			ReadAckWrite:    0x01,
+		},
+		{Type: HESTSourcePCIeRootAER, SourceID: 5, Enabled: true},
+	}
+
+	if !reflect.DeepEqual(sources, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, sources)
+	}
+
+	truncatedMCE := errorSource(HESTSourceIA32MCE, 0, 40)
+	truncatedMCE[32] = 1
+
+	specs := []*SDTHeader{
+		// Source count exceeds the table length
+		hestFor(2, nmi),
+		// Bank structures extend past the end of the table
+		hestFor(1, truncatedMCE),
+		// Unknown source type
+		hestFor(1, errorSource(0x42, 0, 20)),
+		// Truncated header
+		tableFor(hestSignature, hestHeaderLen-1, nil),
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := DecodeHEST(spec); err != errMalformedHEST {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, errMalformedHEST, err)
+		}
+	}
+
+	if _, err := DecodeHEST(tableFor(hpetSignature, hestHeaderLen, nil)); err != errNotHEST {
+		t.Errorf("expected to get error %v; got %v", errNotHEST, err)
+	}
+}
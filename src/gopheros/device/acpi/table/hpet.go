@@ -0,0 +1,88 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotHPET       = &kernel.Error{Module: "acpi_table", Message: "table is not a HPET table", Code: kernel.ErrCodeInvalidArgument}
+	errHPETTruncated = &kernel.Error{Module: "acpi_table", Message: "HPET table is too short", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature and length of the HPET table.
+const (
+	hpetSignature = "HPET"
+	hpetTableLen  = 56
+)
+
+// The fields of the event timer block ID that is stored in the HPET table.
+// The ID mirrors the contents of the lower 32 bits of the capabilities
+// register of the timer block.
+const (
+	hpetIDRevisionMask      = uint32(0xff)
+	hpetIDComparatorsShift  = 8
+	hpetIDComparatorsMask   = uint32(0x1f << hpetIDComparatorsShift)
+	hpetIDCounter64Bit      = uint32(1 << 13)
+	hpetIDLegacyReplacement = uint32(1 << 15)
+	hpetIDVendorShift       = 16
+)
+
+// HPETInfo contains the decoded contents of the HPET table which describes a
+// High Precision Event Timer block.
+type HPETInfo struct {
+	// The hardware revision of the timer block.
+	Revision uint8
+
+	// The number of comparators (timers) provided by the timer block.
+	Comparators uint8
+
+	// Set if the main counter is 64 bits wide.
+	Counter64Bit bool
+
+	// Set if the timer block can replace the PIT and RTC interrupts.
+	LegacyReplacement bool
+
+	// The PCI vendor ID of the timer block.
+	VendorID uint16
+
+	// The location of the timer block registers.
+	BaseAddress GenericAddress
+
+	// The sequence number of the timer block.
+	Number uint8
+
+	// The minimum number of counter ticks that can be used by a periodic
+	// timer without losing interrupts.
+	MinimumTick uint16
+
+	// The page protection and OEM attributes of the timer block.
+	PageProtection uint8
+}
+
+// DecodeHPET decodes the HPET table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeHPET(header *SDTHeader) (*HPETInfo, *kernel.Error) {
+	if string(header.Signature[:]) != hpetSignature {
+		return nil, errNotHPET
+	}
+
+	return decodeHPET(tableData(header))
+}
+
+// decodeHPET decodes the HPET table stored in data.
+func decodeHPET(data []byte) (*HPETInfo, *kernel.Error) {
+	if len(data) < hpetTableLen {
+		return nil, errHPETTruncated
+	}
+
+	id := dword(data[36:])
+	return &HPETInfo{
+		Revision:          uint8(id & hpetIDRevisionMask),
+		Comparators:       uint8((id&hpetIDComparatorsMask)>>hpetIDComparatorsShift) + 1,
+		Counter64Bit:      id&hpetIDCounter64Bit != 0,
+		LegacyReplacement: id&hpetIDLegacyReplacement != 0,
+		VendorID:          uint16(id >> hpetIDVendorShift),
+		BaseAddress:       genericAddress(data[40:]),
+		Number:            data[52],
+		MinimumTick:       word(data[53:]),
+		PageProtection:    data[55],
+	}, nil
+}
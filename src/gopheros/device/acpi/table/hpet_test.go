@@ -0,0 +1,49 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeHPET(t *testing.T) {
+	hpet := tableFor(hpetSignature, 36, []byte{
+		// Event timer block ID: rev 1, 3 comparators, 64-bit counter,
+		// legacy replacement capable, vendor 0x8086
+		0x01, 0xa2, 0x86, 0x80,
+		// Base address: SystemMemory, 64-bit, 0xfed00000
+		0x00, 0x40, 0x00, 0x00, 0x00, 0x00, 0xd0, 0xfe, 0x00, 0x00, 0x00, 0x00,
+		// HPET number, minimum tick and page protection
+		0x00, 0x80, 0x00, 0x00,
+	})
+
+	info, err := DecodeHPET(hpet)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &HPETInfo{
+		Revision:          1,
+		Comparators:       3,
+		Counter64Bit:      true,
+		LegacyReplacement: true,
+		VendorID:          0x8086,
+		BaseAddress: GenericAddress{
+			Space:    AddressSpaceSysMemory,
+			BitWidth: 64,
+			Address:  0xfed00000,
+		},
+		MinimumTick: 0x80,
+	}
+
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, info)
+	}
+
+	if _, err = DecodeHPET(tableFor(madtSignature, hpetTableLen, nil)); err != errNotHPET {
+		t.Errorf("expected to get error %v; got %v", errNotHPET, err)
+	}
+
+	if _, err = DecodeHPET(tableFor(hpetSignature, hpetTableLen-1, nil)); err != errHPETTruncated {
+		t.Errorf("expected to get error %v; got %v", errHPETTruncated, err)
+	}
+}
@@ -0,0 +1,28 @@
+package table
+
+// registeredTables lists the headers of all mapped tables in the order they
+// were discovered. Unlike a lookup by signature, the list retains all
+// instances of tables that may appear multiple times (e.g. SSDTs).
+var registeredTables []*SDTHeader
+
+// SetTables registers the list of mapped ACPI tables that can be visited via
+// ForEach. It is invoked by the ACPI driver once it has enumerated the tables
+// listed by the RSDT or XSDT. The supplied headers must remain mapped for as
+// long as they are registered.
+func SetTables(headers []*SDTHeader) {
+	registeredTables = append([]*SDTHeader(nil), headers...)
+}
+
+// ForEach invokes fn for each registered table with the specified signature
+// in the order they were discovered. The payload argument contains the table
+// contents that follow the table header. Callers do not need to know whether
+// the tables were listed by the RSDT or the XSDT.
+func ForEach(sig string, fn func(hdr *SDTHeader, payload []byte)) {
+	for _, header := range registeredTables {
+		if string(header.Signature[:]) != sig {
+			continue
+		}
+
+		fn(header, tableData(header)[sdtHeaderLen:])
+	}
+}
@@ -0,0 +1,52 @@
+package table
+
+import "testing"
+
+func TestForEach(t *testing.T) {
+	defer SetTables(nil)
+
+	var (
+		ssdt1 = tableFor("SSDT", 36, []byte{1})
+		apic1 = tableFor(madtSignature, 36, []byte{2, 3})
+		ssdt2 = tableFor("SSDT", 36, []byte{4})
+		apic2 = tableFor(madtSignature, 36, nil)
+	)
+
+	ForEach("SSDT", func(_ *SDTHeader, _ []byte) {
+		t.Fatal("expected ForEach not to invoke fn when no tables are registered")
+	})
+
+	SetTables([]*SDTHeader{ssdt1, apic1, ssdt2, apic2})
+
+	specs := []struct {
+		sig        string
+		expHeaders []*SDTHeader
+		expPayload [][]byte
+	}{
+		{"SSDT", []*SDTHeader{ssdt1, ssdt2}, [][]byte{{1}, {4}}},
+		{madtSignature, []*SDTHeader{apic1, apic2}, [][]byte{{2, 3}, {}}},
+		{"HPET", nil, nil},
+	}
+
+	for specIndex, spec := range specs {
+		var visited int
+		ForEach(spec.sig, func(hdr *SDTHeader, payload []byte) {
+			if visited >= len(spec.expHeaders) {
+				t.Fatalf("[spec %d] fn invoked more times than expected", specIndex)
+			}
+
+			if hdr != spec.expHeaders[visited] {
+				t.Errorf("[spec %d] [table %d] got unexpected header %p", specIndex, visited, hdr)
+			}
+
+			if string(payload) != string(spec.expPayload[visited]) {
+				t.Errorf("[spec %d] [table %d] expected payload %v; got %v", specIndex, visited, spec.expPayload[visited], payload)
+			}
+			visited++
+		})
+
+		if visited != len(spec.expHeaders) {
+			t.Errorf("[spec %d] expected fn to be invoked %d times; got %d", specIndex, len(spec.expHeaders), visited)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotMADT         = &kernel.Error{Module: "acpi_table", Message: "table is not a MADT", Code: kernel.ErrCodeInvalidArgument}
+	errMADTTruncated   = &kernel.Error{Module: "acpi_table", Message: "MADT is too short to contain its header", Code: kernel.ErrCodeCorrupted}
+	errMalformedRecord = &kernel.Error{Module: "acpi_table", Message: "MADT record exceeds the table bounds or has an invalid length", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the MADT.
+const madtSignature = "APIC"
+
+// The size of the MADT header and the minimum size of each supported record
+// type in the ACPI table (as opposed to the size of the Go structs which may
+// include padding).
+const (
+	madtHeaderLen = 44
+
+	madtLocalAPICLen             = 8
+	madtIOAPICLen                = 12
+	madtIntSrcOverrideLen        = 10
+	madtNMISourceLen             = 8
+	madtNMILen                   = 6
+	madtLocalAPICAddrOverrideLen = 12
+)
+
+// MADTInfo contains the decoded contents of a MADT. The entries of each type
+// are listed in the order in which they appear in the table.
+type MADTInfo struct {
+	// The physical address of the local APIC of each processor. If the
+	// MADT contains a local APIC address override record, this field
+	// contains the 64-bit address specified by the override.
+	LocalControllerAddress uint64
+
+	// The MADT flags (see MADTFlagPCATCompat).
+	Flags uint32
+
+	LocalAPICs         []MADTEntryLocalAPIC
+	IOAPICs            []MADTEntryIOAPIC
+	InterruptOverrides []MADTEntryInterruptSrcOverride
+	NMISources         []MADTEntryNMISource
+	NMIs               []MADTEntryNMI
+}
+
+// DecodeMADT decodes the MADT described by header. The caller must ensure
+// that the entire table contents are mapped. Records with types that are not
+// supported by the decoder are skipped.
+func DecodeMADT(header *SDTHeader) (*MADTInfo, *kernel.Error) {
+	if string(header.Signature[:]) != madtSignature {
+		return nil, errNotMADT
+	}
+
+	return decodeMADT(tableData(header))
+}
+
+// decodeMADT decodes the MADT stored in data.
+func decodeMADT(data []byte) (*MADTInfo, *kernel.Error) {
+	if len(data) < madtHeaderLen {
+		return nil, errMADTTruncated
+	}
+
+	info := &MADTInfo{
+		LocalControllerAddress: uint64(dword(data[36:])),
+		Flags:                  dword(data[40:]),
+	}
+
+	for offset := madtHeaderLen; offset < len(data); {
+		if len(data)-offset < 2 {
+			return nil, errMalformedRecord
+		}
+
+		recType, recLen := MADTEntryType(data[offset]), int(data[offset+1])
+		if recLen < 2 || recLen > len(data)-offset {
+			return nil, errMalformedRecord
+		}
+
+		rec := data[offset : offset+recLen]
+		offset += recLen
+
+		switch recType {
+		case MADTEntryTypeLocalAPIC:
+			if recLen < madtLocalAPICLen {
+				return nil, errMalformedRecord
+			}
+			info.LocalAPICs = append(info.LocalAPICs, MADTEntryLocalAPIC{
+				ProcessorID: rec[2],
+				APICID:      rec[3],
+				Flags:       dword(rec[4:]),
+			})
+		case MADTEntryTypeIOAPIC:
+			if recLen < madtIOAPICLen {
+				return nil, errMalformedRecord
+			}
+			info.IOAPICs = append(info.IOAPICs, MADTEntryIOAPIC{
+				APICID:           rec[2],
+				Address:          dword(rec[4:]),
+				SysInterruptBase: dword(rec[8:]),
+			})
+		case MADTEntryTypeIntSrcOverride:
+			if recLen < madtIntSrcOverrideLen {
+				return nil, errMalformedRecord
+			}
+			info.InterruptOverrides = append(info.InterruptOverrides, MADTEntryInterruptSrcOverride{
+				BusSrc:          rec[2],
+				IRQSrc:          rec[3],
+				GlobalInterrupt: dword(rec[4:]),
+				Flags:           word(rec[8:]),
+			})
+		case MADTEntryTypeNMISource:
+			if recLen < madtNMISourceLen {
+				return nil, errMalformedRecord
+			}
+			info.NMISources = append(info.NMISources, MADTEntryNMISource{
+				Flags:           word(rec[2:]),
+				GlobalInterrupt: dword(rec[4:]),
+			})
+		case MADTEntryTypeNMI:
+			if recLen < madtNMILen {
+				return nil, errMalformedRecord
+			}
+			info.NMIs = append(info.NMIs, MADTEntryNMI{
+				Processor: rec[2],
+				Flags:     word(rec[3:]),
+				LINT:      rec[5],
+			})
+		case MADTEntryTypeLocalAPICAddrOverride:
+			if recLen < madtLocalAPICAddrOverrideLen {
+				return nil, errMalformedRecord
+			}
+			info.LocalControllerAddress = qword(rec[4:])
+		}
+	}
+
+	return info, nil
+}
@@ -0,0 +1,123 @@
+package table
+
+import (
+	"gopheros/kernel"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+func TestDecodeMADT(t *testing.T) {
+	_, f, _, _ := runtime.Caller(0)
+	data, err := ioutil.ReadFile(filepath.Join(filepath.Dir(f), "tabletest", "APIC.aml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info, decErr := DecodeMADT((*SDTHeader)(unsafe.Pointer(&data[0])))
+	if decErr != nil {
+		t.Fatal(decErr)
+	}
+
+	exp := &MADTInfo{
+		LocalControllerAddress: 0xfee00000,
+		Flags:                  MADTFlagPCATCompat,
+		LocalAPICs: []MADTEntryLocalAPIC{
+			{ProcessorID: 0, APICID: 0, Flags: LocalAPICEnabled},
+		},
+		IOAPICs: []MADTEntryIOAPIC{
+			{APICID: 1, Address: 0xfec00000, SysInterruptBase: 0},
+		},
+		InterruptOverrides: []MADTEntryInterruptSrcOverride{
+			{BusSrc: 0, IRQSrc: 0, GlobalInterrupt: 2, Flags: 0},
+			{BusSrc: 0, IRQSrc: 9, GlobalInterrupt: 9, Flags: IntFlagPolarityActiveHigh | IntFlagTriggerLevel},
+		},
+	}
+
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, info)
+	}
+
+	data[0] = 'X'
+	if _, decErr = DecodeMADT((*SDTHeader)(unsafe.Pointer(&data[0]))); decErr != errNotMADT {
+		t.Fatalf("expected to get error %v; got %v", errNotMADT, decErr)
+	}
+}
+
+func TestDecodeMADTRecords(t *testing.T) {
+	header := make([]byte, madtHeaderLen)
+	copy(header, madtSignature)
+	header[36], header[37], header[38], header[39] = 0x00, 0x00, 0xe0, 0xfe
+
+	specs := []struct {
+		records []byte
+		expInfo *MADTInfo
+		expErr  *kernel.Error
+	}{
+		{
+			[]byte{
+				// NMI source: flags 0x000d, GSI 0x12
+				0x03, 0x08, 0x0d, 0x00, 0x12, 0x00, 0x00, 0x00,
+				// Local APIC NMI: all processors, flags 0x0005, LINT1
+				0x04, 0x06, 0xff, 0x05, 0x00, 0x01,
+				// Unsupported record type; skipped
+				0x7f, 0x04, 0xaa, 0xbb,
+				// Local APIC address override: 0x1_fee00000
+				0x05, 0x0c, 0x00, 0x00, 0x00, 0x00, 0xe0, 0xfe, 0x01, 0x00, 0x00, 0x00,
+			},
+			&MADTInfo{
+				LocalControllerAddress: 0x1fee00000,
+				NMISources: []MADTEntryNMISource{
+					{Flags: IntFlagPolarityActiveHigh | IntFlagTriggerLevel, GlobalInterrupt: 0x12},
+				},
+				NMIs: []MADTEntryNMI{
+					{Processor: 0xff, Flags: IntFlagPolarityActiveHigh | IntFlagTriggerEdge, LINT: 1},
+				},
+			},
+			nil,
+		},
+		// Record length exceeds table length
+		{
+			[]byte{0x00, 0x08, 0x00, 0x00},
+			nil,
+			errMalformedRecord,
+		},
+		// Record length too short for its type
+		{
+			[]byte{0x01, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+			nil,
+			errMalformedRecord,
+		},
+		// Zero-length record
+		{
+			[]byte{0x00, 0x00},
+			nil,
+			errMalformedRecord,
+		},
+		// Truncated record header
+		{
+			[]byte{0x00},
+			nil,
+			errMalformedRecord,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		info, err := decodeMADT(append(append([]byte{}, header...), spec.records...))
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(info, spec.expInfo) {
+			t.Errorf("[spec %d] expected to get:\n%+v\ngot:\n%+v", specIndex, spec.expInfo, info)
+		}
+	}
+
+	if _, err := decodeMADT(header[:madtHeaderLen-1]); err != errMADTTruncated {
+		t.Errorf("expected to get error %v; got %v", errMADTTruncated, err)
+	}
+}
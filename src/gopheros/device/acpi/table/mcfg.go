@@ -0,0 +1,72 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotMCFG       = &kernel.Error{Module: "acpi_table", Message: "table is not a MCFG table", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedMCFG = &kernel.Error{Module: "acpi_table", Message: "MCFG table contains a malformed allocation record", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the MCFG table, the length of its header (including 8
+// reserved bytes) and the length of each allocation record that follows it.
+const (
+	mcfgSignature = "MCFG"
+	mcfgHeaderLen = 44
+	mcfgRecordLen = 16
+)
+
+// MCFGEntry describes the memory-mapped (ECAM) configuration space of a range
+// of PCI buses within a PCI segment.
+type MCFGEntry struct {
+	// The physical address of the configuration space of bus 0 in the
+	// segment. The configuration space of a particular function is located
+	// at BaseAddress + (bus << 20 | device << 15 | function << 12).
+	BaseAddress uint64
+
+	// The PCI segment group number.
+	Segment uint16
+
+	// The first and last bus numbers decoded by this entry.
+	StartBus uint8
+	EndBus   uint8
+}
+
+// Covers returns true if the entry decodes the specified PCI segment and bus.
+func (e *MCFGEntry) Covers(segment uint16, bus uint8) bool {
+	return e.Segment == segment && bus >= e.StartBus && bus <= e.EndBus
+}
+
+// DecodeMCFG decodes the allocation records of the MCFG table described by
+// header. The caller must ensure that the entire table contents are mapped.
+func DecodeMCFG(header *SDTHeader) ([]MCFGEntry, *kernel.Error) {
+	if string(header.Signature[:]) != mcfgSignature {
+		return nil, errNotMCFG
+	}
+
+	return decodeMCFG(tableData(header))
+}
+
+// decodeMCFG decodes the MCFG table stored in data.
+func decodeMCFG(data []byte) ([]MCFGEntry, *kernel.Error) {
+	if len(data) < mcfgHeaderLen || (len(data)-mcfgHeaderLen)%mcfgRecordLen != 0 {
+		return nil, errMalformedMCFG
+	}
+
+	var entries []MCFGEntry
+	for offset := mcfgHeaderLen; offset < len(data); offset += mcfgRecordLen {
+		entry := MCFGEntry{
+			BaseAddress: qword(data[offset:]),
+			Segment:     word(data[offset+8:]),
+			StartBus:    data[offset+10],
+			EndBus:      data[offset+11],
+		}
+
+		if entry.EndBus < entry.StartBus {
+			return nil, errMalformedMCFG
+		}
+
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
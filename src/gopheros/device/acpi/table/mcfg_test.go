@@ -0,0 +1,69 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeMCFG(t *testing.T) {
+	header := tableFor(mcfgSignature, mcfgHeaderLen, concat(
+		// Segment 0, buses 0-255
+		[]byte{0x00, 0x00, 0x00, 0xe0, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0x00, 0x00, 0x00, 0x00},
+		// Segment 1, buses 0x10-0x1f
+		[]byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x01, 0x00, 0x10, 0x1f, 0x00, 0x00, 0x00, 0x00},
+	))
+
+	entries, err := DecodeMCFG(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []MCFGEntry{
+		{BaseAddress: 0xe0000000, Segment: 0, StartBus: 0, EndBus: 0xff},
+		{BaseAddress: 0x100000000, Segment: 1, StartBus: 0x10, EndBus: 0x1f},
+	}
+	if !reflect.DeepEqual(entries, exp) {
+		t.Fatalf("expected to get %+v; got %+v", exp, entries)
+	}
+
+	specs := []struct {
+		segment uint16
+		bus     uint8
+		exp     bool
+	}{
+		{0, 0, true},
+		{0, 0xff, true},
+		{1, 0x0f, false},
+		{1, 0x10, true},
+		{1, 0x20, false},
+		{2, 0x10, false},
+	}
+
+	for specIndex, spec := range specs {
+		covered := entries[0].Covers(spec.segment, spec.bus) || entries[1].Covers(spec.segment, spec.bus)
+		if covered != spec.exp {
+			t.Errorf("[spec %d] expected coverage of %d:%d to be %t", specIndex, spec.segment, spec.bus, spec.exp)
+		}
+	}
+}
+
+func TestDecodeMCFGErrors(t *testing.T) {
+	specs := []*SDTHeader{
+		// Truncated header
+		tableFor(mcfgSignature, mcfgHeaderLen-8, nil),
+		// Truncated record
+		tableFor(mcfgSignature, mcfgHeaderLen, make([]byte, mcfgRecordLen-1)),
+		// End bus precedes start bus
+		tableFor(mcfgSignature, mcfgHeaderLen, []byte{0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0x10, 0x0f, 0, 0, 0, 0}),
+	}
+
+	for specIndex, header := range specs {
+		if _, err := DecodeMCFG(header); err != errMalformedMCFG {
+			t.Errorf("[spec %d] expected to get errMalformedMCFG; got %v", specIndex, err)
+		}
+	}
+
+	if _, err := DecodeMCFG(tableFor(hpetSignature, mcfgHeaderLen, nil)); err != errNotMCFG {
+		t.Errorf("expected to get errNotMCFG; got %v", err)
+	}
+}
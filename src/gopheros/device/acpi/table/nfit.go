@@ -0,0 +1,115 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotNFIT             = &kernel.Error{Module: "acpi_table", Message: "table is not a NFIT", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedNFITRecord = &kernel.Error{Module: "acpi_table", Message: "NFIT structure exceeds the table bounds or has an invalid length", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the NFIT, the length of its header (including 4 reserved
+// bytes) and the length of the system physical address (SPA) range structure.
+const (
+	nfitSignature = "NFIT"
+	nfitHeaderLen = 40
+
+	nfitSPARangeLen = 56
+)
+
+// The NFIT structure type that describes a SPA range. All other structure
+// types describe the NVDIMM topology and are skipped by the decoder.
+const nfitTypeSPARange = 0
+
+// The flags of a SPA range structure.
+const (
+	// Set if the range is only used for controlling hot-added NVDIMMs.
+	NFITSPAControlRegion = uint16(1 << 0)
+
+	// Set if the ProximityDomain field is valid.
+	NFITSPAProximityValid = uint16(1 << 1)
+)
+
+// The GUIDs that identify the type of a SPA range. The GUIDs are stored in
+// their in-memory representation.
+var (
+	NFITRangePersistent = [16]byte{0x79, 0xd3, 0xf0, 0x66, 0xf3, 0xb4, 0x74, 0x40, 0xac, 0x43, 0x0d, 0x33, 0x18, 0xb7, 0x8c, 0xdb}
+	NFITRangeVolatile   = [16]byte{0x4f, 0x94, 0x05, 0x73, 0xda, 0xfd, 0xe3, 0x44, 0xb1, 0x6c, 0x3f, 0x22, 0xd2, 0x52, 0xe5, 0xd0}
+)
+
+// NFITRange describes a system physical address range that is backed by
+// NVDIMMs.
+type NFITRange struct {
+	// The index that other NFIT structures use to refer to this range.
+	Index uint16
+
+	Flags uint16
+
+	// The proximity domain of the range. Only valid if the
+	// NFITSPAProximityValid flag is set.
+	ProximityDomain uint32
+
+	// The GUID that identifies the type of the range.
+	RangeType [16]byte
+
+	Base   uint64
+	Length uint64
+
+	// The EFI memory mapping attributes of the range.
+	MappingAttributes uint64
+}
+
+// Persistent returns true if the range describes byte-addressable persistent
+// memory.
+func (r *NFITRange) Persistent() bool {
+	return r.RangeType == NFITRangePersistent
+}
+
+// DecodeNFIT decodes the SPA range structures of the NFIT described by
+// header. The caller must ensure that the entire table contents are mapped.
+func DecodeNFIT(header *SDTHeader) ([]NFITRange, *kernel.Error) {
+	if string(header.Signature[:]) != nfitSignature {
+		return nil, errNotNFIT
+	}
+
+	return decodeNFIT(tableData(header))
+}
+
+// decodeNFIT decodes the NFIT stored in data.
+func decodeNFIT(data []byte) ([]NFITRange, *kernel.Error) {
+	if len(data) < nfitHeaderLen {
+		return nil, errMalformedNFITRecord
+	}
+
+	var ranges []NFITRange
+	for offset := nfitHeaderLen; offset < len(data); {
+		if offset+4 > len(data) {
+			return nil, errMalformedNFITRecord
+		}
+
+		recType, recLen := word(data[offset:]), int(word(data[offset+2:]))
+		if recLen < 4 || offset+recLen > len(data) {
+			return nil, errMalformedNFITRecord
+		}
+
+		if recType == nfitTypeSPARange {
+			if recLen < nfitSPARangeLen {
+				return nil, errMalformedNFITRecord
+			}
+
+			rec := NFITRange{
+				Index:             word(data[offset+4:]),
+				Flags:             word(data[offset+6:]),
+				ProximityDomain:   dword(data[offset+12:]),
+				Base:              qword(data[offset+32:]),
+				Length:            qword(data[offset+40:]),
+				MappingAttributes: qword(data[offset+48:]),
+			}
+			copy(rec.RangeType[:], data[offset+16:offset+32])
+			ranges = append(ranges, rec)
+		}
+
+		offset += recLen
+	}
+
+	return ranges, nil
+}
@@ -0,0 +1,74 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeNFIT(t *testing.T) {
+	header := tableFor(nfitSignature, nfitHeaderLen, concat(
+		spaRange(1, NFITSPAProximityValid, 2, NFITRangePersistent, 0x100000000, 0x40000000),
+		// A memory device to SPA range map structure that must be skipped
+		[]byte{1, 0, 8, 0, 0, 0, 0, 0},
+		spaRange(2, 0, 0, NFITRangeVolatile, 0x200000000, 0x1000),
+	))
+
+	ranges, err := DecodeNFIT(header)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := []NFITRange{
+		{Index: 1, Flags: NFITSPAProximityValid, ProximityDomain: 2, RangeType: NFITRangePersistent, Base: 0x100000000, Length: 0x40000000},
+		{Index: 2, RangeType: NFITRangeVolatile, Base: 0x200000000, Length: 0x1000},
+	}
+	if !reflect.DeepEqual(ranges, exp) {
+		t.Fatalf("expected to get %+v; got %+v", exp, ranges)
+	}
+
+	if !ranges[0].Persistent() || ranges[1].Persistent() {
+		t.Fatal("expected only the first range to be persistent")
+	}
+}
+
+func TestDecodeNFITErrors(t *testing.T) {
+	specs := []*SDTHeader{
+		// Truncated header
+		tableFor(nfitSignature, nfitHeaderLen-4, nil),
+		// Truncated structure header
+		tableFor(nfitSignature, nfitHeaderLen, []byte{0, 0}),
+		// Structure length too small
+		tableFor(nfitSignature, nfitHeaderLen, []byte{1, 0, 2, 0}),
+		// Structure exceeds table bounds
+		tableFor(nfitSignature, nfitHeaderLen, []byte{1, 0, 16, 0, 0, 0, 0, 0}),
+		// Truncated SPA range structure
+		tableFor(nfitSignature, nfitHeaderLen, []byte{0, 0, 8, 0, 0, 0, 0, 0}),
+	}
+
+	for specIndex, header := range specs {
+		if _, err := DecodeNFIT(header); err != errMalformedNFITRecord {
+			t.Errorf("[spec %d] expected to get errMalformedNFITRecord; got %v", specIndex, err)
+		}
+	}
+
+	if _, err := DecodeNFIT(tableFor(mcfgSignature, nfitHeaderLen, nil)); err != errNotNFIT {
+		t.Errorf("expected to get errNotNFIT; got %v", err)
+	}
+}
+
+// spaRange returns a NFIT SPA range structure.
+func spaRange(index, flags uint16, domain uint32, rangeType [16]byte, base, length uint64) []byte {
+	rec := make([]byte, nfitSPARangeLen)
+	rec[0], rec[2] = nfitTypeSPARange, nfitSPARangeLen
+	rec[4], rec[5] = byte(index), byte(index>>8)
+	rec[6], rec[7] = byte(flags), byte(flags>>8)
+	for i := uint(0); i < 4; i++ {
+		rec[12+i] = byte(domain >> (8 * i))
+	}
+	copy(rec[16:], rangeType[:])
+	for i := uint(0); i < 8; i++ {
+		rec[32+i] = byte(base >> (8 * i))
+		rec[40+i] = byte(length >> (8 * i))
+	}
+	return rec
+}
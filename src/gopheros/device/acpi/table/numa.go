@@ -0,0 +1,284 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotSRAT              = &kernel.Error{Module: "acpi_table", Message: "table is not a SRAT", Code: kernel.ErrCodeInvalidArgument}
+	errNotSLIT              = &kernel.Error{Module: "acpi_table", Message: "table is not a SLIT", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedSRATRecord  = &kernel.Error{Module: "acpi_table", Message: "SRAT record exceeds the table bounds or has an invalid length", Code: kernel.ErrCodeCorrupted}
+	errMalformedSLIT        = &kernel.Error{Module: "acpi_table", Message: "SLIT distance matrix does not match the table length", Code: kernel.ErrCodeCorrupted}
+	errSLITDomainOutOfRange = &kernel.Error{Module: "acpi_table", Message: "SRAT proximity domain is not described by the SLIT", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signatures of the tables that describe the NUMA topology.
+const (
+	sratSignature = "SRAT"
+	slitSignature = "SLIT"
+)
+
+// The size of the SRAT and SLIT headers and the minimum size of each supported
+// SRAT record type.
+const (
+	sratHeaderLen = 48
+	slitHeaderLen = 44
+
+	sratCPUAffinityLen    = 16
+	sratMemoryAffinityLen = 40
+	sratX2APICAffinityLen = 24
+)
+
+// The supported SRAT record types.
+const (
+	sratTypeCPUAffinity = iota
+	sratTypeMemoryAffinity
+	sratTypeX2APICAffinity
+)
+
+// The flags of SRAT records.
+const (
+	// Set if the record is in use. Disabled records must be ignored.
+	sratFlagEnabled = uint32(1 << 0)
+
+	// The memory affinity flags that indicate whether the memory range
+	// is hot-pluggable or non-volatile.
+	sratMemFlagHotPluggable = uint32(1 << 1)
+	sratMemFlagNonVolatile  = uint32(1 << 2)
+)
+
+// The relative distances reported by the SLIT for the local proximity domain
+// and the distance assumed for remote domains if the firmware does not provide
+// a SLIT.
+const (
+	LocalDistance         = uint8(10)
+	DefaultRemoteDistance = uint8(20)
+)
+
+// CPUAffinity associates a processor with a proximity domain.
+type CPUAffinity struct {
+	// The (x2)APIC ID of the processor.
+	APICID uint32
+
+	Domain uint32
+}
+
+// MemoryAffinity associates a physical memory range with a proximity domain.
+type MemoryAffinity struct {
+	Base   uint64
+	Length uint64
+
+	Domain uint32
+
+	HotPluggable bool
+	NonVolatile  bool
+}
+
+// Topology describes the NUMA topology of the system as reported by the SRAT
+// and the optional SLIT. Proximity domains group processors and memory ranges
+// that are close to each other; accessing memory in a remote domain incurs a
+// higher latency than accessing memory in the local domain.
+type Topology struct {
+	// The enabled processor and memory affinity records in the order in
+	// which they appear in the SRAT.
+	CPUs   []CPUAffinity
+	Memory []MemoryAffinity
+
+	// The number of localities described by the SLIT and the distance
+	// matrix stored in row-major order. Both are empty if the firmware
+	// did not provide a SLIT.
+	localities uint64
+	distances  []uint8
+}
+
+// DecodeTopology decodes the NUMA topology described by the SRAT and the
+// optional SLIT. If slit is nil, Distance reports the default distances for
+// local and remote domains. The caller must ensure that the entire contents
+// of both tables are mapped.
+func DecodeTopology(srat, slit *SDTHeader) (*Topology, *kernel.Error) {
+	if string(srat.Signature[:]) != sratSignature {
+		return nil, errNotSRAT
+	}
+
+	topo := &Topology{}
+	if err := topo.decodeSRAT(tableData(srat)); err != nil {
+		return nil, err
+	}
+
+	if slit != nil {
+		if string(slit.Signature[:]) != slitSignature {
+			return nil, errNotSLIT
+		}
+
+		if err := topo.decodeSLIT(tableData(slit)); err != nil {
+			return nil, err
+		}
+	}
+
+	return topo, nil
+}
+
+// Domains returns the list of proximity domains that contain at least one
+// processor or memory range in ascending order.
+func (t *Topology) Domains() []uint32 {
+	var domains []uint32
+
+	addDomain := func(domain uint32) {
+		index := 0
+		for ; index < len(domains) && domains[index] < domain; index++ {
+		}
+
+		if index < len(domains) && domains[index] == domain {
+			return
+		}
+
+		domains = append(domains, 0)
+		copy(domains[index+1:], domains[index:])
+		domains[index] = domain
+	}
+
+	for _, cpu := range t.CPUs {
+		addDomain(cpu.Domain)
+	}
+	for _, mem := range t.Memory {
+		addDomain(mem.Domain)
+	}
+
+	return domains
+}
+
+// DomainForAPIC returns the proximity domain of the processor with the
+// specified APIC ID. If the processor is not described by the SRAT,
+// DomainForAPIC returns false.
+func (t *Topology) DomainForAPIC(apicID uint32) (uint32, bool) {
+	for _, cpu := range t.CPUs {
+		if cpu.APICID == apicID {
+			return cpu.Domain, true
+		}
+	}
+
+	return 0, false
+}
+
+// DomainForAddress returns the proximity domain of the memory range that
+// contains the specified physical address. If the address does not belong to
+// any memory range described by the SRAT, DomainForAddress returns false.
+func (t *Topology) DomainForAddress(addr uint64) (uint32, bool) {
+	for _, mem := range t.Memory {
+		if addr >= mem.Base && addr-mem.Base < mem.Length {
+			return mem.Domain, true
+		}
+	}
+
+	return 0, false
+}
+
+// Distance returns the relative distance between two proximity domains. The
+// distance between a domain and itself is LocalDistance; the distances
+// between remote domains are scaled relative to it.
+func (t *Topology) Distance(from, to uint32) uint8 {
+	switch {
+	case t.localities != 0 && uint64(from) < t.localities && uint64(to) < t.localities:
+		return t.distances[uint64(from)*t.localities+uint64(to)]
+	case from == to:
+		return LocalDistance
+	default:
+		return DefaultRemoteDistance
+	}
+}
+
+// decodeSRAT populates the processor and memory affinity lists from the SRAT
+// stored in data. Records with types that are not supported by the decoder are
+// skipped.
+func (t *Topology) decodeSRAT(data []byte) *kernel.Error {
+	if len(data) < sratHeaderLen {
+		return errMalformedSRATRecord
+	}
+
+	for offset := sratHeaderLen; offset < len(data); {
+		if len(data)-offset < 2 {
+			return errMalformedSRATRecord
+		}
+
+		recType, recLen := data[offset], int(data[offset+1])
+		if recLen < 2 || recLen > len(data)-offset {
+			return errMalformedSRATRecord
+		}
+
+		rec := data[offset : offset+recLen]
+		offset += recLen
+
+		switch recType {
+		case sratTypeCPUAffinity:
+			if recLen < sratCPUAffinityLen {
+				return errMalformedSRATRecord
+			}
+
+			if dword(rec[4:])&sratFlagEnabled == 0 {
+				continue
+			}
+
+			// The proximity domain is split into the low byte at
+			// offset 2 and the upper three bytes at offset 9.
+			t.CPUs = append(t.CPUs, CPUAffinity{
+				APICID: uint32(rec[3]),
+				Domain: uint32(rec[2]) | uint32(rec[9])<<8 | uint32(rec[10])<<16 | uint32(rec[11])<<24,
+			})
+		case sratTypeMemoryAffinity:
+			if recLen < sratMemoryAffinityLen {
+				return errMalformedSRATRecord
+			}
+
+			flags := dword(rec[28:])
+			if flags&sratFlagEnabled == 0 {
+				continue
+			}
+
+			t.Memory = append(t.Memory, MemoryAffinity{
+				Base:         qword(rec[8:]),
+				Length:       qword(rec[16:]),
+				Domain:       dword(rec[2:]),
+				HotPluggable: flags&sratMemFlagHotPluggable != 0,
+				NonVolatile:  flags&sratMemFlagNonVolatile != 0,
+			})
+		case sratTypeX2APICAffinity:
+			if recLen < sratX2APICAffinityLen {
+				return errMalformedSRATRecord
+			}
+
+			if dword(rec[12:])&sratFlagEnabled == 0 {
+				continue
+			}
+
+			t.CPUs = append(t.CPUs, CPUAffinity{
+				APICID: dword(rec[8:]),
+				Domain: dword(rec[4:]),
+			})
+		}
+	}
+
+	return nil
+}
+
+// decodeSLIT populates the distance matrix from the SLIT stored in data. All
+// proximity domains referenced by the SRAT must be described by the SLIT.
+func (t *Topology) decodeSLIT(data []byte) *kernel.Error {
+	if len(data) < slitHeaderLen {
+		return errMalformedSLIT
+	}
+
+	localities := qword(data[36:])
+	if localities == 0 || localities > 0xffff || uint64(len(data)-slitHeaderLen) < localities*localities {
+		return errMalformedSLIT
+	}
+
+	for _, domain := range t.Domains() {
+		if uint64(domain) >= localities {
+			return errSLITDomainOutOfRange
+		}
+	}
+
+	// Copy the matrix so it remains valid even if the table is unmapped.
+	t.localities = localities
+	t.distances = make([]uint8, localities*localities)
+	copy(t.distances, data[slitHeaderLen:])
+	return nil
+}
@@ -0,0 +1,197 @@
+package table
+
+import (
+	"gopheros/kernel"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestDecodeTopology(t *testing.T) {
+	srat := tableFor(sratSignature, sratHeaderLen, concat(
+		// CPU affinity: APIC 0, domain 0, enabled
+		[]byte{0x00, 0x10, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		// CPU affinity: APIC 2, domain 0x101, enabled
+		[]byte{0x00, 0x10, 0x01, 0x02, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		// CPU affinity: APIC 4, domain 1, disabled
+		[]byte{0x00, 0x10, 0x01, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00},
+		// x2APIC affinity: APIC 0x100, domain 1, enabled
+		[]byte{
+			0x02, 0x18, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00,
+			0x01, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		},
+		// Memory affinity: [0, 1G) in domain 0, enabled
+		memoryAffinity(0, 0, 0x40000000, 0x1),
+		// Memory affinity: [4G, 8G) in domain 1, enabled, hot-pluggable, non-volatile
+		memoryAffinity(1, 0x100000000, 0x100000000, 0x7),
+		// Memory affinity: [1G, 2G) in domain 1, disabled
+		memoryAffinity(1, 0x40000000, 0x40000000, 0x0),
+		// Unsupported record type; skipped
+		[]byte{0x7f, 0x04, 0x00, 0x00},
+	))
+
+	topo, err := DecodeTopology(srat, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expCPUs := []CPUAffinity{
+		{APICID: 0, Domain: 0},
+		{APICID: 2, Domain: 0x101},
+		{APICID: 0x100, Domain: 1},
+	}
+	if !reflect.DeepEqual(topo.CPUs, expCPUs) {
+		t.Errorf("expected CPU affinities to be %+v; got %+v", expCPUs, topo.CPUs)
+	}
+
+	expMemory := []MemoryAffinity{
+		{Base: 0, Length: 0x40000000, Domain: 0},
+		{Base: 0x100000000, Length: 0x100000000, Domain: 1, HotPluggable: true, NonVolatile: true},
+	}
+	if !reflect.DeepEqual(topo.Memory, expMemory) {
+		t.Errorf("expected memory affinities to be %+v; got %+v", expMemory, topo.Memory)
+	}
+
+	if exp, got := []uint32{0, 1, 0x101}, topo.Domains(); !reflect.DeepEqual(got, exp) {
+		t.Errorf("expected domains to be %v; got %v", exp, got)
+	}
+
+	t.Run("lookups", func(t *testing.T) {
+		specs := []struct {
+			lookup    func() (uint32, bool)
+			expDomain uint32
+			expOK     bool
+		}{
+			{func() (uint32, bool) { return topo.DomainForAPIC(2) }, 0x101, true},
+			{func() (uint32, bool) { return topo.DomainForAPIC(0x100) }, 1, true},
+			// Disabled CPU affinity records are ignored
+			{func() (uint32, bool) { return topo.DomainForAPIC(4) }, 0, false},
+			{func() (uint32, bool) { return topo.DomainForAddress(0x3fffffff) }, 0, true},
+			{func() (uint32, bool) { return topo.DomainForAddress(0x100000000) }, 1, true},
+			// Disabled memory affinity records are ignored
+			{func() (uint32, bool) { return topo.DomainForAddress(0x40000000) }, 0, false},
+		}
+
+		for specIndex, spec := range specs {
+			if domain, ok := spec.lookup(); domain != spec.expDomain || ok != spec.expOK {
+				t.Errorf("[spec %d] expected to get domain %d, %t; got %d, %t", specIndex, spec.expDomain, spec.expOK, domain, ok)
+			}
+		}
+	})
+
+	t.Run("default distances", func(t *testing.T) {
+		if got := topo.Distance(1, 1); got != LocalDistance {
+			t.Errorf("expected local distance to be %d; got %d", LocalDistance, got)
+		}
+
+		if got := topo.Distance(0, 1); got != DefaultRemoteDistance {
+			t.Errorf("expected remote distance to be %d; got %d", DefaultRemoteDistance, got)
+		}
+	})
+}
+
+func TestDecodeTopologySLIT(t *testing.T) {
+	srat := tableFor(sratSignature, sratHeaderLen, concat(
+		memoryAffinity(0, 0, 0x40000000, 0x1),
+		memoryAffinity(1, 0x40000000, 0x40000000, 0x1),
+	))
+
+	slit := tableFor(slitSignature, slitHeaderLen-8, []byte{
+		0x02, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		10, 21,
+		21, 10,
+	})
+
+	topo, err := DecodeTopology(srat, slit)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		from, to uint32
+		exp      uint8
+	}{
+		{0, 0, 10},
+		{0, 1, 21},
+		{1, 0, 21},
+		{1, 1, 10},
+		// Domains not covered by the SLIT use the default distances
+		{2, 2, LocalDistance},
+		{2, 0, DefaultRemoteDistance},
+	}
+
+	for specIndex, spec := range specs {
+		if got := topo.Distance(spec.from, spec.to); got != spec.exp {
+			t.Errorf("[spec %d] expected distance(%d, %d) to be %d; got %d", specIndex, spec.from, spec.to, spec.exp, got)
+		}
+	}
+}
+
+func TestDecodeTopologyErrors(t *testing.T) {
+	validSRAT := tableFor(sratSignature, sratHeaderLen, memoryAffinity(2, 0, 0x1000, 0x1))
+
+	specs := []struct {
+		srat, slit *SDTHeader
+		expErr     *kernel.Error
+	}{
+		{tableFor(slitSignature, sratHeaderLen, nil), nil, errNotSRAT},
+		{validSRAT, tableFor(sratSignature, slitHeaderLen, nil), errNotSLIT},
+		// Truncated header
+		{tableFor(sratSignature, sratHeaderLen-8, nil), nil, errMalformedSRATRecord},
+		// Record length exceeds table length
+		{tableFor(sratSignature, sratHeaderLen, []byte{0x00, 0x10, 0x00, 0x00}), nil, errMalformedSRATRecord},
+		// Record length too short for its type
+		{tableFor(sratSignature, sratHeaderLen, []byte{0x01, 0x04, 0x00, 0x00}), nil, errMalformedSRATRecord},
+		// Zero-length record
+		{tableFor(sratSignature, sratHeaderLen, []byte{0x00, 0x00}), nil, errMalformedSRATRecord},
+		// Truncated record header
+		{tableFor(sratSignature, sratHeaderLen, []byte{0x00}), nil, errMalformedSRATRecord},
+		// Truncated SLIT header
+		{validSRAT, tableFor(slitSignature, slitHeaderLen-8, nil), errMalformedSLIT},
+		// SLIT matrix larger than the table
+		{validSRAT, tableFor(slitSignature, slitHeaderLen-8, []byte{0x03, 0, 0, 0, 0, 0, 0, 0, 10, 20}), errMalformedSLIT},
+		// SRAT references a domain that is not covered by the SLIT
+		{validSRAT, tableFor(slitSignature, slitHeaderLen-8, []byte{0x01, 0, 0, 0, 0, 0, 0, 0, 10}), errSLITDomainOutOfRange},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := DecodeTopology(spec.srat, spec.slit); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+// tableFor returns a table with the specified signature whose records follow
+// a zeroed header of headerLen bytes.
+func tableFor(signature string, headerLen int, records []byte) *SDTHeader {
+	data := make([]byte, headerLen+len(records))
+	copy(data, signature)
+	copy(data[headerLen:], records)
+
+	header := (*SDTHeader)(unsafe.Pointer(&data[0]))
+	header.Length = uint32(len(data))
+	return header
+}
+
+// memoryAffinity returns a SRAT memory affinity record.
+func memoryAffinity(domain uint32, base, length uint64, flags uint32) []byte {
+	rec := make([]byte, sratMemoryAffinityLen)
+	rec[0], rec[1] = sratTypeMemoryAffinity, sratMemoryAffinityLen
+	for i := uint(0); i < 4; i++ {
+		rec[2+i] = byte(domain >> (8 * i))
+		rec[28+i] = byte(flags >> (8 * i))
+	}
+	for i := uint(0); i < 8; i++ {
+		rec[8+i] = byte(base >> (8 * i))
+		rec[16+i] = byte(length >> (8 * i))
+	}
+	return rec
+}
+
+func concat(chunks ...[]byte) []byte {
+	var out []byte
+	for _, chunk := range chunks {
+		out = append(out, chunk...)
+	}
+	return out
+}
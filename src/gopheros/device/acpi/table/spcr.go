@@ -0,0 +1,117 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotSPCR       = &kernel.Error{Module: "acpi_table", Message: "table is not a SPCR table", Code: kernel.ErrCodeInvalidArgument}
+	errSPCRTruncated = &kernel.Error{Module: "acpi_table", Message: "SPCR table is too short", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature and length of the SPCR table.
+const (
+	spcrSignature = "SPCR"
+	spcrTableLen  = 80
+)
+
+// SPCRInterfaceType describes the type of the serial port that is used for
+// console redirection. The values are defined by the Debug Port Table 2
+// specification.
+type SPCRInterfaceType uint8
+
+// The list of serial port interface types that are relevant to x86 systems.
+const (
+	// SPCRInterface16550 is a fully 16550-compatible UART.
+	SPCRInterface16550 SPCRInterfaceType = 0x00
+
+	// SPCRInterface16450 is a 16450-compatible UART which implements a
+	// subset of the 16550 registers.
+	SPCRInterface16450 SPCRInterfaceType = 0x01
+
+	// SPCRInterface16550GAS is a 16550-compatible UART whose register
+	// access width is described by the base address structure.
+	SPCRInterface16550GAS SPCRInterfaceType = 0x12
+)
+
+// SPCRInterruptType is a bitmask that describes the interrupt controllers
+// that can deliver the serial port interrupt.
+type SPCRInterruptType uint8
+
+// The list of supported interrupt types.
+const (
+	// SPCRInterruptPIC indicates that the IRQ field is valid.
+	SPCRInterruptPIC SPCRInterruptType = 1 << iota
+
+	// SPCRInterruptIOAPIC indicates that the GSI field is valid.
+	SPCRInterruptIOAPIC
+)
+
+// The baud rates that can be encoded in the SPCR table indexed by the
+// encoded value. A zero value indicates that the firmware has already
+// configured the baud rate and it should be left as is.
+var spcrBaudRates = [...]uint32{0, 0, 0, 9600, 19200, 0, 57600, 115200}
+
+// SPCRInfo contains the decoded contents of the Serial Port Console
+// Redirection table which describes the serial port that the firmware uses
+// for console redirection.
+type SPCRInfo struct {
+	// The type of the serial port.
+	InterfaceType SPCRInterfaceType
+
+	// The location of the serial port registers.
+	BaseAddress GenericAddress
+
+	// The interrupt controllers that can deliver the serial port
+	// interrupt and the interrupt used with each one of them.
+	InterruptType SPCRInterruptType
+	IRQ           uint8
+	GSI           uint32
+
+	// The configured baud rate or 0 if the serial port should be used
+	// with its current settings.
+	BaudRate uint32
+
+	// The parity, stop bits and flow control settings as defined by the
+	// SPCR specification.
+	Parity      uint8
+	StopBits    uint8
+	FlowControl uint8
+
+	// The terminal protocol used by the remote console (0: VT100,
+	// 1: VT100+, 2: VT-UTF8, 3: ANSI).
+	TerminalType uint8
+}
+
+// DecodeSPCR decodes the SPCR table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeSPCR(header *SDTHeader) (*SPCRInfo, *kernel.Error) {
+	if string(header.Signature[:]) != spcrSignature {
+		return nil, errNotSPCR
+	}
+
+	return decodeSPCR(tableData(header))
+}
+
+// decodeSPCR decodes the SPCR table stored in data.
+func decodeSPCR(data []byte) (*SPCRInfo, *kernel.Error) {
+	if len(data) < spcrTableLen {
+		return nil, errSPCRTruncated
+	}
+
+	info := &SPCRInfo{
+		InterfaceType: SPCRInterfaceType(data[36]),
+		BaseAddress:   genericAddress(data[40:]),
+		InterruptType: SPCRInterruptType(data[52]),
+		IRQ:           data[53],
+		GSI:           dword(data[54:]),
+		Parity:        data[59],
+		StopBits:      data[60],
+		FlowControl:   data[61],
+		TerminalType:  data[62],
+	}
+
+	if baudIndex := int(data[58]); baudIndex < len(spcrBaudRates) {
+		info.BaudRate = spcrBaudRates[baudIndex]
+	}
+
+	return info, nil
+}
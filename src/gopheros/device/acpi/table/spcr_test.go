@@ -0,0 +1,76 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeSPCR(t *testing.T) {
+	spcrFor := func(baud uint8) *SDTHeader {
+		return tableFor(spcrSignature, 36, []byte{
+			// Interface type: full 16550 and reserved bytes
+			0x00, 0x00, 0x00, 0x00,
+			// Base address: SystemIO, 8-bit, 0x3f8
+			0x01, 0x08, 0x00, 0x01, 0xf8, 0x03, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			// Interrupt type: PIC and I/O APIC, IRQ 4, GSI 4
+			0x03, 0x04, 0x04, 0x00, 0x00, 0x00,
+			// Baud rate, parity, stop bits, flow control (RTS/CTS),
+			// terminal type (VT-UTF8) and language
+			baud, 0x00, 0x01, 0x02, 0x02, 0x00,
+			// PCI device/vendor ID, bus, device, function, flags,
+			// segment and reserved bytes
+			0xff, 0xff, 0xff, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00,
+		})
+	}
+
+	specs := []struct {
+		baud    uint8
+		expBaud uint32
+	}{
+		{0, 0},
+		{3, 9600},
+		{4, 19200},
+		{6, 57600},
+		{7, 115200},
+		{5, 0},
+		{0xff, 0},
+	}
+
+	for specIndex, spec := range specs {
+		info, err := DecodeSPCR(spcrFor(spec.baud))
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		exp := &SPCRInfo{
+			InterfaceType: SPCRInterface16550,
+			BaseAddress: GenericAddress{
+				Space:      AddressSpaceSysIO,
+				BitWidth:   8,
+				AccessSize: 1,
+				Address:    0x3f8,
+			},
+			InterruptType: SPCRInterruptPIC | SPCRInterruptIOAPIC,
+			IRQ:           4,
+			GSI:           4,
+			BaudRate:      spec.expBaud,
+			StopBits:      1,
+			FlowControl:   2,
+			TerminalType:  2,
+		}
+
+		if !reflect.DeepEqual(info, exp) {
+			t.Errorf("[spec %d] expected to get:\n%+v\ngot:\n%+v", specIndex, exp, info)
+		}
+	}
+
+	if _, err := DecodeSPCR(tableFor(hpetSignature, spcrTableLen, nil)); err != errNotSPCR {
+		t.Errorf("expected to get error %v; got %v", errNotSPCR, err)
+	}
+
+	if _, err := DecodeSPCR(tableFor(spcrSignature, spcrTableLen-1, nil)); err != errSPCRTruncated {
+		t.Errorf("expected to get error %v; got %v", errSPCRTruncated, err)
+	}
+}
@@ -187,6 +187,34 @@ type FADT struct {
 	Ext FADT64
 }
 
+// FACS (Firmware ACPI Control Structure) is a structure in read/write memory
+// that the OS and the firmware use for synchronizing access to shared
+// hardware resources via the global lock and for handing off control when
+// resuming from a sleep state. Unlike other tables, the FACS does not begin
+// with a SDTHeader and is not covered by a checksum.
+type FACS struct {
+	Signature         [4]byte
+	Length            uint32
+	HardwareSignature uint32
+
+	// The 32-bit physical address of the real mode code that the
+	// firmware jumps to when resuming from a sleep state.
+	FirmwareWakingVector uint32
+
+	GlobalLock uint32
+	Flags      uint32
+
+	// The 64-bit physical address of the OS waking vector. If non-zero,
+	// it is used by the firmware instead of FirmwareWakingVector. This
+	// field is only defined if Version >= 1.
+	XFirmwareWakingVector uint64
+
+	Version   uint8
+	reserved  [3]uint8
+	OSPMFlags uint32
+	reserved2 [24]uint8
+}
+
 // MADT (Multiple APIC Description Table) is an ACPI table containing
 // information about the interrupt controllers and the number of installed
 // CPUs. Following the table header are a series of variable sized records
@@ -198,6 +226,13 @@ type MADT struct {
 	Flags                  uint32
 }
 
+// The MADT flags.
+const (
+	// Set if the system also has a PC-AT-compatible dual-8259 setup that
+	// must be disabled before enabling the APICs.
+	MADTFlagPCATCompat = uint32(1 << 0)
+)
+
 // MADTEntryLocalAPIC describes a single physical processor and its local
 // interrupt controller.
 type MADTEntryLocalAPIC struct {
@@ -206,6 +241,15 @@ type MADTEntryLocalAPIC struct {
 	Flags       uint32
 }
 
+// The flags of MADTEntryLocalAPIC entries.
+const (
+	// Set if the processor is ready for use.
+	LocalAPICEnabled = uint32(1 << 0)
+
+	// Set if a disabled processor can be brought online at runtime.
+	LocalAPICOnlineCapable = uint32(1 << 1)
+)
+
 // MADTEntryIOAPIC describes an I/O Advanced Programmable Interrupt Controller.
 type MADTEntryIOAPIC struct {
 	APICID   uint8
@@ -229,6 +273,13 @@ type MADTEntryInterruptSrcOverride struct {
 	Flags           uint16
 }
 
+// MADTEntryNMISource specifies a global system interrupt that should be
+// configured as a non-maskable interrupt.
+type MADTEntryNMISource struct {
+	Flags           uint16
+	GlobalInterrupt uint32
+}
+
 // MADTEntryNMI describes a non-maskable interrupt that we need to set up for
 // a single processor or all processors.
 type MADTEntryNMI struct {
@@ -243,6 +294,21 @@ type MADTEntryNMI struct {
 	LINT uint8
 }
 
+// The polarity and trigger mode bits of the flags used by interrupt source
+// override and NMI entries. Interrupts whose polarity or trigger mode is set
+// to the "conforms" value use the default settings of their source bus.
+const (
+	IntFlagPolarityMask       = uint16(3 << 0)
+	IntFlagPolarityConforms   = uint16(0 << 0)
+	IntFlagPolarityActiveHigh = uint16(1 << 0)
+	IntFlagPolarityActiveLow  = uint16(3 << 0)
+
+	IntFlagTriggerMask     = uint16(3 << 2)
+	IntFlagTriggerConforms = uint16(0 << 2)
+	IntFlagTriggerEdge     = uint16(1 << 2)
+	IntFlagTriggerLevel    = uint16(3 << 2)
+)
+
 // MADTEntryType describes the type of a MADT record.
 type MADTEntryType uint8
 
@@ -251,7 +317,9 @@ const (
 	MADTEntryTypeLocalAPIC MADTEntryType = iota
 	MADTEntryTypeIOAPIC
 	MADTEntryTypeIntSrcOverride
+	MADTEntryTypeNMISource
 	MADTEntryTypeNMI
+	MADTEntryTypeLocalAPICAddrOverride
 )
 
 // MADTEntry describes a MADT table entry that follows the MADT definition. As
@@ -0,0 +1,83 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotTPM2       = &kernel.Error{Module: "acpi_table", Message: "table is not a TPM2 table", Code: kernel.ErrCodeInvalidArgument}
+	errTPM2Truncated = &kernel.Error{Module: "acpi_table", Message: "TPM2 table is too short", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the TPM2 table, its minimum length and the length of
+// tables that also describe the event log area.
+const (
+	tpm2Signature   = "TPM2"
+	tpm2TableLen    = 52
+	tpm2TableLogLen = 76
+)
+
+// TPM2StartMethod describes the interface used for submitting commands to
+// the TPM.
+type TPM2StartMethod uint32
+
+// The list of start methods that are relevant to x86 systems.
+const (
+	// TPM2StartACPI indicates that commands are started by invoking an
+	// ACPI method.
+	TPM2StartACPI TPM2StartMethod = 2
+
+	// TPM2StartTIS indicates that the TPM implements the memory-mapped
+	// FIFO (TIS) interface.
+	TPM2StartTIS TPM2StartMethod = 6
+
+	// TPM2StartCRB indicates that the TPM implements the command
+	// response buffer (CRB) interface.
+	TPM2StartCRB TPM2StartMethod = 7
+
+	// TPM2StartCRBWithACPI indicates that the TPM implements the CRB
+	// interface but commands must be started by invoking an ACPI method.
+	TPM2StartCRBWithACPI TPM2StartMethod = 8
+)
+
+// TPM2Info contains the decoded contents of the TPM2 table which describes
+// the interface of a TPM 2.0 device.
+type TPM2Info struct {
+	// The platform class (0: client, 1: server).
+	PlatformClass uint16
+
+	// The physical address of the CRB control area. It is only defined
+	// for TPMs that implement the CRB interface.
+	ControlArea uint64
+
+	StartMethod TPM2StartMethod
+
+	// The location and minimum length of the area that holds the TPM
+	// event log or zero if the table does not describe the log area.
+	LogAreaStart     uint64
+	LogAreaMinLength uint32
+}
+
+// DecodeTPM2 decodes the TPM2 table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeTPM2(header *SDTHeader) (*TPM2Info, *kernel.Error) {
+	if string(header.Signature[:]) != tpm2Signature {
+		return nil, errNotTPM2
+	}
+
+	data := tableData(header)
+	if len(data) < tpm2TableLen {
+		return nil, errTPM2Truncated
+	}
+
+	info := &TPM2Info{
+		PlatformClass: word(data[36:]),
+		ControlArea:   qword(data[40:]),
+		StartMethod:   TPM2StartMethod(dword(data[48:])),
+	}
+
+	if len(data) >= tpm2TableLogLen {
+		info.LogAreaMinLength = dword(data[64:])
+		info.LogAreaStart = qword(data[68:])
+	}
+
+	return info, nil
+}
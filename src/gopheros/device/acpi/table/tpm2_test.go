@@ -0,0 +1,55 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeTPM2(t *testing.T) {
+	tpm2Payload := []byte{
+		// Platform class (server) and reserved bytes
+		0x01, 0x00, 0x00, 0x00,
+		// Address of CRB control area
+		0x40, 0x00, 0xd4, 0xfe, 0x00, 0x00, 0x00, 0x00,
+		// Start method (CRB)
+		0x07, 0x00, 0x00, 0x00,
+		// Start method specific parameters
+		0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		// Log area minimum length and start address
+		0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x7e, 0x7f, 0x00, 0x00, 0x00, 0x00,
+	}
+
+	specs := []struct {
+		payload []byte
+		exp     *TPM2Info
+	}{
+		{
+			tpm2Payload[:tpm2TableLen-36],
+			&TPM2Info{PlatformClass: 1, ControlArea: 0xfed40040, StartMethod: TPM2StartCRB},
+		},
+		{
+			tpm2Payload,
+			&TPM2Info{PlatformClass: 1, ControlArea: 0xfed40040, StartMethod: TPM2StartCRB, LogAreaMinLength: 0x10000, LogAreaStart: 0x7f7e0000},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		info, err := DecodeTPM2(tableFor(tpm2Signature, 36, spec.payload))
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(info, spec.exp) {
+			t.Errorf("[spec %d] expected to get:\n%+v\ngot:\n%+v", specIndex, spec.exp, info)
+		}
+	}
+
+	if _, err := DecodeTPM2(tableFor(hpetSignature, tpm2TableLen, nil)); err != errNotTPM2 {
+		t.Errorf("expected to get error %v; got %v", errNotTPM2, err)
+	}
+
+	if _, err := DecodeTPM2(tableFor(tpm2Signature, tpm2TableLen-1, nil)); err != errTPM2Truncated {
+		t.Errorf("expected to get error %v; got %v", errTPM2Truncated, err)
+	}
+}
@@ -0,0 +1,142 @@
+package table
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"unsafe"
+)
+
+var (
+	errInvalidTable = &kernel.Error{Module: "acpi_table", Message: "table failed validation", Code: kernel.ErrCodeCorrupted}
+
+	// minRevisions contains the lowest revision number that has been
+	// assigned to each of the tables defined by the ACPI specification.
+	// Tables that are not listed here are not subject to revision checks.
+	minRevisions = map[string]uint8{
+		"APIC": 1,
+		"BERT": 1,
+		"BGRT": 1,
+		"DMAR": 1,
+		"DSDT": 1,
+		"ERST": 1,
+		"FACP": 1,
+		"HEST": 1,
+		"HPET": 1,
+		"MCFG": 1,
+		"NFIT": 1,
+		"SLIT": 1,
+		"SPCR": 1,
+		"SRAT": 1,
+		"SSDT": 1,
+		"TPM2": 3,
+		"WDAT": 1,
+		"XSDT": 1,
+	}
+)
+
+// sdtHeaderLen is the size in bytes of an ACPI table header.
+const sdtHeaderLen = uint32(unsafe.Sizeof(SDTHeader{}))
+
+// ValidationFailure describes the reason why a table failed validation.
+type ValidationFailure uint8
+
+// The list of supported validation failures.
+const (
+	// ValidationLengthTooShort indicates that the table length is
+	// smaller than the size of the table header.
+	ValidationLengthTooShort ValidationFailure = iota + 1
+
+	// ValidationLengthExceedsMapping indicates that the table length
+	// exceeds the size of the memory region that has been mapped for
+	// accessing the table.
+	ValidationLengthExceedsMapping
+
+	// ValidationChecksumMismatch indicates that the bytes of the table do
+	// not sum to zero.
+	ValidationChecksumMismatch
+
+	// ValidationInvalidRevision indicates that the table revision is lower
+	// than the first revision defined by the ACPI specification for the
+	// table.
+	ValidationInvalidRevision
+)
+
+// ValidationError describes a table that failed validation. The error returned
+// by Validate wraps a *ValidationError which can be retrieved via its Cause
+// field.
+type ValidationError struct {
+	// The table signature.
+	Signature string
+
+	// The reason why the table failed validation.
+	Failure ValidationFailure
+
+	// The table length reported by the header and the number of bytes
+	// that are accessible starting from the table header.
+	Length     uint32
+	MappedSize uintptr
+
+	// The table revision.
+	Revision uint8
+
+	// The sum of the table bytes. It is only populated for checksum
+	// mismatch failures.
+	Sum uint8
+}
+
+// Error implements the error interface.
+func (e *ValidationError) Error() string {
+	var buf bytes.Buffer
+	switch e.Failure {
+	case ValidationLengthTooShort:
+		kfmt.Fprintf(&buf, "[table: %s] length %d is smaller than the table header", e.Signature, e.Length)
+	case ValidationLengthExceedsMapping:
+		kfmt.Fprintf(&buf, "[table: %s] length %d exceeds the mapped size %d", e.Signature, e.Length, e.MappedSize)
+	case ValidationChecksumMismatch:
+		kfmt.Fprintf(&buf, "[table: %s] checksum mismatch (sum: 0x%x)", e.Signature, e.Sum)
+	case ValidationInvalidRevision:
+		kfmt.Fprintf(&buf, "[table: %s] invalid revision %d", e.Signature, e.Revision)
+	default:
+		kfmt.Fprintf(&buf, "[table: %s] unknown validation failure", e.Signature)
+	}
+
+	return buf.String()
+}
+
+// Validate checks that the table described by header is safe to hand to a
+// decoder or the AML parser. The mappedSize argument specifies the number of
+// bytes that are accessible starting from the table header. Validate ensures
+// that the table length covers the header but does not exceed mappedSize, that
+// the table checksum is correct and that the table revision is sane. If the
+// table fails validation, the returned error wraps a *ValidationError with
+// the failure details.
+func Validate(header *SDTHeader, mappedSize uintptr) *kernel.Error {
+	verr := ValidationError{
+		Signature:  string(header.Signature[:]),
+		Length:     header.Length,
+		MappedSize: mappedSize,
+		Revision:   header.Revision,
+	}
+
+	switch {
+	case mappedSize < uintptr(sdtHeaderLen) || header.Length < sdtHeaderLen:
+		verr.Failure = ValidationLengthTooShort
+	case uintptr(header.Length) > mappedSize:
+		verr.Failure = ValidationLengthExceedsMapping
+	default:
+		for _, b := range tableData(header) {
+			verr.Sum += b
+		}
+
+		if verr.Sum != 0 {
+			verr.Failure = ValidationChecksumMismatch
+		} else if minRev, known := minRevisions[verr.Signature]; known && header.Revision < minRev {
+			verr.Failure = ValidationInvalidRevision
+		} else {
+			return nil
+		}
+	}
+
+	return errInvalidTable.Wrap(&verr)
+}
@@ -0,0 +1,96 @@
+package table
+
+import "testing"
+
+func TestValidate(t *testing.T) {
+	// withChecksum updates the checksum of header so the table bytes sum
+	// to zero.
+	withChecksum := func(header *SDTHeader) *SDTHeader {
+		var sum uint8
+		for _, b := range tableData(header) {
+			sum += b
+		}
+		header.Checksum -= sum
+		return header
+	}
+
+	// withRevision sets the revision of header.
+	withRevision := func(header *SDTHeader, revision uint8) *SDTHeader {
+		header.Revision = revision
+		return header
+	}
+
+	// withLength overrides the length of header.
+	withLength := func(header *SDTHeader, length uint32) *SDTHeader {
+		header.Length = length
+		return header
+	}
+
+	validMADT := func() *SDTHeader {
+		return withChecksum(withRevision(tableFor(madtSignature, 44, []byte{0xde, 0xad}), 3))
+	}
+
+	specs := []struct {
+		header     *SDTHeader
+		mappedSize uintptr
+		expFailure ValidationFailure
+	}{
+		{validMADT(), 46, 0},
+		{validMADT(), 4096, 0},
+		// unknown tables are not subject to revision checks
+		{withChecksum(tableFor("OEM1", 36, nil)), 36, 0},
+		{withLength(validMADT(), 35), 46, ValidationLengthTooShort},
+		{validMADT(), 35, ValidationLengthTooShort},
+		{validMADT(), 45, ValidationLengthExceedsMapping},
+		{withRevision(validMADT(), 4), 46, ValidationChecksumMismatch},
+		{withChecksum(withRevision(tableFor(madtSignature, 44, nil), 0)), 44, ValidationInvalidRevision},
+	}
+
+	for specIndex, spec := range specs {
+		err := Validate(spec.header, spec.mappedSize)
+		if spec.expFailure == 0 {
+			if err != nil {
+				t.Errorf("[spec %d] expected no error; got %v", specIndex, err)
+			}
+			continue
+		}
+
+		if err == nil || !err.Is(errInvalidTable) {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, errInvalidTable, err)
+			continue
+		}
+
+		verr, ok := err.Cause.(*ValidationError)
+		if !ok {
+			t.Errorf("[spec %d] expected error to wrap a *ValidationError; got %T", specIndex, err.Cause)
+			continue
+		}
+
+		if verr.Failure != spec.expFailure {
+			t.Errorf("[spec %d] expected validation failure %d; got %d (%s)", specIndex, spec.expFailure, verr.Failure, verr.Error())
+		}
+
+		if verr.Signature != string(spec.header.Signature[:]) || verr.Length != spec.header.Length || verr.MappedSize != spec.mappedSize {
+			t.Errorf("[spec %d] unexpected validation error details: %+v", specIndex, verr)
+		}
+	}
+}
+
+func TestValidationErrorMessage(t *testing.T) {
+	specs := []struct {
+		err    ValidationError
+		expMsg string
+	}{
+		{ValidationError{Signature: "APIC", Failure: ValidationLengthTooShort, Length: 12}, "[table: APIC] length 12 is smaller than the table header"},
+		{ValidationError{Signature: "APIC", Failure: ValidationLengthExceedsMapping, Length: 128, MappedSize: 36}, "[table: APIC] length 128 exceeds the mapped size 36"},
+		{ValidationError{Signature: "DSDT", Failure: ValidationChecksumMismatch, Sum: 0xfe}, "[table: DSDT] checksum mismatch (sum: 0xfe)"},
+		{ValidationError{Signature: "SSDT", Failure: ValidationInvalidRevision}, "[table: SSDT] invalid revision 0"},
+		{ValidationError{Signature: "SSDT"}, "[table: SSDT] unknown validation failure"},
+	}
+
+	for specIndex, spec := range specs {
+		if got := spec.err.Error(); got != spec.expMsg {
+			t.Errorf("[spec %d] expected error message %q; got %q", specIndex, spec.expMsg, got)
+		}
+	}
+}
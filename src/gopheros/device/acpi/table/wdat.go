@@ -0,0 +1,163 @@
+package table
+
+import "gopheros/kernel"
+
+var (
+	errNotWDAT       = &kernel.Error{Module: "acpi_table", Message: "table is not a WDAT table", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedWDAT = &kernel.Error{Module: "acpi_table", Message: "WDAT table contains a malformed instruction entry", Code: kernel.ErrCodeCorrupted}
+)
+
+// The signature of the WDAT table, the length of its header and the length of
+// each instruction entry that follows it.
+const (
+	wdatSignature = "WDAT"
+	wdatHeaderLen = 68
+	wdatEntryLen  = 24
+)
+
+// WDATAction identifies the watchdog operation that is implemented by a
+// sequence of WDAT instruction entries.
+type WDATAction uint8
+
+// The list of watchdog actions defined by the WDAT specification.
+const (
+	WDATActionReset                 WDATAction = 0x01
+	WDATActionQueryCurrentCountdown WDATAction = 0x04
+	WDATActionQueryCountdown        WDATAction = 0x05
+	WDATActionSetCountdown          WDATAction = 0x06
+	WDATActionQueryRunningState     WDATAction = 0x08
+	WDATActionSetRunningState       WDATAction = 0x09
+	WDATActionQueryStoppedState     WDATAction = 0x0a
+	WDATActionSetStoppedState       WDATAction = 0x0b
+	WDATActionQueryReboot           WDATAction = 0x10
+	WDATActionSetReboot             WDATAction = 0x11
+	WDATActionQueryShutdown         WDATAction = 0x12
+	WDATActionSetShutdown           WDATAction = 0x13
+	WDATActionQueryStatus           WDATAction = 0x20
+	WDATActionSetStatus             WDATAction = 0x21
+)
+
+// WDATInstruction describes how the register referenced by a WDAT instruction
+// entry is accessed.
+type WDATInstruction uint8
+
+// The list of WDAT instructions.
+const (
+	// WDATReadValue reads the register and compares the masked value
+	// against the entry value.
+	WDATReadValue WDATInstruction = 0x00
+
+	// WDATReadCountdown reads the masked register value.
+	WDATReadCountdown WDATInstruction = 0x01
+
+	// WDATWriteValue writes the masked entry value to the register.
+	WDATWriteValue WDATInstruction = 0x02
+
+	// WDATWriteCountdown writes the masked countdown value supplied by
+	// the caller to the register.
+	WDATWriteCountdown WDATInstruction = 0x03
+
+	// WDATPreserveRegister is combined with one of the write instructions
+	// to request that the register bits outside the mask are preserved.
+	WDATPreserveRegister WDATInstruction = 0x80
+)
+
+// WDATFlags describes the state of the watchdog at boot.
+type WDATFlags uint8
+
+// The list of supported WDAT flags.
+const (
+	// WDATEnabled indicates that the watchdog hardware is enabled.
+	WDATEnabled WDATFlags = 1 << 0
+
+	// WDATStoppedInSleep indicates that the watchdog countdown stops
+	// while the system is in a sleep state.
+	WDATStoppedInSleep WDATFlags = 1 << 7
+)
+
+// WDATEntry is a single step of a watchdog action.
+type WDATEntry struct {
+	Action      WDATAction
+	Instruction WDATInstruction
+
+	// The register accessed by the instruction.
+	Register GenericAddress
+
+	// The value that is compared against or written to the register and
+	// the mask that selects the register bits affected by the instruction.
+	Value uint32
+	Mask  uint32
+}
+
+// WDATInfo contains the decoded contents of the Watchdog Action Table which
+// describes a hardware watchdog as a list of register access sequences.
+type WDATInfo struct {
+	// The location of the watchdog if it is a PCI device. A segment and
+	// bus value of 0xff indicates that the watchdog is not a PCI device.
+	PCISegment  uint16
+	PCIBus      uint8
+	PCIDevice   uint8
+	PCIFunction uint8
+
+	// The period of a countdown tick in milliseconds and the range of
+	// countdown values supported by the hardware.
+	TimerPeriod uint32
+	MaxCount    uint32
+	MinCount    uint32
+
+	Flags WDATFlags
+
+	// The instruction entries in table order.
+	Entries []WDATEntry
+}
+
+// DecodeWDAT decodes the WDAT table described by header. The caller must
+// ensure that the entire table contents are mapped.
+func DecodeWDAT(header *SDTHeader) (*WDATInfo, *kernel.Error) {
+	if string(header.Signature[:]) != wdatSignature {
+		return nil, errNotWDAT
+	}
+
+	return decodeWDAT(tableData(header))
+}
+
+// decodeWDAT decodes the WDAT table stored in data.
+func decodeWDAT(data []byte) (*WDATInfo, *kernel.Error) {
+	if len(data) < wdatHeaderLen {
+		return nil, errMalformedWDAT
+	}
+
+	info := &WDATInfo{
+		PCISegment:  word(data[40:]),
+		PCIBus:      data[42],
+		PCIDevice:   data[43],
+		PCIFunction: data[44],
+		TimerPeriod: dword(data[48:]),
+		MaxCount:    dword(data[52:]),
+		MinCount:    dword(data[56:]),
+		Flags:       WDATFlags(data[60]),
+	}
+
+	numEntries := int(dword(data[64:]))
+	if numEntries > (len(data)-wdatHeaderLen)/wdatEntryLen {
+		return nil, errMalformedWDAT
+	}
+
+	for index, offset := 0, wdatHeaderLen; index < numEntries; index, offset = index+1, offset+wdatEntryLen {
+		entry := WDATEntry{
+			Action:      WDATAction(data[offset]),
+			Instruction: WDATInstruction(data[offset+1]),
+			Register:    genericAddress(data[offset+4:]),
+			Value:       dword(data[offset+16:]),
+			Mask:        dword(data[offset+20:]),
+		}
+
+		if entry.Instruction&^WDATPreserveRegister > WDATWriteCountdown {
+			return nil, errMalformedWDAT
+		}
+
+		info.Entries = append(info.Entries, entry)
+	}
+
+	return info, nil
+}
@@ -0,0 +1,95 @@
+package table
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDecodeWDAT(t *testing.T) {
+	wdatFor := func(numEntries uint32, entries ...[]byte) *SDTHeader {
+		var records []byte
+		for _, entry := range entries {
+			records = append(records, entry...)
+		}
+
+		header := tableFor(wdatSignature, wdatHeaderLen, records)
+		data := tableData(header)
+		copy(data[40:], []byte{
+			// PCI segment, bus, device, function and reserved bytes
+			0xff, 0x00, 0xff, 0x00, 0x00, 0x00, 0x00, 0x00,
+			// Timer period (1000 ms), max count (1023), min count (2)
+			0xe8, 0x03, 0x00, 0x00, 0xff, 0x03, 0x00, 0x00, 0x02, 0x00, 0x00, 0x00,
+			// Flags (enabled) and reserved bytes
+			0x01, 0x00, 0x00, 0x00,
+		})
+		for i := uint(0); i < 4; i++ {
+			data[64+i] = byte(numEntries >> (8 * i))
+		}
+		return header
+	}
+
+	wdatEntry := func(action WDATAction, instr WDATInstruction, value, mask uint32) []byte {
+		entry := []byte{
+			byte(action), byte(instr), 0x00, 0x00,
+			// Register: SystemIO, 32-bit, port 0x440
+			0x01, 0x20, 0x00, 0x03, 0x40, 0x04, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+			0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00,
+		}
+		for i := uint(0); i < 4; i++ {
+			entry[16+i] = byte(value >> (8 * i))
+			entry[20+i] = byte(mask >> (8 * i))
+		}
+		return entry
+	}
+
+	reg := GenericAddress{Space: AddressSpaceSysIO, BitWidth: 32, AccessSize: 3, Address: 0x440}
+
+	info, err := DecodeWDAT(wdatFor(2,
+		wdatEntry(WDATActionReset, WDATWriteValue|WDATPreserveRegister, 1, 0x1),
+		wdatEntry(WDATActionQueryRunningState, WDATReadValue, 1, 0x1),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	exp := &WDATInfo{
+		PCISegment:  0xff,
+		PCIBus:      0xff,
+		TimerPeriod: 1000,
+		MaxCount:    1023,
+		MinCount:    2,
+		Flags:       WDATEnabled,
+		Entries: []WDATEntry{
+			{Action: WDATActionReset, Instruction: WDATWriteValue | WDATPreserveRegister, Register: reg, Value: 1, Mask: 1},
+			{Action: WDATActionQueryRunningState, Instruction: WDATReadValue, Register: reg, Value: 1, Mask: 1},
+		},
+	}
+
+	if !reflect.DeepEqual(info, exp) {
+		t.Fatalf("expected to get:\n%+v\ngot:\n%+v", exp, info)
+	}
+
+	specs := []struct {
+		header *SDTHeader
+		expErr bool
+	}{
+		// Entry count exceeds the table length
+		{wdatFor(2, wdatEntry(WDATActionReset, WDATWriteValue, 1, 1)), true},
+		// Unknown instruction
+		{wdatFor(1, wdatEntry(WDATActionReset, 0x04, 1, 1)), true},
+		// Truncated header
+		{tableFor(wdatSignature, wdatHeaderLen-1, nil), true},
+		// No entries
+		{wdatFor(0), false},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := DecodeWDAT(spec.header); (err == errMalformedWDAT) != spec.expErr {
+			t.Errorf("[spec %d] expected to get error: %t; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if _, err := DecodeWDAT(tableFor(hpetSignature, wdatHeaderLen, nil)); err != errNotWDAT {
+		t.Errorf("expected to get error %v; got %v", errNotWDAT, err)
+	}
+}
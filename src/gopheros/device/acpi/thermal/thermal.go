@@ -0,0 +1,344 @@
+// Package thermal implements the ACPI thermal zone model. It monitors the
+// temperature of the ThermalZone objects defined in the ACPI namespace and
+// informs the kernel when the trip points defined by the firmware are
+// crossed so that it can throttle the system or shut it down.
+package thermal
+
+import (
+	"gopheros/device/acpi/aml"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/sync"
+	"io"
+)
+
+var (
+	errNoTemperature = &kernel.Error{Module: "acpi_thermal", Message: "thermal zone does not define a _TMP object", Code: kernel.ErrCodeNotSupported}
+	errNotInteger    = &kernel.Error{Module: "acpi_thermal", Message: "thermal zone object did not evaluate to an integer", Code: kernel.ErrCodeCorrupted}
+)
+
+// The notification values that the firmware sends to thermal zones.
+const (
+	notifyTemperatureChanged = uint64(0x80)
+	notifyTripPointsChanged  = uint64(0x81)
+	notifyDeviceListsChanged = uint64(0x82)
+)
+
+// The maximum number of active trip points (_AC0 to _AC9) that a thermal zone
+// may define.
+const maxActiveTrips = 10
+
+// Temperature is a temperature value expressed in tenths of a degree Kelvin.
+type Temperature uint64
+
+// DeciCelsius returns the temperature in tenths of a degree Celsius.
+func (t Temperature) DeciCelsius() int64 {
+	return int64(t) - 2732
+}
+
+// TripType describes the action that the OS is expected to take when a trip
+// point is crossed.
+type TripType uint8
+
+// The supported trip point types.
+const (
+	// TripCritical (_CRT) indicates that the system must be shut down.
+	TripCritical TripType = iota
+
+	// TripHot (_HOT) indicates that the system should enter S4.
+	TripHot
+
+	// TripPassive (_PSV) indicates that the OS should start passive
+	// cooling by throttling the processors.
+	TripPassive
+
+	// TripActive (_ACx) indicates that the OS should start the active
+	// cooling devices (e.g. fans) listed in the matching _ALx object.
+	TripActive
+)
+
+// String implements fmt.Stringer for TripType.
+func (t TripType) String() string {
+	switch t {
+	case TripCritical:
+		return "critical"
+	case TripHot:
+		return "hot"
+	case TripPassive:
+		return "passive"
+	default:
+		return "active"
+	}
+}
+
+// TripPoint describes a temperature threshold defined by a thermal zone.
+type TripPoint struct {
+	Type TripType
+
+	// For active trip points, Index contains the x value of the _ACx
+	// object. Lower indices correspond to higher temperatures.
+	Index int
+
+	Temperature Temperature
+}
+
+// TripHandler is implemented by types that react to trip point changes. Trip
+// is invoked when the temperature of zone reaches trip (tripped is true) or
+// drops back below it (tripped is false).
+type TripHandler interface {
+	Trip(zone *Zone, trip TripPoint, tripped bool)
+}
+
+// Zone describes an ACPI thermal zone.
+type Zone struct {
+	Node *aml.NamespaceNode
+
+	// The trip points defined by the zone.
+	Trips []TripPoint
+
+	// The polling interval (_TZP) recommended by the firmware in tenths
+	// of a second. A zero value indicates that the zone notifies the OS
+	// about temperature changes and does not need to be polled.
+	PollInterval uint64
+
+	// The last temperature reported by _TMP.
+	Temperature Temperature
+
+	// tripped tracks which entries in Trips have been reached.
+	tripped []bool
+}
+
+// Path returns the namespace path of the thermal zone.
+func (z *Zone) Path() string {
+	return z.Node.Path()
+}
+
+// Monitor tracks the temperature of all thermal zones defined in the
+// namespace. Zones are updated when the firmware sends a temperature change
+// notification or when the kernel invokes Poll.
+type Monitor struct {
+	errWriter io.Writer
+	vm        *aml.VM
+	handler   TripHandler
+
+	zones []*Zone
+
+	// lock serializes zone updates.
+	lock sync.Mutex
+}
+
+// NewMonitor enumerates the ThermalZone objects in ns, evaluates their trip
+// points and current temperature and installs notify handlers for them. The
+// supplied handler receives all trip point changes, including the ones
+// detected while reading the initial temperature. Zones that cannot be
+// evaluated are skipped and the errors are reported to errWriter.
+func NewMonitor(errWriter io.Writer, vm *aml.VM, ns *aml.Namespace, handler TripHandler) *Monitor {
+	m := &Monitor{
+		errWriter: errWriter,
+		vm:        vm,
+		handler:   handler,
+	}
+
+	ns.Walk(aml.WalkFilter{Types: aml.ObjectTypeThermalZone}, func(node *aml.NamespaceNode) bool {
+		zone := &Zone{Node: node}
+		if node.Child("_TMP") == nil {
+			m.reportError(zone, errNoTemperature)
+			return false
+		}
+
+		if err := m.evalTripPoints(zone); err != nil {
+			m.reportError(zone, err)
+			return false
+		}
+
+		if err := vm.InstallNotifyHandler(node.Path(), m.handleNotify); err != nil {
+			m.reportError(zone, err)
+			return false
+		}
+
+		m.zones = append(m.zones, zone)
+		return false
+	})
+
+	m.Poll()
+	return m
+}
+
+// Zones returns the thermal zones tracked by the monitor.
+func (m *Monitor) Zones() []*Zone {
+	return m.zones
+}
+
+// PollInterval returns the shortest polling interval (in tenths of a second)
+// requested by the thermal zones or zero if none of the zones needs to be
+// polled.
+func (m *Monitor) PollInterval() uint64 {
+	var interval uint64
+	for _, zone := range m.zones {
+		if zone.PollInterval != 0 && (interval == 0 || zone.PollInterval < interval) {
+			interval = zone.PollInterval
+		}
+	}
+
+	return interval
+}
+
+// Poll reads the current temperature of each thermal zone and invokes the
+// trip handler for any trip points that have been crossed since the last
+// update. The kernel is expected to call Poll periodically using the
+// interval returned by PollInterval.
+func (m *Monitor) Poll() {
+	for _, zone := range m.zones {
+		m.update(zone)
+	}
+}
+
+// handleNotify implements aml.NotifyHandler for thermal zones.
+func (m *Monitor) handleNotify(node *aml.NamespaceNode, value uint64) {
+	zone := m.zoneFor(node)
+	if zone == nil {
+		return
+	}
+
+	switch value {
+	case notifyTripPointsChanged, notifyDeviceListsChanged:
+		m.lock.Acquire()
+		err := m.evalTripPoints(zone)
+		m.lock.Release()
+
+		if err != nil {
+			m.reportError(zone, err)
+			return
+		}
+
+		m.update(zone)
+	case notifyTemperatureChanged:
+		m.update(zone)
+	}
+}
+
+// zoneFor returns the Zone associated with node.
+func (m *Monitor) zoneFor(node *aml.NamespaceNode) *Zone {
+	for _, zone := range m.zones {
+		if zone.Node == node {
+			return zone
+		}
+	}
+
+	return nil
+}
+
+// update evaluates the _TMP object of zone and notifies the trip handler
+// about any trip points whose state has changed.
+func (m *Monitor) update(zone *Zone) {
+	temp, found, err := m.evalInteger(zone, "_TMP")
+	if err == nil && !found {
+		err = errNoTemperature
+	}
+
+	if err != nil {
+		m.reportError(zone, err)
+		return
+	}
+
+	var changed []int
+
+	m.lock.Acquire()
+	zone.Temperature = Temperature(temp)
+	for tripIndex, trip := range zone.Trips {
+		if tripped := zone.Temperature >= trip.Temperature; tripped != zone.tripped[tripIndex] {
+			zone.tripped[tripIndex] = tripped
+			changed = append(changed, tripIndex)
+		}
+	}
+	m.lock.Release()
+
+	if m.handler == nil {
+		return
+	}
+
+	for _, tripIndex := range changed {
+		m.handler.Trip(zone, zone.Trips[tripIndex], zone.tripped[tripIndex])
+	}
+}
+
+// evalTripPoints populates the trip point list and polling interval of zone.
+// Trip points that were already reached retain their state.
+func (m *Monitor) evalTripPoints(zone *Zone) *kernel.Error {
+	var trips []TripPoint
+
+	for _, spec := range []struct {
+		name string
+		typ  TripType
+	}{
+		{"_CRT", TripCritical},
+		{"_HOT", TripHot},
+		{"_PSV", TripPassive},
+	} {
+		temp, found, err := m.evalInteger(zone, spec.name)
+		if err != nil {
+			return err
+		}
+
+		if found {
+			trips = append(trips, TripPoint{Type: spec.typ, Temperature: Temperature(temp)})
+		}
+	}
+
+	for index := 0; index < maxActiveTrips; index++ {
+		temp, found, err := m.evalInteger(zone, string([]byte{'_', 'A', 'C', byte('0' + index)}))
+		if err != nil {
+			return err
+		}
+
+		// Active trip points must be defined sequentially
+		if !found {
+			break
+		}
+
+		trips = append(trips, TripPoint{Type: TripActive, Index: index, Temperature: Temperature(temp)})
+	}
+
+	interval, _, err := m.evalInteger(zone, "_TZP")
+	if err != nil {
+		return err
+	}
+
+	tripped := make([]bool, len(trips))
+	for newIndex, trip := range trips {
+		for oldIndex, oldTrip := range zone.Trips {
+			if oldTrip.Type == trip.Type && oldTrip.Index == trip.Index {
+				tripped[newIndex] = zone.tripped[oldIndex] && zone.Temperature >= trip.Temperature
+			}
+		}
+	}
+
+	zone.Trips, zone.tripped, zone.PollInterval = trips, tripped, interval
+	return nil
+}
+
+// evalInteger evaluates the child object of zone with the specified name. It
+// returns false if zone does not define the object.
+func (m *Monitor) evalInteger(zone *Zone, name string) (uint64, bool, *kernel.Error) {
+	node := zone.Node.Child(name)
+	if node == nil {
+		return 0, false, nil
+	}
+
+	val, err := m.vm.Evaluate(node.Path())
+	if err != nil {
+		return 0, false, err
+	}
+
+	intVal, ok := val.(uint64)
+	if !ok {
+		return 0, false, errNotInteger
+	}
+
+	return intVal, true, nil
+}
+
+// reportError logs an error that occurred while processing zone.
+func (m *Monitor) reportError(zone *Zone, err *kernel.Error) {
+	kfmt.Fprintf(m.errWriter, "[acpi_thermal] %s: %s\n", zone.Path(), err.Error())
+}
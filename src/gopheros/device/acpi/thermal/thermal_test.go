@@ -0,0 +1,135 @@
+package thermal
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml/amltest"
+	"gopheros/device/acpi/aml/vmtest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMonitor(t *testing.T) {
+	vm, ns := vmtest.ForPayload(t, amltest.Concat(
+		// ThermalZone(TZ00) {
+		//   Name(TEMP, 3000)
+		//   Method(_TMP) { Return(TEMP) }
+		//   Name(_CRT, 3732)
+		//   Name(_PSV, 3532)
+		//   Name(_AC0, 3432)
+		//   Name(_AC1, 3232)
+		//   Name(_TZP, 50)
+		// }
+		amltest.Pkg([]byte{0x5b, 0x85}, amltest.Concat(
+			[]byte{'T', 'Z', '0', '0'},
+			[]byte{0x08, 'T', 'E', 'M', 'P', 0x0b, 0xb8, 0x0b},
+			amltest.Pkg([]byte{0x14}, []byte{'_', 'T', 'M', 'P', 0x00, 0xa4, 'T', 'E', 'M', 'P'}),
+			[]byte{0x08, '_', 'C', 'R', 'T', 0x0b, 0x94, 0x0e},
+			[]byte{0x08, '_', 'P', 'S', 'V', 0x0b, 0xcc, 0x0d},
+			[]byte{0x08, '_', 'A', 'C', '0', 0x0b, 0x68, 0x0d},
+			[]byte{0x08, '_', 'A', 'C', '1', 0x0b, 0xa0, 0x0c},
+			[]byte{0x08, '_', 'T', 'Z', 'P', 0x0a, 0x32},
+		)),
+		// ThermalZone(TZ01) {}
+		amltest.Pkg([]byte{0x5b, 0x85}, []byte{'T', 'Z', '0', '1'}),
+		// Method(HEAT, 1) { Store(Arg0, \TZ00.TEMP) Notify(\TZ00, 0x80) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'H', 'E', 'A', 'T', 0x01,
+			0x70, 0x68, '\\', 0x2e, 'T', 'Z', '0', '0', 'T', 'E', 'M', 'P',
+			0x86, '\\', 'T', 'Z', '0', '0', 0x0a, 0x80,
+		}),
+		// Method(NPSV, 1) { Store(Arg0, \TZ00._PSV) Notify(\TZ00, 0x81) }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'N', 'P', 'S', 'V', 0x01,
+			0x70, 0x68, '\\', 0x2e, 'T', 'Z', '0', '0', '_', 'P', 'S', 'V',
+			0x86, '\\', 'T', 'Z', '0', '0', 0x0a, 0x81,
+		}),
+	))
+
+	var (
+		errBuf  bytes.Buffer
+		handler = &recordingHandler{}
+		m       = NewMonitor(&errBuf, vm, ns, handler)
+	)
+
+	if !strings.Contains(errBuf.String(), `\TZ01: `+errNoTemperature.Message) {
+		t.Fatalf("expected an error about the missing _TMP object of TZ01; got %q", errBuf.String())
+	}
+
+	zones := m.Zones()
+	if len(zones) != 1 || zones[0].Path() != `\TZ00` {
+		t.Fatalf("expected a single thermal zone at \\TZ00; got %v", zones)
+	}
+
+	expTrips := []TripPoint{
+		{Type: TripCritical, Temperature: 3732},
+		{Type: TripPassive, Temperature: 3532},
+		{Type: TripActive, Index: 0, Temperature: 3432},
+		{Type: TripActive, Index: 1, Temperature: 3232},
+	}
+	if !reflect.DeepEqual(zones[0].Trips, expTrips) {
+		t.Fatalf("expected trip points:\n%v\ngot:\n%v", expTrips, zones[0].Trips)
+	}
+
+	if got := m.PollInterval(); got != 50 {
+		t.Fatalf("expected poll interval to be 50; got %d", got)
+	}
+
+	if zones[0].Temperature != 3000 || zones[0].Temperature.DeciCelsius() != 268 || len(handler.events) != 0 {
+		t.Fatalf("expected initial temperature to be 3000 without any tripped points; got %d (events: %v)", zones[0].Temperature, handler.events)
+	}
+
+	specs := []struct {
+		method string
+		arg    uint64
+		exp    []tripEvent
+	}{
+		{"HEAT", 3300, []tripEvent{{TripActive, 1, true}}},
+		{"HEAT", 3800, []tripEvent{{TripCritical, 0, true}, {TripPassive, 0, true}, {TripActive, 0, true}}},
+		{"HEAT", 3100, []tripEvent{{TripCritical, 0, false}, {TripPassive, 0, false}, {TripActive, 0, false}, {TripActive, 1, false}}},
+		// Lowering the passive trip point below the current temperature
+		{"NPSV", 3000, []tripEvent{{TripPassive, 0, true}}},
+		{"HEAT", 3100, nil},
+	}
+
+	for specIndex, spec := range specs {
+		handler.events = nil
+		if _, err := vm.Evaluate(spec.method, spec.arg); err != nil {
+			t.Errorf("[spec %d] %v", specIndex, err)
+			continue
+		}
+		vm.DispatchNotifications()
+
+		if !reflect.DeepEqual(handler.events, spec.exp) {
+			t.Errorf("[spec %d] expected trip events:\n%v\ngot:\n%v", specIndex, spec.exp, handler.events)
+		}
+	}
+
+	handler.events = nil
+	m.Poll()
+	if len(handler.events) != 0 {
+		t.Fatalf("expected polling to not report any changes; got %v", handler.events)
+	}
+}
+
+func TestTripTypeString(t *testing.T) {
+	for typ, exp := range map[TripType]string{TripCritical: "critical", TripHot: "hot", TripPassive: "passive", TripActive: "active"} {
+		if got := typ.String(); got != exp {
+			t.Errorf("expected %d.String() to return %q; got %q", typ, exp, got)
+		}
+	}
+}
+
+type tripEvent struct {
+	typ     TripType
+	index   int
+	tripped bool
+}
+
+type recordingHandler struct {
+	events []tripEvent
+}
+
+func (h *recordingHandler) Trip(_ *Zone, trip TripPoint, tripped bool) {
+	h.events = append(h.events, tripEvent{trip.Type, trip.Index, tripped})
+}
@@ -0,0 +1,242 @@
+// Package wdat implements a generic hardware watchdog driver for watchdogs
+// described by the WDAT ACPI table. Instead of documenting the watchdog
+// registers, the table lists the register access sequences that implement
+// each watchdog action; the driver interprets these sequences and registers
+// the watchdog with the kernel watchdog package.
+package wdat
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/watchdog"
+	"io"
+)
+
+var (
+	errWatchdogDisabled  = &kernel.Error{Module: "wdat", Message: "watchdog hardware is disabled by the firmware", Code: kernel.ErrCodeNotSupported}
+	errInvalidPeriod     = &kernel.Error{Module: "wdat", Message: "WDAT reports an invalid countdown period", Code: kernel.ErrCodeCorrupted}
+	errMissingAction     = &kernel.Error{Module: "wdat", Message: "WDAT does not implement a mandatory watchdog action", Code: kernel.ErrCodeNotSupported}
+	errUnsupportedAction = &kernel.Error{Module: "wdat", Message: "watchdog action not implemented by the WDAT", Code: kernel.ErrCodeNotSupported}
+	errInvalidTimeout    = &kernel.Error{Module: "wdat", Message: "timeout cannot be represented by the watchdog countdown", Code: kernel.ErrCodeInvalidArgument}
+
+	lookupTableFn         = acpi.LookupTable
+	readGenericAddressFn  = acpi.ReadGenericAddress
+	writeGenericAddressFn = acpi.WriteGenericAddress
+	registerWatchdogFn    = watchdog.Register
+
+	// The actions that must be implemented by the WDAT for the watchdog
+	// to be usable.
+	mandatoryActions = []table.WDATAction{
+		table.WDATActionReset,
+		table.WDATActionSetRunningState,
+		table.WDATActionSetStoppedState,
+	}
+)
+
+// Driver implements a device.Driver for WDAT-based watchdogs. Once
+// initialized, it also implements watchdog.Device.
+type Driver struct {
+	info *table.WDATInfo
+
+	// The instruction entries of each action in table order.
+	actions map[table.WDATAction][]table.WDATEntry
+}
+
+// DriverName returns the name of this driver.
+func (*Driver) DriverName() string {
+	return "WDAT"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Driver) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit groups the WDAT instruction entries by action, checks that the
+// mandatory actions are implemented and registers the watchdog with the
+// kernel.
+func (d *Driver) DriverInit(w io.Writer) *kernel.Error {
+	if d.info.Flags&table.WDATEnabled == 0 {
+		return errWatchdogDisabled
+	}
+
+	if d.info.TimerPeriod == 0 || d.info.MaxCount == 0 || d.info.MinCount > d.info.MaxCount {
+		return errInvalidPeriod
+	}
+
+	d.actions = make(map[table.WDATAction][]table.WDATEntry)
+	for _, entry := range d.info.Entries {
+		d.actions[entry.Action] = append(d.actions[entry.Action], entry)
+	}
+
+	for _, action := range mandatoryActions {
+		if len(d.actions[action]) == 0 {
+			return errMissingAction
+		}
+	}
+
+	min, max := d.TimeoutRange()
+	kfmt.Fprintf(w, "timeout range: %d-%d ms\n", min, max)
+
+	registerWatchdogFn(d)
+	return nil
+}
+
+// WatchdogName returns the name of the watchdog device.
+func (*Driver) WatchdogName() string {
+	return "WDAT"
+}
+
+// TimeoutRange returns the shortest and longest timeout in milliseconds that
+// can be programmed to the watchdog countdown.
+func (d *Driver) TimeoutRange() (uint32, uint32) {
+	return d.countToTimeout(d.info.MinCount), d.countToTimeout(d.info.MaxCount)
+}
+
+// Start programs the watchdog countdown (if supported by the hardware),
+// requests a system reboot on expiry (if supported) and starts the
+// watchdog.
+func (d *Driver) Start(timeout uint32) *kernel.Error {
+	count := timeout / d.info.TimerPeriod
+	if count < d.info.MinCount || count > d.info.MaxCount {
+		return errInvalidTimeout
+	}
+
+	if _, err := d.runOptionalAction(table.WDATActionSetCountdown, count); err != nil {
+		return err
+	}
+
+	if _, err := d.runOptionalAction(table.WDATActionSetReboot, 0); err != nil {
+		return err
+	}
+
+	if _, err := d.runAction(table.WDATActionSetRunningState, 0); err != nil {
+		return err
+	}
+
+	return d.Kick()
+}
+
+// Stop stops the watchdog.
+func (d *Driver) Stop() *kernel.Error {
+	_, err := d.runAction(table.WDATActionSetStoppedState, 0)
+	return err
+}
+
+// Kick reloads the watchdog countdown.
+func (d *Driver) Kick() *kernel.Error {
+	_, err := d.runAction(table.WDATActionReset, 0)
+	return err
+}
+
+// countToTimeout converts a countdown value to milliseconds, saturating at
+// the largest value that can be represented by a uint32.
+func (d *Driver) countToTimeout(count uint32) uint32 {
+	timeout := uint64(count) * uint64(d.info.TimerPeriod)
+	if timeout > uint64(^uint32(0)) {
+		return ^uint32(0)
+	}
+
+	return uint32(timeout)
+}
+
+// runOptionalAction behaves like runAction but treats actions that are not
+// implemented by the WDAT as no-ops.
+func (d *Driver) runOptionalAction(action table.WDATAction, param uint32) (uint32, *kernel.Error) {
+	if len(d.actions[action]) == 0 {
+		return 0, nil
+	}
+
+	return d.runAction(action, param)
+}
+
+// runAction executes the instruction entries of the specified action in
+// table order. The param argument is written by countdown write
+// instructions. The value returned by the last read instruction is returned
+// to the caller.
+func (d *Driver) runAction(action table.WDATAction, param uint32) (uint32, *kernel.Error) {
+	entries := d.actions[action]
+	if len(entries) == 0 {
+		return 0, errUnsupportedAction
+	}
+
+	var (
+		retVal uint32
+		err    *kernel.Error
+	)
+
+	for _, entry := range entries {
+		if retVal, err = executeInstruction(&entry, param, retVal); err != nil {
+			return 0, err
+		}
+	}
+
+	return retVal, nil
+}
+
+// executeInstruction executes a single WDAT instruction entry. Read
+// instructions return the read result; write instructions return retVal
+// unmodified.
+func executeInstruction(entry *table.WDATEntry, param, retVal uint32) (uint32, *kernel.Error) {
+	mask := uint64(entry.Mask)
+	instr := entry.Instruction &^ table.WDATPreserveRegister
+
+	switch instr {
+	case table.WDATReadValue, table.WDATReadCountdown:
+		val, err := readGenericAddressFn(entry.Register)
+		if err != nil {
+			return 0, err
+		}
+
+		val &= mask
+		if instr == table.WDATReadCountdown {
+			return uint32(val), nil
+		}
+
+		if val == uint64(entry.Value) {
+			return 1, nil
+		}
+		return 0, nil
+	default:
+		val := uint64(entry.Value)
+		if instr == table.WDATWriteCountdown {
+			val = uint64(param)
+		}
+		val &= mask
+
+		if entry.Instruction&table.WDATPreserveRegister != 0 {
+			cur, err := readGenericAddressFn(entry.Register)
+			if err != nil {
+				return 0, err
+			}
+			val |= cur &^ mask
+		}
+
+		return retVal, writeGenericAddressFn(entry.Register, val)
+	}
+}
+
+// probeForWDAT checks for the presence of a WDAT table.
+func probeForWDAT() device.Driver {
+	header := lookupTableFn("WDAT")
+	if header == nil {
+		return nil
+	}
+
+	info, err := table.DecodeWDAT(header)
+	if err != nil {
+		return nil
+	}
+
+	return &Driver{info: info}
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForWDAT,
+	})
+}
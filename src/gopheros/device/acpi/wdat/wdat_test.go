@@ -0,0 +1,199 @@
+package wdat
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/watchdog"
+	"testing"
+	"unsafe"
+)
+
+// The registers of the emulated watchdog.
+const (
+	regControl   = 0x440
+	regCountdown = 0x444
+)
+
+// The bits of the emulated control register.
+const (
+	ctrlRunning = 1 << 0
+	ctrlReboot  = 1 << 1
+	ctrlReload  = 1 << 4
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+
+	var registered watchdog.Device
+	registerWatchdogFn = func(dev watchdog.Device) { registered = dev }
+
+	drv := &Driver{info: testInfo()}
+	var buf bytes.Buffer
+	if err := drv.DriverInit(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if registered != drv {
+		t.Fatal("expected the driver to register itself as a watchdog device")
+	}
+
+	if exp, got := "timeout range: 2000-1023000 ms\n", buf.String(); got != exp {
+		t.Fatalf("expected DriverInit to output %q; got %q", exp, got)
+	}
+
+	specs := []struct {
+		mutate func(*table.WDATInfo)
+		expErr *kernel.Error
+	}{
+		{func(info *table.WDATInfo) { info.Flags = 0 }, errWatchdogDisabled},
+		{func(info *table.WDATInfo) { info.TimerPeriod = 0 }, errInvalidPeriod},
+		{func(info *table.WDATInfo) { info.MinCount = info.MaxCount + 1 }, errInvalidPeriod},
+		{func(info *table.WDATInfo) { info.Entries = info.Entries[1:] }, errMissingAction},
+	}
+
+	for specIndex, spec := range specs {
+		info := testInfo()
+		spec.mutate(info)
+
+		if err := (&Driver{info: info}).DriverInit(&bytes.Buffer{}); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestWatchdogActions(t *testing.T) {
+	defer restoreFns()
+
+	regs := mockRegisters()
+	registerWatchdogFn = func(watchdog.Device) {}
+
+	drv := &Driver{info: testInfo()}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The unrelated control register bits must be preserved
+	regs[regControl] = 0x80
+
+	if err := drv.Start(30000); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, got := uint64(0x80|ctrlRunning|ctrlReboot|ctrlReload), regs[regControl]; got != exp {
+		t.Fatalf("expected control register to be 0x%x; got 0x%x", exp, got)
+	}
+
+	if exp, got := uint64(30), regs[regCountdown]; got != exp {
+		t.Fatalf("expected countdown register to be %d; got %d", exp, got)
+	}
+
+	if running, err := drv.runAction(table.WDATActionQueryRunningState, 0); err != nil || running != 1 {
+		t.Fatalf("expected the watchdog to be running; got %d (err: %v)", running, err)
+	}
+
+	regs[regCountdown] = 12
+	if count, err := drv.runAction(table.WDATActionQueryCurrentCountdown, 0); err != nil || count != 12 {
+		t.Fatalf("expected the current countdown to be 12; got %d (err: %v)", count, err)
+	}
+
+	if err := drv.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if running, err := drv.runAction(table.WDATActionQueryRunningState, 0); err != nil || running != 0 {
+		t.Fatalf("expected the watchdog to be stopped; got %d (err: %v)", running, err)
+	}
+
+	for specIndex, timeout := range []uint32{1000, 1024000} {
+		if err := drv.Start(timeout); err != errInvalidTimeout {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, errInvalidTimeout, err)
+		}
+	}
+
+	if _, err := drv.runAction(table.WDATActionQueryStatus, 0); err != errUnsupportedAction {
+		t.Fatalf("expected to get error %v; got %v", errUnsupportedAction, err)
+	}
+
+	expErr := &kernel.Error{Module: "test", Message: "register access failed"}
+	readGenericAddressFn = func(table.GenericAddress) (uint64, *kernel.Error) { return 0, expErr }
+	if err := drv.Start(30000); err != expErr {
+		t.Fatalf("expected to get error %v; got %v", expErr, err)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	wdatFor := func(numEntries uint8) *table.SDTHeader {
+		data := append([]byte("WDAT"), make([]byte, 64)...)
+		data[4] = byte(len(data))
+		data[64] = numEntries
+		return (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	}
+
+	specs := []struct {
+		header    *table.SDTHeader
+		expDriver bool
+	}{
+		{nil, false},
+		{wdatFor(0), true},
+		// Entry count exceeds the table length
+		{wdatFor(1), false},
+	}
+
+	for specIndex, spec := range specs {
+		lookupTableFn = func(string) *table.SDTHeader { return spec.header }
+
+		if drv := probeForWDAT(); (drv != nil) != spec.expDriver {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDriver, drv)
+		}
+	}
+}
+
+// testInfo returns a WDAT that describes a watchdog with a control register
+// (bit 0: running, bit 1: reboot on expiry, bit 4: reload) and a countdown
+// register with a 1 second period.
+func testInfo() *table.WDATInfo {
+	ctrl := table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 32, AccessSize: 3, Address: regControl}
+	countdown := table.GenericAddress{Space: table.AddressSpaceSysIO, BitWidth: 32, AccessSize: 3, Address: regCountdown}
+	preserve := table.WDATPreserveRegister
+
+	return &table.WDATInfo{
+		TimerPeriod: 1000,
+		MaxCount:    1023,
+		MinCount:    2,
+		Flags:       table.WDATEnabled,
+		Entries: []table.WDATEntry{
+			{Action: table.WDATActionReset, Instruction: table.WDATWriteValue | preserve, Register: ctrl, Value: ctrlReload, Mask: ctrlReload},
+			{Action: table.WDATActionQueryCurrentCountdown, Instruction: table.WDATReadCountdown, Register: countdown, Mask: 0x3ff},
+			{Action: table.WDATActionSetCountdown, Instruction: table.WDATWriteCountdown, Register: countdown, Mask: 0x3ff},
+			{Action: table.WDATActionQueryRunningState, Instruction: table.WDATReadValue, Register: ctrl, Value: ctrlRunning, Mask: ctrlRunning},
+			{Action: table.WDATActionSetRunningState, Instruction: table.WDATWriteValue | preserve, Register: ctrl, Value: ctrlRunning, Mask: ctrlRunning},
+			{Action: table.WDATActionSetStoppedState, Instruction: table.WDATWriteValue | preserve, Register: ctrl, Value: 0, Mask: ctrlRunning},
+			{Action: table.WDATActionSetReboot, Instruction: table.WDATWriteValue | preserve, Register: ctrl, Value: ctrlReboot, Mask: ctrlReboot},
+		},
+	}
+}
+
+// mockRegisters installs register access hooks that redirect to the returned
+// map which is indexed by register address.
+func mockRegisters() map[uint64]uint64 {
+	regs := make(map[uint64]uint64)
+	readGenericAddressFn = func(addr table.GenericAddress) (uint64, *kernel.Error) {
+		return regs[addr.Address], nil
+	}
+	writeGenericAddressFn = func(addr table.GenericAddress, val uint64) *kernel.Error {
+		regs[addr.Address] = val
+		return nil
+	}
+	return regs
+}
+
+func restoreFns() {
+	lookupTableFn = acpi.LookupTable
+	readGenericAddressFn = acpi.ReadGenericAddress
+	writeGenericAddressFn = acpi.WriteGenericAddress
+	registerWatchdogFn = watchdog.Register
+}
@@ -0,0 +1,291 @@
+package dma
+
+import (
+	"gopheros/device/acpi/iommu"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/sync"
+)
+
+var (
+	errNoSegments = &kernel.Error{Module: "dma", Message: "scatter-gather list must contain at least one non-empty segment", Code: kernel.ErrCodeInvalidArgument}
+
+	// The following functions are mocked by tests.
+	attachFn    = attachDevice
+	translateFn = vmm.Translate
+
+	strictLock sync.Spinlock
+	strict     = true
+)
+
+// Direction describes the direction of the data transfers that use a DMA
+// mapping.
+type Direction uint8
+
+// The supported transfer directions.
+const (
+	// ToDevice mappings are only read by the device.
+	ToDevice Direction = iota
+
+	// FromDevice mappings are only written by the device.
+	FromDevice
+
+	// Bidirectional mappings are both read and written by the device.
+	Bidirectional
+)
+
+// permission returns the IOMMU permissions that a device requires for
+// performing transfers in direction dir.
+func (dir Direction) permission() iommu.Permission {
+	switch dir {
+	case ToDevice:
+		return iommu.PermRead
+	case FromDevice:
+		return iommu.PermWrite
+	default:
+		return iommu.PermRead | iommu.PermWrite
+	}
+}
+
+// Segment describes a virtually contiguous region of kernel memory.
+type Segment struct {
+	Addr   uintptr
+	Length uintptr
+}
+
+// BusSegment describes a region of memory as seen by a device.
+type BusSegment struct {
+	BusAddr uint64
+	Length  uintptr
+}
+
+// SGMapping describes a scatter-gather list that has been mapped for DMA via
+// a call to Device.MapSG.
+type SGMapping struct {
+	// Segments contains the bus addresses that the device must use.
+	// Physically contiguous parts of the input segments are coalesced
+	// into a single bus segment.
+	Segments []BusSegment
+
+	dir   Direction
+	input []Segment
+	pages []uint64
+}
+
+// SetStrict controls how devices registered via NewDevice are configured
+// when a DMA remapping unit is present. In strict mode (the default), each
+// device is attached to its own IOMMU domain and DMA requests that target
+// memory which has not been mapped for the device are blocked and reported
+// as faults by the remapping unit. Otherwise, devices are configured for
+// pass-through DMA and may access all physical memory.
+func SetStrict(enabled bool) {
+	strictLock.Acquire()
+	strict = enabled
+	strictLock.Release()
+}
+
+// Device describes a bus-mastering PCI function. DMA buffers and mappings
+// for the device must be established via its methods so that they are
+// reflected in the device's IOMMU domain.
+type Device struct {
+	// domain is nil if the device is not behind a remapping unit or if it
+	// has been configured for pass-through DMA.
+	domain Domain
+
+	// The number of active mappings and the permissions granted for each
+	// domain page.
+	lock     sync.Spinlock
+	refCount map[uint64]uint32
+	perms    map[uint64]iommu.Permission
+}
+
+// NewDevice prepares the specified PCI function for performing DMA. If a DMA
+// remapping unit handles the function, the function is attached to a new
+// domain (or to the pass-through domain if strict mode is disabled) and
+// remapping is enabled for the unit.
+func NewDevice(segment uint16, bus, dev, fn uint8) (*Device, *kernel.Error) {
+	strictLock.Acquire()
+	useDomain := strict
+	strictLock.Release()
+
+	domain, err := attachFn(segment, bus, dev, fn, useDomain)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Device{
+		domain:   domain,
+		refCount: make(map[uint64]uint32),
+		perms:    make(map[uint64]iommu.Permission),
+	}, nil
+}
+
+// attachDevice implements the attachment logic for NewDevice. It returns a
+// nil Domain if the function is not translated.
+func attachDevice(segment uint16, bus, dev, fn uint8, useDomain bool) (Domain, *kernel.Error) {
+	unit := iommu.UnitFor(segment, bus, dev, fn)
+	if unit == nil {
+		return nil, nil
+	}
+
+	if !useDomain {
+		return nil, unit.AttachPassThrough(bus, dev, fn)
+	}
+
+	domain, err := unit.NewDomain()
+	if err != nil {
+		return nil, err
+	}
+
+	if err = unit.Attach(bus, dev, fn, domain); err != nil {
+		return nil, err
+	}
+
+	if !unit.Enabled() {
+		if err = unit.Enable(); err != nil {
+			return nil, err
+		}
+	}
+
+	return domain, nil
+}
+
+// Translated returns true if the DMA requests of the device are translated
+// by an IOMMU domain.
+func (d *Device) Translated() bool {
+	return d.domain != nil
+}
+
+// Alloc allocates a DMA buffer via the package-level Alloc function and maps
+// it into the device's IOMMU domain.
+func (d *Device) Alloc(size uintptr, constraints Constraints) (*Buffer, *kernel.Error) {
+	if d.domain != nil {
+		constraints.Domain = d
+	}
+
+	return Alloc(size, constraints)
+}
+
+// Map implements Domain. It establishes a translation for iova in the
+// device's domain or, if iova is already mapped, adds perm to the permissions
+// of the existing translation.
+func (d *Device) Map(iova uint64, frame mm.Frame, perm iommu.Permission) *kernel.Error {
+	d.lock.Acquire()
+	defer d.lock.Release()
+
+	if granted := d.perms[iova]; d.refCount[iova] == 0 || granted|perm != granted {
+		if err := d.domain.Map(iova, frame, d.perms[iova]|perm); err != nil {
+			return err
+		}
+		d.perms[iova] |= perm
+	}
+
+	d.refCount[iova]++
+	return nil
+}
+
+// Unmap implements Domain. It releases a translation established via Map and
+// removes it from the device's domain once it is no longer referenced.
+func (d *Device) Unmap(iova uint64) *kernel.Error {
+	d.lock.Acquire()
+	defer d.lock.Release()
+
+	if d.refCount[iova] == 0 {
+		return nil
+	}
+
+	if d.refCount[iova]--; d.refCount[iova] != 0 {
+		return nil
+	}
+
+	delete(d.refCount, iova)
+	delete(d.perms, iova)
+	return d.domain.Unmap(iova)
+}
+
+// MapSG maps a list of kernel memory segments for DMA transfers in the
+// specified direction and returns the bus segments that the device must use.
+// For ToDevice and Bidirectional transfers, the segment contents are flushed
+// from the CPU caches. Mappings must be released via a call to UnmapSG once
+// the transfer completes.
+func (d *Device) MapSG(segments []Segment, dir Direction) (*SGMapping, *kernel.Error) {
+	mapping := &SGMapping{dir: dir, input: segments}
+
+	for _, seg := range segments {
+		for offset := uintptr(0); offset < seg.Length; {
+			virtAddr := seg.Addr + offset
+			physAddr, err := translateFn(virtAddr)
+			if err != nil {
+				_ = d.unmapPages(mapping)
+				return nil, err
+			}
+
+			chunk := mm.PageSize - vmm.PageOffset(virtAddr)
+			if chunk > seg.Length-offset {
+				chunk = seg.Length - offset
+			}
+
+			if d.domain != nil {
+				iova := uint64(physAddr) &^ uint64(mm.PageSize-1)
+				if err = d.Map(iova, mm.FrameFromAddress(physAddr), dir.permission()); err != nil {
+					_ = d.unmapPages(mapping)
+					return nil, err
+				}
+				mapping.pages = append(mapping.pages, iova)
+			}
+
+			mapping.addBusRange(uint64(physAddr), chunk)
+			offset += chunk
+		}
+	}
+
+	if len(mapping.Segments) == 0 {
+		return nil, errNoSegments
+	}
+
+	if dir != FromDevice {
+		for _, seg := range segments {
+			syncRange(seg.Addr, seg.Length)
+		}
+	}
+
+	return mapping, nil
+}
+
+// UnmapSG releases a mapping established via MapSG. For FromDevice and
+// Bidirectional transfers, any stale cached copies of the segment contents
+// are discarded so that the CPU observes the data written by the device.
+func (d *Device) UnmapSG(mapping *SGMapping) *kernel.Error {
+	if mapping.dir != ToDevice {
+		for _, seg := range mapping.input {
+			syncRange(seg.Addr, seg.Length)
+		}
+	}
+
+	return d.unmapPages(mapping)
+}
+
+// unmapPages releases the domain pages referenced by mapping.
+func (d *Device) unmapPages(mapping *SGMapping) *kernel.Error {
+	var firstErr *kernel.Error
+	for _, iova := range mapping.pages {
+		if err := d.Unmap(iova); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	mapping.pages = nil
+	return firstErr
+}
+
+// addBusRange appends the specified bus address range to the mapping
+// segments, extending the last segment if the range is contiguous to it.
+func (m *SGMapping) addBusRange(busAddr uint64, length uintptr) {
+	if last := len(m.Segments) - 1; last >= 0 && m.Segments[last].BusAddr+uint64(m.Segments[last].Length) == busAddr {
+		m.Segments[last].Length += length
+		return
+	}
+
+	m.Segments = append(m.Segments, BusSegment{BusAddr: busAddr, Length: length})
+}
@@ -0,0 +1,252 @@
+package dma
+
+import (
+	"gopheros/device/acpi/iommu"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/pmm"
+	"gopheros/kernel/mm/vmm"
+	"reflect"
+	"testing"
+)
+
+type permDomain struct {
+	perms   map[uint64]iommu.Permission
+	mapErr  *kernel.Error
+	mapHits int
+}
+
+func (d *permDomain) Map(iova uint64, _ mm.Frame, perm iommu.Permission) *kernel.Error {
+	if d.mapErr != nil {
+		return d.mapErr
+	}
+	d.mapHits++
+	d.perms[iova] = perm
+	return nil
+}
+
+func (d *permDomain) Unmap(iova uint64) *kernel.Error {
+	delete(d.perms, iova)
+	return nil
+}
+
+func TestNewDevice(t *testing.T) {
+	defer func() {
+		attachFn = attachDevice
+		SetStrict(true)
+	}()
+
+	var gotStrict []bool
+	domain := &permDomain{perms: make(map[uint64]iommu.Permission)}
+	attachFn = func(_ uint16, _, _, _ uint8, useDomain bool) (Domain, *kernel.Error) {
+		gotStrict = append(gotStrict, useDomain)
+		if useDomain {
+			return domain, nil
+		}
+		return nil, nil
+	}
+
+	dev, err := NewDevice(0, 0, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !dev.Translated() {
+		t.Fatal("expected device to be translated in strict mode")
+	}
+
+	SetStrict(false)
+	if dev, err = NewDevice(0, 0, 2, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if dev.Translated() {
+		t.Fatal("expected device not to be translated when strict mode is disabled")
+	}
+
+	if exp := []bool{true, false}; !reflect.DeepEqual(gotStrict, exp) {
+		t.Fatalf("expected attach calls with strict mode %v; got %v", exp, gotStrict)
+	}
+
+	expErr := &kernel.Error{Module: "test", Message: "attach failed"}
+	attachFn = func(uint16, uint8, uint8, uint8, bool) (Domain, *kernel.Error) { return nil, expErr }
+	if _, err = NewDevice(0, 0, 2, 0); err != expErr {
+		t.Fatalf("expected to get error %v; got %v", expErr, err)
+	}
+}
+
+func TestDeviceDomainRefCounting(t *testing.T) {
+	domain := &permDomain{perms: make(map[uint64]iommu.Permission)}
+	dev := &Device{domain: domain, refCount: make(map[uint64]uint32), perms: make(map[uint64]iommu.Permission)}
+
+	// Additional mappings of the same page extend its permissions
+	for _, perm := range []iommu.Permission{iommu.PermRead, iommu.PermRead, iommu.PermWrite} {
+		if err := dev.Map(0x1000, 1, perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if domain.mapHits != 2 || domain.perms[0x1000] != iommu.PermRead|iommu.PermWrite {
+		t.Fatalf("expected page to be mapped twice with RW permissions; got %d mappings with permissions %d", domain.mapHits, domain.perms[0x1000])
+	}
+
+	// The page remains mapped until all references are released
+	for i := 0; i < 3; i++ {
+		if _, mapped := domain.perms[0x1000]; !mapped {
+			t.Fatalf("expected page to remain mapped after %d unmap calls", i)
+		}
+
+		if err := dev.Unmap(0x1000); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, mapped := domain.perms[0x1000]; mapped || len(dev.refCount) != 0 {
+		t.Fatal("expected page to be unmapped once all references are released")
+	}
+
+	// Unmapping a page that is not mapped is a no-op
+	if err := dev.Unmap(0x2000); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDeviceSG(t *testing.T) {
+	defer func(origFlush func(uintptr), origFence func()) {
+		flushCacheLineFn, memoryFenceFn = origFlush, origFence
+		translateFn = vmm.Translate
+	}(flushCacheLineFn, memoryFenceFn)
+
+	var flushes int
+	flushCacheLineFn = func(uintptr) { flushes++ }
+	memoryFenceFn = func() {}
+
+	// Virtual pages 0x10-0x12 map to physical pages 0x80, 0x81 and 0x90
+	translateFn = func(virtAddr uintptr) (uintptr, *kernel.Error) {
+		pages := map[uintptr]uintptr{0x10000: 0x80000, 0x11000: 0x81000, 0x12000: 0x90000}
+		physPage, ok := pages[virtAddr&^(mm.PageSize-1)]
+		if !ok {
+			return 0, vmm.ErrInvalidMapping
+		}
+		return physPage + vmm.PageOffset(virtAddr), nil
+	}
+
+	segments := []Segment{
+		{Addr: 0x10800, Length: 0x2000},
+		{Addr: 0x12100, Length: 0x40},
+	}
+	expBusSegments := []BusSegment{
+		{BusAddr: 0x80800, Length: 0x1800},
+		{BusAddr: 0x90000, Length: 0x800},
+		{BusAddr: 0x90100, Length: 0x40},
+	}
+
+	t.Run("translated", func(t *testing.T) {
+		domain := &permDomain{perms: make(map[uint64]iommu.Permission)}
+		dev := &Device{domain: domain, refCount: make(map[uint64]uint32), perms: make(map[uint64]iommu.Permission)}
+
+		flushes = 0
+		mapping, err := dev.MapSG(segments, ToDevice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(mapping.Segments, expBusSegments) {
+			t.Fatalf("expected bus segments %v; got %v", expBusSegments, mapping.Segments)
+		}
+
+		expPerms := map[uint64]iommu.Permission{0x80000: iommu.PermRead, 0x81000: iommu.PermRead, 0x90000: iommu.PermRead}
+		if !reflect.DeepEqual(domain.perms, expPerms) {
+			t.Fatalf("expected domain mappings %v; got %v", expPerms, domain.perms)
+		}
+
+		if expFlushes := 0x2000/cacheLineSize + 1; flushes != expFlushes {
+			t.Fatalf("expected %d cache line flushes; got %d", expFlushes, flushes)
+		}
+
+		flushes = 0
+		if err = dev.UnmapSG(mapping); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(domain.perms) != 0 || flushes != 0 {
+			t.Fatalf("expected all pages to be unmapped without flushing the caches; got %d mapped pages and %d flushes", len(domain.perms), flushes)
+		}
+	})
+
+	t.Run("pass-through", func(t *testing.T) {
+		dev := &Device{refCount: make(map[uint64]uint32), perms: make(map[uint64]iommu.Permission)}
+
+		flushes = 0
+		mapping, err := dev.MapSG(segments, FromDevice)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(mapping.Segments, expBusSegments) || flushes != 0 {
+			t.Fatalf("expected bus segments %v without cache flushes; got %v and %d flushes", expBusSegments, mapping.Segments, flushes)
+		}
+
+		if err = dev.UnmapSG(mapping); err != nil {
+			t.Fatal(err)
+		}
+
+		if flushes == 0 {
+			t.Fatal("expected the segment contents to be flushed from the caches")
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		domain := &permDomain{perms: make(map[uint64]iommu.Permission)}
+		dev := &Device{domain: domain, refCount: make(map[uint64]uint32), perms: make(map[uint64]iommu.Permission)}
+
+		if _, err := dev.MapSG(nil, Bidirectional); err != errNoSegments {
+			t.Errorf("expected to get error %v; got %v", errNoSegments, err)
+		}
+
+		// Partially mapped lists are released on failure
+		if _, err := dev.MapSG([]Segment{{Addr: 0x11000, Length: 0x3000}}, Bidirectional); err != vmm.ErrInvalidMapping {
+			t.Errorf("expected to get error %v; got %v", vmm.ErrInvalidMapping, err)
+		}
+
+		if len(domain.perms) != 0 || len(dev.refCount) != 0 {
+			t.Errorf("expected partially mapped pages to be released; got %d mapped pages", len(domain.perms))
+		}
+
+		domain.mapErr = &kernel.Error{Module: "test", Message: "map failed"}
+		if _, err := dev.MapSG(segments, Bidirectional); err != domain.mapErr {
+			t.Errorf("expected to get error %v; got %v", domain.mapErr, err)
+		}
+	})
+}
+
+func TestDeviceAlloc(t *testing.T) {
+	defer resetHooks()
+
+	allocFramesFn = func(pmm.Zone, uint8) (mm.Frame, *kernel.Error) { return mm.Frame(0x40), nil }
+	mapFramesFn = func(mm.Frame, uintptr, vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.Page(0x1000), nil
+	}
+	freeFramesFn = func(mm.Frame, uint8) *kernel.Error { return nil }
+	freeRegionFn = func(mm.Page) *kernel.Error { return nil }
+
+	domain := &permDomain{perms: make(map[uint64]iommu.Permission)}
+	dev := &Device{domain: domain, refCount: make(map[uint64]uint32), perms: make(map[uint64]iommu.Permission)}
+
+	buf, err := dev.Alloc(mm.PageSize, Constraints{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if domain.perms[buf.BusAddr] != iommu.PermRead|iommu.PermWrite || dev.refCount[buf.BusAddr] != 1 {
+		t.Fatal("expected buffer to be mapped via the device domain")
+	}
+
+	if err = buf.Free(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(domain.perms) != 0 {
+		t.Fatal("expected buffer to be unmapped from the device domain")
+	}
+}
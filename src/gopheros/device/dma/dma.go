@@ -0,0 +1,297 @@
+// Package dma provides an API for allocating buffers that are shared between
+// the CPU and bus-mastering devices. Each buffer is backed by a physically
+// contiguous block of frames that satisfies the addressing and alignment
+// constraints of the device and is mapped into the kernel address space.
+// Buffers can optionally be mapped into an IOMMU domain so that devices
+// attached to it can access them.
+package dma
+
+import (
+	"gopheros/device/acpi/iommu"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/faultinj"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/pmm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/sync"
+)
+
+// cacheLineSize is the granularity used for flushing buffer contents from the
+// CPU caches.
+const cacheLineSize = 64
+
+var (
+	errInvalidSize      = &kernel.Error{Module: "dma", Message: "buffer size must be non-zero and not exceed the max allocation size", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidAlignment = &kernel.Error{Module: "dma", Message: "buffer alignment and boundary must be powers of 2", Code: kernel.ErrCodeInvalidArgument}
+	errBoundaryTooSmall = &kernel.Error{Module: "dma", Message: "buffer does not fit within the requested boundary", Code: kernel.ErrCodeInvalidArgument}
+	errAddressLimit     = &kernel.Error{Module: "dma", Message: "no memory available below the device address limit", Code: kernel.ErrCodeOutOfMemory}
+	errOutOfMemory      = &kernel.Error{Module: "dma", Message: "out of memory", Code: kernel.ErrCodeOutOfMemory}
+
+	// allocFault allows tests to simulate buffer allocation failures.
+	allocFault = faultinj.NewSite("dma/alloc")
+
+	// The following functions are mocked by tests.
+	allocFramesFn    = pmm.AllocFramesInZone
+	freeFramesFn     = pmm.FreeFrames
+	mapFramesFn      = vmm.MapFrames
+	freeRegionFn     = vmm.FreeRegion
+	flushCacheLineFn = cpu.FlushCacheLine
+	memoryFenceFn    = cpu.MemoryFence
+
+	coherentLock sync.Spinlock
+	coherent     bool
+)
+
+// Domain is implemented by IOMMU translation domains (e.g. *iommu.Domain).
+type Domain interface {
+	// Map establishes a translation from the page-aligned I/O virtual
+	// address iova to frame.
+	Map(iova uint64, frame mm.Frame, perm iommu.Permission) *kernel.Error
+
+	// Unmap removes the translation for the page-aligned I/O virtual
+	// address iova.
+	Unmap(iova uint64) *kernel.Error
+}
+
+// Constraints describes the requirements that a device imposes on the
+// buffers it accesses. The zero value requests a page-aligned buffer that
+// can be located anywhere in physical memory.
+type Constraints struct {
+	// AddressBits is the number of address bits that the device can
+	// drive. The bus address of each byte in the buffer is guaranteed to
+	// fit in AddressBits bits. A zero value is equivalent to 64.
+	AddressBits uint8
+
+	// Align specifies the alignment of the buffer bus address. It must be
+	// a power of 2; values smaller than mm.PageSize are rounded up.
+	Align uintptr
+
+	// Boundary, if non-zero, is a power of 2 address boundary that the
+	// buffer must not cross.
+	Boundary uintptr
+
+	// Domain, if not nil, is the IOMMU domain of the device. The buffer
+	// is mapped into the domain using an I/O virtual address that is
+	// equal to its physical address.
+	Domain Domain
+
+	// Uncached requests the buffer to be mapped with caching disabled.
+	// This is useful for small descriptor rings that are frequently
+	// accessed by both the CPU and the device.
+	Uncached bool
+}
+
+// Buffer describes a DMA buffer allocated via a call to Alloc.
+type Buffer struct {
+	// Addr is the kernel virtual address of the buffer.
+	Addr uintptr
+
+	// PhysAddr is the physical address of the buffer.
+	PhysAddr uintptr
+
+	// BusAddr is the address that devices must use to access the
+	// buffer. If the buffer is mapped into an IOMMU domain, it is the I/O
+	// virtual address of the buffer; otherwise it matches PhysAddr.
+	BusAddr uint64
+
+	// Size is the requested buffer size.
+	Size uintptr
+
+	frame    mm.Frame
+	order    uint8
+	domain   Domain
+	uncached bool
+}
+
+// SetCoherent specifies whether all bus-mastering devices snoop the CPU
+// caches. If set, the buffer synchronization methods only need to order
+// memory accesses instead of flushing the cached buffer contents.
+func SetCoherent(snooped bool) {
+	coherentLock.Acquire()
+	coherent = snooped
+	coherentLock.Release()
+}
+
+// Alloc allocates a physically contiguous buffer of the requested size that
+// satisfies the supplied constraints and maps it into the kernel address
+// space. Buffers must be released via a call to Free.
+func Alloc(size uintptr, constraints Constraints) (*Buffer, *kernel.Error) {
+	align := constraints.Align
+	if align < mm.PageSize {
+		align = mm.PageSize
+	}
+
+	if !isPowerOf2(align) || (constraints.Boundary != 0 && !isPowerOf2(constraints.Boundary)) {
+		return nil, errInvalidAlignment
+	}
+
+	// Blocks returned by the frame allocator are aligned to their size so
+	// the block size must also satisfy the requested alignment.
+	order := uint8(0)
+	for blockSize := mm.PageSize; blockSize < size || blockSize < align; blockSize <<= 1 {
+		order++
+	}
+
+	if size == 0 || order > pmm.MaxOrder {
+		return nil, errInvalidSize
+	}
+
+	blockSize := mm.PageSize << order
+	if constraints.Boundary != 0 && blockSize > constraints.Boundary {
+		return nil, errBoundaryTooSmall
+	}
+
+	if allocFault.ShouldFail() {
+		return nil, errOutOfMemory
+	}
+
+	frame, err := allocBelow(constraints.AddressBits, order)
+	if err != nil {
+		return nil, err
+	}
+
+	flags := vmm.FlagRW | vmm.FlagNoExecute
+	if constraints.Uncached {
+		flags |= vmm.FlagDoNotCache | vmm.FlagWriteThroughCaching
+	}
+
+	page, err := mapFramesFn(frame, size, flags)
+	if err != nil {
+		_ = freeFramesFn(frame, order)
+		return nil, err
+	}
+
+	buf := &Buffer{
+		Addr:     page.Address(),
+		PhysAddr: frame.Address(),
+		BusAddr:  uint64(frame.Address()),
+		Size:     size,
+		frame:    frame,
+		order:    order,
+		uncached: constraints.Uncached,
+	}
+
+	if constraints.Domain != nil {
+		for offset := uintptr(0); offset < size; offset += mm.PageSize {
+			if err = constraints.Domain.Map(buf.BusAddr+uint64(offset), frame+mm.Frame(offset>>mm.PageShift), iommu.PermRead|iommu.PermWrite); err != nil {
+				buf.unmapDomainPages(constraints.Domain, offset)
+				_ = freeRegionFn(page)
+				_ = freeFramesFn(frame, order)
+				return nil, err
+			}
+		}
+		buf.domain = constraints.Domain
+	}
+
+	return buf, nil
+}
+
+// Free unmaps the buffer and releases its frames. The caller must ensure that
+// the device no longer accesses the buffer.
+func (b *Buffer) Free() *kernel.Error {
+	if b.domain != nil {
+		b.unmapDomainPages(b.domain, b.Size)
+	}
+
+	if err := freeRegionFn(mm.PageFromAddress(b.Addr)); err != nil {
+		return err
+	}
+
+	return freeFramesFn(b.frame, b.order)
+}
+
+// SyncForDevice makes the CPU writes to the length bytes of the buffer that
+// start at offset visible to the device. It must be invoked before handing
+// the buffer contents over to the device.
+func (b *Buffer) SyncForDevice(offset, length uintptr) {
+	b.sync(offset, length)
+}
+
+// SyncForCPU discards any stale cached copies of the length bytes of the
+// buffer that start at offset. It must be invoked after the device has
+// written to the buffer and before the CPU reads its contents.
+func (b *Buffer) SyncForCPU(offset, length uintptr) {
+	b.sync(offset, length)
+}
+
+// sync synchronizes the specified buffer range via syncRange. Uncached
+// buffers only require memory accesses to be ordered.
+func (b *Buffer) sync(offset, length uintptr) {
+	if b.uncached || offset >= b.Size {
+		memoryFenceFn()
+		return
+	}
+
+	if length > b.Size-offset {
+		length = b.Size - offset
+	}
+
+	syncRange(b.Addr+offset, length)
+}
+
+// syncRange flushes the cache lines that overlap the virtual address range
+// [addr, addr+length) unless devices snoop the CPU caches and then orders the
+// memory accesses performed before the call.
+func syncRange(addr, length uintptr) {
+	coherentLock.Acquire()
+	snooped := coherent
+	coherentLock.Release()
+
+	if !snooped {
+		for line := addr &^ (cacheLineSize - 1); line < addr+length; line += cacheLineSize {
+			flushCacheLineFn(line)
+		}
+	}
+
+	memoryFenceFn()
+}
+
+// unmapDomainPages removes the IOMMU translations for the buffer pages that
+// overlap the first size bytes of the buffer.
+func (b *Buffer) unmapDomainPages(domain Domain, size uintptr) {
+	for offset := uintptr(0); offset < size; offset += mm.PageSize {
+		_ = domain.Unmap(uint64(b.PhysAddr + offset))
+	}
+}
+
+// allocBelow allocates a block of 1 << order frames whose addresses fit in
+// addressBits bits. As zone boundaries do not necessarily match the address
+// limit, blocks that exceed it are released and the allocation is retried in
+// the zones below.
+func allocBelow(addressBits uint8, order uint8) (mm.Frame, *kernel.Error) {
+	var (
+		zone    = pmm.ZoneNormal
+		maxAddr = ^uint64(0)
+	)
+
+	if addressBits != 0 && addressBits < 64 {
+		maxAddr = (uint64(1) << addressBits) - 1
+		switch {
+		case maxAddr < 16<<20:
+			zone = pmm.ZoneDMA
+		case maxAddr < 4<<30:
+			zone = pmm.ZoneDMA32
+		}
+	}
+
+	for ; ; zone-- {
+		frame, err := allocFramesFn(zone, order)
+		if err != nil {
+			return mm.InvalidFrame, err
+		}
+
+		if uint64(frame.Address())+uint64(mm.PageSize<<order)-1 <= maxAddr {
+			return frame, nil
+		}
+
+		_ = freeFramesFn(frame, order)
+		if zone == pmm.ZoneDMA {
+			return mm.InvalidFrame, errAddressLimit
+		}
+	}
+}
+
+func isPowerOf2(val uintptr) bool {
+	return val&(val-1) == 0
+}
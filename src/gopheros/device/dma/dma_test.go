@@ -0,0 +1,284 @@
+package dma
+
+import (
+	"gopheros/device/acpi/iommu"
+	"gopheros/kernel"
+	"gopheros/kernel/faultinj"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/pmm"
+	"gopheros/kernel/mm/vmm"
+	"reflect"
+	"testing"
+)
+
+type fakeDomain struct {
+	mappings map[uint64]mm.Frame
+	failAt   int
+}
+
+func (d *fakeDomain) Map(iova uint64, frame mm.Frame, perm iommu.Permission) *kernel.Error {
+	if len(d.mappings) == d.failAt {
+		return &kernel.Error{Module: "test", Message: "map failed"}
+	}
+	d.mappings[iova] = frame
+	return nil
+}
+
+func (d *fakeDomain) Unmap(iova uint64) *kernel.Error {
+	delete(d.mappings, iova)
+	return nil
+}
+
+func resetHooks() {
+	allocFramesFn = pmm.AllocFramesInZone
+	freeFramesFn = pmm.FreeFrames
+	mapFramesFn = vmm.MapFrames
+	freeRegionFn = vmm.FreeRegion
+	coherent = false
+}
+
+func TestAlloc(t *testing.T) {
+	defer resetHooks()
+
+	var (
+		allocZones  []pmm.Zone
+		allocFrames []mm.Frame
+		freed       []mm.Frame
+		mapFlags    vmm.PageTableEntryFlag
+		mapSize     uintptr
+	)
+
+	allocFramesFn = func(zone pmm.Zone, order uint8) (mm.Frame, *kernel.Error) {
+		allocZones = append(allocZones, zone)
+		frame := allocFrames[0]
+		allocFrames = allocFrames[1:]
+		return frame, nil
+	}
+	freeFramesFn = func(frame mm.Frame, _ uint8) *kernel.Error {
+		freed = append(freed, frame)
+		return nil
+	}
+	mapFramesFn = func(frame mm.Frame, size uintptr, flags vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		mapSize, mapFlags = size, flags
+		return mm.Page(0x1000), nil
+	}
+	freeRegionFn = func(mm.Page) *kernel.Error { return nil }
+
+	specs := []struct {
+		size        uintptr
+		constraints Constraints
+		frames      []mm.Frame
+		expErr      *kernel.Error
+		expZones    []pmm.Zone
+		expOrder    uint8
+		expFreed    []mm.Frame
+	}{
+		{0, Constraints{}, nil, errInvalidSize, nil, 0, nil},
+		{mm.PageSize << (pmm.MaxOrder + 1), Constraints{}, nil, errInvalidSize, nil, 0, nil},
+		{mm.PageSize, Constraints{Align: 3 * mm.PageSize}, nil, errInvalidAlignment, nil, 0, nil},
+		{mm.PageSize, Constraints{Boundary: 3 * mm.PageSize}, nil, errInvalidAlignment, nil, 0, nil},
+		{4 * mm.PageSize, Constraints{Boundary: 2 * mm.PageSize}, nil, errBoundaryTooSmall, nil, 0, nil},
+		{100, Constraints{}, []mm.Frame{0x100000}, nil, []pmm.Zone{pmm.ZoneNormal}, 0, nil},
+		{3 * mm.PageSize, Constraints{AddressBits: 32}, []mm.Frame{0x1000}, nil, []pmm.Zone{pmm.ZoneDMA32}, 2, nil},
+		{mm.PageSize, Constraints{AddressBits: 24, Align: 4 * mm.PageSize}, []mm.Frame{0x100}, nil, []pmm.Zone{pmm.ZoneDMA}, 2, nil},
+		// 31-bit devices: blocks above 2G are rejected and the
+		// allocation is retried in the zone below
+		{mm.PageSize, Constraints{AddressBits: 31}, []mm.Frame{0x80000, 0x10}, nil, []pmm.Zone{pmm.ZoneDMA32, pmm.ZoneDMA}, 0, []mm.Frame{0x80000}},
+		{mm.PageSize, Constraints{AddressBits: 20}, []mm.Frame{0x100}, errAddressLimit, []pmm.Zone{pmm.ZoneDMA}, 0, []mm.Frame{0x100}},
+	}
+
+	for specIndex, spec := range specs {
+		allocZones, allocFrames, freed = nil, spec.frames, nil
+
+		buf, err := Alloc(spec.size, spec.constraints)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(allocZones, spec.expZones) || !reflect.DeepEqual(freed, spec.expFreed) {
+			t.Errorf("[spec %d] expected allocations from zones %v releasing %v; got %v releasing %v", specIndex, spec.expZones, spec.expFreed, allocZones, freed)
+		}
+
+		if err != nil {
+			continue
+		}
+
+		expFrame := spec.frames[len(spec.frames)-1]
+		if buf.PhysAddr != expFrame.Address() || buf.BusAddr != uint64(expFrame.Address()) || buf.Addr != 0x1000<<mm.PageShift || buf.order != spec.expOrder {
+			t.Errorf("[spec %d] unexpected buffer %+v", specIndex, buf)
+		}
+
+		if mapSize != spec.size || mapFlags != vmm.FlagRW|vmm.FlagNoExecute {
+			t.Errorf("[spec %d] expected buffer to be mapped as RW/NX with size %d; got flags 0x%x and size %d", specIndex, spec.size, mapFlags, mapSize)
+		}
+	}
+
+	t.Run("uncached", func(t *testing.T) {
+		allocFrames = []mm.Frame{0x10}
+		if _, err := Alloc(mm.PageSize, Constraints{Uncached: true}); err != nil {
+			t.Fatal(err)
+		}
+
+		if mapFlags&vmm.FlagDoNotCache == 0 {
+			t.Fatalf("expected buffer to be mapped with caching disabled; got flags 0x%x", mapFlags)
+		}
+	})
+
+	t.Run("map error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapFramesFn = func(mm.Frame, uintptr, vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			return 0, expErr
+		}
+		defer func() { mapFramesFn = vmm.MapFrames }()
+
+		allocFrames, freed = []mm.Frame{0x10}, nil
+		if _, err := Alloc(mm.PageSize, Constraints{}); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if !reflect.DeepEqual(freed, []mm.Frame{0x10}) {
+			t.Fatalf("expected frames to be released; got %v", freed)
+		}
+	})
+}
+
+func TestAllocFaultInjection(t *testing.T) {
+	defer func() {
+		_ = faultinj.Configure(allocFault.Name(), faultinj.ModeOff, 0)
+	}()
+	defer resetHooks()
+
+	var allocCount int
+	allocFramesFn = func(pmm.Zone, uint8) (mm.Frame, *kernel.Error) {
+		allocCount++
+		return mm.Frame(0x10), nil
+	}
+	freeFramesFn = func(mm.Frame, uint8) *kernel.Error { return nil }
+	mapFramesFn = func(mm.Frame, uintptr, vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.Page(0x1000), nil
+	}
+
+	if err := faultinj.Configure(allocFault.Name(), faultinj.ModeEveryNth, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expErr := range []*kernel.Error{nil, errOutOfMemory, nil, errOutOfMemory} {
+		if _, err := Alloc(mm.PageSize, Constraints{}); err != expErr {
+			t.Errorf("[call %d] expected error %v; got %v", i, expErr, err)
+		}
+	}
+
+	if allocCount != 2 {
+		t.Fatalf("expected injected failures not to allocate any frames; got %d allocations", allocCount)
+	}
+
+	// Invalid requests are rejected before reaching the injection site
+	if _, err := Alloc(0, Constraints{}); err != errInvalidSize {
+		t.Fatalf("expected to get error %v; got %v", errInvalidSize, err)
+	}
+}
+
+func TestAllocWithDomain(t *testing.T) {
+	defer resetHooks()
+
+	var (
+		freedFrames  int
+		freedRegions int
+		domain       = &fakeDomain{mappings: make(map[uint64]mm.Frame), failAt: -1}
+	)
+
+	allocFramesFn = func(pmm.Zone, uint8) (mm.Frame, *kernel.Error) { return mm.Frame(0x40), nil }
+	freeFramesFn = func(mm.Frame, uint8) *kernel.Error {
+		freedFrames++
+		return nil
+	}
+	mapFramesFn = func(mm.Frame, uintptr, vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.Page(0x1000), nil
+	}
+	freeRegionFn = func(mm.Page) *kernel.Error {
+		freedRegions++
+		return nil
+	}
+
+	buf, err := Alloc(2*mm.PageSize+1, Constraints{Domain: domain})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expMappings := map[uint64]mm.Frame{0x40000: 0x40, 0x41000: 0x41, 0x42000: 0x42}
+	if !reflect.DeepEqual(domain.mappings, expMappings) {
+		t.Fatalf("expected domain mappings %v; got %v", expMappings, domain.mappings)
+	}
+
+	if err = buf.Free(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(domain.mappings) != 0 || freedFrames != 1 || freedRegions != 1 {
+		t.Fatalf("expected buffer to be unmapped and released; got %d domain mappings, %d released frames and %d released regions", len(domain.mappings), freedFrames, freedRegions)
+	}
+
+	// Partially established domain mappings are removed on failure
+	domain.failAt = 2
+	freedFrames, freedRegions = 0, 0
+	if _, err = Alloc(4*mm.PageSize, Constraints{Domain: domain}); err == nil {
+		t.Fatal("expected Alloc to fail")
+	}
+
+	if len(domain.mappings) != 0 || freedFrames != 1 || freedRegions != 1 {
+		t.Fatalf("expected buffer to be unmapped and released; got %d domain mappings, %d released frames and %d released regions", len(domain.mappings), freedFrames, freedRegions)
+	}
+}
+
+func TestSync(t *testing.T) {
+	defer func(origFlush func(uintptr), origFence func()) {
+		flushCacheLineFn, memoryFenceFn = origFlush, origFence
+		resetHooks()
+	}(flushCacheLineFn, memoryFenceFn)
+
+	var (
+		flushed []uintptr
+		fences  int
+	)
+
+	flushCacheLineFn = func(addr uintptr) { flushed = append(flushed, addr) }
+	memoryFenceFn = func() { fences++ }
+
+	buf := &Buffer{Addr: 0x10000, Size: 200}
+
+	specs := []struct {
+		coherent   bool
+		uncached   bool
+		offset     uintptr
+		length     uintptr
+		expFlushed []uintptr
+	}{
+		{false, false, 0, 200, []uintptr{0x10000, 0x10040, 0x10080, 0x100c0}},
+		{false, false, 70, 10, []uintptr{0x10040}},
+		{false, false, 100, 1000, []uintptr{0x10040, 0x10080, 0x100c0}},
+		{false, false, 300, 10, nil},
+		{false, true, 0, 200, nil},
+		{true, false, 0, 200, nil},
+	}
+
+	for specIndex, spec := range specs {
+		flushed, fences = nil, 0
+		SetCoherent(spec.coherent)
+		buf.uncached = spec.uncached
+
+		if specIndex%2 == 0 {
+			buf.SyncForDevice(spec.offset, spec.length)
+		} else {
+			buf.SyncForCPU(spec.offset, spec.length)
+		}
+
+		if !reflect.DeepEqual(flushed, spec.expFlushed) {
+			t.Errorf("[spec %d] expected flushed cache lines %v; got %v", specIndex, spec.expFlushed, flushed)
+		}
+
+		if fences != 1 {
+			t.Errorf("[spec %d] expected a memory fence to be issued", specIndex)
+		}
+	}
+}
@@ -0,0 +1,16 @@
+// Package input defines the interface implemented by the drivers for input
+// devices that can drive the kernel console.
+package input
+
+import (
+	"gopheros/device"
+	"io"
+)
+
+// Device is implemented by input device drivers. Reading from a Device blocks
+// until input is available and returns the UTF-8 encoded characters typed by
+// the user.
+type Device interface {
+	device.Driver
+	io.Reader
+}
@@ -0,0 +1,223 @@
+package keymap
+
+// builtinKeymaps contains the definitions of the keymaps that are compiled
+// into the kernel. The US keymap must come first as it is used as the base
+// for the other keymaps and as the default active keymap.
+var builtinKeymaps = []string{usKeymap, deKeymap, frKeymap, dvorakKeymap}
+
+const usKeymap = `
+name us
+0x01 U+001B U+001B
+0x02 1 !
+0x03 2 @
+0x04 3 #
+0x05 4 $
+0x06 5 %
+0x07 6 ^
+0x08 7 &
+0x09 8 *
+0x0a 9 (
+0x0b 0 )
+0x0c - _
+0x0d = +
+0x0e U+0008 U+0008
+0x0f U+0009 U+0009
+0x10 q Q
+0x11 w W
+0x12 e E
+0x13 r R
+0x14 t T
+0x15 y Y
+0x16 u U
+0x17 i I
+0x18 o O
+0x19 p P
+0x1a [ {
+0x1b ] }
+0x1c U+000A U+000A
+0x1e a A
+0x1f s S
+0x20 d D
+0x21 f F
+0x22 g G
+0x23 h H
+0x24 j J
+0x25 k K
+0x26 l L
+0x27 ; :
+0x28 ' "
+0x29 ` + "`" + ` ~
+0x2b \ |
+0x2c z Z
+0x2d x X
+0x2e c C
+0x2f v V
+0x30 b B
+0x31 n N
+0x32 m M
+0x33 , <
+0x34 . >
+0x35 / ?
+0x37 * *
+0x39 U+0020 U+0020
+0x56 \ |
+`
+
+const deKeymap = `
+name de
+base us
+0x03 2 " ²
+0x04 3 § ³
+0x07 6 &
+0x08 7 / {
+0x09 8 ( [
+0x0a 9 ) ]
+0x0b 0 = }
+0x0c ß ? \
+0x0d dead:´ dead:` + "`" + `
+0x10 q Q @
+0x12 e E €
+0x15 z Z
+0x1a ü Ü
+0x1b + * ~
+0x27 ö Ö
+0x28 ä Ä
+0x29 dead:^ °
+0x2b # '
+0x2c y Y
+0x32 m M µ
+0x33 , ;
+0x34 . :
+0x35 - _
+0x56 < > |
+compose ^ a â
+compose ^ e ê
+compose ^ i î
+compose ^ o ô
+compose ^ u û
+compose ^ A Â
+compose ^ E Ê
+compose ^ I Î
+compose ^ O Ô
+compose ^ U Û
+compose ´ a á
+compose ´ e é
+compose ´ i í
+compose ´ o ó
+compose ´ u ú
+compose ´ A Á
+compose ´ E É
+compose ´ I Í
+compose ´ O Ó
+compose ´ U Ú
+compose ` + "`" + ` a à
+compose ` + "`" + ` e è
+compose ` + "`" + ` i ì
+compose ` + "`" + ` o ò
+compose ` + "`" + ` u ù
+compose ` + "`" + ` A À
+compose ` + "`" + ` E È
+compose ` + "`" + ` I Ì
+compose ` + "`" + ` O Ò
+compose ` + "`" + ` U Ù
+`
+
+const frKeymap = `
+name fr
+base us
+0x02 & 1
+0x03 é 2 dead:~
+0x04 " 3 #
+0x05 ' 4 {
+0x06 ( 5 [
+0x07 - 6 |
+0x08 è 7 ` + "`" + `
+0x09 _ 8 \
+0x0a ç 9 ^
+0x0b à 0 @
+0x0c ) ° ]
+0x0d = + }
+0x10 a A
+0x11 z Z
+0x12 e E €
+0x1a dead:^ dead:¨
+0x1b $ £ ¤
+0x1e q Q
+0x27 m M
+0x28 ù %
+0x29 ² -
+0x2b * µ
+0x2c w W
+0x32 , ?
+0x33 ; .
+0x34 : /
+0x35 ! §
+0x56 < >
+compose ^ a â
+compose ^ e ê
+compose ^ i î
+compose ^ o ô
+compose ^ u û
+compose ^ A Â
+compose ^ E Ê
+compose ^ I Î
+compose ^ O Ô
+compose ^ U Û
+compose ¨ a ä
+compose ¨ e ë
+compose ¨ i ï
+compose ¨ o ö
+compose ¨ u ü
+compose ¨ y ÿ
+compose ¨ A Ä
+compose ¨ E Ë
+compose ¨ I Ï
+compose ¨ O Ö
+compose ¨ U Ü
+compose ~ a ã
+compose ~ n ñ
+compose ~ o õ
+compose ~ A Ã
+compose ~ N Ñ
+compose ~ O Õ
+`
+
+const dvorakKeymap = `
+name dvorak
+base us
+0x0c [ {
+0x0d ] }
+0x10 ' "
+0x11 , <
+0x12 . >
+0x13 p P
+0x14 y Y
+0x15 f F
+0x16 g G
+0x17 c C
+0x18 r R
+0x19 l L
+0x1a / ?
+0x1b = +
+0x1e a A
+0x1f o O
+0x20 e E
+0x21 u U
+0x22 i I
+0x23 d D
+0x24 h H
+0x25 t T
+0x26 n N
+0x27 s S
+0x28 - _
+0x2c ; :
+0x2d q Q
+0x2e j J
+0x2f k K
+0x30 x X
+0x31 b B
+0x32 m M
+0x33 w W
+0x34 v V
+0x35 z Z
+`
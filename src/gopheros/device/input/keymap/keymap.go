@@ -0,0 +1,201 @@
+// Package keymap translates PC keyboard scancodes (set 1) into characters
+// using loadable keyboard layouts. Layouts are described using a simple text
+// format (see Load) so that additional layouts can be shipped as boot modules
+// and loaded at boot time (see Init). The US, DE, FR and dvorak layouts are
+// built in.
+package keymap
+
+import (
+	"bufio"
+	"gopheros/kernel"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	// NumKeys is the number of scancodes (excluding break codes) that a
+	// keymap can describe.
+	NumKeys = 128
+
+	// NoChar indicates that a key does not generate a character.
+	NoChar rune = 0
+)
+
+// Level selects one of the characters assigned to a key.
+type Level uint8
+
+// The list of supported key levels.
+const (
+	LevelNormal Level = iota
+	LevelShift
+	LevelAltGr
+	numLevels
+)
+
+// Key describes the characters generated by a key for each level.
+type Key struct {
+	Chars [numLevels]rune
+
+	// Dead specifies for each level whether the key is a dead key. Dead
+	// keys do not generate a character on their own but modify the
+	// character generated by the following key.
+	Dead [numLevels]bool
+}
+
+// Keymap describes a keyboard layout.
+type Keymap struct {
+	Name string
+	Keys [NumKeys]Key
+
+	// compose maps a (dead key, base character) pair to the composed
+	// character.
+	compose map[[2]rune]rune
+}
+
+// Compose returns the character produced by pressing the dead key dead
+// followed by a key that generates base.
+func (km *Keymap) Compose(dead, base rune) (rune, bool) {
+	ch, ok := km.compose[[2]rune{dead, base}]
+	return ch, ok
+}
+
+var (
+	errMissingName    = &kernel.Error{Module: "keymap", Message: "keymap does not specify a name", Code: kernel.ErrCodeInvalidArgument}
+	errUnknownKeymap  = &kernel.Error{Module: "keymap", Message: "unknown keymap", Code: kernel.ErrCodeNotFound}
+	errInvalidLine    = &kernel.Error{Module: "keymap", Message: "invalid keymap definition", Code: kernel.ErrCodeInvalidArgument}
+	errReadingKeymap  = &kernel.Error{Module: "keymap", Message: "could not read keymap definition", Code: kernel.ErrCodeIO}
+	errInvalidKeyCode = &kernel.Error{Module: "keymap", Message: "invalid key scancode in keymap definition", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidArgs    = &kernel.Error{Module: "keymap", Message: "invalid arguments", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// Load parses a keymap definition from r. The definition uses a line-based
+// text format where empty lines and lines starting with '#' are ignored:
+//
+//   name <keymap name>
+//   base <name of a registered keymap to use as a starting point>
+//   <scancode> <normal> [<shift> [<altgr>]]
+//   compose <dead key char> <base char> <composed char>
+//
+// Scancodes may be specified in decimal or hex (0x prefix). Characters are
+// specified as literal UTF-8 characters or as U+XXXX code points; a single
+// '-' indicates that the key does not generate a character for that level
+// and a "dead:" prefix marks the character as a dead key.
+func Load(r io.Reader) (*Keymap, *kernel.Error) {
+	km := &Keymap{compose: make(map[[2]rune]rune)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		switch fields[0] {
+		case "name":
+			if len(fields) != 2 {
+				return nil, errInvalidLine
+			}
+			km.Name = fields[1]
+		case "base":
+			if len(fields) != 2 {
+				return nil, errInvalidLine
+			}
+
+			base := Lookup(fields[1])
+			if base == nil {
+				return nil, errUnknownKeymap
+			}
+
+			km.Keys = base.Keys
+			for k, v := range base.compose {
+				km.compose[k] = v
+			}
+		case "compose":
+			if len(fields) != 4 {
+				return nil, errInvalidLine
+			}
+
+			var chars [3]rune
+			for i := range chars {
+				ch, dead, err := parseChar(fields[i+1])
+				if err != nil || dead || ch == NoChar {
+					return nil, errInvalidLine
+				}
+				chars[i] = ch
+			}
+			km.compose[[2]rune{chars[0], chars[1]}] = chars[2]
+		default:
+			if err := km.parseKey(fields); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if scanner.Err() != nil {
+		return nil, errReadingKeymap
+	}
+
+	if km.Name == "" {
+		return nil, errMissingName
+	}
+
+	return km, nil
+}
+
+// parseKey parses a key definition line. Levels that are not specified are
+// cleared.
+func (km *Keymap) parseKey(fields []string) *kernel.Error {
+	if len(fields) < 2 || len(fields) > 1+int(numLevels) {
+		return errInvalidLine
+	}
+
+	code, err := strconv.ParseUint(fields[0], 0, 8)
+	if err != nil || code >= NumKeys {
+		return errInvalidKeyCode
+	}
+
+	var key Key
+	for level, field := range fields[1:] {
+		ch, dead, err := parseChar(field)
+		if err != nil {
+			return err
+		}
+
+		key.Chars[level], key.Dead[level] = ch, dead
+	}
+
+	km.Keys[code] = key
+	return nil
+}
+
+// parseChar parses a character specification.
+func parseChar(spec string) (ch rune, dead bool, err *kernel.Error) {
+	if strings.HasPrefix(spec, "dead:") {
+		spec, dead = spec[5:], true
+	}
+
+	switch {
+	case spec == "-" && !dead:
+		return NoChar, false, nil
+	case strings.HasPrefix(spec, "U+") && len(spec) > 2:
+		val, convErr := strconv.ParseUint(spec[2:], 16, 32)
+		if convErr != nil || !utf8.ValidRune(rune(val)) {
+			return NoChar, false, errInvalidLine
+		}
+		return rune(val), dead, nil
+	default:
+		ch, size := utf8.DecodeRuneInString(spec)
+		if ch == utf8.RuneError || size != len(spec) {
+			return NoChar, false, errInvalidLine
+		}
+		return ch, dead, nil
+	}
+}
+
+// isLetter returns true if caps lock affects the key.
+func (k *Key) isLetter() bool {
+	return unicode.IsLower(k.Chars[LevelNormal]) && unicode.IsUpper(k.Chars[LevelShift])
+}
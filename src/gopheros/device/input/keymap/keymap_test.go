@@ -0,0 +1,287 @@
+package keymap
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/multiboot"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+// typeKeys feeds the supplied scancode sequence to t and returns the
+// generated string.
+func typeKeys(t *Translator, scancodes ...byte) string {
+	var out []rune
+	for _, code := range scancodes {
+		out = t.Process(code, out)
+	}
+	return string(out)
+}
+
+func TestTranslatorUS(t *testing.T) {
+	us := Lookup("us")
+
+	specs := []struct {
+		scancodes []byte
+		exp       string
+	}{
+		// "hi" (make + break codes)
+		{[]byte{0x23, 0xa3, 0x17, 0x97}, "hi"},
+		// Shift + 1 (break code for shift resets the modifier)
+		{[]byte{0x2a, 0x02, 0x82, 0xaa, 0x02}, "!1"},
+		// Right shift
+		{[]byte{0x36, 0x1e, 0xb6, 0x1e}, "Aa"},
+		// Caps lock only affects letters and is inverted by shift
+		{[]byte{0x3a, 0xba, 0x1e, 0x02, 0x2a, 0x1e, 0xaa, 0x3a, 0x1e}, "A1aa"},
+		// Ctrl+C generates a control character
+		{[]byte{0x1d, 0x2e, 0x9d, 0x2e}, "\x03c"},
+		// Extended keys (e.g. arrows) and keys without a mapping are ignored
+		{[]byte{0xe0, 0x48, 0xe0, 0xc8, 0x3b, 0x39}, " "},
+		// Enter, tab and backspace
+		{[]byte{0x1c, 0x0f, 0x0e}, "\n\t\b"},
+	}
+
+	for specIndex, spec := range specs {
+		if got := typeKeys(NewTranslator(us), spec.scancodes...); got != spec.exp {
+			t.Errorf("[spec %d] expected %q; got %q", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestTranslatorLayouts(t *testing.T) {
+	specs := []struct {
+		keymap    string
+		scancodes []byte
+		exp       string
+	}{
+		// QWERTZ layout
+		{"de", []byte{0x15, 0x2c, 0x1a, 0x0c}, "zyüß"},
+		// AltGr
+		{"de", []byte{0xe0, 0x38, 0x10, 0x12, 0xe0, 0xb8, 0x10}, "@€q"},
+		// Dead keys: ^ + o, ´ + e, shift + ` + a
+		{"de", []byte{0x29, 0x18, 0x0d, 0x12, 0x2a, 0x0d, 0xaa, 0x1e}, "ôéà"},
+		// Dead key followed by space generates the dead character
+		{"de", []byte{0x29, 0x39}, "^"},
+		// Dead key followed by a non-composable key generates both
+		{"de", []byte{0x29, 0x31}, "^n"},
+		// Two dead keys in a row
+		{"de", []byte{0x29, 0x29, 0x16}, "^û"},
+		// AZERTY layout and dead diaeresis
+		{"fr", []byte{0x10, 0x11, 0x02, 0x03, 0x2a, 0x1a, 0xaa, 0x15}, "az&éÿ"},
+		// AltGr dead tilde
+		{"fr", []byte{0xe0, 0x38, 0x03, 0xe0, 0xb8, 0x31}, "ñ"},
+		// Dvorak
+		{"dvorak", []byte{0x24, 0x20, 0x19, 0x19, 0x1f}, "hello"},
+	}
+
+	for specIndex, spec := range specs {
+		km := Lookup(spec.keymap)
+		if km == nil {
+			t.Fatalf("[spec %d] keymap %q not registered", specIndex, spec.keymap)
+		}
+
+		if got := typeKeys(NewTranslator(km), spec.scancodes...); got != spec.exp {
+			t.Errorf("[spec %d] expected %q; got %q", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	km, err := Load(strings.NewReader(`
+# A tiny layout based on us
+name test
+base us
+0x10 U+00E6 U+00C6 dead:~
+0x11 -
+compose ~ x U+1E8B
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if km.Name != "test" {
+		t.Fatalf("expected keymap name to be %q; got %q", "test", km.Name)
+	}
+
+	if key := km.Keys[0x10]; key.Chars != [numLevels]rune{'æ', 'Æ', '~'} || key.Dead != [numLevels]bool{false, false, true} {
+		t.Fatalf("unexpected definition for key 0x10: %+v", key)
+	}
+
+	if km.Keys[0x11].Chars[LevelNormal] != NoChar {
+		t.Fatal("expected key 0x11 to be cleared")
+	}
+
+	if km.Keys[0x12].Chars[LevelNormal] != 'e' {
+		t.Fatal("expected key 0x12 to be inherited from the base keymap")
+	}
+
+	if ch, ok := km.Compose('~', 'x'); !ok || ch != 'ẋ' {
+		t.Fatalf("expected compose(~, x) to return ẋ; got %q, %t", ch, ok)
+	}
+
+	specs := []struct {
+		def    string
+		expErr interface{}
+	}{
+		{"0x10 a A", errMissingName},
+		{"name", errInvalidLine},
+		{"name a\nbase", errInvalidLine},
+		{"name a\nbase missing", errUnknownKeymap},
+		{"name a\ncompose ~ x", errInvalidLine},
+		{"name a\ncompose ~ x dead:y", errInvalidLine},
+		{"name a\ncompose ~ x -", errInvalidLine},
+		{"name a\n0x10", errInvalidLine},
+		{"name a\n0x10 a b c d", errInvalidLine},
+		{"name a\n0x80 a", errInvalidKeyCode},
+		{"name a\nfoo a", errInvalidKeyCode},
+		{"name a\n0x10 ab", errInvalidLine},
+		{"name a\n0x10 U+zz", errInvalidLine},
+		{"name a\n0x10 U+D800", errInvalidLine},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := Load(strings.NewReader(spec.def)); err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if _, err := Load(&bytes.Buffer{}); err != errMissingName {
+		t.Errorf("expected errMissingName; got %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	defer func(origActive *Keymap, origCmdLineFn func() map[string]string) {
+		activeKeymap = origActive
+		getBootCmdLineFn = origCmdLineFn
+		visitModulesFn = multiboot.VisitModules
+	}(activeKeymap, getBootCmdLineFn)
+	visitModulesFn = func(multiboot.ModuleVisitor) {}
+
+	if got := strings.Join(Names(), ","); got != "de,dvorak,fr,us" {
+		t.Fatalf("unexpected list of registered keymaps: %q", got)
+	}
+
+	if Active() != Lookup("us") {
+		t.Fatal("expected the us keymap to be active by default")
+	}
+
+	// A translator without a bound keymap uses the active keymap
+	tr := NewTranslator(nil)
+	if got := typeKeys(tr, 0x15); got != "y" {
+		t.Fatalf("expected %q; got %q", "y", got)
+	}
+
+	getBootCmdLineFn = func() map[string]string { return map[string]string{"keymap": "de"} }
+	if err := Init(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := typeKeys(tr, 0x15); got != "z" {
+		t.Fatalf("expected %q after switching keymap; got %q", "z", got)
+	}
+
+	getBootCmdLineFn = func() map[string]string { return map[string]string{"keymap": "xx"} }
+	if err := Init(); err != errUnknownKeymap {
+		t.Fatalf("expected errUnknownKeymap; got %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := cmdKeymap(&buf, []string{"fr"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := cmdKeymap(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "  de\n  dvorak\n* fr\n  us\n"; buf.String() != exp {
+		t.Fatalf("expected output %q; got %q", exp, buf.String())
+	}
+
+	if err := cmdKeymap(&buf, []string{"a", "b"}); err != errInvalidArgs {
+		t.Fatalf("expected errInvalidArgs; got %v", err)
+	}
+
+	activeKeymap = nil
+	if got := typeKeys(tr, 0x15); got != "" {
+		t.Fatalf("expected no output without an active keymap; got %q", got)
+	}
+}
+
+func TestInitLoadsModules(t *testing.T) {
+	defer func(origActive *Keymap) {
+		activeKeymap = origActive
+		delete(keymaps, "test")
+		getBootCmdLineFn = multiboot.GetBootCmdLine
+		visitModulesFn = multiboot.VisitModules
+		mapFramesFn = vmm.MapFrames
+		freeRegionFn = vmm.FreeRegion
+	}(activeKeymap)
+
+	getBootCmdLineFn = func() map[string]string { return map[string]string{"keymap": "test"} }
+
+	// Place the definition at a non page-aligned offset to check that the
+	// module is mapped correctly
+	def := "name test\nbase us\n0x15 z Z\n"
+	buf := make([]byte, 2*int(mm.PageSize)+len(def))
+	pageAddr := (uintptr(unsafe.Pointer(&buf[0])) + mm.PageSize - 1) &^ (mm.PageSize - 1)
+	modStart := pageAddr + 16
+	copy(buf[modStart-uintptr(unsafe.Pointer(&buf[0])):], def)
+	modEnd := modStart + uintptr(len(def))
+
+	var freed int
+	mapFramesFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.Page(frame), nil
+	}
+	freeRegionFn = func(mm.Page) *kernel.Error {
+		freed++
+		return nil
+	}
+
+	specs := []struct {
+		modCmdLine string
+		mapErr     *kernel.Error
+		expErr     *kernel.Error
+	}{
+		{"/boot/initrd", nil, errUnknownKeymap},
+		{"/boot/test.map keymap", &kernel.Error{Module: "test", Message: "map failed"}, errUnknownKeymap},
+		{"/boot/test.map keymap", nil, nil},
+	}
+
+	for specIndex, spec := range specs {
+		freed = 0
+		visitModulesFn = func(visitor multiboot.ModuleVisitor) {
+			visitor(spec.modCmdLine, modStart, modEnd)
+		}
+		if spec.mapErr != nil {
+			mapFramesFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+				return 0, spec.mapErr
+			}
+		} else {
+			mapFramesFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+				return mm.Page(frame), nil
+			}
+		}
+
+		if err := Init(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if spec.expErr != nil {
+			continue
+		}
+
+		if freed != 1 {
+			t.Errorf("[spec %d] expected the module mapping to be released", specIndex)
+		}
+
+		if got := typeKeys(NewTranslator(nil), 0x15, 0x1e); got != "za" {
+			t.Errorf("[spec %d] expected the module keymap to be active; got %q", specIndex, got)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package keymap
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/multiboot"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"unsafe"
+)
+
+// moduleTag is the token that must be present in the command line of each
+// boot module that contains a keymap definition (e.g.
+// "module2 /boot/keymaps/de.map keymap" when using grub2).
+const moduleTag = "keymap"
+
+var (
+	// keymaps tracks all registered keymaps by name.
+	keymaps = make(map[string]*Keymap)
+
+	// activeKeymap is used by translators that are not bound to a
+	// specific keymap.
+	activeKeymap *Keymap
+
+	getBootCmdLineFn = multiboot.GetBootCmdLine
+	visitModulesFn   = multiboot.VisitModules
+	mapFramesFn      = vmm.MapFrames
+	freeRegionFn     = vmm.FreeRegion
+)
+
+// Register adds km to the list of available keymaps replacing any existing
+// keymap with the same name. The first registered keymap becomes the active
+// keymap.
+func Register(km *Keymap) {
+	keymaps[km.Name] = km
+	if activeKeymap == nil {
+		activeKeymap = km
+	}
+}
+
+// Lookup returns the keymap with the specified name or nil if no such keymap
+// has been registered.
+func Lookup(name string) *Keymap {
+	return keymaps[name]
+}
+
+// Names returns the sorted list of registered keymap names.
+func Names() []string {
+	names := make([]string, 0, len(keymaps))
+	for name := range keymaps {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// Active returns the currently active keymap.
+func Active() *Keymap {
+	return activeKeymap
+}
+
+// SetActive selects the keymap used by translators that are not bound to a
+// specific keymap.
+func SetActive(name string) *kernel.Error {
+	km := Lookup(name)
+	if km == nil {
+		return errUnknownKeymap
+	}
+
+	activeKeymap = km
+	return nil
+}
+
+// Init registers the keymaps shipped as boot modules tagged with "keymap" and
+// activates the keymap specified by the "keymap" command line option (if
+// present). Modules that cannot be loaded are reported to the console and
+// skipped.
+func Init() *kernel.Error {
+	loadModules()
+
+	if name, ok := getBootCmdLineFn()["keymap"]; ok {
+		return SetActive(name)
+	}
+
+	return nil
+}
+
+// loadModules registers the keymap contained in each boot module whose
+// command line contains the "keymap" tag. A keymap loaded from a module
+// replaces any builtin keymap with the same name.
+func loadModules() {
+	visitModulesFn(func(cmdLine string, physStart, physEnd uintptr) bool {
+		for _, token := range strings.Fields(cmdLine) {
+			if token != moduleTag {
+				continue
+			}
+
+			if km, err := loadModule(physStart, physEnd); err != nil {
+				kfmt.Printf("[keymap] %s: %s\n", cmdLine, err.Error())
+			} else {
+				Register(km)
+			}
+			break
+		}
+		return true
+	})
+}
+
+// loadModule maps the boot module at [physStart, physEnd) and parses the
+// keymap definition that it contains. The mapping is released once the
+// definition is parsed.
+func loadModule(physStart, physEnd uintptr) (*Keymap, *kernel.Error) {
+	pageOffset := physStart & (mm.PageSize - 1)
+	page, err := mapFramesFn(mm.FrameFromAddress(physStart), physEnd-physStart+pageOffset, vmm.FlagNoExecute)
+	if err != nil {
+		return nil, err
+	}
+
+	data := *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Len:  int(physEnd - physStart),
+		Cap:  int(physEnd - physStart),
+		Data: page.Address() + pageOffset,
+	}))
+	km, err := Load(bytes.NewReader(data))
+
+	_ = freeRegionFn(page)
+	return km, err
+}
+
+// cmdKeymap implements the "keymap" kshell command. When invoked without
+// arguments it lists the available keymaps; otherwise it switches the active
+// keymap.
+func cmdKeymap(w io.Writer, args []string) *kernel.Error {
+	switch len(args) {
+	case 0:
+		for _, name := range Names() {
+			marker := " "
+			if keymaps[name] == activeKeymap {
+				marker = "*"
+			}
+			kfmt.Fprintf(w, "%s %s\n", marker, name)
+		}
+		return nil
+	case 1:
+		return SetActive(args[0])
+	default:
+		return errInvalidArgs
+	}
+}
+
+func init() {
+	for _, def := range builtinKeymaps {
+		km, err := Load(strings.NewReader(def))
+		if err != nil {
+			panic(err)
+		}
+		Register(km)
+	}
+
+	kshell.RegisterCommand(&kshell.Command{
+		Name:  "keymap",
+		Usage: "[name]",
+		Help:  "list the available keyboard layouts or switch the active layout",
+		Fn:    cmdKeymap,
+	})
+}
@@ -0,0 +1,134 @@
+package keymap
+
+// The scancodes (set 1) for keys with special handling.
+const (
+	scancodeExtended = 0xe0
+	scancodeRelease  = 0x80
+	scancodeCtrl     = 0x1d
+	scancodeLShift   = 0x2a
+	scancodeRShift   = 0x36
+	scancodeAlt      = 0x38 // AltGr when prefixed by scancodeExtended
+	scancodeCapsLock = 0x3a
+)
+
+// Translator converts a stream of scancodes into characters. It tracks the
+// state of the modifier keys and any pending dead key.
+type Translator struct {
+	// km is the keymap used for translation. If nil, the active keymap is
+	// used.
+	km *Keymap
+
+	extended bool
+	lShift   bool
+	rShift   bool
+	ctrl     bool
+	alt      bool
+	altGr    bool
+	capsLock bool
+
+	// pendingDead holds the character of the last pressed dead key.
+	pendingDead rune
+}
+
+// NewTranslator returns a translator that uses km for converting scancodes
+// to characters. If km is nil, the translator uses the active keymap.
+func NewTranslator(km *Keymap) *Translator {
+	return &Translator{km: km}
+}
+
+// Process handles a single scancode and appends any generated characters to
+// out returning back the updated slice. Depending on the dead key state, a
+// single scancode may generate zero, one or two characters.
+func (t *Translator) Process(scancode byte, out []rune) []rune {
+	if scancode == scancodeExtended {
+		t.extended = true
+		return out
+	}
+
+	extended := t.extended
+	t.extended = false
+
+	pressed, code := scancode&scancodeRelease == 0, scancode&^scancodeRelease
+	switch code {
+	case scancodeLShift:
+		t.lShift = pressed
+		return out
+	case scancodeRShift:
+		t.rShift = pressed
+		return out
+	case scancodeCtrl:
+		t.ctrl = pressed
+		return out
+	case scancodeAlt:
+		if extended {
+			t.altGr = pressed
+		} else {
+			t.alt = pressed
+		}
+		return out
+	case scancodeCapsLock:
+		if pressed {
+			t.capsLock = !t.capsLock
+		}
+		return out
+	}
+
+	// Extended keys (arrows, keypad enter e.t.c) are not translated
+	if !pressed || extended {
+		return out
+	}
+
+	km := t.km
+	if km == nil {
+		if km = Active(); km == nil {
+			return out
+		}
+	}
+
+	key := &km.Keys[code]
+	level := LevelNormal
+	shifted := t.lShift || t.rShift
+	if t.capsLock && key.isLetter() {
+		shifted = !shifted
+	}
+
+	switch {
+	case t.altGr:
+		level = LevelAltGr
+	case shifted:
+		level = LevelShift
+	}
+
+	ch, dead := key.Chars[level], key.Dead[level]
+	if ch == NoChar {
+		return out
+	}
+
+	if t.pendingDead != NoChar {
+		deadCh := t.pendingDead
+		t.pendingDead = NoChar
+
+		if !dead {
+			if composed, ok := km.Compose(deadCh, ch); ok {
+				return append(out, composed)
+			}
+		}
+
+		// Dead key followed by space generates the dead key character
+		out = append(out, deadCh)
+		if ch == ' ' {
+			return out
+		}
+	}
+
+	if dead {
+		t.pendingDead = ch
+		return out
+	}
+
+	if t.ctrl && ((ch >= 'a' && ch <= 'z') || (ch >= 'A' && ch <= 'Z')) {
+		ch &= 0x1f
+	}
+
+	return append(out, ch)
+}
@@ -0,0 +1,210 @@
+// Package ps2 implements a driver for keyboards attached to the first port of
+// an i8042-compatible PS/2 controller. Scancodes are translated into
+// characters using the active keyboard layout from the keymap package.
+package ps2
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/input/keymap"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/idle"
+	"io"
+	"unicode/utf8"
+)
+
+var (
+	errNoController   = &kernel.Error{Module: "ps2", Message: "PS/2 controller not responding", Code: kernel.ErrCodeIO}
+	errSelfTestFailed = &kernel.Error{Module: "ps2", Message: "PS/2 controller failed its self-test", Code: kernel.ErrCodeIO}
+
+	fadtFn          = acpi.FADT
+	portReadByteFn  = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+	idlePollFn      = idle.Poll
+
+	// pollLimit specifies the number of times that the status register is
+	// polled while waiting for the controller to accept a command or to
+	// respond to it.
+	pollLimit = 100000
+)
+
+// The I/O ports of the controller.
+const (
+	portData    = 0x60
+	portStatus  = 0x64
+	portCommand = 0x64
+)
+
+// The controller status register bits, commands and responses.
+const (
+	statusOutputFull uint8 = 1 << 0
+	statusInputFull  uint8 = 1 << 1
+	statusAuxData    uint8 = 1 << 5
+
+	cmdSelfTest    uint8 = 0xaa
+	cmdEnablePort1 uint8 = 0xae
+	selfTestPassed uint8 = 0x55
+	noController   uint8 = 0xff
+
+	// maxFlushedBytes is the number of stale bytes that are discarded
+	// from the output buffer before the controller is initialized.
+	maxFlushedBytes = 16
+
+	// bootArch8042 is set in the IA-PC boot architecture flags of the FADT
+	// if the platform contains an i8042-compatible controller.
+	bootArch8042 uint16 = 1 << 1
+)
+
+// Keyboard drives a PS/2 keyboard. Once initialized, the keyboard implements
+// io.Reader and can be used as an input source for the kernel shell.
+type Keyboard struct {
+	translator *keymap.Translator
+
+	// runes receives the characters generated by a single scancode.
+	runes [2]rune
+
+	// pending holds the UTF-8 encoded characters that have not yet been
+	// returned by Read.
+	pending      [64]byte
+	pendingStart int
+	pendingEnd   int
+}
+
+// DriverName returns the name of this driver.
+func (*Keyboard) DriverName() string {
+	return "PS/2 keyboard"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Keyboard) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit discards any stale data in the controller output buffer, runs
+// the controller self-test and enables the keyboard port. Scancodes are
+// translated using the active keymap so that switching the keyboard layout
+// at runtime takes effect immediately.
+func (k *Keyboard) DriverInit(_ io.Writer) *kernel.Error {
+	if portReadByteFn(portStatus) == noController {
+		return errNoController
+	}
+
+	for i := 0; i < maxFlushedBytes && portReadByteFn(portStatus)&statusOutputFull != 0; i++ {
+		_ = portReadByteFn(portData)
+	}
+
+	if err := sendCommand(cmdSelfTest); err != nil {
+		return err
+	}
+
+	res, err := readResponse()
+	if err != nil {
+		return err
+	}
+
+	if res != selfTestPassed {
+		return errSelfTestFailed
+	}
+
+	if err = sendCommand(cmdEnablePort1); err != nil {
+		return err
+	}
+
+	k.translator = keymap.NewTranslator(nil)
+	return nil
+}
+
+// Read implements io.Reader. It blocks until at least one character is typed
+// and then returns the UTF-8 encoded characters (up to len(p)). Deferred work
+// is serviced via the idle loop while waiting for input.
+func (k *Keyboard) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		k.translateInput()
+		if n := k.readPending(p); n != 0 {
+			return n, nil
+		}
+
+		idlePollFn()
+	}
+}
+
+// translateInput translates the scancodes in the controller output buffer
+// while the pending buffer has room for the characters generated by a single
+// scancode.
+func (k *Keyboard) translateInput() {
+	for len(k.pending)-k.pendingEnd >= len(k.runes)*utf8.UTFMax {
+		status := portReadByteFn(portStatus)
+		if status&statusOutputFull == 0 {
+			return
+		}
+
+		// Data from the auxiliary (mouse) port is ignored
+		scancode := portReadByteFn(portData)
+		if status&statusAuxData != 0 {
+			continue
+		}
+
+		for _, ch := range k.translator.Process(scancode, k.runes[:0]) {
+			k.pendingEnd += utf8.EncodeRune(k.pending[k.pendingEnd:], ch)
+		}
+	}
+}
+
+// readPending copies the pending characters to p and returns the number of
+// copied bytes.
+func (k *Keyboard) readPending(p []byte) int {
+	n := copy(p, k.pending[k.pendingStart:k.pendingEnd])
+	if k.pendingStart += n; k.pendingStart == k.pendingEnd {
+		k.pendingStart, k.pendingEnd = 0, 0
+	}
+
+	return n
+}
+
+// sendCommand waits for the controller input buffer to become empty and then
+// writes cmd to the command port.
+func sendCommand(cmd uint8) *kernel.Error {
+	for i := 0; i < pollLimit; i++ {
+		if portReadByteFn(portStatus)&statusInputFull == 0 {
+			portWriteByteFn(portCommand, cmd)
+			return nil
+		}
+	}
+
+	return errNoController
+}
+
+// readResponse waits for the controller to place a byte in its output buffer
+// and returns it.
+func readResponse() (uint8, *kernel.Error) {
+	for i := 0; i < pollLimit; i++ {
+		if portReadByteFn(portStatus)&statusOutputFull != 0 {
+			return portReadByteFn(portData), nil
+		}
+	}
+
+	return 0, errNoController
+}
+
+// probeForController checks whether the platform contains a PS/2 controller.
+// If the FADT is available, the controller is only probed when the IA-PC boot
+// architecture flags indicate that it is present.
+func probeForController() device.Driver {
+	if fadt := fadtFn(); fadt != nil && fadt.BootArchitectureFlags&bootArch8042 == 0 {
+		return nil
+	}
+
+	return &Keyboard{}
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderLast,
+		Probe: probeForController,
+	})
+}
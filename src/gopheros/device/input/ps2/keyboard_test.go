@@ -0,0 +1,217 @@
+package ps2
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/device/input/keymap"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/idle"
+	"testing"
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+
+	specs := []struct {
+		absent   bool
+		stuck    bool
+		selfTest uint8
+		expErr   *kernel.Error
+	}{
+		{false, false, selfTestPassed, nil},
+		{true, false, selfTestPassed, errNoController},
+		{false, true, selfTestPassed, errNoController},
+		{false, false, 0xfc, errSelfTestFailed},
+	}
+
+	for specIndex, spec := range specs {
+		fake := newFakeController()
+		fake.absent, fake.stuck, fake.selfTest = spec.absent, spec.stuck, spec.selfTest
+		fake.output = []fakeByte{{0xfa, false}, {0x1e, false}}
+
+		drv := &Keyboard{}
+		if err := drv.DriverInit(&bytes.Buffer{}); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if spec.expErr != nil {
+			continue
+		}
+
+		if exp := []uint8{cmdSelfTest, cmdEnablePort1}; !bytes.Equal(fake.commands, exp) {
+			t.Errorf("[spec %d] expected commands %v to be sent; got %v", specIndex, exp, fake.commands)
+		}
+
+		if len(fake.output) != 0 {
+			t.Errorf("[spec %d] expected stale output to be discarded", specIndex)
+		}
+	}
+}
+
+func TestRead(t *testing.T) {
+	defer func() {
+		restoreFns()
+		_ = keymap.SetActive("us")
+	}()
+
+	fake := newFakeController()
+	drv := &Keyboard{}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := drv.Read(nil); n != 0 || err != nil {
+		t.Fatalf("expected empty read to return (0, nil); got (%d, %v)", n, err)
+	}
+
+	specs := []struct {
+		keymap string
+		input  []fakeByte
+		bufLen int
+		exp    string
+	}{
+		// "ls" followed by enter; break codes generate no characters
+		{"us", []fakeByte{{0x26, false}, {0xa6, false}, {0x1f, false}, {0x1c, false}}, 8, "ls\n"},
+		// Data from the auxiliary port is ignored
+		{"us", []fakeByte{{0x1e, true}, {0x30, false}}, 8, "b"},
+		// The active keymap is used for translation
+		{"de", []fakeByte{{0x15, false}}, 8, "z"},
+		// Multi-byte characters are returned across calls if the
+		// buffer is too small
+		{"de", []fakeByte{{0x1a, false}}, 1, "\xc3"},
+		{"de", nil, 1, "\xbc"},
+	}
+
+	var polls int
+	for specIndex, spec := range specs {
+		if err := keymap.SetActive(spec.keymap); err != nil {
+			t.Fatal(err)
+		}
+
+		// Input arrives after the driver idles for the first time
+		polls = 0
+		pendingInput := spec.input
+		idlePollFn = func() {
+			polls++
+			fake.output = append(fake.output, pendingInput...)
+			pendingInput = nil
+		}
+
+		buf := make([]byte, spec.bufLen)
+		n, err := drv.Read(buf)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got := string(buf[:n]); got != spec.exp {
+			t.Errorf("[spec %d] expected Read to return %q; got %q", specIndex, spec.exp, got)
+		}
+
+		if spec.input != nil && polls == 0 {
+			t.Errorf("[spec %d] expected Read to service deferred work while waiting for input", specIndex)
+		}
+	}
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	specs := []struct {
+		fadt   *table.FADTInfo
+		expDrv bool
+	}{
+		{nil, true},
+		{&table.FADTInfo{BootArchitectureFlags: bootArch8042}, true},
+		{&table.FADTInfo{}, false},
+	}
+
+	for specIndex, spec := range specs {
+		fadt := spec.fadt
+		fadtFn = func() *table.FADTInfo { return fadt }
+
+		if drv := probeForController(); (drv != nil) != spec.expDrv {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDrv, drv)
+		}
+	}
+}
+
+// fakeByte is a byte in the output buffer of the fake controller.
+type fakeByte struct {
+	val uint8
+	aux bool
+}
+
+// fakeController emulates an i8042 controller.
+type fakeController struct {
+	output   []fakeByte
+	commands []uint8
+	selfTest uint8
+
+	// Set to emulate a missing controller or a controller that never
+	// accepts commands.
+	absent bool
+	stuck  bool
+}
+
+// newFakeController returns a fake controller and installs port access hooks
+// that redirect to it.
+func newFakeController() *fakeController {
+	fake := &fakeController{selfTest: selfTestPassed}
+	portReadByteFn = fake.read
+	portWriteByteFn = fake.write
+	idlePollFn = func() {}
+	return fake
+}
+
+func (f *fakeController) read(port uint16) uint8 {
+	switch port {
+	case portStatus:
+		if f.absent {
+			return noController
+		}
+
+		var status uint8
+		if f.stuck {
+			status |= statusInputFull
+		}
+		if len(f.output) != 0 {
+			status |= statusOutputFull
+			if f.output[0].aux {
+				status |= statusAuxData
+			}
+		}
+		return status
+	case portData:
+		if len(f.output) == 0 {
+			return 0
+		}
+		b := f.output[0]
+		f.output = f.output[1:]
+		return b.val
+	}
+
+	return 0
+}
+
+func (f *fakeController) write(port uint16, val uint8) {
+	if port != portCommand {
+		return
+	}
+
+	f.commands = append(f.commands, val)
+	if val == cmdSelfTest {
+		f.output = append(f.output, fakeByte{val: f.selfTest})
+	}
+}
+
+func restoreFns() {
+	fadtFn = acpi.FADT
+	portReadByteFn = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+	idlePollFn = idle.Poll
+	pollLimit = 100000
+}
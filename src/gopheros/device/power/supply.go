@@ -0,0 +1,156 @@
+// Package power defines the interface implemented by the drivers for power
+// supplies (e.g. batteries and AC adapters) and keeps track of the power
+// supplies that are present in the system.
+package power
+
+import "gopheros/kernel"
+
+// SupplyType describes the kind of a power supply.
+type SupplyType uint8
+
+// The supported power supply types.
+const (
+	SupplyTypeBattery SupplyType = iota
+	SupplyTypeMains
+)
+
+// ChargeStatus describes whether a battery is being charged or discharged.
+type ChargeStatus uint8
+
+// The supported charge status values.
+const (
+	ChargeStatusUnknown ChargeStatus = iota
+	ChargeStatusCharging
+	ChargeStatusDischarging
+	ChargeStatusFull
+	ChargeStatusNotCharging
+)
+
+// String implements fmt.Stringer for ChargeStatus.
+func (s ChargeStatus) String() string {
+	switch s {
+	case ChargeStatusCharging:
+		return "charging"
+	case ChargeStatusDischarging:
+		return "discharging"
+	case ChargeStatusFull:
+		return "full"
+	case ChargeStatusNotCharging:
+		return "not charging"
+	default:
+		return "unknown"
+	}
+}
+
+// CapacityUnit describes the unit used for reporting the capacity of a
+// battery.
+type CapacityUnit uint8
+
+// The supported capacity units. Depending on the unit, charge rates are
+// reported in mW or mA.
+const (
+	CapacityUnitMilliWattHours CapacityUnit = iota
+	CapacityUnitMilliAmpHours
+)
+
+// Unknown is reported by the numeric State fields whose value is not known.
+const Unknown = ^uint64(0)
+
+// State describes the state of a power supply.
+type State struct {
+	// Online is true if an AC adapter is connected to mains power or if
+	// a battery is installed.
+	Online bool
+
+	// The remaining fields are only populated for batteries.
+	Status ChargeStatus
+	Unit   CapacityUnit
+
+	// The capacity of a new battery, the capacity of the battery when
+	// it was last fully charged and the remaining battery capacity.
+	DesignCapacity    uint64
+	FullCapacity      uint64
+	RemainingCapacity uint64
+
+	// The current charge or discharge rate and the battery voltage in
+	// mV.
+	Rate    uint64
+	Voltage uint64
+
+	// Critical is true if the battery charge is critically low.
+	Critical bool
+}
+
+// Percentage returns the remaining battery charge as a percentage of its full
+// charge capacity or Unknown if the charge cannot be calculated.
+func (s *State) Percentage() uint64 {
+	if s.FullCapacity == 0 || s.FullCapacity == Unknown || s.RemainingCapacity == Unknown {
+		return Unknown
+	}
+
+	if s.RemainingCapacity >= s.FullCapacity {
+		return 100
+	}
+
+	return s.RemainingCapacity * 100 / s.FullCapacity
+}
+
+// Supply is implemented by power supply drivers.
+type Supply interface {
+	// Name returns a unique name for the power supply.
+	Name() string
+
+	// Type returns the kind of the power supply.
+	Type() SupplyType
+
+	// State queries the hardware for the current power supply state.
+	State() (State, *kernel.Error)
+}
+
+// ChangeListener is a function that is invoked when the state of a power
+// supply changes.
+type ChangeListener func(supply Supply)
+
+var (
+	// supplies tracks the power supplies registered via Register.
+	supplies []Supply
+
+	// listeners tracks the functions registered via AddListener.
+	listeners []ChangeListener
+)
+
+// Register adds a power supply to the list of supplies present in the
+// system.
+func Register(supply Supply) {
+	supplies = append(supplies, supply)
+}
+
+// Unregister removes a power supply from the list of supplies present in the
+// system.
+func Unregister(supply Supply) {
+	for index, s := range supplies {
+		if s == supply {
+			supplies = append(supplies[:index], supplies[index+1:]...)
+			return
+		}
+	}
+}
+
+// Supplies returns the registered power supplies.
+func Supplies() []Supply {
+	return supplies
+}
+
+// AddListener registers a function to be invoked whenever a driver reports a
+// power supply state change.
+func AddListener(listener ChangeListener) {
+	listeners = append(listeners, listener)
+}
+
+// NotifyChanged is invoked by drivers to report that the state of supply has
+// changed.
+func NotifyChanged(supply Supply) {
+	for _, listener := range listeners {
+		listener(supply)
+	}
+}
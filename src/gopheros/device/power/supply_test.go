@@ -0,0 +1,78 @@
+package power
+
+import (
+	"gopheros/kernel"
+	"testing"
+)
+
+type mockSupply struct {
+	name string
+}
+
+func (s *mockSupply) Name() string                  { return s.name }
+func (s *mockSupply) Type() SupplyType              { return SupplyTypeMains }
+func (s *mockSupply) State() (State, *kernel.Error) { return State{Online: true}, nil }
+
+func TestRegistry(t *testing.T) {
+	defer func() {
+		supplies = nil
+		listeners = nil
+	}()
+
+	bat0, ac0 := &mockSupply{name: "BAT0"}, &mockSupply{name: "AC0"}
+	Register(bat0)
+	Register(ac0)
+
+	if got := Supplies(); len(got) != 2 || got[0] != bat0 || got[1] != ac0 {
+		t.Fatalf("expected registered supplies to be [BAT0 AC0]; got %v", got)
+	}
+
+	var changed []string
+	AddListener(func(supply Supply) { changed = append(changed, supply.Name()) })
+	NotifyChanged(ac0)
+	NotifyChanged(bat0)
+
+	if len(changed) != 2 || changed[0] != "AC0" || changed[1] != "BAT0" {
+		t.Fatalf("expected listener to be notified about AC0 and BAT0; got %v", changed)
+	}
+
+	Unregister(bat0)
+	Unregister(bat0)
+	if got := Supplies(); len(got) != 1 || got[0] != ac0 {
+		t.Fatalf("expected registered supplies to be [AC0]; got %v", got)
+	}
+}
+
+func TestStatePercentage(t *testing.T) {
+	specs := []struct {
+		full, remaining uint64
+		exp             uint64
+	}{
+		{5000, 2500, 50},
+		{5000, 6000, 100},
+		{0, 100, Unknown},
+		{Unknown, 100, Unknown},
+		{5000, Unknown, Unknown},
+	}
+
+	for specIndex, spec := range specs {
+		state := State{FullCapacity: spec.full, RemainingCapacity: spec.remaining}
+		if got := state.Percentage(); got != spec.exp {
+			t.Errorf("[spec %d] expected percentage to be %d; got %d", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestChargeStatusString(t *testing.T) {
+	for status, exp := range map[ChargeStatus]string{
+		ChargeStatusUnknown:     "unknown",
+		ChargeStatusCharging:    "charging",
+		ChargeStatusDischarging: "discharging",
+		ChargeStatusFull:        "full",
+		ChargeStatusNotCharging: "not charging",
+	} {
+		if got := status.String(); got != exp {
+			t.Errorf("expected %d.String() to return %q; got %q", status, exp, got)
+		}
+	}
+}
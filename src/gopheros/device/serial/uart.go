@@ -0,0 +1,292 @@
+// Package serial implements a driver for 16550-compatible UARTs. The serial
+// port that the firmware uses for console redirection is located via the SPCR
+// ACPI table and is configured as a kernel console so that headless systems
+// can be monitored remotely.
+package serial
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/idle"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/replay"
+	"io"
+	"sync/atomic"
+)
+
+var (
+	errUnsupportedAddressSpace = &kernel.Error{Module: "uart", Message: "UART registers must be located in the system I/O space", Code: kernel.ErrCodeNotSupported}
+	errUnsupportedBaudRate     = &kernel.Error{Module: "uart", Message: "unsupported baud rate", Code: kernel.ErrCodeNotSupported}
+	errLoopbackFailed          = &kernel.Error{Module: "uart", Message: "UART failed the loopback test", Code: kernel.ErrCodeIO}
+
+	lookupTableFn   = acpi.LookupTable
+	portReadByteFn  = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+	idlePollFn      = idle.Poll
+	idleEnterFn     = idle.Enter
+
+	activeEventSourceFn = clock.ActiveEventSource
+
+	replayEngine = replay.Global()
+
+	// replayedInput buffers the console input that is re-injected by the
+	// replay engine until it is consumed by Read. It is filled from
+	// interrupt context and drained by Read.
+	replayedInput              [64]byte
+	replayedHead, replayedTail uint32
+
+	// pollLimit specifies the number of times that the line status
+	// register is polled while waiting for the transmitter to become
+	// ready before the character is dropped.
+	pollLimit = 100000
+
+	// idleWakeupDelay specifies the time in nanoseconds after which a CPU
+	// that idles while Read waits for input is woken up to check the line
+	// status register again.
+	idleWakeupDelay uint64 = 10000000
+)
+
+// Device is implemented by serial port drivers that can be used as a kernel
+// console.
+type Device interface {
+	device.Driver
+	io.Writer
+}
+
+// The offsets of the UART registers from the port base address. When the
+// DLAB bit of the line control register is set, the first two registers
+// provide access to the baud rate divisor latch.
+const (
+	regData        = 0
+	regIntEnable   = 1
+	regDivisorLo   = 0
+	regDivisorHi   = 1
+	regFIFOControl = 2
+	regLineControl = 3
+	regModemCtrl   = 4
+	regLineStatus  = 5
+)
+
+// The values programmed to the UART control registers.
+const (
+	lineControlDLAB  uint8 = 1 << 7
+	lineControl8N1   uint8 = 0x03
+	fifoEnableClear  uint8 = 0xc7
+	modemDTRRTSOut2  uint8 = 0x0b
+	modemLoopback    uint8 = 0x1e
+	lineStatusRxData uint8 = 1 << 0
+	lineStatusTxIdle uint8 = 1 << 5
+
+	// The value written and read back while the UART is in loopback mode.
+	loopbackTestByte uint8 = 0xae
+
+	// The frequency of the UART clock divided by 16.
+	baseBaudRate = uint32(115200)
+)
+
+// UART drives a 16550-compatible serial port. Once initialized, the UART
+// implements io.ReadWriter and can be used as a kernel console and as an input
+// source for the kernel shell.
+type UART struct {
+	info *table.SPCRInfo
+
+	// The base I/O port of the UART registers.
+	port uint16
+}
+
+// DriverName returns the name of this driver.
+func (*UART) DriverName() string {
+	return "UART"
+}
+
+// DriverVersion returns the version of this driver.
+func (*UART) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit programs the UART with the line settings reported by the SPCR
+// table and verifies that the UART is present by running a loopback test. If
+// the table does not specify a baud rate, the divisor latch is left untouched
+// so that the settings applied by the firmware are retained.
+func (u *UART) DriverInit(w io.Writer) *kernel.Error {
+	if u.info.BaseAddress.Space != table.AddressSpaceSysIO {
+		return errUnsupportedAddressSpace
+	}
+
+	u.port = uint16(u.info.BaseAddress.Address)
+
+	var divisor uint16
+	if u.info.BaudRate != 0 {
+		if u.info.BaudRate > baseBaudRate || baseBaudRate%u.info.BaudRate != 0 {
+			return errUnsupportedBaudRate
+		}
+		divisor = uint16(baseBaudRate / u.info.BaudRate)
+	}
+
+	u.write(regIntEnable, 0)
+	if divisor != 0 {
+		u.write(regLineControl, lineControlDLAB)
+		u.write(regDivisorLo, uint8(divisor))
+		u.write(regDivisorHi, uint8(divisor>>8))
+	}
+	u.write(regLineControl, lineControl8N1)
+	u.write(regFIFOControl, fifoEnableClear)
+
+	// Ensure that the UART is actually present by checking that a byte
+	// sent while in loopback mode can be read back.
+	u.write(regModemCtrl, modemLoopback)
+	u.write(regData, loopbackTestByte)
+	if got := u.read(regData); got != loopbackTestByte {
+		return errLoopbackFailed
+	}
+	u.write(regModemCtrl, modemDTRRTSOut2)
+
+	kfmt.Fprintf(w, "console on port 0x%x, baud: %d\n", u.port, u.info.BaudRate)
+	return nil
+}
+
+// Write implements io.Writer. Line feeds are translated to CR/LF pairs as
+// expected by serial terminals.
+func (u *UART) Write(p []byte) (int, error) {
+	for _, b := range p {
+		if b == '\n' {
+			u.writeChar('\r')
+		}
+		u.writeChar(b)
+	}
+
+	return len(p), nil
+}
+
+// Read implements io.Reader. It blocks until at least one character is
+// received and then returns the characters that are available in the receive
+// FIFO (up to len(p)). The CPU is idled while waiting for input. Received
+// characters are reported to the replay engine. While the replay engine
+// replays a log, the UART is not polled and Read returns the console input
+// re-injected by the engine instead.
+func (u *UART) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	for {
+		if n := readReplayedInput(p); n != 0 {
+			return n, nil
+		}
+
+		if replayEngine.Mode() != replay.ModeReplay && u.read(regLineStatus)&lineStatusRxData != 0 {
+			break
+		}
+
+		waitForInput()
+	}
+
+	n := 0
+	for n < len(p) && u.read(regLineStatus)&lineStatusRxData != 0 {
+		p[n] = u.read(regData)
+		replayEngine.Record(replay.EventConsoleInput, 0, uint64(p[n]))
+		n++
+	}
+
+	return n, nil
+}
+
+// waitForInput idles the CPU while Read waits for input. As the UART does not
+// raise an interrupt when data is received, a one-shot timer is armed to wake
+// the CPU up after idleWakeupDelay. If no clock event source is available, the
+// deferred work of the idle loop (e.g. ACPI events) is serviced without
+// idling the CPU.
+func waitForInput() {
+	if src := activeEventSourceFn(); src != nil && src.SetOneShot(idleWakeupDelay, wakeUp) == nil {
+		idleEnterFn()
+		return
+	}
+
+	idlePollFn()
+}
+
+// wakeUp is the handler for the timer armed by waitForInput. Delivering the
+// timer interrupt is enough to wake the CPU up so it does not need to do any
+// work.
+func wakeUp() {}
+
+// injectReplayedInput is registered as the replay engine injector for console
+// input events. If the buffer is full, the character is dropped.
+func injectReplayedInput(ev replay.Event) {
+	head, tail := atomic.LoadUint32(&replayedHead), atomic.LoadUint32(&replayedTail)
+	if tail-head == uint32(len(replayedInput)) {
+		return
+	}
+
+	replayedInput[tail%uint32(len(replayedInput))] = byte(ev.Payload)
+	atomic.StoreUint32(&replayedTail, tail+1)
+}
+
+// readReplayedInput copies the buffered re-injected console input to p and
+// returns the number of copied characters.
+func readReplayedInput(p []byte) int {
+	head, tail := atomic.LoadUint32(&replayedHead), atomic.LoadUint32(&replayedTail)
+
+	n := 0
+	for ; head != tail && n < len(p); head, n = head+1, n+1 {
+		p[n] = replayedInput[head%uint32(len(replayedInput))]
+	}
+	atomic.StoreUint32(&replayedHead, head)
+
+	return n
+}
+
+// writeChar waits for the transmitter holding register to become empty and
+// then transmits b. If the UART does not become ready within pollLimit polls,
+// the character is dropped so that a disconnected or misbehaving UART cannot
+// stall the kernel.
+func (u *UART) writeChar(b byte) {
+	for i := 0; i < pollLimit; i++ {
+		if u.read(regLineStatus)&lineStatusTxIdle != 0 {
+			u.write(regData, b)
+			return
+		}
+	}
+}
+
+func (u *UART) read(reg uint16) uint8 {
+	return portReadByteFn(u.port + reg)
+}
+
+func (u *UART) write(reg uint16, val uint8) {
+	portWriteByteFn(u.port+reg, val)
+}
+
+// probeForSPCR checks for the presence of a SPCR table that describes a
+// 16550-compatible UART.
+func probeForSPCR() device.Driver {
+	header := lookupTableFn("SPCR")
+	if header == nil {
+		return nil
+	}
+
+	info, err := table.DecodeSPCR(header)
+	if err != nil {
+		return nil
+	}
+
+	switch info.InterfaceType {
+	case table.SPCRInterface16550, table.SPCRInterface16450, table.SPCRInterface16550GAS:
+		return &UART{info: info}
+	}
+
+	return nil
+}
+
+func init() {
+	_ = replayEngine.SetInjector(replay.EventConsoleInput, injectReplayedInput)
+
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForSPCR,
+	})
+}
@@ -0,0 +1,413 @@
+package serial
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/idle"
+	"gopheros/kernel/replay"
+	"testing"
+	"unsafe"
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+
+	specs := []struct {
+		baud       uint32
+		expDivisor []uint8
+	}{
+		{115200, []uint8{1, 0}},
+		{9600, []uint8{12, 0}},
+		// the firmware settings should be retained
+		{0, nil},
+	}
+
+	for specIndex, spec := range specs {
+		fake := newFakeUART(0x3f8)
+		drv := &UART{info: testInfo(table.AddressSpaceSysIO, 0x3f8, spec.baud)}
+
+		var buf bytes.Buffer
+		if err := drv.DriverInit(&buf); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got := fake.divisor; !bytes.Equal(got, spec.expDivisor) {
+			t.Errorf("[spec %d] expected divisor latch to be set to %v; got %v", specIndex, spec.expDivisor, got)
+		}
+
+		if exp, got := lineControl8N1, fake.regs[regLineControl]; got != exp {
+			t.Errorf("[spec %d] expected line control register to be 0x%x; got 0x%x", specIndex, exp, got)
+		}
+
+		if exp, got := modemDTRRTSOut2, fake.regs[regModemCtrl]; got != exp {
+			t.Errorf("[spec %d] expected modem control register to be 0x%x; got 0x%x", specIndex, exp, got)
+		}
+
+		if buf.Len() == 0 {
+			t.Errorf("[spec %d] expected DriverInit to log the console settings", specIndex)
+		}
+	}
+}
+
+func TestDriverInitErrors(t *testing.T) {
+	defer restoreFns()
+
+	specs := []struct {
+		info     *table.SPCRInfo
+		noLoop   bool
+		expErr   *kernel.Error
+		expWrite bool
+	}{
+		{testInfo(table.AddressSpaceSysMemory, 0xfe000000, 115200), false, errUnsupportedAddressSpace, false},
+		{testInfo(table.AddressSpaceSysIO, 0x3f8, 7), false, errUnsupportedBaudRate, false},
+		{testInfo(table.AddressSpaceSysIO, 0x3f8, 230400), false, errUnsupportedBaudRate, false},
+		{testInfo(table.AddressSpaceSysIO, 0x3f8, 115200), true, errLoopbackFailed, true},
+	}
+
+	for specIndex, spec := range specs {
+		fake := newFakeUART(0x3f8)
+		fake.noLoopback = spec.noLoop
+
+		drv := &UART{info: spec.info}
+		if err := drv.DriverInit(&bytes.Buffer{}); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+
+		if gotWrite := fake.writeCount != 0; gotWrite != spec.expWrite {
+			t.Errorf("[spec %d] expected UART registers to be accessed: %t; got %t", specIndex, spec.expWrite, gotWrite)
+		}
+	}
+}
+
+func TestWrite(t *testing.T) {
+	defer restoreFns()
+
+	fake := newFakeUART(0x2f8)
+	drv := &UART{info: testInfo(table.AddressSpaceSysIO, 0x2f8, 0)}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+	fake.tx = nil
+
+	if n, err := drv.Write([]byte("a\nb")); err != nil || n != 3 {
+		t.Fatalf("expected Write to return (3, nil); got (%d, %v)", n, err)
+	}
+
+	if exp, got := "a\r\nb", string(fake.tx); got != exp {
+		t.Fatalf("expected UART to transmit %q; got %q", exp, got)
+	}
+
+	// Characters should be dropped if the transmitter never becomes ready
+	fake.tx = nil
+	fake.txBusy = true
+	pollLimit = 10
+	drv.Write([]byte("lost"))
+
+	if len(fake.tx) != 0 {
+		t.Fatalf("expected no characters to be transmitted while the UART is busy; got %q", fake.tx)
+	}
+}
+
+func TestRead(t *testing.T) {
+	defer restoreFns()
+
+	fake := newFakeUART(0x3f8)
+	drv := &UART{info: testInfo(table.AddressSpaceSysIO, 0x3f8, 0)}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := drv.Read(nil); n != 0 || err != nil {
+		t.Fatalf("expected Read with an empty buffer to return (0, nil); got (%d, %v)", n, err)
+	}
+
+	fake.rx = []byte("help\r")
+
+	specs := []struct {
+		bufLen int
+		exp    string
+	}{
+		{3, "hel"},
+		{16, "p\r"},
+	}
+
+	for specIndex, spec := range specs {
+		buf := make([]byte, spec.bufLen)
+		n, err := drv.Read(buf)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got := string(buf[:n]); got != spec.exp {
+			t.Errorf("[spec %d] expected Read to return %q; got %q", specIndex, spec.exp, got)
+		}
+	}
+
+	// Deferred work should be serviced while waiting for input
+	var polls int
+	idlePollFn = func() {
+		if polls++; polls == 3 {
+			fake.rx = []byte("q")
+		}
+	}
+
+	buf := make([]byte, 4)
+	if n, _ := drv.Read(buf); string(buf[:n]) != "q" || polls != 3 {
+		t.Fatalf("expected Read to return %q after 3 idle polls; got %q after %d polls", "q", buf[:n], polls)
+	}
+}
+
+func TestReadIdlesCPU(t *testing.T) {
+	defer func() {
+		restoreFns()
+		idle.SetHandler(nil)
+	}()
+
+	fake := newFakeUART(0x3f8)
+	drv := &UART{info: testInfo(table.AddressSpaceSysIO, 0x3f8, 0)}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	timer := &fakeEventSource{}
+	activeEventSourceFn = func() clock.EventSource { return timer }
+	idleEnterFn = idle.Enter
+
+	// The CPU should be idled via the installed idle handler with a
+	// wakeup timer armed
+	var idleCalls int
+	idle.SetHandler(func() {
+		if timer.handler == nil || timer.delay != idleWakeupDelay {
+			t.Errorf("expected a wakeup timer to be armed before idling the CPU")
+		}
+
+		if idleCalls++; idleCalls == 2 {
+			fake.rx = []byte("x")
+		}
+		timer.handler()
+	})
+
+	buf := make([]byte, 4)
+	if n, _ := drv.Read(buf); string(buf[:n]) != "x" || idleCalls != 2 {
+		t.Fatalf("expected Read to return %q after idling twice; got %q after %d idle calls", "x", buf[:n], idleCalls)
+	}
+
+	// If the wakeup timer cannot be armed, the CPU should not be idled
+	var polls int
+	timer.armErr = &kernel.Error{Module: "test", Message: "something went wrong"}
+	idlePollFn = func() {
+		polls++
+		fake.rx = []byte("y")
+	}
+
+	if n, _ := drv.Read(buf); string(buf[:n]) != "y" || polls != 1 || idleCalls != 2 {
+		t.Fatalf("expected Read to poll instead of idling the CPU; got %q after %d polls and %d idle calls", buf[:n], polls, idleCalls)
+	}
+}
+
+func TestReadRecordAndReplay(t *testing.T) {
+	defer restoreFns()
+
+	fake := newFakeUART(0x3f8)
+	drv := &UART{info: testInfo(table.AddressSpaceSysIO, 0x3f8, 0)}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// Received characters should be recorded
+	recorder := &replay.Engine{}
+	recorder.StartRecording(8)
+	replayEngine = recorder
+
+	fake.rx = []byte("ls")
+	buf := make([]byte, 8)
+	if n, _ := drv.Read(buf); string(buf[:n]) != "ls" {
+		t.Fatalf("expected Read to return %q; got %q", "ls", buf[:n])
+	}
+
+	events := recorder.Events()
+	if len(events) != 2 || events[0].Kind != replay.EventConsoleInput || events[0].Payload != 'l' || events[1].Payload != 's' {
+		t.Fatalf("expected received characters to be recorded; got %v", events)
+	}
+
+	// While replaying, the UART should not be polled and the re-injected
+	// input should be returned instead
+	var logBuf bytes.Buffer
+	recorder.WriteTo(&logBuf)
+
+	player := &replay.Engine{}
+	if err := player.StartReplay(logBuf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	replayEngine = player
+
+	fake.rx = []byte("xx")
+	for _, ev := range events {
+		injectReplayedInput(ev)
+	}
+
+	if n, _ := drv.Read(buf); string(buf[:n]) != "ls" {
+		t.Fatalf("expected Read to return the re-injected input %q; got %q", "ls", buf[:n])
+	}
+
+	if string(fake.rx) != "xx" {
+		t.Fatal("expected the UART not to be polled while replaying")
+	}
+
+	// Input that does not fit in the buffer is dropped
+	for i := 0; i < len(replayedInput)+1; i++ {
+		injectReplayedInput(replay.Event{Kind: replay.EventConsoleInput, Payload: 'a'})
+	}
+
+	if n := readReplayedInput(make([]byte, 2*len(replayedInput))); n != len(replayedInput) {
+		t.Fatalf("expected %d buffered characters; got %d", len(replayedInput), n)
+	}
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	spcrFor := func(ifaceType uint8) *table.SDTHeader {
+		data := append([]byte("SPCR"), make([]byte, 76)...)
+		data[4] = byte(len(data))
+		data[36] = ifaceType
+		return (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	}
+
+	specs := []struct {
+		header    *table.SDTHeader
+		expDriver bool
+	}{
+		{nil, false},
+		{spcrFor(uint8(table.SPCRInterface16550)), true},
+		{spcrFor(uint8(table.SPCRInterface16450)), true},
+		{spcrFor(uint8(table.SPCRInterface16550GAS)), true},
+		// ARM PL011 UART
+		{spcrFor(0x03), false},
+		// Truncated table
+		{&table.SDTHeader{Signature: [4]byte{'S', 'P', 'C', 'R'}, Length: 36}, false},
+	}
+
+	for specIndex, spec := range specs {
+		lookupTableFn = func(string) *table.SDTHeader { return spec.header }
+
+		if drv := probeForSPCR(); (drv != nil) != spec.expDriver {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDriver, drv)
+		}
+	}
+}
+
+// fakeUART emulates the registers of a 16550 UART.
+type fakeUART struct {
+	base       uint16
+	regs       [8]uint8
+	divisor    []uint8
+	tx         []byte
+	rx         []byte
+	writeCount int
+
+	// Set to emulate a missing UART or a UART whose transmitter never
+	// becomes ready.
+	noLoopback bool
+	txBusy     bool
+}
+
+// newFakeUART returns a fake UART at the specified base port and installs
+// port access hooks that redirect to it.
+func newFakeUART(base uint16) *fakeUART {
+	fake := &fakeUART{base: base}
+	portReadByteFn = fake.read
+	portWriteByteFn = fake.write
+	idlePollFn = func() {}
+	activeEventSourceFn = func() clock.EventSource { return nil }
+	return fake
+}
+
+// fakeEventSource is a clock.EventSource that records the last armed
+// one-shot timer.
+type fakeEventSource struct {
+	delay   uint64
+	handler clock.EventHandler
+	armErr  *kernel.Error
+}
+
+func (*fakeEventSource) EventSourceName() string                              { return "fake" }
+func (*fakeEventSource) EventSourceRating() uint8                             { return 1 }
+func (*fakeEventSource) SetPeriodic(uint64, clock.EventHandler) *kernel.Error { return nil }
+func (*fakeEventSource) Stop()                                                {}
+
+func (f *fakeEventSource) SetOneShot(delay uint64, handler clock.EventHandler) *kernel.Error {
+	if f.armErr != nil {
+		return f.armErr
+	}
+
+	f.delay, f.handler = delay, handler
+	return nil
+}
+
+func (f *fakeUART) read(port uint16) uint8 {
+	switch reg := port - f.base; reg {
+	case regLineStatus:
+		var status uint8
+		if !f.txBusy {
+			status |= lineStatusTxIdle
+		}
+		if len(f.rx) != 0 {
+			status |= lineStatusRxData
+		}
+		return status
+	case regData:
+		if f.regs[regModemCtrl] == modemLoopback && !f.noLoopback {
+			return f.regs[regData]
+		}
+		if len(f.rx) != 0 {
+			ch := f.rx[0]
+			f.rx = f.rx[1:]
+			return ch
+		}
+		return 0xff
+	default:
+		return f.regs[reg]
+	}
+}
+
+func (f *fakeUART) write(port uint16, val uint8) {
+	f.writeCount++
+	reg := port - f.base
+
+	if f.regs[regLineControl]&lineControlDLAB != 0 && reg <= regDivisorHi {
+		f.divisor = append(f.divisor, val)
+		return
+	}
+
+	if reg == regData && f.regs[regModemCtrl] != modemLoopback {
+		f.tx = append(f.tx, val)
+	}
+	f.regs[reg] = val
+}
+
+func testInfo(space table.AddressSpace, addr uint64, baud uint32) *table.SPCRInfo {
+	return &table.SPCRInfo{
+		InterfaceType: table.SPCRInterface16550,
+		BaseAddress:   table.GenericAddress{Space: space, Address: addr},
+		BaudRate:      baud,
+	}
+}
+
+func restoreFns() {
+	lookupTableFn = acpi.LookupTable
+	portReadByteFn = cpu.PortReadByte
+	portWriteByteFn = cpu.PortWriteByte
+	idlePollFn = idle.Poll
+	idleEnterFn = idle.Enter
+	activeEventSourceFn = clock.ActiveEventSource
+	pollLimit = 100000
+	replayEngine = replay.Global()
+	replayedHead, replayedTail = 0, 0
+}
@@ -0,0 +1,145 @@
+package tpm
+
+import "gopheros/kernel"
+
+var (
+	errCommandFailed = &kernel.Error{Module: "tpm", Message: "TPM command failed", Code: kernel.ErrCodeIO}
+	errPCRNotFound   = &kernel.Error{Module: "tpm", Message: "TPM did not return the requested PCR", Code: kernel.ErrCodeNotFound}
+)
+
+// HashAlg identifies a hash algorithm supported by the TPM.
+type HashAlg uint16
+
+// The list of hash algorithms used for PCR banks.
+const (
+	AlgSHA1   HashAlg = 0x0004
+	AlgSHA256 HashAlg = 0x000b
+)
+
+// The command tags, codes and structure sizes used by the supported
+// commands.
+const (
+	// The length of the command and response headers.
+	headerLen = 10
+
+	tagNoSessions = uint16(0x8001)
+
+	ccGetRandom = uint32(0x0000017b)
+	ccPCRRead   = uint32(0x0000017e)
+
+	// The number of bytes in the PCR selection bitmap. It covers the 24
+	// PCRs that are defined by the PC client platform specification.
+	pcrSelectSize = 3
+	maxPCR        = pcrSelectSize*8 - 1
+)
+
+// GetRandom requests up to n random bytes from the TPM random number
+// generator. The TPM may return fewer bytes than requested.
+func GetRandom(dev Device, n uint16) ([]byte, *kernel.Error) {
+	cmd := newCommand(ccGetRandom)
+	cmd = append(cmd, byte(n>>8), byte(n))
+
+	params, err := execute(dev, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	return tpm2b(params)
+}
+
+// PCRRead returns the contents of the specified PCR in the PCR bank that
+// uses the specified hash algorithm.
+func PCRRead(dev Device, alg HashAlg, pcr uint8) ([]byte, *kernel.Error) {
+	if pcr > maxPCR {
+		return nil, errMalformedCommand
+	}
+
+	cmd := newCommand(ccPCRRead)
+	// TPML_PCR_SELECTION with a single TPMS_PCR_SELECTION entry
+	cmd = append(cmd, 0, 0, 0, 1, byte(alg>>8), byte(alg), pcrSelectSize)
+	selection := [pcrSelectSize]byte{}
+	selection[pcr/8] = 1 << (pcr % 8)
+	cmd = append(cmd, selection[:]...)
+
+	params, err := execute(dev, cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	// Skip the PCR update counter and the returned PCR selection; the
+	// selection is empty if the TPM does not implement the requested bank
+	if len(params) < 8 {
+		return nil, errMalformedResponse
+	}
+
+	selCount := be32(params[4:])
+	params = params[8:]
+	for ; selCount > 0; selCount-- {
+		if len(params) < 3 || len(params) < 3+int(params[2]) {
+			return nil, errMalformedResponse
+		}
+		params = params[3+int(params[2]):]
+	}
+
+	if len(params) < 4 {
+		return nil, errMalformedResponse
+	}
+
+	if digestCount := be32(params); digestCount == 0 {
+		return nil, errPCRNotFound
+	}
+
+	return tpm2b(params[4:])
+}
+
+// newCommand returns the header of a command without sessions. The command
+// size is filled in by execute.
+func newCommand(code uint32) []byte {
+	return []byte{
+		byte(tagNoSessions >> 8), byte(tagNoSessions & 0xff),
+		0, 0, 0, 0,
+		byte(code >> 24), byte(code >> 16), byte(code >> 8), byte(code),
+	}
+}
+
+// execute sets the size of cmd, submits it to the TPM and returns the
+// response parameters if the command succeeded.
+func execute(dev Device, cmd []byte) ([]byte, *kernel.Error) {
+	size := uint32(len(cmd))
+	cmd[2], cmd[3], cmd[4], cmd[5] = byte(size>>24), byte(size>>16), byte(size>>8), byte(size)
+
+	rsp, err := dev.Transmit(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if rc := be32(rsp[6:]); rc != 0 {
+		return nil, errCommandFailed
+	}
+
+	return rsp[headerLen:], nil
+}
+
+// tpm2b decodes the size-prefixed byte buffer stored at the start of buf.
+func tpm2b(buf []byte) ([]byte, *kernel.Error) {
+	if len(buf) < 2 {
+		return nil, errMalformedResponse
+	}
+
+	size := int(be16(buf))
+	if len(buf) < 2+size {
+		return nil, errMalformedResponse
+	}
+
+	return buf[2 : 2+size], nil
+}
+
+// be16 returns the big-endian word stored in the first 2 bytes of buf.
+func be16(buf []byte) uint16 {
+	return uint16(buf[0])<<8 | uint16(buf[1])
+}
+
+// be32 returns the big-endian dword stored in the first 4 bytes of buf.
+func be32(buf []byte) uint32 {
+	return uint32(buf[0])<<24 | uint32(buf[1])<<16 | uint32(buf[2])<<8 | uint32(buf[3])
+}
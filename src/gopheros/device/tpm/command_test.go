@@ -0,0 +1,125 @@
+package tpm
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"io"
+	"testing"
+)
+
+func TestGetRandom(t *testing.T) {
+	dev := &fakeDevice{
+		handler: func(cmd []byte) []byte {
+			exp := []byte{0x80, 0x01, 0, 0, 0, 12, 0, 0, 0x01, 0x7b, 0, 4}
+			if !bytes.Equal(cmd, exp) {
+				t.Errorf("expected command %x; got %x", exp, cmd)
+			}
+			return response(0, 0, 4, 1, 2, 3, 4)
+		},
+	}
+
+	got, err := GetRandom(dev, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := []byte{1, 2, 3, 4}; !bytes.Equal(got, exp) {
+		t.Fatalf("expected to get %x; got %x", exp, got)
+	}
+
+	specs := []struct {
+		rsp    []byte
+		expErr *kernel.Error
+	}{
+		{response(0x101), errCommandFailed},
+		{response(0, 0), errMalformedResponse},
+		{response(0, 0, 4, 1), errMalformedResponse},
+	}
+
+	for specIndex, spec := range specs {
+		dev.handler = func([]byte) []byte { return spec.rsp }
+		if _, err := GetRandom(dev, 4); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestPCRRead(t *testing.T) {
+	digest := bytes.Repeat([]byte{0xab}, 32)
+	pcrResponse := func(selections, digests uint8) []byte {
+		params := []byte{0, 0, 0, 42, 0, 0, 0, selections}
+		for i := uint8(0); i < selections; i++ {
+			params = append(params, 0, 0x0b, 3, 0, 0x80, 0)
+		}
+		params = append(params, 0, 0, 0, digests)
+		for i := uint8(0); i < digests; i++ {
+			params = append(params, 0, 32)
+			params = append(params, digest...)
+		}
+		return response(0, params...)
+	}
+
+	dev := &fakeDevice{
+		handler: func(cmd []byte) []byte {
+			exp := []byte{0x80, 0x01, 0, 0, 0, 20, 0, 0, 0x01, 0x7e, 0, 0, 0, 1, 0, 0x0b, 3, 0, 0x80, 0}
+			if !bytes.Equal(cmd, exp) {
+				t.Errorf("expected command %x; got %x", exp, cmd)
+			}
+			return pcrResponse(1, 1)
+		},
+	}
+
+	got, err := PCRRead(dev, AlgSHA256, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(got, digest) {
+		t.Fatalf("expected to get %x; got %x", digest, got)
+	}
+
+	specs := []struct {
+		rsp    []byte
+		expErr *kernel.Error
+	}{
+		// Bank not implemented
+		{pcrResponse(0, 0), errPCRNotFound},
+		{response(0, 0, 0, 0, 42), errMalformedResponse},
+		{response(0, 0, 0, 0, 42, 0, 0, 0, 1, 0, 0x0b, 3), errMalformedResponse},
+		{pcrResponse(1, 0)[:len(pcrResponse(1, 0))-2], errMalformedResponse},
+		{response(0x18b), errCommandFailed},
+	}
+
+	for specIndex, spec := range specs {
+		dev.handler = func([]byte) []byte { return spec.rsp }
+		if _, err := PCRRead(dev, AlgSHA256, 15); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if _, err := PCRRead(dev, AlgSHA256, maxPCR+1); err != errMalformedCommand {
+		t.Fatalf("expected to get error %v; got %v", errMalformedCommand, err)
+	}
+}
+
+// fakeDevice is a Device that passes commands to a handler.
+type fakeDevice struct {
+	handler func(cmd []byte) []byte
+}
+
+func (*fakeDevice) DriverName() string                            { return "fake" }
+func (*fakeDevice) DriverVersion() (uint16, uint16, uint16)       { return 0, 0, 0 }
+func (*fakeDevice) DriverInit(io.Writer) *kernel.Error            { return nil }
+func (d *fakeDevice) Transmit(cmd []byte) ([]byte, *kernel.Error) { return d.handler(cmd), nil }
+
+// response returns a marshaled response with the specified response code and
+// parameters.
+func response(rc uint32, params ...byte) []byte {
+	size := uint32(headerLen + len(params))
+	rsp := []byte{
+		0x80, 0x01,
+		byte(size >> 24), byte(size >> 16), byte(size >> 8), byte(size),
+		byte(rc >> 24), byte(rc >> 16), byte(rc >> 8), byte(rc),
+	}
+	return append(rsp, params...)
+}
@@ -0,0 +1,157 @@
+package tpm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+)
+
+var (
+	errCRBError          = &kernel.Error{Module: "tpm", Message: "TPM reports a fatal error", Code: kernel.ErrCodeIO}
+	errCommandTooLarge   = &kernel.Error{Module: "tpm", Message: "command does not fit in the TPM command buffer", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidCRBBuffers = &kernel.Error{Module: "tpm", Message: "TPM reports invalid command/response buffers", Code: kernel.ErrCodeCorrupted}
+)
+
+// The offsets of the CRB control area registers.
+const (
+	crbRequest     = 0x00
+	crbStatus      = 0x04
+	crbCancel      = 0x08
+	crbStart       = 0x0c
+	crbCmdSize     = 0x18
+	crbCmdAddrLow  = 0x1c
+	crbCmdAddrHigh = 0x20
+	crbRspSize     = 0x24
+	crbRspAddr     = 0x28
+
+	// The size of the control area.
+	crbCtrlAreaSize = 0x30
+)
+
+// The offsets of the locality 0 registers. When the TPM implements the
+// locality registers, the control area is located at offset
+// crbCtrlAreaOffset from the locality register block.
+const (
+	crbLocCtrl        = 0x08
+	crbLocStatus      = 0x0c
+	crbCtrlAreaOffset = 0x40
+)
+
+// The bits of the CRB registers.
+const (
+	crbReqCmdReady      = uint32(1 << 0)
+	crbReqGoIdle        = uint32(1 << 1)
+	crbStsError         = uint32(1 << 0)
+	crbStartCmd         = uint32(1 << 0)
+	crbLocRequestAccess = uint32(1 << 0)
+	crbLocRelinquish    = uint32(1 << 1)
+	crbLocGranted       = uint32(1 << 0)
+)
+
+// crbTransport implements the command response buffer interface.
+type crbTransport struct {
+	// The physical address of the control area.
+	ctrlAreaAddr uintptr
+
+	// The virtual addresses of the control area and the locality
+	// registers. The latter is 0 if the TPM does not implement them.
+	ctrl uintptr
+	loc  uintptr
+
+	cmdBuf []byte
+	rspBuf []byte
+}
+
+// init maps the control area and the command and response buffers.
+func (t *crbTransport) init() *kernel.Error {
+	var err *kernel.Error
+	if t.ctrl, err = mapRegisters(t.ctrlAreaAddr, crbCtrlAreaSize); err != nil {
+		return err
+	}
+
+	// The locality registers reside in the same page as the control area
+	if t.ctrlAreaAddr%mm.PageSize == crbCtrlAreaOffset {
+		t.loc = t.ctrl - crbCtrlAreaOffset
+	}
+
+	cmdAddr := uintptr(readRegFn(t.ctrl+crbCmdAddrHigh, 32))<<32 | uintptr(readRegFn(t.ctrl+crbCmdAddrLow, 32))
+	cmdSize := readRegFn(t.ctrl+crbCmdSize, 32)
+	rspAddr := uintptr(readRegFn(t.ctrl+crbRspAddr+4, 32))<<32 | uintptr(readRegFn(t.ctrl+crbRspAddr, 32))
+	rspSize := readRegFn(t.ctrl+crbRspSize, 32)
+
+	if cmdAddr == 0 || rspAddr == 0 || cmdSize < headerLen || rspSize < headerLen {
+		return errInvalidCRBBuffers
+	}
+
+	if t.cmdBuf, err = mapBuffer(cmdAddr, cmdSize); err != nil {
+		return err
+	}
+
+	// Some TPMs use the same buffer for both commands and responses
+	if rspAddr == cmdAddr && rspSize == cmdSize {
+		t.rspBuf = t.cmdBuf
+	} else if t.rspBuf, err = mapBuffer(rspAddr, rspSize); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// transmit copies cmd to the command buffer, starts its execution and waits
+// for the TPM to place the response in the response buffer.
+func (t *crbTransport) transmit(cmd []byte) ([]byte, *kernel.Error) {
+	if len(cmd) > len(t.cmdBuf) {
+		return nil, errCommandTooLarge
+	}
+
+	if err := t.requestLocality(); err != nil {
+		return nil, err
+	}
+	defer t.relinquishLocality()
+
+	// Wake the TPM up and wait for it to become ready
+	writeRegFn(t.ctrl+crbRequest, 32, crbReqCmdReady)
+	if err := waitReg(t.ctrl+crbRequest, 32, crbReqCmdReady, 0); err != nil {
+		return nil, err
+	}
+
+	if readRegFn(t.ctrl+crbStatus, 32)&crbStsError != 0 {
+		return nil, errCRBError
+	}
+
+	copy(t.cmdBuf, cmd)
+	writeRegFn(t.ctrl+crbStart, 32, crbStartCmd)
+	if err := waitReg(t.ctrl+crbStart, 32, crbStartCmd, 0); err != nil {
+		writeRegFn(t.ctrl+crbCancel, 32, 1)
+		return nil, err
+	}
+
+	rspLen := int(be32(t.rspBuf[2:]))
+	if rspLen < headerLen || rspLen > len(t.rspBuf) {
+		return nil, errMalformedResponse
+	}
+
+	rsp := make([]byte, rspLen)
+	copy(rsp, t.rspBuf)
+
+	writeRegFn(t.ctrl+crbRequest, 32, crbReqGoIdle)
+	return rsp, nil
+}
+
+// requestLocality requests access to locality 0 if the TPM implements the
+// locality registers.
+func (t *crbTransport) requestLocality() *kernel.Error {
+	if t.loc == 0 {
+		return nil
+	}
+
+	writeRegFn(t.loc+crbLocCtrl, 32, crbLocRequestAccess)
+	return waitReg(t.loc+crbLocStatus, 32, crbLocGranted, crbLocGranted)
+}
+
+// relinquishLocality releases locality 0 if the TPM implements the locality
+// registers.
+func (t *crbTransport) relinquishLocality() {
+	if t.loc != 0 {
+		writeRegFn(t.loc+crbLocCtrl, 32, crbLocRelinquish)
+	}
+}
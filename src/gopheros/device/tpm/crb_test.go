@@ -0,0 +1,201 @@
+package tpm
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"testing"
+	"unsafe"
+)
+
+func TestCRBInit(t *testing.T) {
+	defer restoreFns()
+
+	t.Run("shared buffer", func(t *testing.T) {
+		fake := newFakeCRB(0xfed40040, 64)
+		fake.install()
+
+		tr := &crbTransport{ctrlAreaAddr: 0xfed40040}
+		if err := tr.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := uintptr(0xfed40000); tr.loc != exp {
+			t.Errorf("expected locality registers at 0x%x; got 0x%x", exp, tr.loc)
+		}
+
+		if &tr.cmdBuf[0] != &tr.rspBuf[0] || len(tr.cmdBuf) != 64 {
+			t.Error("expected command and response buffers to be shared")
+		}
+	})
+
+	t.Run("separate buffers", func(t *testing.T) {
+		fake := newFakeCRB(0xfed40000, 64)
+		fake.rspBuf = make([]byte, 128)
+		fake.regs[0xfed40000+crbRspAddr] = uint32(uintptr(unsafe.Pointer(&fake.rspBuf[0])))
+		fake.regs[0xfed40000+crbRspAddr+4] = uint32(uint64(uintptr(unsafe.Pointer(&fake.rspBuf[0]))) >> 32)
+		fake.regs[0xfed40000+crbRspSize] = 128
+		fake.install()
+
+		tr := &crbTransport{ctrlAreaAddr: 0xfed40000}
+		if err := tr.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		if tr.loc != 0 {
+			t.Errorf("expected locality registers to be absent; got 0x%x", tr.loc)
+		}
+
+		if &tr.cmdBuf[0] == &tr.rspBuf[0] || len(tr.rspBuf) != 128 {
+			t.Error("expected separate command and response buffers")
+		}
+	})
+
+	t.Run("invalid buffers", func(t *testing.T) {
+		fake := newFakeCRB(0xfed40040, 64)
+		fake.regs[0xfed40040+crbCmdSize] = 4
+		fake.install()
+
+		tr := &crbTransport{ctrlAreaAddr: 0xfed40040}
+		if err := tr.init(); err != errInvalidCRBBuffers {
+			t.Fatalf("expected to get error %v; got %v", errInvalidCRBBuffers, err)
+		}
+	})
+}
+
+func TestCRBTransmit(t *testing.T) {
+	defer restoreFns()
+	pollLimit = 10
+
+	specs := []struct {
+		setup     func(*fakeCRB)
+		cmd       []byte
+		expErr    *kernel.Error
+		expCancel bool
+	}{
+		{nil, response(0), nil, false},
+		{nil, make([]byte, 65), errCommandTooLarge, false},
+		{func(f *fakeCRB) { f.denyLocality = true }, response(0), errTimeout, false},
+		{func(f *fakeCRB) { f.stuckRequest = true }, response(0), errTimeout, false},
+		{func(f *fakeCRB) { f.regs[f.ctrl+crbStatus] = crbStsError }, response(0), errCRBError, false},
+		{func(f *fakeCRB) { f.stuckStart = true }, response(0), errTimeout, true},
+		{
+			func(f *fakeCRB) {
+				f.handler = func([]byte) []byte { return []byte{0x80, 0x01, 0, 0, 1, 0, 0, 0, 0, 0} }
+			},
+			response(0), errMalformedResponse, false,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		fake := newFakeCRB(0xfed40040, 64)
+		if spec.setup != nil {
+			spec.setup(fake)
+		}
+		fake.install()
+
+		tr := &crbTransport{ctrlAreaAddr: 0xfed40040}
+		if err := tr.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := tr.transmit(spec.cmd)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if fake.cancelled != spec.expCancel {
+			t.Errorf("[spec %d] expected cancel to be %t; got %t", specIndex, spec.expCancel, fake.cancelled)
+		}
+
+		if spec.expErr == nil {
+			if exp := response(0, 0xaa); !bytes.Equal(rsp, exp) {
+				t.Errorf("[spec %d] expected response %x; got %x", specIndex, exp, rsp)
+			}
+
+			if fake.lastRequest != crbReqGoIdle {
+				t.Errorf("[spec %d] expected TPM to be sent to the idle state", specIndex)
+			}
+		}
+
+		if fake.locRequested && fake.regs[fake.ctrl-crbCtrlAreaOffset+crbLocStatus] != 0 {
+			t.Errorf("[spec %d] expected locality to be relinquished", specIndex)
+		}
+	}
+}
+
+// fakeCRB emulates the CRB registers of a TPM whose control area is located
+// at ctrl.
+type fakeCRB struct {
+	ctrl uintptr
+	regs map[uintptr]uint32
+
+	cmdBuf []byte
+	rspBuf []byte
+
+	handler func(cmd []byte) []byte
+
+	denyLocality bool
+	stuckRequest bool
+	stuckStart   bool
+
+	locRequested bool
+	cancelled    bool
+	lastRequest  uint32
+}
+
+func newFakeCRB(ctrl uintptr, bufSize uint32) *fakeCRB {
+	f := &fakeCRB{
+		ctrl:    ctrl,
+		regs:    make(map[uintptr]uint32),
+		cmdBuf:  make([]byte, bufSize),
+		handler: func([]byte) []byte { return response(0, 0xaa) },
+	}
+	f.rspBuf = f.cmdBuf
+
+	bufAddr := uint64(uintptr(unsafe.Pointer(&f.cmdBuf[0])))
+	f.regs[ctrl+crbCmdAddrLow] = uint32(bufAddr)
+	f.regs[ctrl+crbCmdAddrHigh] = uint32(bufAddr >> 32)
+	f.regs[ctrl+crbCmdSize] = bufSize
+	f.regs[ctrl+crbRspAddr] = uint32(bufAddr)
+	f.regs[ctrl+crbRspAddr+4] = uint32(bufAddr >> 32)
+	f.regs[ctrl+crbRspSize] = bufSize
+
+	return f
+}
+
+func (f *fakeCRB) install() {
+	mockMapRegion()
+	readRegFn = func(addr uintptr, _ uint8) uint32 { return f.regs[addr] }
+	writeRegFn = f.write
+}
+
+func (f *fakeCRB) write(addr uintptr, _ uint8, val uint32) {
+	loc := f.ctrl - crbCtrlAreaOffset
+
+	switch addr {
+	case f.ctrl + crbRequest:
+		f.lastRequest = val
+		if f.stuckRequest {
+			f.regs[addr] = val
+		}
+	case f.ctrl + crbStart:
+		if f.stuckStart {
+			f.regs[addr] = val
+			return
+		}
+		copy(f.rspBuf, f.handler(f.cmdBuf[:be32(f.cmdBuf[2:])]))
+	case f.ctrl + crbCancel:
+		f.cancelled = true
+	case loc + crbLocCtrl:
+		switch {
+		case val == crbLocRequestAccess && !f.denyLocality:
+			f.locRequested = true
+			f.regs[loc+crbLocStatus] = crbLocGranted
+		case val == crbLocRelinquish:
+			f.regs[loc+crbLocStatus] = 0
+		}
+	default:
+		f.regs[addr] = val
+	}
+}
@@ -0,0 +1,132 @@
+package tpm
+
+import "gopheros/kernel"
+
+var errTISUnexpectedData = &kernel.Error{Module: "tpm", Message: "TPM expects more command data or returned extra response data", Code: kernel.ErrCodeIO}
+
+// The physical address and size of the locality 0 TIS register block. The
+// location is fixed by the PC client platform specification.
+const (
+	tisBaseAddress = uintptr(0xfed40000)
+	tisRegionSize  = 0x1000
+)
+
+// The offsets of the TIS registers.
+const (
+	tisAccess     = 0x00
+	tisStatus     = 0x18
+	tisBurstCount = 0x19
+	tisDataFIFO   = 0x24
+)
+
+// The bits of the TIS registers.
+const (
+	tisAccessValid        = uint32(1 << 7)
+	tisAccessActive       = uint32(1 << 5)
+	tisAccessRequestUse   = uint32(1 << 1)
+	tisStsValid           = uint32(1 << 7)
+	tisStsCommandReady    = uint32(1 << 6)
+	tisStsGo              = uint32(1 << 5)
+	tisStsDataAvail       = uint32(1 << 4)
+	tisStsExpect          = uint32(1 << 3)
+	tisStsValidDataAvail  = tisStsValid | tisStsDataAvail
+	tisAccessValidActive  = tisAccessValid | tisAccessActive
+	tisStsValidExpectMask = tisStsValid | tisStsExpect
+)
+
+// tisTransport implements the memory-mapped FIFO interface.
+type tisTransport struct {
+	// The virtual address of the locality 0 registers.
+	regs uintptr
+}
+
+// init maps the locality 0 registers.
+func (t *tisTransport) init() *kernel.Error {
+	var err *kernel.Error
+	t.regs, err = mapRegisters(tisBaseAddress, tisRegionSize)
+	return err
+}
+
+// transmit writes cmd to the data FIFO, starts its execution and reads back
+// the response.
+func (t *tisTransport) transmit(cmd []byte) ([]byte, *kernel.Error) {
+	writeRegFn(t.regs+tisAccess, 8, tisAccessRequestUse)
+	if err := waitReg(t.regs+tisAccess, 8, tisAccessValidActive, tisAccessValidActive); err != nil {
+		return nil, err
+	}
+
+	// Relinquish the locality once the command completes
+	defer writeRegFn(t.regs+tisAccess, 8, tisAccessActive)
+
+	writeRegFn(t.regs+tisStatus, 8, tisStsCommandReady)
+	if err := waitReg(t.regs+tisStatus, 8, tisStsCommandReady, tisStsCommandReady); err != nil {
+		return nil, err
+	}
+
+	// Abort any partially executed command on exit and return the TPM
+	// to the ready state
+	defer writeRegFn(t.regs+tisStatus, 8, tisStsCommandReady)
+
+	for _, b := range cmd {
+		if err := t.waitBurstCount(); err != nil {
+			return nil, err
+		}
+		writeRegFn(t.regs+tisDataFIFO, 8, uint32(b))
+	}
+
+	// The TPM should not expect any further data
+	if err := waitReg(t.regs+tisStatus, 8, tisStsValidExpectMask, tisStsValid); err != nil {
+		return nil, errTISUnexpectedData
+	}
+
+	writeRegFn(t.regs+tisStatus, 8, tisStsGo)
+	if err := waitReg(t.regs+tisStatus, 8, tisStsValidDataAvail, tisStsValidDataAvail); err != nil {
+		return nil, err
+	}
+
+	rsp := make([]byte, headerLen)
+	if err := t.readFIFO(rsp); err != nil {
+		return nil, err
+	}
+
+	rspLen := int(be32(rsp[2:]))
+	if rspLen < headerLen {
+		return nil, errMalformedResponse
+	}
+
+	rsp = append(rsp, make([]byte, rspLen-headerLen)...)
+	if err := t.readFIFO(rsp[headerLen:]); err != nil {
+		return nil, err
+	}
+
+	// The entire response should have been consumed
+	if readRegFn(t.regs+tisStatus, 8)&tisStsValidDataAvail == tisStsValidDataAvail {
+		return nil, errTISUnexpectedData
+	}
+
+	return rsp, nil
+}
+
+// readFIFO fills buf with data read from the data FIFO.
+func (t *tisTransport) readFIFO(buf []byte) *kernel.Error {
+	for i := range buf {
+		if err := t.waitBurstCount(); err != nil {
+			return err
+		}
+		buf[i] = uint8(readRegFn(t.regs+tisDataFIFO, 8))
+	}
+
+	return nil
+}
+
+// waitBurstCount waits until the TPM can accept or provide data via the
+// data FIFO.
+func (t *tisTransport) waitBurstCount() *kernel.Error {
+	for i := 0; i < pollLimit; i++ {
+		if readRegFn(t.regs+tisBurstCount, 16) != 0 {
+			return nil
+		}
+	}
+
+	return errTimeout
+}
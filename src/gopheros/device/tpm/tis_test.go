@@ -0,0 +1,149 @@
+package tpm
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestTISTransmit(t *testing.T) {
+	defer restoreFns()
+	pollLimit = 10
+
+	specs := []struct {
+		setup  func(*fakeTIS)
+		cmd    []byte
+		expErr *kernel.Error
+		expRsp []byte
+	}{
+		{nil, response(0), nil, response(0, 0xaa, 0xbb)},
+		{func(f *fakeTIS) { f.denyLocality = true }, response(0), errTimeout, nil},
+		{func(f *fakeTIS) { f.neverReady = true }, response(0), errTimeout, nil},
+		{func(f *fakeTIS) { f.burstCount = 0 }, response(0), errTimeout, nil},
+		// Command shorter than its header claims
+		{nil, response(0, 1)[:headerLen], errTISUnexpectedData, nil},
+		{func(f *fakeTIS) { f.neverExecutes = true }, response(0), errTimeout, nil},
+		{
+			func(f *fakeTIS) {
+				f.handler = func([]byte) []byte { return []byte{0x80, 0x01, 0, 0, 0, 2, 0, 0, 0, 0} }
+			},
+			response(0), errMalformedResponse, nil,
+		},
+		{
+			func(f *fakeTIS) {
+				f.handler = func([]byte) []byte { return append(response(0), 0xff) }
+			},
+			response(0), errTISUnexpectedData, nil,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		fake := newFakeTIS()
+		if spec.setup != nil {
+			spec.setup(fake)
+		}
+		fake.install()
+
+		tr := &tisTransport{}
+		if err := tr.init(); err != nil {
+			t.Fatal(err)
+		}
+
+		rsp, err := tr.transmit(spec.cmd)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if !bytes.Equal(rsp, spec.expRsp) {
+			t.Errorf("[spec %d] expected response %x; got %x", specIndex, spec.expRsp, rsp)
+		}
+
+		if fake.access&tisAccessActive != 0 {
+			t.Errorf("[spec %d] expected locality to be relinquished", specIndex)
+		}
+	}
+}
+
+// fakeTIS emulates the locality 0 TIS registers of a TPM.
+type fakeTIS struct {
+	access     uint32
+	ready      bool
+	burstCount uint32
+	in, out    []byte
+
+	handler func(cmd []byte) []byte
+
+	denyLocality  bool
+	neverReady    bool
+	neverExecutes bool
+}
+
+func newFakeTIS() *fakeTIS {
+	return &fakeTIS{
+		access:     tisAccessValid,
+		burstCount: 1,
+		handler:    func([]byte) []byte { return response(0, 0xaa, 0xbb) },
+	}
+}
+
+func (f *fakeTIS) install() {
+	mockMapRegion()
+	readRegFn = f.read
+	writeRegFn = f.write
+}
+
+func (f *fakeTIS) read(addr uintptr, _ uint8) uint32 {
+	switch addr - tisBaseAddress {
+	case tisAccess:
+		return f.access
+	case tisStatus:
+		sts := tisStsValid
+		if f.ready {
+			sts |= tisStsCommandReady
+		}
+		if len(f.out) != 0 {
+			sts |= tisStsDataAvail
+		}
+		if f.ready && (len(f.in) < headerLen || len(f.in) < int(be32(f.in[2:]))) {
+			sts |= tisStsExpect
+		}
+		return sts
+	case tisBurstCount:
+		return f.burstCount
+	case tisDataFIFO:
+		if len(f.out) == 0 {
+			return 0xff
+		}
+		b := f.out[0]
+		f.out = f.out[1:]
+		return uint32(b)
+	}
+
+	return 0
+}
+
+func (f *fakeTIS) write(addr uintptr, _ uint8, val uint32) {
+	switch addr - tisBaseAddress {
+	case tisAccess:
+		switch {
+		case val == tisAccessRequestUse && !f.denyLocality:
+			f.access = tisAccessValidActive
+		case val == tisAccessActive:
+			f.access = tisAccessValid
+		}
+	case tisStatus:
+		switch val {
+		case tisStsCommandReady:
+			f.ready = !f.neverReady
+			f.in, f.out = nil, nil
+		case tisStsGo:
+			f.ready = false
+			if !f.neverExecutes {
+				f.out = f.handler(f.in)
+			}
+		}
+	case tisDataFIFO:
+		f.in = append(f.in, byte(val))
+	}
+}
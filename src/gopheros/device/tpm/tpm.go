@@ -0,0 +1,200 @@
+// Package tpm implements a minimal driver for TPM 2.0 devices. The TPM is
+// located via the TPM2 ACPI table which describes whether it implements the
+// command response buffer (CRB) or the memory-mapped FIFO (TIS) interface.
+// The driver can submit raw TPM commands and provides helpers for commands
+// that are needed for measured boot support.
+package tpm
+
+import (
+	"gopheros/device"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"io"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	errUnsupportedStartMethod = &kernel.Error{Module: "tpm", Message: "unsupported TPM start method", Code: kernel.ErrCodeNotSupported}
+	errMalformedCommand       = &kernel.Error{Module: "tpm", Message: "malformed TPM command", Code: kernel.ErrCodeInvalidArgument}
+	errMalformedResponse      = &kernel.Error{Module: "tpm", Message: "malformed TPM response", Code: kernel.ErrCodeIO}
+	errTimeout                = &kernel.Error{Module: "tpm", Message: "timed out waiting for the TPM", Code: kernel.ErrCodeTimeout}
+
+	lookupTableFn = acpi.LookupTable
+	mapRegionFn   = vmm.MapRegion
+	readRegFn     = readReg
+	writeRegFn    = writeReg
+
+	// pollLimit specifies the number of times that a TPM register is
+	// polled while waiting for the TPM to change state before giving up.
+	pollLimit = 1000000
+)
+
+// Device is implemented by TPM drivers.
+type Device interface {
+	device.Driver
+
+	// Transmit submits a marshaled TPM command and returns the marshaled
+	// response.
+	Transmit(cmd []byte) ([]byte, *kernel.Error)
+}
+
+// transport is implemented by the TPM interfaces supported by the driver.
+type transport interface {
+	// init maps the interface registers.
+	init() *kernel.Error
+
+	// transmit submits a command and returns the response.
+	transmit(cmd []byte) ([]byte, *kernel.Error)
+}
+
+// Driver implements a device.Driver for TPM 2.0 devices. Once initialized, it
+// also implements Device.
+type Driver struct {
+	info *table.TPM2Info
+
+	transport transport
+}
+
+// DriverName returns the name of this driver.
+func (*Driver) DriverName() string {
+	return "TPM2"
+}
+
+// DriverVersion returns the version of this driver.
+func (*Driver) DriverVersion() (uint16, uint16, uint16) {
+	return 0, 0, 1
+}
+
+// DriverInit selects and maps the TPM interface described by the TPM2 table.
+// TPMs that require an ACPI method to start commands are not supported.
+func (d *Driver) DriverInit(w io.Writer) *kernel.Error {
+	var ifaceName string
+
+	switch d.info.StartMethod {
+	case table.TPM2StartCRB:
+		d.transport = &crbTransport{ctrlAreaAddr: uintptr(d.info.ControlArea)}
+		ifaceName = "CRB"
+	case table.TPM2StartTIS:
+		d.transport = &tisTransport{}
+		ifaceName = "TIS"
+	default:
+		return errUnsupportedStartMethod
+	}
+
+	if err := d.transport.init(); err != nil {
+		return err
+	}
+
+	kfmt.Fprintf(w, "interface: %s\n", ifaceName)
+	return nil
+}
+
+// Transmit submits a marshaled TPM command and returns the marshaled
+// response. The size field of the command header must match the command
+// length.
+func (d *Driver) Transmit(cmd []byte) ([]byte, *kernel.Error) {
+	if len(cmd) < headerLen || int(be32(cmd[2:])) != len(cmd) {
+		return nil, errMalformedCommand
+	}
+
+	rsp, err := d.transport.transmit(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rsp) < headerLen || int(be32(rsp[2:])) != len(rsp) {
+		return nil, errMalformedResponse
+	}
+
+	return rsp, nil
+}
+
+// waitReg polls the register at the specified address until the bits
+// selected by mask are equal to exp.
+func waitReg(addr uintptr, width uint8, mask, exp uint32) *kernel.Error {
+	for i := 0; i < pollLimit; i++ {
+		if readRegFn(addr, width)&mask == exp {
+			return nil
+		}
+	}
+
+	return errTimeout
+}
+
+// readReg reads the 8, 16 or 32-bit memory-mapped register at addr.
+func readReg(addr uintptr, width uint8) uint32 {
+	switch width {
+	case 8:
+		return uint32(*(*uint8)(unsafe.Pointer(addr)))
+	case 16:
+		return uint32(*(*uint16)(unsafe.Pointer(addr)))
+	default:
+		return *(*uint32)(unsafe.Pointer(addr))
+	}
+}
+
+// writeReg writes the 8, 16 or 32-bit memory-mapped register at addr.
+func writeReg(addr uintptr, width uint8, val uint32) {
+	switch width {
+	case 8:
+		*(*uint8)(unsafe.Pointer(addr)) = uint8(val)
+	case 16:
+		*(*uint16)(unsafe.Pointer(addr)) = uint16(val)
+	default:
+		*(*uint32)(unsafe.Pointer(addr)) = val
+	}
+}
+
+// mapRegisters maps the physical memory region at the specified address as
+// uncacheable memory and returns its virtual address.
+func mapRegisters(physAddr uintptr, length uint32) (uintptr, *kernel.Error) {
+	pageOffset := vmm.PageOffset(physAddr)
+	page, err := mapRegionFn(mm.FrameFromAddress(physAddr), pageOffset+uintptr(length), vmm.FlagPresent|vmm.FlagRW|vmm.FlagDoNotCache)
+	if err != nil {
+		return 0, err
+	}
+
+	return page.Address() + pageOffset, nil
+}
+
+// mapBuffer maps the physical memory region at the specified address as
+// uncacheable memory and returns a byte slice that overlays it.
+func mapBuffer(physAddr uintptr, length uint32) ([]byte, *kernel.Error) {
+	addr, err := mapRegisters(physAddr, length)
+	if err != nil {
+		return nil, err
+	}
+
+	return *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Len:  int(length),
+		Cap:  int(length),
+		Data: addr,
+	})), nil
+}
+
+// probeForTPM2 checks for the presence of a TPM2 table.
+func probeForTPM2() device.Driver {
+	header := lookupTableFn("TPM2")
+	if header == nil {
+		return nil
+	}
+
+	info, err := table.DecodeTPM2(header)
+	if err != nil {
+		return nil
+	}
+
+	return &Driver{info: info}
+}
+
+func init() {
+	device.RegisterDriver(&device.DriverInfo{
+		Order: device.DetectOrderACPI,
+		Probe: probeForTPM2,
+	})
+}
@@ -0,0 +1,154 @@
+package tpm
+
+import (
+	"bytes"
+	"gopheros/device/acpi"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"testing"
+	"unsafe"
+)
+
+func TestDriverInit(t *testing.T) {
+	defer restoreFns()
+
+	fake := newFakeCRB(0xfed40040, 64)
+	fake.install()
+
+	specs := []struct {
+		startMethod table.TPM2StartMethod
+		expErr      *kernel.Error
+		expOutput   string
+	}{
+		{table.TPM2StartCRB, nil, "interface: CRB\n"},
+		{table.TPM2StartTIS, nil, "interface: TIS\n"},
+		{table.TPM2StartACPI, errUnsupportedStartMethod, ""},
+		{table.TPM2StartCRBWithACPI, errUnsupportedStartMethod, ""},
+	}
+
+	for specIndex, spec := range specs {
+		drv := &Driver{info: &table.TPM2Info{StartMethod: spec.startMethod, ControlArea: 0xfed40040}}
+
+		var buf bytes.Buffer
+		if err := drv.DriverInit(&buf); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+
+		if buf.String() != spec.expOutput {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, spec.expOutput, buf.String())
+		}
+	}
+
+	expErr := &kernel.Error{Module: "test", Message: "map failed"}
+	mapRegionFn = func(mm.Frame, uintptr, vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) { return 0, expErr }
+
+	drv := &Driver{info: &table.TPM2Info{StartMethod: table.TPM2StartTIS}}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != expErr {
+		t.Fatalf("expected to get error %v; got %v", expErr, err)
+	}
+}
+
+func TestTransmit(t *testing.T) {
+	defer restoreFns()
+
+	fake := newFakeCRB(0xfed40040, 64)
+	fake.install()
+
+	drv := &Driver{info: &table.TPM2Info{StartMethod: table.TPM2StartCRB, ControlArea: 0xfed40040}}
+	if err := drv.DriverInit(&bytes.Buffer{}); err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		cmd    []byte
+		rsp    []byte
+		expErr *kernel.Error
+	}{
+		{response(0), response(0, 1, 2), nil},
+		// Command shorter than a header
+		{[]byte{0x80, 0x01}, nil, errMalformedCommand},
+		// Command size does not match its length
+		{append(response(0), 0), nil, errMalformedCommand},
+	}
+
+	for specIndex, spec := range specs {
+		fake.handler = func([]byte) []byte { return spec.rsp }
+
+		rsp, err := drv.Transmit(spec.cmd)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if err == nil && !bytes.Equal(rsp, spec.rsp) {
+			t.Errorf("[spec %d] expected response %x; got %x", specIndex, spec.rsp, rsp)
+		}
+	}
+
+	t.Run("transport error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "transmit failed"}
+		drv.transport = &fakeTransport{err: expErr}
+		if _, err := drv.Transmit(response(0)); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+
+	t.Run("response size does not match its length", func(t *testing.T) {
+		drv.transport = &fakeTransport{rsp: append(response(0), 0)}
+		if _, err := drv.Transmit(response(0)); err != errMalformedResponse {
+			t.Fatalf("expected to get error %v; got %v", errMalformedResponse, err)
+		}
+	})
+}
+
+func TestProbe(t *testing.T) {
+	defer restoreFns()
+
+	tpm2 := make([]byte, 52)
+	copy(tpm2, "TPM2")
+	tpm2[4] = byte(len(tpm2))
+	tpm2[48] = byte(table.TPM2StartCRB)
+
+	specs := []struct {
+		header    *table.SDTHeader
+		expDriver bool
+	}{
+		{nil, false},
+		{(*table.SDTHeader)(unsafe.Pointer(&tpm2[0])), true},
+		// Truncated table
+		{&table.SDTHeader{Signature: [4]byte{'T', 'P', 'M', '2'}, Length: 36}, false},
+	}
+
+	for specIndex, spec := range specs {
+		lookupTableFn = func(string) *table.SDTHeader { return spec.header }
+
+		if drv := probeForTPM2(); (drv != nil) != spec.expDriver {
+			t.Errorf("[spec %d] expected probe to return a driver: %t; got %v", specIndex, spec.expDriver, drv)
+		}
+	}
+}
+
+// fakeTransport is a transport that returns a canned response.
+type fakeTransport struct {
+	rsp []byte
+	err *kernel.Error
+}
+
+func (*fakeTransport) init() *kernel.Error                       { return nil }
+func (t *fakeTransport) transmit([]byte) ([]byte, *kernel.Error) { return t.rsp, t.err }
+
+func mockMapRegion() {
+	mapRegionFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.PageFromAddress(frame.Address()), nil
+	}
+}
+
+func restoreFns() {
+	lookupTableFn = acpi.LookupTable
+	mapRegionFn = vmm.MapRegion
+	readRegFn = readReg
+	writeRegFn = writeReg
+	pollLimit = 1000000
+}
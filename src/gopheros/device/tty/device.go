@@ -50,3 +50,13 @@ type Device interface {
 	// viewport.
 	SetCursorPosition(x, y uint32)
 }
+
+// TextCapturer is an interface implemented by terminal devices that can
+// produce a plain-text snapshot of their visible contents.
+//
+// CaptureText writes the contents of the terminal viewport to w, one line at
+// a time. Trailing whitespace is stripped from each line and each line is
+// terminated by a '\n' character.
+type TextCapturer interface {
+	CaptureText(w io.Writer) error
+}
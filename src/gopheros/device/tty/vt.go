@@ -102,6 +102,36 @@ func (t *VT) SetState(newState State) {
 	}
 }
 
+// CaptureText writes the contents of the terminal viewport to w. Trailing
+// whitespace is stripped from each line and each line is terminated by a '\n'
+// character. Calling CaptureText on a terminal without an attached console
+// returns io.ErrClosedPipe.
+func (t *VT) CaptureText(w io.Writer) error {
+	if t.cons == nil {
+		return io.ErrClosedPipe
+	}
+
+	line := make([]byte, t.viewportWidth+1)
+	for y := uint32(0); y < t.viewportHeight; y++ {
+		offset := (y + t.viewportY) * (t.viewportWidth * 3)
+
+		lineLen := 0
+		for x := uint32(0); x < t.viewportWidth; x, offset = x+1, offset+3 {
+			line[x] = t.data[offset]
+			if line[x] != ' ' {
+				lineLen = int(x) + 1
+			}
+		}
+
+		line[lineLen] = '\n'
+		if _, err := w.Write(line[:lineLen+1]); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
 // CursorPosition returns the current cursor position.
 func (t *VT) CursorPosition() (uint32, uint32) {
 	return t.cursorX, t.cursorY
@@ -1,6 +1,7 @@
 package tty
 
 import (
+	"bytes"
 	"gopheros/device"
 	"gopheros/device/video/console"
 	"image/color"
@@ -321,6 +322,33 @@ func TestVtSetState(t *testing.T) {
 	}
 }
 
+func TestVtCaptureText(t *testing.T) {
+	term := NewVT(4, 1)
+
+	var buf bytes.Buffer
+	if err := term.CaptureText(&buf); err != io.ErrClosedPipe {
+		t.Fatal("expected calling CaptureText on a terminal without an attached console to return ErrClosedPipe")
+	}
+
+	term.AttachTo(newMockConsole(8, 3))
+	term.Write([]byte("1\n22  \n333\n4444"))
+
+	if err := term.CaptureText(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	// The first line has been scrolled out of the viewport
+	exp := "22\n333\n4444\n"
+	if got := buf.String(); got != exp {
+		t.Fatalf("expected captured text to be %q; got %q", exp, got)
+	}
+
+	buf.Reset()
+	if err := term.CaptureText(&writerThatFails{}); err != io.ErrShortWrite {
+		t.Fatalf("expected CaptureText to return io.ErrShortWrite; got %v", err)
+	}
+}
+
 func TestVTDriverInterface(t *testing.T) {
 	var dev device.Driver = NewVT(0, 0)
 
@@ -411,3 +439,9 @@ func (cons *mockConsole) Write(b byte, fg, bg uint8, x, y uint32) {
 	cons.bgAttrs[offset] = bg
 	cons.bytesWritten++
 }
+
+type writerThatFails struct{}
+
+func (w *writerThatFails) Write(_ []byte) (int, error) {
+	return 0, io.ErrShortWrite
+}
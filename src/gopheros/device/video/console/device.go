@@ -6,6 +6,7 @@ import (
 	"gopheros/kernel/cpu"
 	"gopheros/kernel/mm/vmm"
 	"gopheros/multiboot"
+	"image"
 	"image/color"
 )
 
@@ -86,3 +87,21 @@ type FontSetter interface {
 type LogoSetter interface {
 	SetLogo(*logo.Image)
 }
+
+// ImageDrawer is an interface implemented by console devices that can draw
+// arbitrary RGB images at a specific framebuffer location.
+//
+// DrawImage draws an image with its upper-left corner located at the
+// specified pixel coordinates.
+type ImageDrawer interface {
+	DrawImage(x, y uint32, img image.Image)
+}
+
+// FramebufferCapturer is an interface implemented by console devices that can
+// produce a snapshot of their framebuffer contents.
+//
+// CaptureFramebuffer returns an image containing a copy of the framebuffer
+// contents decoded into RGBA format.
+type FramebufferCapturer interface {
+	CaptureFramebuffer() *image.RGBA
+}
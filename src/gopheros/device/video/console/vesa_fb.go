@@ -9,6 +9,7 @@ import (
 	"gopheros/kernel/mm"
 	"gopheros/kernel/mm/vmm"
 	"gopheros/multiboot"
+	"image"
 	"image/color"
 	"io"
 	"reflect"
@@ -134,6 +135,49 @@ func (cons *VesaFbConsole) SetLogo(l *logo.Image) {
 	cons.offsetY = l.Height
 }
 
+// DrawImage draws img on the framebuffer so that its upper-left corner is
+// located at pixel (x, y). Unlike SetLogo, the coordinates are relative to
+// the top of the framebuffer and the image colors are written directly to
+// the framebuffer instead of being allocated from the console palette. When
+// using an 8bpp framebuffer, each image color is mapped to the closest
+// palette color. Any image pixels that fall outside the framebuffer are
+// clipped.
+func (cons *VesaFbConsole) DrawImage(x, y uint32, img image.Image) {
+	if cons.fb == nil || x >= cons.width || y >= cons.height {
+		return
+	}
+
+	bounds := img.Bounds()
+	imgW, imgH := uint32(bounds.Dx()), uint32(bounds.Dy())
+	if x+imgW > cons.width {
+		imgW = cons.width - x
+	}
+	if y+imgH > cons.height {
+		imgH = cons.height - y
+	}
+
+	fbRowOffset := (y * cons.pitch) + (x * cons.bytesPerPixel)
+	for imgY := uint32(0); imgY < imgH; imgY, fbRowOffset = imgY+1, fbRowOffset+cons.pitch {
+		for imgX, fbOffset := uint32(0), fbRowOffset; imgX < imgW; imgX, fbOffset = imgX+1, fbOffset+cons.bytesPerPixel {
+			c := color.RGBAModel.Convert(img.At(bounds.Min.X+int(imgX), bounds.Min.Y+int(imgY))).(color.RGBA)
+
+			switch cons.bpp {
+			case 8:
+				cons.fb[fbOffset] = uint8(cons.palette.Index(c))
+			case 15, 16:
+				colorComp := cons.packRGBA16(c)
+				cons.fb[fbOffset] = colorComp[0]
+				cons.fb[fbOffset+1] = colorComp[1]
+			case 24, 32:
+				colorComp := cons.packRGBA24(c)
+				cons.fb[fbOffset] = colorComp[0]
+				cons.fb[fbOffset+1] = colorComp[1]
+				cons.fb[fbOffset+2] = colorComp[2]
+			}
+		}
+	}
+}
+
 // Dimensions returns the console width and height in the specified dimension.
 func (cons *VesaFbConsole) Dimensions(dim Dimension) (uint32, uint32) {
 	switch dim {
@@ -391,8 +435,13 @@ func (cons *VesaFbConsole) fbOffset(x, y uint32) uint32 {
 // packColor24 encodes a palette color into the pixel format required by a
 // 24/32 bpp framebuffer.
 func (cons *VesaFbConsole) packColor24(colorIndex uint8) [3]uint8 {
+	return cons.packRGBA24(cons.palette[colorIndex].(color.RGBA))
+}
+
+// packRGBA24 encodes an arbitrary color into the pixel format required by a
+// 24/32 bpp framebuffer.
+func (cons *VesaFbConsole) packRGBA24(c color.RGBA) [3]uint8 {
 	var (
-		c             = cons.palette[colorIndex].(color.RGBA)
 		packed uint32 = 0 |
 			(uint32(c.R>>(8-cons.colorInfo.RedMaskSize)) << cons.colorInfo.RedPosition) |
 			(uint32(c.G>>(8-cons.colorInfo.GreenMaskSize)) << cons.colorInfo.GreenPosition) |
@@ -409,8 +458,13 @@ func (cons *VesaFbConsole) packColor24(colorIndex uint8) [3]uint8 {
 // packColor16 encodes a palette color into the pixel format required by a
 // 15/16 bpp framebuffer.
 func (cons *VesaFbConsole) packColor16(colorIndex uint8) [2]uint8 {
+	return cons.packRGBA16(cons.palette[colorIndex].(color.RGBA))
+}
+
+// packRGBA16 encodes an arbitrary color into the pixel format required by a
+// 15/16 bpp framebuffer.
+func (cons *VesaFbConsole) packRGBA16(c color.RGBA) [2]uint8 {
 	var (
-		c             = cons.palette[colorIndex].(color.RGBA)
 		packed uint16 = 0 |
 			(uint16(c.R>>(8-cons.colorInfo.RedMaskSize)) << cons.colorInfo.RedPosition) |
 			(uint16(c.G>>(8-cons.colorInfo.GreenMaskSize)) << cons.colorInfo.GreenPosition) |
@@ -423,6 +477,51 @@ func (cons *VesaFbConsole) packColor16(colorIndex uint8) [2]uint8 {
 	}
 }
 
+// CaptureFramebuffer returns an image containing a copy of the framebuffer
+// contents (including any reserved area used for displaying a logo) decoded
+// into RGBA format.
+func (cons *VesaFbConsole) CaptureFramebuffer() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, int(cons.width), int(cons.height)))
+	if cons.fb == nil {
+		return img
+	}
+
+	for y, fbRowOffset := uint32(0), uint32(0); y < cons.height; y, fbRowOffset = y+1, fbRowOffset+cons.pitch {
+		for x, fbOffset := uint32(0), fbRowOffset; x < cons.width; x, fbOffset = x+1, fbOffset+cons.bytesPerPixel {
+			var c color.RGBA
+			switch cons.bpp {
+			case 8:
+				if pc := cons.palette[cons.fb[fbOffset]]; pc != nil {
+					c = pc.(color.RGBA)
+				}
+			case 15, 16:
+				c = cons.unpackColor(uint32(cons.fb[fbOffset]) | uint32(cons.fb[fbOffset+1])<<8)
+			case 24, 32:
+				c = cons.unpackColor(uint32(cons.fb[fbOffset]) | uint32(cons.fb[fbOffset+1])<<8 | uint32(cons.fb[fbOffset+2])<<16)
+			}
+
+			c.A = 255
+			img.SetRGBA(int(x), int(y), c)
+		}
+	}
+
+	return img
+}
+
+// unpackColor decodes a packed 15/16/24/32 bpp pixel value into its RGB
+// components.
+func (cons *VesaFbConsole) unpackColor(packed uint32) color.RGBA {
+	unpack := func(pos, size uint8) uint8 {
+		return uint8(((packed >> pos) & ((1 << size) - 1)) << (8 - size))
+	}
+
+	return color.RGBA{
+		R: unpack(cons.colorInfo.RedPosition, cons.colorInfo.RedMaskSize),
+		G: unpack(cons.colorInfo.GreenPosition, cons.colorInfo.GreenMaskSize),
+		B: unpack(cons.colorInfo.BluePosition, cons.colorInfo.BlueMaskSize),
+	}
+}
+
 // Palette returns the active color palette for this console.
 func (cons *VesaFbConsole) Palette() color.Palette {
 	return cons.palette
@@ -558,12 +657,13 @@ func (cons *VesaFbConsole) DriverVersion() (uint16, uint16, uint16) {
 
 // DriverInit initializes this driver.
 func (cons *VesaFbConsole) DriverInit(w io.Writer) *kernel.Error {
-	// Map the framebuffer so we can write to it
+	// Map the framebuffer so we can write to it. Framebuffers span several
+	// MBs so we request a huge page mapping to reduce TLB pressure.
 	fbSize := uintptr(cons.height * cons.pitch)
 	fbPage, err := mapRegionFn(
 		mm.Frame(cons.fbPhysAddr>>mm.PageShift),
 		fbSize,
-		vmm.FlagPresent|vmm.FlagRW,
+		vmm.FlagPresent|vmm.FlagRW|vmm.FlagHugePage,
 	)
 
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 	"gopheros/kernel/mm"
 	"gopheros/kernel/mm/vmm"
 	"gopheros/multiboot"
+	"image"
 	"image/color"
 	"reflect"
 	"strings"
@@ -1595,6 +1596,77 @@ func TestVesaFbPackColor24(t *testing.T) {
 	}
 }
 
+func TestVesaFbCaptureFramebuffer(t *testing.T) {
+	defer func() {
+		portWriteByteFn = cpu.PortWriteByte
+	}()
+	portWriteByteFn = func(_ uint16, _ uint8) {}
+
+	rgbColorInfo := &multiboot.FramebufferRGBColorInfo{
+		RedPosition:   16,
+		RedMaskSize:   8,
+		GreenPosition: 8,
+		GreenMaskSize: 8,
+		BluePosition:  0,
+		BlueMaskSize:  8,
+	}
+
+	rgb565ColorInfo := &multiboot.FramebufferRGBColorInfo{
+		RedPosition:   11,
+		RedMaskSize:   5,
+		GreenPosition: 5,
+		GreenMaskSize: 6,
+		BluePosition:  0,
+		BlueMaskSize:  5,
+	}
+
+	specs := []struct {
+		bpp       uint8
+		colorInfo *multiboot.FramebufferRGBColorInfo
+	}{
+		{8, nil},
+		{16, rgb565ColorInfo},
+		{24, rgbColorInfo},
+		{32, rgbColorInfo},
+	}
+
+	for specIndex, spec := range specs {
+		var (
+			consW, consH  uint32 = 16, 16
+			bytesPerPixel        = uint32(spec.bpp+1) >> 3
+			cons                 = NewVesaFbConsole(consW, consH, spec.bpp, consW*bytesPerPixel, spec.colorInfo, 0)
+		)
+
+		// Capturing before the framebuffer is mapped yields a blank image
+		if img := cons.CaptureFramebuffer(); img.Bounds().Dx() != int(consW) || img.Bounds().Dy() != int(consH) {
+			t.Errorf("[spec %d] expected captured image dimensions to be %dx%d; got %dx%d", specIndex, consW, consH, img.Bounds().Dx(), img.Bounds().Dy())
+			continue
+		}
+
+		cons.fb = make([]uint8, consH*cons.pitch)
+		cons.loadDefaultPalette()
+		cons.SetPaletteColor(1, color.RGBA{R: 248, G: 252, B: 248})
+		cons.Fill(1, 1, consW, consH, 0, 0)
+		cons.SetFont(mockFont8x10)
+		cons.Write(1, 1, 0, 1, 1)
+
+		img := cons.CaptureFramebuffer()
+		for y := uint32(0); y < consH; y++ {
+			for x := uint32(0); x < consW; x++ {
+				exp := cons.palette[0].(color.RGBA)
+				if x < 8 && y < 10 && (mockFont8x10.Data[10+int(y)]&(1<<(7-x))) != 0 {
+					exp = cons.palette[1].(color.RGBA)
+				}
+				exp.A = 255
+
+				if got := img.RGBAAt(int(x), int(y)); got != exp {
+					t.Errorf("[spec %d] expected pixel at (%d, %d) to be %v; got %v", specIndex, x, y, exp, got)
+				}
+			}
+		}
+	}
+}
+
 func TestVesaFbSetLogo(t *testing.T) {
 	defer func() {
 		portWriteByteFn = cpu.PortWriteByte
@@ -1743,6 +1815,96 @@ func TestVesaFbSetLogo(t *testing.T) {
 	}
 }
 
+func TestVesaFbDrawImage(t *testing.T) {
+	defer func() {
+		portWriteByteFn = cpu.PortWriteByte
+	}()
+	portWriteByteFn = func(_ uint16, _ uint8) {}
+
+	var (
+		consW uint32 = 3
+		consH uint32 = 2
+		white        = color.RGBA{R: 255, G: 255, B: 255, A: 255}
+		red          = color.RGBA{R: 255, A: 255}
+		img          = image.NewRGBA(image.Rect(0, 0, 2, 2))
+	)
+	img.SetRGBA(0, 0, white)
+	img.SetRGBA(1, 0, red)
+	img.SetRGBA(0, 1, red)
+	img.SetRGBA(1, 1, white)
+
+	specs := []struct {
+		bpp       uint8
+		colorInfo *multiboot.FramebufferRGBColorInfo
+		expFb     []byte
+	}{
+		{
+			8,
+			nil,
+			[]byte{
+				0x00, 0x00, 0x0f,
+				0x00, 0x00, 0x0c,
+			},
+		},
+		{
+			16,
+			// RGB565
+			&multiboot.FramebufferRGBColorInfo{
+				RedPosition:   11,
+				RedMaskSize:   5,
+				GreenPosition: 5,
+				GreenMaskSize: 6,
+				BluePosition:  0,
+				BlueMaskSize:  5,
+			},
+			[]byte{
+				0x00, 0x00, 0x00, 0x00, 0xff, 0xff,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0xf8,
+			},
+		},
+		{
+			24,
+			// RGB
+			&multiboot.FramebufferRGBColorInfo{
+				RedPosition:   16,
+				RedMaskSize:   8,
+				GreenPosition: 8,
+				GreenMaskSize: 8,
+				BluePosition:  0,
+				BlueMaskSize:  8,
+			},
+			[]byte{
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff, 0xff, 0xff,
+				0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xff,
+			},
+		},
+	}
+
+	for specIndex, spec := range specs {
+		cons := NewVesaFbConsole(consW, consH, spec.bpp, consW*uint32(spec.bpp>>3), spec.colorInfo, 0)
+
+		// Drawing before the framebuffer is mapped should be a no-op
+		cons.DrawImage(0, 0, img)
+
+		cons.fb = make([]byte, consH*cons.pitch)
+		cons.loadDefaultPalette()
+
+		// Drawing outside the framebuffer should be a no-op
+		cons.DrawImage(consW, 0, img)
+		cons.DrawImage(0, consH, img)
+
+		// The image should be clipped to the framebuffer dimensions
+		cons.DrawImage(consW-1, 0, img)
+
+		if !reflect.DeepEqual(spec.expFb, cons.fb) {
+			t.Errorf("[spec %d] unexpected frame buffer contents:\n%s",
+				specIndex,
+				diffFrameBuffer(consW, consH, cons.pitch, spec.expFb, cons.fb),
+			)
+		}
+	}
+}
+
 func dumpFramebuffer(consW, consH, consPitch uint32, fb []byte) string {
 	var buf bytes.Buffer
 
@@ -0,0 +1,110 @@
+// Package clock keeps track of the devices that the kernel uses for measuring
+// time (clock sources) and for generating timer interrupts (event sources).
+// Timer drivers register their devices with this package which selects the
+// device with the highest rating of each kind.
+package clock
+
+import "gopheros/kernel"
+
+// The number of nanoseconds in a second.
+const nsPerSecond = uint64(1000000000)
+
+// Source is implemented by devices that provide a monotonically increasing
+// counter that can be used for measuring time.
+type Source interface {
+	// SourceName returns the name of the clock source.
+	SourceName() string
+
+	// SourceRating returns a value that describes the quality of the
+	// clock source. Sources with a higher rating are preferred.
+	SourceRating() uint8
+
+	// Frequency returns the number of counter ticks per second.
+	Frequency() uint64
+
+	// ReadCounter returns the current counter value.
+	ReadCounter() uint64
+}
+
+// EventHandler is invoked by an event source each time its timer expires.
+// Event handlers run in interrupt context.
+type EventHandler func()
+
+// EventSource is implemented by devices that can generate timer interrupts.
+type EventSource interface {
+	// EventSourceName returns the name of the event source.
+	EventSourceName() string
+
+	// EventSourceRating returns a value that describes the quality of
+	// the event source. Sources with a higher rating are preferred.
+	EventSourceRating() uint8
+
+	// SetPeriodic arms the timer so that handler is invoked every period
+	// nanoseconds.
+	SetPeriodic(period uint64, handler EventHandler) *kernel.Error
+
+	// SetOneShot arms the timer so that handler is invoked once after
+	// delay nanoseconds.
+	SetOneShot(delay uint64, handler EventHandler) *kernel.Error
+
+	// Stop disarms the timer.
+	Stop()
+}
+
+var (
+	// The clock and event sources with the highest rating registered so
+	// far or nil if no source has been registered.
+	activeSource      Source
+	activeEventSource EventSource
+)
+
+// RegisterSource makes src available for timekeeping. It becomes the active
+// clock source if no other source with the same or higher rating has been
+// registered.
+func RegisterSource(src Source) {
+	if activeSource == nil || src.SourceRating() > activeSource.SourceRating() {
+		activeSource = src
+	}
+}
+
+// RegisterEventSource makes src available for generating timer interrupts.
+// It becomes the active event source if no other source with the same or
+// higher rating has been registered.
+func RegisterEventSource(src EventSource) {
+	if activeEventSource == nil || src.EventSourceRating() > activeEventSource.EventSourceRating() {
+		activeEventSource = src
+	}
+}
+
+// ActiveSource returns the clock source used for timekeeping or nil if no
+// clock source has been registered.
+func ActiveSource() Source {
+	return activeSource
+}
+
+// ActiveEventSource returns the event source used for generating timer
+// interrupts or nil if no event source has been registered.
+func ActiveEventSource() EventSource {
+	return activeEventSource
+}
+
+// Nanoseconds converts the counter value of the active clock source into
+// nanoseconds. It returns 0 if no clock source has been registered.
+func Nanoseconds() uint64 {
+	if activeSource == nil {
+		return 0
+	}
+
+	return TicksToNanoseconds(activeSource.ReadCounter(), activeSource.Frequency())
+}
+
+// TicksToNanoseconds converts a number of ticks of a counter running at freq
+// Hz into nanoseconds. The conversion avoids overflowing the intermediate
+// results for large tick counts.
+func TicksToNanoseconds(ticks, freq uint64) uint64 {
+	if freq == 0 {
+		return 0
+	}
+
+	return (ticks/freq)*nsPerSecond + (ticks%freq)*nsPerSecond/freq
+}
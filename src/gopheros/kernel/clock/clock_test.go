@@ -0,0 +1,101 @@
+package clock
+
+import (
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestRegisterSource(t *testing.T) {
+	defer func() { activeSource = nil }()
+
+	if ActiveSource() != nil || Nanoseconds() != 0 {
+		t.Fatal("expected no active clock source")
+	}
+
+	pit := &fakeSource{name: "pit", rating: 10, freq: 1193182}
+	hpet := &fakeSource{name: "hpet", rating: 50, freq: 1000000000, counter: 1234}
+	tsc := &fakeSource{name: "tsc", rating: 50, freq: 3000000000}
+
+	specs := []struct {
+		src       *fakeSource
+		expActive *fakeSource
+	}{
+		{pit, pit},
+		{hpet, hpet},
+		// Sources with the same rating do not replace the active one
+		{tsc, hpet},
+		{pit, hpet},
+	}
+
+	for specIndex, spec := range specs {
+		RegisterSource(spec.src)
+		if got := ActiveSource(); got != spec.expActive {
+			t.Errorf("[spec %d] expected active source to be %q; got %q", specIndex, spec.expActive.name, got.SourceName())
+		}
+	}
+
+	if exp, got := uint64(1234), Nanoseconds(); got != exp {
+		t.Errorf("expected Nanoseconds() to return %d; got %d", exp, got)
+	}
+}
+
+func TestRegisterEventSource(t *testing.T) {
+	defer func() { activeEventSource = nil }()
+
+	if ActiveEventSource() != nil {
+		t.Fatal("expected no active event source")
+	}
+
+	pit := &fakeEventSource{name: "pit", rating: 10}
+	hpet := &fakeEventSource{name: "hpet", rating: 50}
+
+	RegisterEventSource(hpet)
+	RegisterEventSource(pit)
+	if got := ActiveEventSource(); got != hpet {
+		t.Fatalf("expected active event source to be %q; got %q", hpet.name, got.EventSourceName())
+	}
+}
+
+func TestTicksToNanoseconds(t *testing.T) {
+	specs := []struct {
+		ticks, freq uint64
+		exp         uint64
+	}{
+		{0, 1000, 0},
+		{1, 0, 0},
+		{14318180, 14318180, 1000000000},
+		{7159090, 14318180, 500000000},
+		{3, 3000000000, 1},
+		// The naive ticks * 1e9 computation would overflow
+		{0xffffffffffff, 14318180, 19658572298340641},
+	}
+
+	for specIndex, spec := range specs {
+		if got := TicksToNanoseconds(spec.ticks, spec.freq); got != spec.exp {
+			t.Errorf("[spec %d] expected to get %d; got %d", specIndex, spec.exp, got)
+		}
+	}
+}
+
+type fakeSource struct {
+	name    string
+	rating  uint8
+	freq    uint64
+	counter uint64
+}
+
+func (s *fakeSource) SourceName() string  { return s.name }
+func (s *fakeSource) SourceRating() uint8 { return s.rating }
+func (s *fakeSource) Frequency() uint64   { return s.freq }
+func (s *fakeSource) ReadCounter() uint64 { return s.counter }
+
+type fakeEventSource struct {
+	name   string
+	rating uint8
+}
+
+func (s *fakeEventSource) EventSourceName() string                        { return s.name }
+func (s *fakeEventSource) EventSourceRating() uint8                       { return s.rating }
+func (s *fakeEventSource) SetPeriodic(uint64, EventHandler) *kernel.Error { return nil }
+func (s *fakeEventSource) SetOneShot(uint64, EventHandler) *kernel.Error  { return nil }
+func (s *fakeEventSource) Stop()                                          {}
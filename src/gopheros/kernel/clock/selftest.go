@@ -0,0 +1,129 @@
+package clock
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/selftest"
+)
+
+const (
+	// selfTestDelay is the delay in nanoseconds of the one-shot timers
+	// armed by the self-tests.
+	selfTestDelay = uint64(10000000)
+
+	// selfTestTolerance is the maximum time in nanoseconds that a one-shot
+	// timer may fire after its deadline.
+	selfTestTolerance = uint64(10000000)
+
+	// selfTestTimeout is the time in nanoseconds that the self-tests wait
+	// for a timer interrupt before giving up.
+	selfTestTimeout = uint64(1000000000)
+
+	// selfTestInterrupts is the number of timer interrupts that the
+	// interrupt delivery self-test waits for.
+	selfTestInterrupts = 5
+)
+
+var (
+	errSelfTestNoSource      = &kernel.Error{Module: "clock", Message: "self-test: no clock source registered", Code: kernel.ErrCodeNotFound}
+	errSelfTestNoEventSource = &kernel.Error{Module: "clock", Message: "self-test: no event source registered", Code: kernel.ErrCodeNotFound}
+	errSelfTestNoInterrupt   = &kernel.Error{Module: "clock", Message: "self-test: timer interrupt was not delivered", Code: kernel.ErrCodeTimeout}
+	errSelfTestTimerEarly    = &kernel.Error{Module: "clock", Message: "self-test: timer fired before its deadline", Code: kernel.ErrCodeCorrupted}
+	errSelfTestTimerLate     = &kernel.Error{Module: "clock", Message: "self-test: timer fired too late", Code: kernel.ErrCodeCorrupted}
+
+	// The following functions are mocked by tests.
+	enableInterruptsFn  = cpu.EnableInterrupts
+	disableInterruptsFn = cpu.DisableInterrupts
+
+	// The state updated by the self-test timer handler. It is modified in
+	// interrupt context.
+	selfTestFired   bool
+	selfTestFiredAt uint64
+)
+
+// selfTestTimerAccuracy arms a one-shot timer on the active event source and
+// uses the active clock source to check that it fires no earlier than its
+// deadline and no later than selfTestTolerance after it.
+func selfTestTimerAccuracy() *kernel.Error {
+	if err := checkSelfTestSources(); err != nil {
+		return err
+	}
+
+	start := Nanoseconds()
+	if err := armSelfTestTimer(); err != nil {
+		return err
+	}
+
+	switch elapsed := selfTestFiredAt - start; {
+	case elapsed < selfTestDelay:
+		return errSelfTestTimerEarly
+	case elapsed > selfTestDelay+selfTestTolerance:
+		return errSelfTestTimerLate
+	}
+
+	return nil
+}
+
+// selfTestInterruptDelivery repeatedly arms a one-shot timer on the active
+// event source and checks that each expiry is delivered as an interrupt.
+func selfTestInterruptDelivery() *kernel.Error {
+	if err := checkSelfTestSources(); err != nil {
+		return err
+	}
+
+	for i := 0; i < selfTestInterrupts; i++ {
+		if err := armSelfTestTimer(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkSelfTestSources ensures that both a clock and an event source are
+// available for running the timer self-tests.
+func checkSelfTestSources() *kernel.Error {
+	switch {
+	case activeSource == nil:
+		return errSelfTestNoSource
+	case activeEventSource == nil:
+		return errSelfTestNoEventSource
+	}
+
+	return nil
+}
+
+// armSelfTestTimer arms a one-shot timer that expires after selfTestDelay and
+// waits, with interrupts enabled, until its handler runs or selfTestTimeout
+// elapses.
+func armSelfTestTimer() *kernel.Error {
+	selfTestFired = false
+
+	start := Nanoseconds()
+	if err := activeEventSource.SetOneShot(selfTestDelay, handleSelfTestTimer); err != nil {
+		return err
+	}
+
+	enableInterruptsFn()
+	for !selfTestFired && Nanoseconds()-start < selfTestTimeout {
+	}
+	disableInterruptsFn()
+	activeEventSource.Stop()
+
+	if !selfTestFired {
+		return errSelfTestNoInterrupt
+	}
+
+	return nil
+}
+
+// handleSelfTestTimer records the time when the self-test timer fired.
+func handleSelfTestTimer() {
+	selfTestFiredAt = Nanoseconds()
+	selfTestFired = true
+}
+
+func init() {
+	selftest.Register("clock/timer-accuracy", selfTestTimerAccuracy)
+	selftest.Register("clock/interrupt-delivery", selfTestInterruptDelivery)
+}
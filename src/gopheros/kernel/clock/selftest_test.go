@@ -0,0 +1,159 @@
+package clock
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"testing"
+)
+
+func TestSelfTestTimerAccuracy(t *testing.T) {
+	defer restoreSelfTestHooks()
+
+	errTest := &kernel.Error{Module: "test", Message: "something went wrong"}
+
+	specs := []struct {
+		noSource bool
+		noEvents bool
+		armErr   *kernel.Error
+		dropAt   int
+		skew     int64
+		expErr   *kernel.Error
+	}{
+		{true, false, nil, -1, 0, errSelfTestNoSource},
+		{false, true, nil, -1, 0, errSelfTestNoEventSource},
+		{false, false, errTest, -1, 0, errTest},
+		{false, false, nil, 0, 0, errSelfTestNoInterrupt},
+		{false, false, nil, -1, -2000000, errSelfTestTimerEarly},
+		{false, false, nil, -1, int64(selfTestTolerance) + 1000000, errSelfTestTimerLate},
+		{false, false, nil, -1, 0, nil},
+		{false, false, nil, -1, int64(selfTestTolerance) / 2, nil},
+	}
+
+	for specIndex, spec := range specs {
+		timer := setupFakeTimer(spec.noSource, spec.noEvents)
+		timer.armErr, timer.dropAt, timer.skew = spec.armErr, spec.dropAt, spec.skew
+
+		if err := selfTestTimerAccuracy(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+
+		if interruptsEnabled {
+			t.Errorf("[spec %d] expected interrupts to be disabled after the self-test", specIndex)
+		}
+	}
+}
+
+func TestSelfTestInterruptDelivery(t *testing.T) {
+	defer restoreSelfTestHooks()
+
+	specs := []struct {
+		noSource bool
+		noEvents bool
+		dropAt   int
+		expErr   *kernel.Error
+		expFired int
+	}{
+		{true, false, -1, errSelfTestNoSource, 0},
+		{false, true, -1, errSelfTestNoEventSource, 0},
+		{false, false, 0, errSelfTestNoInterrupt, 0},
+		{false, false, 3, errSelfTestNoInterrupt, 3},
+		{false, false, -1, nil, selfTestInterrupts},
+	}
+
+	for specIndex, spec := range specs {
+		timer := setupFakeTimer(spec.noSource, spec.noEvents)
+		timer.dropAt = spec.dropAt
+
+		if err := selfTestInterruptDelivery(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+
+		if timer.fired != spec.expFired {
+			t.Errorf("[spec %d] expected %d interrupts to be delivered; got %d", specIndex, spec.expFired, timer.fired)
+		}
+
+		if timer.handler != nil {
+			t.Errorf("[spec %d] expected timer to be stopped", specIndex)
+		}
+	}
+}
+
+// interruptsEnabled tracks calls to the mocked enableInterruptsFn and
+// disableInterruptsFn. The fake timer only fires while it is set.
+var interruptsEnabled bool
+
+// fakeTimer implements both Source and EventSource. Its counter runs at 1GHz
+// and advances by step each time it is read; armed timers fire while the
+// counter is being read with interrupts enabled. Timers armed after dropAt
+// interrupts have fired never expire.
+type fakeTimer struct {
+	now      uint64
+	step     uint64
+	deadline uint64
+	handler  EventHandler
+
+	armErr *kernel.Error
+	dropAt int
+	skew   int64
+	fired  int
+}
+
+func (*fakeTimer) SourceName() string       { return "fake" }
+func (*fakeTimer) SourceRating() uint8      { return 1 }
+func (*fakeTimer) Frequency() uint64        { return 1000000000 }
+func (*fakeTimer) EventSourceName() string  { return "fake" }
+func (*fakeTimer) EventSourceRating() uint8 { return 1 }
+
+func (t *fakeTimer) ReadCounter() uint64 {
+	t.now += t.step
+	if handler := t.handler; handler != nil && interruptsEnabled && t.now >= t.deadline {
+		t.handler = nil
+		t.fired++
+		handler()
+	}
+	return t.now
+}
+
+func (t *fakeTimer) SetPeriodic(uint64, EventHandler) *kernel.Error { return nil }
+
+func (t *fakeTimer) SetOneShot(delay uint64, handler EventHandler) *kernel.Error {
+	if t.armErr != nil {
+		return t.armErr
+	}
+
+	if t.fired == t.dropAt {
+		return nil
+	}
+
+	t.deadline = uint64(int64(t.now+delay) + t.skew)
+	t.handler = handler
+	return nil
+}
+
+func (t *fakeTimer) Stop() {
+	t.handler = nil
+}
+
+func setupFakeTimer(noSource, noEvents bool) *fakeTimer {
+	timer := &fakeTimer{step: 10000, dropAt: -1}
+
+	activeSource, activeEventSource = timer, timer
+	if noSource {
+		activeSource = nil
+	}
+	if noEvents {
+		activeEventSource = nil
+	}
+
+	interruptsEnabled = false
+	enableInterruptsFn = func() { interruptsEnabled = true }
+	disableInterruptsFn = func() { interruptsEnabled = false }
+
+	return timer
+}
+
+func restoreSelfTestHooks() {
+	activeSource, activeEventSource = nil, nil
+	enableInterruptsFn = cpu.EnableInterrupts
+	disableInterruptsFn = cpu.DisableInterrupts
+}
@@ -16,6 +16,13 @@ func Halt()
 // FlushTLBEntry flushes a TLB entry for a particular virtual address.
 func FlushTLBEntry(virtAddr uintptr)
 
+// FlushCacheLine writes back and invalidates the cache line that contains
+// addr from all levels of the cache hierarchy.
+func FlushCacheLine(addr uintptr)
+
+// MemoryFence serializes all load and store operations issued before it.
+func MemoryFence()
+
 // SwitchPDT sets the root page table directory to point to the specified
 // physical address and flushes the TLB.
 func SwitchPDT(pdtPhysAddr uintptr)
@@ -56,3 +63,42 @@ func PortReadWord(port uint16) uint16
 
 // PortReadDword reads a uint32 value from the requested port.
 func PortReadDword(port uint16) uint32
+
+// ReadTSC returns the current value of the CPU timestamp counter.
+func ReadTSC() uint64
+
+// ReadRandom returns a random value generated by the on-chip random number
+// generator using the RDRAND instruction. It returns false if the generator
+// could not provide a value; callers should retry a few times before giving
+// up. The caller must ensure that the CPU supports RDRAND via HasRDRAND.
+func ReadRandom() (uint64, bool)
+
+// HasRDRAND returns true if the CPU supports the RDRAND instruction.
+func HasRDRAND() bool {
+	_, _, ecx, _ := cpuidFn(1)
+	return ecx&(1<<30) != 0
+}
+
+// ReadMSR returns the contents of the model-specific register msr.
+func ReadMSR(msr uint32) uint64
+
+// WriteMSR sets the contents of the model-specific register msr to val.
+func WriteMSR(msr uint32, val uint64)
+
+// WaitForInterrupt enables interrupt handling and stops instruction execution
+// until the next interrupt arrives.
+func WaitForInterrupt()
+
+// Monitor arms the address monitoring hardware for the memory range that
+// contains addr. A subsequent call to MWait returns when the range is written
+// to.
+func Monitor(addr uintptr, extensions, hints uint32)
+
+// MWait stops instruction execution until the range armed by Monitor is
+// written to or an interrupt arrives. The hints argument selects the
+// processor C-state that is entered while waiting.
+func MWait(hints, extensions uint32)
+
+// TripleFault resets the CPU by loading an empty IDT and raising an
+// interrupt. It is used as a last resort when all other reset methods fail.
+func TripleFault()
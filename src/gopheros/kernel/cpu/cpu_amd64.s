@@ -18,6 +18,15 @@ TEXT ·FlushTLBEntry(SB),NOSPLIT,$0
 	INVLPG (AX)
 	RET
 
+TEXT ·FlushCacheLine(SB),NOSPLIT,$0
+	MOVQ addr+0(FP), AX
+	CLFLUSH (AX)
+	RET
+
+TEXT ·MemoryFence(SB),NOSPLIT,$0
+	MFENCE
+	RET
+
 TEXT ·SwitchPDT(SB),NOSPLIT,$0
 	// loading CR3 also triggers a TLB flush
 	MOVQ pdtPhysAddr+0(FP), AX
@@ -80,3 +89,59 @@ TEXT ·PortReadDword(SB),NOSPLIT,$0
 	BYTE $0xed  // in eax, dx
 	MOVL AX, ret+0(FP)
 	RET
+
+TEXT ·ReadTSC(SB),NOSPLIT,$0
+	RDTSC
+	SHLQ $32, DX
+	ORQ DX, AX
+	MOVQ AX, ret+0(FP)
+	RET
+
+TEXT ·ReadRandom(SB),NOSPLIT,$0
+	BYTE $0x48; BYTE $0x0f; BYTE $0xc7; BYTE $0xf0 // rdrand rax
+	SETCS BX
+	MOVQ AX, ret+0(FP)
+	MOVB BX, ret1+8(FP)
+	RET
+
+TEXT ·ReadMSR(SB),NOSPLIT,$0
+	MOVL msr+0(FP), CX
+	RDMSR
+	SHLQ $32, DX
+	ORQ DX, AX
+	MOVQ AX, ret+8(FP)
+	RET
+
+TEXT ·WriteMSR(SB),NOSPLIT,$0
+	MOVL msr+0(FP), CX
+	MOVQ val+8(FP), AX
+	MOVQ AX, DX
+	SHRQ $32, DX
+	WRMSR
+	RET
+
+TEXT ·WaitForInterrupt(SB),NOSPLIT,$0
+	STI
+	HLT
+	RET
+
+TEXT ·Monitor(SB),NOSPLIT,$0
+	MOVQ addr+0(FP), AX
+	MOVL extensions+8(FP), CX
+	MOVL hints+12(FP), DX
+	BYTE $0x0f; BYTE $0x01; BYTE $0xc8 // monitor
+	RET
+
+TEXT ·MWait(SB),NOSPLIT,$0
+	MOVL hints+0(FP), AX
+	MOVL extensions+4(FP), CX
+	BYTE $0x0f; BYTE $0x01; BYTE $0xc9 // mwait
+	RET
+
+TEXT ·TripleFault(SB),NOSPLIT,$16
+	MOVQ $0, 0(SP)
+	MOVQ $0, 8(SP)
+	LEAQ 0(SP), AX
+	MOVQ 0(AX), IDTR 	// LIDT[RAX]
+	INT $3
+	RET
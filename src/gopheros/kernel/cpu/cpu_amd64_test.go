@@ -27,3 +27,28 @@ func TestIsIntel(t *testing.T) {
 		}
 	}
 }
+
+func TestHasRDRAND(t *testing.T) {
+	defer func() {
+		cpuidFn = ID
+	}()
+
+	for specIndex, spec := range []struct {
+		ecx uint32
+		exp bool
+	}{
+		{0, false},
+		{1 << 30, true},
+	} {
+		cpuidFn = func(leaf uint32) (uint32, uint32, uint32, uint32) {
+			if leaf != 1 {
+				t.Errorf("[spec %d] expected CPUID leaf 1 to be queried; got %d", specIndex, leaf)
+			}
+			return 0, 0, spec.ecx, 0
+		}
+
+		if got := HasRDRAND(); got != spec.exp {
+			t.Errorf("[spec %d] expected HasRDRAND to return %t; got %t", specIndex, spec.exp, got)
+		}
+	}
+}
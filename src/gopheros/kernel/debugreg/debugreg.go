@@ -0,0 +1,217 @@
+// Package debugreg exposes the x86 debug registers so that HW breakpoints and
+// watchpoints can be installed at runtime. Watchpoints are particularly useful
+// for tracking down memory corruption bugs as the CPU traps right after the
+// instruction that modified the watched memory location.
+package debugreg
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+)
+
+// NumSlots is the number of HW breakpoints/watchpoints supported by the CPU.
+const NumSlots = 4
+
+// Condition specifies the type of access that triggers a debug trap.
+type Condition uint8
+
+// The supported list of trigger conditions. The values match the R/W field
+// encoding used by the DR7 register.
+const (
+	// OnExecute triggers when the CPU executes the instruction at the
+	// watched address.
+	OnExecute Condition = 0
+
+	// OnWrite triggers when the watched memory location is written.
+	OnWrite Condition = 1
+
+	// OnReadWrite triggers when the watched memory location is read or
+	// written.
+	OnReadWrite Condition = 3
+)
+
+const (
+	// dr6 bits 0-3 indicate which slot triggered the #DB trap.
+	dr6SlotMask = 0xf
+
+	// dr7LocalEnableBit is the local-enable bit for slot 0. Each slot's
+	// enable bits are 2 bits apart.
+	dr7LocalEnableBit = 1 << 0
+
+	// dr7ConditionShift and dr7LengthShift are the offsets for the
+	// condition and length fields of slot 0. Each slot's fields are 4
+	// bits apart.
+	dr7ConditionShift = 16
+	dr7LengthShift    = 18
+
+	// rflagsResume is the RF flag that prevents an instruction breakpoint
+	// from re-triggering when the faulting instruction is resumed.
+	rflagsResume = 1 << 16
+)
+
+// Handler is invoked when a HW breakpoint or watchpoint is triggered. The
+// slot argument specifies the debug register slot that triggered the trap
+// whereas regs contains the register snapshot at the time of the trap.
+type Handler func(slot int, regs *gate.Registers)
+
+var (
+	// The following functions are mocked by tests.
+	readDR6Fn         = readDR6
+	writeDR6Fn        = writeDR6
+	readDR7Fn         = readDR7
+	writeDR7Fn        = writeDR7
+	writeAddrRegFn    = writeAddrReg
+	handleInterruptFn = gate.HandleInterrupt
+
+	// slotHandlers and slotAddrs track the handler and address for each
+	// installed breakpoint/watchpoint.
+	slotHandlers [NumSlots]Handler
+	slotAddrs    [NumSlots]uintptr
+
+	errNoFreeSlots      = &kernel.Error{Module: "debugreg", Message: "all debug register slots are in use", Code: kernel.ErrCodeBusy}
+	errInvalidLength    = &kernel.Error{Module: "debugreg", Message: "watch length must be 1, 2, 4 or 8 bytes", Code: kernel.ErrCodeInvalidArgument}
+	errUnalignedAddr    = &kernel.Error{Module: "debugreg", Message: "watch address must be aligned to the watch length", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidSlot      = &kernel.Error{Module: "debugreg", Message: "invalid debug register slot", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidCondition = &kernel.Error{Module: "debugreg", Message: "invalid watch condition", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// Init installs the #DB trap handler.
+func Init() {
+	handleInterruptFn(gate.Debug, 0, debugTrapHandler)
+}
+
+// WatchWrite installs a HW watchpoint that invokes handler whenever the
+// length bytes starting at addr are written. The length must be 1, 2, 4 or 8
+// and addr must be aligned to length. WatchWrite returns the allocated slot
+// which can be passed to Clear to remove the watchpoint.
+func WatchWrite(addr uintptr, length uint8, handler Handler) (int, *kernel.Error) {
+	return Set(addr, length, OnWrite, handler)
+}
+
+// WatchReadWrite installs a HW watchpoint that invokes handler whenever the
+// length bytes starting at addr are read or written.
+func WatchReadWrite(addr uintptr, length uint8, handler Handler) (int, *kernel.Error) {
+	return Set(addr, length, OnReadWrite, handler)
+}
+
+// Break installs a HW breakpoint that invokes handler when the instruction at
+// addr is executed.
+func Break(addr uintptr, handler Handler) (int, *kernel.Error) {
+	return Set(addr, 1, OnExecute, handler)
+}
+
+// Set installs a HW breakpoint or watchpoint in the first available debug
+// register slot and returns the slot index. If handler is nil, a default
+// handler that logs the trap to the console is used instead.
+func Set(addr uintptr, length uint8, cond Condition, handler Handler) (int, *kernel.Error) {
+	var lenBits uint64
+	switch length {
+	case 1:
+		lenBits = 0
+	case 2:
+		lenBits = 1
+	case 4:
+		lenBits = 3
+	case 8:
+		lenBits = 2
+	default:
+		return -1, errInvalidLength
+	}
+
+	switch {
+	case cond != OnExecute && cond != OnWrite && cond != OnReadWrite:
+		return -1, errInvalidCondition
+	case cond == OnExecute && length != 1:
+		return -1, errInvalidLength
+	case addr&uintptr(length-1) != 0:
+		return -1, errUnalignedAddr
+	}
+
+	dr7 := readDR7Fn()
+	for slot := 0; slot < NumSlots; slot++ {
+		if dr7&(dr7LocalEnableBit<<(2*uint(slot))) != 0 {
+			continue
+		}
+
+		if handler == nil {
+			handler = logTrap
+		}
+
+		slotHandlers[slot] = handler
+		slotAddrs[slot] = addr
+		writeAddrRegFn(uint64(slot), uint64(addr))
+
+		dr7 &^= 0xf << (dr7ConditionShift + 4*uint(slot))
+		dr7 |= uint64(cond)<<(dr7ConditionShift+4*uint(slot)) |
+			lenBits<<(dr7LengthShift+4*uint(slot)) |
+			dr7LocalEnableBit<<(2*uint(slot))
+		writeDR7Fn(dr7)
+		return slot, nil
+	}
+
+	return -1, errNoFreeSlots
+}
+
+// Clear removes the HW breakpoint or watchpoint installed at the specified
+// slot.
+func Clear(slot int) *kernel.Error {
+	if slot < 0 || slot >= NumSlots {
+		return errInvalidSlot
+	}
+
+	dr7 := readDR7Fn()
+	dr7 &^= 0xf<<(dr7ConditionShift+4*uint(slot)) | dr7LocalEnableBit<<(2*uint(slot))
+	writeDR7Fn(dr7)
+	writeAddrRegFn(uint64(slot), 0)
+
+	slotHandlers[slot] = nil
+	slotAddrs[slot] = 0
+	return nil
+}
+
+// debugTrapHandler is invoked by the CPU when a #DB trap occurs. It
+// dispatches the trap to the handlers of all slots that were triggered.
+func debugTrapHandler(regs *gate.Registers) {
+	dr6 := readDR6Fn()
+	dr7 := readDR7Fn()
+
+	for slot := 0; slot < NumSlots; slot++ {
+		if dr6&(1<<uint(slot)) == 0 || slotHandlers[slot] == nil {
+			continue
+		}
+
+		// Set the resume flag so that instruction breakpoints do not
+		// trigger again when the trapping instruction is resumed.
+		if Condition((dr7>>(dr7ConditionShift+4*uint(slot)))&0x3) == OnExecute {
+			regs.RFlags |= rflagsResume
+		}
+
+		slotHandlers[slot](slot, regs)
+	}
+
+	// DR6 is never cleared by the CPU
+	writeDR6Fn(dr6 &^ dr6SlotMask)
+}
+
+// logTrap is the default handler for breakpoints and watchpoints. As it runs
+// in interrupt context, the message is queued via kfmt.IRQPrintf and written
+// out once the IRQ log gets drained.
+func logTrap(slot int, regs *gate.Registers) {
+	kfmt.IRQPrintf("[debugreg] slot %d triggered for address 0x%x at RIP 0x%x\n", uint64(slot), uint64(slotAddrs[slot]), regs.RIP)
+}
+
+// readDR6 returns the contents of the debug status register.
+func readDR6() uint64
+
+// writeDR6 updates the contents of the debug status register.
+func writeDR6(val uint64)
+
+// readDR7 returns the contents of the debug control register.
+func readDR7() uint64
+
+// writeDR7 updates the contents of the debug control register.
+func writeDR7(val uint64)
+
+// writeAddrReg sets the address register (DR0-DR3) for the specified slot.
+func writeAddrReg(slot, addr uint64)
@@ -0,0 +1,44 @@
+#include "textflag.h"
+
+TEXT ·readDR6(SB),NOSPLIT,$0
+	MOVQ DR6, AX
+	MOVQ AX, ret+0(FP)
+	RET
+
+TEXT ·writeDR6(SB),NOSPLIT,$0
+	MOVQ val+0(FP), AX
+	MOVQ AX, DR6
+	RET
+
+TEXT ·readDR7(SB),NOSPLIT,$0
+	MOVQ DR7, AX
+	MOVQ AX, ret+0(FP)
+	RET
+
+TEXT ·writeDR7(SB),NOSPLIT,$0
+	MOVQ val+0(FP), AX
+	MOVQ AX, DR7
+	RET
+
+TEXT ·writeAddrReg(SB),NOSPLIT,$0
+	MOVQ addr+8(FP), AX
+	MOVQ slot+0(FP), CX
+	CMPQ CX, $0
+	JE dr0
+	CMPQ CX, $1
+	JE dr1
+	CMPQ CX, $2
+	JE dr2
+	// The Go assembler only supports DR0, DR6 and DR7 so we need to
+	// manually encode the MOV instructions for DR1-DR3.
+	BYTE $0x0f; BYTE $0x23; BYTE $0xd8 // mov dr3, rax
+	RET
+dr0:
+	MOVQ AX, DR0
+	RET
+dr1:
+	BYTE $0x0f; BYTE $0x23; BYTE $0xc8 // mov dr1, rax
+	RET
+dr2:
+	BYTE $0x0f; BYTE $0x23; BYTE $0xd0 // mov dr2, rax
+	RET
@@ -0,0 +1,186 @@
+package debugreg
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+	"testing"
+)
+
+func mockDebugRegs() (dr6, dr7 *uint64, addrRegs *[NumSlots]uint64) {
+	dr6, dr7, addrRegs = new(uint64), new(uint64), new([NumSlots]uint64)
+	readDR6Fn = func() uint64 { return *dr6 }
+	writeDR6Fn = func(v uint64) { *dr6 = v }
+	readDR7Fn = func() uint64 { return *dr7 }
+	writeDR7Fn = func(v uint64) { *dr7 = v }
+	writeAddrRegFn = func(slot, addr uint64) { addrRegs[slot] = addr }
+	return dr6, dr7, addrRegs
+}
+
+func restoreDebugRegs() {
+	readDR6Fn = readDR6
+	writeDR6Fn = writeDR6
+	readDR7Fn = readDR7
+	writeDR7Fn = writeDR7
+	writeAddrRegFn = writeAddrReg
+	handleInterruptFn = gate.HandleInterrupt
+	for i := 0; i < NumSlots; i++ {
+		slotHandlers[i] = nil
+		slotAddrs[i] = 0
+	}
+}
+
+func TestSetAndClear(t *testing.T) {
+	defer restoreDebugRegs()
+	_, dr7, addrRegs := mockDebugRegs()
+
+	specs := []struct {
+		fn      func() (int, *kernel.Error)
+		expSlot int
+		expDR7  uint64
+	}{
+		{
+			func() (int, *kernel.Error) { return WatchWrite(0x1000, 4, nil) },
+			0,
+			// L0, RW0 = 01, LEN0 = 11
+			1<<0 | 1<<16 | 3<<18,
+		},
+		{
+			func() (int, *kernel.Error) { return WatchReadWrite(0x2000, 8, nil) },
+			1,
+			// L1, RW1 = 11, LEN1 = 10
+			1<<2 | 3<<20 | 2<<22,
+		},
+		{
+			func() (int, *kernel.Error) { return Break(0x3001, nil) },
+			2,
+			// L2, RW2 = 00, LEN2 = 00
+			1 << 4,
+		},
+		{
+			func() (int, *kernel.Error) { return WatchWrite(0x4002, 2, nil) },
+			3,
+			// L3, RW3 = 01, LEN3 = 01
+			1<<6 | 1<<28 | 1<<30,
+		},
+	}
+
+	var expDR7 uint64
+	for specIndex, spec := range specs {
+		slot, err := spec.fn()
+		if err != nil {
+			t.Fatalf("[spec %d] unexpected error: %v", specIndex, err)
+		}
+
+		if slot != spec.expSlot {
+			t.Fatalf("[spec %d] expected slot %d; got %d", specIndex, spec.expSlot, slot)
+		}
+
+		expDR7 |= spec.expDR7
+		if *dr7 != expDR7 {
+			t.Fatalf("[spec %d] expected DR7 to be 0x%x; got 0x%x", specIndex, expDR7, *dr7)
+		}
+	}
+
+	if exp := [NumSlots]uint64{0x1000, 0x2000, 0x3001, 0x4002}; *addrRegs != exp {
+		t.Fatalf("expected address registers to be %v; got %v", exp, *addrRegs)
+	}
+
+	if _, err := WatchWrite(0x5000, 1, nil); err != errNoFreeSlots {
+		t.Fatalf("expected errNoFreeSlots; got %v", err)
+	}
+
+	if err := Clear(1); err != nil {
+		t.Fatal(err)
+	}
+
+	expDR7 &^= specs[1].expDR7
+	if *dr7 != expDR7 || addrRegs[1] != 0 || slotHandlers[1] != nil {
+		t.Fatalf("expected slot 1 to be cleared; DR7 = 0x%x", *dr7)
+	}
+
+	if slot, _ := WatchWrite(0x5000, 1, nil); slot != 1 {
+		t.Fatalf("expected freed slot 1 to be reused; got %d", slot)
+	}
+
+	for _, slot := range []int{-1, NumSlots} {
+		if err := Clear(slot); err != errInvalidSlot {
+			t.Errorf("expected Clear(%d) to return errInvalidSlot; got %v", slot, err)
+		}
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	defer restoreDebugRegs()
+	mockDebugRegs()
+
+	specs := []struct {
+		addr   uintptr
+		length uint8
+		cond   Condition
+		exp    *kernel.Error
+	}{
+		{0x1000, 3, OnWrite, errInvalidLength},
+		{0x1000, 4, OnExecute, errInvalidLength},
+		{0x1000, 4, Condition(2), errInvalidCondition},
+		{0x1002, 4, OnWrite, errUnalignedAddr},
+		{0x1004, 8, OnReadWrite, errUnalignedAddr},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := Set(spec.addr, spec.length, spec.cond, nil); err != spec.exp {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.exp, err)
+		}
+	}
+}
+
+func TestDebugTrapHandler(t *testing.T) {
+	defer restoreDebugRegs()
+	dr6, _, _ := mockDebugRegs()
+
+	var installed bool
+	handleInterruptFn = func(intNumber gate.InterruptNumber, _ uint8, _ func(*gate.Registers)) {
+		installed = intNumber == gate.Debug
+	}
+	Init()
+	if !installed {
+		t.Fatal("expected Init to install a #DB handler")
+	}
+
+	var triggered []int
+	handler := func(slot int, _ *gate.Registers) { triggered = append(triggered, slot) }
+
+	WatchWrite(0x1000, 4, handler)
+	Break(0x2000, handler)
+	WatchWrite(0x3000, 4, handler)
+
+	// Trigger slots 0 and 1; DR6 bit 14 (single-step) must be preserved
+	*dr6 = 1<<0 | 1<<1 | 1<<14
+	regs := &gate.Registers{}
+	debugTrapHandler(regs)
+
+	if len(triggered) != 2 || triggered[0] != 0 || triggered[1] != 1 {
+		t.Fatalf("expected slots 0 and 1 to be triggered; got %v", triggered)
+	}
+
+	if regs.RFlags&rflagsResume == 0 {
+		t.Fatal("expected RF flag to be set after an instruction breakpoint")
+	}
+
+	if *dr6 != 1<<14 {
+		t.Fatalf("expected DR6 slot bits to be cleared; got 0x%x", *dr6)
+	}
+
+	// The default handler queues a message in the IRQ log
+	Clear(2)
+	WatchWrite(0x3000, 4, nil)
+	*dr6 = 1 << 2
+	debugTrapHandler(&gate.Registers{RIP: 0xbadf00d})
+
+	var buf bytes.Buffer
+	kfmt.DrainIRQLog(&buf)
+	if exp, got := "[debugreg] slot 2 triggered for address 0x3000 at RIP 0xbadf00d\n", buf.String(); got != exp {
+		t.Fatalf("expected logged message %q; got %q", exp, got)
+	}
+}
@@ -1,18 +1,213 @@
 package kernel
 
+import "runtime"
+
+// ErrorCode classifies a kernel error into a broad category that callers can
+// inspect without having to compare error messages.
+type ErrorCode uint8
+
+// The list of supported error codes.
+const (
+	// ErrCodeUnknown is the default (zero value) for errors that have not
+	// been assigned a specific code.
+	ErrCodeUnknown ErrorCode = iota
+
+	// ErrCodeInvalidArgument indicates that a caller supplied an invalid
+	// argument.
+	ErrCodeInvalidArgument
+
+	// ErrCodeNotFound indicates that a requested resource does not exist.
+	ErrCodeNotFound
+
+	// ErrCodeAlreadyExists indicates that a resource cannot be created
+	// because it already exists.
+	ErrCodeAlreadyExists
+
+	// ErrCodeNotSupported indicates that the requested operation is not
+	// supported.
+	ErrCodeNotSupported
+
+	// ErrCodeOutOfMemory indicates that a memory allocation request could
+	// not be satisfied.
+	ErrCodeOutOfMemory
+
+	// ErrCodeIO indicates a failure while communicating with a device.
+	ErrCodeIO
+
+	// ErrCodeBusy indicates that a resource is currently in use.
+	ErrCodeBusy
+
+	// ErrCodeTimeout indicates that an operation did not complete in time.
+	ErrCodeTimeout
+
+	// ErrCodePermission indicates that an operation is not permitted.
+	ErrCodePermission
+
+	// ErrCodeFault indicates an invalid memory access.
+	ErrCodeFault
+
+	// ErrCodeCorrupted indicates that some data failed an integrity check.
+	ErrCodeCorrupted
+)
+
+// Errno is a POSIX-compatible error number that is returned to userland at
+// the syscall boundary.
+type Errno int32
+
+// The list of errno values that kernel errors get mapped to. The values match
+// the ones used by Linux on amd64.
+const (
+	EPERM     Errno = 1
+	ENOENT    Errno = 2
+	EIO       Errno = 5
+	ENOMEM    Errno = 12
+	EFAULT    Errno = 14
+	EBUSY     Errno = 16
+	EEXIST    Errno = 17
+	EINVAL    Errno = 22
+	ENOSYS    Errno = 38
+	EBADMSG   Errno = 74
+	ETIMEDOUT Errno = 110
+)
+
+// errnoByCode maps each ErrorCode to the equivalent errno value.
+var errnoByCode = [...]Errno{
+	ErrCodeUnknown:         EIO,
+	ErrCodeInvalidArgument: EINVAL,
+	ErrCodeNotFound:        ENOENT,
+	ErrCodeAlreadyExists:   EEXIST,
+	ErrCodeNotSupported:    ENOSYS,
+	ErrCodeOutOfMemory:     ENOMEM,
+	ErrCodeIO:              EIO,
+	ErrCodeBusy:            EBUSY,
+	ErrCodeTimeout:         ETIMEDOUT,
+	ErrCodePermission:      EPERM,
+	ErrCodeFault:           EFAULT,
+	ErrCodeCorrupted:       EBADMSG,
+}
+
+// Errno returns the errno value that corresponds to this error code.
+func (c ErrorCode) Errno() Errno {
+	if int(c) >= len(errnoByCode) {
+		return EIO
+	}
+
+	return errnoByCode[c]
+}
+
 // Error describes a kernel error. All kernel errors must be defined as global
 // variables that are pointers to the Error structure. This requirement stems
 // from the fact that the Go allocator is not available to us so we cannot use
 // errors.New.
+//
+// Once the Go allocator becomes available, code can use the Wrap and
+// WithCallSite helpers to obtain copies of a global error that carry
+// additional context. Such copies still match the original global error when
+// compared using the Is method.
 type Error struct {
 	// The module where the error occurred.
 	Module string
 
 	// The error message
 	Message string
+
+	// Code classifies the error.
+	Code ErrorCode
+
+	// Cause is an optional lower-level error that triggered this error.
+	Cause error
+
+	// CallSite is an optional program counter value for the location
+	// where the error was raised.
+	CallSite uintptr
 }
 
-// Error implements the error interface.
+// Error implements the error interface. If the error wraps a cause, the
+// cause's message is appended to the error message.
 func (e *Error) Error() string {
+	if e.Cause != nil {
+		return e.Message + ": " + e.Cause.Error()
+	}
+
 	return e.Message
 }
+
+// Unwrap returns the error that caused this error or nil if no cause has been
+// specified.
+func (e *Error) Unwrap() error {
+	return e.Cause
+}
+
+// Is returns true if target is a *Error with the same module, message and
+// code as this error. It allows the copies returned by Wrap and WithCallSite
+// to be matched against the global error they originated from.
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	if !ok || t == nil {
+		return false
+	}
+
+	return e == t || (e.Module == t.Module && e.Message == t.Message && e.Code == t.Code)
+}
+
+// Wrap returns a copy of this error that records cause as the underlying
+// reason for the failure. This method allocates memory and must not be used
+// before the Go allocator has been initialized.
+func (e *Error) Wrap(cause error) *Error {
+	wrapped := *e
+	wrapped.Cause = cause
+	return &wrapped
+}
+
+// WithCallSite returns a copy of this error that records the program counter
+// of its caller. The call site can be later retrieved via the Location method.
+// This method allocates memory and must not be used before the Go allocator
+// has been initialized.
+func (e *Error) WithCallSite() *Error {
+	var pc [1]uintptr
+
+	captured := *e
+	if runtime.Callers(2, pc[:]) == 1 {
+		captured.CallSite = pc[0]
+	}
+	return &captured
+}
+
+// Location returns the function name, file and line for the recorded call
+// site. If no call site has been recorded or the symbol information is not
+// available, Location returns ok = false.
+func (e *Error) Location() (fn, file string, line int, ok bool) {
+	if e.CallSite == 0 {
+		return "", "", 0, false
+	}
+
+	frame, _ := runtime.CallersFrames([]uintptr{e.CallSite}).Next()
+	if frame.Function == "" {
+		return "", "", 0, false
+	}
+
+	return frame.Function, frame.File, frame.Line, true
+}
+
+// ToErrno walks the error chain of err and returns the errno value for the
+// first *Error that it encounters. If err is nil, ToErrno returns 0. Errors
+// that are not kernel errors are mapped to EIO.
+func ToErrno(err error) Errno {
+	if err == nil {
+		return 0
+	}
+
+	for err != nil {
+		if kErr, ok := err.(*Error); ok && kErr != nil {
+			return kErr.Code.Errno()
+		}
+
+		unwrapper, ok := err.(interface{ Unwrap() error })
+		if !ok {
+			break
+		}
+		err = unwrapper.Unwrap()
+	}
+
+	return EIO
+}
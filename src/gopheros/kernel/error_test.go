@@ -1,6 +1,11 @@
 package kernel
 
-import "testing"
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
 
 func TestKernelError(t *testing.T) {
 	err := &Error{
@@ -11,4 +16,91 @@ func TestKernelError(t *testing.T) {
 	if err.Error() != err.Message {
 		t.Fatalf("expected to err.Error() to return %q; got %q", err.Message, err.Error())
 	}
+
+	if err.Unwrap() != nil {
+		t.Fatal("expected Unwrap() to return nil for an error without a cause")
+	}
 }
+
+func TestKernelErrorWrap(t *testing.T) {
+	var (
+		cause = &Error{Module: "bar", Message: "device timeout", Code: ErrCodeTimeout}
+		err   = &Error{Module: "foo", Message: "init failed", Code: ErrCodeIO}
+		other = &Error{Module: "foo", Message: "init failed", Code: ErrCodeBusy}
+	)
+
+	wrapped := err.Wrap(cause)
+	if wrapped == err {
+		t.Fatal("expected Wrap to return a copy of the error")
+	}
+
+	if exp, got := "init failed: device timeout", wrapped.Error(); got != exp {
+		t.Fatalf("expected wrapped.Error() to return %q; got %q", exp, got)
+	}
+
+	if !errors.Is(wrapped, err) {
+		t.Fatal("expected wrapped error to match the original error")
+	}
+
+	if !errors.Is(wrapped, cause) {
+		t.Fatal("expected wrapped error to match its cause")
+	}
+
+	if errors.Is(wrapped, other) {
+		t.Fatal("expected wrapped error not to match an error with a different code")
+	}
+
+	if err.Is(io.EOF) || err.Is((*Error)(nil)) {
+		t.Fatal("expected Is to return false for non-kernel errors")
+	}
+}
+
+func TestKernelErrorCallSite(t *testing.T) {
+	err := &Error{Module: "foo", Message: "error message"}
+	if _, _, _, ok := err.Location(); ok {
+		t.Fatal("expected Location to return ok = false when no call site is recorded")
+	}
+
+	captured := err.WithCallSite()
+	fn, file, line, ok := captured.Location()
+	if !ok {
+		t.Fatal("expected Location to return the recorded call site")
+	}
+
+	if !strings.HasSuffix(fn, "TestKernelErrorCallSite") || !strings.HasSuffix(file, "error_test.go") || line == 0 {
+		t.Fatalf("unexpected call site: %s (%s:%d)", fn, file, line)
+	}
+
+	if !errors.Is(captured, err) {
+		t.Fatal("expected error with call site to match the original error")
+	}
+}
+
+func TestToErrno(t *testing.T) {
+	specs := []struct {
+		err error
+		exp Errno
+	}{
+		{nil, 0},
+		{io.EOF, EIO},
+		{&Error{Code: ErrCodeOutOfMemory}, ENOMEM},
+		{&Error{Code: ErrCodeNotFound}, ENOENT},
+		{&Error{Code: ErrorCode(255)}, EIO},
+		{(&Error{Code: ErrCodeInvalidArgument}).Wrap(&Error{Code: ErrCodeFault}), EINVAL},
+		{wrapErr{&Error{Code: ErrCodeCorrupted}}, EBADMSG},
+		{wrapErr{nil}, EIO},
+	}
+
+	for specIndex, spec := range specs {
+		if got := ToErrno(spec.err); got != spec.exp {
+			t.Errorf("[spec %d] expected errno %d; got %d", specIndex, spec.exp, got)
+		}
+	}
+}
+
+type wrapErr struct {
+	cause error
+}
+
+func (e wrapErr) Error() string { return "wrapped" }
+func (e wrapErr) Unwrap() error { return e.cause }
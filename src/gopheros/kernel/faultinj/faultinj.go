@@ -0,0 +1,259 @@
+// Package faultinj implements a fault injection framework that allows tests
+// to exercise the error handling paths of kernel subsystems. Subsystems
+// declare a fault injection Site for each operation that can fail (e.g. frame
+// allocations or I/O submissions) and consult it before performing the
+// operation. Each site can be independently configured to fail either with a
+// fixed probability (using a seedable PRNG so failures are reproducible) or
+// deterministically every Nth call.
+//
+// Sites can be configured at boot via the "faultinj" command line option which
+// accepts a comma-separated list of "site:rule" pairs where rule is either
+// "p<percent>" or "n<interval>". The PRNG seed can be set via the
+// "faultinj.seed" option. For example:
+//
+//   faultinj=pmm/alloc-frame:p5,blk/submit:n100 faultinj.seed=42
+package faultinj
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
+	"gopheros/multiboot"
+	"io"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// Mode defines how a Site decides whether to inject a fault.
+type Mode uint8
+
+// The list of supported fault injection modes.
+const (
+	// ModeOff disables fault injection.
+	ModeOff Mode = iota
+
+	// ModeProbability injects faults with a fixed probability.
+	ModeProbability
+
+	// ModeEveryNth injects a fault every Nth call.
+	ModeEveryNth
+)
+
+// defaultSeed is used for seeding the site PRNGs if no seed is specified.
+const defaultSeed = 0x9e3779b97f4a7c15
+
+// Site describes a location in the kernel where faults can be injected.
+type Site struct {
+	name string
+
+	mode  Mode
+	param uint32
+
+	// The xorshift64 PRNG state used by ModeProbability.
+	rngState uint64
+
+	calls    uint32
+	injected uint32
+}
+
+// Name returns the name of this site.
+func (s *Site) Name() string {
+	return s.name
+}
+
+// Stats returns the number of times this site was evaluated and the number of
+// injected faults.
+func (s *Site) Stats() (calls, injected uint32) {
+	return atomic.LoadUint32(&s.calls), atomic.LoadUint32(&s.injected)
+}
+
+// ShouldFail returns true if the caller should simulate a failure for the
+// operation guarded by this site. When fault injection is disabled for the
+// site, ShouldFail returns false without updating any counters.
+func (s *Site) ShouldFail() bool {
+	if s.mode == ModeOff {
+		return false
+	}
+
+	call := atomic.AddUint32(&s.calls, 1)
+
+	var fail bool
+	switch s.mode {
+	case ModeProbability:
+		fail = uint32(s.nextRand()%100) < s.param
+	case ModeEveryNth:
+		fail = call%s.param == 0
+	}
+
+	if fail {
+		atomic.AddUint32(&s.injected, 1)
+	}
+	return fail
+}
+
+// nextRand advances the site PRNG and returns the next value.
+func (s *Site) nextRand() uint64 {
+	x := s.rngState
+	x ^= x << 13
+	x ^= x >> 7
+	x ^= x << 17
+	s.rngState = x
+	return x
+}
+
+var (
+	// sites tracks all sites created via a call to NewSite.
+	sites []*Site
+
+	// seed is used for initializing the PRNG of each site.
+	seed uint64 = defaultSeed
+
+	getBootCmdLineFn = multiboot.GetBootCmdLine
+
+	errUnknownSite = &kernel.Error{Module: "faultinj", Message: "unknown fault injection site", Code: kernel.ErrCodeNotFound}
+	errInvalidRule = &kernel.Error{Module: "faultinj", Message: "invalid fault injection rule", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// NewSite registers and returns a new fault injection site. Sites are
+// typically declared as package-level variables. Fault injection is disabled
+// for new sites.
+func NewSite(name string) *Site {
+	s := &Site{name: name}
+	sites = append(sites, s)
+	return s
+}
+
+// Sites returns the list of registered sites.
+func Sites() []*Site {
+	return sites
+}
+
+// SetSeed sets the seed for the PRNG used by ModeProbability and reseeds all
+// registered sites. Each site derives its own PRNG state from the seed and its
+// name so the sequence of injected faults for a site does not depend on how
+// often other sites are evaluated.
+func SetSeed(newSeed uint64) {
+	seed = newSeed
+	for _, s := range sites {
+		s.reseed()
+	}
+}
+
+// reseed initializes the site PRNG using the global seed and the site name.
+func (s *Site) reseed() {
+	// FNV-1a hash of the site name
+	h := uint64(14695981039346656037)
+	for i := 0; i < len(s.name); i++ {
+		h ^= uint64(s.name[i])
+		h *= 1099511628211
+	}
+
+	if s.rngState = seed ^ h; s.rngState == 0 {
+		s.rngState = defaultSeed
+	}
+}
+
+// Configure sets the fault injection mode for the named site. For
+// ModeProbability, param specifies the failure probability as a percentage;
+// for ModeEveryNth, param specifies the failure interval. Configuring a site
+// resets its statistics.
+func Configure(name string, mode Mode, param uint32) *kernel.Error {
+	if (mode == ModeProbability && param > 100) || (mode == ModeEveryNth && param == 0) {
+		return errInvalidRule
+	}
+
+	for _, s := range sites {
+		if s.name != name {
+			continue
+		}
+
+		s.mode, s.param = mode, param
+		atomic.StoreUint32(&s.calls, 0)
+		atomic.StoreUint32(&s.injected, 0)
+		s.reseed()
+		return nil
+	}
+
+	return errUnknownSite
+}
+
+// ParseRule parses a rule in "p<percent>", "n<interval>" or "off" format and
+// applies it to the named site.
+func ParseRule(name, rule string) *kernel.Error {
+	if rule == "off" {
+		return Configure(name, ModeOff, 0)
+	}
+
+	if len(rule) < 2 {
+		return errInvalidRule
+	}
+
+	param, err := strconv.ParseUint(rule[1:], 10, 32)
+	if err != nil {
+		return errInvalidRule
+	}
+
+	switch rule[0] {
+	case 'p':
+		return Configure(name, ModeProbability, uint32(param))
+	case 'n':
+		return Configure(name, ModeEveryNth, uint32(param))
+	}
+
+	return errInvalidRule
+}
+
+// Init configures the registered sites using the "faultinj" and
+// "faultinj.seed" command line options. Invalid entries are ignored.
+func Init() {
+	cmdLine := getBootCmdLineFn()
+
+	if v, ok := cmdLine["faultinj.seed"]; ok {
+		if newSeed, err := strconv.ParseUint(v, 0, 64); err == nil {
+			SetSeed(newSeed)
+		}
+	}
+
+	for _, entry := range strings.Split(cmdLine["faultinj"], ",") {
+		if sep := strings.LastIndexByte(entry, ':'); sep > 0 {
+			_ = ParseRule(entry[:sep], entry[sep+1:])
+		}
+	}
+}
+
+// cmdFaultInj implements the "faultinj" kshell command. When invoked without
+// arguments it lists the registered sites and their statistics; otherwise it
+// applies a rule to the specified site.
+func cmdFaultInj(w io.Writer, args []string) *kernel.Error {
+	switch len(args) {
+	case 0:
+		for _, s := range sites {
+			calls, injected := s.Stats()
+			kfmt.Fprintf(w, "%s rule=", s.name)
+			switch s.mode {
+			case ModeProbability:
+				kfmt.Fprintf(w, "p%d", s.param)
+			case ModeEveryNth:
+				kfmt.Fprintf(w, "n%d", s.param)
+			default:
+				kfmt.Fprintf(w, "off")
+			}
+			kfmt.Fprintf(w, " calls=%d injected=%d\n", calls, injected)
+		}
+		return nil
+	case 2:
+		return ParseRule(args[0], args[1])
+	default:
+		return errInvalidRule
+	}
+}
+
+func init() {
+	kshell.RegisterCommand(&kshell.Command{
+		Name:  "faultinj",
+		Usage: "[site p<percent>|n<interval>|off]",
+		Help:  "list fault injection sites or configure a site",
+		Fn:    cmdFaultInj,
+	})
+}
@@ -0,0 +1,195 @@
+package faultinj
+
+import (
+	"bytes"
+	"testing"
+)
+
+func resetSites() func() {
+	origSites, origSeed, origCmdLineFn := sites, seed, getBootCmdLineFn
+	sites = nil
+	seed = defaultSeed
+	return func() {
+		sites, seed, getBootCmdLineFn = origSites, origSeed, origCmdLineFn
+	}
+}
+
+func TestSiteOff(t *testing.T) {
+	defer resetSites()()
+
+	s := NewSite("test/off")
+	for i := 0; i < 100; i++ {
+		if s.ShouldFail() {
+			t.Fatal("expected ShouldFail to return false for a disabled site")
+		}
+	}
+
+	if calls, injected := s.Stats(); calls != 0 || injected != 0 {
+		t.Fatalf("expected stats to be (0, 0); got (%d, %d)", calls, injected)
+	}
+}
+
+func TestSiteEveryNth(t *testing.T) {
+	defer resetSites()()
+
+	s := NewSite("test/nth")
+	if err := Configure("test/nth", ModeEveryNth, 3); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []bool
+	for i := 0; i < 6; i++ {
+		got = append(got, s.ShouldFail())
+	}
+
+	exp := []bool{false, false, true, false, false, true}
+	for i := range exp {
+		if got[i] != exp[i] {
+			t.Fatalf("expected failure pattern %v; got %v", exp, got)
+		}
+	}
+
+	if calls, injected := s.Stats(); calls != 6 || injected != 2 {
+		t.Fatalf("expected stats to be (6, 2); got (%d, %d)", calls, injected)
+	}
+}
+
+func TestSiteProbability(t *testing.T) {
+	defer resetSites()()
+
+	s := NewSite("test/prob")
+	NewSite("test/other")
+
+	pattern := func() []bool {
+		if err := Configure("test/prob", ModeProbability, 30); err != nil {
+			t.Fatal(err)
+		}
+
+		var out []bool
+		for i := 0; i < 1000; i++ {
+			out = append(out, s.ShouldFail())
+		}
+		return out
+	}
+
+	SetSeed(42)
+	first := pattern()
+	second := pattern()
+	for i := range first {
+		if first[i] != second[i] {
+			t.Fatal("expected the same seed to produce the same failure pattern")
+		}
+	}
+
+	if _, injected := s.Stats(); injected < 200 || injected > 400 {
+		t.Fatalf("expected roughly 30%% of calls to fail; got %d/1000", injected)
+	}
+
+	SetSeed(43)
+	third := pattern()
+	same := true
+	for i := range first {
+		if first[i] != third[i] {
+			same = false
+			break
+		}
+	}
+	if same {
+		t.Fatal("expected a different seed to produce a different failure pattern")
+	}
+
+	for _, p := range []uint32{0, 100} {
+		_ = Configure("test/prob", ModeProbability, p)
+		for i := 0; i < 100; i++ {
+			if got := s.ShouldFail(); got != (p == 100) {
+				t.Fatalf("[p=%d] expected ShouldFail to return %t", p, p == 100)
+			}
+		}
+	}
+}
+
+func TestConfigureErrors(t *testing.T) {
+	defer resetSites()()
+
+	NewSite("test/site")
+
+	specs := []struct {
+		name, rule string
+		expErr     error
+	}{
+		{"test/site", "p50", nil},
+		{"test/site", "n10", nil},
+		{"test/site", "off", nil},
+		{"test/site", "p101", errInvalidRule},
+		{"test/site", "n0", errInvalidRule},
+		{"test/site", "x10", errInvalidRule},
+		{"test/site", "p", errInvalidRule},
+		{"test/site", "pfoo", errInvalidRule},
+		{"test/missing", "p10", errUnknownSite},
+	}
+
+	for specIndex, spec := range specs {
+		if err := ParseRule(spec.name, spec.rule); (err == nil && spec.expErr != nil) || (err != nil && err != spec.expErr) {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer resetSites()()
+
+	a := NewSite("test/a")
+	b := NewSite("test/b")
+	c := NewSite("test/c")
+
+	getBootCmdLineFn = func() map[string]string {
+		return map[string]string{
+			"faultinj":      "test/a:n2,test/b:p100,bogus,test/c:zz",
+			"faultinj.seed": "0x1234",
+		}
+	}
+	Init()
+
+	if seed != 0x1234 {
+		t.Errorf("expected seed to be 0x1234; got 0x%x", seed)
+	}
+
+	if a.mode != ModeEveryNth || a.param != 2 {
+		t.Errorf("expected site a to be configured with n2; got mode %d, param %d", a.mode, a.param)
+	}
+
+	if b.mode != ModeProbability || b.param != 100 {
+		t.Errorf("expected site b to be configured with p100; got mode %d, param %d", b.mode, b.param)
+	}
+
+	if c.mode != ModeOff {
+		t.Errorf("expected site c to remain disabled; got mode %d", c.mode)
+	}
+}
+
+func TestCmdFaultInj(t *testing.T) {
+	defer resetSites()()
+
+	s := NewSite("test/cmd")
+
+	var buf bytes.Buffer
+	if err := cmdFaultInj(&buf, []string{"test/cmd", "n1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if !s.ShouldFail() {
+		t.Fatal("expected site to be configured via the shell command")
+	}
+
+	if err := cmdFaultInj(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "test/cmd rule=n1 calls=1 injected=1\n"; buf.String() != exp {
+		t.Fatalf("expected output %q; got %q", exp, buf.String())
+	}
+
+	if err := cmdFaultInj(&buf, []string{"test/cmd"}); err != errInvalidRule {
+		t.Fatalf("expected errInvalidRule; got %v", err)
+	}
+}
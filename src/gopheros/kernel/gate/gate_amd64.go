@@ -60,11 +60,18 @@ const (
 	// IDIV instruction.
 	DivideByZero = InterruptNumber(0)
 
+	// Debug occurs when a debug condition (e.g. a HW breakpoint or
+	// watchpoint match or single-stepping) is detected by the CPU.
+	Debug = InterruptNumber(1)
+
 	// NMI (non-maskable-interrupt) is a hardware interrupt that indicates
 	// issues with RAM or unrecoverable hardware problems. It may also be
 	// raised by the CPU when a watchdog timer is enabled.
 	NMI = InterruptNumber(2)
 
+	// Breakpoint occurs when the CPU executes an INT3 instruction.
+	Breakpoint = InterruptNumber(3)
+
 	// Overflow occurs when an overflow occurs (e.g result of division
 	// cannot fit into the registers used).
 	Overflow = InterruptNumber(4)
@@ -131,6 +138,7 @@ const (
 // support for interrupt handling.
 func Init() {
 	installIDT()
+	installTSS()
 }
 
 // HandleInterrupt ensures that the provided handler will be invoked when a
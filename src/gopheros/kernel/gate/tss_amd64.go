@@ -0,0 +1,92 @@
+package gate
+
+import "unsafe"
+
+// The interrupt stack table slots used by exception handlers that must not
+// run on the stack of the interrupted code. The values can be passed as the
+// istOffset argument to HandleInterrupt once a stack has been assigned to
+// them via SetInterruptStack.
+const (
+	// ISTPageFault is used by the page fault handler so that faults
+	// caused by a stack overflow can be reported instead of escalating to
+	// a double fault.
+	ISTPageFault = uint8(1)
+
+	// ISTDoubleFault is used by the double fault handler so that double
+	// faults caused by an invalid stack pointer do not escalate to a
+	// triple fault.
+	ISTDoubleFault = uint8(2)
+)
+
+const (
+	// tssSelector is the GDT selector of the TSS descriptor.
+	tssSelector = uint16(3 << 3)
+
+	// The size of the 64-bit TSS and the offsets of its fields that are
+	// used by the kernel.
+	tssSize           = 104
+	tssISTOffset      = 36
+	tssIOMapOffset    = 102
+	tssDescriptorType = uint64(0x89) // present, 64-bit available TSS
+)
+
+var (
+	// tss holds the 64-bit task state segment. Its only purpose is to
+	// provide the CPU with the interrupt stack table entries.
+	tss [tssSize / 8]uint64
+
+	// gdt replaces the GDT loaded by the rt0 code. The code and data
+	// segment descriptors match the rt0 ones so that the selectors loaded
+	// in the segment registers remain valid. The last two slots hold the
+	// TSS descriptor.
+	gdt = [5]uint64{
+		0,
+		0x00209a0000000000, // 64-bit kernel code segment
+		0x0000920000000000, // kernel data segment
+	}
+
+	// The following functions are mocked by tests.
+	loadGDTFn          = loadGDT
+	loadTaskRegisterFn = loadTaskRegister
+)
+
+// installTSS appends a descriptor for tss to the GDT, loads the GDT and
+// points the task register to the TSS.
+func installTSS() {
+	base := uint64(uintptr(unsafe.Pointer(&tss[0])))
+	limit := uint64(tssSize - 1)
+
+	// Disable the I/O permission bitmap by pointing it past the TSS limit
+	tssBytes := (*[tssSize]byte)(unsafe.Pointer(&tss[0]))
+	*(*uint16)(unsafe.Pointer(&tssBytes[tssIOMapOffset])) = tssSize
+
+	gdt[3] = (limit & 0xffff) |
+		(base&0xffffff)<<16 |
+		tssDescriptorType<<40 |
+		((limit>>16)&0xf)<<48 |
+		((base>>24)&0xff)<<56
+	gdt[4] = base >> 32
+
+	loadGDTFn(uintptr(unsafe.Pointer(&gdt[0])), uint16(len(gdt)*8-1))
+	loadTaskRegisterFn(tssSelector)
+}
+
+// SetInterruptStack sets the interrupt stack table entry that corresponds to
+// istOffset (1-7) to stackTop. Interrupt handlers installed with the same
+// istOffset will run on this stack. As the CPU switches to the top of the
+// stack each time such an interrupt occurs, the stack must not be shared by
+// handlers that can be nested.
+func SetInterruptStack(istOffset uint8, stackTop uintptr) {
+	if istOffset == 0 || istOffset > 7 {
+		return
+	}
+
+	tssBytes := (*[tssSize]byte)(unsafe.Pointer(&tss[0]))
+	*(*uint64)(unsafe.Pointer(&tssBytes[tssISTOffset+int(istOffset-1)*8])) = uint64(stackTop)
+}
+
+// loadGDT loads the GDT at the specified address and limit to the CPU.
+func loadGDT(gdtAddr uintptr, limit uint16)
+
+// loadTaskRegister loads the task register with the specified GDT selector.
+func loadTaskRegister(selector uint16)
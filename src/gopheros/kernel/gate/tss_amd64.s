@@ -0,0 +1,20 @@
+#include "textflag.h"
+
+// The 64-bit GDT descriptor has the same layout as idtDescriptor.
+GLOBL ·gdtDescriptor<>(SB), NOPTR, $10
+
+// loadGDT loads the GDT at the specified address and limit to the CPU.
+TEXT ·loadGDT(SB),NOSPLIT,$0-10
+	LEAQ ·gdtDescriptor<>(SB), AX
+	MOVW limit+8(FP), BX
+	MOVW BX, 0(AX)
+	MOVQ gdtAddr+0(FP), BX
+	MOVQ BX, 2(AX)
+	MOVQ 0(AX), GDTR 	// LGDT[RAX]
+	RET
+
+// loadTaskRegister loads the task register with the specified GDT selector.
+TEXT ·loadTaskRegister(SB),NOSPLIT,$0-2
+	MOVW selector+0(FP), AX
+	BYTE $0x0f; BYTE $0x00; BYTE $0xd8 // LTR AX
+	RET
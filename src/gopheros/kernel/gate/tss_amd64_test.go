@@ -0,0 +1,79 @@
+package gate
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestInstallTSS(t *testing.T) {
+	defer func() {
+		loadGDTFn = loadGDT
+		loadTaskRegisterFn = loadTaskRegister
+	}()
+
+	var (
+		gotGDTAddr  uintptr
+		gotLimit    uint16
+		gotSelector uint16
+	)
+	loadGDTFn = func(gdtAddr uintptr, limit uint16) { gotGDTAddr, gotLimit = gdtAddr, limit }
+	loadTaskRegisterFn = func(selector uint16) { gotSelector = selector }
+
+	installTSS()
+
+	if exp := uintptr(unsafe.Pointer(&gdt[0])); gotGDTAddr != exp || gotLimit != 5*8-1 {
+		t.Fatalf("expected GDT at 0x%x with limit %d to be loaded; got 0x%x with limit %d", exp, 5*8-1, gotGDTAddr, gotLimit)
+	}
+
+	if gotSelector != tssSelector {
+		t.Fatalf("expected task register to be loaded with selector 0x%x; got 0x%x", tssSelector, gotSelector)
+	}
+
+	base := uint64(uintptr(unsafe.Pointer(&tss[0])))
+	gotBase := (gdt[3]>>16)&0xffffff | ((gdt[3]>>56)&0xff)<<24 | gdt[4]<<32
+	if gotBase != base {
+		t.Errorf("expected TSS descriptor base to be 0x%x; got 0x%x", base, gotBase)
+	}
+
+	if gotLimit, gotType := gdt[3]&0xffff, (gdt[3]>>40)&0xff; gotLimit != tssSize-1 || gotType != tssDescriptorType {
+		t.Errorf("expected TSS descriptor limit %d and type 0x%x; got %d and 0x%x", tssSize-1, tssDescriptorType, gotLimit, gotType)
+	}
+
+	tssBytes := (*[tssSize]byte)(unsafe.Pointer(&tss[0]))
+	if got := *(*uint16)(unsafe.Pointer(&tssBytes[tssIOMapOffset])); got != tssSize {
+		t.Errorf("expected I/O map base to be set to %d; got %d", tssSize, got)
+	}
+}
+
+func TestSetInterruptStack(t *testing.T) {
+	defer func(orig [tssSize / 8]uint64) { tss = orig }(tss)
+
+	tssBytes := (*[tssSize]byte)(unsafe.Pointer(&tss[0]))
+	istEntry := func(istOffset uint8) uint64 {
+		return *(*uint64)(unsafe.Pointer(&tssBytes[tssISTOffset+int(istOffset-1)*8]))
+	}
+
+	specs := []struct {
+		istOffset uint8
+		stackTop  uintptr
+	}{
+		{ISTPageFault, 0xbadf00d000},
+		{ISTDoubleFault, 0xc0ffee0000},
+		{7, 0x1000},
+	}
+
+	for specIndex, spec := range specs {
+		SetInterruptStack(spec.istOffset, spec.stackTop)
+		if got := istEntry(spec.istOffset); got != uint64(spec.stackTop) {
+			t.Errorf("[spec %d] expected IST entry %d to be 0x%x; got 0x%x", specIndex, spec.istOffset, spec.stackTop, got)
+		}
+	}
+
+	// Out of range offsets are ignored
+	orig := tss
+	SetInterruptStack(0, 0xdead)
+	SetInterruptStack(8, 0xdead)
+	if tss != orig {
+		t.Error("expected out of range IST offsets to be ignored")
+	}
+}
@@ -2,17 +2,34 @@ package hal
 
 import (
 	"bytes"
+	"encoding/base64"
 	"gopheros/device"
+	"gopheros/device/input"
+	"gopheros/device/serial"
+	"gopheros/device/tpm"
 	"gopheros/device/tty"
 	"gopheros/device/video/console"
 	"gopheros/device/video/console/font"
 	"gopheros/device/video/console/logo"
+	"gopheros/kernel"
 	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
 	"gopheros/multiboot"
+	"image/png"
+	"io"
 	"sort"
 
-	// import and register acpi driver
-	_ "gopheros/device/acpi"
+	// import and register acpi drivers
+	"gopheros/device/acpi"
+	_ "gopheros/device/acpi/apei"
+	_ "gopheros/device/acpi/hpet"
+	_ "gopheros/device/acpi/iommu"
+	_ "gopheros/device/acpi/nfit"
+	_ "gopheros/device/acpi/numa"
+	_ "gopheros/device/acpi/wdat"
+
+	// import and register input drivers
+	_ "gopheros/device/input/ps2"
 )
 
 // managedDevices contains the devices discovered by the HAL.
@@ -20,6 +37,16 @@ type managedDevices struct {
 	activeConsole console.Device
 	activeTTY     tty.Device
 
+	// activeSerial is the serial console (if any) that mirrors the
+	// kernel output.
+	activeSerial serial.Device
+
+	// activeInput is the first detected input device (e.g. a keyboard).
+	activeInput input.Device
+
+	// activeTPM is the first detected TPM.
+	activeTPM tpm.Device
+
 	// activeDrivers tracks all initialized device drivers.
 	activeDrivers []device.Driver
 }
@@ -27,6 +54,18 @@ type managedDevices struct {
 var (
 	devices managedDevices
 	strBuf  bytes.Buffer
+
+	errNoCapturableTTY = &kernel.Error{Module: "hal", Message: "active TTY does not support content capture", Code: kernel.ErrCodeNotSupported}
+	errNoCapturableFb  = &kernel.Error{Module: "hal", Message: "active console does not support framebuffer capture", Code: kernel.ErrCodeNotSupported}
+	errCaptureFailed   = &kernel.Error{Module: "hal", Message: "could not write console capture", Code: kernel.ErrCodeIO}
+)
+
+// The markers used for delimiting the sections emitted by CaptureConsole.
+const (
+	captureTextBegin = "-----BEGIN CONSOLE TEXT-----\n"
+	captureTextEnd   = "-----END CONSOLE TEXT-----\n"
+	capturePNGBegin  = "-----BEGIN CONSOLE PNG-----\n"
+	capturePNGEnd    = "\n-----END CONSOLE PNG-----\n"
 )
 
 // ActiveTTY returns the currently active TTY
@@ -34,6 +73,67 @@ func ActiveTTY() tty.Device {
 	return devices.activeTTY
 }
 
+// ConsoleInput returns a reader for the input of the active serial console.
+// If no serial console that supports input is present, the first detected
+// input device (e.g. a keyboard) is returned instead. ConsoleInput returns nil
+// if no input source is available.
+func ConsoleInput() io.Reader {
+	if r, ok := devices.activeSerial.(io.Reader); ok {
+		return r
+	}
+
+	if devices.activeInput != nil {
+		return devices.activeInput
+	}
+
+	return nil
+}
+
+// ActiveTPM returns the TPM detected by the HAL or nil if no TPM is present.
+func ActiveTPM() tpm.Device {
+	return devices.activeTPM
+}
+
+// CaptureConsole writes a snapshot of the active TTY contents to w so that
+// automated tests can assert on what was actually rendered. The text snapshot
+// is delimited by BEGIN/END CONSOLE TEXT marker lines. If withFramebuffer is
+// true and the active console supports it, a base64-encoded PNG image of the
+// framebuffer contents is also emitted between BEGIN/END CONSOLE PNG markers.
+//
+// The supplied writer is typically a serial port or a fw_cfg channel that
+// the test harness monitors.
+func CaptureConsole(w io.Writer, withFramebuffer bool) *kernel.Error {
+	capturer, ok := devices.activeTTY.(tty.TextCapturer)
+	if !ok {
+		return errNoCapturableTTY
+	}
+
+	kfmt.Fprintf(w, captureTextBegin)
+	if err := capturer.CaptureText(w); err != nil {
+		return errCaptureFailed
+	}
+	kfmt.Fprintf(w, captureTextEnd)
+
+	if !withFramebuffer {
+		return nil
+	}
+
+	fbCapturer, ok := devices.activeConsole.(console.FramebufferCapturer)
+	if !ok {
+		return errNoCapturableFb
+	}
+
+	kfmt.Fprintf(w, capturePNGBegin)
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	if err := png.Encode(enc, fbCapturer.CaptureFramebuffer()); err != nil {
+		return errCaptureFailed
+	}
+	enc.Close()
+	kfmt.Fprintf(w, capturePNGEnd)
+
+	return nil
+}
+
 // DetectHardware probes for hardware devices and initializes the appropriate
 // drivers.
 func DetectHardware() {
@@ -42,6 +142,7 @@ func DetectHardware() {
 	sort.Sort(drivers)
 
 	probe(drivers)
+	restoreFirmwareLogo()
 }
 
 // probe executes the probe function for each driver and invokes
@@ -62,7 +163,7 @@ func probe(driverInfoList device.DriverInfoList) {
 		w.Sink = kfmt.GetOutputSink()
 
 		if err := drv.DriverInit(&w); err != nil {
-			kfmt.Fprintf(&w, "init failed: %s\n", err.Message)
+			kfmt.Fprintf(&w, "init failed: %s\n", err.Error())
 			continue
 		}
 
@@ -87,9 +188,31 @@ func onDriverInit(info *device.DriverInfo, drv device.Driver) {
 		if devices.activeConsole != nil {
 			linkTTYToConsole()
 		}
+	case serial.Device:
+		onSerialConsoleInit(drvImpl)
+	case input.Device:
+		if devices.activeInput == nil {
+			devices.activeInput = drvImpl
+		}
+	case tpm.Device:
+		if devices.activeTPM == nil {
+			devices.activeTPM = drvImpl
+		}
 	}
 }
 
+// onSerialConsoleInit is invoked whenever a serial console is initialized. If
+// this is the first found serial console, the kernel output is mirrored to it
+// so that headless systems can be monitored remotely.
+func onSerialConsoleInit(dev serial.Device) {
+	if devices.activeSerial != nil {
+		return
+	}
+
+	devices.activeSerial = dev
+	updateOutputSink()
+}
+
 // onConsoleInit is invoked whenever a console is initialized. If this is the
 // first found console it automatically becomes the active console. In
 // addition, if the console supports fonts and/or logos this function ensures
@@ -103,19 +226,9 @@ func onConsoleInit(cons console.Device) {
 
 	devices.activeConsole = cons
 
-	if logoSetter, ok := (devices.activeConsole).(console.LogoSetter); ok {
-		disableLogo := false
-		for k, v := range multiboot.GetBootCmdLine() {
-			if k == "consoleLogo" && v == "off" {
-				disableLogo = true
-				break
-			}
-		}
-
-		if !disableLogo {
-			consW, consH := devices.activeConsole.Dimensions(console.Pixels)
-			logoSetter.SetLogo(logo.BestFit(consW, consH))
-		}
+	if logoSetter, ok := (devices.activeConsole).(console.LogoSetter); ok && !logoDisabled() {
+		consW, consH := devices.activeConsole.Dimensions(console.Pixels)
+		logoSetter.SetLogo(logo.BestFit(consW, consH))
 	}
 
 	if fontSetter, ok := (devices.activeConsole).(console.FontSetter); ok {
@@ -145,13 +258,76 @@ func onConsoleInit(cons console.Device) {
 	}
 }
 
+// restoreFirmwareLogo re-renders the boot logo that was displayed by the
+// firmware at its original screen location so that the transition from the
+// firmware splash screen to the kernel console is seamless. As the firmware
+// logo is described by an ACPI table, this function must be invoked after the
+// ACPI driver has been initialized.
+func restoreFirmwareLogo() {
+	drawer, ok := (devices.activeConsole).(console.ImageDrawer)
+	if !ok || logoDisabled() {
+		return
+	}
+
+	bootImage, err := acpi.FirmwareBootImage()
+	if err != nil {
+		return
+	}
+
+	drawer.DrawImage(bootImage.OffsetX, bootImage.OffsetY, bootImage)
+}
+
+// logoDisabled returns true if logo rendering has been disabled via the
+// "consoleLogo=off" boot cmdline option.
+func logoDisabled() bool {
+	for k, v := range multiboot.GetBootCmdLine() {
+		if k == "consoleLogo" && v == "off" {
+			return true
+		}
+	}
+
+	return false
+}
+
 // linkTTYToConsole connects the active TTY device to the active console device
 // and syncs their contents.
 func linkTTYToConsole() {
 	devices.activeTTY.AttachTo(devices.activeConsole)
-	kfmt.SetOutputSink(devices.activeTTY)
+	updateOutputSink()
 
 	// Sync terminal contents with console
 	devices.activeTTY.SetState(tty.StateActive)
 
 }
+
+// updateOutputSink points the kfmt output sink to the active TTY and/or the
+// active serial console. Any output accumulated before the first sink becomes
+// available is flushed to it.
+func updateOutputSink() {
+	switch {
+	case devices.activeTTY != nil && devices.activeSerial != nil:
+		kfmt.SetOutputSink(io.MultiWriter(devices.activeTTY, devices.activeSerial))
+	case devices.activeSerial != nil:
+		kfmt.SetOutputSink(devices.activeSerial)
+	default:
+		kfmt.SetOutputSink(devices.activeTTY)
+	}
+}
+
+// cmdDrivers implements the "drivers" kshell command which lists the active
+// device drivers.
+func cmdDrivers(w io.Writer, _ []string) *kernel.Error {
+	for _, drv := range devices.activeDrivers {
+		major, minor, patch := drv.DriverVersion()
+		kfmt.Fprintf(w, "%s(%d.%d.%d)\n", drv.DriverName(), major, minor, patch)
+	}
+	return nil
+}
+
+func init() {
+	kshell.RegisterCommand(&kshell.Command{
+		Name: "drivers",
+		Help: "list active device drivers",
+		Fn:   cmdDrivers,
+	})
+}
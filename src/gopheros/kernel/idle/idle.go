@@ -0,0 +1,74 @@
+// Package idle implements the routine that a CPU executes when it has no work
+// to do. By default, the CPU is halted until the next interrupt arrives;
+// platform drivers (e.g. the ACPI processor driver) can install a handler
+// that uses deeper power-saving states instead.
+package idle
+
+import (
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mce"
+	"gopheros/kernel/mm/slab"
+	"gopheros/kernel/sync"
+	"gopheros/kernel/watchdog"
+)
+
+var (
+	waitForInterruptFn = cpu.WaitForInterrupt
+	drainIRQLogFn      = kfmt.DrainIRQLog
+
+	// handler holds the active Handler or nil if the default handler
+	// should be used.
+	handler Handler
+
+	// pollers contains the functions registered via RegisterPoller.
+	pollers []func()
+)
+
+// Handler is a function that puts the CPU into a low-power state and returns
+// once the CPU is woken up by an interrupt.
+type Handler func()
+
+// SetHandler installs the routine that is used for idling the CPU. Passing a
+// nil handler restores the default HLT-based handler.
+func SetHandler(h Handler) {
+	handler = h
+}
+
+// RegisterPoller registers a function that is invoked by Poll. Pollers
+// perform deferred work that must not run in interrupt context (e.g.
+// servicing the ACPI events queued by the SCI handler).
+func RegisterPoller(fn func()) {
+	pollers = append(pollers, fn)
+}
+
+// Poll performs the housekeeping work of the idle loop without idling the
+// CPU. It reports a quiescent state to the RCU subsystem, kicks the system
+// watchdog, checks the polled hardware error sources, validates the debug
+// object caches, flushes the messages logged from interrupt context via
+// kfmt.IRQPrintf and invokes the registered pollers. Code that busy-waits for
+// input (e.g. polled console drivers) should call Poll while waiting.
+func Poll() {
+	sync.RCUQuiescentState()
+	watchdog.Poll()
+	mce.Poll()
+	slab.Poll()
+	drainIRQLogFn(nil)
+
+	for _, fn := range pollers {
+		fn()
+	}
+}
+
+// Enter performs a single idle iteration. It invokes Poll and then the active
+// Handler. The idle task is expected to call Enter in a loop.
+func Enter() {
+	Poll()
+
+	if handler != nil {
+		handler()
+		return
+	}
+
+	waitForInterruptFn()
+}
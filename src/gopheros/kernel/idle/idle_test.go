@@ -0,0 +1,75 @@
+package idle
+
+import (
+	"bytes"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/kfmt"
+	"io"
+	"reflect"
+	"testing"
+)
+
+func TestEnter(t *testing.T) {
+	defer func() {
+		waitForInterruptFn = cpu.WaitForInterrupt
+		handler = nil
+	}()
+
+	var defaultCalls, handlerCalls int
+	waitForInterruptFn = func() { defaultCalls++ }
+
+	Enter()
+	if defaultCalls != 1 {
+		t.Fatalf("expected the default handler to be invoked once; got %d", defaultCalls)
+	}
+
+	SetHandler(func() { handlerCalls++ })
+	Enter()
+	if defaultCalls != 1 || handlerCalls != 1 {
+		t.Fatalf("expected only the installed handler to be invoked; got default: %d, installed: %d", defaultCalls, handlerCalls)
+	}
+
+	SetHandler(nil)
+	Enter()
+	if defaultCalls != 2 || handlerCalls != 1 {
+		t.Fatalf("expected the default handler to be restored; got default: %d, installed: %d", defaultCalls, handlerCalls)
+	}
+}
+
+func TestRegisterPoller(t *testing.T) {
+	defer func(orig []func()) {
+		pollers = orig
+		waitForInterruptFn = cpu.WaitForInterrupt
+	}(pollers)
+	waitForInterruptFn = func() {}
+
+	var calls []int
+	RegisterPoller(func() { calls = append(calls, 1) })
+	RegisterPoller(func() { calls = append(calls, 2) })
+
+	Poll()
+	Enter()
+
+	if exp := []int{1, 2, 1, 2}; !reflect.DeepEqual(calls, exp) {
+		t.Fatalf("expected pollers to be invoked in registration order by Poll and Enter; got %v", calls)
+	}
+}
+
+func TestPollDrainsIRQLog(t *testing.T) {
+	defer func() { drainIRQLogFn = kfmt.DrainIRQLog }()
+
+	var buf bytes.Buffer
+	drainIRQLogFn = func(w io.Writer) int {
+		if w != nil {
+			t.Errorf("expected the IRQ log to be drained to the output sink")
+		}
+		return kfmt.DrainIRQLog(&buf)
+	}
+
+	kfmt.IRQPrintf("irq %d\n", 42)
+	Poll()
+
+	if exp, got := "irq 42\n", buf.String(); got != exp {
+		t.Fatalf("expected Poll to drain the IRQ log; got %q", got)
+	}
+}
@@ -1,6 +1,7 @@
 package kfmt
 
 import (
+	"gopheros/kernel/ksyms"
 	"io"
 	"unsafe"
 )
@@ -15,6 +16,10 @@ var (
 	errExtraArg     = []byte("%!(EXTRA)")
 	trueValue       = []byte("true")
 	falseValue      = []byte("false")
+	hexPrefix       = []byte("0x")
+	symbolPrefix    = []byte(" <")
+	symbolOffset    = []byte("+0x")
+	symbolSuffix    = []byte(">")
 
 	numFmtBuf = []byte("012345678901234567890123456789012")
 
@@ -66,6 +71,11 @@ func SetOutputSink(w io.Writer) {
 // Booleans:
 //              %t "true" or "false"
 //
+// Pointers:
+//              %p uintptr values formatted as 0x-prefixed, zero-padded hex. If the
+//                 address can be resolved to a kernel symbol, the symbol name and
+//                 offset are appended as " <name+0xoffset>".
+//
 // Width is specified by an optional decimal number immediately preceding the verb.
 // If absent, the width is whatever is necessary to represent the value.
 //
@@ -77,11 +87,11 @@ func SetOutputSink(w io.Writer) {
 // Go itables have not been initialized yet so it will not check whether its
 // arguments support io.Stringer if they don't match one of the supported tupes.
 //
-// This function does not provide support for printing pointers (%p) as this
-// requires importing the reflect package. By importing reflect, the go compiler
-// starts generating calls to runtime.convT2E (which calls runtime.newobject)
-// when assembling the argument slice which obviously will crash the kernel since
-// memory management is not yet available.
+// This function only supports printing uintptr values via %p as supporting
+// arbitrary pointer types requires importing the reflect package. By importing
+// reflect, the go compiler starts generating calls to runtime.convT2E (which
+// calls runtime.newobject) when assembling the argument slice which obviously
+// will crash the kernel since memory management is not yet available.
 //
 // The output of Printf is written to the currently active TTY. If no TTY is
 // available, then the output is buffered into a ring-buffer and can be
@@ -130,7 +140,7 @@ func Fprintf(w io.Writer, format string, args ...interface{}) {
 			case nextCh >= '0' && nextCh <= '9':
 				padLen = (padLen * 10) + int(nextCh-'0')
 				continue
-			case nextCh == 'd' || nextCh == 'x' || nextCh == 'o' || nextCh == 's' || nextCh == 't':
+			case nextCh == 'd' || nextCh == 'x' || nextCh == 'o' || nextCh == 's' || nextCh == 't' || nextCh == 'p':
 				// Run out of args to print
 				if nextArgIndex >= len(args) {
 					doWrite(w, errMissingArg)
@@ -148,6 +158,8 @@ func Fprintf(w io.Writer, format string, args ...interface{}) {
 					fmtString(w, args[nextArgIndex], padLen)
 				case 't':
 					fmtBool(w, args[nextArgIndex])
+				case 'p':
+					fmtPointer(w, args[nextArgIndex])
 				}
 
 				nextArgIndex++
@@ -211,6 +223,35 @@ func fmtString(w io.Writer, v interface{}, padLen int) {
 	}
 }
 
+// fmtPointer prints a formatted version of uintptr value v followed by the
+// kernel symbol that v resolves to (if any).
+func fmtPointer(w io.Writer, v interface{}) {
+	addr, ok := v.(uintptr)
+	if !ok {
+		doWrite(w, errWrongArgType)
+		return
+	}
+
+	doWrite(w, hexPrefix)
+	fmtNumber(w, uint64(addr), 0, 16, 16)
+
+	name, offset, ok := ksyms.Lookup(addr)
+	if !ok {
+		return
+	}
+
+	doWrite(w, symbolPrefix)
+	// converting the string to a byte slice triggers a memory allocation
+	// so we need to do this one byte at a time.
+	for i := 0; i < len(name); i++ {
+		singleByte[0] = name[i]
+		doWrite(w, singleByte)
+	}
+	doWrite(w, symbolOffset)
+	fmtNumber(w, uint64(offset), 0, 16, 0)
+	doWrite(w, symbolSuffix)
+}
+
 // fmtRepeat writes count bytes with value ch.
 func fmtRepeat(w io.Writer, ch byte, count int) {
 	singleByte[0] = ch
@@ -224,30 +265,10 @@ func fmtRepeat(w io.Writer, ch byte, count int) {
 // and unsigned integer types and base 8, 10 and 16 output.
 func fmtInt(w io.Writer, v interface{}, base, padLen int) {
 	var (
-		sval             int64
-		uval             uint64
-		divider          uint64
-		remainder        uint64
-		padCh            byte
-		left, right, end int
+		sval int64
+		uval uint64
 	)
 
-	if padLen >= maxBufSize {
-		padLen = maxBufSize - 1
-	}
-
-	switch base {
-	case 8:
-		divider = 8
-		padCh = '0'
-	case 10:
-		divider = 10
-		padCh = ' '
-	case 16:
-		divider = 16
-		padCh = '0'
-	}
-
 	switch v.(type) {
 	case uint8:
 		uval = uint64(v.(uint8))
@@ -274,6 +295,36 @@ func fmtInt(w io.Writer, v interface{}, base, padLen int) {
 		return
 	}
 
+	fmtNumber(w, uval, sval, base, padLen)
+}
+
+// fmtNumber prints out a formatted version of an integer value in the requested
+// base, applying the padding specified by padLen. If sval is non-zero, it is
+// used as the value to be formatted; otherwise uval is used.
+func fmtNumber(w io.Writer, uval uint64, sval int64, base, padLen int) {
+	var (
+		divider          uint64
+		remainder        uint64
+		padCh            byte
+		left, right, end int
+	)
+
+	if padLen >= maxBufSize {
+		padLen = maxBufSize - 1
+	}
+
+	switch base {
+	case 8:
+		divider = 8
+		padCh = '0'
+	case 10:
+		divider = 10
+		padCh = ' '
+	case 16:
+		divider = 16
+		padCh = '0'
+	}
+
 	// Handle signs
 	if sval < 0 {
 		uval = uint64(-sval)
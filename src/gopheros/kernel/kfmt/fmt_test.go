@@ -3,6 +3,7 @@ package kfmt
 import (
 	"bytes"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
 )
@@ -83,6 +84,14 @@ func TestPrintf(t *testing.T) {
 			func() { printfn("uintptr 0x%x", uintptr(0xb8000)) },
 			"uintptr 0xb8000",
 		},
+		{
+			func() { printfn("uintptr %p", uintptr(0xb8000)) },
+			"uintptr 0x00000000000b8000",
+		},
+		{
+			func() { printfn("not uintptr %p", 0xb8000) },
+			"not uintptr %!(WRONGTYPE)",
+		},
 		// ints
 
 		{
@@ -170,6 +179,20 @@ func TestPrintf(t *testing.T) {
 	}
 }
 
+func TestPrintfPointerSymbol(t *testing.T) {
+	var (
+		buf  bytes.Buffer
+		addr = reflect.ValueOf(TestPrintfPointerSymbol).Pointer()
+	)
+
+	Fprintf(&buf, "%p", addr+0x10)
+
+	exp := fmt.Sprintf("0x%016x <gopheros/kernel/kfmt.TestPrintfPointerSymbol+0x10>", addr+0x10)
+	if got := buf.String(); got != exp {
+		t.Fatalf("expected to get:\n%q\ngot:\n%q", exp, got)
+	}
+}
+
 func TestPrintfToRingBuffer(t *testing.T) {
 	defer func() {
 		outputSink = nil
@@ -0,0 +1,215 @@
+package kfmt
+
+import (
+	"io"
+	"sync/atomic"
+)
+
+const (
+	// irqRingSize defines the number of records that can be buffered by
+	// the IRQ log ring. The ring size must always be a power of 2.
+	irqRingSize = 128
+
+	// maxIRQPrintfArgs defines the maximum number of arguments that can be
+	// passed to IRQPrintf.
+	maxIRQPrintfArgs = 6
+)
+
+var (
+	// irqLog buffers the output of IRQPrintf till it gets drained by a
+	// call to DrainIRQLog.
+	irqLog irqRing
+
+	irqLogDroppedFmt = "[irqlog] dropped %d record(s)\n"
+)
+
+// irqRecord describes a log entry submitted via IRQPrintf. To avoid
+// formatting the output in interrupt context, each record stores the format
+// string and its arguments; the output is formatted when the record gets
+// drained.
+type irqRecord struct {
+	// seq tracks the state of the record for the current lap of the ring.
+	// For a record at position pos (lap = pos &^ (irqRingSize-1)):
+	//  - seq == lap: the record is free and can be claimed by a producer.
+	//  - seq == lap + 1: the record contains data that can be consumed.
+	// Once the record is consumed, seq is set to lap + irqRingSize which
+	// marks it as free for the next lap.
+	seq uint64
+
+	format   string
+	argCount int
+	args     [maxIRQPrintfArgs]uint64
+}
+
+// irqRing is a bounded, lock-free, multi-producer single-consumer queue of
+// irqRecord entries. Producers never block; if the ring is full the record is
+// dropped and the drop counter is incremented.
+type irqRing struct {
+	records [irqRingSize]irqRecord
+
+	// tail is the next position that will be claimed by a producer.
+	tail uint64
+
+	// head is the next position to be consumed. It is only accessed by
+	// the consumer.
+	head uint64
+
+	// dropped counts the records that could not be added to the ring
+	// because it was full.
+	dropped uint64
+}
+
+// push appends a record to the ring. It returns false if the ring is full.
+func (r *irqRing) push(format string, args []uint64) bool {
+	for {
+		pos := atomic.LoadUint64(&r.tail)
+		rec := &r.records[pos&(irqRingSize-1)]
+		lap := pos &^ (irqRingSize - 1)
+
+		switch seq := atomic.LoadUint64(&rec.seq); {
+		case seq == lap:
+			if !atomic.CompareAndSwapUint64(&r.tail, pos, pos+1) {
+				continue
+			}
+
+			rec.format = format
+			rec.argCount = copy(rec.args[:], args)
+			atomic.StoreUint64(&rec.seq, lap+1)
+			return true
+		case seq < lap:
+			// The record from the previous lap has not been
+			// consumed yet; the ring is full.
+			atomic.AddUint64(&r.dropped, 1)
+			return false
+		}
+
+		// Another producer claimed this position; retry
+	}
+}
+
+// pop removes the oldest record from the ring and copies its contents to out.
+// It returns false if the ring is empty. Only a single consumer may call pop
+// at any point in time.
+func (r *irqRing) pop(out *irqRecord) bool {
+	rec := &r.records[r.head&(irqRingSize-1)]
+	lap := r.head &^ (irqRingSize - 1)
+
+	if atomic.LoadUint64(&rec.seq) != lap+1 {
+		return false
+	}
+
+	out.format = rec.format
+	out.argCount = rec.argCount
+	out.args = rec.args
+	atomic.StoreUint64(&rec.seq, lap+irqRingSize)
+	r.head++
+	return true
+}
+
+// IRQPrintf queues a log message so that it can be safely submitted from
+// interrupt (including NMI) context. Unlike Printf, IRQPrintf never takes any
+// locks nor touches the output sink; the message is formatted and written to
+// the output sink when the log gets drained via a call to DrainIRQLog. As
+// arguments are stored as raw integers, the format string must only use
+// integer verbs (%d, %x and %o). Any arguments beyond maxIRQPrintfArgs are
+// discarded.
+//
+// IRQPrintf returns false if the message could not be queued because the log
+// is full.
+func IRQPrintf(format string, args ...uint64) bool {
+	return irqLog.push(format, args)
+}
+
+// DrainIRQLog formats and writes all messages queued via IRQPrintf to w. If w
+// is nil, the output is sent to the active output sink. DrainIRQLog must only
+// be invoked from a single task (e.g. the idle task via idle.Poll) and never
+// from interrupt context. It returns the number of messages that were
+// drained.
+func DrainIRQLog(w io.Writer) int {
+	var (
+		rec   irqRecord
+		count int
+	)
+
+	if w == nil {
+		w = GetOutputSink()
+	}
+
+	for ; irqLog.pop(&rec); count++ {
+		fprintfUint(w, rec.format, rec.args[:rec.argCount])
+	}
+
+	if dropped := atomic.SwapUint64(&irqLog.dropped, 0); dropped != 0 {
+		droppedArg := [1]uint64{dropped}
+		fprintfUint(w, irqLogDroppedFmt, droppedArg[:])
+	}
+
+	return count
+}
+
+// fprintfUint behaves like Fprintf but only supports integer verbs and
+// receives its arguments as raw uint64 values. Unlike Fprintf, it does not
+// need to box its arguments into interface{} values and therefore never
+// allocates.
+func fprintfUint(w io.Writer, format string, args []uint64) {
+	var (
+		nextCh       byte
+		nextArgIndex int
+		padLen       int
+		fmtLen       = len(format)
+	)
+
+	for blockEnd := 0; blockEnd < fmtLen; blockEnd++ {
+		nextCh = format[blockEnd]
+		if nextCh != '%' {
+			singleByte[0] = nextCh
+			doWrite(w, singleByte)
+			continue
+		}
+
+		// Scan til we hit the format character
+		padLen = 0
+		blockEnd++
+	parseFmt:
+		for ; blockEnd < fmtLen; blockEnd++ {
+			nextCh = format[blockEnd]
+			switch {
+			case nextCh == '%':
+				singleByte[0] = '%'
+				doWrite(w, singleByte)
+				break parseFmt
+			case nextCh >= '0' && nextCh <= '9':
+				padLen = (padLen * 10) + int(nextCh-'0')
+				continue
+			case nextCh == 'd' || nextCh == 'x' || nextCh == 'o' || nextCh == 's' || nextCh == 't' || nextCh == 'p':
+				// Run out of args to print
+				if nextArgIndex >= len(args) {
+					doWrite(w, errMissingArg)
+					break parseFmt
+				}
+
+				switch nextCh {
+				case 'o':
+					fmtNumber(w, args[nextArgIndex], 0, 8, padLen)
+				case 'd':
+					fmtNumber(w, args[nextArgIndex], 0, 10, padLen)
+				case 'x':
+					fmtNumber(w, args[nextArgIndex], 0, 16, padLen)
+				default:
+					doWrite(w, errWrongArgType)
+				}
+
+				nextArgIndex++
+				break parseFmt
+			}
+
+			// reached end of formatting string without finding a verb
+			doWrite(w, errNoVerb)
+		}
+	}
+
+	// Check for unused args
+	for ; nextArgIndex < len(args); nextArgIndex++ {
+		doWrite(w, errExtraArg)
+	}
+}
@@ -0,0 +1,163 @@
+package kfmt
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+)
+
+func TestIRQPrintf(t *testing.T) {
+	defer func() {
+		irqLog = irqRing{}
+		outputSink = nil
+	}()
+
+	var buf bytes.Buffer
+
+	if got := DrainIRQLog(&buf); got != 0 || buf.Len() != 0 {
+		t.Fatalf("expected draining an empty log to be a no-op; got %d records and output %q", got, buf.String())
+	}
+
+	// Fill the ring over multiple laps
+	for lap := 0; lap < 3; lap++ {
+		buf.Reset()
+		for i := 0; i < irqRingSize; i++ {
+			if !IRQPrintf("irq %d: 0x%x\n", uint64(i), uint64(lap)) {
+				t.Fatalf("[lap %d] expected IRQPrintf to succeed for record %d", lap, i)
+			}
+		}
+
+		if IRQPrintf("irq dropped\n") {
+			t.Fatalf("[lap %d] expected IRQPrintf to fail when the ring is full", lap)
+		}
+
+		if got := DrainIRQLog(&buf); got != irqRingSize {
+			t.Fatalf("[lap %d] expected %d drained records; got %d", lap, irqRingSize, got)
+		}
+
+		var exp bytes.Buffer
+		for i := 0; i < irqRingSize; i++ {
+			Fprintf(&exp, "irq %d: 0x%x\n", uint64(i), uint64(lap))
+		}
+		Fprintf(&exp, "[irqlog] dropped 1 record(s)\n")
+
+		if got := buf.String(); got != exp.String() {
+			t.Fatalf("[lap %d] expected output:\n%q\ngot:\n%q", lap, exp.String(), got)
+		}
+	}
+
+	// Extra args are discarded and a nil writer uses the output sink
+	outputSink = &buf
+	buf.Reset()
+	IRQPrintf("%d %d %d %d %d %d\n", 1, 2, 3, 4, 5, 6, 7)
+	DrainIRQLog(nil)
+	if exp, got := "1 2 3 4 5 6\n", buf.String(); got != exp {
+		t.Fatalf("expected output %q; got %q", exp, got)
+	}
+}
+
+func TestIRQPrintfConcurrentProducers(t *testing.T) {
+	defer func() {
+		irqLog = irqRing{}
+	}()
+
+	var (
+		wg           sync.WaitGroup
+		numProducers = 8
+		perProducer  = 1000
+		stop         = make(chan struct{})
+		consumerDone = make(chan int)
+		buf          bytes.Buffer
+	)
+
+	go func() {
+		var total int
+		for {
+			select {
+			case <-stop:
+				total += DrainIRQLog(&buf)
+				consumerDone <- total
+				return
+			default:
+				total += DrainIRQLog(&buf)
+			}
+		}
+	}()
+
+	var queued uint64
+	var queuedMu sync.Mutex
+	wg.Add(numProducers)
+	for p := 0; p < numProducers; p++ {
+		go func(p int) {
+			defer wg.Done()
+			var ok uint64
+			for i := 0; i < perProducer; i++ {
+				if IRQPrintf("%d:%d\n", uint64(p), uint64(i)) {
+					ok++
+				}
+			}
+			queuedMu.Lock()
+			queued += ok
+			queuedMu.Unlock()
+		}(p)
+	}
+
+	wg.Wait()
+	close(stop)
+
+	if drained := <-consumerDone; uint64(drained) != queued {
+		t.Fatalf("expected %d drained records; got %d", queued, drained)
+	}
+}
+
+func TestDrainIRQLogFormatting(t *testing.T) {
+	defer func() { irqLog = irqRing{} }()
+
+	specs := []struct {
+		format string
+		args   []uint64
+	}{
+		{"no args\n", nil},
+		{"%d %x %o", []uint64{42, 0xbadf00d, 8}},
+		{"[%4d] 0x%16x 100%%", []uint64{7, 0xff}},
+		{"%s %t %p", []uint64{1, 2, 3}},
+		{"missing %d %d", []uint64{1}},
+		{"extra %d", []uint64{1, 2}},
+		{"no verb %", nil},
+	}
+
+	for specIndex, spec := range specs {
+		var exp, got bytes.Buffer
+
+		fmtArgs := make([]interface{}, len(spec.args))
+		for i, arg := range spec.args {
+			fmtArgs[i] = arg
+		}
+		Fprintf(&exp, spec.format, fmtArgs...)
+
+		IRQPrintf(spec.format, spec.args...)
+		DrainIRQLog(&got)
+
+		if got.String() != exp.String() {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, exp.String(), got.String())
+		}
+	}
+}
+
+func TestDrainIRQLogDoesNotAllocate(t *testing.T) {
+	defer func() { irqLog = irqRing{} }()
+
+	var w discardWriter
+	allocs := testing.AllocsPerRun(100, func() {
+		IRQPrintf("irq %d: 0x%x\n", 0x1234, 0xbadf00d)
+		DrainIRQLog(&w)
+	})
+
+	if allocs != 0 {
+		t.Fatalf("expected DrainIRQLog not to allocate; got %f allocations per run", allocs)
+	}
+}
+
+type discardWriter struct{}
+
+func (*discardWriter) Write(p []byte) (int, error) { return len(p), nil }
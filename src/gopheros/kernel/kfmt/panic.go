@@ -30,6 +30,9 @@ func Panic(e interface{}) {
 		err = errRuntimePanic
 	}
 
+	// Flush any messages logged from interrupt context so they are not lost
+	DrainIRQLog(nil)
+
 	Printf("\n-----------------------------------\n")
 	if err != nil {
 		Printf("[%s] unrecoverable error: %s\n", err.Module, err.Message)
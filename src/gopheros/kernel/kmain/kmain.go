@@ -1,13 +1,21 @@
 package kmain
 
 import (
+	"gopheros/device/input/keymap"
 	"gopheros/kernel"
+	"gopheros/kernel/debugreg"
+	"gopheros/kernel/faultinj"
 	"gopheros/kernel/gate"
 	"gopheros/kernel/goruntime"
 	"gopheros/kernel/hal"
 	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
 	"gopheros/kernel/mm/pmm"
+	"gopheros/kernel/mm/slab"
 	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/replay"
+	"gopheros/kernel/selftest"
+	"gopheros/kernel/watchdog"
 	"gopheros/multiboot"
 )
 
@@ -22,20 +30,24 @@ var (
 //
 // The rt0 code passes the address of the multiboot info payload provided by the
 // bootloader as well as the physical addresses for the kernel start/end. In
-// addition, the start of the kernel virtual address space is passed to the
-// kernelPageOffset argument.
+// addition, the start of the kernel virtual address space that the kernel was
+// linked against is passed to the kernelPageOffset argument. Before entering
+// long mode, the rt0 code relocates the kernel image to a randomized virtual
+// address; the offset from the link address is passed to the kernelSlide
+// argument.
 //
 // Kmain is not expected to return. If it does, the rt0 code will halt the CPU.
 //
 //go:noinline
-func Kmain(multibootInfoPtr, kernelStart, kernelEnd, kernelPageOffset uintptr) {
+func Kmain(multibootInfoPtr, kernelStart, kernelEnd, kernelPageOffset, kernelSlide uintptr) {
 	multiboot.SetInfoPtr(multibootInfoPtr)
 
 	var err *kernel.Error
 	gate.Init()
+	debugreg.Init()
 	if err = pmm.Init(kernelStart, kernelEnd); err != nil {
 		panic(err)
-	} else if err = vmm.Init(kernelPageOffset); err != nil {
+	} else if err = vmm.Init(kernelPageOffset, kernelSlide); err != nil {
 		panic(err)
 	} else if err = goruntime.Init(); err != nil {
 		panic(err)
@@ -48,6 +60,35 @@ func Kmain(multibootInfoPtr, kernelStart, kernelEnd, kernelPageOffset uintptr) {
 		kfmt.Panic(errKmainReturned)
 	}()
 
+	// Apply any fault injection rules specified via the command line
+	faultinj.Init()
+
+	// Enable object cache debugging if requested via the command line
+	slab.Init()
+
+	// Start recording interrupt/input streams if requested
+	replay.Init()
+
 	// Detect and initialize hardware
 	hal.DetectHardware()
+
+	// Now that all drivers have established their mappings, ensure that
+	// no page is both writable and executable
+	if err = vmm.VerifyWX(); err != nil {
+		kfmt.Printf("[vmm] %s\n", err.Error())
+	}
+
+	// Arm the watchdog if requested via the command line
+	watchdog.Init()
+
+	// Select the keyboard layout requested via the command line
+	if err = keymap.Init(); err != nil {
+		kfmt.Printf("[keymap] %s\n", err.Error())
+	}
+
+	// Run boot-time self-tests if requested
+	selftest.Init()
+
+	// Start the debug shell on the serial console
+	kshell.Init(hal.ConsoleInput())
 }
@@ -0,0 +1,117 @@
+package kshell
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/ksyms"
+	"io"
+	"strconv"
+	"unsafe"
+)
+
+const (
+	// defaultDumpLen is the number of bytes displayed by the mem command
+	// when no length is specified.
+	defaultDumpLen = 64
+
+	// bytesPerDumpLine is the number of bytes displayed on each line of
+	// the mem command output.
+	bytesPerDumpLine = 16
+)
+
+// cmdHelp lists all registered commands.
+func cmdHelp(w io.Writer, _ []string) *kernel.Error {
+	for _, cmd := range Commands() {
+		kfmt.Fprintf(w, "%s %s\n    %s\n", cmd.Name, cmd.Usage, cmd.Help)
+	}
+	return nil
+}
+
+// cmdMem dumps the contents of a memory region in hex and ASCII format.
+func cmdMem(w io.Writer, args []string) *kernel.Error {
+	if len(args) < 1 || len(args) > 2 {
+		return errInvalidArgs
+	}
+
+	addr, err := strconv.ParseUint(args[0], 0, 64)
+	if err != nil {
+		return errInvalidArgs
+	}
+
+	length := uint64(defaultDumpLen)
+	if len(args) == 2 {
+		if length, err = strconv.ParseUint(args[1], 0, 64); err != nil {
+			return errInvalidArgs
+		}
+	}
+
+	var ascii [bytesPerDumpLine]byte
+	for offset := uint64(0); offset < length; offset += bytesPerDumpLine {
+		kfmt.Fprintf(w, "%16x: ", addr+offset)
+
+		lineLen := length - offset
+		if lineLen > bytesPerDumpLine {
+			lineLen = bytesPerDumpLine
+		}
+
+		for i := uint64(0); i < bytesPerDumpLine; i++ {
+			if i >= lineLen {
+				kfmt.Fprintf(w, "   ")
+				continue
+			}
+
+			b := *(*byte)(unsafe.Pointer(uintptr(addr + offset + i)))
+			kfmt.Fprintf(w, "%2x ", b)
+
+			ascii[i] = '.'
+			if b >= 0x20 && b < 0x7f {
+				ascii[i] = b
+			}
+		}
+
+		kfmt.Fprintf(w, " |%s|\n", ascii[:lineLen])
+	}
+
+	return nil
+}
+
+// cmdSym resolves an address to a symbol or a symbol to an address.
+func cmdSym(w io.Writer, args []string) *kernel.Error {
+	if len(args) != 1 {
+		return errInvalidArgs
+	}
+
+	if addr, err := strconv.ParseUint(args[0], 0, 64); err == nil {
+		ksyms.WriteSymbol(w, uintptr(addr))
+		kfmt.Fprintf(w, "\n")
+		return nil
+	}
+
+	addr, err := ksyms.Address(args[0])
+	if err != nil {
+		return err
+	}
+
+	kfmt.Fprintf(w, "0x%16x\n", addr)
+	return nil
+}
+
+func init() {
+	RegisterCommand(&Command{
+		Name: "help",
+		Help: "list available commands",
+		Fn:   cmdHelp,
+	})
+	RegisterCommand(&Command{
+		Name:  "mem",
+		Usage: "addr [len]",
+		Help:  "dump len (default: 64) bytes of memory starting at addr",
+		Fn:    cmdMem,
+	})
+	RegisterCommand(&Command{
+		Name:  "sym",
+		Usage: "addr|name",
+		Help:  "resolve an address to a kernel symbol or a symbol to its address",
+		Fn:    cmdSym,
+	})
+}
@@ -0,0 +1,159 @@
+// Package kshell implements an interactive kernel debug shell. Subsystems can
+// extend the shell by registering their own commands via RegisterCommand.
+// The shell reads its input from an io.Reader (e.g. a serial port or the
+// keyboard) and writes its output to an io.Writer (e.g. the active TTY).
+package kshell
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/multiboot"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	// prompt is displayed by Run before reading each command.
+	prompt = "kshell> "
+
+	// maxLineLen defines the maximum length of an input line.
+	maxLineLen = 256
+)
+
+// CommandFn is a function that implements a shell command. It receives the
+// writer where any output should be sent and the command arguments (not
+// including the command name).
+type CommandFn func(w io.Writer, args []string) *kernel.Error
+
+// Command describes a shell command.
+type Command struct {
+	// Name is the name used for invoking the command.
+	Name string
+
+	// Usage describes the command arguments (e.g. "addr [len]").
+	Usage string
+
+	// Help is a short description of what the command does.
+	Help string
+
+	// Fn implements the command.
+	Fn CommandFn
+}
+
+var (
+	// registeredCommands tracks the commands registered via a call to
+	// RegisterCommand.
+	registeredCommands = make(map[string]*Command)
+
+	getBootCmdLineFn = multiboot.GetBootCmdLine
+
+	errUnknownCommand = &kernel.Error{Module: "kshell", Message: "unknown command; type help for a list of commands", Code: kernel.ErrCodeNotFound}
+	errInvalidArgs    = &kernel.Error{Module: "kshell", Message: "invalid arguments", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// RegisterCommand adds cmd to the list of commands supported by the shell. If
+// a command with the same name already exists, it is replaced.
+func RegisterCommand(cmd *Command) {
+	registeredCommands[cmd.Name] = cmd
+}
+
+// Commands returns the list of registered commands sorted by name.
+func Commands() []*Command {
+	list := make([]*Command, 0, len(registeredCommands))
+	for _, cmd := range registeredCommands {
+		list = append(list, cmd)
+	}
+
+	sort.Sort(commandsByName(list))
+	return list
+}
+
+// commandsByName implements sort.Interface for sorting a list of commands by
+// their name.
+type commandsByName []*Command
+
+func (l commandsByName) Len() int           { return len(l) }
+func (l commandsByName) Less(i, j int) bool { return l[i].Name < l[j].Name }
+func (l commandsByName) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// Exec parses line and executes the requested command sending its output to
+// w. Empty lines are ignored.
+func Exec(w io.Writer, line string) *kernel.Error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	cmd, ok := registeredCommands[fields[0]]
+	if !ok {
+		return errUnknownCommand
+	}
+
+	return cmd.Fn(w, fields[1:])
+}
+
+// Init starts an interactive shell that reads its input from r and writes its
+// output to the active kfmt output sink. Init returns when the shell exits.
+// The shell is not started if r is nil or if the kernel was booted with the
+// "kshell=off" command line option.
+func Init(r io.Reader) {
+	if r == nil {
+		return
+	}
+
+	for k, v := range getBootCmdLineFn() {
+		if k == "kshell" && v == "off" {
+			return
+		}
+	}
+
+	Run(r, kfmt.GetOutputSink())
+}
+
+// Run implements an interactive read-eval-print loop. Input is read from r
+// and echoed back to w. The loop terminates when the "exit" command is
+// entered or when r returns an error (e.g. io.EOF).
+func Run(r io.Reader, w io.Writer) {
+	var (
+		lineBuf [maxLineLen]byte
+		lineLen int
+		in      [1]byte
+	)
+
+	kfmt.Fprintf(w, prompt)
+	for {
+		if n, err := r.Read(in[:]); err != nil {
+			return
+		} else if n == 0 {
+			continue
+		}
+
+		switch ch := in[0]; ch {
+		case '\r', '\n':
+			kfmt.Fprintf(w, "\n")
+			line := strings.TrimSpace(string(lineBuf[:lineLen]))
+			lineLen = 0
+
+			if line == "exit" {
+				return
+			}
+
+			if err := Exec(w, line); err != nil {
+				kfmt.Fprintf(w, "error: %s\n", err.Error())
+			}
+			kfmt.Fprintf(w, prompt)
+		case '\b', 0x7f:
+			if lineLen > 0 {
+				lineLen--
+				kfmt.Fprintf(w, "\b")
+			}
+		default:
+			if lineLen < maxLineLen {
+				lineBuf[lineLen] = ch
+				lineLen++
+				w.Write(in[:])
+			}
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package kshell
+
+import (
+	"bytes"
+	"fmt"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/multiboot"
+	"io"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestExec(t *testing.T) {
+	defer delete(registeredCommands, "echo")
+
+	RegisterCommand(&Command{
+		Name: "echo",
+		Fn: func(w io.Writer, args []string) *kernel.Error {
+			w.Write([]byte(strings.Join(args, ",")))
+			return nil
+		},
+	})
+
+	specs := []struct {
+		line   string
+		expOut string
+		expErr *kernel.Error
+	}{
+		{"", "", nil},
+		{"   ", "", nil},
+		{"echo a  b c", "a,b,c", nil},
+		{"  echo", "", nil},
+		{"foo bar", "", errUnknownCommand},
+	}
+
+	for specIndex, spec := range specs {
+		var buf bytes.Buffer
+		if err := Exec(&buf, spec.line); err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if got := buf.String(); got != spec.expOut {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, spec.expOut, got)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	var buf bytes.Buffer
+	Run(strings.NewReader("hlp\b\belp\nbogus\r\nexit\nhelp\n"), &buf)
+
+	out := buf.String()
+	if exp := prompt + "hlp\b\belp\n"; !strings.HasPrefix(out, exp) {
+		t.Fatalf("expected output to start with %q; got %q", exp, out)
+	}
+
+	for _, exp := range []string{"mem addr [len]", "sym addr|name", "error: " + errUnknownCommand.Message} {
+		if !strings.Contains(out, exp) {
+			t.Errorf("expected output to contain %q; got %q", exp, out)
+		}
+	}
+
+	// Input following the exit command should not be processed
+	if !strings.HasSuffix(out, prompt+"exit\n") {
+		t.Errorf("expected the shell to terminate after exit; got %q", out)
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer func() {
+		getBootCmdLineFn = multiboot.GetBootCmdLine
+		kfmt.SetOutputSink(nil)
+	}()
+
+	var buf bytes.Buffer
+	kfmt.SetOutputSink(&buf)
+
+	specs := []struct {
+		input   io.Reader
+		cmdLine map[string]string
+		expOut  string
+	}{
+		{nil, nil, ""},
+		{strings.NewReader("help\n"), map[string]string{"kshell": "off"}, ""},
+		{strings.NewReader("sym\r"), nil, prompt + "sym\nerror: " + errInvalidArgs.Message + "\n" + prompt},
+	}
+
+	for specIndex, spec := range specs {
+		buf.Reset()
+		getBootCmdLineFn = func() map[string]string { return spec.cmdLine }
+
+		Init(spec.input)
+		if got := buf.String(); got != spec.expOut {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, spec.expOut, got)
+		}
+	}
+}
+
+func TestCmdMem(t *testing.T) {
+	data := []byte("0123456789abcdef\x00gopher")
+	addr := uintptr(unsafe.Pointer(&data[0]))
+
+	var buf bytes.Buffer
+	if err := Exec(&buf, fmt.Sprintf("mem 0x%x %d", addr, len(data))); err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("expected 2 lines of output; got %d: %q", len(lines), buf.String())
+	}
+
+	specs := []string{
+		fmt.Sprintf("%016x: 30 31 32 33 34 35 36 37 38 39 61 62 63 64 65 66  |0123456789abcdef|", addr),
+		fmt.Sprintf("%016x: 00 67 6f 70 68 65 72 %s |.gopher|", addr+16, strings.Repeat("   ", 9)),
+	}
+
+	for specIndex, exp := range specs {
+		if lines[specIndex] != exp {
+			t.Errorf("[spec %d] expected line:\n%q\ngot:\n%q", specIndex, exp, lines[specIndex])
+		}
+	}
+
+	for specIndex, line := range []string{"mem", "mem foo", "mem 0x1000 foo", "mem 1 2 3"} {
+		if err := Exec(&buf, line); err != errInvalidArgs {
+			t.Errorf("[spec %d] expected to get errInvalidArgs; got %v", specIndex, err)
+		}
+	}
+}
+
+func TestCmdSym(t *testing.T) {
+	var buf bytes.Buffer
+	if err := Exec(&buf, "sym gopheros/kernel/kshell.Exec"); err != nil {
+		t.Fatal(err)
+	}
+
+	addr := strings.TrimSpace(buf.String())
+	if !strings.HasPrefix(addr, "0x") {
+		t.Fatalf("expected sym to print an address; got %q", addr)
+	}
+
+	buf.Reset()
+	if err := Exec(&buf, "sym "+addr); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "gopheros/kernel/kshell.Exec"; !strings.Contains(buf.String(), exp) {
+		t.Fatalf("expected sym output to contain %q; got %q", exp, buf.String())
+	}
+
+	if err := Exec(&buf, "sym"); err != errInvalidArgs {
+		t.Fatalf("expected to get errInvalidArgs; got %v", err)
+	}
+
+	if err := Exec(&buf, "sym no.such.symbol"); err == nil {
+		t.Fatal("expected sym to fail for an unknown symbol")
+	}
+}
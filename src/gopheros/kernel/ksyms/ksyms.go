@@ -0,0 +1,165 @@
+// Package ksyms provides runtime lookups for kernel symbols. The lookups are
+// backed by the symbol table that the Go linker embeds into the kernel image
+// and can be used to resolve an address to a symbol+offset pair or a symbol
+// name to its address.
+package ksyms
+
+import (
+	"gopheros/kernel"
+	"io"
+	"runtime"
+)
+
+// maxStackDepth defines the maximum number of frames reported by DumpStack.
+const maxStackDepth = 32
+
+var (
+	// The following functions are mocked by tests.
+	funcForPCFn = runtime.FuncForPC
+	textRangeFn = textRange
+
+	// symbolIndex maps symbol names to their entry address. It is
+	// lazily populated by the first call to Address.
+	symbolIndex map[string]uintptr
+
+	errSymbolNotFound = &kernel.Error{Module: "ksyms", Message: "symbol not found", Code: kernel.ErrCodeNotFound}
+)
+
+// Lookup resolves addr to the name of the function that contains it and the
+// offset of addr from the function entry point. Lookup does not allocate any
+// memory and can be used even before the Go allocator is initialized.
+func Lookup(addr uintptr) (name string, offset uintptr, ok bool) {
+	fn := funcForPCFn(addr)
+	if fn == nil {
+		return "", 0, false
+	}
+
+	// If addr points to code that was inlined, fn describes the innermost
+	// inlined function. The entry point always resolves to the outermost
+	// function which is the one that owns the symbol.
+	entry := fn.Entry()
+	if outer := funcForPCFn(entry); outer != nil {
+		fn = outer
+	}
+
+	return fn.Name(), addr - entry, true
+}
+
+// Address returns the entry address for the function with the specified
+// fully qualified name (e.g. "gopheros/kernel/kmain.Kmain"). The first call
+// to Address scans the text section to build a symbol index; consequently,
+// Address must not be invoked before the Go allocator is initialized.
+func Address(name string) (uintptr, *kernel.Error) {
+	if symbolIndex == nil {
+		buildIndex()
+	}
+
+	addr, ok := symbolIndex[name]
+	if !ok {
+		return 0, errSymbolNotFound
+	}
+
+	return addr, nil
+}
+
+// buildIndex scans the text section and populates the symbol index. As
+// function bodies are laid out contiguously, the end of each function is
+// located by galloping (exponential) search followed by a binary search for
+// the first address that maps to a different function.
+func buildIndex() {
+	symbolIndex = make(map[string]uintptr)
+
+	start, end := textRangeFn()
+	for pc := start; pc < end; {
+		fn := funcForPCFn(pc)
+		if fn == nil {
+			pc++
+			continue
+		}
+
+		entry := fn.Entry()
+		if outer := funcForPCFn(entry); outer != nil {
+			symbolIndex[outer.Name()] = entry
+		}
+
+		// Find an upper bound for the function end
+		lo, step := pc, uintptr(1)
+		hi := lo + step
+		for hi < end && sameFunc(hi, entry) {
+			lo, step = hi, step<<1
+			hi = lo + step
+		}
+		if hi > end {
+			hi = end
+		}
+
+		// Binary search for the first address in (lo, hi] that
+		// belongs to a different function.
+		for lo+1 < hi {
+			mid := lo + (hi-lo)/2
+			if sameFunc(mid, entry) {
+				lo = mid
+			} else {
+				hi = mid
+			}
+		}
+
+		pc = hi
+	}
+}
+
+// sameFunc returns true if pc belongs to the function with the specified
+// entry address.
+func sameFunc(pc, entry uintptr) bool {
+	fn := funcForPCFn(pc)
+	return fn != nil && fn.Entry() == entry
+}
+
+// WriteSymbol writes a "name+0xoffset" representation of addr to w. If addr
+// cannot be resolved, WriteSymbol writes "?".
+func WriteSymbol(w io.Writer, addr uintptr) {
+	var (
+		buf [3 + 16]byte
+		n   = len(buf)
+	)
+
+	name, offset, ok := Lookup(addr)
+	if !ok {
+		w.Write([]byte{'?'})
+		return
+	}
+
+	io.WriteString(w, name)
+
+	// Format offset as hex without allocating
+	for {
+		n--
+		buf[n] = "0123456789abcdef"[offset&0xf]
+		offset >>= 4
+		if offset == 0 {
+			break
+		}
+	}
+	n -= 3
+	buf[n], buf[n+1], buf[n+2] = '+', '0', 'x'
+	w.Write(buf[n:])
+}
+
+// DumpStack writes a symbolized stack trace of the calling task to w. The
+// skip argument specifies the number of additional frames to skip; 0
+// identifies the caller of DumpStack.
+func DumpStack(w io.Writer, skip int) {
+	var pcs [maxStackDepth]uintptr
+
+	count := runtime.Callers(skip+2, pcs[:])
+	for i := 0; i < count; i++ {
+		io.WriteString(w, "  ")
+		// Callers returns return addresses; subtract 1 so the address
+		// points inside the call instruction.
+		WriteSymbol(w, pcs[i]-1)
+		io.WriteString(w, "\n")
+	}
+}
+
+// textRange returns the start and end address of the kernel text section.
+func textRange() (uintptr, uintptr)
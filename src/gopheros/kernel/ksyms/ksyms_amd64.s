@@ -0,0 +1,10 @@
+#include "textflag.h"
+
+// textRange returns the start and end address of the kernel text section
+// as reported by the linker.
+TEXT ·textRange(SB),NOSPLIT,$0
+	LEAQ runtime·text(SB), AX
+	MOVQ AX, ret+0(FP)
+	LEAQ runtime·etext(SB), AX
+	MOVQ AX, ret1+8(FP)
+	RET
@@ -0,0 +1,93 @@
+package ksyms
+
+import (
+	"bytes"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	entry := funcEntry(TestLookup)
+
+	name, offset, ok := Lookup(entry + 4)
+	if !ok {
+		t.Fatal("expected Lookup to resolve the address of a known function")
+	}
+
+	if exp := "gopheros/kernel/ksyms.TestLookup"; name != exp || offset != 4 {
+		t.Fatalf("expected Lookup to return (%q, 4); got (%q, %d)", exp, name, offset)
+	}
+
+	if _, _, ok = Lookup(0); ok {
+		t.Fatal("expected Lookup to fail for address 0")
+	}
+}
+
+func TestAddress(t *testing.T) {
+	defer func() {
+		symbolIndex = nil
+	}()
+
+	specs := []interface{}{
+		TestAddress,
+		Lookup,
+		buildIndex,
+		strings.HasPrefix,
+	}
+
+	for specIndex, spec := range specs {
+		exp := funcEntry(spec)
+		name := runtime.FuncForPC(exp).Name()
+
+		got, err := Address(name)
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error while looking up %q: %v", specIndex, name, err)
+			continue
+		}
+
+		if got != exp {
+			t.Errorf("[spec %d] expected address of %q to be 0x%x; got 0x%x", specIndex, name, exp, got)
+		}
+	}
+
+	if _, err := Address("no/such.Symbol"); err != errSymbolNotFound {
+		t.Fatalf("expected errSymbolNotFound; got %v", err)
+	}
+}
+
+func TestWriteSymbol(t *testing.T) {
+	var buf bytes.Buffer
+
+	WriteSymbol(&buf, funcEntry(TestWriteSymbol)+0x1a)
+	if exp := "gopheros/kernel/ksyms.TestWriteSymbol+0x1a"; buf.String() != exp {
+		t.Fatalf("expected %q; got %q", exp, buf.String())
+	}
+
+	buf.Reset()
+	WriteSymbol(&buf, funcEntry(TestWriteSymbol))
+	if exp := "gopheros/kernel/ksyms.TestWriteSymbol+0x0"; buf.String() != exp {
+		t.Fatalf("expected %q; got %q", exp, buf.String())
+	}
+
+	buf.Reset()
+	WriteSymbol(&buf, 0)
+	if buf.String() != "?" {
+		t.Fatalf("expected %q; got %q", "?", buf.String())
+	}
+}
+
+func TestDumpStack(t *testing.T) {
+	var buf bytes.Buffer
+	DumpStack(&buf, 0)
+
+	lines := strings.Split(buf.String(), "\n")
+	if !strings.HasPrefix(lines[0], "  gopheros/kernel/ksyms.TestDumpStack+0x") {
+		t.Fatalf("expected first stack frame to point to the caller; got %q", lines[0])
+	}
+}
+
+func funcEntry(fn interface{}) uintptr {
+	return reflect.ValueOf(fn).Pointer()
+}
@@ -0,0 +1,130 @@
+// Package mce implements the machine check subsystem which collects the
+// hardware errors reported by platform error sources (e.g. the generic
+// hardware error sources described by the ACPI HEST table). Error source
+// drivers register their sources with this package specifying how the
+// kernel is notified about new errors: polled sources are checked
+// periodically by the idle task via Poll while NMI-signalled sources are
+// checked by the next call to Poll after the CPU receives an NMI.
+//
+// Errors reported by the sources are written to the active kfmt output sink.
+package mce
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+	"io"
+	"sync/atomic"
+)
+
+var (
+	errUnsupportedNotification = &kernel.Error{Module: "mce", Message: "unsupported error source notification type", Code: kernel.ErrCodeNotSupported}
+
+	handleInterruptFn = gate.HandleInterrupt
+	nanosecondsFn     = clock.Nanoseconds
+
+	// The registered error sources grouped by notification type.
+	polledSources []*polledSource
+	nmiSources    []Source
+
+	// Set once the NMI handler has been installed.
+	nmiHandlerInstalled bool
+
+	// nmiPending is set to 1 by the NMI handler and cleared by Poll once
+	// the NMI-signalled sources have been checked.
+	nmiPending uint32
+)
+
+// The number of nanoseconds in a millisecond.
+const nsPerMillisecond = uint64(1000000)
+
+// Source is implemented by hardware error sources.
+type Source interface {
+	// ErrorSourceName returns the name of the error source.
+	ErrorSourceName() string
+
+	// CheckErrors reports any errors logged by the source since the last
+	// check to w and returns true if any errors were found.
+	CheckErrors(w io.Writer) bool
+}
+
+// Notification describes how the kernel learns about errors logged by an
+// error source.
+type Notification uint8
+
+// The list of supported notification types.
+const (
+	// NotifyPolled indicates that the source must be checked
+	// periodically.
+	NotifyPolled Notification = iota
+
+	// NotifyNMI indicates that the source signals errors via an NMI.
+	NotifyNMI
+)
+
+// polledSource tracks the poll interval of a polled error source. All times
+// are in nanoseconds.
+type polledSource struct {
+	src      Source
+	interval uint64
+	lastPoll uint64
+}
+
+// RegisterSource registers an error source with the machine check subsystem.
+// The pollInterval argument specifies the interval in milliseconds at which
+// polled sources are checked and is ignored for other notification types.
+func RegisterSource(src Source, notify Notification, pollInterval uint32) *kernel.Error {
+	switch notify {
+	case NotifyPolled:
+		polledSources = append(polledSources, &polledSource{
+			src:      src,
+			interval: uint64(pollInterval) * nsPerMillisecond,
+			lastPoll: nanosecondsFn(),
+		})
+	case NotifyNMI:
+		if !nmiHandlerInstalled {
+			handleInterruptFn(gate.NMI, 0, handleNMI)
+			nmiHandlerInstalled = true
+		}
+		nmiSources = append(nmiSources, src)
+	default:
+		return errUnsupportedNotification
+	}
+
+	return nil
+}
+
+// Poll checks the polled error sources whose poll interval has elapsed and,
+// if an NMI was received since the last call, the NMI-signalled sources. If
+// no clock source is available, all polled sources are checked on each call.
+// The idle task is expected to call Poll on each idle iteration.
+func Poll() {
+	if atomic.SwapUint32(&nmiPending, 0) == 1 {
+		for _, src := range nmiSources {
+			src.CheckErrors(kfmt.GetOutputSink())
+		}
+	}
+
+	if len(polledSources) == 0 {
+		return
+	}
+
+	now := nanosecondsFn()
+	for _, ps := range polledSources {
+		if now != 0 && now-ps.lastPoll < ps.interval {
+			continue
+		}
+
+		ps.lastPoll = now
+		ps.src.CheckErrors(kfmt.GetOutputSink())
+	}
+}
+
+// handleNMI flags the NMI-signalled error sources for checking by the next
+// call to Poll. Reporting errors involves writing to the output sink which
+// is not safe in NMI context.
+func handleNMI(_ *gate.Registers) {
+	atomic.StoreUint32(&nmiPending, 1)
+	kfmt.IRQPrintf("[mce] NMI received; checking hardware error sources\n")
+}
@@ -0,0 +1,122 @@
+package mce
+
+import (
+	"bytes"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestRegisterSource(t *testing.T) {
+	defer resetState()
+
+	var nmiHandler func(*gate.Registers)
+	handleInterruptFn = func(intNumber gate.InterruptNumber, _ uint8, handler func(*gate.Registers)) {
+		if intNumber != gate.NMI {
+			t.Errorf("expected handler to be installed for NMI; got %d", intNumber)
+		}
+		nmiHandler = handler
+	}
+
+	if err := RegisterSource(&fakeSource{}, Notification(42), 0); err != errUnsupportedNotification {
+		t.Fatalf("expected to get error %v; got %v", errUnsupportedNotification, err)
+	}
+
+	srcA, srcB := &fakeSource{}, &fakeSource{}
+	for _, src := range []*fakeSource{srcA, srcB} {
+		if err := RegisterSource(src, NotifyNMI, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if nmiHandler == nil {
+		t.Fatal("expected an NMI handler to be installed")
+	}
+
+	// NMI sources are only checked by Poll after an NMI is received
+	Poll()
+	if srcA.checks != 0 || srcB.checks != 0 {
+		t.Fatalf("expected NMI sources not to be checked before an NMI; got %d, %d", srcA.checks, srcB.checks)
+	}
+
+	nmiHandler(nil)
+	if srcA.checks != 0 || srcB.checks != 0 {
+		t.Fatalf("expected NMI sources not to be checked in NMI context; got %d, %d", srcA.checks, srcB.checks)
+	}
+
+	var buf bytes.Buffer
+	kfmt.DrainIRQLog(&buf)
+	if !strings.Contains(buf.String(), "NMI received") {
+		t.Fatalf("expected the NMI to be logged via the IRQ log; got %q", buf.String())
+	}
+
+	for i := 0; i < 2; i++ {
+		Poll()
+		if srcA.checks != 1 || srcB.checks != 1 {
+			t.Fatalf("expected each NMI source to be checked once; got %d, %d", srcA.checks, srcB.checks)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	defer resetState()
+
+	var now uint64
+	nanosecondsFn = func() uint64 { return now }
+
+	// Poll is a no-op when no sources are registered
+	Poll()
+
+	now = 1
+	fast, slow := &fakeSource{}, &fakeSource{}
+	RegisterSource(fast, NotifyPolled, 10)
+	RegisterSource(slow, NotifyPolled, 1000)
+
+	specs := []struct {
+		elapsed   uint64
+		expChecks [2]int
+	}{
+		{9 * nsPerMillisecond, [2]int{0, 0}},
+		{1 * nsPerMillisecond, [2]int{1, 0}},
+		{10 * nsPerMillisecond, [2]int{2, 0}},
+		{980 * nsPerMillisecond, [2]int{3, 1}},
+	}
+
+	for specIndex, spec := range specs {
+		now += spec.elapsed
+		Poll()
+
+		if got := [2]int{fast.checks, slow.checks}; got != spec.expChecks {
+			t.Errorf("[spec %d] expected source checks %v; got %v", specIndex, spec.expChecks, got)
+		}
+	}
+
+	// Without a clock source, all sources are checked on each call
+	now = 0
+	Poll()
+	if fast.checks != 4 || slow.checks != 2 {
+		t.Fatalf("expected all sources to be checked; got %d, %d", fast.checks, slow.checks)
+	}
+}
+
+type fakeSource struct {
+	checks int
+}
+
+func (*fakeSource) ErrorSourceName() string { return "fake" }
+func (s *fakeSource) CheckErrors(io.Writer) bool {
+	s.checks++
+	return false
+}
+
+func resetState() {
+	handleInterruptFn = gate.HandleInterrupt
+	nanosecondsFn = clock.Nanoseconds
+	polledSources = nil
+	nmiSources = nil
+	nmiHandlerInstalled = false
+	nmiPending = 0
+}
@@ -37,6 +37,10 @@ var (
 	// frameAllocator points to a frame allocator function registered using
 	// SetFrameAllocator.
 	frameAllocator FrameAllocatorFn
+
+	// frameReleaser points to a frame release function registered using
+	// SetFrameReleaser.
+	frameReleaser FrameReleaserFn
 )
 
 // FrameAllocatorFn is a function that can allocate physical frames.
@@ -50,6 +54,17 @@ func SetFrameAllocator(allocFn FrameAllocatorFn) { frameAllocator = allocFn }
 // physical frame allocator.
 func AllocFrame() (Frame, *kernel.Error) { return frameAllocator() }
 
+// FrameReleaserFn is a function that can release physical frames.
+type FrameReleaserFn func(Frame) *kernel.Error
+
+// SetFrameReleaser registers a function that will be used by the vmm code to
+// return physical frames previously obtained via AllocFrame.
+func SetFrameReleaser(freeFn FrameReleaserFn) { frameReleaser = freeFn }
+
+// FreeFrame releases a physical frame using the currently active physical
+// frame releaser.
+func FreeFrame(frame Frame) *kernel.Error { return frameReleaser(frame) }
+
 // Page describes a virtual memory page index.
 type Page uintptr
 
@@ -53,7 +53,7 @@ func TestFrameAllocator(t *testing.T) {
 	SetFrameAllocator(customAlloc)
 
 	if _, err := AllocFrame(); err != nil {
-		t.Fatalf(err.Error())
+		t.Fatal(err)
 	}
 
 	if !allocCalled {
@@ -61,6 +61,25 @@ func TestFrameAllocator(t *testing.T) {
 	}
 }
 
+func TestFrameReleaser(t *testing.T) {
+	var freedFrame Frame
+	customFree := func(frame Frame) *kernel.Error {
+		freedFrame = frame
+		return nil
+	}
+
+	defer SetFrameReleaser(nil)
+	SetFrameReleaser(customFree)
+
+	if err := FreeFrame(Frame(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	if freedFrame != Frame(42) {
+		t.Fatal("expected custom releaser to be invoked after a call to FreeFrame")
+	}
+}
+
 func TestPageMethods(t *testing.T) {
 	for pageIndex := uint64(0); pageIndex < 128; pageIndex++ {
 		page := Page(pageIndex)
@@ -8,7 +8,7 @@ import (
 )
 
 var (
-	errBootAllocOutOfMemory = &kernel.Error{Module: "boot_mem_alloc", Message: "out of memory"}
+	errBootAllocOutOfMemory = &kernel.Error{Module: "boot_mem_alloc", Message: "out of memory", Code: kernel.ErrCodeOutOfMemory}
 )
 
 // BootMemAllocator implements a rudimentary physical memory allocator which is
@@ -91,6 +91,20 @@ func (alloc *BootMemAllocator) AllocFrame() (mm.Frame, *kernel.Error) {
 			alloc.lastAllocFrame++
 		}
 
+		// Boot modules (e.g. an initrd) and other reserved regions may
+		// be placed in available memory regions; skip over any frames
+		// that they occupy as well as the kernel frames that may follow
+		// them
+		for {
+			if alloc.lastAllocFrame >= alloc.kernelStartFrame && alloc.lastAllocFrame <= alloc.kernelEndFrame {
+				alloc.lastAllocFrame = alloc.kernelEndFrame + 1
+			} else if reservedEnd, reserved := reservedEndFrame(alloc.lastAllocFrame); reserved {
+				alloc.lastAllocFrame = reservedEnd + 1
+			} else {
+				break
+			}
+		}
+
 		// The above adjustment might push lastAllocFrame outside of the
 		// region end (e.g kernel ends at last page in the region)
 		if alloc.lastAllocFrame > regionEndFrame {
@@ -1,12 +1,15 @@
 package pmm
 
 import (
+	"gopheros/kernel/mm"
 	"gopheros/multiboot"
 	"testing"
 	"unsafe"
 )
 
 func TestBootMemoryAllocator(t *testing.T) {
+	defer resetReservedRegions()
+	resetReservedRegions()
 	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
 
 	specs := []struct {
@@ -90,9 +93,63 @@ func TestBootMemoryAllocator(t *testing.T) {
 	}
 }
 
+func TestBootMemoryAllocatorSkipsModules(t *testing.T) {
+	defer func() {
+		multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
+		resetReservedRegions()
+	}()
+
+	// Prepend a module tag occupying frames 1 and 2 to the memory map
+	infoData := append([]byte{
+		0, 0, 0, 0, // size
+		0, 0, 0, 0, // reserved
+		3, 0, 0, 0, // type
+		17, 0, 0, 0, // size
+		0, 16, 0, 0, // mod_start
+		0, 48, 0, 0, // mod_end
+		0,                   // cmdline
+		0, 0, 0, 0, 0, 0, 0, // padding
+	}, multibootMemoryMap[8:]...)
+	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&infoData[0])))
+
+	specs := []struct {
+		kernelStart, kernelEnd uintptr
+		expFrames              []mm.Frame
+	}{
+		// the kernel is loaded in a reserved memory region
+		{0xa0000, 0xa0000, []mm.Frame{0, 3, 4}},
+		// the kernel is loaded right after the module
+		{0x3000, 0x5000, []mm.Frame{0, 5, 6}},
+		// the kernel is loaded at the beginning of region 1
+		{0x0, 0x1000, []mm.Frame{3, 4, 5}},
+	}
+
+	var alloc BootMemAllocator
+	for specIndex, spec := range specs {
+		alloc.allocCount = 0
+		alloc.lastAllocFrame = 0
+		alloc.init(spec.kernelStart, spec.kernelEnd)
+		if err := registerBootReservations(spec.kernelStart, spec.kernelEnd); err != nil {
+			t.Fatal(err)
+		}
+
+		for frameIndex, expFrame := range spec.expFrames {
+			frame, err := alloc.AllocFrame()
+			if err != nil {
+				t.Errorf("[spec %d] [frame %d] unexpected allocator error: %v", specIndex, frameIndex, err)
+				break
+			}
+
+			if frame != expFrame {
+				t.Errorf("[spec %d] [frame %d] expected allocated frame to be %d; got %d", specIndex, frameIndex, expFrame, frame)
+			}
+		}
+	}
+}
+
 var (
 	// A dump of multiboot data when running under qemu containing only the
-	// memory region tag.  The dump encodes the following available memory
+	// memory region tag followed by an end tag.  The dump encodes the following available memory
 	// regions:
 	// [     0 -   9fc00] length:    654336
 	// [100000 - 7fe0000] length: 133038080
@@ -108,11 +165,6 @@ var (
 		0, 0, 254, 7, 0, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0,
 		2, 0, 0, 0, 0, 0, 0, 0, 0, 0, 252, 255, 0, 0, 0, 0,
 		0, 0, 4, 0, 0, 0, 0, 0, 2, 0, 0, 0, 0, 0, 0, 0,
-		9, 0, 0, 0, 212, 3, 0, 0, 24, 0, 0, 0, 40, 0, 0, 0,
-		21, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
-		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 27, 0, 0, 0,
-		1, 0, 0, 0, 2, 0, 0, 0, 0, 0, 16, 0, 0, 16, 0, 0,
-		24, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 8, 0, 0, 0,
 	}
 )
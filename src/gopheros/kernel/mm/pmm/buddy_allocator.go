@@ -0,0 +1,610 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/faultinj"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/sync"
+	"gopheros/multiboot"
+	"math"
+	"reflect"
+	"unsafe"
+)
+
+var (
+	errBuddyAllocOutOfMemory     = &kernel.Error{Module: "buddy_alloc", Message: "out of memory", Code: kernel.ErrCodeOutOfMemory}
+	errBuddyAllocFrameNotManaged = &kernel.Error{Module: "buddy_alloc", Message: "frame not managed by this allocator", Code: kernel.ErrCodeInvalidArgument}
+	errBuddyAllocDoubleFree      = &kernel.Error{Module: "buddy_alloc", Message: "frame is already free", Code: kernel.ErrCodeInvalidArgument}
+	errBuddyAllocInvalidOrder    = &kernel.Error{Module: "buddy_alloc", Message: "invalid allocation order", Code: kernel.ErrCodeInvalidArgument}
+	errBuddyAllocInvalidZone     = &kernel.Error{Module: "buddy_alloc", Message: "zone not managed by this allocator", Code: kernel.ErrCodeInvalidArgument}
+
+	// The followning functions are used by tests to mock calls to the vmm package
+	// and are automatically inlined by the compiler.
+	reserveRegionFn = vmm.EarlyReserveRegion
+	mapFn           = vmm.Map
+
+	// allocFrameFault allows tests to simulate frame allocation failures.
+	allocFrameFault = faultinj.NewSite("pmm/alloc-frame")
+
+	// retryAfterReclaimFn is used by tests to mock memory reclaim.
+	retryAfterReclaimFn = mm.RetryAfterReclaim
+)
+
+// MaxOrder is the largest supported allocation order. A block of order N
+// consists of 1 << N physically contiguous frames whose first frame number is
+// a multiple of 1 << N.
+const MaxOrder = 10
+
+const (
+	// listEnd terminates the free block lists.
+	listEnd = uint32(math.MaxUint32)
+
+	// orderNone is the order of frames that do not start a block.
+	orderNone = uint8(math.MaxUint8)
+)
+
+// frameInfo holds the allocator state for a frame. Only the entries for
+// frames that start a (free or allocated) block are meaningful; all other
+// entries have their order set to orderNone.
+type frameInfo struct {
+	// The pool-relative indices of the previous and next free blocks
+	// with the same order. Only valid for free blocks.
+	prev, next uint32
+
+	// The order of the block that starts at this frame.
+	order uint8
+
+	// Set if the block that starts at this frame is free.
+	free bool
+}
+
+type framePool struct {
+	// startFrame is the frame number for the first page in this pool.
+	// each frame info entry i corresponds to frame (startFrame + i).
+	startFrame mm.Frame
+
+	// endFrame tracks the last frame in the pool. The total number of
+	// frames is given by: (endFrame - startFrame) + 1
+	endFrame mm.Frame
+
+	// zone is the memory zone that contains all frames in this pool.
+	zone Zone
+
+	// node is the NUMA node that contains all frames in this pool.
+	node uint32
+
+	// freeCount tracks the available pages in this pool. The allocator
+	// can use this field to skip pools that cannot satisfy a request
+	// without the need to scan the free lists.
+	freeCount uint32
+
+	// freeLists contains the pool-relative index of the first free block
+	// of each order.
+	freeLists [MaxOrder + 1]uint32
+
+	// frames tracks the state of each frame in the pool.
+	frames    []frameInfo
+	framesHdr reflect.SliceHeader
+}
+
+// push adds the block that starts at the pool-relative frame index to the
+// free list for the specified order.
+func (pool *framePool) push(index uint32, order uint8) {
+	info := &pool.frames[index]
+	info.order, info.free = order, true
+	info.prev, info.next = listEnd, pool.freeLists[order]
+
+	if info.next != listEnd {
+		pool.frames[info.next].prev = index
+	}
+	pool.freeLists[order] = index
+}
+
+// pushRange adds the frames in [startFrame, endFrame] to the free lists by
+// splitting them into the largest possible naturally aligned blocks.
+func (pool *framePool) pushRange(startFrame, endFrame mm.Frame) {
+	for frame := startFrame; frame <= endFrame; {
+		order := uint8(MaxOrder)
+		for frame&(mm.Frame(1)<<order-1) != 0 || frame+mm.Frame(1)<<order-1 > endFrame {
+			order--
+		}
+
+		pool.push(uint32(frame-pool.startFrame), order)
+		frame += mm.Frame(1) << order
+	}
+}
+
+// remove unlinks the free block that starts at the pool-relative frame index
+// from its free list.
+func (pool *framePool) remove(index uint32) {
+	info := &pool.frames[index]
+	info.free = false
+
+	if info.prev != listEnd {
+		pool.frames[info.prev].next = info.next
+	} else {
+		pool.freeLists[info.order] = info.next
+	}
+
+	if info.next != listEnd {
+		pool.frames[info.next].prev = info.prev
+	}
+}
+
+// split breaks the detached block of the specified order that starts at
+// index into smaller blocks until a block of order targetOrder that contains
+// the pool-relative frame index target is obtained. The remaining blocks are
+// added to the free lists. split returns the index of the obtained block.
+func (pool *framePool) split(index uint32, order, targetOrder uint8, target uint32) uint32 {
+	for ; order > targetOrder; order-- {
+		half := uint32(1) << (order - 1)
+		if target >= index+half {
+			pool.push(index, order-1)
+			index += half
+		} else {
+			pool.push(index+half, order-1)
+		}
+	}
+
+	pool.frames[index].order = order
+	return index
+}
+
+// freeBlockContaining returns the pool-relative index of the free block that
+// contains the pool-relative frame index. If the frame is not part of a free
+// block, freeBlockContaining returns false.
+func (pool *framePool) freeBlockContaining(index uint32) (uint32, bool) {
+	frame := pool.startFrame + mm.Frame(index)
+	for order := uint8(0); order <= MaxOrder; order++ {
+		blockFrame := frame &^ (mm.Frame(1)<<order - 1)
+		if blockFrame < pool.startFrame {
+			break
+		}
+
+		blockIndex := uint32(blockFrame - pool.startFrame)
+		if info := &pool.frames[blockIndex]; info.free && info.order == order {
+			return blockIndex, true
+		}
+	}
+
+	return 0, false
+}
+
+// BuddyAllocator implements a physical frame allocator that manages the
+// available memory pools as blocks of 1 << order contiguous frames. Each pool
+// maintains a free list for each supported order. Allocations are satisfied
+// by splitting the smallest free block that is large enough and freed blocks
+// are coalesced with their free buddies.
+type BuddyAllocator struct {
+	mutex sync.Spinlock
+
+	// totalPages tracks the total number of pages across all pools.
+	totalPages uint32
+
+	// reservedPages tracks the number of reserved pages across all pools.
+	reservedPages uint32
+
+	pools    []framePool
+	poolsHdr reflect.SliceHeader
+
+	// allocatedBlocks tracks the number of blocks of each order that
+	// have been handed out by the allocator for each zone.
+	allocatedBlocks [ZoneNormal + 1][MaxOrder + 1]uint32
+
+	// localNodeFn returns the NUMA node of the CPU that invokes it. If
+	// set, allocations are served by the local node first.
+	localNodeFn func() uint32
+
+	// memoryBlocks tracks the memory ranges added at runtime via
+	// addMemory. A fixed-size array is used as the Go allocator cannot
+	// be invoked while holding the allocator lock.
+	memoryBlocks     [maxHotplugPools]memoryBlock
+	memoryBlockCount int
+}
+
+// init allocates space for the allocator structures using the early bootmem
+// allocator and flags any allocated pages as reserved.
+func (alloc *BuddyAllocator) init() *kernel.Error {
+	if err := alloc.setupPools(); err != nil {
+		return err
+	}
+
+	alloc.initFreeLists()
+	alloc.reserveRegisteredFrames()
+	alloc.reserveEarlyAllocatorFrames()
+	alloc.printStats()
+	return nil
+}
+
+// setupPools uses the early allocator and vmm region reservation helper to
+// initialize the list of available pools and their frame info slices.
+func (alloc *BuddyAllocator) setupPools() *kernel.Error {
+	var (
+		err            *kernel.Error
+		sizeofPool     = unsafe.Sizeof(framePool{})
+		sizeofInfo     = unsafe.Sizeof(frameInfo{})
+		pageSizeMinus1 = mm.PageSize - 1
+		frameCount     uintptr
+	)
+
+	// Detect available memory regions and calculate their frame info
+	// requirements.
+	visitPoolRanges(func(startFrame, endFrame mm.Frame) {
+		alloc.poolsHdr.Len++
+		alloc.poolsHdr.Cap++
+		frameCount += uintptr(endFrame - startFrame + 1)
+	})
+
+	// Reserve spare pool slots for splitting pools at NUMA node
+	// boundaries and for memory added at runtime.
+	alloc.poolsHdr.Cap += maxPoolSplits + maxHotplugPools
+
+	// Reserve enough pages to hold the allocator state
+	requiredBytes := (uintptr(alloc.poolsHdr.Cap)*sizeofPool + frameCount*sizeofInfo + pageSizeMinus1) & ^pageSizeMinus1
+	requiredPages := requiredBytes >> mm.PageShift
+	alloc.poolsHdr.Data, err = reserveRegionFn(requiredBytes)
+	if err != nil {
+		return err
+	}
+
+	for page, index := mm.PageFromAddress(alloc.poolsHdr.Data), uintptr(0); index < requiredPages; page, index = page+1, index+1 {
+		nextFrame, err := earlyAllocFrame()
+		if err != nil {
+			return err
+		}
+
+		if err = mapFn(page, nextFrame, vmm.FlagPresent|vmm.FlagRW|vmm.FlagNoExecute); err != nil {
+			return err
+		}
+
+		kernel.Memset(page.Address(), 0, mm.PageSize)
+	}
+
+	alloc.pools = *(*[]framePool)(unsafe.Pointer(&alloc.poolsHdr))
+
+	// Run a second pass to initialize the frame info slices for all pools
+	framesStartAddr := alloc.poolsHdr.Data + uintptr(alloc.poolsHdr.Cap)*sizeofPool
+	poolIndex := 0
+	visitPoolRanges(func(startFrame, endFrame mm.Frame) {
+		poolFrames := int(endFrame - startFrame + 1)
+
+		alloc.pools[poolIndex].startFrame = startFrame
+		alloc.pools[poolIndex].endFrame = endFrame
+		alloc.pools[poolIndex].zone = zoneForFrame(startFrame)
+		alloc.pools[poolIndex].framesHdr.Len = poolFrames
+		alloc.pools[poolIndex].framesHdr.Cap = poolFrames
+		alloc.pools[poolIndex].framesHdr.Data = framesStartAddr
+		alloc.pools[poolIndex].frames = *(*[]frameInfo)(unsafe.Pointer(&alloc.pools[poolIndex].framesHdr))
+
+		framesStartAddr += uintptr(poolFrames) * sizeofInfo
+		poolIndex++
+	})
+
+	return nil
+}
+
+// visitPoolRanges invokes visitor with the first and last frame of each
+// available memory region reported by the bootloader. Regions that cross a
+// zone boundary are split so that all frames in each range belong to the
+// same zone.
+func visitPoolRanges(visitor func(startFrame, endFrame mm.Frame)) {
+	pageSizeMinus1 := mm.PageSize - 1
+
+	multiboot.VisitMemRegions(func(region *multiboot.MemoryMapEntry) bool {
+		if region.Type != multiboot.MemAvailable {
+			return true
+		}
+
+		// Reported addresses may not be page-aligned; round up to get
+		// the start frame and round down to get the end frame
+		startFrame := mm.Frame(((uintptr(region.PhysAddress) + pageSizeMinus1) & ^pageSizeMinus1) >> mm.PageShift)
+		endFrame := mm.Frame((uintptr(region.PhysAddress+region.Length) & ^pageSizeMinus1)>>mm.PageShift) - 1
+
+		visitZoneRanges(startFrame, endFrame, visitor)
+		return true
+	})
+}
+
+// visitZoneRanges splits [startFrame, endFrame] at the zone boundaries and
+// invokes visitor with the first and last frame of each resulting range.
+func visitZoneRanges(startFrame, endFrame mm.Frame, visitor func(startFrame, endFrame mm.Frame)) {
+	for startFrame <= endFrame {
+		rangeEndFrame := endFrame
+		for _, boundary := range [...]mm.Frame{dmaZoneEndFrame, dma32ZoneEndFrame} {
+			if startFrame < boundary && endFrame >= boundary {
+				rangeEndFrame = boundary - 1
+				break
+			}
+		}
+
+		visitor(startFrame, rangeEndFrame)
+		startFrame = rangeEndFrame + 1
+	}
+}
+
+// initFreeLists marks all pool frames as free by splitting each pool into the
+// largest possible naturally aligned blocks.
+func (alloc *BuddyAllocator) initFreeLists() {
+	alloc.totalPages, alloc.reservedPages = 0, 0
+
+	for poolIndex := range alloc.pools {
+		pool := &alloc.pools[poolIndex]
+		pool.initFreeLists()
+		alloc.totalPages += pool.freeCount
+	}
+}
+
+// initFreeLists marks all frames in the pool as free.
+func (pool *framePool) initFreeLists() {
+	for order := range pool.freeLists {
+		pool.freeLists[order] = listEnd
+	}
+
+	for index := range pool.frames {
+		pool.frames[index] = frameInfo{order: orderNone}
+	}
+
+	pool.pushRange(pool.startFrame, pool.endFrame)
+	pool.freeCount = uint32(len(pool.frames))
+}
+
+// reserveFrame removes the supplied frame from the free block that contains
+// it. It returns false if the frame is not managed by the allocator or is
+// already reserved.
+func (alloc *BuddyAllocator) reserveFrame(poolIndex int, frame mm.Frame) bool {
+	if poolIndex < 0 || frame < alloc.pools[poolIndex].startFrame || frame > alloc.pools[poolIndex].endFrame {
+		return false
+	}
+
+	pool := &alloc.pools[poolIndex]
+	index := uint32(frame - pool.startFrame)
+	blockIndex, ok := pool.freeBlockContaining(index)
+	if !ok {
+		return false
+	}
+
+	pool.remove(blockIndex)
+	pool.split(blockIndex, pool.frames[blockIndex].order, 0, index)
+	pool.freeCount--
+	alloc.reservedPages++
+	return true
+}
+
+// poolForFrame returns the index of the pool that contains frame or -1 if
+// the frame is not contained in any of the available memory pools (e.g it
+// points to a reserved memory region).
+func (alloc *BuddyAllocator) poolForFrame(frame mm.Frame) int {
+	for poolIndex, pool := range alloc.pools {
+		if frame >= pool.startFrame && frame <= pool.endFrame {
+			return poolIndex
+		}
+	}
+
+	return -1
+}
+
+// reserveEarlyAllocatorFrames marks as reserved the frames already allocated
+// by the early allocator.
+func (alloc *BuddyAllocator) reserveEarlyAllocatorFrames() {
+	// We now need to decomission the early allocator by flagging all frames
+	// allocated by it as reserved. The allocator itself does not track
+	// individual frames but only a counter of allocated frames. To get
+	// the list of frames we reset its internal state and "replay" the
+	// allocation requests to get the correct frames.
+	allocCount := bootMemAllocator.allocCount
+	bootMemAllocator.allocCount, bootMemAllocator.lastAllocFrame = 0, 0
+	for i := uint64(0); i < allocCount; i++ {
+		frame, _ := bootMemAllocator.AllocFrame()
+		alloc.reserveFrame(alloc.poolForFrame(frame), frame)
+	}
+}
+
+func (alloc *BuddyAllocator) printStats() {
+	kfmt.Printf(
+		"[buddy_alloc] page stats: free: %d/%d (%d reserved)\n",
+		alloc.totalPages-alloc.reservedPages,
+		alloc.totalPages,
+		alloc.reservedPages,
+	)
+}
+
+// collectStats implements mm.StatsCollector. It appends the frame usage of
+// each zone managed by the allocator to stats.
+func (alloc *BuddyAllocator) collectStats(stats *mm.MemStats) {
+	alloc.mutex.Acquire()
+	defer alloc.mutex.Release()
+
+	for zone := ZoneDMA; zone <= ZoneNormal; zone++ {
+		zoneStats := mm.ZoneStats{
+			Name:            zone.String(),
+			AllocatedBlocks: make([]uint64, MaxOrder+1),
+		}
+
+		for poolIndex := range alloc.pools {
+			if pool := &alloc.pools[poolIndex]; pool.zone == zone {
+				zoneStats.TotalFrames += uint64(pool.endFrame-pool.startFrame) + 1
+				zoneStats.FreeFrames += uint64(pool.freeCount)
+			}
+		}
+
+		for order, count := range alloc.allocatedBlocks[zone] {
+			zoneStats.AllocatedBlocks[order] = uint64(count)
+		}
+
+		stats.Zones = append(stats.Zones, zoneStats)
+	}
+}
+
+// AllocFrame reserves and returns a physical memory frame. An error will be
+// returned if no more memory can be allocated.
+func (alloc *BuddyAllocator) AllocFrame() (mm.Frame, *kernel.Error) {
+	return alloc.AllocFrames(0)
+}
+
+// AllocFrames reserves a block of 1 << order physically contiguous frames
+// whose first frame number is a multiple of 1 << order and returns its first
+// frame. An error will be returned if order exceeds MaxOrder or no free block
+// of the requested size is available.
+func (alloc *BuddyAllocator) AllocFrames(order uint8) (mm.Frame, *kernel.Error) {
+	return alloc.AllocFramesInZone(ZoneNormal, order)
+}
+
+// AllocFramesInZone behaves like AllocFrames but only returns blocks from the
+// specified zone or the zones below it. Zones are searched starting from the
+// requested zone so that frames in the lower zones remain available to
+// devices that can only address them. If a local node function has been
+// registered, the memory of the NUMA node of the calling CPU is searched
+// before the memory of the remaining nodes.
+func (alloc *BuddyAllocator) AllocFramesInZone(zone Zone, order uint8) (mm.Frame, *kernel.Error) {
+	if alloc.localNodeFn != nil {
+		return alloc.allocFrames(zone, order, alloc.localNodeFn(), true)
+	}
+
+	return alloc.allocFrames(zone, order, 0, false)
+}
+
+// AllocFramesOnNode behaves like AllocFrames but searches the memory of the
+// specified NUMA node before the memory of the remaining nodes.
+func (alloc *BuddyAllocator) AllocFramesOnNode(node uint32, order uint8) (mm.Frame, *kernel.Error) {
+	return alloc.allocFrames(ZoneNormal, order, node, true)
+}
+
+// allocFrames reserves a block of 1 << order frames from the specified zone
+// or the zones below it. If preferNode is true, the pools that belong to node
+// are searched before any other pool. If no suitable block is available, the
+// memory reclaimers registered with the mm package are invoked and the
+// request is retried before an error is returned to the caller.
+func (alloc *BuddyAllocator) allocFrames(zone Zone, order uint8, node uint32, preferNode bool) (mm.Frame, *kernel.Error) {
+	if zone > ZoneNormal {
+		return mm.InvalidFrame, errBuddyAllocInvalidZone
+	}
+
+	if order > MaxOrder {
+		return mm.InvalidFrame, errBuddyAllocInvalidOrder
+	}
+
+	for pass := 0; ; pass++ {
+		frame, err := alloc.tryAllocFrames(zone, order, node, preferNode)
+		if !retryAfterReclaimFn(err, pass) {
+			return frame, err
+		}
+	}
+}
+
+// tryAllocFrames implements a single allocFrames attempt.
+func (alloc *BuddyAllocator) tryAllocFrames(zone Zone, order uint8, node uint32, preferNode bool) (mm.Frame, *kernel.Error) {
+	if allocFrameFault.ShouldFail() {
+		return mm.InvalidFrame, errBuddyAllocOutOfMemory
+	}
+
+	alloc.mutex.Acquire()
+
+	blockFrames := uint32(1) << order
+	for pass := 0; pass < 2; pass++ {
+		for curZone := int(zone); curZone >= int(ZoneDMA); curZone-- {
+			for poolIndex := 0; poolIndex < len(alloc.pools); poolIndex++ {
+				pool := &alloc.pools[poolIndex]
+				if pool.zone != Zone(curZone) || pool.freeCount < blockFrames {
+					continue
+				}
+
+				// The first pass only considers the pools of the
+				// preferred node; the second pass the remaining ones
+				if preferNode && (pool.node == node) != (pass == 0) {
+					continue
+				}
+
+				// Find the smallest free block that can satisfy the request
+				for blockOrder := order; blockOrder <= MaxOrder; blockOrder++ {
+					blockIndex := pool.freeLists[blockOrder]
+					if blockIndex == listEnd {
+						continue
+					}
+
+					pool.remove(blockIndex)
+					pool.split(blockIndex, blockOrder, order, blockIndex)
+					pool.freeCount -= blockFrames
+					alloc.reservedPages += blockFrames
+					alloc.allocatedBlocks[pool.zone][order]++
+					alloc.mutex.Release()
+					return pool.startFrame + mm.Frame(blockIndex), nil
+				}
+			}
+		}
+
+		if !preferNode {
+			break
+		}
+	}
+
+	alloc.mutex.Release()
+	return mm.InvalidFrame, errBuddyAllocOutOfMemory
+}
+
+// FreeFrame releases a frame previously allocated via a call to AllocFrame.
+// Trying to release a frame not part of the allocator pools or a frame that
+// is already marked as free will cause an error to be returned.
+func (alloc *BuddyAllocator) FreeFrame(frame mm.Frame) *kernel.Error {
+	return alloc.FreeFrames(frame, 0)
+}
+
+// FreeFrames releases a block of frames previously allocated via a call to
+// AllocFrames with the same order. The released block is coalesced with its
+// buddy if the latter is also free; this process is repeated until a block of
+// MaxOrder is formed or the buddy is not free.
+func (alloc *BuddyAllocator) FreeFrames(frame mm.Frame, order uint8) *kernel.Error {
+	alloc.mutex.Acquire()
+	defer alloc.mutex.Release()
+
+	poolIndex := alloc.poolForFrame(frame)
+	if poolIndex < 0 {
+		return errBuddyAllocFrameNotManaged
+	}
+
+	pool := &alloc.pools[poolIndex]
+	index := uint32(frame - pool.startFrame)
+	if _, free := pool.freeBlockContaining(index); free {
+		return errBuddyAllocDoubleFree
+	}
+
+	if order > MaxOrder || pool.frames[index].order != order {
+		return errBuddyAllocInvalidOrder
+	}
+
+	blockFrames := uint32(1) << order
+	pool.freeCount += blockFrames
+	alloc.reservedPages -= blockFrames
+
+	// Frames reserved while the allocator was initialized are not tracked
+	// as allocated blocks
+	if alloc.allocatedBlocks[pool.zone][order] != 0 {
+		alloc.allocatedBlocks[pool.zone][order]--
+	}
+
+	for ; order < MaxOrder; order++ {
+		buddyFrame := frame ^ mm.Frame(1)<<order
+		if buddyFrame < pool.startFrame || buddyFrame > pool.endFrame {
+			break
+		}
+
+		buddyIndex := uint32(buddyFrame - pool.startFrame)
+		if buddy := &pool.frames[buddyIndex]; !buddy.free || buddy.order != order {
+			break
+		}
+
+		// Merge the two blocks; the block with the lower address
+		// becomes the start of the merged block
+		pool.remove(buddyIndex)
+		if buddyIndex < index {
+			pool.frames[index].order = orderNone
+			index, frame = buddyIndex, buddyFrame
+		} else {
+			pool.frames[buddyIndex].order = orderNone
+		}
+	}
+
+	pool.push(index, order)
+	return nil
+}
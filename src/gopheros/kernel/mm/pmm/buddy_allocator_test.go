@@ -0,0 +1,700 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/faultinj"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/multiboot"
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+func TestSetupPools(t *testing.T) {
+	defer func() {
+		mapFn = vmm.Map
+		reserveRegionFn = vmm.EarlyReserveRegion
+	}()
+
+	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
+
+	// The captured multiboot data corresponds to qemu running with 128M RAM
+	// and reports 2 available regions: [0x0, 0x9fc00) and
+	// [0x100000, 0x7fe0000). As the second region crosses the 16M DMA zone
+	// boundary it will be split into two pools. The allocator will need to
+	// reserve enough pages to store the pool descriptors and the info for
+	// each frame.
+	var (
+		alloc        BuddyAllocator
+		expFrames    = uintptr(0x9f + 0x7ee0)
+		expBytes     = (3+maxPoolSplits+maxHotplugPools)*unsafe.Sizeof(framePool{}) + expFrames*unsafe.Sizeof(frameInfo{})
+		expPageCount = int((expBytes + mm.PageSize - 1) >> mm.PageShift)
+		physMem      = make([]byte, uintptr(expPageCount)*mm.PageSize)
+	)
+
+	// Init phys mem with junk
+	for i := 0; i < len(physMem); i++ {
+		physMem[i] = 0xf0
+	}
+
+	mapCallCount := 0
+	mapFn = func(page mm.Page, frame mm.Frame, flags vmm.PageTableEntryFlag) *kernel.Error {
+		mapCallCount++
+		return nil
+	}
+
+	reserveCallCount := 0
+	reserveRegionFn = func(_ uintptr) (uintptr, *kernel.Error) {
+		reserveCallCount++
+		return uintptr(unsafe.Pointer(&physMem[0])), nil
+	}
+
+	if err := alloc.setupPools(); err != nil {
+		t.Fatal(err)
+	}
+
+	if mapCallCount != expPageCount {
+		t.Fatalf("expected allocator to call vmm.Map %d times; called %d", expPageCount, mapCallCount)
+	}
+
+	if exp := 1; reserveCallCount != exp {
+		t.Fatalf("expected allocator to call vmm.EarlyReserveRegion %d times; called %d", exp, reserveCallCount)
+	}
+
+	if exp, got := 3, len(alloc.pools); got != exp {
+		t.Fatalf("expected allocator to initialize %d pools; got %d", exp, got)
+	}
+
+	expPools := [][2]mm.Frame{{0x0, 0x9e}, {0x100, 0xfff}, {0x1000, 0x7fdf}}
+	expZones := []Zone{ZoneDMA, ZoneDMA, ZoneDMA32}
+	for poolIndex, pool := range alloc.pools {
+		if pool.startFrame != expPools[poolIndex][0] || pool.endFrame != expPools[poolIndex][1] {
+			t.Errorf("[pool %d] expected pool to span frames [%d, %d]; got [%d, %d]", poolIndex, expPools[poolIndex][0], expPools[poolIndex][1], pool.startFrame, pool.endFrame)
+		}
+
+		if pool.zone != expZones[poolIndex] {
+			t.Errorf("[pool %d] expected pool zone to be %s; got %s", poolIndex, expZones[poolIndex], pool.zone)
+		}
+
+		if exp, got := int(pool.endFrame-pool.startFrame+1), len(pool.frames); got != exp {
+			t.Errorf("[pool %d] expected frame info len to be %d; got %d", poolIndex, exp, got)
+		}
+
+		for index, info := range pool.frames {
+			if info != (frameInfo{}) {
+				t.Errorf("[pool %d] expected frame info %d to be cleared; got %+v", poolIndex, index, info)
+				break
+			}
+		}
+	}
+}
+
+func TestSetupPoolsErrors(t *testing.T) {
+	defer func() {
+		mapFn = vmm.Map
+		reserveRegionFn = vmm.EarlyReserveRegion
+	}()
+
+	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
+	var alloc BuddyAllocator
+
+	t.Run("vmm.EarlyReserveRegion returns an error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "something went wrong"}
+
+		reserveRegionFn = func(_ uintptr) (uintptr, *kernel.Error) {
+			return 0, expErr
+		}
+
+		if err := alloc.setupPools(); err != expErr {
+			t.Fatalf("expected to get error: %v; got %v", expErr, err)
+		}
+	})
+	t.Run("vmm.Map returns an error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "something went wrong"}
+
+		reserveRegionFn = func(_ uintptr) (uintptr, *kernel.Error) {
+			return 0, nil
+		}
+
+		mapFn = func(page mm.Page, frame mm.Frame, flags vmm.PageTableEntryFlag) *kernel.Error {
+			return expErr
+		}
+
+		if err := alloc.setupPools(); err != expErr {
+			t.Fatalf("expected to get error: %v; got %v", expErr, err)
+		}
+	})
+
+	t.Run("bootMemAllocator returns an error", func(t *testing.T) {
+		emptyInfoData := []byte{
+			0, 0, 0, 0, // size
+			0, 0, 0, 0, // reserved
+			0, 0, 0, 0, // tag with type zero and length zero
+			0, 0, 0, 0,
+		}
+
+		multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&emptyInfoData[0])))
+
+		if err := alloc.setupPools(); err != errBootAllocOutOfMemory {
+			t.Fatalf("expected to get error: %v; got %v", errBootAllocOutOfMemory, err)
+		}
+	})
+}
+
+func TestBuddyAllocatorInitFreeLists(t *testing.T) {
+	// Frames 1-40 are split into blocks of order 0 (1), 1 (2-3), 2 (4-7),
+	// 3 (8-15), 4 (16-31), 3 (32-39) and 0 (40). Frames 1024-3071 form
+	// two blocks of MaxOrder.
+	alloc := newTestAllocator([2]mm.Frame{1, 40}, [2]mm.Frame{1024, 3071})
+
+	if exp, got := uint32(40+2048), alloc.totalPages; got != exp {
+		t.Fatalf("expected total pages to be %d; got %d", exp, got)
+	}
+
+	specs := []struct {
+		poolIndex int
+		expBlocks map[uint8][]mm.Frame
+	}{
+		{0, map[uint8][]mm.Frame{0: {40, 1}, 1: {2}, 2: {4}, 3: {32, 8}, 4: {16}}},
+		{1, map[uint8][]mm.Frame{MaxOrder: {2048, 1024}}},
+	}
+
+	for specIndex, spec := range specs {
+		for order := uint8(0); order <= MaxOrder; order++ {
+			if got := freeBlocks(alloc, spec.poolIndex, order); !framesEqual(got, spec.expBlocks[order]) {
+				t.Errorf("[spec %d] expected free blocks of order %d to be %v; got %v", specIndex, order, spec.expBlocks[order], got)
+			}
+		}
+	}
+}
+
+func TestBuddyAllocatorPoolForFrame(t *testing.T) {
+	alloc := newTestAllocator([2]mm.Frame{0, 63}, [2]mm.Frame{128, 191})
+
+	specs := []struct {
+		frame    mm.Frame
+		expIndex int
+	}{
+		{mm.Frame(0), 0},
+		{mm.Frame(63), 0},
+		{mm.Frame(64), -1},
+		{mm.Frame(128), 1},
+		{mm.Frame(192), -1},
+	}
+
+	for specIndex, spec := range specs {
+		if got := alloc.poolForFrame(spec.frame); got != spec.expIndex {
+			t.Errorf("[spec %d] expected to get pool index %d; got %d", specIndex, spec.expIndex, got)
+		}
+	}
+}
+
+func TestBuddyAllocatorReserveFrame(t *testing.T) {
+	alloc := newTestAllocator([2]mm.Frame{0, 15})
+
+	if !alloc.reserveFrame(0, 5) {
+		t.Fatal("expected frame 5 to be reserved")
+	}
+
+	// Reserving frame 5 splits the order 4 block into blocks
+	// 8-15 (3), 0-3 (2), 6-7 (1) and 4 (0)
+	expBlocks := map[uint8][]mm.Frame{0: {4}, 1: {6}, 2: {0}, 3: {8}}
+	for order := uint8(0); order <= MaxOrder; order++ {
+		if got := freeBlocks(alloc, 0, order); !framesEqual(got, expBlocks[order]) {
+			t.Errorf("expected free blocks of order %d to be %v; got %v", order, expBlocks[order], got)
+		}
+	}
+
+	specs := []struct {
+		poolIndex int
+		frame     mm.Frame
+	}{
+		{0, 5},
+		{-1, 5},
+		{0, 16},
+	}
+
+	for specIndex, spec := range specs {
+		if alloc.reserveFrame(spec.poolIndex, spec.frame) {
+			t.Errorf("[spec %d] expected reserveFrame to fail", specIndex)
+		}
+	}
+
+	if alloc.reservedPages != 1 || alloc.pools[0].freeCount != 15 {
+		t.Fatalf("expected 1 reserved and 15 free pages; got %d reserved and %d free", alloc.reservedPages, alloc.pools[0].freeCount)
+	}
+}
+
+func TestBuddyAllocatorReserveEarlyAllocatorFrames(t *testing.T) {
+	alloc := newTestAllocator([2]mm.Frame{0, 63}, [2]mm.Frame{64, 191})
+
+	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
+
+	// Simulate 16 allocations made using the early allocator in region 0
+	// as reported by the multiboot data and move the kernel to pool 1
+	allocCount := uint32(16)
+	bootMemAllocator.allocCount = uint64(allocCount)
+	bootMemAllocator.kernelStartFrame = mm.Frame(256)
+	bootMemAllocator.kernelEndFrame = mm.Frame(256)
+	alloc.reserveEarlyAllocatorFrames()
+
+	if exp, got := allocCount, alloc.reservedPages; got != exp {
+		t.Fatalf("expected reserved page counter to be %d; got %d", exp, got)
+	}
+
+	if exp, got := 64-allocCount, alloc.pools[0].freeCount; got != exp {
+		t.Fatalf("expected free count for pool 0 to be %d; got %d", exp, got)
+	}
+
+	if exp, got := uint32(128), alloc.pools[1].freeCount; got != exp {
+		t.Fatalf("expected free count for pool 1 to be %d; got %d", exp, got)
+	}
+
+	assertReserved(t, alloc, 0, 15)
+}
+
+func TestBuddyAllocatorAllocAndFreeFrame(t *testing.T) {
+	alloc := newTestAllocator([2]mm.Frame{0, 7}, [2]mm.Frame{128, 255})
+
+	// Test Alloc
+	for poolIndex, pool := range alloc.pools {
+		for expFrame := pool.startFrame; expFrame <= pool.endFrame; expFrame++ {
+			got, err := alloc.AllocFrame()
+			if err != nil {
+				t.Fatalf("[pool %d] unexpected error: %v", poolIndex, err)
+			}
+
+			if got != expFrame {
+				t.Errorf("[pool %d] expected allocated frame to be %d; got %d", poolIndex, expFrame, got)
+			}
+		}
+
+		if alloc.pools[poolIndex].freeCount != 0 {
+			t.Errorf("[pool %d] expected free count to be 0; got %d", poolIndex, alloc.pools[poolIndex].freeCount)
+		}
+	}
+
+	if alloc.reservedPages != alloc.totalPages {
+		t.Errorf("expected reservedPages to match totalPages(%d); got %d", alloc.totalPages, alloc.reservedPages)
+	}
+
+	if _, err := alloc.AllocFrame(); err != errBuddyAllocOutOfMemory {
+		t.Fatalf("expected error errBuddyAllocOutOfMemory; got %v", err)
+	}
+
+	// Test Free
+	expFreeCount := []uint32{8, 128}
+	for poolIndex, pool := range alloc.pools {
+		for frame := pool.startFrame; frame <= pool.endFrame; frame++ {
+			if err := alloc.FreeFrame(frame); err != nil {
+				t.Fatalf("[pool %d] unexpected error: %v", poolIndex, err)
+			}
+		}
+
+		if alloc.pools[poolIndex].freeCount != expFreeCount[poolIndex] {
+			t.Errorf("[pool %d] expected free count to be %d; got %d", poolIndex, expFreeCount[poolIndex], alloc.pools[poolIndex].freeCount)
+		}
+	}
+
+	if alloc.reservedPages != 0 {
+		t.Errorf("expected reservedPages to be 0; got %d", alloc.reservedPages)
+	}
+
+	// All freed frames should have been coalesced into the initial blocks
+	if got := freeBlocks(alloc, 0, 3); !framesEqual(got, []mm.Frame{0}) {
+		t.Errorf("expected pool 0 to contain a single free block of order 3; got %v", got)
+	}
+
+	if got := freeBlocks(alloc, 1, 7); !framesEqual(got, []mm.Frame{128}) {
+		t.Errorf("expected pool 1 to contain a single free block of order 7; got %v", got)
+	}
+
+	// Test Free errors
+	if err := alloc.FreeFrame(mm.Frame(0)); err != errBuddyAllocDoubleFree {
+		t.Fatalf("expected error errBuddyAllocDoubleFree; got %v", err)
+	}
+
+	if err := alloc.FreeFrame(mm.Frame(3)); err != errBuddyAllocDoubleFree {
+		t.Fatalf("expected error errBuddyAllocDoubleFree; got %v", err)
+	}
+
+	if err := alloc.FreeFrame(mm.Frame(0xbadf00d)); err != errBuddyAllocFrameNotManaged {
+		t.Fatalf("expected error errBuddyAllocFrameNotManaged; got %v", err)
+	}
+}
+
+func TestBuddyAllocatorAllocAndFreeFrames(t *testing.T) {
+	alloc := newTestAllocator([2]mm.Frame{1, 40}, [2]mm.Frame{1024, 3071})
+
+	specs := []struct {
+		order    uint8
+		expFrame mm.Frame
+		expErr   *kernel.Error
+	}{
+		// Satisfied by the order 0 block at frame 40
+		{0, 40, nil},
+		// Satisfied by the order 1 block at frame 2
+		{1, 2, nil},
+		{2, 4, nil},
+		// Satisfied by splitting the order 3 block at frame 32
+		{2, 32, nil},
+		{3, 8, nil},
+		// Satisfied by splitting the order 4 block at frame 16
+		{3, 16, nil},
+		{3, 24, nil},
+		// Served by pool 1 as pool 0 has no order 3 blocks left
+		{3, 2048, nil},
+		{MaxOrder, 1024, nil},
+		{MaxOrder, mm.InvalidFrame, errBuddyAllocOutOfMemory},
+		{MaxOrder + 1, mm.InvalidFrame, errBuddyAllocInvalidOrder},
+	}
+
+	for specIndex, spec := range specs {
+		frame, err := alloc.AllocFrames(spec.order)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if frame != spec.expFrame {
+			t.Errorf("[spec %d] expected to get frame %d; got %d", specIndex, spec.expFrame, frame)
+		}
+	}
+
+	if exp, got := uint32(1+2+4+4+8+8+8+8+1024), alloc.reservedPages; got != exp {
+		t.Fatalf("expected reserved page counter to be %d; got %d", exp, got)
+	}
+
+	freeSpecs := []struct {
+		frame  mm.Frame
+		order  uint8
+		expErr *kernel.Error
+	}{
+		// Order does not match the allocation
+		{4, 1, errBuddyAllocInvalidOrder},
+		// Frame does not start an allocated block
+		{5, 0, errBuddyAllocInvalidOrder},
+		{4, MaxOrder + 1, errBuddyAllocInvalidOrder},
+		// Frame belongs to a free block
+		{37, 0, errBuddyAllocDoubleFree},
+		{40, 0, nil},
+		{2, 1, nil},
+		{4, 2, nil},
+		{32, 2, nil},
+		{8, 3, nil},
+		{16, 3, nil},
+		{24, 3, nil},
+		{2048, 3, nil},
+		{1024, MaxOrder, nil},
+	}
+
+	for specIndex, spec := range freeSpecs {
+		if err := alloc.FreeFrames(spec.frame, spec.order); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if alloc.reservedPages != 0 {
+		t.Fatalf("expected all frames to be released; got %d reserved", alloc.reservedPages)
+	}
+
+	// The free lists should be restored to their initial state
+	expBlocks := map[uint8][]mm.Frame{0: {1, 40}, 1: {2}, 2: {4}, 3: {8, 32}, 4: {16}}
+	for order := uint8(0); order < MaxOrder; order++ {
+		if got := freeBlocks(alloc, 0, order); !framesEqual(sortFrames(got), expBlocks[order]) {
+			t.Errorf("expected free blocks of order %d to be %v; got %v", order, expBlocks[order], got)
+		}
+	}
+
+	if got := freeBlocks(alloc, 1, MaxOrder); !framesEqual(sortFrames(got), []mm.Frame{1024, 2048}) {
+		t.Errorf("expected pool 1 to contain 2 blocks of MaxOrder; got %v", got)
+	}
+}
+
+func TestBuddyAllocatorAllocFramesInZone(t *testing.T) {
+	alloc := newTestAllocator(
+		[2]mm.Frame{0x100, 0x4ff},
+		[2]mm.Frame{dma32ZoneEndFrame - 0x400, dma32ZoneEndFrame - 1},
+		[2]mm.Frame{0x1000, 0x13ff},
+		[2]mm.Frame{dma32ZoneEndFrame, dma32ZoneEndFrame + 0x3ff},
+	)
+
+	specs := []struct {
+		zone     Zone
+		order    uint8
+		expZone  Zone
+		expFrame mm.Frame
+		expErr   *kernel.Error
+	}{
+		// Normal allocations are served from the highest zone first
+		{ZoneNormal, MaxOrder, ZoneNormal, dma32ZoneEndFrame, nil},
+		{ZoneNormal, MaxOrder, ZoneDMA32, dma32ZoneEndFrame - 0x400, nil},
+		{ZoneDMA32, MaxOrder, ZoneDMA32, 0x1000, nil},
+		// DMA32 allocations fall back to the DMA zone
+		{ZoneDMA32, 0, ZoneDMA, 0x400, nil},
+		{ZoneDMA, 8, ZoneDMA, 0x100, nil},
+		{ZoneDMA, MaxOrder, 0, mm.InvalidFrame, errBuddyAllocOutOfMemory},
+		{ZonePersistent, 0, 0, mm.InvalidFrame, errBuddyAllocInvalidZone},
+		{ZoneDMA, MaxOrder + 1, 0, mm.InvalidFrame, errBuddyAllocInvalidOrder},
+	}
+
+	for specIndex, spec := range specs {
+		frame, err := alloc.AllocFramesInZone(spec.zone, spec.order)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if frame != spec.expFrame {
+			t.Errorf("[spec %d] expected to get frame 0x%x; got 0x%x", specIndex, spec.expFrame, frame)
+		}
+
+		if err == nil && zoneForFrame(frame) != spec.expZone {
+			t.Errorf("[spec %d] expected frame to belong to zone %s; got %s", specIndex, spec.expZone, zoneForFrame(frame))
+		}
+	}
+}
+
+func TestAllocatorPackageInit(t *testing.T) {
+	defer func() {
+		mapFn = vmm.Map
+		reserveRegionFn = vmm.EarlyReserveRegion
+		buddyAllocator = BuddyAllocator{}
+		resetReservedRegions()
+	}()
+
+	var (
+		physMem = make([]byte, 128*mm.PageSize)
+	)
+	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
+
+	t.Run("success", func(t *testing.T) {
+		mapFn = func(page mm.Page, frame mm.Frame, flags vmm.PageTableEntryFlag) *kernel.Error {
+			return nil
+		}
+
+		reserveRegionFn = func(_ uintptr) (uintptr, *kernel.Error) {
+			return uintptr(unsafe.Pointer(&physMem[0])), nil
+		}
+
+		if err := Init(0x100000, 0x1fa7c8); err != nil {
+			t.Fatal(err)
+		}
+
+		// At this point the buddy allocator should be up and running
+		allocFrame, err := mm.AllocFrame()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = mm.FreeFrame(allocFrame); err != nil {
+			t.Fatal(err)
+		}
+
+		frame, err := AllocFrames(MaxOrder)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err = FreeFrames(frame, MaxOrder); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		buddyAllocator = BuddyAllocator{}
+		expErr := &kernel.Error{Module: "test", Message: "something went wrong"}
+
+		mapFn = func(page mm.Page, frame mm.Frame, flags vmm.PageTableEntryFlag) *kernel.Error {
+			return expErr
+		}
+
+		if err := Init(0x100000, 0x1fa7c8); err != expErr {
+			t.Fatalf("expected to get error: %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestBuddyAllocatorAllocFrameFaultInjection(t *testing.T) {
+	defer func() {
+		_ = faultinj.Configure(allocFrameFault.Name(), faultinj.ModeOff, 0)
+	}()
+
+	alloc := newTestAllocator([2]mm.Frame{0, 63})
+
+	if err := faultinj.Configure(allocFrameFault.Name(), faultinj.ModeEveryNth, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, expErr := range []*kernel.Error{nil, errBuddyAllocOutOfMemory, nil, errBuddyAllocOutOfMemory} {
+		if _, err := alloc.AllocFrame(); err != expErr {
+			t.Errorf("[call %d] expected error %v; got %v", i, expErr, err)
+		}
+	}
+
+	if alloc.reservedPages != 2 {
+		t.Fatalf("expected injected failures not to reserve any frames; got %d reserved", alloc.reservedPages)
+	}
+}
+
+func TestBuddyAllocatorReclaimOnOOM(t *testing.T) {
+	defer func() { retryAfterReclaimFn = mm.RetryAfterReclaim }()
+
+	alloc := newTestAllocator([2]mm.Frame{0, 1})
+	for i := 0; i < 2; i++ {
+		if _, err := alloc.AllocFrame(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The reclaimer releases a frame on the second pass of the first
+	// allocation
+	var (
+		passes    []int
+		reclaimed bool
+	)
+	retryAfterReclaimFn = func(err *kernel.Error, pass int) bool {
+		if err == nil {
+			return false
+		}
+
+		passes = append(passes, pass)
+		if pass == 1 && !reclaimed {
+			_ = alloc.FreeFrame(1)
+			reclaimed = true
+		}
+		return pass < 2
+	}
+
+	if frame, err := alloc.AllocFrame(); err != nil || frame != 1 {
+		t.Fatalf("expected to allocate the reclaimed frame; got %d, %v", frame, err)
+	}
+
+	if len(passes) != 2 {
+		t.Fatalf("expected allocation to be retried twice; got passes %v", passes)
+	}
+
+	// Once the reclaimers give up, the error is returned to the caller
+	passes = nil
+	if _, err := alloc.AllocFrame(); err != errBuddyAllocOutOfMemory {
+		t.Fatalf("expected error errBuddyAllocOutOfMemory; got %v", err)
+	}
+
+	if len(passes) != 3 {
+		t.Fatalf("expected 3 allocation attempts; got passes %v", passes)
+	}
+}
+
+// newTestAllocator returns a buddy allocator that manages the supplied
+// (inclusive) frame ranges.
+func newTestAllocator(ranges ...[2]mm.Frame) *BuddyAllocator {
+	alloc := &BuddyAllocator{}
+	for _, r := range ranges {
+		alloc.pools = append(alloc.pools, framePool{
+			startFrame: r[0],
+			endFrame:   r[1],
+			zone:       zoneForFrame(r[0]),
+			frames:     make([]frameInfo, r[1]-r[0]+1),
+		})
+	}
+
+	alloc.initFreeLists()
+	return alloc
+}
+
+// freeBlocks returns the first frame of each block in the free list for the
+// specified pool and order.
+func freeBlocks(alloc *BuddyAllocator, poolIndex int, order uint8) []mm.Frame {
+	var (
+		pool   = &alloc.pools[poolIndex]
+		frames []mm.Frame
+	)
+
+	for index := pool.freeLists[order]; index != listEnd; index = pool.frames[index].next {
+		frames = append(frames, pool.startFrame+mm.Frame(index))
+	}
+
+	return frames
+}
+
+// assertReserved checks that none of the frames in [first, last] belongs to a
+// free block.
+func assertReserved(t *testing.T, alloc *BuddyAllocator, first, last mm.Frame) {
+	for frame := first; frame <= last; frame++ {
+		pool := &alloc.pools[alloc.poolForFrame(frame)]
+		if _, free := pool.freeBlockContaining(uint32(frame - pool.startFrame)); free {
+			t.Errorf("expected frame %d to be reserved", frame)
+		}
+	}
+}
+
+func framesEqual(a, b []mm.Frame) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func sortFrames(frames []mm.Frame) []mm.Frame {
+	for i := 1; i < len(frames); i++ {
+		for j := i; j > 0 && frames[j] < frames[j-1]; j-- {
+			frames[j], frames[j-1] = frames[j-1], frames[j]
+		}
+	}
+
+	return frames
+}
+
+func TestBuddyAllocatorCollectStats(t *testing.T) {
+	alloc := newTestAllocator(
+		[2]mm.Frame{0x100, 0x4ff},
+		[2]mm.Frame{dma32ZoneEndFrame, dma32ZoneEndFrame + 0x3ff},
+	)
+
+	var frames []mm.Frame
+	for _, order := range []uint8{0, 0, 2} {
+		frame, err := alloc.AllocFrames(order)
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, frame)
+	}
+
+	if _, err := alloc.AllocFramesInZone(ZoneDMA, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := alloc.FreeFrames(frames[1], 0); err != nil {
+		t.Fatal(err)
+	}
+
+	var stats mm.MemStats
+	alloc.collectStats(&stats)
+
+	expBlocks := [][]uint64{
+		make([]uint64, MaxOrder+1),
+		make([]uint64, MaxOrder+1),
+		make([]uint64, MaxOrder+1),
+	}
+	expBlocks[ZoneDMA][1] = 1
+	expBlocks[ZoneNormal][0] = 1
+	expBlocks[ZoneNormal][2] = 1
+
+	exp := []mm.ZoneStats{
+		{Name: "dma", TotalFrames: 0x400, FreeFrames: 0x400 - 2, AllocatedBlocks: expBlocks[ZoneDMA]},
+		{Name: "dma32", AllocatedBlocks: expBlocks[ZoneDMA32]},
+		{Name: "normal", TotalFrames: 0x400, FreeFrames: 0x400 - 5, AllocatedBlocks: expBlocks[ZoneNormal]},
+	}
+
+	if !reflect.DeepEqual(stats.Zones, exp) {
+		t.Fatalf("expected zone stats to be %+v; got %+v", exp, stats.Zones)
+	}
+}
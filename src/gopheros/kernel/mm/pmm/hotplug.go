@@ -0,0 +1,182 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"unsafe"
+)
+
+// maxHotplugPools defines the number of spare pool slots reserved by the
+// frame allocator for memory added at runtime.
+const maxHotplugPools = 16
+
+var (
+	errHotplugRangeTooSmall = &kernel.Error{Module: "pmm", Message: "hot-added memory range is too small", Code: kernel.ErrCodeInvalidArgument}
+	errHotplugOverlap       = &kernel.Error{Module: "pmm", Message: "hot-added memory overlaps memory managed by the frame allocator", Code: kernel.ErrCodeAlreadyExists}
+	errHotplugTooManyPools  = &kernel.Error{Module: "pmm", Message: "no spare pool slots left for hot-added memory", Code: kernel.ErrCodeOutOfMemory}
+	errHotplugNotFound      = &kernel.Error{Module: "pmm", Message: "memory range was not added at runtime", Code: kernel.ErrCodeNotFound}
+	errHotplugInUse         = &kernel.Error{Module: "pmm", Message: "hot-added memory contains allocated frames", Code: kernel.ErrCodeBusy}
+
+	// The following functions are used by tests to mock calls to the vmm
+	// package.
+	mapFramesFn  = vmm.MapFrames
+	freeRegionFn = vmm.FreeRegion
+)
+
+// memoryBlock describes a physical memory range added at runtime.
+type memoryBlock struct {
+	// The first and last frame of the range.
+	startFrame, endFrame mm.Frame
+
+	// metaPage is the first page of the mapping for the frame info of
+	// the pools that manage the range. The frame info is stored in the
+	// first frames of the range.
+	metaPage mm.Page
+}
+
+// AddMemory hands the physical memory range [base, base+length) to the frame
+// allocator (e.g. after a memory device is hot-plugged). The range is split
+// into pools at the zone boundaries and the state of its frames is stored in
+// the first frames of the range which are therefore never allocated. Frames
+// added by AddMemory belong to NUMA node 0 until they are assigned to a
+// different node via AssignNode.
+func AddMemory(base, length uint64) *kernel.Error {
+	return buddyAllocator.addMemory(base, length)
+}
+
+// RemoveMemory removes a physical memory range previously added via a call to
+// AddMemory with the same arguments from the frame allocator (e.g. before a
+// memory device is ejected). If any of the frames in the range is allocated,
+// the range is not removed and an error is returned.
+func RemoveMemory(base, length uint64) *kernel.Error {
+	return buddyAllocator.removeMemory(base, length)
+}
+
+// addMemory implements AddMemory.
+func (alloc *BuddyAllocator) addMemory(base, length uint64) *kernel.Error {
+	// Only frames that are fully contained in the range are added
+	startFrame := mm.Frame((base + uint64(mm.PageSize-1)) >> mm.PageShift)
+	endFrame := mm.Frame((base+length)>>mm.PageShift) - 1
+	if length == 0 || endFrame < startFrame {
+		return errHotplugRangeTooSmall
+	}
+
+	frameCount := uintptr(endFrame - startFrame + 1)
+	metaBytes := frameCount * unsafe.Sizeof(frameInfo{})
+	metaFrames := (metaBytes + mm.PageSize - 1) >> mm.PageShift
+	if metaFrames >= frameCount {
+		return errHotplugRangeTooSmall
+	}
+
+	// Mapping the range does not modify its contents so this is safe to
+	// do before checking whether the range overlaps managed memory. This
+	// cannot be done while holding the allocator lock as the vmm may need
+	// to allocate frames for the page tables.
+	metaPage, err := mapFramesFn(startFrame, metaBytes, vmm.FlagRW|vmm.FlagNoExecute)
+	if err != nil {
+		return err
+	}
+
+	alloc.mutex.Acquire()
+	err = alloc.insertMemoryBlock(memoryBlock{startFrame: startFrame, endFrame: endFrame, metaPage: metaPage}, mm.Frame(metaFrames))
+	alloc.mutex.Release()
+
+	if err != nil {
+		_ = freeRegionFn(metaPage)
+	}
+	return err
+}
+
+// insertMemoryBlock creates the pools for the frames in block that follow its
+// first metaFrames frames. insertMemoryBlock must be invoked while holding the
+// allocator lock.
+func (alloc *BuddyAllocator) insertMemoryBlock(block memoryBlock, metaFrames mm.Frame) *kernel.Error {
+	for poolIndex := range alloc.pools {
+		if pool := &alloc.pools[poolIndex]; pool.startFrame <= block.endFrame && block.startFrame <= pool.endFrame {
+			return errHotplugOverlap
+		}
+	}
+
+	poolCount := 0
+	visitZoneRanges(block.startFrame+metaFrames, block.endFrame, func(_, _ mm.Frame) {
+		poolCount++
+	})
+
+	if len(alloc.pools)+poolCount > cap(alloc.pools) || alloc.memoryBlockCount == len(alloc.memoryBlocks) {
+		return errHotplugTooManyPools
+	}
+
+	framesAddr := block.metaPage.Address()
+	visitZoneRanges(block.startFrame+metaFrames, block.endFrame, func(startFrame, endFrame mm.Frame) {
+		poolFrames := int(endFrame - startFrame + 1)
+
+		alloc.pools = alloc.pools[:len(alloc.pools)+1]
+		pool := &alloc.pools[len(alloc.pools)-1]
+		*pool = framePool{
+			startFrame: startFrame,
+			endFrame:   endFrame,
+			zone:       zoneForFrame(startFrame),
+		}
+		pool.framesHdr.Len = poolFrames
+		pool.framesHdr.Cap = poolFrames
+		pool.framesHdr.Data = framesAddr
+		pool.frames = *(*[]frameInfo)(unsafe.Pointer(&pool.framesHdr))
+		pool.initFreeLists()
+		alloc.totalPages += pool.freeCount
+
+		framesAddr += uintptr(poolFrames) * unsafe.Sizeof(frameInfo{})
+	})
+
+	alloc.memoryBlocks[alloc.memoryBlockCount] = block
+	alloc.memoryBlockCount++
+	return nil
+}
+
+// removeMemory implements RemoveMemory.
+func (alloc *BuddyAllocator) removeMemory(base, length uint64) *kernel.Error {
+	startFrame := mm.Frame((base + uint64(mm.PageSize-1)) >> mm.PageShift)
+	endFrame := mm.Frame((base+length)>>mm.PageShift) - 1
+
+	alloc.mutex.Acquire()
+
+	blockIndex := -1
+	for index := 0; index < alloc.memoryBlockCount; index++ {
+		if block := &alloc.memoryBlocks[index]; block.startFrame == startFrame && block.endFrame == endFrame {
+			blockIndex = index
+			break
+		}
+	}
+
+	if blockIndex == -1 {
+		alloc.mutex.Release()
+		return errHotplugNotFound
+	}
+
+	block := alloc.memoryBlocks[blockIndex]
+	for poolIndex := range alloc.pools {
+		pool := &alloc.pools[poolIndex]
+		if pool.startFrame >= block.startFrame && pool.endFrame <= block.endFrame && pool.freeCount != uint32(len(pool.frames)) {
+			alloc.mutex.Release()
+			return errHotplugInUse
+		}
+	}
+
+	// Drop the pools of the block; pools may have been split at NUMA node
+	// boundaries so more than one pool may need to be removed per zone.
+	keptPools := alloc.pools[:0]
+	for _, pool := range alloc.pools {
+		if pool.startFrame >= block.startFrame && pool.endFrame <= block.endFrame {
+			alloc.totalPages -= uint32(len(pool.frames))
+			continue
+		}
+		keptPools = append(keptPools, pool)
+	}
+	alloc.pools = keptPools
+
+	copy(alloc.memoryBlocks[blockIndex:], alloc.memoryBlocks[blockIndex+1:alloc.memoryBlockCount])
+	alloc.memoryBlockCount--
+	alloc.mutex.Release()
+
+	return freeRegionFn(block.metaPage)
+}
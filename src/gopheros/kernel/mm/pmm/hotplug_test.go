@@ -0,0 +1,195 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"testing"
+	"unsafe"
+)
+
+func TestAddMemory(t *testing.T) {
+	defer func() {
+		mapFramesFn = vmm.MapFrames
+		freeRegionFn = vmm.FreeRegion
+	}()
+
+	type expPool struct {
+		start, end mm.Frame
+		zone       Zone
+	}
+
+	var (
+		// Large enough to hold the frame info for 0x2000 frames
+		metaBuf  = make([]byte, 0x2000*unsafe.Sizeof(frameInfo{})+mm.PageSize)
+		metaPage = mm.PageFromAddress((uintptr(unsafe.Pointer(&metaBuf[0])) + mm.PageSize - 1) &^ (mm.PageSize - 1))
+		mapErr   = &kernel.Error{Module: "test", Message: "map failed"}
+	)
+
+	specs := []struct {
+		base, length uint64
+		spareSlots   int
+		mapErr       *kernel.Error
+		expErr       *kernel.Error
+		expPools     []expPool
+	}{
+		{0x2000000, 0, 1, nil, errHotplugRangeTooSmall, nil},
+		// A single frame cannot hold its own frame info and remain usable
+		{0x2000000, 0x1000, 1, nil, errHotplugRangeTooSmall, nil},
+		{0x2000000, 0x1000000, 1, mapErr, mapErr, nil},
+		{0x7ff000, 0x1000000, 1, nil, errHotplugOverlap, nil},
+		{0x2000000, 0x1000000, 0, nil, errHotplugTooManyPools, nil},
+		// The first 0xc frames of the range hold the frame info
+		{0x2000000, 0x1000000, 1, nil, nil, []expPool{{0x200c, 0x2fff, ZoneDMA32}}},
+		// Partial frames are ignored and ranges are split at zone boundaries
+		{0xff0000 - 1, 0x1010000, 2, nil, nil, []expPool{{0xffd, 0xfff, ZoneDMA}, {0x1000, 0x1ffe, ZoneDMA32}}},
+	}
+
+	for specIndex, spec := range specs {
+		alloc := newTestAllocator([2]mm.Frame{0, 0x7ff})
+		alloc.pools = append(make([]framePool, 0, len(alloc.pools)+spec.spareSlots), alloc.pools...)
+
+		var mappedFrame mm.Frame
+		mapFramesFn = func(frame mm.Frame, size uintptr, flags vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+			if flags&vmm.FlagRW == 0 {
+				t.Errorf("[spec %d] expected frame info to be mapped RW", specIndex)
+			}
+			mappedFrame = frame
+			return metaPage, spec.mapErr
+		}
+
+		freedPage := mm.Page(0)
+		freeRegionFn = func(page mm.Page) *kernel.Error {
+			freedPage = page
+			return nil
+		}
+
+		if err := alloc.addMemory(spec.base, spec.length); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if spec.expErr != nil {
+			if spec.mapErr == nil && mappedFrame != 0 && freedPage != metaPage {
+				t.Errorf("[spec %d] expected the frame info mapping to be released", specIndex)
+			}
+			if len(alloc.pools) != 1 || alloc.memoryBlockCount != 0 {
+				t.Errorf("[spec %d] expected allocator state to remain unchanged", specIndex)
+			}
+			continue
+		}
+
+		if exp := mm.Frame((spec.base + uint64(mm.PageSize-1)) >> mm.PageShift); mappedFrame != exp {
+			t.Errorf("[spec %d] expected frame info to be stored at frame 0x%x; got 0x%x", specIndex, exp, mappedFrame)
+		}
+
+		if got := alloc.pools[1:]; len(got) != len(spec.expPools) {
+			t.Errorf("[spec %d] expected %d new pools; got %d", specIndex, len(spec.expPools), len(got))
+			continue
+		}
+
+		expTotal := uint32(0x800)
+		for poolIndex, exp := range spec.expPools {
+			pool := &alloc.pools[poolIndex+1]
+			if pool.startFrame != exp.start || pool.endFrame != exp.end || pool.zone != exp.zone {
+				t.Errorf("[spec %d] expected pool %d to be [0x%x, 0x%x] in zone %s; got [0x%x, 0x%x] in zone %s", specIndex, poolIndex, exp.start, exp.end, exp.zone, pool.startFrame, pool.endFrame, pool.zone)
+			}
+
+			if exp := uint32(exp.end - exp.start + 1); pool.freeCount != exp {
+				t.Errorf("[spec %d] expected pool %d to have %d free frames; got %d", specIndex, poolIndex, exp, pool.freeCount)
+			}
+			expTotal += pool.freeCount
+		}
+
+		if alloc.totalPages != expTotal {
+			t.Errorf("[spec %d] expected total pages to be %d; got %d", specIndex, expTotal, alloc.totalPages)
+		}
+
+		// Frames from the hot-added pools should be allocatable from the
+		// requested zone
+		frame, err := alloc.AllocFramesInZone(spec.expPools[len(spec.expPools)-1].zone, 0)
+		if err != nil || frame < spec.expPools[0].start {
+			t.Errorf("[spec %d] expected to allocate a hot-added frame; got 0x%x, %v", specIndex, frame, err)
+		}
+	}
+}
+
+func TestRemoveMemory(t *testing.T) {
+	defer func() {
+		mapFramesFn = vmm.MapFrames
+		freeRegionFn = vmm.FreeRegion
+	}()
+
+	var (
+		metaBuf  = make([]byte, 0x1000*unsafe.Sizeof(frameInfo{})+mm.PageSize)
+		metaPage = mm.PageFromAddress((uintptr(unsafe.Pointer(&metaBuf[0])) + mm.PageSize - 1) &^ (mm.PageSize - 1))
+		freed    []mm.Page
+	)
+
+	mapFramesFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return metaPage, nil
+	}
+	freeRegionFn = func(page mm.Page) *kernel.Error {
+		freed = append(freed, page)
+		return nil
+	}
+
+	alloc := newTestAllocator([2]mm.Frame{0, 0x7ff})
+	alloc.pools = append(make([]framePool, 0, 3), alloc.pools...)
+	if err := alloc.addMemory(0x2000000, 0x1000000); err != nil {
+		t.Fatal(err)
+	}
+
+	// Split the hot-added pool so that the removal must drop both halves
+	if err := alloc.assignNode(1, 0x2800000, 0x800000); err != nil {
+		t.Fatal(err)
+	}
+
+	frame, err := alloc.AllocFramesOnNode(1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		base, length uint64
+		expErr       *kernel.Error
+	}{
+		{0x2000000, 0x800000, errHotplugNotFound},
+		{0x2000000, 0x1000000, errHotplugInUse},
+	}
+
+	for specIndex, spec := range specs {
+		if err := alloc.removeMemory(spec.base, spec.length); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if len(alloc.pools) != 3 || len(freed) != 0 {
+		t.Fatalf("expected failed removals to leave the allocator state unchanged")
+	}
+
+	if err = alloc.FreeFrame(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = alloc.removeMemory(0x2000000, 0x1000000); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(alloc.pools) != 1 || alloc.pools[0].endFrame != 0x7ff {
+		t.Errorf("expected only the boot pool to remain; got %d pools", len(alloc.pools))
+	}
+
+	if alloc.totalPages != 0x800 || alloc.memoryBlockCount != 0 {
+		t.Errorf("expected total pages to be 0x800 and no memory blocks; got 0x%x and %d", alloc.totalPages, alloc.memoryBlockCount)
+	}
+
+	if len(freed) != 1 || freed[0] != metaPage {
+		t.Errorf("expected the frame info mapping to be released; got %v", freed)
+	}
+
+	// The range can be added again after it has been removed
+	if err = alloc.addMemory(0x2000000, 0x1000000); err != nil {
+		t.Errorf("expected to add the range again; got %v", err)
+	}
+}
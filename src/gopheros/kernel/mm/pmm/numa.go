@@ -0,0 +1,166 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+)
+
+const (
+	// MaxNodes defines the max number of NUMA nodes supported by the
+	// frame allocator.
+	MaxNodes = 64
+
+	// maxPoolSplits defines the number of spare pool slots reserved by
+	// the frame allocator for splitting pools at NUMA node boundaries.
+	maxPoolSplits = 32
+)
+
+var (
+	errInvalidNode       = &kernel.Error{Module: "pmm", Message: "NUMA node exceeds the max number of supported nodes", Code: kernel.ErrCodeInvalidArgument}
+	errNodeTooManyPools  = &kernel.Error{Module: "pmm", Message: "no spare pool slots left for splitting pools at NUMA node boundaries", Code: kernel.ErrCodeOutOfMemory}
+	errNodeBoundaryInUse = &kernel.Error{Module: "pmm", Message: "allocated block crosses a NUMA node boundary", Code: kernel.ErrCodeBusy}
+)
+
+// AssignNode assigns the frames in the physical memory range
+// [base, base+length) to the specified NUMA node. Pools that are only
+// partially covered by the range are split at the range boundaries so that
+// each pool belongs to a single node. Frames not assigned to a node belong to
+// node 0.
+func AssignNode(node uint32, base, length uint64) *kernel.Error {
+	return buddyAllocator.assignNode(node, base, length)
+}
+
+// SetLocalNode registers a function that returns the NUMA node of the CPU
+// that invokes it. Once registered, frame allocations are served from the
+// memory of the local node first and only fall back to the memory of remote
+// nodes when the local node runs out of memory. Passing a nil function
+// disables the local node policy.
+func SetLocalNode(localNode func() uint32) {
+	buddyAllocator.localNodeFn = localNode
+}
+
+// AllocFrameOnNode reserves and returns a physical memory frame from the
+// memory of the specified NUMA node. If the node has no free frames, a frame
+// from another node is returned instead.
+func AllocFrameOnNode(node uint32) (mm.Frame, *kernel.Error) {
+	return buddyAllocator.AllocFramesOnNode(node, 0)
+}
+
+// assignNode implements AssignNode.
+func (alloc *BuddyAllocator) assignNode(node uint32, base, length uint64) *kernel.Error {
+	if node >= MaxNodes {
+		return errInvalidNode
+	}
+
+	// Only frames that are fully contained in the range are assigned
+	startFrame := mm.Frame((base + uint64(mm.PageSize-1)) >> mm.PageShift)
+	endFrame := mm.Frame((base+length)>>mm.PageShift) - 1
+	if length == 0 || endFrame < startFrame {
+		return nil
+	}
+
+	alloc.mutex.Acquire()
+	defer alloc.mutex.Release()
+
+	for poolIndex := 0; poolIndex < len(alloc.pools); poolIndex++ {
+		pool := &alloc.pools[poolIndex]
+		if pool.endFrame < startFrame || pool.startFrame > endFrame {
+			continue
+		}
+
+		// Split the pool at the range boundaries; the next iteration
+		// will process the pool that starts at the split point
+		if pool.startFrame < startFrame {
+			if err := alloc.splitPool(poolIndex, startFrame); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if pool.endFrame > endFrame {
+			if err := alloc.splitPool(poolIndex, endFrame+1); err != nil {
+				return err
+			}
+		}
+
+		alloc.pools[poolIndex].node = node
+	}
+
+	return nil
+}
+
+// splitPool splits the pool at the specified index into two pools so that the
+// second pool starts at splitFrame. Free blocks that cross splitFrame are
+// broken into smaller blocks; if an allocated block crosses splitFrame,
+// splitPool returns an error without modifying the allocator state.
+func (alloc *BuddyAllocator) splitPool(poolIndex int, splitFrame mm.Frame) *kernel.Error {
+	if len(alloc.pools) == cap(alloc.pools) {
+		return errNodeTooManyPools
+	}
+
+	pool := &alloc.pools[poolIndex]
+
+	// Locate the block that crosses the split point (if any)
+	for order := uint8(1); order <= MaxOrder; order++ {
+		blockFrame := splitFrame &^ (mm.Frame(1)<<order - 1)
+		if blockFrame < pool.startFrame {
+			break
+		}
+
+		info := &pool.frames[blockFrame-pool.startFrame]
+		if blockFrame == splitFrame || info.order != order {
+			continue
+		}
+
+		if !info.free {
+			return errNodeBoundaryInUse
+		}
+
+		// Replace the crossing block with smaller blocks on each side
+		// of the split point. The free lists are rebuilt below.
+		blockEnd := blockFrame + mm.Frame(1)<<order - 1
+		info.order, info.free = orderNone, false
+		pool.pushRange(blockFrame, splitFrame-1)
+		pool.pushRange(splitFrame, blockEnd)
+		break
+	}
+
+	newPool := framePool{
+		startFrame: splitFrame,
+		endFrame:   pool.endFrame,
+		zone:       pool.zone,
+		node:       pool.node,
+		frames:     pool.frames[splitFrame-pool.startFrame:],
+	}
+	pool.endFrame = splitFrame - 1
+	pool.frames = pool.frames[:splitFrame-pool.startFrame]
+
+	alloc.pools = alloc.pools[:len(alloc.pools)+1]
+	copy(alloc.pools[poolIndex+2:], alloc.pools[poolIndex+1:])
+	alloc.pools[poolIndex+1] = newPool
+
+	alloc.pools[poolIndex].rebuildFreeLists()
+	alloc.pools[poolIndex+1].rebuildFreeLists()
+	return nil
+}
+
+// rebuildFreeLists reconstructs the free lists and the free frame count of
+// the pool from the state of its frames.
+func (pool *framePool) rebuildFreeLists() {
+	for order := range pool.freeLists {
+		pool.freeLists[order] = listEnd
+	}
+
+	pool.freeCount = 0
+	for index := uint32(0); index < uint32(len(pool.frames)); {
+		info := &pool.frames[index]
+		if !info.free || info.order == orderNone {
+			index++
+			continue
+		}
+
+		pool.push(index, info.order)
+		pool.freeCount += uint32(1) << info.order
+		index += uint32(1) << info.order
+	}
+}
@@ -0,0 +1,176 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"testing"
+)
+
+func TestAssignNode(t *testing.T) {
+	type expPool struct {
+		start, end mm.Frame
+		node       uint32
+	}
+
+	specs := []struct {
+		node        uint32
+		base        uint64
+		length      uint64
+		spareSlots  int
+		allocBlocks int
+		expErr      *kernel.Error
+		expPools    []expPool
+	}{
+		{MaxNodes, 0, 0x1000, 1, 0, errInvalidNode, []expPool{{0, 0x7ff, 0}}},
+		{1, 0x100000, 0, 1, 0, nil, []expPool{{0, 0x7ff, 0}}},
+		// Partial frames are not assigned to the node
+		{1, 0x100001, 0xfff, 1, 0, nil, []expPool{{0, 0x7ff, 0}}},
+		{1, 0, 0x800000, 0, 0, nil, []expPool{{0, 0x7ff, 1}}},
+		{1, 0x100000, 0x100000, 2, 0, nil, []expPool{{0, 0xff, 0}, {0x100, 0x1ff, 1}, {0x200, 0x7ff, 0}}},
+		{2, 0x400000, 0x800000, 1, 0, nil, []expPool{{0, 0x3ff, 0}, {0x400, 0x7ff, 2}}},
+		{1, 0x100000, 0x100000, 1, 0, errNodeTooManyPools, nil},
+		{1, 0x100000, 0x100000, 2, 2, errNodeBoundaryInUse, nil},
+	}
+
+	for specIndex, spec := range specs {
+		alloc := newTestAllocator([2]mm.Frame{0, 0x7ff})
+		alloc.pools = append(make([]framePool, 0, len(alloc.pools)+spec.spareSlots), alloc.pools...)
+		for i := 0; i < spec.allocBlocks; i++ {
+			if _, err := alloc.AllocFrames(MaxOrder); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := alloc.assignNode(spec.node, spec.base, spec.length); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if spec.expPools == nil {
+			continue
+		}
+
+		if len(alloc.pools) != len(spec.expPools) {
+			t.Errorf("[spec %d] expected %d pools; got %d", specIndex, len(spec.expPools), len(alloc.pools))
+			continue
+		}
+
+		var freeCount uint32
+		for poolIndex, exp := range spec.expPools {
+			pool := &alloc.pools[poolIndex]
+			if pool.startFrame != exp.start || pool.endFrame != exp.end || pool.node != exp.node {
+				t.Errorf("[spec %d] expected pool %d to be [0x%x, 0x%x] on node %d; got [0x%x, 0x%x] on node %d", specIndex, poolIndex, exp.start, exp.end, exp.node, pool.startFrame, pool.endFrame, pool.node)
+			}
+
+			if exp := uint32(exp.end - exp.start + 1); pool.freeCount != exp {
+				t.Errorf("[spec %d] expected pool %d to have %d free frames; got %d", specIndex, poolIndex, exp, pool.freeCount)
+			}
+			freeCount += pool.freeCount
+		}
+
+		// All frames should still be allocatable after splitting the pools
+		for i := uint32(0); i < freeCount; i++ {
+			if _, err := alloc.AllocFrame(); err != nil {
+				t.Errorf("[spec %d] unexpected error allocating frame %d: %v", specIndex, i, err)
+				break
+			}
+		}
+	}
+}
+
+func TestAllocFramesOnNode(t *testing.T) {
+	alloc := newTestAllocator(
+		[2]mm.Frame{0x1000, 0x13ff},
+		[2]mm.Frame{dma32ZoneEndFrame, dma32ZoneEndFrame + 0x3ff},
+		[2]mm.Frame{dma32ZoneEndFrame + 0x400, dma32ZoneEndFrame + 0x7ff},
+	)
+	alloc.pools[0].node = 1
+	alloc.pools[2].node = 1
+
+	localNode := uint32(1)
+	alloc.localNodeFn = func() uint32 { return localNode }
+
+	specs := []struct {
+		node     uint32
+		onNode   bool
+		expFrame mm.Frame
+	}{
+		// Local node allocations prefer the node over higher zones
+		{1, false, dma32ZoneEndFrame + 0x400},
+		{1, false, 0x1000},
+		// Local node exhausted; fall back to other nodes
+		{1, false, dma32ZoneEndFrame},
+		{0, true, mm.InvalidFrame},
+	}
+
+	for specIndex, spec := range specs {
+		var (
+			frame mm.Frame
+			err   *kernel.Error
+		)
+
+		if spec.onNode {
+			frame, err = alloc.AllocFramesOnNode(spec.node, MaxOrder)
+		} else {
+			localNode = spec.node
+			frame, err = alloc.AllocFrames(MaxOrder)
+		}
+
+		if spec.expFrame == mm.InvalidFrame {
+			if err != errBuddyAllocOutOfMemory {
+				t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, errBuddyAllocOutOfMemory, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if frame != spec.expFrame {
+			t.Errorf("[spec %d] expected to get frame 0x%x; got 0x%x", specIndex, spec.expFrame, frame)
+		}
+	}
+
+	// Allocating on a node with free memory returns a frame from that node
+	if err := alloc.FreeFrames(dma32ZoneEndFrame, MaxOrder); err != nil {
+		t.Fatal(err)
+	}
+	if err := alloc.FreeFrames(0x1000, MaxOrder); err != nil {
+		t.Fatal(err)
+	}
+
+	if frame, err := alloc.AllocFramesOnNode(1, 0); err != nil || frame != 0x1000 {
+		t.Fatalf("expected to get frame 0x1000 on node 1; got 0x%x, %v", frame, err)
+	}
+}
+
+func TestNodePackageAPI(t *testing.T) {
+	origAlloc := buddyAllocator
+	defer func() {
+		buddyAllocator = origAlloc
+	}()
+
+	buddyAllocator = *newTestAllocator([2]mm.Frame{dma32ZoneEndFrame, dma32ZoneEndFrame + 0x7ff})
+	buddyAllocator.pools = append(make([]framePool, 0, 2), buddyAllocator.pools...)
+
+	if err := AssignNode(3, uint64(dma32ZoneEndFrame+0x400)<<mm.PageShift, 0x400<<mm.PageShift); err != nil {
+		t.Fatal(err)
+	}
+
+	SetLocalNode(func() uint32 { return 3 })
+	if buddyAllocator.localNodeFn == nil {
+		t.Fatal("expected local node function to be registered")
+	}
+	SetLocalNode(nil)
+
+	frame, err := AllocFrameOnNode(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if frame < dma32ZoneEndFrame+0x400 {
+		t.Fatalf("expected frame 0x%x to belong to node 3", frame)
+	}
+}
@@ -7,33 +7,68 @@ import (
 
 var (
 	// bootMemAllocator is the page allocator used when the kernel boots.
-	// It is used to bootstrap the bitmap allocator which is used for all
+	// It is used to bootstrap the buddy allocator which is used for all
 	// page allocations while the kernel runs.
 	bootMemAllocator BootMemAllocator
 
-	// bitmapAllocator is the standard allocator used by the kernel.
-	bitmapAllocator BitmapAllocator
+	// buddyAllocator is the standard allocator used by the kernel.
+	buddyAllocator BuddyAllocator
 )
 
 // Init sets up the kernel physical memory allocation sub-system.
 func Init(kernelStart, kernelEnd uintptr) *kernel.Error {
 	bootMemAllocator.init(kernelStart, kernelEnd)
+	if err := registerBootReservations(kernelStart, kernelEnd); err != nil {
+		return err
+	}
 	bootMemAllocator.printMemoryMap()
+	printReservedRegions()
 	mm.SetFrameAllocator(earlyAllocFrame)
 
-	// Using the bootMemAllocator bootstrap the bitmap allocator
-	if err := bitmapAllocator.init(); err != nil {
+	// Using the bootMemAllocator bootstrap the buddy allocator
+	if err := buddyAllocator.init(); err != nil {
 		return err
 	}
-	mm.SetFrameAllocator(bitmapAllocFrame)
+	mm.SetFrameAllocator(buddyAllocFrame)
+	mm.SetFrameReleaser(buddyFreeFrame)
 
 	return nil
 }
 
+// AllocFrames allocates a block of 1 << order physically contiguous frames
+// whose first frame number is a multiple of 1 << order. It is intended for
+// allocating DMA buffers and huge pages; order must not exceed MaxOrder.
+func AllocFrames(order uint8) (mm.Frame, *kernel.Error) {
+	return buddyAllocator.AllocFrames(order)
+}
+
+// AllocFramesInZone behaves like AllocFrames but the returned block is
+// guaranteed to belong to the specified zone or one of the zones below it.
+// It allows drivers for devices with limited DMA addressing capabilities to
+// allocate suitable buffers; zone must be one of ZoneDMA, ZoneDMA32 or
+// ZoneNormal.
+func AllocFramesInZone(zone Zone, order uint8) (mm.Frame, *kernel.Error) {
+	return buddyAllocator.AllocFramesInZone(zone, order)
+}
+
+// FreeFrames releases a block of frames previously allocated via a call to
+// AllocFrames with the same order.
+func FreeFrames(frame mm.Frame, order uint8) *kernel.Error {
+	return buddyAllocator.FreeFrames(frame, order)
+}
+
 func earlyAllocFrame() (mm.Frame, *kernel.Error) {
 	return bootMemAllocator.AllocFrame()
 }
 
-func bitmapAllocFrame() (mm.Frame, *kernel.Error) {
-	return bitmapAllocator.AllocFrame()
+func buddyAllocFrame() (mm.Frame, *kernel.Error) {
+	return buddyAllocator.AllocFrame()
+}
+
+func buddyFreeFrame(frame mm.Frame) *kernel.Error {
+	return buddyAllocator.FreeFrame(frame)
+}
+
+func init() {
+	mm.RegisterStatsCollector(buddyAllocator.collectStats)
 }
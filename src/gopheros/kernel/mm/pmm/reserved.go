@@ -0,0 +1,315 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+	"gopheros/multiboot"
+)
+
+// maxReservedRegions defines the max number of regions that can be tracked by
+// the reserved region registry.
+const maxReservedRegions = 64
+
+var (
+	errReservedRegionLimit = &kernel.Error{Module: "pmm", Message: "max number of reserved regions exceeded", Code: kernel.ErrCodeOutOfMemory}
+	errReservedRegionInUse = &kernel.Error{Module: "pmm", Message: "reserved region overlaps allocated frames", Code: kernel.ErrCodeBusy}
+
+	reservedMutex       sync.Spinlock
+	reservedRegions     [maxReservedRegions]ReservedRegion
+	reservedRegionCount int
+)
+
+// ReservationType describes why a physical memory region is reserved.
+type ReservationType uint8
+
+// The list of supported reservation types.
+const (
+	// ReservedFirmware marks memory that the firmware reported as
+	// unavailable.
+	ReservedFirmware ReservationType = iota
+
+	// ReservedACPIReclaim marks memory that holds the ACPI tables. It can
+	// be reused once the tables are no longer needed.
+	ReservedACPIReclaim
+
+	// ReservedACPINVS marks memory that must be preserved across sleep
+	// states.
+	ReservedACPINVS
+
+	// ReservedMMIO marks device memory such as the framebuffer.
+	ReservedMMIO
+
+	// ReservedTrampoline marks the low memory page used for booting the
+	// application processors.
+	ReservedTrampoline
+
+	// ReservedKernel marks the memory occupied by the kernel image.
+	ReservedKernel
+
+	// ReservedModule marks the memory occupied by the boot modules (e.g.
+	// an initrd) loaded by the bootloader.
+	ReservedModule
+)
+
+// String implements fmt.Stringer for ReservationType.
+func (t ReservationType) String() string {
+	switch t {
+	case ReservedFirmware:
+		return "firmware"
+	case ReservedACPIReclaim:
+		return "ACPI (reclaimable)"
+	case ReservedACPINVS:
+		return "ACPI NVS"
+	case ReservedMMIO:
+		return "MMIO"
+	case ReservedTrampoline:
+		return "AP trampoline"
+	case ReservedKernel:
+		return "kernel"
+	case ReservedModule:
+		return "module"
+	default:
+		return "unknown"
+	}
+}
+
+// ReservedRegion describes a physical memory range that must never be handed
+// out by the frame allocators.
+type ReservedRegion struct {
+	Type ReservationType
+
+	// The first and last frame of the region.
+	StartFrame mm.Frame
+	EndFrame   mm.Frame
+}
+
+// Reserve adds the physical memory range [base, base+length) to the reserved
+// region registry. The range is extended to cover any partially included
+// frames. Overlapping or adjacent regions of the same type are merged.
+//
+// Reserve may also be invoked after the frame allocator has been initialized;
+// in that case, the frames in the range are removed from the allocator pools.
+// If any of these frames has already been allocated, the region is not added
+// and an error is returned.
+func Reserve(typ ReservationType, base, length uint64) *kernel.Error {
+	if length == 0 {
+		return nil
+	}
+
+	reservedMutex.Acquire()
+	defer reservedMutex.Release()
+
+	return addReservedRegion(ReservedRegion{
+		Type:       typ,
+		StartFrame: mm.Frame(base >> mm.PageShift),
+		EndFrame:   mm.Frame((base + length - 1) >> mm.PageShift),
+	})
+}
+
+// IsReserved returns true if frame belongs to a reserved region.
+func IsReserved(frame mm.Frame) bool {
+	reservedMutex.Acquire()
+	defer reservedMutex.Release()
+
+	return reservedRegionIndex(frame) >= 0
+}
+
+// VisitReserved invokes visitor for each reserved region in ascending address
+// order. If visitor returns false, VisitReserved stops iterating the regions.
+func VisitReserved(visitor func(*ReservedRegion) bool) {
+	reservedMutex.Acquire()
+	defer reservedMutex.Release()
+
+	for index := 0; index < reservedRegionCount; index++ {
+		if !visitor(&reservedRegions[index]) {
+			return
+		}
+	}
+}
+
+// addReservedRegion inserts region into the registry keeping the regions
+// sorted by their start frame. Callers must hold reservedMutex.
+func addReservedRegion(region ReservedRegion) *kernel.Error {
+	// Merge the region with any overlapping or adjacent regions of the
+	// same type.
+	mergeCount := 0
+	for index := 0; index < reservedRegionCount; index++ {
+		other := &reservedRegions[index]
+		if other.Type == region.Type && region.StartFrame <= other.EndFrame+1 && other.StartFrame <= region.EndFrame+1 {
+			mergeCount++
+		}
+	}
+
+	if reservedRegionCount-mergeCount == maxReservedRegions {
+		return errReservedRegionLimit
+	}
+
+	// Once the allocator is up, the frames must be removed from its pools
+	if len(buddyAllocator.pools) != 0 {
+		if err := buddyAllocator.reserveUnclaimed(region.StartFrame, region.EndFrame); err != nil {
+			return err
+		}
+	}
+
+	for index := 0; index < reservedRegionCount; {
+		other := reservedRegions[index]
+		if other.Type != region.Type || region.StartFrame > other.EndFrame+1 || other.StartFrame > region.EndFrame+1 {
+			index++
+			continue
+		}
+
+		if other.StartFrame < region.StartFrame {
+			region.StartFrame = other.StartFrame
+		}
+		if other.EndFrame > region.EndFrame {
+			region.EndFrame = other.EndFrame
+		}
+
+		copy(reservedRegions[index:], reservedRegions[index+1:reservedRegionCount])
+		reservedRegionCount--
+	}
+
+	insertIndex := 0
+	for ; insertIndex < reservedRegionCount && reservedRegions[insertIndex].StartFrame <= region.StartFrame; insertIndex++ {
+	}
+
+	copy(reservedRegions[insertIndex+1:reservedRegionCount+1], reservedRegions[insertIndex:reservedRegionCount])
+	reservedRegions[insertIndex] = region
+	reservedRegionCount++
+	return nil
+}
+
+// reservedRegionIndex returns the index of the first reserved region that
+// contains frame or -1 if the frame is not reserved. Callers must hold
+// reservedMutex.
+func reservedRegionIndex(frame mm.Frame) int {
+	for index := 0; index < reservedRegionCount; index++ {
+		if frame >= reservedRegions[index].StartFrame && frame <= reservedRegions[index].EndFrame {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// reservedEndFrame checks whether frame belongs to a reserved region and
+// returns the last frame of the contiguous reserved range that contains it.
+func reservedEndFrame(frame mm.Frame) (mm.Frame, bool) {
+	reservedMutex.Acquire()
+	defer reservedMutex.Release()
+
+	if reservedRegionIndex(frame) < 0 {
+		return 0, false
+	}
+
+	// Regions of different types may overlap or be adjacent to each other
+	for {
+		index := reservedRegionIndex(frame + 1)
+		if index < 0 {
+			return frame, true
+		}
+		frame = reservedRegions[index].EndFrame
+	}
+}
+
+// registerBootReservations populates the reserved region registry with the
+// regions that the bootloader reported as unavailable, the framebuffer, the
+// kernel image and the boot modules.
+func registerBootReservations(kernelStart, kernelEnd uintptr) *kernel.Error {
+	reservedMutex.Acquire()
+	reservedRegionCount = 0
+	reservedMutex.Release()
+
+	var err *kernel.Error
+	multiboot.VisitMemRegions(func(region *multiboot.MemoryMapEntry) bool {
+		typ := ReservedFirmware
+		switch region.Type {
+		case multiboot.MemAvailable:
+			return true
+		case multiboot.MemAcpiReclaimable:
+			typ = ReservedACPIReclaim
+		case multiboot.MemNvs:
+			typ = ReservedACPINVS
+		}
+
+		err = Reserve(typ, region.PhysAddress, region.Length)
+		return err == nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if fbInfo := multiboot.GetFramebufferInfo(); fbInfo != nil {
+		if err = Reserve(ReservedMMIO, fbInfo.PhysAddr, uint64(fbInfo.Pitch)*uint64(fbInfo.Height)); err != nil {
+			return err
+		}
+	}
+
+	if err = Reserve(ReservedKernel, uint64(kernelStart), uint64(kernelEnd-kernelStart)); err != nil {
+		return err
+	}
+
+	multiboot.VisitModules(func(_ string, physStart, physEnd uintptr) bool {
+		if physEnd > physStart {
+			err = Reserve(ReservedModule, uint64(physStart), uint64(physEnd-physStart))
+		}
+		return err == nil
+	})
+
+	return err
+}
+
+// printReservedRegions prints out the contents of the reserved region
+// registry.
+func printReservedRegions() {
+	kfmt.Printf("[pmm] reserved regions:\n")
+	VisitReserved(func(region *ReservedRegion) bool {
+		kfmt.Printf("\t[0x%10x - 0x%10x], type: %s\n", region.StartFrame.Address(), (region.EndFrame + 1).Address(), region.Type.String())
+		return true
+	})
+}
+
+// reserveUnclaimed marks as reserved all frames in [startFrame, endFrame] that
+// belong to the allocator pools and are not already covered by the reserved
+// region registry. If any of these frames has been allocated,
+// reserveUnclaimed returns an error without modifying the allocator state.
+// Callers must hold reservedMutex.
+func (alloc *BuddyAllocator) reserveUnclaimed(startFrame, endFrame mm.Frame) *kernel.Error {
+	alloc.mutex.Acquire()
+	defer alloc.mutex.Release()
+
+	inUse := false
+	alloc.visitPoolFrames(startFrame, endFrame, func(poolIndex int, frame mm.Frame) bool {
+		if reservedRegionIndex(frame) >= 0 {
+			return true
+		}
+
+		pool := &alloc.pools[poolIndex]
+		_, free := pool.freeBlockContaining(uint32(frame - pool.startFrame))
+		inUse = !free
+		return free
+	})
+
+	if inUse {
+		return errReservedRegionInUse
+	}
+
+	alloc.visitPoolFrames(startFrame, endFrame, func(poolIndex int, frame mm.Frame) bool {
+		if reservedRegionIndex(frame) < 0 {
+			alloc.reserveFrame(poolIndex, frame)
+		}
+		return true
+	})
+	return nil
+}
+
+// reserveRegisteredFrames marks as reserved the frames that belong to the
+// regions in the reserved region registry.
+func (alloc *BuddyAllocator) reserveRegisteredFrames() {
+	VisitReserved(func(region *ReservedRegion) bool {
+		alloc.visitPoolFrames(region.StartFrame, region.EndFrame, alloc.reserveFrame)
+		return true
+	})
+}
@@ -0,0 +1,258 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/multiboot"
+	"testing"
+	"unsafe"
+)
+
+func TestReserve(t *testing.T) {
+	defer resetReservedRegions()
+	resetReservedRegions()
+
+	specs := []struct {
+		typ        ReservationType
+		base       uint64
+		length     uint64
+		expErr     *kernel.Error
+		expRegions []ReservedRegion
+	}{
+		{ReservedFirmware, 0x1000, 0, nil, nil},
+		{ReservedFirmware, 0x10000, 0x2000, nil, []ReservedRegion{{ReservedFirmware, 0x10, 0x11}}},
+		// Partially covered frames are reserved
+		{ReservedMMIO, 0x4800, 0x1000, nil, []ReservedRegion{{ReservedMMIO, 4, 5}, {ReservedFirmware, 0x10, 0x11}}},
+		// Adjacent region of the same type is merged
+		{ReservedFirmware, 0x12000, 0x1000, nil, []ReservedRegion{{ReservedMMIO, 4, 5}, {ReservedFirmware, 0x10, 0x12}}},
+		// Overlapping region of a different type is not merged
+		{ReservedKernel, 0x5000, 0x2000, nil, []ReservedRegion{{ReservedMMIO, 4, 5}, {ReservedKernel, 5, 6}, {ReservedFirmware, 0x10, 0x12}}},
+		// Region that bridges two regions of the same type
+		{ReservedMMIO, 0x0, 0x12000, nil, []ReservedRegion{{ReservedMMIO, 0, 0x11}, {ReservedKernel, 5, 6}, {ReservedFirmware, 0x10, 0x12}}},
+	}
+
+	for specIndex, spec := range specs {
+		if err := Reserve(spec.typ, spec.base, spec.length); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		var regions []ReservedRegion
+		VisitReserved(func(region *ReservedRegion) bool {
+			regions = append(regions, *region)
+			return true
+		})
+
+		if len(regions) != len(spec.expRegions) {
+			t.Errorf("[spec %d] expected %d regions; got %d", specIndex, len(spec.expRegions), len(regions))
+			continue
+		}
+
+		for index, exp := range spec.expRegions {
+			if regions[index] != exp {
+				t.Errorf("[spec %d] expected region %d to be %v; got %v", specIndex, index, exp, regions[index])
+			}
+		}
+	}
+
+	for _, spec := range []struct {
+		frame       mm.Frame
+		expReserved bool
+		expEnd      mm.Frame
+	}{{0, true, 0x12}, {6, true, 0x12}, {0x12, true, 0x12}, {0x13, false, 0}} {
+		if got := IsReserved(spec.frame); got != spec.expReserved {
+			t.Errorf("expected IsReserved(%d) to return %t; got %t", spec.frame, spec.expReserved, got)
+		}
+
+		if end, reserved := reservedEndFrame(spec.frame); reserved != spec.expReserved || end != spec.expEnd {
+			t.Errorf("expected reserved range containing frame %d to end at %d; got %d", spec.frame, spec.expEnd, end)
+		}
+	}
+
+	var visitCount int
+	VisitReserved(func(*ReservedRegion) bool {
+		visitCount++
+		return false
+	})
+	if visitCount != 1 {
+		t.Fatalf("expected VisitReserved to stop after the first region; visited %d", visitCount)
+	}
+
+	resetReservedRegions()
+	for index := uint64(0); index < maxReservedRegions; index++ {
+		if err := Reserve(ReservedFirmware, index*2*uint64(mm.PageSize), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Reserve(ReservedMMIO, 0x100000, 1); err != errReservedRegionLimit {
+		t.Fatalf("expected to get error %v; got %v", errReservedRegionLimit, err)
+	}
+
+	// Regions that can be merged do not require a new slot
+	if err := Reserve(ReservedFirmware, uint64(mm.PageSize), 1); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReserveAfterAllocatorInit(t *testing.T) {
+	defer func() {
+		buddyAllocator = BuddyAllocator{}
+		resetReservedRegions()
+	}()
+	resetReservedRegions()
+
+	buddyAllocator = *newTestAllocator([2]mm.Frame{0, 63})
+	if err := Reserve(ReservedTrampoline, 0x8000, uint64(mm.PageSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	assertReserved(t, &buddyAllocator, 8, 8)
+
+	// Frames already covered by the registry are skipped
+	if err := Reserve(ReservedMMIO, 0x7000, 3*uint64(mm.PageSize)); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := uint32(3); buddyAllocator.reservedPages != exp {
+		t.Fatalf("expected %d reserved pages; got %d", exp, buddyAllocator.reservedPages)
+	}
+
+	frame, err := buddyAllocator.AllocFrame()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = Reserve(ReservedMMIO, uint64(frame.Address()), 2*uint64(mm.PageSize)); err != errReservedRegionInUse {
+		t.Fatalf("expected to get error %v; got %v", errReservedRegionInUse, err)
+	}
+
+	if IsReserved(frame) {
+		t.Fatal("expected region overlapping allocated frames not to be registered")
+	}
+}
+
+func TestRegisterBootReservations(t *testing.T) {
+	defer func() {
+		multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
+		resetReservedRegions()
+	}()
+
+	// A module occupying frames 1 and 2 followed by the memory map
+	infoData := append([]byte{
+		0, 0, 0, 0, // size
+		0, 0, 0, 0, // reserved
+		3, 0, 0, 0, // type
+		17, 0, 0, 0, // size
+		0, 16, 0, 0, // mod_start
+		0, 48, 0, 0, // mod_end
+		0,                   // cmdline
+		0, 0, 0, 0, 0, 0, 0, // padding
+	}, multibootMemoryMap[8:]...)
+	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&infoData[0])))
+
+	if err := registerBootReservations(0x200000, 0x202800); err != nil {
+		t.Fatal(err)
+	}
+
+	expRegions := []ReservedRegion{
+		{ReservedModule, 1, 2},
+		{ReservedFirmware, 0x9f, 0x9f},
+		{ReservedFirmware, 0xf0, 0xff},
+		{ReservedKernel, 0x200, 0x202},
+		{ReservedFirmware, 0x7fe0, 0x7fff},
+		{ReservedFirmware, 0xfffc0, 0xfffff},
+	}
+
+	var regions []ReservedRegion
+	VisitReserved(func(region *ReservedRegion) bool {
+		regions = append(regions, *region)
+		return true
+	})
+
+	if len(regions) != len(expRegions) {
+		t.Fatalf("expected %d regions; got %d: %v", len(expRegions), len(regions), regions)
+	}
+
+	for index, exp := range expRegions {
+		if regions[index] != exp {
+			t.Errorf("expected region %d to be %v; got %v", index, exp, regions[index])
+		}
+	}
+
+	// Registering the boot reservations again resets the registry
+	multiboot.SetInfoPtr(uintptr(unsafe.Pointer(&multibootMemoryMap[0])))
+	if err := registerBootReservations(0x200000, 0x200000); err != nil {
+		t.Fatal(err)
+	}
+
+	if reservedRegionCount != 4 {
+		t.Fatalf("expected 4 regions to be registered; got %d", reservedRegionCount)
+	}
+
+	printReservedRegions()
+}
+
+func TestBuddyAllocatorReserveRegisteredFrames(t *testing.T) {
+	defer resetReservedRegions()
+	resetReservedRegions()
+
+	alloc := newTestAllocator([2]mm.Frame{0, 7}, [2]mm.Frame{64, 191})
+
+	// The kernel occupies 16 frames at the beginning of pool 1 and a
+	// module spans frames that are not managed by the allocator
+	for _, spec := range []struct {
+		typ          ReservationType
+		base, length uint64
+	}{
+		{ReservedKernel, 64 << mm.PageShift, 16 << mm.PageShift},
+		{ReservedModule, 6 << mm.PageShift, 4 << mm.PageShift},
+	} {
+		if err := Reserve(spec.typ, spec.base, spec.length); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	alloc.reserveRegisteredFrames()
+
+	if exp, got := uint32(18), alloc.reservedPages; got != exp {
+		t.Fatalf("expected reserved page counter to be %d; got %d", exp, got)
+	}
+
+	if exp, got := uint32(6), alloc.pools[0].freeCount; got != exp {
+		t.Fatalf("expected free count for pool 0 to be %d; got %d", exp, got)
+	}
+
+	if exp, got := uint32(112), alloc.pools[1].freeCount; got != exp {
+		t.Fatalf("expected free count for pool 1 to be %d; got %d", exp, got)
+	}
+
+	assertReserved(t, alloc, 6, 7)
+	assertReserved(t, alloc, 64, 79)
+}
+
+func TestReservationTypeString(t *testing.T) {
+	specs := []struct {
+		typ    ReservationType
+		expStr string
+	}{
+		{ReservedFirmware, "firmware"},
+		{ReservedACPIReclaim, "ACPI (reclaimable)"},
+		{ReservedACPINVS, "ACPI NVS"},
+		{ReservedMMIO, "MMIO"},
+		{ReservedTrampoline, "AP trampoline"},
+		{ReservedKernel, "kernel"},
+		{ReservedModule, "module"},
+		{ReservationType(123), "unknown"},
+	}
+
+	for specIndex, spec := range specs {
+		if got := spec.typ.String(); got != spec.expStr {
+			t.Errorf("[spec %d] expected to get %q; got %q", specIndex, spec.expStr, got)
+		}
+	}
+}
+
+func resetReservedRegions() {
+	reservedRegionCount = 0
+}
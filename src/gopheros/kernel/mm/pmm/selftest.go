@@ -0,0 +1,100 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/selftest"
+)
+
+// selfTestFrameCount is the number of frames allocated by the allocator
+// stress self-test.
+const selfTestFrameCount = 64
+
+// selfTestBlockOrder is the order of the block allocated by the multi-order
+// allocation self-test.
+const selfTestBlockOrder = 4
+
+var (
+	errSelfTestDuplicateFrame = &kernel.Error{Module: "pmm", Message: "self-test: allocator returned the same frame twice", Code: kernel.ErrCodeCorrupted}
+	errSelfTestAccounting     = &kernel.Error{Module: "pmm", Message: "self-test: reserved page count mismatch", Code: kernel.ErrCodeCorrupted}
+	errSelfTestDoubleFree     = &kernel.Error{Module: "pmm", Message: "self-test: double free was not detected", Code: kernel.ErrCodeCorrupted}
+	errSelfTestAlignment      = &kernel.Error{Module: "pmm", Message: "self-test: allocated block is not naturally aligned", Code: kernel.ErrCodeCorrupted}
+)
+
+// selfTestAllocFree allocates a batch of frames from the buddy allocator,
+// checks that no frame is handed out twice, releases them and verifies that
+// the allocator bookkeeping is restored.
+func selfTestAllocFree() *kernel.Error {
+	var (
+		frames          [selfTestFrameCount]mm.Frame
+		reservedAtStart = buddyAllocator.reservedPages
+		err             *kernel.Error
+	)
+
+	for i := 0; i < len(frames); i++ {
+		if frames[i], err = buddyAllocator.AllocFrame(); err != nil {
+			return err
+		}
+
+		for j := 0; j < i; j++ {
+			if frames[j] == frames[i] {
+				return errSelfTestDuplicateFrame
+			}
+		}
+	}
+
+	if buddyAllocator.reservedPages != reservedAtStart+selfTestFrameCount {
+		return errSelfTestAccounting
+	}
+
+	for _, frame := range frames {
+		if err = buddyAllocator.FreeFrame(frame); err != nil {
+			return err
+		}
+	}
+
+	if buddyAllocator.reservedPages != reservedAtStart {
+		return errSelfTestAccounting
+	}
+
+	if buddyAllocator.FreeFrame(frames[0]) != errBuddyAllocDoubleFree {
+		return errSelfTestDoubleFree
+	}
+
+	return nil
+}
+
+// selfTestAllocOrder allocates a multi-frame block from the buddy allocator,
+// checks its alignment and verifies that the allocator bookkeeping is restored
+// once the block is released.
+func selfTestAllocOrder() *kernel.Error {
+	reservedAtStart := buddyAllocator.reservedPages
+
+	frame, err := buddyAllocator.AllocFrames(selfTestBlockOrder)
+	if err != nil {
+		return err
+	}
+
+	if frame&(1<<selfTestBlockOrder-1) != 0 {
+		return errSelfTestAlignment
+	}
+
+	if buddyAllocator.reservedPages != reservedAtStart+1<<selfTestBlockOrder {
+		return errSelfTestAccounting
+	}
+
+	if err = buddyAllocator.FreeFrames(frame, selfTestBlockOrder); err != nil {
+		return err
+	}
+
+	if buddyAllocator.reservedPages != reservedAtStart {
+		return errSelfTestAccounting
+	}
+
+	return nil
+}
+
+func init() {
+	selftest.Register("pmm/alloc-free", selfTestAllocFree)
+	selftest.Register("pmm/alloc-order", selfTestAllocOrder)
+}
@@ -0,0 +1,36 @@
+package pmm
+
+import (
+	"gopheros/kernel/mm"
+	"testing"
+)
+
+func TestSelfTestAllocFree(t *testing.T) {
+	defer func(origAlloc BuddyAllocator) {
+		buddyAllocator = origAlloc
+	}(buddyAllocator)
+
+	buddyAllocator = *newTestAllocator([2]mm.Frame{0, 127})
+
+	if err := selfTestAllocFree(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if err := selfTestAllocOrder(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if buddyAllocator.reservedPages != 0 {
+		t.Fatalf("expected all frames to be released; got %d reserved", buddyAllocator.reservedPages)
+	}
+
+	// Not enough free frames to complete the tests
+	buddyAllocator = *newTestAllocator([2]mm.Frame{0, 7})
+	if err := selfTestAllocFree(); err != errBuddyAllocOutOfMemory {
+		t.Fatalf("expected errBuddyAllocOutOfMemory; got %v", err)
+	}
+
+	if err := selfTestAllocOrder(); err != errBuddyAllocOutOfMemory {
+		t.Fatalf("expected errBuddyAllocOutOfMemory; got %v", err)
+	}
+}
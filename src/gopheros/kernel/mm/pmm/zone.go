@@ -0,0 +1,179 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+)
+
+var (
+	errInvalidZone       = &kernel.Error{Module: "pmm", Message: "regions can only be added to zones not managed by the frame allocator", Code: kernel.ErrCodeInvalidArgument}
+	errZoneRegionInUse   = &kernel.Error{Module: "pmm", Message: "zone region overlaps allocated frames", Code: kernel.ErrCodeBusy}
+	errZoneRegionOverlap = &kernel.Error{Module: "pmm", Message: "zone region overlaps an existing zone region", Code: kernel.ErrCodeInvalidArgument}
+
+	zoneMutex   sync.Spinlock
+	zoneRegions []ZoneRegion
+)
+
+// Zone classifies physical memory according to its intended use.
+type Zone uint8
+
+// The list of supported zones. Zones managed by the frame allocator are
+// ordered by their physical address range.
+const (
+	// ZoneDMA contains the RAM below 16M that can be addressed by legacy
+	// ISA DMA controllers.
+	ZoneDMA Zone = iota
+
+	// ZoneDMA32 contains the RAM between 16M and 4G that can be addressed
+	// by devices limited to 32-bit DMA.
+	ZoneDMA32
+
+	// ZoneNormal contains the ordinary RAM above 4G.
+	ZoneNormal
+
+	// ZonePersistent contains byte-addressable persistent memory (e.g.
+	// NVDIMMs). Frames in this zone are never returned by the frame
+	// allocator as their contents survive reboots.
+	ZonePersistent
+)
+
+const (
+	// dmaZoneEndFrame is the first frame above the ZoneDMA range.
+	dmaZoneEndFrame = mm.Frame((16 << 20) >> mm.PageShift)
+
+	// dma32ZoneEndFrame is the first frame above the ZoneDMA32 range.
+	dma32ZoneEndFrame = mm.Frame((4 << 30) >> mm.PageShift)
+)
+
+// String implements fmt.Stringer for Zone.
+func (z Zone) String() string {
+	switch z {
+	case ZoneDMA:
+		return "dma"
+	case ZoneDMA32:
+		return "dma32"
+	case ZoneNormal:
+		return "normal"
+	case ZonePersistent:
+		return "persistent"
+	default:
+		return "unknown"
+	}
+}
+
+// zoneForFrame returns the allocator-managed zone that contains frame.
+func zoneForFrame(frame mm.Frame) Zone {
+	switch {
+	case frame < dmaZoneEndFrame:
+		return ZoneDMA
+	case frame < dma32ZoneEndFrame:
+		return ZoneDMA32
+	default:
+		return ZoneNormal
+	}
+}
+
+// ZoneRegion describes a physical memory range that belongs to a zone.
+type ZoneRegion struct {
+	Zone Zone
+
+	// The first and last frame of the region.
+	StartFrame mm.Frame
+	EndFrame   mm.Frame
+}
+
+// AddZoneRegion assigns the physical memory range [base, base+length) to the
+// specified zone. Any frames in the range that are managed by the frame
+// allocator are reserved so they are never handed out as ordinary RAM. If any
+// of these frames has already been allocated, the region is not added and an
+// error is returned.
+func AddZoneRegion(zone Zone, base, length uint64) *kernel.Error {
+	if zone <= ZoneNormal {
+		return errInvalidZone
+	}
+
+	if length == 0 {
+		return nil
+	}
+
+	region := ZoneRegion{
+		Zone:       zone,
+		StartFrame: mm.Frame(base >> mm.PageShift),
+		EndFrame:   mm.Frame((base + length - 1) >> mm.PageShift),
+	}
+
+	zoneMutex.Acquire()
+	defer zoneMutex.Release()
+
+	for _, other := range zoneRegions {
+		if region.StartFrame <= other.EndFrame && other.StartFrame <= region.EndFrame {
+			return errZoneRegionOverlap
+		}
+	}
+
+	if err := buddyAllocator.reserveRange(region.StartFrame, region.EndFrame); err != nil {
+		return err
+	}
+
+	zoneRegions = append(zoneRegions, region)
+	return nil
+}
+
+// VisitZone invokes visitor for each region that belongs to the specified
+// zone in the order in which the regions were added. If visitor returns
+// false, VisitZone stops iterating the regions.
+func VisitZone(zone Zone, visitor func(*ZoneRegion) bool) {
+	zoneMutex.Acquire()
+	defer zoneMutex.Release()
+
+	for index := range zoneRegions {
+		if zoneRegions[index].Zone == zone && !visitor(&zoneRegions[index]) {
+			return
+		}
+	}
+}
+
+// reserveRange marks all frames in [startFrame, endFrame] that belong to the
+// allocator pools as reserved. If any of these frames is already reserved,
+// reserveRange returns an error without modifying the allocator state.
+func (alloc *BuddyAllocator) reserveRange(startFrame, endFrame mm.Frame) *kernel.Error {
+	alloc.mutex.Acquire()
+	defer alloc.mutex.Release()
+
+	inUse := false
+	alloc.visitPoolFrames(startFrame, endFrame, func(poolIndex int, frame mm.Frame) bool {
+		pool := &alloc.pools[poolIndex]
+		_, free := pool.freeBlockContaining(uint32(frame - pool.startFrame))
+		inUse = !free
+		return free
+	})
+
+	if inUse {
+		return errZoneRegionInUse
+	}
+
+	alloc.visitPoolFrames(startFrame, endFrame, alloc.reserveFrame)
+	return nil
+}
+
+// visitPoolFrames invokes visitor for each frame in [startFrame, endFrame]
+// that belongs to one of the allocator pools. If visitor returns false,
+// visitPoolFrames stops iterating the frames.
+func (alloc *BuddyAllocator) visitPoolFrames(startFrame, endFrame mm.Frame, visitor func(int, mm.Frame) bool) {
+	for poolIndex, pool := range alloc.pools {
+		from, to := pool.startFrame, pool.endFrame
+		if startFrame > from {
+			from = startFrame
+		}
+		if endFrame < to {
+			to = endFrame
+		}
+
+		for frame := from; from <= to && frame <= to; frame++ {
+			if !visitor(poolIndex, frame) {
+				return
+			}
+		}
+	}
+}
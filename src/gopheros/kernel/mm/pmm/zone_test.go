@@ -0,0 +1,115 @@
+package pmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"testing"
+)
+
+func TestAddZoneRegion(t *testing.T) {
+	defer func(origAlloc BuddyAllocator) {
+		buddyAllocator = origAlloc
+		zoneRegions = nil
+	}(buddyAllocator)
+
+	buddyAllocator = *newTestAllocator([2]mm.Frame{0, 63}, [2]mm.Frame{128, 191})
+
+	const pageSize = uint64(mm.PageSize)
+
+	// Frame 4 is allocated
+	buddyAllocator.reserveFrame(0, 4)
+
+	specs := []struct {
+		zone         Zone
+		base, length uint64
+		expErr       *kernel.Error
+	}{
+		{ZoneNormal, 0, pageSize, errInvalidZone},
+		{ZoneDMA32, 0, pageSize, errInvalidZone},
+		// Overlaps allocated frame 4
+		{ZonePersistent, 2 * pageSize, 4 * pageSize, errZoneRegionInUse},
+		{ZonePersistent, 0, 0, nil},
+		// Frames 60-131; spans both pools and the gap between them
+		{ZonePersistent, 60 * pageSize, 72 * pageSize, nil},
+		// Overlaps the previous region
+		{ZonePersistent, 131 * pageSize, pageSize, errZoneRegionOverlap},
+		// Outside of the allocator pools
+		{ZonePersistent, 1024 * pageSize, pageSize, nil},
+	}
+
+	for specIndex, spec := range specs {
+		if err := AddZoneRegion(spec.zone, spec.base, spec.length); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	// Frames 60-63 and 128-131 should be reserved in addition to frame 4
+	assertReserved(t, &buddyAllocator, 60, 63)
+	assertReserved(t, &buddyAllocator, 128, 131)
+
+	if exp, got := uint32(9), buddyAllocator.reservedPages; got != exp {
+		t.Errorf("expected reserved page count to be %d; got %d", exp, got)
+	}
+
+	var regions []ZoneRegion
+	VisitZone(ZonePersistent, func(region *ZoneRegion) bool {
+		regions = append(regions, *region)
+		return true
+	})
+
+	expRegions := []ZoneRegion{
+		{Zone: ZonePersistent, StartFrame: 60, EndFrame: 131},
+		{Zone: ZonePersistent, StartFrame: 1024, EndFrame: 1024},
+	}
+	if len(regions) != len(expRegions) || regions[0] != expRegions[0] || regions[1] != expRegions[1] {
+		t.Errorf("expected zone regions %v; got %v", expRegions, regions)
+	}
+
+	visitCount := 0
+	VisitZone(ZonePersistent, func(*ZoneRegion) bool {
+		visitCount++
+		return false
+	})
+
+	if visitCount != 1 {
+		t.Errorf("expected VisitZone to stop after the visitor returned false; visited %d regions", visitCount)
+	}
+}
+
+func TestZoneString(t *testing.T) {
+	specs := []struct {
+		zone Zone
+		exp  string
+	}{
+		{ZoneDMA, "dma"},
+		{ZoneDMA32, "dma32"},
+		{ZoneNormal, "normal"},
+		{ZonePersistent, "persistent"},
+		{Zone(42), "unknown"},
+	}
+
+	for specIndex, spec := range specs {
+		if got := spec.zone.String(); got != spec.exp {
+			t.Errorf("[spec %d] expected %q; got %q", specIndex, spec.exp, got)
+		}
+	}
+}
+
+func TestZoneForFrame(t *testing.T) {
+	specs := []struct {
+		physAddr uintptr
+		exp      Zone
+	}{
+		{0, ZoneDMA},
+		{16<<20 - 1, ZoneDMA},
+		{16 << 20, ZoneDMA32},
+		{4<<30 - 1, ZoneDMA32},
+		{4 << 30, ZoneNormal},
+	}
+
+	for specIndex, spec := range specs {
+		if got := zoneForFrame(mm.FrameFromAddress(spec.physAddr)); got != spec.exp {
+			t.Errorf("[spec %d] expected address 0x%x to belong to zone %s; got %s", specIndex, spec.physAddr, spec.exp, got)
+		}
+	}
+}
@@ -0,0 +1,82 @@
+package mm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/sync"
+)
+
+// maxReclaimPasses defines the number of times that a failed allocation is
+// retried after reclaiming memory before the failure is reported to the
+// caller.
+const maxReclaimPasses = 3
+
+var (
+	reclaimLock sync.Spinlock
+	reclaimers  []Reclaimer
+
+	// reclaimInProgress is held while the reclaimers run. Allocations
+	// that fail while memory is being reclaimed (e.g. by a reclaimer or
+	// by another CPU) are not retried.
+	reclaimInProgress sync.Spinlock
+)
+
+// Reclaimer is a function that releases memory held by a kernel subsystem
+// (e.g. empty object cache slabs) back to the frame allocator and returns the
+// number of released frames. Reclaimers are invoked from the allocation path
+// of the frame allocator, possibly while the allocating code holds subsystem
+// locks; they must therefore skip any state whose lock cannot be acquired via
+// TryToAcquire instead of blocking.
+type Reclaimer func() uint64
+
+// RegisterReclaimer registers a function that will be invoked by Reclaim when
+// the system runs out of memory.
+func RegisterReclaimer(reclaimer Reclaimer) {
+	reclaimLock.Acquire()
+	reclaimers = append(reclaimers, reclaimer)
+	reclaimLock.Release()
+}
+
+// Reclaim invokes the registered reclaimers in registration order and
+// returns the total number of frames that they released. If memory is
+// already being reclaimed, Reclaim returns 0 without invoking the reclaimers.
+func Reclaim() uint64 {
+	if !reclaimInProgress.TryToAcquire() {
+		return 0
+	}
+	defer reclaimInProgress.Release()
+
+	reclaimLock.Acquire()
+	list := reclaimers
+	reclaimLock.Release()
+
+	var released uint64
+	for _, reclaimer := range list {
+		released += reclaimer()
+	}
+
+	return released
+}
+
+// RetryAfterReclaim is invoked by allocators when the pass-th attempt to
+// serve an allocation request fails with err. If err indicates that the
+// system is out of memory, RetryAfterReclaim invokes the registered
+// reclaimers and returns true if the request should be retried because some
+// memory was released.
+func RetryAfterReclaim(err *kernel.Error, pass int) bool {
+	if err == nil || err.Code != kernel.ErrCodeOutOfMemory || pass >= maxReclaimPasses {
+		return false
+	}
+
+	return Reclaim() != 0
+}
+
+// OutOfMemory is the last resort for code paths that cannot report an
+// allocation failure to their caller (e.g. page fault handlers). It prints a
+// summary of the kernel memory usage and panics with err. All other callers
+// should propagate the allocation error instead.
+func OutOfMemory(err *kernel.Error) {
+	kfmt.Printf("\nout of memory: %s\n", err.Error())
+	_ = cmdMemInfo(kfmt.GetOutputSink(), nil)
+	panic(err)
+}
@@ -0,0 +1,86 @@
+package mm
+
+import (
+	"gopheros/kernel"
+	"testing"
+)
+
+func TestReclaim(t *testing.T) {
+	defer func(orig []Reclaimer) { reclaimers = orig }(reclaimers)
+	reclaimers = nil
+
+	var (
+		calls     []int
+		nested    uint64
+		released1 uint64
+	)
+
+	RegisterReclaimer(func() uint64 {
+		calls = append(calls, 1)
+		return released1
+	})
+	RegisterReclaimer(func() uint64 {
+		calls = append(calls, 2)
+
+		// Reclaimers that trigger another reclaim are not re-entered
+		nested = Reclaim()
+		return 2
+	})
+
+	if got := Reclaim(); got != 2 || nested != 0 {
+		t.Fatalf("expected Reclaim to release 2 frames without nesting; got %d (nested: %d)", got, nested)
+	}
+
+	if len(calls) != 2 || calls[0] != 1 || calls[1] != 2 {
+		t.Fatalf("expected reclaimers to be invoked in registration order; got %v", calls)
+	}
+
+	errOOM := &kernel.Error{Module: "test", Message: "out of memory", Code: kernel.ErrCodeOutOfMemory}
+	specs := []struct {
+		err      *kernel.Error
+		pass     int
+		expRetry bool
+	}{
+		{nil, 0, false},
+		{&kernel.Error{Module: "test", Message: "invalid", Code: kernel.ErrCodeInvalidArgument}, 0, false},
+		{errOOM, 0, true},
+		{errOOM, maxReclaimPasses - 1, true},
+		{errOOM, maxReclaimPasses, false},
+	}
+
+	for specIndex, spec := range specs {
+		calls = nil
+		if got := RetryAfterReclaim(spec.err, spec.pass); got != spec.expRetry {
+			t.Errorf("[spec %d] expected RetryAfterReclaim to return %t; got %t", specIndex, spec.expRetry, got)
+		}
+
+		if spec.expRetry && len(calls) == 0 {
+			t.Errorf("[spec %d] expected reclaimers to be invoked", specIndex)
+		}
+	}
+
+	// Failed allocations are not retried if no memory was released
+	reclaimers = reclaimers[:1]
+	if RetryAfterReclaim(errOOM, 0) {
+		t.Errorf("expected RetryAfterReclaim to return false when no frames are released")
+	}
+
+	released1 = 1
+	if !RetryAfterReclaim(errOOM, 0) {
+		t.Errorf("expected RetryAfterReclaim to return true when frames are released")
+	}
+}
+
+func TestOutOfMemory(t *testing.T) {
+	defer func(orig []StatsCollector) { statsCollectors = orig }(statsCollectors)
+	statsCollectors = nil
+
+	expErr := &kernel.Error{Module: "test", Message: "out of memory", Code: kernel.ErrCodeOutOfMemory}
+	defer func() {
+		if err := recover(); err != expErr {
+			t.Fatalf("expected OutOfMemory to panic with %v; got %v", expErr, err)
+		}
+	}()
+
+	OutOfMemory(expErr)
+}
@@ -0,0 +1,290 @@
+package slab
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/ksyms"
+	"gopheros/multiboot"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+const (
+	// redzoneSize is the minimum number of guard bytes placed before and
+	// after each object of a debug cache.
+	redzoneSize = 16
+
+	// trackDepth is the number of call frames recorded for the last
+	// allocation and free of each object.
+	trackDepth = 8
+
+	// The byte patterns used for filling redzones and poisoning free
+	// objects.
+	redzoneByte = byte(0xbb)
+	poisonByte  = byte(0x6b)
+
+	// The values of objTrack.state for free and allocated objects.
+	objStateFree      = uint64(0xf7eef7eef7eef7ee)
+	objStateAllocated = uint64(0xa110ca7eda110ca7)
+
+	// validateInterval defines how often Poll validates the debug caches.
+	// If no clock source is available, the caches are instead validated
+	// every validatePollCount calls.
+	validateInterval  = uint64(1000000000)
+	validatePollCount = 1024
+)
+
+var (
+	errCorruption = &kernel.Error{Module: "slab", Message: "object cache corruption detected", Code: kernel.ErrCodeFault}
+
+	// The following functions are mocked by tests.
+	panicFn          = kfmt.Panic
+	nanosecondsFn    = clock.Nanoseconds
+	getBootCmdLineFn = multiboot.GetBootCmdLine
+
+	// debugCaches controls whether caches created via NewCache use the
+	// debug object layout.
+	debugCaches bool
+
+	lastValidation uint64
+	pollCount      uint32
+)
+
+// objTrack is stored after the free list link of each object in a debug
+// cache and records the object state and the call sites that last allocated
+// and freed the object.
+type objTrack struct {
+	state    uint64
+	allocPCs [trackDepth]uintptr
+	freePCs  [trackDepth]uintptr
+}
+
+// SetDebug controls whether caches that are subsequently created via
+// NewCache operate in debug mode. Debug caches surround each object with
+// redzones and poison objects when they are freed. The redzones and poison
+// patterns are verified whenever an object is allocated or freed and
+// periodically by Poll; any corruption triggers a kernel panic that reports
+// the call sites which last allocated and freed the affected object.
+//
+// Objects of caches with a constructor are not poisoned as that would
+// destroy their constructed state.
+func SetDebug(enabled bool) {
+	registryLock.Acquire()
+	debugCaches = enabled
+	registryLock.Release()
+}
+
+// Init enables debug mode for all caches if the kernel was booted with the
+// "slab_debug" command line option.
+func Init() {
+	if _, ok := getBootCmdLineFn()["slab_debug"]; ok {
+		SetDebug(true)
+	}
+}
+
+// Poll validates the objects of all debug caches once validateInterval has
+// elapsed since the last validation. It is meant to be invoked by the idle
+// task.
+func Poll() {
+	now := nanosecondsFn()
+	if now == 0 {
+		if pollCount++; pollCount < validatePollCount {
+			return
+		}
+		pollCount = 0
+	} else if now-lastValidation < validateInterval {
+		return
+	}
+
+	lastValidation = now
+	ValidateAll()
+}
+
+// ValidateAll validates the objects of all debug caches.
+func ValidateAll() {
+	registryLock.Acquire()
+	caches := registry
+	registryLock.Release()
+
+	for _, c := range caches {
+		c.Validate()
+	}
+}
+
+// Validate checks the redzones and poison patterns of all objects in the
+// cache and panics if any corruption is detected. It is a no-op for caches
+// that do not operate in debug mode.
+func (c *Cache) Validate() {
+	if !c.debug {
+		return
+	}
+
+	// Objects parked in the magazines are only protected by the magazine
+	// locks which must be acquired before the cache lock.
+	for cpu := range c.magazines {
+		c.magazines[cpu].lock.Acquire()
+	}
+	c.lock.Acquire()
+
+	for _, list := range []uintptr{c.partial, c.full} {
+		for slab := list; slab != listEnd; slab = header(slab).next {
+			for index := uintptr(0); index < uintptr(c.objsPerSlab); index++ {
+				obj := slab + c.firstObject + index*c.stride
+				switch c.track(obj).state {
+				case objStateFree:
+					c.checkFree(obj, "object modified while free")
+				case objStateAllocated:
+					c.checkRedzones(obj)
+				default:
+					c.reportCorruption(obj, obj+c.trackOffset, "object tracking data overwritten")
+				}
+			}
+		}
+	}
+
+	c.lock.Release()
+	for cpu := len(c.magazines) - 1; cpu >= 0; cpu-- {
+		c.magazines[cpu].lock.Release()
+	}
+}
+
+// track returns a pointer to the tracking data of obj.
+func (c *Cache) track(obj uintptr) *objTrack {
+	return (*objTrack)(unsafe.Pointer(obj + c.trackOffset))
+}
+
+// initDebugObject fills the redzones of a newly constructed object, poisons
+// its contents and marks it as free.
+func (c *Cache) initDebugObject(obj uintptr) {
+	kernel.Memset(obj-c.redzone, redzoneByte, c.redzone)
+	kernel.Memset(obj+c.objSize, redzoneByte, c.linkOffset-c.objSize)
+	if c.ctor == nil {
+		kernel.Memset(obj, poisonByte, c.objSize)
+	}
+
+	*c.track(obj) = objTrack{state: objStateFree}
+}
+
+// debugAlloc verifies that obj was not modified while it was free and marks
+// it as allocated by the caller of Alloc. The caller must hold either the
+// cache lock or the lock of the magazine that contained obj.
+func (c *Cache) debugAlloc(obj uintptr) {
+	t := c.track(obj)
+	if t.state != objStateFree {
+		c.reportCorruption(obj, obj+c.trackOffset, "allocating object that is not free")
+	}
+	c.checkFree(obj, "write after free")
+
+	t.state = objStateAllocated
+	// Skip runtime.Callers, debugAlloc and Alloc
+	for n := runtime.Callers(3, t.allocPCs[:]); n < trackDepth; n++ {
+		t.allocPCs[n] = 0
+	}
+}
+
+// debugFree verifies the redzones of obj, marks it as freed by the caller of
+// Free and poisons its contents. The caller must hold the lock of the
+// magazine that obj will be stored in.
+func (c *Cache) debugFree(obj uintptr) {
+	t := c.track(obj)
+	switch t.state {
+	case objStateAllocated:
+	case objStateFree:
+		c.reportCorruption(obj, obj, "double free")
+		return
+	default:
+		c.reportCorruption(obj, obj+c.trackOffset, "object tracking data overwritten")
+		return
+	}
+	c.checkRedzones(obj)
+
+	t.state = objStateFree
+	// Skip runtime.Callers, debugFree and Free
+	for n := runtime.Callers(3, t.freePCs[:]); n < trackDepth; n++ {
+		t.freePCs[n] = 0
+	}
+
+	if c.ctor == nil {
+		kernel.Memset(obj, poisonByte, c.objSize)
+	}
+}
+
+// checkFree verifies the redzones and the poison pattern of a free object.
+func (c *Cache) checkFree(obj uintptr, reason string) {
+	c.checkRedzones(obj)
+
+	if c.ctor == nil {
+		if addr, ok := verify(obj, c.objSize, poisonByte); !ok {
+			c.reportCorruption(obj, addr, reason)
+		}
+	}
+}
+
+// checkRedzones verifies that the redzones surrounding obj are intact.
+func (c *Cache) checkRedzones(obj uintptr) {
+	if addr, ok := verify(obj-c.redzone, c.redzone, redzoneByte); !ok {
+		c.reportCorruption(obj, addr, "redzone before object overwritten")
+	}
+
+	if addr, ok := verify(obj+c.objSize, c.linkOffset-c.objSize, redzoneByte); !ok {
+		c.reportCorruption(obj, addr, "redzone after object overwritten")
+	}
+}
+
+// reportCorruption prints the details of a corrupted object together with
+// the call sites that last allocated and freed it and panics.
+func (c *Cache) reportCorruption(obj, addr uintptr, reason string) {
+	w := kfmt.GetOutputSink()
+
+	kfmt.Printf("\n[slab] cache %s: %s at address 0x%16x (object 0x%16x, offset %d)\n", c.name, reason, addr, obj, int(addr-obj))
+
+	t := c.track(obj)
+	kfmt.Printf("\nLast allocated by:\n")
+	writeTrace(w, t.allocPCs[:])
+	kfmt.Printf("\nLast freed by:\n")
+	writeTrace(w, t.freePCs[:])
+
+	panicFn(errCorruption)
+}
+
+// writeTrace writes the symbolized call sites in pcs to w.
+func writeTrace(w io.Writer, pcs []uintptr) {
+	if pcs[0] == 0 {
+		io.WriteString(w, "  <none>\n")
+		return
+	}
+
+	for _, pc := range pcs {
+		if pc == 0 {
+			break
+		}
+
+		io.WriteString(w, "  ")
+		// Callers returns return addresses; subtract 1 so the address
+		// points inside the call instruction.
+		ksyms.WriteSymbol(w, pc-1)
+		io.WriteString(w, "\n")
+	}
+}
+
+// verify checks that size bytes starting at addr are set to val and returns
+// the address of the first mismatching byte.
+func verify(addr, size uintptr, val byte) (uintptr, bool) {
+	for ; size > 0; addr, size = addr+1, size-1 {
+		if *(*byte)(unsafe.Pointer(addr)) != val {
+			return addr, false
+		}
+	}
+
+	return 0, true
+}
+
+// cmdSlabCheck implements the "slabcheck" kshell command which validates the
+// objects of all debug caches.
+func cmdSlabCheck(w io.Writer, _ []string) *kernel.Error {
+	ValidateAll()
+	kfmt.Fprintf(w, "no corruption detected\n")
+	return nil
+}
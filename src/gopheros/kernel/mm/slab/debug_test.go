@@ -0,0 +1,284 @@
+package slab
+
+import (
+	"bytes"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/kfmt"
+	"gopheros/multiboot"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestDebugLayout(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	SetDebug(true)
+	defer SetDebug(false)
+
+	specs := []struct {
+		objSize, align uintptr
+		ctor           func(uintptr)
+		expRedzone     uintptr
+		expPoisoned    bool
+	}{
+		{24, 0, nil, redzoneSize, true},
+		{100, 64, nil, 64, true},
+		// Constructed objects are not poisoned
+		{24, 0, func(obj uintptr) { *(*byte)(unsafe.Pointer(obj)) = 0x42 }, redzoneSize, false},
+	}
+
+	for specIndex, spec := range specs {
+		c, err := NewCache("debug", spec.objSize, spec.align, spec.ctor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !c.debug || c.redzone != spec.expRedzone {
+			t.Errorf("[spec %d] expected a debug cache with a %d byte redzone; got debug: %t, redzone: %d", specIndex, spec.expRedzone, c.debug, c.redzone)
+			continue
+		}
+
+		obj, err := c.Alloc()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if spec.align != 0 && obj%spec.align != 0 {
+			t.Errorf("[spec %d] expected object 0x%x to be aligned to %d bytes", specIndex, obj, spec.align)
+		}
+
+		if _, ok := verify(obj-c.redzone, c.redzone, redzoneByte); !ok {
+			t.Errorf("[spec %d] expected redzone before the object to be filled", specIndex)
+		}
+
+		if _, ok := verify(obj+spec.objSize, redzoneSize, redzoneByte); !ok {
+			t.Errorf("[spec %d] expected redzone after the object to be filled", specIndex)
+		}
+
+		if c.track(obj).state != objStateAllocated || c.track(obj).allocPCs[0] == 0 {
+			t.Errorf("[spec %d] expected object to be tracked as allocated", specIndex)
+		}
+
+		if err = c.Free(obj); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, poisoned := verify(obj, spec.objSize, poisonByte); poisoned != spec.expPoisoned {
+			t.Errorf("[spec %d] expected freed object poisoning to be %t", specIndex, spec.expPoisoned)
+		}
+
+		if c.track(obj).state != objStateFree || c.track(obj).freePCs[0] == 0 {
+			t.Errorf("[spec %d] expected object to be tracked as free", specIndex)
+		}
+	}
+}
+
+func TestDebugCorruption(t *testing.T) {
+	defer func() {
+		panicFn = kfmt.Panic
+		kfmt.SetOutputSink(nil)
+		SetDebug(false)
+	}()
+
+	var (
+		buf    bytes.Buffer
+		panics int
+	)
+	panicFn = func(e interface{}) {
+		if e != errCorruption {
+			t.Errorf("expected panic with error %v; got %v", errCorruption, e)
+		}
+		panics++
+	}
+	kfmt.SetOutputSink(&buf)
+	SetDebug(true)
+
+	const objSize = 32
+
+	specs := []struct {
+		corrupt   func(c *Cache, obj uintptr)
+		expReason string
+		expFreed  bool
+	}{
+		// Buffer overflow detected when freeing the object
+		{
+			func(c *Cache, obj uintptr) {
+				*(*byte)(unsafe.Pointer(obj + objSize)) = 0
+				_ = c.Free(obj)
+			},
+			"redzone after object overwritten",
+			false,
+		},
+		// Buffer underflow detected when freeing the object
+		{
+			func(c *Cache, obj uintptr) {
+				*(*byte)(unsafe.Pointer(obj - 1)) = 0
+				_ = c.Free(obj)
+			},
+			"redzone before object overwritten",
+			false,
+		},
+		// Write after free detected when the object is reallocated
+		{
+			func(c *Cache, obj uintptr) {
+				_ = c.Free(obj)
+				*(*byte)(unsafe.Pointer(obj + 8)) = 0
+				_, _ = c.Alloc()
+			},
+			"write after free",
+			true,
+		},
+		{
+			func(c *Cache, obj uintptr) {
+				_ = c.Free(obj)
+				_ = c.Free(obj)
+			},
+			"double free",
+			true,
+		},
+		// Write after free detected by periodic validation
+		{
+			func(c *Cache, obj uintptr) {
+				_ = c.Free(obj)
+				*(*byte)(unsafe.Pointer(obj)) = 0
+				c.Validate()
+			},
+			"object modified while free",
+			true,
+		},
+		// Buffer overflow detected by periodic validation
+		{
+			func(c *Cache, obj uintptr) {
+				*(*byte)(unsafe.Pointer(obj + objSize + 4)) = 0
+				c.Validate()
+			},
+			"redzone after object overwritten",
+			false,
+		},
+	}
+
+	for specIndex, spec := range specs {
+		fake := newFakeMemory(t, 8)
+		buf.Reset()
+		panics = 0
+
+		c, _ := NewCache("corrupt", objSize, 0, nil)
+		obj, err := c.Alloc()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		spec.corrupt(c, obj)
+		fake.restore()
+
+		if panics != 1 {
+			t.Errorf("[spec %d] expected 1 panic; got %d", specIndex, panics)
+			continue
+		}
+
+		out := buf.String()
+		if !strings.Contains(out, "cache corrupt: "+spec.expReason) {
+			t.Errorf("[spec %d] expected report to contain reason %q; got:\n%s", specIndex, spec.expReason, out)
+		}
+
+		// The allocation call site is always known while the free call
+		// site is only known for objects that have been freed.
+		allocTrace := out[strings.Index(out, "Last allocated by:"):strings.Index(out, "Last freed by:")]
+		freeTrace := out[strings.Index(out, "Last freed by:"):]
+		if !strings.Contains(allocTrace, "slab.TestDebugCorruption") {
+			t.Errorf("[spec %d] expected allocation trace to contain the test function; got:\n%s", specIndex, allocTrace)
+		}
+
+		if freed := strings.Contains(freeTrace, "slab.TestDebugCorruption"); freed != spec.expFreed {
+			t.Errorf("[spec %d] expected free trace to contain the test function: %t; got:\n%s", specIndex, spec.expFreed, freeTrace)
+		}
+	}
+}
+
+func TestPoll(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer func() {
+		fake.restore()
+		panicFn = kfmt.Panic
+		nanosecondsFn = clock.Nanoseconds
+		kfmt.SetOutputSink(nil)
+		SetDebug(false)
+		lastValidation, pollCount = 0, 0
+	}()
+
+	var panics int
+	panicFn = func(interface{}) { panics++ }
+	kfmt.SetOutputSink(&bytes.Buffer{})
+	SetDebug(true)
+
+	c, _ := NewCache("poll", 64, 0, nil)
+	obj, _ := c.Alloc()
+	*(*byte)(unsafe.Pointer(obj + 64)) = 0
+
+	specs := []struct {
+		now       uint64
+		polls     int
+		expPanics int
+	}{
+		{validateInterval / 2, 1, 0},
+		{validateInterval, 1, 1},
+		{validateInterval + 1, 1, 0},
+		// Without a clock source, validation is triggered by the number
+		// of Poll calls
+		{0, validatePollCount - 1, 0},
+		{0, 1, 1},
+	}
+
+	for specIndex, spec := range specs {
+		panics = 0
+		nanosecondsFn = func() uint64 { return spec.now }
+		for i := 0; i < spec.polls; i++ {
+			Poll()
+		}
+
+		if panics != spec.expPanics {
+			t.Errorf("[spec %d] expected %d panics; got %d", specIndex, spec.expPanics, panics)
+		}
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer func() {
+		getBootCmdLineFn = multiboot.GetBootCmdLine
+		SetDebug(false)
+	}()
+
+	for specIndex, cmdLine := range []map[string]string{{}, {"slab_debug": "slab_debug"}} {
+		getBootCmdLineFn = func() map[string]string { return cmdLine }
+		Init()
+
+		if _, exp := cmdLine["slab_debug"]; debugCaches != exp {
+			t.Errorf("[spec %d] expected debug mode to be %t; got %t", specIndex, exp, debugCaches)
+		}
+	}
+}
+
+func TestCmdSlabCheck(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer func() {
+		fake.restore()
+		SetDebug(false)
+	}()
+
+	SetDebug(true)
+	c, _ := NewCache("slabcheck", 64, 0, nil)
+	if _, err := c.Alloc(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cmdSlabCheck(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp := "no corruption detected\n"; buf.String() != exp {
+		t.Fatalf("expected output %q; got %q", exp, buf.String())
+	}
+}
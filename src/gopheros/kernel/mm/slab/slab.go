@@ -0,0 +1,596 @@
+// Package slab implements an object-cache allocator that is layered on top of
+// the physical frame allocator. Each cache hands out fixed-size objects that
+// are carved out of slabs: naturally aligned blocks of contiguous frames that
+// are obtained via pmm.AllocFrames. Frequently allocated objects of the same
+// type can thus be served from dedicated caches without fragmenting the
+// general purpose heap.
+//
+// Each cache maintains a per-CPU magazine of recently freed objects; most
+// allocation and free requests are served by the magazine of the current CPU
+// without touching the cache-wide lock or the slab lists.
+//
+// When debug mode is enabled (see SetDebug), newly created caches surround
+// their objects with redzones and poison freed objects so that buffer
+// overflows and use-after-free bugs are detected.
+//
+// Cache objects live outside the Go heap and are never scanned by the garbage
+// collector. Consequently, they must not hold the only reference to memory
+// allocated by the Go runtime.
+package slab
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/faultinj"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/pmm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/kernel/sync"
+	"io"
+	"unsafe"
+)
+
+// MaxCPUs defines the maximum number of CPUs with a dedicated magazine.
+const MaxCPUs = 64
+
+const (
+	// magazineSize is the number of objects that can be stored in each
+	// per-CPU magazine.
+	magazineSize = 16
+
+	// minObjectsPerSlab is the minimum number of objects that a slab
+	// should contain. The slab order is increased until this many objects
+	// fit in a slab or maxSlabOrder is reached.
+	minObjectsPerSlab = 8
+	maxSlabOrder      = 3
+
+	// slabMagic marks the header of each slab.
+	slabMagic = uint32(0x51ab51ab)
+
+	// listEnd terminates the slab and object lists.
+	listEnd = uintptr(0)
+)
+
+var (
+	errInvalidAlignment = &kernel.Error{Module: "slab", Message: "object alignment must be a power of 2", Code: kernel.ErrCodeInvalidArgument}
+	errObjectTooLarge   = &kernel.Error{Module: "slab", Message: "object size exceeds the maximum slab size", Code: kernel.ErrCodeInvalidArgument}
+	errInvalidObject    = &kernel.Error{Module: "slab", Message: "object was not allocated by this cache", Code: kernel.ErrCodeInvalidArgument}
+	errOutOfMemory      = &kernel.Error{Module: "slab", Message: "out of memory", Code: kernel.ErrCodeOutOfMemory}
+
+	// allocFault allows tests to simulate object allocation failures.
+	allocFault = faultinj.NewSite("slab/alloc")
+
+	// The following functions are used by tests to mock calls to the
+	// pmm and vmm packages.
+	allocFramesFn   = pmm.AllocFrames
+	freeFramesFn    = pmm.FreeFrames
+	reserveRegionFn = vmm.EarlyReserveRegion
+	mapFn           = vmm.Map
+	unmapFn         = vmm.Unmap
+
+	// cpuIDFn returns the index of the CPU executing the caller. If nil,
+	// the system is assumed to be uniprocessor.
+	cpuIDFn func() uint32
+
+	// registry tracks all caches created via NewCache.
+	registryLock sync.Spinlock
+	registry     []*Cache
+)
+
+// SetCPUs configures the function for obtaining the index of the current
+// CPU. The function must return values in the range [0, MaxCPUs).
+func SetCPUs(cpuID func() uint32) {
+	cpuIDFn = cpuID
+}
+
+// slabHeader is stored at the beginning of each slab.
+type slabHeader struct {
+	magic   uint32
+	cacheID uint32
+
+	// inUse counts the allocated slab objects, including the ones that
+	// are parked in a magazine.
+	inUse uint32
+
+	// The first frame of the slab.
+	frame mm.Frame
+
+	// The addresses of the previous and next slab in the list that
+	// contains this slab.
+	prev, next uintptr
+
+	// The address of the first free object in the slab.
+	freeList uintptr
+}
+
+// magazine caches freed objects for a single CPU.
+type magazine struct {
+	lock   sync.Spinlock
+	count  int
+	rounds [magazineSize]uintptr
+}
+
+// Cache allocates objects of a fixed size.
+type Cache struct {
+	id   uint32
+	name string
+
+	// The size requested by the cache user and the distance between
+	// consecutive objects in a slab.
+	objSize uintptr
+	stride  uintptr
+
+	// The offset of the free list link from the start of each free
+	// object. If the cache has a constructor the link is stored after
+	// the object so the constructed object state is preserved.
+	linkOffset uintptr
+
+	// The offset of the first object from the slab start.
+	firstObject uintptr
+
+	// Debug caches place a redzone of the specified size before each
+	// object; the redzone after each object extends up to the free list
+	// link which is followed by the object tracking data.
+	debug       bool
+	redzone     uintptr
+	trackOffset uintptr
+
+	ctor func(obj uintptr)
+
+	order       uint8
+	slabSize    uintptr
+	objsPerSlab uint32
+
+	lock sync.Spinlock
+
+	// partial contains the slabs with at least one free object while
+	// full contains the slabs with no free objects.
+	partial uintptr
+	full    uintptr
+
+	// spareRegions contains the virtual address ranges of released slabs
+	// which are reused when the cache grows.
+	spareRegions []uintptr
+
+	slabCount     uint32
+	activeObjects uint32
+
+	magazines [MaxCPUs]magazine
+}
+
+// NewCache creates a cache for objects with the specified size and
+// alignment. If align is 0, objects are aligned to the machine word size. If
+// ctor is not nil, it is invoked for each object when the slab containing it
+// is allocated; objects must be returned to their constructed state before
+// being passed to Free.
+func NewCache(name string, objSize, align uintptr, ctor func(obj uintptr)) (*Cache, *kernel.Error) {
+	if align == 0 {
+		align = unsafe.Sizeof(uintptr(0))
+	}
+
+	if align&(align-1) != 0 {
+		return nil, errInvalidAlignment
+	}
+
+	registryLock.Acquire()
+	debug := debugCaches
+	registryLock.Release()
+
+	c := &Cache{
+		name:    name,
+		objSize: objSize,
+		ctor:    ctor,
+		debug:   debug,
+	}
+
+	// Reserve space for the free list link
+	switch {
+	case debug:
+		c.redzone = alignUp(redzoneSize, align)
+		c.linkOffset = alignUp(objSize, unsafe.Sizeof(uintptr(0))) + redzoneSize
+		c.trackOffset = c.linkOffset + unsafe.Sizeof(uintptr(0))
+		c.stride = alignUp(c.trackOffset+unsafe.Sizeof(objTrack{})+c.redzone, align)
+	case ctor != nil:
+		c.linkOffset = alignUp(objSize, unsafe.Sizeof(uintptr(0)))
+		c.stride = alignUp(c.linkOffset+unsafe.Sizeof(uintptr(0)), align)
+	default:
+		c.stride = alignUp(maxUintptr(objSize, unsafe.Sizeof(uintptr(0))), align)
+	}
+	c.firstObject = alignUp(unsafe.Sizeof(slabHeader{}), align) + c.redzone
+
+	for c.order = 0; ; c.order++ {
+		c.slabSize = mm.PageSize << c.order
+		if c.slabSize > c.firstObject {
+			c.objsPerSlab = uint32((c.slabSize - c.firstObject) / c.stride)
+		}
+
+		if c.objsPerSlab >= minObjectsPerSlab || c.order == maxSlabOrder {
+			break
+		}
+	}
+
+	if c.objsPerSlab == 0 {
+		return nil, errObjectTooLarge
+	}
+
+	registryLock.Acquire()
+	c.id = uint32(len(registry)) + 1
+	registry = append(registry, c)
+	registryLock.Release()
+
+	return c, nil
+}
+
+// Name returns the cache name.
+func (c *Cache) Name() string {
+	return c.name
+}
+
+// Alloc returns the address of a free object from the cache. If the cache has
+// no free objects, a new slab is allocated.
+func (c *Cache) Alloc() (uintptr, *kernel.Error) {
+	if allocFault.ShouldFail() {
+		return 0, errOutOfMemory
+	}
+
+	mag := c.currentMagazine()
+	mag.lock.Acquire()
+	if mag.count > 0 {
+		mag.count--
+		obj := mag.rounds[mag.count]
+		if c.debug {
+			c.debugAlloc(obj)
+		}
+		mag.lock.Release()
+		return obj, nil
+	}
+	mag.lock.Release()
+
+	c.lock.Acquire()
+	defer c.lock.Release()
+
+	if c.partial == listEnd {
+		if err := c.grow(); err != nil {
+			return 0, err
+		}
+	}
+
+	obj := c.allocFromSlab()
+	if c.debug {
+		c.debugAlloc(obj)
+	}
+	return obj, nil
+}
+
+// Free returns an object previously obtained via a call to Alloc to the
+// cache.
+func (c *Cache) Free(obj uintptr) *kernel.Error {
+	if !c.owns(obj) {
+		return errInvalidObject
+	}
+
+	mag := c.currentMagazine()
+	mag.lock.Acquire()
+	defer mag.lock.Release()
+
+	if c.debug {
+		c.debugFree(obj)
+	}
+
+	// If the magazine is full, return half of its objects to their slabs
+	if mag.count == magazineSize {
+		c.lock.Acquire()
+		for ; mag.count > magazineSize/2; mag.count-- {
+			c.freeToSlab(mag.rounds[mag.count-1])
+		}
+		c.lock.Release()
+	}
+
+	mag.rounds[mag.count] = obj
+	mag.count++
+	return nil
+}
+
+// Shrink returns the objects parked in the per-CPU magazines to their slabs
+// and releases the frames of all slabs without allocated objects back to the
+// frame allocator.
+func (c *Cache) Shrink() *kernel.Error {
+	_, err := c.shrink(false)
+	return err
+}
+
+// shrink implements Shrink and returns the number of released frames. If
+// nonBlocking is set, magazines whose locks are held are skipped and shrink
+// returns without releasing any slabs if the cache lock is held.
+func (c *Cache) shrink(nonBlocking bool) (uint64, *kernel.Error) {
+	// Magazine locks must always be acquired before the cache lock
+	for cpu := range c.magazines {
+		mag := &c.magazines[cpu]
+		if !acquire(&mag.lock, nonBlocking) {
+			continue
+		}
+		if !acquire(&c.lock, nonBlocking) {
+			mag.lock.Release()
+			continue
+		}
+		for ; mag.count > 0; mag.count-- {
+			c.freeToSlab(mag.rounds[mag.count-1])
+		}
+		c.lock.Release()
+		mag.lock.Release()
+	}
+
+	if !acquire(&c.lock, nonBlocking) {
+		return 0, nil
+	}
+	defer c.lock.Release()
+
+	var released uint64
+	for slab := c.partial; slab != listEnd; {
+		hdr := header(slab)
+		next := hdr.next
+		if hdr.inUse == 0 {
+			if err := c.release(slab); err != nil {
+				return released, err
+			}
+			released += uint64(1) << c.order
+		}
+		slab = next
+	}
+
+	return released, nil
+}
+
+// reapCaches implements mm.Reclaimer. It shrinks the registered caches
+// without blocking on locks held by the allocating code and returns the
+// number of released frames.
+func reapCaches() uint64 {
+	if !registryLock.TryToAcquire() {
+		return 0
+	}
+	defer registryLock.Release()
+
+	var released uint64
+	for _, c := range registry {
+		frames, _ := c.shrink(true)
+		released += frames
+	}
+
+	return released
+}
+
+// acquire acquires lock and returns true. If nonBlocking is set, acquire
+// returns false instead of waiting for a held lock to be released.
+func acquire(lock *sync.Spinlock, nonBlocking bool) bool {
+	if nonBlocking {
+		return lock.TryToAcquire()
+	}
+
+	lock.Acquire()
+	return true
+}
+
+// currentMagazine returns the magazine for the current CPU.
+func (c *Cache) currentMagazine() *magazine {
+	if cpuIDFn == nil {
+		return &c.magazines[0]
+	}
+
+	return &c.magazines[cpuIDFn()]
+}
+
+// owns returns true if obj points to an object in one of the cache slabs.
+func (c *Cache) owns(obj uintptr) bool {
+	slab := obj &^ (c.slabSize - 1)
+	if obj < slab+c.firstObject || (obj-slab-c.firstObject)%c.stride != 0 {
+		return false
+	}
+
+	hdr := header(slab)
+	return hdr.magic == slabMagic && hdr.cacheID == c.id && uint32((obj-slab-c.firstObject)/c.stride) < c.objsPerSlab
+}
+
+// allocFromSlab removes an object from the first partial slab. The caller
+// must hold the cache lock and ensure that a partial slab exists.
+func (c *Cache) allocFromSlab() uintptr {
+	slab := c.partial
+	hdr := header(slab)
+
+	obj := hdr.freeList
+	hdr.freeList = *(*uintptr)(unsafe.Pointer(obj + c.linkOffset))
+	hdr.inUse++
+	c.activeObjects++
+
+	if hdr.inUse == c.objsPerSlab {
+		c.unlink(&c.partial, slab)
+		c.push(&c.full, slab)
+	}
+
+	return obj
+}
+
+// freeToSlab returns an object to the slab that contains it. The caller must
+// hold the cache lock.
+func (c *Cache) freeToSlab(obj uintptr) {
+	slab := obj &^ (c.slabSize - 1)
+	hdr := header(slab)
+
+	if hdr.inUse == c.objsPerSlab {
+		c.unlink(&c.full, slab)
+		c.push(&c.partial, slab)
+	}
+
+	*(*uintptr)(unsafe.Pointer(obj + c.linkOffset)) = hdr.freeList
+	hdr.freeList = obj
+	hdr.inUse--
+	c.activeObjects--
+}
+
+// grow allocates a new slab, constructs its objects and adds it to the
+// partial slab list. The caller must hold the cache lock.
+func (c *Cache) grow() *kernel.Error {
+	frame, err := allocFramesFn(c.order)
+	if err != nil {
+		return err
+	}
+
+	var slab uintptr
+	if spareCount := len(c.spareRegions); spareCount != 0 {
+		slab = c.spareRegions[spareCount-1]
+		c.spareRegions = c.spareRegions[:spareCount-1]
+	} else {
+		// Reserve twice the slab size so that a naturally aligned slab
+		// can be placed inside the reserved region. This allows Free
+		// to locate the slab header by masking the object address.
+		region, err := reserveRegionFn(2 * c.slabSize)
+		if err != nil {
+			_ = freeFramesFn(frame, c.order)
+			return err
+		}
+		slab = alignUp(region, c.slabSize)
+	}
+
+	for page, index := mm.PageFromAddress(slab), uintptr(0); index < c.slabSize>>mm.PageShift; page, index = page+1, index+1 {
+		if err = mapFn(page, frame+mm.Frame(index), vmm.FlagPresent|vmm.FlagRW|vmm.FlagNoExecute); err != nil {
+			c.spareRegions = append(c.spareRegions, slab)
+			_ = freeFramesFn(frame, c.order)
+			return err
+		}
+	}
+
+	hdr := header(slab)
+	*hdr = slabHeader{
+		magic:   slabMagic,
+		cacheID: c.id,
+		frame:   frame,
+	}
+
+	for index := int(c.objsPerSlab) - 1; index >= 0; index-- {
+		obj := slab + c.firstObject + uintptr(index)*c.stride
+		if c.ctor != nil {
+			c.ctor(obj)
+		}
+		if c.debug {
+			c.initDebugObject(obj)
+		}
+
+		*(*uintptr)(unsafe.Pointer(obj + c.linkOffset)) = hdr.freeList
+		hdr.freeList = obj
+	}
+
+	c.push(&c.partial, slab)
+	c.slabCount++
+	return nil
+}
+
+// release unmaps a slab without allocated objects and returns its frames to
+// the frame allocator. The caller must hold the cache lock.
+func (c *Cache) release(slab uintptr) *kernel.Error {
+	hdr := header(slab)
+	frame := hdr.frame
+
+	c.unlink(&c.partial, slab)
+	hdr.magic = 0
+
+	for page, index := mm.PageFromAddress(slab), uintptr(0); index < c.slabSize>>mm.PageShift; page, index = page+1, index+1 {
+		if err := unmapFn(page); err != nil {
+			return err
+		}
+	}
+
+	c.spareRegions = append(c.spareRegions, slab)
+	c.slabCount--
+	return freeFramesFn(frame, c.order)
+}
+
+// push inserts slab at the head of the specified list.
+func (c *Cache) push(list *uintptr, slab uintptr) {
+	hdr := header(slab)
+	hdr.prev, hdr.next = listEnd, *list
+	if hdr.next != listEnd {
+		header(hdr.next).prev = slab
+	}
+	*list = slab
+}
+
+// unlink removes slab from the specified list.
+func (c *Cache) unlink(list *uintptr, slab uintptr) {
+	hdr := header(slab)
+	if hdr.prev != listEnd {
+		header(hdr.prev).next = hdr.next
+	} else {
+		*list = hdr.next
+	}
+
+	if hdr.next != listEnd {
+		header(hdr.next).prev = hdr.prev
+	}
+}
+
+// header returns a pointer to the header of the slab at the specified
+// address.
+func header(slab uintptr) *slabHeader {
+	return (*slabHeader)(unsafe.Pointer(slab))
+}
+
+func alignUp(val, align uintptr) uintptr {
+	return (val + align - 1) &^ (align - 1)
+}
+
+func maxUintptr(a, b uintptr) uintptr {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// collectStats implements mm.StatsCollector. It appends the usage of each
+// registered cache to stats.
+func collectStats(stats *mm.MemStats) {
+	registryLock.Acquire()
+	defer registryLock.Release()
+
+	for _, c := range registry {
+		c.lock.Acquire()
+		stats.Caches = append(stats.Caches, mm.CacheStats{
+			Name:          c.name,
+			ObjectSize:    uint64(c.objSize),
+			ActiveObjects: uint64(c.activeObjects),
+			TotalObjects:  uint64(c.slabCount) * uint64(c.objsPerSlab),
+			Slabs:         uint64(c.slabCount),
+			Bytes:         uint64(c.slabCount) * uint64(c.slabSize),
+		})
+		c.lock.Release()
+	}
+}
+
+// cmdSlabInfo implements the "slabinfo" kshell command which lists the
+// statistics of each cache.
+func cmdSlabInfo(w io.Writer, _ []string) *kernel.Error {
+	registryLock.Acquire()
+	defer registryLock.Release()
+
+	for _, c := range registry {
+		kfmt.Fprintf(w, "%s: objsize %d, active %d/%d, slabs %d (order %d)\n",
+			c.name, c.objSize, c.activeObjects, c.slabCount*c.objsPerSlab, c.slabCount, c.order,
+		)
+	}
+	return nil
+}
+
+func init() {
+	mm.RegisterStatsCollector(collectStats)
+	mm.RegisterReclaimer(reapCaches)
+
+	kshell.RegisterCommand(&kshell.Command{
+		Name: "slabinfo",
+		Help: "list object cache statistics",
+		Fn:   cmdSlabInfo,
+	})
+	kshell.RegisterCommand(&kshell.Command{
+		Name: "slabcheck",
+		Help: "validate the objects of all debug caches",
+		Fn:   cmdSlabCheck,
+	})
+}
@@ -0,0 +1,506 @@
+package slab
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/faultinj"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/pmm"
+	"gopheros/kernel/mm/vmm"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestNewCache(t *testing.T) {
+	specs := []struct {
+		objSize, align uintptr
+		ctor           func(uintptr)
+		expErr         *kernel.Error
+		expStride      uintptr
+		expOrder       uint8
+	}{
+		{1, 0, nil, nil, 8, 0},
+		{24, 16, nil, nil, 32, 0},
+		// The free list link is stored after constructed objects
+		{24, 0, func(uintptr) {}, nil, 32, 0},
+		{512, 0, nil, nil, 512, 1},
+		// The slab header leaves room for only 7 objects in an order 1 slab
+		{1024, 0, nil, nil, 1024, 2},
+		// Only 7 objects fit in a slab of maxSlabOrder
+		{4096, 0, nil, nil, 4096, maxSlabOrder},
+		{3, 3, nil, errInvalidAlignment, 0, 0},
+		{mm.PageSize << maxSlabOrder, 0, nil, errObjectTooLarge, 0, 0},
+	}
+
+	for specIndex, spec := range specs {
+		c, err := NewCache("test", spec.objSize, spec.align, spec.ctor)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if c.stride != spec.expStride {
+			t.Errorf("[spec %d] expected stride to be %d; got %d", specIndex, spec.expStride, c.stride)
+		}
+
+		if c.order != spec.expOrder {
+			t.Errorf("[spec %d] expected slab order to be %d; got %d", specIndex, spec.expOrder, c.order)
+		}
+
+		if exp := uint32((c.slabSize - c.firstObject) / c.stride); c.objsPerSlab != exp {
+			t.Errorf("[spec %d] expected %d objects per slab; got %d", specIndex, exp, c.objsPerSlab)
+		}
+
+		if c.Name() != "test" {
+			t.Errorf("[spec %d] expected cache name to be %q; got %q", specIndex, "test", c.Name())
+		}
+	}
+}
+
+func TestAllocFree(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	const ctorMagic = uint64(0xc0ffee)
+	c, err := NewCache("obj64", 64, 0, func(obj uintptr) {
+		*(*uint64)(unsafe.Pointer(obj)) = ctorMagic
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allocate enough objects to fill one slab and spill into a second one
+	objCount := int(c.objsPerSlab) + 1
+	objs := make(map[uintptr]bool)
+	for i := 0; i < objCount; i++ {
+		obj, err := c.Alloc()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if objs[obj] {
+			t.Fatalf("object 0x%x was allocated twice", obj)
+		}
+		objs[obj] = true
+
+		if got := *(*uint64)(unsafe.Pointer(obj)); got != ctorMagic {
+			t.Fatalf("expected object 0x%x to be constructed; got 0x%x", obj, got)
+		}
+	}
+
+	if c.slabCount != 2 || fake.allocCount != 2 {
+		t.Fatalf("expected cache to allocate 2 slabs; got %d (%d frame allocations)", c.slabCount, fake.allocCount)
+	}
+
+	if c.full == listEnd || c.partial == listEnd {
+		t.Fatal("expected cache to contain a full and a partial slab")
+	}
+
+	var last uintptr
+	for obj := range objs {
+		if err := c.Free(obj); err != nil {
+			t.Fatal(err)
+		}
+		last = obj
+	}
+
+	// The magazine keeps between magazineSize/2 and magazineSize objects;
+	// the remaining ones are returned to their slabs
+	if count := c.magazines[0].count; count < magazineSize/2 || count > magazineSize {
+		t.Fatalf("expected magazine to hold between %d and %d objects; got %d", magazineSize/2, magazineSize, count)
+	}
+
+	if exp := uint32(c.magazines[0].count); c.activeObjects != exp {
+		t.Fatalf("expected %d active objects; got %d", exp, c.activeObjects)
+	}
+
+	if c.full != listEnd {
+		t.Fatal("expected full slab to be moved to the partial list")
+	}
+
+	// Magazines operate in LIFO order
+	if obj, _ := c.Alloc(); obj != last {
+		t.Fatalf("expected to get the last freed object 0x%x; got 0x%x", last, obj)
+	}
+}
+
+func TestFreeInvalidObject(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	c1, _ := NewCache("c1", 64, 0, nil)
+	c2, _ := NewCache("c2", 64, 0, nil)
+
+	obj, err := c1.Alloc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		cache *Cache
+		obj   uintptr
+	}{
+		// Object belongs to another cache
+		{c2, obj},
+		// Misaligned object
+		{c1, obj + 1},
+		// Slab header
+		{c1, obj &^ (c1.slabSize - 1)},
+	}
+
+	for specIndex, spec := range specs {
+		if err := spec.cache.Free(spec.obj); err != errInvalidObject {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, errInvalidObject, err)
+		}
+	}
+
+	if err := c1.Free(obj); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestShrink(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	c, _ := NewCache("obj512", 512, 0, nil)
+
+	var objs []uintptr
+	for i := 0; i < 3*int(c.objsPerSlab); i++ {
+		obj, err := c.Alloc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, obj)
+	}
+
+	// Keep one object allocated so that its slab cannot be released
+	for _, obj := range objs[1:] {
+		if err := c.Free(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := c.Shrink(); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.slabCount != 1 || c.activeObjects != 1 {
+		t.Fatalf("expected 1 slab with 1 active object; got %d slabs and %d active objects", c.slabCount, c.activeObjects)
+	}
+
+	if exp := 2 << c.order; fake.freeCount != 2 || fake.unmapCount != exp {
+		t.Fatalf("expected 2 slabs to be released and %d pages unmapped; got %d and %d", exp, fake.freeCount, fake.unmapCount)
+	}
+
+	// Growing the cache should reuse the released virtual regions
+	reserveCount := fake.reserveCount
+	for i := 0; i < 2*int(c.objsPerSlab); i++ {
+		if _, err := c.Alloc(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if fake.reserveCount != reserveCount {
+		t.Fatalf("expected released regions to be reused; got %d new reservations", fake.reserveCount-reserveCount)
+	}
+
+	t.Run("unmap error", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "unmap failed"}
+		unmapFn = func(mm.Page) *kernel.Error { return expErr }
+
+		c, _ := NewCache("unmap", 512, 0, nil)
+		obj, _ := c.Alloc()
+		_ = c.Free(obj)
+
+		if err := c.Shrink(); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestReapCaches(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	idle, _ := NewCache("idle", 512, 0, nil)
+	busy, _ := NewCache("busy", 512, 0, nil)
+	for _, c := range []*Cache{idle, busy} {
+		obj, err := c.Alloc()
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = c.Free(obj)
+	}
+
+	// The lock of a cache that is being grown by the allocating code is
+	// held while the reclaimers run
+	busy.lock.Acquire()
+	released := reapCaches()
+	busy.lock.Release()
+
+	if exp := uint64(1) << idle.order; released != exp {
+		t.Errorf("expected %d frames to be released; got %d", exp, released)
+	}
+
+	if idle.slabCount != 0 || busy.slabCount != 1 {
+		t.Errorf("expected only the idle cache to be reaped; got %d and %d slabs", idle.slabCount, busy.slabCount)
+	}
+
+	// Reapers invoked while the registry is locked do not block
+	registryLock.Acquire()
+	released = reapCaches()
+	registryLock.Release()
+
+	if released != 0 || busy.slabCount != 1 {
+		t.Errorf("expected no caches to be reaped while the registry is locked; got %d released frames", released)
+	}
+
+	if released = mm.Reclaim(); released != uint64(1)<<busy.order || busy.slabCount != 0 {
+		t.Errorf("expected mm.Reclaim to reap the remaining cache; got %d released frames", released)
+	}
+}
+
+func TestGrowErrors(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	expErr := &kernel.Error{Module: "test", Message: "something went wrong"}
+	c, _ := NewCache("grow", 64, 0, nil)
+
+	t.Run("frame allocation fails", func(t *testing.T) {
+		defer func(fn func(uint8) (mm.Frame, *kernel.Error)) { allocFramesFn = fn }(allocFramesFn)
+		allocFramesFn = func(uint8) (mm.Frame, *kernel.Error) { return mm.InvalidFrame, expErr }
+
+		if _, err := c.Alloc(); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+
+	t.Run("region reservation fails", func(t *testing.T) {
+		defer func(fn func(uintptr) (uintptr, *kernel.Error)) { reserveRegionFn = fn }(reserveRegionFn)
+		reserveRegionFn = func(uintptr) (uintptr, *kernel.Error) { return 0, expErr }
+
+		freeCount := fake.freeCount
+		if _, err := c.Alloc(); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if fake.freeCount != freeCount+1 {
+			t.Fatal("expected allocated frames to be released")
+		}
+	})
+
+	t.Run("mapping fails", func(t *testing.T) {
+		defer func(fn func(mm.Page, mm.Frame, vmm.PageTableEntryFlag) *kernel.Error) { mapFn = fn }(mapFn)
+		mapFn = func(mm.Page, mm.Frame, vmm.PageTableEntryFlag) *kernel.Error { return expErr }
+
+		freeCount := fake.freeCount
+		if _, err := c.Alloc(); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if fake.freeCount != freeCount+1 || len(c.spareRegions) != 1 {
+			t.Fatal("expected allocated frames to be released and the reserved region to be kept for reuse")
+		}
+	})
+
+	if c.slabCount != 0 {
+		t.Fatalf("expected no slabs to be allocated; got %d", c.slabCount)
+	}
+}
+
+func TestAllocFaultInjection(t *testing.T) {
+	defer func() {
+		_ = faultinj.Configure(allocFault.Name(), faultinj.ModeOff, 0)
+	}()
+
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	c, err := NewCache("fault", 64, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := faultinj.Configure(allocFault.Name(), faultinj.ModeEveryNth, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	var allocated int
+	for i, expErr := range []*kernel.Error{nil, errOutOfMemory, nil, errOutOfMemory} {
+		obj, err := c.Alloc()
+		if err != expErr {
+			t.Errorf("[call %d] expected error %v; got %v", i, expErr, err)
+			continue
+		}
+
+		if err == nil {
+			if obj == 0 {
+				t.Errorf("[call %d] expected a non-nil object", i)
+			}
+			allocated++
+		}
+	}
+
+	if c.slabCount != 1 {
+		t.Fatalf("expected injected failures not to allocate any slabs; got %d", c.slabCount)
+	}
+
+	if got := int(c.activeObjects); got != allocated {
+		t.Fatalf("expected %d active objects; got %d", allocated, got)
+	}
+}
+
+func TestPerCPUMagazines(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	var curCPU uint32
+	SetCPUs(func() uint32 { return curCPU })
+	defer SetCPUs(nil)
+
+	c, _ := NewCache("percpu", 64, 0, nil)
+
+	obj, err := c.Alloc()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	curCPU = 1
+	if err = c.Free(obj); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.magazines[1].count != 1 || c.magazines[0].count != 0 {
+		t.Fatal("expected object to be parked in the magazine of CPU 1")
+	}
+
+	curCPU = 0
+	if got, _ := c.Alloc(); got == obj {
+		t.Fatal("expected CPU 0 not to allocate an object parked in the magazine of CPU 1")
+	}
+
+	curCPU = 1
+	if got, _ := c.Alloc(); got != obj {
+		t.Fatalf("expected CPU 1 to allocate object 0x%x from its magazine; got 0x%x", obj, got)
+	}
+}
+
+func TestCmdSlabInfo(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	c, _ := NewCache("slabinfo-test", 64, 0, nil)
+	if _, err := c.Alloc(); err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := cmdSlabInfo(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	exp := "slabinfo-test: objsize 64, active 1/63, slabs 1 (order 0)\n"
+	if !strings.Contains(buf.String(), exp) {
+		t.Fatalf("expected output to contain %q; got:\n%s", exp, buf.String())
+	}
+}
+
+func TestCollectStats(t *testing.T) {
+	fake := newFakeMemory(t, 8)
+	defer fake.restore()
+
+	c, _ := NewCache("stats-test", 512, 0, nil)
+	for i := 0; i < int(c.objsPerSlab)+1; i++ {
+		if _, err := c.Alloc(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stats mm.MemStats
+	collectStats(&stats)
+
+	exp := mm.CacheStats{
+		Name:          "stats-test",
+		ObjectSize:    512,
+		ActiveObjects: uint64(c.objsPerSlab) + 1,
+		TotalObjects:  2 * uint64(c.objsPerSlab),
+		Slabs:         2,
+		Bytes:         2 * uint64(c.slabSize),
+	}
+
+	if got := stats.Caches[len(stats.Caches)-1]; got != exp {
+		t.Fatalf("expected cache stats to be %+v; got %+v", exp, got)
+	}
+}
+
+// fakeMemory backs the virtual regions reserved by the slab allocator with a
+// Go buffer.
+type fakeMemory struct {
+	buf  []byte
+	next uintptr
+	end  uintptr
+
+	nextFrame mm.Frame
+
+	// The number of registered caches when the arena was created. Caches
+	// registered afterwards are dropped when the arena is released.
+	registryLen int
+
+	allocCount, freeCount, reserveCount, unmapCount int
+}
+
+// newFakeMemory allocates an arena that can hold the specified number of
+// slabs of maxSlabOrder and installs mocks for the pmm and vmm functions.
+func newFakeMemory(t *testing.T, slabs int) *fakeMemory {
+	maxSlabSize := mm.PageSize << maxSlabOrder
+	f := &fakeMemory{
+		buf:         make([]byte, uintptr(2*slabs+1)*maxSlabSize),
+		nextFrame:   1,
+		registryLen: len(registry),
+	}
+	f.next = alignUp(uintptr(unsafe.Pointer(&f.buf[0])), maxSlabSize)
+	f.end = uintptr(unsafe.Pointer(&f.buf[0])) + uintptr(len(f.buf))
+
+	allocFramesFn = func(order uint8) (mm.Frame, *kernel.Error) {
+		f.allocCount++
+		frame := f.nextFrame
+		f.nextFrame += mm.Frame(1) << order
+		return frame, nil
+	}
+	freeFramesFn = func(mm.Frame, uint8) *kernel.Error {
+		f.freeCount++
+		return nil
+	}
+	reserveRegionFn = func(size uintptr) (uintptr, *kernel.Error) {
+		if f.next+size > f.end {
+			t.Fatal("fake memory arena exhausted")
+		}
+		f.reserveCount++
+		region := f.next
+		f.next += size
+		return region, nil
+	}
+	mapFn = func(mm.Page, mm.Frame, vmm.PageTableEntryFlag) *kernel.Error { return nil }
+	unmapFn = func(mm.Page) *kernel.Error {
+		f.unmapCount++
+		return nil
+	}
+
+	return f
+}
+
+func (f *fakeMemory) restore() {
+	allocFramesFn = pmm.AllocFrames
+	freeFramesFn = pmm.FreeFrames
+	reserveRegionFn = vmm.EarlyReserveRegion
+	mapFn = vmm.Map
+	unmapFn = vmm.Unmap
+	registry = registry[:f.registryLen]
+}
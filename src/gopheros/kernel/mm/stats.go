@@ -0,0 +1,123 @@
+package mm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
+	"gopheros/kernel/sync"
+	"io"
+)
+
+var (
+	statsLock       sync.Spinlock
+	statsCollectors []StatsCollector
+)
+
+// ZoneStats describes the frame usage of a physical memory zone.
+type ZoneStats struct {
+	Name string
+
+	// The number of frames managed by the frame allocator and the number
+	// of frames that are currently free.
+	TotalFrames uint64
+	FreeFrames  uint64
+
+	// AllocatedBlocks contains the number of allocated blocks of
+	// 1 << order frames for each allocation order.
+	AllocatedBlocks []uint64
+}
+
+// CacheStats describes the usage of an object cache.
+type CacheStats struct {
+	Name       string
+	ObjectSize uint64
+
+	// The number of allocated objects and the number of objects that fit
+	// in the slabs of the cache.
+	ActiveObjects uint64
+	TotalObjects  uint64
+
+	// The number of slabs and the bytes of memory they occupy.
+	Slabs uint64
+	Bytes uint64
+}
+
+// MemStats contains a snapshot of the kernel memory usage. Each memory
+// subsystem fills in its own fields via a StatsCollector.
+type MemStats struct {
+	// Zones contains the frame allocator statistics for each zone.
+	Zones []ZoneStats
+
+	// Caches contains the statistics for each object cache.
+	Caches []CacheStats
+
+	// The number of regions allocated via the kernel region allocator and
+	// the bytes of memory backed by frames that the regions own or borrow
+	// from their callers.
+	VmallocRegions     uint64
+	VmallocBytes       uint64
+	VmallocMappedBytes uint64
+}
+
+// StatsCollector is a function that populates the MemStats fields of a
+// memory subsystem.
+type StatsCollector func(stats *MemStats)
+
+// RegisterStatsCollector registers a function that will be invoked by Stats
+// to collect the statistics of a memory subsystem.
+func RegisterStatsCollector(collector StatsCollector) {
+	statsLock.Acquire()
+	statsCollectors = append(statsCollectors, collector)
+	statsLock.Release()
+}
+
+// Stats returns a snapshot of the kernel memory usage as reported by the
+// registered collectors.
+func Stats() MemStats {
+	var stats MemStats
+
+	statsLock.Acquire()
+	collectors := statsCollectors
+	statsLock.Release()
+
+	for _, collector := range collectors {
+		collector(&stats)
+	}
+
+	return stats
+}
+
+// cmdMemInfo implements the "meminfo" kshell command which displays a
+// breakdown of the kernel memory usage.
+func cmdMemInfo(w io.Writer, _ []string) *kernel.Error {
+	stats := Stats()
+
+	kfmt.Fprintf(w, "zones:\n")
+	for _, zone := range stats.Zones {
+		kfmt.Fprintf(w, "  %s: free %d/%d frames, allocated blocks by order:", zone.Name, zone.FreeFrames, zone.TotalFrames)
+		for order, count := range zone.AllocatedBlocks {
+			kfmt.Fprintf(w, " %d:%d", order, count)
+		}
+		kfmt.Fprintf(w, "\n")
+	}
+
+	kfmt.Fprintf(w, "caches:\n")
+	for _, cache := range stats.Caches {
+		kfmt.Fprintf(w, "  %s: objsize %d, active %d/%d, slabs %d (%d bytes)\n",
+			cache.Name, cache.ObjectSize, cache.ActiveObjects, cache.TotalObjects, cache.Slabs, cache.Bytes,
+		)
+	}
+
+	kfmt.Fprintf(w, "vmalloc: %d regions, %d bytes allocated, %d bytes mapped\n",
+		stats.VmallocRegions, stats.VmallocBytes, stats.VmallocMappedBytes,
+	)
+	return nil
+}
+
+func init() {
+	kshell.RegisterCommand(&kshell.Command{
+		Name: "meminfo",
+		Help: "display kernel memory usage statistics",
+		Fn:   cmdMemInfo,
+	})
+}
@@ -0,0 +1,49 @@
+package mm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	defer func(orig []StatsCollector) { statsCollectors = orig }(statsCollectors)
+	statsCollectors = nil
+
+	RegisterStatsCollector(func(stats *MemStats) {
+		stats.Zones = append(stats.Zones, ZoneStats{Name: "dma", TotalFrames: 16, FreeFrames: 8, AllocatedBlocks: []uint64{2, 3}})
+	})
+	RegisterStatsCollector(func(stats *MemStats) {
+		stats.Caches = append(stats.Caches, CacheStats{Name: "task", ObjectSize: 64, ActiveObjects: 3, TotalObjects: 63, Slabs: 1, Bytes: 4096})
+	})
+	RegisterStatsCollector(func(stats *MemStats) {
+		stats.VmallocRegions, stats.VmallocBytes, stats.VmallocMappedBytes = 2, 3*4096, 4096
+	})
+
+	exp := MemStats{
+		Zones:              []ZoneStats{{Name: "dma", TotalFrames: 16, FreeFrames: 8, AllocatedBlocks: []uint64{2, 3}}},
+		Caches:             []CacheStats{{Name: "task", ObjectSize: 64, ActiveObjects: 3, TotalObjects: 63, Slabs: 1, Bytes: 4096}},
+		VmallocRegions:     2,
+		VmallocBytes:       12288,
+		VmallocMappedBytes: 4096,
+	}
+
+	if got := Stats(); !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected stats to be %+v; got %+v", exp, got)
+	}
+
+	var buf bytes.Buffer
+	if err := cmdMemInfo(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	expOut := `zones:
+  dma: free 8/16 frames, allocated blocks by order: 0:2 1:3
+caches:
+  task: objsize 64, active 3/63, slabs 1 (4096 bytes)
+vmalloc: 2 regions, 12288 bytes allocated, 4096 bytes mapped
+`
+	if got := buf.String(); got != expOut {
+		t.Fatalf("expected output:\n%s\ngot:\n%s", expOut, got)
+	}
+}
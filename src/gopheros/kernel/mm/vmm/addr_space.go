@@ -12,7 +12,7 @@ var (
 	// space.
 	earlyReserveLastUsed = tempMappingAddr
 
-	errEarlyReserveNoSpace = &kernel.Error{Module: "early_reserve", Message: "remaining virtual address space not large enough to satisfy reservation request"}
+	errEarlyReserveNoSpace = &kernel.Error{Module: "early_reserve", Message: "remaining virtual address space not large enough to satisfy reservation request", Code: kernel.ErrCodeOutOfMemory}
 )
 
 // EarlyReserveRegion reserves a page-aligned contiguous virtual memory region
@@ -33,3 +33,19 @@ func EarlyReserveRegion(size uintptr) (uintptr, *kernel.Error) {
 	earlyReserveLastUsed -= size
 	return earlyReserveLastUsed, nil
 }
+
+// earlyReserveAlignedRegion behaves like EarlyReserveRegion but ensures that
+// the returned address is congruent to offset modulo align. The align argument
+// must be a power of 2 and a multiple of mm.PageSize.
+func earlyReserveAlignedRegion(size, align, offset uintptr) (uintptr, *kernel.Error) {
+	size = (size + (mm.PageSize - 1)) & ^(mm.PageSize - 1)
+	offset &= align - 1
+
+	// reserving a region of the requested size will cause an underflow
+	if size+offset > earlyReserveLastUsed {
+		return 0, errEarlyReserveNoSpace
+	}
+
+	earlyReserveLastUsed = ((earlyReserveLastUsed - size - offset) &^ (align - 1)) + offset
+	return earlyReserveLastUsed, nil
+}
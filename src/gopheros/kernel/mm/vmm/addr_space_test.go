@@ -1,6 +1,7 @@
 package vmm
 
 import (
+	"gopheros/kernel"
 	"runtime"
 	"testing"
 )
@@ -27,3 +28,41 @@ func TestEarlyReserveAmd64(t *testing.T) {
 		t.Fatalf("expected to get errEarlyReserveNoSpace; got %v", err)
 	}
 }
+
+func TestEarlyReserveAlignedAmd64(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	defer func(origLastUsed uintptr) {
+		earlyReserveLastUsed = origLastUsed
+	}(earlyReserveLastUsed)
+
+	const align = uintptr(2 << 20)
+
+	specs := []struct {
+		lastUsed, size, offset uintptr
+		exp                    uintptr
+		expErr                 *kernel.Error
+	}{
+		{5 * align, 4096, 0, 4 * align, nil},
+		{5 * align, 4096, 0x1000, 4*align + 0x1000, nil},
+		{5*align + 0x3000, 0x2000, 0x1000, 5*align + 0x1000, nil},
+		// offset is taken modulo align
+		{5 * align, align, align + 0x1000, 3*align + 0x1000, nil},
+		{align, align, 0x1000, 0, errEarlyReserveNoSpace},
+	}
+
+	for specIndex, spec := range specs {
+		earlyReserveLastUsed = spec.lastUsed
+		got, err := earlyReserveAlignedRegion(spec.size, align, spec.offset)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if err == nil && (got != spec.exp || earlyReserveLastUsed != spec.exp) {
+			t.Errorf("[spec %d] expected reserved address to be 0x%x; got 0x%x", specIndex, spec.exp, got)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+	"unsafe"
+)
+
+// maxAddressSpaceRegions defines the max number of regions that can be mapped
+// into the lower half of an address space.
+const maxAddressSpaceRegions = 64
+
+// asRegion describes a range of pages in the lower half of an address space.
+type asRegion struct {
+	start, end mm.Page
+	flags      PageTableEntryFlag
+
+	// borrowed is set for regions established by MapFrames or MapShared
+	// whose frames are owned by the caller or the shared region and must
+	// not be released by FreeRegion.
+	borrowed bool
+
+	// shared points to the shared region mapped via MapShared.
+	shared *SharedRegion
+}
+
+// AddressSpace describes a virtual address space with its own PDT. The upper
+// half of each address space maps the kernel and is shared by all address
+// spaces while the lower half contains the regions that have been mapped into
+// this particular address space.
+type AddressSpace struct {
+	pdt PageDirectoryTable
+
+	lock        sync.Spinlock
+	regions     [maxAddressSpaceRegions]asRegion
+	regionCount int
+}
+
+var (
+	// kernelAddressSpace wraps the kernel PDT that is set up by Init.
+	kernelAddressSpace AddressSpace
+
+	// kernelTablesShared is set once shareKernelTables has populated all
+	// kernel half entries of the PDT.
+	kernelTablesShared     bool
+	kernelTablesSharedLock sync.Spinlock
+
+	// copyBuf is used by copyFrame to copy the contents of a frame to
+	// another frame via the temporary mapping. It is guarded by copyLock.
+	copyLock sync.Spinlock
+	copyBuf  [mm.PageSize]byte
+
+	errAddressSpaceRange       = &kernel.Error{Module: "vmm", Message: "region must reside in the lower half of the address space", Code: kernel.ErrCodeInvalidArgument}
+	errAddressSpaceOverlap     = &kernel.Error{Module: "vmm", Message: "region overlaps with an already mapped region", Code: kernel.ErrCodeInvalidArgument}
+	errAddressSpaceRegionLimit = &kernel.Error{Module: "vmm", Message: "max number of address space regions exceeded", Code: kernel.ErrCodeOutOfMemory}
+	errAddressSpaceInUse       = &kernel.Error{Module: "vmm", Message: "address space is in use and cannot be destroyed", Code: kernel.ErrCodeBusy}
+)
+
+// KernelAddressSpace returns the address space that uses the kernel PDT set up
+// by Init.
+func KernelAddressSpace() *AddressSpace {
+	return &kernelAddressSpace
+}
+
+// NewAddressSpace creates a new address space with an empty lower half. The
+// upper half of the new address space shares the kernel page tables so any
+// kernel mappings established after this call are visible in all address
+// spaces.
+func NewAddressSpace() (*AddressSpace, *kernel.Error) {
+	if err := shareKernelTables(); err != nil {
+		return nil, err
+	}
+
+	pdtFrame, err := mm.AllocFrame()
+	if err != nil {
+		return nil, err
+	}
+
+	as := &AddressSpace{}
+	if err = as.pdt.Init(pdtFrame); err != nil {
+		_ = mm.FreeFrame(pdtFrame)
+		return nil, err
+	}
+
+	pdtPage, err := mapTemporaryFn(pdtFrame)
+	if err != nil {
+		_ = mm.FreeFrame(pdtFrame)
+		return nil, err
+	}
+
+	// Copy the kernel half entries from the active PDT; the last entry
+	// has already been set up by Init for the recursive mapping
+	for index := kernelHalfFirstEntry(); index < (1<<pageLevelBits[0])-1; index++ {
+		offset := index << mm.PointerShift
+		*(*pageTableEntry)(unsafe.Pointer(pdtPage.Address() + offset)) = *(*pageTableEntry)(ptePtrFn(pdtVirtualAddr + offset))
+	}
+	_ = unmapFn(pdtPage)
+
+	return as, nil
+}
+
+// Switch activates the address space on the current CPU.
+func (as *AddressSpace) Switch() {
+	as.pdt.Activate()
+}
+
+// AllocRegion maps an anonymous zero-filled region of the requested size
+// starting at startPage in the lower half of the address space. If size is not
+// a multiple of mm.PageSize it will be automatically rounded up. The region
+// must not overlap any region that is already mapped into the address space.
+//
+// No physical memory is reserved for the region; instead, all its pages are
+// mapped to ReservedZeroedFrame. Pages of writable regions are flagged as
+// copy-on-write so that a private frame is only allocated the first time each
+// page is written to. Private frames are released when the region is freed.
+func (as *AddressSpace) AllocRegion(startPage mm.Page, size uintptr, flags PageTableEntryFlag) *kernel.Error {
+	as.lock.Acquire()
+	defer as.lock.Release()
+
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	if err := as.addRegion(startPage, pageCount, flags, false); err != nil {
+		return err
+	}
+
+	for mapped := uintptr(0); mapped < pageCount; mapped++ {
+		page := startPage + mm.Page(mapped)
+		if err := as.pdt.Map(page, ReservedZeroedFrame, zeroedFrameFlags(page, flags)); err != nil {
+			as.unmapPages(startPage, mapped, false)
+			as.removeRegion(as.regionCount - 1)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// MapFrames maps the physically contiguous memory that starts at frame to a
+// region of the requested size starting at startPage in the lower half of the
+// address space. If size is not a multiple of mm.PageSize it will be
+// automatically rounded up. Unlike AllocRegion, the frames remain owned by the
+// caller and are not released when the region is freed.
+func (as *AddressSpace) MapFrames(startPage mm.Page, frame mm.Frame, size uintptr, flags PageTableEntryFlag) *kernel.Error {
+	as.lock.Acquire()
+	defer as.lock.Release()
+
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	if err := as.addRegion(startPage, pageCount, flags, true); err != nil {
+		return err
+	}
+
+	for mapped := uintptr(0); mapped < pageCount; mapped++ {
+		if err := as.pdt.Map(startPage+mm.Page(mapped), frame+mm.Frame(mapped), flags|FlagPresent); err != nil {
+			as.unmapPages(startPage, mapped, false)
+			as.removeRegion(as.regionCount - 1)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// FreeRegion unmaps the region starting at startPage that was previously
+// mapped via a call to AllocRegion, MapFrames or MapShared. The physical
+// frames of regions mapped via AllocRegion are also released.
+func (as *AddressSpace) FreeRegion(startPage mm.Page) *kernel.Error {
+	as.lock.Acquire()
+	defer as.lock.Release()
+
+	index := as.findRegion(startPage)
+	if index < 0 {
+		return errVMAreaInvalidRegion
+	}
+
+	as.releaseRegion(index)
+	return nil
+}
+
+// Clone creates a new address space with the same regions as this address
+// space. The contents of the private frames of regions mapped via AllocRegion
+// are copied to newly allocated frames while pages that have not been written
+// to yet and regions mapped via MapFrames or MapShared share their frames with
+// the original address space.
+func (as *AddressSpace) Clone() (*AddressSpace, *kernel.Error) {
+	clone, err := NewAddressSpace()
+	if err != nil {
+		return nil, err
+	}
+
+	as.lock.Acquire()
+	defer as.lock.Release()
+
+	for index := 0; index < as.regionCount; index++ {
+		if err = clone.cloneRegion(as, as.regions[index]); err != nil {
+			_ = clone.Destroy()
+			return nil, err
+		}
+	}
+
+	return clone, nil
+}
+
+// Destroy unmaps all regions of the address space and releases its lower half
+// page tables and its PDT. The kernel address space and the active address
+// space cannot be destroyed.
+func (as *AddressSpace) Destroy() *kernel.Error {
+	if as == &kernelAddressSpace || as.pdt.pdtFrame.Address() == activePDTFn() {
+		return errAddressSpaceInUse
+	}
+
+	as.lock.Acquire()
+	defer as.lock.Release()
+
+	for as.regionCount > 0 {
+		as.releaseRegion(as.regionCount - 1)
+	}
+
+	freePageTables(as.pdt.pdtFrame, 0, kernelHalfFirstEntry())
+	_ = mm.FreeFrame(as.pdt.pdtFrame)
+	as.pdt.pdtFrame = 0
+	return nil
+}
+
+// cloneRegion maps a copy of region from the src address space into the
+// address space. Callers must hold the src lock.
+func (as *AddressSpace) cloneRegion(src *AddressSpace, region asRegion) *kernel.Error {
+	if err := as.addRegion(region.start, uintptr(region.end-region.start), region.flags, region.borrowed); err != nil {
+		return err
+	}
+
+	if region.shared != nil {
+		as.regions[as.regionCount-1].shared = region.shared
+		region.shared.acquire()
+	}
+
+	for page := region.start; page < region.end; page++ {
+		var physAddr uintptr
+		if err := src.pdt.run(func() (err *kernel.Error) {
+			physAddr, err = translateFn(page.Address())
+			return err
+		}); err != nil {
+			continue
+		}
+
+		frame, flags := mm.FrameFromAddress(physAddr), region.flags|FlagPresent
+		if isReservedZeroedFrame(frame) {
+			flags = zeroedFrameFlags(page, region.flags)
+		} else if !region.borrowed {
+			srcFrame := frame
+
+			var err *kernel.Error
+			if frame, err = mm.AllocFrame(); err != nil {
+				return err
+			}
+
+			if err = copyFrame(srcFrame, frame); err != nil {
+				_ = mm.FreeFrame(frame)
+				return err
+			}
+		}
+
+		if err := as.pdt.Map(page, frame, flags); err != nil {
+			if !region.borrowed && !isReservedZeroedFrame(frame) {
+				_ = mm.FreeFrame(frame)
+			}
+			return err
+		}
+	}
+
+	return nil
+}
+
+// releaseRegion unmaps the region at the specified index, releases its frames
+// unless they are borrowed and removes it from the region list. Callers must
+// hold the address space lock.
+func (as *AddressSpace) releaseRegion(index int) {
+	region := as.regions[index]
+	as.unmapPages(region.start, uintptr(region.end-region.start), !region.borrowed)
+	as.removeRegion(index)
+
+	if region.shared != nil {
+		region.shared.release()
+	}
+}
+
+// unmapPages unmaps pageCount pages starting at startPage from the address
+// space and, if freeFrames is true, releases the physical frames they point
+// to. Callers must hold the address space lock.
+func (as *AddressSpace) unmapPages(startPage mm.Page, pageCount uintptr, freeFrames bool) {
+	_ = as.pdt.run(func() *kernel.Error {
+		unmapRegionPages(startPage, pageCount, freeFrames)
+		return nil
+	})
+}
+
+// addRegion appends a region with pageCount pages starting at startPage to
+// the region list. Callers must hold the address space lock.
+func (as *AddressSpace) addRegion(startPage mm.Page, pageCount uintptr, flags PageTableEntryFlag, borrowed bool) *kernel.Error {
+	if pageCount == 0 {
+		return errVMAreaEmpty
+	}
+
+	region := asRegion{
+		start:    startPage,
+		end:      startPage + mm.Page(pageCount),
+		flags:    flags,
+		borrowed: borrowed,
+	}
+
+	if region.end < region.start || region.end > mm.PageFromAddress(userHalfEnd) {
+		return errAddressSpaceRange
+	}
+
+	if as.regionCount == maxAddressSpaceRegions {
+		return errAddressSpaceRegionLimit
+	}
+
+	for index := 0; index < as.regionCount; index++ {
+		if region.start < as.regions[index].end && as.regions[index].start < region.end {
+			return errAddressSpaceOverlap
+		}
+	}
+
+	as.regions[as.regionCount] = region
+	as.regionCount++
+	return nil
+}
+
+// removeRegion removes the region at the specified index from the region
+// list. Callers must hold the address space lock.
+func (as *AddressSpace) removeRegion(index int) {
+	copy(as.regions[index:as.regionCount-1], as.regions[index+1:as.regionCount])
+	as.regionCount--
+}
+
+// findRegion returns the index of the region that starts at startPage or -1
+// if no such region exists. Callers must hold the address space lock.
+func (as *AddressSpace) findRegion(startPage mm.Page) int {
+	for index := 0; index < as.regionCount; index++ {
+		if as.regions[index].start == startPage {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// kernelHalfFirstEntry returns the index of the first PDT entry that maps the
+// upper half of the address space.
+func kernelHalfFirstEntry() uintptr {
+	return (kernelHalfStart >> pageLevelShifts[0]) & ((1 << pageLevelBits[0]) - 1)
+}
+
+// shareKernelTables allocates a page table for each kernel half entry of the
+// active PDT that is not yet present. As these entries never change once
+// populated, the address spaces that copy them share all kernel mappings
+// including the ones established after they were created.
+func shareKernelTables() *kernel.Error {
+	kernelTablesSharedLock.Acquire()
+	defer kernelTablesSharedLock.Release()
+
+	if kernelTablesShared {
+		return nil
+	}
+
+	// Skip the last entry which is used for the recursive mapping
+	for index := kernelHalfFirstEntry(); index < (1<<pageLevelBits[0])-1; index++ {
+		pte := (*pageTableEntry)(ptePtrFn(pdtVirtualAddr + (index << mm.PointerShift)))
+		if pte.HasFlags(FlagPresent) {
+			continue
+		}
+
+		tableFrame, err := mm.AllocFrame()
+		if err != nil {
+			return err
+		}
+
+		if err = zeroFrame(tableFrame); err != nil {
+			_ = mm.FreeFrame(tableFrame)
+			return err
+		}
+
+		*pte = 0
+		pte.SetFrame(tableFrame)
+		pte.SetFlags(FlagPresent | FlagRW)
+	}
+
+	kernelTablesShared = true
+	return nil
+}
+
+// freePageTables releases the page tables that are referenced by the first
+// entryCount entries of the page table stored in tableFrame. The level
+// argument specifies the page level of the table. Frames mapped by the table
+// entries are not released.
+func freePageTables(tableFrame mm.Frame, level uint8, entryCount uintptr) {
+	tablePage, err := mapTemporaryFn(tableFrame)
+	for index := uintptr(0); err == nil && index < entryCount; index++ {
+		pte := *(*pageTableEntry)(unsafe.Pointer(tablePage.Address() + (index << mm.PointerShift)))
+		if !pte.HasFlags(FlagPresent) || pte.HasFlags(FlagHugePage) {
+			continue
+		}
+
+		// Entries of the last page level map frames instead of tables.
+		// As the temporary mapping is reused for the child table, it
+		// needs to be re-established once the child has been processed.
+		if level+1 < pageLevels-1 {
+			freePageTables(pte.Frame(), level+1, 1<<pageLevelBits[level+1])
+			tablePage, err = mapTemporaryFn(tableFrame)
+		}
+
+		_ = mm.FreeFrame(pte.Frame())
+	}
+
+	if err == nil {
+		_ = unmapFn(tablePage)
+	}
+}
+
+// zeroFrame clears the contents of the supplied frame.
+func zeroFrame(frame mm.Frame) *kernel.Error {
+	page, err := mapTemporaryFn(frame)
+	if err != nil {
+		return err
+	}
+
+	kernel.Memset(page.Address(), 0, mm.PageSize)
+	_ = unmapFn(page)
+	return nil
+}
+
+// copyFrame copies the contents of the src frame to the dst frame.
+func copyFrame(src, dst mm.Frame) *kernel.Error {
+	copyLock.Acquire()
+	defer copyLock.Release()
+
+	// Only a single frame can be temporarily mapped at any time so the
+	// contents are copied via an intermediate buffer
+	bufAddr := uintptr(unsafe.Pointer(&copyBuf[0]))
+
+	page, err := mapTemporaryFn(src)
+	if err != nil {
+		return err
+	}
+	kernel.Memcopy(page.Address(), bufAddr, mm.PageSize)
+	_ = unmapFn(page)
+
+	if page, err = mapTemporaryFn(dst); err != nil {
+		return err
+	}
+	kernel.Memcopy(bufAddr, page.Address(), mm.PageSize)
+	_ = unmapFn(page)
+
+	return nil
+}
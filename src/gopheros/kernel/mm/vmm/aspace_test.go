@@ -0,0 +1,433 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+// setupAddressSpaceTest installs a set of fake page tables whose P4 table is
+// the active PDT and tracks the frames released via mm.FreeFrame.
+func setupAddressSpaceTest(t *testing.T) (*fakePageTables, *[mm.PageSize >> mm.PointerShift]pageTableEntry, map[mm.Frame]int, func()) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	// Room for the kernel half tables allocated by shareKernelTables
+	f := newFakePageTables(300)
+	p4, restore := f.install()
+
+	p4Addr := uintptr(unsafe.Pointer(p4))
+	p4[len(p4)-1].SetFrame(mm.FrameFromAddress(p4Addr))
+	p4[len(p4)-1].SetFlags(FlagPresent | FlagRW)
+	activePDTFn = func() uintptr { return p4Addr }
+
+	origZeroedFrame := ReservedZeroedFrame
+	ReservedZeroedFrame = mm.Frame(0x77)
+	protectReservedZeroedPage = true
+
+	freed := make(map[mm.Frame]int)
+	mm.SetFrameReleaser(func(frame mm.Frame) *kernel.Error {
+		freed[frame]++
+		return nil
+	})
+
+	return f, p4, freed, func() {
+		restore()
+		mm.SetFrameReleaser(nil)
+		activePDTFn = cpu.ActivePDT
+		switchPDTFn = cpu.SwitchPDT
+		kernelTablesShared = false
+		ReservedZeroedFrame = origZeroedFrame
+		protectReservedZeroedPage = false
+	}
+}
+
+// lookupIn performs a Lookup using the page tables of the supplied address
+// space.
+func lookupIn(as *AddressSpace, virtAddr uintptr) (mapping Mapping, err *kernel.Error) {
+	_ = as.pdt.run(func() *kernel.Error {
+		mapping, err = Lookup(virtAddr)
+		return nil
+	})
+	return mapping, err
+}
+
+func TestNewAddressSpace(t *testing.T) {
+	f, p4, _, restore := setupAddressSpaceTest(t)
+	defer restore()
+
+	// Populate a lower half and a kernel half entry of the active PDT
+	if err := Map(mm.Page(1), mm.Frame(1), FlagPresent|FlagRW); err != nil {
+		t.Fatal(err)
+	}
+	if err := Map(mm.PageFromAddress(kernelHalfStart), mm.Frame(2), FlagPresent|FlagRW); err != nil {
+		t.Fatal(err)
+	}
+	kernelP3 := p4[kernelHalfFirstEntry()]
+
+	as, err := NewAddressSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !kernelTablesShared {
+		t.Fatal("expected kernel tables to be shared")
+	}
+
+	lastEntry := len(p4) - 1
+	pdt := f.table(as.pdt.pdtFrame.Address())
+	for index := range pdt {
+		switch {
+		case index == lastEntry:
+			if !pdt[index].HasFlags(FlagPresent|FlagRW) || pdt[index].Frame() != as.pdt.pdtFrame {
+				t.Errorf("expected last PDT entry to be set up for recursive mapping; got 0x%x", pdt[index])
+			}
+		case uintptr(index) < kernelHalfFirstEntry():
+			if pdt[index] != 0 {
+				t.Errorf("expected lower half PDT entry %d to be empty; got 0x%x", index, pdt[index])
+			}
+		case !pdt[index].HasFlags(FlagPresent) || pdt[index] != p4[index]:
+			t.Errorf("expected kernel half PDT entry %d to match the active PDT entry 0x%x; got 0x%x", index, p4[index], pdt[index])
+		}
+	}
+
+	if p4[kernelHalfFirstEntry()] != kernelP3 {
+		t.Error("expected populated kernel half entries to remain unchanged")
+	}
+
+	if mapping, err := lookupIn(as, kernelHalfStart); err != nil || mapping.PhysAddr != mm.Frame(2).Address() {
+		t.Errorf("expected kernel mapping to be visible in the new address space; got %+v, %v", mapping, err)
+	}
+
+	if _, err := lookupIn(as, mm.Page(1).Address()); err != ErrInvalidMapping {
+		t.Errorf("expected lower half mapping not to be visible in the new address space; got %v", err)
+	}
+
+	// Subsequent address spaces only require a PDT frame
+	tablesBefore := f.next
+	if _, err = NewAddressSpace(); err != nil {
+		t.Fatal(err)
+	}
+
+	if exp, got := int(mm.PageSize>>mm.PointerShift), f.next-tablesBefore; got != exp {
+		t.Errorf("expected a single table to be allocated; got %d entries", got)
+	}
+
+	t.Run("allocation failure", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "out of memory"}
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) { return 0, expErr })
+
+		if _, err := NewAddressSpace(); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestAddressSpaceRegions(t *testing.T) {
+	f, _, freed, restore := setupAddressSpaceTest(t)
+	defer restore()
+
+	as, err := NewAddressSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	userFlags := FlagRW | FlagUserAccessible | FlagNoExecute
+	if err = as.AllocRegion(mm.Page(0x10), 2*mm.PageSize, userFlags); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = as.MapFrames(mm.Page(0x20), mm.Frame(0x1234), mm.PageSize+1, FlagRW|FlagUserAccessible); err != nil {
+		t.Fatal(err)
+	}
+
+	// Allocated regions are lazily backed by the reserved zeroed frame
+	expFlags := FlagPresent | FlagCopyOnWrite | FlagUserAccessible | FlagNoExecute
+	for _, page := range []mm.Page{0x10, 0x11} {
+		mapping, err := lookupIn(as, page.Address())
+		if err != nil || mapping.Flags != expFlags || mapping.PhysAddr != ReservedZeroedFrame.Address() {
+			t.Errorf("expected page 0x%x to be mapped to the reserved zeroed frame with flags 0x%x; got %+v, %v", page, expFlags, mapping, err)
+		}
+	}
+
+	for page, expFrame := range map[mm.Page]mm.Frame{0x20: 0x1234, 0x21: 0x1235} {
+		if mapping, err := lookupIn(as, page.Address()); err != nil || mapping.PhysAddr != expFrame.Address() {
+			t.Errorf("expected page 0x%x to be mapped to frame 0x%x; got %+v, %v", page, expFrame, mapping, err)
+		}
+	}
+
+	// User-accessible pages must be accessible at all page levels
+	_ = as.pdt.run(func() *kernel.Error {
+		walk(mm.Page(0x10).Address(), func(pteLevel uint8, pte *pageTableEntry) bool {
+			if !pte.HasFlags(FlagUserAccessible) {
+				t.Errorf("expected page table entry at level %d to be user-accessible", pteLevel)
+			}
+			return true
+		})
+		return nil
+	})
+
+	specs := []struct {
+		start  mm.Page
+		size   uintptr
+		expErr *kernel.Error
+	}{
+		{0x30, 0, errVMAreaEmpty},
+		{0x11, mm.PageSize, errAddressSpaceOverlap},
+		{0x0f, 2 * mm.PageSize, errAddressSpaceOverlap},
+		{mm.PageFromAddress(userHalfEnd) - 1, 2 * mm.PageSize, errAddressSpaceRange},
+		{mm.PageFromAddress(kernelHalfStart), mm.PageSize, errAddressSpaceRange},
+	}
+
+	for specIndex, spec := range specs {
+		if err := as.AllocRegion(spec.start, spec.size, userFlags); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if err = as.FreeRegion(mm.Page(0x11)); err != errVMAreaInvalidRegion {
+		t.Errorf("expected to get error %v; got %v", errVMAreaInvalidRegion, err)
+	}
+
+	// Simulate a write fault that populates a page with a private frame
+	if err = as.pdt.Map(mm.Page(0x11), mm.Frame(0x99), userFlags|FlagPresent); err != nil {
+		t.Fatal(err)
+	}
+
+	// Freeing a region releases its private frames unless they are borrowed
+	unmapped := make(map[mm.Page]bool)
+	unmapFn = func(page mm.Page) *kernel.Error {
+		unmapped[page] = true
+		return nil
+	}
+
+	for _, page := range []mm.Page{0x10, 0x20} {
+		if err = as.FreeRegion(page); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, page := range []mm.Page{0x10, 0x11, 0x20, 0x21} {
+		if !unmapped[page] {
+			t.Errorf("expected page 0x%x to be unmapped", page)
+		}
+	}
+
+	if len(freed) != 1 || freed[0x99] != 1 {
+		t.Errorf("expected only the private frame of the allocated region to be freed; got %v", freed)
+	}
+
+	if as.regionCount != 0 {
+		t.Errorf("expected region list to be empty; got %d regions", as.regionCount)
+	}
+
+	t.Run("region limit", func(t *testing.T) {
+		for page := mm.Page(0x100); as.regionCount < maxAddressSpaceRegions; page += 2 {
+			if err := as.MapFrames(page, mm.Frame(page), mm.PageSize, FlagRW); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if err := as.MapFrames(mm.Page(0x1000), 0, mm.PageSize, FlagRW); err != errAddressSpaceRegionLimit {
+			t.Fatalf("expected to get error %v; got %v", errAddressSpaceRegionLimit, err)
+		}
+	})
+
+	t.Run("allocation failure", func(t *testing.T) {
+		as.regionCount = 0
+
+		// Allow the page tables for the first 2 pages to be allocated;
+		// the third page requires a new last level page table
+		var (
+			expErr     = &kernel.Error{Module: "test", Message: "out of memory"}
+			allocCount int
+		)
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) {
+			if allocCount++; allocCount > 3 {
+				return 0, expErr
+			}
+			return mm.FrameFromAddress(f.allocTable()), nil
+		})
+
+		unmapped = make(map[mm.Page]bool)
+		freedBefore := len(freed)
+		startPage := mm.PageFromAddress(1<<40) + 510
+		if err := as.AllocRegion(startPage, 4*mm.PageSize, FlagRW); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if as.regionCount != 0 {
+			t.Errorf("expected failed region to be removed from the region list")
+		}
+
+		if !unmapped[startPage] || !unmapped[startPage+1] {
+			t.Errorf("expected the mapped pages of the failed region to be unmapped")
+		}
+
+		if len(freed) != freedBefore {
+			t.Errorf("expected the reserved zeroed frame not to be freed; got %v", freed)
+		}
+	})
+}
+
+func TestAddressSpaceClone(t *testing.T) {
+	f, _, _, restore := setupAddressSpaceTest(t)
+	defer restore()
+
+	as, err := NewAddressSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = as.AllocRegion(mm.Page(0x10), 2*mm.PageSize, FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = as.MapFrames(mm.Page(0x20), mm.Frame(0x1234), mm.PageSize, FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a write fault that populates the first page with a private
+	// frame. The fake page tables identity map the frames.
+	privateFrame := mm.FrameFromAddress(f.allocTable())
+	kernel.Memset(privateFrame.Address(), 0xfe, mm.PageSize)
+	if err = as.pdt.Map(mm.Page(0x10), privateFrame, FlagPresent|FlagRW); err != nil {
+		t.Fatal(err)
+	}
+	srcMapping, _ := lookupIn(as, mm.Page(0x10).Address())
+
+	clone, err := as.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if clone.regionCount != as.regionCount || clone.regions != as.regions {
+		t.Errorf("expected clone to contain the same regions as the original")
+	}
+
+	cloneMapping, err := lookupIn(clone, mm.Page(0x10).Address())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cloneMapping.PhysAddr == srcMapping.PhysAddr {
+		t.Fatal("expected allocated region to be backed by a different frame in the clone")
+	}
+
+	if _, ok := verifyBytes(cloneMapping.PhysAddr, mm.PageSize, 0xfe); !ok {
+		t.Error("expected the contents of the allocated region to be copied to the clone")
+	}
+
+	// Pages that have not been written to share the reserved zeroed frame
+	expFlags := FlagPresent | FlagCopyOnWrite | FlagNoExecute
+	if mapping, err := lookupIn(clone, mm.Page(0x11).Address()); err != nil || mapping.PhysAddr != ReservedZeroedFrame.Address() || mapping.Flags != expFlags {
+		t.Errorf("expected the unpopulated page to be mapped to the reserved zeroed frame with flags 0x%x; got %+v, %v", expFlags, mapping, err)
+	}
+
+	if mapping, err := lookupIn(clone, mm.Page(0x20).Address()); err != nil || mapping.PhysAddr != mm.Frame(0x1234).Address() {
+		t.Errorf("expected the borrowed region to map the same frames in the clone; got %+v, %v", mapping, err)
+	}
+
+	t.Run("allocation failure", func(t *testing.T) {
+		var (
+			expErr     = &kernel.Error{Module: "test", Message: "out of memory"}
+			allocCount int
+		)
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) {
+			if allocCount++; allocCount > 2 {
+				return 0, expErr
+			}
+			return mm.FrameFromAddress(f.allocTable()), nil
+		})
+
+		if _, err := as.Clone(); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+	})
+}
+
+func TestAddressSpaceDestroy(t *testing.T) {
+	_, p4, freed, restore := setupAddressSpaceTest(t)
+	defer restore()
+
+	as, err := NewAddressSpace()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = as.AllocRegion(mm.Page(0x10), 2*mm.PageSize, FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = as.MapFrames(mm.PageFromAddress(1<<39), mm.Frame(0x1234), mm.PageSize, FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	// Simulate a write fault that populates a page with a private frame
+	if err = as.pdt.Map(mm.Page(0x10), mm.Frame(0x99), FlagPresent|FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	var switchedTo uintptr
+	switchPDTFn = func(pdtAddr uintptr) { switchedTo = pdtAddr }
+
+	as.Switch()
+	if exp := as.pdt.pdtFrame.Address(); switchedTo != exp {
+		t.Errorf("expected Switch to activate PDT at 0x%x; got 0x%x", exp, switchedTo)
+	}
+
+	specs := []struct {
+		as     *AddressSpace
+		active bool
+		expErr *kernel.Error
+	}{
+		{KernelAddressSpace(), false, errAddressSpaceInUse},
+		{as, true, errAddressSpaceInUse},
+		{as, false, nil},
+	}
+
+	pdtFrame := as.pdt.pdtFrame
+	p4Addr := uintptr(unsafe.Pointer(p4))
+	for specIndex, spec := range specs {
+		activePDTFn = func() uintptr { return p4Addr }
+		if spec.active {
+			activePDTFn = func() uintptr { return pdtFrame.Address() }
+		}
+
+		if err := spec.as.Destroy(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	// The private frame, the 6 tables for the two lower half mappings and
+	// the PDT are released while the borrowed and zeroed frames are left
+	// intact
+	if len(freed) != 8 || freed[0x99] != 1 || freed[pdtFrame] != 1 || freed[0x1234] != 0 || freed[ReservedZeroedFrame] != 0 {
+		t.Errorf("expected 8 frames to be freed including the PDT frame; got %v", freed)
+	}
+
+	for index := kernelHalfFirstEntry(); index < uintptr(len(p4)); index++ {
+		if freed[p4[index].Frame()] != 0 {
+			t.Errorf("expected shared kernel table for PDT entry %d not to be freed", index)
+		}
+	}
+
+	if as.regionCount != 0 || as.pdt.pdtFrame != 0 {
+		t.Error("expected address space to be reset")
+	}
+}
+
+// verifyBytes checks that size bytes starting at addr are set to val.
+func verifyBytes(addr, size uintptr, val byte) (uintptr, bool) {
+	for ; size > 0; addr, size = addr+1, size-1 {
+		if *(*byte)(unsafe.Pointer(addr)) != val {
+			return addr, false
+		}
+	}
+
+	return 0, true
+}
@@ -0,0 +1,163 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+)
+
+// maxDemandRegions defines the max number of regions that can be registered
+// for demand paging.
+const maxDemandRegions = 32
+
+// demandRegion describes a range of virtual pages whose contents are backed
+// by physical frames the first time they are accessed.
+type demandRegion struct {
+	start, end mm.Page
+	flags      PageTableEntryFlag
+}
+
+var (
+	demandLock        sync.Spinlock
+	demandRegions     [maxDemandRegions]demandRegion
+	demandRegionCount int
+
+	errDemandRegionEmpty     = &kernel.Error{Module: "vmm", Message: "demand region must contain at least one page", Code: kernel.ErrCodeInvalidArgument}
+	errDemandRegionOverlap   = &kernel.Error{Module: "vmm", Message: "demand region overlaps with an already registered region", Code: kernel.ErrCodeInvalidArgument}
+	errDemandRegionLimit     = &kernel.Error{Module: "vmm", Message: "max number of demand regions exceeded", Code: kernel.ErrCodeOutOfMemory}
+	errDemandRegionProtected = &kernel.Error{Module: "vmm", Message: "access violates the protection flags of demand region", Code: kernel.ErrCodePermission}
+)
+
+// RegisterDemandRegion registers a range of pageCount virtual pages starting at
+// start for demand paging. No physical memory is reserved when the region is
+// registered; instead, the page-fault handler allocates a frame and maps it
+// using the supplied flags the first time each page is accessed.
+//
+// Pages are backed by ReservedZeroedFrame until they are first written to.
+// Read accesses to pages of regions with FlagRW map ReservedZeroedFrame as
+// copy-on-write so that a private frame is only allocated when the page is
+// written to. Pages in regions without FlagRW are never backed by a private
+// frame and any attempt to write to them is treated as a protection violation.
+func RegisterDemandRegion(start mm.Page, pageCount uintptr, flags PageTableEntryFlag) *kernel.Error {
+	if pageCount == 0 {
+		return errDemandRegionEmpty
+	}
+
+	region := demandRegion{
+		start: start,
+		end:   start + mm.Page(pageCount),
+		flags: (flags | FlagPresent) &^ FlagCopyOnWrite,
+	}
+
+	demandLock.Acquire()
+	defer demandLock.Release()
+
+	if demandRegionCount == maxDemandRegions {
+		return errDemandRegionLimit
+	}
+
+	for i := 0; i < demandRegionCount; i++ {
+		if region.start < demandRegions[i].end && demandRegions[i].start < region.end {
+			return errDemandRegionOverlap
+		}
+	}
+
+	demandRegions[demandRegionCount] = region
+	demandRegionCount++
+	return nil
+}
+
+// ReserveOnDemand reserves a virtual memory region with the requested size in
+// the kernel address space and registers it for demand paging. If size is not
+// a multiple of mm.PageSize it will be automatically rounded up. This allows
+// callers to set up large, sparsely populated mappings without allocating any
+// physical memory upfront.
+func ReserveOnDemand(size uintptr, flags PageTableEntryFlag) (mm.Page, *kernel.Error) {
+	size = (size + (mm.PageSize - 1)) & ^(mm.PageSize - 1)
+	if size == 0 {
+		return 0, errDemandRegionEmpty
+	}
+
+	startAddr, err := earlyReserveRegionFn(size)
+	if err != nil {
+		return 0, err
+	}
+
+	startPage := mm.PageFromAddress(startAddr)
+	if err = RegisterDemandRegion(startPage, size>>mm.PageShift, flags); err != nil {
+		return 0, err
+	}
+
+	return startPage, nil
+}
+
+// handleDemandFault attempts to resolve a fault caused by an access to a
+// non-present page. It returns false if the faulting page does not belong to a
+// registered demand region. Otherwise, it either maps a frame for the page
+// and returns true or returns an error if the access violates the protection
+// flags of the region or the page could not be mapped. A private frame is
+// only allocated for write accesses; other accesses map ReservedZeroedFrame.
+func handleDemandFault(faultPage mm.Page, write, fetch bool) (bool, *kernel.Error) {
+	demandLock.Acquire()
+	defer demandLock.Release()
+
+	var region *demandRegion
+	if index := findDemandRegion(faultPage); index >= 0 {
+		region = &demandRegions[index]
+	}
+
+	switch {
+	case region == nil:
+		return false, nil
+	case write && region.flags&FlagRW == 0, fetch && region.flags&FlagNoExecute != 0:
+		return true, errDemandRegionProtected
+	}
+
+	// Another CPU may have already populated the page while we were
+	// waiting for the lock.
+	if _, err := translateFn(faultPage.Address()); err == nil {
+		return true, nil
+	}
+
+	// Pages share the reserved zeroed frame until they are written to
+	if !write {
+		return true, mapFn(faultPage, ReservedZeroedFrame, zeroedFrameFlags(faultPage, region.flags))
+	}
+
+	frame, err := mm.AllocFrame()
+	if err != nil {
+		return true, err
+	}
+
+	if err = mapFn(faultPage, frame, region.flags); err != nil {
+		return true, err
+	}
+
+	kernel.Memset(faultPage.Address(), 0, mm.PageSize)
+	return true, nil
+}
+
+// zeroedFrameFlags returns the flags for mapping ReservedZeroedFrame to a page
+// that would otherwise be mapped with flags. Writable pages are flagged as
+// copy-on-write so that a private frame is allocated by the first write. The
+// W^X policy is applied before FlagRW is removed so the page does not become
+// executable once it is written to.
+func zeroedFrameFlags(page mm.Page, flags PageTableEntryFlag) PageTableEntryFlag {
+	if flags = wxFlags(page.Address(), flags); flags&FlagRW != 0 {
+		flags = (flags &^ FlagRW) | FlagCopyOnWrite
+	}
+
+	return flags | FlagPresent
+}
+
+// findDemandRegion returns the index of the demand region that contains page
+// or -1 if no such region exists. Callers must hold demandLock.
+func findDemandRegion(page mm.Page) int {
+	for index := 0; index < demandRegionCount; index++ {
+		if page >= demandRegions[index].start && page < demandRegions[index].end {
+			return index
+		}
+	}
+
+	return -1
+}
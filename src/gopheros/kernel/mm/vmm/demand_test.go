@@ -0,0 +1,238 @@
+package vmm
+
+import (
+	"fmt"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"io/ioutil"
+	"testing"
+	"unsafe"
+)
+
+func TestRegisterDemandRegion(t *testing.T) {
+	defer resetDemandRegions()
+	resetDemandRegions()
+
+	specs := []struct {
+		start     mm.Page
+		pageCount uintptr
+		expErr    *kernel.Error
+	}{
+		{100, 0, errDemandRegionEmpty},
+		{100, 10, nil},
+		{95, 6, errDemandRegionOverlap},
+		{109, 1, errDemandRegionOverlap},
+		{90, 100, errDemandRegionOverlap},
+		{90, 10, nil},
+		{110, 1, nil},
+	}
+
+	for specIndex, spec := range specs {
+		if err := RegisterDemandRegion(spec.start, spec.pageCount, FlagRW); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if demandRegionCount != 3 {
+		t.Fatalf("expected 3 regions to be registered; got %d", demandRegionCount)
+	}
+
+	if exp := FlagPresent | FlagRW; demandRegions[0].flags != exp {
+		t.Errorf("expected region flags to be %x; got %x", exp, demandRegions[0].flags)
+	}
+
+	for page := mm.Page(1000); demandRegionCount < maxDemandRegions; page++ {
+		if err := RegisterDemandRegion(page, 1, FlagRW); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := RegisterDemandRegion(2000, 1, FlagRW); err != errDemandRegionLimit {
+		t.Fatalf("expected to get error %v; got %v", errDemandRegionLimit, err)
+	}
+}
+
+func TestReserveOnDemand(t *testing.T) {
+	defer func() {
+		earlyReserveRegionFn = EarlyReserveRegion
+		resetDemandRegions()
+	}()
+	resetDemandRegions()
+
+	expErr := &kernel.Error{Module: "test", Message: "out of address space"}
+	specs := []struct {
+		size         uintptr
+		reserveErr   *kernel.Error
+		expPageCount uintptr
+		expErr       *kernel.Error
+	}{
+		{0, nil, 0, errDemandRegionEmpty},
+		{1, nil, 1, nil},
+		{3*mm.PageSize + 1, nil, 4, nil},
+		{mm.PageSize, expErr, 0, expErr},
+		// The reserved region overlaps the previous one
+		{mm.PageSize, nil, 0, errDemandRegionOverlap},
+	}
+
+	nextAddr := uintptr(0xbadf00d000)
+	for specIndex, spec := range specs {
+		var reservedSize uintptr
+		earlyReserveRegionFn = func(size uintptr) (uintptr, *kernel.Error) {
+			reservedSize = size
+			switch {
+			case spec.reserveErr != nil:
+				return 0, spec.reserveErr
+			case spec.expErr == errDemandRegionOverlap:
+				return nextAddr - mm.PageSize, nil
+			}
+			nextAddr += size
+			return nextAddr - size, nil
+		}
+
+		page, err := ReserveOnDemand(spec.size, FlagRW)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if err != nil {
+			continue
+		}
+
+		if got := reservedSize >> mm.PageShift; got != spec.expPageCount {
+			t.Errorf("[spec %d] expected %d pages to be reserved; got %d", specIndex, spec.expPageCount, got)
+		}
+
+		if region := demandRegions[demandRegionCount-1]; region.start != page || region.end != page+mm.Page(spec.expPageCount) {
+			t.Errorf("[spec %d] expected registered region to be [%d, %d); got [%d, %d)", specIndex, page, page+mm.Page(spec.expPageCount), region.start, region.end)
+		}
+	}
+}
+
+func TestDemandPageFault(t *testing.T) {
+	var (
+		regs      gate.Registers
+		pageBuf   = make([]byte, 2*mm.PageSize)
+		faultPage = mm.PageFromAddress(uintptr(unsafe.Pointer(&pageBuf[0])) + mm.PageSize - 1)
+		pageEntry pageTableEntry
+		expErr    = &kernel.Error{Module: "test", Message: "something went wrong"}
+	)
+
+	defer func(origPtePtr func(uintptr) unsafe.Pointer) {
+		ptePtrFn = origPtePtr
+		readCR2Fn = cpu.ReadCR2
+		translateFn = Translate
+		mapFn = Map
+		mm.SetFrameAllocator(nil)
+		kfmt.SetOutputSink(nil)
+		resetDemandRegions()
+	}(ptePtrFn)
+
+	ptePtrFn = func(uintptr) unsafe.Pointer { return unsafe.Pointer(&pageEntry) }
+	kfmt.SetOutputSink(ioutil.Discard)
+	readCR2Fn = func() uint64 { return uint64(faultPage.Address() + 42) }
+
+	specs := []struct {
+		regionFlags PageTableEntryFlag
+		errCode     uint64
+		mapped      bool
+		allocErr    *kernel.Error
+		mapErr      *kernel.Error
+		expFrame    mm.Frame
+		expMapFlags PageTableEntryFlag
+		expPanic    interface{}
+	}{
+		// Fault outside a demand region
+		{0, 2, false, nil, nil, mm.InvalidFrame, 0, errUnrecoverableFault},
+		// Protection violation for a present page
+		{FlagRW, 3, false, nil, nil, mm.InvalidFrame, 0, errUnrecoverableFault},
+		// Write to a read-only region
+		{FlagNoExecute, 2, false, nil, nil, mm.InvalidFrame, 0, errDemandRegionProtected},
+		// Instruction fetch from a non-executable region
+		{FlagRW | FlagNoExecute, 16, false, nil, nil, mm.InvalidFrame, 0, errDemandRegionProtected},
+		// Page already populated by another CPU
+		{FlagRW, 2, true, nil, nil, mm.InvalidFrame, 0, nil},
+		// Read from a read-only region
+		{FlagNoExecute, 0, false, nil, nil, ReservedZeroedFrame, FlagPresent | FlagNoExecute, nil},
+		// Read from a RW region maps the reserved zeroed frame as CoW
+		{FlagRW | FlagNoExecute, 0, false, nil, nil, ReservedZeroedFrame, FlagPresent | FlagCopyOnWrite | FlagNoExecute, nil},
+		// Frame allocation fails
+		{FlagRW, 2, false, expErr, nil, mm.InvalidFrame, 0, expErr},
+		// Mapping the page fails
+		{FlagRW, 2, false, nil, expErr, mm.InvalidFrame, 0, expErr},
+		// Write to a RW region
+		{FlagRW, 2, false, nil, nil, mm.Frame(123), FlagPresent | FlagRW, nil},
+	}
+
+	for specIndex, spec := range specs {
+		t.Run(fmt.Sprint(specIndex), func(t *testing.T) {
+			resetDemandRegions()
+			if spec.regionFlags != 0 {
+				if err := RegisterDemandRegion(faultPage, 1, spec.regionFlags); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for i := range pageBuf {
+				pageBuf[i] = 0xfe
+			}
+
+			var (
+				mappedFrame = mm.InvalidFrame
+				mappedFlags PageTableEntryFlag
+			)
+
+			translateFn = func(uintptr) (uintptr, *kernel.Error) {
+				if spec.mapped {
+					return 0, nil
+				}
+				return 0, ErrInvalidMapping
+			}
+			mapFn = func(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
+				if page != faultPage {
+					t.Errorf("expected page %d to be mapped; got %d", faultPage, page)
+				}
+				if spec.mapErr == nil {
+					mappedFrame, mappedFlags = frame, flags
+				}
+				return spec.mapErr
+			}
+			mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) {
+				return mm.Frame(123), spec.allocErr
+			})
+
+			defer func() {
+				if err := recover(); err != spec.expPanic {
+					t.Errorf("expected to panic with %v; got %v", spec.expPanic, err)
+					return
+				}
+
+				if mappedFrame != spec.expFrame || mappedFlags != spec.expMapFlags {
+					t.Errorf("expected frame %d to be mapped with flags %x; got frame %d with flags %x", spec.expFrame, spec.expMapFlags, mappedFrame, mappedFlags)
+				}
+
+				// Newly allocated frames must be cleared
+				for i := faultPage.Address(); i < faultPage.Address()+mm.PageSize; i++ {
+					expByte := byte(0xfe)
+					if spec.expFrame == mm.Frame(123) {
+						expByte = 0
+					}
+					if got := *(*byte)(unsafe.Pointer(i)); got != expByte {
+						t.Errorf("expected page contents to be 0x%x; got 0x%x at address 0x%x", expByte, got, i)
+						break
+					}
+				}
+			}()
+
+			regs.Info = spec.errCode
+			pageFaultHandler(&regs)
+		})
+	}
+}
+
+func resetDemandRegions() {
+	demandRegionCount = 0
+}
@@ -0,0 +1,160 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
+	"io"
+	"strconv"
+)
+
+// dumpFlagMask selects the effective page flags that are taken into account
+// when coalescing contiguous pages into a single region.
+const dumpFlagMask = FlagRW | FlagUserAccessible | FlagNoExecute | FlagGlobal | FlagCopyOnWrite | FlagDoNotCache
+
+var errInvalidDumpArgs = &kernel.Error{Module: "vmm", Message: "invalid arguments", Code: kernel.ErrCodeInvalidArgument}
+
+// DumpRange writes to w a description of the mappings that overlap the
+// virtual address range [start, end). Contiguous pages with the same page
+// size and effective permissions are coalesced into a single region. Each
+// region is described by its address range followed by a set of permission
+// flags ('w': writable, 'x': executable, 'u': user accessible, 'g': global,
+// 'c': copy-on-write, 'n': not cached), its page size and page count.
+func DumpRange(w io.Writer, start, end uintptr) *kernel.Error {
+	if end <= start {
+		return errInvalidDumpArgs
+	}
+
+	var (
+		regionStart, regionEnd uintptr
+		regionFlags            PageTableEntryFlag
+		regionLevel            uint8
+		regionPages            uint64
+	)
+
+	flush := func() {
+		if regionPages == 0 {
+			return
+		}
+
+		kfmt.Fprintf(w, "0x%16x - 0x%16x %s %s %d\n",
+			regionStart, regionEnd, permString(regionFlags), pageSizeString(regionLevel), regionPages,
+		)
+		regionPages = 0
+	}
+
+	visitMappings(start, end-1, func(virtAddr uintptr, level uint8, flags PageTableEntryFlag) {
+		flags &= dumpFlagMask
+		if regionPages != 0 && virtAddr == regionEnd && level == regionLevel && flags == regionFlags {
+			regionEnd += uintptr(1) << pageLevelShifts[level]
+			regionPages++
+			return
+		}
+
+		flush()
+		regionStart, regionEnd = virtAddr, virtAddr+(uintptr(1)<<pageLevelShifts[level])
+		regionFlags, regionLevel, regionPages = flags, level, 1
+	})
+	flush()
+
+	return nil
+}
+
+// permString returns a fixed-width representation of the supplied effective
+// page flags.
+func permString(flags PageTableEntryFlag) []byte {
+	perms := []byte("r------")
+	for index, flag := range []PageTableEntryFlag{FlagRW, FlagNoExecute, FlagUserAccessible, FlagGlobal, FlagCopyOnWrite, FlagDoNotCache} {
+		set := flags&flag != 0
+		if flag == FlagNoExecute {
+			set = !set
+		}
+
+		if set {
+			perms[index+1] = "wxugcn"[index]
+		}
+	}
+
+	return perms
+}
+
+// pageSizeString returns a human-readable description of the size of pages
+// mapped at the supplied page table level.
+func pageSizeString(level uint8) string {
+	switch pageLevelShifts[level] {
+	case 30:
+		return "1G"
+	case 21:
+		return "2M"
+	default:
+		return "4K"
+	}
+}
+
+// parseAddress parses a kshell command argument as an address.
+func parseAddress(arg string) (uintptr, *kernel.Error) {
+	addr, err := strconv.ParseUint(arg, 0, 64)
+	if err != nil {
+		return 0, errInvalidDumpArgs
+	}
+
+	return uintptr(addr), nil
+}
+
+// cmdTranslate implements the "vtop" kshell command which displays the
+// mapping for a virtual address.
+func cmdTranslate(w io.Writer, args []string) *kernel.Error {
+	if len(args) != 1 {
+		return errInvalidDumpArgs
+	}
+
+	virtAddr, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+
+	mapping, err := Lookup(virtAddr)
+	if err != nil {
+		return err
+	}
+
+	kfmt.Fprintf(w, "0x%16x -> 0x%16x frame 0x%x level %d page %s flags 0x%x\n",
+		virtAddr, mapping.PhysAddr, uintptr(mapping.Frame), mapping.Level, pageSizeString(mapping.Level), uintptr(mapping.Flags),
+	)
+	return nil
+}
+
+// cmdDumpRange implements the "ptdump" kshell command which lists the
+// mappings for a range of virtual addresses.
+func cmdDumpRange(w io.Writer, args []string) *kernel.Error {
+	if len(args) != 2 {
+		return errInvalidDumpArgs
+	}
+
+	start, err := parseAddress(args[0])
+	if err != nil {
+		return err
+	}
+
+	end, err := parseAddress(args[1])
+	if err != nil {
+		return err
+	}
+
+	return DumpRange(w, start, end)
+}
+
+func init() {
+	kshell.RegisterCommand(&kshell.Command{
+		Name:  "vtop",
+		Usage: "address",
+		Help:  "translate a virtual address and display its page table entry",
+		Fn:    cmdTranslate,
+	})
+	kshell.RegisterCommand(&kshell.Command{
+		Name:  "ptdump",
+		Usage: "start end",
+		Help:  "list the mappings and their permissions for a virtual address range",
+		Fn:    cmdDumpRange,
+	})
+}
@@ -0,0 +1,161 @@
+package vmm
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"io"
+	"runtime"
+	"testing"
+	"unsafe"
+)
+
+// setupDumpTables installs a set of fake page tables with the following
+// mappings:
+//   - 0x0: a RW 2M page mapped to 0x400000
+//   - 0x200000, 0x201000: RW pages mapped to 0x1000 and 0x5000
+//   - 0x202000: a read-only executable page mapped to 0x2000
+//   - 0x204000: a RW user-accessible page mapped to 0x3000
+//   - 0xffff960080000000: a RWX global 1G page mapped to 0x40000000
+func setupDumpTables(t *testing.T) func() {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	var (
+		f  = newFakePageTables(6)
+		p4 = f.table(f.allocTable())
+	)
+
+	origPtePtr := ptePtrFn
+	ptePtrFn = recursivePtePtrFn(f, p4)
+
+	setTestEntry(&p4[511], uintptr(unsafe.Pointer(p4)), FlagPresent|FlagRW)
+
+	pdpt, pd, pt := f.allocTable(), f.allocTable(), f.allocTable()
+	setTestEntry(&p4[0], pdpt, FlagPresent|FlagRW|FlagUserAccessible)
+	setTestEntry(&f.table(pdpt)[0], pd, FlagPresent|FlagRW|FlagUserAccessible)
+	setTestEntry(&f.table(pd)[0], 0x400000, FlagPresent|FlagRW|FlagNoExecute|FlagHugePage)
+	setTestEntry(&f.table(pd)[1], pt, FlagPresent|FlagRW|FlagUserAccessible)
+	setTestEntry(&f.table(pt)[0], 0x1000, FlagPresent|FlagRW|FlagNoExecute)
+	setTestEntry(&f.table(pt)[1], 0x5000, FlagPresent|FlagRW|FlagNoExecute|FlagAccessed)
+	setTestEntry(&f.table(pt)[2], 0x2000, FlagPresent)
+	setTestEntry(&f.table(pt)[4], 0x3000, FlagPresent|FlagRW|FlagNoExecute|FlagUserAccessible)
+
+	upperPDPT := f.allocTable()
+	setTestEntry(&p4[300], upperPDPT, FlagPresent|FlagRW)
+	setTestEntry(&f.table(upperPDPT)[2], 0x40000000, FlagPresent|FlagRW|FlagGlobal|FlagHugePage)
+
+	return func() { ptePtrFn = origPtePtr }
+}
+
+func TestLookup(t *testing.T) {
+	defer setupDumpTables(t)()
+
+	specs := []struct {
+		virtAddr uintptr
+		expErr   *kernel.Error
+		expMap   Mapping
+	}{
+		{0x202123, nil, Mapping{PhysAddr: 0x2123, Frame: mm.Frame(2), Flags: FlagPresent, Level: 3, PageSize: mm.PageSize}},
+		{0x1234, nil, Mapping{PhysAddr: 0x401234, Frame: mm.Frame(0x401), Flags: FlagPresent | FlagRW | FlagNoExecute | FlagHugePage, Level: 2, PageSize: 2 << 20}},
+		{0xffff960080001000, nil, Mapping{PhysAddr: 0x40001000, Frame: mm.Frame(0x40001), Flags: FlagPresent | FlagRW | FlagGlobal | FlagHugePage, Level: 1, PageSize: 1 << 30}},
+		{0x203000, ErrInvalidMapping, Mapping{}},
+		{0xffff800000000000, ErrInvalidMapping, Mapping{}},
+	}
+
+	for specIndex, spec := range specs {
+		mapping, err := Lookup(spec.virtAddr)
+		if err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if mapping != spec.expMap {
+			t.Errorf("[spec %d] expected mapping %+v; got %+v", specIndex, spec.expMap, mapping)
+		}
+
+		physAddr, err := Translate(spec.virtAddr)
+		if err != spec.expErr || physAddr != spec.expMap.PhysAddr {
+			t.Errorf("[spec %d] expected Translate to return 0x%x, %v; got 0x%x, %v", specIndex, spec.expMap.PhysAddr, spec.expErr, physAddr, err)
+		}
+	}
+}
+
+func TestDumpRange(t *testing.T) {
+	defer setupDumpTables(t)()
+
+	specs := []struct {
+		start, end uintptr
+		expErr     *kernel.Error
+		expOutput  string
+	}{
+		{
+			0, 0x400000,
+			nil,
+			"0x0000000000000000 - 0x0000000000200000 rw----- 2M 1\n" +
+				"0x0000000000200000 - 0x0000000000202000 rw----- 4K 2\n" +
+				"0x0000000000202000 - 0x0000000000203000 r-x---- 4K 1\n" +
+				"0x0000000000204000 - 0x0000000000205000 rw-u--- 4K 1\n",
+		},
+		{
+			0x201000, 0x203000,
+			nil,
+			"0x0000000000201000 - 0x0000000000202000 rw----- 4K 1\n" +
+				"0x0000000000202000 - 0x0000000000203000 r-x---- 4K 1\n",
+		},
+		{
+			0xffff900000000000, 0xffffa00000000000,
+			nil,
+			"0xffff960080000000 - 0xffff9600c0000000 rwx-g-- 1G 1\n",
+		},
+		{0x203000, 0x204000, nil, ""},
+		{0x1000, 0x1000, errInvalidDumpArgs, ""},
+	}
+
+	for specIndex, spec := range specs {
+		var buf bytes.Buffer
+		if err := DumpRange(&buf, spec.start, spec.end); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if got := buf.String(); got != spec.expOutput {
+			t.Errorf("[spec %d] expected output:\n%q\ngot:\n%q", specIndex, spec.expOutput, got)
+		}
+	}
+}
+
+func TestDumpCommands(t *testing.T) {
+	defer setupDumpTables(t)()
+
+	specs := []struct {
+		cmd       func(io.Writer, []string) *kernel.Error
+		args      []string
+		expErr    *kernel.Error
+		expOutput string
+	}{
+		{cmdTranslate, []string{}, errInvalidDumpArgs, ""},
+		{cmdTranslate, []string{"foo"}, errInvalidDumpArgs, ""},
+		{cmdTranslate, []string{"0x203000"}, ErrInvalidMapping, ""},
+		{cmdTranslate, []string{"0x202123"}, nil, "0x0000000000202123 -> 0x0000000000002123 frame 0x2 level 3 page 4K flags 0x1\n"},
+		{cmdDumpRange, []string{"0x0"}, errInvalidDumpArgs, ""},
+		{cmdDumpRange, []string{"0x0", "bar"}, errInvalidDumpArgs, ""},
+		{cmdDumpRange, []string{"foo", "0x1000"}, errInvalidDumpArgs, ""},
+		{cmdDumpRange, []string{"0x202000", "0x203000"}, nil, "0x0000000000202000 - 0x0000000000203000 r-x---- 4K 1\n"},
+	}
+
+	var buf bytes.Buffer
+	for specIndex, spec := range specs {
+		buf.Reset()
+
+		if err := spec.cmd(&buf, spec.args); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if got := buf.String(); got != spec.expOutput {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, spec.expOutput, got)
+		}
+	}
+}
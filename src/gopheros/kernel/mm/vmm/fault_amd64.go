@@ -7,14 +7,43 @@ import (
 	"gopheros/kernel/mm"
 )
 
+// faultStackSize defines the size of the dedicated stacks used by the page
+// fault and double fault handlers.
+const faultStackSize = 4 * mm.PageSize
+
 var (
-	// handleInterruptFn is used by tests.
-	handleInterruptFn = gate.HandleInterrupt
+	// The following functions are used by tests.
+	handleInterruptFn   = gate.HandleInterrupt
+	setInterruptStackFn = gate.SetInterruptStack
+	allocStackFn        = AllocStack
+)
+
+// Bits of the error code pushed by the CPU when a page fault occurs.
+const (
+	faultErrPresent = 1 << 0
+	faultErrWrite   = 1 << 1
+	faultErrFetch   = 1 << 4
 )
 
-func installFaultHandlers() {
-	handleInterruptFn(gate.PageFaultException, 0, pageFaultHandler)
+// installFaultHandlers allocates guarded stacks for the page fault and
+// double fault handlers and installs the vmm-related exception handlers.
+// Running the page fault handler on its own stack allows it to report
+// overflows of kernel stacks; otherwise, the CPU would fail to push the
+// exception frame to the overflowed stack and raise a double fault.
+func installFaultHandlers() *kernel.Error {
+	for _, istOffset := range []uint8{gate.ISTPageFault, gate.ISTDoubleFault} {
+		// Exception stacks are not owned by a goroutine
+		stackTop, err := allocStackFn(faultStackSize, 0)
+		if err != nil {
+			return err
+		}
+		setInterruptStackFn(istOffset, stackTop)
+	}
+
+	handleInterruptFn(gate.PageFaultException, gate.ISTPageFault, pageFaultHandler)
+	handleInterruptFn(gate.DoubleFault, gate.ISTDoubleFault, doubleFaultHandler)
 	handleInterruptFn(gate.GPFException, 0, generalProtectionFaultHandler)
+	return nil
 }
 
 // pageFaultHandler is invoked when a PDT or PDT-entry is not present or when a
@@ -26,6 +55,25 @@ func pageFaultHandler(regs *gate.Registers) {
 		pageEntry    *pageTableEntry
 	)
 
+	// Lazily populate non-present pages that belong to a demand region
+	if regs.Info&faultErrPresent == 0 {
+		handled, err := handleDemandFault(faultPage, regs.Info&faultErrWrite != 0, regs.Info&faultErrFetch != 0)
+		if err != nil {
+			// TODO: kill the offending task instead of panicking when
+			// user-mode tasks are implemented
+			nonRecoverablePageFault(faultAddress, regs, err)
+		} else if handled {
+			// Fault recovered; retry the instruction that caused the fault
+			return
+		}
+
+		// Accesses to the guard page of a kernel stack indicate that
+		// the stack has overflowed
+		if goroutineID, isGuard := stackGuardOwner(faultPage); isGuard {
+			stackOverflow(faultAddress, regs, goroutineID)
+		}
+	}
+
 	// Lookup entry for the page where the fault occurred
 	walk(faultPage.Address(), func(pteLevel uint8, pte *pageTableEntry) bool {
 		nextIsPresent := pte.HasFlags(FlagPresent)
@@ -34,8 +82,9 @@ func pageFaultHandler(regs *gate.Registers) {
 			pageEntry = pte
 		}
 
-		// Abort walk if the next page table entry is missing
-		return nextIsPresent
+		// Abort walk if the next page table entry is missing or maps
+		// a huge page
+		return nextIsPresent && !pte.HasFlags(FlagHugePage)
 	})
 
 	// CoW is supported for RO pages with the CoW flag set
@@ -51,8 +100,13 @@ func pageFaultHandler(regs *gate.Registers) {
 		} else if tmpPage, err = mapTemporaryFn(copy); err != nil {
 			nonRecoverablePageFault(faultAddress, regs, err)
 		} else {
-			// Copy page contents, mark as RW and remove CoW flag
-			kernel.Memcopy(faultPage.Address(), tmpPage.Address(), mm.PageSize)
+			// Copy page contents, mark as RW and remove CoW flag. Pages
+			// backed by the reserved zeroed frame only need to be cleared.
+			if isReservedZeroedFrame(pageEntry.Frame()) {
+				kernel.Memset(tmpPage.Address(), 0, mm.PageSize)
+			} else {
+				kernel.Memcopy(faultPage.Address(), tmpPage.Address(), mm.PageSize)
+			}
 			_ = unmapFn(tmpPage)
 
 			// Update mapping to point to the new frame, flag it as RW and
@@ -60,7 +114,7 @@ func pageFaultHandler(regs *gate.Registers) {
 			pageEntry.ClearFlags(FlagCopyOnWrite)
 			pageEntry.SetFlags(FlagPresent | FlagRW)
 			pageEntry.SetFrame(copy)
-			flushTLBEntryFn(faultPage.Address())
+			invalidatePage(faultPage.Address())
 
 			// Fault recovered; retry the instruction that caused the fault
 			return
@@ -83,6 +137,17 @@ func generalProtectionFaultHandler(regs *gate.Registers) {
 	panic(errUnrecoverableFault)
 }
 
+// doubleFaultHandler is invoked when the CPU fails to deliver an exception
+// (e.g. a page fault that occurs while pushing the exception frame of another
+// fault). Double faults are not recoverable.
+func doubleFaultHandler(regs *gate.Registers) {
+	kfmt.Printf("\nDouble fault\n")
+	kfmt.Printf("Registers:\n")
+	regs.DumpTo(kfmt.GetOutputSink())
+
+	panic(errUnrecoverableFault)
+}
+
 func nonRecoverablePageFault(faultAddress uintptr, regs *gate.Registers, err *kernel.Error) {
 	kfmt.Printf("\nPage fault while accessing address: 0x%16x\nReason: ", faultAddress)
 	switch {
@@ -107,6 +172,12 @@ func nonRecoverablePageFault(faultAddress uintptr, regs *gate.Registers, err *ke
 	kfmt.Printf("\n\nRegisters:\n")
 	regs.DumpTo(kfmt.GetOutputSink())
 
+	// The fault handler cannot report allocation failures to the code
+	// that triggered the fault
+	if err.Code == kernel.ErrCodeOutOfMemory {
+		mm.OutOfMemory(err)
+	}
+
 	// TODO: Revisit this when user-mode tasks are implemented
 	panic(err)
 }
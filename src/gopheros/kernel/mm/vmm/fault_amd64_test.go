@@ -20,35 +20,45 @@ func TestRecoverablePageFault(t *testing.T) {
 		origPage   = make([]byte, mm.PageSize)
 		clonedPage = make([]byte, mm.PageSize)
 		err        = &kernel.Error{Module: "test", Message: "something went wrong"}
+		errOOM     = &kernel.Error{Module: "test", Message: "out of memory", Code: kernel.ErrCodeOutOfMemory}
 	)
 
-	defer func(origPtePtr func(uintptr) unsafe.Pointer) {
+	defer func(origPtePtr func(uintptr) unsafe.Pointer, origZeroedFrame mm.Frame) {
 		ptePtrFn = origPtePtr
 		readCR2Fn = cpu.ReadCR2
 		mm.SetFrameAllocator(nil)
 		mapTemporaryFn = MapTemporary
 		unmapFn = Unmap
 		flushTLBEntryFn = cpu.FlushTLBEntry
-	}(ptePtrFn)
+		ReservedZeroedFrame = origZeroedFrame
+		protectReservedZeroedPage = false
+	}(ptePtrFn, ReservedZeroedFrame)
+
+	ReservedZeroedFrame = mm.Frame(77)
 
 	specs := []struct {
 		pteFlags   PageTableEntryFlag
+		zeroed     bool
 		allocError *kernel.Error
 		mapError   *kernel.Error
 		expPanic   bool
 	}{
 		// Missing pge
-		{0, nil, nil, true},
+		{0, false, nil, nil, true},
 		// Page is present but CoW flag not set
-		{FlagPresent, nil, nil, true},
+		{FlagPresent, false, nil, nil, true},
 		// Page is present but both CoW and RW flags set
-		{FlagPresent | FlagRW | FlagCopyOnWrite, nil, nil, true},
+		{FlagPresent | FlagRW | FlagCopyOnWrite, false, nil, nil, true},
 		// Page is present with CoW flag set but allocating a page copy fails
-		{FlagPresent | FlagCopyOnWrite, err, nil, true},
+		{FlagPresent | FlagCopyOnWrite, false, err, nil, true},
+		// Page is present with CoW flag set but the system is out of memory
+		{FlagPresent | FlagCopyOnWrite, false, errOOM, nil, true},
 		// Page is present with CoW flag set but mapping the page copy fails
-		{FlagPresent | FlagCopyOnWrite, nil, err, true},
+		{FlagPresent | FlagCopyOnWrite, false, nil, err, true},
 		// Page is present with CoW flag set
-		{FlagPresent | FlagCopyOnWrite, nil, nil, false},
+		{FlagPresent | FlagCopyOnWrite, false, nil, nil, false},
+		// Page is backed by the reserved zeroed frame
+		{FlagPresent | FlagCopyOnWrite, true, nil, nil, false},
 	}
 
 	ptePtrFn = func(entry uintptr) unsafe.Pointer { return unsafe.Pointer(&pageEntry) }
@@ -69,8 +79,12 @@ func TestRecoverablePageFault(t *testing.T) {
 					}
 
 					for i := 0; i < len(origPage); i++ {
-						if origPage[i] != clonedPage[i] {
+						if expByte := origPage[i]; spec.zeroed && clonedPage[i] != 0 {
+							t.Errorf("expected clone page to be cleared; got 0x%x at index %d", clonedPage[i], i)
+							break
+						} else if !spec.zeroed && clonedPage[i] != expByte {
 							t.Errorf("expected clone page to be a copy of the original page; mismatch at index %d", i)
+							break
 						}
 					}
 				}
@@ -84,11 +98,14 @@ func TestRecoverablePageFault(t *testing.T) {
 
 			for i := 0; i < len(origPage); i++ {
 				origPage[i] = byte(i % 256)
-				clonedPage[i] = 0
+				clonedPage[i] = 0xfe
 			}
 
 			pageEntry = 0
 			pageEntry.SetFlags(spec.pteFlags)
+			if protectReservedZeroedPage = spec.zeroed; spec.zeroed {
+				pageEntry.SetFrame(ReservedZeroedFrame)
+			}
 
 			regs.Info = 2
 			pageFaultHandler(&regs)
@@ -183,3 +200,78 @@ func TestGPFHandler(t *testing.T) {
 
 	generalProtectionFaultHandler(&regs)
 }
+
+func TestDoubleFaultHandler(t *testing.T) {
+	var regs gate.Registers
+
+	defer func() {
+		if err := recover(); err != errUnrecoverableFault {
+			t.Errorf("expected a panic with errUnrecoverableFault; got %v", err)
+		}
+	}()
+
+	doubleFaultHandler(&regs)
+}
+
+func TestInstallFaultHandlers(t *testing.T) {
+	defer func() {
+		handleInterruptFn = gate.HandleInterrupt
+		setInterruptStackFn = gate.SetInterruptStack
+		allocStackFn = AllocStack
+	}()
+
+	t.Run("success", func(t *testing.T) {
+		var (
+			nextStackTop = uintptr(0x1000)
+			stacks       = make(map[uint8]uintptr)
+			istOffsets   = make(map[gate.InterruptNumber]uint8)
+		)
+
+		allocStackFn = func(size uintptr, _ uint64) (uintptr, *kernel.Error) {
+			if size != faultStackSize {
+				t.Errorf("expected stack size to be %d; got %d", faultStackSize, size)
+			}
+			nextStackTop += 0x1000
+			return nextStackTop, nil
+		}
+		setInterruptStackFn = func(istOffset uint8, stackTop uintptr) { stacks[istOffset] = stackTop }
+		handleInterruptFn = func(intNumber gate.InterruptNumber, istOffset uint8, _ func(*gate.Registers)) {
+			istOffsets[intNumber] = istOffset
+		}
+
+		if err := installFaultHandlers(); err != nil {
+			t.Fatal(err)
+		}
+
+		if stacks[gate.ISTPageFault] == 0 || stacks[gate.ISTDoubleFault] == 0 || stacks[gate.ISTPageFault] == stacks[gate.ISTDoubleFault] {
+			t.Fatalf("expected distinct stacks to be assigned to the page and double fault IST slots; got %v", stacks)
+		}
+
+		specs := []struct {
+			intNumber gate.InterruptNumber
+			expIST    uint8
+		}{
+			{gate.PageFaultException, gate.ISTPageFault},
+			{gate.DoubleFault, gate.ISTDoubleFault},
+			{gate.GPFException, 0},
+		}
+
+		for specIndex, spec := range specs {
+			if got, installed := istOffsets[spec.intNumber]; !installed || got != spec.expIST {
+				t.Errorf("[spec %d] expected handler for interrupt %d to be installed with IST %d; got %d (installed: %t)", specIndex, spec.intNumber, spec.expIST, got, installed)
+			}
+		}
+	})
+
+	t.Run("stack allocation fails", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "out of memory"}
+		allocStackFn = func(_ uintptr, _ uint64) (uintptr, *kernel.Error) { return 0, expErr }
+		handleInterruptFn = func(_ gate.InterruptNumber, _ uint8, _ func(*gate.Registers)) {
+			t.Error("unexpected call to HandleInterrupt")
+		}
+
+		if err := installFaultHandlers(); err != expErr {
+			t.Fatalf("expected error: %v; got %v", expErr, err)
+		}
+	})
+}
@@ -31,6 +31,12 @@ import (
 // installed in-place with RW permissions.
 var ReservedZeroedFrame mm.Frame
 
+// isReservedZeroedFrame returns true if frame is the ReservedZeroedFrame that
+// is shared by all pages which have not been written to yet.
+func isReservedZeroedFrame(frame mm.Frame) bool {
+	return protectReservedZeroedPage && frame == ReservedZeroedFrame
+}
+
 var (
 	// protectReservedZeroedPage is set to true to prevent mapping to
 	protectReservedZeroedPage bool
@@ -48,14 +54,25 @@ var (
 
 	earlyReserveRegionFn = EarlyReserveRegion
 
-	errNoHugePageSupport           = &kernel.Error{Module: "vmm", Message: "huge pages are not supported"}
-	errAttemptToRWMapReservedFrame = &kernel.Error{Module: "vmm", Message: "reserved blank frame cannot be mapped with a RW flag"}
+	// mapHugePageFn is used by tests and is automatically inlined by the
+	// compiler.
+	mapHugePageFn = mapHugePage
+
+	// largestPageLevel is the page level whose entries map the largest
+	// page size supported by the CPU. It is updated by Init once the CPU
+	// features have been probed.
+	largestPageLevel = uint8(pageLevels - 2)
+
+	errNoHugePageSupport           = &kernel.Error{Module: "vmm", Message: "huge pages are not supported", Code: kernel.ErrCodeNotSupported}
+	errHugePageTableInUse          = &kernel.Error{Module: "vmm", Message: "page table entry already points to a page table", Code: kernel.ErrCodeBusy}
+	errAttemptToRWMapReservedFrame = &kernel.Error{Module: "vmm", Message: "reserved blank frame cannot be mapped with a RW flag", Code: kernel.ErrCodePermission}
 )
 
 // Map establishes a mapping between a virtual page and a physical mmory frame
 // using the currently active page directory table. Calls to Map will use the
 // supplied physical frame allocator to initialize missing page tables at each
-// paging level supported by the MMU.
+// paging level supported by the MMU. If the page is currently covered by a
+// huge page mapping, Map will split it into smaller pages.
 //
 // Attempts to map ReservedZeroedFrame with a RW flag will result in an error.
 func Map(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
@@ -63,22 +80,70 @@ func Map(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
 		return errAttemptToRWMapReservedFrame
 	}
 
+	return mapAtLevel(page.Address(), frame, flags, pageLevels-1)
+}
+
+// mapHugePage establishes a huge page mapping between the virtual address and
+// the physical frame using the page table entry at the specified page level.
+// Both the virtual address and the frame must be aligned to the page size
+// that corresponds to the page level. If the entry already points to a page
+// table, mapHugePage returns errHugePageTableInUse.
+func mapHugePage(virtAddr uintptr, frame mm.Frame, flags PageTableEntryFlag, level uint8) *kernel.Error {
+	if level < hugePageMinLevel || level >= pageLevels-1 {
+		return errNoHugePageSupport
+	}
+
+	return mapAtLevel(virtAddr, frame, flags, level)
+}
+
+// mapAtLevel installs a mapping for the supplied virtual address to the
+// physical frame using the page table entry at the specified page level. Any
+// missing page tables up to that level are allocated and huge pages
+// encountered along the way are split.
+func mapAtLevel(virtAddr uintptr, frame mm.Frame, flags PageTableEntryFlag, level uint8) *kernel.Error {
 	var err *kernel.Error
 
-	walk(page.Address(), func(pteLevel uint8, pte *pageTableEntry) bool {
-		// If we reached the last level all we need to do is to map the
-		// frame in place and flag it as present and flush its TLB entry
-		if pteLevel == pageLevels-1 {
+	// Writable mappings are never executable unless explicitly allowed
+	flags = wxFlags(virtAddr, flags)
+
+	// The huge page bit has a different meaning (PAT) for last level entries
+	if level == pageLevels-1 {
+		flags &^= FlagHugePage
+	} else {
+		flags |= FlagHugePage
+	}
+
+	beginShootdown()
+	defer endShootdown()
+
+	walk(virtAddr, func(pteLevel uint8, pte *pageTableEntry) bool {
+		// If we reached the requested level all we need to do is to
+		// map the frame in place and flag it as present and flush its
+		// TLB entry. Remote CPUs only need to be notified if an
+		// existing mapping was replaced.
+		if pteLevel == level {
+			if level != pageLevels-1 && pte.HasFlags(FlagPresent) && !pte.HasFlags(FlagHugePage) {
+				err = errHugePageTableInUse
+				return false
+			}
+
+			replaced := pte.HasFlags(FlagPresent)
 			*pte = 0
 			pte.SetFrame(frame)
 			pte.SetFlags(flags)
-			flushTLBEntryFn(page.Address())
-			return true
+			if replaced {
+				invalidatePage(virtAddr)
+			} else {
+				flushTLBEntryFn(virtAddr)
+			}
+			return false
 		}
 
 		if pte.HasFlags(FlagHugePage) {
-			err = errNoHugePageSupport
-			return false
+			if err = splitHugePage(virtAddr, pteLevel, pte); err != nil {
+				return false
+			}
+			return true
 		}
 
 		// Next table does not yet exist; we need to allocate a
@@ -100,51 +165,168 @@ func Map(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
 			kernel.Memset(nextAddrFn(nextTableAddr), 0, mm.PageSize)
 		}
 
+		// User-mode accesses must be allowed at every level of the
+		// walk for user-accessible pages
+		pte.SetFlags(flags & FlagUserAccessible)
 		return true
 	})
 
 	return err
 }
 
+// splitHugePage replaces the huge page mapping described by the page table
+// entry at the specified level with a page table whose entries map the same
+// physical memory region using the next smaller page size and flags. The new
+// table is populated before being installed so the region remains accessible
+// while the split is in progress.
+func splitHugePage(virtAddr uintptr, pteLevel uint8, pte *pageTableEntry) *kernel.Error {
+	// The top-most page level does not support huge pages
+	if pteLevel < hugePageMinLevel {
+		return errNoHugePageSupport
+	}
+
+	tableFrame, err := mm.AllocFrame()
+	if err != nil {
+		return err
+	}
+
+	tablePage, err := mapTemporaryFn(tableFrame)
+	if err != nil {
+		return err
+	}
+
+	var (
+		hugePageSize = uintptr(1) << pageLevelShifts[pteLevel]
+		childFrames  = mm.Frame(1) << (uintptr(pageLevelShifts[pteLevel+1]) - mm.PageShift)
+		baseFrame    = mm.FrameFromAddress(pte.Frame().Address() &^ (hugePageSize - 1))
+		childFlags   = PageTableEntryFlag(uintptr(*pte) &^ ptePhysPageMask)
+	)
+
+	if pteLevel+1 == pageLevels-1 {
+		childFlags &^= FlagHugePage
+	}
+
+	for index := uintptr(0); index < 1<<pageLevelBits[pteLevel+1]; index++ {
+		entry := (*pageTableEntry)(unsafe.Pointer(tablePage.Address() + (index << mm.PointerShift)))
+		*entry = 0
+		entry.SetFrame(baseFrame + mm.Frame(index)*childFrames)
+		entry.SetFlags(childFlags)
+	}
+	_ = unmapFn(tablePage)
+
+	// Point the entry to the new table and invalidate the cached huge
+	// page translation
+	userFlag := PageTableEntryFlag(uintptr(*pte)) & FlagUserAccessible
+	*pte = 0
+	pte.SetFrame(tableFrame)
+	pte.SetFlags(FlagPresent | FlagRW | userFlag)
+	invalidatePage(virtAddr &^ (hugePageSize - 1))
+
+	return nil
+}
+
+// mapLargestPage maps the physical memory starting at frame to virtAddr using
+// the largest page size that does not exceed maxSize and is compatible with
+// the alignment of both addresses. Huge pages are only considered if flags
+// include FlagHugePage. The function returns the size of the mapped page.
+func mapLargestPage(virtAddr uintptr, frame mm.Frame, maxSize uintptr, flags PageTableEntryFlag) (uintptr, *kernel.Error) {
+	if flags&FlagHugePage != 0 {
+		for level := largestPageLevel; level < pageLevels-1; level++ {
+			pageSize := uintptr(1) << pageLevelShifts[level]
+			if maxSize < pageSize || (virtAddr|frame.Address())&(pageSize-1) != 0 {
+				continue
+			}
+
+			switch err := mapHugePageFn(virtAddr, frame, flags, level); err {
+			case nil:
+				return pageSize, nil
+			case errHugePageTableInUse:
+				// Region already mapped by a page table; fall
+				// back to smaller pages
+			default:
+				return 0, err
+			}
+		}
+	}
+
+	return mm.PageSize, mapFn(mm.PageFromAddress(virtAddr), frame, flags&^FlagHugePage)
+}
+
 // MapRegion establishes a mapping to the physical mmory region which starts
 // at the given frame and ends at frame + pages(size). The size argument is
 // always rounded up to the nearest page boundary. MapRegion reserves the next
 // available region in the active virtual address space, establishes the
 // mapping and returns back the Page that corresponds to the region start.
+//
+// If flags include FlagHugePage, MapRegion aligns the reserved region so that
+// the largest possible portion of it can be mapped using huge pages. This
+// reduces the number of TLB entries required for large MMIO regions.
 func MapRegion(frame mm.Frame, size uintptr, flags PageTableEntryFlag) (mm.Page, *kernel.Error) {
+	var (
+		startAddr uintptr
+		err       *kernel.Error
+	)
+
 	// Reserve next free block in the address space
 	size = (size + (mm.PageSize - 1)) & ^(mm.PageSize - 1)
-	startPage, err := earlyReserveRegionFn(size)
+	if flags&FlagHugePage != 0 {
+		hugePageSize := largestPageSizeFor(size)
+		startAddr, err = earlyReserveAlignedRegion(size, hugePageSize, frame.Address()&(hugePageSize-1))
+	} else {
+		startAddr, err = earlyReserveRegionFn(size)
+	}
+
 	if err != nil {
 		return 0, err
 	}
 
-	pageCount := size >> mm.PageShift
-	for page := mm.PageFromAddress(startPage); pageCount > 0; pageCount, page, frame = pageCount-1, page+1, frame+1 {
-		if err := mapFn(page, frame, flags); err != nil {
-			return 0, err
-		}
+	if err = mapPhysRegion(startAddr, frame, size, flags); err != nil {
+		return 0, err
 	}
 
-	return mm.PageFromAddress(startPage), nil
+	return mm.PageFromAddress(startAddr), nil
 }
 
 // IdentityMapRegion establishes an identity mapping to the physical mmory
 // region which starts at the given frame and ends at frame + pages(size). The
 // size argument is always rounded up to the nearest page boundary.
 // IdentityMapRegion returns back the Page that corresponds to the region
-// start.
+// start. If flags include FlagHugePage, huge pages will be used for the
+// suitably aligned parts of the region.
 func IdentityMapRegion(startFrame mm.Frame, size uintptr, flags PageTableEntryFlag) (mm.Page, *kernel.Error) {
-	startPage := mm.Page(startFrame)
-	pageCount := mm.Page(((size + (mm.PageSize - 1)) & ^(mm.PageSize - 1)) >> mm.PageShift)
+	size = (size + (mm.PageSize - 1)) & ^(mm.PageSize - 1)
+	if err := mapPhysRegion(startFrame.Address(), startFrame, size, flags); err != nil {
+		return 0, err
+	}
 
-	for curPage := startPage; curPage < startPage+pageCount; curPage++ {
-		if err := mapFn(curPage, mm.Frame(curPage), flags); err != nil {
-			return 0, err
+	return mm.Page(startFrame), nil
+}
+
+// mapPhysRegion maps size bytes of physical memory starting at frame to the
+// virtual address virtAddr.
+func mapPhysRegion(virtAddr uintptr, frame mm.Frame, size uintptr, flags PageTableEntryFlag) *kernel.Error {
+	for size > 0 {
+		pageSize, err := mapLargestPage(virtAddr, frame, size, flags)
+		if err != nil {
+			return err
 		}
+
+		virtAddr, frame, size = virtAddr+pageSize, frame+mm.Frame(pageSize>>mm.PageShift), size-pageSize
 	}
 
-	return startPage, nil
+	return nil
+}
+
+// largestPageSizeFor returns the largest page size supported by the CPU that
+// does not exceed size.
+func largestPageSizeFor(size uintptr) uintptr {
+	for level := largestPageLevel; level < pageLevels-1; level++ {
+		if pageSize := uintptr(1) << pageLevelShifts[level]; size >= pageSize {
+			return pageSize
+		}
+	}
+
+	return mm.PageSize
 }
 
 // MapTemporary establishes a temporary RW mapping of a physical mmory frame
@@ -165,16 +347,21 @@ func MapTemporary(frame mm.Frame) (mm.Page, *kernel.Error) {
 	return mm.PageFromAddress(tempMappingAddr), nil
 }
 
-// Unmap removes a mapping previously installed via a call to Map or MapTemporary.
+// Unmap removes a mapping previously installed via a call to Map or
+// MapTemporary. If the page is covered by a huge page mapping, Unmap will
+// split it so that only the requested page is unmapped.
 func Unmap(page mm.Page) *kernel.Error {
 	var err *kernel.Error
 
+	beginShootdown()
+	defer endShootdown()
+
 	walk(page.Address(), func(pteLevel uint8, pte *pageTableEntry) bool {
 		// If we reached the last level all we need to do is to set the
 		// page as non-present and flush its TLB entry
 		if pteLevel == pageLevels-1 {
 			pte.ClearFlags(FlagPresent)
-			flushTLBEntryFn(page.Address())
+			invalidatePage(page.Address())
 			return true
 		}
 
@@ -185,8 +372,8 @@ func Unmap(page mm.Page) *kernel.Error {
 		}
 
 		if pte.HasFlags(FlagHugePage) {
-			err = errNoHugePageSupport
-			return false
+			err = splitHugePage(page.Address(), pteLevel, pte)
+			return err == nil
 		}
 
 		return true
@@ -195,19 +382,64 @@ func Unmap(page mm.Page) *kernel.Error {
 	return err
 }
 
+// Mapping describes how a virtual address is mapped to physical memory.
+type Mapping struct {
+	// PhysAddr is the physical address that corresponds to the looked up
+	// virtual address.
+	PhysAddr uintptr
+
+	// Frame is the physical frame that contains PhysAddr.
+	Frame mm.Frame
+
+	// Flags contains the flags of the page table entry that maps the
+	// page. Flags inherited from entries in the upper page table levels
+	// are not taken into account.
+	Flags PageTableEntryFlag
+
+	// Level is the page table level of the entry that maps the page. It
+	// is equal to pageLevels-1 for regular pages and less than that for
+	// huge pages.
+	Level uint8
+
+	// PageSize is the size of the page that contains the looked up
+	// virtual address.
+	PageSize uintptr
+}
+
+// Lookup performs a page table walk for the supplied virtual address and
+// returns a Mapping describing it or ErrInvalidMapping if the virtual address
+// is not mapped.
+func Lookup(virtAddr uintptr) (Mapping, *kernel.Error) {
+	pte, level, err := pteForAddress(virtAddr)
+	if err != nil {
+		return Mapping{}, err
+	}
+
+	// Calculate the physical address by taking the physical frame address and
+	// appending the offset from the virtual address
+	pageSize := uintptr(1) << pageLevelShifts[level]
+	offsetMask := pageSize - 1
+	physAddr := (pte.Frame().Address() &^ offsetMask) + (virtAddr & offsetMask)
+
+	return Mapping{
+		PhysAddr: physAddr,
+		Frame:    mm.FrameFromAddress(physAddr),
+		Flags:    PageTableEntryFlag(uintptr(*pte) &^ ptePhysPageMask),
+		Level:    level,
+		PageSize: pageSize,
+	}, nil
+}
+
 // Translate returns the physical address that corresponds to the supplied
 // virtual address or ErrInvalidMapping if the virtual address does not
 // correspond to a mapped physical address.
 func Translate(virtAddr uintptr) (uintptr, *kernel.Error) {
-	pte, err := pteForAddress(virtAddr)
+	mapping, err := Lookup(virtAddr)
 	if err != nil {
 		return 0, err
 	}
 
-	// Calculate the physical address by taking the physical frame address and
-	// appending the offset from the virtual address
-	physAddr := pte.Frame().Address() + PageOffset(virtAddr)
-	return physAddr, nil
+	return mapping.PhysAddr, nil
 }
 
 // PageOffset returns the offset within the page specified by a virtual
@@ -423,3 +423,323 @@ func TestTranslateAmd64(t *testing.T) {
 		}
 	}
 }
+
+// fakePageTables emulates a page table hierarchy backed by Go memory. Entries
+// are resolved by following the frames stored in the entries of the previous
+// level, allowing huge page splits to be observed.
+type fakePageTables struct {
+	buf       []pageTableEntry
+	next      int
+	lastPte   *pageTableEntry
+	lastAlloc uintptr
+}
+
+func newFakePageTables(tableCount int) *fakePageTables {
+	const entriesPerTable = int(mm.PageSize >> mm.PointerShift)
+	f := &fakePageTables{buf: make([]pageTableEntry, (tableCount+1)*entriesPerTable)}
+
+	// Align tables to a page boundary
+	for uintptr(unsafe.Pointer(&f.buf[f.next]))&(mm.PageSize-1) != 0 {
+		f.next++
+	}
+	return f
+}
+
+func (f *fakePageTables) allocTable() uintptr {
+	addr := uintptr(unsafe.Pointer(&f.buf[f.next]))
+	f.next += int(mm.PageSize >> mm.PointerShift)
+	f.lastAlloc = addr
+	return addr
+}
+
+func (f *fakePageTables) table(addr uintptr) *[mm.PageSize >> mm.PointerShift]pageTableEntry {
+	return (*[mm.PageSize >> mm.PointerShift]pageTableEntry)(unsafe.Pointer(addr))
+}
+
+func (f *fakePageTables) install() (p4 *[mm.PageSize >> mm.PointerShift]pageTableEntry, restore func()) {
+	origPtePtr, origNextAddr, origFlush := ptePtrFn, nextAddrFn, flushTLBEntryFn
+	origMapTemp, origUnmap := mapTemporaryFn, unmapFn
+
+	p4 = f.table(f.allocTable())
+	ptePtrFn = func(entry uintptr) unsafe.Pointer {
+		pteIndex := (entry & uintptr(mm.PageSize-1)) >> mm.PointerShift
+		if entry&^uintptr(mm.PageSize-1) == pdtVirtualAddr {
+			// Follow the recursive entry if it has been pointed to
+			// an inactive PDT
+			root := p4
+			if last := p4[len(p4)-1]; last.HasFlags(FlagPresent) {
+				root = f.table(last.Frame().Address())
+			}
+			f.lastPte = &root[pteIndex]
+		} else {
+			f.lastPte = &f.table(f.lastPte.Frame().Address())[pteIndex]
+		}
+		return unsafe.Pointer(f.lastPte)
+	}
+	nextAddrFn = func(uintptr) uintptr { return f.lastAlloc }
+	flushTLBEntryFn = func(uintptr) {}
+	mapTemporaryFn = func(frame mm.Frame) (mm.Page, *kernel.Error) { return mm.Page(frame), nil }
+	unmapFn = func(mm.Page) *kernel.Error { return nil }
+	mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) {
+		return mm.FrameFromAddress(f.allocTable()), nil
+	})
+
+	return p4, func() {
+		ptePtrFn, nextAddrFn, flushTLBEntryFn = origPtePtr, origNextAddr, origFlush
+		mapTemporaryFn, unmapFn = origMapTemp, origUnmap
+		mm.SetFrameAllocator(nil)
+	}
+}
+
+func TestMapHugePageAmd64(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	const (
+		size1G = uintptr(1 << 30)
+		size2M = uintptr(2 << 20)
+	)
+
+	f := newFakePageTables(8)
+	p4, restore := f.install()
+	defer restore()
+
+	frame1G := mm.FrameFromAddress(4 * size1G)
+	frame2M := mm.FrameFromAddress(7 * size2M)
+	flags := FlagPresent | FlagRW | FlagNoExecute
+
+	if err := mapHugePage(size1G, frame1G, flags, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := mapHugePage(3*size2M, frame2M, flags, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	p3 := f.table(p4[0].Frame().Address())
+	if pte := p3[1]; !pte.HasFlags(flags|FlagHugePage) || pte.Frame() != frame1G {
+		t.Errorf("expected PDPT entry to map 1G page at frame %d; got %x", frame1G, pte)
+	}
+
+	p2 := f.table(p3[0].Frame().Address())
+	if pte := p2[3]; !pte.HasFlags(flags|FlagHugePage) || pte.Frame() != frame2M {
+		t.Errorf("expected PD entry to map 2M page at frame %d; got %x", frame2M, pte)
+	}
+
+	specs := []struct {
+		virtAddr, level uintptr
+		expErr          *kernel.Error
+	}{
+		{0, 0, errNoHugePageSupport},
+		{0, pageLevels - 1, errNoHugePageSupport},
+		// PDPT entry 0 points to the PD table
+		{0, 1, errHugePageTableInUse},
+		// Remap an existing huge page
+		{3 * size2M, 2, nil},
+	}
+
+	for specIndex, spec := range specs {
+		if err := mapHugePage(spec.virtAddr, frame2M, flags, uint8(spec.level)); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+}
+
+func TestSplitHugePageAmd64(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	const (
+		size1G = uintptr(1 << 30)
+		size2M = uintptr(2 << 20)
+	)
+
+	f := newFakePageTables(8)
+	p4, restore := f.install()
+	defer restore()
+
+	baseFrame := mm.FrameFromAddress(4 * size1G)
+	hugeFlags := FlagPresent | FlagRW | FlagNoExecute
+	if err := mapHugePage(0, baseFrame, hugeFlags, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	// Mapping a 4K page inside the 1G page splits it into 2M pages and
+	// then splits the 2M page that contains the address into 4K pages
+	if err := Map(mm.PageFromAddress(size2M+0x1000), mm.Frame(999), FlagPresent|FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	p3 := f.table(p4[0].Frame().Address())
+	if p3[0].HasFlags(FlagHugePage) || !p3[0].HasFlags(FlagPresent|FlagRW) {
+		t.Fatalf("expected PDPT entry to point to a page table; got %x", p3[0])
+	}
+
+	p2 := f.table(p3[0].Frame().Address())
+	for index, pte := range p2 {
+		if index == 1 {
+			continue
+		}
+
+		if exp := baseFrame + mm.Frame(index<<9); !pte.HasFlags(hugeFlags|FlagHugePage) || pte.Frame() != exp {
+			t.Fatalf("expected PD entry %d to map a 2M page at frame %d; got %x", index, exp, pte)
+		}
+	}
+
+	p1 := f.table(p2[1].Frame().Address())
+	for index, pte := range p1 {
+		expFrame, expFlags := baseFrame+mm.Frame(512+index), hugeFlags
+		if index == 1 {
+			expFrame, expFlags = mm.Frame(999), FlagPresent|FlagRW
+		}
+
+		if pte.HasFlags(FlagHugePage) || !pte.HasFlags(expFlags) || pte.Frame() != expFrame {
+			t.Fatalf("expected PT entry %d to map frame %d; got %x", index, expFrame, pte)
+		}
+	}
+
+	translateSpecs := []struct {
+		virtAddr, expPhysAddr uintptr
+	}{
+		{size2M + 0x1010, mm.Frame(999).Address() + 0x10},
+		{size2M + 0x2020, 4*size1G + size2M + 0x2020},
+		{5*size2M + 0x1234, 4*size1G + 5*size2M + 0x1234},
+	}
+
+	for specIndex, spec := range translateSpecs {
+		if got, err := Translate(spec.virtAddr); err != nil || got != spec.expPhysAddr {
+			t.Errorf("[spec %d] expected 0x%x to translate to 0x%x; got 0x%x, %v", specIndex, spec.virtAddr, spec.expPhysAddr, got, err)
+		}
+	}
+
+	// Unmapping a page inside a 2M page splits it
+	if err := Unmap(mm.PageFromAddress(5*size2M + 0x3000)); err != nil {
+		t.Fatal(err)
+	}
+
+	if p2[5].HasFlags(FlagHugePage) {
+		t.Fatal("expected 2M page to be split by Unmap")
+	}
+
+	if _, err := Translate(5*size2M + 0x3000); err != ErrInvalidMapping {
+		t.Fatalf("expected unmapped page not to translate; got %v", err)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "something went wrong"}
+
+		p2[7].SetFlags(FlagHugePage)
+		mapTemporaryFn = func(mm.Frame) (mm.Page, *kernel.Error) { return 0, expErr }
+		if err := Unmap(mm.PageFromAddress(7 * size2M)); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) { return mm.InvalidFrame, expErr })
+		if err := Map(mm.PageFromAddress(7*size2M), mm.Frame(1), FlagPresent); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if !p2[7].HasFlags(FlagHugePage) {
+			t.Error("expected huge page to remain intact when splitting fails")
+		}
+	})
+}
+
+func TestMapRegionHugePages(t *testing.T) {
+	defer func(origLastUsed uintptr, origLargestPageLevel uint8) {
+		mapFn = Map
+		mapHugePageFn = mapHugePage
+		earlyReserveLastUsed = origLastUsed
+		largestPageLevel = origLargestPageLevel
+	}(earlyReserveLastUsed, largestPageLevel)
+
+	const (
+		size1G = uintptr(1 << 30)
+		size2M = uintptr(2 << 20)
+	)
+
+	type mapping struct {
+		virtAddr, physAddr, size uintptr
+	}
+
+	var mappings []mapping
+	mapFn = func(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
+		if flags&FlagHugePage != 0 {
+			t.Errorf("expected FlagHugePage to be cleared for 4K mappings")
+		}
+		mappings = append(mappings, mapping{page.Address(), frame.Address(), mm.PageSize})
+		return nil
+	}
+
+	var tableInUseLevel uint8
+	mapHugePageFn = func(virtAddr uintptr, frame mm.Frame, _ PageTableEntryFlag, level uint8) *kernel.Error {
+		if level == tableInUseLevel {
+			return errHugePageTableInUse
+		}
+		mappings = append(mappings, mapping{virtAddr, frame.Address(), uintptr(1) << pageLevelShifts[level]})
+		return nil
+	}
+
+	t.Run("MapRegion", func(t *testing.T) {
+		mappings, tableInUseLevel = nil, 0
+		largestPageLevel = hugePageMinLevel
+		earlyReserveLastUsed = tempMappingAddr
+
+		physAddr := size1G - size2M - mm.PageSize
+		page, err := MapRegion(mm.FrameFromAddress(physAddr), mm.PageSize+size2M+size1G+1, FlagPresent|FlagRW|FlagHugePage)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		virtAddr := page.Address()
+		if virtAddr&(size1G-1) != physAddr&(size1G-1) {
+			t.Fatalf("expected virtual address 0x%x to be congruent to 0x%x modulo 1G", virtAddr, physAddr)
+		}
+
+		exp := []mapping{
+			{virtAddr, physAddr, mm.PageSize},
+			{virtAddr + mm.PageSize, physAddr + mm.PageSize, size2M},
+			{virtAddr + mm.PageSize + size2M, physAddr + mm.PageSize + size2M, size1G},
+			{virtAddr + mm.PageSize + size2M + size1G, physAddr + mm.PageSize + size2M + size1G, mm.PageSize},
+		}
+
+		if len(mappings) != len(exp) {
+			t.Fatalf("expected %d mappings; got %d", len(exp), len(mappings))
+		}
+
+		for index := range exp {
+			if mappings[index] != exp[index] {
+				t.Errorf("[mapping %d] expected %+v; got %+v", index, exp[index], mappings[index])
+			}
+		}
+	})
+
+	t.Run("IdentityMapRegion falls back to smaller pages", func(t *testing.T) {
+		mappings, tableInUseLevel = nil, 2
+		largestPageLevel = pageLevels - 2
+
+		if _, err := IdentityMapRegion(mm.FrameFromAddress(size2M), size2M, FlagPresent|FlagHugePage); err != nil {
+			t.Fatal(err)
+		}
+
+		if exp := int(size2M / mm.PageSize); len(mappings) != exp {
+			t.Fatalf("expected %d 4K mappings; got %d", exp, len(mappings))
+		}
+	})
+
+	t.Run("errors", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "map failed"}
+		mapHugePageFn = func(uintptr, mm.Frame, PageTableEntryFlag, uint8) *kernel.Error { return expErr }
+
+		if _, err := IdentityMapRegion(mm.FrameFromAddress(size2M), size2M, FlagPresent|FlagHugePage); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		earlyReserveLastUsed = size2M
+		if _, err := MapRegion(mm.FrameFromAddress(size2M), 2*size2M, FlagPresent|FlagHugePage); err != errEarlyReserveNoSpace {
+			t.Errorf("expected to get error %v; got %v", errEarlyReserveNoSpace, err)
+		}
+	})
+}
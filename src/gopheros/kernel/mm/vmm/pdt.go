@@ -20,11 +20,15 @@ var (
 	// mapFn is used by tests and is automatically inlined by the compiler.
 	mapFn = Map
 
-	// mapTemporaryFn is used by tests and is automatically inlined by the compiler.
-	mapTemporaryFn = MapTemporary
+	// mapTemporaryFn is used by tests and is automatically inlined by the
+	// compiler. It is set up by init as Map depends on it when splitting
+	// huge pages.
+	mapTemporaryFn func(mm.Frame) (mm.Page, *kernel.Error)
 
-	// unmapmFn is used by tests and is automatically inlined by the compiler.
-	unmapFn = Unmap
+	// unmapmFn is used by tests and is automatically inlined by the
+	// compiler. It is set up by init as Unmap depends on it when splitting
+	// huge pages.
+	unmapFn func(mm.Page) *kernel.Error
 
 	// visitElfSectionsFn is used by tests and is automatically inlined
 	// by the compiler.
@@ -36,6 +40,11 @@ var (
 	kernelPDT PageDirectoryTable
 )
 
+func init() {
+	mapTemporaryFn = MapTemporary
+	unmapFn = Unmap
+}
+
 // PageDirectoryTable describes the top-most table in a multi-level paging scheme.
 type PageDirectoryTable struct {
 	pdtFrame mm.Frame
@@ -83,29 +92,9 @@ func (pdt *PageDirectoryTable) Init(pdtFrame mm.Frame) *kernel.Error {
 // establishing a temporary mapping so that Map() can access the inactive PDT
 // entries.
 func (pdt PageDirectoryTable) Map(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
-	var (
-		activePdtFrame   = mm.Frame(activePDTFn() >> mm.PageShift)
-		lastPdtEntryAddr uintptr
-		lastPdtEntry     *pageTableEntry
-	)
-	// If this table is not active we need to temporarily map it to the
-	// last entry in the active PDT so we can access it using the recursive
-	// virtual address scheme.
-	if activePdtFrame != pdt.pdtFrame {
-		lastPdtEntryAddr = activePdtFrame.Address() + (((1 << pageLevelBits[0]) - 1) << mm.PointerShift)
-		lastPdtEntry = (*pageTableEntry)(unsafe.Pointer(lastPdtEntryAddr))
-		lastPdtEntry.SetFrame(pdt.pdtFrame)
-		flushTLBEntryFn(lastPdtEntryAddr)
-	}
-
-	err := mapFn(page, frame, flags)
-
-	if activePdtFrame != pdt.pdtFrame {
-		lastPdtEntry.SetFrame(activePdtFrame)
-		flushTLBEntryFn(lastPdtEntryAddr)
-	}
-
-	return err
+	return pdt.run(func() *kernel.Error {
+		return mapFn(page, frame, flags)
+	})
 }
 
 // Unmap removes a mapping previousle installed by a call to Map() on this PDT.
@@ -113,6 +102,15 @@ func (pdt PageDirectoryTable) Map(page mm.Page, frame mm.Frame, flags PageTableE
 // the difference that it also supports inactive page PDTs by establishing a
 // temporary mapping so that Unmap() can access the inactive PDT entries.
 func (pdt PageDirectoryTable) Unmap(page mm.Page) *kernel.Error {
+	return pdt.run(func() *kernel.Error {
+		return unmapFn(page)
+	})
+}
+
+// run invokes fn so that any page table walks it performs operate on the
+// entries of this PDT, even if the PDT is not active. Page invalidations
+// issued by fn are delivered to the CPUs that have this PDT active.
+func (pdt PageDirectoryTable) run(fn func() *kernel.Error) *kernel.Error {
 	var (
 		activePdtFrame   = mm.Frame(activePDTFn() >> mm.PageShift)
 		lastPdtEntryAddr uintptr
@@ -128,7 +126,10 @@ func (pdt PageDirectoryTable) Unmap(page mm.Page) *kernel.Error {
 		flushTLBEntryFn(lastPdtEntryAddr)
 	}
 
-	err := unmapFn(page)
+	// Invalidations must be sent to the CPUs that have this PDT active
+	prevTarget := setShootdownTarget(pdt.pdtFrame.Address())
+	err := fn()
+	setShootdownTarget(prevTarget)
 
 	if activePdtFrame != pdt.pdtFrame {
 		lastPdtEntry.SetFrame(activePdtFrame)
@@ -140,14 +141,18 @@ func (pdt PageDirectoryTable) Unmap(page mm.Page) *kernel.Error {
 
 // Activate enables this page directory table and flushes the TLB
 func (pdt PageDirectoryTable) Activate() {
+	noteActivePDT(pdt.pdtFrame.Address())
 	switchPDTFn(pdt.pdtFrame.Address())
 }
 
 // setupPDTForKernel queries the multiboot package for the ELF sections that
 // correspond to the loaded kernel image and establishes a new granular PDT for
 // the kernel's VMA using the appropriate flags (e.g. NX for data sections, RW
-// for writable sections e.t.c).
-func setupPDTForKernel(kernelPageOffset uintptr) *kernel.Error {
+// for writable sections e.t.c). The ELF sections report the addresses that the
+// kernel was linked at; each section is mapped kernelSlide bytes above its
+// link address so that the new PDT matches the randomized base that the rt0
+// code relocated the kernel image to.
+func setupPDTForKernel(kernelPageOffset, kernelSlide uintptr) *kernel.Error {
 	// Allocate frame for the page directory and initialize it
 	kernelPDTFrame, err := mm.AllocFrame()
 	if err != nil {
@@ -180,10 +185,10 @@ func setupPDTForKernel(kernelPageOffset uintptr) *kernel.Error {
 		// Map the start and end VMA addresses for the section contents
 		// into a start and end (inclusive) page number. To figure out
 		// the physical start frame we just need to subtract the
-		// kernel's VMA offset from the virtual address and round that
+		// kernel's VMA offset from the link address and round that
 		// down to the nearest frame number.
-		curPage := mm.PageFromAddress(secAddress)
-		lastPage := mm.PageFromAddress(secAddress + uintptr(secSize-1))
+		curPage := mm.PageFromAddress(secAddress + kernelSlide)
+		lastPage := mm.PageFromAddress(secAddress + kernelSlide + uintptr(secSize-1))
 		curFrame := mm.Frame((secAddress - kernelPageOffset) >> mm.PageShift)
 		for ; curPage <= lastPage; curFrame, curPage = curFrame+1, curPage+1 {
 			if err = kernelPDT.Map(curPage, curFrame, flags); err != nil {
@@ -213,7 +218,7 @@ func setupPDTForKernel(kernelPageOffset uintptr) *kernel.Error {
 			return err
 		}
 
-		if err = kernelPDT.Map(page, mm.Frame(frameAddr>>mm.PageShift), FlagPresent|FlagRW); err != nil {
+		if err = kernelPDT.Map(page, mm.Frame(frameAddr>>mm.PageShift), FlagPresent|FlagRW|FlagNoExecute); err != nil {
 			return err
 		}
 	}
@@ -221,6 +226,7 @@ func setupPDTForKernel(kernelPageOffset uintptr) *kernel.Error {
 	// Activate the new PDT. After this point, the identify mapping for the
 	// physical mmory addresses where the kernel is loaded becomes invalid.
 	kernelPDT.Activate()
+	kernelAddressSpace.pdt = kernelPDT
 
 	return nil
 }
@@ -235,7 +241,7 @@ func noEscape(p unsafe.Pointer) unsafe.Pointer {
 
 var (
 	// ErrInvalidMapping is returned when trying to lookup a virtual memory address that is not yet mapped.
-	ErrInvalidMapping = &kernel.Error{Module: "vmm", Message: "virtual address does not point to a mapped physical page"}
+	ErrInvalidMapping = &kernel.Error{Module: "vmm", Message: "virtual address does not point to a mapped physical page", Code: kernel.ErrCodeFault}
 )
 
 // PageTableEntryFlag describes a flag that can be applied to a page table entry.
@@ -277,13 +283,15 @@ func (pte *pageTableEntry) SetFrame(frame mm.Frame) {
 }
 
 // pteForAddress returns the final page table entry that correspond to a
-// particular virtual address. The function performs a page table walk till it
-// reaches the final page table entry returning ErrInvalidMapping if the page
-// is not present.
-func pteForAddress(virtAddr uintptr) (*pageTableEntry, *kernel.Error) {
+// particular virtual address together with its page level. The function
+// performs a page table walk till it reaches the final page table entry or an
+// entry that maps a huge page, returning ErrInvalidMapping if the page is not
+// present.
+func pteForAddress(virtAddr uintptr) (*pageTableEntry, uint8, *kernel.Error) {
 	var (
 		err   *kernel.Error
 		entry *pageTableEntry
+		level uint8
 	)
 
 	walk(virtAddr, func(pteLevel uint8, pte *pageTableEntry) bool {
@@ -293,11 +301,11 @@ func pteForAddress(virtAddr uintptr) (*pageTableEntry, *kernel.Error) {
 			return false
 		}
 
-		entry = pte
-		return true
+		entry, level = pte, pteLevel
+		return pteLevel < hugePageMinLevel || !pte.HasFlags(FlagHugePage)
 	})
 
-	return entry, err
+	return entry, level, err
 }
 
 var (
@@ -396,7 +396,7 @@ func TestSetupPDTForKernel(t *testing.T) {
 			return nil
 		}
 
-		if err := setupPDTForKernel(0x123); err != nil {
+		if err := setupPDTForKernel(0x123, 0); err != nil {
 			t.Fatal(err)
 		}
 
@@ -405,6 +405,35 @@ func TestSetupPDTForKernel(t *testing.T) {
 		}
 	})
 
+	t.Run("map kernel sections at randomized base", func(t *testing.T) {
+		defer func() { visitElfSectionsFn = multiboot.VisitElfSections }()
+
+		var (
+			pageOffset = uintptr(0x1000000)
+			slide      = uintptr(0x40200000)
+		)
+		visitElfSectionsFn = func(v multiboot.ElfSectionVisitor) {
+			v(".text", multiboot.ElfSectionExecutable, pageOffset+0x100000, uint64(mm.PageSize))
+		}
+
+		var mapped [][2]uintptr
+		mapFn = func(page mm.Page, frame mm.Frame, _ PageTableEntryFlag) *kernel.Error {
+			mapped = append(mapped, [2]uintptr{page.Address(), frame.Address()})
+			return nil
+		}
+
+		if err := setupPDTForKernel(pageOffset, slide); err != nil {
+			t.Fatal(err)
+		}
+
+		// The section should be mapped at its link address plus the
+		// slide but still be backed by the frames it was loaded at
+		exp := [][2]uintptr{{pageOffset + 0x100000 + slide, 0x100000}}
+		if len(mapped) < 1 || mapped[0] != exp[0] {
+			t.Fatalf("expected section to be mapped as %x; got %x", exp, mapped)
+		}
+	})
+
 	t.Run("map of kernel sections fials", func(t *testing.T) {
 		defer func() { visitElfSectionsFn = multiboot.VisitElfSections }()
 		expErr := &kernel.Error{Module: "test", Message: "map failed"}
@@ -426,7 +455,7 @@ func TestSetupPDTForKernel(t *testing.T) {
 			return expErr
 		}
 
-		if err := setupPDTForKernel(0); err != expErr {
+		if err := setupPDTForKernel(0, 0); err != expErr {
 			t.Fatalf("expected error: %v; got %v", expErr, err)
 		}
 	})
@@ -459,7 +488,7 @@ func TestSetupPDTForKernel(t *testing.T) {
 			return nil
 		}
 
-		if err := setupPDTForKernel(0); err != nil {
+		if err := setupPDTForKernel(0, 0); err != nil {
 			t.Fatal(err)
 		}
 	})
@@ -474,7 +503,7 @@ func TestSetupPDTForKernel(t *testing.T) {
 		activePDTFn = func() uintptr { return 0 }
 		mapTemporaryFn = func(f mm.Frame) (mm.Page, *kernel.Error) { return 0, expErr }
 
-		if err := setupPDTForKernel(0); err != expErr {
+		if err := setupPDTForKernel(0, 0); err != expErr {
 			t.Fatalf("expected error: %v; got %v", expErr, err)
 		}
 	})
@@ -494,7 +523,7 @@ func TestSetupPDTForKernel(t *testing.T) {
 			return 0, expErr
 		}
 
-		if err := setupPDTForKernel(0); err != expErr {
+		if err := setupPDTForKernel(0, 0); err != expErr {
 			t.Fatalf("expected error: %v; got %v", expErr, err)
 		}
 	})
@@ -514,7 +543,7 @@ func TestSetupPDTForKernel(t *testing.T) {
 		mapTemporaryFn = func(f mm.Frame) (mm.Page, *kernel.Error) { return mm.Page(f), nil }
 		mapFn = func(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error { return expErr }
 
-		if err := setupPDTForKernel(0); err != expErr {
+		if err := setupPDTForKernel(0, 0); err != expErr {
 			t.Fatalf("expected error: %v; got %v", expErr, err)
 		}
 	})
@@ -0,0 +1,189 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+)
+
+// protectFlagMask contains the page table entry flags that can be modified
+// via a call to Protect.
+const protectFlagMask = FlagRW | FlagNoExecute
+
+var errProtectEmpty = &kernel.Error{Module: "vmm", Message: "protected range must contain at least one page", Code: kernel.ErrCodeInvalidArgument}
+
+// Protect changes the access permissions of the pages in the range of size
+// bytes starting at startPage to the FlagRW and FlagNoExecute bits of flags;
+// all other flags are ignored. If size is not a multiple of mm.PageSize it
+// will be automatically rounded up. Like Map, Protect applies the W^X policy
+// to the new permissions.
+//
+// Huge pages that are only partially covered by the range are split and
+// demand regions that overlap the range are split so that pages populated in
+// the future also receive the new permissions. Pages mapped to
+// ReservedZeroedFrame are made writable by flagging them as copy-on-write.
+// The TLB entries for the modified pages are invalidated on all CPUs that may
+// have cached them.
+//
+// Each page in the range must either be mapped or belong to a demand region;
+// otherwise, Protect returns ErrInvalidMapping without modifying any page.
+func Protect(startPage mm.Page, size uintptr, flags PageTableEntryFlag) *kernel.Error {
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	if pageCount == 0 {
+		return errProtectEmpty
+	}
+
+	var (
+		start  = startPage.Address()
+		length = pageCount << mm.PageShift
+	)
+
+	// Hold the demand lock so that pages in demand regions cannot be
+	// populated with stale permissions while the range is updated
+	demandLock.Acquire()
+	defer demandLock.Release()
+
+	for offset := uintptr(0); offset < length; {
+		virtAddr := start + offset
+		mapping, err := Lookup(virtAddr)
+		if err != nil {
+			if findDemandRegion(mm.PageFromAddress(virtAddr)) < 0 {
+				return err
+			}
+
+			offset += mm.PageSize
+			continue
+		}
+
+		offset = (virtAddr &^ (mapping.PageSize - 1)) + mapping.PageSize - start
+	}
+
+	if err := protectDemandRegions(startPage, startPage+mm.Page(pageCount), flags); err != nil {
+		return err
+	}
+
+	beginShootdown()
+	defer endShootdown()
+
+	for offset := uintptr(0); offset < length; {
+		step, err := protectPage(start+offset, length-offset, flags)
+		if err != nil {
+			return err
+		}
+		offset += step
+	}
+
+	return nil
+}
+
+// protectPage updates the permissions of the page that contains virtAddr. If
+// the page is a huge page that lies entirely within the remaining bytes of
+// the range, its entry is updated in place; otherwise, the huge page is split
+// until virtAddr is mapped by a regular page. The function returns the number
+// of bytes covered by the updated entry.
+func protectPage(virtAddr, remaining uintptr, flags PageTableEntryFlag) (uintptr, *kernel.Error) {
+	var (
+		step = mm.PageSize
+		err  *kernel.Error
+	)
+
+	walk(virtAddr, func(pteLevel uint8, pte *pageTableEntry) bool {
+		// Demand pages that have not been populated yet do not need to
+		// be updated
+		if !pte.HasFlags(FlagPresent) {
+			return false
+		}
+
+		if pteLevel == pageLevels-1 {
+			protectEntry(virtAddr, pte, flags)
+			return false
+		}
+
+		if !pte.HasFlags(FlagHugePage) {
+			return true
+		}
+
+		if pageSize := uintptr(1) << pageLevelShifts[pteLevel]; virtAddr&(pageSize-1) == 0 && remaining >= pageSize {
+			protectEntry(virtAddr, pte, flags)
+			step = pageSize
+			return false
+		}
+
+		err = splitHugePage(virtAddr, pteLevel, pte)
+		return err == nil
+	})
+
+	return step, err
+}
+
+// protectEntry applies the permissions in flags to the page table entry that
+// maps virtAddr and invalidates its TLB entry if the entry was modified.
+func protectEntry(virtAddr uintptr, pte *pageTableEntry, flags PageTableEntryFlag) {
+	flags = wxFlags(virtAddr, flags)
+
+	newFlags := flags & FlagNoExecute
+	if flags&FlagRW != 0 {
+		// Shared frames must be copied before they can be written to
+		if pte.HasFlags(FlagCopyOnWrite) || isReservedZeroedFrame(pte.Frame()) {
+			newFlags |= FlagCopyOnWrite
+		} else {
+			newFlags |= FlagRW
+		}
+	}
+
+	oldEntry := *pte
+	pte.ClearFlags(protectFlagMask | FlagCopyOnWrite)
+	pte.SetFlags(newFlags)
+
+	if *pte != oldEntry {
+		invalidatePage(virtAddr)
+	}
+}
+
+// protectDemandRegions applies the permissions in flags to the demand regions
+// that overlap the page range [start, end). Regions that are only partially
+// covered by the range are split. Callers must hold demandLock.
+func protectDemandRegions(start, end mm.Page, flags PageTableEntryFlag) *kernel.Error {
+	// Each region that extends past either end of the range requires an
+	// additional slot
+	required := demandRegionCount
+	for index := 0; index < demandRegionCount; index++ {
+		region := &demandRegions[index]
+		if region.start >= end || region.end <= start {
+			continue
+		}
+
+		if region.start < start {
+			required++
+		}
+		if region.end > end {
+			required++
+		}
+	}
+
+	if required > maxDemandRegions {
+		return errDemandRegionLimit
+	}
+
+	for index, count := 0, demandRegionCount; index < count; index++ {
+		region := demandRegions[index]
+		if region.start >= end || region.end <= start {
+			continue
+		}
+
+		if region.start < start {
+			demandRegions[demandRegionCount] = demandRegion{start: region.start, end: start, flags: region.flags}
+			demandRegionCount++
+			demandRegions[index].start = start
+		}
+
+		if region.end > end {
+			demandRegions[demandRegionCount] = demandRegion{start: end, end: region.end, flags: region.flags}
+			demandRegionCount++
+			demandRegions[index].end = end
+		}
+
+		demandRegions[index].flags = (region.flags &^ protectFlagMask) | (flags & protectFlagMask)
+	}
+
+	return nil
+}
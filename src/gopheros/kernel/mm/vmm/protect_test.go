@@ -0,0 +1,161 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"reflect"
+	"runtime"
+	"testing"
+)
+
+func TestProtect(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	const size2M = uintptr(2 << 20)
+
+	f := newFakePageTables(8)
+	_, restore := f.install()
+	defer func(origZeroedFrame mm.Frame) {
+		restore()
+		ReservedZeroedFrame = origZeroedFrame
+		protectReservedZeroedPage = false
+	}(ReservedZeroedFrame)
+
+	var flushed []uintptr
+	flushTLBEntryFn = func(virtAddr uintptr) { flushed = append(flushed, virtAddr) }
+
+	ReservedZeroedFrame = mm.Frame(77)
+	protectReservedZeroedPage = true
+
+	rwFlags := FlagPresent | FlagRW | FlagNoExecute
+	for page := mm.Page(1); page <= 3; page++ {
+		if err := Map(page, mm.Frame(9)+mm.Frame(page), rwFlags); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := Map(mm.Page(5), ReservedZeroedFrame, FlagPresent|FlagNoExecute); err != nil {
+		t.Fatal(err)
+	}
+	for _, virtAddr := range []uintptr{size2M, 2 * size2M} {
+		if err := mapHugePage(virtAddr, mm.FrameFromAddress(virtAddr+size2M), rwFlags, 2); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	specs := []struct {
+		start      uintptr
+		size       uintptr
+		flags      PageTableEntryFlag
+		expErr     *kernel.Error
+		expFlushed []uintptr
+		checkAddr  uintptr
+		expFlags   PageTableEntryFlag
+		expLevel   uint8
+	}{
+		{0x1000, 0x2000, FlagPresent, nil, []uintptr{0x1000, 0x2000}, 0x2000, FlagPresent, 3},
+		{0x1000, 1, FlagRW, nil, []uintptr{0x1000}, 0x1000, FlagPresent | FlagRW | FlagNoExecute, 3},
+		// Unmodified entries are not flushed
+		{0x1000, 1, FlagRW | FlagNoExecute, nil, nil, 0x1000, FlagPresent | FlagRW | FlagNoExecute, 3},
+		{0x3000, 0x1000, FlagPresent, nil, []uintptr{0x3000}, 0x3000, FlagPresent, 3},
+		// Pages backed by the reserved zeroed frame become copy-on-write
+		{0x5000, 0x1000, FlagRW, nil, []uintptr{0x5000}, 0x5000, FlagPresent | FlagCopyOnWrite | FlagNoExecute, 3},
+		{0x5000, 0x1000, 0, nil, []uintptr{0x5000}, 0x5000, FlagPresent, 3},
+		// Partially covered huge pages are split
+		{size2M + 0x1000, 0x1000, FlagNoExecute, nil, []uintptr{size2M, size2M + 0x1000}, size2M + 0x1000, FlagPresent | FlagNoExecute, 3},
+		{2 * size2M, size2M, FlagNoExecute, nil, []uintptr{2 * size2M}, 2*size2M + 0x3000, FlagPresent | FlagNoExecute | FlagHugePage, 2},
+		// Ranges with unmapped pages are rejected without modifying
+		// any of the pages
+		{0x3000, 0x2000, FlagRW, ErrInvalidMapping, nil, 0x3000, FlagPresent, 3},
+		{0x1000, 0, FlagRW, errProtectEmpty, nil, 0x1000, FlagPresent | FlagRW | FlagNoExecute, 3},
+	}
+
+	for specIndex, spec := range specs {
+		flushed = nil
+		if err := Protect(mm.PageFromAddress(spec.start), spec.size, spec.flags); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(flushed, spec.expFlushed) {
+			t.Errorf("[spec %d] expected flushed TLB entries %x; got %x", specIndex, spec.expFlushed, flushed)
+		}
+
+		mapping, err := Lookup(spec.checkAddr)
+		if err != nil {
+			t.Errorf("[spec %d] lookup failed: %v", specIndex, err)
+			continue
+		}
+
+		if mapping.Flags != spec.expFlags || mapping.Level != spec.expLevel {
+			t.Errorf("[spec %d] expected 0x%x to be mapped at level %d with flags 0x%x; got level %d and flags 0x%x", specIndex, spec.checkAddr, spec.expLevel, spec.expFlags, mapping.Level, mapping.Flags)
+		}
+	}
+
+	// Pages of a split huge page that are outside the range retain their
+	// permissions
+	if mapping, _ := Lookup(size2M + 0x2000); mapping.Flags != rwFlags || mapping.PhysAddr != 2*size2M+0x2000 {
+		t.Errorf("expected page to retain its mapping and flags; got %+v", mapping)
+	}
+}
+
+func TestProtectDemandRegions(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	f := newFakePageTables(8)
+	_, restore := f.install()
+	defer func() {
+		restore()
+		resetDemandRegions()
+	}()
+	resetDemandRegions()
+
+	// Populate a page table so that the demand region pages are walked
+	// down to the last page level
+	if err := Map(mm.Page(1), mm.Frame(1), FlagPresent|FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := RegisterDemandRegion(mm.Page(0x10), 0x10, FlagRW|FlagNoExecute); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := Protect(mm.Page(0x14), 4*mm.PageSize, FlagNoExecute); err != nil {
+		t.Fatal(err)
+	}
+
+	rwFlags := FlagPresent | FlagRW | FlagNoExecute
+	exp := []demandRegion{
+		{start: 0x14, end: 0x18, flags: FlagPresent | FlagNoExecute},
+		{start: 0x10, end: 0x14, flags: rwFlags},
+		{start: 0x18, end: 0x20, flags: rwFlags},
+	}
+	if got := demandRegions[:demandRegionCount]; !reflect.DeepEqual(got, exp) {
+		t.Fatalf("expected demand regions %+v; got %+v", exp, got)
+	}
+
+	// Splitting a region requires a free region slot
+	for page := mm.Page(0x100); demandRegionCount < maxDemandRegions; page++ {
+		if err := RegisterDemandRegion(page, 1, FlagRW); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := Protect(mm.Page(0x19), mm.PageSize, FlagNoExecute); err != errDemandRegionLimit {
+		t.Fatalf("expected to get error %v; got %v", errDemandRegionLimit, err)
+	}
+
+	// Regions that are fully covered by the range do not need to be split
+	if err := Protect(mm.Page(0x10), 0x10*mm.PageSize, FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	for index := 0; index < 3; index++ {
+		if exp := FlagPresent | FlagRW; demandRegions[index].flags != exp {
+			t.Errorf("expected demand region %d flags to be 0x%x; got 0x%x", index, exp, demandRegions[index].flags)
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/selftest"
+	"unsafe"
+)
+
+var (
+	errSelfTestTranslateMismatch = &kernel.Error{Module: "vmm", Message: "self-test: mapped page translates to the wrong physical address", Code: kernel.ErrCodeCorrupted}
+	errSelfTestDataMismatch      = &kernel.Error{Module: "vmm", Message: "self-test: data written through a mapping could not be read back", Code: kernel.ErrCodeCorrupted}
+	errSelfTestStaleMapping      = &kernel.Error{Module: "vmm", Message: "self-test: page still translates after being unmapped", Code: kernel.ErrCodeCorrupted}
+)
+
+// selfTestMapTranslate checks the mapping invariants of the page table
+// manager: a mapped page must translate to the frame it was mapped to, data
+// written through the mapping must be readable and once the page gets
+// unmapped, it must no longer translate to a physical address.
+func selfTestMapTranslate() *kernel.Error {
+	const offset = uintptr(0x128)
+
+	frame, err := mm.AllocFrame()
+	if err != nil {
+		return err
+	}
+
+	page, err := mapTemporaryFn(frame)
+	if err != nil {
+		return err
+	}
+
+	physAddr, err := translateFn(page.Address() + offset)
+	if err != nil {
+		return err
+	} else if physAddr != frame.Address()+offset {
+		return errSelfTestTranslateMismatch
+	}
+
+	kernel.Memset(page.Address(), 0xa5, mm.PageSize)
+	for addr := page.Address(); addr < page.Address()+mm.PageSize; addr++ {
+		if *(*byte)(unsafe.Pointer(addr)) != 0xa5 {
+			return errSelfTestDataMismatch
+		}
+	}
+
+	if err = unmapFn(page); err != nil {
+		return err
+	}
+
+	if _, err = translateFn(page.Address()); err != ErrInvalidMapping {
+		return errSelfTestStaleMapping
+	}
+
+	return nil
+}
+
+func init() {
+	selftest.Register("vmm/map-translate", selfTestMapTranslate)
+}
@@ -0,0 +1,74 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"testing"
+	"unsafe"
+)
+
+func TestSelfTestMapTranslate(t *testing.T) {
+	defer func() {
+		mm.SetFrameAllocator(nil)
+		mapTemporaryFn = MapTemporary
+		unmapFn = Unmap
+		translateFn = Translate
+	}()
+
+	var (
+		buf        = make([]byte, 2*mm.PageSize)
+		frame      = mm.FrameFromAddress(uintptr(unsafe.Pointer(&buf[0])) + mm.PageSize - 1)
+		mapped     bool
+		translated uintptr
+		errTest    = &kernel.Error{Module: "test", Message: "something went wrong"}
+	)
+
+	specs := []struct {
+		allocErr   *kernel.Error
+		translate  func(uintptr) (uintptr, *kernel.Error)
+		unmapClear bool
+		expErr     *kernel.Error
+	}{
+		// Allocation failure
+		{errTest, nil, true, errTest},
+		// Translation failure
+		{nil, func(uintptr) (uintptr, *kernel.Error) { return 0, errTest }, true, errTest},
+		// Translation returns wrong address
+		{nil, func(uintptr) (uintptr, *kernel.Error) { return 0xbadf00d, nil }, true, errSelfTestTranslateMismatch},
+		// Page still translates after being unmapped
+		{nil, nil, false, errSelfTestStaleMapping},
+		// Success
+		{nil, nil, true, nil},
+	}
+
+	for specIndex, spec := range specs {
+		mapped = false
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) { return frame, spec.allocErr })
+		mapTemporaryFn = func(f mm.Frame) (mm.Page, *kernel.Error) {
+			mapped = true
+			return mm.Page(f), nil
+		}
+		unmapFn = func(_ mm.Page) *kernel.Error {
+			mapped = !spec.unmapClear
+			return nil
+		}
+		translateFn = spec.translate
+		if translateFn == nil {
+			translateFn = func(addr uintptr) (uintptr, *kernel.Error) {
+				if !mapped {
+					return 0, ErrInvalidMapping
+				}
+				translated = addr
+				return addr, nil
+			}
+		}
+
+		if err := selfTestMapTranslate(); err != spec.expErr {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	if exp := frame.Address() + 0x128; translated != exp {
+		t.Errorf("expected translated address to be 0x%x; got 0x%x", exp, translated)
+	}
+}
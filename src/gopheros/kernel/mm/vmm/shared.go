@@ -0,0 +1,203 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+)
+
+// SharedRegion describes a named set of physical frames that can be mapped
+// into multiple address spaces. Each address space can map the region at a
+// different address and with different permissions.
+//
+// Shared regions are reference counted. The handles returned by
+// CreateSharedRegion, CreateSharedFrames and OpenSharedRegion as well as each
+// mapping of the region hold a reference. Once the last reference is dropped,
+// the region name is released together with the frames that were allocated
+// for the region.
+type SharedRegion struct {
+	name   string
+	frames []mm.Frame
+
+	// borrowed is set for regions created by CreateSharedFrames whose
+	// frames are owned by the caller.
+	borrowed bool
+
+	refCount uint32
+}
+
+var (
+	sharedLock    sync.Spinlock
+	sharedRegions []*SharedRegion
+
+	errSharedRegionExists   = &kernel.Error{Module: "vmm", Message: "a shared region with the same name already exists", Code: kernel.ErrCodeAlreadyExists}
+	errSharedRegionNotFound = &kernel.Error{Module: "vmm", Message: "shared region does not exist", Code: kernel.ErrCodeNotFound}
+)
+
+// CreateSharedRegion creates a shared region with the supplied name and backs
+// it with zero-filled physical frames. If size is not a multiple of
+// mm.PageSize it will be automatically rounded up.
+func CreateSharedRegion(name string, size uintptr) (*SharedRegion, *kernel.Error) {
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	if pageCount == 0 {
+		return nil, errVMAreaEmpty
+	}
+
+	r := &SharedRegion{name: name, frames: make([]mm.Frame, pageCount), refCount: 1}
+	for index := range r.frames {
+		frame, err := mm.AllocFrame()
+		if err == nil {
+			if err = zeroFrame(frame); err != nil {
+				_ = mm.FreeFrame(frame)
+			}
+		}
+
+		if err != nil {
+			r.frames = r.frames[:index]
+			r.freeFrames()
+			return nil, err
+		}
+
+		r.frames[index] = frame
+	}
+
+	if err := registerSharedRegion(r); err != nil {
+		r.freeFrames()
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// CreateSharedFrames creates a shared region with the supplied name for the
+// physically contiguous memory region of the requested size that starts at
+// frame (e.g. a framebuffer). If size is not a multiple of mm.PageSize it will
+// be automatically rounded up. The frames remain owned by the caller and are
+// not released when the region is destroyed.
+func CreateSharedFrames(name string, frame mm.Frame, size uintptr) (*SharedRegion, *kernel.Error) {
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	if pageCount == 0 {
+		return nil, errVMAreaEmpty
+	}
+
+	r := &SharedRegion{name: name, frames: make([]mm.Frame, pageCount), borrowed: true, refCount: 1}
+	for index := range r.frames {
+		r.frames[index] = frame + mm.Frame(index)
+	}
+
+	if err := registerSharedRegion(r); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// OpenSharedRegion looks up the shared region with the supplied name and
+// returns a new handle to it.
+func OpenSharedRegion(name string) (*SharedRegion, *kernel.Error) {
+	sharedLock.Acquire()
+	defer sharedLock.Release()
+
+	for _, r := range sharedRegions {
+		if r.name == name {
+			r.refCount++
+			return r, nil
+		}
+	}
+
+	return nil, errSharedRegionNotFound
+}
+
+// Name returns the name of the shared region.
+func (r *SharedRegion) Name() string {
+	return r.name
+}
+
+// Size returns the size of the shared region in bytes.
+func (r *SharedRegion) Size() uintptr {
+	return uintptr(len(r.frames)) << mm.PageShift
+}
+
+// Close releases a handle to the shared region obtained via a call to
+// CreateSharedRegion, CreateSharedFrames or OpenSharedRegion. Existing
+// mappings of the region are not affected.
+func (r *SharedRegion) Close() {
+	r.release()
+}
+
+// acquire increments the reference count of the region.
+func (r *SharedRegion) acquire() {
+	sharedLock.Acquire()
+	r.refCount++
+	sharedLock.Release()
+}
+
+// release decrements the reference count of the region. When the last
+// reference is dropped the region is removed from the list of shared regions
+// and its frames are released.
+func (r *SharedRegion) release() {
+	sharedLock.Acquire()
+	if r.refCount--; r.refCount != 0 {
+		sharedLock.Release()
+		return
+	}
+
+	for index, other := range sharedRegions {
+		if other == r {
+			sharedRegions = append(sharedRegions[:index], sharedRegions[index+1:]...)
+			break
+		}
+	}
+	sharedLock.Release()
+
+	r.freeFrames()
+}
+
+// freeFrames releases the frames of the region unless they are borrowed.
+func (r *SharedRegion) freeFrames() {
+	for index := 0; !r.borrowed && index < len(r.frames); index++ {
+		_ = mm.FreeFrame(r.frames[index])
+	}
+	r.frames = nil
+}
+
+// registerSharedRegion adds r to the list of shared regions provided that its
+// name is not already in use.
+func registerSharedRegion(r *SharedRegion) *kernel.Error {
+	sharedLock.Acquire()
+	defer sharedLock.Release()
+
+	for _, other := range sharedRegions {
+		if other.name == r.name {
+			return errSharedRegionExists
+		}
+	}
+
+	sharedRegions = append(sharedRegions, r)
+	return nil
+}
+
+// MapShared maps the shared region r starting at startPage in the lower half
+// of the address space using the supplied flags. The mapping holds a
+// reference to r until it is removed via a call to FreeRegion or Destroy.
+func (as *AddressSpace) MapShared(startPage mm.Page, r *SharedRegion, flags PageTableEntryFlag) *kernel.Error {
+	as.lock.Acquire()
+	defer as.lock.Release()
+
+	pageCount := uintptr(len(r.frames))
+	if err := as.addRegion(startPage, pageCount, flags, true); err != nil {
+		return err
+	}
+
+	for mapped := uintptr(0); mapped < pageCount; mapped++ {
+		if err := as.pdt.Map(startPage+mm.Page(mapped), r.frames[mapped], flags|FlagPresent); err != nil {
+			as.unmapPages(startPage, mapped, false)
+			as.removeRegion(as.regionCount - 1)
+			return err
+		}
+	}
+
+	as.regions[as.regionCount-1].shared = r
+	r.acquire()
+	return nil
+}
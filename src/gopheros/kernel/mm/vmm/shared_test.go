@@ -0,0 +1,192 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"testing"
+)
+
+func TestSharedRegionLifecycle(t *testing.T) {
+	f, _, freed, restore := setupAddressSpaceTest(t)
+	defer func() {
+		restore()
+		sharedRegions = nil
+	}()
+
+	r, err := CreateSharedRegion("ipc", 2*mm.PageSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Name() != "ipc" || r.Size() != 2*mm.PageSize {
+		t.Fatalf("expected region ipc with size %d; got %s with size %d", 2*mm.PageSize, r.Name(), r.Size())
+	}
+
+	specs := []struct {
+		name   string
+		size   uintptr
+		expErr *kernel.Error
+	}{
+		{"ipc", mm.PageSize, errSharedRegionExists},
+		{"empty", 0, errVMAreaEmpty},
+	}
+
+	for specIndex, spec := range specs {
+		if _, err := CreateSharedRegion(spec.name, spec.size); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+	}
+
+	// The frames allocated for the duplicate region are released
+	if len(freed) != 1 {
+		t.Errorf("expected the frame of the rejected region to be freed; got %v", freed)
+	}
+
+	if _, err = OpenSharedRegion("missing"); err != errSharedRegionNotFound {
+		t.Errorf("expected to get error %v; got %v", errSharedRegionNotFound, err)
+	}
+
+	opened, err := OpenSharedRegion("ipc")
+	if err != nil || opened != r {
+		t.Fatalf("expected OpenSharedRegion to return the created region; got %v, %v", opened, err)
+	}
+
+	// Map the region into two address spaces using different permissions
+	as1, _ := NewAddressSpace()
+	as2, _ := NewAddressSpace()
+	if err = as1.MapShared(mm.Page(0x10), r, FlagRW|FlagUserAccessible); err != nil {
+		t.Fatal(err)
+	}
+	if err = as2.MapShared(mm.Page(0x40), r, FlagUserAccessible); err != nil {
+		t.Fatal(err)
+	}
+
+	mapping1, _ := lookupIn(as1, mm.Page(0x11).Address())
+	mapping2, _ := lookupIn(as2, mm.Page(0x41).Address())
+	if mapping1.PhysAddr != r.frames[1].Address() || mapping2.PhysAddr != r.frames[1].Address() {
+		t.Errorf("expected both address spaces to map frame 0x%x; got 0x%x and 0x%x", r.frames[1], mapping1.PhysAddr, mapping2.PhysAddr)
+	}
+
+	if mapping1.Flags&FlagRW == 0 || mapping2.Flags&FlagRW != 0 {
+		t.Errorf("expected the mappings to use independent permissions; got 0x%x and 0x%x", mapping1.Flags, mapping2.Flags)
+	}
+
+	clone, err := as1.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if mapping, _ := lookupIn(clone, mm.Page(0x10).Address()); mapping.PhysAddr != r.frames[0].Address() {
+		t.Errorf("expected clone to map the shared frames; got 0x%x", mapping.PhysAddr)
+	}
+
+	// Handles: create + open; mappings: as1, as2 and clone
+	if r.refCount != 5 {
+		t.Fatalf("expected region refcount to be 5; got %d", r.refCount)
+	}
+
+	regionFrames := append([]mm.Frame(nil), r.frames...)
+	r.Close()
+	opened.Close()
+	if err = as1.FreeRegion(mm.Page(0x10)); err != nil {
+		t.Fatal(err)
+	}
+	if err = clone.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, frame := range regionFrames {
+		if freed[frame] != 0 {
+			t.Fatalf("expected frame 0x%x to remain allocated while the region is mapped", frame)
+		}
+	}
+
+	// Dropping the last reference releases the frames and the region name
+	if err = as2.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, frame := range regionFrames {
+		if freed[frame] != 1 {
+			t.Errorf("expected frame 0x%x to be freed once; got %d", frame, freed[frame])
+		}
+	}
+
+	if _, err = OpenSharedRegion("ipc"); err != errSharedRegionNotFound {
+		t.Errorf("expected region name to be released; got %v", err)
+	}
+
+	t.Run("allocation failure", func(t *testing.T) {
+		var (
+			expErr     = &kernel.Error{Module: "test", Message: "out of memory"}
+			allocCount int
+		)
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) {
+			if allocCount++; allocCount > 2 {
+				return 0, expErr
+			}
+			return mm.FrameFromAddress(f.allocTable()), nil
+		})
+
+		freedBefore := len(freed)
+		if _, err := CreateSharedRegion("oom", 4*mm.PageSize); err != expErr {
+			t.Fatalf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if got := len(freed) - freedBefore; got != 2 {
+			t.Errorf("expected the 2 allocated frames to be freed; got %d", got)
+		}
+	})
+}
+
+func TestSharedFrames(t *testing.T) {
+	_, _, freed, restore := setupAddressSpaceTest(t)
+	defer func() {
+		restore()
+		sharedRegions = nil
+	}()
+
+	r, err := CreateSharedFrames("fb", mm.Frame(0x1000), 3*mm.PageSize-1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = CreateSharedFrames("fb", mm.Frame(0x2000), mm.PageSize); err != errSharedRegionExists {
+		t.Errorf("expected to get error %v; got %v", errSharedRegionExists, err)
+	}
+
+	if _, err = CreateSharedFrames("empty", mm.Frame(0x2000), 0); err != errVMAreaEmpty {
+		t.Errorf("expected to get error %v; got %v", errVMAreaEmpty, err)
+	}
+
+	as, _ := NewAddressSpace()
+	if err = as.MapShared(mm.Page(0x10), r, FlagRW|FlagUserAccessible); err != nil {
+		t.Fatal(err)
+	}
+
+	for page, expFrame := range map[mm.Page]mm.Frame{0x10: 0x1000, 0x12: 0x1002} {
+		if mapping, err := lookupIn(as, page.Address()); err != nil || mapping.PhysAddr != expFrame.Address() {
+			t.Errorf("expected page 0x%x to be mapped to frame 0x%x; got %+v, %v", page, expFrame, mapping, err)
+		}
+	}
+
+	// Overlapping mappings are rejected without acquiring a reference
+	if err = as.MapShared(mm.Page(0x11), r, FlagRW); err != errAddressSpaceOverlap {
+		t.Errorf("expected to get error %v; got %v", errAddressSpaceOverlap, err)
+	}
+
+	r.Close()
+	if err = as.Destroy(); err != nil {
+		t.Fatal(err)
+	}
+
+	for frame := mm.Frame(0x1000); frame < 0x1003; frame++ {
+		if freed[frame] != 0 {
+			t.Errorf("expected borrowed frame 0x%x not to be freed", frame)
+		}
+	}
+
+	if len(sharedRegions) != 0 {
+		t.Errorf("expected region to be removed once its last reference was dropped")
+	}
+}
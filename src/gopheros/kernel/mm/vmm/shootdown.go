@@ -0,0 +1,238 @@
+package vmm
+
+import (
+	"gopheros/kernel/sync"
+	"sync/atomic"
+)
+
+const (
+	// MaxCPUs defines the maximum number of CPUs that can participate in
+	// TLB shootdowns.
+	MaxCPUs = 64
+
+	// maxShootdownPages defines the number of page invalidations that can
+	// be batched before they are sent to the remote CPUs.
+	maxShootdownPages = 32
+)
+
+// shootdownBatch collects the page invalidations performed by a CPU so that
+// they can be delivered to the remote CPUs with a single IPI.
+type shootdownBatch struct {
+	// The nesting level of beginShootdown calls.
+	depth int
+
+	// The physical address of the PDT whose lower half mappings are being
+	// modified or 0 if modifications target the active PDT.
+	target uintptr
+
+	// The physical address of the PDT that the batched lower half pages
+	// belong to.
+	pdtAddr uintptr
+
+	// global is set if the batch contains upper half pages which need to
+	// be invalidated by all CPUs regardless of their active PDT.
+	global bool
+
+	pages [maxShootdownPages]uintptr
+	count int
+}
+
+// shootdown holds the state of the TLB shootdown mechanism. A CPU that needs
+// to invalidate a set of pages on remote CPUs publishes its batch in req,
+// flags the CPUs that need to process it in pendingMask, sends them an IPI
+// and spins until all of them have acknowledged the request.
+var shootdown struct {
+	lock sync.Spinlock
+
+	batches [MaxCPUs]shootdownBatch
+
+	// The physical address of the PDT loaded by each CPU.
+	activePDT [MaxCPUs]uintptr
+
+	onlineMask  uint64
+	pendingMask uint64
+	req         *shootdownBatch
+
+	// cpuIDFn returns the index of the CPU executing the caller and
+	// sendIPIFn sends the shootdown IPI to the CPUs in the supplied mask.
+	// If sendIPIFn is nil, the system is assumed to be uniprocessor and
+	// invalidations are only performed locally.
+	cpuIDFn   func() uint32
+	sendIPIFn func(cpuMask uint64)
+}
+
+// SetShootdownCPUs enables TLB shootdowns. The cpuID function returns the
+// index of the current CPU in the range [0, MaxCPUs) and sendIPI delivers an
+// IPI to the CPUs in the supplied mask whose handler must call
+// HandleShootdownIPI. The calling CPU is registered as online; application
+// processors must call ShootdownCPUOnline once they have loaded their page
+// tables.
+func SetShootdownCPUs(cpuID func() uint32, sendIPI func(cpuMask uint64)) {
+	shootdown.cpuIDFn = cpuID
+	shootdown.sendIPIFn = sendIPI
+	ShootdownCPUOnline()
+}
+
+// ShootdownCPUOnline registers the current CPU as a recipient of TLB
+// shootdown requests.
+func ShootdownCPUOnline() {
+	cpu := currentCPU()
+	atomic.StoreUintptr(&shootdown.activePDT[cpu], activePDTFn())
+	setMaskBits(&shootdown.onlineMask, 1<<cpu)
+}
+
+// HandleShootdownIPI processes the pending shootdown request for the current
+// CPU. It must be invoked by the handler of the IPI sent via the function
+// registered with SetShootdownCPUs.
+func HandleShootdownIPI() {
+	bit := uint64(1) << currentCPU()
+	if atomic.LoadUint64(&shootdown.pendingMask)&bit == 0 {
+		return
+	}
+
+	req := shootdown.req
+	for index := 0; index < req.count; index++ {
+		flushTLBEntryFn(req.pages[index])
+	}
+
+	clearMaskBits(&shootdown.pendingMask, bit)
+}
+
+// setMaskBits atomically sets the supplied bits in the CPU mask at addr.
+func setMaskBits(addr *uint64, bits uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, old|bits) {
+			return
+		}
+	}
+}
+
+// clearMaskBits atomically clears the supplied bits in the CPU mask at addr.
+func clearMaskBits(addr *uint64, bits uint64) {
+	for {
+		old := atomic.LoadUint64(addr)
+		if atomic.CompareAndSwapUint64(addr, old, old&^bits) {
+			return
+		}
+	}
+}
+
+func currentCPU() uint32 {
+	if shootdown.cpuIDFn == nil {
+		return 0
+	}
+
+	return shootdown.cpuIDFn()
+}
+
+// beginShootdown starts batching the page invalidations performed by the
+// current CPU. Calls may be nested; the batch is delivered to the remote CPUs
+// by the outermost call to endShootdown.
+func beginShootdown() {
+	shootdown.batches[currentCPU()].depth++
+}
+
+// endShootdown ends a batch started by beginShootdown.
+func endShootdown() {
+	cpu := currentCPU()
+	batch := &shootdown.batches[cpu]
+	if batch.depth--; batch.depth == 0 {
+		flushShootdownBatch(cpu, batch)
+	}
+}
+
+// setShootdownTarget sets the physical address of the PDT whose mappings are
+// modified by the current CPU and returns the previous target. A zero
+// address selects the active PDT.
+func setShootdownTarget(pdtAddr uintptr) uintptr {
+	batch := &shootdown.batches[currentCPU()]
+	prev := batch.target
+	batch.target = pdtAddr
+	return prev
+}
+
+// noteActivePDT records the PDT loaded by the current CPU. CPUs are only
+// interrupted for lower half invalidations that target their active PDT;
+// entries cached for other address spaces are implicitly discarded when the
+// CPU reloads CR3 to switch to them.
+func noteActivePDT(pdtAddr uintptr) {
+	atomic.StoreUintptr(&shootdown.activePDT[currentCPU()], pdtAddr)
+}
+
+// invalidatePage flushes the local TLB entry for virtAddr and queues its
+// invalidation on the remote CPUs that may have cached it.
+func invalidatePage(virtAddr uintptr) {
+	flushTLBEntryFn(virtAddr)
+
+	if shootdown.sendIPIFn == nil {
+		return
+	}
+
+	cpu := currentCPU()
+	batch := &shootdown.batches[cpu]
+
+	pdtAddr := batch.target
+	if pdtAddr == 0 {
+		pdtAddr = activePDTFn()
+	}
+
+	if batch.count != 0 && batch.pdtAddr != pdtAddr {
+		flushShootdownBatch(cpu, batch)
+	}
+
+	batch.pdtAddr = pdtAddr
+	batch.global = batch.global || virtAddr >= kernelHalfStart
+	batch.pages[batch.count] = virtAddr
+	batch.count++
+
+	if batch.count == maxShootdownPages || batch.depth == 0 {
+		flushShootdownBatch(cpu, batch)
+	}
+}
+
+// flushShootdownBatch delivers the invalidations queued by cpu to the remote
+// CPUs and waits for them to be processed.
+func flushShootdownBatch(cpu uint32, batch *shootdownBatch) {
+	if batch.count == 0 {
+		return
+	}
+
+	if targets := shootdownTargets(cpu, batch); targets != 0 {
+		// While waiting for the lock keep processing requests sent to
+		// this CPU; the CPU holding the lock may be waiting for us.
+		for !shootdown.lock.TryToAcquire() {
+			HandleShootdownIPI()
+		}
+
+		shootdown.req = batch
+		atomic.StoreUint64(&shootdown.pendingMask, targets)
+		shootdown.sendIPIFn(targets)
+		for atomic.LoadUint64(&shootdown.pendingMask) != 0 {
+			// Wait for all targets to acknowledge the request
+		}
+		shootdown.req = nil
+
+		shootdown.lock.Release()
+	}
+
+	batch.count, batch.global = 0, false
+}
+
+// shootdownTargets returns the mask of remote CPUs that need to process the
+// supplied batch.
+func shootdownTargets(cpu uint32, batch *shootdownBatch) uint64 {
+	online := atomic.LoadUint64(&shootdown.onlineMask) &^ (1 << cpu)
+	if batch.global {
+		return online
+	}
+
+	var targets uint64
+	for id := uint32(0); id < MaxCPUs; id++ {
+		if online&(1<<id) != 0 && atomic.LoadUintptr(&shootdown.activePDT[id]) == batch.pdtAddr {
+			targets |= 1 << id
+		}
+	}
+
+	return targets
+}
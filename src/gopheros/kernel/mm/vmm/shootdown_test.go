@@ -0,0 +1,180 @@
+package vmm
+
+import (
+	"reflect"
+	"testing"
+)
+
+// fakeSMP simulates a set of CPUs that process shootdown IPIs synchronously
+// and records the TLB entries flushed by each one.
+type fakeSMP struct {
+	curCPU  uint32
+	ipis    []uint64
+	flushed [][]uintptr
+}
+
+func setupFakeSMP(cpuCount int, pdtAddrs []uintptr) (*fakeSMP, func()) {
+	origActivePDT, origSwitchPDT, origFlushTLB := activePDTFn, switchPDTFn, flushTLBEntryFn
+
+	f := &fakeSMP{flushed: make([][]uintptr, cpuCount)}
+	flushTLBEntryFn = func(virtAddr uintptr) {
+		f.flushed[f.curCPU] = append(f.flushed[f.curCPU], virtAddr)
+	}
+	activePDTFn = func() uintptr { return pdtAddrs[f.curCPU] }
+	switchPDTFn = func(uintptr) {}
+
+	sendIPI := func(cpuMask uint64) {
+		f.ipis = append(f.ipis, cpuMask)
+
+		sender := f.curCPU
+		for cpu := uint32(0); cpu < uint32(cpuCount); cpu++ {
+			if cpuMask&(1<<cpu) != 0 {
+				f.curCPU = cpu
+				HandleShootdownIPI()
+			}
+		}
+		f.curCPU = sender
+	}
+
+	SetShootdownCPUs(func() uint32 { return f.curCPU }, sendIPI)
+	for f.curCPU = 1; f.curCPU < uint32(cpuCount); f.curCPU++ {
+		ShootdownCPUOnline()
+	}
+	f.curCPU = 0
+
+	return f, func() {
+		activePDTFn, switchPDTFn, flushTLBEntryFn = origActivePDT, origSwitchPDT, origFlushTLB
+		shootdown.cpuIDFn, shootdown.sendIPIFn = nil, nil
+		shootdown.onlineMask, shootdown.pendingMask = 0, 0
+		shootdown.batches = [MaxCPUs]shootdownBatch{}
+		shootdown.activePDT = [MaxCPUs]uintptr{}
+	}
+}
+
+func (f *fakeSMP) reset() {
+	f.ipis = nil
+	for cpu := range f.flushed {
+		f.flushed[cpu] = nil
+	}
+}
+
+func TestShootdownUniprocessor(t *testing.T) {
+	defer func(origFlushTLB func(uintptr)) {
+		flushTLBEntryFn = origFlushTLB
+	}(flushTLBEntryFn)
+
+	var flushed []uintptr
+	flushTLBEntryFn = func(virtAddr uintptr) { flushed = append(flushed, virtAddr) }
+
+	beginShootdown()
+	invalidatePage(0x1000)
+	invalidatePage(kernelHalfStart)
+	endShootdown()
+
+	if exp := []uintptr{0x1000, kernelHalfStart}; !reflect.DeepEqual(flushed, exp) {
+		t.Fatalf("expected flushed entries to be %v; got %v", exp, flushed)
+	}
+
+	if shootdown.batches[0].count != 0 {
+		t.Fatal("expected no invalidations to be queued")
+	}
+}
+
+func TestShootdownTargets(t *testing.T) {
+	// CPUs 0 and 2 share an address space
+	f, restore := setupFakeSMP(4, []uintptr{0x1000, 0x2000, 0x1000, 0x3000})
+	defer restore()
+
+	specs := []struct {
+		cpu        uint32
+		target     uintptr
+		virtAddr   uintptr
+		expIPIs    []uint64
+		expFlushed [][]uintptr
+	}{
+		// Lower half pages are only invalidated by the CPUs that share
+		// the address space
+		{0, 0, 0x4000, []uint64{1 << 2}, [][]uintptr{{0x4000}, nil, {0x4000}, nil}},
+		{1, 0, 0x4000, nil, [][]uintptr{nil, {0x4000}, nil, nil}},
+		// Modifications to an inactive PDT target the CPUs that have
+		// it active
+		{0, 0x3000, 0x4000, []uint64{1 << 3}, [][]uintptr{{0x4000}, nil, nil, {0x4000}}},
+		{3, 0x4000, 0x4000, nil, [][]uintptr{nil, nil, nil, {0x4000}}},
+		// Upper half pages are invalidated by all CPUs
+		{1, 0, kernelHalfStart + 0x1000, []uint64{1<<0 | 1<<2 | 1<<3}, [][]uintptr{{kernelHalfStart + 0x1000}, {kernelHalfStart + 0x1000}, {kernelHalfStart + 0x1000}, {kernelHalfStart + 0x1000}}},
+	}
+
+	for specIndex, spec := range specs {
+		f.reset()
+		f.curCPU = spec.cpu
+
+		prevTarget := setShootdownTarget(spec.target)
+		invalidatePage(spec.virtAddr)
+		setShootdownTarget(prevTarget)
+
+		if !reflect.DeepEqual(f.ipis, spec.expIPIs) {
+			t.Errorf("[spec %d] expected IPIs %v; got %v", specIndex, spec.expIPIs, f.ipis)
+		}
+
+		if !reflect.DeepEqual(f.flushed, spec.expFlushed) {
+			t.Errorf("[spec %d] expected flushed entries %v; got %v", specIndex, spec.expFlushed, f.flushed)
+		}
+
+		if shootdown.pendingMask != 0 {
+			t.Errorf("[spec %d] expected all requests to be acknowledged", specIndex)
+		}
+	}
+}
+
+func TestShootdownBatching(t *testing.T) {
+	f, restore := setupFakeSMP(2, []uintptr{0x1000, 0x1000})
+	defer restore()
+
+	// Nested batches are delivered by the outermost endShootdown call
+	beginShootdown()
+	beginShootdown()
+	invalidatePage(0x4000)
+	invalidatePage(0x5000)
+	endShootdown()
+	if len(f.ipis) != 0 {
+		t.Fatalf("expected no IPIs to be sent before the batch ends; got %d", len(f.ipis))
+	}
+	invalidatePage(0x6000)
+	endShootdown()
+
+	if exp := []uintptr{0x4000, 0x5000, 0x6000}; len(f.ipis) != 1 || !reflect.DeepEqual(f.flushed[1], exp) {
+		t.Fatalf("expected a single IPI invalidating %v; got %d IPIs invalidating %v", exp, len(f.ipis), f.flushed[1])
+	}
+
+	// Full batches are delivered immediately
+	f.reset()
+	beginShootdown()
+	for page := uintptr(0); page <= maxShootdownPages; page++ {
+		invalidatePage(page << 12)
+	}
+	if len(f.ipis) != 1 || len(f.flushed[1]) != maxShootdownPages {
+		t.Fatalf("expected a full batch to be delivered; got %d IPIs invalidating %d pages", len(f.ipis), len(f.flushed[1]))
+	}
+	endShootdown()
+	if len(f.ipis) != 2 || len(f.flushed[1]) != maxShootdownPages+1 {
+		t.Fatalf("expected the remaining batch to be delivered; got %d IPIs invalidating %d pages", len(f.ipis), len(f.flushed[1]))
+	}
+
+	// CPUs that switch to another address space are no longer
+	// interrupted for invalidations of their previous address space.
+	// Invalidations for a different address space flush the pending batch.
+	f.reset()
+	beginShootdown()
+	invalidatePage(0x4000)
+	f.curCPU = 1
+	PageDirectoryTable{pdtFrame: 3}.Activate()
+	f.curCPU = 0
+	prevTarget := setShootdownTarget(0x3000)
+	invalidatePage(0x5000)
+	setShootdownTarget(prevTarget)
+	endShootdown()
+
+	if exp := []uint64{1 << 1}; !reflect.DeepEqual(f.ipis, exp) || !reflect.DeepEqual(f.flushed[1], []uintptr{0x5000}) {
+		t.Fatalf("expected IPIs %v invalidating [0x5000]; got %v invalidating %v", exp, f.ipis, f.flushed[1])
+	}
+}
@@ -0,0 +1,125 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/ksyms"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+)
+
+// maxKernelStacks defines the max number of stacks that can be allocated via
+// AllocStack.
+const maxKernelStacks = 64
+
+// kernelStack describes a stack allocated via AllocStack.
+type kernelStack struct {
+	// The first mapped page of the stack. The page below it is the guard
+	// page reserved by AllocRegion.
+	base      mm.Page
+	pageCount uintptr
+
+	// The ID of the goroutine that runs on this stack.
+	goroutineID uint64
+}
+
+var (
+	stackLock        sync.Spinlock
+	kernelStacks     [maxKernelStacks]kernelStack
+	kernelStackCount int
+
+	// dumpStackFn is used by tests.
+	dumpStackFn = ksyms.DumpStack
+
+	errStackLimit    = &kernel.Error{Module: "vmm", Message: "max number of kernel stacks exceeded", Code: kernel.ErrCodeOutOfMemory}
+	errStackInvalid  = &kernel.Error{Module: "vmm", Message: "address does not correspond to an allocated kernel stack", Code: kernel.ErrCodeInvalidArgument}
+	errStackOverflow = &kernel.Error{Module: "vmm", Message: "kernel stack overflow", Code: kernel.ErrCodeFault}
+)
+
+// AllocStack allocates a stack with the requested size for the goroutine with
+// the specified ID and returns the address of its top. If size is not a
+// multiple of mm.PageSize it will be automatically rounded up.
+//
+// The stack is allocated via AllocRegion and is therefore preceded by an
+// unmapped guard page. Since stacks grow downwards, an overflow triggers a
+// page fault which the page-fault handler reports as a stack overflow in the
+// owning goroutine instead of silently corrupting the memory below the stack.
+func AllocStack(size uintptr, goroutineID uint64) (uintptr, *kernel.Error) {
+	stackLock.Acquire()
+	defer stackLock.Release()
+
+	if kernelStackCount == maxKernelStacks {
+		return 0, errStackLimit
+	}
+
+	base, err := AllocRegion(size, FlagRW|FlagNoExecute)
+	if err != nil {
+		return 0, err
+	}
+
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	kernelStacks[kernelStackCount] = kernelStack{
+		base:        base,
+		pageCount:   pageCount,
+		goroutineID: goroutineID,
+	}
+	kernelStackCount++
+
+	return (base + mm.Page(pageCount)).Address(), nil
+}
+
+// FreeStack releases a stack previously allocated via a call to AllocStack.
+// The top argument must be the address returned by AllocStack.
+func FreeStack(top uintptr) *kernel.Error {
+	stackLock.Acquire()
+	defer stackLock.Release()
+
+	for index := 0; index < kernelStackCount; index++ {
+		stack := &kernelStacks[index]
+		if (stack.base + mm.Page(stack.pageCount)).Address() != top {
+			continue
+		}
+
+		if err := FreeRegion(stack.base); err != nil {
+			return err
+		}
+
+		copy(kernelStacks[index:kernelStackCount-1], kernelStacks[index+1:kernelStackCount])
+		kernelStackCount--
+		return nil
+	}
+
+	return errStackInvalid
+}
+
+// stackGuardOwner checks whether page is the guard page of a stack allocated
+// via AllocStack and returns the ID of the goroutine that owns the stack.
+func stackGuardOwner(page mm.Page) (uint64, bool) {
+	stackLock.Acquire()
+	defer stackLock.Release()
+
+	for index := 0; index < kernelStackCount; index++ {
+		if kernelStacks[index].base-1 == page {
+			return kernelStacks[index].goroutineID, true
+		}
+	}
+
+	return 0, false
+}
+
+// stackOverflow reports a fault caused by an access to the guard page of the
+// stack that belongs to the specified goroutine and panics.
+func stackOverflow(faultAddress uintptr, regs *gate.Registers, goroutineID uint64) {
+	w := kfmt.GetOutputSink()
+
+	kfmt.Printf("\nkernel stack overflow in goroutine %d while accessing address: 0x%16x\n", goroutineID, faultAddress)
+	kfmt.Printf("Faulting instruction: ")
+	ksyms.WriteSymbol(w, uintptr(regs.RIP))
+	kfmt.Printf("\n\nRegisters:\n")
+	regs.DumpTo(w)
+	kfmt.Printf("\nBacktrace:\n")
+	dumpStackFn(w, 1)
+
+	panic(errStackOverflow)
+}
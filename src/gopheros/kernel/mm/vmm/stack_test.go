@@ -0,0 +1,159 @@
+package vmm
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/ksyms"
+	"gopheros/kernel/mm"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestAllocFreeStack(t *testing.T) {
+	defer func() {
+		mapFn = Map
+		unmapFn = Unmap
+		translateFn = Translate
+		mm.SetFrameAllocator(nil)
+		mm.SetFrameReleaser(nil)
+		vmAreaCount = 0
+		kernelStackCount = 0
+	}()
+	vmAreaCount = 0
+	kernelStackCount = 0
+
+	mappings := make(map[mm.Page]mm.Frame)
+	mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) { return mm.Frame(42), nil })
+	mm.SetFrameReleaser(func(mm.Frame) *kernel.Error { return nil })
+	mapFn = func(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
+		if !(pageTableEntry(flags)).HasFlags(FlagPresent | FlagRW | FlagNoExecute) {
+			t.Errorf("expected stack page to be mapped with FlagPresent | FlagRW | FlagNoExecute; got %x", flags)
+		}
+		mappings[page] = frame
+		return nil
+	}
+	unmapFn = func(page mm.Page) *kernel.Error {
+		delete(mappings, page)
+		return nil
+	}
+	translateFn = func(virtAddr uintptr) (uintptr, *kernel.Error) {
+		frame, ok := mappings[mm.PageFromAddress(virtAddr)]
+		if !ok {
+			return 0, ErrInvalidMapping
+		}
+		return frame.Address(), nil
+	}
+
+	if _, err := AllocStack(0, 1); err != errVMAreaEmpty {
+		t.Fatalf("expected to get error %v; got %v", errVMAreaEmpty, err)
+	}
+
+	top1, err := AllocStack(2*mm.PageSize, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	top2, err := AllocStack(mm.PageSize+1, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The guard page is located right below the stack pages
+	specs := []struct {
+		page       mm.Page
+		expOwner   uint64
+		expIsGuard bool
+	}{
+		{mm.PageFromAddress(top1) - 3, 1, true},
+		{mm.PageFromAddress(top1) - 2, 0, false},
+		{mm.PageFromAddress(top1) - 1, 0, false},
+		// The guard page of the second stack follows the first stack
+		{mm.PageFromAddress(top1), 2, true},
+		{mm.PageFromAddress(top2) - 3, 2, true},
+	}
+
+	for specIndex, spec := range specs {
+		owner, isGuard := stackGuardOwner(spec.page)
+		if owner != spec.expOwner || isGuard != spec.expIsGuard {
+			t.Errorf("[spec %d] expected stackGuardOwner to return (%d, %t); got (%d, %t)", specIndex, spec.expOwner, spec.expIsGuard, owner, isGuard)
+		}
+
+		if _, mapped := mappings[spec.page]; mapped == spec.expIsGuard {
+			t.Errorf("[spec %d] expected page %d mapped state to be %t", specIndex, spec.page, !spec.expIsGuard)
+		}
+	}
+
+	if err = FreeStack(top1 - mm.PageSize); err != errStackInvalid {
+		t.Fatalf("expected to get error %v; got %v", errStackInvalid, err)
+	}
+
+	if err = FreeStack(top1); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, isGuard := stackGuardOwner(mm.PageFromAddress(top1) - 3); isGuard {
+		t.Fatal("expected guard page of released stack not to be tracked")
+	}
+
+	if kernelStackCount != 1 || len(mappings) != 2 {
+		t.Fatalf("expected 1 stack with 2 mapped pages to remain; got %d stacks and %d pages", kernelStackCount, len(mappings))
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		defer func() { kernelStackCount = 1 }()
+
+		// Stack region cannot be released
+		kernelStacks[0].base++
+		if err := FreeStack(top2 + mm.PageSize); err != errVMAreaInvalidRegion {
+			t.Errorf("expected to get error %v; got %v", errVMAreaInvalidRegion, err)
+		}
+		kernelStacks[0].base--
+
+		kernelStackCount = maxKernelStacks
+		if _, err := AllocStack(mm.PageSize, 3); err != errStackLimit {
+			t.Errorf("expected to get error %v; got %v", errStackLimit, err)
+		}
+	})
+}
+
+func TestStackOverflowPageFault(t *testing.T) {
+	var (
+		regs gate.Registers
+		buf  bytes.Buffer
+		top  = uintptr(0xbadf00d000)
+	)
+
+	defer func() {
+		readCR2Fn = cpu.ReadCR2
+		dumpStackFn = ksyms.DumpStack
+		kfmt.SetOutputSink(nil)
+		kernelStackCount = 0
+	}()
+
+	kernelStacks[0] = kernelStack{base: mm.PageFromAddress(top) - 2, pageCount: 2, goroutineID: 7}
+	kernelStackCount = 1
+
+	kfmt.SetOutputSink(&buf)
+	readCR2Fn = func() uint64 { return uint64(top - 2*mm.PageSize - 8) }
+	dumpStackFn = func(w io.Writer, _ int) { io.WriteString(w, "  frame\n") }
+
+	defer func() {
+		if err := recover(); err != errStackOverflow {
+			t.Fatalf("expected to panic with %v; got %v", errStackOverflow, err)
+		}
+
+		for _, exp := range []string{"kernel stack overflow in goroutine 7", "Backtrace:\n  frame\n"} {
+			if !strings.Contains(buf.String(), exp) {
+				t.Fatalf("expected output to contain %q; got:\n%s", exp, buf.String())
+			}
+		}
+	}()
+
+	// Write to non-present guard page
+	regs.Info = 2
+	pageFaultHandler(&regs)
+}
@@ -0,0 +1,269 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+)
+
+// maxVMAreas defines the max number of (allocated or free) areas that the
+// kernel region allocator can keep track of.
+const maxVMAreas = 256
+
+// vmArea describes a contiguous range of pages in the region managed by
+// AllocRegion. Each allocated area starts with an unmapped guard page that
+// separates it from the area preceding it.
+type vmArea struct {
+	start     mm.Page
+	pageCount uintptr
+	inUse     bool
+
+	// borrowed is set for areas established by MapFrames whose frames
+	// are owned by the caller and must not be released by FreeRegion.
+	borrowed bool
+}
+
+var (
+	vmAreaLock  sync.Spinlock
+	vmAreas     [maxVMAreas]vmArea
+	vmAreaCount int
+
+	errVMAreaEmpty         = &kernel.Error{Module: "vmm", Message: "region must contain at least one page", Code: kernel.ErrCodeInvalidArgument}
+	errVMAreaNoSpace       = &kernel.Error{Module: "vmm", Message: "kernel virtual address space exhausted", Code: kernel.ErrCodeOutOfMemory}
+	errVMAreaLimit         = &kernel.Error{Module: "vmm", Message: "max number of kernel virtual address regions exceeded", Code: kernel.ErrCodeOutOfMemory}
+	errVMAreaInvalidRegion = &kernel.Error{Module: "vmm", Message: "address does not correspond to an allocated region", Code: kernel.ErrCodeInvalidArgument}
+)
+
+// AllocRegion reserves a region of the requested size in the kernel virtual
+// address range managed by the vmm, backs each page with a physical frame and
+// maps it using the supplied flags. If size is not a multiple of mm.PageSize
+// it will be automatically rounded up. As frames are allocated one at a time,
+// the returned region is virtually but not necessarily physically contiguous.
+//
+// Each region is preceded by an unmapped guard page so that accesses past the
+// end of the previous region trigger a page fault instead of corrupting it.
+// Regions must be released via a call to FreeRegion.
+func AllocRegion(size uintptr, flags PageTableEntryFlag) (mm.Page, *kernel.Error) {
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	if pageCount == 0 {
+		return 0, errVMAreaEmpty
+	}
+
+	vmAreaLock.Acquire()
+	startPage, err := reserveVMArea(pageCount)
+	vmAreaLock.Release()
+	if err != nil {
+		return 0, err
+	}
+
+	for mapped := uintptr(0); mapped < pageCount; mapped++ {
+		var frame mm.Frame
+		if frame, err = mm.AllocFrame(); err == nil {
+			if err = mapFn(startPage+mm.Page(mapped), frame, flags|FlagPresent); err != nil {
+				_ = mm.FreeFrame(frame)
+			}
+		}
+
+		if err != nil {
+			unmapRegionPages(startPage, mapped, true)
+
+			vmAreaLock.Acquire()
+			releaseVMArea(findVMArea(startPage))
+			vmAreaLock.Release()
+			return 0, err
+		}
+	}
+
+	return startPage, nil
+}
+
+// MapFrames reserves a region of the requested size in the kernel virtual
+// address range managed by the vmm and maps the physically contiguous memory
+// that starts at frame to it using the supplied flags. If size is not a
+// multiple of mm.PageSize it will be automatically rounded up. Unlike
+// MapRegion, the reserved address range can be reclaimed via a call to
+// FreeRegion which unmaps the region without releasing its frames.
+func MapFrames(frame mm.Frame, size uintptr, flags PageTableEntryFlag) (mm.Page, *kernel.Error) {
+	pageCount := (size + (mm.PageSize - 1)) >> mm.PageShift
+	if pageCount == 0 {
+		return 0, errVMAreaEmpty
+	}
+
+	vmAreaLock.Acquire()
+	startPage, err := reserveVMArea(pageCount)
+	if err == nil {
+		vmAreas[findVMArea(startPage)].borrowed = true
+	}
+	vmAreaLock.Release()
+	if err != nil {
+		return 0, err
+	}
+
+	for mapped := uintptr(0); mapped < pageCount; mapped++ {
+		if err = mapFn(startPage+mm.Page(mapped), frame+mm.Frame(mapped), flags|FlagPresent); err != nil {
+			unmapRegionPages(startPage, mapped, false)
+
+			vmAreaLock.Acquire()
+			releaseVMArea(findVMArea(startPage))
+			vmAreaLock.Release()
+			return 0, err
+		}
+	}
+
+	return startPage, nil
+}
+
+// FreeRegion unmaps a region previously allocated via a call to AllocRegion
+// or MapFrames. The physical frames of regions allocated via AllocRegion are
+// also released. The virtual address range of the region becomes available
+// for reuse.
+func FreeRegion(startPage mm.Page) *kernel.Error {
+	vmAreaLock.Acquire()
+	defer vmAreaLock.Release()
+
+	index := findVMArea(startPage)
+	if index < 0 {
+		return errVMAreaInvalidRegion
+	}
+
+	// The first page of each area is a guard page
+	unmapRegionPages(startPage, vmAreas[index].pageCount-1, !vmAreas[index].borrowed)
+	releaseVMArea(index)
+	return nil
+}
+
+// unmapRegionPages unmaps pageCount pages starting at startPage and, if
+// freeFrames is true, releases the physical frames they point to. Pages that
+// are mapped to ReservedZeroedFrame never release it.
+func unmapRegionPages(startPage mm.Page, pageCount uintptr, freeFrames bool) {
+	var (
+		endPage = startPage + mm.Page(pageCount)
+		frames  [maxShootdownPages]mm.Frame
+	)
+
+	// Pages are unmapped in batches; the frames of each batch can only be
+	// released after remote CPUs have invalidated their TLB entries.
+	for batchStart := startPage; batchStart < endPage; batchStart += maxShootdownPages {
+		frameCount := 0
+
+		beginShootdown()
+		for page := batchStart; page < endPage && page < batchStart+maxShootdownPages; page++ {
+			physAddr, err := translateFn(page.Address())
+			if err != nil {
+				continue
+			}
+
+			_ = unmapFn(page)
+			if frame := mm.FrameFromAddress(physAddr); !isReservedZeroedFrame(frame) {
+				frames[frameCount] = frame
+				frameCount++
+			}
+		}
+		endShootdown()
+
+		for index := 0; freeFrames && index < frameCount; index++ {
+			_ = mm.FreeFrame(frames[index])
+		}
+	}
+}
+
+// reserveVMArea reserves an area with room for pageCount pages and a leading
+// guard page using a first-fit strategy. It returns the first page after the
+// guard page. Callers must hold vmAreaLock.
+func reserveVMArea(pageCount uintptr) (mm.Page, *kernel.Error) {
+	if vmAreaCount == 0 {
+		vmAreas[0] = vmArea{
+			start:     mm.PageFromAddress(vmallocBase),
+			pageCount: vmallocPageCount(),
+		}
+		vmAreaCount = 1
+	}
+
+	areaPages := pageCount + 1
+	for index := 0; index < vmAreaCount; index++ {
+		area := &vmAreas[index]
+		if area.inUse || area.pageCount < areaPages {
+			continue
+		}
+
+		// Split the free area and track its remainder separately
+		if area.pageCount > areaPages {
+			if vmAreaCount == maxVMAreas {
+				return 0, errVMAreaLimit
+			}
+
+			copy(vmAreas[index+2:vmAreaCount+1], vmAreas[index+1:vmAreaCount])
+			vmAreas[index+1] = vmArea{
+				start:     area.start + mm.Page(areaPages),
+				pageCount: area.pageCount - areaPages,
+			}
+			vmAreaCount++
+			area.pageCount = areaPages
+		}
+
+		area.inUse = true
+		return area.start + 1, nil
+	}
+
+	return 0, errVMAreaNoSpace
+}
+
+// releaseVMArea marks the area at the specified index as free and merges it
+// with any adjacent free areas. Callers must hold vmAreaLock.
+func releaseVMArea(index int) {
+	vmAreas[index].inUse, vmAreas[index].borrowed = false, false
+
+	if index+1 < vmAreaCount && !vmAreas[index+1].inUse {
+		vmAreas[index].pageCount += vmAreas[index+1].pageCount
+		removeVMArea(index + 1)
+	}
+
+	if index > 0 && !vmAreas[index-1].inUse {
+		vmAreas[index-1].pageCount += vmAreas[index].pageCount
+		removeVMArea(index)
+	}
+}
+
+// removeVMArea removes the area at the specified index from the area list.
+func removeVMArea(index int) {
+	copy(vmAreas[index:vmAreaCount-1], vmAreas[index+1:vmAreaCount])
+	vmAreaCount--
+}
+
+// findVMArea returns the index of the allocated area whose first page after
+// the guard page is startPage or -1 if no such area exists. Callers must hold
+// vmAreaLock.
+func findVMArea(startPage mm.Page) int {
+	for index := 0; index < vmAreaCount; index++ {
+		if vmAreas[index].inUse && vmAreas[index].start+1 == startPage {
+			return index
+		}
+	}
+
+	return -1
+}
+
+// collectVMAreaStats implements mm.StatsCollector for the kernel region
+// allocator. Guard pages are not included in the reported sizes.
+func collectVMAreaStats(stats *mm.MemStats) {
+	vmAreaLock.Acquire()
+	defer vmAreaLock.Release()
+
+	for index := 0; index < vmAreaCount; index++ {
+		area := &vmAreas[index]
+		if !area.inUse {
+			continue
+		}
+
+		stats.VmallocRegions++
+		if size := uint64(area.pageCount-1) << mm.PageShift; area.borrowed {
+			stats.VmallocMappedBytes += size
+		} else {
+			stats.VmallocBytes += size
+		}
+	}
+}
+
+func init() {
+	mm.RegisterStatsCollector(collectVMAreaStats)
+}
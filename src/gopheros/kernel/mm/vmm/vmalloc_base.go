@@ -0,0 +1,69 @@
+package vmm
+
+import (
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/mm"
+)
+
+const (
+	// vmallocBaseAlign defines the alignment of the randomized base of the
+	// AllocRegion range. It matches the 2M huge page size so that randomization does
+	// not prevent huge page mappings.
+	vmallocBaseAlign = uintptr(2 << 20)
+
+	// maxRDRANDRetries defines the number of times that entropy reads
+	// from the on-chip random number generator are retried before
+	// falling back to the timestamp counter.
+	maxRDRANDRetries = 10
+)
+
+var (
+	// vmallocBase is the start of the kernel virtual address range used
+	// by AllocRegion. It is set to a random offset into
+	// [vmallocStart, vmallocEnd) by randomizeVMallocBase.
+	vmallocBase = vmallocStart
+
+	// The following functions are mocked by tests.
+	hasRDRANDFn  = cpu.HasRDRAND
+	readRandomFn = cpu.ReadRandom
+	readTSCFn    = cpu.ReadTSC
+)
+
+// randomizeVMallocBase moves the base of the range managed by AllocRegion to a
+// random 2M-aligned offset in the first half of its window so that the
+// addresses of objects allocated in it (e.g. kernel stacks) cannot be
+// predicted. At least half of the window remains available for allocations.
+//
+// The virtual base of the kernel image is randomized separately by the rt0
+// code before the Go runtime starts; the recursive PDT mapping and the
+// temporary mapping page remain at fixed addresses.
+func randomizeVMallocBase() {
+	slots := uint64((vmallocEnd-vmallocStart)/2) / uint64(vmallocBaseAlign)
+	vmallocBase = vmallocStart + uintptr(bootEntropy()%slots)*vmallocBaseAlign
+}
+
+// bootEntropy returns a random value obtained via RDRAND. If the CPU does not
+// support RDRAND or the generator fails to provide a value, bootEntropy
+// falls back to mixing the bits of the timestamp counter.
+func bootEntropy() uint64 {
+	if hasRDRANDFn() {
+		for retry := 0; retry < maxRDRANDRetries; retry++ {
+			if val, ok := readRandomFn(); ok {
+				return val
+			}
+		}
+	}
+
+	// Use the splitmix64 finalizer to spread the entropy from the low
+	// bits of the timestamp counter across the whole value
+	val := readTSCFn()
+	val = (val ^ (val >> 30)) * 0xbf58476d1ce4e5b9
+	val = (val ^ (val >> 27)) * 0x94d049bb133111eb
+	return val ^ (val >> 31)
+}
+
+// vmallocPageCount returns the number of pages in the range managed by
+// AllocRegion.
+func vmallocPageCount() uintptr {
+	return (vmallocEnd - vmallocBase) >> mm.PageShift
+}
@@ -0,0 +1,52 @@
+package vmm
+
+import (
+	"gopheros/kernel/cpu"
+	"testing"
+)
+
+func TestRandomizeVMallocBase(t *testing.T) {
+	defer func(origBase uintptr) {
+		vmallocBase = origBase
+		hasRDRANDFn = cpu.HasRDRAND
+		readRandomFn = cpu.ReadRandom
+		readTSCFn = cpu.ReadTSC
+	}(vmallocBase)
+
+	slots := uint64((vmallocEnd-vmallocStart)/2) / uint64(vmallocBaseAlign)
+
+	specs := []struct {
+		hasRDRAND   bool
+		failedReads int
+		random      uint64
+		tsc         uint64
+		expBase     uintptr
+	}{
+		{true, 0, 42, 0, vmallocStart + 42*vmallocBaseAlign},
+		// Offsets wrap around the number of available slots
+		{true, 2, slots + 1, 0, vmallocStart + vmallocBaseAlign},
+		// RDRAND keeps failing; fall back to the TSC
+		{true, maxRDRANDRetries, 42, 0, vmallocStart},
+		{false, 0, 42, 0, vmallocStart},
+		{false, 0, 42, 1, vmallocStart + uintptr(0x5692161d100b05e5%slots)*vmallocBaseAlign},
+	}
+
+	for specIndex, spec := range specs {
+		reads := 0
+		hasRDRANDFn = func() bool { return spec.hasRDRAND }
+		readRandomFn = func() (uint64, bool) {
+			reads++
+			return spec.random, reads > spec.failedReads
+		}
+		readTSCFn = func() uint64 { return spec.tsc }
+
+		randomizeVMallocBase()
+		if vmallocBase != spec.expBase {
+			t.Errorf("[spec %d] expected base to be 0x%x; got 0x%x", specIndex, spec.expBase, vmallocBase)
+		}
+
+		if vmallocBase%vmallocBaseAlign != 0 || vmallocBase >= vmallocStart+(vmallocEnd-vmallocStart)/2 {
+			t.Errorf("[spec %d] base 0x%x is not aligned or leaves less than half of the range available", specIndex, vmallocBase)
+		}
+	}
+}
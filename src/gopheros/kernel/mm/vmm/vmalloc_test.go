@@ -0,0 +1,269 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/mm"
+	"testing"
+)
+
+func TestAllocFreeRegion(t *testing.T) {
+	defer func() {
+		mapFn = Map
+		unmapFn = Unmap
+		translateFn = Translate
+		mm.SetFrameAllocator(nil)
+		mm.SetFrameReleaser(nil)
+		vmAreaCount = 0
+	}()
+	vmAreaCount = 0
+
+	var (
+		nextFrame = mm.Frame(100)
+		mappings  = make(map[mm.Page]mm.Frame)
+		freed     []mm.Frame
+	)
+
+	mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) {
+		nextFrame++
+		return nextFrame, nil
+	})
+	mm.SetFrameReleaser(func(frame mm.Frame) *kernel.Error {
+		freed = append(freed, frame)
+		return nil
+	})
+	mapFn = func(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
+		if !(pageTableEntry(flags)).HasFlags(FlagPresent | FlagRW) {
+			t.Errorf("expected page to be mapped with FlagPresent | FlagRW; got %x", flags)
+		}
+		mappings[page] = frame
+		return nil
+	}
+	unmapFn = func(page mm.Page) *kernel.Error {
+		delete(mappings, page)
+		return nil
+	}
+	translateFn = func(virtAddr uintptr) (uintptr, *kernel.Error) {
+		frame, ok := mappings[mm.PageFromAddress(virtAddr)]
+		if !ok {
+			return 0, ErrInvalidMapping
+		}
+		return frame.Address(), nil
+	}
+
+	if _, err := AllocRegion(0, FlagRW); err != errVMAreaEmpty {
+		t.Fatalf("expected to get error %v; got %v", errVMAreaEmpty, err)
+	}
+
+	r1, err := AllocRegion(3*mm.PageSize+1, FlagRW)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r2, err := AllocRegion(mm.PageSize, FlagRW)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Regions are preceded by an unmapped guard page
+	basePage := mm.PageFromAddress(vmallocBase)
+	if r1 != basePage+1 || r2 != r1+5 {
+		t.Fatalf("expected regions to start at pages %d and %d; got %d and %d", basePage+1, basePage+6, r1, r2)
+	}
+
+	if len(mappings) != 5 {
+		t.Fatalf("expected 5 pages to be mapped; got %d", len(mappings))
+	}
+
+	for _, guard := range []mm.Page{r1 - 1, r2 - 1} {
+		if _, mapped := mappings[guard]; mapped {
+			t.Fatalf("expected guard page %d not to be mapped", guard)
+		}
+	}
+
+	if err = FreeRegion(r1 + 1); err != errVMAreaInvalidRegion {
+		t.Fatalf("expected to get error %v; got %v", errVMAreaInvalidRegion, err)
+	}
+
+	if err = FreeRegion(r1); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(freed) != 4 || len(mappings) != 1 {
+		t.Fatalf("expected 4 frames to be released and 1 page to remain mapped; got %d and %d", len(freed), len(mappings))
+	}
+
+	if err = FreeRegion(r1); err != errVMAreaInvalidRegion {
+		t.Fatalf("expected double free to fail with %v; got %v", errVMAreaInvalidRegion, err)
+	}
+
+	// The released area should be reused
+	r3, err := AllocRegion(2*mm.PageSize, FlagRW)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r3 != r1 {
+		t.Fatalf("expected released area at page %d to be reused; got %d", r1, r3)
+	}
+
+	// Once all regions are released, the free areas should be merged
+	for _, region := range []mm.Page{r2, r3} {
+		if err = FreeRegion(region); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if vmAreaCount != 1 || vmAreas[0].inUse || vmAreas[0].pageCount != vmallocPageCount() {
+		t.Fatalf("expected free areas to be merged into a single area; got %d areas", vmAreaCount)
+	}
+
+	t.Run("errors", func(t *testing.T) {
+		expErr := &kernel.Error{Module: "test", Message: "something went wrong"}
+
+		if _, err := AllocRegion(vmallocEnd-vmallocBase, FlagRW); err != errVMAreaNoSpace {
+			t.Errorf("expected to get error %v; got %v", errVMAreaNoSpace, err)
+		}
+
+		freed = freed[:0]
+		allocCount := 0
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) {
+			if allocCount++; allocCount == 3 {
+				return mm.InvalidFrame, expErr
+			}
+			return mm.Frame(allocCount), nil
+		})
+
+		if _, err := AllocRegion(4*mm.PageSize, FlagRW); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if len(freed) != 2 || len(mappings) != 0 || vmAreaCount != 1 {
+			t.Errorf("expected partially allocated region to be released")
+		}
+
+		freed = freed[:0]
+		mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) { return mm.Frame(1), nil })
+		mapFn = func(mm.Page, mm.Frame, PageTableEntryFlag) *kernel.Error { return expErr }
+
+		if _, err := AllocRegion(mm.PageSize, FlagRW); err != expErr {
+			t.Errorf("expected to get error %v; got %v", expErr, err)
+		}
+
+		if len(freed) != 1 || vmAreaCount != 1 {
+			t.Errorf("expected frame and area to be released when mapping fails")
+		}
+
+		mapFn = func(mm.Page, mm.Frame, PageTableEntryFlag) *kernel.Error { return nil }
+		for vmAreaCount < maxVMAreas {
+			if _, err := AllocRegion(mm.PageSize, FlagRW); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		if _, err := AllocRegion(mm.PageSize, FlagRW); err != errVMAreaLimit {
+			t.Errorf("expected to get error %v; got %v", errVMAreaLimit, err)
+		}
+	})
+}
+
+func TestMapFrames(t *testing.T) {
+	defer func() {
+		mapFn = Map
+		unmapFn = Unmap
+		translateFn = Translate
+		mm.SetFrameReleaser(nil)
+		vmAreaCount = 0
+	}()
+	vmAreaCount = 0
+
+	var (
+		mappings = make(map[mm.Page]mm.Frame)
+		freed    int
+		expErr   = &kernel.Error{Module: "test", Message: "something went wrong"}
+	)
+
+	mm.SetFrameReleaser(func(mm.Frame) *kernel.Error {
+		freed++
+		return nil
+	})
+	mapFn = func(page mm.Page, frame mm.Frame, flags PageTableEntryFlag) *kernel.Error {
+		if len(mappings) == 3 {
+			return expErr
+		}
+		mappings[page] = frame
+		return nil
+	}
+	unmapFn = func(page mm.Page) *kernel.Error {
+		delete(mappings, page)
+		return nil
+	}
+	translateFn = func(virtAddr uintptr) (uintptr, *kernel.Error) {
+		frame, ok := mappings[mm.PageFromAddress(virtAddr)]
+		if !ok {
+			return 0, ErrInvalidMapping
+		}
+		return frame.Address(), nil
+	}
+
+	if _, err := MapFrames(mm.Frame(100), 0, FlagRW); err != errVMAreaEmpty {
+		t.Fatalf("expected to get error %v; got %v", errVMAreaEmpty, err)
+	}
+
+	region, err := MapFrames(mm.Frame(100), 2*mm.PageSize+1, FlagRW)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for index := mm.Page(0); index < 3; index++ {
+		if got := mappings[region+index]; got != mm.Frame(100)+mm.Frame(index) {
+			t.Fatalf("expected page %d to be mapped to frame %d; got %d", region+index, 100+index, got)
+		}
+	}
+
+	// Frames are owned by the caller and must not be released
+	if err = FreeRegion(region); err != nil {
+		t.Fatal(err)
+	}
+
+	if freed != 0 || len(mappings) != 0 || vmAreaCount != 1 || vmAreas[0].borrowed {
+		t.Fatalf("expected region to be unmapped without releasing its frames; got %d released frames and %d mappings", freed, len(mappings))
+	}
+
+	// Partially mapped regions are unmapped if mapping fails
+	if _, err = MapFrames(mm.Frame(100), 4*mm.PageSize, FlagRW); err != expErr {
+		t.Fatalf("expected to get error %v; got %v", expErr, err)
+	}
+
+	if freed != 0 || len(mappings) != 0 || vmAreaCount != 1 {
+		t.Fatal("expected partially mapped region to be released")
+	}
+}
+
+func TestCollectVMAreaStats(t *testing.T) {
+	defer func() {
+		mapFn = Map
+		mm.SetFrameAllocator(nil)
+		vmAreaCount = 0
+	}()
+	vmAreaCount = 0
+
+	mm.SetFrameAllocator(func() (mm.Frame, *kernel.Error) { return mm.Frame(100), nil })
+	mapFn = func(mm.Page, mm.Frame, PageTableEntryFlag) *kernel.Error { return nil }
+
+	if _, err := AllocRegion(3*mm.PageSize, FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MapFrames(mm.Frame(200), mm.PageSize+1, FlagRW); err != nil {
+		t.Fatal(err)
+	}
+
+	var stats mm.MemStats
+	collectVMAreaStats(&stats)
+
+	if stats.VmallocRegions != 2 || stats.VmallocBytes != uint64(3*mm.PageSize) || stats.VmallocMappedBytes != uint64(2*mm.PageSize) {
+		t.Fatalf("expected 2 regions with %d allocated and %d mapped bytes; got %d regions with %d allocated and %d mapped bytes",
+			3*mm.PageSize, 2*mm.PageSize, stats.VmallocRegions, stats.VmallocBytes, stats.VmallocMappedBytes,
+		)
+	}
+}
@@ -12,18 +12,40 @@ var (
 	readCR2Fn   = cpu.ReadCR2
 	translateFn = Translate
 
-	errUnrecoverableFault = &kernel.Error{Module: "vmm", Message: "page/gpf fault"}
+	// supports1GPagesFn is used by tests to override the CPU feature check
+	// for 1G page support.
+	supports1GPagesFn = func() bool {
+		_, _, _, edx := cpu.ID(0x80000001)
+		return edx&(1<<26) != 0
+	}
+
+	errUnrecoverableFault = &kernel.Error{Module: "vmm", Message: "page/gpf fault", Code: kernel.ErrCodeFault}
 )
 
-// Init initializes the vmm system, creates a granular PDT for the kernel and
-// installs paging-related exception handlers.
-func Init(kernelPageOffset uintptr) *kernel.Error {
-	if err := setupPDTForKernel(kernelPageOffset); err != nil {
+// Init initializes the vmm system, enables support for non-executable pages,
+// randomizes the base of the AllocRegion address range, creates a granular PDT for the
+// kernel and installs paging-related exception handlers. The kernelSlide
+// argument specifies the offset between the virtual address that the rt0 code
+// relocated the kernel image to and the address that it was linked at.
+func Init(kernelPageOffset, kernelSlide uintptr) *kernel.Error {
+	if err := enableNoExecute(); err != nil {
+		return err
+	}
+
+	if supports1GPagesFn() {
+		largestPageLevel = hugePageMinLevel
+	}
+
+	randomizeVMallocBase()
+
+	if err := setupPDTForKernel(kernelPageOffset, kernelSlide); err != nil {
 		return err
 	}
 
 	// Install arch-specific handlers for vmm-related faults.
-	installFaultHandlers()
+	if err := installFaultHandlers(); err != nil {
+		return err
+	}
 
 	return reserveZeroedFrame()
 }
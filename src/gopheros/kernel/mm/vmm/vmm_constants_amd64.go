@@ -16,6 +16,26 @@ const (
 	// pages). For amd64 this address uses the following table indices:
 	// 510, 511, 511, 511.
 	tempMappingAddr = uintptr(0Xffffff7ffffff000)
+
+	// vmallocStart and vmallocEnd define the kernel virtual address range
+	// managed by AllocRegion. For amd64 this range covers the 512G that
+	// correspond to P4 table index 508.
+	vmallocStart = uintptr(0xfffffe0000000000)
+	vmallocEnd   = uintptr(0xfffffe8000000000)
+
+	// kernelHalfStart is the first address of the upper half of the
+	// address space whose mappings are shared by all PDTs.
+	kernelHalfStart = uintptr(0xffff800000000000)
+
+	// userHalfEnd is the end of the lower half of the address space whose
+	// mappings are private to each AddressSpace.
+	userHalfEnd = uintptr(0x0000800000000000)
+
+	// hugePageMinLevel is the top-most page level whose entries can map a
+	// huge page instead of pointing to a page table. For amd64, PDPT
+	// entries (level 1) can map 1G pages and PD entries (level 2) can map
+	// 2M pages.
+	hugePageMinLevel = 1
 )
 
 var (
@@ -71,7 +91,9 @@ const (
 	// FlagDirty is set by the CPU when this page is modified.
 	FlagDirty
 
-	// FlagHugePage is set if when using 2Mb pages instead of 4K pages.
+	// FlagHugePage is set if when using 2Mb or 1Gb pages instead of 4K
+	// pages. When passed to MapRegion or IdentityMapRegion, it requests
+	// the region to be mapped using huge pages where possible.
 	FlagHugePage
 
 	// FlagGlobal if set, prevents the TLB from flushing the cached memory address
@@ -19,7 +19,28 @@ func TestInit(t *testing.T) {
 		mapTemporaryFn = MapTemporary
 		unmapFn = Unmap
 		handleInterruptFn = gate.HandleInterrupt
+		setInterruptStackFn = gate.SetInterruptStack
+		allocStackFn = AllocStack
 	}()
+	setInterruptStackFn = func(_ uint8, _ uintptr) {}
+	allocStackFn = func(_ uintptr, _ uint64) (uintptr, *kernel.Error) { return 0x1000, nil }
+
+	defer func(origSupportsNX func() bool) {
+		supportsNXFn = origSupportsNX
+		readMSRFn = cpu.ReadMSR
+		writeMSRFn = cpu.WriteMSR
+	}(supportsNXFn)
+	supportsNXFn = func() bool { return true }
+	readMSRFn = func(uint32) uint64 { return eferNXE }
+	writeMSRFn = func(uint32, uint64) {}
+
+	defer func(origBase uintptr) {
+		vmallocBase = origBase
+		hasRDRANDFn = cpu.HasRDRAND
+		readRandomFn = cpu.ReadRandom
+	}(vmallocBase)
+	hasRDRANDFn = func() bool { return true }
+	readRandomFn = func() (uint64, bool) { return 3, true }
 
 	// reserve space for an allocated page
 	reservedPage := make([]byte, mm.PageSize)
@@ -44,10 +65,24 @@ func TestInit(t *testing.T) {
 		mapTemporaryFn = func(f mm.Frame) (mm.Page, *kernel.Error) { return mm.Page(f), nil }
 		handleInterruptFn = func(_ gate.InterruptNumber, _ uint8, _ func(*gate.Registers)) {}
 
-		if err := Init(0); err != nil {
+		defer func(origSupports1GPages func() bool, origLargestPageLevel uint8) {
+			supports1GPagesFn = origSupports1GPages
+			largestPageLevel = origLargestPageLevel
+		}(supports1GPagesFn, largestPageLevel)
+		supports1GPagesFn = func() bool { return true }
+
+		if err := Init(0, 0); err != nil {
 			t.Fatal(err)
 		}
 
+		if exp := vmallocStart + 3*vmallocBaseAlign; vmallocBase != exp {
+			t.Errorf("expected region allocator base to be randomized to 0x%x; got 0x%x", exp, vmallocBase)
+		}
+
+		if largestPageLevel != hugePageMinLevel {
+			t.Errorf("expected largest page level to be %d when 1G pages are supported; got %d", hugePageMinLevel, largestPageLevel)
+		}
+
 		// reserved page should be zeroed
 		for i := 0; i < len(reservedPage); i++ {
 			if reservedPage[i] != 0 {
@@ -56,6 +91,15 @@ func TestInit(t *testing.T) {
 		}
 	})
 
+	t.Run("no-execute not supported", func(t *testing.T) {
+		defer func() { supportsNXFn = func() bool { return true } }()
+		supportsNXFn = func() bool { return false }
+
+		if err := Init(0, 0); err != errNoExecuteUnsupported {
+			t.Fatalf("expected error: %v; got %v", errNoExecuteUnsupported, err)
+		}
+	})
+
 	t.Run("setupPDT fails", func(t *testing.T) {
 		expErr := &kernel.Error{Module: "test", Message: "out of memory"}
 
@@ -65,7 +109,7 @@ func TestInit(t *testing.T) {
 			return mm.InvalidFrame, expErr
 		})
 
-		if err := Init(0); err != expErr {
+		if err := Init(0, 0); err != expErr {
 			t.Fatalf("expected error: %v; got %v", expErr, err)
 		}
 	})
@@ -94,7 +138,7 @@ func TestInit(t *testing.T) {
 		mapTemporaryFn = func(f mm.Frame) (mm.Page, *kernel.Error) { return mm.Page(f), nil }
 		handleInterruptFn = func(_ gate.InterruptNumber, _ uint8, _ func(*gate.Registers)) {}
 
-		if err := Init(0); err != expErr {
+		if err := Init(0, 0); err != expErr {
 			t.Fatalf("expected error: %v; got %v", expErr, err)
 		}
 	})
@@ -114,7 +158,7 @@ func TestInit(t *testing.T) {
 		mapTemporaryFn = func(f mm.Frame) (mm.Page, *kernel.Error) { return mm.Page(f), expErr }
 		handleInterruptFn = func(_ gate.InterruptNumber, _ uint8, _ func(*gate.Registers)) {}
 
-		if err := Init(0); err != expErr {
+		if err := Init(0, 0); err != expErr {
 			t.Fatalf("expected error: %v; got %v", expErr, err)
 		}
 	})
@@ -0,0 +1,176 @@
+package vmm
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/sync"
+)
+
+const (
+	// maxWXExceptions defines the max number of regions that can be
+	// exempted from the W^X policy.
+	maxWXExceptions = 8
+
+	// msrEFER is the extended feature enable register and eferNXE is the
+	// bit that enables support for the no-execute page flag.
+	msrEFER = 0xc0000080
+	eferNXE = 1 << 11
+)
+
+// wxException describes a range of pages that may be mapped both writable
+// and executable.
+type wxException struct {
+	start, end mm.Page
+}
+
+var (
+	wxLock           sync.Spinlock
+	wxExceptions     [maxWXExceptions]wxException
+	wxExceptionCount int
+
+	// The following functions are mocked by tests.
+	readMSRFn    = cpu.ReadMSR
+	writeMSRFn   = cpu.WriteMSR
+	supportsNXFn = func() bool {
+		_, _, _, edx := cpu.ID(0x80000001)
+		return edx&(1<<20) != 0
+	}
+
+	errNoExecuteUnsupported = &kernel.Error{Module: "vmm", Message: "CPU does not support the no-execute page flag", Code: kernel.ErrCodeNotSupported}
+	errWXExceptionLimit     = &kernel.Error{Module: "vmm", Message: "max number of W^X exceptions exceeded", Code: kernel.ErrCodeOutOfMemory}
+	errWXViolation          = &kernel.Error{Module: "vmm", Message: "found pages that are both writable and executable", Code: kernel.ErrCodePermission}
+)
+
+// AllowWriteExecute exempts pageCount pages starting at start from the W^X
+// policy. By default, Map adds FlagNoExecute to all writable mappings; pages
+// in an exempted range can be mapped both writable and executable. This is
+// only meant to be used for the rare cases that legitimately require such
+// mappings (e.g. the trampoline used for booting application processors)
+// and must be invoked before the range is mapped.
+func AllowWriteExecute(start mm.Page, pageCount uintptr) *kernel.Error {
+	wxLock.Acquire()
+	defer wxLock.Release()
+
+	if wxExceptionCount == maxWXExceptions {
+		return errWXExceptionLimit
+	}
+
+	wxExceptions[wxExceptionCount] = wxException{start: start, end: start + mm.Page(pageCount)}
+	wxExceptionCount++
+	return nil
+}
+
+// wxAllowed returns true if page belongs to a range exempted from the W^X
+// policy.
+func wxAllowed(page mm.Page) bool {
+	wxLock.Acquire()
+	defer wxLock.Release()
+
+	for index := 0; index < wxExceptionCount; index++ {
+		if page >= wxExceptions[index].start && page < wxExceptions[index].end {
+			return true
+		}
+	}
+
+	return false
+}
+
+// wxFlags applies the W^X policy to the flags for mapping virtAddr by
+// flagging writable mappings as non-executable.
+func wxFlags(virtAddr uintptr, flags PageTableEntryFlag) PageTableEntryFlag {
+	if flags&FlagRW != 0 && flags&FlagNoExecute == 0 && !wxAllowed(mm.PageFromAddress(virtAddr)) {
+		flags |= FlagNoExecute
+	}
+
+	return flags
+}
+
+// enableNoExecute ensures that the no-execute page flag is enabled so that
+// the CPU honors FlagNoExecute instead of treating it as a reserved bit.
+func enableNoExecute() *kernel.Error {
+	if !supportsNXFn() {
+		return errNoExecuteUnsupported
+	}
+
+	if efer := readMSRFn(msrEFER); efer&eferNXE == 0 {
+		writeMSRFn(msrEFER, efer|eferNXE)
+	}
+
+	return nil
+}
+
+// VerifyWX scans the active page tables and reports any page that is both
+// writable and executable and does not belong to a range registered via
+// AllowWriteExecute. It is meant to be invoked late in the boot process once
+// all drivers have established their mappings.
+func VerifyWX() *kernel.Error {
+	var violations int
+
+	visitMappings(0, ^uintptr(0), func(virtAddr uintptr, level uint8, flags PageTableEntryFlag) {
+		if flags&FlagRW == 0 || flags&FlagNoExecute != 0 || wxAllowed(mm.PageFromAddress(virtAddr)) {
+			return
+		}
+
+		kfmt.Printf("[vmm] W^X violation: page at 0x%16x (level %d) is writable and executable\n", virtAddr, level)
+		violations++
+	})
+
+	if violations != 0 {
+		return errWXViolation
+	}
+
+	return nil
+}
+
+// visitMappings invokes visitor for each page (or huge page) mapped by the
+// active page tables that overlaps the inclusive range [first, last]. The
+// visitor receives the effective flags for the page: FlagRW and
+// FlagUserAccessible are only reported if all page table entries leading to
+// the page have them set while FlagNoExecute is reported if any of them has
+// it set.
+func visitMappings(first, last uintptr, visitor func(virtAddr uintptr, level uint8, flags PageTableEntryFlag)) {
+	visitTable(pdtVirtualAddr, 0, 0, first, last, FlagRW|FlagUserAccessible, 0, visitor)
+}
+
+// visitTable implements visitMappings for the table at the recursively mapped
+// address tableAddr that maps the virtual addresses starting at virtBase.
+// The allowed flags are cleared from the visited entries unless all parent
+// entries have them set whereas the denied flags are set on the visited
+// entries if any parent entry has them set.
+func visitTable(tableAddr uintptr, level uint8, virtBase, first, last uintptr, allowed, denied PageTableEntryFlag, visitor func(uintptr, uint8, PageTableEntryFlag)) {
+	entryCount := uintptr(1) << pageLevelBits[level]
+	for index := uintptr(0); index < entryCount; index++ {
+		// Skip the recursive mapping of the top-most table
+		if level == 0 && index == entryCount-1 {
+			continue
+		}
+
+		virtAddr := virtBase | (index << pageLevelShifts[level])
+		if level == 0 && index >= entryCount/2 {
+			// Sign-extend addresses in the upper half
+			virtAddr |= ^uintptr(0) << (pageLevelShifts[0] + pageLevelBits[0])
+		}
+
+		if virtAddr > last || virtAddr+(uintptr(1)<<pageLevelShifts[level])-1 < first {
+			continue
+		}
+
+		entryAddr := tableAddr + (index << mm.PointerShift)
+		pte := (*pageTableEntry)(ptePtrFn(entryAddr))
+		if !pte.HasFlags(FlagPresent) {
+			continue
+		}
+
+		entryFlags := PageTableEntryFlag(uintptr(*pte) &^ ptePhysPageMask)
+		entryAllowed := allowed & entryFlags
+		entryDenied := denied | (entryFlags & FlagNoExecute)
+		if level == pageLevels-1 || pte.HasFlags(FlagHugePage) {
+			visitor(virtAddr, level, (entryFlags&^(FlagRW|FlagUserAccessible))|entryAllowed|entryDenied)
+			continue
+		}
+
+		visitTable(entryAddr<<pageLevelBits[level], level+1, virtAddr, first, last, entryAllowed, entryDenied, visitor)
+	}
+}
@@ -0,0 +1,186 @@
+package vmm
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/mm"
+	"runtime"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestEnableNoExecute(t *testing.T) {
+	defer func(origSupportsNX func() bool, origReadMSR func(uint32) uint64, origWriteMSR func(uint32, uint64)) {
+		supportsNXFn, readMSRFn, writeMSRFn = origSupportsNX, origReadMSR, origWriteMSR
+	}(supportsNXFn, readMSRFn, writeMSRFn)
+
+	specs := []struct {
+		supported bool
+		efer      uint64
+		expEFER   uint64
+		expErr    *kernel.Error
+	}{
+		{false, 0, 0, errNoExecuteUnsupported},
+		{true, 1 << 8, 1<<8 | eferNXE, nil},
+		// NXE already set; the register should not be written
+		{true, 1<<8 | eferNXE, 0, nil},
+	}
+
+	for specIndex, spec := range specs {
+		var writtenEFER uint64
+		supportsNXFn = func() bool { return spec.supported }
+		readMSRFn = func(msr uint32) uint64 {
+			if msr != msrEFER {
+				t.Errorf("[spec %d] expected EFER to be read; got MSR 0x%x", specIndex, msr)
+			}
+			return spec.efer
+		}
+		writeMSRFn = func(msr uint32, val uint64) { writtenEFER = val }
+
+		if err := enableNoExecute(); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+		}
+
+		if writtenEFER != spec.expEFER {
+			t.Errorf("[spec %d] expected EFER to be set to 0x%x; got 0x%x", specIndex, spec.expEFER, writtenEFER)
+		}
+	}
+}
+
+func TestWXFlags(t *testing.T) {
+	defer func() { wxExceptionCount = 0 }()
+	wxExceptionCount = 0
+
+	if err := AllowWriteExecute(mm.Page(8), 2); err != nil {
+		t.Fatal(err)
+	}
+
+	specs := []struct {
+		page     mm.Page
+		flags    PageTableEntryFlag
+		expFlags PageTableEntryFlag
+	}{
+		{1, FlagPresent, FlagPresent},
+		{1, FlagPresent | FlagRW, FlagPresent | FlagRW | FlagNoExecute},
+		{1, FlagPresent | FlagRW | FlagNoExecute, FlagPresent | FlagRW | FlagNoExecute},
+		{8, FlagPresent | FlagRW, FlagPresent | FlagRW},
+		{9, FlagPresent | FlagRW, FlagPresent | FlagRW},
+		{10, FlagPresent | FlagRW, FlagPresent | FlagRW | FlagNoExecute},
+	}
+
+	for specIndex, spec := range specs {
+		if got := wxFlags(spec.page.Address(), spec.flags); got != spec.expFlags {
+			t.Errorf("[spec %d] expected flags to be 0x%x; got 0x%x", specIndex, spec.expFlags, got)
+		}
+	}
+
+	for wxExceptionCount < maxWXExceptions {
+		if err := AllowWriteExecute(mm.Page(100), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := AllowWriteExecute(mm.Page(200), 1); err != errWXExceptionLimit {
+		t.Fatalf("expected to get error %v; got %v", errWXExceptionLimit, err)
+	}
+}
+
+func TestVerifyWXAmd64(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("test requires amd64 runtime; skipping")
+	}
+
+	var (
+		buf bytes.Buffer
+		f   = newFakePageTables(6)
+		p4  = f.table(f.allocTable())
+	)
+
+	defer func(origPtePtr func(uintptr) unsafe.Pointer) {
+		ptePtrFn = origPtePtr
+		kfmt.SetOutputSink(nil)
+		wxExceptionCount = 0
+	}(ptePtrFn)
+	wxExceptionCount = 0
+	kfmt.SetOutputSink(&buf)
+
+	ptePtrFn = recursivePtePtrFn(f, p4)
+
+	// Recursive mapping; must be skipped by the walker
+	setTestEntry(&p4[511], uintptr(unsafe.Pointer(p4)), FlagPresent|FlagRW)
+
+	// Lower half: a RWX 2M page, a RW page, a RO executable page and a
+	// non-present page
+	pdpt, pd, pt := f.allocTable(), f.allocTable(), f.allocTable()
+	setTestEntry(&p4[0], pdpt, FlagPresent|FlagRW)
+	setTestEntry(&f.table(pdpt)[0], pd, FlagPresent|FlagRW)
+	setTestEntry(&f.table(pd)[0], 0, FlagPresent|FlagRW|FlagHugePage)
+	setTestEntry(&f.table(pd)[1], pt, FlagPresent|FlagRW)
+	setTestEntry(&f.table(pt)[0], 0, FlagPresent|FlagRW|FlagNoExecute)
+	setTestEntry(&f.table(pt)[1], 0, FlagPresent)
+	setTestEntry(&f.table(pt)[3], 0, FlagRW)
+
+	// Upper half: a RWX 1G page and a table whose entries inherit NX
+	upperPDPT, upperPD := f.allocTable(), f.allocTable()
+	setTestEntry(&p4[300], upperPDPT, FlagPresent|FlagRW)
+	setTestEntry(&f.table(upperPDPT)[2], 0, FlagPresent|FlagRW|FlagHugePage)
+	setTestEntry(&f.table(upperPDPT)[3], upperPD, FlagPresent|FlagRW|FlagNoExecute)
+	setTestEntry(&f.table(upperPD)[0], 0, FlagPresent|FlagRW|FlagHugePage)
+
+	if err := VerifyWX(); err != errWXViolation {
+		t.Fatalf("expected to get error %v; got %v", errWXViolation, err)
+	}
+
+	expOutput := "[vmm] W^X violation: page at 0x0000000000000000 (level 2) is writable and executable\n" +
+		"[vmm] W^X violation: page at 0xffff960080000000 (level 1) is writable and executable\n"
+	if got := buf.String(); got != expOutput {
+		t.Fatalf("expected output:\n%q\ngot:\n%q", expOutput, got)
+	}
+
+	// Exempted pages are not reported
+	for _, addr := range []uintptr{0, 0xffff960080000000} {
+		if err := AllowWriteExecute(mm.PageFromAddress(addr), 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	buf.Reset()
+	if err := VerifyWX(); err != nil {
+		t.Fatalf("unexpected error: %v; output:\n%s", err, buf.String())
+	}
+
+	if strings.Contains(buf.String(), "violation") {
+		t.Fatalf("expected no violations to be reported; got:\n%s", buf.String())
+	}
+}
+
+// recursivePtePtrFn returns a ptePtrFn implementation that resolves the
+// recursively mapped entry addresses by following the table indices encoded
+// in them starting from the fake p4 table.
+func recursivePtePtrFn(f *fakePageTables, p4 *[mm.PageSize >> mm.PointerShift]pageTableEntry) func(uintptr) unsafe.Pointer {
+	return func(entryAddr uintptr) unsafe.Pointer {
+		var indices [pageLevels]uintptr
+		for level := 0; level < pageLevels; level++ {
+			indices[level] = (entryAddr >> pageLevelShifts[level]) & ((1 << pageLevelBits[level]) - 1)
+		}
+
+		depth := 0
+		for depth < pageLevels && indices[depth] == (1<<pageLevelBits[depth])-1 {
+			depth++
+		}
+
+		table := p4
+		for level := depth; level < pageLevels; level++ {
+			table = f.table(table[indices[level]].Frame().Address())
+		}
+		return unsafe.Pointer(&table[(entryAddr&uintptr(mm.PageSize-1))>>mm.PointerShift])
+	}
+}
+
+func setTestEntry(pte *pageTableEntry, addr uintptr, flags PageTableEntryFlag) {
+	*pte = 0
+	pte.SetFrame(mm.FrameFromAddress(addr))
+	pte.SetFlags(flags)
+}
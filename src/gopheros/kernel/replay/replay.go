@@ -0,0 +1,499 @@
+// Package replay implements a debug facility for deterministically recording
+// and replaying the asynchronous event streams (interrupts, timer ticks and
+// console input) that drive the kernel. A log captured while running under
+// QEMU can be fed back to a subsequent boot so that heisenbugs in the
+// scheduler and drivers become reproducible.
+package replay
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/idle"
+	"gopheros/kernel/kfmt"
+	"gopheros/kernel/kshell"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/multiboot"
+	"io"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// Mode defines the operating mode for the record/replay engine.
+type Mode uint8
+
+// The supported operating modes.
+const (
+	// ModeOff disables recording and replaying of events.
+	ModeOff Mode = iota
+
+	// ModeRecord appends each reported event to the event log.
+	ModeRecord
+
+	// ModeReplay re-injects the events from a previously captured log.
+	ModeReplay
+)
+
+// EventKind describes the type of a recorded event.
+type EventKind uint8
+
+// The list of supported event kinds.
+const (
+	// EventInterrupt is reported when a HW interrupt is serviced. The
+	// event number contains the interrupt vector.
+	EventInterrupt EventKind = iota
+
+	// EventTimerTick is reported by timer drivers for each tick.
+	EventTimerTick
+
+	// EventConsoleInput is reported when console input is received. The
+	// event payload contains the input data.
+	EventConsoleInput
+
+	numEventKinds
+)
+
+// Event describes an entry in the event log.
+type Event struct {
+	Kind EventKind
+
+	// Number is a kind-specific value (e.g. the interrupt vector).
+	Number uint8
+
+	// Timestamp is the value of the engine clock (relative to the time
+	// that recording started) when the event occurred.
+	Timestamp uint64
+
+	// Payload is a kind-specific value (e.g. a scan code).
+	Payload uint64
+}
+
+const (
+	// logMagic is the magic value at the beginning of each serialized log.
+	logMagic = 0x4c505247 // "GRPL"
+
+	// logVersion is the version of the serialized log format.
+	logVersion = 1
+
+	// logHeaderSize is the size of the serialized log header: magic(4),
+	// version(2), reserved(2), event count(4).
+	logHeaderSize = 12
+
+	// eventSize is the size of each serialized event: kind(1), number(1),
+	// timestamp(8) and payload(8).
+	eventSize = 18
+
+	// defaultLogCapacity is the number of events that can be recorded
+	// before the log overflows.
+	defaultLogCapacity = 4096
+
+	// logModuleTag is the token that must be present in the command line
+	// of the boot module that contains the event log to be replayed (e.g.
+	// "module2 /boot/events.log replay_log" when using grub2).
+	logModuleTag = "replay_log"
+
+	// The markers used for delimiting the log emitted by the "replay dump"
+	// shell command.
+	dumpBegin = "-----BEGIN REPLAY LOG-----\n"
+	dumpEnd   = "\n-----END REPLAY LOG-----\n"
+)
+
+var (
+	// clockFn returns the current value of the clock used for
+	// timestamping events.
+	clockFn = cpu.ReadTSC
+
+	getBootCmdLineFn  = multiboot.GetBootCmdLine
+	handleInterruptFn = gate.HandleInterrupt
+	visitModulesFn    = multiboot.VisitModules
+	mapFramesFn       = vmm.MapFrames
+	freeRegionFn      = vmm.FreeRegion
+
+	// recordedHandlers contains the handlers registered via HandleInterrupt.
+	recordedHandlers [256]func(*gate.Registers)
+
+	// injectedRegs is the register snapshot passed to handlers invoked for
+	// replayed interrupts. Poll is not re-entrant so a single instance is
+	// sufficient and no memory needs to be allocated while injecting.
+	injectedRegs gate.Registers
+
+	errBadLog      = &kernel.Error{Module: "replay", Message: "malformed event log", Code: kernel.ErrCodeCorrupted}
+	errUnsupported = &kernel.Error{Module: "replay", Message: "unsupported event log version", Code: kernel.ErrCodeNotSupported}
+	errInvalidKind = &kernel.Error{Module: "replay", Message: "invalid event kind", Code: kernel.ErrCodeInvalidArgument}
+	errNoLog       = &kernel.Error{Module: "replay", Message: "no boot module tagged with " + logModuleTag, Code: kernel.ErrCodeNotFound}
+	errInvalidArgs = &kernel.Error{Module: "replay", Message: "invalid arguments", Code: kernel.ErrCodeInvalidArgument}
+
+	engine Engine
+)
+
+// Engine records or replays a stream of events.
+type Engine struct {
+	mode       Mode
+	startClock uint64
+
+	// The event log and the index for the next event to be recorded or
+	// replayed.
+	log      []Event
+	logIndex uint32
+
+	// overflow is set to 1 if the event log capacity was exceeded while
+	// recording.
+	overflow uint32
+
+	// polling is set to 1 while Poll is injecting events. It prevents
+	// interrupts that arrive while an event is being injected from
+	// re-entering Poll.
+	polling uint32
+
+	// injectors contains the callbacks for re-injecting each event kind
+	// while replaying a log.
+	injectors [numEventKinds]func(Event)
+}
+
+// StartRecording resets the engine and starts recording events into a log
+// with room for capacity events. If capacity is 0, a default capacity is used.
+func (e *Engine) StartRecording(capacity int) {
+	if capacity <= 0 {
+		capacity = defaultLogCapacity
+	}
+
+	e.log = make([]Event, capacity)
+	e.logIndex = 0
+	e.overflow = 0
+	e.startClock = clockFn()
+	e.mode = ModeRecord
+}
+
+// StartReplay parses a log previously generated by WriteTo and switches the
+// engine to replay mode.
+func (e *Engine) StartReplay(data []byte) *kernel.Error {
+	if len(data) < logHeaderSize || binary.LittleEndian.Uint32(data) != logMagic {
+		return errBadLog
+	}
+
+	if binary.LittleEndian.Uint16(data[4:]) != logVersion {
+		return errUnsupported
+	}
+
+	count := int(binary.LittleEndian.Uint32(data[8:]))
+	if len(data) != logHeaderSize+count*eventSize {
+		return errBadLog
+	}
+
+	log := make([]Event, count)
+	for i, offset := 0, logHeaderSize; i < count; i, offset = i+1, offset+eventSize {
+		log[i] = Event{
+			Kind:      EventKind(data[offset]),
+			Number:    data[offset+1],
+			Timestamp: binary.LittleEndian.Uint64(data[offset+2:]),
+			Payload:   binary.LittleEndian.Uint64(data[offset+10:]),
+		}
+
+		if log[i].Kind >= numEventKinds {
+			return errBadLog
+		}
+	}
+
+	e.log = log
+	e.logIndex = 0
+	e.overflow = 0
+	e.startClock = clockFn()
+	e.mode = ModeReplay
+	return nil
+}
+
+// Stop disables recording or replaying. The recorded log remains available
+// and can be retrieved via a call to WriteTo.
+func (e *Engine) Stop() {
+	e.mode = ModeOff
+}
+
+// Mode returns the current engine mode.
+func (e *Engine) Mode() Mode {
+	return e.mode
+}
+
+// Overflowed returns true if the log capacity was exceeded while recording.
+func (e *Engine) Overflowed() bool {
+	return atomic.LoadUint32(&e.overflow) != 0
+}
+
+// Record appends an event to the log if the engine is in record mode. Record
+// does not allocate memory or take any locks and can be safely called from
+// interrupt context.
+func (e *Engine) Record(kind EventKind, number uint8, payload uint64) {
+	if e.mode != ModeRecord {
+		return
+	}
+
+	// Reserve a slot without advancing the index past the end of the log
+	// so that the index can never wrap around and overwrite earlier events.
+	for {
+		index := atomic.LoadUint32(&e.logIndex)
+		if int(index) >= len(e.log) {
+			atomic.StoreUint32(&e.overflow, 1)
+			return
+		}
+
+		if atomic.CompareAndSwapUint32(&e.logIndex, index, index+1) {
+			e.log[index] = Event{
+				Kind:      kind,
+				Number:    number,
+				Timestamp: clockFn() - e.startClock,
+				Payload:   payload,
+			}
+			return
+		}
+	}
+}
+
+// Events returns the list of recorded events.
+func (e *Engine) Events() []Event {
+	count := int(atomic.LoadUint32(&e.logIndex))
+	if e.mode == ModeReplay {
+		count = len(e.log)
+	}
+
+	return e.log[:count]
+}
+
+// WriteTo serializes the recorded events to w. The output can be fed back to
+// StartReplay.
+func (e *Engine) WriteTo(w io.Writer) (int64, error) {
+	var (
+		buf     [logHeaderSize]byte
+		written int64
+		events  = e.Events()
+	)
+
+	binary.LittleEndian.PutUint32(buf[0:], logMagic)
+	binary.LittleEndian.PutUint16(buf[4:], logVersion)
+	binary.LittleEndian.PutUint32(buf[8:], uint32(len(events)))
+	n, err := w.Write(buf[:])
+	if written += int64(n); err != nil {
+		return written, err
+	}
+
+	var evBuf [eventSize]byte
+	for _, ev := range events {
+		evBuf[0] = uint8(ev.Kind)
+		evBuf[1] = ev.Number
+		binary.LittleEndian.PutUint64(evBuf[2:], ev.Timestamp)
+		binary.LittleEndian.PutUint64(evBuf[10:], ev.Payload)
+		n, err = w.Write(evBuf[:])
+		if written += int64(n); err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// SetInjector registers the callback that re-injects events of the specified
+// kind while replaying a log.
+func (e *Engine) SetInjector(kind EventKind, fn func(Event)) *kernel.Error {
+	if kind >= numEventKinds {
+		return errInvalidKind
+	}
+
+	e.injectors[kind] = fn
+	return nil
+}
+
+// Poll re-injects all events from the replay log whose timestamp has elapsed
+// and returns the number of injected events. Events without a registered
+// injector are skipped. Poll is meant to be invoked periodically (e.g. from
+// the idle loop) and is a no-op unless the engine is in replay mode or when
+// invoked by an interrupt that arrives while another Poll call is injecting
+// events. Once all events have been replayed, the engine switches to ModeOff.
+func (e *Engine) Poll() int {
+	if e.mode != ModeReplay || !atomic.CompareAndSwapUint32(&e.polling, 0, 1) {
+		return 0
+	}
+	defer atomic.StoreUint32(&e.polling, 0)
+
+	var (
+		now      = clockFn() - e.startClock
+		injected int
+	)
+
+	for ; int(e.logIndex) < len(e.log) && e.log[e.logIndex].Timestamp <= now; e.logIndex++ {
+		ev := e.log[e.logIndex]
+		if fn := e.injectors[ev.Kind]; fn != nil {
+			fn(ev)
+			injected++
+		}
+	}
+
+	if int(e.logIndex) == len(e.log) {
+		e.mode = ModeOff
+	}
+
+	return injected
+}
+
+// HandleInterrupt works like gate.HandleInterrupt but also records each
+// serviced interrupt in the global engine before invoking the handler. It is
+// meant to be used for HW interrupts where the Info field of the register
+// snapshot contains the IRQ number.
+//
+// As the gate package only stores the code pointer for installed handlers,
+// handlers cannot be closures. To work around this limitation, a single
+// dispatcher function is installed which looks up the actual handler in a
+// table indexed by the IRQ number.
+func HandleInterrupt(intNumber gate.InterruptNumber, istOffset uint8, handler func(*gate.Registers)) {
+	recordedHandlers[intNumber] = handler
+	handleInterruptFn(intNumber, istOffset, dispatchRecordedInterrupt)
+}
+
+// dispatchRecordedInterrupt records the interrupt described by regs and
+// invokes the handler registered via HandleInterrupt. While the global engine
+// is replaying a log, HW interrupts are not forwarded to their handlers;
+// instead, they drive the engine which invokes the handlers for the
+// interrupts in the log via injectInterrupt.
+func dispatchRecordedInterrupt(regs *gate.Registers) {
+	intNumber := uint8(regs.Info)
+	if engine.Mode() == ModeReplay {
+		engine.Poll()
+		return
+	}
+
+	engine.Record(EventInterrupt, intNumber, 0)
+	if handler := recordedHandlers[intNumber]; handler != nil {
+		handler(regs)
+	}
+}
+
+// injectInterrupt is registered as the global engine injector for interrupt
+// events. It invokes the handler registered via HandleInterrupt for the
+// replayed interrupt vector.
+func injectInterrupt(ev Event) {
+	if handler := recordedHandlers[ev.Number]; handler != nil {
+		injectedRegs = gate.Registers{Info: uint64(ev.Number)}
+		handler(&injectedRegs)
+	}
+}
+
+// pollEngine is registered as an idle loop poller and re-injects the events
+// of the global engine whose timestamp has elapsed.
+func pollEngine() {
+	engine.Poll()
+}
+
+// Tick is invoked by timer drivers for each timer tick. It records the tick
+// and the supplied counter value while the global engine is recording and
+// returns true if the driver should deliver the tick to its event handler.
+// While the global engine is replaying a log, the recorded ticks are
+// delivered by the EventTimerTick injector registered by the driver and Tick
+// returns false.
+func Tick(counter uint64) bool {
+	switch engine.Mode() {
+	case ModeRecord:
+		engine.Record(EventTimerTick, 0, counter)
+	case ModeReplay:
+		return false
+	}
+
+	return true
+}
+
+// Global returns the global record/replay engine used by the kernel.
+func Global() *Engine {
+	return &engine
+}
+
+// Init configures the global engine according to the "replay" command line
+// option. When booted with "replay=record", the engine starts recording
+// events. When booted with "replay=replay", the engine replays the event log
+// stored in the boot module whose command line contains the "replay_log"
+// tag.
+func Init() {
+	switch getBootCmdLineFn()["replay"] {
+	case "record":
+		engine.StartRecording(0)
+	case "replay":
+		if err := loadLogModule(); err != nil {
+			kfmt.Printf("[replay] %s\n", err.Error())
+		}
+	}
+}
+
+// loadLogModule locates the boot module that contains the event log to be
+// replayed and starts replaying it.
+func loadLogModule() *kernel.Error {
+	err := errNoLog
+	visitModulesFn(func(cmdLine string, physStart, physEnd uintptr) bool {
+		for _, token := range strings.Fields(cmdLine) {
+			if token == logModuleTag {
+				err = replayModule(physStart, physEnd)
+				return false
+			}
+		}
+		return true
+	})
+
+	return err
+}
+
+// replayModule maps the boot module at [physStart, physEnd) and passes its
+// contents to StartReplay. The mapping is released once the log is parsed.
+func replayModule(physStart, physEnd uintptr) *kernel.Error {
+	pageOffset := physStart & (mm.PageSize - 1)
+	page, err := mapFramesFn(mm.FrameFromAddress(physStart), physEnd-physStart+pageOffset, vmm.FlagNoExecute)
+	if err != nil {
+		return err
+	}
+
+	data := *(*[]byte)(unsafe.Pointer(&reflect.SliceHeader{
+		Len:  int(physEnd - physStart),
+		Cap:  int(physEnd - physStart),
+		Data: page.Address() + pageOffset,
+	}))
+	err = engine.StartReplay(data)
+
+	_ = freeRegionFn(page)
+	return err
+}
+
+// cmdReplay implements the "replay" kshell command. When invoked without
+// arguments it displays the state of the global engine. The "stop" argument
+// stops recording or replaying while the "dump" argument emits the recorded
+// log as base64-encoded text delimited by BEGIN/END REPLAY LOG marker lines.
+// The decoded log can be passed back to a subsequent boot as a boot module
+// tagged with "replay_log".
+func cmdReplay(w io.Writer, args []string) *kernel.Error {
+	switch {
+	case len(args) == 0:
+		modeNames := [...]string{"off", "record", "replay"}
+		kfmt.Fprintf(w, "mode: %s, events: %d, overflow: %t\n", modeNames[engine.Mode()], len(engine.Events()), engine.Overflowed())
+	case len(args) == 1 && args[0] == "stop":
+		engine.Stop()
+	case len(args) == 1 && args[0] == "dump":
+		kfmt.Fprintf(w, dumpBegin)
+		enc := base64.NewEncoder(base64.StdEncoding, w)
+		_, _ = engine.WriteTo(enc)
+		enc.Close()
+		kfmt.Fprintf(w, dumpEnd)
+	default:
+		return errInvalidArgs
+	}
+
+	return nil
+}
+
+func init() {
+	_ = engine.SetInjector(EventInterrupt, injectInterrupt)
+	idle.RegisterPoller(pollEngine)
+
+	kshell.RegisterCommand(&kshell.Command{
+		Name:  "replay",
+		Usage: "[stop|dump]",
+		Help:  "show the event record/replay state, stop it or dump the recorded events",
+		Fn:    cmdReplay,
+	})
+}
@@ -0,0 +1,459 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/base64"
+	"gopheros/kernel"
+	"gopheros/kernel/cpu"
+	"gopheros/kernel/gate"
+	"gopheros/kernel/mm"
+	"gopheros/kernel/mm/vmm"
+	"gopheros/multiboot"
+	"reflect"
+	"strings"
+	"testing"
+	"unsafe"
+)
+
+func TestRecordAndReplay(t *testing.T) {
+	defer func() {
+		clockFn = cpu.ReadTSC
+	}()
+
+	var now uint64 = 100
+	clockFn = func() uint64 { return now }
+
+	var e Engine
+
+	// Recording while the engine is off is a no-op
+	e.Record(EventTimerTick, 0, 0)
+	if len(e.Events()) != 0 {
+		t.Fatal("expected Record to be a no-op when the engine is off")
+	}
+
+	e.StartRecording(3)
+	if e.Mode() != ModeRecord {
+		t.Fatalf("expected engine mode to be ModeRecord; got %d", e.Mode())
+	}
+
+	now = 110
+	e.Record(EventTimerTick, 0, 1)
+	now = 120
+	e.Record(EventInterrupt, 33, 0)
+	now = 125
+	e.Record(EventConsoleInput, 0, 'a')
+
+	if e.Overflowed() {
+		t.Fatal("expected log not to overflow")
+	}
+	e.Record(EventTimerTick, 0, 2)
+	if !e.Overflowed() {
+		t.Fatal("expected log to overflow")
+	}
+	e.Stop()
+
+	expEvents := []Event{
+		{Kind: EventTimerTick, Timestamp: 10, Payload: 1},
+		{Kind: EventInterrupt, Number: 33, Timestamp: 20},
+		{Kind: EventConsoleInput, Timestamp: 25, Payload: 'a'},
+	}
+
+	if got := e.Events(); !reflect.DeepEqual(got, expEvents) {
+		t.Fatalf("expected recorded events to be:\n%v\ngot:\n%v", expEvents, got)
+	}
+
+	var buf bytes.Buffer
+	if n, err := e.WriteTo(&buf); err != nil || n != int64(logHeaderSize+3*eventSize) {
+		t.Fatalf("unexpected WriteTo result: %d, %v", n, err)
+	}
+
+	var (
+		r        Engine
+		injected []Event
+	)
+
+	now = 1000
+	if err := r.StartReplay(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.SetInjector(numEventKinds, nil); err != errInvalidKind {
+		t.Fatalf("expected to get errInvalidKind; got %v", err)
+	}
+
+	inject := func(ev Event) { injected = append(injected, ev) }
+	r.SetInjector(EventTimerTick, inject)
+	r.SetInjector(EventInterrupt, inject)
+
+	now = 1015
+	if got := r.Poll(); got != 1 {
+		t.Fatalf("expected 1 injected event; got %d", got)
+	}
+
+	// The console input event has no injector and is skipped
+	now = 1030
+	if got := r.Poll(); got != 1 {
+		t.Fatalf("expected 1 injected event; got %d", got)
+	}
+
+	if r.Mode() != ModeOff {
+		t.Fatal("expected engine to switch to ModeOff after replaying all events")
+	}
+
+	if r.Poll() != 0 {
+		t.Fatal("expected Poll to be a no-op when the engine is off")
+	}
+
+	if !reflect.DeepEqual(injected, expEvents[:2]) {
+		t.Fatalf("expected injected events to be:\n%v\ngot:\n%v", expEvents[:2], injected)
+	}
+}
+
+func TestStartReplayErrors(t *testing.T) {
+	var (
+		e   Engine
+		buf bytes.Buffer
+	)
+	e.StartRecording(0)
+	e.Record(EventTimerTick, 0, 0)
+	e.WriteTo(&buf)
+	valid := buf.Bytes()
+
+	badVersion := append([]byte{}, valid...)
+	badVersion[4] = 0xff
+
+	badKind := append([]byte{}, valid...)
+	badKind[logHeaderSize] = uint8(numEventKinds)
+
+	specs := []struct {
+		data []byte
+		exp  error
+	}{
+		{nil, errBadLog},
+		{[]byte("not a log at all"), errBadLog},
+		{badVersion, errUnsupported},
+		{valid[:len(valid)-1], errBadLog},
+		{badKind, errBadLog},
+	}
+
+	for specIndex, spec := range specs {
+		if err := e.StartReplay(spec.data); err != spec.exp {
+			t.Errorf("[spec %d] expected error %v; got %v", specIndex, spec.exp, err)
+		}
+	}
+}
+
+func TestHandleInterrupt(t *testing.T) {
+	defer func() {
+		engine = Engine{}
+		handleInterruptFn = gate.HandleInterrupt
+		recordedHandlers[40] = nil
+	}()
+
+	var (
+		called          bool
+		installedNumber gate.InterruptNumber
+		installed       func(*gate.Registers)
+	)
+
+	handleInterruptFn = func(intNumber gate.InterruptNumber, _ uint8, handler func(*gate.Registers)) {
+		installedNumber = intNumber
+		installed = handler
+	}
+
+	HandleInterrupt(gate.InterruptNumber(40), 0, func(_ *gate.Registers) { called = true })
+	if installedNumber != 40 || installed == nil {
+		t.Fatal("expected HandleInterrupt to install the dispatcher")
+	}
+
+	Global().StartRecording(1)
+	installed(&gate.Registers{Info: 40})
+
+	if !called {
+		t.Fatal("expected registered handler to be invoked")
+	}
+
+	if evs := Global().Events(); len(evs) != 1 || evs[0].Kind != EventInterrupt || evs[0].Number != 40 {
+		t.Fatalf("unexpected recorded events: %v", evs)
+	}
+
+	// Interrupts without a registered handler are still recorded
+	installed(&gate.Registers{Info: 41})
+	if !Global().Overflowed() {
+		t.Fatal("expected unhandled interrupt to be recorded")
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer func() {
+		engine = Engine{}
+		getBootCmdLineFn = multiboot.GetBootCmdLine
+	}()
+
+	getBootCmdLineFn = func() map[string]string { return map[string]string{"replay": "record"} }
+	Init()
+
+	if Global().Mode() != ModeRecord {
+		t.Fatal("expected Init to start recording")
+	}
+}
+
+func TestTick(t *testing.T) {
+	defer func() {
+		engine = Engine{}
+		clockFn = cpu.ReadTSC
+	}()
+
+	var now uint64
+	clockFn = func() uint64 { return now }
+
+	// Ticks are ignored while the engine is off
+	Tick(1)
+	if len(engine.Events()) != 0 {
+		t.Fatal("expected Tick to be a no-op while the engine is off")
+	}
+
+	engine.StartRecording(4)
+	now = 10
+	Tick(42)
+
+	evs := engine.Events()
+	if len(evs) != 1 || evs[0].Kind != EventTimerTick || evs[0].Payload != 42 || evs[0].Timestamp != 10 {
+		t.Fatalf("unexpected recorded events: %v", evs)
+	}
+
+	if !Tick(43) {
+		t.Fatal("expected Tick to return true while recording")
+	}
+
+	var buf bytes.Buffer
+	engine.WriteTo(&buf)
+
+	var injected []Event
+	engine.SetInjector(EventTimerTick, func(ev Event) { injected = append(injected, ev) })
+	if err := engine.StartReplay(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Ticks are delivered by the injector while replaying
+	if Tick(0) {
+		t.Fatal("expected Tick to return false while replaying")
+	}
+
+	now = 20
+	engine.Poll()
+	if len(injected) != 2 || injected[0].Payload != 42 || injected[1].Payload != 43 {
+		t.Fatalf("expected recorded ticks to be injected; got %v", injected)
+	}
+}
+
+func TestRecordDoesNotWrap(t *testing.T) {
+	var e Engine
+	e.StartRecording(2)
+
+	for i := 0; i < 10; i++ {
+		e.Record(EventTimerTick, 0, uint64(i))
+	}
+
+	if exp, got := uint32(2), e.logIndex; got != exp {
+		t.Fatalf("expected log index to stop at %d; got %d", exp, got)
+	}
+
+	if evs := e.Events(); len(evs) != 2 || evs[0].Payload != 0 || evs[1].Payload != 1 {
+		t.Fatalf("expected earlier events to be retained; got %v", evs)
+	}
+
+	if !e.Overflowed() {
+		t.Fatal("expected log to overflow")
+	}
+}
+
+func TestReplayInterrupts(t *testing.T) {
+	defer func() {
+		engine = Engine{}
+		clockFn = cpu.ReadTSC
+		handleInterruptFn = gate.HandleInterrupt
+		recordedHandlers[40] = nil
+	}()
+
+	var now uint64
+	clockFn = func() uint64 { return now }
+
+	var installed func(*gate.Registers)
+	handleInterruptFn = func(_ gate.InterruptNumber, _ uint8, handler func(*gate.Registers)) {
+		installed = handler
+	}
+
+	var (
+		calls   int
+		lastReg uint64
+	)
+	HandleInterrupt(gate.InterruptNumber(40), 0, func(regs *gate.Registers) {
+		calls++
+		lastReg = regs.Info
+
+		// Interrupts that arrive while events are being injected must
+		// not re-enter Poll
+		installed(&gate.Registers{Info: 40})
+	})
+
+	engine.StartRecording(0)
+	now = 10
+	engine.Record(EventInterrupt, 40, 0)
+	now = 20
+	engine.Record(EventInterrupt, 40, 0)
+
+	var buf bytes.Buffer
+	engine.WriteTo(&buf)
+
+	engine = Engine{}
+	engine.SetInjector(EventInterrupt, injectInterrupt)
+	now = 100
+	if err := engine.StartReplay(buf.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+
+	// HW interrupts are not forwarded to the handler while replaying but
+	// drive the engine instead
+	now = 105
+	installed(&gate.Registers{Info: 40})
+	if calls != 0 {
+		t.Fatalf("expected HW interrupt not to be forwarded while replaying; got %d calls", calls)
+	}
+
+	now = 110
+	installed(&gate.Registers{Info: 40})
+	if calls != 1 || lastReg != 40 {
+		t.Fatalf("expected the replayed interrupt to be injected once; got %d calls (info %d)", calls, lastReg)
+	}
+
+	now = 120
+	installed(&gate.Registers{Info: 40})
+	if calls != 2 || engine.Mode() != ModeOff {
+		t.Fatalf("expected all replayed interrupts to be injected; got %d calls", calls)
+	}
+}
+
+func TestInitReplay(t *testing.T) {
+	defer func() {
+		engine = Engine{}
+		clockFn = cpu.ReadTSC
+		getBootCmdLineFn = multiboot.GetBootCmdLine
+		visitModulesFn = multiboot.VisitModules
+		mapFramesFn = vmm.MapFrames
+		freeRegionFn = vmm.FreeRegion
+	}()
+
+	clockFn = func() uint64 { return 0 }
+	getBootCmdLineFn = func() map[string]string { return map[string]string{"replay": "replay"} }
+
+	var rec Engine
+	rec.StartRecording(2)
+	rec.Record(EventInterrupt, 33, 0)
+	var logBuf bytes.Buffer
+	rec.WriteTo(&logBuf)
+
+	// Place the log at a non page-aligned offset to check that the
+	// module is mapped correctly
+	buf := make([]byte, 2*int(mm.PageSize)+logBuf.Len())
+	pageAddr := (uintptr(unsafe.Pointer(&buf[0])) + mm.PageSize - 1) &^ (mm.PageSize - 1)
+	modStart := pageAddr + 16
+	copy(buf[modStart-uintptr(unsafe.Pointer(&buf[0])):], logBuf.Bytes())
+	modEnd := modStart + uintptr(logBuf.Len())
+
+	var freed bool
+	mapFramesFn = func(frame mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+		return mm.Page(frame), nil
+	}
+	freeRegionFn = func(mm.Page) *kernel.Error {
+		freed = true
+		return nil
+	}
+
+	specs := []struct {
+		modCmdLine string
+		mapErr     *kernel.Error
+		expMode    Mode
+	}{
+		{"/boot/events.log replay_log", nil, ModeReplay},
+		{"/boot/initrd", nil, ModeOff},
+		{"replay_log", &kernel.Error{Module: "test", Message: "map failed"}, ModeOff},
+	}
+
+	for specIndex, spec := range specs {
+		engine = Engine{}
+		freed = false
+		visitModulesFn = func(visitor multiboot.ModuleVisitor) {
+			visitor(spec.modCmdLine, modStart, modEnd)
+		}
+		if spec.mapErr != nil {
+			mapFramesFn = func(_ mm.Frame, _ uintptr, _ vmm.PageTableEntryFlag) (mm.Page, *kernel.Error) {
+				return 0, spec.mapErr
+			}
+		}
+
+		Init()
+
+		if got := engine.Mode(); got != spec.expMode {
+			t.Errorf("[spec %d] expected engine mode %d; got %d", specIndex, spec.expMode, got)
+			continue
+		}
+
+		if spec.expMode == ModeReplay {
+			if evs := engine.Events(); len(evs) != 1 || evs[0].Number != 33 {
+				t.Errorf("[spec %d] expected the module log to be loaded; got %v", specIndex, evs)
+			}
+
+			if !freed {
+				t.Errorf("[spec %d] expected the module mapping to be released", specIndex)
+			}
+		}
+	}
+}
+
+func TestCmdReplay(t *testing.T) {
+	defer func() {
+		engine = Engine{}
+		clockFn = cpu.ReadTSC
+	}()
+
+	clockFn = func() uint64 { return 0 }
+	engine.StartRecording(2)
+	engine.Record(EventConsoleInput, 0, 'a')
+
+	var buf bytes.Buffer
+	if err := cmdReplay(&buf, nil); err != nil {
+		t.Fatal(err)
+	}
+	if exp := "mode: record, events: 1, overflow: false\n"; buf.String() != exp {
+		t.Fatalf("expected output %q; got %q", exp, buf.String())
+	}
+
+	buf.Reset()
+	if err := cmdReplay(&buf, []string{"dump"}); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.HasPrefix(out, dumpBegin) || !strings.HasSuffix(out, dumpEnd) {
+		t.Fatalf("expected dump to be delimited by the replay log markers; got %q", out)
+	}
+
+	data, err := base64.StdEncoding.DecodeString(strings.TrimSuffix(strings.TrimPrefix(out, dumpBegin), dumpEnd))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var e Engine
+	if err := e.StartReplay(data); err != nil {
+		t.Fatalf("expected dumped log to be accepted by StartReplay; got %v", err)
+	}
+
+	if err := cmdReplay(&buf, []string{"stop"}); err != nil || engine.Mode() != ModeOff {
+		t.Fatalf("expected stop to disable the engine; got mode %d, err %v", engine.Mode(), err)
+	}
+
+	if err := cmdReplay(&buf, []string{"bogus"}); err != errInvalidArgs {
+		t.Fatalf("expected error: %v; got %v", errInvalidArgs, err)
+	}
+}
@@ -0,0 +1,84 @@
+// Package selftest provides a framework for running kernel self-tests at boot.
+// Subsystems register their self-tests via Register and the tests are executed
+// by Init if the kernel was booted with the "selftest=on" command line option.
+//
+// The results are emitted using a machine-parsable line-based format so that
+// CI jobs can capture the kernel output (e.g. via a serial port) and check
+// whether all tests passed:
+//
+//   [selftest] BEGIN count=<number of tests>
+//   [selftest] PASS <test name>
+//   [selftest] FAIL <test name>: <error message>
+//   [selftest] END total=<n> passed=<n> failed=<n>
+package selftest
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"gopheros/multiboot"
+	"io"
+)
+
+// TestFn is a function that implements a self-test. It returns a non-nil
+// error if the test fails.
+type TestFn func() *kernel.Error
+
+// Test describes a registered self-test.
+type Test struct {
+	// Name uniquely identifies the test. By convention, test names are
+	// prefixed by the name of the subsystem that registers them (e.g.
+	// "pmm/alloc-free").
+	Name string
+
+	// Fn implements the test.
+	Fn TestFn
+}
+
+var (
+	// registeredTests tracks the tests registered via Register in
+	// registration order.
+	registeredTests []*Test
+
+	getBootCmdLineFn = multiboot.GetBootCmdLine
+)
+
+// Register adds a self-test to the list of tests executed by Run.
+func Register(name string, fn TestFn) {
+	registeredTests = append(registeredTests, &Test{Name: name, Fn: fn})
+}
+
+// Tests returns the list of registered self-tests.
+func Tests() []*Test {
+	return registeredTests
+}
+
+// Run executes all registered self-tests writing the results to w and returns
+// the number of passed and failed tests.
+func Run(w io.Writer) (passed, failed int) {
+	kfmt.Fprintf(w, "[selftest] BEGIN count=%d\n", len(registeredTests))
+	for _, test := range registeredTests {
+		if err := test.Fn(); err != nil {
+			kfmt.Fprintf(w, "[selftest] FAIL %s: %s\n", test.Name, err.Error())
+			failed++
+			continue
+		}
+
+		kfmt.Fprintf(w, "[selftest] PASS %s\n", test.Name)
+		passed++
+	}
+	kfmt.Fprintf(w, "[selftest] END total=%d passed=%d failed=%d\n", passed+failed, passed, failed)
+
+	return passed, failed
+}
+
+// Init runs all registered self-tests if the kernel was booted with the
+// "selftest=on" command line option. Test results are written to the active
+// kfmt output sink.
+func Init() {
+	for k, v := range getBootCmdLineFn() {
+		if k == "selftest" && v == "on" {
+			Run(kfmt.GetOutputSink())
+			return
+		}
+	}
+}
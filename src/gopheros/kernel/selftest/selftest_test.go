@@ -0,0 +1,80 @@
+package selftest
+
+import (
+	"bytes"
+	"gopheros/kernel"
+	"gopheros/kernel/kfmt"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	defer func(origTests []*Test) {
+		registeredTests = origTests
+	}(registeredTests)
+
+	errTest := &kernel.Error{Module: "test", Message: "something went wrong"}
+
+	registeredTests = nil
+	Register("test/pass", func() *kernel.Error { return nil })
+	Register("test/fail", func() *kernel.Error { return errTest })
+	Register("test/pass2", func() *kernel.Error { return nil })
+
+	if got := len(Tests()); got != 3 {
+		t.Fatalf("expected 3 registered tests; got %d", got)
+	}
+
+	var buf bytes.Buffer
+	passed, failed := Run(&buf)
+	if passed != 2 || failed != 1 {
+		t.Fatalf("expected Run to return (2, 1); got (%d, %d)", passed, failed)
+	}
+
+	exp := "[selftest] BEGIN count=3\n" +
+		"[selftest] PASS test/pass\n" +
+		"[selftest] FAIL test/fail: something went wrong\n" +
+		"[selftest] PASS test/pass2\n" +
+		"[selftest] END total=3 passed=2 failed=1\n"
+
+	if got := buf.String(); got != exp {
+		t.Fatalf("expected output:\n%s\ngot:\n%s", exp, got)
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer func(origTests []*Test, origCmdLineFn func() map[string]string) {
+		registeredTests = origTests
+		getBootCmdLineFn = origCmdLineFn
+		kfmt.SetOutputSink(nil)
+	}(registeredTests, getBootCmdLineFn)
+
+	var (
+		buf      bytes.Buffer
+		runCount int
+	)
+	kfmt.SetOutputSink(&buf)
+
+	registeredTests = nil
+	Register("test/count", func() *kernel.Error {
+		runCount++
+		return nil
+	})
+
+	specs := []struct {
+		cmdLine map[string]string
+		expRuns int
+	}{
+		{nil, 0},
+		{map[string]string{"selftest": "off"}, 0},
+		{map[string]string{"selftest": "on"}, 1},
+	}
+
+	for specIndex, spec := range specs {
+		runCount = 0
+		getBootCmdLineFn = func() map[string]string { return spec.cmdLine }
+
+		Init()
+		if runCount != spec.expRuns {
+			t.Errorf("[spec %d] expected test to run %d time(s); got %d", specIndex, spec.expRuns, runCount)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package sync
+
+import (
+	"gopheros/kernel/cpu"
+	"runtime"
+)
+
+var (
+	// timestampFn returns a monotonically increasing timestamp that is
+	// used for measuring lock hold times.
+	timestampFn = cpu.ReadTSC
+)
+
+// LockStats contains the statistics collected by a DebugLock. All times are
+// measured in CPU timestamp counter ticks.
+type LockStats struct {
+	// The number of times the lock was acquired.
+	Acquisitions uint64
+
+	// The number of acquisitions where the lock was already held by
+	// another task.
+	Contentions uint64
+
+	// The total and maximum time that the lock was held.
+	TotalHoldTime uint64
+	MaxHoldTime   uint64
+}
+
+// DebugLock wraps a Locker and records the lock owner and hold times. It is
+// meant to be used while debugging lock contention or deadlock issues. A
+// DebugLock only works with locks that also provide a TryToAcquire method;
+// for any other lock type contention is not tracked.
+type DebugLock struct {
+	// Name is a descriptive name for the lock.
+	Name string
+
+	lock Locker
+
+	ownerPC    uintptr
+	acquiredAt uint64
+	stats      LockStats
+}
+
+// NewDebugLock returns a DebugLock that wraps the supplied lock.
+func NewDebugLock(name string, lock Locker) *DebugLock {
+	return &DebugLock{
+		Name: name,
+		lock: lock,
+	}
+}
+
+// Acquire acquires the wrapped lock and records the call site of the caller
+// as the lock owner.
+func (l *DebugLock) Acquire() {
+	var pc [1]uintptr
+
+	contended := false
+	if tryLock, ok := l.lock.(interface{ TryToAcquire() bool }); ok {
+		if contended = !tryLock.TryToAcquire(); contended {
+			l.lock.Acquire()
+		}
+	} else {
+		l.lock.Acquire()
+	}
+
+	if runtime.Callers(2, pc[:]) == 1 {
+		l.ownerPC = pc[0]
+	}
+	l.acquiredAt = timestampFn()
+	l.stats.Acquisitions++
+	if contended {
+		l.stats.Contentions++
+	}
+}
+
+// Release updates the hold time statistics and releases the wrapped lock.
+func (l *DebugLock) Release() {
+	holdTime := timestampFn() - l.acquiredAt
+	l.stats.TotalHoldTime += holdTime
+	if holdTime > l.stats.MaxHoldTime {
+		l.stats.MaxHoldTime = holdTime
+	}
+	l.ownerPC = 0
+
+	l.lock.Release()
+}
+
+// Owner returns the program counter for the call site that currently holds
+// the lock or 0 if the lock is not held.
+func (l *DebugLock) Owner() uintptr {
+	return l.ownerPC
+}
+
+// Stats returns the collected lock statistics.
+func (l *DebugLock) Stats() LockStats {
+	return l.stats
+}
@@ -0,0 +1,76 @@
+package sync
+
+import (
+	"gopheros/kernel/cpu"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDebugLock(t *testing.T) {
+	defer func() {
+		timestampFn = cpu.ReadTSC
+	}()
+
+	var now uint64
+	timestampFn = func() uint64 { return now }
+
+	var sl Spinlock
+	l := NewDebugLock("test", &sl)
+
+	if l.Owner() != 0 {
+		t.Fatal("expected lock owner to be 0 when lock is not held")
+	}
+
+	now = 10
+	l.Acquire()
+	frame, _ := runtime.CallersFrames([]uintptr{l.Owner()}).Next()
+	if !strings.HasSuffix(frame.Function, "TestDebugLock") {
+		t.Fatalf("expected lock owner to point to the test function; got %q", frame.Function)
+	}
+	now = 15
+	l.Release()
+
+	if l.Owner() != 0 {
+		t.Fatal("expected lock owner to be reset after the lock is released")
+	}
+
+	// Simulate a contended lock
+	l.lock = &contendedLock{}
+	now = 20
+	l.Acquire()
+	now = 50
+	l.Release()
+
+	exp := LockStats{
+		Acquisitions:  2,
+		Contentions:   1,
+		TotalHoldTime: 35,
+		MaxHoldTime:   30,
+	}
+
+	if got := l.Stats(); got != exp {
+		t.Fatalf("expected lock stats to be %+v; got %+v", exp, got)
+	}
+
+	// Locks without TryToAcquire support do not track contention
+	l = NewDebugLock("locker", &lockerOnly{})
+	l.Acquire()
+	l.Release()
+	if got := l.Stats(); got.Acquisitions != 1 || got.Contentions != 0 {
+		t.Fatalf("unexpected lock stats: %+v", got)
+	}
+}
+
+type contendedLock struct {
+	lockerOnly
+}
+
+func (l *contendedLock) TryToAcquire() bool { return false }
+
+type lockerOnly struct {
+	held bool
+}
+
+func (l *lockerOnly) Acquire() { l.held = true }
+func (l *lockerOnly) Release() { l.held = false }
@@ -0,0 +1,47 @@
+package sync
+
+import "sync/atomic"
+
+// The list of states for a Mutex.
+const (
+	mutexUnlocked uint32 = iota
+	mutexLocked
+	mutexLockedWithWaiters
+)
+
+// Mutex implements a sleeping lock. Unlike the Spinlock, tasks that fail to
+// acquire a Mutex are put to sleep by the scheduler until the lock is
+// released. Until a scheduler registers its hooks via SetSchedulerHooks, the
+// Mutex behaves like a spinlock.
+type Mutex struct {
+	state uint32
+}
+
+// Acquire blocks until the lock can be acquired by the currently active task.
+// Any attempt to re-acquire a lock already held by the current task will cause
+// a deadlock.
+func (m *Mutex) Acquire() {
+	if atomic.CompareAndSwapUint32(&m.state, mutexUnlocked, mutexLocked) {
+		return
+	}
+
+	// Flag the mutex as having waiters so that the task that releases it
+	// knows that it needs to wake us up.
+	for atomic.SwapUint32(&m.state, mutexLockedWithWaiters) != mutexUnlocked {
+		wait(&m.state, mutexLockedWithWaiters)
+	}
+}
+
+// TryToAcquire attempts to acquire the lock and returns true if the lock could
+// be acquired or false otherwise.
+func (m *Mutex) TryToAcquire() bool {
+	return atomic.CompareAndSwapUint32(&m.state, mutexUnlocked, mutexLocked)
+}
+
+// Release relinquishes a held lock waking up any tasks waiting to acquire it.
+// Calling Release while the lock is free has no effect.
+func (m *Mutex) Release() {
+	if atomic.SwapUint32(&m.state, mutexUnlocked) == mutexLockedWithWaiters {
+		wake(&m.state)
+	}
+}
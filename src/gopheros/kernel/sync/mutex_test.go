@@ -0,0 +1,86 @@
+package sync
+
+import (
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestMutex(t *testing.T) {
+	defer SetSchedulerHooks(yieldFn, parkFn, unparkFn)
+
+	var parkCount, unparkCount uint32
+	SetSchedulerHooks(
+		runtime.Gosched,
+		func(addr *uint32, val uint32) {
+			atomic.AddUint32(&parkCount, 1)
+			for atomic.LoadUint32(addr) == val {
+				runtime.Gosched()
+			}
+		},
+		func(_ *uint32) {
+			atomic.AddUint32(&unparkCount, 1)
+		},
+	)
+
+	var (
+		m          Mutex
+		wg         sync.WaitGroup
+		numWorkers = 10
+	)
+
+	m.Acquire()
+
+	if m.TryToAcquire() {
+		t.Error("expected TryToAcquire to return false when lock is held")
+	}
+
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			m.Acquire()
+			m.Release()
+			wg.Done()
+		}()
+	}
+
+	<-time.After(50 * time.Millisecond)
+	m.Release()
+	wg.Wait()
+
+	if atomic.LoadUint32(&parkCount) == 0 || atomic.LoadUint32(&unparkCount) == 0 {
+		t.Fatal("expected contended mutex to park and unpark waiting tasks")
+	}
+
+	if !m.TryToAcquire() {
+		t.Fatal("expected TryToAcquire to return true when lock is free")
+	}
+	m.Release()
+}
+
+func TestMutexWithoutSchedulerHooks(t *testing.T) {
+	defer SetSchedulerHooks(yieldFn, parkFn, unparkFn)
+	SetSchedulerHooks(runtime.Gosched, nil, nil)
+
+	var (
+		m          Mutex
+		wg         sync.WaitGroup
+		numWorkers = 10
+	)
+
+	m.Acquire()
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			m.Acquire()
+			m.Release()
+			wg.Done()
+		}()
+	}
+
+	<-time.After(10 * time.Millisecond)
+	m.Release()
+	wg.Wait()
+}
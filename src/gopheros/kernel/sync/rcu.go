@@ -0,0 +1,165 @@
+package sync
+
+import "sync/atomic"
+
+// MaxRCUCPUs defines the maximum number of CPUs supported by the RCU
+// implementation.
+const MaxRCUCPUs = 64
+
+// rcuCPUState tracks the RCU state of a single CPU.
+type rcuCPUState struct {
+	// The read-side critical section nesting level.
+	nesting uint32
+
+	// needQS is set when a grace period starts and cleared once the CPU
+	// reports a quiescent state.
+	needQS bool
+}
+
+// rcuState holds the global RCU state. Callbacks registered via CallRCU are
+// queued to nextCallbacks. When a grace period starts, the queued callbacks
+// are moved to curCallbacks and are invoked once every CPU has passed through
+// a quiescent state.
+var rcuState struct {
+	lock Spinlock
+
+	cpus    [MaxRCUCPUs]rcuCPUState
+	numCPUs uint32
+
+	gpActive  bool
+	pendingQS uint32
+
+	// completedGPs counts the number of completed grace periods.
+	completedGPs uint64
+
+	curCallbacks  []func()
+	nextCallbacks []func()
+}
+
+// rcuCPUIDFn returns the index of the CPU executing the caller. If nil, the
+// system is assumed to be uniprocessor.
+var rcuCPUIDFn func() uint32
+
+func init() {
+	rcuState.numCPUs = 1
+}
+
+// SetRCUCPUs configures the number of online CPUs that must report a
+// quiescent state before a grace period can complete and the function for
+// obtaining the index of the current CPU. It must be called before any
+// grace periods are started.
+func SetRCUCPUs(numCPUs uint32, cpuID func() uint32) {
+	if numCPUs == 0 || numCPUs > MaxRCUCPUs {
+		return
+	}
+
+	rcuState.numCPUs = numCPUs
+	rcuCPUIDFn = cpuID
+}
+
+func rcuCurrentCPU() *rcuCPUState {
+	if rcuCPUIDFn == nil {
+		return &rcuState.cpus[0]
+	}
+
+	return &rcuState.cpus[rcuCPUIDFn()]
+}
+
+// RCUReadLock marks the beginning of an RCU read-side critical section.
+// Read-side critical sections may be nested and must not block; the caller
+// must not be preempted (or migrated to another CPU) until the matching call
+// to RCUReadUnlock.
+func RCUReadLock() {
+	rcuCurrentCPU().nesting++
+}
+
+// RCUReadUnlock marks the end of an RCU read-side critical section.
+func RCUReadUnlock() {
+	rcuCurrentCPU().nesting--
+}
+
+// RCUQuiescentState reports that the current CPU has passed through a
+// quiescent state; that is, it does not hold any references to RCU-protected
+// data. The scheduler is expected to invoke it on each context switch and
+// while the CPU is idle. Calls made from within a read-side critical section
+// are ignored.
+//
+// If the report completes the current grace period, the callbacks waiting for
+// it are invoked by the caller.
+func RCUQuiescentState() {
+	cpu := rcuCurrentCPU()
+	if cpu.nesting != 0 {
+		return
+	}
+
+	var completed []func()
+
+	rcuState.lock.Acquire()
+	if rcuState.gpActive && cpu.needQS {
+		cpu.needQS = false
+		if rcuState.pendingQS--; rcuState.pendingQS == 0 {
+			completed = rcuState.curCallbacks
+			rcuState.curCallbacks = nil
+			rcuState.gpActive = false
+			atomic.AddUint64(&rcuState.completedGPs, 1)
+
+			if len(rcuState.nextCallbacks) != 0 {
+				rcuStartGracePeriod()
+			}
+		}
+	}
+	rcuState.lock.Release()
+
+	for _, fn := range completed {
+		fn()
+	}
+}
+
+// rcuStartGracePeriod starts a new grace period for all queued callbacks. It
+// must be called while holding rcuState.lock.
+func rcuStartGracePeriod() {
+	rcuState.curCallbacks = rcuState.nextCallbacks
+	rcuState.nextCallbacks = nil
+	rcuState.gpActive = true
+	rcuState.pendingQS = rcuState.numCPUs
+	for i := uint32(0); i < rcuState.numCPUs; i++ {
+		rcuState.cpus[i].needQS = true
+	}
+}
+
+// CallRCU queues fn to be invoked after a full grace period has elapsed, i.e.
+// after all read-side critical sections that were active when CallRCU was
+// invoked have completed. It is typically used for deferring the release of
+// objects that were unlinked from an RCU-protected structure.
+func CallRCU(fn func()) {
+	rcuState.lock.Acquire()
+	rcuState.nextCallbacks = append(rcuState.nextCallbacks, fn)
+	if !rcuState.gpActive {
+		rcuStartGracePeriod()
+	}
+	rcuState.lock.Release()
+}
+
+// SynchronizeRCU blocks until a full grace period has elapsed. It must not be
+// called from within a read-side critical section.
+func SynchronizeRCU() {
+	var done uint32
+	CallRCU(func() { atomic.StoreUint32(&done, 1) })
+
+	for {
+		RCUQuiescentState()
+		if atomic.LoadUint32(&done) != 0 {
+			return
+		}
+
+		if yieldFn != nil {
+			yieldFn()
+		}
+	}
+}
+
+// RCUCompletedGracePeriods returns the number of grace periods that have
+// completed so far.
+func RCUCompletedGracePeriods() uint64 {
+	return atomic.LoadUint64(&rcuState.completedGPs)
+}
@@ -0,0 +1,137 @@
+package sync
+
+import "testing"
+
+func resetRCUState() func() {
+	origCPUIDFn := rcuCPUIDFn
+	reset := func() {
+		rcuState.cpus = [MaxRCUCPUs]rcuCPUState{}
+		rcuState.numCPUs = 1
+		rcuState.gpActive = false
+		rcuState.pendingQS = 0
+		rcuState.completedGPs = 0
+		rcuState.curCallbacks = nil
+		rcuState.nextCallbacks = nil
+		rcuCPUIDFn = nil
+	}
+
+	reset()
+	return func() {
+		reset()
+		rcuCPUIDFn = origCPUIDFn
+	}
+}
+
+func TestRCUUniprocessor(t *testing.T) {
+	defer resetRCUState()()
+
+	var calls int
+	cb := func() { calls++ }
+
+	RCUReadLock()
+	RCUReadLock()
+	CallRCU(cb)
+
+	// Quiescent states reported inside a read-side section are ignored
+	RCUQuiescentState()
+	RCUReadUnlock()
+	RCUQuiescentState()
+	if calls != 0 {
+		t.Fatal("expected callback not to run while a read-side critical section is active")
+	}
+
+	RCUReadUnlock()
+	RCUQuiescentState()
+	if calls != 1 {
+		t.Fatalf("expected callback to run once after the grace period; got %d", calls)
+	}
+
+	if got := RCUCompletedGracePeriods(); got != 1 {
+		t.Fatalf("expected 1 completed grace period; got %d", got)
+	}
+
+	// No grace period should be active
+	RCUQuiescentState()
+	if calls != 1 || RCUCompletedGracePeriods() != 1 {
+		t.Fatal("expected quiescent state without pending callbacks to be a no-op")
+	}
+}
+
+func TestRCUMultiprocessor(t *testing.T) {
+	defer resetRCUState()()
+
+	var (
+		curCPU uint32
+		order  []int
+	)
+
+	SetRCUCPUs(2, func() uint32 { return curCPU })
+
+	// CPU 1 enters a read-side section before the callback is queued
+	curCPU = 1
+	RCUReadLock()
+
+	curCPU = 0
+	CallRCU(func() { order = append(order, 1) })
+
+	// This callback is queued while a grace period is in progress and
+	// needs to wait for the next one
+	CallRCU(func() { order = append(order, 2) })
+
+	RCUQuiescentState()
+	if len(order) != 0 {
+		t.Fatal("expected callbacks to wait for CPU 1")
+	}
+
+	curCPU = 1
+	RCUQuiescentState()
+	if len(order) != 0 {
+		t.Fatal("expected callbacks to wait while CPU 1 is inside a read-side section")
+	}
+
+	RCUReadUnlock()
+	RCUQuiescentState()
+	if len(order) != 1 || order[0] != 1 {
+		t.Fatalf("expected only the first callback to run; got %v", order)
+	}
+
+	// Complete the second grace period
+	RCUQuiescentState()
+	curCPU = 0
+	RCUQuiescentState()
+	if len(order) != 2 || order[1] != 2 {
+		t.Fatalf("expected the second callback to run after the next grace period; got %v", order)
+	}
+
+	if got := RCUCompletedGracePeriods(); got != 2 {
+		t.Fatalf("expected 2 completed grace periods; got %d", got)
+	}
+}
+
+func TestSetRCUCPUs(t *testing.T) {
+	defer resetRCUState()()
+
+	for _, numCPUs := range []uint32{0, MaxRCUCPUs + 1} {
+		SetRCUCPUs(numCPUs, nil)
+		if rcuState.numCPUs != 1 {
+			t.Fatalf("expected invalid CPU count %d to be ignored", numCPUs)
+		}
+	}
+}
+
+func TestSynchronizeRCU(t *testing.T) {
+	defer resetRCUState()()
+	defer func(origYieldFn func()) { yieldFn = origYieldFn }(yieldFn)
+
+	var yieldCount int
+	yieldFn = func() { yieldCount++ }
+
+	SynchronizeRCU()
+	if got := RCUCompletedGracePeriods(); got != 1 {
+		t.Fatalf("expected SynchronizeRCU to wait for a grace period; got %d completed", got)
+	}
+
+	if yieldCount != 0 {
+		t.Fatalf("expected SynchronizeRCU to complete without yielding on a uniprocessor system; got %d yields", yieldCount)
+	}
+}
@@ -0,0 +1,95 @@
+package sync
+
+import "sync/atomic"
+
+const (
+	// rwWriterHeld is set when a writer holds the lock.
+	rwWriterHeld uint32 = 1 << 31
+
+	// rwWriterPending is set when a writer is waiting for the active
+	// readers to release the lock. While this bit is set, new readers
+	// are not allowed to acquire the lock so that writers do not starve.
+	rwWriterPending uint32 = 1 << 30
+
+	// rwReaderMask masks the bits that hold the active reader count.
+	rwReaderMask = rwWriterPending - 1
+)
+
+// RWSpinlock implements a reader-writer lock where each task trying to
+// acquire it busy-waits till the lock becomes available. The lock can be held
+// by any number of readers or by a single writer. Writers take precedence
+// over new readers so that a steady stream of readers cannot starve them.
+type RWSpinlock struct {
+	state uint32
+}
+
+// RAcquire blocks until the lock can be acquired for reading.
+func (l *RWSpinlock) RAcquire() {
+	for !l.TryToRAcquire() {
+		wait(&l.state, atomic.LoadUint32(&l.state))
+	}
+}
+
+// TryToRAcquire attempts to acquire the lock for reading and returns true if
+// the lock could be acquired or false otherwise.
+func (l *RWSpinlock) TryToRAcquire() bool {
+	state := atomic.LoadUint32(&l.state)
+	if state&(rwWriterHeld|rwWriterPending) != 0 {
+		return false
+	}
+
+	return atomic.CompareAndSwapUint32(&l.state, state, state+1)
+}
+
+// RRelease relinquishes a lock held for reading.
+func (l *RWSpinlock) RRelease() {
+	if atomic.AddUint32(&l.state, ^uint32(0))&rwReaderMask == 0 {
+		wake(&l.state)
+	}
+}
+
+// Acquire blocks until the lock can be acquired for writing.
+func (l *RWSpinlock) Acquire() {
+	for {
+		state := atomic.LoadUint32(&l.state)
+		switch {
+		case state&^rwWriterPending == 0:
+			if atomic.CompareAndSwapUint32(&l.state, state, rwWriterHeld) {
+				return
+			}
+			continue
+		case state&rwWriterPending == 0:
+			// Block new readers while we wait for the active ones
+			// to release the lock.
+			if !atomic.CompareAndSwapUint32(&l.state, state, state|rwWriterPending) {
+				continue
+			}
+			state |= rwWriterPending
+		}
+
+		wait(&l.state, state)
+	}
+}
+
+// TryToAcquire attempts to acquire the lock for writing and returns true if
+// the lock could be acquired or false otherwise.
+func (l *RWSpinlock) TryToAcquire() bool {
+	state := atomic.LoadUint32(&l.state)
+	return state&^rwWriterPending == 0 && atomic.CompareAndSwapUint32(&l.state, state, rwWriterHeld)
+}
+
+// Release relinquishes a lock held for writing. Calling Release while the lock
+// is not held by a writer has no effect.
+func (l *RWSpinlock) Release() {
+	for {
+		state := atomic.LoadUint32(&l.state)
+		if state&rwWriterHeld == 0 {
+			return
+		}
+
+		if atomic.CompareAndSwapUint32(&l.state, state, state&^rwWriterHeld) {
+			wake(&l.state)
+			return
+		}
+	}
+}
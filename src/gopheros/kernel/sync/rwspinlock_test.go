@@ -0,0 +1,87 @@
+package sync
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRWSpinlock(t *testing.T) {
+	// Substitute the yieldFn with runtime.Gosched to avoid deadlocks while testing
+	defer func(origYieldFn func()) { yieldFn = origYieldFn }(yieldFn)
+	yieldFn = runtime.Gosched
+
+	var (
+		l          RWSpinlock
+		wg         sync.WaitGroup
+		numWorkers = 10
+		counter    int
+	)
+
+	l.RAcquire()
+	if !l.TryToRAcquire() {
+		t.Fatal("expected TryToRAcquire to return true while the lock is held by readers")
+	}
+
+	if l.TryToAcquire() {
+		t.Fatal("expected TryToAcquire to return false while the lock is held by readers")
+	}
+
+	// Start a writer; once it flags itself as pending, new readers should
+	// not be able to acquire the lock.
+	writerDone := make(chan struct{})
+	go func() {
+		l.Acquire()
+		counter++
+		l.Release()
+		close(writerDone)
+	}()
+
+	for l.TryToRAcquire() {
+		l.RRelease()
+		runtime.Gosched()
+	}
+
+	l.RRelease()
+	l.RRelease()
+	<-writerDone
+
+	if counter != 1 {
+		t.Fatalf("expected counter to be 1; got %d", counter)
+	}
+
+	l.Acquire()
+	if l.TryToRAcquire() {
+		t.Fatal("expected TryToRAcquire to return false while the lock is held by a writer")
+	}
+
+	wg.Add(2 * numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			l.RAcquire()
+			l.RRelease()
+			wg.Done()
+		}()
+		go func() {
+			l.Acquire()
+			counter++
+			l.Release()
+			wg.Done()
+		}()
+	}
+
+	<-time.After(50 * time.Millisecond)
+	l.Release()
+	wg.Wait()
+
+	if exp := numWorkers + 1; counter != exp {
+		t.Fatalf("expected counter to be %d; got %d", exp, counter)
+	}
+
+	// Releasing an unlocked lock should be a no-op
+	l.Release()
+	if l.state != 0 {
+		t.Fatalf("expected lock state to be 0; got %d", l.state)
+	}
+}
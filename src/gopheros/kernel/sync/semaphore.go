@@ -0,0 +1,50 @@
+package sync
+
+import "sync/atomic"
+
+// Semaphore implements a counting semaphore. Tasks trying to acquire the
+// semaphore while its count is zero are put to sleep (or busy-wait if no
+// scheduler hooks have been registered) until the count becomes positive.
+type Semaphore struct {
+	count uint32
+}
+
+// NewSemaphore returns a new semaphore with its count set to the supplied
+// value.
+func NewSemaphore(count uint32) *Semaphore {
+	return &Semaphore{count: count}
+}
+
+// Acquire blocks until the semaphore count is positive and then decrements it.
+func (s *Semaphore) Acquire() {
+	for !s.TryToAcquire() {
+		wait(&s.count, 0)
+	}
+}
+
+// TryToAcquire attempts to decrement the semaphore count and returns true if
+// the count was positive or false otherwise.
+func (s *Semaphore) TryToAcquire() bool {
+	for {
+		count := atomic.LoadUint32(&s.count)
+		if count == 0 {
+			return false
+		}
+
+		if atomic.CompareAndSwapUint32(&s.count, count, count-1) {
+			return true
+		}
+	}
+}
+
+// Release increments the semaphore count and wakes up any tasks waiting on
+// the semaphore.
+func (s *Semaphore) Release() {
+	atomic.AddUint32(&s.count, 1)
+	wake(&s.count)
+}
+
+// Count returns the current semaphore count.
+func (s *Semaphore) Count() uint32 {
+	return atomic.LoadUint32(&s.count)
+}
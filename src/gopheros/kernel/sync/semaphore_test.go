@@ -0,0 +1,44 @@
+package sync
+
+import (
+	"runtime"
+	"sync"
+	"testing"
+)
+
+func TestSemaphore(t *testing.T) {
+	defer func(origYieldFn func()) { yieldFn = origYieldFn }(yieldFn)
+	yieldFn = runtime.Gosched
+
+	sem := NewSemaphore(2)
+
+	if !sem.TryToAcquire() || !sem.TryToAcquire() {
+		t.Fatal("expected to be able to acquire the semaphore twice")
+	}
+
+	if sem.TryToAcquire() {
+		t.Fatal("expected TryToAcquire to return false when the semaphore count is 0")
+	}
+
+	var (
+		wg         sync.WaitGroup
+		numWorkers = 10
+	)
+
+	wg.Add(numWorkers)
+	for i := 0; i < numWorkers; i++ {
+		go func() {
+			sem.Acquire()
+			sem.Release()
+			wg.Done()
+		}()
+	}
+
+	sem.Release()
+	sem.Release()
+	wg.Wait()
+
+	if got := sem.Count(); got != 2 {
+		t.Fatalf("expected semaphore count to be 2; got %d", got)
+	}
+}
@@ -1,5 +1,5 @@
-// Package sync provides synchronization primitive implementations for spinlocks
-// and semaphore.
+// Package sync provides synchronization primitive implementations for
+// spinlocks, reader-writer spinlocks, semaphores, sleeping mutexes and RCU.
 package sync
 
 import "sync/atomic"
@@ -7,8 +7,52 @@ import "sync/atomic"
 var (
 	// TODO: replace with real yield function when context-switching is implemented.
 	yieldFn func()
+
+	// parkFn puts the currently active task to sleep for as long as the
+	// value at addr equals val. If parkFn is nil, blocking primitives fall
+	// back to busy-waiting.
+	parkFn func(addr *uint32, val uint32)
+
+	// unparkFn wakes up any tasks that are parked on addr.
+	unparkFn func(addr *uint32)
 )
 
+// SetSchedulerHooks registers the functions used by the blocking primitives
+// in this package to interact with the scheduler. The yield function
+// relinquishes the CPU to another task. The park function puts the currently
+// active task to sleep for as long as the value at addr equals val whereas
+// the unpark function wakes up all tasks parked on addr.
+func SetSchedulerHooks(yield func(), park func(addr *uint32, val uint32), unpark func(addr *uint32)) {
+	yieldFn = yield
+	parkFn = park
+	unparkFn = unpark
+}
+
+// Locker is implemented by all lock types that can be acquired and released.
+type Locker interface {
+	Acquire()
+	Release()
+}
+
+// wait blocks the currently active task while the value at addr equals val.
+// If no park function has been registered, wait yields the CPU (if a yield
+// function is available) and returns immediately so that callers can retry.
+func wait(addr *uint32, val uint32) {
+	switch {
+	case parkFn != nil:
+		parkFn(addr, val)
+	case yieldFn != nil:
+		yieldFn()
+	}
+}
+
+// wake wakes up any tasks that are waiting on addr.
+func wake(addr *uint32) {
+	if unparkFn != nil {
+		unparkFn(addr)
+	}
+}
+
 // Spinlock implements a lock where each task trying to acquire it busy-waits
 // till the lock becomes available.
 type Spinlock struct {
@@ -0,0 +1,188 @@
+// Package watchdog provides a hardware-agnostic interface to the system
+// watchdog. Watchdog drivers register their devices with this package which
+// arms the watchdog and periodically resets its countdown (kicks it) for as
+// long as the kernel makes progress.
+//
+// The kernel does not support threads so the role of the kick thread is
+// performed by the idle task which calls Poll on each idle iteration. If the
+// kernel gets stuck and never returns to the idle task, the watchdog expires
+// and resets the system. When panic-on-expiry is requested, the hardware is
+// armed with twice the requested timeout and Poll panics once the requested
+// timeout elapses without a kick so that the kernel state can be inspected
+// before the hardware resets the system.
+//
+// The watchdog can be armed at boot via the "watchdog=<seconds>" command line
+// option; passing "watchdog.panic=on" enables panic-on-expiry.
+package watchdog
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/kfmt"
+	"gopheros/multiboot"
+	"strconv"
+)
+
+var (
+	errNoDevice       = &kernel.Error{Module: "watchdog", Message: "no watchdog device registered", Code: kernel.ErrCodeNotFound}
+	errInvalidTimeout = &kernel.Error{Module: "watchdog", Message: "timeout is outside the range supported by the watchdog device", Code: kernel.ErrCodeInvalidArgument}
+	errExpired        = &kernel.Error{Module: "watchdog", Message: "watchdog expired: kernel did not reach the idle task in time", Code: kernel.ErrCodeTimeout}
+
+	getBootCmdLineFn = multiboot.GetBootCmdLine
+	nanosecondsFn    = clock.Nanoseconds
+	panicFn          = kfmt.Panic
+
+	// activeDevice holds the registered watchdog device.
+	activeDevice Device
+
+	// The state of the armed watchdog. All times are in nanoseconds.
+	running       bool
+	panicOnExpiry bool
+	timeout       uint64
+	lastKick      uint64
+)
+
+// The number of nanoseconds in a millisecond.
+const nsPerMillisecond = uint64(1000000)
+
+// Device is implemented by hardware watchdog drivers.
+type Device interface {
+	// WatchdogName returns the name of the watchdog device.
+	WatchdogName() string
+
+	// TimeoutRange returns the shortest and longest timeout in
+	// milliseconds that the device supports.
+	TimeoutRange() (min, max uint32)
+
+	// Start arms the watchdog so that it expires unless it is kicked
+	// within the specified number of milliseconds.
+	Start(timeout uint32) *kernel.Error
+
+	// Stop disarms the watchdog.
+	Stop() *kernel.Error
+
+	// Kick resets the watchdog countdown.
+	Kick() *kernel.Error
+}
+
+// Register sets the device that is used by this package. Registering a device
+// while the watchdog is running has no effect.
+func Register(dev Device) {
+	if running {
+		return
+	}
+
+	activeDevice = dev
+}
+
+// ActiveDevice returns the registered watchdog device or nil if no device has
+// been registered.
+func ActiveDevice() Device {
+	return activeDevice
+}
+
+// Start arms the registered watchdog with the specified timeout in
+// milliseconds. If withPanic is true, the kernel panics if the timeout elapses
+// without a kick; the hardware is armed with twice the timeout (capped to the
+// longest supported timeout) so the panic is reported before the system is
+// reset.
+func Start(timeoutMs uint32, withPanic bool) *kernel.Error {
+	if activeDevice == nil {
+		return errNoDevice
+	}
+
+	min, max := activeDevice.TimeoutRange()
+	if timeoutMs < min || timeoutMs > max {
+		return errInvalidTimeout
+	}
+
+	hwTimeout := timeoutMs
+	if withPanic {
+		hwTimeout = max
+		if uint64(timeoutMs)*2 < uint64(max) {
+			hwTimeout = timeoutMs * 2
+		}
+	}
+
+	if err := activeDevice.Start(hwTimeout); err != nil {
+		return err
+	}
+
+	running = true
+	panicOnExpiry = withPanic
+	timeout = uint64(timeoutMs) * nsPerMillisecond
+	lastKick = nanosecondsFn()
+	return nil
+}
+
+// Stop disarms the registered watchdog.
+func Stop() *kernel.Error {
+	if activeDevice == nil {
+		return errNoDevice
+	}
+
+	if err := activeDevice.Stop(); err != nil {
+		return err
+	}
+
+	running = false
+	return nil
+}
+
+// Kick resets the countdown of the running watchdog.
+func Kick() *kernel.Error {
+	if !running {
+		return nil
+	}
+
+	if err := activeDevice.Kick(); err != nil {
+		return err
+	}
+
+	lastKick = nanosecondsFn()
+	return nil
+}
+
+// Poll implements the kick thread. It kicks the running watchdog once half of
+// its timeout has elapsed since the last kick. If no clock source is
+// available, the watchdog is kicked on every call. When panic-on-expiry is
+// enabled and the full timeout has elapsed since the last kick, Poll panics.
+func Poll() {
+	if !running {
+		return
+	}
+
+	now := nanosecondsFn()
+	elapsed := now - lastKick
+	if now != 0 && elapsed >= timeout && panicOnExpiry {
+		panicFn(errExpired)
+		return
+	}
+
+	if now == 0 || elapsed >= timeout/2 {
+		_ = Kick()
+	}
+}
+
+// Init arms the registered watchdog if the kernel was booted with the
+// "watchdog=<seconds>" command line option.
+func Init() {
+	cmdLine := getBootCmdLineFn()
+
+	seconds, err := strconv.ParseUint(cmdLine["watchdog"], 10, 32)
+	if err != nil || seconds == 0 {
+		return
+	}
+
+	timeoutMs := uint32(seconds * 1000)
+	if seconds*1000 > uint64(^uint32(0)) {
+		timeoutMs = ^uint32(0)
+	}
+
+	if err := Start(timeoutMs, cmdLine["watchdog.panic"] == "on"); err != nil {
+		kfmt.Printf("[watchdog] unable to start: %s\n", err.Error())
+		return
+	}
+
+	kfmt.Printf("[watchdog] %s armed with a %ds timeout\n", activeDevice.WatchdogName(), seconds)
+}
@@ -0,0 +1,172 @@
+package watchdog
+
+import (
+	"gopheros/kernel"
+	"gopheros/kernel/clock"
+	"gopheros/kernel/kfmt"
+	"gopheros/multiboot"
+	"testing"
+)
+
+func TestStartStop(t *testing.T) {
+	defer resetState()
+
+	if err := Start(1000, false); err != errNoDevice {
+		t.Fatalf("expected to get error %v; got %v", errNoDevice, err)
+	}
+
+	if err := Stop(); err != errNoDevice {
+		t.Fatalf("expected to get error %v; got %v", errNoDevice, err)
+	}
+
+	dev := &fakeDevice{min: 1000, max: 60000}
+	Register(dev)
+	if ActiveDevice() != dev {
+		t.Fatal("expected the registered device to become active")
+	}
+
+	specs := []struct {
+		timeout      uint32
+		withPanic    bool
+		expErr       *kernel.Error
+		expHWTimeout uint32
+	}{
+		{999, false, errInvalidTimeout, 0},
+		{60001, false, errInvalidTimeout, 0},
+		{10000, false, nil, 10000},
+		{10000, true, nil, 20000},
+		// The hardware timeout is capped to the device limit
+		{40000, true, nil, 60000},
+	}
+
+	for specIndex, spec := range specs {
+		dev.timeout = 0
+		if err := Start(spec.timeout, spec.withPanic); err != spec.expErr {
+			t.Errorf("[spec %d] expected to get error %v; got %v", specIndex, spec.expErr, err)
+			continue
+		}
+
+		if dev.timeout != spec.expHWTimeout {
+			t.Errorf("[spec %d] expected the device to be armed with a %d ms timeout; got %d", specIndex, spec.expHWTimeout, dev.timeout)
+		}
+	}
+
+	// Devices cannot be replaced while the watchdog is running
+	Register(&fakeDevice{})
+	if ActiveDevice() != dev {
+		t.Fatal("expected the running device to remain active")
+	}
+
+	if err := Stop(); err != nil || running || !dev.stopped {
+		t.Fatalf("expected the watchdog to be stopped; got err: %v, running: %t", err, running)
+	}
+}
+
+func TestPoll(t *testing.T) {
+	defer resetState()
+
+	var (
+		now      uint64
+		panicked bool
+	)
+	nanosecondsFn = func() uint64 { return now }
+	panicFn = func(interface{}) { panicked = true }
+
+	dev := &fakeDevice{min: 1000, max: 60000}
+	Register(dev)
+
+	// Poll is a no-op while the watchdog is stopped
+	Poll()
+	if dev.kicks != 0 {
+		t.Fatal("expected Poll not to kick a stopped watchdog")
+	}
+
+	specs := []struct {
+		withPanic   bool
+		elapsed     uint64
+		expKick     bool
+		expPanicked bool
+	}{
+		{false, 4999 * nsPerMillisecond, false, false},
+		{false, 5000 * nsPerMillisecond, true, false},
+		{false, 20000 * nsPerMillisecond, true, false},
+		{true, 5000 * nsPerMillisecond, true, false},
+		{true, 10000 * nsPerMillisecond, false, true},
+	}
+
+	for specIndex, spec := range specs {
+		now = 1
+		if err := Start(10000, spec.withPanic); err != nil {
+			t.Fatal(err)
+		}
+
+		dev.kicks, panicked = 0, false
+		now += spec.elapsed
+		Poll()
+
+		if gotKick := dev.kicks != 0; gotKick != spec.expKick || panicked != spec.expPanicked {
+			t.Errorf("[spec %d] expected kick: %t, panic: %t; got kick: %t, panic: %t", specIndex, spec.expKick, spec.expPanicked, gotKick, panicked)
+		}
+	}
+
+	// Without a clock source, the watchdog is kicked on every call
+	now = 0
+	dev.kicks = 0
+	Poll()
+	Poll()
+	if dev.kicks != 2 {
+		t.Fatalf("expected the watchdog to be kicked on each call; got %d kicks", dev.kicks)
+	}
+}
+
+func TestInit(t *testing.T) {
+	defer resetState()
+
+	dev := &fakeDevice{min: 1000, max: 60000}
+	Register(dev)
+
+	specs := []struct {
+		cmdLine    map[string]string
+		expRunning bool
+		expPanic   bool
+	}{
+		{map[string]string{}, false, false},
+		{map[string]string{"watchdog": "off"}, false, false},
+		{map[string]string{"watchdog": "0"}, false, false},
+		{map[string]string{"watchdog": "120"}, false, false},
+		{map[string]string{"watchdog": "30"}, true, false},
+		{map[string]string{"watchdog": "30", "watchdog.panic": "on"}, true, true},
+	}
+
+	for specIndex, spec := range specs {
+		running = false
+		getBootCmdLineFn = func() map[string]string { return spec.cmdLine }
+
+		Init()
+		if running != spec.expRunning || running && panicOnExpiry != spec.expPanic {
+			t.Errorf("[spec %d] expected running: %t, panic: %t; got running: %t, panic: %t", specIndex, spec.expRunning, spec.expPanic, running, panicOnExpiry)
+		}
+	}
+}
+
+type fakeDevice struct {
+	min, max uint32
+	timeout  uint32
+	kicks    int
+	stopped  bool
+}
+
+func (d *fakeDevice) WatchdogName() string               { return "fake" }
+func (d *fakeDevice) TimeoutRange() (uint32, uint32)     { return d.min, d.max }
+func (d *fakeDevice) Start(timeout uint32) *kernel.Error { d.timeout = timeout; return nil }
+func (d *fakeDevice) Stop() *kernel.Error                { d.stopped = true; return nil }
+func (d *fakeDevice) Kick() *kernel.Error                { d.kicks++; return nil }
+
+func resetState() {
+	activeDevice = nil
+	running = false
+	panicOnExpiry = false
+	getBootCmdLineFn = multiboot.GetBootCmdLine
+	nanosecondsFn = clock.Nanoseconds
+	panicFn = kfmt.Panic
+}
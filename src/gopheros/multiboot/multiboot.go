@@ -214,6 +214,21 @@ type elfSection64 struct {
 	entSize     uint64
 }
 
+// moduleHeader describes the header for a boot module tag. The header is
+// followed by the module command line.
+type moduleHeader struct {
+	// The physical address range occupied by the module.
+	modStart uint32
+	modEnd   uint32
+}
+
+// ModuleVisitor defines a visitor function that gets invoked by VisitModules
+// for each module loaded by the boot loader. The visitor receives the module
+// command line and the physical address range [physStart, physEnd) that
+// contains the module contents. The visitor must return true to continue or
+// false to abort the scan.
+type ModuleVisitor func(cmdLine string, physStart, physEnd uintptr) bool
+
 // ElfSectionFlag defines an OR-able flag associated with an ElfSection.
 type ElfSectionFlag uint32
 
@@ -306,6 +321,28 @@ func VisitMemRegions(visitor MemRegionVisitor) {
 	}
 }
 
+// VisitModules invokes visitor for each boot module (e.g. an initrd) that was
+// loaded by the boot loader.
+func VisitModules(visitor ModuleVisitor) {
+	visitTagsByType(tagModules, func(curPtr uintptr, size uint32) bool {
+		var (
+			modHeader      = (*moduleHeader)(unsafe.Pointer(curPtr))
+			sizeofHeader   = uint32(unsafe.Sizeof(*modHeader))
+			cmdLine        string
+			cmdLineHeader  = (*reflect.StringHeader)(unsafe.Pointer(&cmdLine))
+			cmdLineDataPtr = curPtr + uintptr(sizeofHeader)
+		)
+
+		// The command line is a C-style NULL-terminated string
+		for end := cmdLineDataPtr; end < curPtr+uintptr(size) && *(*byte)(unsafe.Pointer(end)) != 0; end++ {
+			cmdLineHeader.Len++
+		}
+		cmdLineHeader.Data = cmdLineDataPtr
+
+		return visitor(cmdLine, uintptr(modHeader.modStart), uintptr(modHeader.modEnd))
+	})
+}
+
 // GetFramebufferInfo returns information about the framebuffer initialized by the
 // bootloader. This function returns nil if no framebuffer info is available.
 func GetFramebufferInfo() *FramebufferInfo {
@@ -359,17 +396,38 @@ func GetBootCmdLine() map[string]string {
 // If the tag is not present in the multiboot info, findTagSection will return
 // back (0,0).
 func findTagByType(tagType tagType) (uintptr, uint32) {
+	var (
+		tagPtr  uintptr
+		tagSize uint32
+	)
+
+	visitTagsByType(tagType, func(curPtr uintptr, size uint32) bool {
+		tagPtr, tagSize = curPtr, size
+		return false
+	})
+
+	return tagPtr, tagSize
+}
+
+// visitTagsByType invokes visitor for each tag of the specified type in the
+// multiboot info data. The visitor receives a pointer to the tag contents and
+// the content length excluding the tag header. The visitor must return true
+// to continue or false to abort the scan.
+func visitTagsByType(tagType tagType, visitor func(uintptr, uint32) bool) {
 	var ptrTagHeader *tagHeader
 
+	// No multiboot info data has been supplied via SetInfoPtr
+	if infoData == 0 {
+		return
+	}
+
 	curPtr := infoData + 8
 	for ptrTagHeader = (*tagHeader)(unsafe.Pointer(curPtr)); ptrTagHeader.tagType != tagMbSectionEnd; ptrTagHeader = (*tagHeader)(unsafe.Pointer(curPtr)) {
-		if ptrTagHeader.tagType == tagType {
-			return curPtr + 8, ptrTagHeader.size - 8
+		if ptrTagHeader.tagType == tagType && !visitor(curPtr+8, ptrTagHeader.size-8) {
+			return
 		}
 
 		// Tags are aligned at 8-byte aligned addresses
 		curPtr += uintptr(int32(ptrTagHeader.size+7) & ^7)
 	}
-
-	return 0, 0
 }
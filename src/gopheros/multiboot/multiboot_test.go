@@ -251,6 +251,56 @@ func TestGetElfSections(t *testing.T) {
 	}
 }
 
+func TestVisitModules(t *testing.T) {
+	SetInfoPtr(uintptr(unsafe.Pointer(&emptyInfoData[0])))
+
+	VisitModules(func(_ string, _, _ uintptr) bool {
+		t.Fatal("expected VisitModules not to invoke the visitor when no modules tag is present")
+		return true
+	})
+
+	SetInfoPtr(uintptr(unsafe.Pointer(&modulesInfoTestData[0])))
+
+	specs := []struct {
+		cmdLine   string
+		physStart uintptr
+		physEnd   uintptr
+	}{
+		{"initrd", 0x200000, 0x201000},
+		{"dsdt.aml acpi_override", 0x300000, 0x300100},
+		{"", 0x400000, 0x400010},
+	}
+
+	var visitCount int
+	VisitModules(func(cmdLine string, physStart, physEnd uintptr) bool {
+		if visitCount >= len(specs) {
+			t.Fatalf("visitor invoked more times than expected")
+		}
+
+		spec := specs[visitCount]
+		if cmdLine != spec.cmdLine || physStart != spec.physStart || physEnd != spec.physEnd {
+			t.Errorf("[module %d] expected (%q, 0x%x, 0x%x); got (%q, 0x%x, 0x%x)", visitCount, spec.cmdLine, spec.physStart, spec.physEnd, cmdLine, physStart, physEnd)
+		}
+		visitCount++
+		return true
+	})
+
+	if visitCount != len(specs) {
+		t.Fatalf("expected visitor to be invoked %d times; got %d", len(specs), visitCount)
+	}
+
+	// Aborting the scan should stop the iteration
+	visitCount = 0
+	VisitModules(func(_ string, _, _ uintptr) bool {
+		visitCount++
+		return false
+	})
+
+	if visitCount != 1 {
+		t.Fatalf("expected visitor to be invoked once when aborting the scan; got %d", visitCount)
+	}
+}
+
 var (
 	emptyInfoData = []byte{
 		0, 0, 0, 0, // size
@@ -259,6 +309,37 @@ var (
 		0, 0, 0, 0,
 	}
 
+	// Multiboot data containing three module tags.
+	modulesInfoTestData = []byte{
+		0, 0, 0, 0, // size
+		0, 0, 0, 0, // reserved
+		// module tag: initrd
+		3, 0, 0, 0, // type
+		23, 0, 0, 0, // size
+		0, 0, 32, 0, // mod_start
+		0, 16, 32, 0, // mod_end
+		'i', 'n', 'i', 't', 'r', 'd', 0,
+		0, // padding
+		// module tag: dsdt.aml acpi_override
+		3, 0, 0, 0, // type
+		39, 0, 0, 0, // size
+		0, 0, 48, 0, // mod_start
+		0, 1, 48, 0, // mod_end
+		'd', 's', 'd', 't', '.', 'a', 'm', 'l', ' ',
+		'a', 'c', 'p', 'i', '_', 'o', 'v', 'e', 'r', 'r', 'i', 'd', 'e', 0,
+		0, // padding
+		// module tag with an empty command line
+		3, 0, 0, 0, // type
+		17, 0, 0, 0, // size
+		0, 0, 64, 0, // mod_start
+		16, 0, 64, 0, // mod_end
+		0,
+		0, 0, 0, 0, 0, 0, 0, // padding
+		// end tag
+		0, 0, 0, 0,
+		8, 0, 0, 0,
+	}
+
 	// A dump of multiboot data when running under qemu.
 	multibootInfoTestData = []byte{
 		0xb8, 0x0a, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x01, 0x00, 0x00, 0x00, 0x24, 0x00, 0x00, 0x00,
@@ -0,0 +1,410 @@
+// acpiexec loads AML tables (DSDT/SSDT dumps) on the host and provides a
+// simple command interpreter for inspecting the parsed ACPI namespace. It
+// runs the same aml package used by the kernel so firmware behavior can be
+// debugged without booting anything. Accesses to operation regions are
+// emulated using host memory.
+//
+// Usage: go run tools/acpiexec/acpiexec.go [-script file] [table.aml...]
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/table"
+	"gopheros/kernel"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unsafe"
+)
+
+const prompt = "acpiexec> "
+
+var (
+	errUnknownCommand = errors.New("unknown command; type help for a list of commands")
+	errNotFound       = errors.New("no such object")
+	errInvalidArgs    = errors.New("invalid arguments")
+	errTableTooShort  = errors.New("file is too short to contain an ACPI table")
+)
+
+type command struct {
+	usage string
+	help  string
+	fn    func(s *session, args []string) error
+}
+
+var commands map[string]*command
+
+func init() {
+	commands = map[string]*command{
+		"help":     {"", "list available commands", cmdHelp},
+		"load":     {"file.aml", "parse an AML table and merge it into the namespace", cmdLoad},
+		"tree":     {"", "pretty-print the namespace", cmdTree},
+		"find":     {"path", "resolve a namespace path", cmdFind},
+		"exec":     {"path [args...]", "evaluate a control method", cmdExec},
+		"snapshot": {"[file]", "write a canonical namespace snapshot to file (or stdout)", cmdSnapshot},
+		"diff":     {"old new", "compare two namespace snapshot files", cmdDiff},
+	}
+}
+
+// session tracks the state of an acpiexec run.
+type session struct {
+	out  io.Writer
+	tree *aml.ObjectTree
+	vm   *aml.VM
+
+	// tables holds the raw contents of all loaded tables. The contents
+	// must remain reachable as the object tree references them.
+	tables [][]byte
+}
+
+func newSession(out io.Writer) *session {
+	tree := aml.NewObjectTree()
+	tree.CreateDefaultScopes(0)
+
+	vm := aml.NewVM(out, tree)
+	regions := newMemRegionHandler()
+	for space := aml.RegionSpaceSystemMemory; space <= aml.RegionSpacePCC; space++ {
+		// Serial bus regions exchange buffers via a SerialBusHandler
+		if space == aml.RegionSpaceSMBus || space == aml.RegionSpaceGenericSerialBus {
+			continue
+		}
+		vm.RegisterRegionHandler(space, regions)
+	}
+
+	return &session{
+		out:  out,
+		tree: tree,
+		vm:   vm,
+	}
+}
+
+// regionKey identifies the backing store for an OperationRegion.
+type regionKey struct {
+	space  aml.RegionSpace
+	offset uint64
+
+	// The device address; only used by PCI_Config regions.
+	pciSegment  uint16
+	pciBus      uint8
+	pciDevice   uint8
+	pciFunction uint8
+}
+
+// regionPageSize is the granularity at which memRegionHandler allocates
+// backing memory.
+const regionPageSize = 4096
+
+// regionPage identifies a page of the backing store for an OperationRegion.
+type regionPage struct {
+	region regionKey
+	page   uint64
+}
+
+// memRegionHandler implements aml.RegionHandler by backing each region with
+// host memory so that methods that access operation regions can be evaluated
+// without touching any hardware. Regions are keyed by their address space and
+// offset and their contents are initially zeroed. Backing memory is allocated
+// a page at a time when a region is first written to, so regions spanning
+// large address ranges (e.g. multi-GiB SystemMemory regions) only consume as
+// much host memory as the evaluated methods actually touch.
+type memRegionHandler struct {
+	pages map[regionPage]*[regionPageSize]byte
+}
+
+func newMemRegionHandler() *memRegionHandler {
+	return &memRegionHandler{pages: make(map[regionPage]*[regionPageSize]byte)}
+}
+
+// ReadRegion implements aml.RegionHandler.
+func (h *memRegionHandler) ReadRegion(region *aml.Region, offset uint64, width uint8) (uint64, *kernel.Error) {
+	key := keyFor(region)
+
+	var val uint64
+	for i := uint64(0); i < uint64(width>>3); i++ {
+		if page := h.pages[regionPage{key, (offset + i) / regionPageSize}]; page != nil {
+			val |= uint64(page[(offset+i)%regionPageSize]) << (i * 8)
+		}
+	}
+	return val, nil
+}
+
+// WriteRegion implements aml.RegionHandler.
+func (h *memRegionHandler) WriteRegion(region *aml.Region, offset uint64, width uint8, val uint64) *kernel.Error {
+	key := keyFor(region)
+
+	for i := uint64(0); i < uint64(width>>3); i++ {
+		pageKey := regionPage{key, (offset + i) / regionPageSize}
+		page := h.pages[pageKey]
+		if page == nil {
+			page = new([regionPageSize]byte)
+			h.pages[pageKey] = page
+		}
+		page[(offset+i)%regionPageSize] = byte(val >> (i * 8))
+	}
+	return nil
+}
+
+// keyFor returns the key that identifies the backing store for region.
+func keyFor(region *aml.Region) regionKey {
+	key := regionKey{space: region.Space, offset: region.Offset}
+	if region.Space == aml.RegionSpacePCIConfig {
+		key.pciSegment, key.pciBus = region.PCISegment, region.PCIBus
+		key.pciDevice, key.pciFunction = region.PCIDevice, region.PCIFunction
+	}
+
+	return key
+}
+
+func (s *session) exec(line string) error {
+	fields := strings.Fields(line)
+	if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+		return nil
+	}
+
+	cmd, ok := commands[fields[0]]
+	if !ok {
+		return errUnknownCommand
+	}
+
+	return cmd.fn(s, fields[1:])
+}
+
+// run reads commands from r until EOF or a "quit" command is encountered. If
+// echo is true, each command is echoed back before being executed; this is
+// useful when running scripts.
+func (s *session) run(r io.Reader, interactive, echo bool) {
+	scanner := bufio.NewScanner(r)
+	for {
+		if interactive {
+			fmt.Fprint(s.out, prompt)
+		}
+
+		if !scanner.Scan() {
+			return
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if echo && line != "" {
+			fmt.Fprintf(s.out, "%s%s\n", prompt, line)
+		}
+
+		if line == "quit" || line == "exit" {
+			return
+		}
+
+		if err := s.exec(line); err != nil {
+			fmt.Fprintf(s.out, "error: %s\n", err.Error())
+		}
+	}
+}
+
+func cmdHelp(s *session, _ []string) error {
+	for _, name := range []string{"help", "load", "tree", "find", "exec", "snapshot", "diff"} {
+		cmd := commands[name]
+		fmt.Fprintf(s.out, "%s %s\n    %s\n", name, cmd.usage, cmd.help)
+	}
+	fmt.Fprintf(s.out, "quit\n    exit acpiexec\n")
+	return nil
+}
+
+func cmdLoad(s *session, args []string) error {
+	if len(args) != 1 {
+		return errInvalidArgs
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+
+	if uintptr(len(data)) < unsafe.Sizeof(table.SDTHeader{}) {
+		return errTableTooShort
+	}
+
+	header := (*table.SDTHeader)(unsafe.Pointer(&data[0]))
+	if int(header.Length) > len(data) {
+		return errTableTooShort
+	}
+
+	tableHandle := uint8(len(s.tables) + 1)
+	tableName := string(header.Signature[:])
+	if err := aml.NewParser(s.out, s.tree).ParseAML(tableHandle, tableName, header); err != nil {
+		return err
+	}
+
+	s.tables = append(s.tables, data)
+	fmt.Fprintf(s.out, "loaded %s (%d bytes) as table %d\n", tableName, header.Length, tableHandle)
+	return nil
+}
+
+func cmdTree(s *session, _ []string) error {
+	s.tree.PrettyPrint(s.out)
+	return nil
+}
+
+func cmdFind(s *session, args []string) error {
+	if len(args) != 1 {
+		return errInvalidArgs
+	}
+
+	node := s.tree.Namespace().Lookup(nil, args[0])
+	if node == nil {
+		return errNotFound
+	}
+
+	obj := node.Object()
+	fmt.Fprintf(s.out, "%s [%s, index: %d]\n", node.Path(), obj.Kind(), obj.Index())
+	return nil
+}
+
+func cmdExec(s *session, args []string) error {
+	if len(args) < 1 {
+		return errInvalidArgs
+	}
+
+	// Numeric args may be specified in decimal or in hex (0x prefix); any
+	// other arg is passed to the method as a string.
+	methodArgs := make([]interface{}, 0, len(args)-1)
+	for _, arg := range args[1:] {
+		if val, err := strconv.ParseUint(arg, 0, 64); err == nil {
+			methodArgs = append(methodArgs, val)
+			continue
+		}
+		methodArgs = append(methodArgs, strings.Trim(arg, `"`))
+	}
+
+	res, err := s.vm.Evaluate(args[0], methodArgs...)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(s.out, "%s = %s\n", args[0], formatValue(res))
+	return nil
+}
+
+// formatValue returns a printable representation of a value returned by the
+// AML interpreter.
+func formatValue(val interface{}) string {
+	switch v := val.(type) {
+	case nil:
+		return "<none>"
+	case uint64:
+		return fmt.Sprintf("0x%x", v)
+	case string:
+		return strconv.Quote(v)
+	case []byte:
+		return fmt.Sprintf("Buffer % x", v)
+	case []interface{}:
+		elems := make([]string, len(v))
+		for i, elem := range v {
+			elems[i] = formatValue(elem)
+		}
+		return "Package {" + strings.Join(elems, ", ") + "}"
+	case *aml.Object:
+		return fmt.Sprintf("[%s, index: %d]", v.Kind(), v.Index())
+	default:
+		return fmt.Sprintf("%v", v)
+	}
+}
+
+func cmdSnapshot(s *session, args []string) error {
+	switch len(args) {
+	case 0:
+		s.tree.WriteSnapshot(s.out)
+		return nil
+	case 1:
+		f, err := os.Create(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		s.tree.WriteSnapshot(f)
+		return nil
+	default:
+		return errInvalidArgs
+	}
+}
+
+func cmdDiff(s *session, args []string) error {
+	if len(args) != 2 {
+		return errInvalidArgs
+	}
+
+	var snapshots [2][]string
+	for i, file := range args {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+
+		for _, line := range strings.Split(string(data), "\n") {
+			if line != "" {
+				snapshots[i] = append(snapshots[i], line)
+			}
+		}
+		sort.Strings(snapshots[i])
+	}
+
+	fmt.Fprintf(s.out, "--- %s\n+++ %s\n", args[0], args[1])
+	added, removed := diffSnapshots(s.out, snapshots[0], snapshots[1])
+	fmt.Fprintf(s.out, "%d line(s) removed, %d line(s) added\n", removed, added)
+	return nil
+}
+
+// diffSnapshots compares two sorted snapshot line lists and writes the lines
+// that only appear in old (prefixed by '-') or new (prefixed by '+') to w.
+// Since snapshot lines begin with the object path, changes to an object are
+// reported as a pair of adjacent removed/added lines.
+func diffSnapshots(w io.Writer, old, new []string) (added, removed int) {
+	for len(old) != 0 || len(new) != 0 {
+		switch {
+		case len(new) == 0 || (len(old) != 0 && old[0] < new[0]):
+			fmt.Fprintf(w, "-%s\n", old[0])
+			old = old[1:]
+			removed++
+		case len(old) == 0 || new[0] < old[0]:
+			fmt.Fprintf(w, "+%s\n", new[0])
+			new = new[1:]
+			added++
+		default:
+			old, new = old[1:], new[1:]
+		}
+	}
+
+	return added, removed
+}
+
+func main() {
+	script := flag.String("script", "", "read commands from this file instead of stdin")
+	flag.Parse()
+
+	s := newSession(os.Stdout)
+	for _, file := range flag.Args() {
+		if err := cmdLoad(s, []string{file}); err != nil {
+			fmt.Fprintf(os.Stderr, "[acpiexec] error: %s: %s\n", file, err.Error())
+			os.Exit(1)
+		}
+	}
+
+	if *script != "" {
+		f, err := os.Open(*script)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "[acpiexec] error: %s\n", err.Error())
+			os.Exit(1)
+		}
+		defer f.Close()
+
+		s.run(f, false, true)
+		return
+	}
+
+	s.run(os.Stdin, true, false)
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"gopheros/device/acpi/aml"
+	"gopheros/device/acpi/aml/amltest"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"unsafe"
+)
+
+func TestExecOperationRegions(t *testing.T) {
+	tableFile := writeTestTable(t, amltest.Concat(
+		// OperationRegion(MEM0, SystemMemory, 0x1000, 0x10)
+		// Field(MEM0, AnyAcc, NoLock, Preserve) { FLD0, 32 }
+		[]byte{0x5b, 0x80, 'M', 'E', 'M', '0', 0x00, 0x0b, 0x00, 0x10, 0x0a, 0x10},
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{'M', 'E', 'M', '0', 0x00, 'F', 'L', 'D', '0', 0x20}),
+		// OperationRegion(MEM1, SystemMemory, 0x2000, 0x10)
+		// Field(MEM1, AnyAcc, NoLock, Preserve) { FLD1, 32 }
+		[]byte{0x5b, 0x80, 'M', 'E', 'M', '1', 0x00, 0x0b, 0x00, 0x20, 0x0a, 0x10},
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{'M', 'E', 'M', '1', 0x00, 'F', 'L', 'D', '1', 0x20}),
+		// OperationRegion(IO00, SystemIO, 0x80, 1)
+		// Field(IO00, ByteAcc, NoLock, Preserve) { PRT0, 8 }
+		[]byte{0x5b, 0x80, 'I', 'O', '0', '0', 0x01, 0x0a, 0x80, 0x0a, 0x01},
+		amltest.Pkg([]byte{0x5b, 0x81}, []byte{'I', 'O', '0', '0', 0x01, 'P', 'R', 'T', '0', 0x08}),
+		// Method(TST0) {
+		//   Store(0x12345678, FLD0)
+		//   Store(0xaa, PRT0)
+		//   Return(Add(FLD0, PRT0))
+		// }
+		amltest.Pkg([]byte{0x14}, []byte{
+			'T', 'S', 'T', '0', 0x00,
+			0x70, 0x0c, 0x78, 0x56, 0x34, 0x12, 'F', 'L', 'D', '0',
+			0x70, 0x0a, 0xaa, 'P', 'R', 'T', '0',
+			0xa4, 0x72, 'F', 'L', 'D', '0', 'P', 'R', 'T', '0', 0x00,
+		}),
+		// Method(TST1) { Return(FLD1) }
+		amltest.Pkg([]byte{0x14}, []byte{'T', 'S', 'T', '1', 0x00, 0xa4, 'F', 'L', 'D', '1'}),
+	))
+	defer os.RemoveAll(filepath.Dir(tableFile))
+
+	var buf bytes.Buffer
+	s := newSession(&buf)
+
+	specs := []struct {
+		cmd    string
+		expOut string
+	}{
+		// Values written to a region should be read back
+		{`exec \TST0`, "\\TST0 = 0x12345722\n"},
+		// Regions at different offsets should not alias
+		{`exec \TST1`, "\\TST1 = 0x0\n"},
+	}
+
+	if err := s.exec("load " + tableFile); err != nil {
+		t.Fatal(err)
+	}
+
+	for specIndex, spec := range specs {
+		buf.Reset()
+		if err := s.exec(spec.cmd); err != nil {
+			t.Errorf("[spec %d] unexpected error: %v", specIndex, err)
+			continue
+		}
+
+		if got := buf.String(); got != spec.expOut {
+			t.Errorf("[spec %d] expected output %q; got %q", specIndex, spec.expOut, got)
+		}
+	}
+}
+
+func TestMemRegionHandler(t *testing.T) {
+	h := newMemRegionHandler()
+
+	dev0 := &aml.Region{Space: aml.RegionSpacePCIConfig, Length: 0x100, PCIDevice: 1}
+	dev1 := &aml.Region{Space: aml.RegionSpacePCIConfig, Length: 0x100, PCIDevice: 2}
+
+	if err := h.WriteRegion(dev0, 0xfc, 32, 0xdeadbeef); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := h.ReadRegion(dev0, 0xfc, 32); got != 0xdeadbeef {
+		t.Fatalf("expected to read back 0xdeadbeef; got 0x%x", got)
+	}
+
+	if got, _ := h.ReadRegion(dev0, 0xfe, 16); got != 0xdead {
+		t.Fatalf("expected to read back 0xdead; got 0x%x", got)
+	}
+
+	if got, _ := h.ReadRegion(dev1, 0xfc, 32); got != 0 {
+		t.Fatalf("expected the config space of different PCI devices not to alias; got 0x%x", got)
+	}
+
+	// Regions spanning large address ranges are backed sparsely and
+	// accesses may straddle page boundaries
+	huge := &aml.Region{Space: aml.RegionSpaceSystemMemory, Offset: 0x80000000, Length: 1 << 40}
+	offset := uint64(1<<39) - 2
+	if err := h.WriteRegion(huge, offset, 32, 0xcafef00d); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := h.ReadRegion(huge, offset, 32); got != 0xcafef00d {
+		t.Fatalf("expected to read back 0xcafef00d; got 0x%x", got)
+	}
+
+	if got, _ := h.ReadRegion(huge, 0, 64); got != 0 {
+		t.Fatalf("expected untouched region contents to read as zero; got 0x%x", got)
+	}
+
+	if got := len(h.pages); got != 3 {
+		t.Fatalf("expected only the touched pages to be allocated; got %d pages", got)
+	}
+}
+
+// writeTestTable writes a DSDT containing the supplied AML payload to a
+// file in a new temporary directory and returns the file path.
+func writeTestTable(t *testing.T, payload []byte) string {
+	header := amltest.SDTHeaderFor(payload)
+	stream := (*[1 << 20]byte)(unsafe.Pointer(header))[:header.Length:header.Length]
+
+	dir, err := ioutil.TempDir("", "acpiexec")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(dir, "dsdt.aml")
+	if err = ioutil.WriteFile(file, stream, 0600); err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+
+	return file
+}
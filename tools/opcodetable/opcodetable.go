@@ -0,0 +1,372 @@
+// opcodetable generates the AML parser opcode tables (opcode constants, the
+// opcode info table and the opcode/extended opcode lookup maps) from a
+// declarative description of the AML grammar.
+//
+// Usage: go run tools/opcodetable/opcodetable.go -in parser_opcode_table.spec -out parser_opcode_table_gen.go
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"io"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+const (
+	// badOpcode marks opcode map slots that do not map to a table entry.
+	badOpcode = 0xff
+
+	// extOpPrefix is the first byte of extended opcodes in the AML stream.
+	extOpPrefix = 0x5b
+
+	// maxArgs is the maximum number of args that can be encoded by the
+	// parser's pOpArgTypeList type.
+	maxArgs = 7
+
+	// lastInternalOpcode is the encoding of the last internal opcode. The
+	// parser locates the table entries for internal opcodes by counting
+	// backwards from the end of the table so internal opcodes must be
+	// contiguous and end at this value.
+	lastInternalOpcode = 0xfe
+)
+
+type opcodeKind uint8
+
+const (
+	opcodeKindRegular opcodeKind = iota
+	opcodeKindExtended
+	opcodeKindInternal
+)
+
+var (
+	knownFlags = map[string]bool{
+		"Named": true, "Constant": true, "Reference": true, "Create": true,
+		"Executable": true, "Scoped": true, "DeferParsing": true,
+	}
+
+	knownArgs = map[string]bool{
+		"TermList": true, "TermArg": true, "ByteList": true, "String": true,
+		"ByteData": true, "WordData": true, "DwordData": true, "QwordData": true,
+		"NameString": true, "SuperName": true, "SimpleName": true,
+		"DataRefObj": true, "Target": true, "FieldList": true, "PkgLen": true,
+	}
+
+	errNoEntries = errors.New("spec does not define any opcodes")
+)
+
+// opcodeSpec describes a single opcode table entry.
+type opcodeSpec struct {
+	line      int
+	constName string
+	kind      opcodeKind
+	code      uint8
+	name      string
+	flags     []string
+	args      []string
+}
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "[opcodetable] error: %s\n", err.Error())
+	os.Exit(1)
+}
+
+// parseSpec parses the opcode entries defined in r.
+func parseSpec(r io.Reader) ([]*opcodeSpec, error) {
+	var (
+		specs   []*opcodeSpec
+		scanner = bufio.NewScanner(r)
+	)
+
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' {
+			continue
+		}
+
+		fields, err := splitFields(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNum, err)
+		}
+
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: expected 5 fields; got %d", lineNum, len(fields))
+		}
+
+		spec := &opcodeSpec{line: lineNum, constName: fields[0], name: fields[2]}
+
+		encoding := fields[1]
+		switch {
+		case strings.HasPrefix(encoding, "ext:"):
+			spec.kind, encoding = opcodeKindExtended, encoding[4:]
+		case strings.HasPrefix(encoding, "int:"):
+			spec.kind, encoding = opcodeKindInternal, encoding[4:]
+		}
+
+		code, err := strconv.ParseUint(encoding, 0, 8)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid opcode encoding %q", lineNum, fields[1])
+		}
+		spec.code = uint8(code)
+
+		if fields[3] != "-" {
+			spec.flags = strings.Split(fields[3], "|")
+		}
+
+		if fields[4] != "-" {
+			spec.args = strings.Split(fields[4], ",")
+		}
+
+		specs = append(specs, spec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return specs, nil
+}
+
+// splitFields splits line into whitespace-separated fields. Fields enclosed in
+// double quotes may contain whitespace.
+func splitFields(line string) ([]string, error) {
+	var fields []string
+
+	for line = strings.TrimSpace(line); line != ""; line = strings.TrimSpace(line) {
+		if line[0] == '"' {
+			end := strings.IndexByte(line[1:], '"')
+			if end == -1 {
+				return nil, errors.New("unterminated quoted field")
+			}
+			fields = append(fields, line[1:end+1])
+			line = line[end+2:]
+			continue
+		}
+
+		end := strings.IndexAny(line, " \t")
+		if end == -1 {
+			end = len(line)
+		}
+		fields = append(fields, line[:end])
+		line = line[end:]
+	}
+
+	return fields, nil
+}
+
+// validate checks that specs can be encoded into the parser's opcode tables.
+func validate(specs []*opcodeSpec) error {
+	if len(specs) == 0 {
+		return errNoEntries
+	}
+
+	if len(specs) >= badOpcode {
+		return fmt.Errorf("opcode table contains %d entries; at most %d entries are supported", len(specs), badOpcode-1)
+	}
+
+	var (
+		constNames = make(map[string]int)
+		names      = make(map[string]int)
+		encodings  = make(map[[2]uint8]int)
+		lastKind   = opcodeKindRegular
+	)
+
+	for _, spec := range specs {
+		if prev, exists := constNames[spec.constName]; exists {
+			return fmt.Errorf("line %d: constant %s already defined at line %d", spec.line, spec.constName, prev)
+		}
+		constNames[spec.constName] = spec.line
+
+		if prev, exists := names[spec.name]; exists {
+			return fmt.Errorf("line %d: opcode name %q already defined at line %d", spec.line, spec.name, prev)
+		}
+		names[spec.name] = spec.line
+
+		key := [2]uint8{uint8(spec.kind), spec.code}
+		if prev, exists := encodings[key]; exists {
+			return fmt.Errorf("line %d: opcode encoding 0x%02x already defined at line %d", spec.line, spec.code, prev)
+		}
+		encodings[key] = spec.line
+
+		if spec.kind == opcodeKindRegular && spec.code == extOpPrefix {
+			return fmt.Errorf("line %d: opcode 0x%02x is reserved for the extended opcode prefix", spec.line, spec.code)
+		}
+
+		if lastKind == opcodeKindInternal && spec.kind != opcodeKindInternal {
+			return fmt.Errorf("line %d: internal opcodes must be listed after all other opcodes", spec.line)
+		}
+		lastKind = spec.kind
+
+		for _, flag := range spec.flags {
+			if !knownFlags[flag] {
+				return fmt.Errorf("line %d: unknown flag %q", spec.line, flag)
+			}
+		}
+
+		if len(spec.args) > maxArgs {
+			return fmt.Errorf("line %d: opcodes can have at most %d args", spec.line, maxArgs)
+		}
+
+		for _, arg := range spec.args {
+			if !knownArgs[arg] {
+				return fmt.Errorf("line %d: unknown arg type %q", spec.line, arg)
+			}
+		}
+	}
+
+	// Internal opcodes must be contiguous and end at lastInternalOpcode
+	nextCode := lastInternalOpcode
+	for index := len(specs) - 1; index >= 0 && specs[index].kind == opcodeKindInternal; index-- {
+		if int(specs[index].code) != nextCode {
+			return fmt.Errorf("line %d: expected internal opcode encoding 0x%02x; got 0x%02x", specs[index].line, nextCode, specs[index].code)
+		}
+		nextCode--
+	}
+
+	return nil
+}
+
+// generate writes the Go source for the opcode tables described by specs to w.
+func generate(w io.Writer, specFile string, specs []*opcodeSpec) error {
+	var (
+		buf          bytes.Buffer
+		opcodeMap    [256]uint8
+		extOpcodeMap [256]uint8
+	)
+
+	for i := range opcodeMap {
+		opcodeMap[i], extOpcodeMap[i] = badOpcode, badOpcode
+	}
+
+	fmt.Fprintf(&buf, "// Code generated by tools/opcodetable from %s; DO NOT EDIT.\n\n", specFile)
+	fmt.Fprintf(&buf, "package aml\n\n")
+
+	// Opcode constants
+	fmt.Fprintf(&buf, "// List of AML opcodes.\nconst (\n")
+	lastKind := opcodeKind(0xff)
+	for _, spec := range specs {
+		if spec.kind != lastKind {
+			switch spec.kind {
+			case opcodeKindRegular:
+				fmt.Fprintf(&buf, "// Regular opcode list\n")
+			case opcodeKindExtended:
+				fmt.Fprintf(&buf, "// Extended opcodes\n")
+			case opcodeKindInternal:
+				fmt.Fprintf(&buf, "// Special internal opcodes which are not part of the spec; these are\n// for internal use by the AML parser.\n")
+			}
+			lastKind = spec.kind
+		}
+
+		if spec.kind == opcodeKindRegular {
+			fmt.Fprintf(&buf, "%s = uint16(0x%02x)\n", spec.constName, spec.code)
+			continue
+		}
+		fmt.Fprintf(&buf, "%s = uint16(0xff + 0x%02x)\n", spec.constName, spec.code)
+	}
+	fmt.Fprintf(&buf, ")\n\n")
+
+	// Opcode table
+	fmt.Fprintf(&buf, "// The opcode table contains all opcode-related information that the parser knows.\n")
+	fmt.Fprintf(&buf, "// This table is modeled after a similar table used in the acpica implementation.\n")
+	fmt.Fprintf(&buf, "var pOpcodeTable = []pOpcodeInfo{\n")
+	lastKind = opcodeKindRegular
+	for index, spec := range specs {
+		if spec.kind == opcodeKindInternal && lastKind != opcodeKindInternal {
+			fmt.Fprintf(&buf, "// Special internal opcodes\n")
+		}
+		lastKind = spec.kind
+
+		switch spec.kind {
+		case opcodeKindRegular:
+			opcodeMap[spec.code] = uint8(index)
+		case opcodeKindExtended:
+			extOpcodeMap[spec.code] = uint8(index)
+		}
+
+		flags := "0"
+		if len(spec.flags) != 0 {
+			flags = "pOpFlag" + strings.Join(spec.flags, " | pOpFlag")
+		}
+
+		args := ""
+		if len(spec.args) != 0 {
+			args = "pArgType" + strings.Join(spec.args, ", pArgType")
+		}
+
+		fmt.Fprintf(&buf, "/*0x%02x*/ {%s, %q, %s, makeArg%d(%s)},\n", index, spec.constName, spec.name, flags, len(spec.args), args)
+	}
+	fmt.Fprintf(&buf, "}\n\n")
+
+	fmt.Fprintf(&buf, "// opcodeMap maps an AML opcode to an entry in the opcode table. Entries with\n")
+	fmt.Fprintf(&buf, "// the value 0xff indicate an invalid/unsupported opcode.\n")
+	writeMap(&buf, "opcodeMap", &opcodeMap)
+
+	fmt.Fprintf(&buf, "\n// extendedOpcodeMap maps an AML extended opcode (extOpPrefix + code) to an\n")
+	fmt.Fprintf(&buf, "// entry in the opcode table. Entries with the value 0xff indicate an\n")
+	fmt.Fprintf(&buf, "// invalid/unsupported opcode.\n")
+	writeMap(&buf, "extendedOpcodeMap", &extOpcodeMap)
+
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(src)
+	return err
+}
+
+// writeMap writes the definition of an opcode map to w.
+func writeMap(w io.Writer, varName string, m *[256]uint8) {
+	fmt.Fprintf(w, "var %s = [256]uint8{\n", varName)
+	fmt.Fprintf(w, "/*              0     1     2     3     4     5     6     7*/\n")
+	for row := 0; row < len(m); row += 8 {
+		fmt.Fprintf(w, "/*0x%02x - 0x%02x*/", row, row+7)
+		for col := row; col < row+8; col++ {
+			fmt.Fprintf(w, " 0x%02x,", m[col])
+		}
+		fmt.Fprintf(w, "\n")
+	}
+	fmt.Fprintf(w, "}\n")
+}
+
+func main() {
+	var (
+		specFile = flag.String("in", "", "the opcode table spec file")
+		outFile  = flag.String("out", "", "the file to write the generated tables to")
+	)
+	flag.Parse()
+
+	if *specFile == "" || *outFile == "" {
+		exit(errors.New("both the -in and -out flags must be specified"))
+	}
+
+	f, err := os.Open(*specFile)
+	if err != nil {
+		exit(err)
+	}
+	defer f.Close()
+
+	specs, err := parseSpec(f)
+	if err != nil {
+		exit(err)
+	}
+
+	if err = validate(specs); err != nil {
+		exit(err)
+	}
+
+	var out bytes.Buffer
+	if err = generate(&out, *specFile, specs); err != nil {
+		exit(err)
+	}
+
+	if err = ioutil.WriteFile(*outFile, out.Bytes(), 0644); err != nil {
+		exit(err)
+	}
+}
@@ -0,0 +1,214 @@
+// relocs extracts the list of absolute relocations from a kernel image that
+// was linked with --emit-relocs. The rt0 code uses this list for relocating
+// the kernel image to a randomized virtual address before entering long mode.
+//
+// The generated table contains one little-endian uint32 entry for each 64-bit
+// location in the kernel image that holds the absolute virtual address of a
+// kernel symbol. Each entry stores the offset of the location from
+// _kernel_start.
+//
+// Usage:
+//
+//	relocs generate kernel.bin relocs.bin
+//	relocs verify kernel.bin
+//
+// The verify command checks that the table embedded in the .kaslrrelocs
+// section of a kernel image (also linked with --emit-relocs) matches the
+// relocations of that image.
+package main
+
+import (
+	"bytes"
+	"debug/elf"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+)
+
+const relocTableSection = ".kaslrrelocs"
+
+func exit(err error) {
+	fmt.Fprintf(os.Stderr, "[relocs] error: %s\n", err.Error())
+	os.Exit(1)
+}
+
+// symbolValue returns the value of the named symbol.
+func symbolValue(f *elf.File, name string) (uint64, error) {
+	symbols, err := f.Symbols()
+	if err != nil {
+		return 0, err
+	}
+
+	for _, symbol := range symbols {
+		if symbol.Name == name {
+			return symbol.Value, nil
+		}
+	}
+
+	return 0, fmt.Errorf("could not locate address of %q", name)
+}
+
+// collectRelocations returns the sorted offsets from the kernel start of all
+// 64-bit locations that contain an absolute address within the kernel image.
+// Relocations that store the address of a kernel symbol in a 32-bit field
+// cannot be adjusted by the rt0 code and are reported as errors.
+func collectRelocations(f *elf.File) ([]uint32, error) {
+	kernelStart, err := symbolValue(f, "_kernel_start")
+	if err != nil {
+		return nil, err
+	}
+
+	kernelEnd, err := symbolValue(f, "_kernel_end")
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		offsets  []uint32
+		inKernel = func(addr uint64) bool { return addr >= kernelStart && addr <= kernelEnd }
+	)
+
+	for _, relaSection := range f.Sections {
+		if relaSection.Type != elf.SHT_RELA || int(relaSection.Info) >= len(f.Sections) {
+			continue
+		}
+
+		// Only relocations for sections that are loaded into memory
+		// need to be applied at boot
+		target := f.Sections[relaSection.Info]
+		if target.Flags&elf.SHF_ALLOC == 0 || target.Type == elf.SHT_NOBITS {
+			continue
+		}
+
+		relaData, err := relaSection.Data()
+		if err != nil {
+			return nil, err
+		}
+
+		targetData, err := target.Data()
+		if err != nil {
+			return nil, err
+		}
+
+		var rela elf.Rela64
+		relaReader := bytes.NewReader(relaData)
+		for binary.Read(relaReader, f.ByteOrder, &rela) == nil {
+			if rela.Off < target.Addr || rela.Off-target.Addr >= uint64(len(targetData)) {
+				return nil, fmt.Errorf("%s: relocation offset 0x%x out of range", relaSection.Name, rela.Off)
+			}
+
+			siteData := targetData[rela.Off-target.Addr:]
+			switch elf.R_X86_64(elf.R_TYPE64(rela.Info)) {
+			case elf.R_X86_64_64:
+				if len(siteData) < 8 {
+					return nil, fmt.Errorf("%s: truncated relocation at 0x%x", relaSection.Name, rela.Off)
+				}
+
+				if inKernel(f.ByteOrder.Uint64(siteData)) {
+					offsets = append(offsets, uint32(rela.Off-kernelStart))
+				}
+			case elf.R_X86_64_32, elf.R_X86_64_32S:
+				if len(siteData) < 4 {
+					return nil, fmt.Errorf("%s: truncated relocation at 0x%x", relaSection.Name, rela.Off)
+				}
+
+				val := uint64(f.ByteOrder.Uint32(siteData))
+				if elf.R_X86_64(elf.R_TYPE64(rela.Info)) == elf.R_X86_64_32S {
+					val = uint64(int64(int32(val)))
+				}
+
+				if inKernel(val) {
+					return nil, fmt.Errorf("%s: 32-bit absolute relocation at 0x%x cannot be relocated", relaSection.Name, rela.Off)
+				}
+			}
+		}
+	}
+
+	sort.Sort(offsetList(offsets))
+	return offsets, nil
+}
+
+// offsetList implements sort.Interface for a list of relocation offsets.
+type offsetList []uint32
+
+func (l offsetList) Len() int           { return len(l) }
+func (l offsetList) Less(i, j int) bool { return l[i] < l[j] }
+func (l offsetList) Swap(i, j int)      { l[i], l[j] = l[j], l[i] }
+
+// encodeTable serializes the relocation offsets.
+func encodeTable(offsets []uint32) []byte {
+	table := make([]byte, 4*len(offsets))
+	for i, offset := range offsets {
+		binary.LittleEndian.PutUint32(table[4*i:], offset)
+	}
+
+	return table
+}
+
+func generate(imgFile, outFile string) error {
+	f, err := elf.Open(imgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offsets, err := collectRelocations(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", imgFile, err.Error())
+	}
+
+	return ioutil.WriteFile(outFile, encodeTable(offsets), 0644)
+}
+
+func verify(imgFile string) error {
+	f, err := elf.Open(imgFile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offsets, err := collectRelocations(f)
+	if err != nil {
+		return fmt.Errorf("%s: %s", imgFile, err.Error())
+	}
+
+	section := f.Section(relocTableSection)
+	if section == nil {
+		return fmt.Errorf("%s: missing %s section", imgFile, relocTableSection)
+	}
+
+	embedded, err := section.Data()
+	if err != nil {
+		return err
+	}
+
+	// The linker may pad the section to its alignment
+	table := encodeTable(offsets)
+	if len(embedded) < len(table) || !bytes.Equal(embedded[:len(table)], table) || len(bytes.Trim(embedded[len(table):], "\x00")) != 0 {
+		return fmt.Errorf("%s: embedded relocation table does not match the image relocations; the kernel layout changed between link passes", imgFile)
+	}
+
+	return nil
+}
+
+func main() {
+	flag.Parse()
+
+	var err error
+	switch {
+	case flag.NArg() == 3 && flag.Arg(0) == "generate":
+		err = generate(flag.Arg(1), flag.Arg(2))
+	case flag.NArg() == 2 && flag.Arg(0) == "verify":
+		err = verify(flag.Arg(1))
+	default:
+		err = errors.New("usage: relocs generate kernel.bin relocs.bin | relocs verify kernel.bin")
+	}
+
+	if err != nil {
+		exit(err)
+	}
+}